@@ -0,0 +1,310 @@
+// Package acs712 reads the Allegro ACS712 hall effect current sensor
+// over a drivers.AnalogReader, one channel of an ADS1115 by default.
+// The sensor outputs half its supply at zero current and moves by
+// its sensitivity per amp either way.
+//
+// Single samples are too noisy to use, every reading is worked out
+// over a window of samples: the average for DC loads, the RMS for AC
+// loads with the noise measured by the zero calibration taken off.
+// The zero calibration is saved in the device store.
+package acs712
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// Variant is the current range of the module
+type Variant int
+
+const (
+	ACS712_5A  Variant = 5
+	ACS712_20A Variant = 20
+	ACS712_30A Variant = 30
+)
+
+// Sensitivity returns the volts per amp of the variant at 5V supply
+func (v Variant) Sensitivity() float64 {
+	switch v {
+	case ACS712_5A:
+		return 0.185
+	case ACS712_20A:
+		return 0.100
+	case ACS712_30A:
+		return 0.066
+	}
+	return 0
+}
+
+// Mode selects how a window of samples becomes a current
+type Mode int
+
+const (
+	// DC averages the window, the sign of the current is kept
+	DC Mode = iota
+	// AC takes the RMS of the window around the zero offset
+	AC
+)
+
+const (
+	// DefaultSamples at DefaultInterval cover 10 cycles of 50Hz and
+	// 12 of 60Hz, an RMS over whole cycles does not ripple
+	DefaultSamples  = 200
+	DefaultInterval = time.Millisecond
+
+	// DefaultOffset is the output at zero current, half of the 5V
+	// supply
+	DefaultOffset = 2.5
+)
+
+var (
+	ErrVariant    = errors.New("acs712 variant must be 5, 20 or 30")
+	ErrCommand    = errors.New("unknown command")
+	ErrReadFailed = errors.New("failed to read from ACS712")
+)
+
+// Calibration is the output at zero current and the rms of its noise,
+// both in volts
+type Calibration struct {
+	Offset float64 `json:"offset"`
+	Noise  float64 `json:"noise"`
+}
+
+// Reading is a measurement over one window, what ReadPub publishes
+type Reading struct {
+	Amps  float64 `json:"amps"`
+	Volts float64 `json:"volts"`
+}
+
+// AlertEvent is published when the current goes over the over
+// current limit, "over_current", and when it is back below the limit
+// less the hysteresis, "current_ok"
+type AlertEvent struct {
+	Event string  `json:"event"`
+	Amps  float64 `json:"amps"`
+}
+
+// ACS712 is a hall effect current sensor
+type ACS712 struct {
+	*device.Device
+	drivers.AnalogReader
+
+	// Mode, Samples and Interval set how a reading is taken, set them
+	// before Run
+	Mode     Mode
+	Samples  int
+	Interval time.Duration
+
+	sensitivity float64
+	cal         Calibration
+
+	limit      float64
+	hysteresis float64
+	over       bool
+
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates a sensor of variant v on channel ch of the default
+// ADS1115. opts are passed on to the ADS1115 pin, see analog.New.
+func New(name string, ch int, v Variant, opts any) (*ACS712, error) {
+	if device.IsMock() {
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, ch), v)
+	}
+	p, err := drivers.GetADS1115().Pin(name, ch, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p, v)
+}
+
+// NewWithReader creates a sensor of variant v reading r. The zero
+// calibration saved in the device store is loaded, DefaultOffset
+// without noise is used if there is none.
+func NewWithReader(name string, r drivers.AnalogReader, v Variant) (*ACS712, error) {
+	if v.Sensitivity() == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrVariant, v)
+	}
+	a := &ACS712{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		Samples:      DefaultSamples,
+		Interval:     DefaultInterval,
+		sensitivity:  v.Sensitivity(),
+		cal:          Calibration{Offset: DefaultOffset},
+		sleep:        time.Sleep,
+	}
+	var cal Calibration
+	err := device.GetStore().Load(a.storeKey(), &cal)
+	switch {
+	case err == nil:
+		a.cal = cal
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("acs712 loading calibration", "device", name, "error", err)
+	}
+	return a, nil
+}
+
+func (a *ACS712) storeKey() string {
+	return a.Device.Name + "/calibration"
+}
+
+// Name returns the name of the device
+func (a *ACS712) Name() string {
+	return a.Device.Name
+}
+
+// SetSensitivity sets the volts per amp, for a module on a supply
+// other than 5V or behind a voltage divider
+func (a *ACS712) SetSensitivity(voltsPerAmp float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sensitivity = voltsPerAmp
+}
+
+// Calibration returns the zero calibration in use
+func (a *ACS712) Calibration() Calibration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cal
+}
+
+// CalibrateZero samples a window with the load off and takes its
+// average as the zero current output and its rms as the noise. The
+// calibration is saved.
+func (a *ACS712) CalibrateZero() error {
+	w, err := a.sample()
+	if err != nil {
+		return err
+	}
+	m := w.mean()
+	c := Calibration{Offset: m, Noise: w.rms(m)}
+	a.mu.Lock()
+	a.cal = c
+	a.mu.Unlock()
+	return device.GetStore().Save(a.storeKey(), &c)
+}
+
+// SetOverCurrent publishes an AlertEvent when the current goes over
+// amps, and again when it drops below amps - hysteresis. The size of
+// a DC current is compared. 0 turns the alert off.
+func (a *ACS712) SetOverCurrent(amps, hysteresis float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limit, a.hysteresis = amps, hysteresis
+	a.over = false
+}
+
+// Command handles a command payload: "calibrate zero", taken with
+// the load off
+func (a *ACS712) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "calibrate zero":
+		return a.CalibrateZero()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read samples a window and returns the current, the average for DC
+// and the rms for AC, and the average voltage
+func (a *ACS712) Read() (*Reading, error) {
+	w, err := a.sample()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &Reading{Volts: w.mean()}
+	switch a.Mode {
+	case AC:
+		r.Amps = w.acRMS(a.cal.Offset, a.cal.Noise) / a.sensitivity
+	default:
+		r.Amps = (r.Volts - a.cal.Offset) / a.sensitivity
+	}
+	return r, nil
+}
+
+// ReadPub reads the current and publishes it, and the alert if the
+// current crossed the over current limit
+func (a *ACS712) ReadPub() error {
+	r, err := a.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.PubData(j)
+
+	if evt := a.check(r.Amps); evt != nil {
+		slog.Warn("acs712 current", "device", a.Device.Name, "event", evt.Event, "amps", evt.Amps)
+		if j, err := json.Marshal(evt); err == nil {
+			a.PubData(j)
+		}
+	}
+	return nil
+}
+
+// check returns the AlertEvent for a current, nil if the alert state
+// did not change
+func (a *ACS712) check(amps float64) *AlertEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	size := math.Abs(amps)
+	switch {
+	case a.limit <= 0:
+		return nil
+	case !a.over && size > a.limit:
+		a.over = true
+		return &AlertEvent{Event: "over_current", Amps: amps}
+	case a.over && size <= a.limit-a.hysteresis:
+		a.over = false
+		return &AlertEvent{Event: "current_ok", Amps: amps}
+	}
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (a *ACS712) Run(ctx context.Context, period time.Duration) error {
+	err := a.TimerLoop(ctx, period, a.ReadPub)
+	slog.Debug("acs712 stopped", "device", a.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the reader, the ADS1115 channel by default
+func (a *ACS712) Close() error {
+	if c, ok := a.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// sample reads a window of Samples readings Interval apart
+func (a *ACS712) sample() (window, error) {
+	n := max(a.Samples, 1)
+	w := make(window, n)
+	for i := range w {
+		if i > 0 && a.Interval > 0 {
+			a.sleep(a.Interval)
+		}
+		v, err := a.ReadVolts()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrReadFailed, a.Device.Name, err)
+		}
+		w[i] = v
+	}
+	return w, nil
+}
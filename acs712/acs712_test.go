@@ -0,0 +1,227 @@
+package acs712
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// stream is a synthetic output of the sensor sampled every
+// DefaultInterval: offset, plus a sine of amplitude at hz, plus
+// gaussian noise of rms noise
+type stream struct {
+	offset    float64
+	amplitude float64
+	hz        float64
+	noise     float64
+
+	n   int
+	rnd *rand.Rand
+}
+
+func (s *stream) next() float64 {
+	t := float64(s.n) * DefaultInterval.Seconds()
+	s.n++
+	v := s.offset + s.amplitude*math.Sin(2*math.Pi*s.hz*t)
+	if s.noise > 0 {
+		v += s.noise * s.rnd.NormFloat64()
+	}
+	return v
+}
+
+// newTest creates a 20A sensor reading s
+func newTest(t *testing.T, s *stream) *ACS712 {
+	t.Helper()
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("current", 0)
+	pin.Gen = s.next
+	a, err := NewWithReader("current", pin, ACS712_20A)
+	if err != nil {
+		t.Fatalf("NewWithReader() error = %v", err)
+	}
+	a.sleep = func(time.Duration) {}
+	return a
+}
+
+func TestWindow(t *testing.T) {
+	w := window{1, 2, 3, 4}
+	if got := w.mean(); got != 2.5 {
+		t.Errorf("mean() got (%v) want (2.5)", got)
+	}
+	if got := w.rms(2.5); math.Abs(got-math.Sqrt(1.25)) > 1e-12 {
+		t.Errorf("rms() got (%v) want (%v)", got, math.Sqrt(1.25))
+	}
+	if got := w.acRMS(2.5, 1); math.Abs(got-0.5) > 1e-12 {
+		t.Errorf("acRMS() got (%v) want (0.5)", got)
+	}
+	if got := w.acRMS(2.5, 2); got != 0 {
+		t.Errorf("acRMS() below the noise got (%v) want (0)", got)
+	}
+	var empty window
+	if empty.mean() != 0 || empty.rms(0) != 0 {
+		t.Error("an empty window got a value want (0)")
+	}
+}
+
+func TestSine(t *testing.T) {
+	tests := []struct {
+		name string
+		hz   float64
+		amps float64 // rms
+	}{
+		{"50Hz 10A", 50, 10},
+		{"60Hz 10A", 60, 10},
+		{"50Hz 1.5A", 50, 1.5},
+		{"60Hz 18A", 60, 18},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stream{offset: 2.5, amplitude: tt.amps * math.Sqrt2 * 0.1, hz: tt.hz}
+			a := newTest(t, s)
+			a.Mode = AC
+			r, err := a.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(r.Amps-tt.amps) > 1e-9 {
+				t.Errorf("Read() got (%vA) want (%vA)", r.Amps, tt.amps)
+			}
+			// an average sees nothing of an AC load
+			a.Mode = DC
+			if r, _ := a.Read(); math.Abs(r.Amps) > 1e-9 {
+				t.Errorf("DC Read() of a sine got (%vA) want (0)", r.Amps)
+			}
+		})
+	}
+}
+
+func TestNoisyDC(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset float64
+		amps   float64
+	}{
+		{"idle", 2.5, 0},
+		{"2A", 2.7, 2},
+		{"reverse", 2.0, -5},
+		{"off center zero", 2.47, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 20mV rms of noise, single samples are off by 0.2A
+			s := &stream{offset: tt.offset, noise: 0.02, rnd: rand.New(rand.NewSource(1))}
+			a := newTest(t, s)
+			if tt.offset == 2.47 {
+				if err := a.CalibrateZero(); err != nil {
+					t.Fatalf("CalibrateZero() error = %v", err)
+				}
+			}
+			r, err := a.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(r.Amps-tt.amps) > 0.05 {
+				t.Errorf("Read() got (%vA) want (%vA)", r.Amps, tt.amps)
+			}
+		})
+	}
+}
+
+func TestNoisyAC(t *testing.T) {
+	s := &stream{offset: 2.5, noise: 0.02, hz: 50, rnd: rand.New(rand.NewSource(2))}
+	a := newTest(t, s)
+	a.Mode = AC
+
+	// without the noise measured an idle load reads 0.2A
+	if r, _ := a.Read(); r.Amps < 0.15 {
+		t.Errorf("uncalibrated idle Read() got (%vA) want about 0.2A", r.Amps)
+	}
+	if err := a.Command([]byte("calibrate zero")); err != nil {
+		t.Fatalf("calibrate zero error = %v", err)
+	}
+	if c := a.Calibration(); math.Abs(c.Offset-2.5) > 0.005 || math.Abs(c.Noise-0.02) > 0.003 {
+		t.Errorf("Calibration() got (%+v) want (2.5, 0.02)", c)
+	}
+	if r, _ := a.Read(); r.Amps > 0.08 {
+		t.Errorf("calibrated idle Read() got (%vA) want (0)", r.Amps)
+	}
+
+	s.amplitude = 5 * math.Sqrt2 * 0.1
+	if r, _ := a.Read(); math.Abs(r.Amps-5) > 0.05 {
+		t.Errorf("Read() got (%vA) want (5A)", r.Amps)
+	}
+}
+
+func TestCalibrationSaved(t *testing.T) {
+	s := &stream{offset: 2.46}
+	a := newTest(t, s)
+	if c := a.Calibration(); c.Offset != DefaultOffset {
+		t.Fatalf("Calibration() got (%+v) want the default", c)
+	}
+	if err := a.CalibrateZero(); err != nil {
+		t.Fatalf("CalibrateZero() error = %v", err)
+	}
+
+	again, _ := NewWithReader("current", drivers.NewMockAnalogPin("current", 0), ACS712_20A)
+	if c := again.Calibration(); math.Abs(c.Offset-2.46) > 1e-9 {
+		t.Errorf("Calibration() after restart got (%+v) want (2.46)", c)
+	}
+	if err := a.Command([]byte("calibrate")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(calibrate) error got (%v) want (%v)", err, ErrCommand)
+	}
+}
+
+func TestVariant(t *testing.T) {
+	for v, want := range map[Variant]float64{ACS712_5A: 0.185, ACS712_20A: 0.1, ACS712_30A: 0.066} {
+		if got := v.Sensitivity(); got != want {
+			t.Errorf("%dA Sensitivity() got (%v) want (%v)", v, got, want)
+		}
+	}
+	if _, err := NewWithReader("current", drivers.NewMockAnalogPin("current", 0), 10); !errors.Is(err, ErrVariant) {
+		t.Errorf("NewWithReader(10A) error got (%v) want (%v)", err, ErrVariant)
+	}
+
+	// 1A on the 5A module
+	s := &stream{offset: 2.685}
+	a := newTest(t, s)
+	a.SetSensitivity(ACS712_5A.Sensitivity())
+	if r, _ := a.Read(); math.Abs(r.Amps-1) > 1e-9 {
+		t.Errorf("5A Read() got (%vA) want (1A)", r.Amps)
+	}
+}
+
+func TestOverCurrent(t *testing.T) {
+	a := newTest(t, &stream{offset: 2.5})
+	if evt := a.check(50); evt != nil {
+		t.Errorf("check() with the alert off got (%v) want (nil)", evt)
+	}
+
+	a.SetOverCurrent(10, 1)
+	tests := []struct {
+		amps float64
+		want string
+	}{
+		{5, ""},
+		{10.5, "over_current"},
+		{12, ""},
+		{9.5, ""}, // within the hysteresis
+		{8.9, "current_ok"},
+		{-11, "over_current"},
+		{0, "current_ok"},
+	}
+	for _, tt := range tests {
+		evt := a.check(tt.amps)
+		got := ""
+		if evt != nil {
+			got = evt.Event
+		}
+		if got != tt.want {
+			t.Errorf("check(%v) got (%q) want (%q)", tt.amps, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package acs712
+
+import "math"
+
+// window holds the samples of one measurement in volts. A single
+// sample of the ACS712 is buried in tens of millivolts of noise, the
+// readings are always worked out over a window of them.
+type window []float64
+
+// mean returns the average of the samples
+func (w window) mean() float64 {
+	if len(w) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range w {
+		sum += v
+	}
+	return sum / float64(len(w))
+}
+
+// rms returns the root mean square of the samples around offset
+func (w window) rms(offset float64) float64 {
+	if len(w) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range w {
+		d := v - offset
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(w)))
+}
+
+// acRMS returns the rms of the samples around offset with the noise
+// rms taken off. Uncorrelated noise adds in squares, without taking
+// it off an idle load reads a few hundred milliamps.
+func (w window) acRMS(offset, noise float64) float64 {
+	r := w.rms(offset)
+	return math.Sqrt(max(r*r-noise*noise, 0))
+}
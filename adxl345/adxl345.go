@@ -0,0 +1,435 @@
+// Package adxl345 provides a driver for the Analog Devices ADXL345
+// accelerometer on I2C. Besides the scaled readings it publishes the
+// vibration level and the events of the sensor's tap, activity and
+// free fall detection, signalled on its INT1 pin.
+package adxl345
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the address with SDO low, AddressAlt with it high
+	Address    = 0x53
+	AddressAlt = 0x1D
+)
+
+const (
+	regDevID      = 0x00
+	regBWRate     = 0x2C
+	regPowerCtl   = 0x2D
+	regDataFormat = 0x31
+	regDataX0     = 0x32
+
+	devID        = 0xE5
+	powerMeasure = 0x08
+	fullRes      = 0x08
+
+	// in full resolution every range has 3.9mg per count
+	gPerLSB = 1.0 / 256
+)
+
+// Range is the full scale of the DATA_FORMAT register
+type Range uint8
+
+const (
+	Range2G Range = iota
+	Range4G
+	Range8G
+	Range16G
+)
+
+// Rate is the output data rate code of the BW_RATE register, the
+// bandwidth is half the rate
+type Rate uint8
+
+const (
+	Rate12Hz5  Rate = 0x07
+	Rate25Hz   Rate = 0x08
+	Rate50Hz   Rate = 0x09
+	Rate100Hz  Rate = 0x0A
+	Rate200Hz  Rate = 0x0B
+	Rate400Hz  Rate = 0x0C
+	Rate800Hz  Rate = 0x0D
+	Rate1600Hz Rate = 0x0E
+	Rate3200Hz Rate = 0x0F
+)
+
+// Hz returns the output data rate
+func (r Rate) Hz() float64 {
+	return 3200 / float64(int(1)<<(0x0F-r))
+}
+
+var (
+	ErrNotADXL345 = errors.New("device is not an ADXL345")
+	ErrConfig     = errors.New("invalid adxl345 range or rate")
+	ErrReadFailed = errors.New("failed to read from ADXL345")
+)
+
+// Vector is an acceleration in g
+type Vector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Vibration is what ReadPub publishes, the last acceleration and the
+// RMS of the acceleration around its mean over the period
+type Vibration struct {
+	Accel   Vector  `json:"accel"`
+	RMS     float64 `json:"rms"`
+	Samples int     `json:"samples"`
+}
+
+// ADXL345 is an accelerometer on an I2C bus
+type ADXL345 struct {
+	*device.Device
+
+	// SampleInterval is how often Run samples the acceleration for
+	// the vibration level, the device period sets how often the
+	// level is published
+	SampleInterval time.Duration
+
+	bus     string
+	addr    int
+	dev     *drivers.I2CDevice
+	rng     Range
+	rate    Rate
+	enabled byte
+	intPin  *drivers.DigitalPin
+	win     window
+	mu      sync.Mutex
+}
+
+// New creates an ADXL345 at the given bus and address measuring ±2g
+// at 100Hz, the sensor is not touched until Init
+func New(name, bus string, addr int) *ADXL345 {
+	return &ADXL345{
+		Device:         device.NewDevice(name, "mqtt"),
+		SampleInterval: 10 * time.Millisecond,
+		bus:            bus,
+		addr:           addr,
+		rng:            Range2G,
+		rate:           Rate100Hz,
+	}
+}
+
+// Init opens the i2c bus, checks the device id, writes the range and
+// rate and starts measuring
+func (a *ADXL345) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(a.bus, a.addr)
+	if err != nil {
+		return err
+	}
+	dev.Order = binary.LittleEndian
+
+	id, err := dev.ReadReg8(regDevID)
+	if err != nil {
+		return err
+	}
+	if id != devID {
+		return fmt.Errorf("%w: id %#02x", ErrNotADXL345, id)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dev = dev
+	if err := a.configure(a.rng, a.rate); err != nil {
+		return err
+	}
+	// route every interrupt to INT1
+	if err := dev.WriteReg8(regIntMap, 0); err != nil {
+		return err
+	}
+	return dev.WriteReg8(regPowerCtl, powerMeasure)
+}
+
+// Name returns the name of the device
+func (a *ADXL345) Name() string {
+	return a.Device.Name
+}
+
+// SetRange sets the full scale, the resolution stays 3.9mg
+func (a *ADXL345) SetRange(r Range) error {
+	if r > Range16G {
+		return fmt.Errorf("%w: range %d", ErrConfig, r)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.configure(r, a.rate)
+}
+
+// SetRate sets the output data rate
+func (a *ADXL345) SetRate(r Rate) error {
+	if r < Rate12Hz5 || r > Rate3200Hz {
+		return fmt.Errorf("%w: rate %#02x", ErrConfig, byte(r))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.configure(a.rng, r)
+}
+
+func (a *ADXL345) configure(rng Range, rate Rate) error {
+	if a.dev != nil {
+		if err := a.dev.WriteReg8(regDataFormat, fullRes|byte(rng)); err != nil {
+			return err
+		}
+		if err := a.dev.WriteReg8(regBWRate, byte(rate)); err != nil {
+			return err
+		}
+	}
+	a.rng, a.rate = rng, rate
+	return nil
+}
+
+// SetDetection writes the tap, activity and free fall thresholds and
+// enables the interrupts of the detections that are turned on
+func (a *ADXL345) SetDetection(d Detection) error {
+	regs, enable, err := d.registers()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dev != nil {
+		// interrupts off while the thresholds change
+		if err := a.dev.WriteReg8(regIntEnable, 0); err != nil {
+			return err
+		}
+		for _, r := range regs {
+			if err := a.dev.WriteReg8(r.reg, r.val); err != nil {
+				return err
+			}
+		}
+		if err := a.dev.WriteReg8(regIntEnable, enable); err != nil {
+			return err
+		}
+	}
+	a.enabled = enable
+	return nil
+}
+
+// Read returns the acceleration in g
+func (a *ADXL345) Read() (*Vector, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.read()
+}
+
+func (a *ADXL345) read() (*Vector, error) {
+	if device.IsMock() {
+		noise := func() float64 { return (a.MockRand().Float64()*2 - 1) * 0.02 }
+		return &Vector{noise(), noise(), 1 + noise()}, nil
+	}
+	if a.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	buf, err := a.dev.ReadBlock(regDataX0, 6)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return scale(buf), nil
+}
+
+// scale converts the six data registers to g
+func scale(buf []byte) *Vector {
+	raw := func(i int) float64 {
+		return float64(int16(binary.LittleEndian.Uint16(buf[i:])))
+	}
+	return &Vector{raw(0) * gPerLSB, raw(2) * gPerLSB, raw(4) * gPerLSB}
+}
+
+// Events reads and clears the pending interrupts and returns their
+// events
+func (a *ADXL345) Events() ([]Event, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dev == nil {
+		return nil, nil
+	}
+	// the tap status has to be read before INT_SOURCE clears the
+	// interrupts
+	var status, source byte
+	err := a.dev.Tx(func(bus drivers.I2CBus) error {
+		buf := make([]byte, 1)
+		if err := bus.ReadReg(regActTapStat, buf); err != nil {
+			return err
+		}
+		status = buf[0]
+		if err := bus.ReadReg(regIntSource, buf); err != nil {
+			return err
+		}
+		source = buf[0]
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return decode(source, status, a.enabled), nil
+}
+
+// HandleInterrupt publishes the pending events
+func (a *ADXL345) HandleInterrupt() error {
+	evts, err := a.Events()
+	if err != nil {
+		return err
+	}
+	for _, e := range evts {
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		a.PubData(j)
+	}
+	return nil
+}
+
+// WatchInterrupt requests the pin id (see drivers.ParsePinID) wired
+// to INT1 and publishes the events it signals. Without it ReadPub
+// polls for events every period.
+func (a *ADXL345) WatchInterrupt(id string) error {
+	p, err := drivers.NewDigitalPinID(a.Device.Name+"-int1", id,
+		gpiocdev.AsInput,
+		gpiocdev.WithRisingEdge,
+		gpiocdev.WithEventHandler(func(gpiocdev.LineEvent) {
+			if err := a.HandleInterrupt(); err != nil {
+				slog.Error("adxl345 interrupt", "device", a.Device.Name, "error", err)
+			}
+		}))
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.intPin = p
+	a.mu.Unlock()
+
+	// INT1 stays high until the pending interrupts are read
+	return a.HandleInterrupt()
+}
+
+// ReadPub publishes the vibration level over the samples taken since
+// the last call, and the events pending if INT1 is not watched
+func (a *ADXL345) ReadPub() error {
+	a.mu.Lock()
+	poll := a.intPin == nil && a.enabled != 0
+	v, err := a.read()
+	if err == nil {
+		a.win.add(*v)
+	}
+	vib := a.win.vibration()
+	a.win = window{}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if poll {
+		if err := a.HandleInterrupt(); err != nil {
+			return err
+		}
+	}
+	j, err := json.Marshal(vib)
+	if err != nil {
+		return err
+	}
+	a.PubData(j)
+	return nil
+}
+
+// Run publishes the vibration level every period until ctx is
+// canceled, sampling the acceleration every SampleInterval
+func (a *ADXL345) Run(ctx context.Context, period time.Duration) error {
+	go a.sample(ctx)
+	err := a.TimerLoop(ctx, period, a.ReadPub)
+	slog.Debug("adxl345 stopped", "device", a.Device.Name, "error", err)
+	return err
+}
+
+func (a *ADXL345) sample(ctx context.Context) {
+	ticker := time.NewTicker(a.SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			v, err := a.read()
+			if err == nil {
+				a.win.add(*v)
+			}
+			a.mu.Unlock()
+			if err != nil {
+				slog.Error("adxl345 sample failed", "device", a.Device.Name, "error", err)
+			}
+		}
+	}
+}
+
+// Close stops measuring, releases the interrupt pin and closes the
+// i2c device
+func (a *ADXL345) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	if a.intPin != nil {
+		errs = append(errs, a.intPin.Close())
+		a.intPin = nil
+	}
+	if a.dev != nil {
+		a.dev.WriteReg8(regPowerCtl, 0)
+		errs = append(errs, a.dev.Close())
+		a.dev = nil
+	}
+	return errors.Join(errs...)
+}
+
+// window accumulates the samples of a period
+type window struct {
+	n          int
+	last       Vector
+	sum, sumSq Vector
+}
+
+func (w *window) add(v Vector) {
+	w.n++
+	w.last = v
+	w.sum.X += v.X
+	w.sum.Y += v.Y
+	w.sum.Z += v.Z
+	w.sumSq.X += v.X * v.X
+	w.sumSq.Y += v.Y * v.Y
+	w.sumSq.Z += v.Z * v.Z
+}
+
+// vibration returns the RMS of the samples around their mean, which
+// takes out gravity and the sensor's tilt
+func (w *window) vibration() Vibration {
+	if w.n == 0 {
+		return Vibration{}
+	}
+	n := float64(w.n)
+	variance := func(sum, sumSq float64) float64 {
+		return max(sumSq/n-(sum/n)*(sum/n), 0)
+	}
+	v := variance(w.sum.X, w.sumSq.X) + variance(w.sum.Y, w.sumSq.Y) + variance(w.sum.Z, w.sumSq.Z)
+	return Vibration{Accel: w.last, RMS: math.Sqrt(v), Samples: w.n}
+}
@@ -0,0 +1,228 @@
+package adxl345
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func TestEncode(t *testing.T) {
+	gs := []struct {
+		g    float64
+		want byte
+		err  bool
+	}{
+		{0, 0, false},
+		{0.0625, 1, false},
+		{3, 48, false},
+		{0.4, 6, false}, // rounds to 375mg
+		{15.9375, 255, false},
+		{16.1, 0, true},
+		{-1, 0, true},
+	}
+	for _, tt := range gs {
+		got, err := encodeG(tt.g)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("encodeG(%g) got (%d, %v) want (%d)", tt.g, got, err, tt.want)
+		}
+		if tt.err && !errors.Is(err, ErrThreshold) {
+			t.Errorf("encodeG(%g) error got (%v) want (%v)", tt.g, err, ErrThreshold)
+		}
+	}
+
+	times := []struct {
+		d, lsb time.Duration
+		want   byte
+		err    bool
+	}{
+		{10 * time.Millisecond, durLSB, 16, false},
+		{159375 * time.Microsecond, durLSB, 255, false},
+		{160 * time.Millisecond, durLSB, 0, true},
+		{20 * time.Millisecond, latentLSB, 16, false},
+		{250 * time.Millisecond, latentLSB, 200, false},
+		{200 * time.Millisecond, timeFFLSB, 40, false},
+		{5 * time.Second, inactLSB, 5, false},
+		{256 * time.Second, inactLSB, 0, true},
+	}
+	for _, tt := range times {
+		got, err := encodeTime(tt.d, tt.lsb)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("encodeTime(%v, %v) got (%d, %v) want (%d)", tt.d, tt.lsb, got, err, tt.want)
+		}
+	}
+}
+
+func TestDetectionRegisters(t *testing.T) {
+	d := Detection{
+		TapThreshold:        3,
+		TapDuration:         10 * time.Millisecond,
+		TapAxes:             AxisX | AxisZ,
+		TapLatency:          20 * time.Millisecond,
+		TapWindow:           250 * time.Millisecond,
+		ActivityThreshold:   0.5,
+		ActivityAxes:        AllAxes,
+		InactivityThreshold: 0.125,
+		InactivityTime:      5 * time.Second,
+		FreeFallThreshold:   0.4,
+		FreeFallTime:        200 * time.Millisecond,
+	}
+	regs, enable, err := d.registers()
+	if err != nil {
+		t.Fatalf("registers() error = %v", err)
+	}
+	want := map[byte]byte{
+		regThreshTap:   48,
+		regDur:         16,
+		regLatent:      16,
+		regWindow:      200,
+		regTapAxes:     0x05,
+		regThreshAct:   8,
+		regThreshInact: 2,
+		regTimeInact:   5,
+		regThreshFF:    6,
+		regTimeFF:      40,
+		regActInactCtl: 0xF7,
+	}
+	if len(regs) != len(want) {
+		t.Errorf("registers() wrote (%d) registers want (%d)", len(regs), len(want))
+	}
+	for _, r := range regs {
+		if w, ok := want[r.reg]; !ok || r.val != w {
+			t.Errorf("register %#02x got (%#02x) want (%#02x)", r.reg, r.val, w)
+		}
+	}
+	if enable != intSingleTap|intDoubleTap|intActivity|intInactivity|intFreeFall {
+		t.Errorf("enable got (%#02x) want (0x7c)", enable)
+	}
+
+	// single taps only
+	_, enable, _ = Detection{TapThreshold: 2, TapDuration: 10 * time.Millisecond, TapAxes: AxisY}.registers()
+	if enable != intSingleTap {
+		t.Errorf("single tap enable got (%#02x) want (%#02x)", enable, intSingleTap)
+	}
+	if _, _, err := (Detection{FreeFallThreshold: 0.5, FreeFallTime: 2 * time.Second}).registers(); !errors.Is(err, ErrThreshold) {
+		t.Errorf("registers(free fall 2s) error got (%v) want (%v)", err, ErrThreshold)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	all := byte(intSingleTap | intDoubleTap | intActivity | intInactivity | intFreeFall)
+	tests := []struct {
+		name                    string
+		source, status, enabled byte
+		want                    []Event
+	}{
+		{"tap on x", intSingleTap | intDataReady, 0x04, all, []Event{{"tap", "x"}}},
+		{"double tap on z", intSingleTap | intDoubleTap, 0x01, all, []Event{{"double_tap", "z"}}},
+		{"tap on x and y", intSingleTap, 0x06, all, []Event{{"tap", "xy"}}},
+		{"activity on y", intActivity, 0x20, all, []Event{{"activity", "y"}}},
+		{"free fall", intFreeFall | intInactivity, 0x08, all, []Event{{"inactivity", ""}, {"free_fall", ""}}},
+		{"tap and free fall", intSingleTap | intFreeFall, 0x02, all, []Event{{"tap", "y"}, {"free_fall", ""}}},
+		{"not enabled", intSingleTap | intActivity, 0x44, intActivity, []Event{{"activity", "x"}}},
+		{"nothing", intDataReady, 0, all, nil},
+	}
+	for _, tt := range tests {
+		got := decode(tt.source, tt.status, tt.enabled)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s got (%v) want (%v)", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s got (%v) want (%v)", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestVibration(t *testing.T) {
+	// tilted and still reads no vibration
+	var w window
+	for i := 0; i < 10; i++ {
+		w.add(Vector{0.5, 0, 0.866})
+	}
+	if v := w.vibration(); v.RMS > 1e-6 || v.Samples != 10 {
+		t.Errorf("still got (%+v) want rms 0 of 10 samples", v)
+	}
+
+	// a ±0.1g square wave on z on top of gravity
+	w = window{}
+	for i := 0; i < 100; i++ {
+		w.add(Vector{0, 0, 1 + 0.1*float64(1-2*(i%2))})
+	}
+	if v := w.vibration(); math.Abs(v.RMS-0.1) > 1e-9 {
+		t.Errorf("square wave rms got (%.4f) want (0.1)", v.RMS)
+	}
+
+	if v := (&window{}).vibration(); v.Samples != 0 || v.RMS != 0 {
+		t.Errorf("empty window got (%+v) want zero", v)
+	}
+}
+
+func TestInitRead(t *testing.T) {
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	fake.Set(regDevID, devID)
+	// x -0.5g, y 2g, z 1g
+	fake.Set(regDataX0, 0x80, 0xFF, 0x00, 0x02, 0x00, 0x01)
+
+	a := New("accel-test", TestI2CBus, Address)
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := a.SetRange(Range4G); err != nil {
+		t.Fatalf("SetRange() error = %v", err)
+	}
+	if got := fake.Get(regDataFormat, 1)[0]; got != fullRes|byte(Range4G) {
+		t.Errorf("DATA_FORMAT got (%#02x) want (0x09)", got)
+	}
+	if got := fake.Get(regBWRate, 2); got[0] != byte(Rate100Hz) || got[1] != powerMeasure {
+		t.Errorf("BW_RATE and POWER_CTL got (% x) want (0a 08)", got)
+	}
+	if err := a.SetRate(0x03); !errors.Is(err, ErrConfig) {
+		t.Errorf("SetRate(3) error got (%v) want (%v)", err, ErrConfig)
+	}
+
+	v, err := a.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if *v != (Vector{-0.5, 2, 1}) {
+		t.Errorf("Read() got (%+v) want (-0.5 2 1)", v)
+	}
+
+	if err := a.SetDetection(Detection{TapThreshold: 3, TapDuration: 10 * time.Millisecond, TapAxes: AllAxes}); err != nil {
+		t.Fatalf("SetDetection() error = %v", err)
+	}
+	if got := fake.Get(regIntEnable, 1)[0]; got != intSingleTap {
+		t.Errorf("INT_ENABLE got (%#02x) want (%#02x)", got, intSingleTap)
+	}
+
+	fake.Set(regActTapStat, 0x01)
+	fake.Set(regIntSource, intSingleTap|intDataReady)
+	evts, err := a.Events()
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	if len(evts) != 1 || evts[0] != (Event{"tap", "z"}) {
+		t.Errorf("Events() got (%v) want ([{tap z}])", evts)
+	}
+
+	fake.Set(regDevID, 0x00)
+	if err := New("other", TestI2CBus, AddressAlt).Init(); !errors.Is(err, ErrNotADXL345) {
+		t.Errorf("Init() error got (%v) want (%v)", err, ErrNotADXL345)
+	}
+
+	a.Close()
+	if fake.Get(regPowerCtl, 1)[0] != 0 || !fake.Closed() {
+		t.Error("Close() did not put the sensor in standby")
+	}
+}
@@ -0,0 +1,222 @@
+package adxl345
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// detection registers
+const (
+	regThreshTap   = 0x1D
+	regDur         = 0x21
+	regLatent      = 0x22
+	regWindow      = 0x23
+	regThreshAct   = 0x24
+	regThreshInact = 0x25
+	regTimeInact   = 0x26
+	regActInactCtl = 0x27
+	regThreshFF    = 0x28
+	regTimeFF      = 0x29
+	regTapAxes     = 0x2A
+	regActTapStat  = 0x2B
+	regIntEnable   = 0x2E
+	regIntMap      = 0x2F
+	regIntSource   = 0x30
+)
+
+// INT_ENABLE, INT_MAP and INT_SOURCE bits
+const (
+	intDataReady  = 0x80
+	intSingleTap  = 0x40
+	intDoubleTap  = 0x20
+	intActivity   = 0x10
+	intInactivity = 0x08
+	intFreeFall   = 0x04
+)
+
+// scale factors of the threshold and time registers
+const (
+	threshLSB  = 0.0625 // g
+	durLSB     = 625 * time.Microsecond
+	latentLSB  = 1250 * time.Microsecond
+	timeFFLSB  = 5 * time.Millisecond
+	inactLSB   = time.Second
+	actACCoupl = 0x80
+)
+
+// Axes selects the axes taking part in tap and activity detection
+type Axes uint8
+
+const (
+	AxisZ Axes = 1 << iota
+	AxisY
+	AxisX
+
+	AllAxes = AxisX | AxisY | AxisZ
+)
+
+// String returns the axes as in "xz"
+func (a Axes) String() string {
+	var sb strings.Builder
+	for _, ax := range []struct {
+		bit  Axes
+		name string
+	}{{AxisX, "x"}, {AxisY, "y"}, {AxisZ, "z"}} {
+		if a&ax.bit != 0 {
+			sb.WriteString(ax.name)
+		}
+	}
+	return sb.String()
+}
+
+var ErrThreshold = errors.New("adxl345 threshold or time out of range")
+
+// Detection configures the built in event detection, a zero threshold
+// turns the detection off. Thresholds are in g, the registers have a
+// resolution of 62.5mg and go up to 16g.
+type Detection struct {
+	// TapThreshold and TapDuration, the longest a tap may stay above
+	// the threshold (up to 159ms), detect single taps on TapAxes
+	TapThreshold float64
+	TapDuration  time.Duration
+	TapAxes      Axes
+
+	// TapLatency is the quiet time after a tap and TapWindow the time
+	// after it a second tap makes a double tap, both up to 318ms. A
+	// zero TapWindow turns double taps off.
+	TapLatency time.Duration
+	TapWindow  time.Duration
+
+	// ActivityThreshold is compared to the change of acceleration
+	// (ac coupled) on ActivityAxes
+	ActivityThreshold float64
+	ActivityAxes      Axes
+
+	// InactivityThreshold must not be reached on ActivityAxes for
+	// InactivityTime, up to 255s
+	InactivityThreshold float64
+	InactivityTime      time.Duration
+
+	// FreeFallThreshold must not be reached on all axes for
+	// FreeFallTime, up to 1.275s. The datasheet suggests 300 to 600mg
+	// and 100 to 350ms.
+	FreeFallThreshold float64
+	FreeFallTime      time.Duration
+}
+
+// regval is a register write
+type regval struct {
+	reg byte
+	val byte
+}
+
+// encodeG converts a threshold in g to a threshold register
+func encodeG(g float64) (byte, error) {
+	n := math.Round(g / threshLSB)
+	if n < 0 || n > 255 {
+		return 0, fmt.Errorf("%w: %gg", ErrThreshold, g)
+	}
+	return byte(n), nil
+}
+
+// encodeTime converts a time to a register counting in lsb
+func encodeTime(d, lsb time.Duration) (byte, error) {
+	n := math.Round(float64(d) / float64(lsb))
+	if n < 0 || n > 255 {
+		return 0, fmt.Errorf("%w: %v", ErrThreshold, d)
+	}
+	return byte(n), nil
+}
+
+// registers returns the register writes for the detection and the
+// INT_ENABLE bits of the detections it turns on
+func (d Detection) registers() ([]regval, byte, error) {
+	var regs []regval
+	var enable, actCtl byte
+	add := func(reg byte, v byte, err error) error {
+		regs = append(regs, regval{reg, v})
+		return err
+	}
+	g := func(reg byte, v float64) error {
+		b, err := encodeG(v)
+		return add(reg, b, err)
+	}
+	t := func(reg byte, v, lsb time.Duration) error {
+		b, err := encodeTime(v, lsb)
+		return add(reg, b, err)
+	}
+
+	errs := []error{
+		g(regThreshTap, d.TapThreshold),
+		t(regDur, d.TapDuration, durLSB),
+		t(regLatent, d.TapLatency, latentLSB),
+		t(regWindow, d.TapWindow, latentLSB),
+		add(regTapAxes, byte(d.TapAxes&AllAxes), nil),
+		g(regThreshAct, d.ActivityThreshold),
+		g(regThreshInact, d.InactivityThreshold),
+		t(regTimeInact, d.InactivityTime, inactLSB),
+		g(regThreshFF, d.FreeFallThreshold),
+		t(regTimeFF, d.FreeFallTime, timeFFLSB),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, 0, err
+	}
+
+	if d.TapThreshold > 0 && d.TapAxes&AllAxes != 0 {
+		enable |= intSingleTap
+		if d.TapWindow > 0 {
+			enable |= intDoubleTap
+		}
+	}
+	axes := byte(d.ActivityAxes & AllAxes)
+	if d.ActivityThreshold > 0 && axes != 0 {
+		enable |= intActivity
+		actCtl |= actACCoupl | axes<<4
+	}
+	if d.InactivityThreshold > 0 && axes != 0 {
+		enable |= intInactivity
+		actCtl |= axes
+	}
+	if d.FreeFallThreshold > 0 {
+		enable |= intFreeFall
+	}
+	regs = append(regs, regval{regActInactCtl, actCtl})
+	return regs, enable, nil
+}
+
+// Event is a detected tap, activity or free fall. Axis names the
+// axes that triggered a tap or activity.
+type Event struct {
+	Event string `json:"event"`
+	Axis  string `json:"axis,omitempty"`
+}
+
+// decode turns the INT_SOURCE and ACT_TAP_STATUS registers into
+// events, only the enabled interrupts are reported
+func decode(source, status, enabled byte) []Event {
+	source &= enabled
+	tapAxes := Axes(status & 0x07).String()
+	actAxes := Axes(status >> 4 & 0x07).String()
+
+	var evts []Event
+	// a double tap sets the single tap bit as well
+	switch {
+	case source&intDoubleTap != 0:
+		evts = append(evts, Event{Event: "double_tap", Axis: tapAxes})
+	case source&intSingleTap != 0:
+		evts = append(evts, Event{Event: "tap", Axis: tapAxes})
+	}
+	if source&intActivity != 0 {
+		evts = append(evts, Event{Event: "activity", Axis: actAxes})
+	}
+	if source&intInactivity != 0 {
+		evts = append(evts, Event{Event: "inactivity"})
+	}
+	if source&intFreeFall != 0 {
+		evts = append(evts, Event{Event: "free_fall"})
+	}
+	return evts
+}
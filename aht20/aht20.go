@@ -0,0 +1,346 @@
+// Package aht20 provides a driver for the Aosong AHT20 and AHT21
+// temperature and humidity sensors. The sensor has no registers, it
+// takes 3 byte commands and answers a measurement with 7 bytes: the
+// status, 20 bits of humidity, 20 of temperature and a CRC.
+//
+// The AHT20 is known to lock up, answering busy for good or with
+// garbage, after a brown out or a glitch on the bus. A failed
+// measurement soft resets it, calibrates it again and measures once
+// more.
+package aht20
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the only address of the sensor
+	Address = 0x38
+)
+
+// commands and status bits, see section 5.4 of the datasheet
+const (
+	cmdStatus    = 0x71
+	cmdInit      = 0xBE
+	cmdMeasure   = 0xAC
+	cmdSoftReset = 0xBA
+
+	StatusBusy       = 1 << 7
+	StatusCalibrated = 1 << 3
+	// statusReady are the bits set on a sensor that needs no
+	// initialization
+	statusReady = 0x18
+
+	// the datasheet waits 10ms after the initialization, 80ms for a
+	// measurement and 20ms after a soft reset. A busy sensor is
+	// polled a few times more before it is given up on.
+	initTime    = 10 * time.Millisecond
+	measureTime = 80 * time.Millisecond
+	resetTime   = 20 * time.Millisecond
+	pollTime    = 10 * time.Millisecond
+	maxPolls    = 5
+)
+
+var (
+	ErrCRC         = errors.New("aht20 crc mismatch")
+	ErrBusy        = errors.New("aht20 stays busy")
+	ErrCalibration = errors.New("aht20 not calibrated")
+	ErrReadFailed  = errors.New("failed to read from AHT20")
+	ErrCommand     = errors.New("unknown aht20 command")
+)
+
+// Response holds the temperature (C) and humidity (%RH)
+type Response struct {
+	Temperature float64
+	Humidity    float64
+}
+
+// Env is what ReadPub publishes, the bme280 Env without the pressure
+type Env struct {
+	Temperature string `json:"temperature"`
+	Humidity    string `json:"humidity"`
+}
+
+// AHT20 is a temperature and humidity sensor on an I2C bus
+type AHT20 struct {
+	*device.Device
+
+	bus   string
+	dev   *drivers.I2CDevice
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates an AHT20 on the given bus, the sensor is not touched
+// until Init
+func New(name, bus string) *AHT20 {
+	return &AHT20{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		sleep:  time.Sleep,
+	}
+}
+
+// Init opens the i2c bus and calibrates the sensor if it needs it
+func (a *AHT20) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+
+	dev, err := drivers.NewI2CDevice(a.bus, Address)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dev = dev
+	return a.calibrate()
+}
+
+// Name returns the name of the device
+func (a *AHT20) Name() string {
+	return a.Device.Name
+}
+
+// Read triggers a measurement and reads it. When it fails the sensor
+// is soft reset, calibrated and measured once more.
+func (a *AHT20) Read() (*Response, error) {
+	if device.IsMock() {
+		return &Response{
+			Temperature: 18 + a.MockRand().Float64()*6,
+			Humidity:    40 + a.MockRand().Float64()*20,
+		}, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	resp, err := a.measure()
+	if err == nil {
+		return resp, nil
+	}
+	slog.Warn("aht20 read failed, resetting", "device", a.Device.Name, "error", err)
+	if rerr := a.reset(); rerr != nil {
+		return nil, fmt.Errorf("%w: %w (reset: %w)", ErrReadFailed, err, rerr)
+	}
+	if resp, err = a.measure(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return resp, nil
+}
+
+// Temperature reads the temperature in °C, the AHT20 is a
+// device.Thermometer
+func (a *AHT20) Temperature() (float64, error) {
+	r, err := a.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// Humidity reads the relative humidity in %, the AHT20 is a
+// device.Hygrometer
+func (a *AHT20) Humidity() (float64, error) {
+	r, err := a.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Humidity, nil
+}
+
+func (a *AHT20) measure() (*Response, error) {
+	if err := a.command(cmdMeasure, 0x33, 0x00); err != nil {
+		return nil, err
+	}
+	a.sleep(measureTime)
+
+	buf := make([]byte, 7)
+	for i := 0; ; i++ {
+		if err := a.read(buf); err != nil {
+			return nil, err
+		}
+		if buf[0]&StatusBusy == 0 {
+			break
+		}
+		if i == maxPolls {
+			return nil, fmt.Errorf("%w: status %#02x", ErrBusy, buf[0])
+		}
+		a.sleep(pollTime)
+	}
+	if crc := crc8(buf[:6]...); crc != buf[6] {
+		return nil, fmt.Errorf("%w: got %#02x want %#02x", ErrCRC, buf[6], crc)
+	}
+	hum, temp := raw(buf)
+	return &Response{
+		Temperature: temperature(temp),
+		Humidity:    humidity(hum),
+	}, nil
+}
+
+// Status reads the status byte, see StatusBusy and StatusCalibrated
+func (a *AHT20) Status() (byte, error) {
+	if device.IsMock() {
+		return statusReady, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status()
+}
+
+func (a *AHT20) status() (byte, error) {
+	if err := a.command(cmdStatus); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1)
+	if err := a.read(buf); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// calibrate sends the initialization command unless the status shows
+// the sensor ready, and checks the calibration bit after
+func (a *AHT20) calibrate() error {
+	s, err := a.status()
+	if err != nil {
+		return err
+	}
+	if s&statusReady == statusReady {
+		return nil
+	}
+	if err := a.command(cmdInit, 0x08, 0x00); err != nil {
+		return err
+	}
+	a.sleep(initTime)
+	if s, err = a.status(); err != nil {
+		return err
+	}
+	if s&StatusCalibrated == 0 {
+		return fmt.Errorf("%w: status %#02x", ErrCalibration, s)
+	}
+	return nil
+}
+
+// Reset soft resets and calibrates the sensor
+func (a *AHT20) Reset() error {
+	if device.IsMock() {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reset()
+}
+
+func (a *AHT20) reset() error {
+	if err := a.command(cmdSoftReset); err != nil {
+		return err
+	}
+	a.sleep(resetTime)
+	return a.calibrate()
+}
+
+// Command handles a command payload: "reset"
+func (a *AHT20) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "reset":
+		return a.Reset()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub reads the sensor and publishes the values on the device's
+// topic
+func (a *AHT20) ReadPub() error {
+	vals, err := a.Read()
+	if err != nil {
+		return fmt.Errorf("reading AHT20: %w", err)
+	}
+
+	jb, err := json.Marshal(&Env{
+		Temperature: fmt.Sprintf("%.2f", vals.Temperature),
+		Humidity:    fmt.Sprintf("%.2f", vals.Humidity),
+	})
+	if err != nil {
+		return err
+	}
+	a.PubData(jb)
+	return nil
+}
+
+// Close closes the i2c device
+func (a *AHT20) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dev == nil {
+		return nil
+	}
+	err := a.dev.Close()
+	a.dev = nil
+	return err
+}
+
+// command sends a command byte followed by its parameters
+func (a *AHT20) command(cmd byte, params ...byte) error {
+	buf := append([]byte{cmd}, params...)
+	return a.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.Write(buf); err != nil {
+			return fmt.Errorf("command %#02x: %w", cmd, err)
+		}
+		return nil
+	})
+}
+
+func (a *AHT20) read(buf []byte) error {
+	return a.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.Read(buf)
+	})
+}
+
+// crc8 is the CRC of the measurement, polynomial 0x31 starting at
+// 0xFF
+func crc8(data ...byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// raw returns the 20 bit humidity and temperature of a measurement,
+// they share the middle byte
+func raw(buf []byte) (hum, temp uint32) {
+	hum = uint32(buf[1])<<12 | uint32(buf[2])<<4 | uint32(buf[3])>>4
+	temp = uint32(buf[3]&0x0F)<<16 | uint32(buf[4])<<8 | uint32(buf[5])
+	return hum, temp
+}
+
+// humidity and temperature convert the raw values, section 6
+func humidity(raw uint32) float64 {
+	return 100 * float64(raw) / (1 << 20)
+}
+
+func temperature(raw uint32) float64 {
+	return 200*float64(raw)/(1<<20) - 50
+}
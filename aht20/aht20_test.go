@@ -0,0 +1,231 @@
+package aht20
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// measurements as the sensor sends them
+var (
+	frame25   = []byte{0x1C, 0x80, 0x00, 0x06, 0x00, 0x00, 0x4E} // 25.0C 50.0%RH
+	frameRoom = []byte{0x1C, 0x6E, 0x8A, 0x35, 0xB6, 0x2E, 0xDD} // 21.40C 43.18%RH
+	frameBusy = []byte{0x9C, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+)
+
+func newTestAHT20(t *testing.T) (*AHT20, *driverstest.I2C, *[]time.Duration) {
+	t.Helper()
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+	fake.Set(cmdStatus, statusReady)
+
+	a := New("aht-test", TestI2CBus)
+	var slept []time.Duration
+	a.sleep = func(d time.Duration) { slept = append(slept, d) }
+	if err := a.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	fake.Writes = nil
+	return a, fake, &slept
+}
+
+// commands returns the raw writes made to the fake as hex strings
+func commands(fake *driverstest.I2C) []string {
+	var cmds []string
+	for _, w := range fake.Writes {
+		cmds = append(cmds, fmt.Sprintf("% x", w.Data))
+	}
+	fake.Writes = nil
+	return cmds
+}
+
+func equal(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func TestCRC(t *testing.T) {
+	// the check value of CRC-8/NRSC-5, the same polynomial and
+	// start, and the example of the Sensirion datasheets
+	if got := crc8([]byte("123456789")...); got != 0xF7 {
+		t.Errorf("crc8(123456789) got (%#02x) want (0xf7)", got)
+	}
+	if got := crc8(0xBE, 0xEF); got != 0x92 {
+		t.Errorf("crc8(0xBEEF) got (%#02x) want (0x92)", got)
+	}
+	for _, f := range [][]byte{frame25, frameRoom} {
+		if got := crc8(f[:6]...); got != f[6] {
+			t.Errorf("crc8(% x) got (%#02x) want (%#02x)", f[:6], got, f[6])
+		}
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		frame []byte
+		temp  float64
+		hum   float64
+	}{
+		{[]byte{0x1C, 0x00, 0x00, 0x00, 0x00, 0x00}, -50, 0},
+		{frame25, 25, 50},
+		{frameRoom, 21.40, 43.18},
+		{[]byte{0x1C, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, 150, 100},
+	}
+	for _, tt := range tests {
+		hum, temp := raw(tt.frame)
+		if got := temperature(temp); math.Abs(got-tt.temp) > 0.01 {
+			t.Errorf("temperature(% x) got (%.2f) want (%.2f)", tt.frame[:6], got, tt.temp)
+		}
+		if got := humidity(hum); math.Abs(got-tt.hum) > 0.01 {
+			t.Errorf("humidity(% x) got (%.2f) want (%.2f)", tt.frame[:6], got, tt.hum)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name   string
+		status []byte // the status reads in order
+		cmds   []string
+		err    error
+	}{
+		{"ready", []byte{0x18}, []string{"71"}, nil},
+		{"after power on", []byte{0x00, 0x08}, []string{"71", "be 08 00", "71"}, nil},
+		{"calibration lost", []byte{0x10, 0x00}, []string{"71", "be 08 00", "71"}, ErrCalibration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device.Mock(false)
+			fake := driverstest.NewI2C()
+			driverstest.UseI2C(t, fake)
+			for _, s := range tt.status {
+				fake.QueueRead(s)
+			}
+			a := New("aht-test", TestI2CBus)
+			a.sleep = func(time.Duration) {}
+			if err := a.Init(); !errors.Is(err, tt.err) {
+				t.Errorf("Init() error got (%v) want (%v)", err, tt.err)
+			}
+			if got := commands(fake); !equal(got, tt.cmds) {
+				t.Errorf("commands got (%v) want (%v)", got, tt.cmds)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	a, fake, slept := newTestAHT20(t)
+
+	fake.QueueRead(frameRoom...)
+	resp, err := a.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(resp.Temperature-21.40) > 0.01 || math.Abs(resp.Humidity-43.18) > 0.01 {
+		t.Errorf("Read() got (%+v) want (21.40C 43.18%%)", resp)
+	}
+	if got, want := commands(fake), []string{"ac 33 00"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+	if fmt.Sprint(*slept) != fmt.Sprint([]time.Duration{measureTime}) {
+		t.Errorf("slept got (%v) want ([%v])", *slept, measureTime)
+	}
+
+	// still busy after the measurement time, it is polled
+	*slept = nil
+	fake.QueueRead(frameBusy...)
+	fake.QueueRead(frameBusy...)
+	fake.QueueRead(frame25...)
+	if resp, err = a.Read(); err != nil || resp.Temperature != 25 || resp.Humidity != 50 {
+		t.Errorf("Read() busy got (%+v, %v) want (25C 50%%)", resp, err)
+	}
+	if want := []time.Duration{measureTime, pollTime, pollTime}; fmt.Sprint(*slept) != fmt.Sprint(want) {
+		t.Errorf("slept got (%v) want (%v)", *slept, want)
+	}
+	if got, want := commands(fake), []string{"ac 33 00"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	// a thermometer linked by its name
+	dm := device.GetDeviceManager()
+	dm.Add(a)
+	defer dm.Remove(a.Name())
+	th, err := device.GetThermometer("aht-test")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	fake.QueueRead(frameRoom...)
+	if c, err := th.Temperature(); err != nil || math.Abs(c-21.40) > 0.01 {
+		t.Errorf("Temperature() got (%v, %v) want (21.40)", c, err)
+	}
+	h, err := device.GetHygrometer("aht-test")
+	if err != nil {
+		t.Fatalf("GetHygrometer() error = %v", err)
+	}
+	fake.QueueRead(frameRoom...)
+	if rh, err := h.Humidity(); err != nil || math.Abs(rh-43.18) > 0.01 {
+		t.Errorf("Humidity() got (%v, %v) want (43.18)", rh, err)
+	}
+}
+
+func TestReadRecovery(t *testing.T) {
+	a, fake, _ := newTestAHT20(t)
+
+	// a corrupt measurement resets the sensor and measures again
+	bad := append([]byte(nil), frame25...)
+	bad[6] ^= 0x01
+	fake.QueueRead(bad...)
+	fake.QueueRead(statusReady)
+	fake.QueueRead(frame25...)
+	if _, err := a.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got, want := commands(fake), []string{"ac 33 00", "ba", "71", "ac 33 00"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	// locked up busy, the reset does not help
+	busy := func() {
+		for range maxPolls + 1 {
+			fake.QueueRead(frameBusy...)
+		}
+	}
+	busy()
+	fake.QueueRead(statusReady)
+	busy()
+	_, err := a.Read()
+	if !errors.Is(err, ErrReadFailed) || !errors.Is(err, ErrBusy) {
+		t.Errorf("Read() locked up error got (%v) want (%v, %v)", err, ErrReadFailed, ErrBusy)
+	}
+	commands(fake)
+
+	// a sensor that does not answer the reset gives up
+	fake.FailNext(driverstest.ErrnoNak, driverstest.ErrnoNak)
+	if _, err := a.Read(); !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrReadFailed)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	a, fake, _ := newTestAHT20(t)
+
+	if err := a.Command([]byte(" Reset\n")); err != nil {
+		t.Fatalf("reset error = %v", err)
+	}
+	if got, want := commands(fake), []string{"ba", "71"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+	if err := a.Command([]byte("heater on")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(heater on) error got (%v) want (%v)", err, ErrCommand)
+	}
+	if s, err := a.Status(); err != nil || s != statusReady {
+		t.Errorf("Status() got (%#02x, %v) want (%#02x)", s, err, statusReady)
+	}
+}
@@ -0,0 +1,140 @@
+// Package analog provides a generic analog input device that reads
+// a drivers.AnalogReader, one channel of an ADS1115 by default, and
+// converts the voltage to engineering units (kPa, %RH, amps ...) with
+// a linear scale and offset.
+package analog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// AnalogInput is a single analog channel. Value = Volts * Scale + Offset,
+// the defaults (1, 0) report plain volts.
+type AnalogInput struct {
+	*device.Device
+	drivers.AnalogReader
+
+	Scale  float64
+	Offset float64
+	Units  string
+}
+
+// Reading is a single converted sample
+type Reading struct {
+	Volts float64 `json:"volts"`
+	Value float64 `json:"value"`
+	Units string  `json:"units"`
+}
+
+// New creates an analog input on channel ch (0 - 3) of the default
+// ADS1115. opts are passed on to the ADS1115 pin and may be nil, a
+// drivers.Gain or a drivers.ADS1115PinOpts.
+func New(name string, ch int, opts any) (*AnalogInput, error) {
+	return NewWithADC(name, drivers.GetADS1115(), ch, opts)
+}
+
+// NewWithADC creates an analog input on channel ch of the given ADS1115
+func NewWithADC(name string, ads *drivers.ADS1115, ch int, opts any) (*AnalogInput, error) {
+	p, err := ads.Pin(name, ch, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p), nil
+}
+
+// NewWithReader creates an analog input reading r
+func NewWithReader(name string, r drivers.AnalogReader) *AnalogInput {
+	return &AnalogInput{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		Scale:        1.0,
+		Units:        "V",
+	}
+}
+
+// Name returns the name of the device
+func (a *AnalogInput) Name() string {
+	return a.Device.Name
+}
+
+// SetScale sets the scale, the offset and the units of the values,
+// the Schemas of the readings are published again
+func (a *AnalogInput) SetScale(scale, offset float64, units string) error {
+	a.Scale, a.Offset, a.Units = scale, offset, units
+	return a.PubSchemas(a.Schemas())
+}
+
+// Schemas describe the Reading the input publishes, the value in its
+// units
+func (a *AnalogInput) Schemas() device.Schemas {
+	return device.Schemas{Data: device.Object(map[string]*device.Schema{
+		"volts": device.Number("volts read", "V"),
+		"value": device.Number(fmt.Sprintf("volts * %g + %g", a.Scale, a.Offset), a.Units),
+		"units": {Type: "string", Const: a.Units},
+	})}
+}
+
+// Convert applies the scale and offset to volts
+func (a *AnalogInput) Convert(volts float64) float64 {
+	return volts*a.Scale + a.Offset
+}
+
+// Read returns the voltage and the converted value of the channel.
+// An over range reading is returned along with drivers.ErrOverRange
+// so callers can decide to publish it anyway.
+func (a *AnalogInput) Read() (*Reading, error) {
+	volts, err := a.ReadVolts()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.Device.Name, err)
+	}
+	return &Reading{
+		Volts: volts,
+		Value: a.Convert(volts),
+		Units: a.Units,
+	}, nil
+}
+
+// ReadPub reads the channel and publishes the reading
+func (a *AnalogInput) ReadPub() error {
+	r, err := a.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (a *AnalogInput) Run(ctx context.Context, period time.Duration) error {
+	err := a.TimerLoop(ctx, period, a.ReadPub)
+	slog.Debug("analog input stopped", "device", a.Device.Name, "error", err)
+	return err
+}
+
+// String returns the device and the channel it reads
+func (a *AnalogInput) String() string {
+	if s, ok := a.AnalogReader.(fmt.Stringer); ok {
+		return a.Device.String() + s.String()
+	}
+	return a.Device.String()
+}
+
+// Close releases the reader, the ADS1115 channel by default
+func (a *AnalogInput) Close() error {
+	if c, ok := a.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package analog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+func TestAnalogInputConvert(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	tests := []struct {
+		name   string
+		scale  float64
+		offset float64
+		code   int16
+		volts  float64
+		value  float64
+	}{
+		{name: "volts", scale: 1, offset: 0, code: 16384, volts: 2.048, value: 2.048},
+		// a 0.5V - 4.5V, 0 - 100 psi pressure transducer
+		{name: "pressure", scale: 25, offset: -12.5, code: 20480, volts: 2.56, value: 51.5},
+		{name: "negative", scale: 10, offset: 1, code: -8192, volts: -1.024, value: -9.24},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+			ain, err := NewWithADC(tt.name, ads, i, nil)
+			if err != nil {
+				t.Fatalf("NewWithADC() error = %v", err)
+			}
+			ain.Scale, ain.Offset = tt.scale, tt.offset
+			ads.MockCodes(i, tt.code)
+
+			r, err := ain.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if math.Abs(r.Volts-tt.volts) > 0.0001 || math.Abs(r.Value-tt.value) > 0.0001 {
+				t.Errorf("Read() got (%f, %f) want (%f, %f)", r.Volts, r.Value, tt.volts, tt.value)
+			}
+		})
+	}
+}
+
+func TestAnalogInputOverRange(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	ain, err := NewWithADC("ain", ads, 0, drivers.Gain0_256V)
+	if err != nil {
+		t.Fatalf("NewWithADC() error = %v", err)
+	}
+	ads.MockCodes(0, -32768)
+
+	if _, err := ain.Read(); !errors.Is(err, drivers.ErrOverRange) {
+		t.Errorf("Read() error = %v want %v", err, drivers.ErrOverRange)
+	}
+	if _, err := NewWithADC("bad", ads, 1, drivers.Gain(9)); !errors.Is(err, drivers.ErrInvalidGain) {
+		t.Errorf("NewWithADC() error = %v want %v", err, drivers.ErrInvalidGain)
+	}
+}
+
+func TestAnalogInputReader(t *testing.T) {
+	// a divider halving a 0 - 5V signal, calibrated against a meter
+	m := drivers.NewMockAnalogPin("mock", 0)
+	cal, err := drivers.NewCalibrated(m, 0.5, 1.0, 2.0, 4.0)
+	if err != nil {
+		t.Fatalf("NewCalibrated() error = %v", err)
+	}
+	ain := NewWithReader("ain", cal)
+	ain.Scale, ain.Units = 20, "%"
+
+	m.MockValues(1.25)
+	r, err := ain.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Volts != 2.5 || r.Value != 50 || r.Units != "%" {
+		t.Errorf("Read() got (%+v) want (2.5V 50%%)", *r)
+	}
+	if err := ain.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestAnalogInputRun(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	ain, err := NewWithADC("ain", ads, 2, nil)
+	if err != nil {
+		t.Fatalf("NewWithADC() error = %v", err)
+	}
+	defer ain.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ain.Run(ctx, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v want %v", err, context.DeadlineExceeded)
+	}
+	if ain.Device.State != device.StateStopped {
+		t.Errorf("state got (%s) want (%s)", ain.Device.State, device.StateStopped)
+	}
+	if ain.Name() != "ain" {
+		t.Errorf("Name() got (%s) want (ain)", ain.Name())
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestAnalogInputSchemas(t *testing.T) {
+	c := devicetest.Use(t)
+	dm := device.GetDeviceManager()
+	t.Cleanup(dm.Clear)
+
+	ain := NewWithReader("tank", drivers.NewMockAnalogPin("mock", 0))
+	for _, tt := range []struct {
+		name string
+		set  func() error
+	}{
+		{"volts", func() error { return dm.Add(ain) }},
+		// a 0.5V - 4.5V, 0 - 100 psi pressure transducer
+		{"pressure", func() error { return ain.SetScale(25, -12.5, "psi") }},
+	} {
+		if err := tt.set(); err != nil {
+			t.Fatalf("%s error = %v", tt.name, err)
+		}
+		msg := c.ExpectPublish(device.SchemaTopic(ain.DataTopic()), devicetest.Retained(true), devicetest.Timeout)
+		var got bytes.Buffer
+		json.Indent(&got, msg.Data, "", "  ")
+		got.WriteByte('\n')
+
+		golden := filepath.Join("testdata", "schema_"+tt.name+".json")
+		if *update {
+			os.MkdirAll("testdata", 0o755)
+			os.WriteFile(golden, got.Bytes(), 0o644)
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s schema got\n%s\nwant\n%s", tt.name, got.String(), want)
+		}
+	}
+}
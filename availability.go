@@ -0,0 +1,86 @@
+package device
+
+import (
+	"slices"
+	"sync"
+)
+
+// The payloads of the availability topics, the ones Home Assistant
+// expects by default
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// announcer keeps the availability topics a Messanger announces, the
+// station's first. They are "online" after every connect, the
+// station's goes "offline" with the will when the connection is lost
+// and all of them when the Messanger is closed.
+type announcer struct {
+	topics []string
+	mu     sync.Mutex
+}
+
+// add adds topic, it returns false when it was announced already
+func (a *announcer) add(topic string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if slices.Contains(a.topics, topic) {
+		return false
+	}
+	a.topics = append(a.topics, topic)
+	return true
+}
+
+// withdraw stops announcing topic, its retained message was cleared
+func (a *announcer) withdraw(topic string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.topics = slices.DeleteFunc(a.topics, func(t string) bool { return t == topic })
+}
+
+func (a *announcer) list() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.topics)
+}
+
+// announce publishes payload retained on every topic announced
+func (a *announcer) announce(m Messanger, payload string) error {
+	var err error
+	for _, topic := range a.list() {
+		if e := m.PublishQoS(topic, []byte(payload), AtLeastOnce, true); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// AvailabilityTopic returns the topic the device is announced on, the
+// one to give Home Assistant discovery along with its StationTopic
+func (d *Device) AvailabilityTopic() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.topics.Availability
+}
+
+// StationTopic returns the topic the station of the device is
+// announced on
+func (d *Device) StationTopic() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.topics.Station
+}
+
+// Announce has the Messanger of the device publish it "online" on its
+// AvailabilityTopic now and after every reconnect, and "offline" when
+// it is closed. A device goes offline with its station when the
+// connection is lost, the will is the station's. ClearRetained
+// withdraws it.
+func (d *Device) Announce() error {
+	m, ok := d.Messanger()
+	if !ok {
+		return nil
+	}
+	return m.Announce(d.AvailabilityTopic())
+}
@@ -0,0 +1,90 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAvailability(t *testing.T) {
+	m := NewMemMessanger()
+	SetMessanger("mqtt", m)
+	defer SetMessanger("mqtt", nil)
+
+	var got []string
+	m.Subscribe("ss/a/#", func(msg *Msg) { got = append(got, msg.Topic+" "+msg.String()) })
+	expect := func(when string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s got (%q) want (%q)", when, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s got (%q) want (%q)", when, got, want)
+			}
+		}
+		got = nil
+	}
+
+	relay := NewDevice("relay", "mqtt")
+	if relay.AvailabilityTopic() != "ss/a/station/relay" || relay.StationTopic() != "ss/a/station" || StationTopic() != "ss/a/station" {
+		t.Errorf("topics got (%s, %s)", relay.AvailabilityTopic(), relay.StationTopic())
+	}
+	m.Connect()
+	expect("connect", "ss/a/station online")
+	relay.Announce()
+	relay.Announce()
+	expect("announce", "ss/a/station/relay online")
+
+	// the connection is lost, the will is the station's alone
+	m.Disconnect()
+	expect("disconnect", "ss/a/station offline")
+	if err := relay.PubData("on"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PubData() disconnected error got (%v) want (%v)", err, ErrNotConnected)
+	}
+	m.Connect()
+	expect("reconnect", "ss/a/station online", "ss/a/station/relay online")
+	if msg, _ := m.Retained("ss/a/station"); msg.String() != Online {
+		t.Errorf("station retained got (%v) want (online)", msg)
+	}
+
+	// removed, the device is no longer announced
+	dm := GetDeviceManager()
+	dm.Add(named{relay})
+	dm.Remove("relay")
+	got = nil
+	m.Disconnect()
+	m.Connect()
+	expect("removed", "ss/a/station offline", "ss/a/station online")
+
+	led := NewDevice("led", "mqtt")
+	led.Announce()
+	dm.Add(named{led})
+	defer dm.Clear()
+	got = nil
+	if err := dm.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	expect("shutdown", "ss/a/station offline", "ss/a/station/led offline")
+	if _, ok := GetMessanger("mqtt"); ok {
+		t.Error("Shutdown() left the messanger set")
+	}
+	if err := m.Publish("ss/d/station/led", nil); !errors.Is(err, ErrMessangerClosed) {
+		t.Errorf("Publish() after Shutdown() error got (%v) want (%v)", err, ErrMessangerClosed)
+	}
+}
+
+func TestAvailabilityMQTT(t *testing.T) {
+	c := newClient()
+	m := &MQTT{Broker: "tcp://test:1883", client: c}
+	m.births.add(StationTopic())
+
+	m.connected()
+	m.Announce("ss/a/station/relay")
+	if c.published["ss/a/station"] != Online || c.published["ss/a/station/relay"] != Online || c.retained["ss/a/station/relay"] != 1 {
+		t.Errorf("birth got (%v, %v)", c.published, c.retained)
+	}
+	m.Close()
+	if c.published["ss/a/station"] != Offline || c.published["ss/a/station/relay"] != Offline {
+		t.Errorf("Close() got (%v) want offline", c.published)
+	}
+}
@@ -0,0 +1,263 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultDebounce is how long a Beacon waits for the devices to stop
+// changing before it publishes them
+const DefaultDebounce = time.Second
+
+// module is the path of this module, the one Version looks up
+const module = "github.com/rustyeddy/otto-devices"
+
+// started is when the station started, its uptime is counted from it
+var started = time.Now()
+
+// interfaceAddrs returns the addresses of the station, a var for the
+// tests
+var interfaceAddrs = net.InterfaceAddrs
+
+// StationInfo is the document a station announces itself with, for
+// the tools to list the stations on a broker
+type StationInfo struct {
+	Station   string       `json:"station"`
+	Version   string       `json:"version"`
+	Started   time.Time    `json:"started"`
+	Uptime    float64      `json:"uptime"` // seconds
+	Addresses []string     `json:"addresses"`
+	Devices   []DeviceInfo `json:"devices"`
+	Mocks     *MockReport  `json:"mocks,omitempty"` // when anything is faked
+}
+
+// DeviceInfo is a device of the station in its StationInfo
+type DeviceInfo struct {
+	Name    string `json:"name"`
+	Data    string `json:"data,omitempty"`
+	Control string `json:"control,omitempty"`
+}
+
+// Beacon announces the station with its StationInfo, retained on
+// Topic, when it starts and when a tool asks on the Request topic.
+// A device added to the manager or removed publishes it again, once
+// the devices stopped changing for Debounce, so registering twenty of
+// them publishes it once.
+type Beacon struct {
+	Topic    string // "ss/i/<station>"
+	Request  string // "ss/who", any payload asks every station
+	Debounce time.Duration
+
+	dm      *DeviceManager
+	m       Messanger
+	stop    func()
+	done    chan struct{}
+	refresh *time.Timer
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+// ErrBeacon is returned by a Beacon started twice or stopped before
+// it was started
+var ErrBeacon = errors.New("beacon")
+
+// NewBeacon creates the Beacon of the station with the devices of dm
+func NewBeacon(dm *DeviceManager) *Beacon {
+	return &Beacon{
+		Topic:    "ss/i/" + Station(),
+		Request:  "ss/who",
+		Debounce: DefaultDebounce,
+		dm:       dm,
+	}
+}
+
+// Version returns the version of this module in the station, "(devel)"
+// when it is not built from a release
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == module {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == module {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
+
+// Info returns the StationInfo of the station now
+func (b *Beacon) Info() StationInfo {
+	info := StationInfo{
+		Station:   Station(),
+		Version:   Version(),
+		Started:   started.UTC(),
+		Uptime:    time.Since(started).Seconds(),
+		Addresses: addresses(),
+		Devices:   []DeviceInfo{},
+	}
+	names := b.dm.List()
+	slices.Sort(names)
+	for _, name := range names {
+		d, ok := b.dm.Get(name)
+		if !ok {
+			continue
+		}
+		di := DeviceInfo{Name: name}
+		if t, ok := d.(interface{ DataTopic() string }); ok {
+			di.Data = t.DataTopic()
+		}
+		if t, ok := d.(interface{ ControlTopic() string }); ok {
+			di.Control = t.ControlTopic()
+		}
+		info.Devices = append(info.Devices, di)
+	}
+	if mocks := Mocks(); mocks.active() {
+		info.Mocks = &mocks
+	}
+	return info
+}
+
+// addresses returns the IP addresses of the station but the loopback
+// and link local ones
+func addresses() []string {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		slog.Warn("station addresses", "error", err)
+	}
+	ips := []string{}
+	for _, a := range addrs {
+		var ip net.IP
+		switch a := a.(type) {
+		case *net.IPNet:
+			ip = a.IP
+		case *net.IPAddr:
+			ip = a.IP
+		}
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// Start publishes the StationInfo on m and keeps it up to date with
+// the devices, and answers the requests
+func (b *Beacon) Start(m Messanger) error {
+	b.mu.Lock()
+	if b.done != nil {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: %s started", ErrBeacon, b.Topic)
+	}
+	b.m = m
+	b.done = make(chan struct{})
+	events, stop := SubscribeEvents(streamBuffer)
+	b.stop = stop
+	b.mu.Unlock()
+
+	warnMocks("station started with mocks, its readings are not all of the sensors")
+	err := b.publish()
+	if e := m.Subscribe(b.Request, b.requested); e != nil {
+		err = errors.Join(err, e)
+	}
+
+	b.wg.Add(1)
+	go b.follow(events)
+	return err
+}
+
+// Stop stops keeping the StationInfo up to date, it stays retained for
+// the tools to list the station, its StationTopic tells it is offline
+func (b *Beacon) Stop() error {
+	b.mu.Lock()
+	if b.done == nil {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: %s not started", ErrBeacon, b.Topic)
+	}
+	close(b.done)
+	b.stop()
+	if b.refresh != nil {
+		b.refresh.Stop()
+		b.refresh = nil
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+
+	b.mu.Lock()
+	b.done = nil
+	b.mu.Unlock()
+	return nil
+}
+
+// follow publishes the StationInfo again when a device is added or
+// removed, Debounce after the last one
+func (b *Beacon) follow(events <-chan Event) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case ev := <-events:
+			if ev.Type != EventAdded && ev.Type != EventRemoved {
+				continue
+			}
+			b.mu.Lock()
+			if b.refresh == nil {
+				b.refresh = time.AfterFunc(b.Debounce, b.refreshed)
+			} else {
+				b.refresh.Reset(b.Debounce)
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *Beacon) refreshed() {
+	b.mu.Lock()
+	if b.done == nil || b.refresh == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.refresh = nil
+	b.mu.Unlock()
+	if err := b.publish(); err != nil {
+		slog.Error("station info", "topic", b.Topic, "error", err)
+	}
+}
+
+// requested answers a request, not the one retained the Beacon had
+// answered when it started
+func (b *Beacon) requested(msg *Msg) {
+	if msg.Retained {
+		return
+	}
+	if err := b.publish(); err != nil {
+		slog.Error("station info", "topic", b.Topic, "error", err)
+	}
+}
+
+// publish publishes the StationInfo retained
+func (b *Beacon) publish() error {
+	b.mu.Lock()
+	m := b.m
+	b.mu.Unlock()
+	doc, err := json.Marshal(b.Info())
+	if err != nil {
+		return err
+	}
+	return m.PublishRetained(b.Topic, doc)
+}
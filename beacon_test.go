@@ -0,0 +1,95 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBeacon(t *testing.T) {
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	defer func(f func() ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("192.168.1.20"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::20"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+
+	m := NewMemMessanger()
+	m.PublishRetained("ss/who", []byte("?"))
+	dm.Add(named{NewDevice("soil", "mqtt")})
+	dm.Add(named{NewDevice("pump", "mqtt")})
+
+	b := NewBeacon(dm)
+	b.Debounce = 20 * time.Millisecond
+	if err := b.Start(m); err != nil {
+		t.Fatalf("Start() error (%v)", err)
+	}
+	defer b.Stop()
+	if err := b.Start(m); !errors.Is(err, ErrBeacon) {
+		t.Errorf("Start() again error got (%v) want (%v)", err, ErrBeacon)
+	}
+
+	msgs := m.Messages("ss/i/station")
+	if len(msgs) != 1 || !msgs[0].Retained {
+		t.Fatalf("published got (%v) want the info retained once, not for the request retained", msgs)
+	}
+	var info StationInfo
+	if err := json.Unmarshal(msgs[0].Data, &info); err != nil {
+		t.Fatalf("info (%s) error (%v)", msgs[0].Data, err)
+	}
+	if info.Station != "station" || info.Version == "" || info.Uptime <= 0 || info.Started.After(time.Now()) {
+		t.Errorf("info got (%+v)", info)
+	}
+	if want := []string{"192.168.1.20", "2001:db8::20"}; !reflect.DeepEqual(info.Addresses, want) {
+		t.Errorf("addresses got (%v) want (%v)", info.Addresses, want)
+	}
+	want := []DeviceInfo{
+		{Name: "pump", Data: "ss/d/station/pump", Control: "ss/c/station/pump"},
+		{Name: "soil", Data: "ss/d/station/soil", Control: "ss/c/station/soil"},
+	}
+	if !reflect.DeepEqual(info.Devices, want) {
+		t.Errorf("devices got (%+v) want (%+v)", info.Devices, want)
+	}
+
+	// a burst of devices is published once they settled
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		dm.Add(named{NewDevice(name, "mqtt")})
+	}
+	dm.Remove("a")
+	waitFor(t, func() bool { return len(m.Messages("ss/i/station")) == 2 })
+	time.Sleep(3 * b.Debounce)
+	msgs = m.Messages("ss/i/station")
+	if len(msgs) != 2 {
+		t.Fatalf("published after the burst got %d want 1 more", len(msgs)-1)
+	}
+	json.Unmarshal(msgs[1].Data, &info)
+	if len(info.Devices) != 9 || info.Devices[0].Name != "b" {
+		t.Errorf("devices after the burst got (%+v)", info.Devices)
+	}
+
+	// a request is answered at once
+	m.Publish("ss/who", nil)
+	if n := len(m.Messages("ss/i/station")); n != 3 {
+		t.Errorf("published after a request got %d want 3", n)
+	}
+
+	if err := b.Stop(); err != nil {
+		t.Errorf("Stop() error (%v)", err)
+	}
+	if err := b.Stop(); !errors.Is(err, ErrBeacon) {
+		t.Errorf("Stop() again error got (%v) want (%v)", err, ErrBeacon)
+	}
+	dm.Add(named{NewDevice("late", "mqtt")})
+	time.Sleep(3 * b.Debounce)
+	if _, ok := m.Retained("ss/i/station"); !ok || len(m.Messages("ss/i/station")) != 3 {
+		t.Errorf("after Stop got (%d) published want none and the info retained", len(m.Messages("ss/i/station")))
+	}
+}
@@ -0,0 +1,58 @@
+package bh1750
+
+// levels are the settings AutoRange steps through, from the widest
+// range to the most sensitive
+var levels = []struct {
+	res Resolution
+	mt  int
+}{
+	{High, MTregMin},      // up to 121557 lx, 1.85 lx per count
+	{High, MTregDefault},  // up to 54612 lx, 0.83 lx per count
+	{High2, MTregDefault}, // up to 27306 lx, 0.42 lx per count
+	{High2, MTregMax},     // up to 7417 lx, 0.11 lx per count
+}
+
+// defaultLevel matches the power on High resolution and MTreg 69
+const defaultLevel = 1
+
+// gain returns the counts per lux of a level
+func gain(level int) float64 {
+	return 1 / Lux(1, levels[level].res, levels[level].mt)
+}
+
+// autoRange returns the level for the next measurement and if the
+// count should be measured again at that level. A saturated count
+// steps down and is measured again. A count that would stay under
+// half scale at the next level steps up for the next reading, the
+// margin keeps it from stepping straight back down.
+func autoRange(level int, count uint16) (next int, again bool) {
+	switch {
+	case level < 0:
+		// the resolution or MTreg were set by hand
+		return defaultLevel, true
+
+	case count == saturated:
+		if level == 0 {
+			return level, false
+		}
+		return level - 1, true
+
+	case level < len(levels)-1:
+		if float64(count)*gain(level+1)/gain(level) < saturated/2 {
+			return level + 1, false
+		}
+	}
+	return level, false
+}
+
+// setLevel switches the resolution and MTreg to those of level
+func (b *BH1750) setLevel(level int) error {
+	l := levels[level]
+	if l.mt != b.mt {
+		if err := b.writeMTreg(l.mt); err != nil {
+			return err
+		}
+	}
+	b.res, b.level = l.res, level
+	return b.start()
+}
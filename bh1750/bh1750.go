@@ -0,0 +1,389 @@
+// Package bh1750 provides a driver for the BH1750 ambient light
+// sensor. The sensor counts light over a measurement time (MTreg)
+// and reports 16 bit counts, 1.2 counts per lux at the default
+// MTreg of 69 in high resolution mode.
+//
+// With AutoRange set the resolution and measurement time follow the
+// light level, a saturated reading steps down and is measured again,
+// a dim one steps up for the next reading.
+package bh1750
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// AddressLow is the address with the ADDR pin low, AddressHigh
+	// with it high
+	AddressLow  = 0x23
+	AddressHigh = 0x5C
+
+	// measurement time register limits, section "Measurement
+	// sensitivity adjustment" of the datasheet
+	MTregMin     = 31
+	MTregDefault = 69
+	MTregMax     = 254
+
+	saturated = 0xFFFF
+)
+
+// instructions
+const (
+	opPowerDown  = 0x00
+	opPowerOn    = 0x01
+	opReset      = 0x07
+	opContinuous = 0x10 // | Resolution
+	opOneTime    = 0x20 // | Resolution
+	opMTregHigh  = 0x40
+	opMTregLow   = 0x60
+)
+
+// Resolution is the measurement mode, its value is the low bits of
+// the measurement instructions
+type Resolution byte
+
+const (
+	// High counts 1 lx, 120ms at MTreg 69
+	High Resolution = 0x00
+	// High2 counts 0.5 lx with half the range of High
+	High2 Resolution = 0x01
+	// Low counts 4 lx, 16ms at MTreg 69
+	Low Resolution = 0x03
+)
+
+// Mode selects one-shot measurements, after which the sensor powers
+// down, or continuous measurements
+type Mode int
+
+const (
+	OneShot Mode = iota
+	Continuous
+)
+
+var (
+	ErrMTreg      = errors.New("bh1750 MTreg must be 31 to 254")
+	ErrResolution = errors.New("invalid bh1750 resolution")
+	ErrSaturated  = errors.New("bh1750 saturated")
+	ErrReadFailed = errors.New("failed to read from BH1750")
+)
+
+// Reading is a single measurement, Lux is what ReadPub publishes
+type Reading struct {
+	Lux   float64 `json:"lux"`
+	Count uint16  `json:"-"`
+}
+
+// BH1750 is an ambient light sensor on an I2C bus
+type BH1750 struct {
+	*device.Device
+
+	// AutoRange adjusts the resolution and MTreg to the light level
+	AutoRange bool
+
+	bus   string
+	addr  int
+	dev   *drivers.I2CDevice
+	mode  Mode
+	res   Resolution
+	mt    int
+	level int // the autorange level matching res and mt, -1 if set by hand
+
+	counts []uint16       // scripted mock counts
+	live   func() float64 // the Generator started, lux
+	mu     sync.Mutex
+}
+
+// New creates a BH1750 at the given bus and address in one-shot high
+// resolution mode, the sensor is not touched until Init
+func New(name, bus string, addr int) *BH1750 {
+	b := &BH1750{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		addr:   addr,
+		res:    High,
+		mt:     MTregDefault,
+		level:  defaultLevel,
+	}
+	b.SetMockKind("bh1750", b)
+	return b
+}
+
+// Init opens the i2c bus and writes the mode, resolution and MTreg
+func (b *BH1750) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(b.bus, b.addr)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dev = dev
+	if err := b.send(opPowerOn, opReset); err != nil {
+		return err
+	}
+	if err := b.writeMTreg(b.mt); err != nil {
+		return err
+	}
+	return b.start()
+}
+
+// Name returns the name of the device
+func (b *BH1750) Name() string {
+	return b.Device.Name
+}
+
+// SetMode switches between one-shot and continuous measurements
+func (b *BH1750) SetMode(m Mode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mode = m
+	return b.start()
+}
+
+// SetResolution selects the resolution, it turns AutoRange off
+func (b *BH1750) SetResolution(r Resolution) error {
+	if r != High && r != High2 && r != Low {
+		return fmt.Errorf("%w: %#02x", ErrResolution, byte(r))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.AutoRange = false
+	b.res, b.level = r, -1
+	return b.start()
+}
+
+// SetMTreg sets the measurement time, 31 to 254. A low MTreg extends
+// the range in bright light, a high one the sensitivity in dim light.
+// It turns AutoRange off.
+func (b *BH1750) SetMTreg(mt int) error {
+	if mt < MTregMin || mt > MTregMax {
+		return fmt.Errorf("%w: %d", ErrMTreg, mt)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.AutoRange = false
+	b.level = -1
+	if err := b.writeMTreg(mt); err != nil {
+		return err
+	}
+	return b.start()
+}
+
+// Settings returns the current resolution and MTreg
+func (b *BH1750) Settings() (Resolution, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.res, b.mt
+}
+
+// Read measures the light level. A saturated reading returns
+// ErrSaturated along with the lux, with AutoRange only once the
+// least sensitive setting saturates as well.
+func (b *BH1750) Read() (*Reading, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		count, err := b.measure()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		r := &Reading{Lux: Lux(count, b.res, b.mt), Count: count}
+		if !b.AutoRange {
+			if count == saturated {
+				return r, ErrSaturated
+			}
+			return r, nil
+		}
+
+		next, again := autoRange(b.level, count)
+		if next != b.level {
+			if err := b.setLevel(next); err != nil {
+				return r, err
+			}
+		}
+		if !again {
+			if count == saturated {
+				return r, ErrSaturated
+			}
+			return r, nil
+		}
+	}
+}
+
+// ReadPub reads the sensor and publishes the lux
+func (b *BH1750) ReadPub() error {
+	r, err := b.Read()
+	if err != nil && !errors.Is(err, ErrSaturated) {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (b *BH1750) Run(ctx context.Context, period time.Duration) error {
+	err := b.TimerLoop(ctx, period, b.ReadPub)
+	slog.Debug("bh1750 stopped", "device", b.Device.Name, "error", err)
+	return err
+}
+
+// Close powers the sensor down and closes the i2c device
+func (b *BH1750) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		return nil
+	}
+	b.send(opPowerDown)
+	err := b.dev.Close()
+	b.dev = nil
+	return err
+}
+
+// MockCounts scripts the raw counts returned in mock mode, they are
+// converted and auto ranged like counts read from the sensor
+func (b *BH1750) MockCounts(counts ...uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts = append(b.counts, counts...)
+}
+
+// SetGenerator has the mock reads take their lux of g, started now by
+// the clock of the device. The counts scripted with MockCounts come
+// first.
+func (b *BH1750) SetGenerator(g device.Generator) {
+	var live func() float64
+	if g != nil {
+		live = device.LiveOn(g, b.Now)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.live = live
+}
+
+// Lux converts a count to lux, the datasheet's count / 1.2 scaled
+// by the MTreg, High2 counts half lux
+func Lux(count uint16, r Resolution, mt int) float64 {
+	lux := float64(count) / 1.2 * MTregDefault / float64(mt)
+	if r == High2 {
+		lux /= 2
+	}
+	return lux
+}
+
+// count is the count of lux, Lux the other way saturated like the
+// sensor
+func count(lux float64, r Resolution, mt int) uint16 {
+	c := lux * 1.2 * float64(mt) / MTregDefault
+	if r == High2 {
+		c *= 2
+	}
+	return uint16(min(max(c, 0), saturated))
+}
+
+// measureTime is the longest a measurement takes, 180ms in high and
+// 24ms in low resolution at the default MTreg
+func measureTime(r Resolution, mt int) time.Duration {
+	t := 180 * time.Millisecond
+	if r == Low {
+		t = 24 * time.Millisecond
+	}
+	return t * time.Duration(mt) / MTregDefault
+}
+
+// measure returns the count of a one-shot measurement or the latest
+// continuous one
+func (b *BH1750) measure() (uint16, error) {
+	if device.IsMock() {
+		if err := b.ReadFault(); err != nil {
+			return 0, err
+		}
+		switch {
+		case len(b.counts) > 0:
+			c := b.counts[0]
+			b.counts = b.counts[1:]
+			return c, nil
+		case b.live != nil:
+			return count(b.live(), b.res, b.mt), nil
+		}
+		// an office, 300 to 500 lx
+		return count(300+b.MockRand().Float64()*200, b.res, b.mt), nil
+	}
+	if b.dev == nil {
+		return 0, errors.New("not initialized")
+	}
+
+	if b.mode == OneShot {
+		if err := b.send(opOneTime | byte(b.res)); err != nil {
+			return 0, err
+		}
+		time.Sleep(measureTime(b.res, b.mt))
+	}
+
+	buf := make([]byte, 2)
+	err := b.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.Read(buf)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint16(buf[0])<<8 | uint16(buf[1]), nil
+}
+
+// start begins continuous measurements, one-shot measurements are
+// started by every read
+func (b *BH1750) start() error {
+	if b.mode != Continuous {
+		return nil
+	}
+	if err := b.send(opContinuous | byte(b.res)); err != nil {
+		return err
+	}
+	// the first result is ready after a full measurement
+	time.Sleep(measureTime(b.res, b.mt))
+	return nil
+}
+
+// writeMTreg sends the MTreg in its two instructions, the high 3 and
+// the low 5 bits
+func (b *BH1750) writeMTreg(mt int) error {
+	if err := b.send(opMTregHigh|byte(mt>>5), opMTregLow|byte(mt&0x1F)); err != nil {
+		return err
+	}
+	b.mt = mt
+	return nil
+}
+
+// send writes single byte instructions
+func (b *BH1750) send(ops ...byte) error {
+	if device.IsMock() || b.dev == nil {
+		return nil
+	}
+	return b.dev.Tx(func(bus drivers.I2CBus) error {
+		for _, op := range ops {
+			if err := bus.Write([]byte{op}); err != nil {
+				return fmt.Errorf("instruction %#02x: %w", op, err)
+			}
+		}
+		return nil
+	})
+}
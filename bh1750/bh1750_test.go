@@ -0,0 +1,219 @@
+package bh1750
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func TestLux(t *testing.T) {
+	tests := []struct {
+		count uint16
+		res   Resolution
+		mt    int
+		lux   float64
+	}{
+		// the example of the datasheet, 0x8390 is 28067 lx
+		{0x8390, High, MTregDefault, 28066.67},
+		{0x8390, Low, MTregDefault, 28066.67},
+		{0x8390, High2, MTregDefault, 14033.33},
+		{0x8390, High, 138, 14033.33},
+		{0xFFFF, High, MTregMin, 121556.85},
+		{1, High2, MTregMax, 0.11},
+		{0, High, MTregDefault, 0},
+	}
+	for _, tt := range tests {
+		if got := Lux(tt.count, tt.res, tt.mt); math.Abs(got-tt.lux) > 0.01 {
+			t.Errorf("Lux(%#04x, %d, %d) got (%.2f) want (%.2f)", tt.count, tt.res, tt.mt, got, tt.lux)
+		}
+	}
+}
+
+func TestAutoRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		count uint16
+		next  int
+		again bool
+	}{
+		{"manual", -1, 1000, defaultLevel, true},
+		{"saturated", 1, 0xFFFF, 0, true},
+		{"saturated widest", 0, 0xFFFF, 0, false},
+		{"bright", 0, 20000, 0, false},
+		{"brighter than next", 0, 14800, 0, false},
+		{"dimmer", 0, 14000, 1, false},
+		{"steady", 1, 30000, 1, false},
+		{"dim", 1, 10000, 2, false},
+		{"dark", 2, 100, 3, false},
+		{"most sensitive", 3, 100, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, again := autoRange(tt.level, tt.count)
+			if next != tt.next || again != tt.again {
+				t.Errorf("autoRange(%d, %d) got (%d, %t) want (%d, %t)", tt.level, tt.count, next, again, tt.next, tt.again)
+			}
+		})
+	}
+}
+
+func TestReadAutoRange(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	b := New("lux-test", TestI2CBus, AddressLow)
+	b.AutoRange = true
+
+	// full sun saturates the default setting and is measured again
+	b.MockCounts(0xFFFF, 40000)
+	r, err := b.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(r.Lux-74193.55) > 0.01 {
+		t.Errorf("Read() lux got (%.2f) want (74193.55)", r.Lux)
+	}
+	if res, mt := b.Settings(); res != High || mt != MTregMin {
+		t.Errorf("Settings() got (%d, %d) want (%d, %d)", res, mt, High, MTregMin)
+	}
+
+	// brighter than the widest range
+	b.MockCounts(0xFFFF)
+	if _, err := b.Read(); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrSaturated)
+	}
+
+	// dusk steps up one level per reading
+	b.MockCounts(500, 500, 500, 500)
+	for _, want := range []struct {
+		res Resolution
+		mt  int
+	}{{High, MTregDefault}, {High2, MTregDefault}, {High2, MTregMax}, {High2, MTregMax}} {
+		if _, err := b.Read(); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if res, mt := b.Settings(); res != want.res || mt != want.mt {
+			t.Errorf("Settings() got (%d, %d) want (%d, %d)", res, mt, want.res, want.mt)
+		}
+	}
+
+	// without AutoRange a saturated reading is reported
+	b.SetResolution(High)
+	b.MockCounts(0xFFFF)
+	if r, err := b.Read(); !errors.Is(err, ErrSaturated) || r.Count != 0xFFFF {
+		t.Errorf("Read() got (%+v, %v) want (%v)", r, err, ErrSaturated)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	clock := device.NewSimClock(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+	b := New("lux-profile", TestI2CBus, AddressLow)
+	b.SetClock(clock)
+	if err := b.MockProfile("office"); err != nil {
+		t.Fatalf("MockProfile(office) error = %v", err)
+	}
+	for h := range 24 {
+		if r, err := b.Read(); err != nil || r.Lux < 299 || r.Lux > 501 {
+			t.Errorf("Read() office at %02d:00 got (%+v, %v) want 300 - 500 lx", h, r, err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	if err := b.MockProfile("outdoor"); err != nil {
+		t.Fatalf("MockProfile(outdoor) error = %v", err)
+	}
+	var naks int
+	for i := range 50 {
+		r, err := b.Read()
+		var nak *drivers.ErrNak
+		switch at := time.Duration(i) * 30 * time.Minute; {
+		case errors.As(err, &nak):
+			naks++
+		case err != nil:
+			t.Fatalf("Read() outdoor at %v error = %v", at, err)
+		case at == 0 && r.Lux > 200:
+			t.Errorf("Read() outdoor at midnight got (%.0f) lx want dark", r.Lux)
+		case at == 12*time.Hour && r.Lux < 45000:
+			t.Errorf("Read() outdoor at noon got (%.0f) lx want daylight", r.Lux)
+		}
+		clock.Advance(30 * time.Minute)
+	}
+	if naks != 1 {
+		t.Errorf("outdoor reads not acked got (%d) want (1)", naks)
+	}
+}
+
+// instructions returns the instructions written to the fake
+func instructions(fake *driverstest.I2C) string {
+	var ops []byte
+	for _, w := range fake.Writes {
+		ops = append(ops, w.Data...)
+	}
+	return fmt.Sprintf("% x", ops)
+}
+
+func TestInstructions(t *testing.T) {
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	b := New("lux-test", TestI2CBus, AddressHigh)
+	if err := b.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if got := instructions(fake); got != "01 07 42 65" {
+		t.Errorf("Init() wrote (%s) want (01 07 42 65)", got)
+	}
+
+	fake.Writes = nil
+	fake.QueueRead(0x83, 0x90)
+	r, err := b.Read()
+	if err != nil || r.Count != 0x8390 {
+		t.Fatalf("Read() got (%+v, %v) want count 0x8390", r, err)
+	}
+	if got := instructions(fake); got != "20" {
+		t.Errorf("one-shot Read() wrote (%s) want (20)", got)
+	}
+
+	fake.Writes = nil
+	if err := b.SetMTreg(MTregMax); err != nil {
+		t.Fatalf("SetMTreg() error = %v", err)
+	}
+	if err := b.SetResolution(Low); err != nil {
+		t.Fatalf("SetResolution() error = %v", err)
+	}
+	if err := b.SetMode(Continuous); err != nil {
+		t.Fatalf("SetMode() error = %v", err)
+	}
+	fake.QueueRead(0x00, 0x10)
+	if _, err := b.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got := instructions(fake); got != "47 7e 13" {
+		t.Errorf("wrote (%s) want (47 7e 13)", got)
+	}
+
+	if err := b.SetMTreg(MTregMin - 1); !errors.Is(err, ErrMTreg) {
+		t.Errorf("SetMTreg(30) error got (%v) want (%v)", err, ErrMTreg)
+	}
+	if err := b.SetResolution(0x02); !errors.Is(err, ErrResolution) {
+		t.Errorf("SetResolution(2) error got (%v) want (%v)", err, ErrResolution)
+	}
+
+	b.Close()
+	if !fake.Closed() {
+		t.Error("Close() did not close the i2c device")
+	}
+}
@@ -0,0 +1,29 @@
+package bh1750
+
+import (
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a BH1750, the light where it is. An "office"
+// is lit 300 to 500 lx. "outdoor" is daylight up to 50000 lx at noon
+// when applied at midnight, dark at night, its bus failing to ack
+// every 50th read.
+func init() {
+	device.RegisterMockProfile("bh1750", "office", func(b *BH1750) error {
+		b.SetGenerator(device.Walk(400, 1, 300, 500, b.MockRand()))
+		b.InjectFaults()
+		return nil
+	})
+	device.RegisterMockProfile("bh1750", "outdoor", func(b *BH1750) error {
+		sun := device.Shift(device.Sine(50000, 24*time.Hour, 0), 6*time.Hour)
+		noise := device.Noise(50, b.MockRand())
+		b.SetGenerator(device.GeneratorFunc(func(t time.Duration) float64 {
+			return max(sun.At(t)+noise.At(t), 0)
+		}))
+		b.InjectFaults(device.FailEvery(50), device.FailWith(&drivers.ErrNak{Err: device.ErrInjected}))
+		return nil
+	})
+}
@@ -3,14 +3,16 @@
 package bme280
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/maciej/bme280"
-	"github.com/rustyeddy/otto/device"
-	"github.com/rustyeddy/otto/device/drivers"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 // BME280 represents an I2C temperature, humidity and pressure sensor.
@@ -18,52 +20,152 @@ import (
 type BME280 struct {
 	*device.Device
 
-	bus    string
-	addr   int
-	driver *bme280.Driver
+	bus  string
+	addr int
+	dev  *drivers.I2CDevice
+	cal  calibration
+	cfg  BME280Config
+
+	high       float64
+	hysteresis float64
+	hot        bool // above the high threshold
+
+	live [3]func() float64 // the Generators started, by channel
+
+	mu sync.Mutex
 }
 
+// Generators make the mock Responses of a BME280 by channel, the
+// temperature in C, the channels without one random
+type Generators struct {
+	Temperature device.Generator
+	Humidity    device.Generator
+	Pressure    device.Generator
+}
+
+// Env is the reading the BME280 publishes, the values formatted with
+// two decimals. The humidity and the pressure are left out when the
+// configuration skips their measurement. Mocked flags a reading of
+// values injected by the mock command.
 type Env struct {
 	Temperature string `json:"temperature"`
-	Humidity    string `json:"humidity"`
-	Pressure    string `json:"pressure"`
+	Humidity    string `json:"humidity,omitempty"`
+	Pressure    string `json:"pressure,omitempty"`
+	Mocked      bool   `json:"mocked,omitempty"`
+}
+
+// AlertEvent is published when the temperature rises above the high
+// alert threshold, "hot", and when it is back below the threshold
+// minus the hysteresis, "normal". The temperature is in F.
+type AlertEvent struct {
+	Event       string  `json:"event"`
+	Temperature float64 `json:"temperature"`
+	Mocked      bool    `json:"mocked,omitempty"`
 }
 
+// Mode is the power mode of the sensor
+type Mode uint8
+
+const (
+	ModeSleep  Mode = 0
+	ModeForced Mode = 1
+	ModeNormal Mode = 3
+)
+
+// Filter is the IIR filter coefficient
+type Filter uint8
+
+const (
+	FilterOff Filter = iota
+	Filter2
+	Filter4
+	Filter8
+	Filter16
+)
+
+// StandByTime is the inactive period between measurements in normal mode
+type StandByTime uint8
+
+const (
+	StandByTime0_5ms StandByTime = iota
+	StandByTime62_5ms
+	StandByTime125ms
+	StandByTime250ms
+	StandByTime500ms
+	StandByTime1000ms
+	StandByTime10ms
+	StandByTime20ms
+)
+
+// Oversampling is the number of samples averaged per measurement
+type Oversampling uint8
+
+const (
+	OversamplingOff Oversampling = iota
+	Oversampling1x
+	Oversampling2x
+	Oversampling4x
+	Oversampling8x
+	Oversampling16x
+)
+
 // BME280Config holds the configuration for the BME280 sensor
 type BME280Config struct {
-	Mode       bme280.Mode
-	Filter     bme280.Filter
-	Standby    bme280.StandByTime
+	Mode       Mode
+	Filter     Filter
+	Standby    StandByTime
 	Oversample struct {
-		Pressure    bme280.Oversampling
-		Temperature bme280.Oversampling
-		Humidity    bme280.Oversampling
+		Pressure    Oversampling
+		Temperature Oversampling
+		Humidity    Oversampling
 	}
 }
 
 // DefaultConfig returns the default configuration
 func DefaultConfig() BME280Config {
 	return BME280Config{
-		Mode:    bme280.ModeForced,
-		Filter:  bme280.FilterOff,
-		Standby: bme280.StandByTime1000ms,
+		Mode:    ModeForced,
+		Filter:  FilterOff,
+		Standby: StandByTime1000ms,
 		Oversample: struct {
-			Pressure    bme280.Oversampling
-			Temperature bme280.Oversampling
-			Humidity    bme280.Oversampling
+			Pressure    Oversampling
+			Temperature Oversampling
+			Humidity    Oversampling
 		}{
-			Pressure:    bme280.Oversampling16x,
-			Temperature: bme280.Oversampling16x,
-			Humidity:    bme280.Oversampling16x,
+			Pressure:    Oversampling16x,
+			Temperature: Oversampling16x,
+			Humidity:    Oversampling16x,
 		},
 	}
 }
 
 // Response returns values read from the sensor containing all three
-// values for temperature, humidity and pressure
-type Response bme280.Response
+// values for temperature (C), humidity (%RH) and pressure (hPa)
+type Response struct {
+	Temperature float64
+	Humidity    float64
+	Pressure    float64
+}
+
+// registers, see section 5.3 of the datasheet
+const (
+	regCalib00  = 0x88
+	regChipID   = 0xD0
+	regReset    = 0xE0
+	regCalib26  = 0xE1
+	regCtrlHum  = 0xF2
+	regStatus   = 0xF3
+	regCtrlMeas = 0xF4
+	regConfig   = 0xF5
+	regData     = 0xF7
+
+	chipID        = 0x60
+	resetCmd      = 0xB6
+	statusMeasure = 0x08
+)
 
 var (
+	ErrNotBME280     = errors.New("device is not a BME280")
 	ErrInitFailed    = errors.New("failed to initialize BME280")
 	ErrReadFailed    = errors.New("failed to read from BME280")
 	ErrMarshalFailed = errors.New("failed to marshal BME280 data")
@@ -72,6 +174,9 @@ var (
 const (
 	DefaultI2CBus     = "/dev/i2c-1"
 	DefaultI2CAddress = 0x77
+
+	readAttempts = 3
+	retryDelay   = 10 * time.Millisecond
 )
 
 // Create a new BME280 at the give bus and address. Defaults are
@@ -81,7 +186,10 @@ func New(name, bus string, addr int) *BME280 {
 		Device: device.NewDevice(name, "mqtt"),
 		bus:    bus,
 		addr:   addr,
+		cfg:    DefaultConfig(),
 	}
+	b.SetMockFields("temperature", "humidity", "pressure")
+	b.SetMockKind("bme280", b)
 	return b
 }
 
@@ -92,60 +200,268 @@ func (b *BME280) Init() error {
 		return nil
 	}
 
-	b.PubData([]byte(`{"status":"initializing"}`))
+	b.PubState([]byte(`{"status":"initializing"}`))
+	return b.InitWith(DefaultConfig())
+}
 
-	i2c, err := drivers.GetI2CDriver(b.bus, b.addr)
+// InitWith opens the i2c bus, verifies the chip id, reads the
+// calibration data and writes the given configuration. The Schemas
+// of the readings it takes are published again.
+func (b *BME280) InitWith(cfg BME280Config) error {
+	dev, err := drivers.NewI2CDevice(b.bus, b.addr)
 	if err != nil {
 		return err
 	}
 
-	b.driver = bme280.New(i2c)
-	err = b.driver.InitWith(bme280.ModeForced, bme280.Settings{
-		Filter:                  bme280.FilterOff,
-		Standby:                 bme280.StandByTime1000ms,
-		PressureOversampling:    bme280.Oversampling16x,
-		TemperatureOversampling: bme280.Oversampling16x,
-		HumidityOversampling:    bme280.Oversampling16x,
+	id, err := dev.ReadReg8(regChipID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInitFailed, err)
+	}
+	if id != chipID {
+		return fmt.Errorf("%w: chip id %#02x", ErrNotBME280, id)
+	}
+
+	b.cal, err = readCalibration(dev)
+	if err != nil {
+		return fmt.Errorf("%w: calibration: %w", ErrInitFailed, err)
+	}
+
+	// ctrl_hum only takes effect after ctrl_meas is written
+	err = dev.Tx(func(bus drivers.I2CBus) error {
+		for _, w := range []struct {
+			reg byte
+			val byte
+		}{
+			{regCtrlMeas, byte(ModeSleep)},
+			{regConfig, byte(cfg.Standby)<<5 | byte(cfg.Filter)<<2},
+			{regCtrlHum, byte(cfg.Oversample.Humidity)},
+			{regCtrlMeas, ctrlMeas(cfg)},
+		} {
+			if err := bus.WriteReg(w.reg, []byte{w.val}); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrInitFailed, err)
 	}
+
+	b.dev = dev
+	b.cfg = cfg
+	b.PubSchemas(b.Schemas())
 	return nil
 }
 
-// Read one Response from the sensor. If this device is being mocked
-// we will make up some random floating point numbers between 0 and
-// 100.
-func (b *BME280) Read() (*bme280.Response, error) {
+func ctrlMeas(cfg BME280Config) byte {
+	return byte(cfg.Oversample.Temperature)<<5 | byte(cfg.Oversample.Pressure)<<2 | byte(cfg.Mode)
+}
+
+// Name returns the name of the device
+func (b *BME280) Name() string {
+	return b.Device.Name
+}
+
+// Read one Response from the sensor, recorded when the device is
+// recording. A device with a mock sequence or a Replay reads its
+// Responses, else if this device is being mocked we will make up
+// some random floating point numbers between 0 and 100, or take them
+// of its Generators, with the values the mock command injected. The
+// faults injected fail the reads within their retries, like the bus.
+func (b *BME280) Read() (*Response, error) {
+	return b.ReadContext(context.Background())
+}
+
+// ReadContext is Read, the mock reads delayed by the latency set
+// abandoned when ctx is done
+func (b *BME280) ReadContext(ctx context.Context) (*Response, error) {
+	r, err := b.read(ctx)
+	if err == nil {
+		b.RecordRead(r)
+	}
+	return r, err
+}
+
+// Temperature reads the temperature in °C, the BME280 is a
+// device.Thermometer
+func (b *BME280) Temperature() (float64, error) {
+	r, err := b.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// Humidity reads the relative humidity in %, the BME280 is a
+// device.Hygrometer
+func (b *BME280) Humidity() (float64, error) {
+	r, err := b.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Humidity, nil
+}
+
+func (b *BME280) read(ctx context.Context) (*Response, error) {
+	if v, ok, err := b.NextMock(); ok {
+		if err == nil {
+			err = b.MockWait(ctx)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		r, err := device.MockValue[Response](v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		return b.injected(r), nil
+	}
 	if device.IsMock() {
-		return &bme280.Response{
-			Temperature: rand.Float64() * 100,
-			Pressure:    rand.Float64() * 100,
-			Humidity:    rand.Float64() * 100,
-		}, nil
+		if err := b.MockWait(ctx); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		err := drivers.RetryI2C(readAttempts, retryDelay, b.ReadFault)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		return b.injected(b.mockResponse()), nil
 	}
 
-	response, err := b.driver.Read()
+	if b.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	// a NAK or a lost arbitration on a shared bus is worth another
+	// try before giving up on the reading
+	var buf []byte
+	err := drivers.RetryI2C(readAttempts, retryDelay, func() (err error) {
+		if err := b.ReadFault(); err != nil {
+			return err
+		}
+		if b.cfg.Mode == ModeForced {
+			if err := b.measure(); err != nil {
+				return err
+			}
+		}
+
+		// burst read so all three values come from the same measurement
+		buf, err = b.dev.ReadBlock(regData, 8)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	adcP := int32(buf[0])<<12 | int32(buf[1])<<4 | int32(buf[2])>>4
+	adcT := int32(buf[3])<<12 | int32(buf[4])<<4 | int32(buf[5])>>4
+	adcH := int32(buf[6])<<8 | int32(buf[7])
+
+	response := b.cal.compensate(adcT, adcP, adcH)
+	return &response, nil
+}
+
+// SetGenerators has the mock reads take their values of gens, started
+// now by the clock of the device
+func (b *BME280) SetGenerators(gens Generators) {
+	var live [3]func() float64
+	for i, g := range []device.Generator{gens.Temperature, gens.Humidity, gens.Pressure} {
+		if g != nil {
+			live[i] = device.LiveOn(g, b.Now)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.live = live
+}
+
+// mockRanges are the ranges of the random mock values of the channels
+// without a Generator, the ones of a room like the "indoor" profile
+var mockRanges = [3]struct{ min, max float64 }{
+	{19.5, 22.5}, // C
+	{40, 50},     // %
+	{990, 1035},  // hPa
+}
+
+// mockResponse makes a Response of the Generators, random values in
+// the mockRanges for the channels without one
+func (b *BME280) mockResponse() *Response {
+	b.mu.Lock()
+	live := b.live
+	b.mu.Unlock()
+	var vals [3]float64
+	for i, value := range live {
+		if value == nil {
+			r := mockRanges[i]
+			vals[i] = r.min + b.MockRand().Float64()*(r.max-r.min)
+		} else {
+			vals[i] = value()
+		}
+	}
+	return &Response{Temperature: vals[0], Humidity: vals[1], Pressure: vals[2]}
+}
+
+// injected returns r with the values injected by the mock command, in
+// Celsius like r
+func (b *BME280) injected(r *Response) *Response {
+	r.Temperature = b.MockField("temperature", r.Temperature)
+	r.Humidity = b.MockField("humidity", r.Humidity)
+	r.Pressure = b.MockField("pressure", r.Pressure)
+	return r
+}
+
+// measure triggers a forced mode measurement and waits for it
+func (b *BME280) measure() error {
+	if err := b.dev.UpdateBits(regCtrlMeas, 0x03, byte(ModeForced)); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for {
+		status, err := b.dev.ReadReg8(regStatus)
+		if err != nil {
+			return err
+		}
+		if status&statusMeasure == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("measurement timed out")
+		}
+		time.Sleep(2 * time.Millisecond)
 	}
-	return &response, err
+}
+
+// SetHighAlert publishes an AlertEvent when the temperature rises
+// above fahrenheit, and again when it drops below fahrenheit -
+// hysteresis so a reading hovering around the threshold does not
+// flap. 0 turns the alert off. The Schemas are published again.
+func (b *BME280) SetHighAlert(fahrenheit, hysteresis float64) {
+	b.mu.Lock()
+	b.high, b.hysteresis = fahrenheit, hysteresis
+	b.hot = false
+	b.mu.Unlock()
+	b.PubSchemas(b.Schemas())
 }
 
 // ReadPub reads the latest values from the sendsor then publishes
-// them on the MQTT topic assigned to this device.
+// them on the DataTopic of this device, and the alert if the reading
+// crossed the high threshold.
 func (b *BME280) ReadPub() error {
 	vals, err := b.Read()
 	if err != nil {
 		return fmt.Errorf("reading BME280: %w", err)
 	}
 
-	vals.Temperature = (vals.Temperature * (9 / 5)) + 32
+	vals.Temperature = ConvertCtoF(vals.Temperature)
 
+	mocked := device.IsMock() && b.MockInjected()
 	valstr := &Env{
-		Temperature: fmt.Sprintf("%.2f", vals.Temperature),
-		Humidity:    fmt.Sprintf("%.2f", vals.Humidity),
-		Pressure:    fmt.Sprintf("%.2f", vals.Pressure),
+		Temperature: strconv.FormatFloat(vals.Temperature, 'f', 2, 64),
+		Mocked:      mocked,
+	}
+	if b.cfg.Oversample.Humidity != OversamplingOff {
+		valstr.Humidity = strconv.FormatFloat(vals.Humidity, 'f', 2, 64)
+	}
+	if b.cfg.Oversample.Pressure != OversamplingOff {
+		valstr.Pressure = strconv.FormatFloat(vals.Pressure, 'f', 2, 64)
 	}
 
 	jb, err := json.Marshal(valstr)
@@ -153,9 +469,90 @@ func (b *BME280) ReadPub() error {
 		return errors.New("BME280 failed marshal read response" + err.Error())
 	}
 	b.PubData(jb)
+
+	if evt := b.check(vals.Temperature); evt != nil {
+		evt.Mocked = mocked
+		jb, err := json.Marshal(evt)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMarshalFailed, err)
+		}
+		b.PubData(jb)
+	}
+	return nil
+}
+
+// check returns the AlertEvent for a reading of fahrenheit, nil if
+// the alert state did not change
+func (b *BME280) check(fahrenheit float64) *AlertEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.high == 0:
+		return nil
+	case !b.hot && fahrenheit > b.high:
+		b.hot = true
+		return &AlertEvent{Event: "hot", Temperature: fahrenheit}
+	case b.hot && fahrenheit <= b.high-b.hysteresis:
+		b.hot = false
+		return &AlertEvent{Event: "normal", Temperature: fahrenheit}
+	}
 	return nil
 }
 
+// Entities are the temperature, humidity and pressure sensors of the
+// BME280 in Home Assistant, read from its data
+func (b *BME280) Entities() []device.Entity {
+	sensor := func(id, unit string) device.Entity {
+		return device.Entity{
+			Component:   "sensor",
+			ID:          id,
+			DeviceClass: id,
+			StateClass:  "measurement",
+			Unit:        unit,
+			StateTopic:  b.DataTopic(),
+			Value:       id,
+		}
+	}
+	return []device.Entity{
+		sensor("temperature", "°F"),
+		sensor("humidity", "%"),
+		sensor("pressure", "hPa"),
+	}
+}
+
+// Schemas describe the Env the BME280 publishes with its configuration,
+// the values measured, or the AlertEvent with the high alert on
+func (b *BME280) Schemas() device.Schemas {
+	value := func(name, unit string) *device.Schema {
+		return &device.Schema{Type: "string", Description: name, Pattern: `^-?[0-9]+\.[0-9]{2}$`, Unit: unit}
+	}
+	props := map[string]*device.Schema{"temperature": value("temperature", "°F")}
+	if b.cfg.Oversample.Humidity != OversamplingOff {
+		props["humidity"] = value("humidity", "%")
+	}
+	if b.cfg.Oversample.Pressure != OversamplingOff {
+		props["pressure"] = value("pressure", "hPa")
+	}
+	env := device.Object(props)
+	env.Properties["mocked"] = mockedSchema
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.high == 0 {
+		return device.Schemas{Data: env}
+	}
+	alert := device.Object(map[string]*device.Schema{
+		"event":       {Type: "string", Enum: []any{"hot", "normal"}},
+		"temperature": device.Number("temperature", "°F"),
+	})
+	alert.Properties["mocked"] = mockedSchema
+	return device.Schemas{Data: &device.Schema{OneOf: []*device.Schema{env, alert}}}
+}
+
+// mockedSchema is the schema of the flag of the payloads of values
+// injected, not required as a real reading has none
+var mockedSchema = &device.Schema{Type: "boolean", Description: "values injected by the mock command"}
+
 // ConvertCtoF converts Celsius to Fahrenheit
 func ConvertCtoF(celsius float64) float64 {
 	return (celsius * 9.0 / 5.0) + 32.0
@@ -0,0 +1,56 @@
+//go:build hardware
+
+package bme280
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+)
+
+// TestHardwareBME280 initializes the BME280 on OTTO_BME280_BUS at
+// OTTO_BME280_ADDR and reads it, its values within the ranges of the
+// sensor, in normal mode too
+func TestHardwareBME280(t *testing.T) {
+	bus := devicetest.HardwareEnv(t, "OTTO_BME280_BUS", "the i2c bus of the BME280 like /dev/i2c-1")
+	addr := devicetest.HardwareInt(t, "OTTO_BME280_ADDR", "the address of the BME280, 0x76 or 0x77")
+	defer device.Mock(device.IsMock())
+	device.Mock(false)
+
+	normal := DefaultConfig()
+	normal.Mode = ModeNormal
+	for _, tt := range []struct {
+		name   string
+		cfg    BME280Config
+		settle time.Duration // for the first measurement
+	}{
+		{"forced", DefaultConfig(), 0},
+		{"normal", normal, 100 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("hw-bme280", bus, addr)
+			if err := b.InitWith(tt.cfg); err != nil {
+				t.Fatalf("InitWith() on %s at %#x error = %v", bus, addr, err)
+			}
+			time.Sleep(tt.settle)
+			for range 3 {
+				r, err := b.Read()
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+				t.Logf("%.2fC %.2f%% %.2fhPa", r.Temperature, r.Humidity, r.Pressure)
+				if r.Temperature < -40 || r.Temperature > 85 {
+					t.Errorf("Temperature %.2f outside [-40, 85]", r.Temperature)
+				}
+				if r.Humidity < 0 || r.Humidity > 100 {
+					t.Errorf("Humidity %.2f outside [0, 100]", r.Humidity)
+				}
+				if r.Pressure < 300 || r.Pressure > 1100 {
+					t.Errorf("Pressure %.2f outside [300, 1100]", r.Pressure)
+				}
+			}
+		})
+	}
+}
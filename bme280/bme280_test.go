@@ -1,10 +1,24 @@
 package bme280
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/rustyeddy/otto/device"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
 )
 
 // Test constants
@@ -47,8 +61,8 @@ func TestBME280Creation(t *testing.T) {
 				t.Fatal("Failed to create BME280 device")
 			}
 
-			if bme.Name != tt.devName {
-				t.Errorf("Name() = %v, want %v", bme.Name, tt.devName)
+			if bme.Name() != tt.devName {
+				t.Errorf("Name() = %v, want %v", bme.Name(), tt.devName)
 			}
 
 			err := bme.Open()
@@ -128,11 +142,22 @@ func TestBME280ReadPub(t *testing.T) {
 		t.Fatalf("Open() failed: %v", err)
 	}
 
-	// Test ReadPub method
-	err := bme.ReadPub()
-	if err != nil {
+	c := devicetest.Use(t)
+	if err := bme.ReadPub(); err != nil {
 		t.Errorf("ReadPub() error = %v", err)
 	}
+
+	// the values as strings of two decimals, the temperature in F
+	msg := c.ExpectPublish(bme.DataTopic(), devicetest.Retained(false), devicetest.Timeout)
+	var data map[string]string
+	if _, err := device.Decode(msg.Topic, msg.Data, &data); err != nil {
+		t.Fatalf("Decode(%s) error = %v", msg, err)
+	}
+	for _, k := range []string{"temperature", "humidity", "pressure"} {
+		if v := data[k]; len(v) < 4 || v[len(v)-3] != '.' {
+			t.Errorf("%s got (%s) want 2 decimals", k, v)
+		}
+	}
 }
 
 func TestBME280JSON(t *testing.T) {
@@ -172,7 +197,785 @@ func TestBME280String(t *testing.T) {
 		t.Error("String() returned empty string")
 	}
 
-	if str != bme.Name {
-		t.Errorf("String() = %v, want %v", str, bme.Name)
+	// the String of a device is its name and its state
+	if want := bme.Name() + " (" + string(bme.State) + ") "; str != want {
+		t.Errorf("String() = %q, want %q", str, want)
+	}
+}
+
+func TestBME280InitUsesRegistry(t *testing.T) {
+	errNoBus := errors.New("no bus")
+
+	var gotBus string
+	var gotAddr int
+	driverstest.UseI2CProvider(t, func(bus string, addr int) (drivers.I2CBus, error) {
+		gotBus, gotAddr = bus, addr
+		return nil, errNoBus
+	})
+
+	bme := New("bme-test", "/dev/i2c-3", 0x76)
+	if err := bme.Init(); !errors.Is(err, errNoBus) {
+		t.Errorf("Init() error = %v want %v", err, errNoBus)
+	}
+	if gotBus != "/dev/i2c-3" || gotAddr != 0x76 {
+		t.Errorf("provider got (%s, %#x) want (/dev/i2c-3, 0x76)", gotBus, gotAddr)
+	}
+}
+
+// datasheetCal is the example calibration from section 8.1 of the
+// BME280 datasheet
+var datasheetCal = calibration{
+	T1: 27504, T2: 26435, T3: -1000,
+	P1: 36477, P2: -10685, P3: 3024, P4: 2855, P5: 140,
+	P6: -7, P7: 15500, P8: -14600, P9: 6000,
+}
+
+func TestBME280Compensate(t *testing.T) {
+	r := datasheetCal.compensate(519888, 415148, 0)
+
+	if math.Abs(r.Temperature-25.08) > 0.01 {
+		t.Errorf("Temperature got (%f) want (25.08)", r.Temperature)
+	}
+	if math.Abs(r.Pressure-1006.53) > 0.05 {
+		t.Errorf("Pressure got (%f) want (1006.53)", r.Pressure)
+	}
+	if r.Humidity < 0 || r.Humidity > 100 {
+		t.Errorf("Humidity got (%f) want [0, 100]", r.Humidity)
+	}
+}
+
+func TestBME280CalibrationDecode(t *testing.T) {
+	tp := make([]byte, 26)
+	binary.LittleEndian.PutUint16(tp[0:], 27504)
+	binary.LittleEndian.PutUint16(tp[2:], uint16(26435))
+	tp[25] = 75
+	h := []byte{0x6A, 0x01, 0x00, 0x13, 0x2F, 0x03, 0x1E}
+
+	var c calibration
+	c.decode(tp, h)
+
+	if c.T1 != 27504 || c.T2 != 26435 || c.H1 != 75 {
+		t.Errorf("T1, T2, H1 got (%d, %d, %d) want (27504, 26435, 75)", c.T1, c.T2, c.H1)
+	}
+	if c.H2 != 362 || c.H4 != 0x13F || c.H5 != 0x032 || c.H6 != 30 {
+		t.Errorf("H2, H4, H5, H6 got (%d, %#x, %#x, %d)", c.H2, c.H4, c.H5, c.H6)
+	}
+}
+
+// datasheetFake returns an I2C fake holding the datasheet calibration
+// and a measurement of 25.08C and 1006.53hPa
+func datasheetFake() *driverstest.I2C {
+	fake := driverstest.NewI2C()
+	fake.Set(regChipID, chipID)
+
+	// the datasheet calibration laid out as it is on the chip
+	tp := make([]byte, 26)
+	for i, v := range []uint16{
+		datasheetCal.T1, uint16(datasheetCal.T2), uint16(datasheetCal.T3),
+		datasheetCal.P1, uint16(datasheetCal.P2), uint16(datasheetCal.P3),
+		uint16(datasheetCal.P4), uint16(datasheetCal.P5), uint16(datasheetCal.P6),
+		uint16(datasheetCal.P7), uint16(datasheetCal.P8), uint16(datasheetCal.P9),
+	} {
+		binary.LittleEndian.PutUint16(tp[i*2:], v)
+	}
+	fake.Set(regCalib00, tp...)
+
+	// adc_P 415148, adc_T 519888, adc_H 0
+	fake.Set(regData, 0x65, 0x5A, 0xC0, 0x7E, 0xED, 0x00, 0x00, 0x00)
+	return fake
+}
+
+func TestBME280InitRead(t *testing.T) {
+	fake := datasheetFake()
+	driverstest.UseI2C(t, fake)
+
+	bme := New("bme-test", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+
+	resp, err := bme.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(resp.Temperature-25.08) > 0.01 {
+		t.Errorf("Temperature got (%f) want (25.08)", resp.Temperature)
+	}
+	if math.Abs(resp.Pressure-1006.53) > 0.05 {
+		t.Errorf("Pressure got (%f) want (1006.53)", resp.Pressure)
+	}
+}
+
+// TestBME280InitTranscript checks the bus operations of InitWith in
+// order, ctrl_hum written before ctrl_meas it only takes effect after.
+// -update records them off the datasheet fake again.
+func TestBME280InitTranscript(t *testing.T) {
+	golden := filepath.Join("testdata", "init.i2c")
+	if *update {
+		rec := driverstest.Record(datasheetFake())
+		driverstest.UseI2C(t, rec)
+		if err := New("bme-test", TestI2CBus, TestI2CAddress).InitWith(DefaultConfig()); err != nil {
+			t.Fatalf("InitWith() error = %v", err)
+		}
+		got := "# InitWith(DefaultConfig()) with the datasheet calibration\n" + rec.Transcript().String()
+		os.WriteFile(golden, []byte(got), 0o644)
+	}
+	want, err := driverstest.LoadTranscript(golden)
+	if err != nil {
+		t.Fatalf("LoadTranscript() error = %v", err)
+	}
+	driverstest.UseScript(t, want)
+
+	bme := New("bme-test", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+	if bme.cal != datasheetCal {
+		t.Errorf("calibration got (%+v) want (%+v)", bme.cal, datasheetCal)
+	}
+}
+
+// TestBME280ForcedMeasurement simulates the chip measuring for two
+// reads of the status after ctrl_meas starts it, then back to sleep
+func TestBME280ForcedMeasurement(t *testing.T) {
+	fake := datasheetFake()
+	var started, polls int
+	fake.OnWrite(regCtrlMeas, func(regs *[256]byte, v byte) {
+		if Mode(v&0x03) == ModeForced {
+			started++
+			polls = 0
+			regs[regStatus] |= statusMeasure
+		}
+	})
+	fake.OnRead(regStatus, func(regs *[256]byte) {
+		if polls++; polls == 2 {
+			regs[regStatus] &^= statusMeasure
+			regs[regCtrlMeas] &^= 0x03
+		}
+	})
+	driverstest.UseI2C(t, fake)
+
+	bme := New("bme-test", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+	for i := range 2 {
+		if _, err := bme.Read(); err != nil {
+			t.Fatalf("Read() %d error = %v", i, err)
+		}
+		if got := fake.Get(regStatus, 1)[0]; got&statusMeasure != 0 {
+			t.Errorf("Read() %d returned measuring", i)
+		}
+	}
+	// the one InitWith started and the one of the second Read
+	if started != 2 {
+		t.Errorf("measurements got (%d) want (2)", started)
+	}
+}
+
+func TestBME280NotBME280(t *testing.T) {
+	fake := driverstest.NewI2C()
+	fake.Set(regChipID, 0x58)
+	driverstest.UseI2C(t, fake)
+
+	bme := New("bme-test", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); !errors.Is(err, ErrNotBME280) {
+		t.Errorf("InitWith() error = %v want %v", err, ErrNotBME280)
+	}
+}
+
+func TestBME280ReadRetry(t *testing.T) {
+	fake := datasheetFake()
+	driverstest.UseI2C(t, fake)
+
+	bme := New("bme-test", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+
+	// a NAK and a lost arbitration are retried
+	fake.FailNext(driverstest.ErrnoNak, driverstest.ErrnoBusBusy)
+	if _, err := bme.Read(); err != nil {
+		t.Errorf("Read() after transient errors error = %v", err)
+	}
+
+	// a permission problem is not
+	fake.FailNext(driverstest.ErrnoPermission)
+	_, err := bme.Read()
+	if !errors.Is(err, drivers.ErrPermission) || !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() error = %v want %v", err, drivers.ErrPermission)
+	}
+
+	// a device that keeps NAKing gives up after readAttempts
+	fake.FailNext(driverstest.ErrnoNak, driverstest.ErrnoNak, driverstest.ErrnoNak)
+	_, err = bme.Read()
+	var nak *drivers.ErrNak
+	if !errors.As(err, &nak) || nak.Addr != TestI2CAddress {
+		t.Errorf("Read() error = %v want a NAK from %#02x", err, TestI2CAddress)
+	}
+}
+
+// ts replaces the timestamp of an Envelope, checked to be RFC3339Nano,
+// so the payload can be compared to a golden one
+func ts(t *testing.T, payload string) string {
+	t.Helper()
+	var env device.Envelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		t.Fatalf("Unmarshal(%s) error = %v", payload, err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, env.TS); err != nil {
+		t.Errorf("ts got (%s) error = %v", env.TS, err)
+	}
+	return strings.Replace(payload, env.TS, "TS", 1)
+}
+
+func TestBME280Envelope(t *testing.T) {
+	driverstest.UseI2C(t, datasheetFake())
+	c := devicetest.Use(t)
+
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	if err := bme.InitWith(DefaultConfig()); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+	bme.ReadPub()
+	bme.ReadPub()
+
+	want := []string{
+		`{"schema":1,"ts":"TS","device":"bme280","seq":1,"data":{"temperature":"77.15","humidity":"0.00","pressure":"1006.53"}}`,
+		`{"schema":1,"ts":"TS","device":"bme280","seq":2,"data":{"temperature":"77.15","humidity":"0.00","pressure":"1006.53"}}`,
+	}
+	for i := range want {
+		msg := c.ExpectPublish(bme.DataTopic(), devicetest.Any(), devicetest.Timeout)
+		if got := ts(t, msg.String()); got != want[i] {
+			t.Errorf("reading %d got (%s) want (%s)", i, got, want[i])
+		}
+	}
+	c.ExpectNoPublish(bme.DataTopic(), devicetest.Any(), 0)
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestBME280Discovery(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
+
+	bme := New("bme280", "/dev/i2c-fake", 0x76)
+	if err := bme.PubDiscovery(bme.Entities()); err != nil {
+		t.Fatalf("PubDiscovery() error = %v", err)
+	}
+	configs := make(map[string]json.RawMessage)
+	for _, msg := range c.Messages("homeassistant/#") {
+		configs[msg.Topic] = msg.Data
+	}
+	got, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent() error = %v", err)
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "discovery.json")
+	if *update {
+		os.MkdirAll("testdata", 0o755)
+		os.WriteFile(golden, got, 0o644)
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("discovery got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBME280Schemas(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(false)
+	driverstest.UseI2C(t, datasheetFake())
+	c := devicetest.Use(t)
+
+	noHumidity := DefaultConfig()
+	noHumidity.Oversample.Humidity = OversamplingOff
+	temperature := noHumidity
+	temperature.Oversample.Pressure = OversamplingOff
+
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	for _, tt := range []struct {
+		name string
+		cfg  BME280Config
+		want string // the reading published
+	}{
+		{"default", DefaultConfig(), `{"temperature":"77.15","humidity":"0.00","pressure":"1006.53"}`},
+		{"no_humidity", noHumidity, `{"temperature":"77.15","pressure":"1006.53"}`},
+		{"temperature", temperature, `{"temperature":"77.15"}`},
+	} {
+		// the schema is published again with the configuration
+		if err := bme.InitWith(tt.cfg); err != nil {
+			t.Fatalf("%s InitWith() error = %v", tt.name, err)
+		}
+		msg := c.ExpectPublish(device.SchemaTopic(bme.DataTopic()), devicetest.Retained(true), devicetest.Timeout)
+		var got bytes.Buffer
+		json.Indent(&got, msg.Data, "", "  ")
+		got.WriteByte('\n')
+
+		golden := filepath.Join("testdata", "schema_"+tt.name+".json")
+		if *update {
+			os.WriteFile(golden, got.Bytes(), 0o644)
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s schema got\n%s\nwant\n%s", tt.name, got.String(), want)
+		}
+
+		bme.ReadPub()
+		c.ExpectPublish(bme.DataTopic(), devicetest.Data(tt.want), devicetest.Timeout)
+	}
+}
+
+func TestBME280MockSequence(t *testing.T) {
+	c := devicetest.Use(t)
+
+	// a ramp in C through the alert at 85F, back below it with 2F of
+	// hysteresis
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	bme.SetHighAlert(85, 2)
+	errDone := errors.New("ramp done")
+	var ramp []any
+	for _, celsius := range []float64{27, 29, 30, 31, 29, 28} {
+		ramp = append(ramp, Response{Temperature: celsius, Humidity: 40, Pressure: 1013.25})
+	}
+	bme.SetMockSequence(ramp, device.MockOnce, device.MockErr(errDone))
+
+	for range ramp {
+		if err := bme.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() error = %v", err)
+		}
+	}
+	if err := bme.ReadPub(); !errors.Is(err, errDone) || !errors.Is(err, ErrReadFailed) {
+		t.Errorf("ReadPub() past the ramp error got (%v) want (%v)", err, errDone)
+	}
+
+	reading := func(f string) string {
+		return `{"temperature":"` + f + `","humidity":"40.00","pressure":"1013.25"}`
+	}
+	want := []string{
+		reading("80.60"),
+		reading("84.20"),
+		reading("86.00"),
+		`{"event":"hot","temperature":86}`,
+		reading("87.80"),
+		reading("84.20"),
+		reading("82.40"),
+		`{"event":"normal","temperature":82.4}`,
+	}
+	var got []string
+	for _, msg := range c.Messages(bme.DataTopic()) {
+		var data json.RawMessage
+		if _, err := device.Decode(msg.Topic, msg.Data, &data); err != nil {
+			t.Fatalf("Decode(%s) error = %v", msg, err)
+		}
+		got = append(got, string(data))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("published got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the schema takes the alert
+	msg, ok := c.Retained(device.SchemaTopic(bme.DataTopic()))
+	if !ok || !strings.Contains(msg.String(), `"oneOf"`) {
+		t.Errorf("schema with the alert got (%s)", msg)
+	}
+}
+
+func TestBME280Thermometer(t *testing.T) {
+	devicetest.Use(t)
+
+	bme := New("porch", TestI2CBus, TestI2CAddress)
+	bme.SetMockSequence([]any{Response{Temperature: 21.5, Humidity: 45, Pressure: 1013.25}}, device.MockLoop)
+	dm := device.GetDeviceManager()
+	dm.Add(bme)
+	defer dm.Remove("porch")
+
+	th, err := device.GetThermometer("porch")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	if c, err := th.Temperature(); err != nil || c != 21.5 {
+		t.Errorf("Temperature() got (%v, %v) want (21.5)", c, err)
+	}
+	h, err := device.GetHygrometer("porch")
+	if err != nil {
+		t.Fatalf("GetHygrometer() error = %v", err)
+	}
+	if rh, err := h.Humidity(); err != nil || rh != 45 {
+		t.Errorf("Humidity() got (%v, %v) want (45)", rh, err)
+	}
+}
+
+func TestBME280MockSeed(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	read := func(b *BME280) []Response {
+		var rs []Response
+		for range 3 {
+			r, err := b.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			rs = append(rs, *r)
+		}
+		return rs
+	}
+	a, b, c := New("a", TestI2CBus, 0x76), New("b", TestI2CBus, 0x77), New("c", TestI2CBus, 0x76)
+	a.SetMockSeed(1)
+	b.SetMockSeed(1)
+	c.SetMockSeed(2)
+	want := read(a)
+	if got := read(b); !slices.Equal(got, want) {
+		t.Errorf("same seed got (%v) want (%v)", got, want)
+	}
+	if got := read(c); slices.Equal(got, want) {
+		t.Errorf("another seed got the same (%v)", got)
+	}
+}
+
+func TestBME280RecordReplay(t *testing.T) {
+	c := devicetest.Use(t)
+
+	// a session recorded from a ramp
+	path := filepath.Join(t.TempDir(), "bme280.jsonl")
+	rec, err := device.CreateRecorder(path)
+	if err != nil {
+		t.Fatalf("CreateRecorder() error = %v", err)
+	}
+	hw := New("bme280", TestI2CBus, TestI2CAddress)
+	hw.SetRecorder(rec)
+	hw.SetMockSequence([]any{
+		Response{Temperature: 20, Humidity: 40, Pressure: 1013.25},
+		errors.New("bus busy"),
+		Response{Temperature: 21.5, Humidity: 41, Pressure: 1012.5},
+	}, device.MockOnce)
+	for range 4 {
+		hw.ReadPub()
+	}
+	rec.Close()
+
+	// replayed by the device in CI, the failed reads not recorded
+	replay, err := device.OpenReplay(path)
+	if err != nil {
+		t.Fatalf("OpenReplay() error = %v", err)
+	}
+	if replay.Len() != 2 {
+		t.Fatalf("recorded %d samples want 2", replay.Len())
+	}
+	ci := New("ci", TestI2CBus, TestI2CAddress)
+	ci.SetReplay(replay)
+	for _, want := range []string{
+		`{"temperature":"68.00","humidity":"40.00","pressure":"1013.25"}`,
+		`{"temperature":"70.70","humidity":"41.00","pressure":"1012.50"}`,
+	} {
+		if err := ci.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() error = %v", err)
+		}
+		c.ExpectPublish(ci.DataTopic(), devicetest.Data(want), devicetest.Timeout)
+	}
+	if err := ci.ReadPub(); !errors.Is(err, device.ErrReplayDone) {
+		t.Errorf("ReadPub() past the recording error got (%v) want (%v)", err, device.ErrReplayDone)
+	}
+}
+
+func TestBME280Faults(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	c := devicetest.Use(t)
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+
+	// a NAK every other transfer is retried away
+	bme.InjectFaults(device.FailEvery(2), device.FailWith(&drivers.ErrNak{Addr: TestI2CAddress, Err: device.ErrInjected}))
+	for i := range 4 {
+		if err := bme.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() %d with a NAK every other read error = %v", i, err)
+		}
+	}
+	if n := len(c.Messages(bme.DataTopic())); n != 4 {
+		t.Errorf("published %d readings want 4", n)
+	}
+
+	// every transfer, the retries give up as on the bus
+	bme.InjectFaults(device.FailEvery(1), device.FailWith(drivers.ErrTimeout))
+	if err := bme.ReadPub(); !errors.Is(err, ErrReadFailed) || !errors.Is(err, drivers.ErrTimeout) {
+		t.Errorf("ReadPub() timing out error got (%v) want (%v)", err, drivers.ErrTimeout)
+	}
+
+	// a window of failures, the loop publishes again after
+	bme.InjectFaults(device.FailFor(100*time.Millisecond), device.FailWith(drivers.ErrBusBusy))
+	var results []error
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	bme.TimerLoop(ctx, 20*time.Millisecond, func() error {
+		err := bme.ReadPub()
+		results = append(results, err)
+		return err
+	})
+	if len(results) < 2 || results[0] == nil || results[len(results)-1] != nil {
+		t.Errorf("loop read (%v) want failures then readings", results)
+	}
+
+	// the same by the fault command, and Open failed twice
+	if err := device.NewRouter(bme.Device).Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	c.Inject(bme.ControlTopic(), []byte(`{"cmd":"fault","args":{"every":1,"err":"nak","open":2}}`))
+	if err := bme.ReadPub(); !errors.Is(err, &drivers.ErrNak{}) {
+		t.Errorf("ReadPub() after the fault command error got (%v) want a NAK", err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if err := bme.Open(); (err != nil) != want {
+			t.Errorf("Open() %d error = %v", i+1, err)
+		}
+	}
+	c.Inject(bme.ControlTopic(), []byte("fault"))
+	if err := bme.ReadPub(); err != nil {
+		t.Errorf("ReadPub() with the faults cleared error = %v", err)
+	}
+}
+
+func TestBME280Latency(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	bme.SetLatency(device.LatencyDelay(20 * time.Millisecond))
+	start := time.Now()
+	if _, err := bme.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Read() took %v want 20ms", elapsed)
+	}
+
+	// the watchdog gives up on a stalled read, and on a scripted one
+	bme.SetLatency(device.LatencyStall(1, time.Minute))
+	for _, script := range []bool{false, true} {
+		if script {
+			bme.SetMockSequence([]any{Response{Temperature: 20}}, device.MockOnce)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		start := time.Now()
+		_, err := bme.ReadContext(ctx)
+		cancel()
+		if !errors.Is(err, ErrReadFailed) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ReadContext() stalled error got (%v) want (%v)", err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("ReadContext() stalled took %v", elapsed)
+		}
+	}
+}
+
+func TestBME280Generators(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	bme.SetGenerators(Generators{
+		Temperature: device.Sine(5, 24*time.Hour, 15),
+		Pressure:    device.Square(1000, 1020, 400*time.Millisecond),
+	})
+	r, err := bme.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(r.Temperature-15) > 0.01 || r.Pressure != 1020 {
+		t.Errorf("Read() at the start got (%+v) want 15C and 1020hPa", r)
+	}
+	if h := mockRanges[1]; r.Humidity < h.min || r.Humidity > h.max {
+		t.Errorf("Read() humidity without a generator got (%v) want a room's, %v - %v", r.Humidity, h.min, h.max)
+	}
+
+	// the square wave in real time
+	time.Sleep(250 * time.Millisecond)
+	if r, _ := bme.Read(); r.Pressure != 1000 {
+		t.Errorf("Read() half a period on got %vhPa want 1000hPa", r.Pressure)
+	}
+}
+
+func TestBME280CSVSource(t *testing.T) {
+	c := devicetest.Use(t)
+
+	// two stations of a history, in C and hPa
+	history := "time,out_t,out_h,out_p,in_t\n" +
+		"2024-01-01T00:00:00Z,5,80,1020,20\n" +
+		"2024-01-01T01:00:00Z,4,82,1019,19.5\n"
+	now := time.Now()
+	src, err := device.NewCSVSource(strings.NewReader(history), []device.CSVColumn{
+		{Column: "out_t", Device: "outside", Field: "temperature"},
+		{Column: "out_h", Device: "outside", Field: "humidity"},
+		{Column: "out_p", Device: "outside", Field: "pressure"},
+		{Column: "in_t", Device: "inside", Field: "temperature"},
+	}, device.CSVTime("time", ""), device.CSVClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("NewCSVSource() error = %v", err)
+	}
+	outside, inside := New("outside", TestI2CBus, 0x76), New("inside", TestI2CBus, 0x77)
+	outside.SetMockSource(src)
+	inside.SetMockSource(src)
+
+	for _, want := range [][2]string{
+		{`{"temperature":"41.00","humidity":"80.00","pressure":"1020.00"}`, `{"temperature":"68.00","humidity":"0.00","pressure":"0.00"}`},
+		{`{"temperature":"39.20","humidity":"82.00","pressure":"1019.00"}`, `{"temperature":"67.10","humidity":"0.00","pressure":"0.00"}`},
+	} {
+		outside.ReadPub()
+		inside.ReadPub()
+		c.ExpectPublish(outside.DataTopic(), devicetest.Data(want[0]), devicetest.Timeout)
+		c.ExpectPublish(inside.DataTopic(), devicetest.Data(want[1]), devicetest.Timeout)
+		now = now.Add(time.Hour)
+	}
+}
+
+func TestBME280MockCommand(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	c := devicetest.Use(t)
+
+	constant := func(v float64) device.Generator {
+		return device.GeneratorFunc(func(time.Duration) float64 { return v })
+	}
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	bme.SetGenerators(Generators{Temperature: constant(20), Humidity: constant(40), Pressure: constant(1000)})
+	if err := device.NewRouter(bme.Device).Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		cmd  string
+		want string
+	}{
+		{
+			`{"id":"1","mock":{"temperature":31.2,"humidity":{"delta":2}}}`,
+			`{"temperature":"88.16","humidity":"42.00","pressure":"1000.00","mocked":true}`,
+		},
+		{
+			`{"id":"2","mock":{"temperature":{"delta":-1.2}}}`,
+			`{"temperature":"86.00","humidity":"42.00","pressure":"1000.00","mocked":true}`,
+		},
+		{
+			`{"id":"3","mock":null}`,
+			`{"temperature":"68.00","humidity":"40.00","pressure":"1000.00"}`,
+		},
+	} {
+		c.Inject(bme.ControlTopic(), []byte(tt.cmd))
+		c.ExpectPublish(bme.AckTopic(), devicetest.Contains(`"ok":true`), devicetest.Timeout)
+		if err := bme.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() error = %v", err)
+		}
+		c.ExpectPublish(bme.DataTopic(), devicetest.Data(tt.want), devicetest.Timeout)
+	}
+
+	// a real sensor is not set from MQTT
+	device.Mock(false)
+	c.Inject(bme.ControlTopic(), []byte(`{"id":"4","mock":{"temperature":31.2}}`))
+	c.ExpectPublish(bme.AckTopic(), devicetest.Contains(device.ErrNotMock.Error()), devicetest.Timeout)
+	if bme.MockInjected() {
+		t.Error("mock command out of mock mode injected")
+	}
+}
+
+func TestBME280Drift(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+
+	// a month of drifting a degree a week, read in no time
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	bme := New("bme280", TestI2CBus, TestI2CAddress)
+	bme.SetClock(clock)
+	flat := device.GeneratorFunc(func(time.Duration) float64 { return 20 })
+	bme.SetGenerators(Generators{Temperature: device.Drift(flat, 1, 7*24*time.Hour)})
+
+	clock.Advance(28 * 24 * time.Hour)
+	resp, err := bme.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(resp.Temperature-24) > 1e-9 {
+		t.Errorf("Temperature after 4 weeks got (%f) want (24)", resp.Temperature)
+	}
+}
+
+func TestBME280Profiles(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+
+	for _, tt := range []struct {
+		profile    string
+		lo, hi     float64 // C, the day
+		faultEvery int
+	}{
+		{"indoor", 19, 23, 0},
+		{"outdoor-winter", -7, 3, 40},
+		{"greenhouse", 17, 35, 25},
+	} {
+		t.Run(tt.profile, func(t *testing.T) {
+			clock := device.NewSimClock(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+			bme := New("bme280-"+tt.profile, TestI2CBus, TestI2CAddress)
+			bme.SetClock(clock)
+			if err := bme.MockProfile(tt.profile); err != nil {
+				t.Fatalf("MockProfile() error = %v", err)
+			}
+
+			var night, afternoon float64
+			for h := range 24 {
+				resp, err := bme.Read()
+				if err != nil {
+					t.Fatalf("Read() at %02d:00 error = %v", h, err)
+				}
+				if resp.Temperature < tt.lo || resp.Temperature > tt.hi || resp.Humidity < 0 || resp.Humidity > 100 ||
+					resp.Pressure < 950 || resp.Pressure > 1050 {
+					t.Errorf("Read() at %02d:00 got (%+v) out of the profile", h, resp)
+				}
+				switch h {
+				case 3:
+					night = resp.Temperature
+				case 15:
+					afternoon = resp.Temperature
+				}
+				clock.Advance(time.Hour)
+			}
+			if afternoon <= night {
+				t.Errorf("Temperature at 15:00 (%.1f) not above 03:00 (%.1f)", afternoon, night)
+			}
+			st, _ := bme.MockStatus()
+			if st.Profile != tt.profile || (st.Faults == nil) != (tt.faultEvery == 0) ||
+				(st.Faults != nil && (st.Faults.Every != tt.faultEvery || st.Faults.Err != "nak")) {
+				t.Errorf("MockStatus() got (%+v) faults (%+v)", st, st.Faults)
+			}
+		})
+	}
+
+	err := New("bme280", TestI2CBus, TestI2CAddress).MockProfile("flaky")
+	if !errors.Is(err, device.ErrMockProfile) || !strings.Contains(err.Error(), "greenhouse, indoor, outdoor-winter") {
+		t.Errorf("MockProfile(flaky) error got (%v) want the profiles of a bme280", err)
+	}
+}
+
+// BenchmarkReadPubJSON is a mock read published as JSON, without a
+// Messanger for the transport to be left out of the allocations
+func BenchmarkReadPubJSON(b *testing.B) {
+	device.Mock(true)
+	defer device.Mock(false)
+	device.SetMessanger("mqtt", nil)
+
+	bme := New("bme-bench", "/dev/i2c-fake", 0x76)
+	if err := bme.Open(); err != nil {
+		b.Fatalf("Open() failed: %v", err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := bme.ReadPub(); err != nil {
+			b.Fatalf("ReadPub() error = %v", err)
+		}
 	}
 }
@@ -0,0 +1,112 @@
+package bme280
+
+import (
+	"encoding/binary"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// calibration holds the trimming parameters burned into each sensor
+// at the factory, see section 4.2.2 of the BME280 datasheet.
+type calibration struct {
+	T1 uint16
+	T2 int16
+	T3 int16
+
+	P1 uint16
+	P2 int16
+	P3 int16
+	P4 int16
+	P5 int16
+	P6 int16
+	P7 int16
+	P8 int16
+	P9 int16
+
+	H1 uint8
+	H2 int16
+	H3 uint8
+	H4 int16
+	H5 int16
+	H6 int8
+}
+
+// readCalibration reads the two calibration blocks from the sensor
+func readCalibration(dev *drivers.I2CDevice) (calibration, error) {
+	var c calibration
+
+	tp, err := dev.ReadBlock(regCalib00, 26)
+	if err != nil {
+		return c, err
+	}
+	h, err := dev.ReadBlock(regCalib26, 7)
+	if err != nil {
+		return c, err
+	}
+	c.decode(tp, h)
+	return c, nil
+}
+
+// decode unpacks the calibration registers 0x88-0xA1 (tp) and
+// 0xE1-0xE7 (h). The words are little endian, H4 and H5 are 12 bit
+// values sharing the nibbles of 0xE5.
+func (c *calibration) decode(tp, h []byte) {
+	le := binary.LittleEndian
+	c.T1 = le.Uint16(tp[0:])
+	c.T2 = int16(le.Uint16(tp[2:]))
+	c.T3 = int16(le.Uint16(tp[4:]))
+	c.P1 = le.Uint16(tp[6:])
+	c.P2 = int16(le.Uint16(tp[8:]))
+	c.P3 = int16(le.Uint16(tp[10:]))
+	c.P4 = int16(le.Uint16(tp[12:]))
+	c.P5 = int16(le.Uint16(tp[14:]))
+	c.P6 = int16(le.Uint16(tp[16:]))
+	c.P7 = int16(le.Uint16(tp[18:]))
+	c.P8 = int16(le.Uint16(tp[20:]))
+	c.P9 = int16(le.Uint16(tp[22:]))
+	c.H1 = tp[25]
+
+	c.H2 = int16(le.Uint16(h[0:]))
+	c.H3 = h[2]
+	c.H4 = int16(int8(h[3]))<<4 | int16(h[4]&0x0F)
+	c.H5 = int16(int8(h[5]))<<4 | int16(h[4]>>4)
+	c.H6 = int8(h[6])
+}
+
+// compensate converts raw readings into degrees C, hPa and %RH using
+// the floating point formulas of section 8.1 of the datasheet.
+func (c *calibration) compensate(adcT, adcP, adcH int32) Response {
+	var r Response
+
+	// temperature, t_fine carries over to the other two
+	v1 := (float64(adcT)/16384.0 - float64(c.T1)/1024.0) * float64(c.T2)
+	v2 := float64(adcT)/131072.0 - float64(c.T1)/8192.0
+	v2 = v2 * v2 * float64(c.T3)
+	tfine := v1 + v2
+	r.Temperature = tfine / 5120.0
+
+	// pressure in Pa
+	v1 = tfine/2.0 - 64000.0
+	v2 = v1 * v1 * float64(c.P6) / 32768.0
+	v2 = v2 + v1*float64(c.P5)*2.0
+	v2 = v2/4.0 + float64(c.P4)*65536.0
+	v1 = (float64(c.P3)*v1*v1/524288.0 + float64(c.P2)*v1) / 524288.0
+	v1 = (1.0 + v1/32768.0) * float64(c.P1)
+	if v1 != 0 {
+		p := 1048576.0 - float64(adcP)
+		p = (p - v2/4096.0) * 6250.0 / v1
+		v1 = float64(c.P9) * p * p / 2147483648.0
+		v2 = p * float64(c.P8) / 32768.0
+		p = p + (v1+v2+float64(c.P7))/16.0
+		r.Pressure = p / 100.0
+	}
+
+	// relative humidity
+	h := tfine - 76800.0
+	h = (float64(adcH) - (float64(c.H4)*64.0 + float64(c.H5)/16384.0*h)) *
+		(float64(c.H2) / 65536.0 * (1.0 + float64(c.H6)/67108864.0*h*(1.0+float64(c.H3)/67108864.0*h)))
+	h = h * (1.0 - float64(c.H1)*h/524288.0)
+	r.Humidity = min(max(h, 0), 100)
+
+	return r
+}
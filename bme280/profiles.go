@@ -0,0 +1,53 @@
+package bme280
+
+import (
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a BME280, the weather of where it is. The days
+// of a profile applied at midnight are the warmest at 3 in the
+// afternoon, the driest too.
+func init() {
+	device.RegisterMockProfile("bme280", "indoor", func(b *BME280) error {
+		b.profile(Generators{
+			Temperature: daily(b, 21, 1.5, 0.05),
+			Humidity:    device.Shift(device.Sine(5, 24*time.Hour, 45), 21*time.Hour),
+			Pressure:    device.Walk(1013, 0.01, 990, 1035, b.MockRand()),
+		})
+		return nil
+	})
+	device.RegisterMockProfile("bme280", "outdoor-winter", func(b *BME280) error {
+		b.profile(Generators{
+			Temperature: daily(b, -2, 4, 0.2),
+			Humidity:    device.Shift(device.Sine(10, 24*time.Hour, 80), 21*time.Hour),
+			Pressure:    device.Walk(1020, 0.05, 980, 1045, b.MockRand()),
+		}, device.FailEvery(40), device.FailWith(&drivers.ErrNak{Err: device.ErrInjected}))
+		return nil
+	})
+	device.RegisterMockProfile("bme280", "greenhouse", func(b *BME280) error {
+		b.profile(Generators{
+			Temperature: daily(b, 26, 8, 0.3),
+			Humidity:    device.Shift(device.Sine(15, 24*time.Hour, 70), 21*time.Hour),
+			Pressure:    device.Walk(1013, 0.01, 990, 1035, b.MockRand()),
+		}, device.FailEvery(25), device.FailWith(&drivers.ErrNak{Err: device.ErrInjected}))
+		return nil
+	})
+}
+
+// daily is a day of mean ± swing C, the noise of its MockRand sd
+func daily(b *BME280, mean, swing, sd float64) device.Generator {
+	return device.Sum(
+		device.Shift(device.Sine(swing, 24*time.Hour, mean), 9*time.Hour),
+		device.Noise(sd, b.MockRand()),
+	)
+}
+
+// profile sets the Generators of a profile and its faults, the ones
+// of the profile before cleared
+func (b *BME280) profile(gens Generators, faults ...device.FaultOption) {
+	b.SetGenerators(gens)
+	b.InjectFaults(faults...)
+}
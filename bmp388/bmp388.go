@@ -0,0 +1,454 @@
+// Package bmp388 provides a driver for the BMP388 and BMP390
+// barometric pressure sensors using I2C communication.
+//
+// Pressure is compensated with the floating point formulas of the
+// datasheet and published with the temperature and the altitude
+// above SeaLevel, in the payload of the bme280 package so the two
+// sensors can stand in for each other.
+package bmp388
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus     = "/dev/i2c-1"
+	DefaultI2CAddress = 0x77
+	// AltI2CAddress is the address with SDO pulled low
+	AltI2CAddress = 0x76
+
+	// StandardSeaLevel is the sea level pressure in hPa of the
+	// standard atmosphere
+	StandardSeaLevel = 1013.25
+
+	readAttempts = 3
+	retryDelay   = 10 * time.Millisecond
+)
+
+// registers, see section 4.3 of the datasheet
+const (
+	regChipID     = 0x00
+	regErr        = 0x02
+	regStatus     = 0x03
+	regData       = 0x04
+	regFIFOLength = 0x12
+	regFIFOData   = 0x14
+	regFIFOConfig = 0x17
+	regPwrCtrl    = 0x1B
+	regOSR        = 0x1C
+	regODR        = 0x1D
+	regConfig     = 0x1F
+	regCalib      = 0x31
+	regCmd        = 0x7E
+
+	calibLen = 21
+
+	chipIDBMP388 = 0x50
+	chipIDBMP390 = 0x60
+
+	errConf      = 0x04
+	statusDrdy   = 0x60 // temperature and pressure ready
+	pwrEnable    = 0x03 // pressure and temperature enabled
+	cmdFIFOFlush = 0xB0
+)
+
+var (
+	ErrNotBMP388  = errors.New("device is not a BMP388 or BMP390")
+	ErrInitFailed = errors.New("failed to initialize BMP388")
+	ErrReadFailed = errors.New("failed to read from BMP388")
+	ErrConfig     = errors.New("invalid BMP388 configuration")
+)
+
+// Env is what ReadPub publishes, the temperature (F), pressure (hPa)
+// and altitude (m) formatted like the bme280 Env
+type Env struct {
+	Temperature string `json:"temperature"`
+	Pressure    string `json:"pressure"`
+	Altitude    string `json:"altitude"`
+}
+
+// Response is a single measurement, the temperature in °C, the
+// pressure in hPa and the altitude in meters
+type Response struct {
+	Temperature float64
+	Pressure    float64
+	Altitude    float64
+}
+
+// Mode is the power mode of the sensor
+type Mode uint8
+
+const (
+	ModeSleep  Mode = 0x00
+	ModeForced Mode = 0x01
+	ModeNormal Mode = 0x03
+)
+
+// Oversampling is the number of samples averaged per measurement
+type Oversampling uint8
+
+const (
+	Oversampling1x Oversampling = iota
+	Oversampling2x
+	Oversampling4x
+	Oversampling8x
+	Oversampling16x
+	Oversampling32x
+)
+
+// Filter is the IIR filter coefficient
+type Filter uint8
+
+const (
+	FilterOff Filter = iota
+	Filter1
+	Filter3
+	Filter7
+	Filter15
+	Filter31
+	Filter63
+	Filter127
+)
+
+// ODR is the output data rate in normal mode, 200Hz halved with
+// every step
+type ODR uint8
+
+const (
+	ODR200Hz ODR = iota
+	ODR100Hz
+	ODR50Hz
+	ODR25Hz
+	ODR12_5Hz
+	ODR6_25Hz
+	ODR3_1Hz
+	ODR1_5Hz
+	ODR0_78Hz
+	ODR0_39Hz
+	ODR0_2Hz
+	ODR0_1Hz
+	ODR0_05Hz
+	ODR0_02Hz
+	ODR0_01Hz
+	ODR0_006Hz
+	ODR0_003Hz
+	ODR0_0015Hz
+)
+
+// Period returns the time between measurements
+func (o ODR) Period() time.Duration {
+	return 5 * time.Millisecond << o
+}
+
+// Config holds the configuration of the sensor
+type Config struct {
+	Mode        Mode
+	Pressure    Oversampling
+	Temperature Oversampling
+	Filter      Filter
+	ODR         ODR
+}
+
+// DefaultConfig returns forced measurements with 8x pressure and 1x
+// temperature oversampling, a 19ms measurement
+func DefaultConfig() Config {
+	return Config{
+		Mode:        ModeForced,
+		Pressure:    Oversampling8x,
+		Temperature: Oversampling1x,
+		Filter:      FilterOff,
+		ODR:         ODR50Hz,
+	}
+}
+
+// MeasureTime is the time of a measurement, section 3.9.2 of the
+// datasheet
+func (c Config) MeasureTime() time.Duration {
+	us := 234 + 392 + (1<<c.Pressure)*2020 + 163 + (1<<c.Temperature)*2020
+	return time.Duration(us) * time.Microsecond
+}
+
+// validate checks the settings are in range and that a measurement
+// fits in the output data period of normal mode
+func (c Config) validate() error {
+	switch {
+	case c.Mode != ModeSleep && c.Mode != ModeForced && c.Mode != ModeNormal:
+		return fmt.Errorf("%w: mode %d", ErrConfig, c.Mode)
+	case c.Pressure > Oversampling32x || c.Temperature > Oversampling32x:
+		return fmt.Errorf("%w: oversampling", ErrConfig)
+	case c.Filter > Filter127:
+		return fmt.Errorf("%w: filter %d", ErrConfig, c.Filter)
+	case c.ODR > ODR0_0015Hz:
+		return fmt.Errorf("%w: odr %d", ErrConfig, c.ODR)
+	case c.Mode == ModeNormal && c.MeasureTime() > c.ODR.Period():
+		return fmt.Errorf("%w: a %v measurement is longer than the %v period",
+			ErrConfig, c.MeasureTime(), c.ODR.Period())
+	}
+	return nil
+}
+
+// BMP388 is a BMP388 or BMP390 barometer on an I2C bus
+type BMP388 struct {
+	*device.Device
+
+	// SeaLevel is the sea level pressure in hPa the altitude is
+	// derived from
+	SeaLevel float64
+
+	bus  string
+	addr int
+	dev  *drivers.I2CDevice
+	id   byte
+	cal  calibration
+	cfg  Config
+	mu   sync.Mutex
+}
+
+// New creates a BMP388 at the given bus and address, the sensor is
+// not touched until Init
+func New(name, bus string, addr int) *BMP388 {
+	return &BMP388{
+		Device:   device.NewDevice(name, "mqtt"),
+		SeaLevel: StandardSeaLevel,
+		bus:      bus,
+		addr:     addr,
+		cfg:      DefaultConfig(),
+	}
+}
+
+// Name returns the name of the device
+func (b *BMP388) Name() string {
+	return b.Device.Name
+}
+
+// Init opens the i2c bus with the default configuration
+func (b *BMP388) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	return b.InitWith(DefaultConfig())
+}
+
+// InitWith opens the i2c bus, verifies the chip id, reads the
+// calibration and writes the given configuration
+func (b *BMP388) InitWith(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	dev, err := drivers.NewI2CDevice(b.bus, b.addr)
+	if err != nil {
+		return err
+	}
+
+	id, err := dev.ReadReg8(regChipID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInitFailed, err)
+	}
+	if id != chipIDBMP388 && id != chipIDBMP390 {
+		return fmt.Errorf("%w: chip id %#02x", ErrNotBMP388, id)
+	}
+	cal, err := readCalibration(dev)
+	if err != nil {
+		return fmt.Errorf("%w: calibration: %w", ErrInitFailed, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dev, b.id, b.cal = dev, id, cal
+	if err := b.configure(cfg); err != nil {
+		return fmt.Errorf("%w: %w", ErrInitFailed, err)
+	}
+	return nil
+}
+
+// Configure writes a new configuration
+func (b *BMP388) Configure(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		b.cfg = cfg
+		return nil
+	}
+	return b.configure(cfg)
+}
+
+// configure writes cfg with the sensor asleep, the settings only
+// take in sleep mode, and checks the sensor accepted them
+func (b *BMP388) configure(cfg Config) error {
+	pwr := byte(pwrEnable)
+	if cfg.Mode == ModeNormal {
+		pwr |= byte(ModeNormal) << 4
+	}
+	err := b.dev.Tx(func(bus drivers.I2CBus) error {
+		for _, w := range []struct {
+			reg byte
+			val byte
+		}{
+			{regPwrCtrl, 0},
+			{regOSR, byte(cfg.Temperature)<<3 | byte(cfg.Pressure)},
+			{regODR, byte(cfg.ODR)},
+			{regConfig, byte(cfg.Filter) << 1},
+			{regPwrCtrl, pwr},
+		} {
+			if err := bus.WriteReg(w.reg, []byte{w.val}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	status, err := b.dev.ReadReg8(regErr)
+	if err != nil {
+		return err
+	}
+	if status&errConf != 0 {
+		return fmt.Errorf("%w: rejected by the sensor", ErrConfig)
+	}
+	b.cfg = cfg
+	return nil
+}
+
+// Model returns "BMP388" or "BMP390", empty before Init
+func (b *BMP388) Model() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.id {
+	case chipIDBMP388:
+		return "BMP388"
+	case chipIDBMP390:
+		return "BMP390"
+	}
+	return ""
+}
+
+// Read one Response from the sensor, in forced mode after taking a
+// measurement. If the device is mocked it makes up a reading.
+func (b *BMP388) Read() (*Response, error) {
+	if device.IsMock() {
+		p := 1000 + b.MockRand().Float64()*25
+		return &Response{
+			Temperature: 15 + b.MockRand().Float64()*10,
+			Pressure:    p,
+			Altitude:    Altitude(p, b.SeaLevel),
+		}, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	var buf []byte
+	err := drivers.RetryI2C(readAttempts, retryDelay, func() (err error) {
+		if b.cfg.Mode == ModeForced {
+			if err := b.measure(); err != nil {
+				return err
+			}
+		}
+		// burst read so both values come from the same measurement
+		buf, err = b.dev.ReadBlock(regData, 6)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	r := b.compensate(uint24(buf[3:]), uint24(buf[0:]))
+	return &r, nil
+}
+
+// Temperature reads the temperature in °C, the BMP388 is a
+// device.Thermometer
+func (b *BMP388) Temperature() (float64, error) {
+	r, err := b.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// measure triggers a forced measurement and waits for it
+func (b *BMP388) measure() error {
+	if err := b.dev.WriteReg8(regPwrCtrl, pwrEnable|byte(ModeForced)<<4); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(2*b.cfg.MeasureTime() + 10*time.Millisecond)
+	for {
+		status, err := b.dev.ReadReg8(regStatus)
+		if err != nil {
+			return err
+		}
+		if status&statusDrdy == statusDrdy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("measurement timed out")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// compensate converts the raw values to a Response
+func (b *BMP388) compensate(adcT, adcP uint32) Response {
+	t := b.cal.temperature(adcT)
+	p := b.cal.pressure(adcP, t) / 100
+	return Response{Temperature: t, Pressure: p, Altitude: Altitude(p, b.SeaLevel)}
+}
+
+// uint24 returns the little endian 24 bit value of b
+func uint24(b []byte) uint32 {
+	return uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
+}
+
+// ReadPub reads the sensor and publishes the temperature, pressure
+// and altitude
+func (b *BMP388) ReadPub() error {
+	r, err := b.Read()
+	if err != nil {
+		return fmt.Errorf("reading BMP388: %w", err)
+	}
+	j, err := json.Marshal(&Env{
+		Temperature: fmt.Sprintf("%.2f", r.Temperature*9/5+32),
+		Pressure:    fmt.Sprintf("%.2f", r.Pressure),
+		Altitude:    fmt.Sprintf("%.2f", r.Altitude),
+	})
+	if err != nil {
+		return err
+	}
+	b.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (b *BMP388) Run(ctx context.Context, period time.Duration) error {
+	err := b.TimerLoop(ctx, period, b.ReadPub)
+	slog.Debug("bmp388 stopped", "device", b.Device.Name, "error", err)
+	return err
+}
+
+// Close puts the sensor to sleep and closes the i2c device
+func (b *BMP388) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		return nil
+	}
+	b.dev.WriteReg8(regPwrCtrl, 0)
+	err := b.dev.Close()
+	b.dev = nil
+	return err
+}
@@ -0,0 +1,271 @@
+package bmp388
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const (
+	TestI2CBus     = "/dev/i2c-1"
+	TestI2CAddress = 0x77
+)
+
+// testNVM is a calibration as the sensor keeps it in 0x31 - 0x45,
+// T1 27865, T2 19008, T3 -7, P1 -1425, P2 -2716, P3 35, P4 0,
+// P5 25130, P6 30744, P7 3, P8 -6, P9 16173, P10 4, P11 -60
+func testNVM() []byte {
+	b := make([]byte, calibLen)
+	le := binary.LittleEndian
+	le.PutUint16(b[0:], 27865)
+	le.PutUint16(b[2:], 19008)
+	b[4] = byte(0xF9)                   // -7
+	le.PutUint16(b[5:], uint16(0xFA6F)) // -1425
+	le.PutUint16(b[7:], uint16(0xF564)) // -2716
+	b[9], b[10] = 35, 0
+	le.PutUint16(b[11:], 25130)
+	le.PutUint16(b[13:], 30744)
+	b[15], b[16] = 3, byte(0xFA) // 3, -6
+	le.PutUint16(b[17:], 16173)
+	b[19], b[20] = 4, byte(0xC4) // 4, -60
+	return b
+}
+
+// vectors holds raw readings with the temperature and pressure of
+// the datasheet's floating point compensation of section 9.3 for the
+// testNVM calibration, worked out apart from this package in double
+// precision
+var vectors = []struct {
+	adcT, adcP uint32
+	temp, pres float64
+}{
+	{8545000, 6600000, 24.938701601946605, 97158.54156448726},
+	{8100000, 6000000, 17.08737167320578, 105439.70901226885},
+}
+
+func TestCalibrationDecode(t *testing.T) {
+	var c calibration
+	c.decode(testNVM())
+
+	want := calibration{
+		T1: 27865 * 256, T2: 19008 / math.Pow(2, 30), T3: -7 / math.Pow(2, 48),
+		P1: (-1425 - 16384) / math.Pow(2, 20), P2: (-2716 - 16384) / math.Pow(2, 29),
+		P3: 35 / math.Pow(2, 32), P4: 0, P5: 25130 * 8, P6: 30744 / math.Pow(2, 6),
+		P7: 3 / math.Pow(2, 8), P8: -6 / math.Pow(2, 15), P9: 16173 / math.Pow(2, 48),
+		P10: 4 / math.Pow(2, 48), P11: -60 / math.Pow(2, 65),
+	}
+	if c != want {
+		t.Errorf("decode() got (%+v) want (%+v)", c, want)
+	}
+}
+
+func TestCompensate(t *testing.T) {
+	var c calibration
+	c.decode(testNVM())
+	for _, v := range vectors {
+		temp := c.temperature(v.adcT)
+		if math.Abs(temp-v.temp) > 1e-9 {
+			t.Errorf("temperature(%d) got (%.9f) want (%.9f)", v.adcT, temp, v.temp)
+		}
+		if p := c.pressure(v.adcP, temp); math.Abs(p-v.pres) > 1e-6 {
+			t.Errorf("pressure(%d) got (%.6f) want (%.6f)", v.adcP, p, v.pres)
+		}
+	}
+}
+
+func TestAltitude(t *testing.T) {
+	alts := []struct {
+		p, p0, want float64
+	}{
+		{1013.25, StandardSeaLevel, 0},
+		{898.75, StandardSeaLevel, 1000.1},
+		{971.59, StandardSeaLevel, 352.8},
+		{971.59, 1000, 242.5},
+		{1054.4, StandardSeaLevel, -337.1},
+	}
+	for _, tt := range alts {
+		if got := Altitude(tt.p, tt.p0); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("Altitude(%g, %g) got (%.2f) want (%.1f)", tt.p, tt.p0, got, tt.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if got := DefaultConfig().MeasureTime(); got != 18969*time.Microsecond {
+		t.Errorf("MeasureTime() got (%v) want (18.969ms)", got)
+	}
+	if got := ODR0_1Hz.Period(); got != 10240*time.Millisecond {
+		t.Errorf("Period() got (%v) want (10.24s)", got)
+	}
+
+	ok := DefaultConfig()
+	ok.Mode = ModeNormal
+	if err := ok.validate(); err != nil {
+		t.Errorf("validate(normal, 50Hz) error = %v", err)
+	}
+	bad := []Config{
+		{Mode: 2},
+		{Mode: ModeForced, Pressure: 6},
+		{Mode: ModeForced, Filter: 8},
+		{Mode: ModeForced, ODR: 18},
+		// 32x pressure takes 67ms
+		{Mode: ModeNormal, Pressure: Oversampling32x, ODR: ODR50Hz},
+	}
+	for _, c := range bad {
+		if err := c.validate(); !errors.Is(err, ErrConfig) {
+			t.Errorf("validate(%+v) error got (%v) want (%v)", c, err, ErrConfig)
+		}
+	}
+}
+
+func newFake(id byte) *driverstest.I2C {
+	fake := driverstest.NewI2C()
+	fake.Set(regChipID, id)
+	fake.Set(regCalib, testNVM()...)
+	fake.Set(regStatus, 0x70)
+	// adc_P 6600000 and adc_T 8545000
+	fake.Set(regData, 0x40, 0xB5, 0x64, 0xE8, 0x62, 0x82)
+	return fake
+}
+
+func TestInitRead(t *testing.T) {
+	device.Mock(false)
+	fake := newFake(chipIDBMP390)
+	driverstest.UseI2C(t, fake)
+
+	b := New("baro-test", TestI2CBus, TestI2CAddress)
+	cfg := DefaultConfig()
+	cfg.Filter = Filter3
+	if err := b.InitWith(cfg); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+	if b.Model() != "BMP390" {
+		t.Errorf("Model() got (%s) want (BMP390)", b.Model())
+	}
+	if got := fake.Get(regOSR, 2); got[0] != 0x03 || got[1] != byte(ODR50Hz) {
+		t.Errorf("OSR and ODR got (% x) want (03 02)", got)
+	}
+	if got := fake.Get(regConfig, 1)[0]; got != 0x04 {
+		t.Errorf("CONFIG got (%#02x) want (0x04)", got)
+	}
+
+	r, err := b.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	// the forced measurement was triggered
+	if got := fake.Get(regPwrCtrl, 1)[0]; got != 0x13 {
+		t.Errorf("PWR_CTRL got (%#02x) want (0x13)", got)
+	}
+	if math.Abs(r.Temperature-24.9387) > 1e-4 || math.Abs(r.Pressure-971.5854) > 1e-4 {
+		t.Errorf("Read() got (%+v) want (24.9387C, 971.5854hPa)", r)
+	}
+	if math.Abs(r.Altitude-352.8) > 0.1 {
+		t.Errorf("Altitude got (%.2f) want (352.8)", r.Altitude)
+	}
+
+	b.SeaLevel = 1000
+	if r, _ := b.Read(); math.Abs(r.Altitude-242.5) > 0.1 {
+		t.Errorf("Altitude at 1000hPa got (%.2f) want (242.5)", r.Altitude)
+	}
+
+	// a thermometer linked by its name
+	dm := device.GetDeviceManager()
+	dm.Add(b)
+	defer dm.Remove(b.Name())
+	th, err := device.GetThermometer("baro-test")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	if c, err := th.Temperature(); err != nil || math.Abs(c-24.9387) > 1e-4 {
+		t.Errorf("Temperature() got (%v, %v) want (24.9387)", c, err)
+	}
+
+	// normal mode measures on its own
+	cfg.Mode = ModeNormal
+	if err := b.Configure(cfg); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if got := fake.Get(regPwrCtrl, 1)[0]; got != 0x33 {
+		t.Errorf("PWR_CTRL got (%#02x) want (0x33)", got)
+	}
+	fake.Set(regErr, errConf)
+	if err := b.Configure(cfg); !errors.Is(err, ErrConfig) {
+		t.Errorf("Configure() rejected error got (%v) want (%v)", err, ErrConfig)
+	}
+
+	b.Close()
+	if fake.Get(regPwrCtrl, 1)[0] != 0 || !fake.Closed() {
+		t.Error("Close() did not put the sensor to sleep")
+	}
+}
+
+func TestNotBMP388(t *testing.T) {
+	device.Mock(false)
+	driverstest.UseI2C(t, newFake(0x58))
+
+	b := New("baro-test", TestI2CBus, TestI2CAddress)
+	if err := b.Init(); !errors.Is(err, ErrNotBMP388) {
+		t.Errorf("Init() error got (%v) want (%v)", err, ErrNotBMP388)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	frames := []byte{
+		0x48, 0x00, // configuration change
+		0xA0, 0x01, 0x02, 0x03, // sensor time
+		0x94, 0xE8, 0x62, 0x82, 0x40, 0xB5, 0x64,
+		0x90, 0xE8, 0x62, 0x82, // temperature only
+		0x94, 0xA0, 0x98, 0x7B, 0x80, 0x8D, 0x5B,
+		0x80, 0x00, // empty
+	}
+	got, err := parseFIFO(frames)
+	want := []fifoFrame{{8545000, 6600000}, {8100000, 6000000}}
+	if err != nil || len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parseFIFO() got (%v, %v) want (%v)", got, err, want)
+	}
+
+	// a frame cut short ends the frames
+	if got, err := parseFIFO(frames[:20]); err != nil || len(got) != 1 {
+		t.Errorf("parseFIFO(short) got (%v, %v) want 1 frame", got, err)
+	}
+	if _, err := parseFIFO([]byte{0x44, 0x00}); !errors.Is(err, ErrConfig) {
+		t.Errorf("parseFIFO(config error) error got (%v) want (%v)", err, ErrConfig)
+	}
+	if _, err := parseFIFO([]byte{0x13}); !errors.Is(err, ErrReadFailed) {
+		t.Errorf("parseFIFO(0x13) error got (%v) want (%v)", err, ErrReadFailed)
+	}
+
+	device.Mock(false)
+	fake := newFake(chipIDBMP388)
+	driverstest.UseI2C(t, fake)
+	b := New("baro-test", TestI2CBus, TestI2CAddress)
+	if err := b.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer b.Close()
+	if err := b.SetFIFO(true); err != nil {
+		t.Fatalf("SetFIFO() error = %v", err)
+	}
+	if got := fake.Get(regFIFOConfig, 1)[0]; got != fifoEnable {
+		t.Errorf("FIFO_CONFIG_1 got (%#02x) want (%#02x)", got, fifoEnable)
+	}
+	if got := fake.Get(regCmd, 1)[0]; got != cmdFIFOFlush {
+		t.Errorf("CMD got (%#02x) want the FIFO flushed", got)
+	}
+
+	fake.Set(regFIFOLength, byte(len(frames)), 0)
+	fake.Set(regFIFOData, frames...)
+	rs, err := b.ReadFIFO()
+	if err != nil || len(rs) != 2 {
+		t.Fatalf("ReadFIFO() got (%v, %v) want 2 readings", rs, err)
+	}
+	if math.Abs(rs[1].Temperature-17.0874) > 1e-4 || math.Abs(rs[1].Pressure-1054.3971) > 1e-4 {
+		t.Errorf("ReadFIFO()[1] got (%+v) want (17.0874C, 1054.3971hPa)", rs[1])
+	}
+}
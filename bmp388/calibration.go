@@ -0,0 +1,76 @@
+package bmp388
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// calibration holds the trimming coefficients of the NVM scaled to
+// floating point, see section 9.1 of the BMP388 datasheet
+type calibration struct {
+	T1, T2, T3 float64
+
+	P1, P2, P3, P4, P5, P6, P7, P8, P9, P10, P11 float64
+}
+
+// readCalibration reads the calibration coefficients 0x31 - 0x45
+func readCalibration(dev *drivers.I2CDevice) (calibration, error) {
+	var c calibration
+	buf, err := dev.ReadBlock(regCalib, calibLen)
+	if err != nil {
+		return c, err
+	}
+	c.decode(buf)
+	return c, nil
+}
+
+// decode unpacks the little endian coefficients and scales them by
+// the powers of two of the datasheet
+func (c *calibration) decode(b []byte) {
+	le := binary.LittleEndian
+	u16 := func(i int) float64 { return float64(le.Uint16(b[i:])) }
+	s16 := func(i int) float64 { return float64(int16(le.Uint16(b[i:]))) }
+	s8 := func(i int) float64 { return float64(int8(b[i])) }
+
+	c.T1 = u16(0) * 0x1p8
+	c.T2 = u16(2) * 0x1p-30
+	c.T3 = s8(4) * 0x1p-48
+
+	c.P1 = (s16(5) - 0x1p14) * 0x1p-20
+	c.P2 = (s16(7) - 0x1p14) * 0x1p-29
+	c.P3 = s8(9) * 0x1p-32
+	c.P4 = s8(10) * 0x1p-37
+	c.P5 = u16(11) * 0x1p3
+	c.P6 = u16(13) * 0x1p-6
+	c.P7 = s8(15) * 0x1p-8
+	c.P8 = s8(16) * 0x1p-15
+	c.P9 = s16(17) * 0x1p-48
+	c.P10 = s8(19) * 0x1p-48
+	c.P11 = s8(20) * 0x1p-65
+}
+
+// temperature returns the compensated temperature in °C, which the
+// pressure compensation takes as well
+func (c *calibration) temperature(adcT uint32) float64 {
+	d := float64(adcT) - c.T1
+	return d*c.T2 + d*d*c.T3
+}
+
+// pressure returns the compensated pressure in Pa at the compensated
+// temperature t
+func (c *calibration) pressure(adcP uint32, t float64) float64 {
+	p := float64(adcP)
+	out1 := c.P5 + c.P6*t + c.P7*t*t + c.P8*t*t*t
+	out2 := p * (c.P1 + c.P2*t + c.P3*t*t + c.P4*t*t*t)
+	out3 := p*p*(c.P9+c.P10*t) + p*p*p*c.P11
+	return out1 + out2 + out3
+}
+
+// Altitude returns the altitude in meters at pressure p for the sea
+// level pressure p0, both in hPa, from the international barometric
+// formula
+func Altitude(p, p0 float64) float64 {
+	return 44330 * (1 - math.Pow(p/p0, 1/5.255))
+}
@@ -0,0 +1,119 @@
+package bmp388
+
+import (
+	"fmt"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// FIFO frame headers, section 3.6 of the datasheet
+const (
+	frameSensor    = 0x80 // the sensor frames, with the data bits below
+	frameTime      = 0x20
+	frameTemp      = 0x10
+	framePress     = 0x04
+	frameConfigErr = 0x44
+	frameConfigChg = 0x48
+
+	fifoEnable = 0x19 // fifo_mode, fifo_press_en and fifo_temp_en
+	fifoSize   = 512
+)
+
+// SetFIFO turns the FIFO on or off, in normal mode it keeps the
+// measurements taken between calls to ReadFIFO
+func (b *BMP388) SetFIFO(on bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		return nil
+	}
+	cfg := byte(0)
+	if on {
+		cfg = fifoEnable
+	}
+	return b.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.WriteReg(regFIFOConfig, []byte{cfg}); err != nil {
+			return err
+		}
+		return bus.WriteReg(regCmd, []byte{cmdFIFOFlush})
+	})
+}
+
+// ReadFIFO reads the measurements kept in the FIFO, the oldest first
+func (b *BMP388) ReadFIFO() ([]Response, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dev == nil {
+		return nil, nil
+	}
+
+	var buf []byte
+	err := b.dev.Tx(func(bus drivers.I2CBus) error {
+		n := make([]byte, 2)
+		if err := bus.ReadReg(regFIFOLength, n); err != nil {
+			return err
+		}
+		length := min(int(n[1]&0x01)<<8|int(n[0]), fifoSize)
+		if length == 0 {
+			return nil
+		}
+		buf = make([]byte, length)
+		return bus.ReadReg(regFIFOData, buf)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+
+	frames, err := parseFIFO(buf)
+	var rs []Response
+	for _, f := range frames {
+		rs = append(rs, b.compensate(f.adcT, f.adcP))
+	}
+	return rs, err
+}
+
+// fifoFrame is the raw temperature and pressure of a sensor frame
+type fifoFrame struct {
+	adcT, adcP uint32
+}
+
+// parseFIFO returns the frames holding both a temperature and a
+// pressure. The time, empty and control frames are skipped, a
+// configuration error frame returns ErrConfig after the frames.
+func parseFIFO(buf []byte) ([]fifoFrame, error) {
+	var frames []fifoFrame
+	var err error
+	for len(buf) > 0 {
+		h := buf[0]
+		buf = buf[1:]
+
+		if h == frameConfigErr || h == frameConfigChg {
+			if h == frameConfigErr {
+				err = fmt.Errorf("%w: fifo configuration error", ErrConfig)
+			}
+			buf = buf[min(1, len(buf)):]
+			continue
+		}
+		if h&0xC0 != frameSensor || h&^(frameSensor|frameTime|frameTemp|framePress) != 0 {
+			return frames, fmt.Errorf("%w: fifo frame header %#02x", ErrReadFailed, h)
+		}
+
+		size := 0
+		for _, bit := range []byte{frameTime, frameTemp, framePress} {
+			if h&bit != 0 {
+				size += 3
+			}
+		}
+		if h == frameSensor {
+			size = 1 // an empty frame, the FIFO ran out
+		}
+		if len(buf) < size {
+			break // a frame cut short by the length read
+		}
+		if h&(frameTemp|framePress) == frameTemp|framePress {
+			frames = append(frames, fifoFrame{adcT: uint24(buf), adcP: uint24(buf[3:])})
+		}
+		buf = buf[size:]
+	}
+	return frames, err
+}
@@ -0,0 +1,406 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// DefaultBufferSize is the number of publishes a Buffer keeps in
+// memory unless it is given another
+const DefaultBufferSize = 1000
+
+// Buffer is a Messanger keeping the data the devices failed to publish
+// on another one, while the broker or the uplink is down, and
+// publishing it again in order once the station is back "online". The
+// data is replayed with the Envelope it was taken with, its TS, flagged
+// Replay so a consumer tells the backfill from the live data. A device
+// not Enveloped replays its payload as it was.
+//
+// The state of a device is not kept unless BufferStates has it kept,
+// the state of an actuator replayed after the fact is wrong and a
+// consumer may act on it. The acks of the commands are never kept, nor
+// what is published on the Messanger directly.
+//
+// A Buffer keeps BufferSize publishes, the oldest dropped for a new one
+// and counted. BufferFile spills the oldest to a file rather than
+// dropping them, and the file is read back when the Buffer is created
+// again, after a restart. The publishes are replayed unretained, the
+// retained one on a topic stays the live one.
+type Buffer struct {
+	Messanger
+
+	size    int
+	path    string
+	fileMax int
+	states  bool
+
+	mem   []buffered // the newest, in order
+	cache []buffered // the spilled ones read back to replay
+	first uint64     // the seq of the oldest kept
+	spill uint64     // the seqs from first to spill are in the file
+	next  uint64
+	file  *os.File
+	lines int // in the file, the ones before first included
+	stats BufferStats
+
+	replaying sync.Mutex
+	wg        sync.WaitGroup
+	closed    bool
+	mu        sync.Mutex
+}
+
+// BufferStats counts the publishes of a Buffer. Buffered are the ones
+// it kept, Replayed the ones published after and Dropped the ones it
+// had no room for, Pending the ones it has left to replay.
+type BufferStats struct {
+	Buffered int `json:"buffered"`
+	Replayed int `json:"replayed"`
+	Dropped  int `json:"dropped"`
+	Pending  int `json:"pending"`
+}
+
+// buffered is a publish kept by a Buffer, a line of its file
+type buffered struct {
+	Seq   uint64 `json:"seq"`
+	Topic string `json:"topic"`
+	Data  []byte `json:"data"`
+	QoS   QoS    `json:"qos"`
+}
+
+// backfill is a publish of a device a Buffer may keep, encode makes
+// the payload to replay
+type backfill struct {
+	typ    EventType
+	encode func() ([]byte, error)
+}
+
+// buffering is a Messanger keeping the publishes of the devices that
+// failed, a Buffer or a Fanout with one among its backends
+type buffering interface {
+	// publishBuffered publishes, a failure kept as bf tells and
+	// buffered true then
+	publishBuffered(topic string, payload []byte, qos QoS, retain bool, bf *backfill) (buffered bool, err error)
+}
+
+// BufferOption configures a Buffer
+type BufferOption func(*Buffer)
+
+// BufferSize sets the number of publishes kept in memory
+func BufferSize(n int) BufferOption {
+	return func(b *Buffer) {
+		b.size = max(n, 1)
+	}
+}
+
+// BufferFile spills the publishes there is no room for in memory to
+// the file at path, up to n of them
+func BufferFile(path string, n int) BufferOption {
+	return func(b *Buffer) {
+		b.path, b.fileMax = path, max(n, 1)
+	}
+}
+
+// BufferStates has the state of the devices kept too, for the ones
+// whose state is a reading
+func BufferStates(on bool) BufferOption {
+	return func(b *Buffer) {
+		b.states = on
+	}
+}
+
+// NewBuffer creates a Buffer of the publishes on m, replaying them
+// every time the station is announced "online". The publishes left in
+// the file of BufferFile are replayed first.
+func NewBuffer(m Messanger, opts ...BufferOption) (*Buffer, error) {
+	b := &Buffer{Messanger: m, size: DefaultBufferSize}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.path != "" {
+		if err := b.open(); err != nil {
+			return nil, err
+		}
+	}
+	err := m.Subscribe(StationTopic(), func(msg *Msg) {
+		if msg.String() != Online {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.closed {
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			if err := b.Replay(); err != nil {
+				slog.Warn("buffer replay", "error", err)
+			}
+		}()
+	})
+	if err != nil {
+		b.closeFile()
+		return nil, err
+	}
+	return b, nil
+}
+
+// open opens the file and takes the publishes left in it
+func (b *Buffer) open() error {
+	f, err := os.OpenFile(b.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("buffer file: %w", err)
+	}
+	kept, err := readBuffered(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("buffer file %s: %w", b.path, err)
+	}
+	b.file = f
+	b.lines = len(kept)
+	if len(kept) > 0 {
+		b.first = kept[0].Seq
+		b.spill = kept[len(kept)-1].Seq + 1
+		b.next = b.spill
+		if n := b.spill - b.first; n > uint64(b.fileMax) {
+			b.first = b.spill - uint64(b.fileMax)
+			b.stats.Dropped += int(n) - b.fileMax
+		}
+	}
+	return nil
+}
+
+func readBuffered(r io.ReadSeeker) ([]buffered, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var kept []buffered
+	dec := json.NewDecoder(r)
+	for {
+		var e buffered
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return kept, nil
+		}
+		if err != nil {
+			return kept, err
+		}
+		kept = append(kept, e)
+	}
+}
+
+func (b *Buffer) publishBuffered(topic string, payload []byte, qos QoS, retain bool, bf *backfill) (bool, error) {
+	err := b.PublishQoS(topic, payload, qos, retain)
+	if err != nil && b.buffer(topic, qos, bf, err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// buffer keeps the publish of bf on topic that failed with err, it
+// returns false when it does not
+func (b *Buffer) buffer(topic string, qos QoS, bf *backfill, err error) bool {
+	if errors.Is(err, ErrMessangerClosed) || (bf.typ == EventState && !b.states) {
+		return false
+	}
+	payload, err := bf.encode()
+	if err != nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	b.mem = append(b.mem, buffered{Seq: b.next, Topic: topic, Data: payload, QoS: qos})
+	b.next++
+	b.stats.Buffered++
+	if len(b.mem) > b.size {
+		old := b.mem[0]
+		b.mem = b.mem[1:]
+		if b.file == nil || !b.spillOne(old) {
+			// the file lost too when it could not be written
+			b.stats.Dropped += int(old.Seq + 1 - b.first)
+			b.first = old.Seq + 1
+		}
+	}
+	b.spill = max(b.spill, b.first)
+	return true
+}
+
+// spillOne appends e to the file, the oldest of the file dropped when
+// it is full
+func (b *Buffer) spillOne(e buffered) bool {
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = b.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		slog.Error("buffer spill", "file", b.path, "error", err)
+		return false
+	}
+	b.lines++
+	b.spill = e.Seq + 1
+	if b.spill-b.first > uint64(b.fileMax) {
+		b.first++
+		b.stats.Dropped++
+	}
+	if dead := b.lines - int(b.spill-b.first); dead >= b.fileMax {
+		b.compact()
+	}
+	return true
+}
+
+// compact rewrites the file without the publishes dropped or replayed
+func (b *Buffer) compact() {
+	kept, err := readBuffered(b.file)
+	if err != nil {
+		slog.Error("buffer compact", "file", b.path, "error", err)
+		return
+	}
+	var data []byte
+	lines := 0
+	for _, e := range kept {
+		if e.Seq >= b.first {
+			line, _ := json.Marshal(e)
+			data = append(append(data, line...), '\n')
+			lines++
+		}
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("buffer compact", "file", b.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		slog.Error("buffer compact", "file", b.path, "error", err)
+		return
+	}
+	f, err := os.OpenFile(b.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		slog.Error("buffer compact", "file", b.path, "error", err)
+		return
+	}
+	b.file.Close()
+	b.file, b.lines = f, lines
+}
+
+// head returns the oldest publish kept
+func (b *Buffer) head() (buffered, bool) {
+	if b.first == b.next {
+		return buffered{}, false
+	}
+	if b.first >= b.spill {
+		return b.mem[0], true
+	}
+	if b.file == nil {
+		return buffered{}, false // closed
+	}
+	for len(b.cache) > 0 && b.cache[0].Seq < b.first {
+		b.cache = b.cache[1:]
+	}
+	if len(b.cache) == 0 {
+		kept, err := readBuffered(b.file)
+		if err != nil {
+			slog.Error("buffer read", "file", b.path, "error", err)
+		}
+		for _, e := range kept {
+			if e.Seq >= b.first && e.Seq < b.spill {
+				b.cache = append(b.cache, e)
+			}
+		}
+		if len(b.cache) == 0 {
+			// lost with the file, go on with memory
+			b.stats.Dropped += int(b.spill - b.first)
+			b.first = b.spill
+			return b.head()
+		}
+	}
+	return b.cache[0], true
+}
+
+// pop drops e once replayed, unless it was dropped meanwhile
+func (b *Buffer) pop(e buffered) {
+	if e.Seq != b.first {
+		return
+	}
+	b.first++
+	b.stats.Replayed++
+	if len(b.mem) > 0 && b.mem[0].Seq == e.Seq {
+		b.mem = b.mem[1:]
+	}
+	if b.file != nil && b.first >= b.spill && b.lines > 0 {
+		// all replayed, start over
+		b.cache = nil
+		if err := b.file.Truncate(0); err != nil {
+			slog.Error("buffer truncate", "file", b.path, "error", err)
+		} else {
+			b.lines = 0
+		}
+	}
+	b.spill = max(b.spill, b.first)
+}
+
+// Replay publishes what the Buffer kept, oldest first, and stops at
+// the first publish that fails
+func (b *Buffer) Replay() error {
+	b.replaying.Lock()
+	defer b.replaying.Unlock()
+	for {
+		b.mu.Lock()
+		e, ok := b.head()
+		b.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		if err := b.Messanger.PublishQoS(e.Topic, e.Data, e.QoS, false); err != nil {
+			return fmt.Errorf("replay %s: %w", e.Topic, err)
+		}
+		b.mu.Lock()
+		b.pop(e)
+		b.mu.Unlock()
+	}
+}
+
+// Stats returns the counts of the Buffer
+func (b *Buffer) Stats() BufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Pending = int(b.next - b.first)
+	return stats
+}
+
+// Close waits for a replay going on and closes the Messanger. The
+// publishes not replayed are spilled to the file of BufferFile, for
+// the Buffer created after a restart, and lost without one.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.wg.Wait()
+
+	b.mu.Lock()
+	b.closeFile()
+	b.mu.Unlock()
+	return b.Messanger.Close()
+}
+
+func (b *Buffer) closeFile() {
+	if b.file == nil {
+		return
+	}
+	for _, e := range b.mem {
+		b.spillOne(e)
+	}
+	b.mem = nil
+	b.compact()
+	b.file.Close()
+	b.file = nil
+}
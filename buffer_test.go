@@ -0,0 +1,179 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// values decodes the data published on topic and tells the replayed
+func values(t *testing.T, m *MemMessanger, topic string) (vals []string, replayed []bool) {
+	t.Helper()
+	for _, msg := range m.Messages(topic) {
+		var data []byte
+		env, err := Decode(msg.Topic, msg.Data, &data)
+		if err != nil || env == nil {
+			t.Fatalf("Decode(%s) got (%v, %v)", msg, env, err)
+		}
+		vals = append(vals, string(data))
+		replayed = append(replayed, env.Replay)
+	}
+	return vals, replayed
+}
+
+func TestBuffer(t *testing.T) {
+	m := NewMemMessanger()
+	b, err := NewBuffer(m, BufferSize(3))
+	if err != nil {
+		t.Fatalf("NewBuffer() error (%v)", err)
+	}
+	soil := NewDevice("soil", "mqtt", WithMessanger(b))
+	relay := NewDevice("relay", "mqtt", WithMessanger(b))
+
+	soil.PubData(1)
+	m.Disconnect()
+	for i := 2; i <= 6; i++ {
+		if err := soil.PubData(i); err != nil {
+			t.Fatalf("PubData(%d) offline error (%v) want it buffered", i, err)
+		}
+	}
+	if err := relay.PubState(1); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PubState() offline error got (%v) want (%v), states are not buffered", err, ErrNotConnected)
+	}
+	want := BufferStats{Buffered: 5, Dropped: 2, Pending: 3}
+	if got := b.Stats(); got != want {
+		t.Errorf("Stats() offline got (%+v) want (%+v)", got, want)
+	}
+	if got := soil.PubStats(); got.Buffered != 5 || got.Failed != 0 {
+		t.Errorf("PubStats() got (%+v) want 5 buffered", got)
+	}
+
+	back := time.Now()
+	m.Connect()
+	waitFor(t, func() bool { return b.Stats().Pending == 0 })
+	soil.PubData(7)
+
+	vals, replayed := values(t, m, soil.DataTopic())
+	wantVals := []string{"1", "4", "5", "6", "7"}
+	wantReplayed := []bool{false, true, true, true, false}
+	for i := range max(len(vals), len(wantVals)) {
+		if i >= len(vals) || i >= len(wantVals) || vals[i] != wantVals[i] || replayed[i] != wantReplayed[i] {
+			t.Fatalf("published got (%v, %v) want (%v, %v)", vals, replayed, wantVals, wantReplayed)
+		}
+	}
+	// the time they were taken
+	var env Envelope
+	json.Unmarshal(m.Messages(soil.DataTopic())[1].Data, &env)
+	if ts, err := time.Parse(time.RFC3339Nano, env.TS); err != nil || !ts.Before(back) || env.Seq != 4 {
+		t.Errorf("replayed envelope got (%+v) want seq 4 taken offline", env)
+	}
+	if got := b.Stats(); got.Replayed != 3 {
+		t.Errorf("Stats() got (%+v) want 3 replayed", got)
+	}
+}
+
+func TestBufferStates(t *testing.T) {
+	m := NewMemMessanger()
+	b, _ := NewBuffer(m, BufferStates(true))
+	d := NewDevice("fan", "mqtt", WithMessanger(b))
+	m.Disconnect()
+	if err := d.PubState(1); err != nil {
+		t.Fatalf("PubState() error (%v) want it buffered", err)
+	}
+	m.Connect()
+	waitFor(t, func() bool { return b.Stats().Replayed == 1 })
+	if vals, replayed := values(t, m, d.StateTopic()); len(vals) != 1 || vals[0] != "1" || !replayed[0] {
+		t.Errorf("state got (%v, %v) want 1 replayed", vals, replayed)
+	}
+}
+
+func TestBufferEncoders(t *testing.T) {
+	for _, enc := range []Encoder{JSON, Protobuf, CBOR, CBOREncoder{Compact: true}} {
+		m := NewMemMessanger()
+		b, _ := NewBuffer(m)
+		d := NewDevice("bme280", "mqtt", WithMessanger(b), WithEncoder(enc))
+		m.Disconnect()
+		d.PubData(Reading{Values: map[string]float64{"temperature": 20.5}})
+		m.Connect()
+		waitFor(t, func() bool { return b.Stats().Replayed == 1 })
+
+		msg := m.Messages(d.DataTopic() + enc.Suffix())[0]
+		var r Reading
+		env, err := Decode(msg.Topic, msg.Data, &r)
+		if err != nil || env == nil || !env.Replay || r.Values["temperature"] != 20.5 {
+			t.Errorf("%T replayed got (%+v, %+v, %v)", enc, env, r, err)
+		}
+	}
+
+	m := NewMemMessanger()
+	b, _ := NewBuffer(m)
+	d := NewDevice("bme280", "mqtt", WithMessanger(b), WithEncoder(Line))
+	m.Disconnect()
+	d.PubData(20.5)
+	m.Connect()
+	waitFor(t, func() bool { return b.Stats().Replayed == 1 })
+	if line := m.Messages("#")[len(m.Messages("#"))-1].Data; !bytes.Contains(line, []byte(" value=20.5,replay=true ")) {
+		t.Errorf("line replayed got (%s)", line)
+	}
+}
+
+func TestBufferFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	m := NewMemMessanger()
+	b, err := NewBuffer(m, BufferSize(2), BufferFile(path, 4))
+	if err != nil {
+		t.Fatalf("NewBuffer() error (%v)", err)
+	}
+	d := NewDevice("soil", "mqtt", WithMessanger(b))
+	m.Disconnect()
+	for i := 1; i <= 20; i++ {
+		d.PubData(i)
+		if lines := bytes.Count(readFile(t, path), []byte("\n")); lines > 8 {
+			t.Fatalf("file got %d lines want it compacted", lines)
+		}
+	}
+	want := BufferStats{Buffered: 20, Dropped: 14, Pending: 6}
+	if got := b.Stats(); got != want {
+		t.Errorf("Stats() got (%+v) want (%+v)", got, want)
+	}
+
+	// a restart takes them from the file, the ones in memory spilled
+	b.Close()
+	m = NewMemMessanger()
+	b, err = NewBuffer(m, BufferSize(2), BufferFile(path, 4))
+	if err != nil {
+		t.Fatalf("NewBuffer() again error (%v)", err)
+	}
+	if got := b.Stats(); got.Pending != 4 {
+		t.Errorf("Stats() after restart got (%+v) want 4 pending", got)
+	}
+	m.Connect()
+	waitFor(t, func() bool { return b.Stats().Pending == 0 })
+	vals, _ := values(t, m, d.DataTopic())
+	var got []int
+	for _, v := range vals {
+		n, _ := strconv.Atoi(v)
+		got = append(got, n)
+	}
+	if len(got) != 4 || got[0] != 17 || got[3] != 20 {
+		t.Errorf("replayed got (%v) want 17 to 20", got)
+	}
+	if data := readFile(t, path); len(data) != 0 {
+		t.Errorf("file after replay got (%s) want it empty", data)
+	}
+	b.Close()
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -4,8 +4,8 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/rustyeddy/otto/device"
-	"github.com/rustyeddy/otto/device/drivers"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
 	"github.com/warthog618/go-gpiocdev"
 )
 
@@ -24,6 +24,7 @@ func New(name string, offset int, opts ...gpiocdev.LineReqOption) *Button {
 	var evtQ chan gpiocdev.LineEvent
 	evtQ = make(chan gpiocdev.LineEvent)
 	bopts := []gpiocdev.LineReqOption{
+		drivers.WithOwner("button"),
 		gpiocdev.WithPullUp,
 		gpiocdev.WithDebounce(10 * time.Millisecond),
 		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
@@ -53,3 +54,8 @@ func (b *Button) ReadPub() {
 	slog.Debug("read", "device", "button", "val", val)
 	b.PubData(val)
 }
+
+// Close releases the pin used by the button
+func (b *Button) Close() error {
+	return b.DigitalPin.Close()
+}
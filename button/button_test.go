@@ -1,58 +1,30 @@
 package button
 
 import (
-	"strconv"
-	"sync"
 	"testing"
 	"time"
 
 	"github.com/rustyeddy/otto-devices"
-)
-
-var (
-	gotit [2]bool
-	wg    sync.WaitGroup
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
 )
 
 func TestButton(t *testing.T) {
-	device.Mock(true)
-	done := make(chan any)
-
-	// c := messanger.GetMockClient()
-	// m := messanger.SetMQTTClient(c)
-	// err := m.Connect()
-	// if err != nil {
-	// 	t.Error("Failed to connect to MQTT broker: ", err)
-	// }
-	NewMessanger("local", "button")
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	c := devicetest.Use(t)
 
 	b := New("button", 23)
-	//b.SetTopic(messanger.GetTopics().Control("button"))
-	// b.Subscribe(messanger.GetTopics().Control("button"), b.MsgHandler)
+	done := make(chan any)
 	go b.EventLoop(done, b.ReadPub)
-
-	wg.Add(2)
-	b.MockHWInput(0)
-	b.MockHWInput(1)
-
-	wg.Wait()
-	time.Sleep(10 * time.Millisecond)
-	b.Close()
-	done <- true
-
-	if !gotit[0] || !gotit[1] {
-		t.Errorf("failed to get 0 and 1 got (%t) and (%t)", gotit[0], gotit[1])
-	}
-
-}
-
-func (b *Button) MsgHandler(msg *messanger.Msg) {
-	i, err := strconv.Atoi(msg.String())
-	if err != nil {
-		println("button return error")
-		return
-	}
-	gotit[i] = true
-	wg.Done()
-	return
+	defer close(done)
+	defer b.Close()
+
+	// each edge publishes the value of the button
+	line := chip.Line(23)
+	line.Edge(0)
+	c.ExpectPublish(b.DataTopic(), devicetest.Data(0), time.Second)
+	line.Edge(1)
+	c.ExpectPublish(b.DataTopic(), devicetest.Data(1), time.Second)
 }
@@ -0,0 +1,104 @@
+package button
+
+import "time"
+
+// Gesture is a classified press of a button
+type Gesture string
+
+const (
+	Click       Gesture = "click"
+	DoubleClick Gesture = "double_click"
+	LongPress   Gesture = "long_press"
+)
+
+type classifyState int
+
+const (
+	idle       classifyState = iota
+	pressed                  // first press, could still be any gesture
+	released                 // short press, waiting for a second one
+	pressedTwo               // second press within the gap
+	held                     // long press reported, waiting for release
+)
+
+// classifier turns presses and releases into gestures. A press held
+// for hold is a long press, reported while the button is still down.
+// A short press is a click once gap passes without a second press, a
+// second press within gap makes a double click when it is released.
+// With a gap of 0 double clicks are off and clicks are reported on
+// release.
+type classifier struct {
+	gap  time.Duration
+	hold time.Duration
+
+	state     classifyState
+	pressedAt time.Time
+	releaseAt time.Time
+}
+
+// press records the button going down at t
+func (c *classifier) press(t time.Time) []Gesture {
+	gs := c.tick(t)
+	switch c.state {
+	case idle:
+		c.state, c.pressedAt = pressed, t
+	case released:
+		c.state, c.pressedAt = pressedTwo, t
+	}
+	return gs
+}
+
+// release records the button coming up at t
+func (c *classifier) release(t time.Time) []Gesture {
+	gs := c.tick(t)
+	switch c.state {
+	case pressed:
+		if c.gap <= 0 {
+			c.state = idle
+			return append(gs, Click)
+		}
+		c.state, c.releaseAt = released, t
+	case pressedTwo:
+		c.state = idle
+		gs = append(gs, DoubleClick)
+	case held:
+		c.state = idle
+	}
+	return gs
+}
+
+// tick reports the gestures whose time has come by t
+func (c *classifier) tick(t time.Time) []Gesture {
+	switch c.state {
+	case pressed:
+		if t.Sub(c.pressedAt) >= c.hold {
+			c.state = held
+			return []Gesture{LongPress}
+		}
+	case released:
+		if t.Sub(c.releaseAt) >= c.gap {
+			c.state = idle
+			return []Gesture{Click}
+		}
+	case pressedTwo:
+		// the second press turned into a long press, the first
+		// one was a click after all
+		if t.Sub(c.pressedAt) >= c.hold {
+			c.state = held
+			return []Gesture{Click, LongPress}
+		}
+	}
+	return nil
+}
+
+// deadline returns when tick has to be called next, false if the
+// classifier is waiting for the button
+func (c *classifier) deadline() (time.Time, bool) {
+	switch c.state {
+	case pressed, pressedTwo:
+		return c.pressedAt.Add(c.hold), true
+	case released:
+		return c.releaseAt.Add(c.gap), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,187 @@
+package button
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultDoubleClickGap is the longest release between the two
+	// presses of a double click
+	DefaultDoubleClickGap = 300 * time.Millisecond
+
+	// DefaultHoldTime is how long a press has to be held to be a
+	// long press
+	DefaultHoldTime = 800 * time.Millisecond
+)
+
+// GestureEvent is published for every gesture
+type GestureEvent struct {
+	Event Gesture `json:"event"`
+}
+
+// GestureButton is a momentary button that reports clicks, double
+// clicks and long presses instead of bare edges. Every gesture is
+// published and handed to the functions bound to it, a bound
+// function can switch a relay without a round trip through the
+// broker.
+type GestureButton struct {
+	*device.Device
+
+	pin      *drivers.DigitalPin
+	cls      classifier
+	timer    *device.Timer
+	bindings map[Gesture][]func()
+
+	mu sync.Mutex
+}
+
+// NewGesture creates a button on the line at offset of the default
+// chip. It is wired active low to ground with the pull up and
+// debounced by 10ms, opts are applied after that so
+// gpiocdev.AsActiveHigh and gpiocdev.WithPullDown wire it to the
+// supply instead.
+func NewGesture(name string, offset int, opts ...gpiocdev.LineReqOption) (*GestureButton, error) {
+	b := &GestureButton{
+		Device: device.NewDevice(name, "mqtt"),
+		cls: classifier{
+			gap:  DefaultDoubleClickGap,
+			hold: DefaultHoldTime,
+		},
+		bindings: make(map[Gesture][]func()),
+	}
+	if device.IsMock() {
+		return b, nil
+	}
+
+	bopts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("button"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithDebounce(10 * time.Millisecond),
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(b.edge),
+	}, opts...)
+	pin, err := drivers.GetGPIO().Request(name, offset, bopts...)
+	if err != nil {
+		return nil, err
+	}
+	b.pin = pin
+	return b, nil
+}
+
+// Name returns the name of the device
+func (b *GestureButton) Name() string {
+	return b.Device.Name
+}
+
+// SetDoubleClickGap sets the longest release between the presses of
+// a double click, 0 turns double clicks off so clicks are reported
+// without waiting for a second press
+func (b *GestureButton) SetDoubleClickGap(gap time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cls.gap = gap
+}
+
+// SetHoldTime sets how long a press has to be held to be a long press
+func (b *GestureButton) SetHoldTime(hold time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cls.hold = hold
+}
+
+// Bind calls fn for every gesture g, in the order of binding
+func (b *GestureButton) Bind(g Gesture, fn func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bindings[g] = append(b.bindings[g], fn)
+}
+
+// MockPress presses (1) or releases (0) the button in mock mode
+func (b *GestureButton) MockPress(v int) {
+	b.handle(v == 1, b.Now())
+}
+
+// Close stops the gesture timer and releases the line
+func (b *GestureButton) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.pin == nil {
+		return nil
+	}
+	err := b.pin.Close()
+	b.pin = nil
+	return err
+}
+
+// edge is called with the debounced edges, rising is a press as the
+// events follow the active level
+func (b *GestureButton) edge(evt gpiocdev.LineEvent) {
+	b.handle(evt.Type == gpiocdev.LineEventRisingEdge, b.Now())
+}
+
+func (b *GestureButton) handle(down bool, t time.Time) {
+	b.mu.Lock()
+	var gs []Gesture
+	if down {
+		gs = b.cls.press(t)
+	} else {
+		gs = b.cls.release(t)
+	}
+	b.schedule(t)
+	b.mu.Unlock()
+	b.report(gs)
+}
+
+// expire reports the gestures that are due without an edge, a long
+// press while the button is still held or a click without a second
+// press
+func (b *GestureButton) expire() {
+	b.mu.Lock()
+	t := b.Now()
+	gs := b.cls.tick(t)
+	b.schedule(t)
+	b.mu.Unlock()
+	b.report(gs)
+}
+
+// schedule arms the timer for the classifier's next deadline, b.mu
+// is held
+func (b *GestureButton) schedule(t time.Time) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if d, ok := b.cls.deadline(); ok {
+		b.timer = b.Clock().AfterFunc(d.Sub(t), b.expire)
+	}
+}
+
+// report publishes the gestures and calls their bindings
+func (b *GestureButton) report(gs []Gesture) {
+	for _, g := range gs {
+		slog.Debug("gesture", "device", b.Device.Name, "gesture", g)
+		if j, err := json.Marshal(&GestureEvent{Event: g}); err == nil {
+			b.PubData(j)
+		}
+
+		b.mu.Lock()
+		fns := append([]func(){}, b.bindings[g]...)
+		b.mu.Unlock()
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
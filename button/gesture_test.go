@@ -0,0 +1,153 @@
+package button
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// input is a press (+), release (-) or timer tick (.) at ms
+type input struct {
+	ms int
+	op byte
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		gap  int
+		in   []input
+		want []Gesture
+	}{
+		{"click", 300, []input{{0, '+'}, {80, '-'}, {379, '.'}, {380, '.'}}, []Gesture{Click}},
+		{"click waits for the gap", 300, []input{{0, '+'}, {80, '-'}, {379, '.'}}, nil},
+		{"double click", 300, []input{{0, '+'}, {80, '-'}, {200, '+'}, {260, '-'}}, []Gesture{DoubleClick}},
+		{"second press just too late", 300, []input{{0, '+'}, {80, '-'}, {380, '+'}, {450, '-'}, {750, '.'}},
+			[]Gesture{Click, Click}},
+		{"timer late for the first click", 300, []input{{0, '+'}, {80, '-'}, {500, '+'}, {560, '-'}, {860, '.'}},
+			[]Gesture{Click, Click}},
+		{"long press while held", 300, []input{{0, '+'}, {799, '.'}, {800, '.'}, {2000, '-'}}, []Gesture{LongPress}},
+		{"long press without a tick", 300, []input{{0, '+'}, {1200, '-'}, {1600, '.'}}, []Gesture{LongPress}},
+		{"short of a long press", 300, []input{{0, '+'}, {799, '-'}, {1099, '.'}, {1100, '.'}}, []Gesture{Click}},
+		{"second press held", 300, []input{{0, '+'}, {80, '-'}, {200, '+'}, {1000, '.'}, {1500, '-'}},
+			[]Gesture{Click, LongPress}},
+		{"second press held no tick", 300, []input{{0, '+'}, {80, '-'}, {200, '+'}, {1500, '-'}},
+			[]Gesture{Click, LongPress}},
+		{"long press then click", 300, []input{{0, '+'}, {800, '.'}, {900, '-'}, {1000, '+'}, {1050, '-'}, {1350, '.'}},
+			[]Gesture{LongPress, Click}},
+		{"triple click", 300, []input{{0, '+'}, {50, '-'}, {150, '+'}, {200, '-'}, {300, '+'}, {350, '-'}, {650, '.'}},
+			[]Gesture{DoubleClick, Click}},
+		{"two double clicks", 300, []input{{0, '+'}, {50, '-'}, {150, '+'}, {200, '-'}, {600, '+'}, {650, '-'}, {700, '+'}, {750, '-'}},
+			[]Gesture{DoubleClick, DoubleClick}},
+		{"double clicks off", 0, []input{{0, '+'}, {50, '-'}, {150, '+'}, {200, '-'}}, []Gesture{Click, Click}},
+		{"double clicks off long press", 0, []input{{0, '+'}, {800, '.'}, {850, '-'}}, []Gesture{LongPress}},
+		{"repeated press edge", 300, []input{{0, '+'}, {20, '+'}, {80, '-'}, {380, '.'}}, []Gesture{Click}},
+		{"release while idle", 300, []input{{0, '-'}, {500, '.'}}, nil},
+		{"tick while idle", 300, []input{{100, '.'}}, nil},
+	}
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := classifier{
+				gap:  time.Duration(tt.gap) * time.Millisecond,
+				hold: DefaultHoldTime,
+			}
+			var got []Gesture
+			for _, in := range tt.in {
+				at := base.Add(time.Duration(in.ms) * time.Millisecond)
+				switch in.op {
+				case '+':
+					got = append(got, c.press(at)...)
+				case '-':
+					got = append(got, c.release(at)...)
+				case '.':
+					got = append(got, c.tick(at)...)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got (%v) want (%v)", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyDeadline(t *testing.T) {
+	base := time.Now()
+	c := classifier{gap: 300 * time.Millisecond, hold: DefaultHoldTime}
+	if _, ok := c.deadline(); ok {
+		t.Error("idle classifier has a deadline")
+	}
+	c.press(base)
+	if d, ok := c.deadline(); !ok || d.Sub(base) != DefaultHoldTime {
+		t.Errorf("pressed deadline got (%v) want (%v)", d.Sub(base), DefaultHoldTime)
+	}
+	c.release(base.Add(100 * time.Millisecond))
+	if d, ok := c.deadline(); !ok || d.Sub(base) != 400*time.Millisecond {
+		t.Errorf("released deadline got (%v) want (400ms)", d.Sub(base))
+	}
+	c.tick(base.Add(800 * time.Millisecond))
+	if _, ok := c.deadline(); ok {
+		t.Error("deadline after the click")
+	}
+}
+
+func TestGestureButton(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	clock := devicetest.UseClock(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	b, err := NewGesture("gesture", 5)
+	if err != nil {
+		t.Fatalf("NewGesture() error = %v", err)
+	}
+	defer b.Close()
+	b.SetDoubleClickGap(300 * time.Millisecond)
+	b.SetHoldTime(600 * time.Millisecond)
+
+	var got []Gesture
+	for _, g := range []Gesture{Click, DoubleClick, LongPress} {
+		b.Bind(g, func() { got = append(got, g) })
+	}
+	expect := func(want ...Gesture) {
+		t.Helper()
+		if !slices.Equal(got, want) {
+			t.Fatalf("gestures got (%v) want (%v)", got, want)
+		}
+	}
+
+	line := chip.Line(5)
+	press := func(held time.Duration) {
+		line.Edge(1)
+		clock.Advance(held)
+		line.Edge(0)
+	}
+	press(50 * time.Millisecond)
+	clock.Advance(299 * time.Millisecond)
+	expect() // the click needs the gap to pass
+	clock.Advance(time.Millisecond)
+	expect(Click)
+
+	press(50 * time.Millisecond)
+	clock.Advance(100 * time.Millisecond)
+	press(50 * time.Millisecond)
+	expect(Click, DoubleClick)
+	clock.Advance(time.Second)
+	expect(Click, DoubleClick)
+
+	line.Edge(1)
+	clock.Advance(600 * time.Millisecond)
+	expect(Click, DoubleClick, LongPress) // the long press comes while held
+	line.Edge(0)
+	clock.Advance(time.Second)
+	expect(Click, DoubleClick, LongPress)
+
+	b.Close()
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("Waiters() after Close() got (%d) want no gesture timer", n)
+	}
+}
@@ -0,0 +1,385 @@
+// Package buzzer sounds an active buzzer, one with its own oscillator
+// switched on and off by a GPIO line, or a passive one, a piezo driven
+// at the frequency of a PWM channel.
+//
+// Beeps, tones and melodies play one at a time, starting one stops
+// the one playing. They can be canceled with their context or Stop.
+// An active buzzer has one pitch, it plays the rhythm of a melody.
+//
+// Alerts are named melodies other devices play through the buzzer in
+// the device manager with Alert.
+package buzzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultTempo is the quarter notes per minute of melodies
+	DefaultTempo = 120
+
+	// DefaultBeepFreq is the pitch of a beep on a passive buzzer,
+	// near the loudest of most small piezos
+	DefaultBeepFreq = 2000
+
+	// DefaultGap is the silence at the end of every note so repeated
+	// notes do not run together
+	DefaultGap = 10 * time.Millisecond
+)
+
+var (
+	ErrNoAlert  = errors.New("no such alert")
+	ErrNoBuzzer = errors.New("no such buzzer")
+	ErrCommand  = errors.New("unknown command")
+)
+
+// Alerts are the melodies Alert and the "alert" command play by name.
+// Add to them before the devices start.
+var Alerts = map[string]string{
+	"warning": "a5:8 r:8 a5:8 r:8 a5:8",
+	"error":   "e5:8 c5:8 a4:2",
+	"success": "c5:16 e5:16 g5:16 c6:4",
+}
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	Playing bool `json:"playing"`
+}
+
+// output sounds a tone, 0 is silence
+type output interface {
+	tone(hz float64) error
+	Close() error
+}
+
+// Buzzer is an active or passive buzzer
+type Buzzer struct {
+	*device.Device
+
+	// Tempo of melodies in quarter notes per minute, BeepFreq the
+	// pitch of Beep and Gap the silence ending every note
+	Tempo    int
+	BeepFreq float64
+	Gap      time.Duration
+
+	out     output
+	cancel  context.CancelFunc
+	playing bool
+	play    sync.Mutex // held while a melody plays
+
+	wait func(ctx context.Context, d time.Duration) error
+	mu   sync.Mutex
+}
+
+// NewActive creates an active buzzer on the line at offset of the
+// default chip, high sounds it
+func NewActive(name string, offset int) (*Buzzer, error) {
+	pin, err := drivers.GetGPIO().Request(name, offset,
+		drivers.WithOwner("buzzer"),
+		gpiocdev.AsOutput(0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return newBuzzer(name, &active{pin}), nil
+}
+
+// NewPassive creates a passive buzzer on channel of the pwm chip, a
+// kernel pwmchip or a controller registered with
+// drivers.RegisterPWMChip
+func NewPassive(name, chip string, channel int) (*Buzzer, error) {
+	if device.IsMock() {
+		return NewPassiveWithPWM(name, &mockPWM{}), nil
+	}
+	pwm, err := drivers.OpenPWM(chip, channel)
+	if err != nil {
+		return nil, err
+	}
+	return NewPassiveWithPWM(name, pwm), nil
+}
+
+// NewPassiveWithPWM creates a passive buzzer on pwm, like a
+// drivers.SoftPWM
+func NewPassiveWithPWM(name string, pwm drivers.PWMChannel) *Buzzer {
+	return newBuzzer(name, &passive{pwm: pwm})
+}
+
+func newBuzzer(name string, out output) *Buzzer {
+	return &Buzzer{
+		Device:   device.NewDevice(name, "mqtt"),
+		Tempo:    DefaultTempo,
+		BeepFreq: DefaultBeepFreq,
+		Gap:      DefaultGap,
+		out:      out,
+		wait:     wait,
+	}
+}
+
+// Name returns the name of the device
+func (b *Buzzer) Name() string {
+	return b.Device.Name
+}
+
+// Beep beeps count times, d on and d off
+func (b *Buzzer) Beep(ctx context.Context, count int, d time.Duration) error {
+	var notes []Note
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			notes = append(notes, Note{Dur: d})
+		}
+		notes = append(notes, Note{Freq: b.BeepFreq, Dur: d})
+	}
+	return b.Play(ctx, notes)
+}
+
+// Tone sounds hz for d
+func (b *Buzzer) Tone(ctx context.Context, hz float64, d time.Duration) error {
+	return b.Play(ctx, []Note{{Freq: hz, Dur: d}})
+}
+
+// PlayMelody plays a melody in the notation of ParseMelody at Tempo
+func (b *Buzzer) PlayMelody(ctx context.Context, melody string) error {
+	notes, err := ParseMelody(melody, b.Tempo)
+	if err != nil {
+		return err
+	}
+	return b.Play(ctx, notes)
+}
+
+// PlayAlert plays the named melody of Alerts
+func (b *Buzzer) PlayAlert(ctx context.Context, alert string) error {
+	melody, ok := Alerts[alert]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoAlert, alert)
+	}
+	return b.PlayMelody(ctx, melody)
+}
+
+// Play plays notes, stopping what is playing first. It returns when
+// the notes are done or with the ctx error when canceled or stopped.
+func (b *Buzzer) Play(ctx context.Context, notes []Note) error {
+	b.Stop()
+	b.play.Lock()
+	defer b.play.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b.mu.Lock()
+	b.cancel, b.playing = cancel, true
+	gap := b.Gap
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.cancel, b.playing = nil, false
+		b.mu.Unlock()
+	}()
+
+	err := b.notes(ctx, notes, gap)
+	return errors.Join(err, b.out.tone(0))
+}
+
+// notes sounds the notes, the last gap of a note is silent
+func (b *Buzzer) notes(ctx context.Context, notes []Note, gap time.Duration) error {
+	for _, n := range notes {
+		sound := n.Dur
+		if n.Freq > 0 && sound > gap {
+			sound -= gap
+		}
+		if err := b.out.tone(n.Freq); err != nil {
+			return err
+		}
+		if err := b.wait(ctx, sound); err != nil {
+			return err
+		}
+		if sound == n.Dur {
+			continue
+		}
+		if err := b.out.tone(0); err != nil {
+			return err
+		}
+		if err := b.wait(ctx, n.Dur-sound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop silences what is playing
+func (b *Buzzer) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// Command handles a command payload: "beep 3 100ms", "tone 440 1s",
+// "melody c4:8 e4:8 g4:4", "alert warning" or "stop". They play in
+// the background, a command returns once it is parsed.
+func (b *Buzzer) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	bad := fmt.Errorf("%w: %q", ErrCommand, payload)
+	if len(f) == 0 {
+		return bad
+	}
+	switch {
+	case f[0] == "beep" && len(f) == 3:
+		count, err1 := strconv.Atoi(f[1])
+		d, err2 := time.ParseDuration(f[2])
+		if err1 != nil || err2 != nil || count <= 0 || d <= 0 {
+			return bad
+		}
+		b.background(func(ctx context.Context) error { return b.Beep(ctx, count, d) })
+	case f[0] == "tone" && len(f) == 3:
+		hz, err1 := strconv.ParseFloat(f[1], 64)
+		d, err2 := time.ParseDuration(f[2])
+		if err1 != nil || err2 != nil || hz <= 0 || d <= 0 {
+			return bad
+		}
+		b.background(func(ctx context.Context) error { return b.Tone(ctx, hz, d) })
+	case f[0] == "melody" && len(f) > 1:
+		notes, err := ParseMelody(strings.Join(f[1:], " "), b.Tempo)
+		if err != nil {
+			return err
+		}
+		b.background(func(ctx context.Context) error { return b.Play(ctx, notes) })
+	case f[0] == "alert" && len(f) == 2:
+		if _, ok := Alerts[f[1]]; !ok {
+			return fmt.Errorf("%w: %s", ErrNoAlert, f[1])
+		}
+		b.background(func(ctx context.Context) error { return b.PlayAlert(ctx, f[1]) })
+	case f[0] == "stop" && len(f) == 1:
+		b.Stop()
+	default:
+		return bad
+	}
+	return nil
+}
+
+// background plays in a goroutine, being stopped is not an error
+func (b *Buzzer) background(play func(ctx context.Context) error) {
+	go func() {
+		if err := play(context.Background()); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Warn("buzzer", "device", b.Device.Name, "error", err)
+		}
+	}()
+}
+
+// Read returns whether the buzzer is playing
+func (b *Buzzer) Read() *Reading {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &Reading{Playing: b.playing}
+}
+
+// ReadPub publishes the reading
+func (b *Buzzer) ReadPub() error {
+	j, err := json.Marshal(b.Read())
+	if err != nil {
+		return err
+	}
+	b.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (b *Buzzer) Run(ctx context.Context, period time.Duration) error {
+	err := b.TimerLoop(ctx, period, b.ReadPub)
+	slog.Debug("buzzer stopped", "device", b.Device.Name, "error", err)
+	return err
+}
+
+// Close silences the buzzer and releases its line or channel
+func (b *Buzzer) Close() error {
+	b.Stop()
+	b.play.Lock()
+	defer b.play.Unlock()
+	return b.out.Close()
+}
+
+// Alert plays the named alert on the buzzer added to the device
+// manager as name, in the background. Other devices use it to sound
+// their alarms.
+func Alert(name, alert string) error {
+	d, ok := device.GetDeviceManager().Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoBuzzer, name)
+	}
+	b, ok := d.(*Buzzer)
+	if !ok {
+		return fmt.Errorf("%w: %s is not a buzzer", ErrNoBuzzer, name)
+	}
+	return b.Command([]byte("alert " + alert))
+}
+
+// active is a buzzer with its own oscillator on a gpio line
+type active struct {
+	pin *drivers.DigitalPin
+}
+
+func (a *active) tone(hz float64) error {
+	if hz > 0 {
+		return a.pin.Set(1)
+	}
+	return a.pin.Set(0)
+}
+
+func (a *active) Close() error {
+	return a.pin.Close()
+}
+
+// passive is a piezo driven at half duty, the frequency is only set
+// when it changes
+type passive struct {
+	pwm  drivers.PWMChannel
+	freq float64
+}
+
+func (p *passive) tone(hz float64) error {
+	if hz <= 0 {
+		return p.pwm.SetDuty(0)
+	}
+	if hz != p.freq {
+		if err := p.pwm.SetFrequency(hz); err != nil {
+			return err
+		}
+		p.freq = hz
+	}
+	return p.pwm.SetDuty(0.5)
+}
+
+func (p *passive) Close() error {
+	return errors.Join(p.pwm.SetDuty(0), p.pwm.Close())
+}
+
+// mockPWM is the pwm channel of a mock passive buzzer
+type mockPWM struct{}
+
+func (*mockPWM) SetFrequency(float64) error { return nil }
+func (*mockPWM) SetDuty(float64) error      { return nil }
+func (*mockPWM) Close() error               { return nil }
+
+// wait waits d or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
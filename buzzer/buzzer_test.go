@@ -0,0 +1,320 @@
+package buzzer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// event is a tone the fake output sounded at a time of the simulated
+// clock
+type event struct {
+	hz float64
+	at time.Duration
+}
+
+// recorder is an output keeping the tones it sounds against the clock
+// the test's wait advances
+type recorder struct {
+	events []event
+	now    time.Duration
+	mu     sync.Mutex
+}
+
+func (r *recorder) tone(hz float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event{hz, r.now})
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func (r *recorder) wait(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.now += d
+	r.mu.Unlock()
+	return nil
+}
+
+func newTestBuzzer() (*Buzzer, *recorder) {
+	r := &recorder{}
+	b := newBuzzer("buzzer", r)
+	b.wait = r.wait
+	return b, r
+}
+
+func TestFrequency(t *testing.T) {
+	tests := []struct {
+		note string
+		want float64
+	}{
+		{"a4", 440},
+		{"c4", 261.626},
+		{"c#4", 277.183},
+		{"db4", 277.183},
+		{"e4", 329.628},
+		{"g4", 391.995},
+		{"b3", 246.942},
+		{"bb3", 233.082},
+		{"c5", 523.251},
+		{"a0", 27.5},
+		{"c8", 4186.009},
+	}
+	for _, tt := range tests {
+		notes, err := ParseMelody(tt.note, DefaultTempo)
+		if err != nil {
+			t.Fatalf("ParseMelody(%s) error = %v", tt.note, err)
+		}
+		if got := notes[0].Freq; math.Abs(got-tt.want) > 1e-3 {
+			t.Errorf("%s got (%vHz) want (%vHz)", tt.note, got, tt.want)
+		}
+	}
+}
+
+func TestParseMelody(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		melody string
+		tempo  int
+		want   []Note
+	}{
+		{"c4:8 e4:8 g4:4", 120, []Note{{261.626, 250 * ms}, {329.628, 250 * ms}, {391.995, 500 * ms}}},
+		{"a4:1 a4:2 a4 a4:16", 120, []Note{{440, 2000 * ms}, {440, 1000 * ms}, {440, 500 * ms}, {440, 125 * ms}}},
+		{"r:4 a4:4. r:8.", 60, []Note{{0, 1000 * ms}, {440, 1500 * ms}, {0, 750 * ms}}},
+		{"  A4:4   C5:8 ", 240, []Note{{440, 250 * ms}, {523.251, 125 * ms}}},
+		{"", 120, nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseMelody(tt.melody, tt.tempo)
+		if err != nil {
+			t.Errorf("ParseMelody(%q) error = %v", tt.melody, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseMelody(%q) got (%v) want (%v)", tt.melody, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i].Freq-tt.want[i].Freq) > 1e-3 || got[i].Dur != tt.want[i].Dur {
+				t.Errorf("ParseMelody(%q) note %d got (%v) want (%v)", tt.melody, i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	for _, bad := range []string{"h4", "c", "c#", "c9", "c4:0", "c4:3x", "c4:", "x:4", "c4 e", "r4"} {
+		if _, err := ParseMelody(bad, 120); !errors.Is(err, ErrNotation) {
+			t.Errorf("ParseMelody(%q) error got (%v) want (%v)", bad, err, ErrNotation)
+		}
+	}
+	if _, err := ParseMelody("c4", 0); !errors.Is(err, ErrNotation) {
+		t.Errorf("tempo 0 error got (%v) want (%v)", err, ErrNotation)
+	}
+	for name, melody := range Alerts {
+		if _, err := ParseMelody(melody, DefaultTempo); err != nil {
+			t.Errorf("alert %s error = %v", name, err)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	ms := time.Millisecond
+	b, r := newTestBuzzer()
+
+	if err := b.PlayMelody(context.Background(), "c4:8 r:8 a4:4"); err != nil {
+		t.Fatalf("PlayMelody() error = %v", err)
+	}
+	want := []event{
+		{261.626, 0}, {0, 240 * ms}, // the gap closes the note
+		{0, 250 * ms},
+		{440, 500 * ms}, {0, 990 * ms},
+		{0, 1000 * ms}, // silent at the end
+	}
+	check := func(name string, want []event) {
+		t.Helper()
+		if len(r.events) != len(want) {
+			t.Fatalf("%s got (%v) want (%v)", name, r.events, want)
+		}
+		for i, e := range r.events {
+			if math.Abs(e.hz-want[i].hz) > 1e-3 || e.at != want[i].at {
+				t.Errorf("%s event %d got (%v) want (%v)", name, i, e, want[i])
+			}
+		}
+	}
+	check("melody", want)
+
+	r.events, r.now = nil, 0
+	b.BeepFreq = 3000
+	b.Beep(context.Background(), 2, 100*ms)
+	check("beeps", []event{
+		{3000, 0}, {0, 90 * ms},
+		{0, 100 * ms},
+		{3000, 200 * ms}, {0, 290 * ms},
+		{0, 300 * ms},
+	})
+
+	r.events, r.now = nil, 0
+	b.Gap = 0
+	b.Tone(context.Background(), 880, time.Second)
+	check("tone", []event{{880, 0}, {0, time.Second}})
+}
+
+func TestCancel(t *testing.T) {
+	pwm := driverstest.NewPWM()
+	b := NewPassiveWithPWM("buzzer", pwm)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := b.Tone(ctx, 440, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Tone() error got (%v) want (%v)", err, context.DeadlineExceeded)
+	}
+	if pwm.Freq != 440 || pwm.Duty != 0 {
+		t.Errorf("after the tone got (%vHz, duty %v) want (440Hz, silent)", pwm.Freq, pwm.Duty)
+	}
+
+	// a new melody stops the one playing
+	done := make(chan error)
+	go func() { done <- b.PlayMelody(context.Background(), "c4:1 c4:1 c4:1") }()
+	time.Sleep(20 * time.Millisecond)
+	if !b.Read().Playing {
+		t.Error("Read() got (not playing) want playing")
+	}
+	go b.Tone(context.Background(), 1000, 10*time.Millisecond)
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("replaced melody error got (%v) want (%v)", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the melody did not stop")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if b.Read().Playing || pwm.Duty != 0 {
+		t.Errorf("after the tone got (playing %v, duty %v) want silent", b.Read().Playing, pwm.Duty)
+	}
+	b.Close()
+}
+
+func TestActive(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	b, err := NewActive("buzzer", 12)
+	if err != nil {
+		t.Fatalf("NewActive() error = %v", err)
+	}
+	b.Gap = 0
+	b.wait = func(context.Context, time.Duration) error { return nil }
+	b.PlayMelody(context.Background(), "c4 r g4 a4")
+	line := chip.Line(12)
+	want := []int{1, 0, 1, 1, 0}
+	if len(line.Values) != len(want) {
+		t.Fatalf("line got (%v) want (%v)", line.Values, want)
+	}
+	for i := range want {
+		if line.Values[i] != want[i] {
+			t.Errorf("line got (%v) want (%v)", line.Values, want)
+			break
+		}
+	}
+	b.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	b, r := newTestBuzzer()
+	played := func() int {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			r.mu.Lock()
+			n := len(r.events)
+			r.mu.Unlock()
+			if n > 0 && !b.Read().Playing {
+				return n
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("nothing played")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for payload, events := range map[string]int{
+		"beep 3 100ms":         9,
+		"tone 440 1s":          3,
+		"melody c4:8 e4:8 g4":  7,
+		"Alert success":        9,
+		"alert warning":        9,
+		"melody r:4 r:4 a#4:4": 5,
+	} {
+		r.mu.Lock()
+		r.events = nil
+		r.mu.Unlock()
+		if err := b.Command([]byte(payload)); err != nil {
+			t.Errorf("Command(%q) error = %v", payload, err)
+			continue
+		}
+		if n := played(); n != events {
+			t.Errorf("Command(%q) got (%d) tones want (%d)", payload, n, events)
+		}
+	}
+
+	if err := b.Command([]byte("melody c4 h4")); !errors.Is(err, ErrNotation) {
+		t.Errorf("bad melody error got (%v) want (%v)", err, ErrNotation)
+	}
+	if err := b.Command([]byte("alert fire")); !errors.Is(err, ErrNoAlert) {
+		t.Errorf("unknown alert error got (%v) want (%v)", err, ErrNoAlert)
+	}
+	for _, bad := range []string{"", "beep", "beep 0 1s", "beep 3 loud", "tone 440", "tone -1 1s", "melody", "sing"} {
+		if err := b.Command([]byte(bad)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", bad, err, ErrCommand)
+		}
+	}
+}
+
+func TestAlert(t *testing.T) {
+	b, r := newTestBuzzer()
+	dm := device.GetDeviceManager()
+	dm.Add(b)
+	defer dm.Remove("buzzer")
+
+	if err := Alert("buzzer", "error"); err != nil {
+		t.Fatalf("Alert() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		n := len(r.events)
+		r.mu.Unlock()
+		if n == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Alert() played (%d) tones want (7)", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := Alert("siren", "error"); !errors.Is(err, ErrNoBuzzer) {
+		t.Errorf("Alert() on no device error got (%v) want (%v)", err, ErrNoBuzzer)
+	}
+	if err := Alert("buzzer", "fire"); !errors.Is(err, ErrNoAlert) {
+		t.Errorf("Alert() of no alert error got (%v) want (%v)", err, ErrNoAlert)
+	}
+}
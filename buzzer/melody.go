@@ -0,0 +1,98 @@
+package buzzer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotation is returned for a melody that does not parse
+var ErrNotation = errors.New("bad melody notation")
+
+// Note is a tone of Freq hertz for Dur, a Freq of 0 is a rest
+type Note struct {
+	Freq float64
+	Dur  time.Duration
+}
+
+// semitones of the note names above C
+var semitones = map[byte]int{'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11}
+
+// ParseMelody parses a melody of space separated notes at tempo
+// quarter notes per minute. A note is a name, c to b, an optional
+// sharp "#" or flat "b", an octave and a length after a colon: 1 a
+// whole note, 2 a half, 4 a quarter and so on, a trailing "." dots it.
+// "r" is a rest. Without a length a note is a quarter, "c4:8 e4:8
+// g4:4 r:2 c5:2." for example. A4 is 440Hz.
+func ParseMelody(melody string, tempo int) ([]Note, error) {
+	if tempo <= 0 {
+		return nil, fmt.Errorf("%w: tempo %d", ErrNotation, tempo)
+	}
+	var notes []Note
+	for _, tok := range strings.Fields(strings.ToLower(melody)) {
+		n, err := parseNote(tok, tempo)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
+
+func parseNote(tok string, tempo int) (Note, error) {
+	bad := fmt.Errorf("%w: %q", ErrNotation, tok)
+	pitch, length, colon := strings.Cut(tok, ":")
+	if colon && length == "" {
+		return Note{}, bad
+	}
+
+	var n Note
+	if pitch != "r" {
+		if len(pitch) < 2 {
+			return n, bad
+		}
+		semi, ok := semitones[pitch[0]]
+		if !ok {
+			return n, bad
+		}
+		pitch = pitch[1:]
+		switch pitch[0] {
+		case '#':
+			semi++
+			pitch = pitch[1:]
+		case 'b':
+			semi--
+			pitch = pitch[1:]
+		}
+		octave, err := strconv.Atoi(pitch)
+		if err != nil || octave < 0 || octave > 8 {
+			return n, bad
+		}
+		n.Freq = Frequency(12*(octave+1) + semi)
+	}
+
+	dotted := strings.HasSuffix(length, ".")
+	length = strings.TrimSuffix(length, ".")
+	div := 4
+	if length != "" {
+		var err error
+		div, err = strconv.Atoi(length)
+		if err != nil || div <= 0 || div > 64 {
+			return n, bad
+		}
+	}
+	// a whole note is four beats
+	n.Dur = 4 * time.Minute / time.Duration(tempo*div)
+	if dotted {
+		n.Dur += n.Dur / 2
+	}
+	return n, nil
+}
+
+// Frequency returns the frequency of the MIDI note number, 69 is A4
+func Frequency(midi int) float64 {
+	return 440 * math.Pow(2, float64(midi-69)/12)
+}
@@ -0,0 +1,220 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORSuffix ends the topics of the CBOR payloads
+const CBORSuffix = "/cbor"
+
+// CBORFloats is how a CBOREncoder encodes the floats of a payload
+type CBORFloats int
+
+const (
+	// CBORFloat64 encodes the floats as they are, in 9 bytes each
+	CBORFloat64 CBORFloats = iota
+
+	// CBORShortest encodes a float in the fewest of 16, 32 or 64 bits
+	// that keep its value exactly, 21.5 in 3 bytes but 21.46 in 9
+	CBORShortest
+
+	// CBORFloat32 shrinks the floats to 32 bits, 7 significant digits
+	// like the protobuf readings. The values decoded are not the ones
+	// published, 21.46 comes back as 21.459999084472656.
+	CBORFloat32
+)
+
+// CBOREncoder encodes the payloads in CBOR on topics ending in /cbor.
+// A payload is the value the JSONEncoder publishes, maps by the same
+// field names and numbers of the same values unless the Floats shrink
+// them, so it decodes to the same JSON. Compact keys the maps by the
+// index of the names in CBORKeys, the names not there go as they are.
+type CBOREncoder struct {
+	Compact bool
+	Floats  CBORFloats
+}
+
+// CBOR is the CBOREncoder with the field names and the floats as they are
+var CBOR Encoder = CBOREncoder{}
+
+// CBORKeys are the field names a compact CBOREncoder sends as the
+// integer of their index, the key map a consumer decodes them with.
+// Append only, the index is the key on the wire.
+var CBORKeys = []string{
+	"schema", "ts", "device", "seq", "data",
+	"values", "units", "on", "level", "message", "state", "error",
+	"temperature", "humidity", "pressure", "light", "co2", "tvoc",
+	"pm1", "pm25", "pm10", "moisture", "voltage", "current", "power",
+	"replay",
+}
+
+var (
+	cborKeyIndex = func() map[string]int {
+		idx := make(map[string]int, len(CBORKeys))
+		for i, k := range CBORKeys {
+			idx[k] = i
+		}
+		return idx
+	}()
+
+	// the map keys sorted so a payload encodes the same every time
+	cborExact    = encMode(cbor.ShortestFloatNone)
+	cborShortest = encMode(cbor.ShortestFloat16)
+)
+
+func encMode(sf cbor.ShortestFloatMode) cbor.EncMode {
+	em, err := cbor.EncOptions{Sort: cbor.SortCanonical, ShortestFloat: sf}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}
+
+// Encode encodes data as the JSONEncoder does, wrapped in env when it
+// is not nil, and the JSON made in CBOR. Bytes that are not JSON go as
+// a CBOR byte string.
+func (e CBOREncoder) Encode(data any, env *Envelope) ([]byte, error) {
+	payload, err := JSON.Encode(data, env)
+	if err != nil {
+		return nil, err
+	}
+	v, err := fromJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+	em := cborExact
+	if e.Floats == CBORShortest {
+		em = cborShortest
+	}
+	b, err := em.Marshal(e.shape(v))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	return b, nil
+}
+
+// Suffix is CBORSuffix
+func (CBOREncoder) Suffix() string {
+	return CBORSuffix
+}
+
+// fromJSON returns payload as the values it is JSON of, the integers as
+// int64 or uint64 and the other numbers float64. A payload that is not
+// JSON is returned as it is.
+func fromJSON(payload []byte) (any, error) {
+	if !json.Valid(payload) {
+		return payload, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	return numbers(v), nil
+}
+
+func numbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// shape keys the maps of v by CBORKeys when the encoder is compact and
+// shrinks the floats when it does
+func (e CBOREncoder) shape(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if !e.Compact {
+			for k, x := range v {
+				v[k] = e.shape(x)
+			}
+			return v
+		}
+		m := make(map[any]any, len(v))
+		for k, x := range v {
+			if i, ok := cborKeyIndex[k]; ok {
+				m[i] = e.shape(x)
+			} else {
+				m[k] = e.shape(x)
+			}
+		}
+		return m
+	case []any:
+		for i, x := range v {
+			v[i] = e.shape(x)
+		}
+	case float64:
+		if e.Floats == CBORFloat32 {
+			return float32(v)
+		}
+	}
+	return v
+}
+
+// decodeCBOR decodes a CBOR payload into v, through the JSON it was
+// made of
+func decodeCBOR(payload []byte, v any) (*Envelope, error) {
+	var x any
+	if err := cbor.Unmarshal(payload, &x); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	if b, ok := x.([]byte); ok {
+		return decodeJSON(b, v)
+	}
+	js, err := json.Marshal(cborNamed(x))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	return decodeJSON(js, v)
+}
+
+// cborNamed keys the maps of v by name, the integer keys by CBORKeys
+func cborNamed(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, x := range v {
+			switch k := k.(type) {
+			case string:
+				m[k] = cborNamed(x)
+			case uint64:
+				if k < uint64(len(CBORKeys)) {
+					m[CBORKeys[k]] = cborNamed(x)
+				} else {
+					m[strconv.FormatUint(k, 10)] = cborNamed(x)
+				}
+			default:
+				m[fmt.Sprint(k)] = cborNamed(x)
+			}
+		}
+		return m
+	case []any:
+		for i, x := range v {
+			v[i] = cborNamed(x)
+		}
+	}
+	return v
+}
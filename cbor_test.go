@@ -0,0 +1,174 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCBOREncoder(t *testing.T) {
+	payloads := []any{
+		Reading{Values: map[string]float64{"temperature": 21.46, "humidity": 40, "lux": 1e5}, Units: map[string]string{"temperature": "C"}},
+		&BinaryState{On: true},
+		Alert{Level: "warning", Message: "too hot"},
+		Status{State: "error", Error: "no reply"},
+		[]byte(`{"temperature":"77.15","humidity":"40.20","pressure":"1013.25"}`),
+		"on",
+		21.456,
+		-3,
+		uint64(math.MaxUint64),
+		[]byte{0xff, 0x00},
+	}
+	env := &Envelope{Schema: SchemaVersion, TS: "2026-10-15T12:00:00.5Z", Device: "bme280", Seq: 42}
+
+	for _, enc := range []CBOREncoder{{}, {Compact: true}, {Floats: CBORShortest}} {
+		for _, e := range []*Envelope{nil, env} {
+			for _, data := range payloads {
+				js, err := JSON.Encode(data, e)
+				if err != nil {
+					t.Fatalf("JSON.Encode(%v) error (%v)", data, err)
+				}
+				cb, err := enc.Encode(data, e)
+				if err != nil {
+					t.Fatalf("%+v.Encode(%v) error (%v)", enc, data, err)
+				}
+
+				var want, got []byte
+				wenv, _ := Decode("ss/d/station/bme280", js, &want)
+				genv, err := Decode("ss/d/station/bme280"+enc.Suffix(), cb, &got)
+				if err != nil {
+					t.Errorf("%+v Decode(%v) error (%v)", enc, data, err)
+				}
+				if genv != nil && wenv != nil {
+					// the data is compared below, the keys of its maps sorted
+					genv.Data, wenv.Data = nil, nil
+				}
+				if !reflect.DeepEqual(genv, wenv) {
+					t.Errorf("%+v Decode(%v) envelope got (%+v) want (%+v)", enc, data, genv, wenv)
+				}
+				if !sameJSON(got, want) {
+					t.Errorf("%+v Decode(%v) got (%s) want (%s)", enc, data, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCBORTyped(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("bme280", "mqtt", WithMessanger(m), WithEncoder(CBOREncoder{Compact: true}))
+
+	reading := Reading{Values: map[string]float64{"temperature": 21.46, "humidity": 40}, Units: map[string]string{"temperature": "C"}}
+	d.PubData(reading)
+	d.PubState(BinaryState{On: true})
+
+	msgs := m.Messages("#")
+	if len(msgs) != 2 {
+		t.Fatalf("published got (%v) want 2", msgs)
+	}
+	if !strings.HasSuffix(msgs[0].Topic, CBORSuffix) {
+		t.Errorf("topic got (%s) want suffix (%s)", msgs[0].Topic, CBORSuffix)
+	}
+	var got Reading
+	env, err := Decode(msgs[0].Topic, msgs[0].Data, &got)
+	if err != nil || !reflect.DeepEqual(got, reading) {
+		t.Errorf("Decode(reading) got (%+v, %v) want (%+v)", got, err, reading)
+	}
+	if env == nil || env.Device != "bme280" || env.Seq != 1 {
+		t.Errorf("Decode(reading) envelope got (%+v)", env)
+	}
+	var state BinaryState
+	if _, err := Decode(msgs[1].Topic, msgs[1].Data, &state); err != nil || !state.On {
+		t.Errorf("Decode(state) got (%+v, %v)", state, err)
+	}
+	if _, err := Decode(msgs[1].Topic, []byte{0xff}, &state); err == nil {
+		t.Errorf("Decode(bad cbor) error got (nil)")
+	}
+}
+
+func TestCBORKeys(t *testing.T) {
+	reading := Reading{Values: map[string]float64{"temperature": 21.5, "lux": 300}}
+	names, _ := CBOR.Encode(reading, nil)
+	compact, _ := CBOREncoder{Compact: true}.Encode(reading, nil)
+
+	if !bytes.Contains(names, []byte("temperature")) {
+		t.Errorf("named payload (%x) has no field names", names)
+	}
+	if bytes.Contains(compact, []byte("temperature")) || !bytes.Contains(compact, []byte("lux")) {
+		t.Errorf("compact payload (%x) got the names of CBORKeys or lost the others", compact)
+	}
+	if len(compact) >= len(names) {
+		t.Errorf("compact payload got (%d) bytes want fewer than (%d)", len(compact), len(names))
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range CBORKeys {
+		if seen[k] {
+			t.Errorf("CBORKeys has (%s) twice", k)
+		}
+		seen[k] = true
+	}
+}
+
+func TestCBORFloats(t *testing.T) {
+	tests := []struct {
+		floats CBORFloats
+		value  float64
+		want   float64
+		size   int
+	}{
+		{CBORFloat64, 21.46, 21.46, 9},
+		{CBORFloat64, 21.5, 21.5, 9},
+		{CBORShortest, 21.46, 21.46, 9},
+		{CBORShortest, 21.5, 21.5, 3},
+		{CBORShortest, 1013.2, 1013.2, 9},
+		{CBORShortest, 0.1, 0.1, 9},
+		{CBORFloat32, 21.46, float64(float32(21.46)), 5},
+		{CBORFloat32, 21.5, 21.5, 5},
+	}
+
+	for _, tt := range tests {
+		enc := CBOREncoder{Floats: tt.floats}
+		b, err := enc.Encode(Reading{Values: map[string]float64{"temperature": tt.value}}, nil)
+		if err != nil {
+			t.Fatalf("Encode(%v) error (%v)", tt.value, err)
+		}
+		var got Reading
+		if _, err := Decode("t"+CBORSuffix, b, &got); err != nil {
+			t.Fatalf("Decode(%v) error (%v)", tt.value, err)
+		}
+		if v := got.Values["temperature"]; v != tt.want {
+			t.Errorf("floats (%d) value (%v) got (%v) want (%v)", tt.floats, tt.value, v, tt.want)
+		}
+
+		// the value is the last item of the payload
+		ints, _ := CBOREncoder{Floats: tt.floats}.Encode(Reading{Values: map[string]float64{"temperature": 0}}, nil)
+		if size := len(b) - len(ints) + 1; size != tt.size {
+			t.Errorf("floats (%d) value (%v) size got (%d) want (%d)", tt.floats, tt.value, size, tt.size)
+		}
+	}
+
+	// shrunk, a value is off by no more than a float32 rounds
+	b, _ := CBOREncoder{Floats: CBORFloat32}.Encode(1013.25678, nil)
+	var raw []byte
+	if _, err := Decode("t"+CBORSuffix, b, &raw); err != nil {
+		t.Fatalf("Decode(float32) error (%v)", err)
+	}
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil || math.Abs(f-1013.26) > 1013.26*1e-7 {
+		t.Errorf("Decode(float32) got (%s, %v) want about 1013.26", raw, err)
+	}
+}
+
+// sameJSON reports if a and b are the same JSON, or the same bytes
+// when they are not JSON
+func sameJSON(a, b []byte) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,466 @@
+// Package ccs811 provides a driver for the ams CCS811 air quality
+// sensor, equivalent CO2 in ppm and total volatile organic compounds
+// in ppb over I2C, with nWAKE tied low.
+//
+// The sensor starts in its boot loader, Init resets it, starts the
+// application and sets the drive mode. Its metal oxide element needs
+// 20 minutes to settle after each power on, ReadPub publishes nothing
+// until then. The baseline the algorithm works from drifts and is
+// lost with the power, it is saved to the device store with the
+// "baseline save" command and written back once the sensor is warm.
+// Linked to a device.Thermometer in the device manager, a bme280,
+// sht31, aht20 or dht22 that is a device.Hygrometer too, the readings
+// are compensated for the environment.
+package ccs811
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// AddressLow is the address with the ADDR pin low, AddressHigh
+	// with it high, the default on most breakouts
+	AddressLow  = 0x5A
+	AddressHigh = 0x5B
+
+	// DefaultWarmUp is the conditioning period of the datasheet
+	// after every power on
+	DefaultWarmUp = 20 * time.Minute
+
+	// DefaultHumidity is assumed with a linked device that has no
+	// humidity
+	DefaultHumidity = 50.0
+
+	resetTime    = 2 * time.Millisecond
+	appStartTime = time.Millisecond
+)
+
+var (
+	ErrNotCCS811  = errors.New("device is not a CCS811")
+	ErrNoApp      = errors.New("ccs811 has no valid application firmware")
+	ErrAppStart   = errors.New("ccs811 application did not start")
+	ErrNotReady   = errors.New("ccs811 data not ready")
+	ErrReadFailed = errors.New("failed to read from CCS811")
+	ErrMode       = errors.New("invalid ccs811 drive mode")
+	ErrSensor     = device.ErrSource
+	ErrCommand    = errors.New("unknown ccs811 command")
+)
+
+// Reading is a measurement, what ReadPub publishes
+type Reading struct {
+	ECO2 uint16 `json:"eco2"` // ppm
+	TVOC uint16 `json:"tvoc"` // ppb
+}
+
+// CCS811 is an air quality sensor on an I2C bus
+type CCS811 struct {
+	*device.Device
+
+	// WarmUp is how long after Init the readings are not published
+	WarmUp time.Duration
+
+	bus     string
+	addr    int
+	dev     *drivers.I2CDevice
+	mode    Mode
+	sensor  string
+	started time.Time
+	warm    bool
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// New creates a CCS811 at the given bus and address measuring every
+// second, the sensor is not touched until Init
+func New(name, bus string, addr int) *CCS811 {
+	return &CCS811{
+		Device: device.NewDevice(name, "mqtt"),
+		WarmUp: DefaultWarmUp,
+		bus:    bus,
+		addr:   addr,
+		mode:   Mode1s,
+		now:    time.Now,
+	}
+}
+
+// Init opens the i2c bus and boots the sensor, the warm up starts
+func (c *CCS811) Init() error {
+	if device.IsMock() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.started = c.now()
+		return nil
+	}
+
+	dev, err := drivers.NewI2CDevice(c.bus, c.addr)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dev = dev
+	return c.boot()
+}
+
+// Name returns the name of the device
+func (c *CCS811) Name() string {
+	return c.Device.Name
+}
+
+// boot resets the sensor, checks it has a valid application and
+// starts it, section 8 of the datasheet
+func (c *CCS811) boot() error {
+	err := c.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.WriteReg(regSWReset, resetKey)
+	})
+	if err != nil {
+		return fmt.Errorf("ccs811 reset: %w", err)
+	}
+	time.Sleep(resetTime)
+
+	id, err := c.dev.ReadReg8(regHWID)
+	if err != nil {
+		return err
+	}
+	if id != hwID {
+		return fmt.Errorf("%w: hardware id %#02x", ErrNotCCS811, id)
+	}
+	status, err := c.dev.ReadReg8(regStatus)
+	if err != nil {
+		return err
+	}
+	if status&statusAppValid == 0 {
+		return fmt.Errorf("%w: status %#02x", ErrNoApp, status)
+	}
+
+	err = c.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.Write([]byte{regAppStart})
+	})
+	if err != nil {
+		return fmt.Errorf("ccs811 app start: %w", err)
+	}
+	time.Sleep(appStartTime)
+
+	if status, err = c.dev.ReadReg8(regStatus); err != nil {
+		return err
+	}
+	if status&statusError != 0 {
+		return c.deviceError()
+	}
+	if status&statusFWMode == 0 {
+		return fmt.Errorf("%w: status %#02x", ErrAppStart, status)
+	}
+	if err := c.dev.WriteReg8(regMeasMode, byte(c.mode)<<measDriveShift); err != nil {
+		return err
+	}
+	c.started, c.warm = c.now(), false
+	return nil
+}
+
+// deviceError reads ERROR_ID, reading it clears the ERROR bit
+func (c *CCS811) deviceError() error {
+	id, err := c.dev.ReadReg8(regErrorID)
+	if err != nil {
+		return err
+	}
+	if err := decodeError(id); err != nil {
+		return err
+	}
+	return fmt.Errorf("ccs811 error status without an error id")
+}
+
+// Reset resets and boots the sensor again, the warm up starts over
+func (c *CCS811) Reset() error {
+	if device.IsMock() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	return c.boot()
+}
+
+// Mode returns the drive mode
+func (c *CCS811) Mode() Mode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mode
+}
+
+// SetMode sets the drive mode. The datasheet asks for 10 minutes in
+// Idle before a slower mode than the one running.
+func (c *CCS811) SetMode(m Mode) error {
+	if m > Mode60s {
+		return fmt.Errorf("%w: %d", ErrMode, byte(m))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev != nil {
+		if err := c.dev.UpdateBits(regMeasMode, measDriveMask, byte(m)<<measDriveShift); err != nil {
+			return err
+		}
+	}
+	c.mode = m
+	return nil
+}
+
+// Warm reports if the warm up is over
+func (c *CCS811) Warm() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.started.IsZero() && c.now().Sub(c.started) >= c.WarmUp
+}
+
+// Link compensates the readings with the device.Thermometer added to
+// the device manager as sensor, with its humidity when it is a
+// device.Hygrometer too, DefaultHumidity if not. "" stops the
+// compensation, the sensor keeps the last environment written until
+// it is reset.
+func (c *CCS811) Link(sensor string) error {
+	if sensor != "" {
+		if _, err := device.GetThermometer(sensor); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sensor = sensor
+	return nil
+}
+
+// Compensate writes the temperature in °C and the humidity in %RH
+// the sensor compensates its readings for
+func (c *CCS811) Compensate(temp, hum float64) error {
+	if device.IsMock() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	return c.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.WriteReg(regEnvData, encodeEnv(hum, temp))
+	})
+}
+
+// compensate writes the environment of the linked device
+func (c *CCS811) compensate() error {
+	c.mu.Lock()
+	sensor := c.sensor
+	c.mu.Unlock()
+	if sensor == "" {
+		return nil
+	}
+	t, err := device.GetThermometer(sensor)
+	if err != nil {
+		return err
+	}
+	temp, err := t.Temperature()
+	if err != nil {
+		return fmt.Errorf("%s: %w", sensor, err)
+	}
+	hum := DefaultHumidity
+	if h, ok := t.(device.Hygrometer); ok {
+		if hum, err = h.Humidity(); err != nil {
+			return fmt.Errorf("%s: %w", sensor, err)
+		}
+	}
+	return c.Compensate(temp, hum)
+}
+
+// Read polls the status and reads the measurement, ErrNotReady when
+// there is no new one. An error the sensor reports is returned as
+// one or more of ErrWriteReg, ErrReadReg, ErrMeasMode,
+// ErrMaxResistance, ErrHeaterFault and ErrHeaterSupply.
+func (c *CCS811) Read() (*Reading, error) {
+	if device.IsMock() {
+		return &Reading{
+			ECO2: uint16(400 + c.MockRand().Intn(600)),
+			TVOC: uint16(c.MockRand().Intn(200)),
+		}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	status, err := c.dev.ReadReg8(regStatus)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	if status&statusError != 0 {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, c.deviceError())
+	}
+	if status&statusDataReady == 0 {
+		return nil, ErrNotReady
+	}
+	buf, err := c.dev.ReadBlock(regAlgResult, algResultLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	if buf[4]&statusError != 0 {
+		if err := decodeError(buf[5]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+	}
+	return &Reading{
+		ECO2: binary.BigEndian.Uint16(buf[0:2]),
+		TVOC: binary.BigEndian.Uint16(buf[2:4]),
+	}, nil
+}
+
+// Baseline reads the baseline of the algorithm
+func (c *CCS811) Baseline() (uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return 0, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	return c.dev.ReadReg16(regBaseline)
+}
+
+// SetBaseline writes a baseline read earlier with Baseline
+func (c *CCS811) SetBaseline(b uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	return c.dev.WriteReg16(regBaseline, b)
+}
+
+func (c *CCS811) storeKey() string {
+	return c.Device.Name + "/baseline"
+}
+
+// SaveBaseline reads the baseline and saves it in the device store,
+// do it in clean air once the sensor has been running a while
+func (c *CCS811) SaveBaseline() error {
+	if device.IsMock() {
+		return nil
+	}
+	b, err := c.Baseline()
+	if err != nil {
+		return err
+	}
+	return device.GetStore().Save(c.storeKey(), &b)
+}
+
+// RestoreBaseline writes the baseline saved in the device store,
+// device.ErrNotStored if there is none
+func (c *CCS811) RestoreBaseline() error {
+	if device.IsMock() {
+		return nil
+	}
+	var b uint16
+	if err := device.GetStore().Load(c.storeKey(), &b); err != nil {
+		return err
+	}
+	return c.SetBaseline(b)
+}
+
+// Command handles a command payload: "baseline save", "baseline
+// restore", "mode 1s" with the drive mode or "reset"
+func (c *CCS811) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(f) == 2 && f[0] == "baseline" && f[1] == "save":
+		return c.SaveBaseline()
+	case len(f) == 2 && f[0] == "baseline" && f[1] == "restore":
+		return c.RestoreBaseline()
+	case len(f) == 2 && f[0] == "mode":
+		m, err := ParseMode(f[1])
+		if err != nil {
+			return err
+		}
+		return c.SetMode(m)
+	case len(f) == 1 && f[0] == "reset":
+		return c.Reset()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// warmed reports if the warm up just ended
+func (c *CCS811) warmed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.warm || c.started.IsZero() || c.now().Sub(c.started) < c.WarmUp {
+		return false
+	}
+	c.warm = true
+	return true
+}
+
+// ReadPub compensates and reads the sensor and publishes a new
+// reading. Nothing is published before the warm up is over, then
+// the saved baseline is restored first.
+func (c *CCS811) ReadPub() error {
+	if err := c.compensate(); err != nil {
+		slog.Warn("ccs811 compensation", "device", c.Device.Name, "error", err)
+	}
+	r, err := c.Read()
+	if errors.Is(err, ErrNotReady) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !c.Warm() {
+		slog.Debug("ccs811 warming up", "device", c.Device.Name, "eco2", r.ECO2, "tvoc", r.TVOC)
+		return nil
+	}
+	if c.warmed() {
+		err := c.RestoreBaseline()
+		switch {
+		case err == nil:
+			// the reading was made with the old baseline
+			return nil
+		case !errors.Is(err, device.ErrNotStored):
+			slog.Warn("ccs811 restoring baseline", "device", c.Device.Name, "error", err)
+		}
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	c.PubData(j)
+	return nil
+}
+
+// Run polls the sensor every period until ctx is canceled, poll at
+// least as often as the drive mode measures
+func (c *CCS811) Run(ctx context.Context, period time.Duration) error {
+	err := c.TimerLoop(ctx, period, c.ReadPub)
+	slog.Debug("ccs811 stopped", "device", c.Device.Name, "error", err)
+	return err
+}
+
+// Close closes the i2c device
+func (c *CCS811) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dev == nil {
+		return nil
+	}
+	err := c.dev.Close()
+	c.dev = nil
+	return err
+}
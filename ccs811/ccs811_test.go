@@ -0,0 +1,323 @@
+package ccs811
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/bme280"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// sim is a CCS811 on the fake bus: a reset drops it into the boot
+// loader, APP_START starts the application when it is valid, the
+// result mailbox is kept apart from the registers it overlaps and
+// reading ERROR_ID clears the ERROR bit
+type sim struct {
+	*driverstest.I2C
+	result   []byte
+	noStart  bool
+	noApp    bool
+	errorIDs byte
+}
+
+func newSim() *sim {
+	s := &sim{I2C: driverstest.NewI2C(), result: make([]byte, algResultLen)}
+	s.Set(regHWID, hwID)
+	s.Set(regStatus, statusAppValid)
+	return s
+}
+
+func (s *sim) Write(buf []byte) error {
+	if err := s.I2C.Write(buf); err != nil {
+		return err
+	}
+	status := s.Get(regStatus, 1)[0]
+	if len(buf) == 1 && buf[0] == regAppStart && status&statusAppValid != 0 && !s.noStart {
+		s.Set(regStatus, status|statusFWMode)
+	}
+	return nil
+}
+
+func (s *sim) WriteReg(reg byte, buf []byte) error {
+	if err := s.I2C.WriteReg(reg, buf); err != nil {
+		return err
+	}
+	if reg == regSWReset && string(buf) == string(resetKey) {
+		status := byte(statusAppValid)
+		if s.noApp {
+			status = 0
+		}
+		s.Set(0xFF, 0)
+		s.Set(regStatus, status, 0, 0)
+	}
+	return nil
+}
+
+func (s *sim) ReadReg(reg byte, buf []byte) error {
+	switch reg {
+	case regAlgResult:
+		copy(buf, s.result)
+		return nil
+	case regErrorID:
+		buf[0] = s.errorIDs
+		s.Set(regStatus, s.Get(regStatus, 1)[0]&^statusError)
+		return nil
+	}
+	return s.I2C.ReadReg(reg, buf)
+}
+
+// measure makes a measurement ready
+func (s *sim) measure(eco2, tvoc uint16) {
+	s.result = []byte{byte(eco2 >> 8), byte(eco2), byte(tvoc >> 8), byte(tvoc), statusFWMode | statusDataReady, 0, 0, 0}
+	s.Set(regStatus, s.Get(regStatus, 1)[0]|statusDataReady)
+}
+
+// writes returns the writes made to the fake
+func writes(s *sim) []string {
+	var w []string
+	for _, x := range s.Writes {
+		w = append(w, fmt.Sprintf("%d:% x", x.Reg, x.Data))
+	}
+	return w
+}
+
+func equal(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func newTestCCS811(t *testing.T) (*CCS811, *sim) {
+	t.Helper()
+	device.Mock(false)
+	s := newSim()
+	driverstest.UseI2C(t, s)
+
+	c := New("air", TestI2CBus, AddressHigh)
+	if err := c.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	s.Writes = nil
+	return c, s
+}
+
+func TestEncodeEnv(t *testing.T) {
+	// the examples of the ENV_DATA section of the datasheet, 48.5%RH
+	// and 23.5°C are both 0x6100
+	tests := []struct {
+		hum, temp float64
+		want      string
+	}{
+		{48.5, 25, "61 00 64 00"},
+		{48.5, 23.5, "61 00 61 00"},
+		{50, 0, "64 00 32 00"},
+		{42.348, -25, "54 b2 00 00"},
+		{0, -40, "00 00 00 00"},
+		{100, 100.5, "c8 00 fb 00"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf("% x", encodeEnv(tt.hum, tt.temp)); got != tt.want {
+			t.Errorf("encodeEnv(%g%%, %g°C) got (%s) want (%s)", tt.hum, tt.temp, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	if err := decodeError(0); err != nil {
+		t.Errorf("decodeError(0) got (%v) want nil", err)
+	}
+	err := decodeError(0x18)
+	if !errors.Is(err, ErrMaxResistance) || !errors.Is(err, ErrHeaterFault) || errors.Is(err, ErrHeaterSupply) {
+		t.Errorf("decodeError(0x18) got (%v) want (%v, %v)", err, ErrMaxResistance, ErrHeaterFault)
+	}
+	for i, want := range errorIDs {
+		if err := decodeError(1 << i); !errors.Is(err, want) {
+			t.Errorf("decodeError(%#02x) got (%v) want (%v)", 1<<i, err, want)
+		}
+	}
+	if err := decodeError(0x80); err == nil {
+		t.Errorf("decodeError(0x80) got nil want the reserved bit")
+	}
+}
+
+func TestInit(t *testing.T) {
+	device.Mock(false)
+	s := newSim()
+	driverstest.UseI2C(t, s)
+
+	c := New("air", TestI2CBus, AddressLow)
+	if err := c.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	// reset, app start and 1s drive mode
+	want := []string{"255:11 e5 72 8a", "-1:f4", "1:10"}
+	if got := writes(s); !equal(got, want) {
+		t.Errorf("writes got (%v) want (%v)", got, want)
+	}
+	if c.Warm() {
+		t.Errorf("Warm() right after Init got true want false")
+	}
+
+	s.Set(regHWID, 0x80)
+	if err := c.Reset(); !errors.Is(err, ErrNotCCS811) {
+		t.Errorf("Reset() with hardware id 0x80 error got (%v) want (%v)", err, ErrNotCCS811)
+	}
+	s.Set(regHWID, hwID)
+	s.noApp = true
+	if err := c.Reset(); !errors.Is(err, ErrNoApp) {
+		t.Errorf("Reset() without an application error got (%v) want (%v)", err, ErrNoApp)
+	}
+	s.noApp, s.noStart = false, true
+	if err := c.Reset(); !errors.Is(err, ErrAppStart) {
+		t.Errorf("Reset() with the application not starting error got (%v) want (%v)", err, ErrAppStart)
+	}
+	s.FailNext(driverstest.ErrnoNak)
+	if err := c.Reset(); err == nil {
+		t.Errorf("Reset() with no ack got nil want an error")
+	}
+}
+
+func TestRead(t *testing.T) {
+	c, s := newTestCCS811(t)
+
+	if _, err := c.Read(); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Read() before a measurement error got (%v) want (%v)", err, ErrNotReady)
+	}
+	s.measure(412, 27)
+	r, err := c.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.ECO2 != 412 || r.TVOC != 27 {
+		t.Errorf("Read() got (%+v) want (412ppm 27ppb)", r)
+	}
+
+	// an error in STATUS is read from ERROR_ID
+	s.Set(regStatus, statusFWMode|statusError)
+	s.errorIDs = 0x10
+	if _, err := c.Read(); !errors.Is(err, ErrHeaterFault) || !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() with a heater fault error got (%v) want (%v)", err, ErrHeaterFault)
+	}
+	if s.Get(regStatus, 1)[0]&statusError != 0 {
+		t.Errorf("the error bit was not cleared")
+	}
+
+	// and in the status of the result
+	s.measure(400, 0)
+	s.result[4] |= statusError
+	s.result[5] = 0x04
+	if _, err := c.Read(); !errors.Is(err, ErrMeasMode) {
+		t.Errorf("Read() with a result error got (%v) want (%v)", err, ErrMeasMode)
+	}
+}
+
+func TestMode(t *testing.T) {
+	c, s := newTestCCS811(t)
+	if err := c.Command([]byte("Mode 60s")); err != nil {
+		t.Fatalf("mode 60s error = %v", err)
+	}
+	if got := s.Get(regMeasMode, 1)[0]; got != 0x30 || c.Mode() != Mode60s {
+		t.Errorf("MEAS_MODE got (%#02x, %v) want (0x30, 60s)", got, c.Mode())
+	}
+	if err := c.Command([]byte("mode 250ms")); !errors.Is(err, ErrMode) {
+		t.Errorf("mode 250ms error got (%v) want (%v)", err, ErrMode)
+	}
+	if err := c.SetMode(4); !errors.Is(err, ErrMode) {
+		t.Errorf("SetMode(4) error got (%v) want (%v)", err, ErrMode)
+	}
+	if err := c.Command([]byte("sleep")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(sleep) error got (%v) want (%v)", err, ErrCommand)
+	}
+
+	// a reset keeps the drive mode
+	s.Writes = nil
+	if err := c.Command([]byte("reset")); err != nil {
+		t.Fatalf("reset error = %v", err)
+	}
+	if got, want := writes(s), []string{"255:11 e5 72 8a", "-1:f4", "1:30"}; !equal(got, want) {
+		t.Errorf("writes got (%v) want (%v)", got, want)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	devicetest.UseStore(t)
+	c, s := newTestCCS811(t)
+
+	if err := c.Command([]byte("baseline restore")); !errors.Is(err, device.ErrNotStored) {
+		t.Errorf("baseline restore error got (%v) want (%v)", err, device.ErrNotStored)
+	}
+	s.Set(regBaseline, 0x84, 0x7B)
+	if err := c.Command([]byte("baseline save")); err != nil {
+		t.Fatalf("baseline save error = %v", err)
+	}
+
+	// the power went, the sensor starts over from another baseline
+	s.Set(regBaseline, 0x90, 0x01)
+	if err := c.Command([]byte("baseline restore")); err != nil {
+		t.Fatalf("baseline restore error = %v", err)
+	}
+	if b, err := c.Baseline(); err != nil || b != 0x847B {
+		t.Errorf("Baseline() got (%#04x, %v) want (0x847b)", b, err)
+	}
+}
+
+func TestReadPub(t *testing.T) {
+	devicetest.UseStore(t)
+	c, s := newTestCCS811(t)
+	clock := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return clock }
+	c.started = clock
+
+	if err := c.Link("room"); !errors.Is(err, ErrSensor) {
+		t.Errorf("Link(missing) error got (%v) want (%v)", err, ErrSensor)
+	}
+	dm := device.GetDeviceManager()
+	room := bme280.New("room", "/dev/i2c-1", 0x76)
+	room.SetMockSequence([]any{bme280.Response{Temperature: 25, Humidity: 48.5, Pressure: 1013.25}}, device.MockLoop)
+	dm.Add(room)
+	defer dm.Remove("room")
+	if err := c.Link("room"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	s.Set(regBaseline, 0x84, 0x7B)
+	if err := c.SaveBaseline(); err != nil {
+		t.Fatalf("SaveBaseline() error = %v", err)
+	}
+
+	// warming up, compensated and read but not published
+	s.Writes = nil
+	s.measure(450, 10)
+	if err := c.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	if got, want := writes(s), []string{"5:61 00 64 00"}; !equal(got, want) {
+		t.Errorf("writes warming up got (%v) want (%v)", got, want)
+	}
+
+	// once warm the saved baseline is restored, only the first time
+	clock = clock.Add(DefaultWarmUp)
+	s.Writes = nil
+	if err := c.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	if err := c.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	want := []string{"5:61 00 64 00", "17:84 7b", "5:61 00 64 00"}
+	if got := writes(s); !equal(got, want) {
+		t.Errorf("writes warm got (%v) want (%v)", got, want)
+	}
+	if !c.Warm() {
+		t.Errorf("Warm() after %v got false want true", DefaultWarmUp)
+	}
+
+	// no new data is not an error
+	s.Set(regStatus, statusFWMode)
+	if err := c.ReadPub(); err != nil {
+		t.Errorf("ReadPub() without data error got (%v) want nil", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ccs811
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// mailboxes, see section 6 of the datasheet. Each is read or written
+// in a single transaction of its own length, APP_START is the
+// address alone.
+const (
+	regStatus    = 0x00 // 1 byte
+	regMeasMode  = 0x01 // 1 byte
+	regAlgResult = 0x02 // 8 bytes, eCO2, TVOC, STATUS, ERROR_ID, RAW_DATA
+	regEnvData   = 0x05 // 4 bytes, humidity and temperature
+	regBaseline  = 0x11 // 2 bytes
+	regHWID      = 0x20 // 1 byte, 0x81
+	regErrorID   = 0xE0 // 1 byte
+	regAppStart  = 0xF4 // no data
+	regSWReset   = 0xFF // 4 bytes, resetKey
+
+	hwID = 0x81
+
+	algResultLen = 8
+)
+
+// resetKey written to SW_RESET resets the sensor, any other write is
+// ignored so a stray one does not
+var resetKey = []byte{0x11, 0xE5, 0x72, 0x8A}
+
+// STATUS bits
+const (
+	statusError     = 1 << 0
+	statusDataReady = 1 << 3
+	statusAppValid  = 1 << 4
+	statusFWMode    = 1 << 7 // application mode, 0 in the boot loader
+)
+
+// MEAS_MODE bits
+const (
+	measDriveShift = 4
+	measDriveMask  = 0x70
+)
+
+// Mode is the drive mode, how often the sensor measures
+type Mode byte
+
+const (
+	Idle    Mode = 0 // no measurements
+	Mode1s  Mode = 1 // every second
+	Mode10s Mode = 2 // every 10 seconds
+	Mode60s Mode = 3 // every minute, the lowest power
+)
+
+// ParseMode parses "idle", "1s", "10s" or "60s"
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "idle":
+		return Idle, nil
+	case "1s":
+		return Mode1s, nil
+	case "10s":
+		return Mode10s, nil
+	case "60s":
+		return Mode60s, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrMode, s)
+}
+
+func (m Mode) String() string {
+	switch m {
+	case Idle:
+		return "idle"
+	case Mode1s:
+		return "1s"
+	case Mode10s:
+		return "10s"
+	case Mode60s:
+		return "60s"
+	}
+	return fmt.Sprintf("mode(%d)", byte(m))
+}
+
+// The errors of ERROR_ID, the sensor sets the ERROR bit of STATUS
+// for them
+var (
+	ErrWriteReg      = errors.New("ccs811 write to an invalid register")
+	ErrReadReg       = errors.New("ccs811 read from an invalid register")
+	ErrMeasMode      = errors.New("ccs811 invalid drive mode")
+	ErrMaxResistance = errors.New("ccs811 sensor resistance out of range")
+	ErrHeaterFault   = errors.New("ccs811 heater current out of range")
+	ErrHeaterSupply  = errors.New("ccs811 heater voltage out of range")
+)
+
+// errorIDs are in the order of their ERROR_ID bit
+var errorIDs = []error{ErrWriteReg, ErrReadReg, ErrMeasMode, ErrMaxResistance, ErrHeaterFault, ErrHeaterSupply}
+
+// decodeError returns the errors set in ERROR_ID, nil for none
+func decodeError(id byte) error {
+	var errs []error
+	for i, err := range errorIDs {
+		if id&(1<<i) != 0 {
+			errs = append(errs, err)
+		}
+	}
+	if id&0xC0 != 0 {
+		errs = append(errs, fmt.Errorf("ccs811 reserved error bits %#02x", id&0xC0))
+	}
+	return errors.Join(errs...)
+}
+
+// encodeEnv returns the ENV_DATA of the humidity in %RH and the
+// temperature in °C, both in 1/512 steps, the temperature offset by
+// 25°C so 0 is -25°C
+func encodeEnv(hum, temp float64) []byte {
+	h := envWord(hum)
+	t := envWord(temp + 25)
+	return []byte{byte(h >> 8), byte(h), byte(t >> 8), byte(t)}
+}
+
+func envWord(v float64) uint16 {
+	return uint16(math.Round(math.Max(0, math.Min(v*512, 0xFFFF))))
+}
@@ -0,0 +1,295 @@
+package device
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the time of the devices, the RealClock or the SimClock of
+// a test that moves it tick by tick rather than sleeping
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) *Ticker
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) *Timer
+}
+
+// Ticker delivers the ticks of a Clock on C, like a time.Ticker
+type Ticker struct {
+	C <-chan time.Time
+
+	stop  func()
+	reset func(d time.Duration)
+}
+
+// Stop turns the ticker off, no more ticks are delivered
+func (t *Ticker) Stop() {
+	t.stop()
+}
+
+// Reset stops the ticker and resets its period to d, the next tick
+// d from now
+func (t *Ticker) Reset(d time.Duration) {
+	t.reset(d)
+}
+
+// Timer calls its func when its time comes, like time.AfterFunc
+type Timer struct {
+	stop func() bool
+}
+
+// Stop keeps the func from being called, false when it was called or
+// the timer stopped already
+func (t *Timer) Stop() bool {
+	return t.stop()
+}
+
+// RealClock is the clock of the time passing
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) *Ticker {
+	t := time.NewTicker(d)
+	return &Ticker{C: t.C, stop: t.Stop, reset: t.Reset}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) *Timer {
+	return &Timer{stop: time.AfterFunc(d, f).Stop}
+}
+
+// the clock of the devices without one of their own
+var defaultClock = struct {
+	c  Clock
+	mu sync.RWMutex
+}{c: RealClock}
+
+// SetDefaultClock sets the clock of the devices without one of their
+// own and returns a function that restores the previous one. A nil
+// clock is the RealClock.
+func SetDefaultClock(c Clock) (restore func()) {
+	if c == nil {
+		c = RealClock
+	}
+	defaultClock.mu.Lock()
+	defer defaultClock.mu.Unlock()
+	prev := defaultClock.c
+	defaultClock.c = c
+	return func() {
+		defaultClock.mu.Lock()
+		defer defaultClock.mu.Unlock()
+		defaultClock.c = prev
+	}
+}
+
+// DefaultClock returns the clock of the devices without one of their
+// own, the RealClock unless SetDefaultClock
+func DefaultClock() Clock {
+	defaultClock.mu.RLock()
+	defer defaultClock.mu.RUnlock()
+	return defaultClock.c
+}
+
+// SimClock is a clock moved by the test rather than by time passing.
+// Advance delivers the ticks and timers due in order and returns once
+// every tick was taken and every AfterFunc returned. Unlike a
+// time.Ticker none are dropped, a ticker must be read or stopped.
+type SimClock struct {
+	now    time.Time
+	timers []*simTimer
+	mu     sync.Mutex
+}
+
+// simTimer is a ticker of a SimClock, the timer of an After without
+// a period, or of an AfterFunc with its func
+type simTimer struct {
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+	stop   chan struct{}
+}
+
+// NewSimClock returns a SimClock at start
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+// Now returns the time of the clock
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker ticking every d of the clock, d must be
+// greater than zero like for time.NewTicker
+func (c *SimClock) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("non-positive interval for SimClock.NewTicker")
+	}
+	t := c.add(d, d, nil)
+	return &Ticker{
+		C:    t.c,
+		stop: func() { c.remove(t) },
+		reset: func(d time.Duration) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			t.when, t.period = c.now.Add(d), d
+			if !slices.Contains(c.timers, t) {
+				// stopped, back on the clock
+				t.stop = make(chan struct{})
+				c.timers = append(c.timers, t)
+			}
+		},
+	}
+}
+
+// After returns a channel the time is sent on once the clock moved d
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		ch := make(chan time.Time, 1)
+		ch <- c.Now()
+		return ch
+	}
+	return c.add(d, 0, nil).c
+}
+
+// AfterFunc calls f once the clock moved d, in the goroutine of the
+// Advance moving it there
+func (c *SimClock) AfterFunc(d time.Duration, f func()) *Timer {
+	t := c.add(max(d, 0), 0, f)
+	return &Timer{stop: func() bool { return c.remove(t) }}
+}
+
+func (c *SimClock) add(d, period time.Duration, f func()) *simTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &simTimer{when: c.now.Add(d), period: period, f: f, stop: make(chan struct{})}
+	if period > 0 {
+		t.c = make(chan time.Time)
+	} else {
+		t.c = make(chan time.Time, 1) // nobody may wait for it
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// remove takes t off the clock, false when it was not on it
+func (c *SimClock) remove(t *simTimer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, ti := range c.timers {
+		if ti == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			close(t.stop)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock d forward, delivering the ticks and the
+// timers due on the way
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var next *simTimer
+		for _, t := range c.timers {
+			if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			if end.After(c.now) {
+				c.now = end
+			}
+			c.mu.Unlock()
+			return
+		}
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		at, stop := c.now, next.stop
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			for i, t := range c.timers {
+				if t == next {
+					c.timers = append(c.timers[:i], c.timers[i+1:]...)
+					break
+				}
+			}
+		}
+		c.mu.Unlock()
+
+		if next.f != nil {
+			next.f()
+			continue
+		}
+		select {
+		case next.c <- at:
+		case <-stop:
+		}
+	}
+}
+
+// Waiters returns the tickers and the timers waiting on the clock, a
+// test waits for the loop it drives to be waiting before it advances
+func (c *SimClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Set moves the clock to t, the ticks and the timers due delivered
+// when it is forward, none when it is back
+func (c *SimClock) Set(t time.Time) {
+	c.mu.Lock()
+	if !t.After(c.now) {
+		c.now = t
+		c.mu.Unlock()
+		return
+	}
+	d := t.Sub(c.now)
+	c.mu.Unlock()
+	c.Advance(d)
+}
+
+// SetClock sets the clock the device tells the time and ticks by, the
+// DefaultClock when nil. Its package reads Now for what it paces by
+// the time, the mock values of its Generators and the totals of a
+// day, set it before them.
+func (d *Device) SetClock(c Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = c
+}
+
+// Clock returns the clock of the device
+func (d *Device) Clock() Clock {
+	d.mu.RLock()
+	c := d.clock
+	d.mu.RUnlock()
+	if c == nil {
+		return DefaultClock()
+	}
+	return c
+}
+
+// Now returns the time of the clock of the device
+func (d *Device) Now() time.Time {
+	return d.Clock().Now()
+}
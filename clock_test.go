@@ -0,0 +1,182 @@
+package device
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewSimClock(start)
+	d := NewDevice("bme", "mqtt")
+	if got := d.Now(); got.Sub(time.Now()).Abs() > time.Second {
+		t.Errorf("Now() without a clock got (%v) want about now", got)
+	}
+
+	d.SetClock(clock)
+	if got := d.Now(); !got.Equal(start) {
+		t.Errorf("Now() got (%v) want (%v)", got, start)
+	}
+	clock.Advance(30 * 24 * time.Hour)
+	if got := d.Now(); !got.Equal(start.AddDate(0, 0, 30)) {
+		t.Errorf("Now() a month on got (%v) want (%v)", got, start.AddDate(0, 0, 30))
+	}
+	clock.Set(start)
+	if got := d.Now(); !got.Equal(start) {
+		t.Errorf("Now() set back got (%v) want (%v)", got, start)
+	}
+
+	d.SetClock(nil)
+	if got := d.Now(); got.Sub(time.Now()).Abs() > time.Second {
+		t.Errorf("Now() with the clock removed got (%v) want about now", got)
+	}
+}
+
+func TestSimClockTickers(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewSimClock(start)
+
+	// the ticks due come in order of their time, each taken
+	var got []string
+	done := make(chan struct{})
+	ticker := clock.NewTicker(10 * time.Second)
+	slow := clock.NewTicker(25 * time.Second)
+	go func() {
+		defer close(done)
+		for len(got) < 4 {
+			select {
+			case at := <-ticker.C:
+				got = append(got, "10s "+at.Sub(start).String())
+			case at := <-slow.C:
+				got = append(got, "25s "+at.Sub(start).String())
+			}
+		}
+	}()
+	clock.Advance(30 * time.Second)
+	<-done
+	want := []string{"10s 10s", "10s 20s", "25s 25s", "10s 30s"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Advance() delivered (%v) want (%v)", got, want)
+	}
+	slow.Stop()
+	if n := clock.Waiters(); n != 1 {
+		t.Errorf("Waiters() got (%d) want the ticker", n)
+	}
+
+	// a reset ticker ticks a period from the reset, a stopped one not
+	ticker.Reset(time.Minute)
+	ticks := make(chan time.Time)
+	go func() { ticks <- <-ticker.C }()
+	clock.Advance(time.Minute)
+	if at := <-ticks; at.Sub(start) != 90*time.Second {
+		t.Errorf("Reset() ticked at (%v) want (1m30s)", at.Sub(start))
+	}
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("Waiters() after Stop() got (%d) want (0)", n)
+	}
+
+	// a timer fires once, whether it is read then or not
+	after := clock.After(time.Minute)
+	clock.Advance(2 * time.Minute)
+	if at := <-after; !at.Equal(start.Add(time.Hour + 150*time.Second)) {
+		t.Errorf("After() fired at (%v) want (%v)", at, start.Add(92*time.Minute))
+	}
+	select {
+	case <-clock.After(0):
+	default:
+		t.Errorf("After(0) did not fire")
+	}
+
+	// the devices without a clock tell the time of the default
+	restore := SetDefaultClock(clock)
+	d := NewDevice("bme", "mqtt")
+	if got := d.Now(); !got.Equal(clock.Now()) {
+		t.Errorf("Now() with the default clock got (%v) want (%v)", got, clock.Now())
+	}
+	restore()
+	if got := d.Now(); got.Sub(time.Now()).Abs() > time.Second {
+		t.Errorf("Now() with the default restored got (%v) want about now", got)
+	}
+}
+
+func TestSimClockResetStopped(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewSimClock(start)
+
+	// a stopped ticker reset ticks again
+	ticker := clock.NewTicker(10 * time.Second)
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	ticker.Reset(30 * time.Second)
+	if n := clock.Waiters(); n != 1 {
+		t.Fatalf("Waiters() after Reset() got (%d) want the ticker", n)
+	}
+	ticks := make(chan time.Time, 2)
+	go func() {
+		for range 2 {
+			ticks <- <-ticker.C
+		}
+	}()
+	clock.Advance(time.Minute)
+	for _, want := range []time.Duration{90 * time.Second, 2 * time.Minute} {
+		if at := <-ticks; at.Sub(start) != want {
+			t.Errorf("ticked at (%v) want (%v)", at.Sub(start), want)
+		}
+	}
+
+	// and stops again
+	ticker.Stop()
+	ticker.Reset(time.Minute)
+	ticker.Reset(time.Minute)
+	if n := clock.Waiters(); n != 1 {
+		t.Errorf("Waiters() after two Reset() got (%d) want the ticker once", n)
+	}
+	ticker.Stop()
+	clock.Advance(time.Hour)
+}
+
+func TestSimClockAfterFunc(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewSimClock(start)
+
+	// called in order of their time, by the Advance past them
+	var got []string
+	call := func(name string) func() {
+		return func() { got = append(got, name+" "+clock.Now().Sub(start).String()) }
+	}
+	clock.AfterFunc(20*time.Second, call("hold"))
+	clock.AfterFunc(5*time.Second, call("debounce"))
+	stopped := clock.AfterFunc(10*time.Second, call("stopped"))
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("Stop() got a second stop of the same timer")
+	}
+	clock.Advance(10 * time.Second)
+	if strings.Join(got, ",") != "debounce 5s" {
+		t.Errorf("Advance(10s) called (%v) want (debounce 5s)", got)
+	}
+
+	// a func setting another timer, the one due before the end called too
+	clock.AfterFunc(5*time.Second, func() {
+		got = append(got, "rearm")
+		clock.AfterFunc(time.Second, call("rearmed"))
+	})
+	clock.Advance(time.Minute)
+	want := []string{"debounce 5s", "rearm", "rearmed 16s", "hold 20s"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Advance(1m) called (%v) want (%v)", got, want)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("Waiters() got (%d) want none left", n)
+	}
+
+	fired := make(chan struct{})
+	RealClock.AfterFunc(time.Millisecond, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Errorf("RealClock AfterFunc() was not called")
+	}
+}
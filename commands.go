@@ -0,0 +1,281 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is a command taken on the ControlTopic of a device. It comes
+// as a JSON envelope, {"id":"42","cmd":"on","args":{...}}, answered
+// with an Ack of the same ID, or as a plain string like "on", the
+// legacy payload executed without an Ack.
+type Command struct {
+	ID   string          `json:"id"`
+	Cmd  string          `json:"cmd"`
+	Args json.RawMessage `json:"args,omitempty"`
+
+	Msg *Msg `json:"-"` // the message it came in
+}
+
+// Bind unmarshals the args of the command into v
+func (c *Command) Bind(v any) error {
+	if len(c.Args) == 0 {
+		return fmt.Errorf("%w: %s has no args", ErrCommand, c.Cmd)
+	}
+	if err := json.Unmarshal(c.Args, v); err != nil {
+		return fmt.Errorf("%w: %s args: %v", ErrCommand, c.Cmd, err)
+	}
+	return nil
+}
+
+// Ack answers a Command with an ID on the AckTopic of the device, OK
+// or not with the error, and the time it took to execute
+type Ack struct {
+	ID      string  `json:"id"`
+	OK      bool    `json:"ok"`
+	Error   string  `json:"error,omitempty"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// CommandFunc executes a command, an error is the nack
+type CommandFunc func(cmd *Command) error
+
+var (
+	// ErrBadPayload is a payload taken on the ControlTopic of a device
+	// that is not a command of it, ErrCommand and ErrUnknownCommand
+	// are ones
+	ErrBadPayload = errors.New("bad payload")
+
+	// ErrCommand is a command that is not JSON, has no cmd or bad
+	// args, or a payload over MaxCommandSize
+	ErrCommand error = payloadError("bad command")
+
+	// ErrUnknownCommand is a command no handler was set for
+	ErrUnknownCommand error = payloadError("unknown command")
+)
+
+// payloadError is an ErrBadPayload of its own
+type payloadError string
+
+func (e payloadError) Error() string {
+	return string(e)
+}
+
+func (e payloadError) Is(target error) bool {
+	return target == ErrBadPayload
+}
+
+const (
+	// DefaultCommandWindow is how long a Router remembers the IDs of
+	// the commands it executed
+	DefaultCommandWindow = time.Minute
+
+	// MaxCommandSize is the largest payload a command is parsed of
+	MaxCommandSize = 16 << 10
+
+	// maxSeen is the most IDs a Router remembers, the oldest
+	// forgotten first within the Window
+	maxSeen = 1024
+)
+
+// Router executes the commands taken on the ControlTopic of a device
+// with the handlers set for them, the names taken in any case.
+//
+// A command with an ID is executed once within the Window: one that
+// comes again, a QoS 1 redelivery or a sender retrying, is answered
+// with the Ack it got the first time. Of a flood of IDs only the last
+// 1024 are remembered. The commands marked Inhibitable are nacked with
+// ErrInhibited while the device is inhibited, see Inhibit.
+//
+// In mock mode a Router without a handler for it takes the fault
+// command, see FaultArgs, and the mock command injecting the values
+// of the mock reads, {"cmd":"mock","args":{"temperature":31.2}} or
+// {"mock":{"temperature":31.2}} for short. The mock command is nacked
+// with ErrNotMock out of mock mode.
+type Router struct {
+	Window time.Duration
+
+	dev         *Device
+	handlers    map[string]CommandFunc
+	inhibitable map[string]bool // see Inhibitable
+	seen        map[string]*executed
+	mu          sync.Mutex
+}
+
+// executed is a command with an ID, the ack is nil while it runs
+type executed struct {
+	at  time.Time
+	ack *Ack
+}
+
+// NewRouter creates the command Router of d
+func NewRouter(d *Device) *Router {
+	return &Router{
+		Window:   DefaultCommandWindow,
+		dev:      d,
+		handlers: make(map[string]CommandFunc),
+		seen:     make(map[string]*executed),
+	}
+}
+
+// Handle sets fn to execute the command name
+func (r *Router) Handle(name string, fn CommandFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[strings.ToLower(name)] = fn
+}
+
+// Listen subscribes the router to the ControlTopic of its device
+func (r *Router) Listen() error {
+	return r.dev.Subscribe(r.dev.ControlTopic(), Dedupe(r.Dispatch))
+}
+
+// ParseCommand parses a payload, a JSON envelope or a plain string
+// command. An envelope with a mock and no cmd is the mock command of
+// its args. A payload over MaxCommandSize is an ErrCommand.
+func ParseCommand(payload []byte) (*Command, error) {
+	if len(payload) > MaxCommandSize {
+		return nil, fmt.Errorf("%w: %d bytes over %d", ErrCommand, len(payload), MaxCommandSize)
+	}
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 || payload[0] != '{' {
+		return &Command{Cmd: string(payload)}, nil
+	}
+	var env struct {
+		Command
+		Mock json.RawMessage `json:"mock"`
+	}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCommand, err)
+	}
+	cmd := &env.Command
+	if cmd.Cmd == "" && len(env.Mock) > 0 {
+		cmd.Cmd, cmd.Args = "mock", env.Mock
+	}
+	if cmd.Cmd == "" {
+		return cmd, fmt.Errorf("%w: no cmd", ErrCommand)
+	}
+	return cmd, nil
+}
+
+// Dispatch executes the command of msg and acks it when it has an ID.
+// A payload that is JSON but not a command is nacked when it has one,
+// there is no one to answer otherwise.
+func (r *Router) Dispatch(msg *Msg) {
+	if err := r.Execute(msg); err != nil {
+		slog.Warn("command failed", "device", r.dev.Name, "error", err)
+	}
+}
+
+// Execute is Dispatch returning the error of the command, an
+// ErrBadPayload when msg is not a command of the device. A command
+// executed already within the Window is acked again, nil.
+func (r *Router) Execute(msg *Msg) error {
+	start := time.Now()
+	cmd, err := ParseCommand(msg.Data)
+	if err != nil {
+		if cmd != nil && cmd.ID != "" {
+			r.ack(newAck(cmd.ID, start, err))
+		}
+		return err
+	}
+	cmd.Msg = msg
+
+	if cmd.ID == "" {
+		return r.execute(cmd)
+	}
+	if ack, ok := r.replayed(cmd.ID, start); ok {
+		if ack != nil {
+			r.ack(ack)
+		}
+		return nil
+	}
+	err = r.execute(cmd)
+	ack := newAck(cmd.ID, start, err)
+	r.mu.Lock()
+	if e, ok := r.seen[cmd.ID]; ok {
+		e.ack = ack
+	}
+	r.mu.Unlock()
+	r.ack(ack)
+	return err
+}
+
+func newAck(id string, start time.Time, err error) *Ack {
+	ack := &Ack{ID: id, OK: err == nil, Latency: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	return ack
+}
+
+// replayed returns the Ack of the command id when it was executed
+// within the Window, nil while it runs, or marks it running
+func (r *Router) replayed(id string, now time.Time) (*Ack, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for seen, e := range r.seen {
+		if now.Sub(e.at) > r.Window {
+			delete(r.seen, seen)
+		}
+	}
+	if e, ok := r.seen[id]; ok {
+		return e.ack, true
+	}
+	if len(r.seen) >= maxSeen {
+		oldest := ""
+		for seen, e := range r.seen {
+			if oldest == "" || e.at.Before(r.seen[oldest].at) {
+				oldest = seen
+			}
+		}
+		delete(r.seen, oldest)
+	}
+	r.seen[id] = &executed{at: now}
+	return nil, false
+}
+
+func (r *Router) execute(cmd *Command) error {
+	r.mu.Lock()
+	fn, ok := r.handlers[strings.ToLower(cmd.Cmd)]
+	inhibitable := r.inhibitable[strings.ToLower(cmd.Cmd)]
+	r.mu.Unlock()
+	if inhibitable {
+		if err := r.dev.Inhibited(); err != nil {
+			return err
+		}
+	}
+	if !ok && IsMock() && strings.EqualFold(cmd.Cmd, "fault") {
+		return r.dev.faultCommand(cmd)
+	}
+	if !ok && strings.EqualFold(cmd.Cmd, "mock") {
+		return r.dev.mockCommand(cmd)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, cmd.Cmd)
+	}
+	return fn(cmd)
+}
+
+// ack publishes ack at QoS 1, the sender waits for it
+func (r *Router) ack(ack *Ack) {
+	payload, err := json.Marshal(ack)
+	if err == nil {
+		err = r.dev.publish(r.dev.AckTopic(), payload, false, []PubOption{PubQoS(AtLeastOnce)}, nil)
+	}
+	if err != nil {
+		slog.Error("command ack", "device", r.dev.Name, "id", ack.ID, "error", err)
+	}
+}
+
+// AckTopic returns the topic the device acks its commands on, under
+// its ControlTopic
+func (d *Device) AckTopic() string {
+	return d.ControlTopic() + "/ack"
+}
@@ -0,0 +1,208 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// router is a Router of a relay on m that counts what it executed
+func router(t *testing.T, m *MemMessanger) (*Router, *Device, *[]string) {
+	t.Helper()
+	d := NewDevice("relay", "mqtt", WithMessanger(m), WithQoS(AtLeastOnce))
+	rt := NewRouter(d)
+	var executed []string
+	rt.Handle("on", func(cmd *Command) error {
+		executed = append(executed, cmd.Cmd)
+		return nil
+	})
+	rt.Handle("dim", func(cmd *Command) error {
+		var args struct {
+			Level int `json:"level"`
+		}
+		if err := cmd.Bind(&args); err != nil {
+			return err
+		}
+		executed = append(executed, cmd.Cmd)
+		return nil
+	})
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	return rt, d, &executed
+}
+
+func acks(t *testing.T, m *MemMessanger, d *Device) []Ack {
+	t.Helper()
+	var got []Ack
+	for _, msg := range m.Messages(d.AckTopic()) {
+		var ack Ack
+		if err := json.Unmarshal(msg.Data, &ack); err != nil {
+			t.Fatalf("ack %s error = %v", msg, err)
+		}
+		if msg.QoS != AtLeastOnce {
+			t.Errorf("ack qos got (%d) want (1)", msg.QoS)
+		}
+		got = append(got, ack)
+	}
+	return got
+}
+
+func TestRouter(t *testing.T) {
+	m := NewMemMessanger()
+	rt, d, executed := router(t, m)
+	if d.AckTopic() != "ss/c/station/relay/ack" {
+		t.Errorf("AckTopic() got (%s)", d.AckTopic())
+	}
+
+	tests := []struct {
+		payload string
+		ok      bool
+	}{
+		{`{"id":"1","cmd":"on"}`, true},
+		{`{"id":"2","cmd":"ON"}`, true},
+		{`{"id":"3","cmd":"dim","args":{"level":50}}`, true},
+		{`{"id":"4","cmd":"explode"}`, false},
+		{`{"id":"5","cmd":"dim"}`, false},
+		{`{"id":"6","cmd":"dim","args":{"level":"high"}}`, false},
+		{`{"id":"7"}`, false},
+	}
+	for _, tt := range tests {
+		m.Reset()
+		m.PublishQoS(d.ControlTopic(), []byte(tt.payload), AtLeastOnce, false)
+		got := acks(t, m, d)
+		if len(got) != 1 {
+			t.Fatalf("%s acks got (%+v) want 1", tt.payload, got)
+		}
+		var cmd Command
+		json.Unmarshal([]byte(tt.payload), &cmd)
+		if got[0].ID != cmd.ID || got[0].OK != tt.ok || (got[0].Error == "") != tt.ok || got[0].Latency < 0 {
+			t.Errorf("%s ack got (%+v)", tt.payload, got[0])
+		}
+	}
+	if _, err := ParseCommand([]byte(`{"id":"7"}`)); !errors.Is(err, ErrCommand) {
+		t.Errorf("ParseCommand() without cmd error got (%v) want (%v)", err, ErrCommand)
+	}
+	if cmd, err := ParseCommand([]byte(" toggle\n")); err != nil || cmd.Cmd != "toggle" || cmd.ID != "" {
+		t.Errorf("ParseCommand(toggle) got (%+v, %v)", cmd, err)
+	}
+	if len(*executed) != 3 {
+		t.Errorf("executed got (%q) want ([on ON dim])", *executed)
+	}
+
+	// not JSON or no ID, nothing to answer
+	m.Reset()
+	m.Publish(d.ControlTopic(), []byte(`{"id":`))
+	m.Publish(d.ControlTopic(), []byte(`{"args":{}}`))
+	if got := acks(t, m, d); len(got) != 0 {
+		t.Errorf("bad JSON acks got (%+v) want none", got)
+	}
+	if err := rt.Execute(NewMsg(d.ControlTopic(), []byte(`{"id":`), "test")); !errors.Is(err, ErrBadPayload) {
+		t.Errorf("Execute(bad JSON) error got (%v) want (%v)", err, ErrBadPayload)
+	}
+
+	// plain strings execute without an ack
+	m.Reset()
+	m.Publish(d.ControlTopic(), []byte("on"))
+	m.Publish(d.ControlTopic(), []byte("explode"))
+	if got := acks(t, m, d); len(got) != 0 || len(*executed) != 4 {
+		t.Errorf("legacy got (%+v, %q) want no ack, on executed", got, *executed)
+	}
+}
+
+func TestRouterReplay(t *testing.T) {
+	m := NewMemMessanger()
+	m.SetRedeliver(2)
+	_, d, executed := router(t, m)
+
+	// redelivered at QoS 1, then retried by the sender
+	m.PublishQoS(d.ControlTopic(), []byte(`{"id":"a1","cmd":"on"}`), AtLeastOnce, false)
+	m.PublishQoS(d.ControlTopic(), []byte(`{"id":"a1","cmd":"on"}`), AtLeastOnce, false)
+	if len(*executed) != 1 {
+		t.Errorf("executed got (%q) want once", *executed)
+	}
+	got := acks(t, m, d)
+	if len(got) != 2 || got[0] != got[1] || !got[0].OK {
+		t.Errorf("acks got (%+v) want the same ack for the retry", got)
+	}
+
+	// a new ID is a new command
+	m.PublishQoS(d.ControlTopic(), []byte(`{"id":"a2","cmd":"on"}`), AtLeastOnce, false)
+	if len(*executed) != 2 {
+		t.Errorf("executed got (%q) want twice", *executed)
+	}
+}
+
+func TestRouterWindow(t *testing.T) {
+	m := NewMemMessanger()
+	rt, d, executed := router(t, m)
+	rt.Window = time.Millisecond
+	m.Publish(d.ControlTopic(), []byte(`{"id":"w","cmd":"on"}`))
+	time.Sleep(2 * time.Millisecond)
+	m.Publish(d.ControlTopic(), []byte(`{"id":"w","cmd":"on"}`))
+	if len(*executed) != 2 {
+		t.Errorf("executed past the window got (%q) want twice", *executed)
+	}
+}
+
+func TestRouterFlood(t *testing.T) {
+	m := NewMemMessanger()
+	rt, d, executed := router(t, m)
+	for i := range 2 * maxSeen {
+		m.Publish(d.ControlTopic(), []byte(fmt.Sprintf(`{"id":"%d","cmd":"on"}`, i)))
+	}
+	if len(rt.seen) != maxSeen || len(*executed) != 2*maxSeen {
+		t.Errorf("flood remembered (%d) ids executed (%d) want (%d %d)", len(rt.seen), len(*executed), maxSeen, 2*maxSeen)
+	}
+
+	// the last ones are still answered with their ack
+	m.Reset()
+	m.Publish(d.ControlTopic(), []byte(fmt.Sprintf(`{"id":"%d","cmd":"on"}`, 2*maxSeen-1)))
+	if len(*executed) != 2*maxSeen || len(acks(t, m, d)) != 1 {
+		t.Errorf("last id of the flood executed again")
+	}
+
+	err := rt.Execute(NewMsg(d.ControlTopic(), bytes.Repeat([]byte("on"), MaxCommandSize), "test"))
+	if !errors.Is(err, ErrBadPayload) || !errors.Is(err, ErrCommand) {
+		t.Errorf("Execute() of %d bytes error got (%v) want ErrCommand", 2*MaxCommandSize, err)
+	}
+}
+
+// FuzzCommandEnvelope feeds payloads to a Router: one that is not a
+// command of it fails with an ErrBadPayload, one that succeeds was
+// executed by its handler
+func FuzzCommandEnvelope(f *testing.F) {
+	for _, seed := range []string{
+		"on", " ON\n", "", "dim", "off",
+		`{"cmd":"on"}`,
+		`{"id":"42","cmd":"on"}`,
+		`{"id":"7","cmd":"dim","args":{"level":3}}`,
+		`{"id":"8","cmd":"dim","args":{"level":"high"}}`,
+		`{"id":"9","cmd":"dim"}`,
+		`{"mock":{"temperature":31.2}}`,
+		`{"cmd":"mock","args":{"temperature":{"delta":-2}}}`,
+		`{"cmd":"fault","args":{"every":3,"err":"nak"}}`,
+		`{"id":1,"cmd":"on"}`,
+		`{"id":"10"}`,
+		`{`, `[]`, `null`, `{"cmd":null}`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		m := NewMemMessanger()
+		rt, d, executed := router(t, m)
+		err := rt.Execute(NewMsg(d.ControlTopic(), payload, "fuzz"))
+		_, perr := ParseCommand(payload)
+		switch {
+		case perr != nil && !errors.Is(err, ErrBadPayload):
+			t.Errorf("Execute(%q) error got (%v) want the ErrBadPayload of ParseCommand (%v)", payload, err, perr)
+		case err == nil && len(*executed) != 1:
+			t.Errorf("Execute(%q) succeeded without a handler", payload)
+		case err != nil && !errors.Is(err, ErrBadPayload) && !errors.Is(err, ErrNotMock):
+			t.Errorf("Execute(%q) error got (%v) want an ErrBadPayload", payload, err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenAuth returns the options of a grpc.Server taking the calls with
+// the shared token in their "authorization" metadata, "Bearer <token>",
+// the others are Unauthenticated. An empty token takes none.
+func TokenAuth(token string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := authorized(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorized(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+func authorized(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		bearer, ok := strings.CutPrefix(auth, "Bearer ")
+		if ok && token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "bad or missing token")
+}
+
+// Token returns the credentials of a client calling with the shared
+// token, grpc.WithPerRPCCredentials(Token(token)). They go over a
+// connection without TLS too, the token is shared on the LAN of the
+// stations.
+func Token(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (tokenCredentials) RequireTransportSecurity() bool { return false }
@@ -0,0 +1,194 @@
+package control
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+)
+
+type named struct{ *device.Device }
+
+func (n named) Name() string { return n.Device.Name }
+
+// gadget is a device with only a name
+type gadget string
+
+func (g gadget) Name() string { return string(g) }
+
+// serve serves the Control service of dm with token on a bufconn and
+// returns a client with the credentials given
+func serve(t *testing.T, dm *device.DeviceManager, token string, opts ...grpc.DialOption) ControlClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(TokenAuth(token)...)
+	RegisterControlServer(s, NewServer(dm))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("NewClient() error (%v)", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewControlClient(conn)
+}
+
+func TestControl(t *testing.T) {
+	c := devicetest.Use(t)
+	dm := device.GetDeviceManager()
+	t.Cleanup(dm.Clear)
+
+	pump := device.NewDevice("pump", "mqtt")
+	rt := device.NewRouter(pump)
+	rt.Handle("on", func(*device.Command) error { return pump.PubState(1) })
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error (%v)", err)
+	}
+	dm.Add(named{pump})
+	dm.Add(gadget("gadget"))
+
+	client := serve(t, dm, "secret", grpc.WithPerRPCCredentials(Token("secret")))
+	ctx := context.Background()
+
+	list, err := client.ListDevices(ctx, &ListDevicesRequest{})
+	if err != nil {
+		t.Fatalf("ListDevices() error (%v)", err)
+	}
+	if len(list.Devices) != 2 || list.Devices[0].Name != "gadget" || list.Devices[1].Name != "pump" {
+		t.Fatalf("ListDevices() got (%v) want gadget and pump", list.Devices)
+	}
+	if list.Devices[0].Topics != nil {
+		t.Errorf("gadget got (%v) want only its name", list.Devices[0])
+	}
+
+	d, err := client.GetDevice(ctx, &GetDeviceRequest{Name: "pump"})
+	if err != nil {
+		t.Fatalf("GetDevice() error (%v)", err)
+	}
+	if d.Transport != "mqtt" || d.Topics.GetControl() != pump.ControlTopic() || d.Qos != uint32(pump.QoS()) {
+		t.Errorf("GetDevice() got (%v)", d)
+	}
+	if _, err := client.GetDevice(ctx, &GetDeviceRequest{Name: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetDevice(nope) error got (%v) want NotFound", err)
+	}
+
+	// a plain command is given an ID to be acked
+	ack, err := client.SendCommand(ctx, &SendCommandRequest{Name: "pump", Payload: []byte("on")})
+	if err != nil {
+		t.Fatalf("SendCommand(on) error (%v)", err)
+	}
+	if !ack.Ok || ack.Id == "" {
+		t.Errorf("SendCommand(on) got (%v) want ok", ack)
+	}
+	c.ExpectPublish(pump.StateTopic(), devicetest.Data(1), devicetest.Timeout)
+
+	ack, err = client.SendCommand(ctx, &SendCommandRequest{Name: "pump", Payload: []byte(`{"id":"7","cmd":"off"}`)})
+	if err != nil {
+		t.Fatalf("SendCommand(off) error (%v)", err)
+	}
+	if ack.Ok || ack.Id != "7" || !strings.Contains(ack.Error, "unknown command") {
+		t.Errorf("SendCommand(off) got (%v) want it nacked", ack)
+	}
+	if _, err := client.SendCommand(ctx, &SendCommandRequest{Name: "pump", Payload: []byte(`{"id":"8"}`)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SendCommand(no cmd) error got (%v) want InvalidArgument", err)
+	}
+	if _, err := client.SendCommand(ctx, &SendCommandRequest{Name: "gadget", Payload: []byte("on")}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("SendCommand(gadget) error got (%v) want FailedPrecondition", err)
+	}
+}
+
+func TestControlTimeout(t *testing.T) {
+	devicetest.Use(t)
+	dm := device.GetDeviceManager()
+	t.Cleanup(dm.Clear)
+	dm.Add(named{device.NewDevice("mute", "mqtt")}) // no Router listening
+
+	client := serve(t, dm, "secret", grpc.WithPerRPCCredentials(Token("secret")))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.SendCommand(ctx, &SendCommandRequest{Name: "mute", Payload: []byte("on")})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("SendCommand() error got (%v) want DeadlineExceeded", err)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	devicetest.Use(t)
+	dm := device.GetDeviceManager()
+	t.Cleanup(dm.Clear)
+	pump := device.NewDevice("pump", "mqtt")
+	fan := device.NewDevice("fan", "mqtt")
+
+	client := serve(t, dm, "secret", grpc.WithPerRPCCredentials(Token("secret")))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.StreamEvents(ctx, &StreamEventsRequest{Devices: []string{"pump"}, Types: []string{"state"}})
+	if err != nil {
+		t.Fatalf("StreamEvents() error (%v)", err)
+	}
+
+	// until the stream is subscribed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			fan.PubState(1)
+			pump.PubData(2)
+			pump.PubState(3)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error (%v)", err)
+	}
+	if ev.Type != "state" || ev.Device != "pump" || ev.Topic != pump.StateTopic() || string(ev.Data) != "3" || ev.Time.AsTime().IsZero() {
+		t.Errorf("Recv() got (%v) want the state of the pump", ev)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	dm := device.GetDeviceManager()
+	ctx := context.Background()
+	for name, opts := range map[string][]grpc.DialOption{
+		"none":  nil,
+		"wrong": {grpc.WithPerRPCCredentials(Token("guess"))},
+	} {
+		client := serve(t, dm, "secret", opts...)
+		if _, err := client.ListDevices(ctx, &ListDevicesRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s ListDevices() error got (%v) want Unauthenticated", name, err)
+		}
+		stream, err := client.StreamEvents(ctx, &StreamEventsRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s StreamEvents() error got (%v) want Unauthenticated", name, err)
+		}
+	}
+
+	// an empty token takes none
+	client := serve(t, dm, "", grpc.WithPerRPCCredentials(Token("")))
+	if _, err := client.ListDevices(ctx, &ListDevicesRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("empty token ListDevices() error got (%v) want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Package control serves the DeviceManager of a station over gRPC,
+// the Control service of control.proto: the devices listed and their
+// snapshots, the commands sent to them and a stream of their events.
+// It is a package of its own so a station not serving it does not
+// build gRPC in.
+//
+//	s := grpc.NewServer(control.TokenAuth(token)...)
+//	control.RegisterControlServer(s, control.NewServer(device.GetDeviceManager()))
+//	s.Serve(lis)
+//
+// A client calls it with the token as its credentials:
+//
+//	conn, err := grpc.NewClient(addr,
+//	    grpc.WithTransportCredentials(insecure.NewCredentials()),
+//	    grpc.WithPerRPCCredentials(control.Token(token)))
+//	client := control.NewControlClient(conn)
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+const (
+	// DefaultTimeout is how long SendCommand waits for the Ack of a
+	// command when the call has no deadline sooner
+	DefaultTimeout = 5 * time.Second
+
+	// streamBuffer is the number of events a StreamEvents client can
+	// be behind before its events are dropped
+	streamBuffer = 64
+)
+
+// Server is the Control service of the devices of a DeviceManager
+type Server struct {
+	UnimplementedControlServer
+
+	Timeout time.Duration
+
+	dm        *device.DeviceManager
+	acks      map[string]chan *device.Ack // the commands sent by ID
+	listening map[string]bool             // the AckTopics subscribed
+	ids       atomic.Uint64
+	mu        sync.Mutex
+}
+
+// commander is a device taking commands on its ControlTopic, the
+// ones built on the device package
+type commander interface {
+	ControlTopic() string
+	AckTopic() string
+	Messanger() (device.Messanger, bool)
+}
+
+// NewServer creates the Control service of the devices of dm
+func NewServer(dm *device.DeviceManager) *Server {
+	return &Server{
+		Timeout:   DefaultTimeout,
+		dm:        dm,
+		acks:      make(map[string]chan *device.Ack),
+		listening: make(map[string]bool),
+	}
+}
+
+// ListDevices returns the snapshots of the devices sorted by name
+func (s *Server) ListDevices(ctx context.Context, req *ListDevicesRequest) (*ListDevicesResponse, error) {
+	names := s.dm.List()
+	slices.Sort(names)
+	resp := &ListDevicesResponse{Devices: make([]*Device, 0, len(names))}
+	for _, name := range names {
+		d, ok := s.dm.Get(name)
+		if !ok {
+			continue
+		}
+		pb, err := snapshot(d)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "device %s: %v", name, err)
+		}
+		resp.Devices = append(resp.Devices, pb)
+	}
+	return resp, nil
+}
+
+// GetDevice returns the snapshot of a device
+func (s *Server) GetDevice(ctx context.Context, req *GetDeviceRequest) (*Device, error) {
+	d, ok := s.dm.Get(req.GetName())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "device %q not found", req.GetName())
+	}
+	pb, err := snapshot(d)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "device %s: %v", req.GetName(), err)
+	}
+	return pb, nil
+}
+
+// snapshot returns the Device of d from its JSON, only its name when
+// it has none
+func snapshot(d device.Name) (*Device, error) {
+	pb := &Device{Name: d.Name()}
+	j, ok := d.(interface{ JSON() ([]byte, error) })
+	if !ok {
+		return pb, nil
+	}
+	data, err := j.JSON()
+	if err != nil {
+		return nil, err
+	}
+	var snap struct {
+		State     device.DeviceState
+		Transport string
+		Topics    device.Topics
+		Labels    map[string]string
+		QoS       device.QoS
+		Stats     device.PubStats
+		Period    time.Duration
+		Error     string
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	pb.State = string(snap.State)
+	pb.Transport = snap.Transport
+	pb.Topics = &Topics{
+		Data:         snap.Topics.Data,
+		Control:      snap.Topics.Control,
+		State:        snap.Topics.State,
+		Availability: snap.Topics.Availability,
+		Station:      snap.Topics.Station,
+	}
+	pb.Labels = snap.Labels
+	pb.Qos = uint32(snap.QoS)
+	pb.Stats = &PubStats{
+		Published: int64(snap.Stats.Published),
+		Failed:    int64(snap.Stats.Failed),
+		Dropped:   int64(snap.Stats.Dropped),
+		Buffered:  int64(snap.Stats.Buffered),
+	}
+	pb.Period = durationpb.New(snap.Period)
+	pb.Error = snap.Error
+	return pb, nil
+}
+
+// SendCommand publishes the command on the ControlTopic of the device,
+// for its Router to dispatch like one from any client, and returns the
+// Ack. A command without an ID is given one so it is acked.
+func (s *Server) SendCommand(ctx context.Context, req *SendCommandRequest) (*Ack, error) {
+	d, ok := s.dm.Get(req.GetName())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "device %q not found", req.GetName())
+	}
+	c, ok := d.(commander)
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "device %s takes no commands", req.GetName())
+	}
+	m, ok := c.Messanger()
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "device %s: %v", req.GetName(), device.ErrNoMessanger)
+	}
+	cmd, err := device.ParseCommand(req.GetPayload())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if cmd.ID == "" {
+		cmd.ID = "grpc-" + strconv.FormatUint(s.ids.Add(1), 10)
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	acked, err := s.expect(m, c.AckTopic(), cmd.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "device %s acks: %v", req.GetName(), err)
+	}
+	defer s.forget(cmd.ID)
+	if err := m.PublishQoS(c.ControlTopic(), payload, device.AtLeastOnce, false); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "device %s: %v", req.GetName(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	select {
+	case ack := <-acked:
+		return &Ack{Id: ack.ID, Ok: ack.OK, Error: ack.Error, LatencyMs: ack.Latency}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(fmt.Errorf("device %s did not ack %s: %w", req.GetName(), cmd.ID, ctx.Err())).Err()
+	}
+}
+
+// expect returns the channel the Ack of the command id comes on,
+// subscribing to the AckTopic of the device the first time
+func (s *Server) expect(m device.Messanger, topic, id string) (<-chan *device.Ack, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.listening[topic] {
+		if err := m.SubscribeQoS(topic, device.AtLeastOnce, s.acked); err != nil {
+			return nil, err
+		}
+		s.listening[topic] = true
+	}
+	ch := make(chan *device.Ack, 1)
+	s.acks[id] = ch
+	return ch, nil
+}
+
+func (s *Server) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.acks, id)
+}
+
+// acked hands an Ack to the SendCommand waiting for it, the ones of
+// the commands of other clients are not
+func (s *Server) acked(msg *device.Msg) {
+	ack := &device.Ack{}
+	if err := json.Unmarshal(msg.Data, ack); err != nil {
+		slog.Warn("command ack", "topic", msg.Topic, "error", err)
+		return
+	}
+	s.mu.Lock()
+	ch, ok := s.acks[ack.ID]
+	delete(s.acks, ack.ID)
+	s.mu.Unlock()
+	if ok {
+		ch <- ack
+	}
+}
+
+// StreamEvents streams the events of the devices the request picks
+// until the client cancels
+func (s *Server) StreamEvents(req *StreamEventsRequest, stream grpc.ServerStreamingServer[Event]) error {
+	filter := &device.StreamFilter{Devices: req.GetDevices()}
+	for _, typ := range req.GetTypes() {
+		filter.Types = append(filter.Types, device.EventType(typ))
+	}
+	events, stop := device.SubscribeEvents(streamBuffer)
+	defer stop()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return status.FromContextError(err).Err()
+		case ev := <-events:
+			if !filter.Match(ev) {
+				continue
+			}
+			err := stream.Send(&Event{
+				Type:   string(ev.Type),
+				Device: ev.Device,
+				Topic:  ev.Topic,
+				Data:   ev.Data,
+				Time:   timestamppb.New(ev.Time),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package device
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCSVInterval is how long a row of a CSVSource is read without
+// a time column
+const DefaultCSVInterval = time.Second
+
+// ErrCSVColumn is a column of a CSVColumn mapping the CSV has not
+var ErrCSVColumn = errors.New("no such csv column")
+
+// CSVColumn maps the column of a CSV to the field of a device, the
+// values of the field taken by the name
+type CSVColumn struct {
+	Column string
+	Device string
+	Field  string
+}
+
+// CSVError is a row of a CSV skipped, the line and why
+type CSVError struct {
+	Line int
+	Err  error
+}
+
+func (e *CSVError) Error() string {
+	return fmt.Sprintf("csv line %d: %v", e.Line, e.Err)
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+// CSVSource is a MockSource of the rows of a CSV, a mocked station
+// driven by the history of a real one. Its columns feed the fields of
+// the devices as its CSVColumns map them, the devices reading it see
+// the same row, the one of the time since the first read. The rows
+// are read a row long, the interval or the time to the next with a
+// time column. A row that does not convert is reported and skipped.
+//
+// Past the last row the reads fail with ErrMockDone, or it starts
+// over with MockLoop, or the last row stays with MockHoldLast.
+type CSVSource struct {
+	r        io.ReadSeeker
+	close    func() error
+	cols     []CSVColumn
+	tsCol    string
+	layout   string
+	interval time.Duration
+	end      MockMode
+	now      func() time.Time
+	onError  func(*CSVError)
+
+	csv     *csv.Reader
+	index   map[string]int
+	tsIndex int
+	cur     *csvRow
+	next    *csvRow
+	rows    int           // read since the start
+	base    time.Duration // of the rows since the start, after a loop
+	start   time.Time
+	skipped int
+	mu      sync.Mutex
+}
+
+// csvRow is a row converted, the values of the fields by device
+type csvRow struct {
+	at     time.Duration // from the first row
+	span   time.Duration // until the next
+	ts     time.Time
+	values map[string]map[string]float64
+}
+
+// CSVOption configures a CSVSource
+type CSVOption func(*CSVSource)
+
+// CSVTime paces the rows by the time of the column, in layout,
+// time.RFC3339 when it is empty
+func CSVTime(column, layout string) CSVOption {
+	return func(s *CSVSource) {
+		s.tsCol, s.layout = column, layout
+		if s.layout == "" {
+			s.layout = time.RFC3339
+		}
+	}
+}
+
+// CSVInterval sets how long a row is read without a time column
+func CSVInterval(d time.Duration) CSVOption {
+	return func(s *CSVSource) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// CSVAtEnd sets what the source does past its last row, MockOnce by
+// default
+func CSVAtEnd(mode MockMode) CSVOption {
+	return func(s *CSVSource) {
+		s.end = mode
+	}
+}
+
+// CSVClock sets the clock the rows are paced by, time.Now by default
+func CSVClock(now func() time.Time) CSVOption {
+	return func(s *CSVSource) {
+		s.now = now
+	}
+}
+
+// CSVOnError sets fn to be called with the rows skipped, they are
+// logged otherwise
+func CSVOnError(fn func(*CSVError)) CSVOption {
+	return func(s *CSVSource) {
+		s.onError = fn
+	}
+}
+
+// NewCSVSource creates a CSVSource of the CSV read from r, its first
+// line the names of its columns. Rewinding r starts it over.
+func NewCSVSource(r io.ReadSeeker, cols []CSVColumn, opts ...CSVOption) (*CSVSource, error) {
+	s := &CSVSource{
+		r:        r,
+		close:    func() error { return nil },
+		cols:     cols,
+		interval: DefaultCSVInterval,
+		end:      MockOnce,
+		now:      time.Now,
+		onError: func(e *CSVError) {
+			slog.Warn("csv row skipped", "line", e.Line, "error", e.Err)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.rewind(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenCSVSource creates a CSVSource of the file at path
+func OpenCSVSource(path string, cols []CSVColumn, opts ...CSVOption) (*CSVSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	s, err := NewCSVSource(f, cols, opts...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.close = f.Close
+	return s, nil
+}
+
+// Close closes the file of the source
+func (s *CSVSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+// Skipped returns the number of rows skipped
+func (s *CSVSource) Skipped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped
+}
+
+// rewind reads the CSV from its header again
+func (s *CSVSource) rewind() error {
+	if _, err := s.r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("csv: %w", err)
+	}
+	s.csv = csv.NewReader(s.r)
+	s.csv.ReuseRecord = true
+	header, err := s.csv.Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+	s.index = make(map[string]int, len(header))
+	for i, name := range header {
+		s.index[strings.TrimSpace(name)] = i
+	}
+	s.tsIndex = -1
+	if s.tsCol != "" {
+		i, ok := s.index[s.tsCol]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrCSVColumn, s.tsCol)
+		}
+		s.tsIndex = i
+	}
+	for _, c := range s.cols {
+		if _, ok := s.index[c.Column]; !ok {
+			return fmt.Errorf("%w: %q", ErrCSVColumn, c.Column)
+		}
+	}
+	s.rows = 0
+	s.cur = nil
+	s.next, err = s.read(nil)
+	return err
+}
+
+// read returns the next row that converts after prev, nil at the end
+// of the CSV
+func (s *CSVSource) read(prev *csvRow) (*csvRow, error) {
+	for {
+		rec, err := s.csv.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			s.skip(perr.Line, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %w", err)
+		}
+		row, err := s.convert(rec, prev)
+		if err != nil {
+			line, _ := s.csv.FieldPos(0)
+			s.skip(line, err)
+			continue
+		}
+		s.rows++
+		return row, nil
+	}
+}
+
+// convert converts rec, the row after prev
+func (s *CSVSource) convert(rec []string, prev *csvRow) (*csvRow, error) {
+	row := &csvRow{values: make(map[string]map[string]float64)}
+	for _, c := range s.cols {
+		cell := strings.TrimSpace(rec[s.index[c.Column]])
+		v, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %q is not a number", c.Column, cell)
+		}
+		if row.values[c.Device] == nil {
+			row.values[c.Device] = make(map[string]float64)
+		}
+		row.values[c.Device][c.Field] = v
+	}
+
+	if s.tsIndex < 0 {
+		row.at = time.Duration(s.rows) * s.interval
+		row.span = s.interval
+		return row, nil
+	}
+	cell := strings.TrimSpace(rec[s.tsIndex])
+	ts, err := time.Parse(s.layout, cell)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %q is not a time", s.tsCol, cell)
+	}
+	row.ts = ts
+	if prev != nil {
+		if ts.Before(prev.ts) {
+			return nil, fmt.Errorf("column %s: %s before the row before", s.tsCol, cell)
+		}
+		row.at = prev.at + ts.Sub(prev.ts)
+		row.span = ts.Sub(prev.ts)
+	}
+	return row, nil
+}
+
+func (s *CSVSource) skip(line int, err error) {
+	s.skipped++
+	s.onError(&CSVError{Line: line, Err: err})
+}
+
+// NextValue returns the fields of the device named name of the row of
+// the time, a map[string]float64 MockValue takes
+func (s *CSVSource) NextValue(name string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		s.start = s.now()
+	}
+	elapsed := s.now().Sub(s.start) - s.base
+	for {
+		for s.next != nil && s.next.at <= elapsed {
+			var err error
+			s.cur = s.next
+			if s.next, err = s.read(s.cur); err != nil {
+				return nil, err
+			}
+		}
+		if s.cur == nil {
+			return nil, ErrMockDone // no rows
+		}
+		if s.next != nil || elapsed < s.cur.at+s.span() || s.end == MockHoldLast {
+			break
+		}
+		if s.end != MockLoop {
+			return nil, ErrMockDone
+		}
+		end := s.cur.at + s.span()
+		if err := s.rewind(); err != nil {
+			return nil, err
+		}
+		s.base += end
+		elapsed -= end
+	}
+
+	values, ok := s.cur.values[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no fields of %s", ErrCSVColumn, name)
+	}
+	fields := make(map[string]float64, len(values))
+	for k, v := range values {
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+// mockPosition returns the rows read, the one read now the last, the
+// length of the CSV unknown
+func (s *CSVSource) mockPosition() (pos, length int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return 0, 0
+	}
+	if s.next != nil {
+		return s.rows - 1, 0
+	}
+	return s.rows, 0
+}
+
+// span returns how long the last row is read, the interval or the
+// time to it from the row before
+func (s *CSVSource) span() time.Duration {
+	if s.tsIndex < 0 || s.cur.span == 0 {
+		return s.interval
+	}
+	return s.cur.span
+}
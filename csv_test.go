@@ -0,0 +1,145 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const weather = `time,temp,hum,wind
+2024-06-01T00:00:00Z,20.5,40,3
+2024-06-01T00:10:00Z,21,41,4
+2024-06-01T00:15:00Z,bad,41,4
+2024-06-01T00:20:00Z,22,42
+not a time,22,42,5
+2024-06-01T00:05:00Z,22,42,5
+2024-06-01T00:30:00Z, 23 ,43,6
+`
+
+var weatherCols = []CSVColumn{
+	{Column: "temp", Device: "bme280", Field: "temperature"},
+	{Column: "hum", Device: "bme280", Field: "humidity"},
+	{Column: "wind", Device: "anemometer", Field: "speed"},
+}
+
+// clock is a fake clock the tests move
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestCSVSource(t *testing.T) {
+	clk := &clock{now: time.Unix(1000, 0)}
+	var skipped []int
+	src, err := NewCSVSource(strings.NewReader(weather), weatherCols,
+		CSVTime("time", ""), CSVClock(clk.Now), CSVOnError(func(e *CSVError) {
+			skipped = append(skipped, e.Line)
+		}))
+	if err != nil {
+		t.Fatalf("NewCSVSource() error (%v)", err)
+	}
+	bme, wind := NewDevice("bme280", "mqtt"), NewDevice("anemometer", "mqtt")
+	bme.SetMockSource(src)
+	wind.SetMockSource(src)
+
+	type env struct {
+		Temperature float64 `json:"temperature"`
+		Humidity    float64 `json:"humidity"`
+	}
+	read := func(d *Device) map[string]float64 {
+		t.Helper()
+		v, ok, err := d.NextMock()
+		if !ok || err != nil {
+			t.Fatalf("NextMock() %s at %v got (%v, %v)", d.Name, clk.now, ok, err)
+		}
+		return v.(map[string]float64)
+	}
+
+	// the rows of the time, the bad ones skipped
+	start := clk.now
+	for _, tt := range []struct {
+		at        time.Duration
+		temp, spd float64
+	}{
+		{0, 20.5, 3},
+		{9 * time.Minute, 20.5, 3},
+		{10 * time.Minute, 21, 4},
+		{25 * time.Minute, 21, 4},
+		{30 * time.Minute, 23, 6},
+		{49 * time.Minute, 23, 6},
+	} {
+		clk.now = start.Add(tt.at)
+		got := read(bme)
+		if got["temperature"] != tt.temp {
+			t.Errorf("at %v temperature got (%v) want (%v)", tt.at, got, tt.temp)
+		}
+		if got := read(wind); !reflect.DeepEqual(got, map[string]float64{"speed": tt.spd}) {
+			t.Errorf("at %v wind got (%v) want (%v)", tt.at, got, tt.spd)
+		}
+	}
+	if !slices.Equal(skipped, []int{4, 5, 6, 7}) || src.Skipped() != 4 {
+		t.Errorf("skipped lines got (%v) want (4 5 6 7)", skipped)
+	}
+	v, _, _ := bme.NextMock()
+	if got, err := MockValue[env](v); err != nil || *got != (env{23, 43}) {
+		t.Errorf("MockValue() got (%v, %v)", got, err)
+	}
+
+	clk.now = start.Add(50 * time.Minute)
+	if _, _, err := bme.NextMock(); !errors.Is(err, ErrMockDone) {
+		t.Errorf("NextMock() past the last row error got (%v) want (%v)", err, ErrMockDone)
+	}
+
+	if _, err := NewCSVSource(strings.NewReader(weather), []CSVColumn{{Column: "rain"}}); !errors.Is(err, ErrCSVColumn) {
+		t.Errorf("NewCSVSource() mapping a missing column error got (%v) want (%v)", err, ErrCSVColumn)
+	}
+	other := NewDevice("other", "mqtt")
+	other.SetMockSource(src)
+	if _, _, err := other.NextMock(); err == nil {
+		t.Error("NextMock() of a device not mapped got no error")
+	}
+}
+
+func TestCSVSourceAtEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "levels.csv")
+	os.WriteFile(path, []byte("level\n1\n2\n3\n"), 0o644)
+	cols := []CSVColumn{{Column: "level", Device: "tank", Field: "cm"}}
+
+	for _, tt := range []struct {
+		name string
+		end  MockMode
+		at   []time.Duration
+		want []float64 // 0 the reads failing
+	}{
+		{"stop", MockOnce, []time.Duration{0, 1, 2, 3}, []float64{1, 2, 3, 0}},
+		{"loop", MockLoop, []time.Duration{0, 2, 3, 4, 7, 9}, []float64{1, 3, 1, 2, 2, 1}},
+		{"hold", MockHoldLast, []time.Duration{0, 2, 3, 100}, []float64{1, 3, 3, 3}},
+	} {
+		clk := &clock{now: time.Unix(0, 0)}
+		src, err := OpenCSVSource(path, cols, CSVInterval(time.Minute), CSVAtEnd(tt.end), CSVClock(clk.Now))
+		if err != nil {
+			t.Fatalf("%s OpenCSVSource() error (%v)", tt.name, err)
+		}
+		d := NewDevice("tank", "mqtt")
+		d.SetMockSource(src)
+		start := clk.now
+		for i, at := range tt.at {
+			clk.now = start.Add(at * time.Minute)
+			v, _, err := d.NextMock()
+			if tt.want[i] == 0 {
+				if !errors.Is(err, ErrMockDone) {
+					t.Errorf("%s at %vm error got (%v) want (%v)", tt.name, at, err, ErrMockDone)
+				}
+				continue
+			}
+			if err != nil || v.(map[string]float64)["cm"] != tt.want[i] {
+				t.Errorf("%s at %vm got (%v, %v) want (%v)", tt.name, at, v, err, tt.want[i])
+			}
+		}
+		src.Close()
+	}
+}
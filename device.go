@@ -7,8 +7,10 @@ package device
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"sync"
 	"time"
 )
@@ -49,11 +51,15 @@ type mockConfig struct {
 
 var mockCfg = &mockConfig{}
 
-// Mock enables or disables mock device behavior
+// Mock enables or disables mock device behavior, warned of outside
+// of the tests
 func Mock(mocking bool) {
 	mockCfg.mu.Lock()
-	defer mockCfg.mu.Unlock()
 	mockCfg.enabled = mocking
+	mockCfg.mu.Unlock()
+	if mocking {
+		warnMocks("mock mode on, the readings are not of the sensors")
+	}
 }
 
 // IsMock returns the current mock state
@@ -70,9 +76,131 @@ type Device struct {
 	Period time.Duration // Period for timed operations
 	Val    any           // Mock value storage
 
-	err    error        // Last error encountered (use SetError to set)
-	mu     sync.RWMutex // Protects device state
-	Opener              // Device opening interface
+	err       error                 // Last error encountered (use SetError to set)
+	transport string                // Selects the Messanger, see SetMessanger
+	topics    Topics                // Resolved when the device is created
+	retain    Retain                // Which publishes the transport keeps
+	qos       QoS                   // Of the publishes and subscriptions, see WithQoS
+	stats     PubStats              // Counts the publishes
+	enveloped bool                  // Wraps PubData and PubState, see Envelope
+	enc       Encoder               // Of PubData and PubState, JSON when nil
+	labels    map[string]string     // Like the room, see WithLabels
+	seq       map[string]uint64     // The Envelope seq of each topic
+	msgr      Messanger             // Set by WithMessanger, else the transport's
+	mock      *mockSequence         // The values read, see SetMockSequence
+	rand      *MockRand             // The mock randomness, see SetMockSeed
+	seeded    bool                  // The randomness seeded by SetMockSeed
+	recorder  *Recorder             // Records the readings, see SetRecorder
+	source    MockSource            // The values read, see SetMockSource
+	faults    *faults               // The failures injected, see InjectFaults
+	latency   *latency              // The delay of the mock reads, see SetLatency
+	fields    []string              // The fields mock values are injected in
+	injected  map[string]*injection // The mock values injected, see InjectMock
+	clock     Clock                 // Tells the time and ticks, see SetClock
+	kind      string                // Of its mock profiles, see SetMockKind
+	self      any                   // The device of its package, see SetMockKind
+	profile   string                // The mock profile applied, see MockProfile
+	inhibits  []string              // What holds its commands back, see Inhibit
+	mu        sync.RWMutex          // Protects device state
+	Opener                          // Device opening interface
+}
+
+// Retain tells which publishes of a device the transport keeps for
+// late subscribers. Data is the readings, a time series a late
+// subscriber has no use for the last of, State what the device is
+// doing now.
+type Retain struct {
+	Data  bool
+	State bool
+}
+
+// DefaultRetain retains the state of a device but not its data
+var DefaultRetain = Retain{State: true}
+
+// Option configures a Device in NewDevice
+type Option func(*Device)
+
+// WithMessanger makes the device use m rather than the Messanger of
+// its transport
+func WithMessanger(m Messanger) Option {
+	return func(d *Device) {
+		d.msgr = m
+	}
+}
+
+// WithRetain sets which of the device's publishes are retained,
+// DefaultRetain unless it is given
+func WithRetain(r Retain) Option {
+	return func(d *Device) {
+		d.retain = r
+	}
+}
+
+// WithQoS sets the QoS the device publishes and subscribes at,
+// AtMostOnce unless it is given. A relay takes its commands at
+// AtLeastOnce, a sensor sampling every second needs no more than
+// AtMostOnce. Retained publishes go at AtLeastOnce at least.
+func WithQoS(q QoS) Option {
+	return func(d *Device) {
+		d.qos = q
+	}
+}
+
+// WithLabels labels the device, like {"room": "kitchen"}, for the
+// consumers that group the devices like the InfluxDB tags
+func WithLabels(labels map[string]string) Option {
+	return func(d *Device) {
+		d.labels = maps.Clone(labels)
+	}
+}
+
+// Labels returns the labels of the device
+func (d *Device) Labels() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return maps.Clone(d.labels)
+}
+
+// PubOption overrides the device's settings for one publish, like an
+// alert that must not be lost from a device publishing at QoS 0
+type PubOption func(*pubOpts)
+
+type pubOpts struct {
+	qos    QoS
+	retain bool
+}
+
+// PubQoS publishes at q
+func PubQoS(q QoS) PubOption {
+	return func(o *pubOpts) {
+		o.qos = q
+	}
+}
+
+// PubRetain has the publish retained or not
+func PubRetain(retain bool) PubOption {
+	return func(o *pubOpts) {
+		o.retain = retain
+	}
+}
+
+// PubStats counts the publishes of a device. Failed are the ones the
+// transport returned an error for, a publish at QoS 1 not
+// acknowledged included, Dropped the ones without a Messanger.
+// Buffered failed but were kept by a Buffer to publish again.
+type PubStats struct {
+	Published int `json:"published"`
+	Failed    int `json:"failed"`
+	Dropped   int `json:"dropped"`
+	Buffered  int `json:"buffered"`
+}
+
+// WithTopic sets the topic the device publishes its data on, the
+// station's data topic of its name by default
+func WithTopic(topic string) Option {
+	return func(d *Device) {
+		d.topics.Data = topic
+	}
 }
 
 // SetError sets the device error and updates the state to StateError
@@ -92,15 +220,282 @@ func (d *Device) Error() error {
 	return d.err
 }
 
-// NewDevice creates a new device with the given name
-func NewDevice(name string, t string) *Device {
-	return &Device{
+// Open opens the device's Opener, devices without one have nothing
+// to open. It fails when FailOpen says so.
+func (d *Device) Open() error {
+	if err := d.openFault(); err != nil {
+		return err
+	}
+	if d.Opener == nil {
+		return nil
+	}
+	return d.Opener.Open()
+}
+
+// Close closes the device's Opener, devices without one have nothing
+// to close.
+func (d *Device) Close() error {
+	if d.Opener == nil {
+		return nil
+	}
+	return d.Opener.Close()
+}
+
+// NewDevice creates a new device with the given name publishing over
+// transport, the name of a Messanger set with SetMessanger like
+// "mqtt"
+func NewDevice(name string, transport string, opts ...Option) *Device {
+	d := &Device{
 		Name:      name,
 		State:     StateUnknown,
+		transport: transport,
+		topics:    stationTopicsOf(name),
+		retain:    DefaultRetain,
+		enveloped: Envelopes(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Transport returns the name of the device's transport
+func (d *Device) Transport() string {
+	return d.transport
+}
+
+// Topic returns the topic PubData publishes on, the DataTopic
+func (d *Device) Topic() string {
+	return d.DataTopic()
+}
+
+// DataTopic returns the topic the device publishes its data on
+func (d *Device) DataTopic() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.topics.Data
+}
+
+// ControlTopic returns the topic the device takes its commands on
+func (d *Device) ControlTopic() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.topics.Control
+}
+
+// StateTopic returns the topic the device publishes its state on
+func (d *Device) StateTopic() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.topics.State
+}
+
+// SetTopic sets the topic the device publishes its data on
+func (d *Device) SetTopic(topic string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.topics.Data = topic
+}
+
+// Retain returns which of the device's publishes are retained
+func (d *Device) Retain() Retain {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.retain
+}
+
+// SetRetain sets which of the device's publishes are retained
+func (d *Device) SetRetain(r Retain) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retain = r
+}
+
+// QoS returns the QoS the device publishes and subscribes at
+func (d *Device) QoS() QoS {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.qos
+}
+
+// SetQoS sets the QoS the device publishes and subscribes at, the
+// subscriptions taken before keep theirs
+func (d *Device) SetQoS(q QoS) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.qos = q
+}
+
+// PubStats returns the counts of the device's publishes
+func (d *Device) PubStats() PubStats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.stats
+}
+
+// SetTopics overrides the station's topic templates for the device,
+// the ones left empty stay the station's. The topics are resolved at
+// once, a template that can not be is an ErrTopic.
+func (d *Device) SetTopics(t Topics) error {
+	t = GetTopics().With(t)
+	if err := t.Valid(); err != nil {
+		return fmt.Errorf("%s: %w", d.Name, err)
+	}
+	topics := t.Resolve(Station(), d.Name)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.topics = topics
+	return nil
+}
+
+// Messanger returns the Messanger the device publishes on, the one it
+// was given or the one of its transport
+func (d *Device) Messanger() (Messanger, bool) {
+	d.mu.RLock()
+	m := d.msgr
+	d.mu.RUnlock()
+	if m != nil {
+		return m, true
+	}
+	return GetMessanger(d.transport)
+}
+
+// PubData publishes data on the device's topic. JSON and other []byte
+// or strings go as they are, numbers and bools formatted and anything
+// else as JSON. Without a Messanger the data is dropped, the device
+// runs without a broker. The options override the device's QoS and
+// Retain for this publish. The payload is wrapped in an Envelope when
+// the device is Enveloped, and encoded by the device's Encoder, JSON
+// unless it was given another. The data published is an EventReading.
+func (d *Device) PubData(data any, opts ...PubOption) error {
+	return d.pub(EventReading, d.DataTopic(), data, d.Retain().Data, opts)
+}
+
+// PubState publishes the state of the device on its StateTopic, data
+// is encoded and the options taken like PubData does, an EventState
+func (d *Device) PubState(data any, opts ...PubOption) error {
+	return d.pub(EventState, d.StateTopic(), data, d.Retain().State, opts)
+}
+
+// pub encodes data with the device's Encoder and publishes it on
+// topic with the Encoder's suffix
+func (d *Device) pub(typ EventType, topic string, data any, retain bool, opts []PubOption) error {
+	enc := d.Encoder()
+	env := d.envelope(topic)
+	payload, err := d.encode(enc, data, env)
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.Name, err)
+	}
+	bf := &backfill{typ: typ, encode: func() ([]byte, error) {
+		if env == nil {
+			return payload, nil
+		}
+		replayed := *env
+		replayed.Replay = true
+		return d.encode(enc, data, &replayed)
+	}}
+	if err := d.publish(topic+enc.Suffix(), payload, retain, opts, bf); err != nil {
+		return err
+	}
+	emit(typ, d.Name, topic, data)
+	return nil
+}
+
+func (d *Device) encode(enc Encoder, data any, env *Envelope) ([]byte, error) {
+	if de, ok := enc.(deviceEncoder); ok {
+		return de.encodeDevice(d, data, env)
+	}
+	return enc.Encode(data, env)
+}
+
+// PubRetained publishes data on topic for the transport to keep, for
+// the topics a late subscriber needs like availability and discovery.
+// Nil clears the topic.
+func (d *Device) PubRetained(topic string, data any, opts ...PubOption) error {
+	var payload []byte
+	if data != nil {
+		p, err := textPayload(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.Name, err)
+		}
+		payload = p
+	}
+	return d.publish(topic, payload, true, opts, nil)
+}
+
+// ClearRetained clears what is retained on the data, state and
+// availability topics of the device, so a device removed is not shown
+// with its last values
+func (d *Device) ClearRetained() error {
+	return errors.Join(
+		d.PubRetained(d.DataTopic()+d.Encoder().Suffix(), nil),
+		d.PubRetained(d.StateTopic()+d.Encoder().Suffix(), nil),
+		d.PubRetained(d.AvailabilityTopic(), nil),
+	)
+}
+
+// publish sends payload on topic, a failure is the device's error
+// and counted in its PubStats. A failure is kept to publish again
+// when the Messanger is a Buffer, or a Fanout with one, and bf tells
+// how.
+func (d *Device) publish(topic string, payload []byte, retain bool, opts []PubOption, bf *backfill) error {
+	o := pubOpts{qos: d.QoS(), retain: retain}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.retain {
+		o.qos = max(o.qos, AtLeastOnce)
+	}
+
+	m, ok := d.Messanger()
+	if !ok {
+		if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+			slog.Debug("no messanger, data dropped", "device", d.Name, "transport", d.transport)
+		}
+		d.mu.Lock()
+		d.stats.Dropped++
+		d.mu.Unlock()
+		return nil
+	}
+	var err error
+	if b, ok := m.(buffering); ok && bf != nil {
+		var buffered bool
+		if buffered, err = b.publishBuffered(topic, payload, o.qos, o.retain, bf); buffered {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.stats.Buffered++
+			return nil
+		}
+	} else {
+		err = m.PublishQoS(topic, payload, o.qos, o.retain)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", d.Name, err)
+		d.SetError(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.stats.Failed++
+	} else {
+		d.stats.Published++
+	}
+	return err
+}
+
+// Subscribe calls cb with the messages on topics matching topic, like
+// the ControlTopic of the device, at the device's QoS. A handler of
+// commands at AtLeastOnce wraps cb in Dedupe.
+func (d *Device) Subscribe(topic string, cb func(*Msg)) error {
+	m, ok := d.Messanger()
+	if !ok {
+		return fmt.Errorf("%w %q: %s", ErrNoMessanger, d.transport, d.Name)
 	}
+	return m.SubscribeQoS(topic, d.QoS(), cb)
 }
 
-// TimerLoop runs periodic operations with context support
+// TimerLoop runs periodic operations with context support, ticking
+// by the Clock of the device
 func (d *Device) TimerLoop(ctx context.Context, period time.Duration, readpub func() error) error {
 	if period <= 0 {
 		return fmt.Errorf("invalid period: %v", period)
@@ -109,7 +504,7 @@ func (d *Device) TimerLoop(ctx context.Context, period time.Duration, readpub fu
 	d.Period = period
 	d.State = StateRunning
 
-	ticker := time.NewTicker(period)
+	ticker := d.Clock().NewTicker(period)
 	defer ticker.Stop()
 
 	for {
@@ -141,12 +536,21 @@ func (d *Device) JSON() ([]byte, error) {
 	j := struct {
 		Name      string
 		State     DeviceState
+		Transport string
+		Topics    Topics
+		Labels    map[string]string `json:",omitempty"`
+		QoS       QoS
+		Stats     PubStats
 		Period    time.Duration
 		Error     string
 	}{
 		Name:      d.Name,
 		State:     d.State,
-		Messanger: d.Messanger,
+		Transport: d.transport,
+		Topics:    d.topics,
+		Labels:    d.labels,
+		QoS:       d.qos,
+		Stats:     d.stats,
 		Period:    d.Period,
 		Error:     errString(d.err),
 	}
@@ -0,0 +1,37 @@
+/*
+Package device is the device package at the path it had in the otto
+module, github.com/rustyeddy/otto/device, for the code written against
+it to move to this module by changing the module of its imports.
+
+The aliases are only seen by the code whose imports were moved to
+this module, github.com/rustyeddy/otto/device itself is the otto
+module's package, its deprecation is to be marked there.
+
+Deprecated: the device package is the root of this module, import
+github.com/rustyeddy/otto-devices instead. Only what the devices of the
+otto module used is aliased here.
+*/
+package device
+
+import (
+	device "github.com/rustyeddy/otto-devices"
+)
+
+type (
+	// Deprecated: use device.Device of github.com/rustyeddy/otto-devices
+	Device = device.Device
+
+	// Deprecated: use device.Msg of github.com/rustyeddy/otto-devices
+	Msg = device.Msg
+)
+
+var (
+	// Deprecated: use device.NewDevice of github.com/rustyeddy/otto-devices
+	NewDevice = device.NewDevice
+
+	// Deprecated: use device.Mock of github.com/rustyeddy/otto-devices
+	Mock = device.Mock
+
+	// Deprecated: use device.IsMock of github.com/rustyeddy/otto-devices
+	IsMock = device.IsMock
+)
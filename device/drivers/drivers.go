@@ -0,0 +1,58 @@
+/*
+Package drivers is the drivers package at the path it had in the otto
+module, github.com/rustyeddy/otto/device/drivers, for the code written
+against it to move to this module by changing the module of its
+imports.
+
+The aliases are only seen by the code whose imports were moved to
+this module, github.com/rustyeddy/otto/device/drivers itself is the
+otto module's package, its deprecation is to be marked there.
+
+Deprecated: import github.com/rustyeddy/otto-devices/drivers instead.
+Only what the devices of the otto module used is aliased here.
+*/
+package drivers
+
+import (
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+type (
+	// Deprecated: use drivers.GPIO of github.com/rustyeddy/otto-devices/drivers
+	GPIO = drivers.GPIO
+
+	// Deprecated: use drivers.DigitalPin of github.com/rustyeddy/otto-devices/drivers
+	DigitalPin = drivers.DigitalPin
+
+	// Deprecated: use drivers.AnalogPin of github.com/rustyeddy/otto-devices/drivers
+	AnalogPin = drivers.AnalogPin
+
+	// Deprecated: use drivers.ADS1115 of github.com/rustyeddy/otto-devices/drivers
+	ADS1115 = drivers.ADS1115
+
+	// Deprecated: use drivers.Serial of github.com/rustyeddy/otto-devices/drivers
+	Serial = drivers.Serial
+)
+
+var (
+	// Deprecated: use drivers.GetGPIO of github.com/rustyeddy/otto-devices/drivers
+	GetGPIO = drivers.GetGPIO
+
+	// Deprecated: use drivers.NewDigitalPin of github.com/rustyeddy/otto-devices/drivers
+	NewDigitalPin = drivers.NewDigitalPin
+
+	// Deprecated: use drivers.GetADS1115 of github.com/rustyeddy/otto-devices/drivers
+	GetADS1115 = drivers.GetADS1115
+
+	// Deprecated: use drivers.NewADS1115 of github.com/rustyeddy/otto-devices/drivers
+	NewADS1115 = drivers.NewADS1115
+
+	// Deprecated: use drivers.NewMockAnalogPin of github.com/rustyeddy/otto-devices/drivers
+	NewMockAnalogPin = drivers.NewMockAnalogPin
+
+	// Deprecated: use drivers.GetI2CDriver of github.com/rustyeddy/otto-devices/drivers
+	GetI2CDriver = drivers.GetI2CDriver
+
+	// Deprecated: use drivers.NewSerial of github.com/rustyeddy/otto-devices/drivers
+	NewSerial = drivers.NewSerial
+)
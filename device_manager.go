@@ -1,21 +1,29 @@
 package device
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sort"
 	"sync"
 )
 
 // DeviceManager handles the registration and retrieval of devices.
 // It ensures thread-safe access to the device collection.
 type DeviceManager struct {
-	devices map[string]Name `json:"devices"`
-	mu      sync.RWMutex    `json:"-"`
+	devices  map[string]Name `json:"devices"`
+	discover bool            // PubDiscovery of the devices added
+	mu       sync.RWMutex    `json:"-"`
 }
 
 var (
-	stationName string = "station"
-	devices     *DeviceManager
-	once        sync.Once
+	devices *DeviceManager
+	once    sync.Once
+
+	statusFuncs   = make(map[string]func() any)
+	statusFuncsMu sync.Mutex
 )
 
 // GetDeviceManager returns the singleton instance of DeviceManager.
@@ -29,18 +37,36 @@ func GetDeviceManager() *DeviceManager {
 	return devices
 }
 
-// Add registers a new device with the manager.
-// If a device with the same name exists, it will be replaced.
+// Add registers a new device with the manager, an EventAdded. The
+// schemas of a Describer are published, its discovery when the
+// manager's was, see PubDiscovery.
+// If a device with the same name exists, it will be replaced, and
+// closed like on Remove so its timers and pins are released.
 func (dm *DeviceManager) Add(d Name) error {
 	if d == nil {
 		return fmt.Errorf("cannot add nil device")
 	}
 
 	dm.mu.Lock()
-	defer dm.mu.Unlock()
-
+	old, replaced := dm.devices[d.Name()]
 	dm.devices[d.Name()] = d
-	return nil
+	discover := dm.discover
+	dm.mu.Unlock()
+
+	var errs []error
+	if replaced && !same(old, d) {
+		if err := closeDevice(old); err != nil {
+			errs = append(errs, fmt.Errorf("closing the %s replaced: %w", d.Name(), err))
+		}
+	}
+	emit(EventAdded, d.Name(), "", nil)
+	if dd, ok := d.(describer); ok {
+		errs = append(errs, dd.PubSchemas(dd.Schemas()))
+	}
+	if dd, ok := d.(discoverer); ok && discover {
+		errs = append(errs, dd.PubDiscovery(dd.Entities()))
+	}
+	return errors.Join(errs...)
 }
 
 // Get retrieves a device by name.
@@ -53,17 +79,74 @@ func (dm *DeviceManager) Get(name string) (Name, bool) {
 	return d, exists
 }
 
-// Remove removes a device from the manager.
+// Remove removes a device from the manager, devices holding
+// resources (implementing io.Closer) are closed so their pins and
+// buses are released. What the device retained on the broker is
+// cleared, its schemas too, it is gone rather than stopped like on
+// Shutdown, an EventRemoved.
 // Returns true if the device was removed, false if it didn't exist.
 func (dm *DeviceManager) Remove(name string) bool {
 	dm.mu.Lock()
-	defer dm.mu.Unlock()
+	d, exists := dm.devices[name]
+	delete(dm.devices, name)
+	dm.mu.Unlock()
 
-	if _, exists := dm.devices[name]; exists {
-		delete(dm.devices, name)
-		return true
+	if !exists {
+		return false
 	}
-	return false
+	emit(EventRemoved, name, "", nil)
+	if dd, ok := d.(discoverer); ok {
+		if err := dd.ClearDiscovery(dd.Entities()); err != nil {
+			slog.Error("Failed to clear discovery", "device", name, "error", err)
+		}
+	}
+	if dd, ok := d.(describer); ok {
+		if err := dd.ClearSchemas(); err != nil {
+			slog.Error("Failed to clear schemas", "device", name, "error", err)
+		}
+	}
+	if r, ok := d.(interface{ ClearRetained() error }); ok {
+		if err := r.ClearRetained(); err != nil {
+			slog.Error("Failed to clear retained topics", "device", name, "error", err)
+		}
+	}
+	if err := closeDevice(d); err != nil {
+		slog.Error("Failed to close device", "device", name, "error", err)
+	}
+	return true
+}
+
+// Shutdown closes every registered device that implements io.Closer
+// and removes all devices from the manager. The Messangers set are
+// closed last, announcing the station "offline" rather than leaving
+// it to the will.
+func (dm *DeviceManager) Shutdown() error {
+	dm.mu.Lock()
+	all := dm.devices
+	dm.devices = make(map[string]Name)
+	dm.mu.Unlock()
+
+	var errs []error
+	for name, d := range all {
+		if err := closeDevice(d); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", name, err))
+		}
+	}
+	errs = append(errs, closeMessangers())
+	return errors.Join(errs...)
+}
+
+// same reports if a and b are the same device, added again
+func same(a, b Name) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+func closeDevice(d Name) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
 // List returns a slice of all registered device names.
@@ -84,4 +167,43 @@ func (dm *DeviceManager) Clear() {
 	defer dm.mu.Unlock()
 
 	dm.devices = make(map[string]Name)
+	dm.discover = false
+}
+
+// RegisterStatus adds fn to the manager's Status under key, packages
+// use it to report diagnostics like the gpio lines they hold. A nil
+// fn removes the key.
+func RegisterStatus(key string, fn func() any) {
+	statusFuncsMu.Lock()
+	defer statusFuncsMu.Unlock()
+	if fn == nil {
+		delete(statusFuncs, key)
+		return
+	}
+	statusFuncs[key] = fn
+}
+
+// Status returns the sorted names of the registered devices under
+// "devices" along with the diagnostics of every registered status
+// function, ready to be served as JSON or published. What is faked
+// is under "mocks", the MockReport, when anything is.
+func (dm *DeviceManager) Status() map[string]any {
+	names := dm.List()
+	sort.Strings(names)
+	status := map[string]any{"devices": names}
+
+	statusFuncsMu.Lock()
+	funcs := make(map[string]func() any, len(statusFuncs))
+	for key, fn := range statusFuncs {
+		funcs[key] = fn
+	}
+	statusFuncsMu.Unlock()
+
+	for key, fn := range funcs {
+		status[key] = fn()
+	}
+	if mocks := Mocks(); mocks.active() {
+		status["mocks"] = mocks
+	}
+	return status
 }
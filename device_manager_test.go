@@ -1,7 +1,9 @@
 package device
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -217,3 +219,217 @@ func TestDeviceManager_ConcurrentAccess(t *testing.T) {
 		t.Errorf("Expected %d devices after concurrent adds, got %d", deviceCount, len(dm.List()))
 	}
 }
+
+// closerDevice records when the manager closes it
+type closerDevice struct {
+	mockDevice
+	closed int
+}
+
+func (c *closerDevice) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestDeviceManager_RemoveCloses(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+
+	d := &closerDevice{mockDevice: mockDevice{name: "relay"}}
+	dm.Add(d)
+	dm.Add(&mockDevice{name: "plain"})
+
+	if !dm.Remove("relay") {
+		t.Fatal("Remove() = false, want true")
+	}
+	if d.closed != 1 {
+		t.Errorf("Remove() closed device %d times, want 1", d.closed)
+	}
+	if !dm.Remove("plain") {
+		t.Error("Remove() = false for a device without Close")
+	}
+}
+
+func TestDeviceManager_AddCloses(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+	defer dm.Clear()
+
+	first := &closerDevice{mockDevice: mockDevice{name: "relay"}}
+	dm.Add(first)
+	dm.Add(first)
+	if first.closed != 0 {
+		t.Errorf("Add() again closed the device %d times, want 0", first.closed)
+	}
+
+	second := &closerDevice{mockDevice: mockDevice{name: "relay"}}
+	if err := dm.Add(second); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if first.closed != 1 || second.closed != 0 {
+		t.Errorf("Add() closed the replaced %d times and the new %d, want 1 and 0", first.closed, second.closed)
+	}
+	if got, _ := dm.Get("relay"); got != second {
+		t.Error("Add() did not replace the device")
+	}
+}
+
+func TestDeviceManager_Shutdown(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+
+	devs := []*closerDevice{
+		{mockDevice: mockDevice{name: "led"}},
+		{mockDevice: mockDevice{name: "relay"}},
+	}
+	for _, d := range devs {
+		dm.Add(d)
+	}
+	dm.Add(&mockDevice{name: "plain"})
+
+	if err := dm.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	for _, d := range devs {
+		if d.closed != 1 {
+			t.Errorf("Shutdown() closed %s %d times, want 1", d.name, d.closed)
+		}
+	}
+	if n := len(dm.List()); n != 0 {
+		t.Errorf("Shutdown() left %d devices registered", n)
+	}
+}
+
+func TestDeviceManager_Status(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+	dm.Add(&mockDevice{name: "relay"})
+	dm.Add(&mockDevice{name: "led"})
+
+	RegisterStatus("test", func() any { return 42 })
+	defer RegisterStatus("test", nil)
+
+	status := dm.Status()
+	names, ok := status["devices"].([]string)
+	if !ok || len(names) != 2 || names[0] != "led" || names[1] != "relay" {
+		t.Errorf("Status() devices got (%v) want ([led relay])", status["devices"])
+	}
+	if status["test"] != 42 {
+		t.Errorf("Status() test got (%v) want (42)", status["test"])
+	}
+
+	RegisterStatus("test", nil)
+	if _, ex := dm.Status()["test"]; ex {
+		t.Error("Status() still reports a removed key")
+	}
+	dm.Clear()
+}
+
+// sensor is a device with a reading, or an error
+type sensor struct {
+	temp float64
+	err  error
+}
+
+type reading struct {
+	Temp     float64  `json:"temp"`
+	Humidity *float64 `json:"humidity"`
+	Wind     struct {
+		Speed int    `json:"speed"`
+		Dir   string `json:"dir"`
+	} `json:"wind"`
+	Alarm bool      `json:"alarm"`
+	Trend []float64 `json:"trend"`
+}
+
+func (s *sensor) Name() string { return "weather" }
+
+func (s *sensor) Read() (*reading, error) {
+	r := &reading{Temp: s.temp, Trend: []float64{0.5, -0.25}}
+	r.Wind.Speed, r.Wind.Dir = 12, "NW"
+	return r, s.err
+}
+
+// gauge reads a bare value
+type gauge struct{}
+
+func (gauge) Name() string  { return "gauge" }
+func (gauge) Read() float64 { return 101325.25 }
+
+func TestDeviceManager_Lines(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+	s := &sensor{temp: 21.4567}
+	dm.Add(s)
+	dm.Add(gauge{})
+	dm.Add(&mockDevice{name: "relay"})
+	defer dm.Clear()
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"weather", []string{
+			"weather temp 21.46",
+			"weather humidity -",
+			"weather wind speed 12",
+			"weather wind dir NW",
+			"weather alarm false",
+			"weather trend 0 0.5",
+			"weather trend 1 -0.25",
+		}},
+		{"gauge", []string{"gauge 101325.2"}},
+		{"relay", []string{"relay ?"}},
+		{"nothing", []string{"nothing -"}},
+	}
+	for _, tt := range tests {
+		if got := dm.Lines(tt.name); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Lines(%s) got (%q) want (%q)", tt.name, got, tt.want)
+		}
+	}
+
+	s.err = errors.New("no response")
+	if got := dm.Lines("weather"); len(got) != 1 || got[0] != "weather error" {
+		t.Errorf("failing Lines(weather) got (%q) want ([weather error])", got)
+	}
+}
+
+// benchManager returns a manager, not the singleton, of n devices and
+// their names
+func benchManager(b *testing.B, n int) (*DeviceManager, []string) {
+	b.Helper()
+	dm := &DeviceManager{devices: make(map[string]Name, n)}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench-%05d", i)
+		if err := dm.Add(&mockDevice{name: names[i]}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dm, names
+}
+
+func BenchmarkManagerGetParallel(b *testing.B) {
+	dm, names := benchManager(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := dm.Get(names[i%len(names)]); !ok {
+				b.Errorf("Get(%s) not found", names[i%len(names)])
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkManagerListLarge(b *testing.B) {
+	dm, names := benchManager(b, 10000)
+	b.ReportAllocs()
+	for b.Loop() {
+		if got := dm.List(); len(got) != len(names) {
+			b.Fatalf("List() got (%d) names want (%d)", len(got), len(names))
+		}
+	}
+}
@@ -105,12 +105,18 @@ func TestDeviceTimerLoop(t *testing.T) {
 	tests := []struct {
 		name    string
 		period  time.Duration
+		ticks   int
 		wantErr bool
 	}{
 		{
-			name:    "valid period",
-			period:  10 * time.Millisecond,
-			wantErr: false,
+			name:   "valid period",
+			period: 10 * time.Millisecond,
+			ticks:  5,
+		},
+		{
+			name:   "a day",
+			period: 24 * time.Hour,
+			ticks:  3,
 		},
 		{
 			name:    "zero period",
@@ -126,24 +132,40 @@ func TestDeviceTimerLoop(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			   d := NewDevice("test-device", "mqtt")
-			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			d := NewDevice("test-device", "mqtt")
+			clock := NewSimClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+			d.SetClock(clock)
+			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
 			calls := 0
-			err := d.TimerLoop(ctx, tt.period, func() error {
-				calls++
-				return nil
-			})
+			done := make(chan error, 1)
+			go func() {
+				done <- d.TimerLoop(ctx, tt.period, func() error {
+					calls++
+					return nil
+				})
+			}()
 
-			if tt.wantErr && err == nil {
-				t.Error("TimerLoop() error = nil, want error")
+			if tt.wantErr {
+				if err := <-done; err == nil {
+					t.Error("TimerLoop() error = nil, want error")
+				}
+				return
+			}
+			for clock.Waiters() == 0 {
+				time.Sleep(time.Millisecond)
 			}
-			if !tt.wantErr && err != context.DeadlineExceeded {
-				t.Errorf("TimerLoop() error = %v, want context.DeadlineExceeded", err)
+			clock.Advance(time.Duration(tt.ticks)*tt.period + tt.period/2)
+			cancel()
+			if err := <-done; err != context.Canceled {
+				t.Errorf("TimerLoop() error = %v, want context.Canceled", err)
 			}
-			if !tt.wantErr && calls == 0 {
-				t.Error("TimerLoop() made no calls to readpub")
+			if calls != tt.ticks {
+				t.Errorf("TimerLoop() made (%d) calls to readpub want (%d)", calls, tt.ticks)
+			}
+			if clock.Waiters() != 0 {
+				t.Error("TimerLoop() left its ticker running")
 			}
 		})
 	}
@@ -196,3 +218,54 @@ func TestMockConfiguration(t *testing.T) {
 		t.Error("Mock should be disabled after Mock(false)")
 	}
 }
+
+func TestDeviceOpenClose(t *testing.T) {
+	d := NewDevice("test-device", "mqtt")
+	if err := d.Open(); err != nil {
+		t.Errorf("Open() without an Opener error = %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("Close() without an Opener error = %v", err)
+	}
+
+	m := &MockOpener{}
+	d.Opener = m
+	if err := d.Open(); err != nil || !m.opened {
+		t.Errorf("Open() error = %v opened = %t", err, m.opened)
+	}
+	if err := d.Close(); err != nil || m.opened {
+		t.Errorf("Close() error = %v opened = %t", err, m.opened)
+	}
+}
+
+// BenchmarkTimerLoopTick is the cost of a tick of the loop with a
+// readpub doing nothing, the hand off of the tick by the SimClock
+// included
+func BenchmarkTimerLoopTick(b *testing.B) {
+	d := NewDevice("bench-loop", "mqtt")
+	clock := NewSimClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	d.SetClock(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- d.TimerLoop(ctx, time.Second, func() error {
+			calls++
+			return nil
+		})
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	clock.Advance(time.Duration(b.N) * time.Second)
+	b.StopTimer()
+	cancel()
+	<-done
+	if calls != b.N {
+		b.Fatalf("TimerLoop() made (%d) calls to readpub want (%d)", calls, b.N)
+	}
+}
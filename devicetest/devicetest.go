@@ -0,0 +1,274 @@
+// Package devicetest provides a capture Messanger so the device
+// packages, and the projects using them, test what the devices
+// publish without a broker. Install one with Use, it is removed when
+// the test ends, then inject the commands on the control topics and
+// expect the payloads the devices publish.
+package devicetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// Timeout is a timeout long enough for a device publishing from a
+// goroutine of its own, and short enough to fail a test soon
+const Timeout = 2 * time.Second
+
+// Capture is a Messanger recording every publish, its topic, payload,
+// QoS and Retained, and delivering them to the subscriptions like a
+// broker does, with the MQTT wildcards and the retained messages. It
+// is a device.MemMessanger, a publish is delivered before it returns.
+type Capture struct {
+	*device.MemMessanger
+
+	t        testing.TB
+	expected map[int]bool // the indexes of the ones an expect returned
+	changed  chan struct{}
+	mu       sync.Mutex
+}
+
+// New creates a Capture for the devices given it WithMessanger
+func New(t testing.TB) *Capture {
+	t.Helper()
+	c := &Capture{
+		MemMessanger: device.NewMemMessanger(),
+		t:            t,
+		expected:     make(map[int]bool),
+		changed:      make(chan struct{}),
+	}
+	c.MemMessanger.Subscribe("#", func(*device.Msg) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		close(c.changed)
+		c.changed = make(chan struct{})
+	})
+	return c
+}
+
+// Use creates a Capture and installs it as the Messanger of the "mqtt"
+// devices for the duration of the test
+func Use(t testing.TB) *Capture {
+	t.Helper()
+	c := New(t)
+	device.SetMessanger("mqtt", c)
+	t.Cleanup(func() { device.SetMessanger("mqtt", nil) })
+	return c
+}
+
+// UseClock installs a SimClock at start as the clock of the devices
+// without one of their own for the duration of the test, their loops
+// ticking as the test advances it
+func UseClock(t testing.TB, start time.Time) *device.SimClock {
+	t.Helper()
+	clock := device.NewSimClock(start)
+	t.Cleanup(device.SetDefaultClock(clock))
+	return clock
+}
+
+// UseStore installs a FileStore in a directory of the test as the
+// store of the devices for the duration of the test, the settings the
+// devices save kept apart from the other tests
+func UseStore(t testing.TB) device.Store {
+	t.Helper()
+	old := device.GetStore()
+	s := device.NewFileStore(t.TempDir())
+	device.SetStore(s)
+	t.Cleanup(func() { device.SetStore(old) })
+	return s
+}
+
+// HardwareEnv returns the environment variable name configuring a
+// test of the real devices, built with the hardware tag. The test is
+// skipped when it is not set, what telling what to set it to.
+func HardwareEnv(t testing.TB, name, what string) string {
+	t.Helper()
+	v := os.Getenv(name)
+	if v == "" {
+		t.Skipf("%s not set, %s", name, what)
+	}
+	return v
+}
+
+// HardwareInt is HardwareEnv of an integer like a pin offset, or an
+// i2c address in hex like 0x76. The test fails on one that is not.
+func HardwareInt(t testing.TB, name, what string) int {
+	t.Helper()
+	v := HardwareEnv(t, name, what)
+	n, err := strconv.ParseInt(v, 0, 0)
+	if err != nil {
+		t.Fatalf("%s=%s is not %s: %v", name, v, what, err)
+	}
+	return int(n)
+}
+
+// Inject delivers payload on topic to the subscriptions at QoS 1, like
+// a command from another client on the ControlTopic of a device. It
+// is not recorded with the publishes.
+func (c *Capture) Inject(topic string, payload []byte) {
+	msg := device.NewMsg(topic, payload, "inject")
+	msg.QoS = device.AtLeastOnce
+	c.Deliver(msg)
+}
+
+// Reset forgets the messages published so far, the retained ones are
+// still kept
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.MemMessanger.Reset()
+	clear(c.expected)
+}
+
+// Matcher tells if a message is the one expected
+type Matcher func(*device.Msg) bool
+
+// ExpectPublish waits for a message on a topic matching the filter
+// topic that m matches, and returns it. One published before is taken
+// too, but not one an expect returned already, so expecting twice
+// waits for a second message. It fails the test after timeout.
+func (c *Capture) ExpectPublish(topic string, m Matcher, timeout time.Duration) *device.Msg {
+	c.t.Helper()
+	deadline := time.After(timeout)
+	for {
+		c.mu.Lock()
+		changed := c.changed
+		for i, msg := range c.Messages("#") {
+			if c.expected[i] || !device.TopicMatch(topic, msg.Topic) || !m(msg) {
+				continue
+			}
+			c.expected[i] = true
+			c.mu.Unlock()
+			return msg
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			c.t.Fatalf("no publish on %s in %v, published %s", topic, timeout, c.published(topic))
+			return nil
+		}
+	}
+}
+
+// ExpectNoPublish fails the test if a message on a topic matching
+// the filter topic that m matches is published within wait
+func (c *Capture) ExpectNoPublish(topic string, m Matcher, wait time.Duration) {
+	c.t.Helper()
+	deadline := time.After(wait)
+	for {
+		c.mu.Lock()
+		changed := c.changed
+		for i, msg := range c.Messages("#") {
+			if !c.expected[i] && device.TopicMatch(topic, msg.Topic) && m(msg) {
+				c.mu.Unlock()
+				c.t.Fatalf("publish on %s got (%s) want none", msg.Topic, msg)
+				return
+			}
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func (c *Capture) published(topic string) string {
+	var b strings.Builder
+	for _, msg := range c.Messages(topic) {
+		fmt.Fprintf(&b, "\n\t%s %s", msg.Topic, msg.Data)
+	}
+	if b.Len() == 0 {
+		return "nothing"
+	}
+	return b.String()
+}
+
+// Any matches every message
+func Any() Matcher {
+	return func(*device.Msg) bool { return true }
+}
+
+// Payload matches the messages of payload as it was published
+func Payload(payload string) Matcher {
+	return func(msg *device.Msg) bool { return string(msg.Data) == payload }
+}
+
+// Contains matches the messages whose payload contains s
+func Contains(s string) Matcher {
+	return func(msg *device.Msg) bool { return bytes.Contains(msg.Data, []byte(s)) }
+}
+
+// Data matches the messages of the data v, unwrapped from the
+// Envelope and decoded by the suffix of the topic. A string is the
+// data as it was published, anything else is compared as JSON, so
+// Data(1) matches a device that did PubState(1).
+func Data(v any) Matcher {
+	return func(msg *device.Msg) bool {
+		var data []byte
+		if _, err := device.Decode(msg.Topic, msg.Data, &data); err != nil {
+			return false
+		}
+		if s, ok := v.(string); ok {
+			return string(data) == s
+		}
+		want, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		return jsonEqual(data, want)
+	}
+}
+
+// Enveloped matches the messages wrapped in an Envelope that fn
+// returns true for, like the ones of a device and a seq
+func Enveloped(fn func(*device.Envelope) bool) Matcher {
+	return func(msg *device.Msg) bool {
+		var data []byte
+		env, err := device.Decode(msg.Topic, msg.Data, &data)
+		return err == nil && env != nil && fn(env)
+	}
+}
+
+// Retained matches the messages published retained, or not
+func Retained(retained bool) Matcher {
+	return func(msg *device.Msg) bool { return msg.Retained == retained }
+}
+
+// QoS matches the messages published at q
+func QoS(q device.QoS) Matcher {
+	return func(msg *device.Msg) bool { return msg.QoS == q }
+}
+
+// All matches the messages every one of ms matches
+func All(ms ...Matcher) Matcher {
+	return func(msg *device.Msg) bool {
+		for _, m := range ms {
+			if !m(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func jsonEqual(a, b []byte) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
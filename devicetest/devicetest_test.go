@@ -0,0 +1,138 @@
+package devicetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+func TestCapture(t *testing.T) {
+	c := Use(t)
+	d := device.NewDevice("pump", "mqtt")
+	rt := device.NewRouter(d)
+	rt.Handle("on", func(*device.Command) error { return d.PubState(1) })
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error (%v)", err)
+	}
+
+	// from a goroutine, the expect waits for it
+	go d.PubData(map[string]any{"flow": 2.5})
+	msg := c.ExpectPublish("ss/d/+/pump", Data(map[string]any{"flow": 2.5}), Timeout)
+	if msg.Topic != d.DataTopic() || msg.Retained || msg.QoS != device.AtMostOnce {
+		t.Errorf("ExpectPublish() got (%+v)", msg)
+	}
+
+	c.Inject(d.ControlTopic(), []byte("on"))
+	c.ExpectPublish(d.StateTopic(), All(Data(1), Enveloped(func(env *device.Envelope) bool { return env.Seq == 1 })), Timeout)
+	if msgs := c.Messages(d.ControlTopic()); len(msgs) != 0 {
+		t.Errorf("injected got (%v) want it not recorded", msgs)
+	}
+
+	// an expect takes a message once
+	d.PubData("a")
+	d.PubData("a")
+	c.ExpectPublish(d.DataTopic(), Data("a"), Timeout)
+	c.ExpectPublish(d.DataTopic(), Data("a"), Timeout)
+	c.ExpectNoPublish(d.DataTopic(), Data("a"), 10*time.Millisecond)
+
+	d.PubRetained(d.AvailabilityTopic(), device.Online)
+	c.ExpectPublish("#", All(Payload(device.Online), Retained(true), QoS(device.AtLeastOnce)), Timeout)
+	if msg, ok := c.Retained(d.AvailabilityTopic()); !ok || msg.String() != device.Online {
+		t.Errorf("Retained() got (%v) want (online)", msg)
+	}
+
+	c.Reset()
+	d.PubData("a")
+	c.ExpectPublish(d.DataTopic(), Contains(`"a"`), Timeout)
+}
+
+func TestCaptureTimeout(t *testing.T) {
+	ft := &fatal{TB: t}
+	c := New(ft)
+	c.ExpectPublish("nothing/#", Any(), time.Millisecond)
+	if !ft.failed {
+		t.Errorf("ExpectPublish() did not fail the test on timeout")
+	}
+}
+
+// fatal records a Fatalf rather than stopping the test
+type fatal struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fatal) Fatalf(string, ...any) { f.failed = true }
+
+func TestUseClock(t *testing.T) {
+	c := Use(t)
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	t.Run("clock", func(t *testing.T) {
+		clock := UseClock(t, start)
+		d := device.NewDevice("meter", "mqtt")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		n := 0
+		go d.TimerLoop(ctx, time.Hour, func() error {
+			n++
+			return d.PubData(n)
+		})
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// a day of hourly reads without waiting for them
+		clock.Advance(24 * time.Hour)
+		c.ExpectPublish(d.DataTopic(), Data(24), Timeout)
+		if got := d.Now(); !got.Equal(start.Add(24 * time.Hour)) {
+			t.Errorf("Now() got (%v) want (%v)", got, start.Add(24*time.Hour))
+		}
+	})
+	if got := device.NewDevice("meter", "mqtt").Now(); got.Sub(time.Now()).Abs() > time.Second {
+		t.Errorf("Now() after the test got (%v) want the real time", got)
+	}
+}
+
+func TestUseStore(t *testing.T) {
+	old := device.GetStore()
+	t.Run("store", func(t *testing.T) {
+		s := UseStore(t)
+		if device.GetStore() != s {
+			t.Fatal("GetStore() is not the store of the test")
+		}
+		if err := s.Save("meter/calibration", 1.5); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		var v float64
+		if err := device.GetStore().Load("meter/calibration", &v); err != nil || v != 1.5 {
+			t.Errorf("Load() got (%v, %v) want (1.5)", v, err)
+		}
+	})
+	if device.GetStore() != old {
+		t.Error("GetStore() after the test is not the store before it")
+	}
+}
+
+func TestHardwareEnv(t *testing.T) {
+	t.Setenv("OTTO_TEST_UNSET", "")
+	var unset *testing.T
+	t.Run("unset", func(t *testing.T) {
+		unset = t
+		HardwareEnv(t, "OTTO_TEST_UNSET", "anything")
+		t.Error("HardwareEnv() of a variable not set did not skip")
+	})
+	if !unset.Skipped() {
+		t.Error("HardwareEnv() of a variable not set did not skip")
+	}
+
+	t.Setenv("OTTO_TEST_ADDR", "0x76")
+	if got := HardwareInt(t, "OTTO_TEST_ADDR", "an address"); got != 0x76 {
+		t.Errorf("HardwareInt() got (%#x) want (0x76)", got)
+	}
+	t.Setenv("OTTO_TEST_PIN", "seventeen")
+	f := &fatal{TB: t}
+	if HardwareInt(f, "OTTO_TEST_PIN", "an offset"); !f.failed {
+		t.Error("HardwareInt() of seventeen did not fail")
+	}
+}
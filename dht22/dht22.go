@@ -0,0 +1,353 @@
+// Package dht22 reads the DHT22 (AM2302) temperature and humidity
+// sensor. The single wire protocol is timed from the kernel's GPIO
+// edge event timestamps, or the readings come from the kernel dht11
+// iio driver when the sensor is bound to it.
+//
+// The DHT22 can only be read every 2 seconds and bad reads are
+// common, Read caches the last reading for the interval and retries
+// failed reads.
+package dht22
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// MinInterval is the shortest time between two reads of the
+	// sensor, reading it sooner returns stale or corrupt data
+	MinInterval = 2 * time.Second
+
+	// DefaultRetries is how often a bad read is repeated
+	DefaultRetries = 3
+
+	// the start signal holds the line low for at least 1ms, the
+	// whole transfer takes about 5ms
+	startLow     = 2 * time.Millisecond
+	transferTime = 8 * time.Millisecond
+)
+
+// iioRoot is where the kernel lists iio devices
+var iioRoot = "/sys/bus/iio/devices"
+
+// Reading is a single measurement
+type Reading struct {
+	Temperature float64 `json:"temperature"` // C
+	Humidity    float64 `json:"humidity"`    // %RH
+}
+
+// Env is what ReadPub publishes, it matches the bme280 Env without
+// the pressure so consumers can handle either sensor
+type Env struct {
+	Temperature string `json:"temperature"`
+	Humidity    string `json:"humidity"`
+}
+
+// DHT22 is a temperature and humidity sensor on a single GPIO line
+type DHT22 struct {
+	*device.Device
+
+	// Retries is how many times a bad read is repeated, every retry
+	// waits out MinInterval first
+	Retries int
+
+	pin       *drivers.DigitalPin
+	iio       string
+	events    []gpiocdev.LineEvent
+	capturing bool
+	evmu      sync.Mutex
+
+	last     *Reading
+	lastRead time.Time
+	mock     mockSensor
+
+	read  func() (*Reading, error)
+	now   func() time.Time
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates a DHT22 on the GPIO line at offset of the default
+// chip. In mock mode no line is requested and scripted readings are
+// returned, see MockReadings.
+func New(name string, offset int) (*DHT22, error) {
+	d := newDHT22(name)
+	if device.IsMock() {
+		d.read = d.readMock
+		return d, nil
+	}
+
+	pin, err := drivers.GetGPIO().Request(name, offset,
+		drivers.WithOwner("dht22"),
+		gpiocdev.AsOutput(1),
+		gpiocdev.WithEventHandler(d.edge),
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.pin = pin
+	d.read = d.readGPIO
+	return d, nil
+}
+
+// NewIIO creates a DHT22 read through the kernel dht11 iio driver
+// (dtoverlay=dht11 on a Pi, it handles the DHT22 as well). dir is
+// the device's directory like /sys/bus/iio/devices/iio:device0, see
+// IIODevices.
+func NewIIO(name, dir string) *DHT22 {
+	d := newDHT22(name)
+	d.iio = dir
+	d.read = d.readIIO
+	if device.IsMock() {
+		d.read = d.readMock
+	}
+	return d
+}
+
+func newDHT22(name string) *DHT22 {
+	return &DHT22{
+		Device:  device.NewDevice(name, "mqtt"),
+		Retries: DefaultRetries,
+		mock:    mockSensor{temp: 21.5, hum: 45},
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+// IIODevices returns the directories of the iio devices bound to the
+// kernel dht11 driver
+func IIODevices() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(iioRoot, "*", "name"))
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, n := range names {
+		b, err := os.ReadFile(n)
+		if err == nil && strings.TrimSpace(string(b)) == "dht11" {
+			dirs = append(dirs, filepath.Dir(n))
+		}
+	}
+	return dirs, nil
+}
+
+// Name returns the name of the device
+func (d *DHT22) Name() string {
+	return d.Device.Name
+}
+
+// Read returns the temperature and humidity. The sensor is read at
+// most every MinInterval, within the interval the last reading is
+// returned. A bad read is retried Retries times.
+func (d *DHT22) Read() (*Reading, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if since := d.now().Sub(d.lastRead); !d.lastRead.IsZero() && since < MinInterval {
+		if d.last != nil {
+			r := *d.last
+			return &r, nil
+		}
+		d.sleep(MinInterval - since)
+	}
+
+	var err error
+	for i := 0; i <= d.Retries; i++ {
+		if i > 0 {
+			d.sleep(MinInterval)
+		}
+		var r *Reading
+		r, err = d.read()
+		d.lastRead = d.now()
+		if err == nil {
+			d.last = r
+			rc := *r
+			return &rc, nil
+		}
+		slog.Debug("dht22 bad read", "device", d.Device.Name, "attempt", i+1, "error", err)
+	}
+	return nil, fmt.Errorf("%s: %w", d.Device.Name, err)
+}
+
+// Temperature reads the temperature in °C, the DHT22 is a
+// device.Thermometer. Within MinInterval of a Read it is the one read.
+func (d *DHT22) Temperature() (float64, error) {
+	r, err := d.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// Humidity reads the relative humidity in %, the DHT22 is a
+// device.Hygrometer. Within MinInterval of a Read it is the one read.
+func (d *DHT22) Humidity() (float64, error) {
+	r, err := d.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Humidity, nil
+}
+
+// ReadPub reads the sensor and publishes the reading
+func (d *DHT22) ReadPub() error {
+	r, err := d.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(&Env{
+		Temperature: fmt.Sprintf("%.2f", r.Temperature),
+		Humidity:    fmt.Sprintf("%.2f", r.Humidity),
+	})
+	if err != nil {
+		return err
+	}
+	d.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled, the
+// period is at least MinInterval
+func (d *DHT22) Run(ctx context.Context, period time.Duration) error {
+	if period < MinInterval {
+		period = MinInterval
+	}
+	err := d.TimerLoop(ctx, period, d.ReadPub)
+	slog.Debug("dht22 stopped", "device", d.Device.Name, "error", err)
+	return err
+}
+
+// String returns the device and its last reading
+func (d *DHT22) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last == nil {
+		return d.Device.String()
+	}
+	return fmt.Sprintf("%s %.1fC %.1f%%", d.Device.String(), d.last.Temperature, d.last.Humidity)
+}
+
+// Close releases the GPIO line
+func (d *DHT22) Close() error {
+	if d.pin == nil {
+		return nil
+	}
+	return d.pin.Close()
+}
+
+// readGPIO sends the start signal and decodes the edges the sensor
+// answers with
+func (d *DHT22) readGPIO() (*Reading, error) {
+	d.evmu.Lock()
+	d.events = d.events[:0]
+	d.evmu.Unlock()
+
+	if err := d.pin.Set(0); err != nil {
+		return nil, err
+	}
+	time.Sleep(startLow)
+
+	d.evmu.Lock()
+	d.capturing = true
+	d.evmu.Unlock()
+	if err := d.pin.Reconfigure(gpiocdev.AsInput, gpiocdev.WithBothEdges); err != nil {
+		return nil, err
+	}
+	time.Sleep(transferTime)
+	err := d.pin.Reconfigure(gpiocdev.WithoutEdges, gpiocdev.AsOutput(1))
+
+	d.evmu.Lock()
+	d.capturing = false
+	events := append([]gpiocdev.LineEvent(nil), d.events...)
+	d.evmu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decode(events)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// edge collects the events of a transfer
+func (d *DHT22) edge(evt gpiocdev.LineEvent) {
+	d.evmu.Lock()
+	defer d.evmu.Unlock()
+	if d.capturing {
+		d.events = append(d.events, evt)
+	}
+}
+
+// readIIO reads the milli degrees and milli percent the kernel
+// driver reports, the driver validates the checksum itself
+func (d *DHT22) readIIO() (*Reading, error) {
+	temp, err := readMilli(filepath.Join(d.iio, "in_temp_input"))
+	if err != nil {
+		return nil, err
+	}
+	hum, err := readMilli(filepath.Join(d.iio, "in_humidityrelative_input"))
+	if err != nil {
+		return nil, err
+	}
+	return &Reading{Temperature: temp, Humidity: hum}, nil
+}
+
+func readMilli(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return float64(v) / 1000, nil
+}
+
+// MockReadings scripts the readings returned in mock mode, once
+// they are used up the values drift on from the last one
+func (d *DHT22) MockReadings(rs ...Reading) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mock.script = append(d.mock.script, rs...)
+}
+
+// mockSensor drifts slowly around a comfortable room in the 0.1
+// steps the sensor reports
+type mockSensor struct {
+	temp   float64
+	hum    float64
+	script []Reading
+}
+
+func (d *DHT22) readMock() (*Reading, error) {
+	m := &d.mock
+	if len(m.script) > 0 {
+		m.temp, m.hum = m.script[0].Temperature, m.script[0].Humidity
+		m.script = m.script[1:]
+		return &Reading{Temperature: m.temp, Humidity: m.hum}, nil
+	}
+	m.temp = math.Round((m.temp+d.MockRand().Float64()*0.4-0.2)*10) / 10
+	m.hum = math.Round((m.hum+d.MockRand().Float64()*1.0-0.5)*10) / 10
+	m.hum = math.Max(0, math.Min(100, m.hum))
+	return &Reading{Temperature: m.temp, Humidity: m.hum}, nil
+}
@@ -0,0 +1,268 @@
+package dht22
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// captured is the width in microseconds of the high pulses of a
+// transfer recorded with a logic analyzer, the response pulse
+// followed by 0x02 0x8C 0x01 0x05 0x94 (65.2% 26.1C)
+var captured = []int{
+	81,
+	26, 27, 26, 27, 26, 27, 70, 26,
+	71, 26, 27, 26, 70, 70, 27, 26,
+	26, 27, 26, 27, 26, 27, 27, 70,
+	26, 27, 27, 26, 26, 71, 26, 70,
+	70, 26, 27, 70, 26, 70, 27, 27,
+}
+
+// events turns high pulse widths into the edges the kernel reports,
+// every high pulse follows a 50us low
+func events(highs []int) []gpiocdev.LineEvent {
+	var evts []gpiocdev.LineEvent
+	ts := time.Millisecond
+	for _, h := range highs {
+		ts += 50 * time.Microsecond
+		evts = append(evts, gpiocdev.LineEvent{Timestamp: ts, Type: gpiocdev.LineEventRisingEdge})
+		ts += time.Duration(h) * time.Microsecond
+		evts = append(evts, gpiocdev.LineEvent{Timestamp: ts, Type: gpiocdev.LineEventFallingEdge})
+	}
+	return evts
+}
+
+// pulses encodes data bytes as high pulse widths
+func pulses(data ...byte) []int {
+	highs := []int{80}
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			if b>>i&1 == 1 {
+				highs = append(highs, 70)
+			} else {
+				highs = append(highs, 27)
+			}
+		}
+	}
+	return highs
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		highs []int
+		data  [5]byte
+		err   error
+	}{
+		{"captured", captured, [5]byte{0x02, 0x8C, 0x01, 0x05, 0x94}, nil},
+		{"lost response", captured[1:], [5]byte{0x02, 0x8C, 0x01, 0x05, 0x94}, nil},
+		{"negative", pulses(0x01, 0xF4, 0x80, 0x65, 0xDA), [5]byte{0x01, 0xF4, 0x80, 0x65, 0xDA}, nil},
+		{"short", captured[:30], [5]byte{}, ErrShortRead},
+		{"stuck high", append(append([]int{}, captured[:20]...), append([]int{400}, captured[21:]...)...), [5]byte{}, ErrShortRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := decode(events(tt.highs))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error got (%v) want (%v)", err, tt.err)
+			}
+			if err == nil && data != tt.data {
+				t.Errorf("data got (% x) want (% x)", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data [5]byte
+		want Reading
+		err  error
+	}{
+		{"captured", [5]byte{0x02, 0x8C, 0x01, 0x05, 0x94}, Reading{Temperature: 26.1, Humidity: 65.2}, nil},
+		{"negative", [5]byte{0x01, 0xF4, 0x80, 0x65, 0xDA}, Reading{Temperature: -10.1, Humidity: 50.0}, nil},
+		{"zero", [5]byte{0x00, 0x00, 0x00, 0x00, 0x00}, Reading{}, nil},
+		{"checksum", [5]byte{0x02, 0x8C, 0x01, 0x05, 0x95}, Reading{}, ErrChecksum},
+		{"humidity", [5]byte{0x03, 0xF0, 0x00, 0xC8, 0xBB}, Reading{}, ErrOutOfRange},
+		{"temperature", [5]byte{0x01, 0xF4, 0x03, 0x84, 0x7C}, Reading{}, ErrOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parse(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error got (%v) want (%v)", err, tt.err)
+			}
+			if err == nil && r != tt.want {
+				t.Errorf("reading got (%+v) want (%+v)", r, tt.want)
+			}
+		})
+	}
+}
+
+// clock is a fake time source, sleeping advances it
+type clock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	c.slept += d
+}
+
+func newTestDHT(results ...error) (*DHT22, *clock, *int) {
+	c := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	d := newDHT22("dht-test")
+	d.now, d.sleep = c.now, c.sleep
+
+	calls := 0
+	d.read = func() (*Reading, error) {
+		calls++
+		if calls <= len(results) && results[calls-1] != nil {
+			return nil, results[calls-1]
+		}
+		return &Reading{Temperature: 20 + float64(calls), Humidity: 40}, nil
+	}
+	return d, c, &calls
+}
+
+func TestReadRetry(t *testing.T) {
+	d, c, calls := newTestDHT(ErrChecksum, ErrShortRead)
+	r, err := d.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Temperature != 23 || *calls != 3 {
+		t.Errorf("Read() got (%.1f after %d reads) want (23.0 after 3 reads)", r.Temperature, *calls)
+	}
+	if c.slept != 2*MinInterval {
+		t.Errorf("retries waited (%v) want (%v)", c.slept, 2*MinInterval)
+	}
+
+	d, _, calls = newTestDHT(ErrChecksum, ErrChecksum, ErrChecksum, ErrChecksum)
+	if _, err := d.Read(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrChecksum)
+	}
+	if *calls != DefaultRetries+1 {
+		t.Errorf("reads got (%d) want (%d)", *calls, DefaultRetries+1)
+	}
+}
+
+func TestReadInterval(t *testing.T) {
+	d, c, calls := newTestDHT()
+	first, _ := d.Read()
+
+	c.t = c.t.Add(time.Second)
+	r, err := d.Read()
+	if err != nil || *r != *first || *calls != 1 {
+		t.Errorf("Read() within interval got (%+v, %v, %d reads) want the cached (%+v)", r, err, *calls, first)
+	}
+
+	c.t = c.t.Add(MinInterval)
+	if r, _ = d.Read(); *r == *first || *calls != 2 {
+		t.Errorf("Read() after interval got (%+v, %d reads) want a new reading", r, *calls)
+	}
+
+	// a thermometer and hygrometer of the one reading
+	dm := device.GetDeviceManager()
+	dm.Add(d)
+	defer dm.Remove(d.Name())
+	th, err := device.GetThermometer("dht-test")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	h, err := device.GetHygrometer("dht-test")
+	if err != nil {
+		t.Fatalf("GetHygrometer() error = %v", err)
+	}
+	temp, terr := th.Temperature()
+	hum, herr := h.Humidity()
+	if temp != r.Temperature || hum != r.Humidity || terr != nil || herr != nil || *calls != 2 {
+		t.Errorf("Temperature(), Humidity() got (%v %v, %v %v, %d reads) want the cached (%+v)", temp, terr, hum, herr, *calls, r)
+	}
+
+	// without a good reading to return the read waits for the interval
+	d, c, calls = newTestDHT(ErrChecksum, ErrChecksum, ErrChecksum, ErrChecksum)
+	d.Read()
+	c.slept = 0
+	c.t = c.t.Add(500 * time.Millisecond)
+	if _, err := d.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if c.slept != MinInterval-500*time.Millisecond {
+		t.Errorf("Read() waited (%v) want (%v)", c.slept, MinInterval-500*time.Millisecond)
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	d, err := New("dht-mock", 4)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c := &clock{t: time.Now()}
+	d.now, d.sleep = c.now, c.sleep
+
+	d.MockReadings(Reading{Temperature: 18.3, Humidity: 52.1}, Reading{Temperature: 18.4, Humidity: 52.0})
+	for _, want := range []Reading{{18.3, 52.1}, {18.4, 52.0}} {
+		r, err := d.Read()
+		if err != nil || *r != want {
+			t.Errorf("Read() got (%+v, %v) want (%+v)", r, err, want)
+		}
+		c.t = c.t.Add(MinInterval)
+	}
+
+	for i := 0; i < 20; i++ {
+		r, _ := d.Read()
+		if r.Temperature < 14 || r.Temperature > 23 || r.Humidity < 40 || r.Humidity > 65 {
+			t.Errorf("drifting Read() got (%+v) want near (18.4C 52.0%%)", r)
+		}
+		c.t = c.t.Add(MinInterval)
+	}
+}
+
+func TestIIO(t *testing.T) {
+	root := t.TempDir()
+	old := iioRoot
+	iioRoot = root
+	t.Cleanup(func() { iioRoot = old })
+
+	write := func(dev, file, val string) {
+		t.Helper()
+		os.MkdirAll(filepath.Join(root, dev), 0755)
+		if err := os.WriteFile(filepath.Join(root, dev, file), []byte(val), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("iio:device0", "name", "ads1015\n")
+	write("iio:device1", "name", "dht11\n")
+	write("iio:device1", "in_temp_input", "23400\n")
+	write("iio:device1", "in_humidityrelative_input", "48700\n")
+
+	dirs, err := IIODevices()
+	if err != nil || len(dirs) != 1 || filepath.Base(dirs[0]) != "iio:device1" {
+		t.Fatalf("IIODevices() got (%v, %v) want ([iio:device1], nil)", dirs, err)
+	}
+
+	d := newDHT22("dht-iio")
+	d.iio = dirs[0]
+	r, err := d.readIIO()
+	if err != nil || *r != (Reading{Temperature: 23.4, Humidity: 48.7}) {
+		t.Errorf("readIIO() got (%+v, %v) want ({23.4 48.7}, nil)", r, err)
+	}
+
+	write("iio:device1", "in_temp_input", "bad\n")
+	if _, err := d.readIIO(); err == nil {
+		t.Error("readIIO() expected an error")
+	}
+}
@@ -0,0 +1,91 @@
+package dht22
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// The sensor answers the start signal with an 80us low and an 80us
+// high response, then sends 40 bits. Every bit is a 50us low
+// followed by a 26-28us high for a 0 or a 70us high for a 1.
+const (
+	bits         = 40
+	oneThreshold = 48 * time.Microsecond
+	maxPulse     = 120 * time.Microsecond
+)
+
+var (
+	// ErrShortRead is returned when fewer than 40 bits were seen,
+	// usually because edges were lost while the system was busy
+	ErrShortRead = errors.New("dht22: short read")
+
+	// ErrChecksum is returned when the checksum byte does not match
+	ErrChecksum = errors.New("dht22: checksum mismatch")
+
+	// ErrOutOfRange is returned for a reading the sensor can not
+	// make, a corrupt frame that happened to pass the checksum
+	ErrOutOfRange = errors.New("dht22: reading out of range")
+)
+
+// decode turns the edge events captured during a transfer into the
+// 5 data bytes. Only the widths of the high pulses matter, the last
+// 40 of them are the data bits so losing the response edges at the
+// start of the transfer does no harm.
+func decode(events []gpiocdev.LineEvent) ([5]byte, error) {
+	var data [5]byte
+	var highs []time.Duration
+	var rise time.Duration
+	rising := false
+
+	for _, evt := range events {
+		switch evt.Type {
+		case gpiocdev.LineEventRisingEdge:
+			rise, rising = evt.Timestamp, true
+		case gpiocdev.LineEventFallingEdge:
+			if rising {
+				highs = append(highs, evt.Timestamp-rise)
+			}
+			rising = false
+		}
+	}
+	if len(highs) < bits {
+		return data, fmt.Errorf("%w: %d of %d bits", ErrShortRead, len(highs), bits)
+	}
+
+	highs = highs[len(highs)-bits:]
+	for i, w := range highs {
+		if w > maxPulse {
+			return data, fmt.Errorf("%w: bit %d is %v high", ErrShortRead, i, w)
+		}
+		data[i/8] <<= 1
+		if w > oneThreshold {
+			data[i/8] |= 1
+		}
+	}
+	return data, nil
+}
+
+// parse checks the checksum and converts the data bytes, humidity
+// and temperature are sent in tenths with the temperature's sign
+// in the top bit.
+func parse(data [5]byte) (Reading, error) {
+	sum := data[0] + data[1] + data[2] + data[3]
+	if sum != data[4] {
+		return Reading{}, fmt.Errorf("%w: got %#02x want %#02x", ErrChecksum, data[4], sum)
+	}
+
+	r := Reading{
+		Humidity:    float64(uint16(data[0])<<8|uint16(data[1])) / 10,
+		Temperature: float64(uint16(data[2]&0x7F)<<8|uint16(data[3])) / 10,
+	}
+	if data[2]&0x80 != 0 {
+		r.Temperature = -r.Temperature
+	}
+	if r.Humidity > 100 || r.Temperature < -40 || r.Temperature > 80 {
+		return r, fmt.Errorf("%w: %.1fC %.1f%%", ErrOutOfRange, r.Temperature, r.Humidity)
+	}
+	return r, nil
+}
@@ -0,0 +1,245 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Entity is an entity of a device in Home Assistant, a value it reads
+// or a thing it switches. The Component is the kind, like "sensor",
+// "binary_sensor", "switch" or "light", and ID tells the entities of a
+// device apart. Value is the key of the value in the data published on
+// the StateTopic, the data itself when it is empty. A CommandTopic
+// takes PayloadOn and PayloadOff, the state is compared to them.
+type Entity struct {
+	Component    string
+	ID           string
+	Name         string // the device name and the ID when empty
+	DeviceClass  string
+	StateClass   string
+	Unit         string
+	Icon         string
+	StateTopic   string
+	Value        string
+	CommandTopic string
+	PayloadOn    string
+	PayloadOff   string
+}
+
+// Discoverable is a device that describes its entities to Home
+// Assistant, the DeviceManager publishes their discovery with
+// PubDiscovery
+type Discoverable interface {
+	Entities() []Entity
+}
+
+// HADevice is the device of Home Assistant the entities of the station
+// are grouped in, the station rather than each of its sensors
+type HADevice struct {
+	Identifiers   []string `json:"identifiers"`
+	Name          string   `json:"name"`
+	Manufacturer  string   `json:"manufacturer,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	SWVersion     string   `json:"sw_version,omitempty"`
+	SuggestedArea string   `json:"suggested_area,omitempty"`
+}
+
+// Discovery is how the station is discovered. The config of an entity
+// goes retained on Prefix/component/station/device_id/config, with
+// the Device of every entity of the station.
+type Discovery struct {
+	Prefix string
+	Device HADevice
+}
+
+// DefaultDiscovery is the discovery of a station that set none, the
+// HADevice made of the station name
+var DefaultDiscovery = Discovery{
+	Prefix: "homeassistant",
+	Device: HADevice{Manufacturer: "otto-devices"},
+}
+
+var (
+	discovery   = DefaultDiscovery
+	discoveryMu sync.RWMutex
+)
+
+// GetDiscovery returns the discovery of the station, the Device named
+// after the station unless it was given a name
+func GetDiscovery() Discovery {
+	discoveryMu.RLock()
+	disc := discovery
+	discoveryMu.RUnlock()
+
+	if disc.Prefix == "" {
+		disc.Prefix = DefaultDiscovery.Prefix
+	}
+	station := Station()
+	if disc.Device.Name == "" {
+		disc.Device.Name = station
+	}
+	if len(disc.Device.Identifiers) == 0 {
+		disc.Device.Identifiers = []string{"otto_" + objectID(station)}
+	}
+	return disc
+}
+
+// SetDiscovery sets the discovery of the station, set it before
+// publishing the discovery
+func SetDiscovery(disc Discovery) {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+	discovery = disc
+}
+
+// haAvailability is a topic Home Assistant takes the availability of
+// an entity from
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haConfig is the discovery config of an entity
+type haConfig struct {
+	Name               string           `json:"name"`
+	UniqueID           string           `json:"unique_id"`
+	StateTopic         string           `json:"state_topic,omitempty"`
+	ValueTemplate      string           `json:"value_template,omitempty"`
+	StateValueTemplate string           `json:"state_value_template,omitempty"`
+	CommandTopic       string           `json:"command_topic,omitempty"`
+	PayloadOn          string           `json:"payload_on,omitempty"`
+	PayloadOff         string           `json:"payload_off,omitempty"`
+	DeviceClass        string           `json:"device_class,omitempty"`
+	StateClass         string           `json:"state_class,omitempty"`
+	Unit               string           `json:"unit_of_measurement,omitempty"`
+	Icon               string           `json:"icon,omitempty"`
+	Availability       []haAvailability `json:"availability"`
+	AvailabilityMode   string           `json:"availability_mode"`
+	Device             HADevice         `json:"device"`
+}
+
+// DiscoveryTopic returns the topic the config of entity e of the
+// device goes on
+func (d *Device) DiscoveryTopic(e Entity) string {
+	disc := GetDiscovery()
+	return strings.Join([]string{disc.Prefix, e.Component, objectID(Station()), d.entityID(e), "config"}, "/")
+}
+
+func (d *Device) entityID(e Entity) string {
+	if e.ID == "" {
+		return objectID(d.Name)
+	}
+	return objectID(d.Name + "_" + e.ID)
+}
+
+// discoveryConfig makes the config of entity e of the device. The
+// value is taken from the data of the Envelope when the device wraps
+// its payloads. An entity is available when the station and the
+// device are.
+func (d *Device) discoveryConfig(e Entity, disc Discovery) haConfig {
+	c := haConfig{
+		Name:             e.Name,
+		UniqueID:         objectID(Station()) + "_" + d.entityID(e),
+		StateTopic:       e.StateTopic,
+		CommandTopic:     e.CommandTopic,
+		PayloadOn:        e.PayloadOn,
+		PayloadOff:       e.PayloadOff,
+		DeviceClass:      e.DeviceClass,
+		StateClass:       e.StateClass,
+		Unit:             e.Unit,
+		Icon:             e.Icon,
+		Availability:     []haAvailability{{d.StationTopic()}, {d.AvailabilityTopic()}},
+		AvailabilityMode: "all",
+		Device:           disc.Device,
+	}
+	if c.Name == "" {
+		c.Name = strings.TrimSpace(d.Name + " " + e.ID)
+	}
+
+	var path []string
+	if d.Enveloped() {
+		path = append(path, "data")
+	}
+	if e.Value != "" {
+		path = append(path, e.Value)
+	}
+	var tmpl string
+	if len(path) > 0 && e.StateTopic != "" {
+		tmpl = "{{ value_json." + strings.Join(path, ".") + " }}"
+	}
+	if e.Component == "light" {
+		c.StateValueTemplate = tmpl
+	} else {
+		c.ValueTemplate = tmpl
+	}
+	return c
+}
+
+// PubDiscovery publishes the discovery config of the entities of the
+// device retained, and announces it for the availability of the
+// entities
+func (d *Device) PubDiscovery(entities []Entity) error {
+	disc := GetDiscovery()
+	var errs []error
+	for _, e := range entities {
+		if e.Component == "" {
+			errs = append(errs, fmt.Errorf("%s: entity %q has no component", d.Name, e.ID))
+			continue
+		}
+		errs = append(errs, d.PubRetained(d.DiscoveryTopic(e), d.discoveryConfig(e, disc)))
+	}
+	errs = append(errs, d.Announce())
+	return errors.Join(errs...)
+}
+
+// ClearDiscovery clears the discovery config of the entities of the
+// device, Home Assistant removes them
+func (d *Device) ClearDiscovery(entities []Entity) error {
+	var errs []error
+	for _, e := range entities {
+		errs = append(errs, d.PubRetained(d.DiscoveryTopic(e), nil))
+	}
+	return errors.Join(errs...)
+}
+
+// discoverer is a Discoverable device that can publish its discovery,
+// one embedding a Device
+type discoverer interface {
+	Discoverable
+	PubDiscovery([]Entity) error
+	ClearDiscovery([]Entity) error
+}
+
+// PubDiscovery publishes the discovery of every Discoverable device
+// of the manager, at startup, and of the ones added after. Remove
+// clears the discovery of a device.
+func (dm *DeviceManager) PubDiscovery() error {
+	dm.mu.Lock()
+	dm.discover = true
+	all := make([]Name, 0, len(dm.devices))
+	for _, d := range dm.devices {
+		all = append(all, d)
+	}
+	dm.mu.Unlock()
+
+	var errs []error
+	for _, d := range all {
+		if dd, ok := d.(discoverer); ok {
+			errs = append(errs, dd.PubDiscovery(dd.Entities()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// objectID makes s an id Home Assistant takes in a topic, of letters,
+// digits, underscores and dashes
+func objectID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
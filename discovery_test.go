@@ -0,0 +1,123 @@
+package device
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// discoverable is a device of entities
+type discoverable struct {
+	named
+	entities []Entity
+}
+
+func (d discoverable) Entities() []Entity { return d.entities }
+
+func TestPubDiscovery(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("soil 1", "mqtt", WithMessanger(m))
+	moisture := Entity{Component: "sensor", ID: "moisture", DeviceClass: "moisture", Unit: "%", StateTopic: d.DataTopic(), Value: "moisture"}
+	if err := d.PubDiscovery([]Entity{moisture}); err != nil {
+		t.Fatalf("PubDiscovery() error (%v)", err)
+	}
+
+	topic := "homeassistant/sensor/station/soil_1_moisture/config"
+	if got := d.DiscoveryTopic(moisture); got != topic {
+		t.Errorf("DiscoveryTopic() got (%s) want (%s)", got, topic)
+	}
+	msg, ok := m.Retained(topic)
+	if !ok {
+		t.Fatalf("config got (%v) want it retained", m.Messages("#"))
+	}
+	var got map[string]any
+	if err := json.Unmarshal(msg.Data, &got); err != nil {
+		t.Fatalf("config (%s) error (%v)", msg.Data, err)
+	}
+	want := map[string]any{
+		"name":                "soil 1 moisture",
+		"unique_id":           "station_soil_1_moisture",
+		"state_topic":         "ss/d/station/soil 1",
+		"value_template":      "{{ value_json.data.moisture }}",
+		"device_class":        "moisture",
+		"unit_of_measurement": "%",
+		"availability":        []any{map[string]any{"topic": "ss/a/station"}, map[string]any{"topic": "ss/a/station/soil 1"}},
+		"availability_mode":   "all",
+		"device":              map[string]any{"identifiers": []any{"otto_station"}, "name": "station", "manufacturer": "otto-devices"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config got (%v) want (%v)", got, want)
+	}
+
+	// bare payloads are the value
+	d.SetEnveloped(false)
+	if c := d.discoveryConfig(Entity{Component: "light", StateTopic: "s"}, GetDiscovery()); c.ValueTemplate != "" || c.StateValueTemplate != "" {
+		t.Errorf("bare light templates got (%+v) want none", c)
+	}
+	if c := d.discoveryConfig(Entity{Component: "sensor", StateTopic: "s", Value: "v"}, GetDiscovery()); c.ValueTemplate != "{{ value_json.v }}" {
+		t.Errorf("bare value template got (%s)", c.ValueTemplate)
+	}
+
+	if err := d.ClearDiscovery([]Entity{moisture}); err != nil {
+		t.Errorf("ClearDiscovery() error (%v)", err)
+	}
+	if msg, ok := m.Retained(topic); ok {
+		t.Errorf("ClearDiscovery() left (%v) retained", msg)
+	}
+	if err := d.PubDiscovery([]Entity{{ID: "x"}}); err == nil {
+		t.Errorf("PubDiscovery(no component) error got (nil)")
+	}
+}
+
+func TestManagerDiscovery(t *testing.T) {
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	SetDiscovery(Discovery{Prefix: "ha", Device: HADevice{Name: "Greenhouse", Model: "pi"}})
+	defer SetDiscovery(DefaultDiscovery)
+
+	m := NewMemMessanger()
+	entity := func(d *Device) []Entity {
+		return []Entity{{Component: "switch", StateTopic: d.StateTopic(), CommandTopic: d.ControlTopic(), PayloadOn: "1", PayloadOff: "0"}}
+	}
+	pump := NewDevice("pump", "mqtt", WithMessanger(m))
+	fan := NewDevice("fan", "mqtt", WithMessanger(m))
+	dm.Add(discoverable{named{pump}, entity(pump)})
+	dm.Add(named{NewDevice("plain", "mqtt", WithMessanger(m))})
+	if msgs := m.Messages("ha/#"); len(msgs) != 0 {
+		t.Fatalf("discovery before PubDiscovery got (%v)", msgs)
+	}
+
+	if err := dm.PubDiscovery(); err != nil {
+		t.Fatalf("PubDiscovery() error (%v)", err)
+	}
+	dm.Add(discoverable{named{fan}, entity(fan)})
+	msgs := m.Messages("ha/#")
+	if len(msgs) != 2 || msgs[0].Topic != "ha/switch/station/pump/config" || msgs[1].Topic != "ha/switch/station/fan/config" {
+		t.Fatalf("discovery got (%v) want pump then fan", msgs)
+	}
+	var c haConfig
+	json.Unmarshal(msgs[0].Data, &c)
+	want := HADevice{Identifiers: []string{"otto_station"}, Name: "Greenhouse", Model: "pi"}
+	if !reflect.DeepEqual(c.Device, want) {
+		t.Errorf("device got (%+v) want (%+v)", c.Device, want)
+	}
+	if msg, ok := m.Retained(pump.AvailabilityTopic()); !ok || msg.String() != Online {
+		t.Errorf("availability got (%v) want the device announced", msg)
+	}
+
+	dm.Remove("pump")
+	if msg, ok := m.Retained("ha/switch/station/pump/config"); ok {
+		t.Errorf("Remove() left (%v) retained", msg)
+	}
+	if _, ok := m.Retained("ha/switch/station/fan/config"); !ok {
+		t.Errorf("Remove(pump) cleared the fan")
+	}
+}
+
+func TestObjectID(t *testing.T) {
+	for in, want := range map[string]string{"bme280": "bme280", "soil 1": "soil_1", "a/b+c#": "a_b_c_", "ünit-2": "_nit-2"} {
+		if got := objectID(in); got != want {
+			t.Errorf("objectID(%s) got (%s) want (%s)", in, got, want)
+		}
+	}
+}
@@ -7,5 +7,23 @@ that they could perhaps be.
 
 Each of the said drivers can be put into mock mode for testing
 and development on a non-raspberry pi.
+
+Device packages open their I2C devices, gpiochips, pwm channels and
+SPI devices through a small registry (OpenI2C, OpenGPIOChip, OpenPWM,
+OpenSPI). The defaults talk to the kernel, tests can swap in the
+scripted fakes from the driverstest package instead of flipping the
+global mock.
+PWM controllers that are not kernel pwmchips, like the PCA9685,
+add their channels with RegisterPWMChip so a device can be pointed
+at them by chip name. A BitBangI2C bus on two spare GPIOs registers
+itself the same way with RegisterI2CBus.
+
+Pins can be picked by the kernel line name ("GPIO17") or the Pi
+header label ("PIN11") instead of a raw offset, NewDigitalPinID
+looks them up with FindLine across every gpiochip.
+
+1-Wire slaves are read through the kernel's w1 drivers, OneWire
+lists them by family code and reads and writes the files the slave
+driver exposes.
 */
 package drivers
@@ -1,36 +1,153 @@
 package drivers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-
-	"github.com/rustyeddy/otto/device"
-	"periph.io/x/conn/v3/analog"
-	"periph.io/x/conn/v3/i2c"
-	"periph.io/x/conn/v3/i2c/i2creg"
-	"periph.io/x/conn/v3/physic"
-	"periph.io/x/devices/v3/ads1x15"
-	"periph.io/x/host/v3"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
 )
 
 // ADS1115 is an i2c ADC chip that will use the i2c device type
 // to provide 4 single analog pins to be used by the raspberry
 // pi to access analog sensors via the i2c bus.  In a sense this
 // device is a higher level device than the device_i2c.
+//
+// The chip has a single converter multiplexed across the four
+// inputs, conversions are serialized by the ADS1115 so pins on the
+// same chip can be read from different goroutines.
 type ADS1115 struct {
-	pins [4]*ADS1115Pin
-	bus  i2c.BusCloser
-	adc  *ads1x15.Dev
-	mock bool
+	Name string
+	Bus  string
+	Addr int
+	Rate DataRate
+
+	pins  [4]*ADS1115Pin
+	dev   *I2CDevice
+	mock  bool
+	codes [4][]int16 // scripted conversion codes used in mock mode
+	last  [4]int16
+	live  [4]func() float64 // the mock volts of a profile, by channel
+	nak   int               // every nak-th mock conversion is not acked
+	convs int
+
+	// cont is the config last written for continuous mode, zero
+	// if the chip is in single-shot mode
+	cont uint16
+	mu   sync.Mutex
 }
 
+// ADS1115 registers and config fields, see section 8.6 of the
+// datasheet
+const (
+	adsRegConversion = 0x00
+	adsRegConfig     = 0x01
+
+	adsOS         = 1 << 15 // write: start a conversion, read: not busy
+	adsMuxSingle  = 0x4     // AINx vs GND, the channel is added to it
+	adsModeSingle = 1 << 8
+	adsCompOff    = 0x0003
+)
+
 var (
+	// ErrInvalidGain is returned for a gain the chip does not support
+	ErrInvalidGain = errors.New("ads1115: invalid gain")
+
+	// ErrOverRange is returned when a conversion saturates the
+	// selected gain, the input is beyond the full scale range.
+	ErrOverRange = errors.New("ads1115: input out of range")
+
+	// ErrNotReady is returned when a conversion does not complete
+	ErrNotReady = errors.New("ads1115: conversion timed out")
+
 	ads1115 *ADS1115
 )
 
-func sample2Volts(val int64) float64 {
-	return float64(val) / (1000 * 1000 * 1000)
+// Gain selects the full scale range of the programmable gain
+// amplifier. The zero value selects the ±4.096V default which
+// covers 3.3V sensors.
+type Gain int
+
+const (
+	GainDefault Gain = iota
+	Gain6_144V
+	Gain4_096V
+	Gain2_048V
+	Gain1_024V
+	Gain0_512V
+	Gain0_256V
+)
+
+// gains maps each Gain to its PGA field and full scale voltage
+var gains = map[Gain]struct {
+	pga uint16
+	fsr float64
+}{
+	Gain6_144V: {0, 6.144},
+	Gain4_096V: {1, 4.096},
+	Gain2_048V: {2, 2.048},
+	Gain1_024V: {3, 1.024},
+	Gain0_512V: {4, 0.512},
+	Gain0_256V: {5, 0.256},
+}
+
+// FullScale returns the full scale voltage of the gain
+func (g Gain) FullScale() float64 {
+	if g == GainDefault {
+		g = Gain4_096V
+	}
+	return gains[g].fsr
+}
+
+// GainFor returns the most sensitive gain that can still measure
+// maxVolts, an error is returned if no gain covers it.
+func GainFor(maxVolts float64) (Gain, error) {
+	maxVolts = math.Abs(maxVolts)
+	for g := Gain0_256V; g >= Gain6_144V; g-- {
+		if maxVolts <= gains[g].fsr {
+			return g, nil
+		}
+	}
+	return GainDefault, fmt.Errorf("%w: %.3fV exceeds ±6.144V", ErrInvalidGain, maxVolts)
+}
+
+func (g Gain) valid() bool {
+	_, ok := gains[g]
+	return ok || g == GainDefault
+}
+
+// DataRate is the number of samples per second the chip converts
+type DataRate int
+
+// rates maps each supported DataRate to its DR field
+var rates = map[DataRate]uint16{
+	8: 0, 16: 1, 32: 2, 64: 3, 128: 4, 250: 5, 475: 6, 860: 7,
+}
+
+// ConvMode selects how a pin converts
+type ConvMode int
+
+const (
+	// SingleShot starts a conversion for every Read and powers down
+	// in between, this is the default.
+	SingleShot ConvMode = iota
+
+	// Continuous leaves the converter running on the pin's channel,
+	// Read returns the latest result. Only one channel of a chip can
+	// convert continuously at a time.
+	Continuous
+)
+
+// ADS1115PinOpts configures a pin, the zero value is a single-shot
+// pin at the default gain.
+type ADS1115PinOpts struct {
+	Gain Gain
+	Mode ConvMode
 }
 
 // GetADS1115 will return the default ads1115 struct singleton. The
@@ -46,93 +163,256 @@ func GetADS1115() *ADS1115 {
 // NewADS creates a new ADS1115 giving it the provided name,
 // I2C bus (default /dev/i2c-1) and address (default 0x48).
 func NewADS1115(name string, bus string, addr int) *ADS1115 {
-	a := &ADS1115{}
+	a := &ADS1115{
+		Name: name,
+		Bus:  bus,
+		Addr: addr,
+		Rate: 128,
+	}
 	if device.IsMock() {
 		a.mock = true
+		if def := device.DefaultMockProfile(); slices.Contains(device.MockProfiles("ads1115"), def) {
+			if err := a.MockProfile(def); err != nil {
+				slog.Error("ads1115: default mock profile", "profile", def, "error", err)
+			}
+		}
 		return a
 	}
 
-	a.Init()
+	if err := a.Init(); err != nil {
+		slog.Error("ads1115: init failed", "bus", bus, "addr", addr, "error", err)
+	}
 	return a
 }
 
 // Init prepares the chip for usage
 func (a *ADS1115) Init() (err error) {
-	// Make sure periph is initialized.
-	if _, err := host.Init(); err != nil {
-		log.Printf("device_ads1115: host init failed: %s", err)
-		return err
-	}
-
-	// Open default I²C bus.
-	a.bus, err = i2creg.Open("")
-	if err != nil {
-		log.Printf("device_ads1115: i2c open failed: %s", err)
-		return err
+	if a.mock || a.dev != nil {
+		return nil
 	}
-
-	// Create a new ADS1115 ADC.
-	a.adc, err = ads1x15.NewADS1115(a.bus, &ads1x15.DefaultOpts)
-	if err != nil {
-		log.Printf("device_ads1115: new ads failed: %s", err)
-		return err
-	}
-	return nil
+	a.dev, err = NewI2CDevice(a.Bus, a.Addr)
+	return err
 }
 
-// Pin allocates and prepares one of the ads1115 pins (0 - 3) for use.
+// Pin allocates and prepares one of the ads1115 pins (0 - 3) for
+// use. opts may be nil, a Gain or an ADS1115PinOpts.
 func (a *ADS1115) Pin(name string, ch int, opts any) (pin *ADS1115Pin, err error) {
-	// Obtain an analog pin from the ADC.
 	if ch < 0 || ch > 3 {
 		return pin, fmt.Errorf("PinInit Invalid channel %d", ch)
 	}
 
-	c := ads1x15.Channel0
-	switch ch {
-	case 1:
-		c = ads1x15.Channel1
+	var o ADS1115PinOpts
+	switch v := opts.(type) {
+	case nil:
+	case Gain:
+		o.Gain = v
+	case ADS1115PinOpts:
+		o = v
+	default:
+		return pin, fmt.Errorf("ads1115: unsupported pin options %T", opts)
+	}
+	if !o.Gain.valid() {
+		return pin, fmt.Errorf("%w: %d", ErrInvalidGain, o.Gain)
+	}
+	if err := a.Init(); err != nil {
+		return pin, err
+	}
+
+	pin = &ADS1115Pin{
+		name: name,
+		ch:   ch,
+		gain: o.Gain,
+		mode: o.Mode,
+		ads:  a,
+		done: make(chan struct{}),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pins[ch] != nil {
+		a.pins[ch].stop()
+	}
+	a.pins[ch] = pin
+	return pin, nil
+}
+
+// MockCodes scripts the conversion codes returned for channel ch in
+// mock mode. Each conversion consumes one code, the last one keeps
+// being returned once the script runs out.
+func (a *ADS1115) MockCodes(ch int, codes ...int16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.codes[ch] = append(a.codes[ch], codes...)
+}
+
+// convert returns a conversion code for the pin along with the
+// gain it was made at
+func (a *ADS1115) convert(p *ADS1115Pin) (int16, Gain, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	case 2:
-		c = ads1x15.Channel2
+	code, err := a.convertLocked(p)
+	return code, p.gain, err
+}
 
-	case 3:
-		c = ads1x15.Channel3
+func (a *ADS1115) convertLocked(p *ADS1115Pin) (int16, error) {
+	if a.mock {
+		a.convs++
+		if a.nak > 0 && a.convs%a.nak == 0 {
+			return 0, &ErrNak{Bus: a.Bus, Addr: a.Addr, Err: device.ErrInjected}
+		}
+		switch {
+		case len(a.codes[p.ch]) > 0:
+			a.last[p.ch] = a.codes[p.ch][0]
+			a.codes[p.ch] = a.codes[p.ch][1:]
+		case a.live[p.ch] != nil:
+			a.last[p.ch] = voltsToCode(a.live[p.ch](), p.gain)
+		}
+		return a.last[p.ch], nil
+	}
+	if a.dev == nil {
+		return 0, fmt.Errorf("ads1115 %s: not initialized", a.Name)
 	}
 
-	pin = &ADS1115Pin{}
-	pin.PinADC, err = a.adc.PinForChannel(c, 3300*physic.MilliVolt, 1*physic.Hertz, ads1x15.SaveEnergy)
+	cfg, err := a.config(p)
 	if err != nil {
-		return pin, err
+		return 0, err
 	}
-	return pin, err
+
+	if p.mode == Continuous {
+		if a.cont != cfg {
+			if err := a.dev.WriteReg16(adsRegConfig, cfg); err != nil {
+				return 0, err
+			}
+			a.cont = cfg
+			// the first result is ready after one conversion
+			time.Sleep(a.conversionTime())
+		}
+	} else {
+		a.cont = 0
+		if err := a.dev.WriteReg16(adsRegConfig, cfg|adsOS); err != nil {
+			return 0, err
+		}
+		if err := a.waitReady(); err != nil {
+			return 0, err
+		}
+	}
+
+	v, err := a.dev.ReadReg16(adsRegConversion)
+	return int16(v), err
+}
+
+// config builds the config register for a conversion of p
+func (a *ADS1115) config(p *ADS1115Pin) (uint16, error) {
+	dr, ok := rates[a.Rate]
+	if !ok {
+		return 0, fmt.Errorf("ads1115 %s: unsupported data rate %d", a.Name, a.Rate)
+	}
+	g := p.gain
+	if g == GainDefault {
+		g = Gain4_096V
+	}
+
+	cfg := uint16(adsMuxSingle+p.ch)<<12 | gains[g].pga<<9 | dr<<5 | adsCompOff
+	if p.mode == SingleShot {
+		cfg |= adsModeSingle
+	}
+	return cfg, nil
+}
+
+// waitReady polls the OS bit of the config register until the
+// conversion in progress is done
+func (a *ADS1115) waitReady() error {
+	ct := a.conversionTime()
+	deadline := time.Now().Add(2*ct + 10*time.Millisecond)
+	time.Sleep(ct)
+	for {
+		cfg, err := a.dev.ReadReg16(adsRegConfig)
+		if err != nil {
+			return err
+		}
+		if cfg&adsOS != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s", ErrNotReady, a.Name)
+		}
+		time.Sleep(ct / 4)
+	}
+}
+
+// conversionTime is the time a single conversion takes at Rate
+func (a *ADS1115) conversionTime() time.Duration {
+	if a.Rate <= 0 {
+		return time.Second / 128
+	}
+	return time.Second/time.Duration(a.Rate) + 100*time.Microsecond
 }
 
 // Close the ads1115 and shutdown all the pins
 func (a *ADS1115) Close() error {
-	a.bus.Close()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for i := 0; i < 4; i++ {
 		if a.pins[i] != nil {
-			a.pins[i].Close()
+			a.pins[i].stop()
+			a.pins[i] = nil
 		}
 	}
-	return nil
+	if a.dev == nil {
+		return nil
+	}
+
+	// a single-shot config with no conversion powers the chip down
+	err := a.dev.WriteReg16(adsRegConfig, 0x8583&^adsOS)
+	a.cont = 0
+	if cerr := a.dev.Close(); err == nil {
+		err = cerr
+	}
+	a.dev = nil
+	return err
 }
 
 // String returns a string. Clean code!
 func (a *ADS1115) String() string {
-	return "ADS1115 todo write string function"
+	return fmt.Sprintf("ADS1115 %s %s %#02x", a.Name, a.Bus, a.Addr)
 }
 
 // JSON returns JSON more clean!
 func (a *ADS1115) JSON() []byte {
-	panic("write ads1115 JSON function")
-	return nil
+	var pins []string
+	a.mu.Lock()
+	for _, p := range a.pins {
+		if p != nil {
+			pins = append(pins, p.name)
+		}
+	}
+	a.mu.Unlock()
+
+	j, err := json.Marshal(struct {
+		Name string   `json:"name"`
+		Bus  string   `json:"bus"`
+		Addr int      `json:"addr"`
+		Rate DataRate `json:"rate"`
+		Pins []string `json:"pins"`
+	}{a.Name, a.Bus, a.Addr, a.Rate, pins})
+	if err != nil {
+		slog.Error("ads1115: json", "error", err)
+	}
+	return j
 }
 
 // ADS1115Pin is an analog analagous to a digital pin
 type ADS1115Pin struct {
 	name string
-	ads1x15.PinADC
+	ch   int
+	gain Gain
+	mode ConvMode
+	ads  *ADS1115
+
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 // Name is what we call this pin
@@ -143,46 +423,124 @@ func (p *ADS1115Pin) Name() string {
 // String is written text to hopefully drop some old eyeball style of
 // knowledge.
 func (p *ADS1115Pin) String() string {
-	return p.name + ": todo write String() function"
+	return fmt.Sprintf("%s: ain%d ±%.3fV", p.name, p.ch, p.Gain().FullScale())
+}
+
+// Gain returns the gain the pin converts with
+func (p *ADS1115Pin) Gain() Gain {
+	p.ads.mu.Lock()
+	defer p.ads.mu.Unlock()
+	return p.gain
+}
+
+// SetGain changes the gain used by the following conversions
+func (p *ADS1115Pin) SetGain(g Gain) error {
+	if !g.valid() {
+		return fmt.Errorf("%w: %d", ErrInvalidGain, g)
+	}
+	p.ads.mu.Lock()
+	p.gain = g
+	p.ads.mu.Unlock()
+	return nil
 }
 
-// Read returns a single float64 reading from the pin
-func (p ADS1115Pin) Read() (float64, error) {
-	reading, err := p.PinADC.Read()
+// ReadCode returns the raw signed conversion code of the pin
+func (p *ADS1115Pin) ReadCode() (int16, error) {
+	code, _, err := p.ads.convert(p)
+	return code, err
+}
+
+// Read returns a single float64 reading from the pin in volts. A
+// reading that saturates the gain is returned along with
+// ErrOverRange.
+func (p *ADS1115Pin) Read() (float64, error) {
+	code, gain, err := p.ads.convert(p)
 	if err != nil {
 		return 0.0, err
 	}
-	volts := sample2Volts(int64(reading.V))
-	return volts, err
+	volts := CodeToVolts(code, gain)
+	if code == math.MaxInt16 || code == math.MinInt16 {
+		return volts, fmt.Errorf("%w: %s reads beyond ±%.3fV", ErrOverRange, p.name, gain.FullScale())
+	}
+	return volts, nil
+}
+
+// ReadVolts is Read, it makes the pin an AnalogReader
+func (p *ADS1115Pin) ReadVolts() (float64, error) {
+	return p.Read()
+}
+
+// Resolution returns the volts of one code at the current gain
+func (p *ADS1115Pin) Resolution() float64 {
+	return p.Gain().FullScale() / 32768.0
+}
+
+// Reference returns the full scale voltage of the current gain
+func (p *ADS1115Pin) Reference() float64 {
+	return p.Gain().FullScale()
+}
+
+// CodeToVolts converts a conversion code made at gain g to volts
+func CodeToVolts(code int16, g Gain) float64 {
+	return float64(code) * g.FullScale() / 32768.0
+}
+
+// voltsToCode is the code of volts at gain g, saturated like the chip
+func voltsToCode(volts float64, g Gain) int16 {
+	code := math.Round(volts / g.FullScale() * 32768.0)
+	return int16(min(max(code, math.MinInt16), math.MaxInt16))
 }
 
 // Set the value on the PIN
-func (p ADS1115Pin) Set(val float64) error {
+func (p *ADS1115Pin) Set(val float64) error {
 	return errors.New("Analog Pin ads1115 can not be set")
 }
 
 // ReadContinous returns a channel that will continually read
 // data from respective ads1115 pin and make the float64 values
-// available as soon as the data is ready.
-func (p ADS1115Pin) ReadContinuous() <-chan float64 {
-	// Read values continuously from ADC.
-	c := p.PinADC.ReadContinuous()
+// available as soon as the data is ready. The channel is closed
+// when the pin is closed.
+func (p *ADS1115Pin) ReadContinuous() <-chan float64 {
 	floatQ := make(chan float64)
 	go func() {
-		var vsamp analog.Sample
+		defer close(floatQ)
 
+		t := time.NewTicker(p.ads.conversionTime())
+		defer t.Stop()
 		for {
-			vsamp = <-c
-			volts := sample2Volts(int64(vsamp.V))
-			floatQ <- volts
+			select {
+			case <-p.done:
+				return
+			case <-t.C:
+			}
+
+			volts, err := p.Read()
+			if err != nil && !errors.Is(err, ErrOverRange) {
+				slog.Error("ads1115: read failed", "pin", p.name, "error", err)
+				continue
+			}
+			select {
+			case floatQ <- volts:
+			case <-p.done:
+				return
+			}
 		}
 	}()
 
 	return floatQ
 }
 
-// Close the pin and set it back to it's defaults. TODO
-// set the pin back to its defaults
-func (a ADS1115Pin) Close() error {
-	return a.Halt()
+// Close stops any continuous reads and releases the channel
+func (p *ADS1115Pin) Close() error {
+	p.ads.mu.Lock()
+	defer p.ads.mu.Unlock()
+	if p.ads.pins[p.ch] == p {
+		p.ads.pins[p.ch] = nil
+	}
+	p.stop()
+	return nil
+}
+
+func (p *ADS1115Pin) stop() {
+	p.stopOnce.Do(func() { close(p.done) })
 }
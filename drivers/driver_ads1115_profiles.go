@@ -0,0 +1,45 @@
+package drivers
+
+import (
+	device "github.com/rustyeddy/otto-devices"
+)
+
+// the mock profiles of an ADS1115, the inputs of its four channels.
+// "grounded" reads 0V but for a millivolt of noise, "noisy" a 3.3V
+// sensor at half scale on a long cable, its bus failing to ack every
+// 50th conversion.
+func init() {
+	device.RegisterMockProfile("ads1115", "grounded", func(a *ADS1115) error {
+		r := device.NewMockRand(device.MockSeedOf(a.Name))
+		a.profile(0, func() device.Generator { return device.Noise(0.001, r) })
+		return nil
+	})
+	device.RegisterMockProfile("ads1115", "noisy", func(a *ADS1115) error {
+		r := device.NewMockRand(device.MockSeedOf(a.Name))
+		a.profile(50, func() device.Generator {
+			return device.Sum(device.Walk(1.65, 0.005, 0, 3.3, r), device.Noise(0.02, r))
+		})
+		return nil
+	})
+}
+
+// MockProfile applies the mock profile name to the channels of the
+// chip, see device.RegisterMockProfile. It fails with
+// device.ErrMockProfile listing the profiles of an ADS1115 when none
+// has the name.
+func (a *ADS1115) MockProfile(name string) error {
+	return device.ApplyMockProfile("ads1115", name, a)
+}
+
+// profile has the mock conversions of each channel take the volts of
+// gen, started now, and fail every nak-th one. The codes scripted
+// with MockCodes come first.
+func (a *ADS1115) profile(nak int, gen func() device.Generator) {
+	now := device.DefaultClock().Now
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ch := range a.live {
+		a.live[ch] = device.LiveOn(gen(), now)
+	}
+	a.nak, a.convs = nak, 0
+}
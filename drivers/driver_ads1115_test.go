@@ -0,0 +1,276 @@
+package drivers_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// adsFake keeps the 16 bit conversion register apart from the
+// config register, the byte wide register map of driverstest.I2C
+// would otherwise overlap them.
+type adsFake struct {
+	*driverstest.I2C
+	conv int16
+}
+
+func (f *adsFake) ReadReg(reg byte, buf []byte) error {
+	if reg == 0x00 {
+		binary.BigEndian.PutUint16(buf, uint16(f.conv))
+		return nil
+	}
+	return f.I2C.ReadReg(reg, buf)
+}
+
+func newADS(t *testing.T) (*drivers.ADS1115, *adsFake) {
+	t.Helper()
+	fake := &adsFake{I2C: driverstest.NewI2C()}
+	driverstest.UseI2C(t, fake)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	ads.Rate = 860
+	t.Cleanup(func() { ads.Close() })
+	return ads, fake
+}
+
+func TestADS1115Gain(t *testing.T) {
+	tests := []struct {
+		volts float64
+		gain  drivers.Gain
+		fsr   float64
+	}{
+		{volts: 0.1, gain: drivers.Gain0_256V, fsr: 0.256},
+		{volts: 0.3, gain: drivers.Gain0_512V, fsr: 0.512},
+		{volts: 3.3, gain: drivers.Gain4_096V, fsr: 4.096},
+		{volts: -5.0, gain: drivers.Gain6_144V, fsr: 6.144},
+	}
+
+	for _, tt := range tests {
+		g, err := drivers.GainFor(tt.volts)
+		if err != nil || g != tt.gain || g.FullScale() != tt.fsr {
+			t.Errorf("GainFor(%.2f) got (%d, %v) want (%d, nil)", tt.volts, g, err, tt.gain)
+		}
+	}
+	if _, err := drivers.GainFor(12.0); !errors.Is(err, drivers.ErrInvalidGain) {
+		t.Errorf("GainFor(12) error = %v want %v", err, drivers.ErrInvalidGain)
+	}
+	if drivers.GainDefault.FullScale() != 4.096 {
+		t.Errorf("default gain got (%f) want (4.096)", drivers.GainDefault.FullScale())
+	}
+}
+
+func TestADS1115CodeToVolts(t *testing.T) {
+	tests := []struct {
+		code  int16
+		gain  drivers.Gain
+		volts float64
+	}{
+		{code: 0, gain: drivers.Gain4_096V, volts: 0},
+		{code: 16384, gain: drivers.Gain4_096V, volts: 2.048},
+		{code: -16384, gain: drivers.Gain2_048V, volts: -1.024},
+		{code: 26400, gain: drivers.GainDefault, volts: 3.3},
+	}
+
+	for _, tt := range tests {
+		got := drivers.CodeToVolts(tt.code, tt.gain)
+		if math.Abs(got-tt.volts) > 0.001 {
+			t.Errorf("CodeToVolts(%d) got (%f) want (%f)", tt.code, got, tt.volts)
+		}
+	}
+}
+
+func TestADS1115Mock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	pin, err := ads.Pin("ain1", 1, drivers.Gain2_048V)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	ads.MockCodes(1, 8192, 32767)
+
+	v, err := pin.Read()
+	if err != nil || math.Abs(v-0.512) > 0.0001 {
+		t.Errorf("Read() got (%f, %v) want (0.512, nil)", v, err)
+	}
+	if _, err := pin.Read(); !errors.Is(err, drivers.ErrOverRange) {
+		t.Errorf("Read() error = %v want %v", err, drivers.ErrOverRange)
+	}
+	// the script ran out, the last code repeats
+	if code, _ := pin.ReadCode(); code != 32767 {
+		t.Errorf("ReadCode() got (%d) want (32767)", code)
+	}
+}
+
+func TestADS1115MockProfile(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	pin, err := ads.Pin("ain0", 0, nil)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if err := ads.MockProfile("grounded"); err != nil {
+		t.Fatalf("MockProfile(grounded) error = %v", err)
+	}
+	if v, err := pin.Read(); err != nil || math.Abs(v) > 0.01 {
+		t.Errorf("Read() grounded got (%f, %v) want (0)", v, err)
+	}
+
+	if err := ads.MockProfile("noisy"); err != nil {
+		t.Fatalf("MockProfile(noisy) error = %v", err)
+	}
+	var naks int
+	for range 100 {
+		v, err := pin.Read()
+		var nak *drivers.ErrNak
+		switch {
+		case errors.As(err, &nak):
+			naks++
+		case err != nil || v < 1 || v > 2.3:
+			t.Errorf("Read() noisy got (%f, %v) want about 1.65V", v, err)
+		}
+	}
+	if naks != 2 {
+		t.Errorf("noisy conversions not acked got (%d) want (2)", naks)
+	}
+
+	// the station's profile
+	defer device.SetDefaultMockProfile("")
+	if err := device.SetDefaultMockProfile("grounded"); err != nil {
+		t.Fatalf("SetDefaultMockProfile() error = %v", err)
+	}
+	pin, _ = drivers.NewADS1115("station", "/dev/i2c-1", 0x49).Pin("ain3", 3, nil)
+	if v, err := pin.Read(); err != nil || math.Abs(v) > 0.01 {
+		t.Errorf("Read() of the default profile got (%f, %v) want (0)", v, err)
+	}
+
+	if err := ads.MockProfile("flaky"); !errors.Is(err, device.ErrMockProfile) {
+		t.Errorf("MockProfile(flaky) error got (%v) want (%v)", err, device.ErrMockProfile)
+	}
+}
+
+func TestADS1115PinErrors(t *testing.T) {
+	ads, _ := newADS(t)
+
+	if _, err := ads.Pin("bad", 4, nil); err == nil {
+		t.Error("Pin(4) expected an error")
+	}
+	if _, err := ads.Pin("bad", 0, drivers.Gain(42)); !errors.Is(err, drivers.ErrInvalidGain) {
+		t.Errorf("Pin() error = %v want %v", err, drivers.ErrInvalidGain)
+	}
+	pin, err := ads.Pin("ain0", 0, nil)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if err := pin.SetGain(drivers.Gain(-1)); !errors.Is(err, drivers.ErrInvalidGain) {
+		t.Errorf("SetGain() error = %v want %v", err, drivers.ErrInvalidGain)
+	}
+}
+
+func TestADS1115SingleShot(t *testing.T) {
+	ads, fake := newADS(t)
+	fake.conv = 8000
+
+	pin, err := ads.Pin("ain2", 2, drivers.Gain1_024V)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	v, err := pin.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if want := 8000 * 1.024 / 32768; math.Abs(v-want) > 0.0001 {
+		t.Errorf("Read() got (%f) want (%f)", v, want)
+	}
+
+	// OS=1, MUX=110 (AIN2), PGA=011, MODE=1, DR=111, comparator off
+	want := uint16(0x8000 | 0x6000 | 0x0600 | 0x0100 | 0x00E0 | 0x0003)
+	w := fake.Writes[len(fake.Writes)-1]
+	if w.Reg != 0x01 || binary.BigEndian.Uint16(w.Data) != want {
+		t.Errorf("config got (%d % x) want (1 %04x)", w.Reg, w.Data, want)
+	}
+}
+
+func TestADS1115NotReady(t *testing.T) {
+	// a busy chip never reports OS=1
+	driverstest.UseI2C(t, &busyADS{adsFake: &adsFake{I2C: driverstest.NewI2C()}})
+
+	ads := drivers.NewADS1115("busy", "/dev/i2c-1", 0x48)
+	ads.Rate = 860
+	defer ads.Close()
+
+	pin, err := ads.Pin("ain0", 0, nil)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if _, err := pin.Read(); !errors.Is(err, drivers.ErrNotReady) {
+		t.Errorf("Read() error = %v want %v", err, drivers.ErrNotReady)
+	}
+}
+
+type busyADS struct {
+	*adsFake
+}
+
+func (b *busyADS) ReadReg(reg byte, buf []byte) error {
+	if reg == 0x01 {
+		buf[0], buf[1] = 0x05, 0x83
+		return nil
+	}
+	return b.adsFake.ReadReg(reg, buf)
+}
+
+func TestADS1115Continuous(t *testing.T) {
+	ads, fake := newADS(t)
+	fake.conv = 4096
+
+	pin, err := ads.Pin("ain3", 3, drivers.ADS1115PinOpts{Mode: drivers.Continuous})
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+
+	q := pin.ReadContinuous()
+	for i := 0; i < 3; i++ {
+		select {
+		case v := <-q:
+			if math.Abs(v-0.512) > 0.0001 {
+				t.Errorf("ReadContinuous() got (%f) want (0.512)", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("ReadContinuous() timed out")
+		}
+	}
+
+	// continuous mode configures the chip once
+	configs := 0
+	for _, w := range fake.Writes {
+		if w.Reg == 0x01 {
+			configs++
+			if binary.BigEndian.Uint16(w.Data)&0x0100 != 0 {
+				t.Error("continuous config has MODE set to single-shot")
+			}
+		}
+	}
+	if configs != 1 {
+		t.Errorf("config writes got (%d) want (1)", configs)
+	}
+
+	pin.Close()
+	select {
+	case _, ok := <-q:
+		for ok {
+			_, ok = <-q
+		}
+	case <-time.After(time.Second):
+		t.Error("ReadContinuous() channel not closed after Close()")
+	}
+}
@@ -1,8 +1,14 @@
 package drivers
 
 import (
-	"math/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
 	"time"
+
+	device "github.com/rustyeddy/otto-devices"
 )
 
 type AnalogPin interface {
@@ -11,24 +17,117 @@ type AnalogPin interface {
 	Close() error
 }
 
+// AnalogReader is what analog devices read their voltage from. It
+// is implemented by the ADS1115 pins, MockAnalogPin and Calibrated,
+// devices take one in their constructor so the ADC under them can be
+// swapped without touching the device.
+type AnalogReader interface {
+	// ReadVolts returns a single reading in volts
+	ReadVolts() (float64, error)
+
+	// Resolution is the smallest step in volts the reader can
+	// tell apart, one LSB of the ADC
+	Resolution() float64
+
+	// Reference is the full scale voltage of the reader
+	Reference() float64
+}
+
+var (
+	// ErrCalibration is returned for calibration points that do
+	// not define a line
+	ErrCalibration = errors.New("invalid calibration")
+)
+
+// MockAnalogPin is an AnalogReader for mock mode. Without a Gen it
+// reads random values between 0 and 1 volt, of the mock randomness
+// of its name like a device's, see SetMockSeed.
 type MockAnalogPin struct {
 	PinName string
 	Offset  int
-	val     float64
+
+	// Ref and Bits describe the pretend ADC, they default to a 12
+	// bit 3.3V ADC like the ones built into most microcontrollers
+	Ref  float64
+	Bits int
+
+	// Gen generates the readings when it is set
+	Gen func() float64
+
+	val  float64
+	rand *device.MockRand
+	mu   sync.Mutex
 }
 
 func NewMockAnalogPin(name string, pin int, opts ...any) *MockAnalogPin {
 	return &MockAnalogPin{
 		PinName: name,
 		Offset:  pin,
+		Ref:     3.3,
+		Bits:    12,
+	}
+}
+
+// MockValues makes the pin read vals in order, repeating the last
+// one once they are used up
+func (a *MockAnalogPin) MockValues(vals ...float64) {
+	var i int
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Gen = func() float64 {
+		v := vals[i]
+		if i < len(vals)-1 {
+			i++
+		}
+		return v
 	}
 }
 
+// SetGenerator makes the pin read the volts of g, started now by the
+// default clock
+func (a *MockAnalogPin) SetGenerator(g device.Generator) {
+	live := device.LiveOn(g, device.DefaultClock().Now)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Gen = live
+}
+
+// SetMockSeed seeds the random values the pin reads without a Gen
+func (a *MockAnalogPin) SetMockSeed(seed int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rand = device.NewMockRand(seed)
+}
+
 func (a *MockAnalogPin) Read() (float64, error) {
-	a.val = rand.Float64()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Gen != nil {
+		a.val = a.Gen()
+	} else {
+		if a.rand == nil {
+			a.rand = device.NewMockRand(device.MockSeedOf(a.PinName))
+		}
+		a.val = a.rand.Float64()
+	}
 	return a.val, nil
 }
 
+// ReadVolts is Read
+func (a *MockAnalogPin) ReadVolts() (float64, error) {
+	return a.Read()
+}
+
+// Resolution returns Ref divided into 2^Bits steps
+func (a *MockAnalogPin) Resolution() float64 {
+	return a.Ref / float64(uint64(1)<<a.Bits)
+}
+
+// Reference returns Ref
+func (a *MockAnalogPin) Reference() float64 {
+	return a.Ref
+}
+
 func (a *MockAnalogPin) ReadContinuous() <-chan float64 {
 	readQ := make(chan float64)
 
@@ -46,3 +145,67 @@ func (a *MockAnalogPin) ReadContinuous() <-chan float64 {
 func (a *MockAnalogPin) Close() error {
 	return nil
 }
+
+// Calibrated corrects the readings of an AnalogReader with a two
+// point linear calibration, volts = raw * Scale + Offset. The zero
+// value of Scale passes readings through unchanged.
+type Calibrated struct {
+	AnalogReader
+
+	Scale  float64
+	Offset float64
+}
+
+// NewCalibrated layers a calibration over r, see Calibrate
+func NewCalibrated(r AnalogReader, raw1, want1, raw2, want2 float64) (*Calibrated, error) {
+	c := &Calibrated{AnalogReader: r}
+	if err := c.Calibrate(raw1, want1, raw2, want2); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Calibrate sets the scale and offset from two points, raw is what
+// the reader read and want what it should have read, for example
+// the readings of two buffer solutions or of a meter.
+func (c *Calibrated) Calibrate(raw1, want1, raw2, want2 float64) error {
+	if raw1 == raw2 || want1 == want2 {
+		return fmt.Errorf("%w: (%v, %v) and (%v, %v)", ErrCalibration, raw1, want1, raw2, want2)
+	}
+	c.Scale = (want2 - want1) / (raw2 - raw1)
+	c.Offset = want1 - raw1*c.Scale
+	return nil
+}
+
+// Apply corrects a raw reading
+func (c *Calibrated) Apply(raw float64) float64 {
+	if c.Scale == 0 {
+		return raw + c.Offset
+	}
+	return raw*c.Scale + c.Offset
+}
+
+// ReadVolts reads the underlying reader and corrects the reading. A
+// reading returned along with an error, like ErrOverRange, is
+// corrected too.
+func (c *Calibrated) ReadVolts() (float64, error) {
+	raw, err := c.AnalogReader.ReadVolts()
+	return c.Apply(raw), err
+}
+
+// Resolution is the resolution of the underlying reader after the
+// scale is applied
+func (c *Calibrated) Resolution() float64 {
+	if c.Scale == 0 {
+		return c.AnalogReader.Resolution()
+	}
+	return c.AnalogReader.Resolution() * math.Abs(c.Scale)
+}
+
+// Close closes the underlying reader if it can be closed
+func (c *Calibrated) Close() error {
+	if cl, ok := c.AnalogReader.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package drivers_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+func TestMockAnalogPin(t *testing.T) {
+	m := drivers.NewMockAnalogPin("mock", 0)
+	var _ drivers.AnalogReader = m
+
+	if m.Reference() != 3.3 {
+		t.Errorf("Reference() got (%f) want (3.3)", m.Reference())
+	}
+	if got, want := m.Resolution(), 3.3/4096; got != want {
+		t.Errorf("Resolution() got (%g) want (%g)", got, want)
+	}
+
+	v, err := m.ReadVolts()
+	if err != nil || v < 0 || v > 1 {
+		t.Errorf("random ReadVolts() got (%f, %v) want 0 - 1", v, err)
+	}
+
+	// the same seed reads the same
+	other := drivers.NewMockAnalogPin("other", 1)
+	m.SetMockSeed(7)
+	other.SetMockSeed(7)
+	for i := range 3 {
+		a, _ := m.ReadVolts()
+		b, _ := other.ReadVolts()
+		if a != b {
+			t.Errorf("ReadVolts() %d of the same seed got (%f) and (%f)", i, a, b)
+		}
+	}
+	m.MockValues(0.5, 1.5, 2.5)
+	for _, want := range []float64{0.5, 1.5, 2.5, 2.5} {
+		if v, _ := m.ReadVolts(); v != want {
+			t.Errorf("ReadVolts() got (%f) want (%f)", v, want)
+		}
+	}
+
+	// a generator by the default clock
+	clock := device.NewSimClock(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	defer device.SetDefaultClock(clock)()
+	m.SetGenerator(device.Ramp(1, 2, time.Hour))
+	clock.Advance(15 * time.Minute)
+	if v, _ := m.ReadVolts(); v != 1.25 {
+		t.Errorf("ReadVolts() of a Ramp got (%f) want (1.25)", v)
+	}
+}
+
+func TestCalibrated(t *testing.T) {
+	tests := []struct {
+		name                     string
+		raw1, want1, raw2, want2 float64
+		scale, offset            float64
+		raw, volts               float64
+	}{
+		{name: "identity", raw1: 0, want1: 0, raw2: 1, want2: 1, scale: 1, offset: 0, raw: 0.7, volts: 0.7},
+		{name: "offset", raw1: 0.1, want1: 0, raw2: 3.1, want2: 3, scale: 1, offset: -0.1, raw: 2.1, volts: 2},
+		{name: "gain", raw1: 0.5, want1: 1, raw2: 1.5, want2: 3, scale: 2, offset: 0, raw: 1.2, volts: 2.4},
+		// pH probe, 2.03V in pH 7 buffer and 2.53V in pH 4
+		{name: "inverted", raw1: 2.03, want1: 7, raw2: 2.53, want2: 4, scale: -6, offset: 19.18, raw: 2.28, volts: 5.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := drivers.NewMockAnalogPin("mock", 0)
+			m.MockValues(tt.raw)
+			c, err := drivers.NewCalibrated(m, tt.raw1, tt.want1, tt.raw2, tt.want2)
+			if err != nil {
+				t.Fatalf("NewCalibrated() error = %v", err)
+			}
+			if math.Abs(c.Scale-tt.scale) > 1e-9 || math.Abs(c.Offset-tt.offset) > 1e-9 {
+				t.Errorf("calibration got (%f, %f) want (%f, %f)", c.Scale, c.Offset, tt.scale, tt.offset)
+			}
+			v, err := c.ReadVolts()
+			if err != nil || math.Abs(v-tt.volts) > 1e-9 {
+				t.Errorf("ReadVolts() got (%f, %v) want (%f, nil)", v, err, tt.volts)
+			}
+			if got, want := c.Resolution(), m.Resolution()*math.Abs(tt.scale); math.Abs(got-want) > 1e-12 {
+				t.Errorf("Resolution() got (%g) want (%g)", got, want)
+			}
+			if c.Reference() != m.Reference() {
+				t.Errorf("Reference() got (%f) want (%f)", c.Reference(), m.Reference())
+			}
+		})
+	}
+}
+
+func TestCalibratedErrors(t *testing.T) {
+	m := drivers.NewMockAnalogPin("mock", 0)
+	if _, err := drivers.NewCalibrated(m, 1, 0, 1, 5); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("same raw points error = %v want %v", err, drivers.ErrCalibration)
+	}
+	if _, err := drivers.NewCalibrated(m, 1, 2, 3, 2); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("same wanted points error = %v want %v", err, drivers.ErrCalibration)
+	}
+
+	// the zero value passes readings through
+	c := &drivers.Calibrated{AnalogReader: m}
+	m.MockValues(1.25)
+	if v, _ := c.ReadVolts(); v != 1.25 {
+		t.Errorf("zero Calibrated ReadVolts() got (%f) want (1.25)", v)
+	}
+}
+
+func TestADS1115AnalogReader(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	ads := drivers.NewADS1115("ads", "/dev/i2c-1", 0x48)
+	p, err := ads.Pin("ain0", 0, drivers.Gain2_048V)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	defer p.Close()
+
+	var r drivers.AnalogReader = p
+	if r.Reference() != 2.048 || r.Resolution() != 2.048/32768 {
+		t.Errorf("metadata got (%f, %g) want (2.048, %g)", r.Reference(), r.Resolution(), 2.048/32768)
+	}
+	ads.MockCodes(0, 8192)
+	if v, err := r.ReadVolts(); err != nil || v != 0.512 {
+		t.Errorf("ReadVolts() got (%f, %v) want (0.512, nil)", v, err)
+	}
+}
@@ -0,0 +1,108 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// ErrPinClaimed matches every *ClaimError with errors.Is
+var ErrPinClaimed = errors.New("gpio line already claimed")
+
+// Claim records which pin holds a gpio line
+type Claim struct {
+	Chip   string    `json:"chip"`
+	Offset int       `json:"offset"`
+	Name   string    `json:"name"`
+	Owner  string    `json:"owner,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// ClaimError is returned when a line is requested by a pin while
+// another pin holds it
+type ClaimError struct {
+	Claim Claim  // the claim holding the line
+	Name  string // the pin that asked for it
+}
+
+func (e *ClaimError) Error() string {
+	by := fmt.Sprintf("'%s'", e.Claim.Name)
+	if e.Claim.Owner != "" {
+		by = e.Claim.Owner + " " + by
+	}
+	return fmt.Sprintf("offset %d on %s already claimed by %s", e.Claim.Offset, e.Claim.Chip, by)
+}
+
+func (e *ClaimError) Is(target error) bool {
+	return target == ErrPinClaimed
+}
+
+// OwnerOption names the kind of device a pin belongs to ("relay",
+// "button"), it shows up in Claims() and in claim conflicts. Like
+// ChipOption it is stripped before the options reach gpiocdev.
+type OwnerOption struct {
+	gpiocdev.LineReqOption
+	Owner string
+}
+
+// WithOwner returns an OwnerOption for the kind of device
+func WithOwner(kind string) OwnerOption {
+	return OwnerOption{Owner: kind}
+}
+
+// ownerFromOpts pulls an OwnerOption out of the line options
+func ownerFromOpts(opts []gpiocdev.LineReqOption) (string, []gpiocdev.LineReqOption) {
+	owner := ""
+	var rest []gpiocdev.LineReqOption
+	for _, o := range opts {
+		if c, ok := o.(OwnerOption); ok {
+			owner = c.Owner
+			continue
+		}
+		rest = append(rest, o)
+	}
+	return owner, rest
+}
+
+// Claims returns the lines held by every chip, ordered by chip and
+// offset
+func Claims() []Claim {
+	chipsMu.Lock()
+	gpios := make([]*GPIO, 0, len(chips))
+	for _, g := range chips {
+		gpios = append(gpios, g)
+	}
+	chipsMu.Unlock()
+
+	claims := []Claim{}
+	for _, g := range gpios {
+		for _, p := range g.Pins() {
+			claims = append(claims, p.claim())
+		}
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Chip != claims[j].Chip {
+			return chipLess(claims[i].Chip, claims[j].Chip)
+		}
+		return claims[i].Offset < claims[j].Offset
+	})
+	return claims
+}
+
+func (p *DigitalPin) claim() Claim {
+	return Claim{
+		Chip:   p.Chipname(),
+		Offset: p.offset,
+		Name:   p.name,
+		Owner:  p.owner,
+		Since:  p.claimed,
+	}
+}
+
+func init() {
+	device.RegisterStatus("gpio_claims", func() any { return Claims() })
+}
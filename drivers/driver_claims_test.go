@@ -0,0 +1,97 @@
+package drivers
+
+import (
+	"errors"
+	"testing"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestClaimConflict(t *testing.T) {
+	resetChips(t)
+	g := GetGPIO()
+
+	pump, err := g.Request("pump", 17, WithOwner("relay"), gpiocdev.AsOutput(0))
+	if err != nil {
+		t.Fatalf("Request(pump) error = %v", err)
+	}
+
+	fan, err := g.Request("fan", 17, gpiocdev.AsOutput(0))
+	if !errors.Is(err, ErrPinClaimed) {
+		t.Fatalf("Request(fan) error = %v want %v", err, ErrPinClaimed)
+	}
+	if want := "offset 17 on gpiochip0 already claimed by relay 'pump'"; err.Error() != want {
+		t.Errorf("error got (%s) want (%s)", err, want)
+	}
+	var ce *ClaimError
+	if !errors.As(err, &ce) || ce.Name != "fan" || ce.Claim.Name != "pump" {
+		t.Errorf("ClaimError got (%+v)", ce)
+	}
+
+	// the losing pin has no line and the winner is untouched
+	if err := fan.On(); err == nil {
+		t.Error("On() of the conflicting pin expected an error")
+	}
+	if err := pump.On(); err != nil {
+		t.Errorf("On() of the claiming pin error = %v", err)
+	}
+
+	// Pin logs the conflict and returns the same detached pin
+	if p := g.Pin("heater", 17, gpiocdev.AsOutput(0)); p.Line != nil {
+		t.Error("Pin() handed out a claimed line")
+	}
+
+	_, err = NewDigitalPinID("heater", "17")
+	if want := "offset 17 on gpiochip0 already claimed by relay 'pump'"; err == nil || err.Error() != want {
+		t.Errorf("NewDigitalPinID() error = %v want %s", err, want)
+	}
+}
+
+func TestClaimLifecycle(t *testing.T) {
+	resetChips(t)
+
+	pump := NewDigitalPin("pump", 17, WithOwner("relay"), gpiocdev.AsOutput(0))
+	NewDigitalPin("door", 4, WithOwner("button"), gpiocdev.AsInput)
+	NewDigitalPin("fan", 12, WithChip("gpiochip4"), gpiocdev.AsOutput(0))
+
+	claims := Claims()
+	want := []Claim{
+		{Chip: "gpiochip0", Offset: 4, Name: "door", Owner: "button"},
+		{Chip: "gpiochip0", Offset: 17, Name: "pump", Owner: "relay"},
+		{Chip: "gpiochip4", Offset: 12, Name: "fan"},
+	}
+	if len(claims) != len(want) {
+		t.Fatalf("Claims() got %d claims want %d", len(claims), len(want))
+	}
+	for i, w := range want {
+		c := claims[i]
+		if c.Chip != w.Chip || c.Offset != w.Offset || c.Name != w.Name || c.Owner != w.Owner || c.Since.IsZero() {
+			t.Errorf("Claims()[%d] got (%+v) want (%+v)", i, c, w)
+		}
+	}
+
+	// re-creating a device under the same name replaces its claim
+	again := NewDigitalPin("pump", 17, WithOwner("relay"), gpiocdev.AsOutput(1))
+	if !pump.IsClosed() || again.Line == nil {
+		t.Error("the re-created pin did not replace the old one")
+	}
+
+	// releasing the pin clears the claim
+	if err := again.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for _, c := range Claims() {
+		if c.Offset == 17 {
+			t.Errorf("claim survived Close(): %+v", c)
+		}
+	}
+	if _, err := GetGPIO().Request("heater", 17, gpiocdev.AsOutput(0)); err != nil {
+		t.Errorf("Request() of a released line error = %v", err)
+	}
+
+	status := device.GetDeviceManager().Status()
+	if claims, ok := status["gpio_claims"].([]Claim); !ok || len(claims) != 3 {
+		t.Errorf("manager status gpio_claims got (%v)", status["gpio_claims"])
+	}
+}
@@ -2,12 +2,16 @@ package drivers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rustyeddy/otto-devices/device"
+	device "github.com/rustyeddy/otto-devices"
 	"github.com/warthog618/go-gpiocdev"
 )
 
@@ -16,69 +20,145 @@ type GPIO struct {
 	Chipname string              `json:"chipname"`
 	pins     map[int]*DigitalPin `json:"pins"`
 	Mock     bool                `json:"mock"`
+	mu       sync.Mutex
 }
 
-var (
-	gpio *GPIO
-)
+// ErrClosed is returned when a pin is used after it was closed
+var ErrClosed = errors.New("gpio pin closed")
 
-// GetGPIO returns the GPIO singleton for the Raspberry PI
+// GetGPIO returns the GPIO for the default chip (DefaultChipname).
+// Use GetGPIOChip() to reach lines on other chips.
 func GetGPIO() *GPIO {
-	if gpio != nil {
-		return gpio
+	chipsMu.Lock()
+	defer chipsMu.Unlock()
+
+	if g, ex := chips[DefaultChipname]; ex {
+		return g
 	}
+	g := newGPIO(DefaultChipname)
+	chips[DefaultChipname] = g
+	return g
+}
 
-	gpio = &GPIO{
-		// Chipname: "gpiochip4", // raspberry pi-5
-		Chipname: "gpiochip0", // raspberry pi zero
+func newGPIO(chipname string) *GPIO {
+	return &GPIO{
+		Chipname: chipname,
 		Mock:     device.IsMock(),
+		pins:     make(map[int]*DigitalPin),
 	}
-	gpio.pins = make(map[int]*DigitalPin)
-	for _, pin := range gpio.pins {
-		if err := pin.Init(); err != nil {
-			slog.Error("Error initializing pin ", "offset", pin.offset)
-		}
-	}
-	return gpio
 }
 
-// Pin initializes the given GPIO pin, name and mode
+// Pin initializes the given GPIO pin, name and mode. Errors are
+// logged, the pin returned for a line claimed by another device has
+// no line and fails every operation. Use Request to get the error.
 func (gpio *GPIO) Pin(name string, offset int, opts ...gpiocdev.LineReqOption) *DigitalPin {
-
-	var dopts []gpiocdev.LineReqOption
-	for _, o := range opts {
-		dopts = append(dopts, o.(gpiocdev.LineReqOption))
+	p, err := gpio.Request(name, offset, opts...)
+	if err != nil {
+		slog.Error(err.Error(), "name", name, "offset", offset)
 	}
+	return p
+}
+
+// Request claims the line at offset for the pin name and initializes
+// it. A *ClaimError is returned if another pin holds the line, a pin
+// requested again under the same name (a device re-created) replaces
+// the old one. The pin is always returned, it has no line on error.
+func (gpio *GPIO) Request(name string, offset int, opts ...gpiocdev.LineReqOption) (*DigitalPin, error) {
+	_, dopts := chipFromOpts(opts)
+	owner, dopts := ownerFromOpts(dopts)
 	p := &DigitalPin{
-		name:   name,
-		offset: offset,
-		opts:   dopts,
+		name:    name,
+		owner:   owner,
+		offset:  offset,
+		opts:    dopts,
+		gpio:    gpio,
+		claimed: time.Now(),
 	}
 
+	gpio.mu.Lock()
 	if gpio.pins == nil {
 		gpio.pins = make(map[int]*DigitalPin)
 	}
+	old := gpio.pins[offset]
+	if old != nil && old.name != name {
+		gpio.mu.Unlock()
+		return p, &ClaimError{Claim: old.claim(), Name: name}
+	}
 	gpio.pins[offset] = p
+	gpio.mu.Unlock()
+
+	// release the line of the replaced pin first or the kernel
+	// reports it busy
+	if old != nil {
+		old.Close()
+	}
+
 	if err := p.Init(); err != nil {
-		slog.Error(err.Error(), "name", name, "offset", offset)
+		gpio.release(p)
+		return p, err
 	}
-	return p
+	return p, nil
+}
+
+// CloseAll releases every pin requested from this chip allowing
+// the lines to be used by another program.
+func (gpio *GPIO) CloseAll() error {
+	var errs []error
+	for _, p := range gpio.Pins() {
+		if err := p.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", p.name, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// Shutdown resets the GPIO line allowing use by another program
+// Close resets the GPIO lines allowing use by another program
 func (gpio *GPIO) Close() error {
+	return gpio.CloseAll()
+}
+
+// Pins returns the pins currently held open on this chip
+func (gpio *GPIO) Pins() []*DigitalPin {
+	gpio.mu.Lock()
+	defer gpio.mu.Unlock()
+
+	pins := make([]*DigitalPin, 0, len(gpio.pins))
 	for _, p := range gpio.pins {
-		p.Reconfigure(gpiocdev.AsInput)
-		p.Close()
+		pins = append(pins, p)
 	}
-	gpio.pins = nil
-	return nil
+	return pins
+}
+
+// release forgets p, it is called when the pin is closed
+func (gpio *GPIO) release(p *DigitalPin) {
+	gpio.mu.Lock()
+	defer gpio.mu.Unlock()
+	if gpio.pins[p.offset] == p {
+		delete(gpio.pins, p.offset)
+	}
+}
+
+// CloseAllGPIO releases the pins of every chip and forgets the
+// chips, a following GetGPIO() starts fresh.
+func CloseAllGPIO() error {
+	chipsMu.Lock()
+	all := chips
+	chips = make(map[string]*GPIO)
+	chipsMu.Unlock()
+
+	var errs []error
+	for _, g := range all {
+		if err := g.CloseAll(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // String returns the string representation of the GPIO
 func (gpio *GPIO) String() string {
 	str := ""
-	for _, pin := range gpio.pins {
+	for _, pin := range gpio.Pins() {
 		str += pin.String()
 	}
 	return str
@@ -104,20 +184,47 @@ type Line interface {
 }
 
 type DigitalPin struct {
-	name string
-	opts []gpiocdev.LineReqOption
+	name  string
+	owner string
+	opts  []gpiocdev.LineReqOption
 	Line
 
-	offset int
-	val    int
-	mock   bool
+	// ReadBack makes Value() read the line of an output pin from the
+	// hardware instead of returning the value last written to it.
+	// Not every backend can read back an output.
+	ReadBack bool
+
+	gpio    *GPIO
+	offset  int
+	val     int
+	claimed time.Time
+	changed time.Time
+	output  bool
+	mock    bool
+	closed  bool
+	mu      sync.Mutex
+
+	// held by the event handlers while they run, Close takes it to
+	// wait for them before closing EvtQ
+	events sync.RWMutex
 
 	gpiocdev.EventHandler `json:"event-handler"`
 	EvtQ                  chan gpiocdev.LineEvent
 }
 
+// NewDigitalPin creates a pin on the default chip, or on the chip
+// selected with a WithChip() option.
 func NewDigitalPin(name string, offset int, opts ...gpiocdev.LineReqOption) *DigitalPin {
-	gpio := GetGPIO()
+	chip, _ := chipFromOpts(opts)
+	if chip == "" {
+		return GetGPIO().Pin(name, offset, opts...)
+	}
+
+	gpio, err := GetGPIOChip(chip)
+	if err != nil {
+		slog.Error(err.Error(), "name", name, "offset", offset)
+		return &DigitalPin{name: name, offset: offset}
+	}
 	return gpio.Pin(name, offset, opts...)
 }
 
@@ -125,25 +232,87 @@ func (p *DigitalPin) PinName() string {
 	return p.name
 }
 
+// Chipname returns the name of the chip the pin was requested from
+func (p *DigitalPin) Chipname() string {
+	if p.gpio == nil {
+		return ""
+	}
+	return p.gpio.Chipname
+}
+
 // Init the pin from the offset and mode
 func (p *DigitalPin) Init() error {
 
-	gpio := GetGPIO()
-	if gpio.Mock {
-		line := GetMockLine(p.offset, p.opts...)
+	gpio := p.gpio
+	if gpio == nil {
+		return fmt.Errorf("pin %s has no gpio chip", p.name)
+	}
+	_, registered := registeredGPIOChip(gpio.Chipname)
+	if gpio.Mock && !registered {
+		chipsMu.Lock()
+		lines, ex := mockChips[gpio.Chipname]
+		chipsMu.Unlock()
+		if ex && p.offset >= lines {
+			return fmt.Errorf("offset %d out of range for %s (%d lines)", p.offset, gpio.Chipname, lines)
+		}
+		line := GetMockLine(p.offset, p.lineOpts()...)
 		p.mock = true
-		p.Line = line
+		p.setLine(line)
 		return nil
 	}
 
-	line, err := gpiocdev.RequestLine(gpio.Chipname, p.offset, p.opts...)
+	chip, err := OpenGPIOChip(gpio.Chipname)
 	if err != nil {
 		return err
 	}
-	p.Line = line
+	line, err := chip.RequestLine(p.offset, p.lineOpts()...)
+	if err != nil {
+		return err
+	}
+	p.setLine(line)
 	return nil
 }
 
+// lineOpts returns the options the line is requested with, the event
+// handlers guarded so none runs once the pin is closed
+func (p *DigitalPin) lineOpts() []gpiocdev.LineReqOption {
+	opts := slices.Clone(p.opts)
+	for i, o := range opts {
+		h, ok := o.(gpiocdev.EventHandler)
+		if !ok {
+			continue
+		}
+		opts[i] = gpiocdev.EventHandler(func(evt gpiocdev.LineEvent) {
+			p.events.RLock()
+			defer p.events.RUnlock()
+			if !p.isClosed() {
+				h(evt)
+			}
+		})
+	}
+	return opts
+}
+
+// setLine makes line the pin's line, an output starts tracking the
+// value it was requested with
+func (p *DigitalPin) setLine(line Line) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Line = line
+	p.output = false
+	for _, o := range p.opts {
+		if v, ok := o.(gpiocdev.OutputOption); ok {
+			p.output = true
+			p.val = 0
+			if len(v) > 0 && v[0] != 0 {
+				p.val = 1
+			}
+		}
+	}
+	p.changed = time.Now()
+}
+
 func (p *DigitalPin) SetOpts(opts ...gpiocdev.LineReqOption) {
 	p.opts = append(p.opts, opts...)
 }
@@ -162,6 +331,9 @@ func (pin *DigitalPin) String() string {
 // the GPIO value fails. Note: you can Get() the value of an
 // input pin so no direction checks are done
 func (pin *DigitalPin) Get() (int, error) {
+	if pin.isClosed() {
+		return 0, ErrClosed
+	}
 	if pin.Line == nil {
 		return 0, fmt.Errorf("GPIO not active")
 	}
@@ -172,15 +344,58 @@ func (pin *DigitalPin) Get() (int, error) {
 	return val, err
 }
 
-// Set the value of the pin. Note: you can NOT set the value
-// of an input pin, so we will check it and return an error.
-// This maybe worthy of making it a panic!
+// Set the value of the pin and remember it as the pin's value.
+// The value is only recorded once the line accepted it.
 func (pin *DigitalPin) Set(v int) error {
+	pin.mu.Lock()
+	defer pin.mu.Unlock()
+	return pin.setLocked(v)
+}
+
+func (pin *DigitalPin) setLocked(v int) error {
+	if pin.closed {
+		return ErrClosed
+	}
 	if pin.Line == nil {
 		return fmt.Errorf("GPIO not active")
 	}
-	pin.val = v
-	return pin.Line.SetValue(v)
+	if v != 0 {
+		v = 1
+	}
+	if err := pin.Line.SetValue(v); err != nil {
+		return err
+	}
+	if v != pin.val {
+		pin.val = v
+		pin.changed = time.Now()
+	}
+	return nil
+}
+
+// Value returns the value last written to an output pin. Inputs,
+// and outputs with ReadBack set, read the line instead.
+func (pin *DigitalPin) Value() (int, error) {
+	pin.mu.Lock()
+	defer pin.mu.Unlock()
+
+	if pin.closed {
+		return 0, ErrClosed
+	}
+	if pin.Line == nil {
+		return 0, fmt.Errorf("GPIO not active")
+	}
+	if !pin.output || pin.ReadBack {
+		return pin.Line.Value()
+	}
+	return pin.val, nil
+}
+
+// LastChanged returns when the value written to the pin last
+// changed, or when the line was requested if it never has.
+func (pin *DigitalPin) LastChanged() time.Time {
+	pin.mu.Lock()
+	defer pin.mu.Unlock()
+	return pin.changed
 }
 
 // On sets the value of the pin to 1
@@ -193,43 +408,23 @@ func (pin *DigitalPin) Off() error {
 	return pin.Set(0)
 }
 
-// Toggle with flip the value of the pin from 1 to 0 or 0 to 1
+// Toggle with flip the value of the pin from 1 to 0 or 0 to 1, the
+// value last written is flipped so an output never has to be read
+// back.
 func (pin *DigitalPin) Toggle() error {
-	val, err := pin.Get()
-	if err != nil {
-		return err
-	}
-
-	if val == 0 {
-		val = 1
-	} else {
-		val = 0
-	}
-	return pin.Set(val)
+	pin.mu.Lock()
+	defer pin.mu.Unlock()
+	return pin.setLocked(pin.val ^ 1)
 }
 
-// Callback is the default callback for pins if they are
-// registered with the MQTT.Subscribe() function
-// func (pin DigitalPin) Callback(msg *messanger.Msg) {
-// 	cmd := msg.String()
-// 	switch cmd {
-// 	case "on", "1":
-// 		pin.On()
-
-// 	case "off", "0":
-// 		pin.Off()
-
-// 	case "toggle":
-// 		pin.Toggle()
-// 	}
-// 	return
-// }
-
 func (d *DigitalPin) EventLoop(done chan any, readpub func()) {
 	running := true
 	for running {
 		select {
-		case evt := <-d.EvtQ:
+		case evt, ok := <-d.EvtQ:
+			if !ok {
+				return
+			}
 			evtype := "falling"
 
 			switch evt.Type {
@@ -255,19 +450,76 @@ func (d *DigitalPin) EventLoop(done chan any, readpub func()) {
 	}
 }
 
+// Close releases the line so it can be requested again, outputs are
+// switched back to inputs first. Closing a closed pin does nothing,
+// any other use of the pin returns ErrClosed.
 func (d *DigitalPin) Close() error {
-	close(d.EvtQ)
-	return d.Line.Close()
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	if d.gpio != nil {
+		d.gpio.release(d)
+	}
+
+	var err error
+	if d.Line != nil {
+		if d.isOutput() {
+			d.Line.Reconfigure(gpiocdev.AsInput)
+		}
+		err = d.Line.Close()
+	}
 
+	// the line is released and the handlers see the pin closed, wait
+	// for one still sending, draining EvtQ as the event loop may be
+	// gone
+	if d.EvtQ != nil {
+		go func() {
+			for range d.EvtQ {
+			}
+		}()
+	}
+	d.events.Lock()
+	defer d.events.Unlock()
+	if d.EvtQ != nil {
+		close(d.EvtQ)
+	}
+	return err
+}
+
+// IsClosed reports if the pin has been closed
+func (d *DigitalPin) IsClosed() bool {
+	return d.isClosed()
+}
+
+func (d *DigitalPin) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+func (d *DigitalPin) isOutput() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.output
 }
 
 // MockGPIO fakes the Line interface on computers that don't
-// actually have GPIO pins mostly for mocking tests
+// actually have GPIO pins mostly for mocking tests. The values set
+// are recorded in its Waveform, timed by the default clock of the
+// devices, a SimClock set by the test before the line is requested.
 type MockLine struct {
 	offset                int `json:"offset"`
 	Val                   int `json:"val"`
 	gpiocdev.EventHandler `json:"event-handler"`
 	start                 time.Time
+	wave                  *Waveform
+	closed                bool
+	mu                    sync.Mutex
 }
 
 func GetMockLine(offset int, opts ...gpiocdev.LineReqOption) *MockLine {
@@ -282,46 +534,67 @@ func GetMockLine(offset int, opts ...gpiocdev.LineReqOption) *MockLine {
 
 		case gpiocdev.EventHandler:
 			m.EventHandler = opt.(gpiocdev.EventHandler)
-			msg := messanger.GetMessanger()
-			msg.Subscribe(messanger.GetTopics().Control("mock/"+strconv.Itoa(m.Offset())), m.Callback)
+			if msgr, ok := device.GetMessanger("mqtt"); ok {
+				msgr.Subscribe(device.ControlTopic("mock/"+strconv.Itoa(m.Offset())), m.Callback)
+			}
 
 		default:
 			// slog.Debug("MockLine does not record", "optType", v)
 		}
 	}
 
+	m.wave = NewWaveform(m.Val, device.DefaultClock().Now)
 	return m
 }
 
-func (m MockLine) Close() error {
+// Waveform returns the waveform of the values set
+func (m *MockLine) Waveform() *Waveform {
+	return m.wave
+}
+
+func (m *MockLine) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
 	return nil
 }
 
-func (m MockLine) Offset() int {
+// Closed reports if the line has been released
+func (m *MockLine) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *MockLine) Offset() int {
 	return m.offset
 }
 
 func (m *MockLine) SetValue(val int) error {
+	m.mu.Lock()
 	m.Val = val
+	m.mu.Unlock()
+	m.wave.Record(val)
 	return nil
 }
 
-func (m MockLine) Reconfigure(...gpiocdev.LineConfigOption) error {
+func (m *MockLine) Reconfigure(...gpiocdev.LineConfigOption) error {
 	return nil
 }
 
-func (m MockLine) Value() (int, error) {
+func (m *MockLine) Value() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.Val, nil
 }
 
-var seqno uint32
+var seqno atomic.Uint32
 
 func getSeqno() uint32 {
-	seqno += 1
-	return seqno
+	return seqno.Add(1)
 }
 
-func (m *MockLine) Callback(msg *messanger.Msg) {
+func (m *MockLine) Callback(msg *device.Msg) {
 	str := msg.String()
 	switch str {
 	case "on":
@@ -348,7 +621,13 @@ func (d *DigitalPin) MockHWInput(v int) {
 }
 
 func (m *MockLine) MockHWInput(v int) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
 	m.Val = v
+	m.mu.Unlock()
 
 	t := gpiocdev.LineEventRisingEdge
 	if v == 0 {
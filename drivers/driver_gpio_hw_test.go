@@ -0,0 +1,68 @@
+//go:build hardware
+
+package drivers
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// TestHardwareReleaseLine requests a real line twice, the second
+// request fails with "device or resource busy" if Close leaks the
+// line. Set OTTO_GPIO_PIN (e.g. gpiochip0:17) to a free line.
+func TestHardwareReleaseLine(t *testing.T) {
+	id := os.Getenv("OTTO_GPIO_PIN")
+	if id == "" {
+		t.Skip("OTTO_GPIO_PIN not set, a free line like gpiochip0:17")
+	}
+
+	for i := 0; i < 2; i++ {
+		p, err := NewDigitalPinID("hw-test", id, gpiocdev.AsOutput(0))
+		if err != nil {
+			t.Fatalf("NewDigitalPinID(%s) error = %v", id, err)
+		}
+		if err := p.On(); err != nil {
+			t.Fatalf("request %d: On() error = %v", i, err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("request %d: Close() error = %v", i, err)
+		}
+	}
+}
+
+// TestHardwareClaim requests the real line of OTTO_GPIO_PIN while it is
+// claimed, it fails with ErrPinClaimed and the line is listed in
+// Claims, and again once it is released
+func TestHardwareClaim(t *testing.T) {
+	id := os.Getenv("OTTO_GPIO_PIN")
+	if id == "" {
+		t.Skip("OTTO_GPIO_PIN not set, a free line like gpiochip0:17")
+	}
+
+	p, err := NewDigitalPinID("hw-claim", id, WithOwner("test"), gpiocdev.AsOutput(0))
+	if err != nil {
+		t.Fatalf("NewDigitalPinID(%s) error = %v", id, err)
+	}
+	if _, err := NewDigitalPinID("hw-claim-again", id, gpiocdev.AsInput); !errors.Is(err, ErrPinClaimed) {
+		t.Errorf("claimed NewDigitalPinID(%s) error got (%v) want ErrPinClaimed", id, err)
+	}
+	if !slices.ContainsFunc(Claims(), func(c Claim) bool { return c.Name == "hw-claim" && c.Owner == "test" }) {
+		t.Errorf("Claims() got (%+v) want hw-claim", Claims())
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if slices.ContainsFunc(Claims(), func(c Claim) bool { return c.Name == "hw-claim" }) {
+		t.Errorf("Claims() after Close got (%+v)", Claims())
+	}
+
+	p, err = NewDigitalPinID("hw-claim", id, gpiocdev.AsInput)
+	if err != nil {
+		t.Fatalf("released NewDigitalPinID(%s) error = %v", id, err)
+	}
+	p.Close()
+}
@@ -0,0 +1,237 @@
+package drivers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestDigitalPinClose(t *testing.T) {
+	resetChips(t)
+
+	g := GetGPIO()
+	p := g.Pin("led", 5, gpiocdev.AsOutput(0))
+	line := p.Line.(*MockLine)
+
+	if n := len(g.Pins()); n != 1 {
+		t.Fatalf("Pins() got %d want 1", n)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+	if n := len(g.Pins()); n != 0 {
+		t.Errorf("Pins() after Close got %d want 0", n)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close() error = %v want nil", err)
+	}
+	if err := p.On(); !errors.Is(err, ErrClosed) {
+		t.Errorf("On() after Close error = %v want ErrClosed", err)
+	}
+	if _, err := p.Get(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() after Close error = %v want ErrClosed", err)
+	}
+}
+
+func TestDigitalPinEventQueueClosed(t *testing.T) {
+	resetChips(t)
+
+	p := GetGPIO().Pin("button", 23, gpiocdev.AsInput)
+	p.EvtQ = make(chan gpiocdev.LineEvent)
+
+	done := make(chan any)
+	exited := make(chan bool)
+	go func() {
+		p.EventLoop(done, func() {})
+		exited <- true
+	}()
+
+	p.Close()
+	<-exited
+}
+
+func TestDigitalPinCloseWhileEdges(t *testing.T) {
+	resetChips(t)
+
+	evtQ := make(chan gpiocdev.LineEvent)
+	p := GetGPIO().Pin("button", 24, gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+		evtQ <- evt
+	}))
+	p.EvtQ = evtQ
+	line := p.Line.(*MockLine)
+
+	done := make(chan any)
+	defer close(done)
+	read := make(chan bool, 1)
+	go p.EventLoop(done, func() {
+		select {
+		case read <- true:
+		default:
+		}
+	})
+
+	edges := make(chan bool)
+	go func() {
+		for i := 0; !line.Closed(); i++ {
+			line.MockHWInput(i % 2)
+		}
+		edges <- true
+	}()
+
+	<-read
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	<-edges
+	// an edge after Close is not sent
+	line.EventHandler(gpiocdev.LineEvent{})
+}
+
+func TestPinReplacesClaimedOffset(t *testing.T) {
+	resetChips(t)
+
+	g := GetGPIO()
+	old := g.Pin("relay", 6, gpiocdev.AsOutput(0))
+	oldLine := old.Line.(*MockLine)
+
+	p := g.Pin("relay", 6, gpiocdev.AsOutput(0))
+	if !oldLine.Closed() {
+		t.Error("re-requesting an offset did not release the previous line")
+	}
+	if err := p.On(); err != nil {
+		t.Errorf("On() on the new pin error = %v", err)
+	}
+	if n := len(g.Pins()); n != 1 {
+		t.Errorf("Pins() got %d want 1", n)
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	resetChips(t)
+
+	g0 := GetGPIO()
+	g4, err := GetGPIOChip("gpiochip4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []*MockLine
+	for _, p := range []*DigitalPin{
+		g0.Pin("led", 5, gpiocdev.AsOutput(0)),
+		g0.Pin("relay", 6, gpiocdev.AsOutput(0)),
+		g4.Pin("fan", 12, gpiocdev.AsOutput(0)),
+	} {
+		lines = append(lines, p.Line.(*MockLine))
+	}
+
+	if err := g0.CloseAll(); err != nil {
+		t.Fatalf("CloseAll() error = %v", err)
+	}
+	if !lines[0].Closed() || !lines[1].Closed() || lines[2].Closed() {
+		t.Error("CloseAll() should only release the pins of its own chip")
+	}
+
+	if err := CloseAllGPIO(); err != nil {
+		t.Fatalf("CloseAllGPIO() error = %v", err)
+	}
+	if !lines[2].Closed() {
+		t.Error("CloseAllGPIO() did not release the pins of every chip")
+	}
+	if GetGPIO() == g0 {
+		t.Error("CloseAllGPIO() should forget the cached chips")
+	}
+}
+
+func TestDigitalPinValueTracking(t *testing.T) {
+	resetChips(t)
+
+	p := GetGPIO().Pin("relay", 6, gpiocdev.AsOutput(1))
+	line := p.Line.(*MockLine)
+	requested := p.LastChanged()
+
+	steps := []struct {
+		name string
+		fn   func() error
+		want int
+	}{
+		{name: "initial", fn: func() error { return nil }, want: 1},
+		{name: "off", fn: p.Off, want: 0},
+		{name: "on", fn: p.On, want: 1},
+		{name: "toggle", fn: p.Toggle, want: 0},
+		{name: "toggle again", fn: p.Toggle, want: 1},
+		{name: "set 5", fn: func() error { return p.Set(5) }, want: 1},
+	}
+
+	for _, s := range steps {
+		if err := s.fn(); err != nil {
+			t.Fatalf("%s error = %v", s.name, err)
+		}
+		v, err := p.Value()
+		if err != nil || v != s.want {
+			t.Errorf("%s Value() got (%d, %v) want (%d, nil)", s.name, v, err, s.want)
+		}
+		if line.Val != v {
+			t.Errorf("%s mock line got (%d) tracked (%d)", s.name, line.Val, v)
+		}
+	}
+	if !p.LastChanged().After(requested) {
+		t.Error("LastChanged() did not move when the value changed")
+	}
+
+	// writing the same value is not a change
+	changed := p.LastChanged()
+	p.On()
+	if !p.LastChanged().Equal(changed) {
+		t.Error("LastChanged() moved without a change")
+	}
+
+	// with ReadBack the line is the source of truth
+	line.Val = 0
+	if v, _ := p.Value(); v != 1 {
+		t.Errorf("tracked Value() got (%d) want (1)", v)
+	}
+	p.ReadBack = true
+	if v, _ := p.Value(); v != 0 {
+		t.Errorf("read back Value() got (%d) want (0)", v)
+	}
+}
+
+func TestDigitalPinValueInput(t *testing.T) {
+	resetChips(t)
+
+	p := GetGPIO().Pin("switch", 7, gpiocdev.AsInput)
+	p.MockHWInput(1)
+	if v, err := p.Value(); err != nil || v != 1 {
+		t.Errorf("input Value() got (%d, %v) want (1, nil)", v, err)
+	}
+	p.MockHWInput(0)
+	if v, _ := p.Value(); v != 0 {
+		t.Errorf("input Value() got (%d) want (0)", v)
+	}
+}
+
+// failLine refuses every write
+type failLine struct {
+	MockLine
+}
+
+func (l *failLine) SetValue(int) error {
+	return errors.New("write failed")
+}
+
+func TestDigitalPinSetFailure(t *testing.T) {
+	p := &DigitalPin{name: "bad", output: true}
+	p.Line = &failLine{}
+
+	if err := p.On(); err == nil {
+		t.Fatal("On() expected an error")
+	}
+	if v, _ := p.Value(); v != 0 {
+		t.Errorf("Value() after a failed write got (%d) want (0)", v)
+	}
+}
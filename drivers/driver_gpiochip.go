@@ -0,0 +1,204 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// DefaultChipname is the gpiochip used by GetGPIO() and by any pin
+// that does not select a chip. A Raspberry Pi 5 exposes the header
+// on gpiochip4, everything older uses gpiochip0.
+var DefaultChipname = "gpiochip0"
+
+var (
+	// ErrChipNotFound is returned when a requested gpiochip does not
+	// exist on this system (or has not been defined in mock mode).
+	ErrChipNotFound = errors.New("gpio chip not found")
+
+	chips   = make(map[string]*GPIO)
+	chipsMu sync.Mutex
+
+	// mockChips are the simulated chips and their line counts used
+	// when running in mock mode. They mirror a Raspberry Pi 5.
+	mockChips = map[string]int{
+		"gpiochip0": 54,
+		"gpiochip4": 54,
+	}
+)
+
+// ChipInfo describes one of the gpiochips available on the system
+type ChipInfo struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Lines int    `json:"lines"`
+}
+
+// ChipOption selects the gpiochip a pin is requested from. It can be
+// passed along with the regular gpiocdev options to NewDigitalPin,
+// the GPIO layer strips it before the options reach gpiocdev.
+type ChipOption struct {
+	gpiocdev.LineReqOption
+	Chipname string
+}
+
+// WithChip returns a ChipOption for the given chip name
+func WithChip(name string) ChipOption {
+	return ChipOption{Chipname: name}
+}
+
+// GetGPIOChip returns the GPIO for the named chip, creating and
+// caching it the first time the chip is asked for. An empty name
+// selects the DefaultChipname. An error is returned if the chip
+// does not exist.
+func GetGPIOChip(name string) (*GPIO, error) {
+	if name == "" {
+		name = DefaultChipname
+	}
+
+	chipsMu.Lock()
+	defer chipsMu.Unlock()
+
+	if g, ex := chips[name]; ex {
+		return g, nil
+	}
+	if err := checkChip(name); err != nil {
+		return nil, err
+	}
+	g := newGPIO(name)
+	chips[name] = g
+	return g, nil
+}
+
+// MockChip defines a simulated chip with the given number of lines,
+// it is only consulted when running in mock mode.
+func MockChip(name string, lines int) {
+	chipsMu.Lock()
+	defer chipsMu.Unlock()
+	mockChips[name] = lines
+}
+
+// Chips returns the name, label and line count of every gpiochip
+// available on this system.
+func Chips() ([]ChipInfo, error) {
+	if device.IsMock() {
+		chipsMu.Lock()
+		defer chipsMu.Unlock()
+
+		var infos []ChipInfo
+		for name, lines := range mockChips {
+			infos = append(infos, ChipInfo{Name: name, Label: "mock", Lines: lines})
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return chipLess(infos[i].Name, infos[j].Name)
+		})
+		return infos, nil
+	}
+
+	var infos []ChipInfo
+	for _, name := range gpiocdev.Chips() {
+		c, err := gpiocdev.NewChip(name)
+		if err != nil {
+			return infos, fmt.Errorf("opening %s: %w", name, err)
+		}
+		infos = append(infos, ChipInfo{Name: name, Label: c.Label, Lines: c.Lines()})
+		c.Close()
+	}
+	return infos, nil
+}
+
+// ParsePinID splits a pin identifier of the form "chipname:offset"
+// into its chip and offset. A bare offset selects the DefaultChipname.
+func ParsePinID(id string) (chip string, offset int, err error) {
+	chip = DefaultChipname
+	off := id
+	if i := strings.LastIndex(id, ":"); i >= 0 {
+		chip, off = id[:i], id[i+1:]
+		if chip == "" {
+			return "", 0, fmt.Errorf("pin id %q is missing the chip name", id)
+		}
+	}
+	offset, err = strconv.Atoi(off)
+	if err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("pin id %q has an invalid offset", id)
+	}
+	return chip, offset, nil
+}
+
+// NewDigitalPinID creates a pin from an identifier like "gpiochip4:17",
+// "17", a line name like "GPIO17" or a header label like "PIN11" (see
+// ResolvePinID). An error is returned if the chip or the line does
+// not exist, or if the line is claimed by another pin.
+func NewDigitalPinID(name string, id string, opts ...gpiocdev.LineReqOption) (*DigitalPin, error) {
+	chip, offset, err := ResolvePinID(id)
+	if err != nil {
+		return nil, err
+	}
+	g, err := GetGPIOChip(chip)
+	if err != nil {
+		return nil, err
+	}
+	p, err := g.Request(name, offset, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// chipFromOpts pulls a ChipOption out of the line options returning
+// the selected chip (empty if none) and the remaining options.
+func chipFromOpts(opts []gpiocdev.LineReqOption) (string, []gpiocdev.LineReqOption) {
+	chip := ""
+	var rest []gpiocdev.LineReqOption
+	for _, o := range opts {
+		if c, ok := o.(ChipOption); ok {
+			chip = c.Chipname
+			continue
+		}
+		rest = append(rest, o)
+	}
+	return chip, rest
+}
+
+// checkChip returns an error if the named chip is not present, the
+// caller must hold chipsMu
+func checkChip(name string) error {
+	if _, ex := registeredGPIOChip(name); ex {
+		return nil
+	}
+	if device.IsMock() {
+		if _, ex := mockChips[name]; !ex {
+			return fmt.Errorf("%w: %s (mock chips: %s)", ErrChipNotFound, name, strings.Join(mockChipNames(), ", "))
+		}
+		return nil
+	}
+
+	c, err := OpenGPIOChip(name)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func mockChipNames() []string {
+	names := make([]string, 0, len(mockChips))
+	for name := range mockChips {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return chipLess(names[i], names[j]) })
+	return names
+}
+
+// chipLess sorts chip names numerically so gpiochip10 follows gpiochip4
+func chipLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
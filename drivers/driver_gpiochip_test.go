@@ -0,0 +1,150 @@
+package drivers
+
+import (
+	"errors"
+	"testing"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// resetChips forgets all cached chips so each test starts clean
+func resetChips(t *testing.T) {
+	t.Helper()
+	device.Mock(true)
+	chipsMu.Lock()
+	chips = make(map[string]*GPIO)
+	chipsMu.Unlock()
+	t.Cleanup(func() {
+		device.Mock(false)
+		chipsMu.Lock()
+		chips = make(map[string]*GPIO)
+		chipsMu.Unlock()
+	})
+}
+
+func TestGetGPIOChip(t *testing.T) {
+	resetChips(t)
+
+	g0, err := GetGPIOChip("gpiochip0")
+	if err != nil {
+		t.Fatalf("GetGPIOChip(gpiochip0) error = %v", err)
+	}
+	g4, err := GetGPIOChip("gpiochip4")
+	if err != nil {
+		t.Fatalf("GetGPIOChip(gpiochip4) error = %v", err)
+	}
+	if g0 == g4 {
+		t.Error("expected different GPIO for different chips")
+	}
+
+	again, _ := GetGPIOChip("gpiochip4")
+	if again != g4 {
+		t.Error("expected GetGPIOChip to return the cached chip")
+	}
+	if GetGPIO() != g0 {
+		t.Error("expected GetGPIO to return the default chip")
+	}
+	if def, _ := GetGPIOChip(""); def != g0 {
+		t.Error("expected empty chip name to select the default chip")
+	}
+
+	_, err = GetGPIOChip("gpiochip9")
+	if !errors.Is(err, ErrChipNotFound) {
+		t.Errorf("GetGPIOChip(gpiochip9) error = %v, want ErrChipNotFound", err)
+	}
+}
+
+func TestChips(t *testing.T) {
+	resetChips(t)
+	MockChip("gpiochip10", 8)
+	t.Cleanup(func() { delete(mockChips, "gpiochip10") })
+
+	infos, err := Chips()
+	if err != nil {
+		t.Fatalf("Chips() error = %v", err)
+	}
+
+	want := []ChipInfo{
+		{Name: "gpiochip0", Label: "mock", Lines: 54},
+		{Name: "gpiochip4", Label: "mock", Lines: 54},
+		{Name: "gpiochip10", Label: "mock", Lines: 8},
+	}
+	if len(infos) != len(want) {
+		t.Fatalf("Chips() got %d chips want %d", len(infos), len(want))
+	}
+	for i := range want {
+		if infos[i] != want[i] {
+			t.Errorf("Chips()[%d] got %+v want %+v", i, infos[i], want[i])
+		}
+	}
+}
+
+func TestParsePinID(t *testing.T) {
+	tests := []struct {
+		id      string
+		chip    string
+		offset  int
+		wantErr bool
+	}{
+		{id: "17", chip: "gpiochip0", offset: 17},
+		{id: "gpiochip4:17", chip: "gpiochip4", offset: 17},
+		{id: ":17", wantErr: true},
+		{id: "gpiochip4:", wantErr: true},
+		{id: "gpiochip4:-1", wantErr: true},
+		{id: "led", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			chip, offset, err := ParsePinID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePinID(%q) error = %v, wantErr %t", tt.id, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if chip != tt.chip || offset != tt.offset {
+				t.Errorf("ParsePinID(%q) got (%s, %d) want (%s, %d)", tt.id, chip, offset, tt.chip, tt.offset)
+			}
+		})
+	}
+}
+
+func TestPinsOnMultipleChips(t *testing.T) {
+	resetChips(t)
+
+	p0 := NewDigitalPin("led", 6, gpiocdev.AsOutput(0))
+	if p0.Chipname() != "gpiochip0" {
+		t.Errorf("default pin chip got (%s) want (gpiochip0)", p0.Chipname())
+	}
+
+	p4 := NewDigitalPin("relay", 6, WithChip("gpiochip4"), gpiocdev.AsOutput(0))
+	if p4.Chipname() != "gpiochip4" {
+		t.Errorf("WithChip pin chip got (%s) want (gpiochip4)", p4.Chipname())
+	}
+
+	if err := p4.On(); err != nil {
+		t.Fatalf("On() error = %v", err)
+	}
+	if v, _ := p0.Get(); v != 0 {
+		t.Errorf("pin on gpiochip0 changed with gpiochip4, got (%d)", v)
+	}
+
+	p, err := NewDigitalPinID("fan", "gpiochip4:12", gpiocdev.AsOutput(1))
+	if err != nil {
+		t.Fatalf("NewDigitalPinID() error = %v", err)
+	}
+	if v, _ := p.Get(); v != 1 || p.Chipname() != "gpiochip4" {
+		t.Errorf("NewDigitalPinID got chip (%s) val (%d)", p.Chipname(), v)
+	}
+
+	if _, err := NewDigitalPinID("fan", "gpiochip9:12"); !errors.Is(err, ErrChipNotFound) {
+		t.Errorf("NewDigitalPinID(gpiochip9:12) error = %v, want ErrChipNotFound", err)
+	}
+
+	bad := NewDigitalPin("bad", 60, gpiocdev.AsOutput(0))
+	if err := bad.On(); err == nil {
+		t.Error("expected an error using an offset beyond the chip's lines")
+	}
+}
@@ -0,0 +1,353 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// BitBangI2CMaxFreq caps the clock of a bit-banged bus, it is
+	// the I2C standard mode speed. Most boards manage 50kHz or so
+	// before the clock gets ragged.
+	BitBangI2CMaxFreq = 100000
+
+	// DefaultBitBangI2CFreq is slow enough to be reliable on a busy
+	// Pi Zero and fast enough for polled sensors
+	DefaultBitBangI2CFreq = 20000
+
+	// DefaultStretchTimeout is how long a device may hold the clock
+	// low before a transfer fails with ErrTimeout
+	DefaultStretchTimeout = 25 * time.Millisecond
+)
+
+var (
+	errAddrNak = errors.New("address not acknowledged")
+	errDataNak = errors.New("data not acknowledged")
+)
+
+// BitBangI2C is an I2C master driven in software on two GPIO lines,
+// for boards whose hardware I2C pins are already taken. Both lines
+// are requested open drain so the pull up resistors on the bus, and
+// not the pin, drive them high. Devices on the bus need the usual
+// pull ups, a bare GPIO is not enough.
+//
+// The bus registers itself under Name with RegisterI2CBus so sensor
+// packages open their devices on it by passing Name as the bus, the
+// register helpers and the bus lock of I2CDevice work unchanged.
+// Devices may stretch the clock up to StretchTimeout.
+type BitBangI2C struct {
+	Name           string
+	StretchTimeout time.Duration
+
+	sda  Line
+	scl  Line
+	half time.Duration
+	mu   sync.Mutex
+}
+
+// NewBitBangI2C creates a bus named name on the lines sda and scl,
+// any pin identifier ResolvePinID understands ("GPIO23", "PIN16",
+// "gpiochip0:23") may be used. The clock runs at hz, 0 selects the
+// DefaultBitBangI2CFreq.
+func NewBitBangI2C(name, sda, scl string, hz int) (*BitBangI2C, error) {
+	b := &BitBangI2C{
+		Name:           name,
+		StretchTimeout: DefaultStretchTimeout,
+	}
+	if err := b.SetFrequency(hz); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if b.sda, err = requestOpenDrain(sda); err != nil {
+		return nil, fmt.Errorf("i2c %s sda: %w", name, err)
+	}
+	if b.scl, err = requestOpenDrain(scl); err != nil {
+		b.sda.Close()
+		return nil, fmt.Errorf("i2c %s scl: %w", name, err)
+	}
+	RegisterI2CBus(name, b.Device)
+	return b, nil
+}
+
+func requestOpenDrain(id string) (Line, error) {
+	chip, offset, err := ResolvePinID(id)
+	if err != nil {
+		return nil, err
+	}
+	opts := []gpiocdev.LineReqOption{gpiocdev.AsOutput(1), gpiocdev.AsOpenDrain}
+	if _, registered := registeredGPIOChip(chip); device.IsMock() && !registered {
+		return GetMockLine(offset, opts...), nil
+	}
+	c, err := OpenGPIOChip(chip)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestLine(offset, opts...)
+}
+
+// SetFrequency sets the clock to hz, 0 selects the default
+func (b *BitBangI2C) SetFrequency(hz int) error {
+	if hz == 0 {
+		hz = DefaultBitBangI2CFreq
+	}
+	if hz < 0 || hz > BitBangI2CMaxFreq {
+		return fmt.Errorf("i2c %s: clock %dHz outside 0 - %dHz", b.Name, hz, BitBangI2CMaxFreq)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.half = time.Second / time.Duration(2*hz)
+	return nil
+}
+
+// Device returns the device at addr on the bus
+func (b *BitBangI2C) Device(addr int) (I2CBus, error) {
+	if addr < 0 || addr > 0x7F {
+		return nil, fmt.Errorf("i2c %s: invalid address %#02x", b.Name, addr)
+	}
+	return &bitBangDevice{bus: b, addr: addr}, nil
+}
+
+// Close removes the bus from the registry and releases both lines
+func (b *BitBangI2C) Close() error {
+	RegisterI2CBus(b.Name, nil)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return errors.Join(b.sda.Close(), b.scl.Close())
+}
+
+// transfer writes w to and then reads r from the device at addr in
+// a single transaction, with a repeated start between the two.
+func (b *BitBangI2C) transfer(addr int, w, r []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.start(); err != nil {
+		return err
+	}
+	err := b.transferBytes(addr, w, r)
+	if serr := b.stop(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (b *BitBangI2C) transferBytes(addr int, w, r []byte) error {
+	if len(w) > 0 || len(r) == 0 {
+		if err := b.writeAddr(addr, 0); err != nil {
+			return err
+		}
+		for _, v := range w {
+			ack, err := b.writeByte(v)
+			if err != nil {
+				return err
+			}
+			if !ack {
+				return &ErrNak{Bus: b.Name, Addr: addr, Err: errDataNak}
+			}
+		}
+		if len(r) == 0 {
+			return nil
+		}
+		if err := b.repeatedStart(); err != nil {
+			return err
+		}
+	}
+
+	if err := b.writeAddr(addr, 1); err != nil {
+		return err
+	}
+	for i := range r {
+		v, err := b.readByte(i < len(r)-1)
+		if err != nil {
+			return err
+		}
+		r[i] = v
+	}
+	return nil
+}
+
+func (b *BitBangI2C) writeAddr(addr int, rw byte) error {
+	ack, err := b.writeByte(byte(addr)<<1 | rw)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		return &ErrNak{Bus: b.Name, Addr: addr, Err: errAddrNak}
+	}
+	return nil
+}
+
+// start pulls SDA low while SCL is high, both must be idle
+func (b *BitBangI2C) start() error {
+	sda, err := b.sda.Value()
+	if err != nil {
+		return err
+	}
+	scl, err := b.scl.Value()
+	if err != nil {
+		return err
+	}
+	if sda == 0 || scl == 0 {
+		return fmt.Errorf("%w: %s held low", ErrBusBusy, b.Name)
+	}
+	if err := b.sda.SetValue(0); err != nil {
+		return err
+	}
+	b.wait()
+	return b.scl.SetValue(0)
+}
+
+func (b *BitBangI2C) repeatedStart() error {
+	if err := b.sda.SetValue(1); err != nil {
+		return err
+	}
+	b.wait()
+	if err := b.releaseSCL(); err != nil {
+		return err
+	}
+	b.wait()
+	if err := b.sda.SetValue(0); err != nil {
+		return err
+	}
+	b.wait()
+	return b.scl.SetValue(0)
+}
+
+// stop releases SDA while SCL is high
+func (b *BitBangI2C) stop() error {
+	if err := b.sda.SetValue(0); err != nil {
+		return err
+	}
+	b.wait()
+	if err := b.releaseSCL(); err != nil {
+		return err
+	}
+	b.wait()
+	err := b.sda.SetValue(1)
+	b.wait()
+	return err
+}
+
+func (b *BitBangI2C) writeByte(v byte) (ack bool, err error) {
+	for i := 7; i >= 0; i-- {
+		if err := b.writeBit(int(v>>i) & 1); err != nil {
+			return false, err
+		}
+	}
+	bit, err := b.readBit()
+	return bit == 0, err
+}
+
+func (b *BitBangI2C) readByte(ack bool) (byte, error) {
+	var v byte
+	for i := 0; i < 8; i++ {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | byte(bit)
+	}
+	nak := 1
+	if ack {
+		nak = 0
+	}
+	return v, b.writeBit(nak)
+}
+
+// writeBit puts bit on SDA and clocks it. A released SDA that reads
+// low means another master is driving the bus.
+func (b *BitBangI2C) writeBit(bit int) error {
+	if err := b.sda.SetValue(bit); err != nil {
+		return err
+	}
+	b.wait()
+	if err := b.releaseSCL(); err != nil {
+		return err
+	}
+	if bit == 1 {
+		if v, err := b.sda.Value(); err != nil {
+			return err
+		} else if v == 0 {
+			return fmt.Errorf("%w: %s lost arbitration", ErrBusBusy, b.Name)
+		}
+	}
+	b.wait()
+	return b.scl.SetValue(0)
+}
+
+func (b *BitBangI2C) readBit() (int, error) {
+	if err := b.sda.SetValue(1); err != nil {
+		return 0, err
+	}
+	b.wait()
+	if err := b.releaseSCL(); err != nil {
+		return 0, err
+	}
+	bit, err := b.sda.Value()
+	if err != nil {
+		return 0, err
+	}
+	b.wait()
+	return bit, b.scl.SetValue(0)
+}
+
+// releaseSCL lets SCL go high and waits for any device stretching
+// the clock to let go of it
+func (b *BitBangI2C) releaseSCL() error {
+	if err := b.scl.SetValue(1); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(b.StretchTimeout)
+	for {
+		v, err := b.scl.Value()
+		if err != nil {
+			return err
+		}
+		if v == 1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s clock held low for %v", ErrTimeout, b.Name, b.StretchTimeout)
+		}
+	}
+}
+
+// wait spins for half a clock period, the scheduler can not sleep
+// for the tens of microseconds a bit takes
+func (b *BitBangI2C) wait() {
+	for end := time.Now().Add(b.half); time.Now().Before(end); {
+	}
+}
+
+// bitBangDevice is a device at a fixed address on a BitBangI2C
+type bitBangDevice struct {
+	bus  *BitBangI2C
+	addr int
+}
+
+func (d *bitBangDevice) Read(buf []byte) error {
+	return d.bus.transfer(d.addr, nil, buf)
+}
+
+func (d *bitBangDevice) Write(buf []byte) error {
+	return d.bus.transfer(d.addr, buf, nil)
+}
+
+func (d *bitBangDevice) ReadReg(reg byte, buf []byte) error {
+	return d.bus.transfer(d.addr, []byte{reg}, buf)
+}
+
+func (d *bitBangDevice) WriteReg(reg byte, buf []byte) error {
+	return d.bus.transfer(d.addr, append([]byte{reg}, buf...), nil)
+}
+
+// Close does nothing, the lines belong to the bus
+func (d *bitBangDevice) Close() error {
+	return nil
+}
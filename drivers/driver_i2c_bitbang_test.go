@@ -0,0 +1,389 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// simI2C is a gpiochip with two open drain lines, SDA at offset 0
+// and SCL at offset 1, wired to a simulated I2C slave with a 256
+// byte register map. The bus level is the wired AND of the master
+// and the slave. Every start, stop, byte and ack it sees is logged.
+type simI2C struct {
+	addr byte
+	regs [256]byte
+	log  []string
+
+	// stretch holds SCL low for that many reads of the clock
+	// after each release by the master, -1 holds it forever
+	stretch int
+
+	master [2]int // 1 released, 0 pulled low
+	slave  [2]int
+	level  [2]int
+	held   int
+
+	mode  int // simIdle ...
+	bit   int // clock of the current byte, 8 is the ack
+	shift byte
+	rw    byte
+	ptr   byte
+	first bool
+	ack   bool
+	mu    sync.Mutex
+}
+
+const (
+	simIdle = iota
+	simAddr
+	simWrite
+	simRead
+	simIgnore
+)
+
+const (
+	simSDA = 0
+	simSCL = 1
+)
+
+func newSimI2C(addr byte) *simI2C {
+	return &simI2C{
+		addr:   addr,
+		master: [2]int{1, 1},
+		slave:  [2]int{1, 1},
+		level:  [2]int{1, 1},
+	}
+}
+
+func (s *simI2C) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (Line, error) {
+	if offset > 1 {
+		return nil, fmt.Errorf("sim i2c has no line %d", offset)
+	}
+	return &simLine{sim: s, offset: offset}, nil
+}
+
+func (s *simI2C) Close() error {
+	return nil
+}
+
+func (s *simI2C) Log() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Join(s.log, " ")
+}
+
+// set is the master driving a line
+func (s *simI2C) set(line, v int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master[line] = v
+	if line == simSCL && v == 1 && s.stretch != 0 {
+		s.held = s.stretch
+		s.slave[simSCL] = 0
+	}
+	s.update()
+}
+
+// value is the master reading a line
+func (s *simI2C) value(line int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if line == simSCL && s.held > 0 {
+		if s.held--; s.held == 0 {
+			s.slave[simSCL] = 1
+			s.update()
+		}
+	}
+	return s.level[line]
+}
+
+// update recomputes the bus levels and runs the slave on any edge
+func (s *simI2C) update() {
+	prev := s.level
+	for i := range s.level {
+		s.level[i] = s.master[i] & s.slave[i]
+	}
+	sda, scl := s.level[simSDA], s.level[simSCL]
+
+	switch {
+	case prev[simSCL] == 1 && scl == 1 && prev[simSDA] == 1 && sda == 0:
+		if s.mode == simIdle {
+			s.log = append(s.log, "S")
+		} else {
+			s.log = append(s.log, "Sr")
+		}
+		// the master pulling SCL low ends the start, not a bit
+		s.mode, s.bit, s.shift = simAddr, -1, 0
+
+	case prev[simSCL] == 1 && scl == 1 && prev[simSDA] == 0 && sda == 1:
+		s.log = append(s.log, "P")
+		s.mode = simIdle
+
+	case prev[simSCL] == 0 && scl == 1:
+		s.rise(sda)
+
+	case prev[simSCL] == 1 && scl == 0:
+		s.fall()
+		s.level[simSDA] = s.master[simSDA] & s.slave[simSDA]
+	}
+}
+
+func (s *simI2C) rise(sda int) {
+	switch s.mode {
+	case simAddr, simWrite, simIgnore:
+		if s.bit < 8 {
+			s.shift = s.shift<<1 | byte(sda)
+		}
+	case simRead:
+		if s.bit == 8 {
+			s.ack = sda == 0
+			if s.ack {
+				s.log = append(s.log, "A")
+			} else {
+				s.log = append(s.log, "N")
+			}
+		}
+	}
+}
+
+func (s *simI2C) fall() {
+	switch s.mode {
+	case simAddr, simWrite, simIgnore:
+		switch {
+		case s.bit < 7:
+			s.bit++
+
+		case s.bit == 7:
+			s.log = append(s.log, fmt.Sprintf("%02x", s.shift))
+			ack := s.received()
+			if ack {
+				s.slave[simSDA] = 0
+				s.log = append(s.log, "A")
+			} else {
+				s.log = append(s.log, "N")
+			}
+			s.bit = 8
+
+		default:
+			// the ack clock is over, an acked address starts the
+			// transfer
+			s.slave[simSDA] = 1
+			s.bit, s.shift = 0, 0
+			if s.mode == simAddr && s.rw == 1 {
+				s.mode = simRead
+				s.drive()
+			} else if s.mode == simAddr {
+				s.mode, s.first = simWrite, true
+			}
+		}
+
+	case simRead:
+		switch {
+		case s.bit < 7:
+			s.bit++
+			s.drive()
+		case s.bit == 7:
+			s.slave[simSDA] = 1
+			s.bit = 8
+		default:
+			s.bit = 0
+			if !s.ack {
+				s.mode = simIgnore
+				return
+			}
+			s.ptr++
+			s.drive()
+		}
+	}
+}
+
+// received handles a complete byte and reports if it is acked
+func (s *simI2C) received() bool {
+	switch s.mode {
+	case simAddr:
+		if s.shift>>1 != s.addr {
+			s.mode = simIgnore
+			return false
+		}
+		s.rw = s.shift & 1
+		return true
+	case simWrite:
+		if s.first {
+			s.ptr, s.first = s.shift, false
+		} else {
+			s.regs[s.ptr] = s.shift
+			s.ptr++
+		}
+		return true
+	}
+	return false
+}
+
+// drive puts the current bit of the register at ptr on SDA
+func (s *simI2C) drive() {
+	s.slave[simSDA] = int(s.regs[s.ptr]>>(7-s.bit)) & 1
+}
+
+type simLine struct {
+	sim    *simI2C
+	offset int
+}
+
+func (l *simLine) Close() error                                   { return nil }
+func (l *simLine) Offset() int                                    { return l.offset }
+func (l *simLine) Reconfigure(...gpiocdev.LineConfigOption) error { return nil }
+func (l *simLine) SetValue(v int) error                           { l.sim.set(l.offset, v); return nil }
+func (l *simLine) Value() (int, error)                            { return l.sim.value(l.offset), nil }
+
+func newSimBus(t *testing.T, name string, addr byte) (*BitBangI2C, *simI2C) {
+	t.Helper()
+	sim := newSimI2C(addr)
+	RegisterGPIOChip(name, sim)
+	t.Cleanup(func() { RegisterGPIOChip(name, nil) })
+
+	b, err := NewBitBangI2C(name, name+":0", name+":1", BitBangI2CMaxFreq)
+	if err != nil {
+		t.Fatalf("NewBitBangI2C() error = %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b, sim
+}
+
+func TestBitBangI2CSequence(t *testing.T) {
+	_, sim := newSimBus(t, "sim-seq", 0x50)
+	sim.regs[0x10], sim.regs[0x11] = 0xC3, 0x5A
+
+	dev, err := OpenI2C("sim-seq", 0x50)
+	if err != nil {
+		t.Fatalf("OpenI2C() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+		log  string
+	}{
+		{
+			name: "write reg",
+			op:   func() error { return dev.WriteReg(0x20, []byte{0xAB, 0x01}) },
+			log:  "S a0 A 20 A ab A 01 A P",
+		},
+		{
+			name: "read reg",
+			op: func() error {
+				buf := make([]byte, 2)
+				if err := dev.ReadReg(0x10, buf); err != nil {
+					return err
+				}
+				if buf[0] != 0xC3 || buf[1] != 0x5A {
+					return fmt.Errorf("read got (% x) want (c3 5a)", buf)
+				}
+				return nil
+			},
+			log: "S a0 A 10 A Sr a1 A A N P",
+		},
+		{
+			name: "raw read",
+			op: func() error {
+				buf := make([]byte, 1)
+				if err := dev.Read(buf); err != nil {
+					return err
+				}
+				if buf[0] != 0x5A {
+					return fmt.Errorf("read got (%#x) want (0x5a)", buf[0])
+				}
+				return nil
+			},
+			log: "S a1 A N P",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim.log = nil
+			if err := tt.op(); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got := sim.Log(); got != tt.log {
+				t.Errorf("bus got (%s) want (%s)", got, tt.log)
+			}
+		})
+	}
+	if sim.regs[0x20] != 0xAB || sim.regs[0x21] != 0x01 {
+		t.Errorf("slave registers got (% x) want (ab 01)", sim.regs[0x20:0x22])
+	}
+}
+
+func TestBitBangI2CNak(t *testing.T) {
+	_, sim := newSimBus(t, "sim-nak", 0x50)
+
+	dev, err := OpenI2C("sim-nak", 0x51)
+	if err != nil {
+		t.Fatalf("OpenI2C() error = %v", err)
+	}
+	err = dev.WriteReg(0x00, []byte{1})
+	if !errors.Is(err, &ErrNak{Addr: 0x51}) || !IsRetryable(err) {
+		t.Errorf("WriteReg() error = %v want a NAK from 0x51", err)
+	}
+	if got := sim.Log(); got != "S a2 N P" {
+		t.Errorf("bus got (%s) want (S a2 N P)", got)
+	}
+}
+
+func TestBitBangI2CStretch(t *testing.T) {
+	b, sim := newSimBus(t, "sim-stretch", 0x50)
+	sim.regs[0x00] = 0x42
+	dev, _ := b.Device(0x50)
+
+	sim.stretch = 5
+	buf := make([]byte, 1)
+	if err := dev.ReadReg(0x00, buf); err != nil || buf[0] != 0x42 {
+		t.Errorf("stretched ReadReg() got (%#x, %v) want (0x42, nil)", buf[0], err)
+	}
+
+	sim.stretch = -1
+	b.StretchTimeout = time.Millisecond
+	if err := dev.ReadReg(0x00, buf); !errors.Is(err, ErrTimeout) {
+		t.Errorf("held clock ReadReg() error = %v want %v", err, ErrTimeout)
+	}
+}
+
+func TestBitBangI2CDevice(t *testing.T) {
+	_, sim := newSimBus(t, "sim-dev", 0x76)
+	sim.regs[0xD0] = 0x60
+
+	// the register helpers and the bus lock work on the soft bus
+	d, err := NewI2CDevice("sim-dev", 0x76)
+	if err != nil {
+		t.Fatalf("NewI2CDevice() error = %v", err)
+	}
+	if id, err := d.ReadReg8(0xD0); err != nil || id != 0x60 {
+		t.Errorf("ReadReg8() got (%#x, %v) want (0x60, nil)", id, err)
+	}
+	if err := d.UpdateBits(0xF4, 0x03, 0x01); err != nil {
+		t.Fatalf("UpdateBits() error = %v", err)
+	}
+	if sim.regs[0xF4] != 0x01 {
+		t.Errorf("UpdateBits() register got (%#x) want (0x01)", sim.regs[0xF4])
+	}
+}
+
+func TestBitBangI2CErrors(t *testing.T) {
+	if _, err := NewBitBangI2C("bad", "GPIO2", "GPIO3", BitBangI2CMaxFreq+1); err == nil {
+		t.Error("NewBitBangI2C() expected an error for a fast clock")
+	}
+	b, _ := newSimBus(t, "sim-err", 0x50)
+	if _, err := b.Device(0x80); err == nil {
+		t.Error("Device(0x80) expected an error")
+	}
+	b.Close()
+	if _, err := OpenI2C("sim-err", 0x50); err == nil {
+		t.Error("OpenI2C() after Close() expected an error")
+	}
+}
@@ -0,0 +1,114 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+)
+
+var (
+	// ErrBusBusy is returned when the bus was held by another master
+	// or arbitration was lost, the transfer can be retried.
+	ErrBusBusy = errors.New("i2c bus busy")
+
+	// ErrTimeout is returned when a transfer did not complete, most
+	// often a device stretching the clock for too long.
+	ErrTimeout = errors.New("i2c timeout")
+
+	// ErrPermission is returned when the bus device can not be
+	// opened or used by this process. Add the user to the i2c group.
+	ErrPermission = errors.New("i2c permission denied")
+)
+
+// the fault command of the mock devices fails their reads like the bus
+func init() {
+	device.RegisterFaultError("nak", &ErrNak{Err: device.ErrInjected})
+	device.RegisterFaultError("busy", ErrBusBusy)
+	device.RegisterFaultError("timeout", ErrTimeout)
+	device.RegisterFaultError("permission", ErrPermission)
+}
+
+// ErrNak is returned when the device at Addr did not acknowledge a
+// transfer. The device may be absent, at another address or busy,
+// many sensors NAK while they are converting.
+type ErrNak struct {
+	Bus  string
+	Addr int
+	Err  error
+}
+
+func (e *ErrNak) Error() string {
+	return fmt.Sprintf("no ack from %#02x: %v", e.Addr, e.Err)
+}
+
+func (e *ErrNak) Unwrap() error {
+	return e.Err
+}
+
+// Is matches a target ErrNak with the same address, a target with
+// a zero Addr matches a NAK from any address.
+func (e *ErrNak) Is(target error) bool {
+	t, ok := target.(*ErrNak)
+	return ok && (t.Addr == 0 || t.Addr == e.Addr)
+}
+
+// ClassifyI2CError turns the errno returned by the kernel i2c-dev
+// driver into ErrNak, ErrBusBusy, ErrTimeout or ErrPermission, see
+// Documentation/i2c/fault-codes.rst in the kernel tree. The errno
+// stays wrapped, errors that are already classified or are not an
+// errno are returned unchanged.
+func ClassifyI2CError(bus string, addr int, err error) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+
+	switch errno {
+	case syscall.ENXIO, syscall.EREMOTEIO:
+		return &ErrNak{Bus: bus, Addr: addr, Err: err}
+	case syscall.EAGAIN, syscall.EBUSY:
+		return fmt.Errorf("%w: %w", ErrBusBusy, err)
+	case syscall.ETIMEDOUT:
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case syscall.EACCES, syscall.EPERM:
+		return fmt.Errorf("%w: %w", ErrPermission, err)
+	}
+	return err
+}
+
+func isClassified(err error) bool {
+	return errors.Is(err, &ErrNak{}) || errors.Is(err, ErrBusBusy) ||
+		errors.Is(err, ErrTimeout) || errors.Is(err, ErrPermission)
+}
+
+// IsRetryable reports if err is a transient I2C failure that may
+// succeed when the transfer is repeated: a NAK, a busy bus or a
+// timeout. Permission and unclassified errors are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, &ErrNak{}) || errors.Is(err, ErrBusBusy) || errors.Is(err, ErrTimeout)
+}
+
+// RetryI2C calls fn up to attempts times while it fails with a
+// retryable error, sleeping delay between the attempts. The last
+// error is returned.
+func RetryI2C(attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		if err = fn(); !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
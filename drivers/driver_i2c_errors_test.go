@@ -0,0 +1,127 @@
+package drivers_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func TestClassifyI2CError(t *testing.T) {
+	tests := []struct {
+		err       error
+		class     error
+		retryable bool
+	}{
+		{err: syscall.ENXIO, class: &drivers.ErrNak{}, retryable: true},
+		{err: syscall.EREMOTEIO, class: &drivers.ErrNak{Addr: 0x48}, retryable: true},
+		{err: syscall.EAGAIN, class: drivers.ErrBusBusy, retryable: true},
+		{err: syscall.EBUSY, class: drivers.ErrBusBusy, retryable: true},
+		{err: syscall.ETIMEDOUT, class: drivers.ErrTimeout, retryable: true},
+		{err: syscall.EACCES, class: drivers.ErrPermission},
+		{err: &os.PathError{Op: "open", Path: "/dev/i2c-1", Err: syscall.EACCES}, class: drivers.ErrPermission},
+		{err: syscall.EPERM, class: drivers.ErrPermission},
+		{err: syscall.EIO},
+		{err: errors.New("bad crc")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			err := drivers.ClassifyI2CError("/dev/i2c-1", 0x48, tt.err)
+			if tt.class != nil && !errors.Is(err, tt.class) {
+				t.Errorf("got (%v) want (%v)", err, tt.class)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got (%v) does not wrap (%v)", err, tt.err)
+			}
+			if drivers.IsRetryable(err) != tt.retryable {
+				t.Errorf("IsRetryable(%v) got (%t) want (%t)", err, !tt.retryable, tt.retryable)
+			}
+
+			// classifying twice changes nothing
+			if again := drivers.ClassifyI2CError("/dev/i2c-1", 0x48, err); again != err {
+				t.Errorf("reclassified got (%v) want (%v)", again, err)
+			}
+		})
+	}
+
+	nak := drivers.ClassifyI2CError("/dev/i2c-1", 0x48, syscall.EREMOTEIO)
+	if errors.Is(nak, &drivers.ErrNak{Addr: 0x49}) {
+		t.Errorf("NAK from 0x48 matched 0x49")
+	}
+	if drivers.ClassifyI2CError("/dev/i2c-1", 0x48, nil) != nil {
+		t.Error("nil error classified")
+	}
+}
+
+func TestI2CDeviceErrors(t *testing.T) {
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+	d, err := drivers.NewI2CDevice("/dev/i2c-1", 0x40)
+	if err != nil {
+		t.Fatalf("NewI2CDevice() error = %v", err)
+	}
+
+	tests := []struct {
+		errno error
+		class error
+	}{
+		{driverstest.ErrnoNak, &drivers.ErrNak{Addr: 0x40}},
+		{driverstest.ErrnoBusBusy, drivers.ErrBusBusy},
+		{driverstest.ErrnoTimeout, drivers.ErrTimeout},
+		{driverstest.ErrnoPermission, drivers.ErrPermission},
+	}
+	for _, tt := range tests {
+		fake.FailNext(tt.errno)
+		if _, err := d.ReadReg8(0x00); !errors.Is(err, tt.class) {
+			t.Errorf("ReadReg8() error = %v want %v", err, tt.class)
+		}
+		fake.FailNext(tt.errno)
+		err := d.Tx(func(bus drivers.I2CBus) error { return bus.WriteReg(0x01, []byte{1}) })
+		if !errors.Is(err, tt.class) {
+			t.Errorf("Tx() error = %v want %v", err, tt.class)
+		}
+	}
+
+	var nak *drivers.ErrNak
+	fake.FailNext(driverstest.ErrnoNak)
+	if err := d.WriteReg8(0x02, 1); !errors.As(err, &nak) || nak.Addr != 0x40 || nak.Bus != "/dev/i2c-1" {
+		t.Errorf("WriteReg8() error = %v want a NAK from 0x40", err)
+	}
+}
+
+func TestRetryI2C(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{name: "ok", errs: []error{nil}, calls: 1},
+		{name: "nak then ok", errs: []error{syscall.EREMOTEIO, nil}, calls: 2},
+		{name: "busy twice", errs: []error{syscall.EAGAIN, syscall.EAGAIN, nil}, calls: 3},
+		{name: "gives up", errs: []error{syscall.ETIMEDOUT, syscall.ETIMEDOUT, syscall.ETIMEDOUT, nil}, calls: 3, err: drivers.ErrTimeout},
+		{name: "permission", errs: []error{syscall.EACCES, nil}, calls: 1, err: drivers.ErrPermission},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := drivers.RetryI2C(3, time.Millisecond, func() error {
+				err := tt.errs[calls]
+				calls++
+				return drivers.ClassifyI2CError("/dev/i2c-1", 0x10, err)
+			})
+			if calls != tt.calls {
+				t.Errorf("calls got (%d) want (%d)", calls, tt.calls)
+			}
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Errorf("RetryI2C() error = %v want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package drivers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+var (
+	busLocks   = make(map[string]*sync.Mutex)
+	busLocksMu sync.Mutex
+)
+
+// busLock returns the lock shared by every device on the named bus
+func busLock(bus string) *sync.Mutex {
+	busLocksMu.Lock()
+	defer busLocksMu.Unlock()
+
+	l, ex := busLocks[bus]
+	if !ex {
+		l = &sync.Mutex{}
+		busLocks[bus] = l
+	}
+	return l
+}
+
+// I2CDevice wraps an I2CBus with register level helpers. Each helper
+// is a single transaction made while holding the lock of the bus, so
+// devices sharing a bus never interleave a read-modify-write. Order
+// selects the byte order of multi-byte registers, it defaults to
+// big endian which most sensors use.
+type I2CDevice struct {
+	I2CBus
+	Bus   string
+	Addr  int
+	Order binary.ByteOrder
+
+	lock *sync.Mutex
+}
+
+// NewI2CDevice opens the device at addr on bus through the registry
+func NewI2CDevice(bus string, addr int) (*I2CDevice, error) {
+	b, err := OpenI2C(bus, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &I2CDevice{
+		I2CBus: b,
+		Bus:    bus,
+		Addr:   addr,
+		Order:  binary.BigEndian,
+		lock:   busLock(bus),
+	}, nil
+}
+
+// Tx runs fn while holding the bus lock, use it to group several
+// bus operations into one atomic transaction. The error returned by
+// fn is classified with ClassifyI2CError.
+func (d *I2CDevice) Tx(fn func(bus I2CBus) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return ClassifyI2CError(d.Bus, d.Addr, fn(d.I2CBus))
+}
+
+// ReadReg8 reads the 8 bit register reg
+func (d *I2CDevice) ReadReg8(reg byte) (uint8, error) {
+	buf, err := d.ReadBlock(reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// ReadReg16 reads the 16 bit register starting at reg
+func (d *I2CDevice) ReadReg16(reg byte) (uint16, error) {
+	buf, err := d.ReadBlock(reg, 2)
+	if err != nil {
+		return 0, err
+	}
+	return d.order().Uint16(buf), nil
+}
+
+// ReadReg24 reads the 24 bit register starting at reg
+func (d *I2CDevice) ReadReg24(reg byte) (uint32, error) {
+	buf, err := d.ReadBlock(reg, 3)
+	if err != nil {
+		return 0, err
+	}
+	if d.order() == binary.LittleEndian {
+		return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16, nil
+	}
+	return uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2]), nil
+}
+
+// WriteReg8 writes v to the 8 bit register reg
+func (d *I2CDevice) WriteReg8(reg byte, v uint8) error {
+	return d.Tx(func(bus I2CBus) error {
+		return d.wrap("write", reg, bus.WriteReg(reg, []byte{v}))
+	})
+}
+
+// WriteReg16 writes v to the 16 bit register starting at reg
+func (d *I2CDevice) WriteReg16(reg byte, v uint16) error {
+	buf := make([]byte, 2)
+	d.order().PutUint16(buf, v)
+	return d.Tx(func(bus I2CBus) error {
+		return d.wrap("write", reg, bus.WriteReg(reg, buf))
+	})
+}
+
+// ReadBlock reads n consecutive registers starting at reg
+func (d *I2CDevice) ReadBlock(reg byte, n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("i2c %s %#02x: invalid block length %d", d.Bus, d.Addr, n)
+	}
+	buf := make([]byte, n)
+	err := d.Tx(func(bus I2CBus) error {
+		return d.wrap("read", reg, bus.ReadReg(reg, buf))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// UpdateBits sets the bits of reg selected by mask to value leaving
+// the others untouched. The register is only written if it changes.
+func (d *I2CDevice) UpdateBits(reg byte, mask, value uint8) error {
+	return d.Tx(func(bus I2CBus) error {
+		buf := make([]byte, 1)
+		if err := bus.ReadReg(reg, buf); err != nil {
+			return d.wrap("read", reg, err)
+		}
+		v := (buf[0] &^ mask) | (value & mask)
+		if v == buf[0] {
+			return nil
+		}
+		return d.wrap("write", reg, bus.WriteReg(reg, []byte{v}))
+	})
+}
+
+func (d *I2CDevice) order() binary.ByteOrder {
+	if d.Order == nil {
+		return binary.BigEndian
+	}
+	return d.Order
+}
+
+func (d *I2CDevice) wrap(op string, reg byte, err error) error {
+	if err == nil {
+		return nil
+	}
+	err = ClassifyI2CError(d.Bus, d.Addr, err)
+	return fmt.Errorf("i2c %s %#02x %s reg %#02x: %w", d.Bus, d.Addr, op, reg, err)
+}
@@ -0,0 +1,161 @@
+package drivers_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func newRegDevice(t *testing.T) (*drivers.I2CDevice, *driverstest.I2C) {
+	t.Helper()
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	d, err := drivers.NewI2CDevice("/dev/i2c-1", 0x40)
+	if err != nil {
+		t.Fatalf("NewI2CDevice() error = %v", err)
+	}
+	return d, fake
+}
+
+func TestI2CReadRegs(t *testing.T) {
+	d, fake := newRegDevice(t)
+	fake.Set(0x10, 0x12, 0x34, 0x56)
+
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		r16   uint16
+		r24   uint32
+	}{
+		{name: "big endian", order: binary.BigEndian, r16: 0x1234, r24: 0x123456},
+		{name: "little endian", order: binary.LittleEndian, r16: 0x3412, r24: 0x563412},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.Order = tt.order
+
+			v8, err := d.ReadReg8(0x10)
+			if err != nil || v8 != 0x12 {
+				t.Errorf("ReadReg8() got (%#x, %v) want (0x12, nil)", v8, err)
+			}
+			v16, err := d.ReadReg16(0x10)
+			if err != nil || v16 != tt.r16 {
+				t.Errorf("ReadReg16() got (%#x, %v) want (%#x, nil)", v16, err, tt.r16)
+			}
+			v24, err := d.ReadReg24(0x10)
+			if err != nil || v24 != tt.r24 {
+				t.Errorf("ReadReg24() got (%#x, %v) want (%#x, nil)", v24, err, tt.r24)
+			}
+		})
+	}
+}
+
+func TestI2CWriteRegs(t *testing.T) {
+	d, fake := newRegDevice(t)
+
+	if err := d.WriteReg8(0x01, 0xAB); err != nil {
+		t.Fatalf("WriteReg8() error = %v", err)
+	}
+	if err := d.WriteReg16(0x02, 0x1234); err != nil {
+		t.Fatalf("WriteReg16() error = %v", err)
+	}
+	d.Order = binary.LittleEndian
+	if err := d.WriteReg16(0x04, 0x1234); err != nil {
+		t.Fatalf("WriteReg16() error = %v", err)
+	}
+
+	want := []byte{0xAB, 0x12, 0x34, 0x34, 0x12}
+	if got := fake.Get(0x01, 5); !bytes.Equal(got, want) {
+		t.Errorf("registers got (% x) want (% x)", got, want)
+	}
+	if len(fake.Writes) != 3 {
+		t.Errorf("expected 3 bus writes got %d", len(fake.Writes))
+	}
+}
+
+func TestI2CReadBlock(t *testing.T) {
+	d, fake := newRegDevice(t)
+	fake.Set(0xF7, 1, 2, 3, 4, 5, 6, 7, 8)
+
+	buf, err := d.ReadBlock(0xF7, 8)
+	if err != nil {
+		t.Fatalf("ReadBlock() error = %v", err)
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("ReadBlock() got (% x)", buf)
+	}
+	if _, err := d.ReadBlock(0xF7, 0); err == nil {
+		t.Error("ReadBlock() expected an error for a zero length")
+	}
+}
+
+func TestI2CUpdateBits(t *testing.T) {
+	d, fake := newRegDevice(t)
+	fake.Set(0x0F, 0b1010_0101)
+
+	if err := d.UpdateBits(0x0F, 0b0000_1000, 0xFF); err != nil {
+		t.Fatalf("UpdateBits() error = %v", err)
+	}
+	if got := fake.Get(0x0F, 1)[0]; got != 0b1010_1101 {
+		t.Errorf("set bit 3 got (%08b) want (10101101)", got)
+	}
+
+	if err := d.UpdateBits(0x0F, 0b1110_0000, 0b0100_0000); err != nil {
+		t.Fatalf("UpdateBits() error = %v", err)
+	}
+	if got := fake.Get(0x0F, 1)[0]; got != 0b0100_1101 {
+		t.Errorf("set field got (%08b) want (01001101)", got)
+	}
+
+	writes := len(fake.Writes)
+	if err := d.UpdateBits(0x0F, 0b0000_1000, 0b0000_1000); err != nil {
+		t.Fatalf("UpdateBits() error = %v", err)
+	}
+	if len(fake.Writes) != writes {
+		t.Error("UpdateBits() wrote a register that did not change")
+	}
+}
+
+func TestI2CUpdateBitsAtomic(t *testing.T) {
+	d, fake := newRegDevice(t)
+
+	var wg sync.WaitGroup
+	for bit := 0; bit < 8; bit++ {
+		wg.Add(1)
+		go func(mask uint8) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				d.UpdateBits(0x20, mask, 0)
+				d.UpdateBits(0x20, mask, mask)
+			}
+		}(uint8(1) << bit)
+	}
+	wg.Wait()
+
+	if got := fake.Get(0x20, 1)[0]; got != 0xFF {
+		t.Errorf("concurrent UpdateBits lost an update got (%08b)", got)
+	}
+}
+
+func TestI2CErrors(t *testing.T) {
+	d, fake := newRegDevice(t)
+	errBus := errors.New("remote I/O error")
+	fake.Err = errBus
+
+	if _, err := d.ReadReg16(0x10); !errors.Is(err, errBus) {
+		t.Errorf("ReadReg16() error = %v want %v", err, errBus)
+	}
+	if err := d.WriteReg8(0x10, 1); !errors.Is(err, errBus) {
+		t.Errorf("WriteReg8() error = %v want %v", err, errBus)
+	}
+	if err := d.UpdateBits(0x10, 1, 1); !errors.Is(err, errBus) {
+		t.Errorf("UpdateBits() error = %v want %v", err, errBus)
+	}
+}
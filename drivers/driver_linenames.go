@@ -0,0 +1,218 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+var (
+	// ErrLineNotFound is returned when no gpiochip has a line with
+	// the requested name
+	ErrLineNotFound = errors.New("gpio line not found")
+
+	// ErrLineAmbiguous is returned when more than one gpiochip has
+	// a line with the requested name
+	ErrLineAmbiguous = errors.New("gpio line name is ambiguous")
+
+	// mockLineNames are the line names of the mock chips. Like on a
+	// Pi 5 the header lines of gpiochip4 are named GPIO0 - GPIO27.
+	mockLineNames = map[string][]string{
+		"gpiochip4": bcmNames(28),
+	}
+)
+
+// HeaderPins translates the physical pin labels of the 40 pin
+// Raspberry Pi header to the kernel line names. Boards with another
+// header can add their labels before looking pins up.
+var HeaderPins = map[string]string{
+	"PIN3": "GPIO2", "PIN5": "GPIO3", "PIN7": "GPIO4", "PIN8": "GPIO14",
+	"PIN10": "GPIO15", "PIN11": "GPIO17", "PIN12": "GPIO18", "PIN13": "GPIO27",
+	"PIN15": "GPIO22", "PIN16": "GPIO23", "PIN18": "GPIO24", "PIN19": "GPIO10",
+	"PIN21": "GPIO9", "PIN22": "GPIO25", "PIN23": "GPIO11", "PIN24": "GPIO8",
+	"PIN26": "GPIO7", "PIN27": "GPIO0", "PIN28": "GPIO1", "PIN29": "GPIO5",
+	"PIN31": "GPIO6", "PIN32": "GPIO12", "PIN33": "GPIO13", "PIN35": "GPIO19",
+	"PIN36": "GPIO16", "PIN37": "GPIO26", "PIN38": "GPIO20", "PIN40": "GPIO21",
+}
+
+// MockLineNames names the lines of a mock chip starting at offset 0,
+// an empty string leaves a line unnamed. It is only consulted when
+// running in mock mode.
+func MockLineNames(chip string, names ...string) {
+	chipsMu.Lock()
+	defer chipsMu.Unlock()
+	mockLineNames[chip] = names
+}
+
+// FindLine searches every gpiochip for the line called nameOrLabel.
+// The name is matched without regard to case and may be a kernel
+// line name like "GPIO17", a BCM number like "BCM17" or a header
+// label from HeaderPins like "PIN11". A name that is on no chip, or
+// on more than one, returns an error listing the near matches or
+// the candidates.
+func FindLine(nameOrLabel string) (chip string, offset int, err error) {
+	name := lineName(nameOrLabel)
+	all, err := lineNames()
+	if err != nil {
+		return "", 0, err
+	}
+
+	var found []string
+	for _, c := range sortedChips(all) {
+		for off, n := range all[c] {
+			if n != "" && strings.EqualFold(n, name) {
+				chip, offset = c, off
+				found = append(found, fmt.Sprintf("%s:%d", c, off))
+			}
+		}
+	}
+
+	switch len(found) {
+	case 1:
+		return chip, offset, nil
+	case 0:
+		near := nearLines(name, all)
+		if len(near) == 0 {
+			return "", 0, fmt.Errorf("%w: %s", ErrLineNotFound, nameOrLabel)
+		}
+		return "", 0, fmt.Errorf("%w: %s (did you mean %s)", ErrLineNotFound, nameOrLabel, strings.Join(near, ", "))
+	default:
+		return "", 0, fmt.Errorf("%w: %s is on %s, use chip:offset", ErrLineAmbiguous, nameOrLabel, strings.Join(found, ", "))
+	}
+}
+
+// ResolvePinID turns any pin identifier into a chip and offset, it
+// accepts the "chipname:offset" and bare offset forms of ParsePinID
+// and line names or header labels for FindLine.
+func ResolvePinID(id string) (chip string, offset int, err error) {
+	if isOffsetID(id) {
+		return ParsePinID(id)
+	}
+	return FindLine(id)
+}
+
+// isOffsetID reports if id is an offset optionally prefixed by a
+// chip, the forms handled by ParsePinID
+func isOffsetID(id string) bool {
+	off := id
+	if i := strings.LastIndex(id, ":"); i >= 0 {
+		off = id[i+1:]
+	}
+	_, err := strconv.Atoi(off)
+	return err == nil || strings.Contains(id, ":")
+}
+
+// lineName translates header labels and BCM numbers to line names
+func lineName(label string) string {
+	l := strings.ToUpper(strings.TrimSpace(label))
+	if n, ex := HeaderPins[l]; ex {
+		return n
+	}
+	if num, ok := strings.CutPrefix(l, "BCM"); ok {
+		if _, err := strconv.Atoi(num); err == nil {
+			return "GPIO" + num
+		}
+	}
+	return label
+}
+
+// lineNames returns the line names of every chip indexed by offset
+func lineNames() (map[string][]string, error) {
+	all := make(map[string][]string)
+	if device.IsMock() {
+		chipsMu.Lock()
+		defer chipsMu.Unlock()
+		for c := range mockChips {
+			all[c] = mockLineNames[c]
+		}
+		return all, nil
+	}
+
+	for _, name := range gpiocdev.Chips() {
+		c, err := gpiocdev.NewChip(name)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", name, err)
+		}
+		names := make([]string, c.Lines())
+		for i := range names {
+			if li, err := c.LineInfo(i); err == nil {
+				names[i] = li.Name
+			}
+		}
+		c.Close()
+		all[name] = names
+	}
+	return all, nil
+}
+
+// nearLines returns up to five line names that are a couple of edits
+// from name
+func nearLines(name string, all map[string][]string) []string {
+	type match struct {
+		name string
+		dist int
+	}
+	var near []match
+	seen := make(map[string]bool)
+	for _, c := range sortedChips(all) {
+		for _, n := range all[c] {
+			if n == "" || seen[n] {
+				continue
+			}
+			seen[n] = true
+			if d := editDistance(strings.ToUpper(n), strings.ToUpper(name)); d <= 2 {
+				near = append(near, match{n, d})
+			}
+		}
+	}
+	sort.SliceStable(near, func(i, j int) bool { return near[i].dist < near[j].dist })
+
+	var names []string
+	for i := 0; i < len(near) && i < 5; i++ {
+		names = append(names, near[i].name)
+	}
+	return names
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func sortedChips(all map[string][]string) []string {
+	names := make([]string, 0, len(all))
+	for c := range all {
+		names = append(names, c)
+	}
+	sort.Slice(names, func(i, j int) bool { return chipLess(names[i], names[j]) })
+	return names
+}
+
+func bcmNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "GPIO" + strconv.Itoa(i)
+	}
+	return names
+}
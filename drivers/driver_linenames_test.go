@@ -0,0 +1,99 @@
+package drivers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestFindLine(t *testing.T) {
+	resetChips(t)
+	MockLineNames("gpiochip0", "ID_SDA", "ID_SCL", "", "", "", "", "FAN_PWM")
+	t.Cleanup(func() { delete(mockLineNames, "gpiochip0") })
+
+	tests := []struct {
+		id     string
+		chip   string
+		offset int
+		err    error
+		near   string
+	}{
+		{id: "GPIO17", chip: "gpiochip4", offset: 17},
+		{id: "gpio17", chip: "gpiochip4", offset: 17},
+		{id: "BCM4", chip: "gpiochip4", offset: 4},
+		{id: "PIN11", chip: "gpiochip4", offset: 17},
+		{id: "pin40", chip: "gpiochip4", offset: 21},
+		{id: "FAN_PWM", chip: "gpiochip0", offset: 6},
+		{id: "GPIO99", err: ErrLineNotFound, near: "GPIO9"},
+		{id: "FAN-PWN", err: ErrLineNotFound, near: "FAN_PWM"},
+		{id: "heater", err: ErrLineNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			chip, offset, err := FindLine(tt.id)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("FindLine(%s) error = %v want %v", tt.id, err, tt.err)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.near) {
+					t.Errorf("FindLine(%s) error %q does not suggest %s", tt.id, err, tt.near)
+				}
+				return
+			}
+			if chip != tt.chip || offset != tt.offset {
+				t.Errorf("FindLine(%s) got (%s, %d) want (%s, %d)", tt.id, chip, offset, tt.chip, tt.offset)
+			}
+		})
+	}
+}
+
+func TestFindLineAmbiguous(t *testing.T) {
+	resetChips(t)
+	MockChip("gpiochip10", 8)
+	MockLineNames("gpiochip10", "", "", "GPIO17")
+	t.Cleanup(func() {
+		delete(mockChips, "gpiochip10")
+		delete(mockLineNames, "gpiochip10")
+	})
+
+	_, _, err := FindLine("PIN11")
+	if !errors.Is(err, ErrLineAmbiguous) {
+		t.Fatalf("FindLine(PIN11) error = %v want %v", err, ErrLineAmbiguous)
+	}
+	if !strings.Contains(err.Error(), "gpiochip4:17") || !strings.Contains(err.Error(), "gpiochip10:2") {
+		t.Errorf("FindLine(PIN11) error %q does not list both lines", err)
+	}
+}
+
+func TestNewDigitalPinLabel(t *testing.T) {
+	resetChips(t)
+
+	tests := []struct {
+		id     string
+		chip   string
+		offset int
+	}{
+		{id: "17", chip: "gpiochip0", offset: 17},
+		{id: "gpiochip4:12", chip: "gpiochip4", offset: 12},
+		{id: "GPIO23", chip: "gpiochip4", offset: 23},
+		{id: "PIN12", chip: "gpiochip4", offset: 18},
+	}
+
+	for _, tt := range tests {
+		p, err := NewDigitalPinID("pin", tt.id, gpiocdev.AsOutput(1))
+		if err != nil {
+			t.Fatalf("NewDigitalPinID(%s) error = %v", tt.id, err)
+		}
+		if p.Chipname() != tt.chip || p.offset != tt.offset {
+			t.Errorf("NewDigitalPinID(%s) got (%s, %d) want (%s, %d)", tt.id, p.Chipname(), p.offset, tt.chip, tt.offset)
+		}
+		p.Close()
+	}
+
+	if _, err := NewDigitalPinID("pin", "PIN1"); !errors.Is(err, ErrLineNotFound) {
+		t.Errorf("NewDigitalPinID(PIN1) error = %v want %v", err, ErrLineNotFound)
+	}
+}
@@ -0,0 +1,506 @@
+package drivers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// MCP23017 registers with IOCON.BANK = 0, the port B register
+// follows its port A register so a 16 bit little endian access
+// covers both ports with offset 0 - 7 on port A and 8 - 15 on B.
+const (
+	mcpRegIODIR   = 0x00
+	mcpRegGPINTEN = 0x04
+	mcpRegINTCON  = 0x08
+	mcpRegIOCON   = 0x0A
+	mcpRegGPPU    = 0x0C
+	mcpRegINTF    = 0x0E
+	mcpRegINTCAP  = 0x10
+	mcpRegGPIO    = 0x12
+	mcpRegOLAT    = 0x14
+
+	mcpIOCONMirror = 0x40 // INTA and INTB both fire for either port
+
+	// MCP23017Lines is the number of lines on the expander
+	MCP23017Lines = 16
+)
+
+// ErrLineBusy is returned when a line that is already requested is
+// requested again
+var ErrLineBusy = errors.New("gpio line busy")
+
+// MCP23017 is a 16 line i2c GPIO expander. It registers itself as a
+// gpiochip under its name, pins are requested from it the same way
+// as from a native chip and come back as regular DigitalPins, so
+// the led, relay and button devices run on it unmodified.
+//
+// Interrupt on change is delivered through the event handler of the
+// line like a native edge, either by wiring INTA/INTB to a native
+// pin (WatchInterrupt) or by calling HandleInterrupt when polling.
+type MCP23017 struct {
+	Name string
+	Bus  string
+	Addr int
+
+	dev    *I2CDevice
+	mem    *mcpMem // register map used in mock mode
+	lines  [MCP23017Lines]*mcpLine
+	intPin *DigitalPin
+	mu     sync.Mutex
+}
+
+// NewMCP23017 opens the expander at addr on bus (0x20 - 0x27) and
+// registers it as gpiochip name. All lines start as inputs.
+func NewMCP23017(name string, bus string, addr int) (*MCP23017, error) {
+	m := &MCP23017{
+		Name: name,
+		Bus:  bus,
+		Addr: addr,
+	}
+
+	if device.IsMock() {
+		m.mem = newMCPMem()
+		m.dev = &I2CDevice{I2CBus: m.mem, Bus: bus, Addr: addr, lock: busLock(bus)}
+	} else {
+		var err error
+		if m.dev, err = NewI2CDevice(bus, addr); err != nil {
+			return nil, err
+		}
+	}
+	m.dev.Order = binary.LittleEndian
+
+	if err := m.dev.WriteReg8(mcpRegIOCON, mcpIOCONMirror); err != nil {
+		return nil, fmt.Errorf("mcp23017 %s: init: %w", name, err)
+	}
+	RegisterGPIOChip(name, m)
+	return m, nil
+}
+
+// Pin requests the line at offset as a DigitalPin, the same as
+// NewDigitalPin(name, offset, WithChip(m.Name), opts...)
+func (m *MCP23017) Pin(name string, offset int, opts ...gpiocdev.LineReqOption) (*DigitalPin, error) {
+	g, err := GetGPIOChip(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	p := g.Pin(name, offset, opts...)
+	if p.Line == nil {
+		return nil, fmt.Errorf("mcp23017 %s: pin %s failed to initialize", m.Name, name)
+	}
+	return p, nil
+}
+
+// RequestLine configures the line at offset from the options and
+// returns it. Direction, output values, pull-ups, active low and
+// event handlers with their edges are supported.
+func (m *MCP23017) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (Line, error) {
+	if offset < 0 || offset >= MCP23017Lines {
+		return nil, fmt.Errorf("mcp23017 %s: offset %d out of range", m.Name, offset)
+	}
+
+	m.mu.Lock()
+	if m.lines[offset] != nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s line %d", ErrLineBusy, m.Name, offset)
+	}
+	l := &mcpLine{m: m, offset: offset}
+	m.lines[offset] = l
+	m.mu.Unlock()
+
+	cfg := make([]any, len(opts))
+	for i, o := range opts {
+		cfg[i] = o
+	}
+	if err := l.configure(cfg); err != nil {
+		m.release(l)
+		return nil, err
+	}
+	return l, nil
+}
+
+// HandleInterrupt reads the interrupt flags and the captured port
+// values and delivers an event to every flagged line. Reading the
+// capture registers clears the interrupt on the chip.
+func (m *MCP23017) HandleInterrupt() error {
+	var flags, capture uint16
+	err := m.dev.Tx(func(bus I2CBus) error {
+		buf := make([]byte, 2)
+		if err := bus.ReadReg(mcpRegINTF, buf); err != nil {
+			return err
+		}
+		flags = binary.LittleEndian.Uint16(buf)
+		if flags == 0 {
+			return nil
+		}
+		if err := bus.ReadReg(mcpRegINTCAP, buf); err != nil {
+			return err
+		}
+		capture = binary.LittleEndian.Uint16(buf)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("mcp23017 %s: interrupt: %w", m.Name, err)
+	}
+
+	for off := 0; off < MCP23017Lines; off++ {
+		if flags&(1<<off) == 0 {
+			continue
+		}
+		m.mu.Lock()
+		l := m.lines[off]
+		m.mu.Unlock()
+		if l != nil {
+			l.event(int(capture>>off) & 1)
+		}
+	}
+	return nil
+}
+
+// WatchInterrupt requests the native pin id (see ParsePinID) wired
+// to INTA or INTB and handles the interrupts it signals.
+func (m *MCP23017) WatchInterrupt(id string) error {
+	p, err := NewDigitalPinID(m.Name+"-int", id,
+		gpiocdev.AsInput,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithFallingEdge,
+		gpiocdev.WithEventHandler(func(gpiocdev.LineEvent) {
+			if err := m.HandleInterrupt(); err != nil {
+				slog.Error("mcp23017 interrupt", "name", m.Name, "error", err)
+			}
+		}))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.intPin = p
+	m.mu.Unlock()
+
+	// clear anything that fired before we were listening
+	return m.HandleInterrupt()
+}
+
+// MockInput drives the input at offset to v in mock mode, raising
+// the interrupt if it is enabled for the line.
+func (m *MCP23017) MockInput(offset int, v int) error {
+	if m.mem == nil {
+		return fmt.Errorf("mcp23017 %s: MockInput requires mock mode", m.Name)
+	}
+	if m.mem.input(offset, v) {
+		return m.HandleInterrupt()
+	}
+	return nil
+}
+
+// Close releases every line, stops watching the interrupt pin and
+// removes the chip from the registry.
+func (m *MCP23017) Close() error {
+	RegisterGPIOChip(m.Name, nil)
+
+	chipsMu.Lock()
+	g := chips[m.Name]
+	delete(chips, m.Name)
+	chipsMu.Unlock()
+
+	var errs []error
+	if g != nil {
+		errs = append(errs, g.CloseAll())
+	}
+
+	m.mu.Lock()
+	intPin := m.intPin
+	m.intPin = nil
+	var lines []*mcpLine
+	for _, l := range m.lines {
+		if l != nil {
+			lines = append(lines, l)
+		}
+	}
+	m.mu.Unlock()
+
+	if intPin != nil {
+		errs = append(errs, intPin.Close())
+	}
+	for _, l := range lines {
+		errs = append(errs, l.Close())
+	}
+	if m.mem == nil {
+		errs = append(errs, m.dev.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MCP23017) release(l *mcpLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lines[l.offset] == l {
+		m.lines[l.offset] = nil
+	}
+}
+
+// setBit sets or clears the bit of offset in the register pair reg
+func (m *MCP23017) setBit(reg byte, offset int, on bool) error {
+	bit := uint8(1) << (offset % 8)
+	val := uint8(0)
+	if on {
+		val = bit
+	}
+	return m.dev.UpdateBits(reg+byte(offset/8), bit, val)
+}
+
+// mcpLine is a single line of the expander, it satisfies Line
+type mcpLine struct {
+	m         *MCP23017
+	offset    int
+	activeLow bool
+	handler   gpiocdev.EventHandler
+	edge      gpiocdev.LineEdge
+	seqno     uint32
+	closed    bool
+	mu        sync.Mutex
+}
+
+func (l *mcpLine) Offset() int {
+	return l.offset
+}
+
+// Value reads the level of the line from the GPIO register
+func (l *mcpLine) Value() (int, error) {
+	if l.isClosed() {
+		return 0, ErrClosed
+	}
+	v, err := l.m.dev.ReadReg8(mcpRegGPIO + byte(l.offset/8))
+	if err != nil {
+		return 0, err
+	}
+	return l.level(int(v>>(l.offset%8)) & 1), nil
+}
+
+// SetValue sets the output latch of the line
+func (l *mcpLine) SetValue(v int) error {
+	if l.isClosed() {
+		return ErrClosed
+	}
+	return l.m.setBit(mcpRegOLAT, l.offset, l.level(v) == 1)
+}
+
+func (l *mcpLine) Reconfigure(opts ...gpiocdev.LineConfigOption) error {
+	if l.isClosed() {
+		return ErrClosed
+	}
+	cfg := make([]any, len(opts))
+	for i, o := range opts {
+		cfg[i] = o
+	}
+	return l.configure(cfg)
+}
+
+// Close disables the interrupt and returns the line to an input
+func (l *mcpLine) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	l.m.release(l)
+	return errors.Join(
+		l.m.setBit(mcpRegGPINTEN, l.offset, false),
+		l.m.setBit(mcpRegIODIR, l.offset, true),
+	)
+}
+
+// configure applies gpiocdev line options to the expander registers.
+// Output values are latched before the direction is switched so an
+// output never glitches to a stale level.
+func (l *mcpLine) configure(opts []any) error {
+	var output *int
+	input := false
+	for _, o := range opts {
+		switch v := o.(type) {
+		case gpiocdev.OutputOption:
+			val := 0
+			if len(v) > 0 {
+				val = v[0]
+			}
+			output = &val
+		case gpiocdev.InputOption:
+			input = true
+		case gpiocdev.LevelOption:
+			l.activeLow = bool(v)
+		case gpiocdev.LineBias:
+			if err := l.m.setBit(mcpRegGPPU, l.offset, v == gpiocdev.LineBiasPullUp); err != nil {
+				return err
+			}
+			if v == gpiocdev.LineBiasPullDown {
+				slog.Warn("mcp23017 has no pull-downs", "name", l.m.Name, "offset", l.offset)
+			}
+		case gpiocdev.EventHandler:
+			l.mu.Lock()
+			l.handler = v
+			l.mu.Unlock()
+		case gpiocdev.LineEdge:
+			l.mu.Lock()
+			l.edge = v
+			l.mu.Unlock()
+		}
+	}
+
+	if output != nil {
+		if err := l.m.setBit(mcpRegOLAT, l.offset, l.level(*output) == 1); err != nil {
+			return err
+		}
+		if err := l.m.setBit(mcpRegIODIR, l.offset, false); err != nil {
+			return err
+		}
+	} else if input {
+		if err := l.m.setBit(mcpRegIODIR, l.offset, true); err != nil {
+			return err
+		}
+	}
+
+	// interrupt on change, compared against the previous value. The
+	// edges are filtered in event() as the chip reports both.
+	l.mu.Lock()
+	watch := l.handler != nil
+	l.mu.Unlock()
+	if err := l.m.setBit(mcpRegINTCON, l.offset, false); err != nil {
+		return err
+	}
+	return l.m.setBit(mcpRegGPINTEN, l.offset, watch && output == nil)
+}
+
+// event delivers an edge to the handler if it wants that edge
+func (l *mcpLine) event(raw int) {
+	v := l.level(raw)
+
+	l.mu.Lock()
+	if l.closed || l.handler == nil {
+		l.mu.Unlock()
+		return
+	}
+	typ := gpiocdev.LineEventRisingEdge
+	if v == 0 {
+		typ = gpiocdev.LineEventFallingEdge
+	}
+	if (l.edge == gpiocdev.LineEdgeRising && v == 0) || (l.edge == gpiocdev.LineEdgeFalling && v == 1) {
+		l.mu.Unlock()
+		return
+	}
+	l.seqno++
+	evt := gpiocdev.LineEvent{
+		Offset:    l.offset,
+		Type:      typ,
+		Seqno:     l.seqno,
+		LineSeqno: l.seqno,
+	}
+	h := l.handler
+	l.mu.Unlock()
+
+	h(evt)
+}
+
+// level converts between the physical and the logical value
+func (l *mcpLine) level(v int) int {
+	if l.activeLow {
+		return v ^ 1
+	}
+	return v
+}
+
+func (l *mcpLine) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// mcpMem is the register map of a simulated MCP23017, it latches
+// INTF and INTCAP on input changes and clears them when INTCAP is
+// read like the chip does.
+type mcpMem struct {
+	regs [0x16]byte
+	mu   sync.Mutex
+}
+
+func newMCPMem() *mcpMem {
+	m := &mcpMem{}
+	m.regs[mcpRegIODIR] = 0xFF
+	m.regs[mcpRegIODIR+1] = 0xFF
+	return m
+}
+
+// input sets the level of an input pin, it reports if an interrupt
+// was raised
+func (m *mcpMem) input(offset int, v int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	port, bit := byte(offset/8), byte(1)<<(offset%8)
+	old := m.regs[mcpRegGPIO+port]
+	val := old &^ bit
+	if v != 0 {
+		val |= bit
+	}
+	m.regs[mcpRegGPIO+port] = val
+	if val == old || m.regs[mcpRegGPINTEN+port]&bit == 0 {
+		return false
+	}
+	m.regs[mcpRegINTF+port] |= bit
+	m.regs[mcpRegINTCAP+port] = val
+	return true
+}
+
+func (m *mcpMem) Read(buf []byte) error {
+	return errors.New("mcp23017 mock: raw reads not supported")
+}
+
+func (m *mcpMem) Write(buf []byte) error {
+	return errors.New("mcp23017 mock: raw writes not supported")
+}
+
+func (m *mcpMem) ReadReg(reg byte, buf []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range buf {
+		r := int(reg) + i
+		if r >= len(m.regs) {
+			return fmt.Errorf("mcp23017 mock: register %#02x", r)
+		}
+		buf[i] = m.regs[r]
+		if r == mcpRegINTCAP || r == mcpRegINTCAP+1 {
+			m.regs[r-mcpRegINTCAP+mcpRegINTF] = 0
+		}
+	}
+	return nil
+}
+
+func (m *mcpMem) WriteReg(reg byte, buf []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, v := range buf {
+		r := int(reg) + i
+		if r >= len(m.regs) {
+			return fmt.Errorf("mcp23017 mock: register %#02x", r)
+		}
+		m.regs[r] = v
+
+		// outputs read back their latch
+		if port := r % 2; r == mcpRegOLAT+port || r == mcpRegIODIR+port {
+			dir := m.regs[mcpRegIODIR+port]
+			m.regs[mcpRegGPIO+port] = m.regs[mcpRegGPIO+port]&dir | m.regs[mcpRegOLAT+port]&^dir
+		}
+	}
+	return nil
+}
+
+func (m *mcpMem) Close() error {
+	return nil
+}
@@ -0,0 +1,223 @@
+package drivers_test
+
+import (
+	"errors"
+	"testing"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// mcpFake clears the interrupt flags when the capture registers are
+// read, like the chip does
+type mcpFake struct {
+	*driverstest.I2C
+}
+
+func (f *mcpFake) ReadReg(reg byte, buf []byte) error {
+	err := f.I2C.ReadReg(reg, buf)
+	if reg == 0x10 {
+		f.Set(0x0E, make([]byte, len(buf))...)
+	}
+	return err
+}
+
+func newMCP(t *testing.T, name string) *drivers.MCP23017 {
+	t.Helper()
+	m, err := drivers.NewMCP23017(name, "/dev/i2c-1", 0x20)
+	if err != nil {
+		t.Fatalf("NewMCP23017() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestMCP23017Direction(t *testing.T) {
+	fake := &mcpFake{I2C: driverstest.NewI2C()}
+	fake.Set(0x00, 0xFF, 0xFF) // IODIR resets to all inputs
+	driverstest.UseI2C(t, fake)
+	m := newMCP(t, "mcp-dir")
+
+	if got := fake.Get(0x0A, 1)[0]; got != 0x40 {
+		t.Errorf("IOCON got (%#x) want (0x40)", got)
+	}
+
+	relay, err := m.Pin("relay", 9, gpiocdev.AsOutput(1))
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if got := fake.Get(0x00, 2); got[0] != 0xFF || got[1] != 0xFD {
+		t.Errorf("IODIR got (% x) want (ff fd)", got)
+	}
+	if got := fake.Get(0x15, 1)[0]; got != 0x02 {
+		t.Errorf("OLATB got (%#x) want (0x02)", got)
+	}
+	if relay.Chipname() != "mcp-dir" {
+		t.Errorf("Chipname() got (%s) want (mcp-dir)", relay.Chipname())
+	}
+
+	// the same calls the relay and led packages make
+	if err := relay.Off(); err != nil {
+		t.Fatalf("Off() error = %v", err)
+	}
+	if got := fake.Get(0x15, 1)[0]; got != 0 {
+		t.Errorf("OLATB after Off() got (%#x) want (0)", got)
+	}
+
+	button, err := m.Pin("button", 3, gpiocdev.AsInput, gpiocdev.WithPullUp)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if got := fake.Get(0x0C, 1)[0]; got != 0x08 {
+		t.Errorf("GPPUA got (%#x) want (0x08)", got)
+	}
+	fake.Set(0x12, 0x08)
+	if v, err := button.Get(); err != nil || v != 1 {
+		t.Errorf("Get() got (%d, %v) want (1, nil)", v, err)
+	}
+	fake.Set(0x12, 0x00)
+	if v, err := button.Get(); err != nil || v != 0 {
+		t.Errorf("Get() got (%d, %v) want (0, nil)", v, err)
+	}
+
+	// closing an output returns it to an input
+	if err := relay.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := fake.Get(0x01, 1)[0]; got != 0xFF {
+		t.Errorf("IODIRB after Close() got (%#x) want (0xff)", got)
+	}
+}
+
+func TestMCP23017Busy(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	m := newMCP(t, "mcp-busy")
+
+	if _, err := m.RequestLine(2); err != nil {
+		t.Fatalf("RequestLine() error = %v", err)
+	}
+	if _, err := m.RequestLine(2); !errors.Is(err, drivers.ErrLineBusy) {
+		t.Errorf("RequestLine() error = %v want %v", err, drivers.ErrLineBusy)
+	}
+	if _, err := m.RequestLine(16); err == nil {
+		t.Error("RequestLine(16) expected an error")
+	}
+}
+
+func TestMCP23017Mock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	m := newMCP(t, "mcp-mock")
+
+	led := drivers.NewDigitalPin("led", 0, drivers.WithChip("mcp-mock"), gpiocdev.AsOutput(0))
+	if err := led.On(); err != nil {
+		t.Fatalf("On() error = %v", err)
+	}
+	if v, _ := led.Get(); v != 1 {
+		t.Errorf("Get() after On() got (%d) want (1)", v)
+	}
+	if err := led.Toggle(); err != nil {
+		t.Fatalf("Toggle() error = %v", err)
+	}
+	if v, _ := led.Get(); v != 0 {
+		t.Errorf("Get() after Toggle() got (%d) want (0)", v)
+	}
+
+	low, err := m.Pin("low", 12, gpiocdev.AsInput, gpiocdev.AsActiveLow)
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	m.MockInput(12, 0)
+	if v, _ := low.Get(); v != 1 {
+		t.Errorf("active low Get() got (%d) want (1)", v)
+	}
+}
+
+func TestMCP23017Interrupt(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	m := newMCP(t, "mcp-int")
+
+	var events []gpiocdev.LineEvent
+	handler := func(evt gpiocdev.LineEvent) { events = append(events, evt) }
+
+	if _, err := m.Pin("both", 4, gpiocdev.WithPullUp, gpiocdev.WithEventHandler(handler)); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if _, err := m.Pin("rising", 10, gpiocdev.WithRisingEdge, gpiocdev.WithEventHandler(handler)); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if _, err := m.Pin("quiet", 11, gpiocdev.AsInput); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+
+	m.MockInput(4, 1)
+	m.MockInput(4, 0)
+	m.MockInput(10, 1)
+	m.MockInput(10, 0) // filtered, only rising edges
+	m.MockInput(11, 1) // no interrupt enabled
+
+	want := []struct {
+		offset int
+		typ    gpiocdev.LineEventType
+	}{
+		{4, gpiocdev.LineEventRisingEdge},
+		{4, gpiocdev.LineEventFallingEdge},
+		{10, gpiocdev.LineEventRisingEdge},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events got (%v) want %d events", events, len(want))
+	}
+	for i, w := range want {
+		if events[i].Offset != w.offset || events[i].Type != w.typ {
+			t.Errorf("event %d got (%d %v) want (%d %v)", i, events[i].Offset, events[i].Type, w.offset, w.typ)
+		}
+	}
+
+	// the flags were cleared, nothing is delivered twice
+	if err := m.HandleInterrupt(); err != nil {
+		t.Fatalf("HandleInterrupt() error = %v", err)
+	}
+	if len(events) != len(want) {
+		t.Errorf("HandleInterrupt() delivered cleared events (%d)", len(events)-len(want))
+	}
+}
+
+func TestMCP23017InterruptFlags(t *testing.T) {
+	fake := &mcpFake{I2C: driverstest.NewI2C()}
+	driverstest.UseI2C(t, fake)
+	m := newMCP(t, "mcp-flags")
+
+	var got []int
+	_, err := m.Pin("b", 8, gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+		got = append(got, evt.Offset)
+	}))
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if en := fake.Get(0x05, 1)[0]; en != 0x01 {
+		t.Errorf("GPINTENB got (%#x) want (0x01)", en)
+	}
+
+	// INTFB and INTCAPB flag line 8 high
+	fake.Set(0x0F, 0x01)
+	fake.Set(0x11, 0x01)
+	if err := m.HandleInterrupt(); err != nil {
+		t.Fatalf("HandleInterrupt() error = %v", err)
+	}
+	if len(got) != 1 || got[0] != 8 {
+		t.Errorf("events got (%v) want ([8])", got)
+	}
+	if flags := fake.Get(0x0E, 2); flags[0] != 0 || flags[1] != 0 {
+		t.Errorf("INTF not cleared got (% x)", flags)
+	}
+
+	fake.Err = errors.New("nack")
+	if err := m.HandleInterrupt(); !errors.Is(err, fake.Err) {
+		t.Errorf("HandleInterrupt() error = %v want %v", err, fake.Err)
+	}
+	fake.Err = nil
+}
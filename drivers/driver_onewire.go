@@ -0,0 +1,143 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrOneWireNotFound is returned when no slave with the ID is on the bus
+var ErrOneWireNotFound = errors.New("1-wire device not found")
+
+// w1Root is where the kernel w1 subsystem lists the slaves it found
+// on the bus, on a Raspberry Pi this requires the w1-gpio dtoverlay.
+var (
+	w1Root   = "/sys/bus/w1/devices"
+	w1RootMu sync.RWMutex
+)
+
+// SetOneWireRoot points the 1-Wire driver at dir instead of the
+// kernel's w1 devices and returns a function that restores the
+// previous root, tests use it with canned slave files.
+func SetOneWireRoot(dir string) (restore func()) {
+	w1RootMu.Lock()
+	defer w1RootMu.Unlock()
+	prev := w1Root
+	w1Root = dir
+	return func() {
+		w1RootMu.Lock()
+		defer w1RootMu.Unlock()
+		w1Root = prev
+	}
+}
+
+func oneWireRoot() string {
+	w1RootMu.RLock()
+	defer w1RootMu.RUnlock()
+	return w1Root
+}
+
+// OneWire is a slave on the 1-Wire bus, the kernel's slave driver
+// does the bus timing and exposes the device as files.
+type OneWire struct {
+	ID string `json:"id"` // family and serial, e.g. 28-0316a2795aff
+
+	path string
+}
+
+// OneWireDevices returns the sorted IDs of the slaves on the bus
+// with the given family code, 0 returns every slave
+func OneWireDevices(family byte) ([]string, error) {
+	entries, err := os.ReadDir(oneWireRoot())
+	if err != nil {
+		return nil, fmt.Errorf("1-wire bus: %w", err)
+	}
+
+	var ids []string
+	for _, e := range entries {
+		f, ok := oneWireFamily(e.Name())
+		if !ok || (family != 0 && f != family) {
+			continue
+		}
+		ids = append(ids, e.Name())
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// OpenOneWire returns the slave with the given ID
+func OpenOneWire(id string) (*OneWire, error) {
+	if _, ok := oneWireFamily(id); !ok {
+		return nil, fmt.Errorf("invalid 1-wire id %q", id)
+	}
+	path := filepath.Join(oneWireRoot(), id)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrOneWireNotFound, id)
+	}
+	return &OneWire{ID: id, path: path}, nil
+}
+
+// Family returns the family code of the slave, 0x28 for a DS18B20
+func (w *OneWire) Family() byte {
+	f, _ := oneWireFamily(w.ID)
+	return f
+}
+
+// Has reports if the slave driver exposes the named file
+func (w *OneWire) Has(name string) bool {
+	_, err := os.Stat(filepath.Join(w.path, name))
+	return err == nil
+}
+
+// ReadFile reads one of the files the slave driver exposes
+func (w *OneWire) ReadFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(w.path, name))
+	if err != nil {
+		return nil, fmt.Errorf("1-wire %s: %w", w.ID, err)
+	}
+	return b, nil
+}
+
+// WriteFile writes val to one of the files the slave driver exposes
+func (w *OneWire) WriteFile(name, val string) error {
+	if err := writeSysfs(filepath.Join(w.path, name), val); err != nil {
+		return fmt.Errorf("1-wire %s: %w", w.ID, err)
+	}
+	return nil
+}
+
+// oneWireFamily parses the family code of a slave ID, the bus master
+// entries (w1_bus_master1) are not slaves
+func oneWireFamily(id string) (byte, bool) {
+	fam, serial, ok := strings.Cut(id, "-")
+	if !ok || len(fam) != 2 || serial == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseUint(fam, 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(f), true
+}
+
+// CRC8 is the Dallas/Maxim 1-Wire CRC, a scratchpad or ROM code
+// including its CRC byte sums to 0
+func CRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			mix := (crc ^ b) & 1
+			crc >>= 1
+			if mix != 0 {
+				crc ^= 0x8C
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
@@ -0,0 +1,90 @@
+package drivers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeOneWireBus lays out the w1 devices the way the kernel lists them
+func fakeOneWireBus(t *testing.T, ids ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Cleanup(SetOneWireRoot(root))
+
+	for _, id := range ids {
+		if err := os.MkdirAll(filepath.Join(root, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestOneWireDevices(t *testing.T) {
+	fakeOneWireBus(t, "w1_bus_master1", "28-0316a2795aff", "10-000802b4d1e7", "28-00000a1b2c3d")
+
+	tests := []struct {
+		family byte
+		want   []string
+	}{
+		{0x28, []string{"28-00000a1b2c3d", "28-0316a2795aff"}},
+		{0x10, []string{"10-000802b4d1e7"}},
+		{0, []string{"10-000802b4d1e7", "28-00000a1b2c3d", "28-0316a2795aff"}},
+		{0x3B, nil},
+	}
+	for _, tt := range tests {
+		got, err := OneWireDevices(tt.family)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("OneWireDevices(%#02x) got (%v, %v) want (%v)", tt.family, got, err, tt.want)
+		}
+	}
+}
+
+func TestOneWireFiles(t *testing.T) {
+	fakeOneWireBus(t, "28-0316a2795aff")
+
+	w, err := OpenOneWire("28-0316a2795aff")
+	if err != nil {
+		t.Fatalf("OpenOneWire() error = %v", err)
+	}
+	if w.Family() != 0x28 {
+		t.Errorf("Family() got (%#02x) want (0x28)", w.Family())
+	}
+	if w.Has("resolution") {
+		t.Error("Has(resolution) got (true) want (false)")
+	}
+	if err := w.WriteFile("resolution", "10"); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if b, err := w.ReadFile("resolution"); err != nil || string(b) != "10" {
+		t.Errorf("ReadFile() got (%q, %v) want (10, nil)", b, err)
+	}
+
+	if _, err := OpenOneWire("28-000000000001"); !errors.Is(err, ErrOneWireNotFound) {
+		t.Errorf("OpenOneWire(missing) error got (%v) want (%v)", err, ErrOneWireNotFound)
+	}
+	if _, err := OpenOneWire("w1_bus_master1"); err == nil {
+		t.Error("OpenOneWire(w1_bus_master1) expected an error")
+	}
+}
+
+func TestCRC8(t *testing.T) {
+	tests := []struct {
+		data []byte
+		crc  byte
+	}{
+		{[]byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10}, 0x57},
+		{[]byte{0x50, 0x05, 0x4b, 0x46, 0x7f, 0xff, 0x0c, 0x10}, 0x1c},
+		{[]byte{0x28, 0xff, 0x64, 0x1e, 0x0f, 0x35, 0x2d}, 0x38},
+	}
+	for _, tt := range tests {
+		if got := CRC8(tt.data); got != tt.crc {
+			t.Errorf("CRC8(% x) got (%#02x) want (%#02x)", tt.data, got, tt.crc)
+		}
+		if got := CRC8(append(tt.data, tt.crc)); got != 0 {
+			t.Errorf("CRC8 with the crc byte got (%#02x) want (0)", got)
+		}
+	}
+}
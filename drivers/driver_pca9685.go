@@ -0,0 +1,273 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+)
+
+// PCA9685 registers and bits, see section 7.3 of the datasheet
+const (
+	pcaRegMode1     = 0x00
+	pcaRegMode2     = 0x01
+	pcaRegLED0      = 0x06 // ON_L, ON_H, OFF_L, OFF_H for each channel
+	pcaRegAllLED    = 0xFA
+	pcaRegPrescale  = 0xFE
+	pcaMode1Restart = 0x80
+	pcaMode1AI      = 0x20
+	pcaMode1Sleep   = 0x10
+	pcaMode2Outdrv  = 0x04
+	pcaFull         = 0x10 // full on / full off bit of the _H registers
+
+	// PCA9685Channels is the number of outputs on the chip
+	PCA9685Channels = 16
+
+	// PCA9685Ticks is the resolution of a pwm period
+	PCA9685Ticks = 4096
+
+	pcaOscillator = 25_000_000
+	pcaMinFreq    = 24
+	pcaMaxFreq    = 1526
+)
+
+// ErrInvalidChannel is returned for a channel the chip does not have
+var ErrInvalidChannel = errors.New("pwm: invalid channel")
+
+// PCA9685 is a 16 channel, 12 bit i2c PWM controller. All channels
+// share one frequency. In mock mode nothing is written, the channel
+// settings are still tracked so they can be inspected.
+type PCA9685 struct {
+	Name string
+	Bus  string
+	Addr int
+	Freq float64
+
+	dev   *I2CDevice
+	mock  bool
+	chans [PCA9685Channels]pcaChannel
+	mu    sync.Mutex
+}
+
+// pcaChannel is the on and off tick of a channel, the full on and
+// full off bits are kept in bit 12 like the chip does
+type pcaChannel struct {
+	on, off uint16
+}
+
+// NewPCA9685 opens the PCA9685 at addr on bus (default 0x40), wakes
+// it up with auto increment and totem pole outputs and registers its
+// channels with OpenPWM under name.
+func NewPCA9685(name string, bus string, addr int) (*PCA9685, error) {
+	p := &PCA9685{
+		Name: name,
+		Bus:  bus,
+		Addr: addr,
+	}
+	if device.IsMock() {
+		p.mock = true
+	} else {
+		var err error
+		if p.dev, err = NewI2CDevice(bus, addr); err != nil {
+			return nil, err
+		}
+		err = p.dev.Tx(func(bus I2CBus) error {
+			if err := bus.WriteReg(pcaRegMode2, []byte{pcaMode2Outdrv}); err != nil {
+				return err
+			}
+			return bus.WriteReg(pcaRegMode1, []byte{pcaMode1AI})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pca9685 %s: init: %w", name, err)
+		}
+		// the oscillator needs 500us to start after leaving sleep
+		time.Sleep(500 * time.Microsecond)
+	}
+
+	RegisterPWMChip(name, func(ch int) (PWMChannel, error) {
+		return p.Channel(ch)
+	})
+	return p, nil
+}
+
+// Prescale returns the prescale value for the frequency hz. The chip
+// supports roughly 24Hz to 1526Hz.
+func Prescale(hz float64) (uint8, error) {
+	if hz < pcaMinFreq || hz > pcaMaxFreq {
+		return 0, fmt.Errorf("pca9685: frequency %vHz outside %d - %dHz", hz, pcaMinFreq, pcaMaxFreq)
+	}
+	return uint8(math.Round(pcaOscillator/(PCA9685Ticks*hz)) - 1), nil
+}
+
+// SetFrequency sets the pwm frequency of all channels. The prescaler
+// can only be written while the oscillator sleeps, the chip is put to
+// sleep and restarted so running outputs pick up where they were.
+func (p *PCA9685) SetFrequency(hz float64) error {
+	pre, err := Prescale(hz)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.mock {
+		err = p.dev.Tx(func(bus I2CBus) error {
+			buf := []byte{0}
+			if err := bus.ReadReg(pcaRegMode1, buf); err != nil {
+				return err
+			}
+			old := buf[0] &^ pcaMode1Restart
+			for _, w := range [][2]byte{
+				{pcaRegMode1, old | pcaMode1Sleep},
+				{pcaRegPrescale, pre},
+				{pcaRegMode1, old},
+			} {
+				if err := bus.WriteReg(w[0], w[1:]); err != nil {
+					return err
+				}
+			}
+			time.Sleep(500 * time.Microsecond)
+			return bus.WriteReg(pcaRegMode1, []byte{old | pcaMode1Restart | pcaMode1AI})
+		})
+		if err != nil {
+			return fmt.Errorf("pca9685 %s: set frequency: %w", p.Name, err)
+		}
+	}
+	p.Freq = hz
+	return nil
+}
+
+// SetChannel sets the tick (0 - 4095) the output of ch turns on and
+// the tick it turns off.
+func (p *PCA9685) SetChannel(ch int, onTick, offTick uint16) error {
+	if onTick >= PCA9685Ticks || offTick >= PCA9685Ticks {
+		return fmt.Errorf("pca9685: ticks (%d, %d) must be below %d", onTick, offTick, PCA9685Ticks)
+	}
+	return p.write(ch, pcaChannel{on: onTick, off: offTick})
+}
+
+// SetDuty sets the duty cycle of ch as a fraction 0.0 - 1.0, 0 and 1
+// use the full off and full on bits for a flat output.
+func (p *PCA9685) SetDuty(ch int, fraction float64) error {
+	switch {
+	case fraction < 0 || fraction > 1:
+		return fmt.Errorf("invalid pwm duty: %v", fraction)
+	case fraction == 0:
+		return p.FullOff(ch)
+	case fraction == 1:
+		return p.FullOn(ch)
+	}
+	off := uint16(math.Round(fraction * PCA9685Ticks))
+	if off >= PCA9685Ticks {
+		return p.FullOn(ch)
+	}
+	return p.write(ch, pcaChannel{on: 0, off: off})
+}
+
+// FullOn drives ch high continuously
+func (p *PCA9685) FullOn(ch int) error {
+	return p.write(ch, pcaChannel{on: pcaFull << 8})
+}
+
+// FullOff drives ch low continuously
+func (p *PCA9685) FullOff(ch int) error {
+	return p.write(ch, pcaChannel{off: pcaFull << 8})
+}
+
+// AllOff turns every channel off with a single write
+func (p *PCA9685) AllOff() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.mock {
+		if err := p.dev.Tx(func(bus I2CBus) error {
+			return bus.WriteReg(pcaRegAllLED, []byte{0, 0, 0, pcaFull})
+		}); err != nil {
+			return fmt.Errorf("pca9685 %s: all off: %w", p.Name, err)
+		}
+	}
+	for i := range p.chans {
+		p.chans[i] = pcaChannel{off: pcaFull << 8}
+	}
+	return nil
+}
+
+// Ticks returns the on and off ticks last set on ch, the full on and
+// full off bits are reported in bit 12.
+func (p *PCA9685) Ticks(ch int) (on, off uint16, err error) {
+	if ch < 0 || ch >= PCA9685Channels {
+		return 0, 0, fmt.Errorf("%w: %d", ErrInvalidChannel, ch)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.chans[ch].on, p.chans[ch].off, nil
+}
+
+// write sets the four registers of ch in one transaction
+func (p *PCA9685) write(ch int, c pcaChannel) error {
+	if ch < 0 || ch >= PCA9685Channels {
+		return fmt.Errorf("%w: %d", ErrInvalidChannel, ch)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.mock {
+		buf := []byte{byte(c.on), byte(c.on >> 8), byte(c.off), byte(c.off >> 8)}
+		reg := byte(pcaRegLED0 + 4*ch)
+		if err := p.dev.Tx(func(bus I2CBus) error {
+			return bus.WriteReg(reg, buf)
+		}); err != nil {
+			return fmt.Errorf("pca9685 %s: channel %d: %w", p.Name, ch, err)
+		}
+	}
+	p.chans[ch] = c
+	return nil
+}
+
+// Channel returns ch as a PWMChannel, letting devices that drive a
+// PWMChannel (led brightness, servos) use a PCA9685 output. Note the
+// frequency is shared, setting it on one channel sets all of them.
+func (p *PCA9685) Channel(ch int) (PWMChannel, error) {
+	if ch < 0 || ch >= PCA9685Channels {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidChannel, ch)
+	}
+	return &pcaPWM{pca: p, ch: ch}, nil
+}
+
+// Close turns all outputs off, puts the chip to sleep and removes it
+// from the pwm registry
+func (p *PCA9685) Close() error {
+	RegisterPWMChip(p.Name, nil)
+	err := p.AllOff()
+	if p.mock || p.dev == nil {
+		return err
+	}
+	if serr := p.dev.WriteReg8(pcaRegMode1, pcaMode1Sleep|pcaMode1AI); err == nil {
+		err = serr
+	}
+	if cerr := p.dev.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pcaPWM adapts one PCA9685 output to the PWMChannel interface
+type pcaPWM struct {
+	pca *PCA9685
+	ch  int
+}
+
+func (c *pcaPWM) SetFrequency(hz float64) error {
+	return c.pca.SetFrequency(hz)
+}
+
+func (c *pcaPWM) SetDuty(fraction float64) error {
+	return c.pca.SetDuty(c.ch, fraction)
+}
+
+// Close turns the output off, the chip stays running for the others
+func (c *pcaPWM) Close() error {
+	return c.pca.FullOff(c.ch)
+}
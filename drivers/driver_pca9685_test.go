@@ -0,0 +1,182 @@
+package drivers_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func newPCA(t *testing.T) (*drivers.PCA9685, *driverstest.I2C) {
+	t.Helper()
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	pca, err := drivers.NewPCA9685("pca", "/dev/i2c-1", 0x40)
+	if err != nil {
+		t.Fatalf("NewPCA9685() error = %v", err)
+	}
+	t.Cleanup(func() { pca.Close() })
+	return pca, fake
+}
+
+func TestPCA9685Prescale(t *testing.T) {
+	tests := []struct {
+		hz      float64
+		pre     uint8
+		wantErr bool
+	}{
+		// the 200Hz example of section 7.3.5 of the datasheet
+		{hz: 200, pre: 30},
+		{hz: 50, pre: 121},
+		{hz: 1526, pre: 3},
+		{hz: 24, pre: 253},
+		{hz: 10, wantErr: true},
+		{hz: 2000, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		pre, err := drivers.Prescale(tt.hz)
+		if (err != nil) != tt.wantErr || pre != tt.pre {
+			t.Errorf("Prescale(%v) got (%d, %v) want (%d, err %t)", tt.hz, pre, err, tt.pre, tt.wantErr)
+		}
+	}
+}
+
+func TestPCA9685SetFrequency(t *testing.T) {
+	pca, fake := newPCA(t)
+	fake.Writes = nil
+
+	if err := pca.SetFrequency(200); err != nil {
+		t.Fatalf("SetFrequency() error = %v", err)
+	}
+
+	// sleep, prescale, wake, restart
+	want := []driverstest.Write{
+		{Reg: 0x00, Data: []byte{0x30}},
+		{Reg: 0xFE, Data: []byte{30}},
+		{Reg: 0x00, Data: []byte{0x20}},
+		{Reg: 0x00, Data: []byte{0xA0}},
+	}
+	if len(fake.Writes) != len(want) {
+		t.Fatalf("writes got (%v) want (%v)", fake.Writes, want)
+	}
+	for i, w := range want {
+		got := fake.Writes[i]
+		if got.Reg != w.Reg || !bytes.Equal(got.Data, w.Data) {
+			t.Errorf("write %d got (%#x % x) want (%#x % x)", i, got.Reg, got.Data, w.Reg, w.Data)
+		}
+	}
+	if pca.Freq != 200 {
+		t.Errorf("Freq got (%v) want (200)", pca.Freq)
+	}
+	if err := pca.SetFrequency(5000); err == nil {
+		t.Error("SetFrequency(5000) expected an error")
+	}
+}
+
+func TestPCA9685SetChannel(t *testing.T) {
+	pca, fake := newPCA(t)
+
+	tests := []struct {
+		name    string
+		ch      int
+		on, off uint16
+		regs    []byte
+	}{
+		// examples 1 and 2 of section 7.3.3 of the datasheet
+		{name: "delay 10% duty 20%", ch: 0, on: 409, off: 1228, regs: []byte{0x99, 0x01, 0xCC, 0x04}},
+		{name: "delay 90% duty 90%", ch: 15, on: 3686, off: 3277, regs: []byte{0x66, 0x0E, 0xCD, 0x0C}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := pca.SetChannel(tt.ch, tt.on, tt.off); err != nil {
+				t.Fatalf("SetChannel() error = %v", err)
+			}
+			reg := byte(0x06 + 4*tt.ch)
+			if got := fake.Get(reg, 4); !bytes.Equal(got, tt.regs) {
+				t.Errorf("registers got (% x) want (% x)", got, tt.regs)
+			}
+		})
+	}
+
+	if err := pca.SetChannel(16, 0, 0); !errors.Is(err, drivers.ErrInvalidChannel) {
+		t.Errorf("SetChannel(16) error = %v want %v", err, drivers.ErrInvalidChannel)
+	}
+	if err := pca.SetChannel(0, 0, 4096); err == nil {
+		t.Error("SetChannel() expected an error for tick 4096")
+	}
+}
+
+func TestPCA9685SetDuty(t *testing.T) {
+	pca, fake := newPCA(t)
+
+	tests := []struct {
+		duty float64
+		regs []byte
+	}{
+		{duty: 0.25, regs: []byte{0x00, 0x00, 0x00, 0x04}},
+		{duty: 0.5, regs: []byte{0x00, 0x00, 0x00, 0x08}},
+		{duty: 0, regs: []byte{0x00, 0x00, 0x00, 0x10}},
+		{duty: 1, regs: []byte{0x00, 0x10, 0x00, 0x00}},
+		{duty: 0.99999, regs: []byte{0x00, 0x10, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		if err := pca.SetDuty(3, tt.duty); err != nil {
+			t.Fatalf("SetDuty(%v) error = %v", tt.duty, err)
+		}
+		if got := fake.Get(0x06+12, 4); !bytes.Equal(got, tt.regs) {
+			t.Errorf("SetDuty(%v) registers got (% x) want (% x)", tt.duty, got, tt.regs)
+		}
+	}
+	if err := pca.SetDuty(3, 1.5); err == nil {
+		t.Error("SetDuty(1.5) expected an error")
+	}
+
+	if err := pca.AllOff(); err != nil {
+		t.Fatalf("AllOff() error = %v", err)
+	}
+	if got := fake.Get(0xFA, 4); !bytes.Equal(got, []byte{0, 0, 0, 0x10}) {
+		t.Errorf("ALL_LED registers got (% x)", got)
+	}
+	if on, off, _ := pca.Ticks(3); on != 0 || off != 0x1000 {
+		t.Errorf("Ticks() got (%#x, %#x) want (0, 0x1000)", on, off)
+	}
+}
+
+func TestPCA9685OpenPWM(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	pca, err := drivers.NewPCA9685("pca-mock", "/dev/i2c-1", 0x41)
+	if err != nil {
+		t.Fatalf("NewPCA9685() error = %v", err)
+	}
+
+	pwm, err := drivers.OpenPWM("pca-mock", 7)
+	if err != nil {
+		t.Fatalf("OpenPWM() error = %v", err)
+	}
+	if err := pwm.SetFrequency(50); err != nil {
+		t.Errorf("SetFrequency() error = %v", err)
+	}
+	if err := pwm.SetDuty(0.075); err != nil {
+		t.Errorf("SetDuty() error = %v", err)
+	}
+	if on, off, _ := pca.Ticks(7); on != 0 || off != 307 {
+		t.Errorf("Ticks() got (%d, %d) want (0, 307)", on, off)
+	}
+	if pca.Freq != 50 {
+		t.Errorf("Freq got (%v) want (50)", pca.Freq)
+	}
+
+	pca.Close()
+	if _, err := drivers.OpenPWM("pca-mock", 7); err == nil {
+		t.Error("OpenPWM() expected an error after Close()")
+	}
+}
@@ -0,0 +1,111 @@
+package drivers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pwmSysfsRoot is where the kernel exports pwmchips
+var pwmSysfsRoot = "/sys/class/pwm"
+
+// SysfsPWM is a hardware PWM channel exported by the kernel under
+// /sys/class/pwm/<chip>/pwm<channel>. On a Raspberry Pi this requires
+// the pwm (or pwm-2chan) dtoverlay.
+type SysfsPWM struct {
+	Chip    string  `json:"chip"`
+	Channel int     `json:"channel"`
+	Freq    float64 `json:"frequency"`
+	Duty    float64 `json:"duty"`
+
+	path   string
+	period time.Duration
+	mu     sync.Mutex
+}
+
+// NewSysfsPWM exports (if needed) the channel of the given pwmchip
+func NewSysfsPWM(chip string, channel int) (*SysfsPWM, error) {
+	chipPath := filepath.Join(pwmSysfsRoot, chip)
+	if _, err := os.Stat(chipPath); err != nil {
+		return nil, fmt.Errorf("pwm chip %s: %w", chip, err)
+	}
+
+	p := &SysfsPWM{
+		Chip:    chip,
+		Channel: channel,
+		path:    filepath.Join(chipPath, "pwm"+strconv.Itoa(channel)),
+	}
+	if _, err := os.Stat(p.path); os.IsNotExist(err) {
+		if err := writeSysfs(filepath.Join(chipPath, "export"), strconv.Itoa(channel)); err != nil {
+			return nil, fmt.Errorf("pwm export %s/%d: %w", chip, channel, err)
+		}
+	}
+	return p, nil
+}
+
+// SetFrequency sets the period of the pwm signal, the duty cycle
+// fraction is preserved.
+func (p *SysfsPWM) SetFrequency(hz float64) error {
+	if hz <= 0 {
+		return fmt.Errorf("invalid pwm frequency: %v", hz)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	period := time.Duration(float64(time.Second) / hz)
+
+	// the kernel rejects a period shorter than the current duty cycle
+	if err := p.writeDuty(0); err != nil {
+		return err
+	}
+	if err := writeSysfs(filepath.Join(p.path, "period"), strconv.FormatInt(period.Nanoseconds(), 10)); err != nil {
+		return err
+	}
+	p.period = period
+	p.Freq = hz
+	return p.writeDuty(p.Duty)
+}
+
+// SetDuty sets the duty cycle as a fraction of the period and
+// enables the output.
+func (p *SysfsPWM) SetDuty(fraction float64) error {
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("invalid pwm duty: %v", fraction)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.period == 0 {
+		return fmt.Errorf("pwm %s/%d: frequency not set", p.Chip, p.Channel)
+	}
+	if err := p.writeDuty(fraction); err != nil {
+		return err
+	}
+	p.Duty = fraction
+	return writeSysfs(filepath.Join(p.path, "enable"), "1")
+}
+
+// Close disables the output and unexports the channel
+func (p *SysfsPWM) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := writeSysfs(filepath.Join(p.path, "enable"), "0"); err != nil {
+		return err
+	}
+	return writeSysfs(filepath.Join(pwmSysfsRoot, p.Chip, "unexport"), strconv.Itoa(p.Channel))
+}
+
+func (p *SysfsPWM) writeDuty(fraction float64) error {
+	ns := int64(float64(p.period.Nanoseconds()) * fraction)
+	return writeSysfs(filepath.Join(p.path, "duty_cycle"), strconv.FormatInt(ns, 10))
+}
+
+func writeSysfs(path string, val string) error {
+	return os.WriteFile(path, []byte(val), 0644)
+}
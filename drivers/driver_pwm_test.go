@@ -0,0 +1,80 @@
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakePWMChip lays out a pwmchip the way the kernel exports it
+func fakePWMChip(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	old := pwmSysfsRoot
+	pwmSysfsRoot = root
+	t.Cleanup(func() { pwmSysfsRoot = old })
+
+	chan0 := filepath.Join(root, "pwmchip0", "pwm0")
+	if err := os.MkdirAll(chan0, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"period", "duty_cycle", "enable"} {
+		if err := os.WriteFile(filepath.Join(chan0, f), []byte("0"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chan0
+}
+
+func readSysfs(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSysfsPWM(t *testing.T) {
+	dir := fakePWMChip(t)
+
+	p, err := NewSysfsPWM("pwmchip0", 0)
+	if err != nil {
+		t.Fatalf("NewSysfsPWM() error = %v", err)
+	}
+
+	if err := p.SetDuty(0.5); err == nil {
+		t.Error("expected an error setting duty before the frequency")
+	}
+	if err := p.SetFrequency(1000); err != nil {
+		t.Fatalf("SetFrequency() error = %v", err)
+	}
+	if err := p.SetDuty(0.25); err != nil {
+		t.Fatalf("SetDuty() error = %v", err)
+	}
+
+	if got := readSysfs(t, filepath.Join(dir, "period")); got != "1000000" {
+		t.Errorf("period got (%s) want (1000000)", got)
+	}
+	if got := readSysfs(t, filepath.Join(dir, "duty_cycle")); got != "250000" {
+		t.Errorf("duty_cycle got (%s) want (250000)", got)
+	}
+	if got := readSysfs(t, filepath.Join(dir, "enable")); got != "1" {
+		t.Errorf("enable got (%s) want (1)", got)
+	}
+
+	// changing the frequency keeps the duty fraction
+	if err := p.SetFrequency(2000); err != nil {
+		t.Fatalf("SetFrequency() error = %v", err)
+	}
+	if got := readSysfs(t, filepath.Join(dir, "duty_cycle")); got != "125000" {
+		t.Errorf("duty_cycle got (%s) want (125000)", got)
+	}
+
+	if err := p.SetDuty(1.5); err == nil {
+		t.Error("expected an error for duty > 1")
+	}
+	if _, err := NewSysfsPWM("pwmchip9", 0); err == nil {
+		t.Error("expected an error for a missing pwm chip")
+	}
+}
@@ -0,0 +1,310 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/warthog618/go-gpiocdev"
+	"golang.org/x/exp/io/spi"
+)
+
+// I2CBus is a single device at a fixed address on an I2C bus. The
+// kernel backed golang.org/x/exp/io/i2c Device satisfies it.
+type I2CBus interface {
+	Read(buf []byte) error
+	Write(buf []byte) error
+	ReadReg(reg byte, buf []byte) error
+	WriteReg(reg byte, buf []byte) error
+	Close() error
+}
+
+// GPIOChip hands out the lines of a single gpiochip
+type GPIOChip interface {
+	RequestLine(offset int, opts ...gpiocdev.LineReqOption) (Line, error)
+	Close() error
+}
+
+// PWMChannel is a single PWM output, duty is a fraction 0.0 - 1.0
+type PWMChannel interface {
+	SetFrequency(hz float64) error
+	SetDuty(fraction float64) error
+	Close() error
+}
+
+// SPIConn is a device on an SPI bus, Tx clocks w out and what comes
+// back into r, r may be nil. The kernel backed golang.org/x/exp/io/spi
+// Device satisfies it.
+type SPIConn interface {
+	Tx(w, r []byte) error
+	Close() error
+}
+
+// I2CProvider opens the device at addr on the given bus
+type I2CProvider func(bus string, addr int) (I2CBus, error)
+
+// GPIOProvider opens the named gpiochip
+type GPIOProvider func(chip string) (GPIOChip, error)
+
+// PWMProvider opens a channel of the named pwmchip
+type PWMProvider func(chip string, channel int) (PWMChannel, error)
+
+// SPIProvider opens the spidev device dev in SPI mode 0 - 3 clocked
+// at hz
+type SPIProvider func(dev string, mode, hz int) (SPIConn, error)
+
+// registry holds the providers device packages resolve their
+// hardware through. The defaults are kernel backed, tests swap them
+// out with the Set functions below.
+var registry = struct {
+	mu   sync.RWMutex
+	i2c  I2CProvider
+	gpio GPIOProvider
+	pwm  PWMProvider
+	spi  SPIProvider
+
+	// i2cBuses, gpioChips and pwmChips are controllers that are not
+	// kernel devices, like a bit-banged bus, an MCP23017 or a
+	// PCA9685. They are looked up by name before the providers.
+	i2cBuses  map[string]func(addr int) (I2CBus, error)
+	gpioChips map[string]GPIOChip
+	pwmChips  map[string]func(channel int) (PWMChannel, error)
+}{
+	i2c:       kernelI2C,
+	gpio:      kernelGPIO,
+	pwm:       kernelPWM,
+	spi:       kernelSPI,
+	i2cBuses:  make(map[string]func(addr int) (I2CBus, error)),
+	gpioChips: make(map[string]GPIOChip),
+	pwmChips:  make(map[string]func(channel int) (PWMChannel, error)),
+}
+
+// SetI2CProvider replaces the I2C provider and returns a function
+// that restores the previous one, suitable for t.Cleanup(). A nil
+// provider restores the kernel backed default.
+func SetI2CProvider(p I2CProvider) (restore func()) {
+	if p == nil {
+		p = kernelI2C
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	prev := registry.i2c
+	registry.i2c = p
+	return func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		registry.i2c = prev
+	}
+}
+
+// CurrentI2CProvider returns the I2C provider in use, for one wrapping
+// it like a recorder of the transfers
+func CurrentI2CProvider() I2CProvider {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.i2c
+}
+
+// SetGPIOProvider replaces the GPIO provider and returns a function
+// that restores the previous one. A nil provider restores the kernel
+// backed default.
+func SetGPIOProvider(p GPIOProvider) (restore func()) {
+	if p == nil {
+		p = kernelGPIO
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	prev := registry.gpio
+	registry.gpio = p
+	return func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		registry.gpio = prev
+	}
+}
+
+// SetPWMProvider replaces the PWM provider and returns a function
+// that restores the previous one. A nil provider restores the sysfs
+// backed default.
+func SetPWMProvider(p PWMProvider) (restore func()) {
+	if p == nil {
+		p = kernelPWM
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	prev := registry.pwm
+	registry.pwm = p
+	return func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		registry.pwm = prev
+	}
+}
+
+// SetSPIProvider replaces the SPI provider and returns a function
+// that restores the previous one. A nil provider restores the spidev
+// backed default.
+func SetSPIProvider(p SPIProvider) (restore func()) {
+	if p == nil {
+		p = kernelSPI
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	prev := registry.spi
+	registry.spi = p
+	return func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		registry.spi = prev
+	}
+}
+
+// RegisterI2CBus makes the devices of an I2C bus that is not a
+// kernel i2c-dev available to OpenI2C under name, device packages
+// then use it by bus name like /dev/i2c-1. A nil open removes it.
+func RegisterI2CBus(name string, open func(addr int) (I2CBus, error)) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if open == nil {
+		delete(registry.i2cBuses, name)
+		return
+	}
+	registry.i2cBuses[name] = open
+}
+
+// OpenI2C resolves the device at addr on bus through the registry,
+// buses added with RegisterI2CBus take precedence over the i2c
+// provider.
+func OpenI2C(bus string, addr int) (I2CBus, error) {
+	registry.mu.RLock()
+	p := registry.i2c
+	open, ex := registry.i2cBuses[bus]
+	registry.mu.RUnlock()
+	if ex {
+		return open(addr)
+	}
+	return p(bus, addr)
+}
+
+// RegisterGPIOChip makes chip available to OpenGPIOChip under name,
+// pins can then be requested from it with WithChip(name) like from
+// any other gpiochip, in mock mode too. A nil chip removes it.
+func RegisterGPIOChip(name string, chip GPIOChip) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if chip == nil {
+		delete(registry.gpioChips, name)
+		return
+	}
+	registry.gpioChips[name] = chip
+}
+
+// OpenGPIOChip resolves the named gpiochip through the registry,
+// chips added with RegisterGPIOChip take precedence over the gpio
+// provider.
+func OpenGPIOChip(chip string) (GPIOChip, error) {
+	if c, ex := registeredGPIOChip(chip); ex {
+		return c, nil
+	}
+	registry.mu.RLock()
+	p := registry.gpio
+	registry.mu.RUnlock()
+	return p(chip)
+}
+
+func registeredGPIOChip(name string) (GPIOChip, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	c, ex := registry.gpioChips[name]
+	return c, ex
+}
+
+// RegisterPWMChip makes the channels of a PWM controller available
+// to OpenPWM under name, so devices can select it as their PWM
+// backend instead of a kernel pwmchip. A nil open removes the chip.
+func RegisterPWMChip(name string, open func(channel int) (PWMChannel, error)) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if open == nil {
+		delete(registry.pwmChips, name)
+		return
+	}
+	registry.pwmChips[name] = open
+}
+
+// OpenPWM resolves a pwm channel through the registry, chips added
+// with RegisterPWMChip take precedence over the pwm provider.
+func OpenPWM(chip string, channel int) (PWMChannel, error) {
+	registry.mu.RLock()
+	p := registry.pwm
+	open, ex := registry.pwmChips[chip]
+	registry.mu.RUnlock()
+	if ex {
+		return open(channel)
+	}
+	return p(chip, channel)
+}
+
+// OpenSPI opens the spidev device dev, like /dev/spidev0.0, in SPI
+// mode 0 - 3 clocked at hz through the spi provider
+func OpenSPI(dev string, mode, hz int) (SPIConn, error) {
+	if mode < 0 || mode > 3 {
+		return nil, fmt.Errorf("spi %s: invalid mode %d", dev, mode)
+	}
+	registry.mu.RLock()
+	p := registry.spi
+	registry.mu.RUnlock()
+	return p(dev, mode, hz)
+}
+
+func kernelI2C(bus string, addr int) (I2CBus, error) {
+	d, err := GetI2CDriver(bus, addr)
+	if err != nil {
+		return nil, ClassifyI2CError(bus, addr, err)
+	}
+	return d, nil
+}
+
+// kernelChip requests lines from the gpio character device
+type kernelChip struct {
+	name string
+}
+
+func kernelGPIO(chip string) (GPIOChip, error) {
+	if err := gpiocdev.IsChip(chip); err != nil {
+		return nil, fmt.Errorf("%w: %s (available: %s): %v", ErrChipNotFound, chip, strings.Join(gpiocdev.Chips(), ", "), err)
+	}
+	return &kernelChip{name: chip}, nil
+}
+
+func (c *kernelChip) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (Line, error) {
+	line, err := gpiocdev.RequestLine(c.name, offset, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+func (c *kernelChip) Close() error {
+	return nil
+}
+
+func kernelPWM(chip string, channel int) (PWMChannel, error) {
+	p, err := NewSysfsPWM(chip, channel)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func kernelSPI(dev string, mode, hz int) (SPIConn, error) {
+	d, err := spi.Open(&spi.Devfs{Dev: dev, Mode: spi.Mode(mode), MaxSpeed: int64(hz)})
+	if err != nil {
+		return nil, fmt.Errorf("spi %s: %w", dev, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,108 @@
+package drivers_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestI2CProvider(t *testing.T) {
+	fake := driverstest.NewI2C()
+	fake.Set(0xD0, 0x60)
+
+	restore := drivers.SetI2CProvider(func(bus string, addr int) (drivers.I2CBus, error) {
+		if bus != "/dev/i2c-1" || addr != 0x76 {
+			t.Errorf("provider got (%s, %#x) want (/dev/i2c-1, 0x76)", bus, addr)
+		}
+		return fake, nil
+	})
+
+	bus, err := drivers.OpenI2C("/dev/i2c-1", 0x76)
+	if err != nil {
+		t.Fatalf("OpenI2C() error = %v", err)
+	}
+	buf := make([]byte, 1)
+	if err := bus.ReadReg(0xD0, buf); err != nil || buf[0] != 0x60 {
+		t.Errorf("ReadReg(0xD0) got (%#x, %v) want (0x60, nil)", buf[0], err)
+	}
+
+	restore()
+	errBus := errors.New("no bus")
+	restore = drivers.SetI2CProvider(func(string, int) (drivers.I2CBus, error) {
+		return nil, errBus
+	})
+	defer restore()
+	if _, err := drivers.OpenI2C("/dev/i2c-1", 0x76); !errors.Is(err, errBus) {
+		t.Errorf("OpenI2C() error = %v want %v", err, errBus)
+	}
+}
+
+func TestGPIOProvider(t *testing.T) {
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	g, err := drivers.GetGPIOChip("gpiochip0")
+	if err != nil {
+		t.Fatalf("GetGPIOChip() error = %v", err)
+	}
+	p := g.Pin("led", 5, gpiocdev.AsOutput(0))
+	if err := p.On(); err != nil {
+		t.Fatalf("On() error = %v", err)
+	}
+	if err := p.Off(); err != nil {
+		t.Fatalf("Off() error = %v", err)
+	}
+
+	line := chip.Line(5)
+	if line == nil {
+		t.Fatal("pin did not request its line through the provider")
+	}
+	if len(line.Values) != 2 || line.Values[0] != 1 || line.Values[1] != 0 {
+		t.Errorf("line values got (%v) want ([1 0])", line.Values)
+	}
+}
+
+func TestPWMProvider(t *testing.T) {
+	fake := driverstest.NewPWM()
+	driverstest.UsePWM(t, fake)
+
+	pwm, err := drivers.OpenPWM("pwmchip0", 0)
+	if err != nil {
+		t.Fatalf("OpenPWM() error = %v", err)
+	}
+	pwm.SetFrequency(1000)
+	pwm.SetDuty(0.25)
+	if fake.Freq != 1000 || fake.Duty != 0.25 {
+		t.Errorf("pwm got (%v Hz, %v) want (1000 Hz, 0.25)", fake.Freq, fake.Duty)
+	}
+}
+
+func TestSPIProvider(t *testing.T) {
+	fake := driverstest.NewSPI()
+	driverstest.UseSPI(t, fake)
+
+	spi, err := drivers.OpenSPI("/dev/spidev0.0", 3, 1000000)
+	if err != nil {
+		t.Fatalf("OpenSPI() error = %v", err)
+	}
+	r := []byte{0xFF, 0xFF}
+	spi.Tx([]byte{0x01, 0x02}, r)
+	spi.Tx([]byte{0x03}, nil)
+	got := fake.Transcript()
+	if fake.Dev != "/dev/spidev0.0" || fake.Mode != 3 || fake.Hz != 1000000 {
+		t.Errorf("opened (%s, mode %d, %d Hz) want (/dev/spidev0.0, mode 3, 1000000 Hz)", fake.Dev, fake.Mode, fake.Hz)
+	}
+	if len(got) != 2 || string(got[0]) != "\x01\x02" || string(got[1]) != "\x03" || r[0] != 0 {
+		t.Errorf("transcript got (%x) read (%x) want ([0102 03]) read zeros", got, r)
+	}
+	spi.Close()
+	if err := spi.Tx([]byte{0x04}, nil); !errors.Is(err, driverstest.ErrClosed) {
+		t.Errorf("Tx() after Close() error got (%v) want (%v)", err, driverstest.ErrClosed)
+	}
+	if _, err := drivers.OpenSPI("/dev/spidev0.0", 4, 1000000); err == nil {
+		t.Error("OpenSPI(mode 4) error got (nil)")
+	}
+}
@@ -3,7 +3,7 @@ package drivers
 import (
 	"log/slog"
 
-	"github.com/rustyeddy/otto/device"
+	device "github.com/rustyeddy/otto-devices"
 	"go.bug.st/serial"
 )
 
@@ -0,0 +1,302 @@
+package drivers
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// SoftPWMMaxFreq caps the soft PWM frequency. Above a couple of
+	// kHz the timer jitter becomes a large part of the period and
+	// the duty cycle stops meaning much.
+	SoftPWMMaxFreq = 2000
+
+	// DefaultSoftPWMFreq is fast enough that an LED does not flicker
+	DefaultSoftPWMFreq = 200
+)
+
+// SoftPWM drives a PWM signal on any output DigitalPin for boards
+// without a spare hardware channel. It satisfies PWMChannel.
+//
+// All soft PWM pins share a single scheduler goroutine that sleeps
+// until the next edge of any pin, there is no per pin busy loop and
+// no goroutine at all while every pin is flat (duty 0 or 1).
+//
+// The edges are timed by the Go runtime timers so they land late by
+// the timer jitter, typically tens of microseconds and more under
+// load or on a slow board. Jitter() reports what was measured on the
+// running system. It is fine for LED dimming and for servos that
+// tolerate a little twitch, use hardware PWM or a PCA9685 for more.
+type SoftPWM struct {
+	Pin *DigitalPin
+
+	// Idle is the level the pin is left at by Close
+	Idle int
+
+	freq float64
+	duty float64
+
+	// latched at the start of every period so duty and frequency
+	// changes never cut a pulse short
+	period time.Duration
+	high   time.Duration
+
+	level int
+	start time.Time
+	next  time.Time
+	index int // position in the scheduler heap, -1 if not scheduled
+
+	edges   int64
+	lateSum time.Duration
+	lateMax time.Duration
+	closed  bool
+
+	sched *softScheduler
+}
+
+// NewSoftPWM starts a soft PWM at frequency hz on pin, the pin must
+// be an output. The duty cycle starts at 0.
+func NewSoftPWM(pin *DigitalPin, hz float64) (*SoftPWM, error) {
+	p := &SoftPWM{
+		Pin:   pin,
+		index: -1,
+		sched: softPWMs,
+	}
+	if err := p.SetFrequency(hz); err != nil {
+		return nil, err
+	}
+	if err := pin.Set(0); err != nil {
+		return nil, fmt.Errorf("soft pwm %s: %w", pin.PinName(), err)
+	}
+	return p, nil
+}
+
+// SetFrequency sets the frequency, it takes effect with the next
+// period.
+func (p *SoftPWM) SetFrequency(hz float64) error {
+	if hz <= 0 || hz > SoftPWMMaxFreq {
+		return fmt.Errorf("soft pwm frequency %vHz outside 0 - %dHz", hz, SoftPWMMaxFreq)
+	}
+
+	p.sched.mu.Lock()
+	defer p.sched.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.freq = hz
+	return nil
+}
+
+// SetDuty sets the duty cycle as a fraction 0.0 - 1.0. A running
+// signal picks the change up at the start of the next period, 0 and
+// 1 leave the pin flat and stop scheduling it.
+func (p *SoftPWM) SetDuty(fraction float64) error {
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("invalid pwm duty: %v", fraction)
+	}
+
+	s := p.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.duty = fraction
+
+	if p.index >= 0 {
+		return nil
+	}
+	if fraction == 0 || fraction == 1 {
+		return p.set(int(fraction))
+	}
+	// start a period right away
+	p.level = 0
+	p.next = s.now()
+	s.push(p)
+	return nil
+}
+
+// Duty returns the duty cycle last set
+func (p *SoftPWM) Duty() float64 {
+	p.sched.mu.Lock()
+	defer p.sched.mu.Unlock()
+	return p.duty
+}
+
+// Jitter returns the mean and the worst lateness of the edges so far
+func (p *SoftPWM) Jitter() (mean, max time.Duration) {
+	p.sched.mu.Lock()
+	defer p.sched.mu.Unlock()
+	if p.edges == 0 {
+		return 0, 0
+	}
+	return p.lateSum / time.Duration(p.edges), p.lateMax
+}
+
+// Close stops the signal and leaves the pin at the Idle level, the
+// pin itself stays open.
+func (p *SoftPWM) Close() error {
+	s := p.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.index >= 0 {
+		heap.Remove(&s.pwms, p.index)
+	}
+	return p.set(p.Idle)
+}
+
+// edge makes the edge that is due at p.next, now is when it actually
+// happens. It reports false when the pin went flat and should no
+// longer be scheduled. The caller holds the scheduler lock.
+func (p *SoftPWM) edge(now time.Time) bool {
+	late := now.Sub(p.next)
+	p.edges++
+	p.lateSum += late
+	if late > p.lateMax {
+		p.lateMax = late
+	}
+
+	if p.level == 1 {
+		p.set(0)
+		p.next = p.start.Add(p.period)
+		return true
+	}
+
+	// a new period, latch the settings
+	if p.duty == 0 || p.duty == 1 {
+		p.set(int(p.duty))
+		return false
+	}
+	p.start = p.next
+	if now.Sub(p.start) > p.period {
+		// we fell more than a period behind, resync instead of
+		// bursting through the missed periods
+		p.start = now
+	}
+	p.period = time.Duration(float64(time.Second) / p.freq)
+	p.high = time.Duration(float64(p.period) * p.duty)
+	p.set(1)
+	p.next = p.start.Add(p.high)
+	return true
+}
+
+func (p *SoftPWM) set(v int) error {
+	p.level = v
+	err := p.Pin.Set(v)
+	if err != nil {
+		slog.Error("soft pwm", "pin", p.Pin.PinName(), "error", err)
+	}
+	return err
+}
+
+// softScheduler runs the edges of every soft PWM from one goroutine
+// ordered by a heap on the time of their next edge.
+type softScheduler struct {
+	pwms    softHeap
+	running bool
+	manual  bool // tests drive runDue themselves
+	now     func() time.Time
+	wake    chan struct{}
+	mu      sync.Mutex
+}
+
+var softPWMs = newSoftScheduler()
+
+func newSoftScheduler() *softScheduler {
+	return &softScheduler{now: time.Now, wake: make(chan struct{}, 1)}
+}
+
+// push schedules p, starting the goroutine if needed. The caller
+// holds the lock.
+func (s *softScheduler) push(p *SoftPWM) {
+	heap.Push(&s.pwms, p)
+	if s.manual {
+		return
+	}
+	if !s.running {
+		s.running = true
+		go s.run()
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *softScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		next, ok := s.runDue(s.now())
+		if !ok {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+	}
+}
+
+// runDue makes every edge due at now and returns the time of the
+// next one, ok is false when nothing is scheduled. The caller holds
+// the lock.
+func (s *softScheduler) runDue(now time.Time) (next time.Time, ok bool) {
+	for len(s.pwms) > 0 && !s.pwms[0].next.After(now) {
+		p := s.pwms[0]
+		if p.edge(now) {
+			heap.Fix(&s.pwms, 0)
+		} else {
+			heap.Pop(&s.pwms)
+		}
+	}
+	if len(s.pwms) == 0 {
+		return time.Time{}, false
+	}
+	return s.pwms[0].next, true
+}
+
+// softHeap implements heap.Interface ordered by the next edge
+type softHeap []*SoftPWM
+
+func (h softHeap) Len() int           { return len(h) }
+func (h softHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+func (h softHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *softHeap) Push(x any) {
+	p := x.(*SoftPWM)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *softHeap) Pop() any {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	p.index = -1
+	*h = old[:n-1]
+	return p
+}
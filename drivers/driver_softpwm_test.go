@@ -0,0 +1,232 @@
+package drivers
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// recLine records the values written in a Waveform of its clock
+type recLine struct {
+	wave *Waveform
+	val  int
+	mu   sync.Mutex
+}
+
+func (l *recLine) Close() error                                   { return nil }
+func (l *recLine) Offset() int                                    { return 0 }
+func (l *recLine) Reconfigure(...gpiocdev.LineConfigOption) error { return nil }
+
+func (l *recLine) Value() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.val, nil
+}
+
+func (l *recLine) SetValue(v int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.val = v
+	l.wave.Record(v)
+	return nil
+}
+
+// dutyOf returns the fraction of time the line was high over the
+// whole periods between the first and the last rising edge
+func (l *recLine) dutyOf() float64 {
+	edges := l.wave.Edges(1)
+	if len(edges) == 0 {
+		return 0
+	}
+	return l.wave.Duty(edges[0], edges[len(edges)-1])
+}
+
+func newSoftPin(clock func() time.Time) (*DigitalPin, *recLine) {
+	line := &recLine{wave: NewWaveform(0, clock)}
+	return &DigitalPin{name: "soft", Line: line}, line
+}
+
+func TestSoftPWMDuty(t *testing.T) {
+	tests := []struct {
+		hz   float64
+		duty float64
+	}{
+		{hz: 100, duty: 0.25},
+		{hz: 200, duty: 0.5},
+		{hz: 2000, duty: 0.1},
+		{hz: 50, duty: 0.9},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(0, 0)
+		pin, line := newSoftPin(func() time.Time { return now })
+		sched := newSoftScheduler()
+		sched.manual = true
+		sched.now = func() time.Time { return now }
+
+		p := &SoftPWM{Pin: pin, index: -1, sched: sched}
+		if err := p.SetFrequency(tt.hz); err != nil {
+			t.Fatalf("SetFrequency() error = %v", err)
+		}
+		sched.mu.Lock()
+		p.duty = tt.duty
+		p.next = now
+		sched.push(p)
+		sched.mu.Unlock()
+
+		// run 20 periods landing every edge exactly on time
+		for i := 0; i < 40; i++ {
+			next, ok := sched.runDue(now)
+			if !ok {
+				t.Fatal("runDue() nothing scheduled")
+			}
+			now = next
+		}
+
+		if got := line.dutyOf(); math.Abs(got-tt.duty) > 0.001 {
+			t.Errorf("%vHz duty got (%f) want (%f)", tt.hz, got, tt.duty)
+		}
+		if n := len(line.wave.Transitions()); n != 40 {
+			t.Errorf("%vHz transitions got (%d) want (40)\nwaveform %s", tt.hz, n, line.wave)
+		}
+		if got := line.wave.Frequency(0, time.Hour); math.Abs(got-tt.hz) > 1e-6 {
+			t.Errorf("%vHz frequency got (%v)", tt.hz, got)
+		}
+		if mean, max := p.Jitter(); mean != 0 || max != 0 {
+			t.Errorf("Jitter() got (%v, %v) want (0, 0)", mean, max)
+		}
+	}
+}
+
+func TestSoftPWMLatch(t *testing.T) {
+	now := time.Unix(0, 0)
+	pin, line := newSoftPin(func() time.Time { return now })
+	sched := newSoftScheduler()
+	sched.manual = true
+	sched.now = func() time.Time { return now }
+
+	p := &SoftPWM{Pin: pin, index: -1, sched: sched}
+	p.SetFrequency(100)
+	p.SetDuty(0.5)
+
+	// rising edge, then change the duty half way through the pulse
+	next, _ := sched.runDue(now)
+	now = now.Add(2 * time.Millisecond)
+	p.SetDuty(0.2)
+	sched.runDue(now)
+	if next != time.Unix(0, 0).Add(5*time.Millisecond) {
+		t.Errorf("pulse cut short, falling edge at (%v) want (5ms)", next.Sub(time.Unix(0, 0)))
+	}
+
+	// the next period uses the new duty
+	for i := 0; i < 2; i++ {
+		now = next
+		next, _ = sched.runDue(now)
+	}
+	if d := next.Sub(now); d != 2*time.Millisecond {
+		t.Errorf("new pulse got (%v) want (2ms)", d)
+	}
+
+	// flat duty stops scheduling at the next period
+	p.SetDuty(1)
+	for i := 0; i < 2; i++ {
+		now = next
+		next, _ = sched.runDue(now)
+	}
+	if len(sched.pwms) != 0 {
+		t.Error("duty 1 still scheduled")
+	}
+	if v, _ := line.Value(); v != 1 {
+		t.Errorf("duty 1 level got (%d) want (1)", v)
+	}
+
+	p.Idle = 0
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if v, _ := line.Value(); v != 0 {
+		t.Errorf("level after Close() got (%d) want (0)", v)
+	}
+	if err := p.SetDuty(0.5); err != ErrClosed {
+		t.Errorf("SetDuty() after Close() error = %v want %v", err, ErrClosed)
+	}
+}
+
+func TestSoftPWMResync(t *testing.T) {
+	now := time.Unix(0, 0)
+	pin, _ := newSoftPin(func() time.Time { return now })
+	sched := newSoftScheduler()
+	sched.manual = true
+	sched.now = func() time.Time { return now }
+
+	p := &SoftPWM{Pin: pin, index: -1, sched: sched}
+	p.SetFrequency(1000)
+	p.SetDuty(0.5)
+	next, _ := sched.runDue(now)
+
+	// a 10ms stall, the missed periods are skipped not replayed
+	now = now.Add(10 * time.Millisecond)
+	next, _ = sched.runDue(now)
+	next, _ = sched.runDue(next)
+	if d := next.Sub(now); d > time.Millisecond+500*time.Microsecond {
+		t.Errorf("did not resync, next edge %v after the stall", d)
+	}
+	if _, max := p.Jitter(); max < 9*time.Millisecond {
+		t.Errorf("Jitter() max got (%v) want >= 9ms", max)
+	}
+}
+
+func TestSoftPWMErrors(t *testing.T) {
+	pin, _ := newSoftPin(time.Now)
+	if _, err := NewSoftPWM(pin, 5000); err == nil {
+		t.Error("NewSoftPWM(5000Hz) expected an error")
+	}
+	p, err := NewSoftPWM(pin, 100)
+	if err != nil {
+		t.Fatalf("NewSoftPWM() error = %v", err)
+	}
+	if err := p.SetDuty(-0.1); err == nil {
+		t.Error("SetDuty(-0.1) expected an error")
+	}
+	var _ PWMChannel = p
+}
+
+func TestSoftPWMRealtime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+
+	var pins []*recLine
+	var pwms []*SoftPWM
+	for i := 0; i < 20; i++ {
+		pin, line := newSoftPin(time.Now)
+		p, err := NewSoftPWM(pin, 100)
+		if err != nil {
+			t.Fatalf("NewSoftPWM() error = %v", err)
+		}
+		p.SetDuty(0.3)
+		pins = append(pins, line)
+		pwms = append(pwms, p)
+	}
+	time.Sleep(300 * time.Millisecond)
+	for _, p := range pwms {
+		p.Close()
+	}
+
+	for i, l := range pins {
+		if got := l.dutyOf(); math.Abs(got-0.3) > 0.1 {
+			t.Errorf("pin %d duty got (%f) want (0.3)", i, got)
+		}
+	}
+	mean, max := pwms[0].Jitter()
+	t.Logf("soft pwm jitter with 20 pins: mean %v max %v", mean, max)
+
+	softPWMs.mu.Lock()
+	defer softPWMs.mu.Unlock()
+	if len(softPWMs.pwms) != 0 {
+		t.Errorf("%d pwms still scheduled after Close()", len(softPWMs.pwms))
+	}
+}
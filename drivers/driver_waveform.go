@@ -0,0 +1,191 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transition is the level of an output changing, At the time from
+// the start of its Waveform
+type Transition struct {
+	At    time.Duration
+	Level int
+}
+
+// Waveform records the transitions of an output, the mock lines keep
+// one of every output for the tests of the devices that are all about
+// timing, a trigger pulse, a soft PWM or the steps of a motor. It is
+// read by the levels, the pulses, the duty and the frequency, and
+// dumped by String for the message of a test failing.
+type Waveform struct {
+	now     func() time.Time
+	start   time.Time
+	initial int
+	level   int
+	trans   []Transition
+	mu      sync.Mutex
+}
+
+// maxDump is the transitions String writes before it cuts the rest
+const maxDump = 32
+
+// NewWaveform returns a Waveform at the level initial, timed by now
+func NewWaveform(initial int, now func() time.Time) *Waveform {
+	initial = levelOf(initial)
+	return &Waveform{now: now, start: now(), initial: initial, level: initial}
+}
+
+func levelOf(v int) int {
+	if v != 0 {
+		return 1
+	}
+	return 0
+}
+
+// Record records the output set to v, a transition when it changed
+func (w *Waveform) Record(v int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v = levelOf(v)
+	if v == w.level {
+		return
+	}
+	w.level = v
+	w.trans = append(w.trans, Transition{At: w.now().Sub(w.start), Level: v})
+}
+
+// Reset forgets the transitions, the waveform starts again now at the
+// level of the output
+func (w *Waveform) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.start = w.now()
+	w.initial = w.level
+	w.trans = nil
+}
+
+// Transitions returns the transitions recorded
+func (w *Waveform) Transitions() []Transition {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Transition(nil), w.trans...)
+}
+
+// Levels returns the initial level and the level of every transition
+func (w *Waveform) Levels() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	levels := []int{w.initial}
+	for _, t := range w.trans {
+		levels = append(levels, t.Level)
+	}
+	return levels
+}
+
+// Edges returns the times of the transitions to level, the rising
+// edges of 1
+func (w *Waveform) Edges(level int) []time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var edges []time.Duration
+	for _, t := range w.trans {
+		if t.Level == levelOf(level) {
+			edges = append(edges, t.At)
+		}
+	}
+	return edges
+}
+
+// Pulses returns the widths of the pulses at level that ended, the
+// high pulses of 1
+func (w *Waveform) Pulses(level int) []time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var widths []time.Duration
+	for i, t := range w.trans[:max(len(w.trans)-1, 0)] {
+		if t.Level == levelOf(level) {
+			widths = append(widths, w.trans[i+1].At-t.At)
+		}
+	}
+	return widths
+}
+
+// Duty returns the fraction of the time from from to to the output
+// was high
+func (w *Waveform) Duty(from, to time.Duration) float64 {
+	if to <= from {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	level, last := w.initial, from
+	var high time.Duration
+	for _, t := range w.trans {
+		if t.At >= to {
+			break
+		}
+		if t.At > from {
+			if level == 1 {
+				high += t.At - last
+			}
+			last = t.At
+		}
+		level = t.Level
+	}
+	if level == 1 {
+		high += to - last
+	}
+	return float64(high) / float64(to-from)
+}
+
+// Frequency returns the rising edges a second from from to to, 0
+// without two of them
+func (w *Waveform) Frequency(from, to time.Duration) float64 {
+	var edges []time.Duration
+	for _, e := range w.Edges(1) {
+		if e >= from && e <= to {
+			edges = append(edges, e)
+		}
+	}
+	if len(edges) < 2 {
+		return 0
+	}
+	return float64(len(edges)-1) / (edges[len(edges)-1] - edges[0]).Seconds()
+}
+
+// String dumps the waveform, the initial level then the time to each
+// transition and its level, "0 +10ms 1 +5ms 0"
+func (w *Waveform) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d", w.initial)
+	var prev time.Duration
+	for i, t := range w.trans {
+		if i == maxDump {
+			fmt.Fprintf(&b, " ... %d more", len(w.trans)-i)
+			break
+		}
+		fmt.Fprintf(&b, " +%v %d", t.At-prev, t.Level)
+		prev = t.At
+	}
+	return b.String()
+}
+
+// waveformer is a line recording the waveform of its output
+type waveformer interface {
+	Waveform() *Waveform
+}
+
+// Waveform returns the waveform of the output of the pin when its line
+// records one, a mock line does, nil on the hardware
+func (p *DigitalPin) Waveform() *Waveform {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w, ok := p.Line.(waveformer); ok {
+		return w.Waveform()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package drivers
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestWaveform(t *testing.T) {
+	now := time.Unix(0, 0)
+	w := NewWaveform(0, func() time.Time { return now })
+	at := func(d time.Duration, v int) {
+		now = time.Unix(0, 0).Add(d)
+		w.Record(v)
+	}
+
+	// a 10ms pulse, a 5ms one, 20ms apart, the 1 written twice
+	at(10*time.Millisecond, 1)
+	at(15*time.Millisecond, 1)
+	at(20*time.Millisecond, 0)
+	at(30*time.Millisecond, 5)
+	at(35*time.Millisecond, 0)
+
+	if got := w.Levels(); !slices.Equal(got, []int{0, 1, 0, 1, 0}) {
+		t.Errorf("Levels() got %v", got)
+	}
+	if got := w.Pulses(1); !slices.Equal(got, []time.Duration{10 * time.Millisecond, 5 * time.Millisecond}) {
+		t.Errorf("Pulses(1) got %v", got)
+	}
+	if got := w.Pulses(0); !slices.Equal(got, []time.Duration{10 * time.Millisecond}) {
+		t.Errorf("Pulses(0) got %v", got)
+	}
+	if got := w.Edges(1); !slices.Equal(got, []time.Duration{10 * time.Millisecond, 30 * time.Millisecond}) {
+		t.Errorf("Edges(1) got %v", got)
+	}
+	if got := w.Frequency(0, time.Second); math.Abs(got-50) > 1e-9 {
+		t.Errorf("Frequency() got (%v) want (50)", got)
+	}
+	for _, tt := range []struct {
+		from, to time.Duration
+		want     float64
+	}{
+		{0, 40 * time.Millisecond, 15.0 / 40},
+		{10 * time.Millisecond, 30 * time.Millisecond, 0.5},
+		{15 * time.Millisecond, 18 * time.Millisecond, 1},
+		{32 * time.Millisecond, 40 * time.Millisecond, 3.0 / 8},
+		{40 * time.Millisecond, 40 * time.Millisecond, 0},
+	} {
+		if got := w.Duty(tt.from, tt.to); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Duty(%v, %v) got (%v) want (%v)", tt.from, tt.to, got, tt.want)
+		}
+	}
+	if got, want := w.String(), "0 +10ms 1 +10ms 0 +10ms 1 +5ms 0"; got != want {
+		t.Errorf("String() got (%s) want (%s)", got, want)
+	}
+
+	w.Reset()
+	if got := w.Levels(); !slices.Equal(got, []int{0}) {
+		t.Errorf("Levels() after Reset() got %v", got)
+	}
+	for i := range 40 {
+		w.Record(i % 2)
+	}
+	if !strings.HasSuffix(w.String(), " ... 7 more") {
+		t.Errorf("String() of 39 transitions got (%s)", w.String())
+	}
+}
+
+func TestMockLineWaveform(t *testing.T) {
+	resetChips(t)
+
+	pin := GetGPIO().Pin("wave", 17, gpiocdev.AsOutput(1))
+	defer pin.Close()
+	pin.Off()
+	pin.Off()
+	pin.Toggle()
+	if got := pin.Waveform().Levels(); !slices.Equal(got, []int{1, 0, 1}) {
+		t.Errorf("mock pin levels got %v want [1 0 1]", got)
+	}
+	if (&DigitalPin{Line: &recLine{}}).Waveform() != nil {
+		t.Error("Waveform() of a line without one got one")
+	}
+}
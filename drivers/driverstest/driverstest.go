@@ -0,0 +1,520 @@
+// Package driverstest provides scripted fakes for the drivers
+// registry so device packages can be unit tested without hardware
+// and without the global mock flag. Install a fake with one of the
+// Use functions, it is removed automatically when the test ends.
+package driverstest
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// ErrClosed is returned when a fake is used after it was closed
+var ErrClosed = errors.New("driverstest: closed")
+
+// The errno the kernel returns for each class of I2C failure, hand
+// them to I2C.Err or I2C.FailNext to have the drivers classify them
+// as drivers.ErrNak, ErrBusBusy, ErrTimeout and ErrPermission.
+var (
+	ErrnoNak        = syscall.EREMOTEIO
+	ErrnoBusBusy    = syscall.EAGAIN
+	ErrnoTimeout    = syscall.ETIMEDOUT
+	ErrnoPermission = syscall.EACCES
+)
+
+// UseI2C installs bus as the device returned for every bus and
+// address for the duration of the test.
+func UseI2C(t testing.TB, bus drivers.I2CBus) {
+	t.Helper()
+	UseI2CProvider(t, func(string, int) (drivers.I2CBus, error) {
+		return bus, nil
+	})
+}
+
+// UseI2CProvider installs p for the duration of the test
+func UseI2CProvider(t testing.TB, p drivers.I2CProvider) {
+	t.Helper()
+	t.Cleanup(drivers.SetI2CProvider(p))
+}
+
+// UseGPIO installs chip as every gpiochip for the duration of the test
+func UseGPIO(t testing.TB, chip drivers.GPIOChip) {
+	t.Helper()
+	t.Cleanup(drivers.SetGPIOProvider(func(string) (drivers.GPIOChip, error) {
+		return chip, nil
+	}))
+}
+
+// UsePWM installs pwm as every pwm channel for the duration of the test
+func UsePWM(t testing.TB, pwm drivers.PWMChannel) {
+	t.Helper()
+	t.Cleanup(drivers.SetPWMProvider(func(string, int) (drivers.PWMChannel, error) {
+		return pwm, nil
+	}))
+}
+
+// UseSPI installs spi as every SPI device for the duration of the
+// test, the device, mode and clock asked for are kept in spi.Dev,
+// spi.Mode and spi.Hz
+func UseSPI(t testing.TB, spi *SPI) {
+	t.Helper()
+	t.Cleanup(drivers.SetSPIProvider(func(dev string, mode, hz int) (drivers.SPIConn, error) {
+		spi.mu.Lock()
+		defer spi.mu.Unlock()
+		spi.Dev, spi.Mode, spi.Hz = dev, mode, hz
+		return spi, nil
+	}))
+}
+
+// Write records a single write made to the I2C fake. Reg is -1 for
+// raw writes made without a register.
+type Write struct {
+	Reg  int
+	Data []byte
+}
+
+// I2C is a scripted I2C device. It models a 256 byte register map,
+// register reads auto-increment like most sensors do. Raw Read()
+// calls return queued responses first and fall back to reading from
+// the register pointer set by the last raw Write(). Every write is
+// recorded in Writes. The registers simulate a chip with OnRead,
+// OnWrite and ReadOnly, a data ready flag set by the measurement a
+// write starts and cleared by reading it.
+type I2C struct {
+	Regs   [256]byte
+	Writes []Write
+
+	// Err when set is returned by every operation
+	Err error
+
+	fails    []error
+	reads    [][]byte
+	ptr      byte
+	onRead   map[byte]func(regs *[256]byte)
+	onWrite  map[byte]func(regs *[256]byte, v byte)
+	readOnly [256]bool
+	closed   bool
+	mu       sync.Mutex
+}
+
+// NewI2C returns an I2C fake with all registers zero
+func NewI2C() *I2C {
+	return &I2C{}
+}
+
+// Set stores vals in consecutive registers starting at reg
+func (b *I2C) Set(reg byte, vals ...byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, v := range vals {
+		b.Regs[byte(int(reg)+i)] = v
+	}
+}
+
+// Get returns n consecutive register values starting at reg
+func (b *I2C) Get(reg byte, n int) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = b.Regs[byte(int(reg)+i)]
+	}
+	return buf
+}
+
+// OnRead calls fn after register reg is read, with the registers to
+// change, a status cleared by reading it
+func (b *I2C) OnRead(reg byte, fn func(regs *[256]byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.onRead == nil {
+		b.onRead = make(map[byte]func(*[256]byte))
+	}
+	b.onRead[reg] = fn
+}
+
+// OnWrite calls fn after v is written to register reg, with the
+// registers to change, a data ready flag set by the measurement the
+// write started
+func (b *I2C) OnWrite(reg byte, fn func(regs *[256]byte, v byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.onWrite == nil {
+		b.onWrite = make(map[byte]func(*[256]byte, byte))
+	}
+	b.onWrite[reg] = fn
+}
+
+// ReadOnly has the writes to regs ignored, they are still recorded
+// in Writes
+func (b *I2C) ReadOnly(regs ...byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, reg := range regs {
+		b.readOnly[reg] = true
+	}
+}
+
+// FailNext makes the next len(errs) operations fail with errs in
+// order, a nil entry lets that operation succeed.
+func (b *I2C) FailNext(errs ...error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = append(b.fails, errs...)
+}
+
+// QueueRead scripts the response to the next raw Read()
+func (b *I2C) QueueRead(data ...byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads = append(b.reads, data)
+}
+
+// Read fills buf from the next queued response, or from the
+// registers at the current pointer.
+func (b *I2C) Read(buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return err
+	}
+	if len(b.reads) > 0 {
+		copy(buf, b.reads[0])
+		b.reads = b.reads[1:]
+		return nil
+	}
+	b.readRegs(b.ptr, buf)
+	return nil
+}
+
+// Write records buf, the first byte sets the register pointer and
+// any remaining bytes are stored starting at that register.
+func (b *I2C) Write(buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return err
+	}
+	b.Writes = append(b.Writes, Write{Reg: -1, Data: append([]byte(nil), buf...)})
+	if len(buf) > 0 {
+		b.ptr = buf[0]
+		b.writeRegs(b.ptr, buf[1:])
+	}
+	return nil
+}
+
+// ReadReg fills buf from consecutive registers starting at reg
+func (b *I2C) ReadReg(reg byte, buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return err
+	}
+	b.readRegs(reg, buf)
+	return nil
+}
+
+// WriteReg stores buf in consecutive registers starting at reg
+func (b *I2C) WriteReg(reg byte, buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.check(); err != nil {
+		return err
+	}
+	b.Writes = append(b.Writes, Write{Reg: int(reg), Data: append([]byte(nil), buf...)})
+	b.writeRegs(reg, buf)
+	return nil
+}
+
+// Close marks the fake closed, further use returns ErrClosed
+func (b *I2C) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// Closed reports if Close has been called
+func (b *I2C) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *I2C) readRegs(reg byte, buf []byte) {
+	for i := range buf {
+		buf[i] = b.Regs[byte(int(reg)+i)]
+	}
+	for i := range buf {
+		if fn := b.onRead[byte(int(reg)+i)]; fn != nil {
+			fn(&b.Regs)
+		}
+	}
+}
+
+func (b *I2C) writeRegs(reg byte, buf []byte) {
+	for i, v := range buf {
+		r := byte(int(reg) + i)
+		if b.readOnly[r] {
+			continue
+		}
+		b.Regs[r] = v
+		if fn := b.onWrite[r]; fn != nil {
+			fn(&b.Regs, v)
+		}
+	}
+}
+
+func (b *I2C) check() error {
+	if b.closed {
+		return ErrClosed
+	}
+	if len(b.fails) > 0 {
+		err := b.fails[0]
+		b.fails = b.fails[1:]
+		return err
+	}
+	return b.Err
+}
+
+// Chip is a fake gpiochip handing out Lines that record what was
+// written to them.
+type Chip struct {
+	lines map[int]*Line
+	mu    sync.Mutex
+}
+
+// NewChip returns an empty fake chip
+func NewChip() *Chip {
+	return &Chip{lines: make(map[int]*Line)}
+}
+
+// RequestLine returns the fake line at offset. Output values and
+// event handlers in opts are honored.
+func (c *Chip) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (drivers.Line, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l := &Line{offset: offset}
+	for _, o := range opts {
+		switch v := o.(type) {
+		case gpiocdev.OutputOption:
+			if len(v) > 0 {
+				l.val = v[0]
+			}
+		case gpiocdev.EventHandler:
+			l.handler = v
+		}
+	}
+	l.wave = drivers.NewWaveform(l.val, device.DefaultClock().Now)
+	c.lines[offset] = l
+	return l, nil
+}
+
+// Line returns the line requested at offset, nil if never requested
+func (c *Chip) Line(offset int) *Line {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lines[offset]
+}
+
+// Close does nothing, lines are closed individually
+func (c *Chip) Close() error {
+	return nil
+}
+
+// Line is a fake GPIO line. Every SetValue is recorded in Values and
+// in its Waveform, Edge() delivers an event to the handler registered
+// at request time.
+type Line struct {
+	Values []int
+
+	offset  int
+	val     int
+	wave    *drivers.Waveform
+	seqno   uint32
+	handler gpiocdev.EventHandler
+	closed  bool
+	mu      sync.Mutex
+}
+
+func (l *Line) Offset() int {
+	return l.offset
+}
+
+func (l *Line) Value() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	return l.val, nil
+}
+
+func (l *Line) SetValue(v int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.val = v
+	l.Values = append(l.Values, v)
+	l.wave.Record(v)
+	return nil
+}
+
+// Waveform returns the waveform of the values set, timed by the
+// default clock of the devices when the line was requested
+func (l *Line) Waveform() *drivers.Waveform {
+	return l.wave
+}
+
+func (l *Line) Reconfigure(...gpiocdev.LineConfigOption) error {
+	return nil
+}
+
+func (l *Line) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return nil
+}
+
+// Closed reports if the line has been released
+func (l *Line) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// Edge drives the line to v and delivers the matching edge event
+func (l *Line) Edge(v int) {
+	l.mu.Lock()
+	l.val = v
+	l.seqno++
+	evt := gpiocdev.LineEvent{
+		Offset:    l.offset,
+		Type:      gpiocdev.LineEventRisingEdge,
+		Seqno:     l.seqno,
+		LineSeqno: l.seqno,
+	}
+	if v == 0 {
+		evt.Type = gpiocdev.LineEventFallingEdge
+	}
+	h := l.handler
+	l.mu.Unlock()
+
+	if h != nil {
+		h(evt)
+	}
+}
+
+// PWM is a fake pwm channel recording every duty cycle written
+type PWM struct {
+	Freq   float64
+	Duty   float64
+	Duties []float64
+
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewPWM returns a fake pwm channel
+func NewPWM() *PWM {
+	return &PWM{}
+}
+
+func (p *PWM) SetFrequency(hz float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.Freq = hz
+	return nil
+}
+
+func (p *PWM) SetDuty(fraction float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.Duty = fraction
+	p.Duties = append(p.Duties, fraction)
+	return nil
+}
+
+func (p *PWM) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// SPI is a fake SPI device keeping a transcript of every transfer,
+// what is read back is the next queued response or all zeros
+type SPI struct {
+	Dev  string
+	Mode int
+	Hz   int
+
+	txs    [][]byte
+	reads  [][]byte
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewSPI returns a fake SPI device
+func NewSPI() *SPI {
+	return &SPI{}
+}
+
+func (s *SPI) Tx(w, r []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.txs = append(s.txs, append([]byte(nil), w...))
+	clear(r)
+	if len(s.reads) > 0 {
+		copy(r, s.reads[0])
+		s.reads = s.reads[1:]
+	}
+	return nil
+}
+
+// QueueRead scripts what the next transfer reads back
+func (s *SPI) QueueRead(data ...byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads = append(s.reads, data)
+}
+
+// Transcript returns the bytes written by every transfer so far
+func (s *SPI) Transcript() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.txs...)
+}
+
+func (s *SPI) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Closed reports if the device has been closed
+func (s *SPI) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,127 @@
+package driverstest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// recordTB keeps the errors of a test failed on purpose and runs its
+// cleanups when done
+type recordTB struct {
+	testing.TB
+	errs     []string
+	cleanups []func()
+}
+
+func (r *recordTB) Errorf(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recordTB) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+func (r *recordTB) done() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestTranscriptParse(t *testing.T) {
+	want := Transcript{
+		RegRead(0xd0, 0x60),
+		RegWrite(0x05, 0x01, 0x02),
+		RawWrite(0xf4),
+		RawRead(0xab, 0xcd),
+	}
+	text := "# a comment\n\n" + want.String()
+	got, err := ParseTranscript(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseTranscript() error = %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("transcript got\n%s\nwant\n%s", got, want)
+	}
+	if line := want[1].String(); line != "writereg 0x05 01 02" {
+		t.Errorf("op got (%s) want (writereg 0x05 01 02)", line)
+	}
+
+	for _, bad := range []string{"readreg", "readreg 0x100", "peek 01", "write 0g"} {
+		if _, err := ParseTranscript(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseTranscript(%q) error = nil", bad)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	tb := &recordTB{TB: t}
+	s := NewScript(tb, Transcript{RegRead(0xd0, 0x60), RegWrite(0xf4, 0x27)})
+
+	buf := make([]byte, 1)
+	if err := s.ReadReg(0xd0, buf); err != nil || buf[0] != 0x60 {
+		t.Errorf("ReadReg() got (%#x, %v) want (0x60, nil)", buf[0], err)
+	}
+	if err := s.WriteReg(0xf4, []byte{0x25}); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("WriteReg() wrong data error = %v want %v", err, ErrUnexpected)
+	}
+	if err := s.WriteReg(0xf4, []byte{0x27}); err != nil {
+		t.Errorf("WriteReg() error = %v", err)
+	}
+	if err := s.Write([]byte{0x01}); !errors.Is(err, ErrUnexpected) {
+		t.Errorf("Write() past the end error = %v want %v", err, ErrUnexpected)
+	}
+	tb.done()
+	if len(tb.errs) != 2 {
+		t.Errorf("errors got %q want the wrong data and past the end", tb.errs)
+	}
+
+	// the ops left fail the test when it ends
+	tb = &recordTB{TB: t}
+	NewScript(tb, Transcript{RawRead(0x01)})
+	tb.done()
+	if len(tb.errs) != 1 || !strings.Contains(tb.errs[0], "1 ops left") {
+		t.Errorf("errors got %q want 1 ops left", tb.errs)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	fake := NewI2C()
+	fake.Set(0x10, 0xaa, 0xbb)
+	fake.ReadOnly(0x10)
+	fake.OnWrite(0x20, func(regs *[256]byte, v byte) {
+		regs[0x21] = 0x80 // ready
+	})
+	fake.OnRead(0x21, func(regs *[256]byte) {
+		regs[0x21] = 0
+	})
+
+	UseI2C(t, fake)
+	rec, restore := RecordI2C("/dev/i2c-1", 0x40)
+	defer restore()
+
+	bus, err := drivers.OpenI2C("/dev/i2c-1", 0x40)
+	if err != nil {
+		t.Fatalf("OpenI2C() error = %v", err)
+	}
+	buf := make([]byte, 2)
+	bus.WriteReg(0x10, []byte{0x00})
+	bus.ReadReg(0x10, buf)
+	bus.WriteReg(0x20, []byte{0x01})
+	bus.ReadReg(0x21, buf[:1])
+	bus.ReadReg(0x21, buf[1:])
+
+	want := Transcript{
+		RegWrite(0x10, 0x00),
+		RegRead(0x10, 0xaa, 0xbb),
+		RegWrite(0x20, 0x01),
+		RegRead(0x21, 0x80),
+		RegRead(0x21, 0x00),
+	}
+	if got := rec.Transcript(); got.String() != want.String() {
+		t.Errorf("transcript got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package driverstest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// ErrUnexpected is returned by a Script for an operation that is not
+// the next of its transcript
+var ErrUnexpected = errors.New("driverstest: unexpected i2c operation")
+
+// OpKind is the kind of an I2C operation
+type OpKind string
+
+const (
+	OpRead     OpKind = "read"
+	OpWrite    OpKind = "write"
+	OpReadReg  OpKind = "readreg"
+	OpWriteReg OpKind = "writereg"
+)
+
+// Op is an operation on an I2C bus. Data is what was written, or what
+// was read back. Reg is the register of a readreg or a writereg.
+type Op struct {
+	Kind OpKind
+	Reg  byte
+	Data []byte
+}
+
+// String formats op as a line of a transcript, "readreg 0xd0 60" or
+// "write f4 27"
+func (op Op) String() string {
+	data := hex.EncodeToString(op.Data)
+	var b strings.Builder
+	b.WriteString(string(op.Kind))
+	if op.Kind == OpReadReg || op.Kind == OpWriteReg {
+		fmt.Fprintf(&b, " 0x%02x", op.Reg)
+	}
+	for i := 0; i < len(data); i += 2 {
+		b.WriteString(" " + data[i:i+2])
+	}
+	return b.String()
+}
+
+// RegRead is the op of reading data from the registers at reg
+func RegRead(reg byte, data ...byte) Op {
+	return Op{Kind: OpReadReg, Reg: reg, Data: data}
+}
+
+// RegWrite is the op of writing data to the registers at reg
+func RegWrite(reg byte, data ...byte) Op {
+	return Op{Kind: OpWriteReg, Reg: reg, Data: data}
+}
+
+// RawRead is the op of a raw read of data
+func RawRead(data ...byte) Op {
+	return Op{Kind: OpRead, Data: data}
+}
+
+// RawWrite is the op of a raw write of data
+func RawWrite(data ...byte) Op {
+	return Op{Kind: OpWrite, Data: data}
+}
+
+// Transcript is the operations on an I2C bus in order, written one a
+// line. Lines starting with # are comments.
+type Transcript []Op
+
+// String returns the transcript one op a line
+func (t Transcript) String() string {
+	var b strings.Builder
+	for _, op := range t {
+		b.WriteString(op.String() + "\n")
+	}
+	return b.String()
+}
+
+// ParseTranscript reads a transcript written by String
+func ParseTranscript(r io.Reader) (Transcript, error) {
+	var t Transcript
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		op := Op{Kind: OpKind(fields[0])}
+		switch op.Kind {
+		case OpReadReg, OpWriteReg:
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("transcript line %d: no register", n)
+			}
+			reg, err := strconv.ParseUint(fields[1], 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("transcript line %d: register: %w", n, err)
+			}
+			op.Reg = byte(reg)
+			fields = fields[2:]
+		case OpRead, OpWrite:
+			fields = fields[1:]
+		default:
+			return nil, fmt.Errorf("transcript line %d: unknown op %q", n, op.Kind)
+		}
+		data, err := hex.DecodeString(strings.Join(fields, ""))
+		if err != nil {
+			return nil, fmt.Errorf("transcript line %d: %w", n, err)
+		}
+		op.Data = data
+		t = append(t, op)
+	}
+	return t, sc.Err()
+}
+
+// LoadTranscript reads the transcript in the file at path
+func LoadTranscript(path string) (Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTranscript(f)
+}
+
+// Script is an I2C bus expecting the operations of a transcript in
+// order, its reads answered with the data of the transcript. An
+// operation that is not the next one fails the test and returns
+// ErrUnexpected, and so do the ones left when the test ends.
+type Script struct {
+	t      testing.TB
+	ops    Transcript
+	next   int
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewScript returns a Script of the transcript for the test
+func NewScript(t testing.TB, ops Transcript) *Script {
+	s := &Script{t: t, ops: ops}
+	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.next < len(s.ops) {
+			t.Errorf("i2c script: %d ops left, next (%s)", len(s.ops)-s.next, s.ops[s.next])
+		}
+	})
+	return s
+}
+
+// UseScript installs a Script of the transcript as the device
+// returned for every bus and address for the duration of the test
+func UseScript(t testing.TB, ops Transcript) *Script {
+	t.Helper()
+	s := NewScript(t, ops)
+	UseI2C(t, s)
+	return s
+}
+
+// expect checks got is the next op, the data of a read its length,
+// and returns the op of the transcript
+func (s *Script) expect(got Op) (Op, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return Op{}, ErrClosed
+	}
+	if s.next == len(s.ops) {
+		s.t.Errorf("i2c script: got (%s) past the end", got)
+		return Op{}, ErrUnexpected
+	}
+	want := s.ops[s.next]
+	match := got.Kind == want.Kind && got.Reg == want.Reg && len(got.Data) == len(want.Data)
+	if match && (got.Kind == OpWrite || got.Kind == OpWriteReg) {
+		match = string(got.Data) == string(want.Data)
+	}
+	if !match {
+		s.t.Errorf("i2c script op %d: got (%s) want (%s)", s.next+1, got, want)
+		return Op{}, ErrUnexpected
+	}
+	s.next++
+	return want, nil
+}
+
+func (s *Script) Read(buf []byte) error {
+	op, err := s.expect(Op{Kind: OpRead, Data: buf})
+	copy(buf, op.Data)
+	return err
+}
+
+func (s *Script) Write(buf []byte) error {
+	_, err := s.expect(Op{Kind: OpWrite, Data: buf})
+	return err
+}
+
+func (s *Script) ReadReg(reg byte, buf []byte) error {
+	op, err := s.expect(Op{Kind: OpReadReg, Reg: reg, Data: buf})
+	copy(buf, op.Data)
+	return err
+}
+
+func (s *Script) WriteReg(reg byte, buf []byte) error {
+	_, err := s.expect(Op{Kind: OpWriteReg, Reg: reg, Data: buf})
+	return err
+}
+
+// Close closes the script, it is not an op of the transcript
+func (s *Script) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Recorder is an I2C bus recording the operations that succeed on the
+// one it wraps, the transcript of the real hardware a Script asserts
+// later
+type Recorder struct {
+	bus drivers.I2CBus
+	ops Transcript
+	mu  sync.Mutex
+}
+
+// Record returns a Recorder of the operations on bus
+func Record(bus drivers.I2CBus) *Recorder {
+	return &Recorder{bus: bus}
+}
+
+// RecordI2C has the device at addr on bus record its operations on the
+// Recorder returned, opened by the provider in use, the kernel on the
+// hardware, until restore
+func RecordI2C(bus string, addr int) (rec *Recorder, restore func()) {
+	rec = &Recorder{}
+	open := drivers.CurrentI2CProvider()
+	restore = drivers.SetI2CProvider(func(b string, a int) (drivers.I2CBus, error) {
+		dev, err := open(b, a)
+		if err != nil || b != bus || a != addr {
+			return dev, err
+		}
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.bus = dev
+		return rec, nil
+	})
+	return rec, restore
+}
+
+// Transcript returns the operations recorded so far
+func (r *Recorder) Transcript() Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Transcript(nil), r.ops...)
+}
+
+func (r *Recorder) record(kind OpKind, reg byte, data []byte, err error) error {
+	if err == nil {
+		r.mu.Lock()
+		r.ops = append(r.ops, Op{Kind: kind, Reg: reg, Data: append([]byte(nil), data...)})
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func (r *Recorder) Read(buf []byte) error {
+	return r.record(OpRead, 0, buf, r.bus.Read(buf))
+}
+
+func (r *Recorder) Write(buf []byte) error {
+	return r.record(OpWrite, 0, buf, r.bus.Write(buf))
+}
+
+func (r *Recorder) ReadReg(reg byte, buf []byte) error {
+	return r.record(OpReadReg, reg, buf, r.bus.ReadReg(reg, buf))
+}
+
+func (r *Recorder) WriteReg(reg byte, buf []byte) error {
+	return r.record(OpWriteReg, reg, buf, r.bus.WriteReg(reg, buf))
+}
+
+func (r *Recorder) Close() error {
+	return r.bus.Close()
+}
@@ -0,0 +1,64 @@
+package driverstest
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// ExpectSequence fails the test unless the levels of w, its initial
+// one and the one of every transition, are levels
+func ExpectSequence(t testing.TB, w *drivers.Waveform, levels ...int) {
+	t.Helper()
+	if w == nil {
+		t.Fatal("no waveform, the line does not record one")
+	}
+	if got := w.Levels(); !slices.Equal(got, levels) {
+		t.Errorf("levels got %v want %v\nwaveform %s", got, levels, w)
+	}
+}
+
+// ExpectPulseWidth fails the test unless w has pulses at level and
+// they are all from min to max wide, a width and its tolerance
+func ExpectPulseWidth(t testing.TB, w *drivers.Waveform, level int, min, max time.Duration) {
+	t.Helper()
+	if w == nil {
+		t.Fatal("no waveform, the line does not record one")
+	}
+	pulses := w.Pulses(level)
+	if len(pulses) == 0 {
+		t.Errorf("no pulses at %d\nwaveform %s", level, w)
+	}
+	for i, p := range pulses {
+		if p < min || p > max {
+			t.Errorf("pulse %d at %d got (%v) want %v - %v\nwaveform %s", i+1, level, p, min, max, w)
+			return
+		}
+	}
+}
+
+// ExpectFrequency fails the test unless the rising edges of w from
+// from to to are want a second, within tol
+func ExpectFrequency(t testing.TB, w *drivers.Waveform, from, to time.Duration, want, tol float64) {
+	t.Helper()
+	if w == nil {
+		t.Fatal("no waveform, the line does not record one")
+	}
+	if got := w.Frequency(from, to); got < want-tol || got > want+tol {
+		t.Errorf("frequency got (%vHz) want (%vHz ± %v)\nwaveform %s", got, want, tol, w)
+	}
+}
+
+// ExpectDuty fails the test unless w was high want of the time from
+// from to to, within tol
+func ExpectDuty(t testing.TB, w *drivers.Waveform, from, to time.Duration, want, tol float64) {
+	t.Helper()
+	if w == nil {
+		t.Fatal("no waveform, the line does not record one")
+	}
+	if got := w.Duty(from, to); got < want-tol || got > want+tol {
+		t.Errorf("duty got (%v) want (%v ± %v)\nwaveform %s", got, want, tol, w)
+	}
+}
@@ -0,0 +1,235 @@
+// Package ds18b20 reads DS18B20 temperature probes on the 1-Wire bus
+// through the kernel's w1_therm driver (dtoverlay=w1-gpio on a Pi).
+// Every probe on the bus is its own device, Discover creates and
+// registers one for each.
+package ds18b20
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// Family is the 1-Wire family code of the DS18B20
+const Family = 0x28
+
+// MockID is the probe a mocked DS18B20 pretends to be when no ID is
+// given
+const MockID = "28-000000000000"
+
+var (
+	// ErrNoProbe is returned when no DS18B20 is on the bus
+	ErrNoProbe = errors.New("no ds18b20 on the 1-wire bus")
+
+	// ErrMultipleProbes is returned when a probe was to be picked
+	// automatically but there are several, name one by its ID
+	ErrMultipleProbes = errors.New("more than one ds18b20 on the 1-wire bus")
+
+	// ErrResolution is returned for a resolution other than 9 to 12 bits
+	ErrResolution = errors.New("ds18b20 resolution must be 9 to 12 bits")
+)
+
+// Env is what ReadPub publishes, the temperature field matches the
+// bme280 Env
+type Env struct {
+	Temperature string `json:"temperature"`
+}
+
+// DS18B20 is a single temperature probe
+type DS18B20 struct {
+	*device.Device
+	ID string
+
+	// Units are the units ReadPub publishes, "F" like the bme280
+	// or "C"
+	Units string
+
+	w1         *drivers.OneWire
+	resolution int
+	mock       float64
+	live       func() float64 // the Generator started
+	mu         sync.Mutex
+}
+
+// New creates the probe with the given ID, e.g. 28-0316a2795aff. An
+// empty ID picks the only probe on the bus.
+func New(name, id string) (*DS18B20, error) {
+	d := &DS18B20{
+		Device:     device.NewDevice(name, "mqtt"),
+		ID:         id,
+		Units:      "F",
+		resolution: 12,
+		mock:       19.5,
+	}
+	d.SetMockKind("ds18b20", d)
+	if device.IsMock() {
+		if d.ID == "" {
+			d.ID = MockID
+		}
+		return d, nil
+	}
+
+	if d.ID == "" {
+		ids, err := drivers.OneWireDevices(Family)
+		if err != nil {
+			return nil, err
+		}
+		switch len(ids) {
+		case 0:
+			return nil, ErrNoProbe
+		case 1:
+			d.ID = ids[0]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrMultipleProbes, strings.Join(ids, ", "))
+		}
+	}
+
+	w1, err := drivers.OpenOneWire(d.ID)
+	if err != nil {
+		return nil, err
+	}
+	if w1.Family() != Family {
+		return nil, fmt.Errorf("%s is not a ds18b20", d.ID)
+	}
+	d.w1 = w1
+	return d, nil
+}
+
+// Discover creates a device for every probe on the bus, named
+// prefix-<id>, and adds them to the DeviceManager
+func Discover(prefix string) ([]*DS18B20, error) {
+	ids := []string{MockID}
+	if !device.IsMock() {
+		var err error
+		if ids, err = drivers.OneWireDevices(Family); err != nil {
+			return nil, err
+		}
+	}
+
+	dm := device.GetDeviceManager()
+	var probes []*DS18B20
+	for _, id := range ids {
+		d, err := New(prefix+"-"+id, id)
+		if err != nil {
+			return probes, err
+		}
+		if err := dm.Add(d); err != nil {
+			return probes, err
+		}
+		probes = append(probes, d)
+	}
+	return probes, nil
+}
+
+// Name returns the name of the device
+func (d *DS18B20) Name() string {
+	return d.Device.Name
+}
+
+// Read returns the temperature in Celsius. A failed CRC or the
+// power-on value are returned as errors (ErrCRC, ErrPowerOn) so a
+// bad conversion is never published as a temperature.
+func (d *DS18B20) Read() (float64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if device.IsMock() {
+		if err := d.ReadFault(); err != nil {
+			return 0, fmt.Errorf("%s: %w", d.ID, err)
+		}
+		if d.live != nil {
+			d.mock = d.live()
+		} else {
+			// a fermenter warms and cools slowly
+			d.mock += d.MockRand().Float64()*0.2 - 0.1
+		}
+		step := math.Ldexp(1, -(d.resolution - 8))
+		return math.Round(d.mock/step) * step, nil
+	}
+
+	b, err := d.w1.ReadFile("w1_slave")
+	if err != nil {
+		return 0, err
+	}
+	r, err := parse(b)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", d.ID, err)
+	}
+	d.resolution = r.Resolution
+	return r.Celsius, nil
+}
+
+// SetGenerator has the mock reads take their temperatures in °C of g,
+// started now by the clock of the device
+func (d *DS18B20) SetGenerator(g device.Generator) {
+	var live func() float64
+	if g != nil {
+		live = device.LiveOn(g, d.Now)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.live = live
+}
+
+// Temperature reads the temperature in °C whatever the Units, the
+// probe is a device.Thermometer for the pH and TDS probes it sits by
+func (d *DS18B20) Temperature() (float64, error) {
+	return d.Read()
+}
+
+// Resolution returns the bits of the last reading, conversions take
+// 94ms at 9 bits up to 750ms at 12 bits
+func (d *DS18B20) Resolution() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resolution
+}
+
+// SetResolution sets the conversion resolution, 9 to 12 bits. The
+// setting is lost when the probe loses power.
+func (d *DS18B20) SetResolution(bits int) error {
+	if bits < 9 || bits > 12 {
+		return fmt.Errorf("%w: %d", ErrResolution, bits)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !device.IsMock() {
+		// older kernels take the resolution written to w1_slave
+		file := "w1_slave"
+		if d.w1.Has("resolution") {
+			file = "resolution"
+		}
+		if err := d.w1.WriteFile(file, strconv.Itoa(bits)); err != nil {
+			return err
+		}
+	}
+	d.resolution = bits
+	return nil
+}
+
+// ReadPub reads the probe and publishes the temperature in Units
+func (d *DS18B20) ReadPub() error {
+	c, err := d.Read()
+	if err != nil {
+		return err
+	}
+
+	t := c
+	if d.Units != "C" {
+		t = c*9/5 + 32
+	}
+	j, err := json.Marshal(&Env{Temperature: fmt.Sprintf("%.2f", t)})
+	if err != nil {
+		return err
+	}
+	d.PubData(j)
+	return nil
+}
@@ -0,0 +1,249 @@
+package ds18b20
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// w1_slave contents as read from the kernel
+const (
+	slave23   = "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n"
+	slaveNeg  = "5e ff 4b 46 7f ff 02 10 b6 : crc=b6 YES\n5e ff 4b 46 7f ff 02 10 b6 t=-10125\n"
+	slave9bit = "91 01 4b 46 1f ff 0f 10 b5 : crc=b5 YES\n91 01 4b 46 1f ff 0f 10 b5 t=25062\n"
+	slave10   = "a2 00 4b 46 3f ff 0e 10 05 : crc=05 YES\na2 00 4b 46 3f ff 0e 10 05 t=10125\n"
+	slave85   = "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n"
+	slaveNO   = "72 01 4b 46 7f ff 0e 10 58 : crc=57 NO\n72 01 4b 46 7f ff 0e 10 58 t=23125\n"
+	slaveBad  = "72 01 4b 46 7f ff 0e 10 58 : crc=58 YES\n72 01 4b 46 7f ff 0e 10 58 t=23125\n"
+	slaveZero = "00 00 00 00 00 00 00 00 00 : crc=00 YES\n00 00 00 00 00 00 00 00 00 t=0\n"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want reading
+		err  error
+	}{
+		{"23C", slave23, reading{Celsius: 23.125, Resolution: 12}, nil},
+		{"negative", slaveNeg, reading{Celsius: -10.125, Resolution: 12}, nil},
+		{"9 bit", slave9bit, reading{Celsius: 25.0, Resolution: 9}, nil},
+		{"10 bit", slave10, reading{Celsius: 10.0, Resolution: 10}, nil},
+		{"power on", slave85, reading{}, ErrPowerOn},
+		{"kernel crc", slaveNO, reading{}, ErrCRC},
+		{"crc", slaveBad, reading{}, ErrCRC},
+		{"shorted", slaveZero, reading{}, ErrMalformed},
+		{"empty", "", reading{}, ErrMalformed},
+		{"truncated", "72 01 4b 46 : crc=57 YES\nt=23125\n", reading{}, ErrMalformed},
+		{"garbage", "zz 01 4b 46 7f ff 0e 10 57 : crc=57 YES\nt=23125\n", reading{}, ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parse([]byte(tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error got (%v) want (%v)", err, tt.err)
+			}
+			if r != tt.want {
+				t.Errorf("reading got (%+v) want (%+v)", r, tt.want)
+			}
+		})
+	}
+}
+
+// fakeBus lays out a 1-Wire bus with the probes and their w1_slave
+func fakeBus(t *testing.T, probes map[string]string) string {
+	t.Helper()
+	device.Mock(false)
+	root := t.TempDir()
+	t.Cleanup(drivers.SetOneWireRoot(root))
+
+	os.MkdirAll(filepath.Join(root, "w1_bus_master1"), 0755)
+	for id, slave := range probes {
+		os.MkdirAll(filepath.Join(root, id), 0755)
+		if err := os.WriteFile(filepath.Join(root, id, "w1_slave"), []byte(slave), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestNew(t *testing.T) {
+	fakeBus(t, map[string]string{"28-0316a2795aff": slave23})
+
+	d, err := New("fermenter", "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if d.ID != "28-0316a2795aff" {
+		t.Errorf("ID got (%s) want (28-0316a2795aff)", d.ID)
+	}
+	if c, err := d.Read(); err != nil || c != 23.125 {
+		t.Errorf("Read() got (%v, %v) want (23.125, nil)", c, err)
+	}
+
+	if _, err := New("missing", "28-000000000001"); !errors.Is(err, drivers.ErrOneWireNotFound) {
+		t.Errorf("New(missing) error got (%v) want (%v)", err, drivers.ErrOneWireNotFound)
+	}
+
+	fakeBus(t, nil)
+	if _, err := New("none", ""); !errors.Is(err, ErrNoProbe) {
+		t.Errorf("New() on an empty bus error got (%v) want (%v)", err, ErrNoProbe)
+	}
+
+	fakeBus(t, map[string]string{"28-0316a2795aff": slave23, "28-00000a1b2c3d": slaveNeg})
+	if _, err := New("which", ""); !errors.Is(err, ErrMultipleProbes) {
+		t.Errorf("New() with two probes error got (%v) want (%v)", err, ErrMultipleProbes)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	fakeBus(t, map[string]string{
+		"28-0316a2795aff": slave23,
+		"28-00000a1b2c3d": slaveNeg,
+		"10-000802b4d1e7": slave23,
+	})
+	dm := device.GetDeviceManager()
+	t.Cleanup(dm.Clear)
+
+	probes, err := Discover("probe")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(probes) != 2 {
+		t.Fatalf("Discover() got (%d) probes want (2)", len(probes))
+	}
+
+	want := map[string]float64{
+		"probe-28-00000a1b2c3d": -10.125,
+		"probe-28-0316a2795aff": 23.125,
+	}
+	for name, temp := range want {
+		d, ok := dm.Get(name)
+		if !ok {
+			t.Fatalf("device %s was not registered", name)
+		}
+		if c, err := d.(*DS18B20).Read(); err != nil || c != temp {
+			t.Errorf("%s Read() got (%v, %v) want (%v, nil)", name, c, err, temp)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	root := fakeBus(t, map[string]string{"28-0316a2795aff": slave85})
+	d, err := New("probe", "28-0316a2795aff")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := d.Read(); !errors.Is(err, ErrPowerOn) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrPowerOn)
+	}
+
+	os.WriteFile(filepath.Join(root, "28-0316a2795aff", "w1_slave"), []byte(slaveBad), 0644)
+	if _, err := d.Read(); !errors.Is(err, ErrCRC) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrCRC)
+	}
+}
+
+func TestSetResolution(t *testing.T) {
+	root := fakeBus(t, map[string]string{"28-0316a2795aff": slave23})
+	d, _ := New("probe", "")
+
+	if err := d.SetResolution(8); !errors.Is(err, ErrResolution) {
+		t.Errorf("SetResolution(8) error got (%v) want (%v)", err, ErrResolution)
+	}
+
+	// newer kernels have a resolution attribute
+	res := filepath.Join(root, "28-0316a2795aff", "resolution")
+	os.WriteFile(res, []byte("12\n"), 0644)
+	if err := d.SetResolution(10); err != nil || d.Resolution() != 10 {
+		t.Errorf("SetResolution(10) got (%d, %v) want (10, nil)", d.Resolution(), err)
+	}
+	if b, _ := os.ReadFile(res); string(b) != "10" {
+		t.Errorf("resolution got (%q) want (10)", b)
+	}
+
+	// the next read reports the resolution the probe used
+	if _, err := d.Read(); err != nil || d.Resolution() != 12 {
+		t.Errorf("Read() resolution got (%d, %v) want (12, nil)", d.Resolution(), err)
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	d, err := New("mock", "")
+	if err != nil || d.ID != MockID {
+		t.Fatalf("New() got (%v, %v) want (%s, nil)", d, err, MockID)
+	}
+	d.SetResolution(9)
+	for i := 0; i < 10; i++ {
+		c, err := d.Read()
+		if err != nil || c < 17 || c > 22 || c != float64(int(c*2))/2 {
+			t.Errorf("Read() got (%v, %v) want a 9 bit reading near 19.5", c, err)
+		}
+	}
+
+	// a thermometer linked by its name, in C whatever the Units
+	dm := device.GetDeviceManager()
+	dm.Add(d)
+	defer dm.Remove(d.Name())
+	th, err := device.GetThermometer("mock")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	if c, err := th.Temperature(); err != nil || c < 17 || c > 22 {
+		t.Errorf("Temperature() got (%v, %v) want near 19.5", c, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	clock := device.NewSimClock(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	d, _ := New("mock", "")
+	d.SetClock(clock)
+	if err := d.MockProfile("fermenter"); err != nil {
+		t.Fatalf("MockProfile(fermenter) error = %v", err)
+	}
+	for h := range 48 {
+		if c, err := d.Read(); err != nil || c < 18 || c > 21 {
+			t.Errorf("Read() fermenter at %dh got (%v, %v) want 18 - 21", h, c, err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	if err := d.MockProfile("outdoor"); err != nil {
+		t.Fatalf("MockProfile(outdoor) error = %v", err)
+	}
+	var night, afternoon float64
+	var crc int
+	for h := range 30 {
+		c, err := d.Read()
+		switch {
+		case errors.Is(err, ErrCRC):
+			crc++
+		case err != nil || c < -7 || c > 3:
+			t.Errorf("Read() outdoor at %02d:00 got (%v, %v) want -7 - 3", h%24, c, err)
+		case h == 3:
+			night = c
+		case h == 15:
+			afternoon = c
+		}
+		clock.Advance(time.Hour)
+	}
+	if afternoon <= night || crc != 1 {
+		t.Errorf("outdoor got 15:00 (%.1f) 03:00 (%.1f) and (%d) CRC errors, want warmer and (1)", afternoon, night, crc)
+	}
+
+	if err := d.MockProfile("greenhouse"); !errors.Is(err, device.ErrMockProfile) {
+		t.Errorf("MockProfile(greenhouse) error got (%v) want (%v)", err, device.ErrMockProfile)
+	}
+}
@@ -0,0 +1,91 @@
+package ds18b20
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+var (
+	// ErrCRC is returned when the scratchpad fails its CRC, a noisy
+	// or too long bus
+	ErrCRC = errors.New("ds18b20: crc mismatch")
+
+	// ErrPowerOn is returned for the 85C the probe reports before its
+	// first conversion, usually a brown out on parasite power
+	ErrPowerOn = errors.New("ds18b20: power-on reset value")
+
+	// ErrMalformed is returned for w1_slave contents that can not be
+	// parsed
+	ErrMalformed = errors.New("ds18b20: malformed w1_slave")
+)
+
+// powerOnRaw is the temperature register after a reset, 85C
+const powerOnRaw = 0x0550
+
+// reading is a parsed w1_slave
+type reading struct {
+	Celsius    float64
+	Resolution int
+}
+
+// parse checks and converts the contents of the kernel's w1_slave
+// file, two lines with the 9 byte scratchpad:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+//
+// The CRC is checked again here rather than trusting the kernel's
+// YES, and the undefined low bits of lower resolutions are dropped.
+func parse(b []byte) (reading, error) {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 2 {
+		return reading{}, fmt.Errorf("%w: %d lines", ErrMalformed, len(lines))
+	}
+
+	fields := strings.Fields(lines[0])
+	if len(fields) < 9 {
+		return reading{}, fmt.Errorf("%w: %q", ErrMalformed, lines[0])
+	}
+	var pad [9]byte
+	for i := range pad {
+		v, err := strconv.ParseUint(fields[i], 16, 8)
+		if err != nil {
+			return reading{}, fmt.Errorf("%w: %q", ErrMalformed, lines[0])
+		}
+		pad[i] = byte(v)
+	}
+
+	if !strings.HasSuffix(lines[0], "YES") {
+		return reading{}, fmt.Errorf("%w: kernel reported %q", ErrCRC, lines[0])
+	}
+	if crc := drivers.CRC8(pad[:8]); crc != pad[8] {
+		return reading{}, fmt.Errorf("%w: got %#02x want %#02x", ErrCRC, pad[8], crc)
+	}
+	// a shorted bus reads all zeros which passes the crc, the
+	// reserved byte 7 of a real scratchpad is always 0x10
+	if pad[7] != 0x10 {
+		return reading{}, fmt.Errorf("%w: reserved byte %#02x", ErrMalformed, pad[7])
+	}
+
+	raw := uint16(pad[1])<<8 | uint16(pad[0])
+	if raw == powerOnRaw {
+		return reading{}, ErrPowerOn
+	}
+
+	res := resolutionFromConfig(pad[4])
+	raw &^= uint16(1)<<(12-res) - 1
+	return reading{
+		Celsius:    float64(int16(raw)) / 16,
+		Resolution: res,
+	}, nil
+}
+
+// resolutionFromConfig returns the bits from the configuration
+// register, R1 and R0 are bits 6 and 5
+func resolutionFromConfig(cfg byte) int {
+	return 9 + int(cfg>>5&0x03)
+}
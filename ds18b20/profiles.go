@@ -0,0 +1,27 @@
+package ds18b20
+
+import (
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// the mock profiles of a DS18B20, where the probe is. A "fermenter"
+// wanders slowly around 19.5°C. An "outdoor" probe follows the day,
+// the warmest at 3 in the afternoon when applied at midnight, and its
+// long cable fails the CRC of every 30th read.
+func init() {
+	device.RegisterMockProfile("ds18b20", "fermenter", func(d *DS18B20) error {
+		d.SetGenerator(device.Walk(19.5, 0.002, 18, 21, d.MockRand()))
+		d.InjectFaults()
+		return nil
+	})
+	device.RegisterMockProfile("ds18b20", "outdoor", func(d *DS18B20) error {
+		d.SetGenerator(device.Sum(
+			device.Shift(device.Sine(4, 24*time.Hour, -2), 9*time.Hour),
+			device.Noise(0.1, d.MockRand()),
+		))
+		d.InjectFaults(device.FailEvery(30), device.FailWith(ErrCRC))
+		return nil
+	})
+}
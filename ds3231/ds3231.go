@@ -0,0 +1,430 @@
+// Package ds3231 provides a driver for the DS3231 real time clock.
+// The clock keeps UTC in 24 hour mode on its battery, with its die
+// temperature and two alarms that pull the INT/SQW pin low.
+//
+// The oscillator stop flag is set when the clock stopped, a new
+// battery or a drained one, the time it keeps then is not trusted
+// until it is set again. SyncSystemTime sets the clock from the
+// system time once NTP synchronized it, or the system time from the
+// clock at boot.
+package ds3231
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+	Address       = 0x68
+)
+
+// registers
+const (
+	regTime    = 0x00
+	regAlarm1  = 0x07
+	regAlarm2  = 0x0B
+	regControl = 0x0E
+	regStatus  = 0x0F
+	regTemp    = 0x11
+)
+
+// control and status bits
+const (
+	ctlEOSC  = 0x80 // oscillator off on battery
+	ctlINTCN = 0x04 // alarms on INT/SQW instead of the square wave
+	ctlA2IE  = 0x02
+	ctlA1IE  = 0x01
+
+	statOSF = 0x80
+	statA2F = 0x02
+	statA1F = 0x01
+)
+
+var (
+	ErrOscillatorStopped = errors.New("ds3231 oscillator stopped, time not trusted")
+	ErrNoTimeSource      = errors.New("no trusted time to sync")
+	ErrReadFailed        = errors.New("failed to read from DS3231")
+)
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	Time        time.Time `json:"time"`
+	Trusted     bool      `json:"trusted"`
+	Temperature float64   `json:"temperature"`
+}
+
+// AlarmEvent is published when an alarm fires
+type AlarmEvent struct {
+	Event string    `json:"event"`
+	Alarm int       `json:"alarm"`
+	Time  time.Time `json:"time"`
+}
+
+// DS3231 is a real time clock on an I2C bus
+type DS3231 struct {
+	*device.Device
+
+	// SetSystemClock lets SyncSystemTime set the system clock from
+	// the RTC
+	SetSystemClock bool
+
+	bus     string
+	addr    int
+	dev     *drivers.I2CDevice
+	intPin  *drivers.DigitalPin
+	onAlarm []func(alarm int)
+	mu      sync.Mutex
+}
+
+// New creates a DS3231 at the given bus and address, the clock is
+// not touched until Init
+func New(name, bus string, addr int) *DS3231 {
+	return &DS3231{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		addr:   addr,
+	}
+}
+
+// Init opens the i2c bus, keeps the oscillator running on battery
+// and routes the alarms to INT/SQW
+func (r *DS3231) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(r.bus, r.addr)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dev = dev
+	return dev.UpdateBits(regControl, ctlEOSC|ctlINTCN, ctlINTCN)
+}
+
+// Name returns the name of the device
+func (r *DS3231) Name() string {
+	return r.Device.Name
+}
+
+// Time reads the clock. If the oscillator stopped it returns
+// ErrOscillatorStopped along with the time.
+func (r *DS3231) Time() (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.time()
+}
+
+func (r *DS3231) time() (time.Time, error) {
+	if device.IsMock() {
+		return time.Now().UTC().Truncate(time.Second), nil
+	}
+	if r.dev == nil {
+		return time.Time{}, errors.New("not initialized")
+	}
+
+	var regs [7]byte
+	var status byte
+	err := r.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.ReadReg(regTime, regs[:]); err != nil {
+			return err
+		}
+		buf := make([]byte, 1)
+		if err := bus.ReadReg(regStatus, buf); err != nil {
+			return err
+		}
+		status = buf[0]
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	t, err := decodeTime(regs)
+	if err != nil {
+		return t, err
+	}
+	if status&statOSF != 0 {
+		return t, ErrOscillatorStopped
+	}
+	return t, nil
+}
+
+// SetTime sets the clock to t, to the second, and clears the
+// oscillator stop flag
+func (r *DS3231) SetTime(t time.Time) error {
+	regs, err := encodeTime(t)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if device.IsMock() {
+		return nil
+	}
+	if r.dev == nil {
+		return errors.New("not initialized")
+	}
+	return r.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.WriteReg(regTime, regs[:]); err != nil {
+			return err
+		}
+		buf := make([]byte, 1)
+		if err := bus.ReadReg(regStatus, buf); err != nil {
+			return err
+		}
+		return bus.WriteReg(regStatus, []byte{buf[0] &^ statOSF})
+	})
+}
+
+// Temperature returns the die temperature in °C, to 0.25°C. The
+// clock measures it every 64 seconds.
+func (r *DS3231) Temperature() (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.temperature()
+}
+
+func (r *DS3231) temperature() (float64, error) {
+	if device.IsMock() {
+		return 25, nil
+	}
+	if r.dev == nil {
+		return 0, errors.New("not initialized")
+	}
+	buf, err := r.dev.ReadBlock(regTemp, 2)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return celsius(buf[0], buf[1]), nil
+}
+
+// celsius converts the temperature registers, a signed byte of
+// degrees and the quarters in the top bits of the second
+func celsius(msb, lsb byte) float64 {
+	return float64(int16(uint16(msb)<<8|uint16(lsb))>>6) / 4
+}
+
+// Read reads the time and the temperature
+func (r *DS3231) Read() (*Reading, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, err := r.time()
+	if err != nil && !errors.Is(err, ErrOscillatorStopped) {
+		return nil, err
+	}
+	rd := &Reading{Time: t, Trusted: err == nil}
+	if rd.Temperature, err = r.temperature(); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// SetAlarm sets alarm 1 or 2 and enables its interrupt
+func (r *DS3231) SetAlarm(n int, a Alarm) error {
+	regs, err := encodeAlarm(n, a)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if device.IsMock() || r.dev == nil {
+		return nil
+	}
+	reg, ie, flag := alarmRegs(n)
+	return r.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.WriteReg(reg, regs); err != nil {
+			return err
+		}
+		if err := update(bus, regStatus, flag, 0); err != nil {
+			return err
+		}
+		return update(bus, regControl, ie, ie)
+	})
+}
+
+// ClearAlarm disables alarm 1 or 2
+func (r *DS3231) ClearAlarm(n int) error {
+	if n != 1 && n != 2 {
+		return fmt.Errorf("%w: no alarm %d", ErrAlarm, n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if device.IsMock() || r.dev == nil {
+		return nil
+	}
+	_, ie, flag := alarmRegs(n)
+	return r.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := update(bus, regControl, ie, 0); err != nil {
+			return err
+		}
+		return update(bus, regStatus, flag, 0)
+	})
+}
+
+// alarmRegs returns the first register, the interrupt enable and the
+// flag bit of alarm n
+func alarmRegs(n int) (reg, ie, flag byte) {
+	if n == 2 {
+		return regAlarm2, ctlA2IE, statA2F
+	}
+	return regAlarm1, ctlA1IE, statA1F
+}
+
+// update sets the mask bits of reg to value within a transaction
+func update(bus drivers.I2CBus, reg, mask, value byte) error {
+	buf := make([]byte, 1)
+	if err := bus.ReadReg(reg, buf); err != nil {
+		return err
+	}
+	return bus.WriteReg(reg, []byte{buf[0]&^mask | value&mask})
+}
+
+// OnAlarm calls fn with the alarm, 1 or 2, every time one fires
+func (r *DS3231) OnAlarm(fn func(alarm int)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onAlarm = append(r.onAlarm, fn)
+}
+
+// Alarms reads and clears the flags of the enabled alarms and
+// returns the alarms that fired
+func (r *DS3231) Alarms() ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dev == nil {
+		return nil, nil
+	}
+	var fired []int
+	err := r.dev.Tx(func(bus drivers.I2CBus) error {
+		buf := make([]byte, 2)
+		if err := bus.ReadReg(regControl, buf); err != nil {
+			return err
+		}
+		control, status := buf[0], buf[1]
+		var flags byte
+		for n := 1; n <= 2; n++ {
+			_, ie, flag := alarmRegs(n)
+			if control&ie != 0 && status&flag != 0 {
+				fired = append(fired, n)
+				flags |= flag
+			}
+		}
+		if flags == 0 {
+			return nil
+		}
+		// the pin stays low until the flags are cleared
+		return bus.WriteReg(regStatus, []byte{status &^ flags})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return fired, nil
+}
+
+// HandleInterrupt publishes the alarms that fired and calls the
+// OnAlarm functions
+func (r *DS3231) HandleInterrupt() error {
+	fired, err := r.Alarms()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	fns := append([]func(int){}, r.onAlarm...)
+	r.mu.Unlock()
+
+	for _, n := range fired {
+		j, err := json.Marshal(&AlarmEvent{Event: "alarm", Alarm: n, Time: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		r.PubData(j)
+		for _, fn := range fns {
+			fn(n)
+		}
+	}
+	return nil
+}
+
+// WatchInterrupt requests the pin id (see drivers.ParsePinID) wired
+// to INT/SQW and publishes the alarms it signals. Without it ReadPub
+// polls for alarms every period.
+func (r *DS3231) WatchInterrupt(id string) error {
+	p, err := drivers.NewDigitalPinID(r.Device.Name+"-int", id,
+		gpiocdev.AsInput,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithFallingEdge,
+		gpiocdev.WithEventHandler(func(gpiocdev.LineEvent) {
+			if err := r.HandleInterrupt(); err != nil {
+				slog.Error("ds3231 interrupt", "device", r.Device.Name, "error", err)
+			}
+		}))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.intPin = p
+	r.mu.Unlock()
+
+	// an alarm that fired before the pin was watched holds it low
+	return r.HandleInterrupt()
+}
+
+// ReadPub publishes the time and temperature, and the alarms that
+// fired if INT/SQW is not watched
+func (r *DS3231) ReadPub() error {
+	r.mu.Lock()
+	poll := r.intPin == nil
+	r.mu.Unlock()
+	if poll {
+		if err := r.HandleInterrupt(); err != nil {
+			return err
+		}
+	}
+
+	rd, err := r.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (r *DS3231) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("ds3231 stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the interrupt pin and closes the i2c device, the
+// clock keeps running on its battery
+func (r *DS3231) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	if r.intPin != nil {
+		errs = append(errs, r.intPin.Close())
+		r.intPin = nil
+	}
+	if r.dev != nil {
+		errs = append(errs, r.dev.Close())
+		r.dev = nil
+	}
+	return errors.Join(errs...)
+}
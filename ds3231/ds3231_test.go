@@ -0,0 +1,340 @@
+package ds3231
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func TestBCD(t *testing.T) {
+	for v := 0; v <= 99; v++ {
+		if got := fromBCD(bcd(v)); got != v {
+			t.Errorf("fromBCD(bcd(%d)) got (%d)", v, got)
+		}
+	}
+	if got := bcd(59); got != 0x59 {
+		t.Errorf("bcd(59) got (%#02x) want (0x59)", got)
+	}
+	for _, b := range []byte{0x1A, 0xA0, 0xFF} {
+		if got := fromBCD(b); got != -1 {
+			t.Errorf("fromBCD(%#02x) got (%d) want (-1)", b, got)
+		}
+	}
+}
+
+func TestLeap(t *testing.T) {
+	years := map[int]bool{2000: true, 2023: false, 2024: true, 2100: false, 2104: true, 2400: true}
+	for y, want := range years {
+		if got := leap(y); got != want {
+			t.Errorf("leap(%d) got (%t) want (%t)", y, got, want)
+		}
+	}
+	days := []struct {
+		year  int
+		month time.Month
+		want  int
+	}{
+		{2024, time.February, 29},
+		{2023, time.February, 28},
+		{2100, time.February, 28},
+		{2024, time.April, 30},
+		{2024, time.December, 31},
+	}
+	for _, tt := range days {
+		if got := daysIn(tt.year, tt.month); got != tt.want {
+			t.Errorf("daysIn(%d, %s) got (%d) want (%d)", tt.year, tt.month, got, tt.want)
+		}
+	}
+}
+
+func TestTimeRegisters(t *testing.T) {
+	times := []struct {
+		t    time.Time
+		regs [7]byte
+	}{
+		// a Thursday
+		{time.Date(2024, time.February, 29, 13, 45, 7, 0, time.UTC), [7]byte{0x07, 0x45, 0x13, 0x05, 0x29, 0x02, 0x24}},
+		{time.Date(2099, time.December, 31, 23, 59, 59, 0, time.UTC), [7]byte{0x59, 0x59, 0x23, 0x05, 0x31, 0x12, 0x99}},
+		// the century bit
+		{time.Date(2100, time.March, 1, 0, 0, 0, 0, time.UTC), [7]byte{0x00, 0x00, 0x00, 0x02, 0x01, 0x83, 0x00}},
+	}
+	for _, tt := range times {
+		regs, err := encodeTime(tt.t)
+		if err != nil || regs != tt.regs {
+			t.Errorf("encodeTime(%v) got (% x, %v) want (% x)", tt.t, regs, err, tt.regs)
+		}
+		got, err := decodeTime(tt.regs)
+		if err != nil || !got.Equal(tt.t) {
+			t.Errorf("decodeTime(% x) got (%v, %v) want (%v)", tt.regs, got, err, tt.t)
+		}
+	}
+
+	// local times are kept in UTC
+	est := time.FixedZone("EST", -5*3600)
+	if regs, _ := encodeTime(time.Date(2024, time.February, 28, 20, 0, 0, 0, est)); regs[2] != 0x01 || regs[4] != 0x29 {
+		t.Errorf("encodeTime(EST) got (% x) want 01:00 on the 29th", regs)
+	}
+	if _, err := encodeTime(time.Date(1999, time.December, 31, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrRange) {
+		t.Errorf("encodeTime(1999) error got (%v) want (%v)", err, ErrRange)
+	}
+
+	// 12 hour mode
+	hours := map[byte]int{0x52: 0, 0x41: 1, 0x72: 12, 0x61: 13, 0x71: 23}
+	for reg, want := range hours {
+		got, err := decodeTime([7]byte{0, 0, reg, 1, 0x01, 0x01, 0x24})
+		if err != nil || got.Hour() != want {
+			t.Errorf("decodeTime(hour %#02x) got (%v, %v) want (%d)", reg, got, err, want)
+		}
+	}
+
+	invalid := [][7]byte{
+		{0x00, 0x00, 0x00, 1, 0x29, 0x02, 0x23}, // not a leap year
+		{0x00, 0x00, 0x00, 1, 0x29, 0x82, 0x00}, // 2100 neither
+		{0x00, 0x00, 0x00, 1, 0x31, 0x04, 0x24},
+		{0x60, 0x00, 0x00, 1, 0x01, 0x01, 0x24},
+		{0x00, 0x00, 0x24, 1, 0x01, 0x01, 0x24},
+		{0x00, 0x00, 0x40, 1, 0x01, 0x01, 0x24}, // 12 hour 0
+		{0x00, 0x00, 0x00, 1, 0x01, 0x13, 0x24},
+		{0x00, 0x00, 0x00, 1, 0x00, 0x01, 0x24},
+		{0x0A, 0x00, 0x00, 1, 0x01, 0x01, 0x24},
+		{0x00, 0x00, 0x00, 0, 0x00, 0x00, 0x00}, // never set
+	}
+	for _, regs := range invalid {
+		if _, err := decodeTime(regs); !errors.Is(err, ErrTime) {
+			t.Errorf("decodeTime(% x) error got (%v) want (%v)", regs, err, ErrTime)
+		}
+	}
+}
+
+func TestAlarmRegisters(t *testing.T) {
+	at := Alarm{Second: 30, Minute: 15, Hour: 6, Date: 31, Weekday: time.Monday}
+	alarms := []struct {
+		n    int
+		rate AlarmRate
+		want []byte
+	}{
+		{1, EverySecond, []byte{0x80, 0x80, 0x80, 0x80}},
+		{1, EveryMinute, []byte{0x00, 0x80, 0x80, 0x80}},
+		{1, MatchSecond, []byte{0x30, 0x80, 0x80, 0x80}},
+		{1, MatchMinute, []byte{0x30, 0x15, 0x80, 0x80}},
+		{1, MatchHour, []byte{0x30, 0x15, 0x06, 0x80}},
+		{1, MatchDate, []byte{0x30, 0x15, 0x06, 0x31}},
+		{1, MatchWeekday, []byte{0x30, 0x15, 0x06, 0x42}},
+	}
+	for _, tt := range alarms {
+		a := at
+		a.Rate = tt.rate
+		got, err := encodeAlarm(tt.n, a)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeAlarm(%d, rate %d) got (% x, %v) want (% x)", tt.n, tt.rate, got, err, tt.want)
+		}
+	}
+
+	at.Second = 0
+	alarm2 := []struct {
+		rate AlarmRate
+		want []byte
+	}{
+		{EveryMinute, []byte{0x80, 0x80, 0x80}},
+		{MatchMinute, []byte{0x15, 0x80, 0x80}},
+		{MatchHour, []byte{0x15, 0x06, 0x80}},
+		{MatchDate, []byte{0x15, 0x06, 0x31}},
+		{MatchWeekday, []byte{0x15, 0x06, 0x42}},
+	}
+	for _, tt := range alarm2 {
+		a := at
+		a.Rate = tt.rate
+		got, err := encodeAlarm(2, a)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeAlarm(2, rate %d) got (% x, %v) want (% x)", tt.rate, got, err, tt.want)
+		}
+	}
+
+	bad := []struct {
+		n int
+		a Alarm
+	}{
+		{3, Alarm{Rate: EverySecond}},
+		{2, Alarm{Rate: EverySecond}},
+		{2, Alarm{Rate: MatchSecond}},
+		{2, Alarm{Rate: MatchMinute, Second: 5}},
+		{1, Alarm{Rate: MatchMinute, Minute: 60}},
+		{1, Alarm{Rate: MatchHour, Hour: 24}},
+		{1, Alarm{Rate: MatchDate}},
+		{1, Alarm{Rate: MatchWeekday, Weekday: 7}},
+		{1, Alarm{Rate: 9}},
+	}
+	for _, tt := range bad {
+		if _, err := encodeAlarm(tt.n, tt.a); !errors.Is(err, ErrAlarm) {
+			t.Errorf("encodeAlarm(%d, %+v) error got (%v) want (%v)", tt.n, tt.a, err, ErrAlarm)
+		}
+	}
+}
+
+func TestCelsius(t *testing.T) {
+	temps := []struct {
+		msb, lsb byte
+		want     float64
+	}{
+		{0x19, 0x40, 25.25},
+		{0x19, 0xC0, 25.75},
+		{0x00, 0x00, 0},
+		{0xFF, 0xC0, -0.25},
+		{0xE7, 0x00, -25},
+	}
+	for _, tt := range temps {
+		if got := celsius(tt.msb, tt.lsb); got != tt.want {
+			t.Errorf("celsius(%#02x, %#02x) got (%g) want (%g)", tt.msb, tt.lsb, got, tt.want)
+		}
+	}
+}
+
+func newClock(t *testing.T) (*DS3231, *driverstest.I2C) {
+	t.Helper()
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	// the oscillator off on battery, as it is after a new battery
+	fake.Set(regControl, ctlEOSC|0x18, statOSF)
+	r := New("rtc-test", TestI2CBus, Address)
+	if err := r.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, fake
+}
+
+func TestTimeTrust(t *testing.T) {
+	r, fake := newClock(t)
+	if got := fake.Get(regControl, 1)[0]; got != 0x1C {
+		t.Errorf("control got (%#02x) want (0x1c)", got)
+	}
+
+	fake.Set(regTime, 0x07, 0x45, 0x13, 0x05, 0x29, 0x02, 0x24)
+	fake.Set(regTemp, 0x19, 0x40)
+	want := time.Date(2024, time.February, 29, 13, 45, 7, 0, time.UTC)
+
+	got, err := r.Time()
+	if !errors.Is(err, ErrOscillatorStopped) || !got.Equal(want) {
+		t.Errorf("Time() got (%v, %v) want (%v, %v)", got, err, want, ErrOscillatorStopped)
+	}
+	rd, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if rd.Trusted || rd.Temperature != 25.25 {
+		t.Errorf("Read() got (%+v) want untrusted at 25.25", rd)
+	}
+
+	set := time.Date(2031, time.July, 4, 8, 30, 0, 0, time.UTC)
+	if err := r.SetTime(set); err != nil {
+		t.Fatalf("SetTime() error = %v", err)
+	}
+	if got := fake.Get(regTime, 7); !bytes.Equal(got, []byte{0x00, 0x30, 0x08, 0x06, 0x04, 0x07, 0x31}) {
+		t.Errorf("time registers got (% x)", got)
+	}
+	if got := fake.Get(regStatus, 1)[0]; got != 0 {
+		t.Errorf("status got (%#02x) want the oscillator stop flag cleared", got)
+	}
+	if got, err := r.Time(); err != nil || !got.Equal(set) {
+		t.Errorf("Time() got (%v, %v) want (%v)", got, err, set)
+	}
+
+	fake.Set(regTime, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00)
+	if _, err := r.Read(); !errors.Is(err, ErrTime) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrTime)
+	}
+}
+
+func TestAlarmInterrupt(t *testing.T) {
+	r, fake := newClock(t)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	if err := r.SetAlarm(1, Alarm{Rate: MatchHour, Second: 30, Minute: 15, Hour: 6}); err != nil {
+		t.Fatalf("SetAlarm(1) error = %v", err)
+	}
+	if err := r.SetAlarm(2, Alarm{Rate: EveryMinute}); err != nil {
+		t.Fatalf("SetAlarm(2) error = %v", err)
+	}
+	if got := fake.Get(regAlarm1, 7); !bytes.Equal(got, []byte{0x30, 0x15, 0x06, 0x80, 0x80, 0x80, 0x80}) {
+		t.Errorf("alarm registers got (% x)", got)
+	}
+	if got := fake.Get(regControl, 2); got[0] != 0x1F || got[1] != statOSF {
+		t.Errorf("control and status got (% x) want (1f 80)", got)
+	}
+	if err := r.ClearAlarm(2); err != nil {
+		t.Fatalf("ClearAlarm(2) error = %v", err)
+	}
+
+	var fired []int
+	r.OnAlarm(func(n int) { fired = append(fired, n) })
+	if err := r.WatchInterrupt("7"); err != nil {
+		t.Fatalf("WatchInterrupt() error = %v", err)
+	}
+
+	// alarm 2 fires but is disabled
+	fake.Set(regStatus, statOSF|statA2F|statA1F)
+	chip.Line(7).Edge(0)
+	if len(fired) != 1 || fired[0] != 1 {
+		t.Errorf("OnAlarm got (%v) want ([1])", fired)
+	}
+	if got := fake.Get(regStatus, 1)[0]; got != statOSF|statA2F {
+		t.Errorf("status got (%#02x) want (0x82)", got)
+	}
+
+	r.Close()
+	if !chip.Line(7).Closed() || !fake.Closed() {
+		t.Error("Close() did not release the pin and the bus")
+	}
+}
+
+func TestSyncSystemTime(t *testing.T) {
+	r, fake := newClock(t)
+	fake.Set(regTime, 0x07, 0x45, 0x13, 0x05, 0x29, 0x02, 0x24)
+
+	ntp := false
+	var system time.Time
+	systemSynced = func() (bool, error) { return ntp, nil }
+	setSystem = func(t time.Time) error { system = t; return nil }
+	now = func() time.Time { return time.Date(2025, time.May, 1, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { systemSynced, setSystem, now = ntpSynced, settimeofday, time.Now })
+
+	if got, err := r.SyncSystemTime(); got != "" || !errors.Is(err, ErrNoTimeSource) {
+		t.Errorf("SyncSystemTime() got (%q, %v) want (%v)", got, err, ErrNoTimeSource)
+	}
+	// an RTC that stopped is not trusted
+	r.SetSystemClock = true
+	if got, err := r.SyncSystemTime(); got != "" || !errors.Is(err, ErrNoTimeSource) {
+		t.Errorf("SyncSystemTime(stopped) got (%q, %v) want (%v)", got, err, ErrNoTimeSource)
+	}
+
+	ntp = true
+	if got, err := r.SyncSystemTime(); got != "rtc" || err != nil {
+		t.Errorf("SyncSystemTime(ntp) got (%q, %v) want (rtc)", got, err)
+	}
+	if got := fake.Get(regTime, 7); !bytes.Equal(got, []byte{0x00, 0x00, 0x12, 0x05, 0x01, 0x05, 0x25}) {
+		t.Errorf("time registers got (% x)", got)
+	}
+
+	ntp = false
+	if got, err := r.SyncSystemTime(); got != "system" || err != nil {
+		t.Errorf("SyncSystemTime(rtc) got (%q, %v) want (system)", got, err)
+	}
+	if !system.Equal(now()) {
+		t.Errorf("system clock got (%v) want (%v)", system, now())
+	}
+
+	// a clock that kept running but was never set
+	fake.Set(regTime, 0x00, 0x00, 0x00, 0x07, 0x01, 0x01, 0x00)
+	if got, err := r.SyncSystemTime(); got != "" || !errors.Is(err, ErrNoTimeSource) {
+		t.Errorf("SyncSystemTime(2000) got (%q, %v) want (%v)", got, err, ErrNoTimeSource)
+	}
+}
@@ -0,0 +1,196 @@
+package ds3231
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTime  = errors.New("ds3231 registers hold no valid time")
+	ErrRange = errors.New("ds3231 keeps the years 2000 to 2199")
+	ErrAlarm = errors.New("invalid ds3231 alarm")
+)
+
+// bcd returns v, 0 to 99, in binary coded decimal
+func bcd(v int) byte {
+	return byte(v/10<<4 | v%10)
+}
+
+// fromBCD returns the value of the binary coded decimal b, -1 if a
+// digit is over 9
+func fromBCD(b byte) int {
+	hi, lo := int(b>>4), int(b&0x0F)
+	if hi > 9 || lo > 9 {
+		return -1
+	}
+	return hi*10 + lo
+}
+
+// leap reports if year is a leap year of the Gregorian calendar. The
+// DS3231 takes every fourth year as a leap year, which holds until
+// 2100.
+func leap(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// daysIn returns the number of days of month in year
+func daysIn(year int, month time.Month) int {
+	switch month {
+	case time.February:
+		if leap(year) {
+			return 29
+		}
+		return 28
+	case time.April, time.June, time.September, time.November:
+		return 30
+	}
+	return 31
+}
+
+// encodeTime returns the timekeeping registers 0x00 - 0x06 for t in
+// UTC, in 24 hour mode with the century bit set from 2100
+func encodeTime(t time.Time) ([7]byte, error) {
+	t = t.UTC()
+	if t.Year() < 2000 || t.Year() > 2199 {
+		return [7]byte{}, fmt.Errorf("%w: %d", ErrRange, t.Year())
+	}
+	century := byte(0)
+	if t.Year() >= 2100 {
+		century = 0x80
+	}
+	return [7]byte{
+		bcd(t.Second()),
+		bcd(t.Minute()),
+		bcd(t.Hour()),
+		byte(t.Weekday()) + 1,
+		bcd(t.Day()),
+		bcd(int(t.Month())) | century,
+		bcd(t.Year() % 100),
+	}, nil
+}
+
+// decodeTime returns the UTC time of the timekeeping registers, the
+// hours in 12 or 24 hour mode. The day of the week is not checked.
+func decodeTime(r [7]byte) (time.Time, error) {
+	sec := fromBCD(r[0] & 0x7F)
+	min := fromBCD(r[1] & 0x7F)
+	hour := fromBCD(r[2] & 0x3F)
+	if r[2]&0x40 != 0 { // 12 hour mode, bit 5 is PM
+		hour = fromBCD(r[2] & 0x1F)
+		if hour < 1 || hour > 12 {
+			hour = -1
+		} else if hour %= 12; r[2]&0x20 != 0 {
+			hour += 12
+		}
+	}
+	day := fromBCD(r[4] & 0x3F)
+	month := time.Month(fromBCD(r[5] & 0x1F))
+	year := 2000 + fromBCD(r[6])
+	if r[5]&0x80 != 0 {
+		year += 100
+	}
+	if sec < 0 || sec > 59 || min < 0 || min > 59 || hour < 0 || hour > 23 ||
+		year < 2000 || month < 1 || month > 12 || day < 1 || day > daysIn(year, month) {
+		return time.Time{}, fmt.Errorf("%w: % x", ErrTime, r)
+	}
+	return time.Date(year, month, day, hour, min, sec, 0, time.UTC), nil
+}
+
+// AlarmRate is what an alarm matches
+type AlarmRate int
+
+const (
+	// EverySecond fires every second, alarm 1 only
+	EverySecond AlarmRate = iota
+	// EveryMinute fires at second 0 of every minute
+	EveryMinute
+	// MatchSecond fires when the seconds match, alarm 1 only
+	MatchSecond
+	// MatchMinute fires when the minutes and seconds match
+	MatchMinute
+	// MatchHour fires when the hours, minutes and seconds match
+	MatchHour
+	// MatchDate fires when the day of the month and the time match
+	MatchDate
+	// MatchWeekday fires when the day of the week and the time match
+	MatchWeekday
+)
+
+// Alarm is when an alarm fires, in UTC. Alarm 2 has no seconds, it
+// fires at second 0.
+type Alarm struct {
+	Rate    AlarmRate
+	Second  int
+	Minute  int
+	Hour    int
+	Date    int
+	Weekday time.Weekday
+}
+
+// encodeAlarm returns the registers of alarm n, 0x07 - 0x0A for
+// alarm 1 and 0x0B - 0x0D for alarm 2. Bit 7 of a register masks it
+// out of the match, the fields below the rate are masked.
+func encodeAlarm(n int, a Alarm) ([]byte, error) {
+	if n != 1 && n != 2 {
+		return nil, fmt.Errorf("%w: no alarm %d", ErrAlarm, n)
+	}
+	if n == 2 && a.Second != 0 {
+		return nil, fmt.Errorf("%w: alarm 2 has no seconds", ErrAlarm)
+	}
+
+	// the fields matched from the seconds up
+	match := 0
+	switch a.Rate {
+	case EverySecond:
+		if n == 2 {
+			return nil, fmt.Errorf("%w: alarm 2 cannot fire every second", ErrAlarm)
+		}
+	case EveryMinute:
+		match, a.Second = 1, 0
+	case MatchSecond:
+		if n == 2 {
+			return nil, fmt.Errorf("%w: alarm 2 has no seconds", ErrAlarm)
+		}
+		match = 1
+	case MatchMinute:
+		match = 2
+	case MatchHour:
+		match = 3
+	case MatchDate, MatchWeekday:
+		match = 4
+	default:
+		return nil, fmt.Errorf("%w: rate %d", ErrAlarm, a.Rate)
+	}
+
+	day := bcd(a.Date)
+	fields := []struct {
+		ok  bool
+		reg byte
+	}{
+		{a.Second >= 0 && a.Second <= 59, bcd(a.Second)},
+		{a.Minute >= 0 && a.Minute <= 59, bcd(a.Minute)},
+		{a.Hour >= 0 && a.Hour <= 23, bcd(a.Hour)},
+		{a.Date >= 1 && a.Date <= 31, day},
+	}
+	if a.Rate == MatchWeekday {
+		fields[3].ok = a.Weekday >= time.Sunday && a.Weekday <= time.Saturday
+		fields[3].reg = byte(a.Weekday) + 1 | 0x40 // DY/DT
+	}
+
+	regs := make([]byte, len(fields))
+	for i, f := range fields {
+		switch {
+		case i >= match:
+			regs[i] = 0x80
+		case !f.ok:
+			return nil, fmt.Errorf("%w: %+v", ErrAlarm, a)
+		default:
+			regs[i] = f.reg
+		}
+	}
+	if n == 2 {
+		return regs[1:], nil
+	}
+	return regs, nil
+}
@@ -0,0 +1,62 @@
+package ds3231
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// MinTrusted is the earliest RTC time SyncSystemTime sets the system
+// clock to, anything before is a clock that was never set
+var MinTrusted = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// the system clock, replaced by the tests
+var (
+	systemSynced = ntpSynced
+	setSystem    = settimeofday
+	now          = time.Now
+)
+
+// SyncSystemTime sets the RTC from the system clock when NTP keeps it
+// synchronized. Otherwise, with SetSystemClock set, it sets the system
+// clock from a trusted RTC, which is what a station without a network
+// wants at boot. It returns the clock it set, "rtc" or "system", or
+// ErrNoTimeSource if neither had a time to trust.
+func (r *DS3231) SyncSystemTime() (string, error) {
+	synced, err := systemSynced()
+	if err != nil {
+		return "", err
+	}
+	if synced {
+		return "rtc", r.SetTime(now())
+	}
+	if !r.SetSystemClock {
+		return "", ErrNoTimeSource
+	}
+
+	t, err := r.Time()
+	if errors.Is(err, ErrOscillatorStopped) || err == nil && t.Before(MinTrusted) {
+		return "", ErrNoTimeSource
+	}
+	if err != nil {
+		return "", err
+	}
+	return "system", setSystem(t)
+}
+
+// ntpSynced reports if the kernel clock is synchronized, NTP clears
+// STA_UNSYNC once it disciplines the clock
+func ntpSynced() (bool, error) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return false, err
+	}
+	return state != unix.TIME_ERROR && tx.Status&unix.STA_UNSYNC == 0, nil
+}
+
+func settimeofday(t time.Time) error {
+	tv := unix.NsecToTimeval(t.UnixNano())
+	return unix.Settimeofday(&tv)
+}
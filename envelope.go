@@ -0,0 +1,104 @@
+package device
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is the version of the Envelope, its schema field
+const SchemaVersion = 1
+
+// Envelope wraps what a device publishes with PubData and PubState,
+// so a reading retained or come late is placed at the time it was
+// taken. Seq counts the publishes on a topic of the device, a gap in
+// it is a message lost. Data is the payload, as JSON when it is.
+// Replay is set on a payload a Buffer kept while the connection was
+// down and published once it was back, the TS still the one it was
+// taken at.
+type Envelope struct {
+	Schema int             `json:"schema"`
+	TS     string          `json:"ts"`
+	Device string          `json:"device"`
+	Seq    uint64          `json:"seq"`
+	Replay bool            `json:"replay,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+var (
+	envelopes   = true
+	envelopesMu sync.RWMutex
+)
+
+// Envelopes reports if the devices created wrap their payloads in an
+// Envelope, they do unless SetEnvelopes turned it off
+func Envelopes() bool {
+	envelopesMu.RLock()
+	defer envelopesMu.RUnlock()
+	return envelopes
+}
+
+// SetEnvelopes sets if the devices created wrap their payloads in an
+// Envelope, off for a deployment where every byte counts. Set it
+// before creating the devices, WithEnvelope sets it for one.
+func SetEnvelopes(on bool) {
+	envelopesMu.Lock()
+	defer envelopesMu.Unlock()
+	envelopes = on
+}
+
+// WithEnvelope sets if the device wraps its payloads in an Envelope,
+// the station's Envelopes unless it is given
+func WithEnvelope(on bool) Option {
+	return func(d *Device) {
+		d.enveloped = on
+	}
+}
+
+// Enveloped reports if the device wraps its payloads in an Envelope
+func (d *Device) Enveloped() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.enveloped
+}
+
+// SetEnveloped sets if the device wraps its payloads in an Envelope
+func (d *Device) SetEnveloped(on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.enveloped = on
+}
+
+// envelope returns the Envelope of the next publish on topic when the
+// device wraps its payloads, counting it in the seq of the topic
+func (d *Device) envelope(topic string) *Envelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.enveloped {
+		return nil
+	}
+	if d.seq == nil {
+		d.seq = make(map[string]uint64)
+	}
+	d.seq[topic]++
+	return &Envelope{
+		Schema: SchemaVersion,
+		TS:     time.Now().UTC().Format(time.RFC3339Nano),
+		Device: d.Name,
+		Seq:    d.seq[topic],
+	}
+}
+
+// jsonData returns payload as JSON, a string when it is not, null
+// when it is empty
+func jsonData(payload []byte) json.RawMessage {
+	switch {
+	case len(payload) == 0:
+		return json.RawMessage("null")
+	case json.Valid(payload):
+		return append(json.RawMessage(nil), payload...)
+	default:
+		data, _ := json.Marshal(string(payload))
+		return data
+	}
+}
@@ -0,0 +1,69 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("bme280", "mqtt", WithMessanger(m))
+	if !d.Enveloped() || !Envelopes() {
+		t.Fatal("devices are not enveloped by default")
+	}
+
+	before := time.Now()
+	d.PubData(`{"temperature":"70.10"}`)
+	d.PubData("on")
+	d.PubState(1)
+	d.PubRetained(AvailabilityTopic("bme280"), Online)
+
+	var data []Envelope
+	for _, msg := range m.Messages(d.DataTopic()) {
+		var env Envelope
+		if err := json.Unmarshal(msg.Data, &env); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", msg, err)
+		}
+		data = append(data, env)
+	}
+	if len(data) != 2 {
+		t.Fatalf("data got (%+v) want 2 envelopes", data)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, data[0].TS)
+	if err != nil || ts.Before(before.Add(-time.Second)) {
+		t.Errorf("ts got (%s, %v)", data[0].TS, err)
+	}
+	if data[0].Schema != SchemaVersion || data[0].Device != "bme280" || data[0].Seq != 1 || string(data[0].Data) != `{"temperature":"70.10"}` {
+		t.Errorf("envelope got (%+v)", data[0])
+	}
+	if data[1].Seq != 2 || string(data[1].Data) != `"on"` {
+		t.Errorf("second envelope got (%+v) want seq 2 and the string", data[1])
+	}
+
+	// each topic counts its own, a consumer of one sees no gaps
+	var state Envelope
+	json.Unmarshal(m.Messages(d.StateTopic())[0].Data, &state)
+	if state.Seq != 1 || string(state.Data) != "1" {
+		t.Errorf("state envelope got (%+v)", state)
+	}
+	// the availability is what Home Assistant expects
+	if msg, _ := m.Retained(AvailabilityTopic("bme280")); msg.String() != Online {
+		t.Errorf("PubRetained() got (%v) want (online)", msg)
+	}
+
+	SetEnvelopes(false)
+	defer SetEnvelopes(true)
+	m.Reset()
+	small := NewDevice("lux", "mqtt", WithMessanger(m))
+	small.PubData(120.5)
+	NewDevice("co2", "mqtt", WithMessanger(m), WithEnvelope(true)).PubData(400)
+	if msgs := m.Messages("#"); len(msgs) != 2 || msgs[0].String() != "120.50" || msgs[1].String()[0] != '{' {
+		t.Errorf("SetEnvelopes(false) got (%v) want lux bare and co2 enveloped", msgs)
+	}
+	small.SetEnveloped(true)
+	small.PubData(121.0)
+	if msgs := m.Messages(small.DataTopic()); msgs[len(msgs)-1].String()[0] != '{' {
+		t.Errorf("SetEnveloped(true) got (%v)", msgs[len(msgs)-1])
+	}
+}
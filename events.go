@@ -0,0 +1,87 @@
+package device
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EventType tells what an Event is about
+type EventType string
+
+const (
+	EventReading EventType = "reading" // data published with PubData
+	EventState   EventType = "state"   // state published with PubState
+	EventAdded   EventType = "added"   // a device added to the manager
+	EventRemoved EventType = "removed" // a device removed from it
+
+	// EventConnected is a Messanger connected, again after a
+	// connection lost, EventDisconnected the connection lost. Their
+	// Data is the connection, no device.
+	EventConnected    EventType = "connected"
+	EventDisconnected EventType = "disconnected"
+)
+
+// connection is the Data of an EventConnected or EventDisconnected
+type connection struct {
+	Broker string `json:"broker"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Event is something a device did, handed to the listeners of
+// SubscribeEvents like the /devices/stream clients. Data is the
+// payload published, as JSON when it is.
+type Event struct {
+	Type   EventType       `json:"type"`
+	Device string          `json:"device"`
+	Topic  string          `json:"topic,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Time   time.Time       `json:"time"`
+}
+
+var (
+	listeners   = make(map[chan Event]struct{})
+	listenersMu sync.RWMutex
+)
+
+// SubscribeEvents returns a channel of the events, holding up to
+// size of them, and the func to stop. The events a listener is too
+// slow to take are dropped, a device never waits on it.
+func SubscribeEvents(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	listenersMu.Lock()
+	listeners[ch] = struct{}{}
+	listenersMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			listenersMu.Lock()
+			delete(listeners, ch)
+			listenersMu.Unlock()
+		})
+	}
+}
+
+// emit hands the event of device name to the listeners, data as
+// PubData would publish it in JSON
+func emit(typ EventType, name, topic string, data any) {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+
+	ev := Event{Type: typ, Device: name, Topic: topic, Time: time.Now()}
+	if data != nil {
+		if payload, err := textPayload(data); err == nil && len(payload) > 0 {
+			ev.Data = jsonData(payload)
+		}
+	}
+	for ch := range listeners {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
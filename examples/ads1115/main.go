@@ -4,13 +4,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/rustyeddy/otto/device/drivers"
-	"periph.io/x/conn/v3/physic"
-)
-
-const (
-	adcRange              = 26400 // ADS1115 gives you 32768 steps with 4.096v, with 3.3v which is the 80% of 4.096v we get 26400 steps
-	inputVoltageInChannel = 3300 * physic.MilliVolt
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 func main() {
@@ -22,7 +16,7 @@ func main() {
 	var chans4 [4]<-chan float64
 	for i := 0; i < 4; i++ {
 		pname := fmt.Sprintf("pin%d", i)
-		pins[i], err = ads.Pin(pname, i, nil)
+		pins[i], err = ads.Pin(pname, i, drivers.Gain4_096V)
 		if err != nil {
 			fmt.Printf("Error creating pin: %d\n", i)
 		}
@@ -41,6 +35,7 @@ func main() {
 		time.Sleep(2 * time.Second)
 	}
 
+	defer ads.Close()
 	var val float64
 	for {
 		select {
@@ -49,8 +44,6 @@ func main() {
 		case val = <-chans4[2]:
 		case val = <-chans4[3]:
 		}
+		fmt.Printf("VAL: %5.2f\n", val)
 	}
-	fmt.Printf("VAL: %5.2f\n", val)
-	ads.Close()
-
 }
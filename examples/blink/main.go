@@ -6,13 +6,17 @@ toggle mode.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
 	"time"
 
-	"github.com/rustyeddy/otto/device/drivers"
-	"github.com/rustyeddy/otto/device/led"
-	"github.com/rustyeddy/otto/messanger"
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/led"
 )
 
 var (
@@ -30,49 +34,62 @@ func init() {
 
 func main() {
 	flag.Parse()
+	domock()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	// Create the led, name it "led" and add a publish topic
-	led, done := initLED("led", pinid)
+	led := initLED("led", pinid)
 	if useMQTT {
-		domqtt(led)
-	} else {
-		dotimer(led, 1*time.Second, done)
-		fmt.Println("LED will blink every second")
+		if err := domqtt(led); err != nil {
+			slog.Error("led listen", "error", err)
+			return
+		}
+		<-ctx.Done()
+		return
 	}
-	<-done
+	fmt.Println("LED will blink every second")
+	dotimer(ctx, led, 1*time.Second)
 }
 
-func initLED(name string, pin int) (*led.LED, chan any) {
+func initLED(name string, pin int) *led.LED {
 	led := led.New(name, pin)
-	led.SetTopic(messanger.GetTopics().Data(led.Device.Name()))
-	done := make(chan any)
-	return led, done
+	led.SetTopic(device.DataTopic(led.Device.Name))
+	return led
 }
 
-func domqtt(led *led.LED) {
-	led.Subscribe(messanger.GetTopics().Control(led.Device.Name()), led.Callback)
+// domqtt has the led take "on", "off" and "toggle" on its control
+// topic, from the broker on the station unless mqtt is mocked
+func domqtt(led *led.LED) error {
+	if _, ok := device.GetMessanger("mqtt"); !ok {
+		m, err := device.NewMQTT(device.DefaultBroker, "blink")
+		if err != nil {
+			return err
+		}
+		device.SetMessanger("mqtt", m)
+	}
+	return led.Listen()
 }
 
-func dotimer(led *led.LED, period time.Duration, done chan any) {
+func dotimer(ctx context.Context, led *led.LED, period time.Duration) {
 	count = 0
-	led.TimerLoop(period, done, func() error {
-		// led.Set(count % 2)
+	led.TimerLoop(ctx, period, func() error {
 		count++
-		return nil
+		return led.Toggle()
 	})
 }
 
 func domock() {
 	switch mock {
 	case "mqtt":
-		messanger.NewMessanger("local", "")
+		device.SetMessanger("mqtt", device.NewMemMessanger())
 
 	case "gpio":
 		drivers.GetGPIO().Mock = true
 
 	case "both":
-		// messanger.SetMQTTClient(messanger.GetMockClient())
-		messanger.NewMessanger("local", "")
+		device.SetMessanger("mqtt", device.NewMemMessanger())
 		drivers.GetGPIO().Mock = true
 
 	default:
@@ -1,26 +1,39 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
-	"github.com/rustyeddy/otto/device/drivers"
-	"github.com/rustyeddy/otto/messanger"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 func TestBlink(t *testing.T) {
 	drivers.GetGPIO().Mock = true
-	messanger.NewMessanger("local", "blink")
+	devicetest.Use(t)
+	clock := devicetest.UseClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
 
-	led, done := initLED("test-led", 13)
-	if led.Device.Name() != "test-led" {
-		t.Errorf("name expected (%s) got (%s)", "test-led", led.Device.Name())
+	led := initLED("test-led", 13)
+	if led.Device.Name != "test-led" {
+		t.Errorf("name expected (%s) got (%s)", "test-led", led.Device.Name)
+	}
+	if err := domqtt(led); err != nil {
+		t.Fatalf("domqtt() error (%v)", err)
 	}
 
-	domqtt(led)
-	go dotimer(led, 100*time.Millisecond, done)
-	time.Sleep(1 * time.Second)
-	done <- true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dotimer(ctx, led, 100*time.Millisecond)
+		close(done)
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(1 * time.Second)
+	cancel()
+	<-done
 
 	if count != 10 {
 		t.Errorf("count expected (%d) got (%d)", 10, count)
@@ -1,35 +1,38 @@
 package main
 
 import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
 	"time"
 
-	"github.com/rustyeddy/otto/data"
-	"github.com/rustyeddy/otto/device/bme280"
-	"github.com/rustyeddy/otto/messanger"
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/bme280"
 )
 
 func main() {
-	// create the topic the bme will publish to and the DataManager
-	// will subscribe to
-	topic := messanger.GetTopics().Data("bme280")
+	// Connect to the broker on the station, the bme publishes its
+	// readings to it
+	m, err := device.NewMQTT(device.DefaultBroker, "bme280")
+	if err != nil {
+		panic(err)
+	}
+	device.SetMessanger("mqtt", m)
+	defer m.Close()
 
 	// Set the BME i2c device and address Initialize the bme to use
 	// the i2c bus
 	bme := bme280.New("bme280", "/dev/i2c-1", 0x76)
-	bme.SetTopic(topic)
-	err := bme.Init()
-	if err != nil {
+	bme.SetTopic(device.DataTopic("bme280"))
+	if err := bme.Init(); err != nil {
 		panic(err)
 	}
 
-	// Before we start reading temp, etc. let's subscribe to
-	// the messages we are going to publish.
-	dm := data.GetDataManager()
-	dm.Subscribe(topic, dm.Callback)
-
 	// start reading in a loop and publish the results via MQTT
-	done := make(chan any)
-	go bme.TimerLoop(5*time.Second, done, bme.ReadPub)
-
-	<-done
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := bme.TimerLoop(ctx, 5*time.Second, bme.ReadPub); err != nil && ctx.Err() == nil {
+		slog.Error("bme280", "error", err)
+	}
 }
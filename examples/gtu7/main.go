@@ -1,9 +1,17 @@
 package main
 
-import "github.com/rustyeddy/otto/device/gtu7"
+import (
+	"log/slog"
+
+	"github.com/rustyeddy/otto-devices/gtu7"
+)
 
 func main() {
 	g := gtu7.NewGTU7("/dev/ttyS0")
+	if err := g.OpenRead(); err != nil {
+		slog.Error("GTU7 open", "error", err)
+		return
+	}
 	gpsQ := g.StartReading()
 
 	for gps := range gpsQ {
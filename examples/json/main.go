@@ -6,10 +6,10 @@ toggle mode.
 package main
 
 import (
-	"log/slog"
-
 	"encoding/json"
+	"log/slog"
 
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 var gpioStr = `
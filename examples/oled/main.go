@@ -5,7 +5,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/rustyeddy/otto/device/oled"
+	"github.com/rustyeddy/otto-devices/oled"
 	"periph.io/x/devices/v3/ssd1306"
 )
 
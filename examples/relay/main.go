@@ -7,32 +7,31 @@ package main
 
 import (
 	"embed"
+	"io/fs"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/rustyeddy/otto/device/drivers"
-	"github.com/rustyeddy/otto/messanger"
-	"github.com/rustyeddy/otto/server"
-	"github.com/warthog618/go-gpiocdev"
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/relay"
 )
 
 //go:embed app
 var content embed.FS
 
-type relay struct {
-	*drivers.DigitalPin
-}
-
 func main() {
-	// var data any
-	done := make(chan any)
-
-	s := server.GetServer()
-	// s.EmbedTempl("/", data, content)
-	s.Appdir("/", "app")
-	go s.Start(done)
+	app, err := fs.Sub(content, "app")
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		if err := http.ListenAndServe(":8011", http.FileServerFS(app)); err != nil {
+			slog.Error("relay app", "error", err)
+		}
+	}()
 
 	// Get the GPIO driver
 	g := drivers.GetGPIO()
@@ -45,10 +44,19 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(quit)
 
-	r := g.Pin("relay", 6, gpiocdev.AsOutput(0))
-	m := messanger.GetMQTT()
-	m.Connect()
-	m.Subscribe("ss/c/station/relay", r.Callback)
+	m, err := device.NewMQTT(device.DefaultBroker, "relay")
+	if err != nil {
+		slog.Error("relay", "error", err)
+		return
+	}
+	device.SetMessanger("mqtt", m)
+	defer m.Close()
+
+	r := relay.New("relay", 6)
+	if err := r.Listen(); err != nil {
+		slog.Error("relay listen", "error", err)
+		return
+	}
 
 	<-quit
 	slog.Info("Exiting relay")
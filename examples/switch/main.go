@@ -5,17 +5,23 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/rustyeddy/otto/device/drivers"
-	"github.com/rustyeddy/otto/messanger"
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
 	"github.com/warthog618/go-gpiocdev"
 )
 
 var (
-	mqtt *messanger.MQTT
+	mqtt *device.MQTT
 )
 
 func main() {
-	mqtt = messanger.GetMQTT()
+	var err error
+	mqtt, err = device.NewMQTT(device.DefaultBroker, "switch")
+	if err != nil {
+		slog.Error("switch", "error", err)
+		return
+	}
+	defer mqtt.Close()
 
 	// Get the GPIO driver
 	g := drivers.GetGPIO()
@@ -67,7 +73,7 @@ func startSwitchHandler(g *drivers.GPIO, done chan bool) {
 					continue
 				}
 				val := strconv.Itoa(v)
-				mqtt.Publish("ss/d/station/switch", val)
+				mqtt.Publish(device.DataTopic("switch"), []byte(val))
 
 			default:
 				slog.Warn("Switch unknown event type ", "type", evt.Type)
@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
-	"github.com/rustyeddy/otto/device/vh400"
+	"github.com/rustyeddy/otto-devices/vh400"
 )
 
 func main() {
@@ -19,14 +20,13 @@ func main() {
 		}
 	}
 
-	readQ := make(<-chan float64)
 	s := vh400.New("vh400", pin)
-	readQ = s.AnalogPin.ReadContinuous()
-
-	for {
-		select {
-		case val := <-readQ:
-			fmt.Printf("adc: %d: %5.2f\n", pin, val)
+	for range time.Tick(time.Second) {
+		val, err := s.Read()
+		if err != nil {
+			fmt.Printf("adc: %d: %s\n", pin, err)
+			continue
 		}
+		fmt.Printf("adc: %d: %5.2f\n", pin, val)
 	}
 }
@@ -0,0 +1,74 @@
+package fan
+
+import (
+	"fmt"
+	"math"
+)
+
+// Point is a duty, 0.0 - 1.0, at a temperature in °C
+type Point struct {
+	Temp float64 `json:"temp"`
+	Duty float64 `json:"duty"`
+}
+
+// Curve is the duty against the temperature, the points are in order
+// of rising temperature. The duty is interpolated between the points
+// and held at the first and the last beyond them.
+type Curve []Point
+
+// DefaultCurve idles the fan to 35°C and runs it flat out from 70°C
+var DefaultCurve = Curve{{35, 0.2}, {50, 0.4}, {70, 1}}
+
+// Valid returns ErrCurve for a curve without points, with the
+// temperatures not rising or a duty outside 0.0 - 1.0
+func (c Curve) Valid() error {
+	if len(c) == 0 {
+		return fmt.Errorf("%w: no points", ErrCurve)
+	}
+	for i, p := range c {
+		if p.Duty < 0 || p.Duty > 1 || math.IsNaN(p.Duty) || math.IsNaN(p.Temp) {
+			return fmt.Errorf("%w: duty %v at %v°C", ErrCurve, p.Duty, p.Temp)
+		}
+		if i > 0 && p.Temp <= c[i-1].Temp {
+			return fmt.Errorf("%w: %v°C after %v°C", ErrCurve, p.Temp, c[i-1].Temp)
+		}
+	}
+	return nil
+}
+
+// Duty returns the duty at temp
+func (c Curve) Duty(temp float64) float64 {
+	if temp <= c[0].Temp {
+		return c[0].Duty
+	}
+	for i := 1; i < len(c); i++ {
+		if temp < c[i].Temp {
+			a, b := c[i-1], c[i]
+			return a.Duty + (b.Duty-a.Duty)*(temp-a.Temp)/(b.Temp-a.Temp)
+		}
+	}
+	return c[len(c)-1].Duty
+}
+
+// follower is the temperature the curve is followed at. It rises with
+// the temperature, but only comes back down once the temperature has
+// dropped by the hysteresis, so a temperature wavering around a
+// point does not hunt the fan up and down.
+type follower struct {
+	hysteresis float64
+	temp       float64
+	started    bool
+}
+
+// update returns the temperature to follow for a reading of temp
+func (f *follower) update(temp float64) float64 {
+	switch {
+	case !f.started:
+		f.temp, f.started = temp, true
+	case temp > f.temp:
+		f.temp = temp
+	case temp < f.temp-f.hysteresis:
+		f.temp = temp + f.hysteresis
+	}
+	return f.temp
+}
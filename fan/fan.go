@@ -0,0 +1,381 @@
+// Package fan drives a 4-pin PC fan, the speed set with a 25kHz PWM
+// and measured from the pulses of the tach line.
+//
+// The fan runs at a duty set by hand, or follows a device.Thermometer
+// added to the device manager, like a bme280 or a ds18b20, along a
+// Curve of duty against the temperature. A fan commanded to turn that gives no tach pulse for
+// the stall timeout is published as a "stall" event.
+package fan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// Frequency of the PWM, the 4-pin fan specification asks for
+	// 25kHz
+	Frequency = 25000
+
+	// DefaultPulsesPerRev of the tach line, most fans pulse twice a
+	// revolution
+	DefaultPulsesPerRev = 2
+
+	// DefaultStallTimeout is how long a fan gets to turn after it
+	// was started, or since it last turned
+	DefaultStallTimeout = 5 * time.Second
+
+	// DefaultHysteresis in °C the temperature has to drop before the
+	// fan slows down
+	DefaultHysteresis = 2.0
+)
+
+// Mode is how the duty is set
+type Mode string
+
+const (
+	Manual Mode = "manual"
+	Auto   Mode = "auto"
+)
+
+var (
+	ErrDuty    = errors.New("duty outside 0.0 - 1.0")
+	ErrCurve   = errors.New("invalid fan curve")
+	ErrSensor  = device.ErrSource
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes, Temperature is the temperature
+// followed in auto mode
+type Reading struct {
+	Duty        float64  `json:"duty"`
+	RPM         float64  `json:"rpm"`
+	Mode        Mode     `json:"mode"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// StallEvent is published when a fan commanded to turn stalls,
+// "stall", and when it turns again or is stopped, "stall_cleared"
+type StallEvent struct {
+	Event string    `json:"event"`
+	Duty  float64   `json:"duty"`
+	Since time.Time `json:"since"`
+}
+
+// Fan is a 4-pin PC fan
+type Fan struct {
+	*device.Device
+
+	// PulsesPerRev of the tach line
+	PulsesPerRev int
+
+	pwm  drivers.PWMChannel
+	tach *drivers.DigitalPin
+	cnt  tach
+	st   stall
+
+	duty   float64
+	mode   Mode
+	sensor string
+	curve  Curve
+	follow follower
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates a fan driven by channel of the pwm chip, a kernel
+// pwmchip or a controller registered with drivers.RegisterPWMChip,
+// with the tach line on the pin tach, any pin identifier
+// drivers.ResolvePinID understands. An empty tach is a 3-pin fan
+// without one, or one not wired.
+func New(name, chip string, channel int, tach string) (*Fan, error) {
+	if device.IsMock() {
+		f, err := NewWithPWM(name, &mockPWM{})
+		if err == nil {
+			f.st.timeout = 0
+		}
+		return f, err
+	}
+	pwm, err := drivers.OpenPWM(chip, channel)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewWithPWM(name, pwm)
+	if err != nil {
+		pwm.Close()
+		return nil, err
+	}
+	if tach == "" {
+		// nothing to tell a stall by
+		f.st.timeout = 0
+		return f, nil
+	}
+
+	pin, err := drivers.NewDigitalPinID(name+"-tach", tach,
+		drivers.WithOwner("fan"),
+		gpiocdev.AsInput,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithFallingEdge,
+		gpiocdev.WithEventHandler(func(gpiocdev.LineEvent) {
+			f.pulse(f.now())
+		}))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.mu.Lock()
+	f.tach = pin
+	f.mu.Unlock()
+	return f, nil
+}
+
+// NewWithPWM creates a fan on pwm without a tach line, it is stopped
+// until a duty is set
+func NewWithPWM(name string, pwm drivers.PWMChannel) (*Fan, error) {
+	if err := pwm.SetFrequency(Frequency); err != nil {
+		return nil, err
+	}
+	if err := pwm.SetDuty(0); err != nil {
+		return nil, err
+	}
+	f := &Fan{
+		Device:       device.NewDevice(name, "mqtt"),
+		PulsesPerRev: DefaultPulsesPerRev,
+		pwm:          pwm,
+		mode:         Manual,
+		curve:        DefaultCurve,
+		st:           stall{timeout: DefaultStallTimeout},
+		follow:       follower{hysteresis: DefaultHysteresis},
+		now:          time.Now,
+	}
+	f.cnt.readAt = f.now()
+	return f, nil
+}
+
+// Name returns the name of the device
+func (f *Fan) Name() string {
+	return f.Device.Name
+}
+
+// SetDuty runs the fan at duty, 0.0 - 1.0, by hand
+func (f *Fan) SetDuty(duty float64) error {
+	if duty < 0 || duty > 1 || math.IsNaN(duty) {
+		return fmt.Errorf("%w: %v", ErrDuty, duty)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mode = Manual
+	return f.setDuty(duty)
+}
+
+// setDuty writes duty to the pwm, f.mu is held
+func (f *Fan) setDuty(duty float64) error {
+	if err := f.pwm.SetDuty(duty); err != nil {
+		return err
+	}
+	f.duty = duty
+	f.st.command(duty, f.now())
+	return nil
+}
+
+// SetStallTimeout sets how long a fan commanded to turn may give no
+// tach pulse before it is taken as stalled, 0 turns the check off
+func (f *Fan) SetStallTimeout(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.st.timeout = d
+}
+
+// Follow links the fan to the temperature device added to the device
+// manager as sensor, the duty follows curve with the temperature
+// dropping hysteresis °C before the fan slows. The duty is set on
+// every ReadPub.
+func (f *Fan) Follow(sensor string, curve Curve, hysteresis float64) error {
+	if err := curve.Valid(); err != nil {
+		return err
+	}
+	if hysteresis < 0 || math.IsNaN(hysteresis) {
+		return fmt.Errorf("%w: hysteresis %v", ErrCurve, hysteresis)
+	}
+	if _, err := device.GetThermometer(sensor); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sensor, f.curve = sensor, curve
+	f.follow = follower{hysteresis: hysteresis}
+	f.mode = Auto
+	return nil
+}
+
+// Command handles a command payload: "duty 40" in percent, "off" or
+// "auto" to follow the temperature device again
+func (f *Fan) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(c) == 2 && c[0] == "duty":
+		pct, err := strconv.ParseFloat(c[1], 64)
+		if err == nil {
+			return f.SetDuty(pct / 100)
+		}
+	case len(c) == 1 && c[0] == "off":
+		return f.SetDuty(0)
+	case len(c) == 1 && c[0] == "auto":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.sensor == "" {
+			return fmt.Errorf("%w: the fan follows none", ErrSensor)
+		}
+		f.mode = Auto
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the duty, the speed since the last Read and the mode.
+// In auto mode the temperature is read and the duty set from the
+// curve first, a temperature that can not be read runs the fan flat
+// out.
+func (f *Fan) Read() (*Reading, error) {
+	var temp *float64
+	var terr error
+	f.mu.Lock()
+	mode, sensor := f.mode, f.sensor
+	f.mu.Unlock()
+	if mode == Auto {
+		var t float64
+		if t, terr = readTemperature(sensor); terr == nil {
+			temp = &t
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if mode == Auto && f.mode == Auto {
+		duty := 1.0
+		if temp != nil {
+			duty = f.curve.Duty(f.follow.update(*temp))
+		}
+		if err := f.setDuty(duty); err != nil {
+			return nil, err
+		}
+	}
+	r := &Reading{
+		Duty:        f.duty,
+		RPM:         f.cnt.rpm(f.PulsesPerRev, f.now()),
+		Mode:        f.mode,
+		Temperature: temp,
+	}
+	return r, terr
+}
+
+func readTemperature(sensor string) (float64, error) {
+	t, err := device.GetThermometer(sensor)
+	if err != nil {
+		return 0, err
+	}
+	temp, err := t.Temperature()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", sensor, err)
+	}
+	return temp, nil
+}
+
+// ReadPub publishes the Reading, and a StallEvent when the fan stalls
+// or the stall clears
+func (f *Fan) ReadPub() error {
+	r, err := f.Read()
+	if r == nil {
+		return err
+	}
+	if err != nil {
+		slog.Error("fan temperature, running flat out", "device", f.Device.Name, "error", err)
+	}
+	if err := f.publish(r); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	stalled, changed := f.st.check(f.cnt.last, f.now())
+	evt := &StallEvent{Event: "stall_cleared", Duty: f.duty, Since: f.st.since}
+	f.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	if stalled {
+		evt.Event = "stall"
+		slog.Warn("fan stalled", "device", f.Device.Name, "duty", evt.Duty, "since", evt.Since)
+	}
+	return f.publish(evt)
+}
+
+// Stalled reports if the fan was stalled at the last ReadPub
+func (f *Fan) Stalled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.st.stalled
+}
+
+// Run publishes the Reading every period until ctx is canceled
+func (f *Fan) Run(ctx context.Context, period time.Duration) error {
+	err := f.TimerLoop(ctx, period, f.ReadPub)
+	slog.Debug("fan stopped", "device", f.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the fan and releases the pwm and the tach line
+func (f *Fan) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pwm == nil {
+		return nil
+	}
+	err := errors.Join(f.pwm.SetDuty(0), f.pwm.Close())
+	f.pwm = nil
+	if f.tach != nil {
+		err = errors.Join(err, f.tach.Close())
+		f.tach = nil
+	}
+	return err
+}
+
+// MockPulses counts n tach pulses in mock mode
+func (f *Fan) MockPulses(n int) {
+	for i := 0; i < n; i++ {
+		f.pulse(f.now())
+	}
+}
+
+func (f *Fan) pulse(at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cnt.pulse(at)
+}
+
+func (f *Fan) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f.PubData(j)
+	return nil
+}
+
+type mockPWM struct{}
+
+func (*mockPWM) SetFrequency(float64) error { return nil }
+func (*mockPWM) SetDuty(float64) error      { return nil }
+func (*mockPWM) Close() error               { return nil }
@@ -0,0 +1,264 @@
+package fan
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCurve(t *testing.T) {
+	c := Curve{{30, 0}, {40, 0.5}, {60, 1}}
+	if err := c.Valid(); err != nil {
+		t.Fatalf("Valid() error = %v", err)
+	}
+	duties := []struct {
+		temp, duty float64
+	}{
+		{-10, 0},
+		{30, 0},
+		{35, 0.25},
+		{40, 0.5},
+		{45, 0.625},
+		{59, 0.975},
+		{60, 1},
+		{90, 1},
+	}
+	for _, tt := range duties {
+		if got := c.Duty(tt.temp); !near(got, tt.duty) {
+			t.Errorf("Duty(%g) got (%g) want (%g)", tt.temp, got, tt.duty)
+		}
+	}
+	if got := (Curve{{50, 0.3}}).Duty(80); got != 0.3 {
+		t.Errorf("Duty() of a single point got (%g) want (0.3)", got)
+	}
+
+	invalid := []Curve{
+		nil,
+		{{30, 0}, {30, 1}},
+		{{40, 0}, {30, 1}},
+		{{30, -0.1}},
+		{{30, 1.5}},
+		{{math.NaN(), 0.5}},
+	}
+	for _, c := range invalid {
+		if err := c.Valid(); !errors.Is(err, ErrCurve) {
+			t.Errorf("Valid(%v) error got (%v) want (%v)", c, err, ErrCurve)
+		}
+	}
+}
+
+func TestFollower(t *testing.T) {
+	f := follower{hysteresis: 2}
+	steps := []struct {
+		temp, follow float64
+	}{
+		{40, 40},
+		{45, 45},
+		{44, 45}, // within the hysteresis
+		{43, 45}, // on its edge
+		{42.5, 44.5},
+		{44, 44.5}, // rising again within it
+		{46, 46},
+		{30, 32},
+	}
+	for i, s := range steps {
+		if got := f.update(s.temp); got != s.follow {
+			t.Errorf("step %d update(%g) got (%g) want (%g)", i, s.temp, got, s.follow)
+		}
+	}
+
+	none := follower{}
+	for _, temp := range []float64{40, 39.9, 41} {
+		if got := none.update(temp); got != temp {
+			t.Errorf("update(%g) without hysteresis got (%g)", temp, got)
+		}
+	}
+}
+
+func TestStall(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s float64) time.Time { return t0.Add(time.Duration(s * float64(time.Second))) }
+	var none time.Time
+
+	s := stall{timeout: 5 * time.Second}
+	if stalled, changed := s.check(none, at(60)); stalled || changed {
+		t.Errorf("check() of a stopped fan got (%t, %t)", stalled, changed)
+	}
+
+	// started, it gets the timeout to spin up
+	s.command(0.5, at(100))
+	if stalled, _ := s.check(none, at(104)); stalled {
+		t.Error("check() spinning up got stalled")
+	}
+	if stalled, changed := s.check(none, at(105)); !stalled || !changed {
+		t.Errorf("check() after the timeout got (%t, %t) want (true, true)", stalled, changed)
+	}
+	if stalled, changed := s.check(none, at(106)); !stalled || changed {
+		t.Errorf("check() still stalled got (%t, %t) want (true, false)", stalled, changed)
+	}
+
+	// turning again
+	if stalled, changed := s.check(at(107), at(108)); stalled || !changed {
+		t.Errorf("check() turning got (%t, %t) want (false, true)", stalled, changed)
+	}
+	// a new duty while it turns does not restart the spin up
+	s.command(0.8, at(110))
+	if stalled, _ := s.check(at(107), at(112)); !stalled {
+		t.Error("check() 5s after the last pulse got (false)")
+	}
+
+	// stopping clears the stall
+	s.command(0, at(113))
+	if stalled, changed := s.check(at(107), at(114)); stalled || !changed {
+		t.Errorf("check() stopped got (%t, %t) want (false, true)", stalled, changed)
+	}
+	s.command(0.3, at(120))
+	if stalled, _ := s.check(at(107), at(121)); stalled {
+		t.Error("check() restarted got stalled")
+	}
+}
+
+func TestTach(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	c := tach{readAt: t0}
+	for i := 0; i < 40; i++ {
+		c.pulse(t0.Add(time.Duration(i) * 25 * time.Millisecond))
+	}
+	// 40 pulses in a second at 2 a revolution
+	if got := c.rpm(2, t0.Add(time.Second)); got != 1200 {
+		t.Errorf("rpm(2) got (%g) want (1200)", got)
+	}
+	if got := c.rpm(2, t0.Add(2*time.Second)); got != 0 {
+		t.Errorf("rpm() without pulses got (%g) want (0)", got)
+	}
+	c.pulse(t0.Add(2500 * time.Millisecond))
+	if got := c.rpm(1, t0.Add(3*time.Second)); got != 60 {
+		t.Errorf("rpm(1) got (%g) want (60)", got)
+	}
+}
+
+// sensor is a temperature device playing a script
+type sensor struct {
+	temps []float64
+	err   error
+}
+
+func (s *sensor) Name() string {
+	return "cpu-temp"
+}
+
+func (s *sensor) Temperature() (float64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	t := s.temps[0]
+	if len(s.temps) > 1 {
+		s.temps = s.temps[1:]
+	}
+	return t, nil
+}
+
+func TestFan(t *testing.T) {
+	device.Mock(false)
+	pwm := driverstest.NewPWM()
+	driverstest.UsePWM(t, pwm)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	f, err := New("case", "pwmchip0", 0, "9")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+	clock := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+	f.cnt.readAt = clock
+	if pwm.Freq != Frequency || pwm.Duty != 0 {
+		t.Errorf("pwm got (%gHz, %g) want (%dHz, 0)", pwm.Freq, pwm.Duty, Frequency)
+	}
+
+	if err := f.Follow("cpu-temp", DefaultCurve, 2); !errors.Is(err, ErrSensor) {
+		t.Errorf("Follow(missing) error got (%v) want (%v)", err, ErrSensor)
+	}
+	s := &sensor{temps: []float64{35, 45, 44, 42.5}}
+	dm := device.GetDeviceManager()
+	dm.Add(s)
+	defer dm.Remove("cpu-temp")
+	if err := f.Follow("cpu-temp", Curve{{30, 0}, {40, 0.5}}, -1); !errors.Is(err, ErrCurve) {
+		t.Errorf("Follow(negative hysteresis) error got (%v) want (%v)", err, ErrCurve)
+	}
+	if err := f.Follow("cpu-temp", Curve{{30, 0}, {40, 0.5}, {60, 1}}, 2); err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+
+	tach := chip.Line(9)
+	for _, want := range []float64{0.25, 0.625, 0.625, 0.6125} {
+		// 20 pulses a second at 2 a revolution
+		for i := 0; i < 20; i++ {
+			tach.Edge(0)
+		}
+		clock = clock.Add(time.Second)
+		r, err := f.Read()
+		if err != nil || !near(pwm.Duty, want) || r.Mode != Auto || r.Temperature == nil || r.RPM != 600 {
+			t.Errorf("Read(auto) got (%+v, %v) duty (%g) want (%g, 600rpm)", r, err, pwm.Duty, want)
+		}
+	}
+	if f.Stalled() {
+		t.Error("Stalled() of a turning fan got (true)")
+	}
+
+	// the tach stops
+	clock = clock.Add(6 * time.Second)
+	if err := f.ReadPub(); err != nil || !f.Stalled() {
+		t.Errorf("ReadPub() got (%v) stalled (%t) want (nil, true)", err, f.Stalled())
+	}
+	tach.Edge(0)
+	clock = clock.Add(time.Second)
+	if err := f.ReadPub(); err != nil || f.Stalled() {
+		t.Errorf("ReadPub() got (%v) stalled (%t) want (nil, false)", err, f.Stalled())
+	}
+
+	// no temperature runs it flat out
+	s.err = errors.New("sensor gone")
+	if err := f.ReadPub(); err != nil || pwm.Duty != 1 {
+		t.Errorf("ReadPub(no temperature) got (%v, %g) want (nil, 1)", err, pwm.Duty)
+	}
+	s.err = nil
+
+	clock = clock.Add(time.Minute)
+	for i := 0; i < 600; i++ {
+		tach.Edge(0)
+	}
+	if err := f.Command([]byte("Duty 30")); err != nil {
+		t.Fatalf("Command(duty 30) error = %v", err)
+	}
+	r, err := f.Read()
+	if err != nil || r.Mode != Manual || r.Duty != 0.3 || pwm.Duty != 0.3 || r.Temperature != nil || r.RPM != 300 {
+		t.Errorf("Read(manual) got (%+v, %v) want (manual 0.3, 300rpm)", r, err)
+	}
+	if err := f.Command([]byte("duty 150")); !errors.Is(err, ErrDuty) {
+		t.Errorf("Command(duty 150) error got (%v) want (%v)", err, ErrDuty)
+	}
+	if err := f.Command([]byte("spin")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(spin) error got (%v) want (%v)", err, ErrCommand)
+	}
+	if err := f.Command([]byte("auto")); err != nil {
+		t.Fatalf("Command(auto) error = %v", err)
+	}
+	if r, _ := f.Read(); r.Mode != Auto || !near(r.Duty, 0.6125) {
+		t.Errorf("Read(auto) got (%+v) want (auto 0.6125)", r)
+	}
+
+	f.Close()
+	if pwm.Duty != 0 || pwm.SetDuty(0) == nil || !tach.Closed() {
+		t.Error("Close() did not stop the fan and release the pwm and the tach")
+	}
+}
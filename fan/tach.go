@@ -0,0 +1,58 @@
+package fan
+
+import "time"
+
+// tach counts the pulses of the tach line, a 4-pin fan pulls it low
+// twice a revolution on most fans
+type tach struct {
+	count  int64
+	last   time.Time // when the last pulse was seen
+	readN  int64     // the pulses and the time of the last rpm
+	readAt time.Time
+}
+
+func (t *tach) pulse(at time.Time) {
+	t.count++
+	t.last = at
+}
+
+// rpm returns the speed since the last call for ppr pulses a
+// revolution
+func (t *tach) rpm(ppr int, at time.Time) float64 {
+	var rpm float64
+	if d := at.Sub(t.readAt); d > 0 && ppr > 0 {
+		rpm = float64(t.count-t.readN) / float64(ppr) / d.Minutes()
+	}
+	t.readN, t.readAt = t.count, at
+	return rpm
+}
+
+// stall tracks a fan commanded to run that does not turn
+type stall struct {
+	timeout time.Duration
+	since   time.Time // the fan was started, or last seen turning
+	running bool      // commanded to a nonzero duty
+	stalled bool
+}
+
+// command records the duty commanded at at
+func (s *stall) command(duty float64, at time.Time) {
+	run := duty > 0
+	if run && !s.running {
+		// the fan gets the timeout to spin up
+		s.since = at
+	}
+	s.running = run
+}
+
+// check returns whether the fan is stalled at at, with the last
+// pulse seen at last, and if that changed
+func (s *stall) check(last, at time.Time) (stalled, changed bool) {
+	if last.After(s.since) {
+		s.since = last
+	}
+	now := s.running && s.timeout > 0 && at.Sub(s.since) >= s.timeout
+	changed = now != s.stalled
+	s.stalled = now
+	return now, changed
+}
@@ -0,0 +1,343 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFanoutQueue is the number of publishes a backend of a
+	// Fanout can be behind before the next ones are dropped
+	DefaultFanoutQueue = 64
+
+	// DefaultFanoutWait bounds how long a publish on a Fanout waits
+	// for its backends
+	DefaultFanoutWait = 5 * time.Second
+
+	// DefaultFanoutWindow is how long a command taken from a backend
+	// is the same one when it comes from another
+	DefaultFanoutWindow = time.Second
+)
+
+// Fanout is a Messanger publishing on several, like the broker and a
+// webhook of the station for the devices that must get through. Each
+// backend publishes from a queue of its own, a slow one does not hold
+// the others up and one too far behind drops the publishes it has no
+// room for. A publish succeeds when one backend took it, or every one
+// of them with FanoutAll. A Buffer backend takes the publishes of the
+// devices it failed to publish, to replay them.
+//
+// The messages subscribed to come from every backend with their
+// Source the name of the backend, so Dedupe tells their redeliveries
+// apart. A message the same as one another backend delivered within
+// FanoutWindow is taken once, a command sent over both is executed
+// once.
+type Fanout struct {
+	backends []*backend
+	all      bool
+	queue    int
+	wait     time.Duration
+	window   time.Duration
+	closed   bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// FanoutStats counts the publishes of a backend of a Fanout. Failed
+// are the ones it returned an error for, Buffered the ones a Buffer
+// kept to replay, Dropped the ones it had no room for in its queue and
+// Queued the ones it has left.
+type FanoutStats struct {
+	Published int `json:"published"`
+	Failed    int `json:"failed"`
+	Buffered  int `json:"buffered"`
+	Dropped   int `json:"dropped"`
+	Queued    int `json:"queued"`
+}
+
+// backend is a Messanger of a Fanout and the publishes it has left
+type backend struct {
+	name  string
+	m     Messanger
+	queue chan fanoutPub
+	stats FanoutStats
+}
+
+// fanoutPub is a publish queued on a backend, its result handed back
+// on done, the backfill of a device's publish for a Buffer
+type fanoutPub struct {
+	topic   string
+	payload []byte
+	qos     QoS
+	retain  bool
+	bf      *backfill
+	done    chan<- fanoutDone
+}
+
+// fanoutDone is the result of a publish on a backend
+type fanoutDone struct {
+	err      error
+	buffered bool
+}
+
+// FanoutOption configures a Fanout
+type FanoutOption func(*Fanout)
+
+// FanoutAll has a publish succeed only when every backend took it
+func FanoutAll(on bool) FanoutOption {
+	return func(f *Fanout) {
+		f.all = on
+	}
+}
+
+// FanoutQueue sets the number of publishes a backend can be behind
+func FanoutQueue(n int) FanoutOption {
+	return func(f *Fanout) {
+		f.queue = max(n, 1)
+	}
+}
+
+// FanoutWait sets how long a publish waits for the backends, the ones
+// that did not answer by then are not delivered
+func FanoutWait(d time.Duration) FanoutOption {
+	return func(f *Fanout) {
+		f.wait = d
+	}
+}
+
+// FanoutWindow sets how long a message from a backend is the same one
+// when another delivers it
+func FanoutWindow(d time.Duration) FanoutOption {
+	return func(f *Fanout) {
+		f.window = d
+	}
+}
+
+// NewFanout creates a Fanout publishing on the backends, by their
+// names
+func NewFanout(backends map[string]Messanger, opts ...FanoutOption) (*Fanout, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("%w: fanout without backends", ErrNoMessanger)
+	}
+	f := &Fanout{queue: DefaultFanoutQueue, wait: DefaultFanoutWait, window: DefaultFanoutWindow}
+	for _, opt := range opts {
+		opt(f)
+	}
+	for _, name := range slices.Sorted(maps.Keys(backends)) {
+		b := &backend{name: name, m: backends[name], queue: make(chan fanoutPub, f.queue)}
+		f.backends = append(f.backends, b)
+		f.wg.Add(1)
+		go f.run(b)
+	}
+	return f, nil
+}
+
+// run publishes the queue of b until the Fanout is closed
+func (f *Fanout) run(b *backend) {
+	defer f.wg.Done()
+	for p := range b.queue {
+		var r fanoutDone
+		if bm, ok := b.m.(buffering); ok && p.bf != nil {
+			r.buffered, r.err = bm.publishBuffered(p.topic, p.payload, p.qos, p.retain, p.bf)
+		} else {
+			r.err = b.m.PublishQoS(p.topic, p.payload, p.qos, p.retain)
+		}
+		f.mu.Lock()
+		switch {
+		case r.err != nil:
+			b.stats.Failed++
+		case r.buffered:
+			b.stats.Buffered++
+		default:
+			b.stats.Published++
+		}
+		f.mu.Unlock()
+		if r.err != nil {
+			r.err = fmt.Errorf("%s: %w", b.name, r.err)
+		}
+		p.done <- r
+	}
+}
+
+// Publish publishes on the backends at QoS 0
+func (f *Fanout) Publish(topic string, payload []byte) error {
+	return f.PublishQoS(topic, payload, AtMostOnce, false)
+}
+
+// PublishRetained publishes retained on the backends
+func (f *Fanout) PublishRetained(topic string, payload []byte) error {
+	return f.PublishQoS(topic, payload, AtLeastOnce, true)
+}
+
+// PublishQoS queues the publish on every backend and waits for one of
+// them to take it, or all of them with FanoutAll
+func (f *Fanout) PublishQoS(topic string, payload []byte, qos QoS, retain bool) error {
+	_, err := f.publishBuffered(topic, payload, qos, retain, nil)
+	return err
+}
+
+// publishBuffered is PublishQoS handing bf to the Buffer backends, a
+// publish one of them kept taken too. It is buffered when a Buffer
+// kept it and no backend published it.
+func (f *Fanout) publishBuffered(topic string, payload []byte, qos QoS, retain bool, bf *backfill) (bool, error) {
+	payload = append([]byte(nil), payload...)
+	done := make(chan fanoutDone, len(f.backends))
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return false, ErrMessangerClosed
+	}
+	for _, b := range f.backends {
+		select {
+		case b.queue <- fanoutPub{topic: topic, payload: payload, qos: qos, retain: retain, bf: bf, done: done}:
+		default:
+			b.stats.Dropped++
+			done <- fanoutDone{err: fmt.Errorf("%s: %w: queue full", b.name, ErrNotDelivered)}
+		}
+	}
+	f.mu.Unlock()
+
+	timeout := time.NewTimer(f.wait)
+	defer timeout.Stop()
+	var errs []error
+	var buffered, published bool
+	for range f.backends {
+		select {
+		case r := <-done:
+			switch {
+			case r.err != nil && f.all:
+				return false, r.err
+			case r.err != nil:
+				errs = append(errs, r.err)
+			case r.buffered:
+				buffered = true
+			case !f.all:
+				return false, nil
+			default:
+				published = true
+			}
+		case <-timeout.C:
+			if buffered && !f.all {
+				return true, nil
+			}
+			return false, errors.Join(append(errs, fmt.Errorf("%w in %v", ErrNotDelivered, f.wait))...)
+		}
+	}
+	if !buffered {
+		return false, errors.Join(errs...)
+	}
+	return !published, nil
+}
+
+// Subscribe subscribes cb on the backends at QoS 0
+func (f *Fanout) Subscribe(topic string, cb func(*Msg)) error {
+	return f.SubscribeQoS(topic, AtMostOnce, cb)
+}
+
+// SubscribeQoS subscribes cb on every backend, the messages the same
+// as one another backend delivered within the window taken once
+func (f *Fanout) SubscribeQoS(topic string, qos QoS, cb func(*Msg)) error {
+	in := &fanIn{cb: cb, window: f.window, seen: make(map[fanInKey]fanInSeen)}
+	return f.each(func(b *backend) error {
+		return b.m.SubscribeQoS(topic, qos, func(msg *Msg) { in.deliver(b.name, msg) })
+	})
+}
+
+// Announce announces topic on the backends
+func (f *Fanout) Announce(topic string) error {
+	return f.each(func(b *backend) error { return b.m.Announce(topic) })
+}
+
+// each calls fn with every backend, it fails when one did with
+// FanoutAll and when all did otherwise
+func (f *Fanout) each(fn func(*backend) error) error {
+	var errs []error
+	for _, b := range f.backends {
+		if err := fn(b); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+	if len(errs) == 0 || (!f.all && len(errs) < len(f.backends)) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the FanoutStats of the backends by their names
+func (f *Fanout) Stats() map[string]FanoutStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]FanoutStats, len(f.backends))
+	for _, b := range f.backends {
+		s := b.stats
+		s.Queued = len(b.queue)
+		stats[b.name] = s
+	}
+	return stats
+}
+
+// Close publishes what the backends have left and closes them
+func (f *Fanout) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for _, b := range f.backends {
+		close(b.queue)
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+
+	var errs []error
+	for _, b := range f.backends {
+		if err := b.m.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fanIn hands the messages of a subscription on the backends to its
+// callback, one delivered by another backend within the window once
+type fanIn struct {
+	cb     func(*Msg)
+	window time.Duration
+	seen   map[fanInKey]fanInSeen
+	mu     sync.Mutex
+}
+
+type fanInKey struct {
+	topic, data string
+}
+
+type fanInSeen struct {
+	backend string
+	at      time.Time
+}
+
+func (in *fanIn) deliver(name string, msg *Msg) {
+	got := *msg
+	got.Source = name
+	now := time.Now()
+	k := fanInKey{msg.Topic, string(msg.Data)}
+
+	in.mu.Lock()
+	for key, s := range in.seen {
+		if now.Sub(s.at) > in.window {
+			delete(in.seen, key)
+		}
+	}
+	if s, ok := in.seen[k]; ok && s.backend != name {
+		in.mu.Unlock()
+		return
+	}
+	in.seen[k] = fanInSeen{backend: name, at: now}
+	in.mu.Unlock()
+	in.cb(&got)
+}
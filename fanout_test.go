@@ -0,0 +1,218 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slow is a backend whose publishes wait for the gate
+type slow struct {
+	*MemMessanger
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (s *slow) PublishQoS(topic string, payload []byte, qos QoS, retain bool) error {
+	s.started <- struct{}{}
+	<-s.gate
+	return s.MemMessanger.PublishQoS(topic, payload, qos, retain)
+}
+
+func TestFanout(t *testing.T) {
+	mqtt, hook := NewMemMessanger(), NewMemMessanger()
+	f, err := NewFanout(map[string]Messanger{"mqtt": mqtt, "webhook": hook})
+	if err != nil {
+		t.Fatalf("NewFanout() error (%v)", err)
+	}
+	defer f.Close()
+	d := NewDevice("pump", "mqtt", WithMessanger(f))
+
+	hook.Disconnect()
+	if err := d.PubData(1); err != nil {
+		t.Fatalf("PubData() with a backend down error (%v) want it published on the other", err)
+	}
+	waitFor(t, func() bool { return f.Stats()["webhook"].Failed == 1 })
+	if got := f.Stats()["mqtt"]; got != (FanoutStats{Published: 1}) {
+		t.Errorf("mqtt stats got (%+v) want 1 published", got)
+	}
+	if n := len(mqtt.Messages(d.DataTopic())); n != 1 {
+		t.Errorf("mqtt published got %d want 1", n)
+	}
+
+	mqtt.Disconnect()
+	if err := d.PubData(2); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PubData() with both down error got (%v) want (%v)", err, ErrNotConnected)
+	}
+	if got := d.PubStats(); got.Published != 1 || got.Failed != 1 {
+		t.Errorf("PubStats() got (%+v) want 1 published and 1 failed", got)
+	}
+
+	if _, err := NewFanout(nil); !errors.Is(err, ErrNoMessanger) {
+		t.Errorf("NewFanout(nil) error got (%v) want (%v)", err, ErrNoMessanger)
+	}
+	f.Close()
+	if err := d.PubData(3); !errors.Is(err, ErrMessangerClosed) {
+		t.Errorf("PubData() closed error got (%v) want (%v)", err, ErrMessangerClosed)
+	}
+}
+
+func TestFanoutAll(t *testing.T) {
+	mqtt, hook := NewMemMessanger(), NewMemMessanger()
+	f, _ := NewFanout(map[string]Messanger{"mqtt": mqtt, "webhook": hook}, FanoutAll(true))
+	defer f.Close()
+	d := NewDevice("pump", "mqtt", WithMessanger(f))
+
+	if err := d.PubData(1); err != nil {
+		t.Fatalf("PubData() error (%v)", err)
+	}
+	hook.Disconnect()
+	if err := d.PubData(2); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PubData() with a backend down error got (%v) want (%v)", err, ErrNotConnected)
+	}
+	waitFor(t, func() bool { return f.Stats()["mqtt"].Published == 2 })
+	if got := f.Stats()["webhook"]; got.Published != 1 || got.Failed != 1 {
+		t.Errorf("webhook stats got (%+v) want 1 published and 1 failed", got)
+	}
+	if err := f.Announce("ss/a/station/pump"); err != nil {
+		t.Errorf("Announce() error (%v)", err)
+	}
+}
+
+func TestFanoutSlow(t *testing.T) {
+	mqtt := NewMemMessanger()
+	hook := &slow{MemMessanger: NewMemMessanger(), started: make(chan struct{}, 10), gate: make(chan struct{})}
+	f, _ := NewFanout(map[string]Messanger{"mqtt": mqtt, "webhook": hook}, FanoutQueue(2))
+	defer f.Close()
+	d := NewDevice("pump", "mqtt", WithMessanger(f))
+
+	// the webhook takes the first and holds it, two wait and the
+	// others are dropped, the broker has them all
+	start := time.Now()
+	d.PubData(1)
+	<-hook.started
+	for i := 2; i <= 5; i++ {
+		if err := d.PubData(i); err != nil {
+			t.Fatalf("PubData(%d) error (%v)", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("publishes took %v, held by the slow backend", elapsed)
+	}
+	if n := len(mqtt.Messages(d.DataTopic())); n != 5 {
+		t.Errorf("mqtt published got %d want 5", n)
+	}
+	if got := f.Stats()["webhook"]; got != (FanoutStats{Dropped: 2, Queued: 2}) {
+		t.Errorf("webhook stats got (%+v) want 2 dropped and 2 queued", got)
+	}
+	close(hook.gate)
+	waitFor(t, func() bool { return f.Stats()["webhook"].Published == 3 })
+
+	// every one is waited for no longer than the wait
+	stuck := &slow{MemMessanger: NewMemMessanger(), started: make(chan struct{}, 1), gate: make(chan struct{})}
+	all, _ := NewFanout(map[string]Messanger{"mqtt": mqtt, "webhook": stuck}, FanoutAll(true), FanoutWait(20*time.Millisecond))
+	defer all.Close()
+	defer close(stuck.gate)
+	if err := all.Publish("a", nil); !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("Publish() all with a slow backend error got (%v) want (%v)", err, ErrNotDelivered)
+	}
+}
+
+func TestFanoutFanIn(t *testing.T) {
+	mqtt, hook := NewMemMessanger(), NewMemMessanger()
+	f, _ := NewFanout(map[string]Messanger{"mqtt": mqtt, "webhook": hook}, FanoutWindow(50*time.Millisecond))
+	defer f.Close()
+	d := NewDevice("relay", "mqtt", WithMessanger(f), WithQoS(AtLeastOnce))
+
+	var (
+		executed []string
+		mu       sync.Mutex
+	)
+	rt := NewRouter(d)
+	for _, cmd := range []string{"on", "off", "toggle"} {
+		rt.Handle(cmd, func(c *Command) error {
+			mu.Lock()
+			defer mu.Unlock()
+			executed = append(executed, c.Cmd+" "+c.Msg.Source)
+			return nil
+		})
+	}
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error (%v)", err)
+	}
+	got := func(want ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !slices.Equal(executed, want) {
+			t.Errorf("executed got (%v) want (%v)", executed, want)
+		}
+		executed = nil
+	}
+
+	// a command sent over both is taken once
+	mqtt.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	hook.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	got("toggle mqtt")
+
+	// again from the same backend, and after the window from the other
+	mqtt.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	time.Sleep(60 * time.Millisecond)
+	hook.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	got("toggle mqtt", "toggle webhook")
+
+	// a redelivery is taken once, the IDs of a backend its own
+	mqtt.SetRedeliver(1)
+	mqtt.PublishQoS(d.ControlTopic(), []byte("on"), AtLeastOnce, false)
+	id := mqtt.Messages(d.ControlTopic())[2].ID
+	hook.Deliver(&Msg{Topic: d.ControlTopic(), Data: []byte("off"), QoS: AtLeastOnce, ID: id, Duplicate: true})
+	got("on mqtt", "off webhook")
+}
+
+func TestFanoutBuffer(t *testing.T) {
+	mqtt, hook := NewMemMessanger(), NewMemMessanger()
+	b, err := NewBuffer(mqtt)
+	if err != nil {
+		t.Fatalf("NewBuffer() error (%v)", err)
+	}
+	f, err := NewFanout(map[string]Messanger{"mqtt": b, "webhook": hook})
+	if err != nil {
+		t.Fatalf("NewFanout() error (%v)", err)
+	}
+	defer f.Close()
+	d := NewDevice("soil", "mqtt", WithMessanger(f))
+
+	mqtt.Disconnect()
+	if err := d.PubData(1); err != nil {
+		t.Fatalf("PubData() with mqtt down error (%v) want it published on the webhook", err)
+	}
+	waitFor(t, func() bool { return f.Stats()["mqtt"].Buffered == 1 })
+	if got := d.PubStats(); got.Published != 1 || got.Buffered != 0 {
+		t.Errorf("PubStats() got (%+v) want 1 published", got)
+	}
+
+	hook.Disconnect()
+	if err := d.PubData(2); err != nil {
+		t.Fatalf("PubData() with both down error (%v) want it buffered", err)
+	}
+	if got := d.PubStats(); got.Buffered != 1 || got.Failed != 0 {
+		t.Errorf("PubStats() got (%+v) want 1 buffered", got)
+	}
+	if got := f.Stats()["webhook"]; got.Failed != 1 {
+		t.Errorf("webhook stats got (%+v) want 1 failed", got)
+	}
+	if got := b.Stats(); got.Buffered != 2 || got.Pending != 2 {
+		t.Errorf("Buffer Stats() got (%+v) want 2 buffered", got)
+	}
+	if err := d.PubState(1); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("PubState() with both down error got (%v) want (%v), states are not buffered", err, ErrNotConnected)
+	}
+
+	mqtt.Connect()
+	waitFor(t, func() bool { return b.Stats().Pending == 0 })
+	if vals, replayed := values(t, mqtt, d.DataTopic()); !slices.Equal(vals, []string{"1", "2"}) || !replayed[0] || !replayed[1] {
+		t.Errorf("mqtt got (%v, %v) want 1 and 2 replayed", vals, replayed)
+	}
+}
@@ -0,0 +1,169 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of the reads failed by a fault injected
+// without one of its own
+var ErrInjected = errors.New("injected fault")
+
+// faults are the failures injected in a device, see InjectFaults
+type faults struct {
+	every int           // every Nth read fails
+	fail  time.Duration // the reads fail for it from when injected
+	until time.Time     // by the clock of the device
+	err   error
+	open  int // the Open attempts left to fail
+	reads int
+}
+
+// FaultOption configures the faults injected in a device
+type FaultOption func(*faults)
+
+// FailEvery fails every nth read
+func FailEvery(n int) FaultOption {
+	return func(f *faults) {
+		f.every = n
+	}
+}
+
+// FailFor fails the reads for d from when the faults are injected, by
+// the clock of the device
+func FailFor(d time.Duration) FaultOption {
+	return func(f *faults) {
+		f.fail = d
+	}
+}
+
+// FailWith sets the error of the failed reads and Opens, one of the
+// drivers like a NAK to have it retried as the real one is
+func FailWith(err error) FaultOption {
+	return func(f *faults) {
+		f.err = err
+	}
+}
+
+// FailOpen fails the first k Open attempts
+func FailOpen(k int) FaultOption {
+	return func(f *faults) {
+		f.open = k
+	}
+}
+
+// InjectFaults has the device fail the way the options say, for the
+// tests of the retries and the alerts. A package calls ReadFault where
+// it reads its sensor, within its retries, for the failures to take
+// the paths of the real ones. No options clear the faults.
+func (d *Device) InjectFaults(opts ...FaultOption) {
+	defer trackMock(d)
+	now := d.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(opts) == 0 {
+		d.faults = nil
+		return
+	}
+	f := &faults{err: ErrInjected}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.fail > 0 {
+		f.until = now.Add(f.fail)
+	}
+	d.faults = f
+}
+
+// ReadFault returns the error a read of the device fails with, nil
+// when none was injected for it. Every call is a read.
+func (d *Device) ReadFault() error {
+	now := d.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.faults
+	if f == nil {
+		return nil
+	}
+	f.reads++
+	if (f.every > 0 && f.reads%f.every == 0) || now.Before(f.until) {
+		return f.err
+	}
+	return nil
+}
+
+// openFault returns the error an Open attempt fails with
+func (d *Device) openFault() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.faults == nil || d.faults.open == 0 {
+		return nil
+	}
+	d.faults.open--
+	return d.faults.err
+}
+
+// the errors a fault command can name
+var faultErrs = struct {
+	errs map[string]error
+	mu   sync.RWMutex
+}{errs: map[string]error{"injected": ErrInjected}}
+
+// RegisterFaultError names err for the fault command, the drivers
+// register theirs
+func RegisterFaultError(name string, err error) {
+	faultErrs.mu.Lock()
+	defer faultErrs.mu.Unlock()
+	faultErrs.errs[strings.ToLower(name)] = err
+}
+
+// FaultArgs are the args of the fault command a Router takes in mock
+// mode, {"cmd":"fault","args":{"every":3,"err":"nak"}}. For is a
+// positive duration like "30s", Err the name of a registered error.
+// A fault command without args clears the faults.
+type FaultArgs struct {
+	Every int    `json:"every,omitempty"`
+	For   string `json:"for,omitempty"`
+	Err   string `json:"err,omitempty"`
+	Open  int    `json:"open,omitempty"`
+}
+
+// faultCommand injects the faults of cmd in d
+func (d *Device) faultCommand(cmd *Command) error {
+	if len(cmd.Args) == 0 {
+		d.InjectFaults()
+		return nil
+	}
+	var args FaultArgs
+	if err := cmd.Bind(&args); err != nil {
+		return err
+	}
+	if args.Every < 0 || args.Open < 0 {
+		return fmt.Errorf("%w: fault every %d open %d", ErrCommand, args.Every, args.Open)
+	}
+	opts := []FaultOption{FailEvery(args.Every), FailOpen(args.Open)}
+	if args.For != "" {
+		dur, err := time.ParseDuration(args.For)
+		if err != nil {
+			return fmt.Errorf("%w: fault for: %v", ErrCommand, err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("%w: fault for %s", ErrCommand, args.For)
+		}
+		opts = append(opts, FailFor(dur))
+	}
+	if args.Err != "" {
+		faultErrs.mu.RLock()
+		err, ok := faultErrs.errs[strings.ToLower(args.Err)]
+		faultErrs.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("%w: fault err %q", ErrCommand, args.Err)
+		}
+		opts = append(opts, FailWith(err))
+	}
+	d.InjectFaults(opts...)
+	return nil
+}
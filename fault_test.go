@@ -0,0 +1,150 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFaults(t *testing.T) {
+	d := NewDevice("probe", "mqtt")
+	reads := func(n int) []error {
+		var errs []error
+		for range n {
+			errs = append(errs, d.ReadFault())
+		}
+		return errs
+	}
+	if errs := reads(3); errs[0] != nil || errs[1] != nil || errs[2] != nil {
+		t.Fatalf("ReadFault() without faults got (%v)", errs)
+	}
+
+	errNak := errors.New("nak")
+	d.InjectFaults(FailEvery(3), FailWith(errNak))
+	for i, err := range reads(6) {
+		if want := (i+1)%3 == 0; (err != nil) != want || (want && err != errNak) {
+			t.Errorf("read %d error got (%v)", i+1, err)
+		}
+	}
+
+	// the window of a FailFor goes by the clock of the device
+	clock := NewSimClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	d.SetClock(clock)
+	d.InjectFaults(FailFor(time.Hour))
+	clock.Advance(59 * time.Minute)
+	if err := d.ReadFault(); !errors.Is(err, ErrInjected) {
+		t.Errorf("ReadFault() in the window error got (%v) want (%v)", err, ErrInjected)
+	}
+	if st, _ := d.MockStatus(); st.Faults == nil || st.Faults.For != "1m0s" {
+		t.Errorf("MockStatus() faults got (%+v) want a minute left", st.Faults)
+	}
+	clock.Advance(time.Minute)
+	if err := d.ReadFault(); err != nil {
+		t.Errorf("ReadFault() after the window error got (%v)", err)
+	}
+	d.SetClock(nil)
+
+	d.InjectFaults(FailOpen(2))
+	for i, want := range []bool{true, true, false} {
+		if err := d.Open(); (err != nil) != want {
+			t.Errorf("Open() %d error got (%v)", i+1, err)
+		}
+	}
+	if err := d.ReadFault(); err != nil {
+		t.Errorf("ReadFault() failing Open only error got (%v)", err)
+	}
+
+	d.InjectFaults(FailEvery(1))
+	d.InjectFaults()
+	if err := d.ReadFault(); err != nil {
+		t.Errorf("ReadFault() cleared error got (%v)", err)
+	}
+}
+
+func TestFaultCommand(t *testing.T) {
+	defer Mock(IsMock())
+	m := NewMemMessanger()
+	_, d, _ := router(t, m)
+	errBusy := errors.New("busy")
+	RegisterFaultError("Busy", errBusy)
+
+	command := func(payload string) Ack {
+		t.Helper()
+		m.Reset()
+		m.PublishQoS(d.ControlTopic(), []byte(payload), AtLeastOnce, false)
+		got := acks(t, m, d)
+		if len(got) != 1 {
+			t.Fatalf("%s acks got (%+v) want 1", payload, got)
+		}
+		return got[0]
+	}
+
+	Mock(false)
+	if ack := command(`{"id":"1","cmd":"fault","args":{"every":1}}`); ack.OK {
+		t.Error("fault command out of mock mode got ok")
+	}
+
+	Mock(true)
+	if ack := command(`{"id":"2","cmd":"fault","args":{"every":2,"err":"busy","open":1}}`); !ack.OK {
+		t.Fatalf("fault command got (%+v)", ack)
+	}
+	if err := d.Open(); err != errBusy {
+		t.Errorf("Open() error got (%v) want (%v)", err, errBusy)
+	}
+	if d.ReadFault() != nil || d.ReadFault() != errBusy {
+		t.Error("reads not failed every 2nd")
+	}
+
+	for _, payload := range []string{
+		`{"id":"3","cmd":"fault","args":{"for":"soon"}}`,
+		`{"id":"4","cmd":"fault","args":{"err":"meltdown"}}`,
+	} {
+		if ack := command(payload); ack.OK || ack.Error == "" {
+			t.Errorf("%s ack got (%+v) want a nack", payload, ack)
+		}
+	}
+
+	if ack := command(`{"id":"5","cmd":"fault","args":{"for":"1h"}}`); !ack.OK {
+		t.Fatalf("fault command for got (%+v)", ack)
+	}
+	if err := d.ReadFault(); !errors.Is(err, ErrInjected) {
+		t.Errorf("ReadFault() error got (%v) want (%v)", err, ErrInjected)
+	}
+	command(`{"id":"6","cmd":"fault"}`)
+	if err := d.ReadFault(); err != nil {
+		t.Errorf("ReadFault() cleared by command error got (%v)", err)
+	}
+}
+
+// FuzzDurationArg feeds the for of the fault command: a positive
+// duration injects the faults, anything else fails with an
+// ErrBadPayload
+func FuzzDurationArg(f *testing.F) {
+	for _, seed := range []string{
+		"30s", "1h30m", "250ms", "1.5h", "", "0", "0s", "-5s", "soon",
+		"1e9h", "9999999999h", "s", "1.5.5s", "+3m", " 3m", "3",
+	} {
+		f.Add(seed)
+	}
+	defer Mock(IsMock())
+	Mock(true)
+	d := NewDevice("fuzz-fault", "mqtt")
+	rt := NewRouter(d)
+	f.Fuzz(func(t *testing.T, s string) {
+		args, err := json.Marshal(FaultArgs{For: s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := `{"cmd":"fault","args":` + string(args) + `}`
+		err = rt.Execute(NewMsg(d.ControlTopic(), []byte(payload), "fuzz"))
+		dur, perr := time.ParseDuration(s)
+		switch valid := s == "" || (perr == nil && dur > 0); {
+		case valid && err != nil:
+			t.Errorf("fault for (%q) error got (%v) want nil", s, err)
+		case !valid && !errors.Is(err, ErrBadPayload):
+			t.Errorf("fault for (%q) error got (%v) want an ErrBadPayload", s, err)
+		}
+		d.InjectFaults()
+	})
+}
@@ -0,0 +1,314 @@
+// Package flowmeter reads a hall effect flow sensor like the
+// YF-S201, which pulses at a rate proportional to the flow. The
+// pulses are counted from the kernel's event stream, the event buffer
+// is sized for hundreds of pulses a second and the events lost when
+// it overflows are still counted from the gaps in their numbers.
+//
+// The flow rate, the volume of the session and the lifetime volume are
+// published, the lifetime volume is saved in the device store. With a
+// no flow timeout set a NoFlowEvent is published when the flow is
+// expected, the valve commanded open, but no pulse comes.
+package flowmeter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultKFactor is the pulses a liter of the YF-S201, 7.5Hz
+	// per L/min
+	DefaultKFactor = 450
+
+	// EventBufferSize is the number of events the kernel holds for
+	// the line, half a second of pulses at full flow
+	EventBufferSize = 256
+)
+
+var ErrCommand = errors.New("unknown command")
+
+// Reading is the flow rate in L/min since the last reading, and the
+// volumes in liters. Lost is the number of pulses the kernel dropped
+// that were counted from the event numbers.
+type Reading struct {
+	Rate     float64 `json:"rate_l_min"`
+	Session  float64 `json:"session_l"`
+	Lifetime float64 `json:"lifetime_l"`
+	Lost     int64   `json:"lost_pulses,omitempty"`
+}
+
+// NoFlowEvent is published when the flow is expected but there has
+// been no pulse for the no flow timeout
+type NoFlowEvent struct {
+	Event string    `json:"event"`
+	Since time.Time `json:"since"`
+}
+
+// saved is the lifetime volume kept in the device store
+type saved struct {
+	Liters float64 `json:"liters"`
+}
+
+// FlowMeter is a pulse flow sensor on a GPIO line
+type FlowMeter struct {
+	*device.Device
+
+	kfactor float64
+	pin     *drivers.DigitalPin
+	cnt     pulses
+
+	lifeBase  float64 // liters saved before this start
+	savedAt   int64   // the pulses counted when the lifetime was saved
+	session   int64   // the pulses counted when the session started
+	rateCount int64   // the pulses and the time of the last reading
+	rateAt    time.Time
+
+	noFlow    time.Duration
+	expect    bool
+	expectAt  time.Time
+	flowTimer *device.Timer
+	alerted   bool
+
+	mu sync.Mutex
+}
+
+// New creates a flow meter on the line at offset of the default chip,
+// kfactor is the pulses a liter. opts are added to the line request
+// after the defaults.
+func New(name string, offset int, kfactor float64, opts ...gpiocdev.LineReqOption) (*FlowMeter, error) {
+	f := &FlowMeter{
+		Device:  device.NewDevice(name, "mqtt"),
+		kfactor: kfactor,
+	}
+	f.rateAt = f.Now()
+	f.restore()
+	if device.IsMock() {
+		return f, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("flowmeter"),
+		gpiocdev.AsInput,
+		gpiocdev.WithRisingEdge,
+		gpiocdev.WithEventBufferSize(EventBufferSize),
+		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			f.pulse(evt.LineSeqno, f.Now())
+		}),
+	}, opts...)
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.pin = pin
+	f.mu.Unlock()
+	return f, nil
+}
+
+// Name returns the name of the device
+func (f *FlowMeter) Name() string {
+	return f.Device.Name
+}
+
+func (f *FlowMeter) liters(pulses int64) float64 {
+	return float64(pulses) / f.kfactor
+}
+
+// Read returns the flow rate since the last Read and the volumes
+func (f *FlowMeter) Read() *Reading {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.Now()
+	r := &Reading{
+		Session:  f.liters(f.cnt.total - f.session),
+		Lifetime: f.lifeBase + f.liters(f.cnt.total),
+		Lost:     f.cnt.lost,
+	}
+	if d := now.Sub(f.rateAt); d > 0 {
+		r.Rate = f.liters(f.cnt.total-f.rateCount) / d.Minutes()
+	}
+	f.rateCount, f.rateAt = f.cnt.total, now
+	return r
+}
+
+// ReadPub publishes the Reading and saves the lifetime volume if it
+// changed
+func (f *FlowMeter) ReadPub() error {
+	r := f.Read()
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f.PubData(j)
+	return f.save()
+}
+
+// Run publishes the Reading every period until ctx is canceled
+func (f *FlowMeter) Run(ctx context.Context, period time.Duration) error {
+	err := f.TimerLoop(ctx, period, f.ReadPub)
+	slog.Debug("flowmeter stopped", "device", f.Device.Name, "error", err)
+	return err
+}
+
+// ResetSession starts a new session volume
+func (f *FlowMeter) ResetSession() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.session = f.cnt.total
+}
+
+// Command handles a command payload: "reset session"
+func (f *FlowMeter) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "reset session":
+		f.ResetSession()
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// SetNoFlowTimeout sets how long the flow may be missing while it is
+// expected before a NoFlowEvent is published, 0 turns it off
+func (f *FlowMeter) SetNoFlowTimeout(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noFlow = d
+	f.armNoFlow()
+}
+
+// ExpectFlow tells the meter if there should be a flow, call it when
+// the valve or the pump is switched
+func (f *FlowMeter) ExpectFlow(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if on && !f.expect {
+		f.expectAt = f.Now()
+		f.alerted = false
+	}
+	f.expect = on
+	f.armNoFlow()
+}
+
+// Close saves the lifetime volume, stops the timer and releases the
+// line
+func (f *FlowMeter) Close() error {
+	f.mu.Lock()
+	stop(&f.flowTimer)
+	pin := f.pin
+	f.pin = nil
+	f.mu.Unlock()
+
+	err := f.save()
+	if pin != nil {
+		err = errors.Join(err, pin.Close())
+	}
+	return err
+}
+
+// MockPulses counts n pulses in mock mode
+func (f *FlowMeter) MockPulses(n int) {
+	for i := 0; i < n; i++ {
+		f.pulse(0, f.Now())
+	}
+}
+
+func (f *FlowMeter) pulse(seq uint32, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cnt.event(seq, t)
+	if f.alerted {
+		// the flow is back, alert again when it stops
+		f.alerted = false
+		f.armNoFlow()
+	}
+}
+
+// armNoFlow starts the no flow timer for the deadline of the last
+// pulse or the start of the expected flow, f.mu is held
+func (f *FlowMeter) armNoFlow() {
+	stop(&f.flowTimer)
+	if f.noFlow <= 0 || !f.expect || f.alerted {
+		return
+	}
+	wait := f.flowSince().Add(f.noFlow).Sub(f.Now())
+	f.flowTimer = f.Clock().AfterFunc(max(wait, 0), func() { f.checkFlow() })
+}
+
+// flowSince returns the later of the last pulse and the start of the
+// expected flow, f.mu is held
+func (f *FlowMeter) flowSince() time.Time {
+	if f.cnt.last.After(f.expectAt) {
+		return f.cnt.last
+	}
+	return f.expectAt
+}
+
+// checkFlow publishes a NoFlowEvent if the flow is expected and
+// there has been no pulse for the timeout, otherwise the timer is
+// armed for the new deadline. It returns true if it published.
+func (f *FlowMeter) checkFlow() bool {
+	f.mu.Lock()
+	since := f.flowSince()
+	due := f.noFlow > 0 && f.expect && !f.alerted && f.Now().Sub(since) >= f.noFlow
+	if due {
+		f.alerted = true
+	} else {
+		f.armNoFlow()
+	}
+	f.mu.Unlock()
+
+	if !due {
+		return false
+	}
+	slog.Warn("flowmeter no flow", "device", f.Device.Name, "since", since)
+	if j, err := json.Marshal(&NoFlowEvent{Event: "no_flow", Since: since}); err == nil {
+		f.PubData(j)
+	}
+	return true
+}
+
+// save saves the lifetime volume if it changed since the last save
+func (f *FlowMeter) save() error {
+	f.mu.Lock()
+	if f.cnt.total == f.savedAt {
+		f.mu.Unlock()
+		return nil
+	}
+	s := saved{Liters: f.lifeBase + f.liters(f.cnt.total)}
+	f.savedAt = f.cnt.total
+	f.mu.Unlock()
+	return device.GetStore().Save(f.storeKey(), &s)
+}
+
+// restore loads the lifetime volume saved before a restart
+func (f *FlowMeter) restore() {
+	var s saved
+	err := device.GetStore().Load(f.storeKey(), &s)
+	switch {
+	case err == nil:
+		f.lifeBase = s.Liters
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("flowmeter loading the lifetime volume", "device", f.Device.Name, "error", err)
+	}
+}
+
+func (f *FlowMeter) storeKey() string {
+	return f.Device.Name + "/lifetime"
+}
+
+func stop(t **device.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
@@ -0,0 +1,200 @@
+package flowmeter
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// newMock creates a YF-S201 in mock mode on clock
+func newMock(t *testing.T, clock *device.SimClock) *FlowMeter {
+	device.Mock(true)
+	t.Cleanup(func() { device.Mock(false) })
+	f, err := New("irrigation", 12, DefaultKFactor)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	f.SetClock(clock)
+	f.rateAt = clock.Now()
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestPulses(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		seqs  []uint32
+		total int64
+		lost  int64
+	}{
+		{"in order", []uint32{1, 2, 3, 4}, 4, 0},
+		{"overflow", []uint32{1, 2, 10, 11}, 11, 7},
+		{"starting late", []uint32{40, 41}, 2, 0},
+		{"wrapping", []uint32{math.MaxUint32 - 1, math.MaxUint32, 2}, 5, 2},
+		{"requested again", []uint32{7, 8, 1, 2}, 4, 0},
+		{"unnumbered", []uint32{0, 0, 0}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p pulses
+			for i, s := range tt.seqs {
+				p.event(s, t0.Add(time.Duration(i)*time.Millisecond))
+			}
+			if p.total != tt.total || p.lost != tt.lost {
+				t.Errorf("pulses got (%d, %d lost) want (%d, %d lost)", p.total, p.lost, tt.total, tt.lost)
+			}
+		})
+	}
+}
+
+func TestRateAndVolume(t *testing.T) {
+	devicetest.UseStore(t)
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC))
+	f := newMock(t, clock)
+
+	// 10 L/min is 75Hz, for 30 seconds
+	f.MockPulses(75 * 30)
+	clock.Advance(30 * time.Second)
+	r := f.Read()
+	if !near(r.Rate, 10) || !near(r.Session, 5) || !near(r.Lifetime, 5) {
+		t.Errorf("Read() got (%+v) want (10 L/min, 5 L)", *r)
+	}
+
+	// a burst of 400Hz for 2 seconds is 53.3 L/min
+	f.MockPulses(800)
+	clock.Advance(2 * time.Second)
+	r = f.Read()
+	if !near(r.Rate, 800.0/450/(2.0/60)) || !near(r.Lifetime, 5+800.0/450) {
+		t.Errorf("Read() got (%+v) want (%v L/min)", *r, 800.0/450/(2.0/60))
+	}
+
+	// no pulses
+	clock.Advance(time.Minute)
+	if r = f.Read(); r.Rate != 0 {
+		t.Errorf("Read() without pulses got (%v L/min) want (0)", r.Rate)
+	}
+
+	if err := f.Command([]byte("reset session")); err != nil {
+		t.Fatalf("Command(reset session) error = %v", err)
+	}
+	f.MockPulses(450)
+	clock.Advance(time.Minute)
+	r = f.Read()
+	if !near(r.Rate, 1) || !near(r.Session, 1) || !near(r.Lifetime, 6+800.0/450) {
+		t.Errorf("Read() after reset got (%+v) want (1 L/min, 1 L session)", *r)
+	}
+	if err := f.Command([]byte("reset")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(reset) error got (%v) want (%v)", err, ErrCommand)
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	devicetest.UseStore(t)
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC))
+	f := newMock(t, clock)
+	f.MockPulses(900)
+	if err := f.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	f.MockPulses(450)
+	f.Close()
+
+	// a restart carries the lifetime volume on, the session starts
+	// over
+	again := newMock(t, clock)
+	again.MockPulses(450)
+	r := again.Read()
+	if !near(r.Lifetime, 4) || !near(r.Session, 1) {
+		t.Errorf("Read() after restart got (%+v) want (4 L lifetime, 1 L session)", *r)
+	}
+}
+
+func TestNoFlow(t *testing.T) {
+	devicetest.UseStore(t)
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC))
+	f := newMock(t, clock)
+	f.SetNoFlowTimeout(time.Hour)
+	alerted := func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.alerted
+	}
+
+	if f.checkFlow() {
+		t.Error("checkFlow() with no flow expected got (true) want (false)")
+	}
+	f.ExpectFlow(true)
+	clock.Advance(30 * time.Second)
+	f.MockPulses(10)
+	clock.Advance(59 * time.Minute)
+	if alerted() {
+		t.Error("no flow within the timeout of a pulse")
+	}
+	// the timer alerts a timeout after the last pulse, once
+	clock.Advance(time.Minute)
+	if !alerted() {
+		t.Error("no flow a timeout after the last pulse not alerted")
+	}
+	if f.checkFlow() {
+		t.Error("second checkFlow() got (true) want (false)")
+	}
+
+	// the flow comes back and stops again
+	f.MockPulses(1)
+	if alerted() {
+		t.Error("no flow still alerted with the flow back")
+	}
+	clock.Advance(time.Hour)
+	if !alerted() {
+		t.Error("no flow after the flow stopped again not alerted")
+	}
+
+	// a stuck valve, switched on but never a pulse
+	f.ExpectFlow(false)
+	clock.Advance(time.Hour)
+	f.ExpectFlow(true)
+	clock.Advance(30 * time.Minute)
+	if alerted() {
+		t.Error("no flow before the timeout")
+	}
+	clock.Advance(30 * time.Minute)
+	if !alerted() {
+		t.Error("no flow for a stuck valve not alerted")
+	}
+}
+
+func TestFlowMeter(t *testing.T) {
+	devicetest.UseStore(t)
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	f, err := New("irrigation", 12, DefaultKFactor)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer f.Close()
+
+	line := chip.Line(12)
+	for i := 0; i < 45; i++ {
+		line.Edge(1)
+	}
+	if r := f.Read(); !near(r.Session, 0.1) || r.Lost != 0 {
+		t.Errorf("Read() got (%+v) want (0.1 L)", *r)
+	}
+
+	f.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
@@ -0,0 +1,29 @@
+package flowmeter
+
+import "time"
+
+// pulses counts the pulses of the sensor from the kernel's event
+// stream. The kernel numbers the events of a line, when its buffer
+// overflows the events are dropped but the numbers carry on, so the
+// gap in the numbers is counted as the pulses missed.
+type pulses struct {
+	total int64 // every pulse, the missed ones included
+	lost  int64
+	seq   uint32    // the number of the last event, 0 for none
+	last  time.Time // when the last pulse was seen
+}
+
+// event counts the pulse of the event numbered seq at t, seq 0 is an
+// event without a number like the mock ones
+func (p *pulses) event(seq uint32, at time.Time) {
+	n := int64(1)
+	// uint32 arithmetic carries the gap over a wrap of the numbers, a
+	// number going backwards is a line requested again
+	if gap := seq - p.seq; p.seq != 0 && seq != 0 && gap > 1 && gap < 1<<31 {
+		n += int64(gap - 1)
+		p.lost += int64(gap - 1)
+	}
+	p.seq = seq
+	p.total += n
+	p.last = at
+}
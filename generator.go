@@ -0,0 +1,179 @@
+package device
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Generator makes the mock values of a sensor as time passes, the
+// value at t from its start. The generators of a demo draw waves a
+// dashboard and the thresholds make sense of, rather than noise.
+type Generator interface {
+	At(t time.Duration) float64
+}
+
+// GeneratorFunc is a Generator of a func
+type GeneratorFunc func(t time.Duration) float64
+
+// At returns f(t)
+func (f GeneratorFunc) At(t time.Duration) float64 {
+	return f(t)
+}
+
+// phase returns where t is in period, from 0 to 1
+func phase(t, period time.Duration) float64 {
+	if period <= 0 {
+		return 0
+	}
+	p := math.Mod(float64(t)/float64(period), 1)
+	if p < 0 {
+		p++
+	}
+	return p
+}
+
+// Sine is a sine of amplitude around offset, rising from it at the
+// start
+func Sine(amplitude float64, period time.Duration, offset float64) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		return offset + amplitude*math.Sin(2*math.Pi*phase(t, period))
+	})
+}
+
+// Ramp rises from from to to over the period, then starts over
+func Ramp(from, to float64, period time.Duration) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		return from + (to-from)*phase(t, period)
+	})
+}
+
+// Square is high the first half of the period, low the other
+func Square(low, high float64, period time.Duration) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		if phase(t, period) < 0.5 {
+			return high
+		}
+		return low
+	})
+}
+
+// Shift delays g by d, the value of g at its start d after
+func Shift(g Generator, d time.Duration) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		return g.At(t - d)
+	})
+}
+
+// Sum adds the values of the generators, a wave and its Noise
+func Sum(gens ...Generator) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		var v float64
+		for _, g := range gens {
+			v += g.At(t)
+		}
+		return v
+	})
+}
+
+// Noise is normal noise of the standard deviation sd, of r
+func Noise(sd float64, r *MockRand) Generator {
+	return GeneratorFunc(func(time.Duration) float64 {
+		return r.NormFloat64() * sd
+	})
+}
+
+// Drift adds an offset growing rate every per to the values of g, a
+// sensor aging out of its calibration
+func Drift(g Generator, rate float64, per time.Duration) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		return g.At(t) + rate*float64(t)/float64(per)
+	})
+}
+
+// ExpDrift adds an offset growing exponentially to the values of g,
+// scale at the start and e times that every tau, a fouling probe
+// degrading faster as it goes
+func ExpDrift(g Generator, scale float64, tau time.Duration) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		return g.At(t) + scale*(math.Exp(float64(t)/float64(tau))-1)
+	})
+}
+
+// Step adds jump to the values of g from at on, a sensor knocked out
+// of place
+func Step(g Generator, at time.Duration, jump float64) Generator {
+	return GeneratorFunc(func(t time.Duration) float64 {
+		if t >= at {
+			return g.At(t) + jump
+		}
+		return g.At(t)
+	})
+}
+
+// dropout is a window of a stuck value, see Dropout
+type dropout struct {
+	g        Generator
+	from, to time.Duration
+	stuck    *float64
+	mu       sync.Mutex
+}
+
+// Dropout sticks the values of g at the first one read from from on,
+// for d, a sensor whose reads repeat the last register
+func Dropout(g Generator, from, d time.Duration) Generator {
+	return &dropout{g: g, from: from, to: from + d}
+}
+
+func (o *dropout) At(t time.Duration) float64 {
+	if t < o.from || t >= o.to {
+		return o.g.At(t)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stuck == nil {
+		v := o.g.At(t)
+		o.stuck = &v
+	}
+	return *o.stuck
+}
+
+// walk is a random walk, see Walk
+type walk struct {
+	v, step, lo, hi float64
+	last            time.Duration
+	r               *MockRand
+	mu              sync.Mutex
+}
+
+// Walk walks at random from start within lo and hi, of r. It moves
+// step a second in the mean, by the time since it was last read, it
+// is read forward in time.
+func Walk(start, step, lo, hi float64, r *MockRand) Generator {
+	return &walk{v: min(max(start, lo), hi), step: step, lo: lo, hi: hi, r: r}
+}
+
+func (w *walk) At(t time.Duration) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if dt := t - w.last; dt > 0 {
+		w.v += w.r.NormFloat64() * w.step * math.Sqrt(dt.Seconds())
+		w.v = min(max(w.v, w.lo), w.hi)
+		w.last = t
+	}
+	return w.v
+}
+
+// Live returns the values of g in real time, from now
+func Live(g Generator) func() float64 {
+	return LiveOn(g, time.Now)
+}
+
+// LiveOn returns the values of g by the time of now, from its time
+// now, a SimClock fast forwarding it
+func LiveOn(g Generator, now func() time.Time) func() float64 {
+	start := now()
+	return func() float64 {
+		return g.At(now().Sub(start))
+	}
+}
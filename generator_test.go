@@ -0,0 +1,151 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGenerators(t *testing.T) {
+	r := NewMockRand(1)
+	const eps = 1e-9
+	for _, tt := range []struct {
+		name string
+		g    Generator
+		at   time.Duration
+		want float64
+	}{
+		{"sine start", Sine(2, time.Hour, 10), 0, 10},
+		{"sine peak", Sine(2, time.Hour, 10), 15 * time.Minute, 12},
+		{"sine trough", Sine(2, time.Hour, 10), 45 * time.Minute, 8},
+		{"sine periods on", Sine(2, time.Hour, 10), 100*time.Hour + 15*time.Minute, 12},
+		{"ramp start", Ramp(0, 100, 10*time.Second), 0, 0},
+		{"ramp half", Ramp(0, 100, 10*time.Second), 5 * time.Second, 50},
+		{"ramp over", Ramp(0, 100, 10*time.Second), 12 * time.Second, 20},
+		{"square high", Square(0, 1, time.Second), 400 * time.Millisecond, 1},
+		{"square low", Square(0, 1, time.Second), 600 * time.Millisecond, 0},
+		{"shift", Shift(Ramp(0, 100, 10*time.Second), 2*time.Second), 7 * time.Second, 50},
+		{"shift before", Shift(Ramp(0, 100, 10*time.Second), 2*time.Second), time.Second, 90},
+		{"sum", Sum(Square(0, 1, time.Second), Ramp(0, 10, 10*time.Second), Noise(0, r)), 5 * time.Second, 6},
+	} {
+		if got := tt.g.At(tt.at); math.Abs(got-tt.want) > eps {
+			t.Errorf("%s At(%v) got (%v) want (%v)", tt.name, tt.at, got, tt.want)
+		}
+	}
+
+	// continuous from a read to the next, a second apart
+	for _, tt := range []struct {
+		name  string
+		g     Generator
+		limit float64
+	}{
+		{"sine", Sine(5, 24*time.Hour, 15), 0.01},
+		{"ramp", Ramp(0, 100, time.Hour), 0.1},
+		{"walk", Walk(50, 1, 0, 100, r), 6}, // 6 sd of a step
+	} {
+		prev := tt.g.At(0)
+		for ts := time.Second; ts < 10*time.Minute; ts += time.Second {
+			v := tt.g.At(ts)
+			if math.Abs(v-prev) > tt.limit {
+				t.Fatalf("%s jumped from %v to %v at %v", tt.name, prev, v, ts)
+			}
+			prev = v
+		}
+	}
+
+	// the walk within its bounds, still between reads at once
+	w := Walk(5, 10, 0, 10, r)
+	for ts := time.Second; ts < time.Hour; ts += time.Second {
+		if v := w.At(ts); v < 0 || v > 10 {
+			t.Fatalf("walk at %v got (%v) out of 0 - 10", ts, v)
+		}
+	}
+	if w.At(time.Hour) != w.At(time.Hour) || w.At(time.Minute) != w.At(time.Hour) {
+		t.Error("walk moved without time passing")
+	}
+
+	// noise of the seed, around 0
+	var sum float64
+	for range 1000 {
+		sum += Noise(1, r).At(0)
+	}
+	if math.Abs(sum/1000) > 0.1 {
+		t.Errorf("noise mean got %v want 0", sum/1000)
+	}
+
+	live := Live(Ramp(0, 1000, time.Second))
+	first := live()
+	time.Sleep(20 * time.Millisecond)
+	if second := live(); second <= first {
+		t.Errorf("live got (%v) then (%v) want it advancing", first, second)
+	}
+}
+
+func TestDriftAnomalies(t *testing.T) {
+	const eps = 1e-9
+	flat := GeneratorFunc(func(time.Duration) float64 { return 20 })
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewSimClock(start)
+
+	// drifting half a degree an hour, knocked up 5 at 36h, the drifts
+	// added to the jump
+	linear := LiveOn(Step(Drift(flat, 0.5, time.Hour), 36*time.Hour, 5), clock.Now)
+	exp := LiveOn(ExpDrift(flat, 0.1, 24*time.Hour), clock.Now)
+	for _, tt := range []struct {
+		at     time.Duration
+		linear float64
+		exp    float64
+	}{
+		{0, 20, 20},
+		{12 * time.Hour, 26, 20 + 0.1*(math.Exp(0.5)-1)},
+		{36*time.Hour - time.Millisecond, 20 + 0.5*(36-1.0/3600000), 20 + 0.1*(math.Exp(1.5-1.0/86400000)-1)},
+		{36 * time.Hour, 20 + 18 + 5, 20 + 0.1*(math.Exp(1.5)-1)},
+		{72 * time.Hour, 20 + 36 + 5, 20 + 0.1*(math.Exp(3)-1)},
+	} {
+		clock.Set(start.Add(tt.at))
+		if got := linear(); math.Abs(got-tt.linear) > 1e-6 {
+			t.Errorf("linear drift at %v got (%v) want (%v)", tt.at, got, tt.linear)
+		}
+		if got := exp(); math.Abs(got-tt.exp) > 1e-6 {
+			t.Errorf("exponential drift at %v got (%v) want (%v)", tt.at, got, tt.exp)
+		}
+	}
+
+	// stuck at what it read first for the 2h from 10h, on after
+	ramp := Dropout(Ramp(0, 100, 100*time.Hour), 10*time.Hour, 2*time.Hour)
+	for _, tt := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{9 * time.Hour, 9},
+		{10*time.Hour + 30*time.Minute, 10.5},
+		{11 * time.Hour, 10.5},
+		{12*time.Hour - time.Second, 10.5},
+		{12 * time.Hour, 12},
+		{50 * time.Hour, 50},
+	} {
+		if got := ramp.At(tt.at); math.Abs(got-tt.want) > eps {
+			t.Errorf("dropout at %v got (%v) want (%v)", tt.at, got, tt.want)
+		}
+	}
+}
+
+// The temperature of a day, the coolest at 3 in the morning and the
+// warmest at 3 in the afternoon, for a mocked sensor started at
+// midnight
+func Example_dailyTemperature() {
+	day := Shift(Sine(5, 24*time.Hour, 15), 9*time.Hour)
+	for h := 0; h < 24; h += 3 {
+		fmt.Printf("%02d:00 %.1fC\n", h, day.At(time.Duration(h)*time.Hour))
+	}
+	// Output:
+	// 00:00 11.5C
+	// 03:00 10.0C
+	// 06:00 11.5C
+	// 09:00 15.0C
+	// 12:00 18.5C
+	// 15:00 20.0C
+	// 18:00 18.5C
+	// 21:00 15.0C
+}
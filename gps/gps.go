@@ -0,0 +1,217 @@
+// Package gps reads a GPS receiver, like the GT-U7 or a NEO-6M, from
+// the NMEA sentences it sends over a UART.
+//
+// The sentences are parsed by a Parser into a Fix, the position,
+// altitude, speed, fix quality, satellites and HDOP, which is
+// published every period of Run. Gaining or losing the fix publishes
+// a FixEvent as it happens.
+//
+// With Discipline set the GPS also keeps the offset of the local clock
+// from the GPS time, Now returns the corrected time and Run registers
+// it as the "time" of the station status. The time of a sentence is
+// when the second began, receivers send it some 100ms - 500ms later,
+// so it is good to about half a second.
+package gps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// DefaultBaud is the rate most receivers start at
+const DefaultBaud = 9600
+
+// Reading is what ReadPub publishes, the fix with the station time and
+// the sentences dropped since the start
+type Reading struct {
+	Fix
+	Time    time.Time `json:"time"`
+	Dropped int       `json:"dropped"`
+}
+
+// FixEvent is published when the fix is gained or lost
+type FixEvent struct {
+	Event string    `json:"event"` // "fix" or "no_fix"
+	Fix   Fix       `json:"fix"`
+	Time  time.Time `json:"time"`
+}
+
+// GPS is a GPS receiver sending NMEA sentences
+type GPS struct {
+	*device.Device
+
+	// Discipline corrects the station time with the GPS time
+	Discipline bool
+
+	r       io.Reader
+	parser  Parser
+	dropped int
+	offset  time.Duration
+	synced  bool
+	onFix   []func(fixed bool, fix Fix)
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates a GPS on the serial port, like "/dev/serial0", at baud
+func New(name, port string, baud int) (*GPS, error) {
+	if device.IsMock() {
+		return NewWithReader(name, strings.NewReader("")), nil
+	}
+	s, err := drivers.NewSerial(port, baud)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, s), nil
+}
+
+// NewWithReader creates a GPS reading the sentences from r, a recorded
+// log for example. r is closed by Close if it is an io.Closer.
+func NewWithReader(name string, r io.Reader) *GPS {
+	return &GPS{
+		Device: device.NewDevice(name, "mqtt"),
+		r:      r,
+		now:    time.Now,
+	}
+}
+
+// Name returns the name of the device
+func (g *GPS) Name() string {
+	return g.Device.Name
+}
+
+// Fix returns the last fix
+func (g *GPS) Fix() Fix {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.parser.Fix()
+}
+
+// Now returns the time, corrected with the GPS time once there has
+// been one when Discipline is set
+func (g *GPS) Now() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stationTime()
+}
+
+func (g *GPS) stationTime() time.Time {
+	t := g.now()
+	if g.Discipline && g.synced {
+		return t.Add(g.offset).UTC()
+	}
+	return t
+}
+
+// OnFix calls fn when the fix is gained or lost
+func (g *GPS) OnFix(fn func(fixed bool, fix Fix)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.onFix = append(g.onFix, fn)
+}
+
+// Feed parses bytes from the receiver, Run feeds what it reads
+func (g *GPS) Feed(data []byte) {
+	g.mu.Lock()
+	was := g.parser.Fix()
+	errs := g.parser.Feed(data)
+	fix := g.parser.Fix()
+	g.dropped += len(errs)
+	if fix.Time.After(was.Time) {
+		g.offset, g.synced = fix.Time.Sub(g.now()), true
+	}
+	now := g.stationTime()
+	var calls []func(bool, Fix)
+	if fix.Fixed != was.Fixed {
+		calls = g.onFix
+	}
+	g.mu.Unlock()
+
+	for _, err := range errs {
+		slog.Debug("gps sentence dropped", "device", g.Device.Name, "error", err)
+	}
+	if fix.Fixed == was.Fixed {
+		return
+	}
+	evt := &FixEvent{Event: "fix", Fix: fix, Time: now}
+	if !fix.Fixed {
+		evt.Event = "no_fix"
+	}
+	if j, err := json.Marshal(evt); err == nil {
+		g.PubData(j)
+	}
+	for _, fn := range calls {
+		fn(fix.Fixed, fix)
+	}
+}
+
+// Read returns the fix
+func (g *GPS) Read() *Reading {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return &Reading{
+		Fix:     g.parser.Fix(),
+		Time:    g.stationTime(),
+		Dropped: g.dropped,
+	}
+}
+
+// ReadPub publishes the reading
+func (g *GPS) ReadPub() error {
+	j, err := json.Marshal(g.Read())
+	if err != nil {
+		return err
+	}
+	g.PubData(j)
+	return nil
+}
+
+// Run reads the receiver in the background and publishes a reading
+// every period until ctx is canceled. A read error ends the reading,
+// the last fix is still published.
+func (g *GPS) Run(ctx context.Context, period time.Duration) error {
+	if g.Discipline {
+		device.RegisterStatus("time", func() any { return g.Now() })
+		defer device.RegisterStatus("time", nil)
+	}
+	go func() {
+		if err := g.listen(); err != nil && !errors.Is(err, io.EOF) {
+			slog.Warn("gps read", "device", g.Device.Name, "error", err)
+		}
+	}()
+	err := g.TimerLoop(ctx, period, g.ReadPub)
+	slog.Debug("gps stopped", "device", g.Device.Name, "error", err)
+	return err
+}
+
+// listen feeds what it reads until the reader fails
+func (g *GPS) listen() error {
+	buf := make([]byte, 256)
+	for {
+		n, err := g.r.Read(buf)
+		if n > 0 {
+			g.Feed(buf[:n])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the serial port, which ends the reading of Run
+func (g *GPS) Close() error {
+	if c, ok := g.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package gps
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// recorded starts with the tail of a sentence cut off by the start
+// up, looks for satellites, gets a fix, then loses it. Along the way
+// there is a sentence with a bad checksum, one missing fields, one
+// with a bad field and one cut short by the next.
+const recorded = "\xff\x00GA,160439.00,,,,,0,00,99.99,,,,,,*6B\r\n" +
+	"$GPRMC,160440.00,V,,,,,,,020125,,,N*7E\r\n" +
+	"$GPGGA,160440.00,,,,,0,00,99.99,,,,,,*61\r\n" +
+	"$GPGSV,1,1,02,01,02,193,,03,58,181,33*75\r\n" +
+	"$GPGGA,160446.00,3340.34121,N,11800.11332,W,2,08,1.20,11.8,M,-33.1,M,,0000*58\r\n" +
+	"$GPGSA,A,3,09,16,46,03,07,31,26,04,,,,,3.08,1.20,2.84*0E\r\n" +
+	"$GPGSV,4,1,13,01,02,193,,03,58,181,33,04,64,360,31,06,12,295,*7A\r\n" +
+	"$GPGSV,4,2,13,07,32,254,25,08,00,154,,09,44,317,33,16,52,085,26*72\r\n" +
+	"$GPGSV,4,3,13,26,31,051,15,27,05,124,16,31,15,053,10,46,49,200,33*76\r\n" +
+	"$GPGSV,4,4,13,48,50,193,*49\r\n" +
+	"$GLGSV,1,1,03,65,40,080,30,66,12,140,,72,55,300,28*50\r\n" +
+	"$GPGLL,3340.34121,N,11800.11332,W,160446.00,A,D*74\r\n" +
+	"$GPRMC,160447.00,A,3340.34118,N,11800.11331,W,0.063,,020125,,,D*64\r\n" +
+	"$GPVTG,,T,,M,0.063,N,0.117,K,D*24\r\n" +
+	"$GPGGA,160448.00,3340.34121,N,11800.11332,W,1,07,1.40,99.9,M,-33.1,M,,*52\r\n" +
+	"$GNGGA,160448.00,3340.34121,N*14\r\n" +
+	"$GPGGA,160451.00,3340.34121,N,11800.11332,W,x,07,1.40,12.5,M,-33.1,M,,*13\r\n" +
+	"$GPRMC,160449.00,A,3340.342$GPGGA,160448.00,3340.34121,N,11800.11332,W,1,07,1.40,12.5,M,-33.1,M,,*52\r\n" +
+	"$GPRMC,160449.00,A,3340.34200,S,11800.11400,E,10.000,45.5,020125,,,A*41\r\n" +
+	"$GPRMC,160450.00,V,,,,,,,020125,,,N*7F\r\n" +
+	"$GPGGA,160450.00,,,,,0,03,,,,,,,*4D\r\n"
+
+// dropped in recorded: the bad checksum, the missing fields, the bad
+// field and the cut short
+const dropped = 4
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParseSentence(t *testing.T) {
+	s, err := ParseSentence("$GPGSV,4,4,13,48,50,193,*49\r\n")
+	if err != nil {
+		t.Fatalf("ParseSentence() error = %v", err)
+	}
+	if s.Talker != "GP" || s.Type != "GSV" || strings.Join(s.Fields, ",") != "4,4,13,48,50,193," {
+		t.Errorf("ParseSentence() got (%+v)", s)
+	}
+
+	for line, want := range map[string]error{
+		"$GPGSV,4,4,13,48,50,193,*48": ErrChecksum,
+		"$GPGSV,4,4,13,48,50,194,*49": ErrChecksum,
+		"$GPGSV,4,4,13,48,50,193,":    ErrSentence,
+		"$GPGSV,4,4,13,48,50,193,*4":  ErrSentence,
+		"$GPGSV,4,4,13,48,50,193,*zz": ErrSentence,
+		"GPGSV,4,4,13,48,50,193,*49":  ErrSentence,
+		"$GSV,4,4,13,48,50,193,*5E":   ErrSentence,
+	} {
+		if _, err := ParseSentence(line); !errors.Is(err, want) {
+			t.Errorf("ParseSentence(%q) error got (%v) want (%v)", line, err, want)
+		}
+	}
+}
+
+func TestSentences(t *testing.T) {
+	s, _ := ParseSentence("$GPRMC,160449.00,A,3340.34200,S,11800.11400,E,10.000,45.5,020125,,,A*41")
+	rmc, err := s.RMC()
+	if err != nil {
+		t.Fatalf("RMC() error = %v", err)
+	}
+	want := time.Date(2025, 1, 2, 16, 4, 49, 0, time.UTC)
+	if !rmc.Valid || !rmc.Time.Equal(want) || !near(rmc.Latitude, -33.6723666667) ||
+		!near(rmc.Longitude, 118.0019) || !near(rmc.Speed, 18.52) || rmc.Course != 45.5 {
+		t.Errorf("RMC() got (%+v)", rmc)
+	}
+
+	// a void fix has no position
+	s, _ = ParseSentence("$GPRMC,160440.00,V,,,,,,,020125,,,N*7E")
+	if rmc, err := s.RMC(); err != nil || rmc.Valid || rmc.Latitude != 0 || rmc.Time.Second() != 40 {
+		t.Errorf("void RMC() got (%+v, %v)", rmc, err)
+	}
+
+	s, _ = ParseSentence("$GPGGA,160446.00,3340.34121,N,11800.11332,W,2,08,1.20,11.8,M,-33.1,M,,0000*58")
+	gga, err := s.GGA()
+	if err != nil {
+		t.Fatalf("GGA() error = %v", err)
+	}
+	if gga.Time != 16*time.Hour+4*time.Minute+46*time.Second || gga.Quality != 2 || gga.Satellites != 8 ||
+		gga.HDOP != 1.2 || gga.Altitude != 11.8 || !near(gga.Latitude, 33.6723535) || !near(gga.Longitude, -118.0018886667) {
+		t.Errorf("GGA() got (%+v)", gga)
+	}
+	if _, err := s.RMC(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("RMC() of a GGA error got (%v) want (%v)", err, ErrUnsupported)
+	}
+
+	s, _ = ParseSentence("$GPGSV,4,1,13,01,02,193,,03,58,181,33,04,64,360,31,06,12,295,*7A")
+	gsv, err := s.GSV()
+	if err != nil {
+		t.Fatalf("GSV() error = %v", err)
+	}
+	if gsv.Messages != 4 || gsv.Message != 1 || gsv.InView != 13 || len(gsv.Satellites) != 4 ||
+		gsv.Satellites[1] != (Satellite{PRN: 3, Elevation: 58, Azimuth: 181, SNR: 33}) || gsv.Satellites[3].SNR != 0 {
+		t.Errorf("GSV() got (%+v)", gsv)
+	}
+
+	for _, line := range []string{
+		"$GNGGA,160448.00,3340.34121,N*14",
+		"$GPGGA,160451.00,3340.34121,N,11800.11332,W,x,07,1.40,12.5,M,-33.1,M,,*13",
+		"$GPGGA,1604,,,,,0,03,,,,,,,*66",
+	} {
+		s, err := ParseSentence(line)
+		if err != nil {
+			t.Fatalf("ParseSentence(%q) error = %v", line, err)
+		}
+		if _, err := s.GGA(); !errors.Is(err, ErrSentence) {
+			t.Errorf("GGA(%q) error got (%v) want (%v)", line, err, ErrSentence)
+		}
+	}
+}
+
+func TestParser(t *testing.T) {
+	want := Fix{
+		Quality:    0,
+		Latitude:   -33.6723666667,
+		Longitude:  118.0019,
+		Altitude:   12.5,
+		Speed:      18.52,
+		Course:     45.5,
+		Satellites: 3,
+		InView:     16,
+		Time:       time.Date(2025, 1, 2, 16, 4, 50, 0, time.UTC),
+	}
+
+	// whatever the reads split the sentences into, the result is
+	// the same
+	for _, size := range []int{1, 2, 7, 64, 256, len(recorded)} {
+		var p Parser
+		errs := 0
+		for data := []byte(recorded); len(data) > 0; {
+			n := min(size, len(data))
+			errs += len(p.Feed(data[:n]))
+			data = data[n:]
+		}
+		fix := p.Fix()
+		if fix.Fixed || fix.Quality != want.Quality || !near(fix.Latitude, want.Latitude) ||
+			!near(fix.Longitude, want.Longitude) || fix.Altitude != want.Altitude || !near(fix.Speed, want.Speed) ||
+			fix.Course != want.Course || fix.Satellites != want.Satellites || fix.InView != want.InView ||
+			!fix.Time.Equal(want.Time) {
+			t.Errorf("reads of %d got (%+v) want (%+v)", size, fix, want)
+		}
+		if errs != dropped {
+			t.Errorf("reads of %d dropped (%d) want (%d)", size, errs, dropped)
+		}
+	}
+
+	// a line that never ends is dropped
+	var p Parser
+	errs := p.Feed([]byte("$GPGGA," + strings.Repeat("9", 100) + "\r\n"))
+	if len(errs) != 1 || !errors.Is(errs[0], ErrSentence) {
+		t.Errorf("long line errors got (%v) want (%v)", errs, ErrSentence)
+	}
+}
+
+func TestFix(t *testing.T) {
+	g := NewWithReader("gps", strings.NewReader(""))
+	clock := time.Date(2025, 1, 2, 16, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return clock }
+	g.Discipline = true
+
+	var events []bool
+	g.OnFix(func(fixed bool, fix Fix) { events = append(events, fixed) })
+
+	if got := g.Now(); !got.Equal(clock) {
+		t.Errorf("Now() before the GPS time got (%v) want (%v)", got, clock)
+	}
+
+	lines := strings.SplitAfter(recorded, "\n")
+	for i, line := range lines {
+		g.Feed([]byte(line))
+		if i == 12 { // the first valid RMC
+			r := g.Read()
+			if !r.Fixed || r.Quality != 2 || r.Satellites != 8 || r.HDOP != 1.2 || r.Altitude != 11.8 || r.InView != 16 {
+				t.Errorf("with the fix got (%+v)", r.Fix)
+			}
+		}
+		clock = clock.Add(100 * time.Millisecond)
+	}
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Errorf("fix events got (%v) want ([true false])", events)
+	}
+
+	// the offset is from the last RMC, 16:04:50 with the local clock
+	// at 16:00:01.9, it is now 16:00:02.2
+	want := time.Date(2025, 1, 2, 16, 4, 50, 300_000_000, time.UTC)
+	if got := g.Now(); !got.Equal(want) {
+		t.Errorf("Now() got (%v) want (%v)", got, want)
+	}
+	if r := g.Read(); r.Dropped != dropped || !r.Time.Equal(want) {
+		t.Errorf("Read() got (%d dropped at %v) want (%d at %v)", r.Dropped, r.Time, dropped, want)
+	}
+
+	g.Discipline = false
+	if got := g.Now(); !got.Equal(clock) {
+		t.Errorf("Now() without Discipline got (%v) want (%v)", got, clock)
+	}
+}
+
+func TestRun(t *testing.T) {
+	g := NewWithReader("gps", strings.NewReader(recorded))
+	g.Discipline = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- g.Run(ctx, 10*time.Millisecond) }()
+
+	time.Sleep(20 * time.Millisecond)
+	status := device.GetDeviceManager().Status()
+	if _, ok := status["time"].(time.Time); !ok {
+		t.Errorf("status time got (%v) want the GPS time", status["time"])
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error got (%v) want (%v)", err, context.DeadlineExceeded)
+	}
+	if fix := g.Fix(); fix.InView != 16 || fix.Fixed {
+		t.Errorf("Fix() got (%+v) want the end of the log", fix)
+	}
+	if _, ok := device.GetDeviceManager().Status()["time"]; ok {
+		t.Error("status time is still registered after Run")
+	}
+}
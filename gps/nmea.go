@@ -0,0 +1,241 @@
+package gps
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrChecksum    = errors.New("nmea checksum mismatch")
+	ErrSentence    = errors.New("malformed nmea sentence")
+	ErrUnsupported = errors.New("unsupported nmea sentence")
+)
+
+// MaxSentence is the longest sentence NMEA 0183 allows, from the '$'
+// to the end of the line
+const MaxSentence = 82
+
+// knots to km/h
+const kmhPerKnot = 1.852
+
+// Sentence is a sentence with a good checksum split into its fields,
+// Talker is "GP" for GPS, "GN" for a mix of systems and so on
+type Sentence struct {
+	Talker string
+	Type   string
+	Fields []string
+}
+
+// ParseSentence checks the checksum of a sentence, "$GPRMC,...*64",
+// and splits it up. The line ending is optional.
+func ParseSentence(line string) (Sentence, error) {
+	line = strings.TrimRight(line, "\r\n")
+	body, sum, ok := strings.Cut(line, "*")
+	if !strings.HasPrefix(body, "$") || !ok || len(sum) != 2 {
+		return Sentence{}, fmt.Errorf("%w: %q", ErrSentence, line)
+	}
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil {
+		return Sentence{}, fmt.Errorf("%w: %q", ErrSentence, line)
+	}
+	var got byte
+	for i := 1; i < len(body); i++ {
+		got ^= body[i]
+	}
+	if got != byte(want) {
+		return Sentence{}, fmt.Errorf("%w: %q got %02X", ErrChecksum, line, got)
+	}
+
+	f := strings.Split(body[1:], ",")
+	if len(f[0]) != 5 {
+		return Sentence{}, fmt.Errorf("%w: %q", ErrSentence, line)
+	}
+	return Sentence{Talker: f[0][:2], Type: f[0][2:], Fields: f[1:]}, nil
+}
+
+// RMC is the recommended minimum data, sent every fix
+type RMC struct {
+	Time      time.Time // UTC
+	Valid     bool
+	Latitude  float64 // degrees, south negative
+	Longitude float64 // degrees, west negative
+	Speed     float64 // km/h
+	Course    float64 // degrees true
+}
+
+// GGA is the fix data
+type GGA struct {
+	Time       time.Duration // since midnight UTC
+	Latitude   float64
+	Longitude  float64
+	Quality    int // 0 no fix, 1 GPS, 2 DGPS, 4 RTK ...
+	Satellites int // in use
+	HDOP       float64
+	Altitude   float64 // meters above mean sea level
+}
+
+// GSV is one of the sentences listing the satellites in view
+type GSV struct {
+	Messages   int
+	Message    int
+	InView     int
+	Satellites []Satellite
+}
+
+// Satellite is a satellite in view, SNR is 0 when it is not tracked
+type Satellite struct {
+	PRN       int
+	Elevation int
+	Azimuth   int
+	SNR       int
+}
+
+// fields reads the fields of a sentence, empty fields read as zero.
+// The first error is kept.
+type fields struct {
+	s   Sentence
+	err error
+}
+
+func (f *fields) bad(i int) {
+	if f.err == nil {
+		f.err = fmt.Errorf("%w: %s%s field %d %q", ErrSentence, f.s.Talker, f.s.Type, i+1, f.s.Fields[i])
+	}
+}
+
+func (f *fields) float(i int) float64 {
+	if f.s.Fields[i] == "" {
+		return 0
+	}
+	v, err := strconv.ParseFloat(f.s.Fields[i], 64)
+	if err != nil {
+		f.bad(i)
+	}
+	return v
+}
+
+func (f *fields) int(i int) int {
+	if f.s.Fields[i] == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(f.s.Fields[i])
+	if err != nil {
+		f.bad(i)
+	}
+	return v
+}
+
+// coord reads ddmm.mmmm or dddmm.mmmm at i and the hemisphere at i+1
+func (f *fields) coord(i int) float64 {
+	v := f.float(i)
+	deg := float64(int(v / 100))
+	v = deg + (v-deg*100)/60
+	switch f.s.Fields[i+1] {
+	case "S", "W":
+		return -v
+	case "N", "E", "":
+		return v
+	}
+	f.bad(i + 1)
+	return 0
+}
+
+// clock reads hhmmss.ss at i
+func (f *fields) clock(i int) time.Duration {
+	s := f.s.Fields[i]
+	if s == "" {
+		return 0
+	}
+	if len(s) < 6 {
+		f.bad(i)
+		return 0
+	}
+	h, err1 := strconv.Atoi(s[0:2])
+	m, err2 := strconv.Atoi(s[2:4])
+	sec, err3 := strconv.ParseFloat(s[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil || h > 23 || m > 59 || sec >= 61 {
+		f.bad(i)
+		return 0
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second)).Round(time.Millisecond)
+}
+
+func (f *fields) want(n int) bool {
+	if len(f.s.Fields) < n {
+		f.err = fmt.Errorf("%w: %s%s has %d fields want %d", ErrSentence, f.s.Talker, f.s.Type, len(f.s.Fields), n)
+		return false
+	}
+	return true
+}
+
+// RMC reads a RMC sentence, a void fix has empty fields. Without a
+// date the Time is zero.
+func (s Sentence) RMC() (RMC, error) {
+	f := &fields{s: s}
+	if s.Type != "RMC" || !f.want(9) {
+		return RMC{}, errors.Join(f.err, unsupported(s, "RMC"))
+	}
+	r := RMC{
+		Valid:     s.Fields[1] == "A",
+		Latitude:  f.coord(2),
+		Longitude: f.coord(4),
+		Speed:     f.float(6) * kmhPerKnot,
+		Course:    f.float(7),
+	}
+	clock := f.clock(0)
+	if date := s.Fields[8]; date != "" {
+		d, err := time.Parse("020106", date)
+		if err != nil {
+			f.bad(8)
+		}
+		r.Time = d.Add(clock)
+	}
+	return r, f.err
+}
+
+// GGA reads a GGA sentence
+func (s Sentence) GGA() (GGA, error) {
+	f := &fields{s: s}
+	if s.Type != "GGA" || !f.want(9) {
+		return GGA{}, errors.Join(f.err, unsupported(s, "GGA"))
+	}
+	return GGA{
+		Time:       f.clock(0),
+		Latitude:   f.coord(1),
+		Longitude:  f.coord(3),
+		Quality:    f.int(5),
+		Satellites: f.int(6),
+		HDOP:       f.float(7),
+		Altitude:   f.float(8),
+	}, f.err
+}
+
+// GSV reads a GSV sentence, up to 4 satellites each
+func (s Sentence) GSV() (GSV, error) {
+	f := &fields{s: s}
+	if s.Type != "GSV" || !f.want(3) {
+		return GSV{}, errors.Join(f.err, unsupported(s, "GSV"))
+	}
+	g := GSV{Messages: f.int(0), Message: f.int(1), InView: f.int(2)}
+	// a trailing signal id of NMEA 4.1 makes the count odd
+	for i := 3; i+3 < len(s.Fields); i += 4 {
+		g.Satellites = append(g.Satellites, Satellite{
+			PRN:       f.int(i),
+			Elevation: f.int(i + 1),
+			Azimuth:   f.int(i + 2),
+			SNR:       f.int(i + 3),
+		})
+	}
+	return g, f.err
+}
+
+func unsupported(s Sentence, want string) error {
+	if s.Type == want {
+		return nil
+	}
+	return fmt.Errorf("%w: %s%s is not %s", ErrUnsupported, s.Talker, s.Type, want)
+}
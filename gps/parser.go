@@ -0,0 +1,123 @@
+package gps
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fix is what the receiver last reported
+type Fix struct {
+	Fixed      bool      `json:"fix"`
+	Quality    int       `json:"quality"`
+	Latitude   float64   `json:"lat"`
+	Longitude  float64   `json:"lon"`
+	Altitude   float64   `json:"altitude_m"`
+	Speed      float64   `json:"speed_kmh"`
+	Course     float64   `json:"course_deg"`
+	Satellites int       `json:"satellites"`
+	InView     int       `json:"in_view"`
+	HDOP       float64   `json:"hdop"`
+	Time       time.Time `json:"gps_time"`
+}
+
+// Parser reads a stream of NMEA sentences as the bytes come off the
+// UART, into the Fix. A sentence split over several reads is put back
+// together, bytes up to the first '$', like a half sentence on start
+// up, are dropped.
+//
+// RMC, GGA and GSV sentences update the Fix from any talker, the
+// rest are skipped. Position and speed are only taken from a valid
+// fix, the fix is lost when an RMC reports void or a GGA quality 0.
+type Parser struct {
+	fix  Fix
+	line []byte
+	in   bool // in a sentence
+
+	// in view of every talker, GPS, GLONASS ... as of their last
+	// complete set of GSV sentences
+	inView map[string]int
+}
+
+// Feed parses data, returning the errors of the sentences that were
+// dropped for a bad checksum or a bad field, or cut short
+func (p *Parser) Feed(data []byte) []error {
+	var errs []error
+	for _, b := range data {
+		switch {
+		case b == '$':
+			if p.in {
+				errs = append(errs, fmt.Errorf("%w: cut short %q", ErrSentence, p.line))
+			}
+			p.line, p.in = append(p.line[:0], b), true
+		case !p.in:
+			// between sentences
+		case b == '\n':
+			if err := p.sentence(string(p.line)); err != nil {
+				errs = append(errs, err)
+			}
+			p.in = false
+		case len(p.line) >= MaxSentence-1: // no room for the newline
+			errs = append(errs, fmt.Errorf("%w: longer than %d %q", ErrSentence, MaxSentence, p.line))
+			p.in = false
+		default:
+			p.line = append(p.line, b)
+		}
+	}
+	return errs
+}
+
+// Fix returns the fix as of the sentences fed so far
+func (p *Parser) Fix() Fix {
+	return p.fix
+}
+
+// sentence updates the fix from a sentence
+func (p *Parser) sentence(line string) error {
+	s, err := ParseSentence(line)
+	if err != nil {
+		return err
+	}
+	switch s.Type {
+	case "RMC":
+		r, err := s.RMC()
+		if err != nil {
+			return err
+		}
+		if !r.Time.IsZero() {
+			p.fix.Time = r.Time
+		}
+		p.fix.Fixed = r.Valid
+		if r.Valid {
+			p.fix.Latitude, p.fix.Longitude = r.Latitude, r.Longitude
+			p.fix.Speed, p.fix.Course = r.Speed, r.Course
+		}
+	case "GGA":
+		g, err := s.GGA()
+		if err != nil {
+			return err
+		}
+		p.fix.Quality, p.fix.Satellites, p.fix.HDOP = g.Quality, g.Satellites, g.HDOP
+		p.fix.Fixed = g.Quality > 0
+		if p.fix.Fixed {
+			p.fix.Latitude, p.fix.Longitude = g.Latitude, g.Longitude
+			p.fix.Altitude = g.Altitude
+		}
+	case "GSV":
+		g, err := s.GSV()
+		if err != nil {
+			return err
+		}
+		if g.Message != g.Messages {
+			return nil
+		}
+		if p.inView == nil {
+			p.inView = make(map[string]int)
+		}
+		p.inView[s.Talker] = g.InView
+		p.fix.InView = 0
+		for _, n := range p.inView {
+			p.fix.InView += n
+		}
+	}
+	return nil
+}
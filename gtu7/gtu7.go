@@ -6,8 +6,8 @@ import (
 	"log/slog"
 	"strings"
 
-	"github.com/rustyeddy/otto/device"
-	"github.com/rustyeddy/otto/device/drivers"
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 type GTU7 struct {
@@ -25,10 +25,10 @@ func NewGTU7(name string) *GTU7 {
 		slog.Error("GTU7 failed to open", "error", err)
 		return nil
 	}
-       g := &GTU7{
-	       Device: device.NewDevice(name, "mqtt"),
-	       Serial: s,
-       }
+	g := &GTU7{
+		Device: device.NewDevice(name, "mqtt"),
+		Serial: s,
+	}
 	return g
 }
 
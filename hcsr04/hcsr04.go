@@ -0,0 +1,324 @@
+// Package hcsr04 measures distance with the HC-SR04 ultrasonic
+// sensor. A pulse on TRIG sends a burst of ultrasound, ECHO stays
+// high for the time of flight of the burst to the obstacle and back.
+// The echo is timed from the kernel's GPIO edge event timestamps, so
+// scheduling delays do not skew the distance. Linked to a
+// device.Thermometer in the device manager, like a bme280 next to the
+// sensor, the speed of sound is compensated for the air temperature.
+package hcsr04
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultPings is how many pings a reading takes the median of
+	DefaultPings = 5
+
+	// DefaultTimeout is the longest Read waits for an echo, the
+	// sensor gives up after about 38ms on its own
+	DefaultTimeout = 50 * time.Millisecond
+
+	// MinDistance and MaxDistance in cm are the range of the sensor,
+	// echoes outside it are out of range
+	MinDistance = 2
+	MaxDistance = 400
+
+	// the trigger pulse is at least 10us, pings closer than 60ms
+	// apart pick up the echoes of the previous one
+	triggerPulse = 10 * time.Microsecond
+	pingInterval = 60 * time.Millisecond
+)
+
+var (
+	// ErrNoEcho is returned by a ping when no echo came back within
+	// the timeout
+	ErrNoEcho = errors.New("no echo")
+
+	// ErrSensor is a temperature device to link that is not in the
+	// device manager
+	ErrSensor = device.ErrSource
+)
+
+// Reading is a distance in cm, Valid is false when the obstacle is
+// out of range or too few pings returned an echo
+type Reading struct {
+	Distance float64 `json:"distance"`
+	Valid    bool    `json:"valid"`
+}
+
+// SpeedOfSound returns the speed of sound in m/s in air of the given
+// temperature in C
+func SpeedOfSound(temp float64) float64 {
+	return 331.3 + 0.606*temp
+}
+
+// Distance converts the echo width to a distance in cm, the sound
+// travels to the obstacle and back
+func Distance(echo time.Duration, speed float64) float64 {
+	return echo.Seconds() * speed * 100 / 2
+}
+
+// HCSR04 is an ultrasonic distance sensor on two GPIO lines
+type HCSR04 struct {
+	*device.Device
+
+	// Pings is the number of pings a reading takes the median of,
+	// more than half of them have to return an echo in range
+	Pings int
+
+	// Timeout is how long a ping waits for the echo
+	Timeout time.Duration
+
+	// Speed is the speed of sound in m/s used when not linked to a
+	// temperature device, or when it fails to read
+	Speed float64
+
+	sensor string // the device.Thermometer linked
+	trig   *drivers.DigitalPin
+	echo   *drivers.DigitalPin
+	edges  chan gpiocdev.LineEvent
+	mock   []float64
+
+	ping  func() (time.Duration, error)
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates an HC-SR04 with TRIG and ECHO on the lines at the given
+// offsets of the default chip. The sensor's ECHO is 5V, it needs a
+// level shifter or divider on a Pi.
+func New(name string, trig, echo int) (*HCSR04, error) {
+	h := &HCSR04{
+		Device:  device.NewDevice(name, "mqtt"),
+		Pings:   DefaultPings,
+		Timeout: DefaultTimeout,
+		Speed:   SpeedOfSound(20),
+		edges:   make(chan gpiocdev.LineEvent, 16),
+		sleep:   time.Sleep,
+	}
+	if device.IsMock() {
+		h.ping = h.pingMock
+		return h, nil
+	}
+
+	var err error
+	h.trig, err = drivers.GetGPIO().Request(name+"-trig", trig,
+		drivers.WithOwner("hcsr04"),
+		gpiocdev.AsOutput(0),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h.echo, err = drivers.GetGPIO().Request(name+"-echo", echo,
+		drivers.WithOwner("hcsr04"),
+		gpiocdev.AsInput,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(h.edge),
+	)
+	if err != nil {
+		h.trig.Close()
+		return nil, err
+	}
+	h.ping = h.pingGPIO
+	return h, nil
+}
+
+// Name returns the name of the device
+func (h *HCSR04) Name() string {
+	return h.Device.Name
+}
+
+// Read pings Pings times and returns the median distance. An echo
+// that does not return or is out of range only fails the reading if
+// more than half of the pings do, a lost echo is not an error.
+func (h *HCSR04) Read() (*Reading, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	speed := h.speed()
+	pings := max(h.Pings, 1)
+	var dists []float64
+	for i := 0; i < pings; i++ {
+		if i > 0 {
+			h.sleep(pingInterval)
+		}
+		echo, err := h.ping()
+		if errors.Is(err, ErrNoEcho) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d := Distance(echo, speed); d >= MinDistance && d <= MaxDistance {
+			dists = append(dists, d)
+		}
+	}
+
+	if len(dists) <= pings/2 {
+		return &Reading{}, nil
+	}
+	return &Reading{Distance: median(dists), Valid: true}, nil
+}
+
+// Link compensates the speed of sound with the temperature of the
+// device.Thermometer added to the device manager as sensor, "" uses
+// Speed again
+func (h *HCSR04) Link(sensor string) error {
+	if sensor != "" {
+		if _, err := device.GetThermometer(sensor); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sensor = sensor
+	return nil
+}
+
+// speed returns the speed of sound compensated for the temperature
+// of the linked device, Speed when it is not read
+func (h *HCSR04) speed() float64 {
+	if h.sensor == "" {
+		return h.Speed
+	}
+	th, err := device.GetThermometer(h.sensor)
+	if err != nil {
+		slog.Debug("hcsr04 temperature", "device", h.Device.Name, "error", err)
+		return h.Speed
+	}
+	t, err := th.Temperature()
+	if err != nil {
+		slog.Debug("hcsr04 temperature", "device", h.Device.Name, "sensor", h.sensor, "error", err)
+		return h.Speed
+	}
+	return SpeedOfSound(t)
+}
+
+// median returns the middle value, the mean of the two middle values
+// for an even count. It is not bothered by the odd stray echo off a
+// wall the mean would be dragged by.
+func median(vals []float64) float64 {
+	s := slices.Clone(vals)
+	slices.Sort(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// ReadPub reads the distance and publishes it
+func (h *HCSR04) ReadPub() error {
+	r, err := h.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	h.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (h *HCSR04) Run(ctx context.Context, period time.Duration) error {
+	err := h.TimerLoop(ctx, period, h.ReadPub)
+	slog.Debug("hcsr04 stopped", "device", h.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the GPIO lines
+func (h *HCSR04) Close() error {
+	if h.trig == nil {
+		return nil
+	}
+	return errors.Join(h.trig.Close(), h.echo.Close())
+}
+
+// pingGPIO pulses TRIG and times the echo
+func (h *HCSR04) pingGPIO() (time.Duration, error) {
+	// drop the edges of an echo that came back after its timeout
+	for len(h.edges) > 0 {
+		<-h.edges
+	}
+
+	if err := h.trig.Set(1); err != nil {
+		return 0, err
+	}
+	time.Sleep(triggerPulse)
+	if err := h.trig.Set(0); err != nil {
+		return 0, err
+	}
+
+	timeout := time.NewTimer(h.Timeout)
+	defer timeout.Stop()
+	var evts []gpiocdev.LineEvent
+	for {
+		select {
+		case evt := <-h.edges:
+			evts = append(evts, evt)
+			if w, err := echoWidth(evts); err == nil {
+				return w, nil
+			}
+		case <-timeout.C:
+			return 0, ErrNoEcho
+		}
+	}
+}
+
+// echoWidth returns the time from the first rising edge of ECHO to
+// the falling edge after it, ErrNoEcho if the echo is not complete.
+// The timestamps are the kernel's monotonic clock.
+func echoWidth(evts []gpiocdev.LineEvent) (time.Duration, error) {
+	var rise time.Duration
+	rising := false
+	for _, e := range evts {
+		switch {
+		case e.Type == gpiocdev.LineEventRisingEdge && !rising:
+			rise, rising = e.Timestamp, true
+		case e.Type == gpiocdev.LineEventFallingEdge && rising:
+			return e.Timestamp - rise, nil
+		}
+	}
+	return 0, ErrNoEcho
+}
+
+// edge passes the ECHO events to the ping waiting for them
+func (h *HCSR04) edge(evt gpiocdev.LineEvent) {
+	select {
+	case h.edges <- evt:
+	default:
+	}
+}
+
+// MockDistances scripts the distances in cm the pings return in mock
+// mode, 0 is a ping without echo. Unscripted pings see an obstacle
+// about a meter away.
+func (h *HCSR04) MockDistances(cm ...float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.mock = append(h.mock, cm...)
+}
+
+func (h *HCSR04) pingMock() (time.Duration, error) {
+	cm := 100 + h.MockRand().Float64()*2 - 1
+	if len(h.mock) > 0 {
+		cm, h.mock = h.mock[0], h.mock[1:]
+	}
+	if cm <= 0 {
+		return 0, ErrNoEcho
+	}
+	return time.Duration(cm * 2 / 100 / h.Speed * float64(time.Second)), nil
+}
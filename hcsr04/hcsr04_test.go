@@ -0,0 +1,232 @@
+package hcsr04
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/bme280"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// echo returns the edges of an echo starting at ts, the kernel
+// timestamps are nanoseconds of the monotonic clock
+func echo(ts, width time.Duration) []gpiocdev.LineEvent {
+	return []gpiocdev.LineEvent{
+		{Timestamp: ts, Type: gpiocdev.LineEventRisingEdge},
+		{Timestamp: ts + width, Type: gpiocdev.LineEventFallingEdge},
+	}
+}
+
+func TestEchoWidth(t *testing.T) {
+	boot := 1234 * time.Second
+	tests := []struct {
+		name string
+		evts []gpiocdev.LineEvent
+		want time.Duration
+		err  error
+	}{
+		{"echo", echo(boot, 5831*time.Microsecond), 5831 * time.Microsecond, nil},
+		{"no echo", nil, 0, ErrNoEcho},
+		{"still high", echo(boot, time.Millisecond)[:1], 0, ErrNoEcho},
+		{
+			"falling edge of an earlier echo first",
+			append([]gpiocdev.LineEvent{{Timestamp: boot - time.Millisecond, Type: gpiocdev.LineEventFallingEdge}},
+				echo(boot, 2*time.Millisecond)...),
+			2 * time.Millisecond, nil,
+		},
+	}
+	for _, tt := range tests {
+		got, err := echoWidth(tt.evts)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s got (%v, %v) want (%v, %v)", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		echo  time.Duration
+		speed float64
+		want  float64
+	}{
+		{5831 * time.Microsecond, 343, 100},
+		{583 * time.Microsecond, 343, 10},
+		{5831 * time.Microsecond, SpeedOfSound(0), 96.59},
+		{5831 * time.Microsecond, SpeedOfSound(35), 102.78},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.echo, tt.speed); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("Distance(%v, %.1f) got (%.2f) want (%.2f)", tt.echo, tt.speed, got, tt.want)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		vals []float64
+		want float64
+	}{
+		{[]float64{50}, 50},
+		{[]float64{50.2, 49.8, 310, 50, 50.1}, 50.1},
+		{[]float64{12, 50, 51, 50.5}, 50.25},
+	}
+	for _, tt := range tests {
+		if got := median(tt.vals); got != tt.want {
+			t.Errorf("median(%v) got (%v) want (%v)", tt.vals, got, tt.want)
+		}
+	}
+}
+
+// pings returns a ping answering with the echoes one after the
+// other, an echo without events times out
+func pings(echoes ...[]gpiocdev.LineEvent) func() (time.Duration, error) {
+	return func() (time.Duration, error) {
+		e := echoes[0]
+		echoes = echoes[1:]
+		return echoWidth(e)
+	}
+}
+
+func TestRead(t *testing.T) {
+	const cm = 58310 * time.Nanosecond // 1cm at 343m/s
+	boot := time.Hour
+	tests := []struct {
+		name   string
+		echoes [][]gpiocdev.LineEvent
+		want   Reading
+	}{
+		{
+			"stray echo rejected",
+			[][]gpiocdev.LineEvent{
+				echo(boot, 50*cm), echo(boot, 51*cm), echo(boot, 12*cm),
+				echo(boot, 50*cm), echo(boot, 49*cm),
+			},
+			Reading{Distance: 50, Valid: true},
+		},
+		{
+			"lost echoes",
+			[][]gpiocdev.LineEvent{
+				echo(boot, 80*cm), nil, echo(boot, 81*cm), nil, echo(boot, 80*cm),
+			},
+			Reading{Distance: 80, Valid: true},
+		},
+		{
+			"out of range",
+			[][]gpiocdev.LineEvent{
+				echo(boot, 38*time.Millisecond), nil, echo(boot, 38*time.Millisecond),
+				echo(boot, 120*cm), nil,
+			},
+			Reading{},
+		},
+		{
+			"too close",
+			[][]gpiocdev.LineEvent{
+				echo(boot, cm), echo(boot, cm), echo(boot, cm), echo(boot, 3*cm), echo(boot, cm),
+			},
+			Reading{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HCSR04{
+				Device: device.NewDevice("sonar", "mqtt"),
+				Pings:  DefaultPings,
+				Speed:  343,
+				ping:   pings(tt.echoes...),
+				sleep:  func(time.Duration) {},
+			}
+			r, err := h.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if r.Valid != tt.want.Valid || math.Abs(r.Distance-tt.want.Distance) > 0.01 {
+				t.Errorf("Read() got (%+v) want (%+v)", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	h, err := New("sonar", 23, 24)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	h.sleep = func(time.Duration) {}
+	h.Pings = 1
+
+	if err := h.Link("porch"); !errors.Is(err, ErrSensor) {
+		t.Errorf("Link(missing) error got (%v) want (%v)", err, ErrSensor)
+	}
+	porch := bme280.New("porch", "/dev/i2c-1", 0x76)
+	porch.SetMockSequence([]any{bme280.Response{Temperature: 0, Humidity: 80, Pressure: 1013.25}}, device.MockLoop)
+	dm := device.GetDeviceManager()
+	dm.Add(porch)
+	if err := h.Link("porch"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+
+	// the mock times its echo at the default 20C, at 0C the sound
+	// is slower and the same echo is closer
+	h.MockDistances(100, 100, 100)
+	r, _ := h.Read()
+	if math.Abs(r.Distance-100*SpeedOfSound(0)/SpeedOfSound(20)) > 0.01 {
+		t.Errorf("Read() at 0C got (%.2f) want (%.2f)", r.Distance, 100*SpeedOfSound(0)/SpeedOfSound(20))
+	}
+
+	// the device gone, at Speed
+	dm.Remove("porch")
+	if r, _ := h.Read(); math.Abs(r.Distance-100) > 0.01 {
+		t.Errorf("Read() without temperature got (%.2f) want (100)", r.Distance)
+	}
+	if err := h.Link(""); err != nil {
+		t.Errorf("Link(\"\") error = %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	h, err := New("sonar", 23, 24)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer h.Close()
+	h.Timeout = 5 * time.Millisecond
+	h.Pings = 3
+	h.sleep = func(time.Duration) {}
+
+	// nothing answers, the read ends out of range instead of hanging
+	done := make(chan *Reading)
+	go func() {
+		r, err := h.Read()
+		if err != nil {
+			t.Errorf("Read() error = %v", err)
+		}
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		if r == nil || r.Valid {
+			t.Errorf("Read() got (%+v) want out of range", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read() did not time out")
+	}
+
+	// 3 pulses of at least the 10us the sensor wants
+	trig := chip.Line(23)
+	if trig == nil {
+		t.Fatal("trigger line not requested")
+	}
+	driverstest.ExpectSequence(t, trig.Waveform(), 0, 1, 0, 1, 0, 1, 0)
+	driverstest.ExpectPulseWidth(t, trig.Waveform(), 1, triggerPulse, 100*time.Millisecond)
+}
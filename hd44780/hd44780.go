@@ -0,0 +1,479 @@
+// Package hd44780 drives an HD44780 character LCD, the 16x2 and 20x4
+// displays, through a PCF8574 I2C backpack.
+//
+// The backpack wires the expander to the LCD in 4 bit mode: P0 is RS,
+// P1 RW, P2 E, P3 the backlight and P4 - P7 the data lines D4 - D7.
+// Every nibble is written as two expander bytes in one I2C write, E
+// high then E low, the LCD latches the nibble on the falling edge.
+// At 100kHz the bus is slow enough for the timing of the LCD, only
+// clear and home need a wait.
+//
+// WriteLine sends only the characters of a line that changed. With
+// Watch the lines show the readings of other devices in the device
+// manager, refreshed every period of Run.
+package hd44780
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the usual address of a PCF8574 backpack, AddressA of
+	// one with a PCF8574A, both with A0 - A2 open
+	Address  = 0x27
+	AddressA = 0x3F
+)
+
+// expander bits
+const (
+	bitRS        = 0x01
+	bitE         = 0x04
+	bitBacklight = 0x08
+)
+
+// instructions, table 6 of the datasheet
+const (
+	cmdClear       = 0x01
+	cmdHome        = 0x02
+	cmdEntryMode   = 0x04 // | flags
+	cmdDisplay     = 0x08 // | flags
+	cmdFunctionSet = 0x20 // | flags
+	cmdCGRAM       = 0x40 // | address
+	cmdDDRAM       = 0x80 // | address
+
+	entryIncrement = 0x02
+	displayOn      = 0x04
+	functionTwoRow = 0x08
+)
+
+// execution times, clear and home take 1.52ms, the rest 37µs which
+// the I2C write takes anyway
+const (
+	clearTime = 2 * time.Millisecond
+	powerUp   = 50 * time.Millisecond
+)
+
+var (
+	ErrSize    = errors.New("hd44780 has 1, 2 or 4 rows of 8 to 40 columns")
+	ErrChar    = errors.New("hd44780 custom characters are 0 to 7")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Degree is the character of '°' in the standard A00 character ROM,
+// WriteLine maps '°' to it
+const Degree = 0xDF
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	Backlight bool     `json:"backlight"`
+	Lines     []string `json:"lines"`
+	Watching  []string `json:"watching,omitempty"`
+}
+
+// LCD is a character LCD on a PCF8574 backpack
+type LCD struct {
+	*device.Device
+
+	bus       string
+	addr      int
+	dev       *drivers.I2CDevice
+	cols      int
+	rows      int
+	backlight bool
+
+	// shown is what each line shows, nil when it is not known
+	shown [][]byte
+	row   int
+
+	watch []string
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates a cols by rows LCD at the given bus and address, the
+// LCD is not touched until Init
+func New(name, bus string, addr, cols, rows int) (*LCD, error) {
+	if cols < 8 || cols > 40 || (rows != 1 && rows != 2 && rows != 4) || cols*rows > 80 {
+		return nil, fmt.Errorf("%w: %dx%d", ErrSize, cols, rows)
+	}
+	return &LCD{
+		Device:    device.NewDevice(name, "mqtt"),
+		bus:       bus,
+		addr:      addr,
+		cols:      cols,
+		rows:      rows,
+		backlight: true,
+		shown:     make([][]byte, rows),
+		sleep:     time.Sleep,
+	}, nil
+}
+
+// Name returns the name of the device
+func (l *LCD) Name() string {
+	return l.Device.Name
+}
+
+// Init opens the i2c bus and puts the LCD in 4 bit mode with the
+// sequence of figure 24 of the datasheet, which works whatever mode
+// the LCD was left in. It clears the LCD and turns it on.
+func (l *LCD) Init() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !device.IsMock() {
+		dev, err := drivers.NewI2CDevice(l.bus, l.addr)
+		if err != nil {
+			return err
+		}
+		l.dev = dev
+	}
+
+	l.sleep(powerUp)
+	if err := l.write(l.flags()); err != nil {
+		return err
+	}
+	for _, step := range []struct {
+		nibble byte
+		wait   time.Duration
+	}{
+		{0x3, 5 * time.Millisecond},
+		{0x3, 150 * time.Microsecond},
+		{0x3, 0},
+		{0x2, 0}, // 4 bit mode
+	} {
+		if err := l.nibble(step.nibble, 0); err != nil {
+			return err
+		}
+		l.sleep(step.wait)
+	}
+
+	function := byte(cmdFunctionSet)
+	if l.rows > 1 {
+		function |= functionTwoRow
+	}
+	for _, cmd := range []byte{function, cmdDisplay, cmdClear, cmdEntryMode | entryIncrement, cmdDisplay | displayOn} {
+		if err := l.command(cmd); err != nil {
+			return err
+		}
+	}
+	l.cleared()
+	return nil
+}
+
+// Size returns the columns and rows
+func (l *LCD) Size() (cols, rows int) {
+	return l.cols, l.rows
+}
+
+// Clear blanks the LCD and moves the cursor home
+func (l *LCD) Clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.command(cmdClear); err != nil {
+		return err
+	}
+	l.cleared()
+	return nil
+}
+
+// Home moves the cursor to the top left
+func (l *LCD) Home() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.row = 0
+	return l.command(cmdHome)
+}
+
+// SetCursor moves the cursor to col of row, both from 0
+func (l *LCD) SetCursor(col, row int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.setCursor(col, row)
+}
+
+func (l *LCD) setCursor(col, row int) error {
+	if col < 0 || col >= l.cols || row < 0 || row >= l.rows {
+		return fmt.Errorf("%w: no column %d of row %d", ErrSize, col, row)
+	}
+	// rows 2 and 3 continue rows 0 and 1 in display RAM
+	offsets := [4]int{0x00, 0x40, l.cols, 0x40 + l.cols}
+	l.row = row
+	return l.command(cmdDDRAM | byte(offsets[row]+col))
+}
+
+// Write writes text at the cursor, it is not cut to the line
+func (l *LCD) Write(text string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shown[l.row] = nil
+	for _, c := range chars(text) {
+		if err := l.data(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLine shows text on row, cut or padded with spaces to the width
+// of the LCD. Only the characters that changed are sent.
+func (l *LCD) WriteLine(row int, text string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if row < 0 || row >= l.rows {
+		return fmt.Errorf("%w: no row %d", ErrSize, row)
+	}
+
+	line := chars(text)
+	if len(line) > l.cols {
+		line = line[:l.cols]
+	}
+	for len(line) < l.cols {
+		line = append(line, ' ')
+	}
+
+	// send from the first to the last change
+	old := l.shown[row]
+	first, last := 0, l.cols-1
+	if old != nil {
+		for first < l.cols && old[first] == line[first] {
+			first++
+		}
+		if first == l.cols {
+			return nil
+		}
+		for old[last] == line[last] {
+			last--
+		}
+	}
+	l.shown[row] = nil
+	if err := l.setCursor(first, row); err != nil {
+		return err
+	}
+	for _, c := range line[first : last+1] {
+		if err := l.data(c); err != nil {
+			return err
+		}
+	}
+	l.shown[row] = line
+	return nil
+}
+
+// CreateChar defines custom character n, 0 to 7, from 8 rows of 5
+// pixels, the low bits of each row. Write it as the rune n, "\x01"
+// for character 1.
+func (l *LCD) CreateChar(n int, rows [8]byte) error {
+	if n < 0 || n > 7 {
+		return fmt.Errorf("%w: %d", ErrChar, n)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.command(cmdCGRAM | byte(n<<3)); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := l.data(r & 0x1F); err != nil {
+			return err
+		}
+	}
+	// lines showing the character change with it, and the next text
+	// has to move the address back to display RAM
+	for i := range l.shown {
+		l.shown[i] = nil
+	}
+	return nil
+}
+
+// SetBacklight turns the backlight on or off
+func (l *LCD) SetBacklight(on bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backlight = on
+	return l.write(l.flags())
+}
+
+// Command handles a command payload: "line0 Temp: 21.4C" shows the
+// rest of the payload on row 0, "clear", "backlight on" or
+// "backlight off"
+func (l *LCD) Command(payload []byte) error {
+	cmd, text, _ := strings.Cut(string(payload), " ")
+	cmd = strings.ToLower(cmd)
+	switch {
+	case strings.HasPrefix(cmd, "line"):
+		row, err := strconv.Atoi(cmd[len("line"):])
+		if err != nil || row < 0 || row >= l.rows {
+			break
+		}
+		return l.WriteLine(row, text)
+	case cmd == "clear" && text == "":
+		return l.Clear()
+	case cmd == "backlight" && (text == "on" || text == "off"):
+		return l.SetBacklight(text == "on")
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Watch shows the readings of the named devices of the device manager
+// on the LCD, a line for every line of DeviceManager.Lines. The lines
+// are redrawn by Refresh. Watch with no names stops watching, the LCD
+// is left as it is.
+func (l *LCD) Watch(names ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.watch = names
+}
+
+// Refresh reads the watched devices and shows their lines, lines
+// beyond the bottom of the LCD are dropped
+func (l *LCD) Refresh() error {
+	l.mu.Lock()
+	names := l.watch
+	l.mu.Unlock()
+	if len(names) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, device.GetDeviceManager().Lines(name)...)
+	}
+	for r := 0; r < l.rows; r++ {
+		line := ""
+		if r < len(lines) {
+			line = lines[r]
+		}
+		if err := l.WriteLine(r, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read returns what the LCD shows, lines written with Write are empty
+func (l *LCD) Read() *Reading {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := &Reading{Backlight: l.backlight, Watching: l.watch}
+	for _, line := range l.shown {
+		r.Lines = append(r.Lines, strings.TrimRight(string(line), " "))
+	}
+	return r
+}
+
+// ReadPub publishes the reading
+func (l *LCD) ReadPub() error {
+	j, err := json.Marshal(l.Read())
+	if err != nil {
+		return err
+	}
+	l.PubData(j)
+	return nil
+}
+
+// Run refreshes the watched devices and publishes a reading every
+// period until ctx is canceled
+func (l *LCD) Run(ctx context.Context, period time.Duration) error {
+	err := l.TimerLoop(ctx, period, func() error {
+		return errors.Join(l.Refresh(), l.ReadPub())
+	})
+	slog.Debug("hd44780 stopped", "device", l.Device.Name, "error", err)
+	return err
+}
+
+// Close turns the LCD and its backlight off and releases the bus
+func (l *LCD) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dev == nil {
+		return nil
+	}
+	l.backlight = false
+	err := errors.Join(l.command(cmdDisplay), l.dev.Close())
+	l.dev = nil
+	return err
+}
+
+// cleared notes the LCD is blank with the cursor home, l.mu is held
+func (l *LCD) cleared() {
+	blank := []byte(strings.Repeat(" ", l.cols))
+	for i := range l.shown {
+		l.shown[i] = append([]byte(nil), blank...)
+	}
+	l.row = 0
+}
+
+// command sends an instruction, l.mu is held
+func (l *LCD) command(cmd byte) error {
+	if err := l.send(cmd, 0); err != nil {
+		return err
+	}
+	if cmd == cmdClear || cmd == cmdHome {
+		l.sleep(clearTime)
+	}
+	return nil
+}
+
+// data sends a character, l.mu is held
+func (l *LCD) data(c byte) error {
+	return l.send(c, bitRS)
+}
+
+// send sends b high nibble first in one write, l.mu is held
+func (l *LCD) send(b, rs byte) error {
+	hi := b&0xF0 | rs | l.flags()
+	lo := b<<4 | rs | l.flags()
+	return l.write(hi|bitE, hi, lo|bitE, lo)
+}
+
+// nibble sends the low 4 bits of n alone, l.mu is held
+func (l *LCD) nibble(n, rs byte) error {
+	b := n<<4 | rs | l.flags()
+	return l.write(b|bitE, b)
+}
+
+// flags are the expander bits held on every write, l.mu is held
+func (l *LCD) flags() byte {
+	if l.backlight {
+		return bitBacklight
+	}
+	return 0
+}
+
+// write writes bytes to the expander, l.mu is held
+func (l *LCD) write(b ...byte) error {
+	if l.dev == nil {
+		return nil
+	}
+	return l.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.Write(b)
+	})
+}
+
+// chars maps text to the character ROM, '°' to Degree and anything
+// else outside of ASCII to '?'. Runes 0 to 7 are the custom
+// characters.
+func chars(text string) []byte {
+	var b []byte
+	for _, r := range text {
+		switch {
+		case r < 8 || (r >= ' ' && r <= '}'):
+			b = append(b, byte(r))
+		case r == '°':
+			b = append(b, Degree)
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
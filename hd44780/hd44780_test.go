@@ -0,0 +1,286 @@
+package hd44780
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func newTestLCD(t *testing.T, cols, rows int) (*LCD, *driverstest.I2C, *[]time.Duration) {
+	t.Helper()
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+	l, err := New("lcd", TestI2CBus, Address, cols, rows)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var slept []time.Duration
+	l.sleep = func(d time.Duration) { slept = append(slept, d) }
+	if err := l.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return l, fake, &slept
+}
+
+// transcript checks the raw writes made to the backpack and forgets
+// them
+func transcript(t *testing.T, fake *driverstest.I2C, name string, want ...[]byte) {
+	t.Helper()
+	if len(fake.Writes) != len(want) {
+		t.Fatalf("%s got (%d) writes want (%d): %v", name, len(fake.Writes), len(want), fake.Writes)
+	}
+	for i, w := range fake.Writes {
+		if w.Reg != -1 || !bytes.Equal(w.Data, want[i]) {
+			t.Errorf("%s write %d got (% x) want (% x)", name, i, w.Data, want[i])
+		}
+	}
+	fake.Writes = nil
+}
+
+func TestInit(t *testing.T) {
+	_, fake, slept := newTestLCD(t, 16, 2)
+
+	// backlight on, E pulsed high then low for every nibble
+	transcript(t, fake, "init",
+		[]byte{0x08},
+		[]byte{0x3C, 0x38},             // 8 bit mode
+		[]byte{0x3C, 0x38},             // 8 bit mode
+		[]byte{0x3C, 0x38},             // 8 bit mode
+		[]byte{0x2C, 0x28},             // 4 bit mode
+		[]byte{0x2C, 0x28, 0x8C, 0x88}, // function set 0x28, 2 rows
+		[]byte{0x0C, 0x08, 0x8C, 0x88}, // display off
+		[]byte{0x0C, 0x08, 0x1C, 0x18}, // clear
+		[]byte{0x0C, 0x08, 0x6C, 0x68}, // entry mode increment
+		[]byte{0x0C, 0x08, 0xCC, 0xC8}, // display on
+	)
+	want := []time.Duration{50 * time.Millisecond, 5 * time.Millisecond, 150 * time.Microsecond, 0, 0, 2 * time.Millisecond}
+	if fmt.Sprint(*slept) != fmt.Sprint(want) {
+		t.Errorf("waits got (%v) want (%v)", *slept, want)
+	}
+
+	device.Mock(false)
+	fake = driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+	l, _ := New("lcd", TestI2CBus, Address, 8, 1)
+	l.sleep = func(time.Duration) {}
+	l.Init()
+	if got := fake.Writes[5].Data; !bytes.Equal(got, []byte{0x2C, 0x28, 0x0C, 0x08}) {
+		t.Errorf("one row function set got (% x) want (2c 28 0c 08)", got)
+	}
+
+	for _, size := range [][2]int{{16, 3}, {7, 2}, {41, 1}, {40, 4}} {
+		if _, err := New("lcd", TestI2CBus, Address, size[0], size[1]); !errors.Is(err, ErrSize) {
+			t.Errorf("New(%dx%d) error got (%v) want (%v)", size[0], size[1], err, ErrSize)
+		}
+	}
+}
+
+func TestWriteLine(t *testing.T) {
+	l, fake, _ := newTestLCD(t, 16, 2)
+	fake.Writes = nil
+
+	// the line is padded, a blank LCD only needs the text
+	if err := l.WriteLine(1, "Hi"); err != nil {
+		t.Fatalf("WriteLine() error = %v", err)
+	}
+	transcript(t, fake, "first line",
+		[]byte{0xCC, 0xC8, 0x0C, 0x08}, // DDRAM 0x40
+		[]byte{0x4D, 0x49, 0x8D, 0x89}, // H
+		[]byte{0x6D, 0x69, 0x9D, 0x99}, // i
+	)
+
+	l.WriteLine(1, "Hi")
+	transcript(t, fake, "same line")
+
+	l.WriteLine(1, "Ho 21.4°C")
+	transcript(t, fake, "changed line",
+		[]byte{0xCC, 0xC8, 0x1C, 0x18}, // DDRAM 0x41
+		[]byte{0x6D, 0x69, 0xFD, 0xF9}, // o
+		[]byte{0x2D, 0x29, 0x0D, 0x09}, // ' '
+		[]byte{0x3D, 0x39, 0x2D, 0x29}, // 2
+		[]byte{0x3D, 0x39, 0x1D, 0x19}, // 1
+		[]byte{0x2D, 0x29, 0xED, 0xE9}, // .
+		[]byte{0x3D, 0x39, 0x4D, 0x49}, // 4
+		[]byte{0xDD, 0xD9, 0xFD, 0xF9}, // °
+		[]byte{0x4D, 0x49, 0x3D, 0x39}, // C
+	)
+
+	l.WriteLine(0, "0123456789abcdefXYZ")
+	if r := l.Read(); r.Lines[0] != "0123456789abcdef" || r.Lines[1] != "Ho 21.4\xdfC" {
+		t.Errorf("Read() lines got (%q) want cut to 16", r.Lines)
+	}
+	fake.Writes = nil
+	l.WriteLine(0, "0123456789abcde")
+	transcript(t, fake, "shorter line",
+		[]byte{0x8C, 0x88, 0xFC, 0xF8}, // DDRAM 0x0F
+		[]byte{0x2D, 0x29, 0x0D, 0x09}, // ' '
+	)
+
+	if err := l.WriteLine(2, "x"); !errors.Is(err, ErrSize) {
+		t.Errorf("WriteLine(2) error got (%v) want (%v)", err, ErrSize)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	l, fake, slept := newTestLCD(t, 20, 4)
+	fake.Writes = nil
+
+	for _, tt := range []struct {
+		col, row int
+		addr     byte
+	}{
+		{0, 0, 0x00}, {5, 1, 0x45}, {0, 2, 0x14}, {19, 3, 0x67},
+	} {
+		if err := l.SetCursor(tt.col, tt.row); err != nil {
+			t.Fatalf("SetCursor(%d, %d) error = %v", tt.col, tt.row, err)
+		}
+		cmd := 0x80 | tt.addr
+		want := []byte{cmd&0xF0 | 0x0C, cmd&0xF0 | 0x08, cmd<<4 | 0x0C, cmd<<4 | 0x08}
+		transcript(t, fake, fmt.Sprintf("cursor %d, %d", tt.col, tt.row), want)
+	}
+	if err := l.SetCursor(20, 0); !errors.Is(err, ErrSize) {
+		t.Errorf("SetCursor(20, 0) error got (%v) want (%v)", err, ErrSize)
+	}
+
+	// text written at the cursor is resent by the next WriteLine
+	l.SetCursor(0, 2)
+	l.Write("ab")
+	fake.Writes = nil
+	l.WriteLine(2, "ab")
+	if len(fake.Writes) != 21 {
+		t.Errorf("WriteLine() after Write() got (%d) writes want the whole line", len(fake.Writes))
+	}
+
+	*slept = nil
+	l.Clear()
+	l.Home()
+	if len(*slept) != 2 || (*slept)[0] != 2*time.Millisecond {
+		t.Errorf("clear and home waits got (%v) want 2ms each", *slept)
+	}
+}
+
+func TestCreateChar(t *testing.T) {
+	l, fake, _ := newTestLCD(t, 16, 2)
+	l.WriteLine(0, "x")
+	fake.Writes = nil
+
+	bell := [8]byte{0x04, 0x0E, 0x0E, 0x0E, 0x1F, 0x00, 0x04, 0xFF}
+	if err := l.CreateChar(1, bell); err != nil {
+		t.Fatalf("CreateChar() error = %v", err)
+	}
+	want := [][]byte{{0x4C, 0x48, 0x8C, 0x88}} // CGRAM 0x08
+	for _, r := range bell {
+		r &= 0x1F
+		want = append(want, []byte{r&0xF0 | 0x09 | 0x04, r&0xF0 | 0x09, r<<4 | 0x0D, r<<4 | 0x09})
+	}
+	transcript(t, fake, "create char", want...)
+
+	// the line is sent again, back in display RAM
+	l.WriteLine(0, "x\x01")
+	transcript(t, fake, "custom char",
+		[]byte{0x8C, 0x88, 0x0C, 0x08},
+		[]byte{0x7D, 0x79, 0x8D, 0x89},
+		[]byte{0x0D, 0x09, 0x1D, 0x19},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+		[]byte{0x2D, 0x29, 0x0D, 0x09},
+	)
+	if err := l.CreateChar(8, bell); !errors.Is(err, ErrChar) {
+		t.Errorf("CreateChar(8) error got (%v) want (%v)", err, ErrChar)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	l, fake, _ := newTestLCD(t, 16, 2)
+	fake.Writes = nil
+
+	if err := l.Command([]byte("backlight off")); err != nil {
+		t.Fatalf("Command(backlight off) error = %v", err)
+	}
+	transcript(t, fake, "backlight off", []byte{0x00})
+
+	// the backlight bit follows every write
+	if err := l.Command([]byte("LINE1 Temp: 21.4C")); err != nil {
+		t.Fatalf("Command(line1) error = %v", err)
+	}
+	if w := fake.Writes[1].Data; !bytes.Equal(w, []byte{0x55, 0x51, 0x45, 0x41}) {
+		t.Errorf("T got (% x) want (55 51 45 41)", w)
+	}
+	if r := l.Read(); r.Backlight || r.Lines[1] != "Temp: 21.4C" {
+		t.Errorf("Read() got (%+v) want line 1 Temp: 21.4C, backlight off", r)
+	}
+	l.Command([]byte("backlight on"))
+	l.Command([]byte("clear"))
+	if r := l.Read(); !r.Backlight || r.Lines[1] != "" {
+		t.Errorf("Read() after clear got (%+v)", r)
+	}
+	if err := l.Command([]byte("line0")); err != nil || l.Read().Lines[0] != "" {
+		t.Errorf("Command(line0) got (%v) want the line blanked", err)
+	}
+
+	for _, bad := range []string{"", "line2 x", "linex y", "clear all", "backlight", "backlight dim", "print x"} {
+		if err := l.Command([]byte(bad)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", bad, err, ErrCommand)
+		}
+	}
+
+	fake.Writes = nil
+	l.Close()
+	transcript(t, fake, "close", []byte{0x04, 0x00, 0x84, 0x80})
+	if !fake.Closed() {
+		t.Error("Close() did not release the bus")
+	}
+}
+
+// thermometer is a device the LCD watches
+type thermometer struct {
+	temp float64
+}
+
+func (th *thermometer) Name() string { return "temp" }
+
+func (th *thermometer) Read() (float64, error) { return th.temp, nil }
+
+func TestWatch(t *testing.T) {
+	dm := device.GetDeviceManager()
+	th := &thermometer{temp: 21.4}
+	dm.Add(th)
+	defer dm.Remove("temp")
+
+	l, fake, _ := newTestLCD(t, 16, 2)
+	l.Watch("temp", "missing")
+	if err := l.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if r := l.Read(); r.Lines[0] != "temp 21.4" || r.Lines[1] != "missing -" {
+		t.Errorf("Read() lines got (%q) want ([temp 21.4 missing -])", r.Lines)
+	}
+
+	// only the changed digit is sent
+	fake.Writes = nil
+	th.temp = 21.5
+	l.Refresh()
+	transcript(t, fake, "refresh",
+		[]byte{0x8C, 0x88, 0x8C, 0x88}, // DDRAM 0x08
+		[]byte{0x3D, 0x39, 0x5D, 0x59}, // 5
+	)
+}
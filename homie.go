@@ -0,0 +1,439 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HomieVersion is the version of the Homie convention published
+const HomieVersion = "4.0.0"
+
+// The $state of a Homie device
+const (
+	HomieInit         = "init"
+	HomieReady        = "ready"
+	HomieDisconnected = "disconnected"
+	HomieLost         = "lost"
+)
+
+// Homie publishes the station as a device of the Homie convention,
+// for the controllers like openHAB that discover them. Every device
+// of the manager is a node, the properties of a Discoverable one are
+// its Entities: a "sensor" a float, a "switch", "light" or
+// "binary_sensor" a boolean, settable when it has a CommandTopic. A
+// device that is not Discoverable has its data as the string property
+// "value". The values follow the readings and the states of the
+// devices, a value set goes to the CommandTopic as the PayloadOn or
+// PayloadOff of the entity, for its command Router to execute.
+//
+// The attributes go retained. The $state is "init" while they are
+// published, "ready" after and "disconnected" on Stop. The will of the
+// connection is the $state "lost", set it with MQTTWill(h.Will()).
+type Homie struct {
+	Base string // of the topics, "homie"
+	ID   string // of the device, the station's
+	Name string // of the device, the station's
+
+	dm    *DeviceManager
+	m     Messanger
+	nodes map[string]homieNode
+	stop  func()
+	done  chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+}
+
+// homieNode is a device of the manager as a Homie node
+type homieNode struct {
+	name  string
+	typ   string
+	props []homieProperty
+}
+
+// homieProperty is an entity of a device as a Homie property
+type homieProperty struct {
+	id     string
+	entity Entity
+	topic  string // the topic of the device it takes its value from
+}
+
+// ErrHomie is returned by Homie started twice or stopped before it
+// was started
+var ErrHomie = errors.New("homie")
+
+// NewHomie creates the Homie device of the station, the devices of dm
+// its nodes
+func NewHomie(dm *DeviceManager) *Homie {
+	return &Homie{
+		Base: "homie",
+		ID:   homieID(Station()),
+		Name: Station(),
+		dm:   dm,
+	}
+}
+
+// Topic returns the topic of the device, the attribute or the node and
+// property given like "$state" or "relay", "value"
+func (h *Homie) Topic(path ...string) string {
+	return strings.Join(append([]string{h.Base, h.ID}, path...), "/")
+}
+
+// Will returns the will of the connection, the $state "lost"
+func (h *Homie) Will() (string, string) {
+	return h.Topic("$state"), HomieLost
+}
+
+// Start publishes the device on m with its nodes, and takes the values
+// set on the settable properties. The device is "ready" again after
+// the station reconnects.
+func (h *Homie) Start(m Messanger) error {
+	h.mu.Lock()
+	if h.done != nil {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s started", ErrHomie, h.ID)
+	}
+	h.m = m
+	h.done = make(chan struct{})
+	events, stop := SubscribeEvents(streamBuffer)
+	h.stop = stop
+	h.mu.Unlock()
+
+	err := h.publish()
+	if e := m.SubscribeQoS(h.Topic("+", "+", "set"), AtLeastOnce, h.set); e != nil {
+		err = errors.Join(err, e)
+	}
+	if e := m.Subscribe(StationTopic(), h.reconnected); e != nil {
+		err = errors.Join(err, e)
+	}
+
+	h.wg.Add(1)
+	go h.follow(events)
+	return err
+}
+
+// Stop has the device "disconnected" and stops following the devices
+func (h *Homie) Stop() error {
+	h.mu.Lock()
+	if h.done == nil {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s not started", ErrHomie, h.ID)
+	}
+	close(h.done)
+	h.stop()
+	h.mu.Unlock()
+	h.wg.Wait()
+
+	err := h.attr(h.Topic("$state"), HomieDisconnected)
+	h.mu.Lock()
+	h.done = nil
+	h.mu.Unlock()
+	return err
+}
+
+// follow publishes the values of the readings and the states of the
+// devices, and the nodes again when a device is added or removed
+func (h *Homie) follow(events <-chan Event) {
+	defer h.wg.Done()
+	for {
+		select {
+		case <-h.done:
+			return
+		case ev := <-events:
+			switch ev.Type {
+			case EventAdded, EventRemoved:
+				if err := h.publish(); err != nil {
+					slog.Error("homie nodes", "device", ev.Device, "error", err)
+				}
+			case EventReading, EventState:
+				if err := h.value(ev); err != nil {
+					slog.Error("homie value", "device", ev.Device, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// publish publishes the attributes of the device and its nodes, the
+// nodes gone cleared, with the device "init" while they change
+func (h *Homie) publish() error {
+	nodes := h.homieNodes()
+	h.mu.Lock()
+	old := h.nodes
+	h.nodes = nodes
+	h.mu.Unlock()
+
+	state := h.Topic("$state")
+	errs := []error{
+		h.attr(state, HomieInit),
+		h.attr(h.Topic("$homie"), HomieVersion),
+		h.attr(h.Topic("$name"), h.Name),
+	}
+	ids := slices.Sorted(maps.Keys(nodes))
+	for _, id := range ids {
+		errs = append(errs, h.publishNode(id, nodes[id]))
+	}
+	for id, n := range old {
+		if _, ok := nodes[id]; !ok {
+			errs = append(errs, h.clearNode(id, n))
+		}
+	}
+	errs = append(errs,
+		h.attr(h.Topic("$nodes"), strings.Join(ids, ",")),
+		h.attr(state, HomieReady),
+	)
+	return errors.Join(errs...)
+}
+
+func (h *Homie) publishNode(id string, n homieNode) error {
+	ids := make([]string, len(n.props))
+	for i, p := range n.props {
+		ids[i] = p.id
+	}
+	errs := []error{
+		h.attr(h.Topic(id, "$name"), n.name),
+		h.attr(h.Topic(id, "$type"), n.typ),
+		h.attr(h.Topic(id, "$properties"), strings.Join(ids, ",")),
+	}
+	for _, p := range n.props {
+		name := p.entity.Name
+		if name == "" {
+			name = strings.TrimSpace(n.name + " " + p.entity.ID)
+		}
+		errs = append(errs,
+			h.attr(h.Topic(id, p.id, "$name"), name),
+			h.attr(h.Topic(id, p.id, "$datatype"), homieDatatype(p.entity)),
+			h.attr(h.Topic(id, p.id, "$settable"), strconv.FormatBool(p.entity.CommandTopic != "")),
+			h.attr(h.Topic(id, p.id, "$retained"), "true"),
+		)
+		if p.entity.Unit != "" {
+			errs = append(errs, h.attr(h.Topic(id, p.id, "$unit"), p.entity.Unit))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clearNode clears the attributes and the values of a node gone
+func (h *Homie) clearNode(id string, n homieNode) error {
+	errs := []error{
+		h.attr(h.Topic(id, "$name"), ""),
+		h.attr(h.Topic(id, "$type"), ""),
+		h.attr(h.Topic(id, "$properties"), ""),
+	}
+	for _, p := range n.props {
+		for _, attr := range []string{"$name", "$datatype", "$settable", "$retained", "$unit"} {
+			errs = append(errs, h.attr(h.Topic(id, p.id, attr), ""))
+		}
+		errs = append(errs, h.attr(h.Topic(id, p.id), ""))
+	}
+	return errors.Join(errs...)
+}
+
+// homieNodes makes the nodes of the devices of the manager
+func (h *Homie) homieNodes() map[string]homieNode {
+	h.dm.mu.RLock()
+	defer h.dm.mu.RUnlock()
+
+	nodes := make(map[string]homieNode, len(h.dm.devices))
+	for name, d := range h.dm.devices {
+		n := homieNode{name: name, typ: homieType(d)}
+		if dd, ok := d.(Discoverable); ok {
+			for _, e := range dd.Entities() {
+				id := e.ID
+				if id == "" {
+					id = e.Component
+				}
+				n.props = append(n.props, homieProperty{id: homieID(id), entity: e, topic: e.StateTopic})
+			}
+		} else if t, ok := d.(interface{ DataTopic() string }); ok {
+			n.props = append(n.props, homieProperty{id: "value", topic: t.DataTopic()})
+		}
+		nodes[homieID(name)] = n
+	}
+	return nodes
+}
+
+// value publishes the values the event of a device has for the
+// properties of its node
+func (h *Homie) value(ev Event) error {
+	id := homieID(ev.Device)
+	h.mu.Lock()
+	n, ok := h.nodes[id]
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	for _, p := range n.props {
+		if p.topic != ev.Topic {
+			continue
+		}
+		v, ok := homieValue(p.entity, ev.Data)
+		if !ok {
+			continue
+		}
+		errs = append(errs, h.attr(h.Topic(id, p.id), v))
+	}
+	return errors.Join(errs...)
+}
+
+// set takes a value set on a settable property to the CommandTopic of
+// its entity
+func (h *Homie) set(msg *Msg) {
+	path := strings.Split(strings.TrimPrefix(msg.Topic, h.Topic()+"/"), "/")
+	if len(path) != 3 {
+		return
+	}
+	h.mu.Lock()
+	n, ok := h.nodes[path[0]]
+	m := h.m
+	h.mu.Unlock()
+	if !ok || !h.started() {
+		return
+	}
+
+	for _, p := range n.props {
+		if p.id != path[1] || p.entity.CommandTopic == "" {
+			continue
+		}
+		payload := string(msg.Data)
+		if homieDatatype(p.entity) == "boolean" {
+			on, err := strconv.ParseBool(payload)
+			if err != nil {
+				slog.Warn("homie set not a boolean", "topic", msg.Topic, "value", payload)
+				return
+			}
+			payload = p.entity.PayloadOff
+			if on {
+				payload = p.entity.PayloadOn
+			}
+		}
+		if err := m.PublishQoS(p.entity.CommandTopic, []byte(payload), AtLeastOnce, false); err != nil {
+			slog.Error("homie set", "topic", msg.Topic, "error", err)
+		}
+		return
+	}
+}
+
+// reconnected has the device "ready" again when the station is
+// "online" after a reconnect, the will had it "lost"
+func (h *Homie) reconnected(msg *Msg) {
+	if msg.String() != Online || !h.started() {
+		return
+	}
+	if err := h.attr(h.Topic("$state"), HomieReady); err != nil {
+		slog.Error("homie ready", "error", err)
+	}
+}
+
+// started reports if the device is started, a Messanger keeps the
+// subscriptions of one stopped
+func (h *Homie) started() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.done != nil
+}
+
+// attr publishes an attribute retained, empty clears it
+func (h *Homie) attr(topic, value string) error {
+	h.mu.Lock()
+	m := h.m
+	h.mu.Unlock()
+	return m.PublishQoS(topic, []byte(value), AtLeastOnce, true)
+}
+
+// homieDatatype is the datatype of the property of an entity
+func homieDatatype(e Entity) string {
+	switch e.Component {
+	case "sensor":
+		return "float"
+	case "switch", "light", "binary_sensor":
+		return "boolean"
+	}
+	return "string"
+}
+
+// homieValue returns the value of the property of entity e in the
+// data of a device, formatted for its datatype
+func homieValue(e Entity, data json.RawMessage) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	var v any
+	if json.Unmarshal(data, &v) != nil {
+		return "", false
+	}
+	if e.Value != "" {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if v, ok = obj[e.Value]; !ok {
+			return "", false
+		}
+	}
+
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	case nil:
+		return "", false
+	default:
+		b, _ := json.Marshal(v)
+		s = string(b)
+	}
+	switch homieDatatype(e) {
+	case "boolean":
+		switch {
+		case s == e.PayloadOn && s != "", s == "true", s == "on", s == "1":
+			return "true", true
+		default:
+			return "false", true
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// homieType is the $type of the node of d, the name of its package
+// like "relay"
+func homieType(d Name) string {
+	t := strings.TrimLeft(fmt.Sprintf("%T", d), "*")
+	if i := strings.IndexByte(t, '.'); i >= 0 && t[:i] != "device" {
+		return t[:i]
+	}
+	return strings.ToLower(t[strings.IndexByte(t, '.')+1:])
+}
+
+// homieID makes s an ID of the Homie convention, of lowercase
+// letters, digits and hyphens not starting with one
+func homieID(s string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, s)
+	if id = strings.TrimLeft(id, "-"); id == "" {
+		return "device"
+	}
+	return id
+}
@@ -0,0 +1,122 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+// retained returns what m retains on topic, empty when nothing
+func retained(m *MemMessanger, topic string) string {
+	if msg, ok := m.Retained(topic); ok {
+		return msg.String()
+	}
+	return ""
+}
+
+func TestHomie(t *testing.T) {
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	m := NewMemMessanger()
+
+	pump := NewDevice("Pump 1", "mqtt", WithMessanger(m))
+	rt := NewRouter(pump)
+	rt.Handle("1", func(*Command) error { return pump.PubState(1) })
+	rt.Handle("0", func(*Command) error { return pump.PubState(0) })
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error (%v)", err)
+	}
+	soil := NewDevice("soil", "mqtt", WithMessanger(m))
+	dm.Add(discoverable{named{pump}, []Entity{{Component: "switch", StateTopic: pump.StateTopic(), CommandTopic: pump.ControlTopic(), PayloadOn: "1", PayloadOff: "0"}}})
+	dm.Add(discoverable{named{soil}, []Entity{{Component: "sensor", ID: "moisture", Unit: "%", StateTopic: soil.DataTopic(), Value: "moisture"}}})
+	dm.Add(named{NewDevice("cam", "mqtt", WithMessanger(m))})
+
+	h := NewHomie(dm)
+	if err := h.Start(m); err != nil {
+		t.Fatalf("Start() error (%v)", err)
+	}
+	if err := h.Start(m); !errors.Is(err, ErrHomie) {
+		t.Errorf("Start() again error got (%v) want (%v)", err, ErrHomie)
+	}
+
+	tree := map[string]string{
+		"$homie":                  "4.0.0",
+		"$name":                   "station",
+		"$state":                  "ready",
+		"$nodes":                  "cam,pump-1,soil",
+		"pump-1/$name":            "Pump 1",
+		"pump-1/$type":            "discoverable",
+		"pump-1/$properties":      "switch",
+		"pump-1/switch/$name":     "Pump 1",
+		"pump-1/switch/$datatype": "boolean",
+		"pump-1/switch/$settable": "true",
+		"pump-1/switch/$retained": "true",
+		"soil/$properties":        "moisture",
+		"soil/moisture/$name":     "soil moisture",
+		"soil/moisture/$datatype": "float",
+		"soil/moisture/$settable": "false",
+		"soil/moisture/$unit":     "%",
+		"cam/$type":               "named",
+		"cam/$properties":         "value",
+		"cam/value/$datatype":     "string",
+	}
+	for path, want := range tree {
+		if got := retained(m, "homie/station/"+path); got != want {
+			t.Errorf("%s got (%s) want (%s)", path, got, want)
+		}
+	}
+
+	// the values follow the devices
+	soil.PubData(map[string]any{"moisture": 41.5})
+	waitFor(t, func() bool { return retained(m, "homie/station/soil/moisture") == "41.5" })
+
+	// a value set goes to the command router, the state comes back
+	m.Publish("homie/station/pump-1/switch/set", []byte("true"))
+	waitFor(t, func() bool { return retained(m, "homie/station/pump-1/switch") == "true" })
+	m.Publish("homie/station/pump-1/switch/set", []byte("false"))
+	waitFor(t, func() bool { return retained(m, "homie/station/pump-1/switch") == "false" })
+	cmds := m.Messages(pump.ControlTopic())
+	if len(cmds) != 2 || cmds[0].String() != "1" || cmds[1].String() != "0" {
+		t.Errorf("commands got (%v) want (1, 0)", cmds)
+	}
+	m.Publish("homie/station/pump-1/switch/set", []byte("maybe"))
+	m.Publish("homie/station/soil/moisture/set", []byte("10"))
+	if cmds := m.Messages("ss/c/#"); len(cmds) != 2 {
+		t.Errorf("commands got (%v) want no more for a bad value or a property not settable", cmds)
+	}
+
+	// the nodes follow the manager
+	dm.Remove("cam")
+	waitFor(t, func() bool { return retained(m, "homie/station/$nodes") == "pump-1,soil" })
+	if got := retained(m, "homie/station/cam/$type"); got != "" {
+		t.Errorf("cam/$type got (%s) want it cleared", got)
+	}
+
+	// the will, and ready again on reconnect
+	m.SetWill(h.Will())
+	m.Disconnect()
+	if got := retained(m, "homie/station/$state"); got != HomieLost {
+		t.Errorf("$state after the connection lost got (%s) want (%s)", got, HomieLost)
+	}
+	m.Connect()
+	if got := retained(m, "homie/station/$state"); got != HomieReady {
+		t.Errorf("$state after reconnect got (%s) want (%s)", got, HomieReady)
+	}
+
+	if err := h.Stop(); err != nil {
+		t.Fatalf("Stop() error (%v)", err)
+	}
+	if got := retained(m, "homie/station/$state"); got != HomieDisconnected {
+		t.Errorf("$state after Stop got (%s) want (%s)", got, HomieDisconnected)
+	}
+	if err := h.Stop(); !errors.Is(err, ErrHomie) {
+		t.Errorf("Stop() again error got (%v) want (%v)", err, ErrHomie)
+	}
+}
+
+func TestHomieID(t *testing.T) {
+	for in, want := range map[string]string{"station": "station", "Pump 1": "pump-1", "-x_y": "x-y", "": "device", "$$": "device"} {
+		if got := homieID(in); got != want {
+			t.Errorf("homieID(%q) got (%s) want (%s)", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package device
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBuffer is the number of events a stream client can be
+	// behind before its events are dropped
+	streamBuffer = 64
+
+	// streamWriteTimeout bounds the write of an event to a stream
+	// client, one that does not take it is disconnected
+	streamWriteTimeout = 5 * time.Second
+)
+
+// EventSubscribed answers the StreamFilter of a stream client, the
+// events after it are filtered
+const EventSubscribed EventType = "subscribed"
+
+// StreamFilter is the message a /devices/stream client sends to pick
+// the events it gets, by the names of the devices and the types of
+// the events. Empty takes them all, as before the first one is sent.
+type StreamFilter struct {
+	Devices []string    `json:"devices"`
+	Types   []EventType `json:"types"`
+}
+
+// Match reports if ev passes the filter
+func (f *StreamFilter) Match(ev Event) bool {
+	if f == nil {
+		return true
+	}
+	return (len(f.Devices) == 0 || slices.Contains(f.Devices, ev.Device)) &&
+		(len(f.Types) == 0 || slices.Contains(f.Types, ev.Type))
+}
+
+var upgrader = websocket.Upgrader{}
+
+// Handler returns the HTTP surface of the manager:
+//
+//	/devices                the Status as JSON
+//	/devices/stream         a websocket of the Events as JSON
+//	/devices/{name}/schema  the Schemas of the messages of a Describer
+func (dm *DeviceManager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices", dm.serveStatus)
+	mux.HandleFunc("GET /devices/stream", dm.serveStream)
+	mux.HandleFunc("GET /devices/{name}/schema", dm.serveSchema)
+	return mux
+}
+
+func (dm *DeviceManager) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dm.Status()); err != nil {
+		slog.Error("devices status", "error", err)
+	}
+}
+
+func (dm *DeviceManager) serveSchema(w http.ResponseWriter, r *http.Request) {
+	d, ok := dm.Get(r.PathValue("name"))
+	dd, described := d.(describer)
+	if !ok || !described {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dd.MessageSchemas(dd.Schemas())); err != nil {
+		slog.Error("device schema", "device", d.Name(), "error", err)
+	}
+}
+
+// serveStream pushes the events to a websocket client until it goes
+// away or is too slow to take them
+func (dm *DeviceManager) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader answered the client
+		return
+	}
+	defer conn.Close()
+
+	events, stop := SubscribeEvents(streamBuffer)
+	defer stop()
+
+	var filter atomic.Pointer[StreamFilter]
+	filters := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			f := &StreamFilter{}
+			if err := conn.ReadJSON(f); err != nil {
+				return
+			}
+			filter.Store(f)
+			select {
+			case filters <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	write := func(ev Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := conn.WriteJSON(ev); err != nil {
+			slog.Debug("devices stream closed", "remote", r.RemoteAddr, "error", err)
+			return false
+		}
+		return true
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-filters:
+			if !write(Event{Type: EventSubscribed, Time: time.Now()}) {
+				return
+			}
+		case ev := <-events:
+			if filter.Load().Match(ev) && !write(ev) {
+				return
+			}
+		}
+	}
+}
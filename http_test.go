@@ -0,0 +1,121 @@
+package device
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// stream dials the /devices/stream of srv, sending filter when it is
+// not nil
+func stream(t *testing.T, srv *httptest.Server, filter *StreamFilter) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/devices/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if filter != nil {
+		if err := conn.WriteJSON(filter); err != nil {
+			t.Fatalf("WriteJSON(filter) error = %v", err)
+		}
+		if ev := next(t, conn); ev.Type != EventSubscribed {
+			t.Fatalf("filter answered with (%+v)", ev)
+		}
+	}
+	return conn
+}
+
+func next(t *testing.T, conn *websocket.Conn) Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ev Event
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+	return ev
+}
+
+func TestStream(t *testing.T) {
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	srv := httptest.NewServer(dm.Handler())
+	defer srv.Close()
+
+	all := stream(t, srv, &StreamFilter{})
+	relays := stream(t, srv, &StreamFilter{Devices: []string{"relay"}})
+	states := stream(t, srv, &StreamFilter{Types: []EventType{EventState, EventRemoved}})
+
+	m := NewMemMessanger()
+	relay := NewDevice("relay", "mqtt", WithMessanger(m))
+	lux := NewDevice("lux", "mqtt", WithMessanger(m))
+	dm.Add(named{lux})
+	lux.PubData(120.5)
+	relay.PubState("on")
+	dm.Remove("lux")
+
+	want := []string{"added lux", "reading lux 120.50", `state relay "on"`, "removed lux"}
+	for _, w := range want {
+		ev := next(t, all)
+		got := strings.TrimSpace(string(ev.Type) + " " + ev.Device + " " + string(ev.Data))
+		if got != w {
+			t.Errorf("all got (%s) want (%s)", got, w)
+		}
+	}
+	if ev := next(t, relays); ev.Type != EventState || ev.Device != "relay" || ev.Topic != relay.StateTopic() {
+		t.Errorf("relay got (%+v)", ev)
+	}
+	if ev := next(t, states); ev.Type != EventState {
+		t.Errorf("states got (%+v) want the relay state", ev)
+	}
+	if ev := next(t, states); ev.Type != EventRemoved || ev.Device != "lux" {
+		t.Errorf("states got (%+v) want lux removed", ev)
+	}
+
+	resp, err := http.Get(srv.URL + "/devices")
+	if err != nil {
+		t.Fatalf("GET /devices error = %v", err)
+	}
+	defer resp.Body.Close()
+	var status map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status["devices"] == nil {
+		t.Errorf("GET /devices got (%v, %v)", status, err)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	events, stop := SubscribeEvents(1)
+	d := NewDevice("fast", "none")
+
+	// the listener is behind, the device does not wait
+	done := make(chan struct{})
+	go func() {
+		for i := range 10 {
+			d.PubData(i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("PubData() waited on a slow listener")
+	}
+	if ev := <-events; string(ev.Data) != "0" {
+		t.Errorf("kept got (%s) want the first (0)", ev.Data)
+	}
+
+	stop()
+	stop()
+	d.PubData("after")
+	select {
+	case ev := <-events:
+		t.Errorf("stopped got (%+v)", ev)
+	default:
+	}
+}
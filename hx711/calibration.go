@@ -0,0 +1,104 @@
+package hx711
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// Calibration converts counts, read at gain 128 or scaled to it, to
+// grams. Offset is the counts of the empty scale and Scale the counts
+// per gram, negative for a load cell wired the other way around.
+type Calibration struct {
+	Offset float64 `json:"offset"`
+	Scale  float64 `json:"scale"`
+}
+
+// DefaultCalibration publishes the counts as grams, tare and
+// calibrate the scale before trusting the weight
+var DefaultCalibration = Calibration{Offset: 0, Scale: 1}
+
+// NewCalibration returns the calibration through two points, counts1
+// read with grams1 on the scale and counts2 with grams2
+func NewCalibration(counts1, grams1, counts2, grams2 float64) (Calibration, error) {
+	if grams1 == grams2 || counts1 == counts2 {
+		return Calibration{}, fmt.Errorf("%w: (%v, %vg) and (%v, %vg)", drivers.ErrCalibration, counts1, grams1, counts2, grams2)
+	}
+	scale := (counts2 - counts1) / (grams2 - grams1)
+	c := Calibration{Offset: counts1 - grams1*scale, Scale: scale}
+	return c, c.Valid()
+}
+
+// Valid returns drivers.ErrCalibration if no weight can be computed
+// with the calibration
+func (c Calibration) Valid() error {
+	if c.Scale == 0 || math.IsNaN(c.Scale) || math.IsInf(c.Scale, 0) || math.IsNaN(c.Offset) || math.IsInf(c.Offset, 0) {
+		return fmt.Errorf("%w: offset %v scale %v", drivers.ErrCalibration, c.Offset, c.Scale)
+	}
+	return nil
+}
+
+// Grams returns the weight of counts, it goes negative below the
+// tare
+func (c Calibration) Grams(counts float64) float64 {
+	return (counts - c.Offset) / c.Scale
+}
+
+// Tare returns the calibration with counts as the empty scale, the
+// scale is kept
+func (c Calibration) Tare(counts float64) Calibration {
+	c.Offset = counts
+	return c
+}
+
+// average returns the mean of the samples left after dropping those
+// more than k median absolute deviations from the median, and how
+// many were dropped. k of 0 keeps them all.
+func average(samples []int32, k float64) (mean float64, dropped int) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	med := median(samples)
+	limit := math.Inf(1)
+	if k > 0 {
+		dev := make([]float64, len(samples))
+		for i, s := range samples {
+			dev[i] = math.Abs(float64(s) - med)
+		}
+		// 1.4826 makes the MAD the standard deviation of normal noise
+		limit = k * 1.4826 * medianFloat(dev)
+	}
+
+	sum, n := 0.0, 0
+	for _, s := range samples {
+		if math.Abs(float64(s)-med) > limit {
+			continue
+		}
+		sum += float64(s)
+		n++
+	}
+	if n == 0 {
+		return med, len(samples)
+	}
+	return sum / float64(n), len(samples) - n
+}
+
+func median(samples []int32) float64 {
+	f := make([]float64, len(samples))
+	for i, s := range samples {
+		f[i] = float64(s)
+	}
+	return medianFloat(f)
+}
+
+// medianFloat sorts f and returns its median
+func medianFloat(f []float64) float64 {
+	sort.Float64s(f)
+	n := len(f)
+	if n%2 == 1 {
+		return f[n/2]
+	}
+	return (f[n/2-1] + f[n/2]) / 2
+}
@@ -0,0 +1,441 @@
+// Package hx711 provides a driver for the HX711 load cell amplifier,
+// bit-banged over two GPIO lines, DOUT and SCK.
+//
+// The HX711 signals a conversion is ready by pulling DOUT low, the
+// 24 bit two's complement value is then clocked out MSB first with 24
+// pulses on SCK. One to three more pulses select the channel and the
+// gain of the next conversion, 25 for channel A at 128, 26 for
+// channel B at 32 and 27 for channel A at 64. SCK held high for more
+// than 60µs powers the chip down, it comes back up at gain 128.
+//
+// A missed pulse, or a read preempted long enough to power the chip
+// down, leaves the driver and the chip out of step. The read is
+// checked and retried after power cycling the chip, and the first
+// conversion after a gain change, made at the old gain, is dropped.
+//
+// The weight is the average of Samples conversions with the outliers
+// rejected, converted to grams with a Calibration taken with the
+// "tare" and "calibrate <grams>" commands and saved in the device
+// store.
+package hx711
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// Gain is the channel and gain of the conversions
+type Gain int
+
+const (
+	Gain128 Gain = 128 // channel A
+	Gain64  Gain = 64  // channel A
+	Gain32  Gain = 32  // channel B
+)
+
+// pulses returns the clock pulses of a read that select g for the
+// next conversion
+func (g Gain) pulses() int {
+	switch g {
+	case Gain128:
+		return 25
+	case Gain32:
+		return 26
+	case Gain64:
+		return 27
+	}
+	return 0
+}
+
+const (
+	// DefaultSamples are averaged for a reading, the HX711 converts
+	// at 10 or 80 per second
+	DefaultSamples = 5
+
+	// DefaultReject drops samples more than that many deviations
+	// from the median
+	DefaultReject = 3.0
+
+	// DefaultRetries of a read that failed
+	DefaultRetries = 3
+
+	// DefaultReadyTimeout is how long a conversion is waited for, a
+	// conversion takes 100ms at 10 per second
+	DefaultReadyTimeout = 500 * time.Millisecond
+)
+
+var (
+	// pulse is the width of the clock pulses, the HX711 needs
+	// 0.2µs and the data is valid 0.1µs after the rising edge
+	pulse = time.Microsecond
+
+	// maxHigh is how long SCK may stay high before the chip could
+	// have powered down
+	maxHigh = 50 * time.Microsecond
+
+	// powerDown is how long SCK is held high to power the chip down
+	powerDown = 100 * time.Microsecond
+)
+
+var (
+	ErrNotReady = errors.New("hx711 conversion not ready")
+	ErrSync     = errors.New("hx711 out of sync")
+	ErrGain     = errors.New("invalid hx711 gain")
+	ErrCommand  = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes, the weight and the averaged raw
+// counts it was computed from at the gain
+type Reading struct {
+	Grams float64 `json:"grams"`
+	Raw   int32   `json:"raw"`
+	Gain  Gain    `json:"gain"`
+}
+
+// HX711 is a load cell amplifier on two GPIO lines
+type HX711 struct {
+	*device.Device
+
+	// Samples is the number of conversions averaged per reading
+	Samples int
+
+	// Reject drops samples more than Reject median absolute
+	// deviations, scaled to standard deviations, from the median.
+	// 0 keeps every sample.
+	Reject float64
+
+	// Retries of a read that was not ready or out of sync
+	Retries int
+
+	// ReadyTimeout is how long DOUT is waited on to go low
+	ReadyTimeout time.Duration
+
+	dout     *drivers.DigitalPin
+	sck      *drivers.DigitalPin
+	gain     Gain
+	selected Gain // the gain of the conversion in progress
+	cal      Calibration
+	mu       sync.Mutex
+}
+
+// New creates an HX711 with DOUT and SCK on the pins dout and sck,
+// any pin identifier drivers.ResolvePinID understands may be used.
+// The calibration saved in the device store is loaded,
+// DefaultCalibration is used if there is none.
+func New(name, dout, sck string) (*HX711, error) {
+	h := &HX711{
+		Device:       device.NewDevice(name, "mqtt"),
+		Samples:      DefaultSamples,
+		Reject:       DefaultReject,
+		Retries:      DefaultRetries,
+		ReadyTimeout: DefaultReadyTimeout,
+		gain:         Gain128,
+		selected:     Gain128,
+		cal:          DefaultCalibration,
+	}
+
+	var err error
+	h.dout, err = drivers.NewDigitalPinID(name+"-dout", dout, gpiocdev.AsInput)
+	if err != nil {
+		return nil, fmt.Errorf("hx711 %s dout: %w", name, err)
+	}
+	h.sck, err = drivers.NewDigitalPinID(name+"-sck", sck, gpiocdev.AsOutput(0))
+	if err != nil {
+		h.dout.Close()
+		return nil, fmt.Errorf("hx711 %s sck: %w", name, err)
+	}
+
+	var cal Calibration
+	err = device.GetStore().Load(h.storeKey(), &cal)
+	switch {
+	case err == nil && cal.Valid() == nil:
+		h.cal = cal
+	case err == nil:
+		slog.Warn("hx711 ignoring saved calibration", "device", name, "error", cal.Valid())
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("hx711 loading calibration", "device", name, "error", err)
+	}
+	return h, nil
+}
+
+func (h *HX711) storeKey() string {
+	return h.Device.Name + "/calibration"
+}
+
+// Name returns the name of the device
+func (h *HX711) Name() string {
+	return h.Device.Name
+}
+
+// Gain returns the gain of the readings
+func (h *HX711) Gain() Gain {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.gain
+}
+
+// SetGain sets the gain of the readings, it takes effect with the
+// next read
+func (h *HX711) SetGain(g Gain) error {
+	if g.pulses() == 0 {
+		return fmt.Errorf("%w: %d", ErrGain, g)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gain = g
+	return nil
+}
+
+// Calibration returns the calibration in use
+func (h *HX711) Calibration() Calibration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cal
+}
+
+// SetCalibration sets and saves the calibration
+func (h *HX711) SetCalibration(c Calibration) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cal = c
+	return device.GetStore().Save(h.storeKey(), &c)
+}
+
+// Tare takes the current weight as zero, the scale empty or holding
+// the empty hive
+func (h *HX711) Tare() error {
+	counts, _, err := h.counts()
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.Device.Name, err)
+	}
+	return h.SetCalibration(h.Calibration().Tare(counts))
+}
+
+// Calibrate takes the current reading as grams, a known weight put
+// on the scale after Tare
+func (h *HX711) Calibrate(grams float64) error {
+	counts, _, err := h.counts()
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.Device.Name, err)
+	}
+	c, err := NewCalibration(h.Calibration().Offset, 0, counts, grams)
+	if err != nil {
+		return err
+	}
+	return h.SetCalibration(c)
+}
+
+// Command handles a command payload: "tare", "calibrate <grams>" or
+// "gain <128|64|32>"
+func (h *HX711) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(f) == 1 && f[0] == "tare":
+		return h.Tare()
+	case len(f) == 2 && f[0] == "calibrate":
+		grams, err := strconv.ParseFloat(f[1], 64)
+		if err != nil || grams == 0 {
+			break
+		}
+		return h.Calibrate(grams)
+	case len(f) == 2 && f[0] == "gain":
+		g, err := strconv.Atoi(f[1])
+		if err != nil {
+			break
+		}
+		return h.SetGain(Gain(g))
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the weight of the average of Samples conversions
+func (h *HX711) Read() (*Reading, error) {
+	counts, gain, err := h.counts()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.Device.Name, err)
+	}
+	return &Reading{
+		Grams: h.Calibration().Grams(counts),
+		Raw:   int32(math.Round(counts * float64(gain) / float64(Gain128))),
+		Gain:  gain,
+	}, nil
+}
+
+// counts averages Samples conversions and returns them scaled to
+// gain 128, along with the gain they were read at. If the device is
+// mocked it makes up the counts.
+func (h *HX711) counts() (float64, Gain, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if device.IsMock() {
+		return 200000 + h.MockRand().Float64()*5000, h.gain, nil
+	}
+	if h.sck == nil {
+		return 0, 0, errors.New("not initialized")
+	}
+
+	n := max(h.Samples, 1)
+	samples := make([]int32, n)
+	for i := range samples {
+		v, err := h.sample()
+		if err != nil {
+			return 0, 0, err
+		}
+		samples[i] = v
+	}
+	mean, dropped := average(samples, h.Reject)
+	if dropped > 0 {
+		slog.Debug("hx711 rejected samples", "device", h.Device.Name, "dropped", dropped, "samples", n)
+	}
+	return mean * float64(Gain128) / float64(h.gain), h.gain, nil
+}
+
+// sample reads a conversion at the gain, retrying after power
+// cycling the chip when it was not ready or out of sync. The
+// conversion after a gain change is made at the old gain and read
+// again. The caller must hold mu.
+func (h *HX711) sample() (int32, error) {
+	for tries := 0; ; {
+		v, err := h.read(h.gain.pulses())
+		if err == nil {
+			converted := h.selected
+			h.selected = h.gain
+			if converted == h.gain {
+				return v, nil
+			}
+			continue
+		}
+
+		if tries++; tries > h.Retries {
+			return 0, err
+		}
+		slog.Debug("hx711 retrying read", "device", h.Device.Name, "error", err)
+		if err := h.reset(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// read waits for a conversion and clocks it out with pulses pulses.
+// DOUT goes high with the 25th pulse, it still being low means the
+// chip missed a pulse.
+func (h *HX711) read(pulses int) (int32, error) {
+	deadline := time.Now().Add(h.ReadyTimeout)
+	for {
+		v, err := h.dout.Get()
+		if err != nil {
+			return 0, err
+		}
+		if v == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("%w after %v", ErrNotReady, h.ReadyTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var raw uint32
+	for i := 0; i < pulses; i++ {
+		start := time.Now()
+		if err := h.sck.Set(1); err != nil {
+			return 0, err
+		}
+		wait(pulse)
+		if i < 24 {
+			bit, err := h.dout.Get()
+			if err != nil {
+				return 0, err
+			}
+			raw = raw<<1 | uint32(bit)
+		}
+		if err := h.sck.Set(0); err != nil {
+			return 0, err
+		}
+		if high := time.Since(start); high > maxHigh {
+			return 0, fmt.Errorf("%w: clock high for %v", ErrSync, high)
+		}
+		wait(pulse)
+	}
+
+	v, err := h.dout.Get()
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("%w: data still low after %d pulses", ErrSync, pulses)
+	}
+	// sign extend the 24 bits
+	return int32(raw<<8) >> 8, nil
+}
+
+// reset power cycles the chip, it comes back up converting at gain
+// 128. The caller must hold mu.
+func (h *HX711) reset() error {
+	if err := h.sck.Set(1); err != nil {
+		return err
+	}
+	time.Sleep(powerDown)
+	if err := h.sck.Set(0); err != nil {
+		return err
+	}
+	h.selected = Gain128
+	return nil
+}
+
+// wait spins for d, the scheduler can not sleep for the microseconds
+// a pulse takes
+func wait(d time.Duration) {
+	for end := time.Now().Add(d); time.Now().Before(end); {
+	}
+}
+
+// ReadPub reads the scale and publishes the reading
+func (h *HX711) ReadPub() error {
+	r, err := h.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	h.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (h *HX711) Run(ctx context.Context, period time.Duration) error {
+	err := h.TimerLoop(ctx, period, h.ReadPub)
+	slog.Debug("hx711 stopped", "device", h.Device.Name, "error", err)
+	return err
+}
+
+// Close powers the chip down, SCK is left high, and releases the
+// pins
+func (h *HX711) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sck == nil {
+		return nil
+	}
+	h.sck.Set(1)
+	err := errors.Join(h.sck.Close(), h.dout.Close())
+	h.sck, h.dout = nil, nil
+	return err
+}
@@ -0,0 +1,393 @@
+package hx711
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestCalibration(t *testing.T) {
+	c, err := NewCalibration(8000, 0, 48000, 1000)
+	if err != nil || c != (Calibration{Offset: 8000, Scale: 40}) {
+		t.Fatalf("NewCalibration() got (%+v, %v) want (8000, 40)", c, err)
+	}
+	// two points neither of them the tare
+	if c2, err := NewCalibration(28000, 500, 88000, 2000); err != nil || c2 != c {
+		t.Errorf("NewCalibration(500g, 2000g) got (%+v, %v) want (%+v)", c2, err, c)
+	}
+
+	weights := []struct {
+		counts, grams float64
+	}{
+		{8000, 0},
+		{48000, 1000},
+		{4000, -100},
+		{-32000, -1000},
+	}
+	for _, tt := range weights {
+		if got := c.Grams(tt.counts); got != tt.grams {
+			t.Errorf("Grams(%g) got (%g) want (%g)", tt.counts, got, tt.grams)
+		}
+	}
+
+	// a tare moves the zero and keeps the scale
+	tared := c.Tare(10000)
+	if tared.Scale != 40 || tared.Grams(10000) != 0 || tared.Grams(6000) != -100 {
+		t.Errorf("Tare(10000) got (%+v)", tared)
+	}
+
+	// a cell wired the other way around
+	rev, err := NewCalibration(-1000, 0, -21000, 1000)
+	if err != nil || rev.Scale != -20 || rev.Grams(-11000) != 500 {
+		t.Errorf("NewCalibration(reversed) got (%+v, %v)", rev, err)
+	}
+
+	for _, pts := range [][4]float64{{100, 0, 100, 500}, {100, 500, 200, 500}} {
+		if _, err := NewCalibration(pts[0], pts[1], pts[2], pts[3]); !errors.Is(err, drivers.ErrCalibration) {
+			t.Errorf("NewCalibration(%v) error got (%v) want (%v)", pts, err, drivers.ErrCalibration)
+		}
+	}
+	for _, bad := range []Calibration{{Scale: 0}, {Scale: math.NaN()}, {Offset: math.Inf(1), Scale: 1}} {
+		if err := bad.Valid(); !errors.Is(err, drivers.ErrCalibration) {
+			t.Errorf("Valid(%+v) error got (%v) want (%v)", bad, err, drivers.ErrCalibration)
+		}
+	}
+}
+
+func TestAverage(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int32
+		k       float64
+		mean    float64
+		dropped int
+	}{
+		{"steady", []int32{100, 100, 100}, 3, 100, 0},
+		{"noise", []int32{98, 100, 102, 101, 99}, 3, 100, 0},
+		{"spike", []int32{98, 100, 102, 101, 99, 5000}, 3, 100, 1},
+		{"negative spike", []int32{-98, -100, -102, -101, -99, -90000, 20000}, 3, -100, 2},
+		{"keep all", []int32{98, 100, 102, 101, 99, 5000}, 0, 5500.0 / 6, 0},
+		{"single", []int32{-7}, 3, -7, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, dropped := average(tt.samples, tt.k)
+			if math.Abs(mean-tt.mean) > 1e-9 || dropped != tt.dropped {
+				t.Errorf("average() got (%v, %d) want (%v, %d)", mean, dropped, tt.mean, tt.dropped)
+			}
+		})
+	}
+}
+
+const (
+	simDOUT = 5
+	simSCK  = 6
+)
+
+// simHX711 is a gpiochip with DOUT and SCK wired to a simulated
+// HX711. SCK is a driverstest.Line, the clock the driver sent is in
+// its Values.
+type simHX711 struct {
+	*driverstest.Chip
+
+	conv  []int32 // the conversions to come
+	gains []Gain  // the gain each conversion read out was made at
+	miss  int     // rising edges of SCK to miss
+
+	gain   Gain
+	data   uint32
+	clk    int // rising edges of the current read
+	high   time.Time
+	resets int
+	mu     sync.Mutex
+}
+
+func newSimHX711(conv ...int32) *simHX711 {
+	return &simHX711{Chip: driverstest.NewChip(), conv: conv, gain: Gain128}
+}
+
+func (s *simHX711) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (drivers.Line, error) {
+	switch offset {
+	case simDOUT:
+		return &simDout{sim: s}, nil
+	case simSCK:
+		l, err := s.Chip.RequestLine(offset, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &simClock{Line: l.(*driverstest.Line), sim: s}, nil
+	}
+	return nil, fmt.Errorf("sim hx711 has no line %d", offset)
+}
+
+func (s *simHX711) queue(conv ...int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conv = append(s.conv, conv...)
+}
+
+// clock is SCK driven to v
+func (s *simHX711) clock(v int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v == 0 {
+		// held high long enough to power down
+		if time.Since(s.high) > 5*time.Millisecond {
+			s.clk, s.gain = 0, Gain128
+			s.resets++
+		}
+		return
+	}
+	s.high = time.Now()
+	if s.miss > 0 {
+		s.miss--
+		return
+	}
+	if s.clk == 0 && len(s.conv) > 0 {
+		s.data = uint32(s.conv[0]) & 0xFFFFFF
+		s.conv = s.conv[1:]
+		s.gains = append(s.gains, s.gain)
+	}
+	s.clk++
+}
+
+// dout is the level of DOUT, low with a conversion ready and then
+// the bits clocked out. The read ends with DOUT read high after the
+// 25th pulse, the pulses select the gain of the next conversion.
+func (s *simHX711) dout() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.clk == 0 && len(s.conv) > 0:
+		return 0
+	case s.clk == 0:
+		return 1
+	case s.clk <= 24:
+		return int(s.data>>(24-s.clk)) & 1
+	}
+	s.gain = map[int]Gain{25: Gain128, 26: Gain32, 27: Gain64}[s.clk]
+	s.clk = 0
+	return 1
+}
+
+type simClock struct {
+	*driverstest.Line
+	sim *simHX711
+}
+
+func (c *simClock) SetValue(v int) error {
+	if err := c.Line.SetValue(v); err != nil {
+		return err
+	}
+	c.sim.clock(v)
+	return nil
+}
+
+type simDout struct {
+	sim *simHX711
+}
+
+func (d *simDout) Close() error                                   { return nil }
+func (d *simDout) Offset() int                                    { return simDOUT }
+func (d *simDout) SetValue(int) error                             { return errors.New("dout is an input") }
+func (d *simDout) Reconfigure(...gpiocdev.LineConfigOption) error { return nil }
+func (d *simDout) Value() (int, error)                            { return d.sim.dout(), nil }
+
+// newScale returns an HX711 reading sim, the clock timing is relaxed
+// so a slow test run is not taken for a power down
+func newScale(t *testing.T, sim *simHX711) *HX711 {
+	t.Helper()
+	device.Mock(false)
+	driverstest.UseGPIO(t, sim)
+	oldHigh, oldDown := maxHigh, powerDown
+	maxHigh, powerDown = time.Second, 10*time.Millisecond
+	t.Cleanup(func() { maxHigh, powerDown = oldHigh, oldDown })
+
+	h, err := New("hive", fmt.Sprint(simDOUT), fmt.Sprint(simSCK))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	h.Samples = 1
+	return h
+}
+
+// pulses returns the clock of n pulses
+func pulses(n int) []int {
+	var v []int
+	for i := 0; i < n; i++ {
+		v = append(v, 1, 0)
+	}
+	return v
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRead(t *testing.T) {
+	devicetest.UseStore(t)
+	sim := newSimHX711(0x123456, -1000)
+	h := newScale(t, sim)
+	sck := sim.Line(simSCK)
+
+	r, err := h.Read()
+	if err != nil || r.Raw != 0x123456 || r.Grams != 0x123456 || r.Gain != Gain128 {
+		t.Errorf("Read() got (%+v, %v) want (%d)", r, err, 0x123456)
+	}
+	if !equal(sck.Values, pulses(25)) {
+		t.Errorf("clock got (%v) want 25 pulses", sck.Values)
+	}
+
+	// negative counts are sign extended
+	if r, err := h.Read(); err != nil || r.Raw != -1000 {
+		t.Errorf("Read(-1000) got (%+v, %v)", r, err)
+	}
+
+	// the conversion after the gain change is still at 128 and dropped
+	sck.Values = nil
+	if err := h.Command([]byte("gain 64")); err != nil {
+		t.Fatalf("Command(gain 64) error = %v", err)
+	}
+	sim.queue(5000, -3000)
+	r, err = h.Read()
+	if err != nil || r.Raw != -3000 || r.Grams != -6000 || r.Gain != Gain64 {
+		t.Errorf("Read(gain 64) got (%+v, %v) want (-3000, -6000g)", r, err)
+	}
+	if !equal(sck.Values, pulses(54)) {
+		t.Errorf("clock got (%d pulses) want 2 x 27", len(sck.Values)/2)
+	}
+
+	// channel B
+	h.SetGain(Gain32)
+	sim.queue(1, 2)
+	if r, err := h.Read(); err != nil || r.Raw != 2 || r.Grams != 8 {
+		t.Errorf("Read(gain 32) got (%+v, %v) want (2, 8g)", r, err)
+	}
+	want := []Gain{Gain128, Gain128, Gain128, Gain64, Gain64, Gain32}
+	if len(sim.gains) != len(want) {
+		t.Fatalf("conversions got (%v) want (%v)", sim.gains, want)
+	}
+	for i := range want {
+		if sim.gains[i] != want[i] {
+			t.Errorf("conversion %d gain got (%d) want (%d)", i, sim.gains[i], want[i])
+		}
+	}
+	if err := h.SetGain(16); !errors.Is(err, ErrGain) {
+		t.Errorf("SetGain(16) error got (%v) want (%v)", err, ErrGain)
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	devicetest.UseStore(t)
+	sim := newSimHX711()
+	h := newScale(t, sim)
+	h.SetGain(Gain64)
+	sim.queue(1, 2)
+	if r, err := h.Read(); err != nil || r.Raw != 2 {
+		t.Fatalf("Read() got (%+v, %v) want (2)", r, err)
+	}
+
+	// missed pulses leave DOUT low, the chip is power cycled back to
+	// gain 128 and the first conversion after it dropped
+	sim.miss = 3
+	sim.queue(0x100, 7, 8)
+	r, err := h.Read()
+	if err != nil || r.Raw != 8 {
+		t.Errorf("Read() got (%+v, %v) want (8)", r, err)
+	}
+	if sim.resets != 1 {
+		t.Errorf("power cycles got (%d) want (1)", sim.resets)
+	}
+	want := []Gain{Gain128, Gain64, Gain64, Gain128, Gain64}
+	if len(sim.gains) != len(want) || sim.gains[3] != Gain128 || sim.gains[4] != Gain64 {
+		t.Errorf("conversions got (%v) want (%v)", sim.gains, want)
+	}
+
+	h.ReadyTimeout = 5 * time.Millisecond
+	h.Retries = 1
+	if _, err := h.Read(); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Read(no conversion) error got (%v) want (%v)", err, ErrNotReady)
+	}
+	if sim.resets != 2 {
+		t.Errorf("power cycles got (%d) want (2)", sim.resets)
+	}
+}
+
+func TestTare(t *testing.T) {
+	devicetest.UseStore(t)
+	sim := newSimHX711()
+	h := newScale(t, sim)
+	h.Samples = 5
+
+	// an empty hive, with a spike
+	sim.queue(1000, 1002, 998, 250000, 1000)
+	if err := h.Command([]byte("tare")); err != nil {
+		t.Fatalf("Command(tare) error = %v", err)
+	}
+	if c := h.Calibration(); c.Offset != 1000 || c.Scale != 1 {
+		t.Errorf("Calibration() got (%+v) want (1000, 1)", c)
+	}
+
+	sim.queue(3000, 3000, 3000, 3000, 3000)
+	if err := h.Command([]byte(" Calibrate 500\n")); err != nil {
+		t.Fatalf("Command(calibrate 500) error = %v", err)
+	}
+	want := Calibration{Offset: 1000, Scale: 4}
+	if h.Calibration() != want {
+		t.Errorf("Calibration() got (%+v) want (%+v)", h.Calibration(), want)
+	}
+
+	// lighter than the tare
+	sim.queue(600, 600, 600, 600, 600)
+	if r, err := h.Read(); err != nil || r.Grams != -100 {
+		t.Errorf("Read() got (%+v, %v) want (-100g)", r, err)
+	}
+	// the calibration holds at gain 64
+	h.SetGain(Gain64)
+	sim.queue(0, 1300, 1300, 1300, 1300, 1300)
+	if r, err := h.Read(); err != nil || r.Grams != 400 || r.Raw != 1300 {
+		t.Errorf("Read(gain 64) got (%+v, %v) want (1300, 400g)", r, err)
+	}
+
+	for _, cmd := range []string{"calibrate", "calibrate 0", "calibrate heavy", "weigh"} {
+		if err := h.Command([]byte(cmd)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", cmd, err, ErrCommand)
+		}
+	}
+	sim.queue(1000, 1000, 1000, 1000, 1000)
+	if err := h.Calibrate(-50); err != nil {
+		t.Errorf("Calibrate() at the tare error got (%v) want (nil)", err)
+	}
+	if c := h.Calibration(); c.Scale != -20 {
+		t.Errorf("Calibration() got (%+v) want a scale of -20", c)
+	}
+
+	// a restarted device loads the saved calibration
+	h.Close()
+	again, err := New("hive", fmt.Sprint(simDOUT), fmt.Sprint(simSCK))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer again.Close()
+	if c := again.Calibration(); c != (Calibration{Offset: 1000, Scale: -20}) {
+		t.Errorf("saved Calibration() got (%+v)", c)
+	}
+}
@@ -0,0 +1,88 @@
+package ina219
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// the fixed LSBs of the shunt and bus voltage registers
+	shuntLSB = 10e-6
+	busLSB   = 4e-3
+
+	// calScale is the internally fixed value of equation 1 of the
+	// datasheet, Cal = trunc(0.04096 / (Current_LSB * R_SHUNT))
+	calScale = 0.04096
+)
+
+// Calibration is the content of the calibration register and the
+// scaling of the current and power registers that goes with it
+type Calibration struct {
+	Cal        uint16
+	CurrentLSB float64 // amps a count
+	PowerLSB   float64 // watts a count
+}
+
+// Calibrate works out the calibration register for the shunt
+// resistance in ohms and the largest current expected in amps, as
+// in the "Calibration Register and Scaling" section of the
+// datasheet. The current LSB, maxCurrent / 2^15 at the least, is
+// rounded up to 1, 2 or 5 times a power of ten so the readings come
+// in round numbers. The power LSB is 20 times the current LSB.
+func Calibrate(shunt, maxCurrent float64) (Calibration, error) {
+	if shunt <= 0 || maxCurrent <= 0 {
+		return Calibration{}, fmt.Errorf("%w: shunt %vΩ, max current %vA", ErrCalibration, shunt, maxCurrent)
+	}
+	lsb := roundLSB(maxCurrent / (1 << 15))
+	cal := math.Trunc(calScale / (lsb * shunt))
+	// bit 0 of the register is not used
+	if cal < 2 || cal > 0xFFFE {
+		return Calibration{}, fmt.Errorf("%w: register %v out of range for a %vΩ shunt", ErrCalibration, cal, shunt)
+	}
+	c := uint16(cal) &^ 1
+	return Calibration{
+		Cal:        c,
+		CurrentLSB: lsb,
+		PowerLSB:   20 * lsb,
+	}, nil
+}
+
+// roundLSB rounds lsb up to 1, 2 or 5 times a power of ten
+func roundLSB(lsb float64) float64 {
+	p := math.Pow(10, math.Floor(math.Log10(lsb)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		// the tolerance keeps an exact step from rounding up
+		if lsb <= m*p*(1+1e-9) {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// PGA is the shunt voltage range, the PG field of the configuration
+// register
+type PGA uint16
+
+const (
+	PGA40mV  PGA = 0 // gain 1
+	PGA80mV  PGA = 1 // gain /2
+	PGA160mV PGA = 2 // gain /4
+	PGA320mV PGA = 3 // gain /8, the power on default
+)
+
+// Volts returns the full scale shunt voltage of the range
+func (p PGA) Volts() float64 {
+	return 0.04 * float64(uint(1)<<p)
+}
+
+// pgaFor returns the smallest range that holds the shunt voltage of
+// maxCurrent
+func pgaFor(shunt, maxCurrent float64) PGA {
+	v := shunt * maxCurrent
+	for p := PGA40mV; p < PGA320mV; p++ {
+		if v <= p.Volts()*(1+1e-9) {
+			return p
+		}
+	}
+	return PGA320mV
+}
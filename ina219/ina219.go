@@ -0,0 +1,304 @@
+// Package ina219 provides a driver for the TI INA219 current and
+// power monitor. The sensor measures the voltage across a shunt
+// resistor and the bus voltage, the calibration register worked out
+// from the shunt and the largest expected current lets it report the
+// current and the power too.
+//
+// A low voltage alert with hysteresis watches the bus voltage, for
+// the battery of a solar powered station.
+package ina219
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the address with A0 and A1 to ground, the others
+	// go up to 0x4F
+	Address = 0x40
+
+	// DefaultShunt and DefaultMaxCurrent match the common breakout
+	// boards with a 0.1Ω shunt
+	DefaultShunt      = 0.1
+	DefaultMaxCurrent = 2.0
+)
+
+// registers, 16 bit big endian
+const (
+	regConfig      = 0x00
+	regShunt       = 0x01
+	regBus         = 0x02
+	regPower       = 0x03
+	regCurrent     = 0x04
+	regCalibration = 0x05
+
+	configReset = 1 << 15
+	configBRNG  = 1 << 13 // 32V bus range
+	configADC12 = 0x3     // 12 bit, 532us conversions
+	configMode  = 0x7     // shunt and bus, continuous
+
+	busOVF = 1 << 0 // math overflow
+)
+
+var (
+	ErrCalibration = errors.New("invalid ina219 calibration")
+	ErrOverflow    = errors.New("ina219 current or power overflow")
+	ErrReadFailed  = errors.New("failed to read from INA219")
+)
+
+// Reading is a measurement, what ReadPub publishes
+type Reading struct {
+	Bus     float64 `json:"bus_v"`
+	Shunt   float64 `json:"shunt_mv"`
+	Current float64 `json:"current_ma"`
+	Power   float64 `json:"power_mw"`
+}
+
+// AlertEvent is published when the bus voltage drops below the low
+// voltage, "low_voltage", and when it is back above the low voltage
+// plus the hysteresis, "voltage_ok"
+type AlertEvent struct {
+	Event string  `json:"event"`
+	Bus   float64 `json:"bus_v"`
+}
+
+// INA219 is a current and power monitor on an I2C bus
+type INA219 struct {
+	*device.Device
+
+	bus  string
+	addr int
+	dev  *drivers.I2CDevice
+	cal  Calibration
+	pga  PGA
+
+	low        float64
+	hysteresis float64
+	isLow      bool
+
+	mu sync.Mutex
+}
+
+// New creates an INA219 at addr on the given bus calibrated for
+// DefaultShunt and DefaultMaxCurrent, the sensor is not touched until
+// Init
+func New(name, bus string, addr int) *INA219 {
+	cal, _ := Calibrate(DefaultShunt, DefaultMaxCurrent)
+	return &INA219{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		addr:   addr,
+		cal:    cal,
+		pga:    pgaFor(DefaultShunt, DefaultMaxCurrent),
+	}
+}
+
+// Init opens the i2c bus, resets the sensor and writes the
+// configuration and the calibration
+func (i *INA219) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(i.bus, i.addr)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.dev = dev
+	if err := dev.WriteReg16(regConfig, configReset); err != nil {
+		return err
+	}
+	return i.configure()
+}
+
+// Name returns the name of the device
+func (i *INA219) Name() string {
+	return i.Device.Name
+}
+
+// Calibrate sets the calibration for a shunt of shunt ohms and a
+// largest current of maxCurrent amps, the shunt voltage range is the
+// smallest that holds their product
+func (i *INA219) Calibrate(shunt, maxCurrent float64) error {
+	cal, err := Calibrate(shunt, maxCurrent)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cal, i.pga = cal, pgaFor(shunt, maxCurrent)
+	return i.configure()
+}
+
+// Calibration returns the calibration in use
+func (i *INA219) Calibration() Calibration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.cal
+}
+
+// SetLowVoltage publishes an AlertEvent when the bus voltage drops
+// below volts, and again when it recovers above volts + hysteresis.
+// 0 turns the alert off.
+func (i *INA219) SetLowVoltage(volts, hysteresis float64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.low, i.hysteresis = volts, hysteresis
+	i.isLow = false
+}
+
+// Read returns the bus and shunt voltages, the current and the power.
+// When the current or power overflowed the reading is returned along
+// with ErrOverflow, the voltages are good but the shunt is carrying
+// more than the calibration allows for.
+func (i *INA219) Read() (*Reading, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if device.IsMock() {
+		// a 12V battery charging at around 350mA
+		bus := 12.6 + i.MockRand().Float64()*0.4
+		cur := 0.3 + i.MockRand().Float64()*0.1
+		return &Reading{
+			Bus:     bus,
+			Shunt:   cur * DefaultShunt * 1e3,
+			Current: cur * 1e3,
+			Power:   bus * cur * 1e3,
+		}, nil
+	}
+	if i.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	var shunt, bus, power, current uint16
+	err := i.dev.Tx(func(b drivers.I2CBus) error {
+		// the calibration is lost on a brown out, the current and
+		// power read 0 without it
+		if err := b.WriteReg(regCalibration, be16(i.cal.Cal)); err != nil {
+			return err
+		}
+		for _, r := range []struct {
+			reg byte
+			v   *uint16
+		}{
+			{regShunt, &shunt}, {regBus, &bus}, {regPower, &power}, {regCurrent, &current},
+		} {
+			buf := make([]byte, 2)
+			if err := b.ReadReg(r.reg, buf); err != nil {
+				return err
+			}
+			*r.v = uint16(buf[0])<<8 | uint16(buf[1])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+
+	r := &Reading{
+		Bus:     float64(bus>>3) * busLSB,
+		Shunt:   float64(int16(shunt)) * shuntLSB * 1e3,
+		Current: float64(int16(current)) * i.cal.CurrentLSB * 1e3,
+		Power:   float64(power) * i.cal.PowerLSB * 1e3,
+	}
+	if bus&busOVF != 0 {
+		return r, ErrOverflow
+	}
+	return r, nil
+}
+
+// ReadPub reads the sensor and publishes the reading, and the alert
+// if the bus voltage crossed the low voltage. An overflowed reading
+// is published, ErrOverflow is returned after it.
+func (i *INA219) ReadPub() error {
+	r, err := i.Read()
+	if r == nil {
+		return err
+	}
+	j, jerr := json.Marshal(r)
+	if jerr != nil {
+		return jerr
+	}
+	i.PubData(j)
+
+	if evt := i.check(r.Bus); evt != nil {
+		slog.Warn("ina219 bus voltage", "device", i.Device.Name, "event", evt.Event, "bus_v", evt.Bus)
+		if j, err := json.Marshal(evt); err == nil {
+			i.PubData(j)
+		}
+	}
+	return err
+}
+
+// check returns the AlertEvent for a bus voltage, nil if the alert
+// state did not change
+func (i *INA219) check(bus float64) *AlertEvent {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case i.low <= 0:
+		return nil
+	case !i.isLow && bus < i.low:
+		i.isLow = true
+		return &AlertEvent{Event: "low_voltage", Bus: bus}
+	case i.isLow && bus >= i.low+i.hysteresis:
+		i.isLow = false
+		return &AlertEvent{Event: "voltage_ok", Bus: bus}
+	}
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (i *INA219) Run(ctx context.Context, period time.Duration) error {
+	err := i.TimerLoop(ctx, period, i.ReadPub)
+	slog.Debug("ina219 stopped", "device", i.Device.Name, "error", err)
+	return err
+}
+
+// Close powers the sensor down and closes the i2c device
+func (i *INA219) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.dev == nil {
+		return nil
+	}
+	i.dev.WriteReg16(regConfig, i.config()&^configMode)
+	err := i.dev.Close()
+	i.dev = nil
+	return err
+}
+
+// config returns the configuration register, 32V bus range, the
+// shunt range of the calibration and 12 bit continuous conversions
+func (i *INA219) config() uint16 {
+	return configBRNG | uint16(i.pga)<<11 | configADC12<<7 | configADC12<<3 | configMode
+}
+
+// configure writes the configuration and the calibration, i.mu is
+// held
+func (i *INA219) configure() error {
+	if i.dev == nil {
+		return nil
+	}
+	if err := i.dev.WriteReg16(regConfig, i.config()); err != nil {
+		return err
+	}
+	return i.dev.WriteReg16(regCalibration, i.cal.Cal)
+}
+
+func be16(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,244 @@
+package ina219
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// sensor models the INA219 over the register map fake. The
+// registers are 16 bits at consecutive addresses, so they are kept
+// here and the fake only records the writes and fails on demand. The
+// current and power registers are worked out from the shunt and bus
+// registers and the calibration with equations 4 and 5 of the
+// datasheet.
+type sensor struct {
+	*driverstest.I2C
+	regs [6]uint16
+}
+
+func (s *sensor) WriteReg(reg byte, buf []byte) error {
+	if err := s.I2C.WriteReg(reg, buf); err != nil {
+		return err
+	}
+	if int(reg) < len(s.regs) {
+		s.regs[reg] = binary.BigEndian.Uint16(buf)
+	}
+	return nil
+}
+
+func (s *sensor) ReadReg(reg byte, buf []byte) error {
+	if err := s.I2C.ReadReg(reg, buf); err != nil {
+		return err
+	}
+	current := int16(int32(int16(s.regs[regShunt])) * int32(s.regs[regCalibration]) / 4096)
+	s.regs[regCurrent] = uint16(current)
+	s.regs[regPower] = uint16(math.Abs(float64(current)) * float64(s.regs[regBus]>>3) / 5000)
+	binary.BigEndian.PutUint16(buf, s.regs[reg])
+	return nil
+}
+
+// setBus sets the bus register to volts with the conversion ready
+// flag and the overflow flag
+func (s *sensor) setBus(volts float64, ovf bool) {
+	s.regs[regBus] = uint16(math.Round(volts/busLSB))<<3 | 1<<1
+	if ovf {
+		s.regs[regBus] |= busOVF
+	}
+}
+
+// setShunt sets the shunt register to volts
+func (s *sensor) setShunt(volts float64) {
+	s.regs[regShunt] = uint16(int16(math.Round(volts / shuntLSB)))
+}
+
+func newTestINA(t *testing.T) (*INA219, *sensor) {
+	t.Helper()
+	device.Mock(false)
+	s := &sensor{I2C: driverstest.NewI2C()}
+	driverstest.UseI2C(t, s)
+
+	i := New("ina-test", TestI2CBus, Address)
+	if err := i.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return i, s
+}
+
+func TestCalibrate(t *testing.T) {
+	tests := []struct {
+		name       string
+		shunt, max float64
+		cal        uint16
+		currentLSB float64
+		powerLSB   float64
+		err        error
+	}{
+		// the worked example of the datasheet, 32V, 0.1Ω and 2A
+		// expected, the current LSB picked is 100uA
+		{"datasheet", 0.1, 2, 4096, 100e-6, 2e-3, nil},
+		{"1A", 0.1, 1, 8192, 50e-6, 1e-3, nil},
+		{"400mA", 0.1, 0.4, 20480, 20e-6, 0.4e-3, nil},
+		{"10A 10mΩ", 0.01, 10, 8192, 500e-6, 10e-3, nil},
+		{"exact step", 1, 0.32768, 4096, 10e-6, 0.2e-3, nil},
+		{"too small a shunt", 0.0001, 0.1, 0, 0, 0, ErrCalibration},
+		{"no shunt", 0, 2, 0, 0, 0, ErrCalibration},
+		{"no current", 0.1, 0, 0, 0, 0, ErrCalibration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Calibrate(tt.shunt, tt.max)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Calibrate() error got (%v) want (%v)", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if c.Cal != tt.cal || math.Abs(c.CurrentLSB-tt.currentLSB) > 1e-12 ||
+				math.Abs(c.PowerLSB-tt.powerLSB) > 1e-12 {
+				t.Errorf("Calibrate() got (%+v) want (%d, %v, %v)", c, tt.cal, tt.currentLSB, tt.powerLSB)
+			}
+		})
+	}
+}
+
+func TestPGA(t *testing.T) {
+	tests := []struct {
+		shunt, max float64
+		want       PGA
+	}{
+		{0.1, 0.4, PGA40mV},
+		{0.1, 0.5, PGA80mV},
+		{0.1, 1.5, PGA160mV},
+		{0.1, 2, PGA320mV},
+		{0.1, 5, PGA320mV},
+	}
+	for _, tt := range tests {
+		if got := pgaFor(tt.shunt, tt.max); got != tt.want {
+			t.Errorf("pgaFor(%v, %v) got (%d) want (%d)", tt.shunt, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	_, s := newTestINA(t)
+	want := []struct {
+		reg byte
+		v   uint16
+	}{
+		{regConfig, configReset},
+		{regConfig, 0x399F}, // the power on default, 320mV range
+		{regCalibration, 4096},
+	}
+	if len(s.Writes) != len(want) {
+		t.Fatalf("Init() wrote (%v) want %d writes", s.Writes, len(want))
+	}
+	for n, w := range want {
+		got := s.Writes[n]
+		if got.Reg != int(w.reg) || binary.BigEndian.Uint16(got.Data) != w.v {
+			t.Errorf("write %d got (%#02x %x) want (%#02x %#04x)", n, got.Reg, got.Data, w.reg, w.v)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	i, s := newTestINA(t)
+
+	// the datasheet example, 12V and 20mV across the 0.1Ω shunt
+	s.setBus(12, false)
+	s.setShunt(0.02)
+	r, err := i.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(r.Bus-12) > 1e-9 || math.Abs(r.Shunt-20) > 1e-9 ||
+		math.Abs(r.Current-200) > 1e-9 || math.Abs(r.Power-2400) > 1e-9 {
+		t.Errorf("Read() got (%+v) want (12V, 20mV, 200mA, 2400mW)", *r)
+	}
+
+	// discharging, the current is negative
+	s.setShunt(-0.0125)
+	if r, _ = i.Read(); math.Abs(r.Current+125) > 1e-9 || math.Abs(r.Power-1500) > 1e-9 {
+		t.Errorf("Read() discharging got (%+v) want (-125mA, 1500mW)", *r)
+	}
+
+	// recalibrated for 400mA the counts are 5 times finer
+	if err := i.Calibrate(0.1, 0.4); err != nil {
+		t.Fatalf("Calibrate() error = %v", err)
+	}
+	s.setBus(3.3, false)
+	s.setShunt(0.0314)
+	if r, _ = i.Read(); math.Abs(r.Current-314) > 1e-9 || math.Abs(r.Bus-3.3) > 1e-9 {
+		t.Errorf("Read() at 400mA got (%+v) want (3.3V, 314mA)", *r)
+	}
+
+	// a brown out lost the calibration, Read writes it back
+	s.regs[regCalibration] = 0
+	if r, _ = i.Read(); math.Abs(r.Current-314) > 1e-9 {
+		t.Errorf("Read() after a brown out got (%v mA) want (314)", r.Current)
+	}
+
+	s.setBus(12, true)
+	r, err = i.Read()
+	if !errors.Is(err, ErrOverflow) || r == nil || math.Abs(r.Bus-12) > 1e-9 {
+		t.Errorf("Read() overflow got (%v, %v) want the reading and (%v)", r, err, ErrOverflow)
+	}
+
+	s.FailNext(driverstest.ErrnoNak)
+	if _, err := i.Read(); !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrReadFailed)
+	}
+}
+
+func TestLowVoltage(t *testing.T) {
+	i := New("battery", TestI2CBus, Address)
+	if evt := i.check(10); evt != nil {
+		t.Errorf("check() with the alert off got (%v) want (nil)", evt)
+	}
+
+	i.SetLowVoltage(11.8, 0.5)
+	tests := []struct {
+		bus  float64
+		want string
+	}{
+		{12.6, ""},
+		{11.7, "low_voltage"},
+		{11.5, ""},
+		{12.0, ""}, // within the hysteresis
+		{12.4, "voltage_ok"},
+		{11.9, ""},
+		{11.79, "low_voltage"},
+	}
+	for _, tt := range tests {
+		evt := i.check(tt.bus)
+		got := ""
+		if evt != nil {
+			got = evt.Event
+		}
+		if got != tt.want {
+			t.Errorf("check(%v) got (%q) want (%q)", tt.bus, got, tt.want)
+		}
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	i := New("battery", TestI2CBus, Address)
+	if err := i.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := i.ReadPub(); err != nil {
+		t.Errorf("ReadPub() error = %v", err)
+	}
+	if err := i.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
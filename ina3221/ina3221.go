@@ -0,0 +1,401 @@
+// Package ina3221 provides a driver for the TI INA3221 three channel
+// current and bus voltage monitor, like the battery, the solar input
+// and the load of a station on one chip. The channels have a shunt
+// resistor and a label each, they are published together as one
+// reading nested by label.
+//
+// Each channel has a warning limit, compared with the averaged
+// current, and a critical limit, compared with every conversion. A
+// channel crossing one publishes an AlertEvent.
+package ina3221
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the address with A0 to ground, to VS, SDA and SCL
+	// it is 0x41 to 0x43
+	Address = 0x40
+
+	// DefaultShunt is the 0.1Ω shunt of the common breakout boards
+	DefaultShunt = 0.1
+
+	// Channels is the number of channels
+	Channels = 3
+)
+
+// registers, 16 bit big endian. The shunt, bus and limit registers
+// of channel n (0 - 2) are 2n after those of channel 0.
+const (
+	regConfig      = 0x00
+	regShunt       = 0x01
+	regBus         = 0x02
+	regCritical    = 0x07
+	regWarning     = 0x08
+	regMask        = 0x0F
+	regManufacture = 0xFE
+	regDie         = 0xFF
+
+	manufacturerID = 0x5449 // "TI"
+	dieID          = 0x3220
+
+	configReset   = 1 << 15
+	configEnable  = 1 << 14 // channel 0, >> n for channel n
+	configDefault = 0x0127  // 1 sample, 1.1ms conversions, continuous
+
+	maskWarning  = 1 << 5 // channel 0, >> n for channel n
+	maskCritical = 1 << 9 // channel 0, >> n for channel n
+
+	// the shunt and bus registers hold 13 bit values above 3 unused
+	// bits
+	shuntLSB = 40e-6
+	busLSB   = 8e-3
+
+	// limitOff is the reset value of the limit registers, the
+	// largest shunt voltage
+	limitOff = 0x7FF8
+)
+
+var (
+	ErrNotINA3221 = errors.New("device is not an INA3221")
+	ErrChannel    = errors.New("ina3221 channel must be 1 to 3")
+	ErrLimit      = errors.New("ina3221 alert limit out of range")
+	ErrReadFailed = errors.New("failed to read from INA3221")
+)
+
+// Channel is the configuration of a channel. The limits are in amps,
+// 0 turns a limit off.
+type Channel struct {
+	Label    string
+	Shunt    float64
+	Disabled bool
+	Warning  float64
+	Critical float64
+}
+
+// ChannelReading is a measurement of one channel
+type ChannelReading struct {
+	Bus     float64 `json:"bus_v"`
+	Shunt   float64 `json:"shunt_mv"`
+	Current float64 `json:"current_ma"`
+	Power   float64 `json:"power_mw"`
+}
+
+// Reading is what ReadPub publishes, the enabled channels by label
+type Reading map[string]*ChannelReading
+
+// AlertEvent is published when a channel's current goes over its
+// warning or critical limit
+type AlertEvent struct {
+	Event   string  `json:"event"`
+	Channel string  `json:"channel"`
+	Current float64 `json:"current_ma"`
+}
+
+// INA3221 is a three channel monitor on an I2C bus
+type INA3221 struct {
+	*device.Device
+
+	bus  string
+	addr int
+	dev  *drivers.I2CDevice
+	ch   [Channels]Channel
+
+	// the alerts published, so an alert is published once per
+	// crossing
+	warned   [Channels]bool
+	critical [Channels]bool
+
+	mu sync.Mutex
+}
+
+// New creates an INA3221 at addr on the given bus with all channels
+// enabled, labeled "ch1" to "ch3" and with DefaultShunt. The sensor
+// is not touched until Init.
+func New(name, bus string, addr int) *INA3221 {
+	i := &INA3221{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		addr:   addr,
+	}
+	for n := range i.ch {
+		i.ch[n] = Channel{Label: fmt.Sprintf("ch%d", n+1), Shunt: DefaultShunt}
+	}
+	return i
+}
+
+// Init opens the i2c bus, checks the ids, resets the sensor and
+// writes the configuration and the limits
+func (i *INA3221) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(i.bus, i.addr)
+	if err != nil {
+		return err
+	}
+	mid, err := dev.ReadReg16(regManufacture)
+	if err != nil {
+		return err
+	}
+	did, err := dev.ReadReg16(regDie)
+	if err != nil {
+		return err
+	}
+	if mid != manufacturerID || did != dieID {
+		dev.Close()
+		return fmt.Errorf("%w: ids %#04x %#04x", ErrNotINA3221, mid, did)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.dev = dev
+	if err := dev.WriteReg16(regConfig, configReset); err != nil {
+		return err
+	}
+	if err := dev.WriteReg16(regConfig, i.config()); err != nil {
+		return err
+	}
+	for n := range i.ch {
+		if err := i.writeLimits(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the device
+func (i *INA3221) Name() string {
+	return i.Device.Name
+}
+
+// SetChannel configures channel n, 1 to 3. A channel without a label
+// keeps its old one.
+func (i *INA3221) SetChannel(n int, c Channel) error {
+	if n < 1 || n > Channels {
+		return fmt.Errorf("%w: %d", ErrChannel, n)
+	}
+	if c.Shunt <= 0 {
+		return fmt.Errorf("%w: shunt %vΩ", ErrLimit, c.Shunt)
+	}
+	for _, limit := range []float64{c.Warning, c.Critical} {
+		if _, err := encodeLimit(limit, c.Shunt); err != nil {
+			return err
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	n--
+	if c.Label == "" {
+		c.Label = i.ch[n].Label
+	}
+	i.ch[n] = c
+	i.warned[n], i.critical[n] = false, false
+	if i.dev == nil {
+		return nil
+	}
+	if err := i.dev.WriteReg16(regConfig, i.config()); err != nil {
+		return err
+	}
+	return i.writeLimits(n)
+}
+
+// Channel returns the configuration of channel n, 1 to 3
+func (i *INA3221) Channel(n int) (Channel, error) {
+	if n < 1 || n > Channels {
+		return Channel{}, fmt.Errorf("%w: %d", ErrChannel, n)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.ch[n-1], nil
+}
+
+// Read returns the enabled channels and the alerts that are new since
+// the last Read
+func (i *INA3221) Read() (Reading, []*AlertEvent, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if device.IsMock() {
+		r := make(Reading)
+		for n, c := range i.ch {
+			if c.Disabled {
+				continue
+			}
+			// a 12V rail with 100 to 500mA on each channel
+			bus := 12 + i.MockRand().Float64()*0.5
+			cur := 100 + i.MockRand().Float64()*400 + float64(n)
+			r[c.Label] = &ChannelReading{
+				Bus:     bus,
+				Shunt:   cur * c.Shunt,
+				Current: cur,
+				Power:   bus * cur,
+			}
+		}
+		return r, nil, nil
+	}
+	if i.dev == nil {
+		return nil, nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	var regs [regBus + 2*Channels]uint16
+	var mask uint16
+	err := i.dev.Tx(func(b drivers.I2CBus) error {
+		buf := make([]byte, 2)
+		for n := range i.ch {
+			if i.ch[n].Disabled {
+				continue
+			}
+			for _, reg := range []byte{regShunt + byte(2*n), regBus + byte(2*n)} {
+				if err := b.ReadReg(reg, buf); err != nil {
+					return err
+				}
+				regs[reg] = uint16(buf[0])<<8 | uint16(buf[1])
+			}
+		}
+		// reading the mask register clears the alert flags
+		if err := b.ReadReg(regMask, buf); err != nil {
+			return err
+		}
+		mask = uint16(buf[0])<<8 | uint16(buf[1])
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+
+	r := make(Reading)
+	var alerts []*AlertEvent
+	for n, c := range i.ch {
+		if c.Disabled {
+			continue
+		}
+		shunt := decodeShunt(regs[regShunt+2*n])
+		bus := decodeBus(regs[regBus+2*n])
+		cr := &ChannelReading{
+			Bus:     bus,
+			Shunt:   shunt * 1e3,
+			Current: shunt / c.Shunt * 1e3,
+			Power:   bus * shunt / c.Shunt * 1e3,
+		}
+		r[c.Label] = cr
+
+		warn, crit := mask&(maskWarning>>n) != 0, mask&(maskCritical>>n) != 0
+		if crit && !i.critical[n] {
+			alerts = append(alerts, &AlertEvent{Event: "critical", Channel: c.Label, Current: cr.Current})
+		} else if warn && !i.warned[n] && !crit {
+			alerts = append(alerts, &AlertEvent{Event: "warning", Channel: c.Label, Current: cr.Current})
+		}
+		i.warned[n], i.critical[n] = warn || crit, crit
+	}
+	return r, alerts, nil
+}
+
+// ReadPub reads the channels and publishes them, and the alerts
+func (i *INA3221) ReadPub() error {
+	r, alerts, err := i.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	i.PubData(j)
+
+	for _, a := range alerts {
+		slog.Warn("ina3221 alert", "device", i.Device.Name, "event", a.Event, "channel", a.Channel, "current_ma", a.Current)
+		if j, err := json.Marshal(a); err == nil {
+			i.PubData(j)
+		}
+	}
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (i *INA3221) Run(ctx context.Context, period time.Duration) error {
+	err := i.TimerLoop(ctx, period, i.ReadPub)
+	slog.Debug("ina3221 stopped", "device", i.Device.Name, "error", err)
+	return err
+}
+
+// Close powers the sensor down and closes the i2c device
+func (i *INA3221) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.dev == nil {
+		return nil
+	}
+	i.dev.WriteReg16(regConfig, i.config()&^0x7)
+	err := i.dev.Close()
+	i.dev = nil
+	return err
+}
+
+// config returns the configuration register with the enabled
+// channels, i.mu is held
+func (i *INA3221) config() uint16 {
+	v := uint16(configDefault)
+	for n, c := range i.ch {
+		if !c.Disabled {
+			v |= configEnable >> n
+		}
+	}
+	return v
+}
+
+// writeLimits writes the warning and critical limits of channel n,
+// i.mu is held
+func (i *INA3221) writeLimits(n int) error {
+	c := i.ch[n]
+	crit, err := encodeLimit(c.Critical, c.Shunt)
+	if err != nil {
+		return err
+	}
+	warn, err := encodeLimit(c.Warning, c.Shunt)
+	if err != nil {
+		return err
+	}
+	if err := i.dev.WriteReg16(regCritical+byte(2*n), crit); err != nil {
+		return err
+	}
+	return i.dev.WriteReg16(regWarning+byte(2*n), warn)
+}
+
+// decodeShunt returns the volts of a shunt voltage register
+func decodeShunt(v uint16) float64 {
+	return float64(int16(v)>>3) * shuntLSB
+}
+
+// decodeBus returns the volts of a bus voltage register
+func decodeBus(v uint16) float64 {
+	return float64(int16(v)>>3) * busLSB
+}
+
+// encodeLimit returns the limit register of a current limit in amps
+// over a shunt of shunt ohms. The limit is a shunt voltage in the
+// format of the shunt register, 0 is written as the largest one.
+func encodeLimit(amps, shunt float64) (uint16, error) {
+	if amps == 0 {
+		return limitOff, nil
+	}
+	counts := math.Round(amps * shunt / shuntLSB)
+	if counts < 0 || counts > limitOff>>3 {
+		return 0, fmt.Errorf("%w: %vA over %vΩ", ErrLimit, amps, shunt)
+	}
+	return uint16(counts) << 3, nil
+}
@@ -0,0 +1,269 @@
+package ina3221
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// sensor models the INA3221 over the register map fake. The registers
+// are 16 bits at consecutive addresses, so they are kept here and the
+// fake only records the writes and fails on demand. Reading the mask
+// register clears its flags like the chip does.
+type sensor struct {
+	*driverstest.I2C
+	regs [256]uint16
+}
+
+func (s *sensor) WriteReg(reg byte, buf []byte) error {
+	if err := s.I2C.WriteReg(reg, buf); err != nil {
+		return err
+	}
+	s.regs[reg] = binary.BigEndian.Uint16(buf)
+	return nil
+}
+
+func (s *sensor) ReadReg(reg byte, buf []byte) error {
+	if err := s.I2C.ReadReg(reg, buf); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(buf, s.regs[reg])
+	if reg == regMask {
+		s.regs[regMask] &^= 0x03FF
+	}
+	return nil
+}
+
+// set sets the shunt and bus registers of channel n, 0 - 2
+func (s *sensor) set(n int, shunt, bus float64) {
+	s.regs[regShunt+2*n] = uint16(int16(math.Round(shunt/shuntLSB)) << 3)
+	s.regs[regBus+2*n] = uint16(int16(math.Round(bus/busLSB)) << 3)
+}
+
+func newTestINA(t *testing.T) (*INA3221, *sensor) {
+	t.Helper()
+	device.Mock(false)
+	s := &sensor{I2C: driverstest.NewI2C()}
+	s.regs[regManufacture] = manufacturerID
+	s.regs[regDie] = dieID
+	driverstest.UseI2C(t, s)
+
+	i := New("power", TestI2CBus, Address)
+	if err := i.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return i, s
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		reg   uint16
+		shunt float64
+		bus   float64
+	}{
+		{0x0000, 0, 0},
+		{0x0008, 40e-6, 8e-3},
+		{0x7FF8, 163.8e-3, 32.760},
+		{0xFFF8, -40e-6, -8e-3},
+		{0x8000, -163.84e-3, -32.768},
+		{0x0007, 0, 0}, // the unused bits
+	}
+	for _, tt := range tests {
+		if got := decodeShunt(tt.reg); math.Abs(got-tt.shunt) > 1e-12 {
+			t.Errorf("decodeShunt(%#04x) got (%v) want (%v)", tt.reg, got, tt.shunt)
+		}
+		if got := decodeBus(tt.reg); math.Abs(got-tt.bus) > 1e-12 {
+			t.Errorf("decodeBus(%#04x) got (%v) want (%v)", tt.reg, got, tt.bus)
+		}
+	}
+}
+
+func TestEncodeLimit(t *testing.T) {
+	tests := []struct {
+		amps, shunt float64
+		want        uint16
+		err         error
+	}{
+		{0, 0.1, limitOff, nil},
+		{1, 0.1, 2500 << 3, nil}, // 100mV
+		{0.5, 0.1, 1250 << 3, nil},
+		{10, 0.01, 2500 << 3, nil},
+		{1.638, 0.1, 4095 << 3, nil}, // the largest
+		{1.64, 0.1, 0, ErrLimit},
+		{-1, 0.1, 0, ErrLimit},
+	}
+	for _, tt := range tests {
+		got, err := encodeLimit(tt.amps, tt.shunt)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("encodeLimit(%v, %v) got (%#04x, %v) want (%#04x, %v)", tt.amps, tt.shunt, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	i, s := newTestINA(t)
+	if s.regs[regConfig] != 0x7127 {
+		t.Errorf("config got (%#04x) want the power on default (0x7127)", s.regs[regConfig])
+	}
+	for n := 0; n < Channels; n++ {
+		if s.regs[regCritical+2*n] != limitOff || s.regs[regWarning+2*n] != limitOff {
+			t.Errorf("channel %d limits got (%#04x, %#04x) want off", n+1, s.regs[regCritical+2*n], s.regs[regWarning+2*n])
+		}
+	}
+	i.Close()
+
+	device.Mock(false)
+	bad := &sensor{I2C: driverstest.NewI2C()}
+	bad.regs[regManufacture] = 0x4954
+	driverstest.UseI2C(t, bad)
+	if err := New("power", TestI2CBus, Address).Init(); !errors.Is(err, ErrNotINA3221) {
+		t.Errorf("Init() of another chip error got (%v) want (%v)", err, ErrNotINA3221)
+	}
+}
+
+func TestSetChannel(t *testing.T) {
+	i, s := newTestINA(t)
+	err := i.SetChannel(1, Channel{Label: "battery", Shunt: 0.1, Warning: 1, Critical: 1.5})
+	if err != nil {
+		t.Fatalf("SetChannel() error = %v", err)
+	}
+	if err := i.SetChannel(3, Channel{Shunt: 0.1, Disabled: true}); err != nil {
+		t.Fatalf("SetChannel() error = %v", err)
+	}
+	if s.regs[regConfig] != 0x6127 {
+		t.Errorf("config got (%#04x) want channel 3 off (0x6127)", s.regs[regConfig])
+	}
+	if s.regs[regWarning] != 2500<<3 || s.regs[regCritical] != 3750<<3 {
+		t.Errorf("channel 1 limits got (%#04x, %#04x) want (%#04x, %#04x)", s.regs[regWarning], s.regs[regCritical], 2500<<3, 3750<<3)
+	}
+	if c, _ := i.Channel(3); c.Label != "ch3" {
+		t.Errorf("channel 3 label got (%s) want (ch3)", c.Label)
+	}
+
+	for n, c := range map[int]Channel{
+		0: {Shunt: 0.1},
+		4: {Shunt: 0.1},
+		2: {Shunt: 0},
+		1: {Shunt: 0.1, Critical: 2},
+	} {
+		if err := i.SetChannel(n, c); err == nil {
+			t.Errorf("SetChannel(%d, %+v) got no error", n, c)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	i, s := newTestINA(t)
+	i.SetChannel(1, Channel{Label: "battery", Shunt: 0.1})
+	i.SetChannel(2, Channel{Label: "solar", Shunt: 0.05})
+	i.SetChannel(3, Channel{Label: "load", Shunt: 0.1})
+	s.set(0, -0.02, 12.8)  // discharging at 200mA
+	s.set(1, 0.025, 18.4)  // 500mA from the panel
+	s.set(2, 0.0304, 12.0) // 304mA to the load
+
+	r, alerts, err := i.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("alerts got (%v) want none", alerts)
+	}
+	want := map[string]ChannelReading{
+		"battery": {Bus: 12.8, Shunt: -20, Current: -200, Power: -2560},
+		"solar":   {Bus: 18.4, Shunt: 25, Current: 500, Power: 9200},
+		"load":    {Bus: 12.0, Shunt: 30.4, Current: 304, Power: 3648},
+	}
+	for label, w := range want {
+		got := r[label]
+		if got == nil {
+			t.Errorf("Read() has no %s channel", label)
+			continue
+		}
+		if math.Abs(got.Bus-w.Bus) > 1e-9 || math.Abs(got.Shunt-w.Shunt) > 1e-9 ||
+			math.Abs(got.Current-w.Current) > 1e-9 || math.Abs(got.Power-w.Power) > 1e-6 {
+			t.Errorf("Read() %s got (%+v) want (%+v)", label, *got, w)
+		}
+	}
+
+	// disabled channels are left out of the payload
+	i.SetChannel(2, Channel{Shunt: 0.05, Disabled: true})
+	r, _, _ = i.Read()
+	j, _ := json.Marshal(r)
+	var nested map[string]map[string]float64
+	if err := json.Unmarshal(j, &nested); err != nil {
+		t.Fatalf("payload %s: %v", j, err)
+	}
+	if len(nested) != 2 || nested["solar"] != nil || nested["load"]["current_ma"] == 0 {
+		t.Errorf("payload got (%s) want battery and load", j)
+	}
+
+	s.FailNext(driverstest.ErrnoNak)
+	if _, _, err := i.Read(); !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrReadFailed)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	i, s := newTestINA(t)
+	i.SetChannel(1, Channel{Label: "battery", Shunt: 0.1, Warning: 1, Critical: 1.5})
+	i.SetChannel(3, Channel{Label: "load", Shunt: 0.1, Warning: 0.5})
+
+	tests := []struct {
+		name string
+		mask uint16
+		want []string
+	}{
+		{"quiet", 0, nil},
+		{"battery warning", maskWarning, []string{"warning battery"}},
+		{"still over", maskWarning, nil},
+		{"battery critical and load warning", maskWarning | maskCritical | maskWarning>>2,
+			[]string{"critical battery", "warning load"}},
+		{"battery back to warning", maskWarning | maskWarning>>2, nil},
+		{"all clear", 0, nil},
+		{"load warning again", maskWarning >> 2, []string{"warning load"}},
+	}
+	for _, tt := range tests {
+		s.regs[regMask] = tt.mask
+		_, alerts, err := i.Read()
+		if err != nil {
+			t.Fatalf("%s: Read() error = %v", tt.name, err)
+		}
+		var got []string
+		for _, a := range alerts {
+			got = append(got, a.Event+" "+a.Channel)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: alerts got (%v) want (%v)", tt.name, got, tt.want)
+			continue
+		}
+		for n := range got {
+			if got[n] != tt.want[n] {
+				t.Errorf("%s: alerts got (%v) want (%v)", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	i := New("power", TestI2CBus, Address)
+	if err := i.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	r, _, err := i.Read()
+	if err != nil || len(r) != Channels || r["ch2"] == nil {
+		t.Errorf("Read() got (%v, %v) want ch1 to ch3", r, err)
+	}
+	if err := i.ReadPub(); err != nil {
+		t.Errorf("ReadPub() error = %v", err)
+	}
+}
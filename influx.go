@@ -0,0 +1,522 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LineSuffix ends the topics of the line protocol payloads
+const LineSuffix = "/influx"
+
+// LineEncoder encodes the payloads in InfluxDB line protocol, for a
+// consumer writing them to Influx as they are. The tags are the
+// station, the device and its labels, the fields the values of the
+// payload, and the time is the one of the Envelope, else of the
+// publish, in nanoseconds.
+//
+// The numbers and the strings that are numbers go as floats, so a
+// field keeps its type when a reading has no decimals. Other strings
+// and bools go as they are. The fields of an object inside the
+// payload are named by the key of the object and theirs, like
+// "wind_speed", but the values of a Reading go by their own names
+// without its units. A payload that is not an object is the field
+// "value". A payload a Buffer replays has the field replay=true.
+type LineEncoder struct {
+	// Measurement is the template of the measurement, taking
+	// {station}, {device} and the labels of the device by name like
+	// {room}. The device name when it is empty.
+	Measurement string
+
+	// Tags are added to the tags of every line, the labels of the
+	// device take over the ones of the same name
+	Tags map[string]string
+}
+
+// Line is the LineEncoder with the devices as the measurements
+var Line Encoder = LineEncoder{}
+
+// Encode encodes data as a line of the device of env, with no device
+// tag when env is nil
+func (e LineEncoder) Encode(data any, env *Envelope) ([]byte, error) {
+	var name string
+	if env != nil {
+		name = env.Device
+	}
+	return e.encode(name, nil, data, env)
+}
+
+// Suffix is LineSuffix
+func (LineEncoder) Suffix() string {
+	return LineSuffix
+}
+
+func (e LineEncoder) encodeDevice(d *Device, data any, env *Envelope) ([]byte, error) {
+	return e.encode(d.Name, d.Labels(), data, env)
+}
+
+func (e LineEncoder) encode(name string, labels map[string]string, data any, env *Envelope) ([]byte, error) {
+	payload, err := textPayload(data)
+	if err != nil {
+		return nil, err
+	}
+	ts := time.Now()
+	if env != nil {
+		if t, err := time.Parse(time.RFC3339Nano, env.TS); err == nil {
+			ts = t
+		}
+	}
+	line, err := e.line(name, labels, payload, ts)
+	if err != nil || env == nil || !env.Replay {
+		return line, err
+	}
+	// the last field, before the timestamp
+	i := bytes.LastIndexByte(line, ' ')
+	return slices.Insert(line, i, []byte(",replay=true")...), nil
+}
+
+// line makes the line of payload, published by device name at ts
+func (e LineEncoder) line(name string, labels map[string]string, payload []byte, ts time.Time) ([]byte, error) {
+	tags := maps.Clone(e.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	maps.Copy(tags, labels)
+	tags["station"] = Station()
+	if name != "" {
+		tags["device"] = name
+	}
+
+	fields := make(map[string]any)
+	var v any = string(payload)
+	if json.Valid(payload) {
+		json.Unmarshal(payload, &v)
+	}
+	lineFields(fields, "", v)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: no fields in %q", ErrEncoding, payload)
+	}
+
+	b := []byte(escapeMeasurement(e.measurement(tags)))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if k == "" || tags[k] == "" {
+			continue // no empty keys or values in line protocol
+		}
+		b = append(b, ',')
+		b = append(b, escapeKey(k)...)
+		b = append(b, '=')
+		b = append(b, escapeKey(tags[k])...)
+	}
+	for i, k := range slices.Sorted(maps.Keys(fields)) {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, escapeKey(k)...)
+		b = append(b, '=')
+		b = appendField(b, fields[k])
+	}
+	b = append(b, ' ')
+	return strconv.AppendInt(b, ts.UnixNano(), 10), nil
+}
+
+// measurement resolves the Measurement of tags, a placeholder of no
+// tag is left out and the device name is taken when nothing is left
+func (e LineEncoder) measurement(tags map[string]string) string {
+	m := e.Measurement
+	if m == "" {
+		m = "{device}"
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(m, '{')
+		j := strings.IndexByte(m[max(i, 0):], '}')
+		if i < 0 || j < 0 {
+			b.WriteString(m)
+			break
+		}
+		b.WriteString(m[:i])
+		b.WriteString(tags[m[i+1:i+j]])
+		m = m[i+j+1:]
+	}
+	if b.Len() > 0 {
+		return b.String()
+	}
+	if tags["device"] != "" {
+		return tags["device"]
+	}
+	return "device"
+}
+
+// lineFields adds the fields of v named by prefix to fields
+func lineFields(fields map[string]any, prefix string, v any) {
+	name := prefix
+	if name == "" {
+		name = "value"
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			switch {
+			case k == "values" && isObject(x):
+				lineFields(fields, prefix, x) // the values of a Reading
+			case k == "units" && isObject(x):
+			case prefix == "":
+				lineFields(fields, k, x)
+			default:
+				lineFields(fields, prefix+"_"+k, x)
+			}
+		}
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			fields[name] = v
+		}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			fields[name] = f
+		} else {
+			fields[name] = v
+		}
+	case bool:
+		fields[name] = v
+	}
+}
+
+func isObject(v any) bool {
+	_, ok := v.(map[string]any)
+	return ok
+}
+
+func appendField(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case float64:
+		return strconv.AppendFloat(b, v, 'g', -1, 64)
+	case bool:
+		return strconv.AppendBool(b, v)
+	default:
+		b = append(b, '"')
+		b = append(b, fieldEscaper.Replace(fmt.Sprint(v))...)
+		return append(b, '"')
+	}
+}
+
+// The escaping of the line protocol: the measurement escapes commas and
+// spaces, the tag keys and values and the field keys commas, equal
+// signs and spaces, the string fields double quotes. Every one escapes
+// backslashes, a backslash ending a key would escape what follows it.
+// Newlines can not be escaped, they go as spaces but in string fields.
+var (
+	measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+	keyEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+	fieldEscaper       = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+func escapeMeasurement(s string) string {
+	return measurementEscaper.Replace(s)
+}
+
+func escapeKey(s string) string {
+	return keyEscaper.Replace(s)
+}
+
+// ErrInflux is returned by an InfluxWriter for the lines Influx did
+// not take
+var ErrInflux = errors.New("influx write failed")
+
+// InfluxWriter writes lines to the write API of InfluxDB 2, batched.
+// A batch is written when it is full or every interval, a write failed
+// for the network or the server is retried with a backoff doubling
+// every try. The lines of a batch failed for good are dropped and
+// counted in the InfluxStats.
+type InfluxWriter struct {
+	url     string
+	org     string
+	bucket  string
+	token   string
+	size    int
+	every   time.Duration
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	clock   Clock
+
+	mu    sync.Mutex
+	lines [][]byte
+	stats InfluxStats
+	full  chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// InfluxStats counts the lines of an InfluxWriter
+type InfluxStats struct {
+	Written int `json:"written"`
+	Dropped int `json:"dropped"`
+	Retries int `json:"retries"`
+}
+
+// InfluxOption configures an InfluxWriter
+type InfluxOption func(*InfluxWriter)
+
+// InfluxOrg sets the organization written to
+func InfluxOrg(org string) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.org = org
+	}
+}
+
+// InfluxBucket sets the bucket written to
+func InfluxBucket(bucket string) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.bucket = bucket
+	}
+}
+
+// InfluxToken sets the API token the writes are made with
+func InfluxToken(token string) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.token = token
+	}
+}
+
+// InfluxBatch writes the lines by size of them, or every interval
+// when fewer come, 500 and 10 seconds unless it is given
+func InfluxBatch(size int, every time.Duration) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.size = size
+		w.every = every
+	}
+}
+
+// InfluxRetry retries a failed write up to retries times, waiting
+// backoff before the first, 3 and a second unless it is given
+func InfluxRetry(retries int, backoff time.Duration) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.retries = retries
+		w.backoff = backoff
+	}
+}
+
+// InfluxClient sets the HTTP client of the writes
+func InfluxClient(c *http.Client) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.client = c
+	}
+}
+
+// InfluxClock sets the clock of the interval and the backoff, the
+// DefaultClock unless it is given
+func InfluxClock(c Clock) InfluxOption {
+	return func(w *InfluxWriter) {
+		w.clock = c
+	}
+}
+
+// NewInfluxWriter creates a writer to the Influx at url, like
+// "http://localhost:8086", and starts writing. Close it to write the
+// lines left.
+func NewInfluxWriter(url string, opts ...InfluxOption) *InfluxWriter {
+	w := &InfluxWriter{
+		url:     strings.TrimSuffix(url, "/"),
+		size:    500,
+		every:   10 * time.Second,
+		retries: 3,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		full:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.size = max(w.size, 1)
+	if w.clock == nil {
+		w.clock = DefaultClock()
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+func (w *InfluxWriter) run() {
+	defer w.wg.Done()
+	var tick <-chan time.Time
+	if w.every > 0 {
+		ticker := w.clock.NewTicker(w.every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.full:
+		case <-tick:
+		}
+		if err := w.Flush(); err != nil {
+			slog.Error("influx write", "url", w.url, "error", err)
+		}
+	}
+}
+
+// Write queues a line, or lines ended by newlines, for the next batch
+func (w *InfluxWriter) Write(line []byte) {
+	w.mu.Lock()
+	w.lines = append(w.lines, bytes.TrimRight(line, "\n"))
+	full := len(w.lines) >= w.size
+	w.mu.Unlock()
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes the lines queued, a batch at a time
+func (w *InfluxWriter) Flush() error {
+	var errs []error
+	for {
+		w.mu.Lock()
+		n := min(len(w.lines), w.size)
+		batch := w.lines[:n:n]
+		w.lines = w.lines[n:]
+		w.mu.Unlock()
+		if n == 0 {
+			return errors.Join(errs...)
+		}
+
+		err := w.write(bytes.Join(batch, []byte("\n")))
+		w.mu.Lock()
+		if err != nil {
+			w.stats.Dropped += n
+			errs = append(errs, err)
+		} else {
+			w.stats.Written += n
+		}
+		w.mu.Unlock()
+	}
+}
+
+// write posts body, retrying the failures that are not of the lines
+func (w *InfluxWriter) write(body []byte) error {
+	q := url.Values{"org": {w.org}, "bucket": {w.bucket}, "precision": {"ns"}}
+	u := w.url + "/api/v2/write?" + q.Encode()
+
+	wait := w.backoff
+	for try := 0; ; try++ {
+		retry, err := w.post(u, body)
+		if err == nil || !retry || try >= w.retries {
+			return err
+		}
+		w.mu.Lock()
+		w.stats.Retries++
+		w.mu.Unlock()
+		select {
+		case <-w.clock.After(wait):
+		case <-w.done:
+			return err
+		}
+		wait *= 2
+	}
+}
+
+// post posts body to u, it reports if a failure is worth retrying
+func (w *InfluxWriter) post(u string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInflux, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("%w: %v", ErrInflux, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%w: %s: %s", ErrInflux, resp.Status, bytes.TrimSpace(msg))
+}
+
+// Stats returns the counts of the lines
+func (w *InfluxWriter) Stats() InfluxStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Follow writes the readings and the states of the devices of dm, as
+// the lines e encodes, until the func returned is called. The
+// Envelopes are not needed, the lines are timed by the events.
+func (w *InfluxWriter) Follow(dm *DeviceManager, e LineEncoder) func() {
+	events, unsubscribe := SubscribeEvents(streamBuffer)
+	stopped := make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-stopped:
+				return
+			case ev := <-events:
+				if ev.Type != EventReading && ev.Type != EventState {
+					continue
+				}
+				var labels map[string]string
+				if d, ok := dm.Get(ev.Device); ok {
+					if l, ok := d.(interface{ Labels() map[string]string }); ok {
+						labels = l.Labels()
+					}
+				}
+				line, err := e.line(ev.Device, labels, ev.Data, ev.Time)
+				if err != nil {
+					slog.Debug("influx line", "device", ev.Device, "error", err)
+					continue
+				}
+				w.Write(line)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
+
+// Close stops the writer and writes the lines left, without retrying
+// a failure, a station shutting down does not wait on Influx
+func (w *InfluxWriter) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	w.wg.Wait()
+	return w.Flush()
+}
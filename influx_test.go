@@ -0,0 +1,348 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestLineEncoder(t *testing.T) {
+	env := &Envelope{Schema: SchemaVersion, TS: "2026-10-15T12:00:00.5Z", Device: "bme280", Seq: 1}
+	ts := " 1792065600500000000"
+
+	tests := []struct {
+		enc  LineEncoder
+		data any
+		env  *Envelope
+		want string
+	}{
+		{Line.(LineEncoder), Reading{Values: map[string]float64{"temperature": 21.46, "humidity": 40}, Units: map[string]string{"temperature": "C"}}, env,
+			"bme280,device=bme280,station=station humidity=40,temperature=21.46" + ts},
+		{Line.(LineEncoder), []byte(`{"temperature":"77.15","humidity":"40.20"}`), env,
+			"bme280,device=bme280,station=station humidity=40.2,temperature=77.15" + ts},
+		{Line.(LineEncoder), "on", env, `bme280,device=bme280,station=station value="on"` + ts},
+		{Line.(LineEncoder), &BinaryState{On: true}, env, "bme280,device=bme280,station=station on=true" + ts},
+		{Line.(LineEncoder), 21.456, env, "bme280,device=bme280,station=station value=21.46" + ts},
+		{Line.(LineEncoder), map[string]any{"wind": map[string]any{"speed": 3.5, "dir": "NW"}}, env,
+			`bme280,device=bme280,station=station wind_dir="NW",wind_speed=3.5` + ts},
+		{LineEncoder{Measurement: "{station}.env", Tags: map[string]string{"site": "home"}}, 1e21, env,
+			"station.env,device=bme280,site=home,station=station value=1e+21" + ts},
+		{LineEncoder{Measurement: "{room}"}, 7, env, "bme280,device=bme280,station=station value=7" + ts},
+		{LineEncoder{Measurement: "env_{room}{device"}, 7, env, "env_{device,device=bme280,station=station value=7" + ts},
+	}
+	for _, tt := range tests {
+		got, err := tt.enc.Encode(tt.data, tt.env)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Encode(%v) got (%s, %v) want (%s)", tt.data, got, err, tt.want)
+		}
+	}
+
+	if _, err := Line.Encode(nil, env); !errors.Is(err, ErrEncoding) {
+		t.Errorf("Encode(nil) error got (%v) want (%v)", err, ErrEncoding)
+	}
+}
+
+func TestLineEncoderDevice(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("bme280", "mqtt", WithMessanger(m),
+		WithLabels(map[string]string{"room": "living room", "floor": "1"}),
+		WithEncoder(LineEncoder{Measurement: "{room}", Tags: map[string]string{"room": "none", "site": "home"}}))
+	before := time.Now()
+	d.PubData(Reading{Values: map[string]float64{"temperature": 21.5}})
+
+	msgs := m.Messages("#")
+	if len(msgs) != 1 || msgs[0].Topic != d.DataTopic()+LineSuffix {
+		t.Fatalf("published got (%v) want one on (%s)", msgs, d.DataTopic()+LineSuffix)
+	}
+	line := string(msgs[0].Data)
+	want := `living\ room,device=bme280,floor=1,room=living\ room,site=home,station=station temperature=21.5 `
+	if !strings.HasPrefix(line, want) {
+		t.Fatalf("line got (%s) want (%s...)", line, want)
+	}
+	ns, err := strconv.ParseInt(strings.TrimPrefix(line, want), 10, 64)
+	if err != nil || time.Unix(0, ns).Before(before) {
+		t.Errorf("line timestamp got (%s, %v) want the publish time", line, err)
+	}
+}
+
+func TestLineEscaping(t *testing.T) {
+	tests := []struct {
+		m, key, value, field, str string
+		want                      string
+	}{
+		{"cpu load", "a,b", "c=d", "e f", `say "hi"`,
+			`cpu\ load,a\,b=c\=d,device=d,m=cpu\ load,station=station e\ f="say \"hi\"" 1`},
+		{"a=b,c", "k", `back\slash`, "f=1", `back\slash`,
+			`a=b\,c,device=d,k=back\\slash,m=a\=b\,c,station=station f\=1="back\\slash" 1`},
+		{`end\`, "k", `end\`, "f", `end\`,
+			`end\\,device=d,k=end\\,m=end\\,station=station f="end\\" 1`},
+		{"new\nline", "k", "new\nline", "f", "new\nline",
+			"new\\ line,device=d,k=new\\ line,m=new\\ line,station=station f=\"new\nline\" 1"},
+		{"m", "empty", "", "f", "", `m,device=d,m=m,station=station f="" 1`},
+	}
+	for _, tt := range tests {
+		labels := map[string]string{"m": tt.m, tt.key: tt.value}
+		got, err := LineEncoder{Measurement: "{m}"}.line("d", labels, jsonObject(tt.field, tt.str), time.Unix(0, 1))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("line(%q) got (%s, %v) want (%s)", tt.m, got, err, tt.want)
+		}
+	}
+}
+
+// jsonObject is the JSON of an object of the string field f
+func jsonObject(f, s string) []byte {
+	j, _ := json.Marshal(map[string]string{f: s})
+	return j
+}
+
+// parsedLine is a line as an Influx reads it
+type parsedLine struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]string
+	ts          string
+}
+
+// parseLine parses a line of string fields by the rules of the line
+// protocol, a backslash escapes the characters that can be escaped
+// where it is and is itself else
+func parseLine(line string) (parsedLine, bool) {
+	p := parsedLine{tags: make(map[string]string), fields: make(map[string]string)}
+	i := 0
+	token := func(stops, escapes string) string {
+		var b strings.Builder
+		for i < len(line) {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) && strings.IndexByte(escapes, line[i+1]) >= 0 {
+				b.WriteByte(line[i+1])
+				i += 2
+				continue
+			}
+			if strings.IndexByte(stops, c) >= 0 {
+				break
+			}
+			b.WriteByte(c)
+			i++
+		}
+		return b.String()
+	}
+
+	p.measurement = token(", ", `, \`)
+	for i < len(line) && line[i] == ',' {
+		i++
+		k := token("=", `,= \`)
+		i++
+		p.tags[k] = token(", ", `,= \`)
+	}
+	if i >= len(line) || line[i] != ' ' {
+		return p, false
+	}
+	for i < len(line) && (len(p.fields) == 0 || line[i] == ',') {
+		i++
+		k := token("=", `,= \`)
+		i++
+		if i < len(line) && line[i] == '"' {
+			i++
+			p.fields[k] = token(`"`, `"\`)
+			i++
+		} else {
+			p.fields[k] = token(", ", "")
+		}
+	}
+	if i < len(line) && line[i] == ' ' {
+		i++
+		p.ts = line[i:]
+	}
+	return p, p.ts != ""
+}
+
+func FuzzLineEscaping(f *testing.F) {
+	f.Add("cpu", "host", "a", "f", "v")
+	f.Add("cpu load", "a,b", "c=d", "e f", `say "hi"`)
+	f.Add(`x\`, `k\`, `v\`, `f\`, `s\`)
+	f.Add(`\,\ \=`, `\\`, `=,`, "\n", "\"\n\\")
+	f.Fuzz(func(t *testing.T, m, key, value, field, str string) {
+		for _, s := range []string{m, key, value, field, str} {
+			if !utf8.ValidString(s) {
+				t.Skip()
+			}
+		}
+		if strings.ContainsAny(m+key+value+field, "{}\n\r") || m == "" || key == "" || value == "" || field == "" ||
+			key == "m" || key == "device" || key == "station" || field == "values" || field == "units" {
+			t.Skip()
+		}
+		labels := map[string]string{"m": m, key: value}
+		line, err := LineEncoder{Measurement: "{m}"}.line("d", labels, jsonObject(field, str), time.Unix(0, 1))
+		if err != nil {
+			t.Fatalf("line() error (%v)", err)
+		}
+		p, ok := parseLine(string(line))
+		if !ok || p.measurement != m || p.tags[key] != value || p.tags["device"] != "d" || p.ts != "1" {
+			t.Fatalf("line (%s) parsed (%+v) want (%q %q=%q)", line, p, m, key, value)
+		}
+		if _, err := strconv.ParseFloat(str, 64); err == nil {
+			return // a number, a float field
+		}
+		if got := p.fields[field]; got != str {
+			t.Fatalf("line (%s) field (%q) got (%q) want (%q)", line, field, got, str)
+		}
+	})
+}
+
+// influx is a fake of the write API of Influx, answering the writes
+// with the codes in turn and 204 after them
+type influx struct {
+	mu     sync.Mutex
+	codes  []int
+	writes []*http.Request
+	bodies []string
+}
+
+func (f *influx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, r)
+	f.bodies = append(f.bodies, string(body))
+	code := http.StatusNoContent
+	if len(f.codes) > 0 {
+		code, f.codes = f.codes[0], f.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func (f *influx) lines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.bodies...)
+}
+
+// waitFor waits for ok to be true, the writes are made in the
+// background
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !ok(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+	}
+}
+
+func TestInfluxWriter(t *testing.T) {
+	fake := &influx{codes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w := NewInfluxWriter(srv.URL+"/", InfluxOrg("home"), InfluxBucket("sensors"), InfluxToken("secret"),
+		InfluxBatch(2, time.Hour), InfluxRetry(2, time.Millisecond))
+	w.Write([]byte("a value=1 1\n"))
+	w.Write([]byte("b value=2 2"))
+	waitFor(t, func() bool { return w.Stats().Written == 2 })
+	w.Write([]byte("c value=3 3"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error (%v)", err)
+	}
+
+	bodies := fake.lines()
+	want := []string{"a value=1 1\nb value=2 2", "a value=1 1\nb value=2 2", "a value=1 1\nb value=2 2", "c value=3 3"}
+	if strings.Join(bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("writes got (%q) want (%q)", bodies, want)
+	}
+	r := fake.writes[0]
+	if r.URL.Path != "/api/v2/write" || r.Method != http.MethodPost {
+		t.Errorf("write got (%s %s)", r.Method, r.URL.Path)
+	}
+	if q := r.URL.Query(); q.Get("org") != "home" || q.Get("bucket") != "sensors" || q.Get("precision") != "ns" {
+		t.Errorf("write query got (%s)", r.URL.RawQuery)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "Token secret" {
+		t.Errorf("write Authorization got (%s)", auth)
+	}
+	if got := w.Stats(); got != (InfluxStats{Written: 3, Retries: 2}) {
+		t.Errorf("Stats() got (%+v)", got)
+	}
+}
+
+func TestInfluxWriterFailures(t *testing.T) {
+	fake := &influx{codes: []int{http.StatusBadRequest, 500, 500, 500}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w := NewInfluxWriter(srv.URL, InfluxBatch(10, time.Hour), InfluxRetry(2, time.Millisecond))
+	defer w.Close()
+	w.Write([]byte("bad"))
+	if err := w.Flush(); !errors.Is(err, ErrInflux) || len(fake.lines()) != 1 {
+		t.Errorf("Flush(bad) got (%v) after (%d) writes want (%v) and no retry", err, len(fake.lines()), ErrInflux)
+	}
+	w.Write([]byte("a value=1 1"))
+	if err := w.Flush(); !errors.Is(err, ErrInflux) || len(fake.lines()) != 4 {
+		t.Errorf("Flush(down) got (%v) after (%d) writes want (%v) after 4", err, len(fake.lines()), ErrInflux)
+	}
+	if got := w.Stats(); got != (InfluxStats{Dropped: 2, Retries: 2}) {
+		t.Errorf("Stats() got (%+v)", got)
+	}
+}
+
+func TestInfluxWriterFollow(t *testing.T) {
+	fake := &influx{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	dm := GetDeviceManager()
+	defer dm.Clear()
+
+	w := NewInfluxWriter(srv.URL, InfluxBatch(2, time.Hour))
+	stop := w.Follow(dm, LineEncoder{Measurement: "{room}"})
+	defer stop()
+
+	m := NewMemMessanger()
+	d := NewDevice("lux", "mqtt", WithMessanger(m), WithLabels(map[string]string{"room": "hall"}))
+	dm.Add(named{d})
+	d.PubData(120.5)
+	d.PubState("on")
+
+	waitFor(t, func() bool { return w.Stats().Written == 2 })
+	w.Close()
+
+	lines := strings.Split(strings.Join(fake.lines(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "hall,device=lux,room=hall,station=station value=120.5 ") ||
+		!strings.HasPrefix(lines[1], `hall,device=lux,room=hall,station=station value="on" `) {
+		t.Errorf("lines got (%q)", lines)
+	}
+}
+
+func TestInfluxWriterClock(t *testing.T) {
+	fake := &influx{codes: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	clock := NewSimClock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	// no tick within the backoff, the writer does not take it then
+	w := NewInfluxWriter(srv.URL, InfluxBatch(10, 2*time.Hour), InfluxRetry(1, time.Hour), InfluxClock(clock))
+	defer w.Close()
+	w.Write([]byte("a value=1 1"))
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+
+	// the interval and the backoff go by the clock, not waited for
+	clock.Advance(2 * time.Hour)
+	waitFor(t, func() bool { return w.Stats().Retries == 1 && clock.Waiters() == 2 })
+	if n := len(fake.lines()); n != 1 {
+		t.Fatalf("writes before the backoff got (%d) want (1)", n)
+	}
+	clock.Advance(time.Hour - time.Second)
+	if n := len(fake.lines()); n != 1 {
+		t.Fatalf("writes within the backoff got (%d) want (1)", n)
+	}
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return w.Stats().Written == 1 })
+	if got := fake.lines(); len(got) != 2 || got[1] != "a value=1 1" {
+		t.Errorf("writes got (%q) want the line retried", got)
+	}
+}
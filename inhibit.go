@@ -0,0 +1,53 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInhibited is a command held back by an interlock, like a relay
+// switched on while the limit switch guarding it is tripped
+var ErrInhibited = errors.New("inhibited")
+
+// Inhibit holds back the commands that drive the device, the ones its
+// Router marks Inhibitable, while on. by is what inhibits it, like the
+// name of a tripped limit switch, the commands go through again once
+// nothing does. The device is not stopped, what inhibits it stops it.
+func (d *Device) Inhibit(by string, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := slices.Index(d.inhibits, by)
+	switch {
+	case on && i < 0:
+		d.inhibits = append(d.inhibits, by)
+	case !on && i >= 0:
+		d.inhibits = slices.Delete(d.inhibits, i, i+1)
+	}
+}
+
+// Inhibited returns an ErrInhibited naming what inhibits the device,
+// nil when nothing does
+func (d *Device) Inhibited() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.inhibits) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s by %s", ErrInhibited, d.Name, strings.Join(d.inhibits, ", "))
+}
+
+// Inhibitable marks the commands of names as ones that drive the
+// device, nacked with ErrInhibited while it is inhibited. The ones
+// stopping it, like "off", are not.
+func (r *Router) Inhibitable(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inhibitable == nil {
+		r.inhibitable = make(map[string]bool)
+	}
+	for _, name := range names {
+		r.inhibitable[strings.ToLower(name)] = true
+	}
+}
@@ -0,0 +1,57 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInhibit(t *testing.T) {
+	m := NewMemMessanger()
+	rt, d, executed := router(t, m)
+	rt.Handle("off", func(cmd *Command) error {
+		*executed = append(*executed, cmd.Cmd)
+		return nil
+	})
+	rt.Inhibitable("ON")
+	send := func(id, cmd string) Ack {
+		t.Helper()
+		m.Reset()
+		m.PublishQoS(d.ControlTopic(), []byte(`{"id":"`+id+`","cmd":"`+cmd+`"}`), AtLeastOnce, false)
+		got := acks(t, m, d)
+		if len(got) != 1 {
+			t.Fatalf("%s acks got (%+v) want 1", cmd, got)
+		}
+		return got[0]
+	}
+
+	if err := d.Inhibited(); err != nil {
+		t.Errorf("Inhibited() got (%v) want nil", err)
+	}
+	d.Inhibit("x-min", true)
+	d.Inhibit("x-max", true)
+	d.Inhibit("x-min", true)
+	err := d.Inhibited()
+	if !errors.Is(err, ErrInhibited) || !strings.HasSuffix(err.Error(), "by x-min, x-max") {
+		t.Errorf("Inhibited() got (%v) want by x-min, x-max", err)
+	}
+
+	// driving it is nacked, stopping it is not
+	if ack := send("1", "on"); ack.OK || !strings.Contains(ack.Error, "inhibited") {
+		t.Errorf("on inhibited ack got (%+v) want a nack", ack)
+	}
+	if ack := send("2", "off"); !ack.OK {
+		t.Errorf("off inhibited ack got (%+v) want ok", ack)
+	}
+	d.Inhibit("x-min", false)
+	if ack := send("3", "on"); ack.OK {
+		t.Errorf("on inhibited by x-max ack got (%+v) want a nack", ack)
+	}
+	d.Inhibit("x-max", false)
+	if ack := send("4", "on"); !ack.OK {
+		t.Errorf("on let go ack got (%+v) want ok", ack)
+	}
+	if strings.Join(*executed, ",") != "off,on" {
+		t.Errorf("executed got (%q) want ([off on])", *executed)
+	}
+}
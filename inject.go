@@ -0,0 +1,159 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// ErrNotMock is the error of the mock command of a device that is not
+// mocked, a demo must not set the value of a real sensor
+var ErrNotMock = errors.New("not in mock mode")
+
+// injection is the value injected in a field of the mock reads, the
+// value read or an offset of the one the device made up
+type injection struct {
+	set    bool
+	value  float64
+	offset float64
+}
+
+// SetMockFields sets the fields mock values can be injected in, the
+// names of its package like "temperature", the ones a mock command
+// can name
+func (d *Device) SetMockFields(fields ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fields = nil
+	for _, f := range fields {
+		d.fields = append(d.fields, strings.ToLower(f))
+	}
+}
+
+// InjectMock has the mock reads of the field take v, until reverted
+func (d *Device) InjectMock(field string, v float64) {
+	d.inject(field, func(in *injection) {
+		in.set, in.value, in.offset = true, v, 0
+	})
+	slog.Info("mock value injected", "device", d.Name, "field", field, "value", v)
+}
+
+// InjectMockDelta adds delta to the value injected in the field, or to
+// the values the device makes up without one
+func (d *Device) InjectMockDelta(field string, delta float64) {
+	d.inject(field, func(in *injection) {
+		if in.set {
+			in.value += delta
+		} else {
+			in.offset += delta
+		}
+	})
+	slog.Info("mock value injected", "device", d.Name, "field", field, "delta", delta)
+}
+
+func (d *Device) inject(field string, fn func(*injection)) {
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.injected == nil {
+		d.injected = make(map[string]*injection)
+	}
+	field = strings.ToLower(field)
+	in, ok := d.injected[field]
+	if !ok {
+		in = &injection{}
+		d.injected[field] = in
+	}
+	fn(in)
+}
+
+// RevertMock removes the values injected in the fields, the mock
+// reads back to their generator or random values. No fields revert
+// them all.
+func (d *Device) RevertMock(fields ...string) {
+	d.mu.Lock()
+	if len(fields) == 0 {
+		d.injected = nil
+	}
+	for _, f := range fields {
+		delete(d.injected, strings.ToLower(f))
+	}
+	d.mu.Unlock()
+	slog.Info("mock values reverted", "device", d.Name, "fields", fields)
+}
+
+// MockField returns the value of the field of a mock read, v the one
+// the device made up, with what was injected in it. Its package calls
+// it for every field of its mock reads.
+func (d *Device) MockField(field string, v float64) float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	in, ok := d.injected[strings.ToLower(field)]
+	switch {
+	case !ok:
+		return v
+	case in.set:
+		return in.value
+	default:
+		return v + in.offset
+	}
+}
+
+// MockInjected reports if values are injected in the mock reads of
+// the device, its payloads flagged "mocked" for nobody to take demo
+// data for real
+func (d *Device) MockInjected() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.injected) > 0
+}
+
+// mockCommand injects the values of cmd, the args a field a value
+// like {"temperature":31.2}, a delta like {"temperature":{"delta":-2}}
+// or null reverting it. A mock command without args reverts them
+// all. It fails with ErrNotMock out of mock mode.
+func (d *Device) mockCommand(cmd *Command) error {
+	if !IsMock() {
+		return fmt.Errorf("%w: %s", ErrNotMock, d.Name)
+	}
+	if len(cmd.Args) == 0 || string(cmd.Args) == "null" {
+		d.RevertMock()
+		return nil
+	}
+	var args map[string]json.RawMessage
+	if err := cmd.Bind(&args); err != nil {
+		return err
+	}
+
+	// check them all before any is injected
+	d.mu.RLock()
+	fields := d.fields
+	d.mu.RUnlock()
+	apply := make([]func(), 0, len(args))
+	for field, arg := range args {
+		if !slices.Contains(fields, strings.ToLower(field)) {
+			return fmt.Errorf("%w: %s has no mock field %q", ErrCommand, d.Name, field)
+		}
+		var v float64
+		var delta struct {
+			Delta *float64 `json:"delta"`
+		}
+		switch {
+		case string(arg) == "null":
+			apply = append(apply, func() { d.RevertMock(field) })
+		case json.Unmarshal(arg, &v) == nil:
+			apply = append(apply, func() { d.InjectMock(field, v) })
+		case json.Unmarshal(arg, &delta) == nil && delta.Delta != nil:
+			apply = append(apply, func() { d.InjectMockDelta(field, *delta.Delta) })
+		default:
+			return fmt.Errorf("%w: mock %s: %s is not a value or a delta", ErrCommand, field, arg)
+		}
+	}
+	for _, fn := range apply {
+		fn()
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInjectMock(t *testing.T) {
+	d := NewDevice("bme", "mqtt")
+	if d.MockField("temperature", 20) != 20 || d.MockInjected() {
+		t.Error("MockField() with nothing injected changed the value")
+	}
+
+	d.InjectMockDelta("Temperature", 1.5)
+	if got := d.MockField("temperature", 20); got != 21.5 {
+		t.Errorf("MockField() with a delta got (%f) want (21.5)", got)
+	}
+	d.InjectMock("temperature", 31.2)
+	d.InjectMockDelta("temperature", -1.2)
+	if got := d.MockField("temperature", 20); got != 30 {
+		t.Errorf("MockField() with a value and a delta got (%f) want (30)", got)
+	}
+	if !d.MockInjected() {
+		t.Error("MockInjected() got false")
+	}
+
+	d.RevertMock("temperature")
+	if d.MockField("temperature", 20) != 20 || d.MockInjected() {
+		t.Error("MockField() reverted changed the value")
+	}
+}
+
+func TestMockCommand(t *testing.T) {
+	defer Mock(IsMock())
+	m := NewMemMessanger()
+	_, d, _ := router(t, m)
+	d.SetMockFields("temperature", "humidity")
+
+	command := func(payload string) Ack {
+		t.Helper()
+		m.Reset()
+		m.PublishQoS(d.ControlTopic(), []byte(payload), AtLeastOnce, false)
+		got := acks(t, m, d)
+		if len(got) != 1 {
+			t.Fatalf("%s acks got (%+v) want 1", payload, got)
+		}
+		return got[0]
+	}
+
+	Mock(false)
+	ack := command(`{"id":"1","mock":{"temperature":31.2}}`)
+	if ack.OK || !strings.Contains(ack.Error, ErrNotMock.Error()) {
+		t.Errorf("mock command out of mock mode got (%+v) want %v", ack, ErrNotMock)
+	}
+	if d.MockInjected() {
+		t.Error("mock command out of mock mode injected")
+	}
+
+	Mock(true)
+	if ack := command(`{"id":"2","mock":{"temperature":31.2,"humidity":{"delta":-5}}}`); !ack.OK {
+		t.Fatalf("mock command got (%+v)", ack)
+	}
+	if got := d.MockField("temperature", 20); got != 31.2 {
+		t.Errorf("temperature got (%f) want (31.2)", got)
+	}
+	if got := d.MockField("humidity", 50); got != 45 {
+		t.Errorf("humidity got (%f) want (45)", got)
+	}
+
+	// a bad field injects none of the others
+	for _, payload := range []string{
+		`{"id":"3","cmd":"mock","args":{"temperature":1,"wind":3}}`,
+		`{"id":"4","mock":{"temperature":"hot"}}`,
+		`{"id":"5","mock":{"temperature":{"by":1}}}`,
+	} {
+		if ack := command(payload); ack.OK || !strings.Contains(ack.Error, ErrCommand.Error()) {
+			t.Errorf("%s ack got (%+v) want a nack", payload, ack)
+		}
+	}
+	if got := d.MockField("temperature", 20); got != 31.2 {
+		t.Errorf("temperature after the nacks got (%f) want (31.2)", got)
+	}
+
+	if ack := command(`{"id":"6","mock":{"temperature":null}}`); !ack.OK {
+		t.Fatalf("mock command null got (%+v)", ack)
+	}
+	if got := d.MockField("temperature", 20); got != 20 {
+		t.Errorf("temperature reverted got (%f) want (20)", got)
+	}
+	if ack := command(`{"id":"7","mock":null}`); !ack.OK || d.MockInjected() {
+		t.Errorf("mock command reverting all got (%+v) injected (%t)", ack, d.MockInjected())
+	}
+}
+
+func TestParseCommandMock(t *testing.T) {
+	cmd, err := ParseCommand([]byte(`{"mock":{"temperature":31.2}}`))
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+	if cmd.Cmd != "mock" || string(cmd.Args) != `{"temperature":31.2}` {
+		t.Errorf("ParseCommand() got (%s, %s)", cmd.Cmd, cmd.Args)
+	}
+	if _, err := ParseCommand([]byte(`{"id":"1"}`)); !errors.Is(err, ErrCommand) {
+		t.Errorf("ParseCommand() without cmd error = %v want %v", err, ErrCommand)
+	}
+}
@@ -0,0 +1,514 @@
+// Package irrecv decodes the NEC protocol most cheap IR remotes use,
+// from a demodulated receiver like the TSOP38238 or VS1838B on a GPIO
+// line. The receiver pulls its output low during a burst of the
+// carrier, a mark, the marks and the spaces between them are timed
+// from the kernel timestamps of the edge events.
+//
+// Every key pressed is published as an Event like
+//
+//	{"proto":"nec","addr":"0x00","cmd":"0x45","repeat":false}
+//
+// and a key held down as repeat events, coalesced to one every repeat
+// interval. Spikes shorter than the glitch time are taken out before
+// the pulses are decoded.
+//
+// A code can be mapped to the command of a device in the device
+// manager, pressing the key sends that device the command. In learn
+// mode the codes without a mapping are recorded, with the number of
+// times they were seen, so the keys of a new remote can be found and
+// mapped later. The keymap and the learned codes are saved in the
+// device store.
+package irrecv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultGlitch is the longest spike taken for noise, the
+	// shortest NEC pulse is 562.5us
+	DefaultGlitch = 150 * time.Microsecond
+
+	// DefaultRepeatWindow is how long after a frame a repeat still
+	// counts for it, the repeats come every 108ms
+	DefaultRepeatWindow = 200 * time.Millisecond
+
+	// DefaultRepeatInterval publishes every other repeat
+	DefaultRepeatInterval = 200 * time.Millisecond
+)
+
+var (
+	ErrConfig  = errors.New("invalid ir receiver configuration")
+	ErrCode    = errors.New("invalid nec code")
+	ErrDevice  = errors.New("no device to command")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Commander is a device in the device manager a key can be mapped
+// to, it takes the same payloads as from its command topic
+type Commander interface {
+	Command(payload []byte) error
+}
+
+// Event is published for a key pressed, and for a key held down with
+// Repeat set and the number of repeats so far. Learned is set the
+// first time learn mode records the code.
+type Event struct {
+	Proto   string `json:"proto"`
+	Addr    string `json:"addr"`
+	Cmd     string `json:"cmd"`
+	Repeat  bool   `json:"repeat"`
+	Repeats int    `json:"repeats,omitempty"`
+	Learned bool   `json:"learned,omitempty"`
+}
+
+// Mapping sends Command to Device when its code is received, with
+// Repeat set on the repeats too
+type Mapping struct {
+	Device  string `json:"device"`
+	Command string `json:"command"`
+	Repeat  bool   `json:"repeat,omitempty"`
+}
+
+// Learned is a code recorded in learn mode
+type Learned struct {
+	Code  Code      `json:"code"`
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// Stats is what ReadPub publishes, the frames and repeat frames
+// decoded, the frames broken and the spikes taken out since New
+type Stats struct {
+	Frames   int    `json:"frames"`
+	Repeats  int    `json:"repeats"`
+	Errors   int    `json:"errors"`
+	Glitches int    `json:"glitches"`
+	Learning bool   `json:"learning"`
+	Last     *Event `json:"last,omitempty"`
+}
+
+// IRRecv is an IR receiver decoding NEC remotes
+type IRRecv struct {
+	*device.Device
+
+	pin    *drivers.DigitalPin
+	dec    decoder
+	rep    repeater
+	glitch time.Duration
+
+	// the edge before the last one, and the decoder before the
+	// last pulse, to take a spike back
+	started  bool
+	lastEdge time.Duration
+	prevEdge time.Duration
+	prev     decoder
+	undo     bool
+	fed      *frame // what the last pulse completed
+	fedErr   bool
+	redo     *frame // a frame taken back, it was handled already
+
+	stats    Stats
+	keymap   map[string]Mapping
+	learning bool
+	learned  map[string]*Learned
+	mockAt   time.Duration
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates an IR receiver on pin, any pin identifier
+// drivers.ResolvePinID understands. The keymap and the learned codes
+// saved for name are loaded.
+func New(name, pin string) (*IRRecv, error) {
+	r := &IRRecv{
+		Device:  device.NewDevice(name, "mqtt"),
+		dec:     decoder{tol: DefaultTolerance},
+		rep:     repeater{window: DefaultRepeatWindow, interval: DefaultRepeatInterval},
+		glitch:  DefaultGlitch,
+		keymap:  make(map[string]Mapping),
+		learned: make(map[string]*Learned),
+		now:     time.Now,
+	}
+	r.load(r.keymapKey(), &r.keymap)
+	r.load(r.learnedKey(), &r.learned)
+	if device.IsMock() {
+		return r, nil
+	}
+
+	p, err := drivers.NewDigitalPinID(name, pin,
+		drivers.WithOwner("irrecv"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			r.handle(evt.Type == gpiocdev.LineEventRisingEdge, evt.Timestamp)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.pin = p
+	r.mu.Unlock()
+	return r, nil
+}
+
+func (r *IRRecv) keymapKey() string  { return r.Device.Name + "/keymap" }
+func (r *IRRecv) learnedKey() string { return r.Device.Name + "/learned" }
+
+func (r *IRRecv) load(key string, v any) {
+	err := device.GetStore().Load(key, v)
+	if err != nil && !errors.Is(err, device.ErrNotStored) {
+		slog.Warn("irrecv loading", "device", r.Device.Name, "key", key, "error", err)
+	}
+}
+
+// Name returns the name of the device
+func (r *IRRecv) Name() string {
+	return r.Device.Name
+}
+
+// SetTolerance sets how far, as a fraction, a mark or space may be
+// off its length
+func (r *IRRecv) SetTolerance(tol float64) error {
+	if !(tol > 0 && tol < 1) {
+		return fmt.Errorf("%w: tolerance %v", ErrConfig, tol)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dec.tol = tol
+	return nil
+}
+
+// SetGlitch sets the longest spike taken for noise, 0 keeps them all
+func (r *IRRecv) SetGlitch(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.glitch = d
+}
+
+// SetRepeat sets how long after a frame a repeat still counts for
+// it, and how often the repeats of a key held down are published
+func (r *IRRecv) SetRepeat(window, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rep.window, r.rep.interval = window, interval
+}
+
+// Learn turns learn mode on or off
+func (r *IRRecv) Learn(on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.learning = on
+}
+
+// Learned returns the codes recorded in learn mode, the most seen
+// first
+func (r *IRRecv) Learned() []Learned {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := make([]Learned, 0, len(r.learned))
+	for _, v := range r.learned {
+		l = append(l, *v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Count != l[j].Count {
+			return l[i].Count > l[j].Count
+		}
+		return l[i].Code.String() < l[j].Code.String()
+	})
+	return l
+}
+
+// Forget drops the codes recorded in learn mode
+func (r *IRRecv) Forget() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.learned = make(map[string]*Learned)
+	return device.GetStore().Save(r.learnedKey(), r.learned)
+}
+
+// Map sends m.Command to the device m.Device in the device manager
+// whenever code is received. A learned code is dropped from the
+// learned codes.
+func (r *IRRecv) Map(code Code, m Mapping) error {
+	if m.Device == "" || m.Command == "" {
+		return fmt.Errorf("%w: mapping %s needs a device and a command", ErrConfig, code)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keymap[code.String()] = m
+	err := device.GetStore().Save(r.keymapKey(), r.keymap)
+	if _, ok := r.learned[code.String()]; ok {
+		delete(r.learned, code.String())
+		err = errors.Join(err, device.GetStore().Save(r.learnedKey(), r.learned))
+	}
+	return err
+}
+
+// Unmap drops the mapping of code
+func (r *IRRecv) Unmap(code Code) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keymap, code.String())
+	return device.GetStore().Save(r.keymapKey(), r.keymap)
+}
+
+// Keymap returns the mappings by code
+func (r *IRRecv) Keymap() map[string]Mapping {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]Mapping, len(r.keymap))
+	for k, v := range r.keymap {
+		m[k] = v
+	}
+	return m
+}
+
+// ParseCode parses an address and a command in hex, or decimal, an
+// address of more than two hex digits is extended NEC
+func ParseCode(addr, cmd string) (Code, error) {
+	a, err := strconv.ParseUint(addr, 0, 16)
+	if err != nil {
+		return Code{}, fmt.Errorf("%w: address %q", ErrCode, addr)
+	}
+	c, err := strconv.ParseUint(cmd, 0, 8)
+	if err != nil {
+		return Code{}, fmt.Errorf("%w: command %q", ErrCode, cmd)
+	}
+	hex := strings.TrimPrefix(strings.ToLower(addr), "0x")
+	ext := a > 0xff || (hex != addr && len(hex) > 2)
+	return Code{Addr: uint16(a), Cmd: uint8(c), Ext: ext}, nil
+}
+
+// Command handles a command payload: "learn on", "learn off",
+// "forget" to drop the learned codes, "map 0x00 0x45 porch on" to
+// send "on" to the device porch on that code and "unmap 0x00 0x45"
+func (r *IRRecv) Command(payload []byte) error {
+	c := strings.Fields(string(payload))
+	if len(c) == 0 {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+	switch verb := strings.ToLower(c[0]); {
+	case verb == "learn" && len(c) == 2 && (c[1] == "on" || c[1] == "off"):
+		r.Learn(c[1] == "on")
+		return nil
+	case verb == "forget" && len(c) == 1:
+		return r.Forget()
+	case verb == "map" && len(c) >= 5:
+		code, err := ParseCode(c[1], c[2])
+		if err != nil {
+			return err
+		}
+		return r.Map(code, Mapping{Device: c[3], Command: strings.Join(c[4:], " ")})
+	case verb == "unmap" && len(c) == 3:
+		code, err := ParseCode(c[1], c[2])
+		if err != nil {
+			return err
+		}
+		return r.Unmap(code)
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Stats returns the counts since New and the last event published
+func (r *IRRecv) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.Learning = r.learning
+	return s
+}
+
+// ReadPub publishes the Stats
+func (r *IRRecv) ReadPub() error {
+	return r.publish(r.Stats())
+}
+
+// Run publishes the Stats every period until ctx is canceled, the
+// keys are published as they are received
+func (r *IRRecv) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("irrecv stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the line
+func (r *IRRecv) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pin == nil {
+		return nil
+	}
+	err := r.pin.Close()
+	r.pin = nil
+	return err
+}
+
+// MockPress sends code and repeats repeat frames in mock mode, as
+// the pulses a receiver would see
+func (r *IRRecv) MockPress(code Code, repeats int) {
+	r.mu.Lock()
+	if r.pin != nil {
+		r.mu.Unlock()
+		return
+	}
+	at := r.mockAt + time.Second
+	r.mu.Unlock()
+
+	mark := true
+	r.handle(mark, at)
+	for _, d := range necPulses(code, repeats) {
+		at += d
+		mark = !mark
+		r.handle(mark, at)
+	}
+
+	r.mu.Lock()
+	r.mockAt = at
+	r.mu.Unlock()
+}
+
+// handle takes the line going to a mark, or a space, at the
+// timestamp at. An edge within the glitch time of the one before
+// takes that one back, the two were a spike.
+func (r *IRRecv) handle(mark bool, at time.Duration) {
+	r.mu.Lock()
+	if !r.started {
+		r.started, r.lastEdge = true, at
+		r.mu.Unlock()
+		return
+	}
+	d := at - r.lastEdge
+	if d < r.glitch && r.undo {
+		r.dec, r.lastEdge, r.undo = r.prev, r.prevEdge, false
+		if r.fedErr {
+			r.stats.Errors--
+		}
+		r.redo = r.fed
+		r.stats.Glitches++
+		r.mu.Unlock()
+		return
+	}
+
+	r.prev, r.prevEdge, r.undo = r.dec, r.lastEdge, true
+	r.lastEdge = at
+	f, err := r.dec.feed(!mark, d)
+	r.fed, r.fedErr = f, err != nil
+	if err != nil {
+		r.stats.Errors++
+		slog.Debug("irrecv", "device", r.Device.Name, "error", err)
+	}
+	redo := r.redo
+	r.redo = nil
+	if f == nil || (redo != nil && *redo == *f) {
+		r.mu.Unlock()
+		return
+	}
+	evt, m, dispatch, learned := r.frame(f, at)
+	r.mu.Unlock()
+
+	if evt == nil {
+		return
+	}
+	if err := r.publish(evt); err != nil {
+		slog.Error("irrecv event", "device", r.Device.Name, "error", err)
+	}
+	if learned != nil {
+		if err := device.GetStore().Save(r.learnedKey(), learned); err != nil {
+			slog.Error("irrecv saving learned codes", "device", r.Device.Name, "error", err)
+		}
+	}
+	if dispatch {
+		if err := r.dispatch(m); err != nil {
+			slog.Error("irrecv command", "device", r.Device.Name, "error", err)
+		}
+	}
+}
+
+// frame coalesces f and returns the event to publish, nil for none,
+// the mapping to dispatch and the learned codes to save when a code
+// was learned, r.mu is held
+func (r *IRRecv) frame(f *frame, at time.Duration) (*Event, Mapping, bool, map[string]*Learned) {
+	if f.repeat {
+		r.stats.Repeats++
+	} else {
+		r.stats.Frames++
+	}
+	repeat, publish := r.rep.frame(f, at)
+	if !publish {
+		return nil, Mapping{}, false, nil
+	}
+
+	code := r.rep.code
+	evt := &Event{
+		Proto:   "nec",
+		Addr:    code.AddrString(),
+		Cmd:     code.CmdString(),
+		Repeat:  repeat,
+		Repeats: r.rep.count,
+	}
+	r.stats.Last = evt
+
+	m, mapped := r.keymap[code.String()]
+	if mapped {
+		return evt, m, !repeat || m.Repeat, nil
+	}
+	if !r.learning || repeat {
+		return evt, m, false, nil
+	}
+	l, ok := r.learned[code.String()]
+	if !ok {
+		l = &Learned{Code: code}
+		r.learned[code.String()] = l
+		evt.Learned = true
+	}
+	l.Count++
+	l.Last = r.now()
+	if ok {
+		return evt, m, false, nil
+	}
+	save := make(map[string]*Learned, len(r.learned))
+	for k, v := range r.learned {
+		c := *v
+		save[k] = &c
+	}
+	return evt, m, false, save
+}
+
+// dispatch sends the command of m to its device
+func (r *IRRecv) dispatch(m Mapping) error {
+	d, ok := device.GetDeviceManager().Get(m.Device)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDevice, m.Device)
+	}
+	c, ok := d.(Commander)
+	if !ok {
+		return fmt.Errorf("%w: %s takes no commands", ErrDevice, m.Device)
+	}
+	return c.Command([]byte(m.Command))
+}
+
+func (r *IRRecv) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
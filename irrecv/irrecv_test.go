@@ -0,0 +1,338 @@
+package irrecv
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func us(v int) time.Duration {
+	return time.Duration(v) * time.Microsecond
+}
+
+// decode feeds the pulses of a capture, starting with a mark, to a
+// decoder
+func decode(capture []int) ([]*frame, []error) {
+	dec := decoder{tol: DefaultTolerance}
+	var frames []*frame
+	var errs []error
+	for i, v := range capture {
+		f, err := dec.feed(i%2 == 0, us(v))
+		if f != nil {
+			frames = append(frames, f)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return frames, errs
+}
+
+// replay drives r with the edges of a capture from at, it returns
+// the time of the last edge
+func replay(r *IRRecv, capture []int, at time.Duration) time.Duration {
+	r.handle(true, at)
+	for i, v := range capture {
+		at += us(v)
+		r.handle(i%2 == 1, at)
+	}
+	return at
+}
+
+func TestDecoder(t *testing.T) {
+	frames, errs := decode(capKey)
+	if len(errs) != 0 || len(frames) != 3 {
+		t.Fatalf("decode(capKey) got (%v, %v) want a frame and 2 repeats", frames, errs)
+	}
+	if f := frames[0]; f.repeat || f.code != (Code{Addr: 0x00, Cmd: 0x45}) {
+		t.Errorf("decode(capKey) got (%+v) want 0x00/0x45", f)
+	}
+	if !frames[1].repeat || !frames[2].repeat {
+		t.Errorf("decode(capKey) got (%+v, %+v) want repeats", frames[1], frames[2])
+	}
+
+	frames, errs = decode(capExt)
+	if len(errs) != 0 || len(frames) != 1 || frames[0].code != (Code{Addr: 0xbd02, Cmd: 0x1a, Ext: true}) {
+		t.Errorf("decode(capExt) got (%v, %v) want 0xbd02/0x1a", frames, errs)
+	}
+	if s := frames[0].code.String(); s != "0xbd02/0x1a" {
+		t.Errorf("String() got (%s) want (0xbd02/0x1a)", s)
+	}
+
+	frames, errs = decode(capCorrupt)
+	if len(frames) != 0 || len(errs) != 1 || !errors.Is(errs[0], ErrFrame) {
+		t.Errorf("decode(capCorrupt) got (%v, %v) want (%v)", frames, errs, ErrFrame)
+	}
+
+	// the cut frame is dropped, the next one decodes
+	frames, errs = decode(capBroken)
+	if len(errs) != 1 || len(frames) != 1 || frames[0].code.Cmd != 0x47 {
+		t.Errorf("decode(capBroken) got (%v, %v) want an error and 0x47", frames, errs)
+	}
+
+	// the pulses sent by MockPress decode to what was sent
+	for _, code := range []Code{{0x00, 0x00, false}, {0xff, 0xff, false}, {0x12ef, 0x81, true}} {
+		dec := decoder{tol: DefaultTolerance}
+		var got []*frame
+		for i, d := range necPulses(code, 1) {
+			f, err := dec.feed(i%2 == 0, d)
+			if err != nil {
+				t.Errorf("feed(%v) error = %v", code, err)
+			}
+			if f != nil {
+				got = append(got, f)
+			}
+		}
+		if len(got) != 2 || got[0].code != code || !got[1].repeat {
+			t.Errorf("necPulses(%v) decoded to (%v)", code, got)
+		}
+	}
+
+	// a space between a 0 and a 1 is neither
+	dec := decoder{tol: DefaultTolerance}
+	if dec.match(us(1000), necUnit) || dec.match(us(1000), necOneSpace) {
+		t.Errorf("match(1ms) got a bit")
+	}
+}
+
+func TestRepeater(t *testing.T) {
+	ms := func(v int) time.Duration { return time.Duration(v) * time.Millisecond }
+	r := repeater{window: ms(200), interval: ms(200)}
+	key := &frame{code: Code{Cmd: 0x45}}
+	rpt := &frame{repeat: true}
+
+	steps := []struct {
+		f               *frame
+		at              int
+		repeat, publish bool
+		count           int
+	}{
+		{rpt, 0, true, false, 0}, // follows nothing
+		{key, 100, false, true, 0},
+		{rpt, 208, true, false, 1},
+		{rpt, 316, true, true, 2},
+		{key, 424, true, false, 3}, // the full frame again
+		{rpt, 532, true, true, 4},
+		{rpt, 900, true, false, 4}, // too late
+		{rpt, 1008, true, false, 4},
+		{key, 1100, false, true, 0},
+		{&frame{code: Code{Cmd: 0x46}}, 1208, false, true, 0},
+	}
+	for i, s := range steps {
+		repeat, publish := r.frame(s.f, ms(s.at))
+		if repeat != s.repeat || publish != s.publish || r.count != s.count {
+			t.Errorf("step %d frame() got (%t, %t, %d) want (%t, %t, %d)",
+				i, repeat, publish, r.count, s.repeat, s.publish, s.count)
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	r, err := New("remote", "4")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer r.Close()
+	r.SetRepeat(DefaultRepeatWindow, 0)
+
+	at := replay(r, capKey, time.Second)
+	s := r.Stats()
+	want := Event{Proto: "nec", Addr: "0x00", Cmd: "0x45", Repeat: true, Repeats: 2}
+	if s.Frames != 1 || s.Repeats != 2 || s.Errors != 0 || s.Last == nil || *s.Last != want {
+		t.Errorf("Stats() got (%+v, %+v) want 1 frame, 2 repeats and (%+v)", s, s.Last, want)
+	}
+
+	at = replay(r, capNoisy, at+time.Second)
+	s = r.Stats()
+	if s.Frames != 2 || s.Glitches != 4 || s.Errors != 0 || s.Last.Cmd != "0x46" || s.Last.Repeat {
+		t.Errorf("Stats(noisy) got (%+v, %+v) want 4 glitches and 0x46", s, s.Last)
+	}
+
+	at = replay(r, capBroken, at+time.Second)
+	s = r.Stats()
+	if s.Frames != 3 || s.Errors != 1 || s.Last.Cmd != "0x47" {
+		t.Errorf("Stats(broken) got (%+v, %+v) want an error and 0x47", s, s.Last)
+	}
+
+	replay(r, capCorrupt, at+time.Second)
+	if s = r.Stats(); s.Frames != 3 || s.Errors != 2 {
+		t.Errorf("Stats(corrupt) got (%+v) want 2 errors", s)
+	}
+
+	if err := r.SetTolerance(1.5); !errors.Is(err, ErrConfig) {
+		t.Errorf("SetTolerance(1.5) error got (%v) want (%v)", err, ErrConfig)
+	}
+	r.Close()
+	if !chip.Line(4).Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
+
+// porch is a device taking commands
+type porch struct {
+	cmds []string
+}
+
+func (p *porch) Name() string {
+	return "porch"
+}
+
+func (p *porch) Command(payload []byte) error {
+	p.cmds = append(p.cmds, string(payload))
+	return nil
+}
+
+func TestLearnAndMap(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	device.SetStore(device.NewFileStore(t.TempDir()))
+	defer device.SetStore(device.NewMemStore())
+	p := &porch{}
+	dm := device.GetDeviceManager()
+	dm.Add(p)
+	defer dm.Remove("porch")
+
+	r, err := New("remote", "4")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := r.Command([]byte("learn on")); err != nil {
+		t.Fatalf("Command(learn on) error = %v", err)
+	}
+	key := Code{Addr: 0x00, Cmd: 0x40}
+	r.MockPress(key, 0)
+	if s := r.Stats(); !s.Learning || s.Last == nil || !s.Last.Learned {
+		t.Errorf("Stats() got (%+v) want the code learned", s)
+	}
+	r.MockPress(key, 3)
+	r.MockPress(Code{Addr: 0x00, Cmd: 0x15}, 0)
+	l := r.Learned()
+	if len(l) != 2 || l[0].Code != key || l[0].Count != 2 || l[1].Count != 1 {
+		t.Errorf("Learned() got (%+v) want 0x00/0x40 twice and 0x00/0x15", l)
+	}
+
+	if err := r.Command([]byte("map 0x00 0x40 porch on")); err != nil {
+		t.Fatalf("Command(map) error = %v", err)
+	}
+	if l := r.Learned(); len(l) != 1 {
+		t.Errorf("Learned() after the map got (%+v) want one code", l)
+	}
+
+	// the keymap is saved
+	r, err = New("remote", "4")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if m := r.Keymap(); m["0x00/0x40"] != (Mapping{Device: "porch", Command: "on"}) {
+		t.Errorf("Keymap() got (%v) want 0x00/0x40 on porch", m)
+	}
+	if l := r.Learned(); len(l) != 1 || l[0].Code.Cmd != 0x15 {
+		t.Errorf("Learned() got (%+v) want 0x00/0x15", l)
+	}
+
+	// held for 3 repeats, 108ms apart, one is published
+	r.MockPress(key, 3)
+	if len(p.cmds) != 1 || p.cmds[0] != "on" {
+		t.Errorf("commands got (%q) want [on]", p.cmds)
+	}
+	if err := r.Map(key, Mapping{Device: "porch", Command: "brighter", Repeat: true}); err != nil {
+		t.Fatalf("Map() error = %v", err)
+	}
+	r.MockPress(key, 3)
+	if len(p.cmds) != 3 || p.cmds[2] != "brighter" {
+		t.Errorf("commands got (%q) want [on brighter brighter]", p.cmds)
+	}
+
+	if err := r.Command([]byte("unmap 0x00 0x40")); err != nil || len(r.Keymap()) != 0 {
+		t.Errorf("Command(unmap) got (%v, %v)", err, r.Keymap())
+	}
+	if err := r.Command([]byte("map 0x00 0x4g porch on")); !errors.Is(err, ErrCode) {
+		t.Errorf("Command(bad code) error got (%v) want (%v)", err, ErrCode)
+	}
+	if err := r.Command([]byte("teach")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(teach) error got (%v) want (%v)", err, ErrCommand)
+	}
+	if err := r.Command([]byte("forget")); err != nil || len(r.Learned()) != 0 {
+		t.Errorf("Command(forget) got (%v, %v)", err, r.Learned())
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	codes := []struct {
+		addr, cmd string
+		want      Code
+	}{
+		{"0x00", "0x45", Code{0x00, 0x45, false}},
+		{"0x0004", "0x08", Code{0x04, 0x08, true}},
+		{"0xbd02", "26", Code{0xbd02, 0x1a, true}},
+		{"7", "0X1A", Code{0x07, 0x1a, false}},
+	}
+	for _, c := range codes {
+		if got, err := ParseCode(c.addr, c.cmd); err != nil || got != c.want {
+			t.Errorf("ParseCode(%s, %s) got (%+v, %v) want (%+v)", c.addr, c.cmd, got, err, c.want)
+		}
+	}
+	if _, err := ParseCode("0x10000", "0x00"); !errors.Is(err, ErrCode) {
+		t.Errorf("ParseCode(0x10000) error got (%v) want (%v)", err, ErrCode)
+	}
+}
+
+// capKey is the 0x45 key of a 21 key remote, held for two repeats
+var capKey = []int{
+	9074, 4443, 606, 504, 630, 464, 642, 484, 626, 514, 626, 472, 654,
+	504, 630, 514, 644, 492, 658, 1612, 628, 1626, 624, 1610, 630, 1628,
+	658, 1578, 610, 1588, 606, 1608, 640, 1630, 638, 1596, 606, 464, 606,
+	1614, 668, 456, 664, 470, 612, 502, 668, 1582, 610, 496, 610, 520,
+	646, 1634, 632, 502, 640, 1616, 666, 1610, 662, 1578, 654, 476, 620,
+	1630, 604, 39991, 9077, 2195, 622, 96106, 9101, 2191, 612,
+}
+
+// capExt is an extended NEC remote, address 0xbd02
+var capExt = []int{
+	9110, 4440, 618, 458, 670, 1632, 666, 476, 624, 466, 624, 464, 658,
+	474, 622, 506, 642, 504, 640, 1620, 662, 508, 650, 1598, 636, 1634,
+	642, 1612, 616, 1610, 636, 456, 624, 1626, 624, 498, 642, 1626, 650,
+	514, 608, 1618, 616, 1608, 638, 502, 616, 508, 608, 498, 644, 1578,
+	610, 512, 624, 1634, 662, 484, 662, 520, 612, 1602, 614, 1632, 670,
+	1588, 620,
+}
+
+// capNoisy is the 0x46 key with spikes in the leader space, a
+// mark, a space and the stop mark
+var capNoisy = []int{
+	9071, 1800, 40, 2603, 628, 456, 662, 470, 616, 502, 300, 60, 304, 502,
+	612, 476, 610, 516, 638, 496, 608, 456, 672, 1580, 664, 1622, 650,
+	1610, 672, 1588, 620, 1630, 670, 900, 80, 642, 616, 1584, 608, 1616,
+	614, 518, 638, 1630, 634, 1616, 608, 520, 660, 508, 638, 472, 624,
+	1578, 670, 516, 620, 1592, 636, 458, 672, 464, 612, 1600, 628, 1626,
+	610, 1598, 662, 514, 640, 1582, 250, 50, 354,
+}
+
+// capBroken loses the end of a frame, the key is pressed again
+var capBroken = []int{
+	9102, 4443, 620, 462, 610, 456, 638, 482, 602, 486, 668, 466, 648,
+	474, 610, 488, 670, 506, 622, 1604, 630, 1620, 628, 1582, 624, 1624,
+	606, 1614, 632, 1578, 618, 1586, 636, 1622, 648, 1600, 636, 1636, 632,
+	1584, 608, 488, 668, 30000, 9050, 4450, 650, 522, 654, 482, 672, 486,
+	672, 496, 652, 484, 636, 456, 656, 482, 640, 472, 672, 1608, 614,
+	1624, 648, 1588, 670, 1636, 632, 1610, 672, 1618, 618, 1644, 636,
+	1606, 624, 1586, 624, 1606, 668, 1610, 664, 462, 622, 462, 624, 516,
+	632, 1644, 628, 488, 626, 492, 646, 472, 614, 472, 638, 1648, 648,
+	1634, 630, 1634, 632, 498, 662, 1632, 672,
+}
+
+// capCorrupt has a command that is not followed by its inverse
+var capCorrupt = []int{
+	9094, 4450, 642, 490, 662, 512, 668, 478, 636, 456, 652, 522, 668,
+	470, 604, 508, 622, 490, 604, 1626, 670, 1636, 638, 1586, 666, 1616,
+	630, 1642, 610, 1614, 624, 1610, 624, 1604, 644, 492, 624, 494, 620,
+	1632, 616, 466, 612, 498, 640, 484, 654, 1630, 650, 510, 610, 506,
+	664, 488, 624, 1602, 666, 1628, 634, 1618, 604, 1600, 612, 472, 652,
+	1634, 634,
+}
@@ -0,0 +1,207 @@
+package irrecv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NEC sends a frame as a 9ms mark and a 4.5ms space, 32 bits and a
+// stop mark. Every bit is a 562.5us mark followed by a 562.5us space
+// for a 0 or a 1687.5us space for a 1, least significant bit first:
+// the address, its inverse, the command and its inverse. A key held
+// down sends a repeat frame every 108ms, a 9ms mark, a 2.25ms space
+// and the stop mark. Extended NEC uses the inverse of the address as
+// the high byte of a 16 bit address.
+const (
+	necUnit        = 562500 * time.Nanosecond
+	necLeaderMark  = 16 * necUnit
+	necLeaderSpace = 8 * necUnit
+	necRepeatSpace = 4 * necUnit
+	necOneSpace    = 3 * necUnit
+	necBits        = 32
+)
+
+// DefaultTolerance is how far a mark or space may be off its length,
+// the receivers stretch the marks and shorten the spaces by 100us or
+// more
+const DefaultTolerance = 0.25
+
+// slack is added to the tolerance, it matters for the short pulses
+const slack = 150 * time.Microsecond
+
+var ErrFrame = errors.New("broken nec frame")
+
+// Code is a decoded address and command, Ext marks a 16 bit address
+// of extended NEC
+type Code struct {
+	Addr uint16 `json:"addr"`
+	Cmd  uint8  `json:"cmd"`
+	Ext  bool   `json:"ext,omitempty"`
+}
+
+// AddrString returns the address in hex, 4 digits for extended NEC
+func (c Code) AddrString() string {
+	if c.Ext {
+		return fmt.Sprintf("0x%04x", c.Addr)
+	}
+	return fmt.Sprintf("0x%02x", c.Addr)
+}
+
+// CmdString returns the command in hex
+func (c Code) CmdString() string {
+	return fmt.Sprintf("0x%02x", c.Cmd)
+}
+
+// String returns the code as "address/command", the key codes are
+// saved under
+func (c Code) String() string {
+	return c.AddrString() + "/" + c.CmdString()
+}
+
+// frame is what the decoder found, a code or a repeat frame
+type frame struct {
+	code   Code
+	repeat bool
+}
+
+const (
+	stIdle = iota
+	stLeader
+	stMark
+	stSpace
+	stStop
+	stRepeat
+)
+
+// decoder turns the marks and spaces of the receiver into frames. A
+// pulse that does not fit drops the frame under way, a leader mark
+// starts the next one straight away so a frame following noise is
+// not lost.
+type decoder struct {
+	tol   float64
+	state int
+	bits  uint32
+	n     int
+}
+
+// match reports if d is want within the tolerance
+func (dec *decoder) match(d, want time.Duration) bool {
+	off := d - want
+	if off < 0 {
+		off = -off
+	}
+	return off <= time.Duration(float64(want)*dec.tol)+slack
+}
+
+// feed takes a mark, or a space, of length d. It returns the frame
+// it completed, or ErrFrame when it broke a frame.
+func (dec *decoder) feed(mark bool, d time.Duration) (*frame, error) {
+	switch {
+	case dec.state == stIdle:
+	case dec.state == stLeader && !mark:
+		switch {
+		case dec.match(d, necLeaderSpace):
+			dec.state, dec.bits, dec.n = stMark, 0, 0
+			return nil, nil
+		case dec.match(d, necRepeatSpace):
+			dec.state = stRepeat
+			return nil, nil
+		}
+		return nil, dec.broken(mark, d, "leader space %v", d)
+	case dec.state == stMark && mark && dec.match(d, necUnit):
+		dec.state = stSpace
+		return nil, nil
+	case dec.state == stSpace && !mark:
+		switch {
+		case dec.match(d, necUnit):
+		case dec.match(d, necOneSpace):
+			dec.bits |= 1 << dec.n
+		default:
+			return nil, dec.broken(mark, d, "bit %d space %v", dec.n, d)
+		}
+		dec.n++
+		dec.state = stMark
+		if dec.n == necBits {
+			dec.state = stStop
+		}
+		return nil, nil
+	case dec.state == stStop && mark && dec.match(d, necUnit):
+		dec.state = stIdle
+		return dec.frame()
+	case dec.state == stRepeat && mark && dec.match(d, necUnit):
+		dec.state = stIdle
+		return &frame{repeat: true}, nil
+	default:
+		return nil, dec.broken(mark, d, "%v %s in state %d", d, pulse(mark), dec.state)
+	}
+
+	if mark && dec.match(d, necLeaderMark) {
+		dec.state = stLeader
+	}
+	return nil, nil
+}
+
+// broken drops the frame under way, d may be the leader of the next
+func (dec *decoder) broken(mark bool, d time.Duration, format string, args ...any) error {
+	dec.state = stIdle
+	if mark && dec.match(d, necLeaderMark) {
+		dec.state = stLeader
+	}
+	return fmt.Errorf("%w: "+format, append([]any{ErrFrame}, args...)...)
+}
+
+// frame checks the bits of a frame, the command has to be followed
+// by its inverse
+func (dec *decoder) frame() (*frame, error) {
+	a, na := uint8(dec.bits), uint8(dec.bits>>8)
+	c, nc := uint8(dec.bits>>16), uint8(dec.bits>>24)
+	if c != ^nc {
+		return nil, fmt.Errorf("%w: command %#02x inverse %#02x", ErrFrame, c, nc)
+	}
+	f := &frame{code: Code{Addr: uint16(a), Cmd: c}}
+	if a != ^na {
+		f.code.Addr |= uint16(na) << 8
+		f.code.Ext = true
+	}
+	return f, nil
+}
+
+func pulse(mark bool) string {
+	if mark {
+		return "mark"
+	}
+	return "space"
+}
+
+// necPulses returns the marks and spaces of a frame of code followed
+// by repeats repeat frames, starting with the leader mark. The spaces
+// between the frames keep the 108ms period.
+func necPulses(code Code, repeats int) []time.Duration {
+	na := ^uint8(code.Addr)
+	if code.Ext {
+		na = uint8(code.Addr >> 8)
+	}
+	bits := uint32(uint8(code.Addr)) | uint32(na)<<8 | uint32(code.Cmd)<<16 | uint32(^code.Cmd)<<24
+
+	p := []time.Duration{necLeaderMark, necLeaderSpace}
+	for i := 0; i < necBits; i++ {
+		space := necUnit
+		if bits&(1<<i) != 0 {
+			space = necOneSpace
+		}
+		p = append(p, necUnit, space)
+	}
+	p = append(p, necUnit)
+
+	const period = 192 * necUnit // 108ms
+	var sent time.Duration
+	for _, d := range p {
+		sent += d
+	}
+	for i := 0; i < repeats; i++ {
+		p = append(p, period-sent, necLeaderMark, necRepeatSpace, necUnit)
+		sent = necLeaderMark + necRepeatSpace + necUnit
+	}
+	return p
+}
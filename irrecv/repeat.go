@@ -0,0 +1,42 @@
+package irrecv
+
+import "time"
+
+// repeater coalesces the frames of a key held down. A repeat frame
+// counts for the last code when it follows that code, or its last
+// repeat, within the window, one that follows nothing is dropped.
+// Some remotes send the full frame again instead of repeat frames,
+// the same code within the window is taken as a repeat too. Of the
+// repeats only one every interval is published.
+type repeater struct {
+	window   time.Duration
+	interval time.Duration
+
+	code  Code
+	held  bool
+	at    time.Duration // the end of the last frame of code
+	pubAt time.Duration // the last published
+	count int           // the repeats of code
+}
+
+// frame takes f ending at at, it returns if f is a repeat and if it
+// is to be published. The code is r.code.
+func (r *repeater) frame(f *frame, at time.Duration) (repeat, publish bool) {
+	within := r.held && at-r.at <= r.window
+	if !f.repeat && !(within && f.code == r.code) {
+		r.code, r.held = f.code, true
+		r.at, r.pubAt, r.count = at, at, 0
+		return false, true
+	}
+	if !within {
+		r.held = false
+		return true, false
+	}
+	r.at = at
+	r.count++
+	if at-r.pubAt < r.interval {
+		return true, false
+	}
+	r.pubAt = at
+	return true, true
+}
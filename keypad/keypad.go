@@ -0,0 +1,436 @@
+// Package keypad scans a matrix keypad, like the 4x4 membrane ones,
+// with its rows and columns on GPIO lines.
+//
+// The rows are strobed one at a time, open drain and active low, and
+// the columns, pulled up, read the keys pressed on the row. The scans
+// are debounced, a single key held down repeats, and a scan that can
+// not be trusted because the keys pressed short rows together is
+// reported as a "ghost" event instead of as phantom keys.
+//
+// With Interrupt set the scanning stops while no key is down, all of
+// the rows are pulled low and a press wakes the scan from the edge on
+// its column.
+//
+// Every press, repeat and release is published as a KeyEvent. In
+// entry mode the keys are collected instead, "#" publishes the code
+// typed as a CodeEvent and hands it to the OnCode functions, "*"
+// clears it. A PIN entered that way can drive a relay latch without
+// a round trip through the broker.
+package keypad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultDebounce filters the bounce of a membrane key
+	DefaultDebounce = 20 * time.Millisecond
+
+	// DefaultRepeatDelay and DefaultRepeatRate repeat a held key
+	DefaultRepeatDelay = 600 * time.Millisecond
+	DefaultRepeatRate  = 150 * time.Millisecond
+
+	// DefaultScanPeriod scans 50 times a second
+	DefaultScanPeriod = 20 * time.Millisecond
+
+	// DefaultEntryTimeout clears a code not finished in time
+	DefaultEntryTimeout = 15 * time.Second
+
+	// MaxCode is the longest code collected in entry mode
+	MaxCode = 16
+)
+
+// The layouts of the common membrane keypads, a row a string
+var (
+	Keymap4x4 = []string{"123A", "456B", "789C", "*0#D"}
+	Keymap4x3 = []string{"123", "456", "789", "*0#"}
+)
+
+var ErrConfig = errors.New("invalid keypad configuration")
+
+// KeyEvent is published for every "press", "repeat" and "release" of
+// a key, and as "ghost" when the keys pressed can not be told apart
+type KeyEvent struct {
+	Event string `json:"event"`
+	Key   string `json:"key,omitempty"`
+}
+
+// CodeEvent is published in entry mode when "#" ends a code
+type CodeEvent struct {
+	Event string `json:"event"`
+	Code  string `json:"code"`
+}
+
+// Keypad is a matrix keypad
+type Keypad struct {
+	*device.Device
+
+	// Interrupt waits on the columns for a press instead of
+	// scanning while no key is down. Turn it off for lines without
+	// edge detection.
+	Interrupt bool
+
+	keys   [][]rune
+	rows   []*drivers.DigitalPin
+	cols   []*drivers.DigitalPin
+	sc     *scanner
+	wake   chan struct{}
+	mock   [][]bool
+	entry  bool
+	code   []rune
+	typed  time.Time // the last key of the code
+	tmo    time.Duration
+	onCode []func(string)
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates a keypad with rows and cols on the pins, any pin
+// identifier drivers.ResolvePinID understands, and keymap giving the
+// keys of each row. Keymap4x4 or Keymap4x3 is used for a keypad of
+// that size if keymap is empty.
+func New(name string, rows, cols []string, keymap ...string) (*Keypad, error) {
+	if len(keymap) == 0 {
+		switch {
+		case len(rows) == 4 && len(cols) == 4:
+			keymap = Keymap4x4
+		case len(rows) == 4 && len(cols) == 3:
+			keymap = Keymap4x3
+		}
+	}
+	keys, err := parseKeymap(keymap, len(rows), len(cols))
+	if err != nil {
+		return nil, err
+	}
+
+	sc := newScanner(len(rows), len(cols))
+	sc.debounce, sc.delay, sc.rate = DefaultDebounce, DefaultRepeatDelay, DefaultRepeatRate
+	k := &Keypad{
+		Device:    device.NewDevice(name, "mqtt"),
+		Interrupt: true,
+		keys:      keys,
+		sc:        sc,
+		wake:      make(chan struct{}, 1),
+		tmo:       DefaultEntryTimeout,
+		now:       time.Now,
+	}
+	if device.IsMock() {
+		k.mock = grid(len(rows), len(cols))
+		return k, nil
+	}
+
+	for i, id := range rows {
+		pin, err := drivers.NewDigitalPinID(fmt.Sprintf("%s-row%d", name, i), id,
+			drivers.WithOwner("keypad"),
+			gpiocdev.AsOutput(0),
+			gpiocdev.AsOpenDrain,
+			gpiocdev.AsActiveLow)
+		if err != nil {
+			k.Close()
+			return nil, fmt.Errorf("keypad %s row %d: %w", name, i, err)
+		}
+		k.rows = append(k.rows, pin)
+	}
+	for i, id := range cols {
+		pin, err := drivers.NewDigitalPinID(fmt.Sprintf("%s-col%d", name, i), id,
+			drivers.WithOwner("keypad"),
+			gpiocdev.AsInput,
+			gpiocdev.AsActiveLow,
+			gpiocdev.WithPullUp,
+			gpiocdev.WithRisingEdge,
+			gpiocdev.WithEventHandler(func(gpiocdev.LineEvent) { k.wakeUp() }))
+		if err != nil {
+			k.Close()
+			return nil, fmt.Errorf("keypad %s col %d: %w", name, i, err)
+		}
+		k.cols = append(k.cols, pin)
+	}
+	return k, nil
+}
+
+// parseKeymap checks keymap has a row of cols keys for each row
+func parseKeymap(keymap []string, rows, cols int) ([][]rune, error) {
+	if rows == 0 || cols == 0 || len(keymap) != rows {
+		return nil, fmt.Errorf("%w: %d keymap rows for %dx%d keys", ErrConfig, len(keymap), rows, cols)
+	}
+	keys := make([][]rune, rows)
+	for r, row := range keymap {
+		keys[r] = []rune(row)
+		if len(keys[r]) != cols {
+			return nil, fmt.Errorf("%w: keymap row %q for %d columns", ErrConfig, row, cols)
+		}
+	}
+	return keys, nil
+}
+
+// Name returns the name of the device
+func (k *Keypad) Name() string {
+	return k.Device.Name
+}
+
+// SetDebounce sets how long a key has to read the same to change
+func (k *Keypad) SetDebounce(d time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.sc.debounce = d
+}
+
+// SetRepeat repeats a single key held down for delay every rate, a
+// delay of 0 turns the repeat off
+func (k *Keypad) SetRepeat(delay, rate time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.sc.delay, k.sc.rate = delay, rate
+}
+
+// SetEntry turns entry mode on or off, the code typed so far is
+// cleared. A code not ended within timeout is dropped, 0 waits for
+// ever.
+func (k *Keypad) SetEntry(on bool, timeout time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entry, k.tmo = on, timeout
+	k.code = nil
+}
+
+// OnCode calls fn with every code ended with "#" in entry mode
+func (k *Keypad) OnCode(fn func(code string)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.onCode = append(k.onCode, fn)
+}
+
+// Scan reads the matrix once and publishes what changed
+func (k *Keypad) Scan() error {
+	m, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	at := k.now()
+	evts := k.sc.scan(m, at)
+	var pubs []any
+	var codes []string
+	for _, e := range evts {
+		if e.kind == "ghost" {
+			slog.Warn("keypad ghosting, too many keys pressed", "device", k.Device.Name)
+			pubs = append(pubs, &KeyEvent{Event: "ghost"})
+			continue
+		}
+		key := k.keys[e.row][e.col]
+		if !k.entry {
+			pubs = append(pubs, &KeyEvent{Event: e.kind, Key: string(key)})
+			continue
+		}
+		if e.kind == "press" {
+			if code, ok := k.enter(key, at); ok {
+				codes = append(codes, code)
+				pubs = append(pubs, &CodeEvent{Event: "code", Code: code})
+			}
+		}
+	}
+	fns := append([]func(string){}, k.onCode...)
+	k.mu.Unlock()
+
+	for _, p := range pubs {
+		if err := k.publish(p); err != nil {
+			return err
+		}
+	}
+	for _, code := range codes {
+		for _, fn := range fns {
+			fn(code)
+		}
+	}
+	return nil
+}
+
+// enter adds key to the code, it returns the code when "#" ends it,
+// k.mu is held
+func (k *Keypad) enter(key rune, at time.Time) (string, bool) {
+	if k.tmo > 0 && len(k.code) > 0 && at.Sub(k.typed) > k.tmo {
+		k.code = nil
+	}
+	k.typed = at
+	switch key {
+	case '#':
+		code := string(k.code)
+		k.code = nil
+		return code, true
+	case '*':
+		k.code = nil
+	default:
+		if len(k.code) < MaxCode {
+			k.code = append(k.code, key)
+		}
+	}
+	return "", false
+}
+
+// read strobes the rows one at a time and returns the keys pressed
+func (k *Keypad) read() ([][]bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.mock != nil {
+		m := grid(len(k.mock), len(k.mock[0]))
+		for r := range m {
+			copy(m[r], k.mock[r])
+		}
+		return m, nil
+	}
+	if k.rows == nil {
+		return nil, errors.New("not initialized")
+	}
+
+	m := grid(len(k.rows), len(k.cols))
+	for r, row := range k.rows {
+		if err := row.Set(1); err != nil {
+			return nil, err
+		}
+		for c, col := range k.cols {
+			v, err := col.Get()
+			if err != nil {
+				row.Set(0)
+				return nil, err
+			}
+			m[r][c] = v == 1
+		}
+		if err := row.Set(0); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// setRows strobes all of the rows, v 1, or releases them
+func (k *Keypad) setRows(v int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, row := range k.rows {
+		if err := row.Set(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anyColumn reports if a column reads a key pressed
+func (k *Keypad) anyColumn() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, col := range k.cols {
+		if v, err := col.Get(); err != nil || v == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Keypad) wakeUp() {
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+}
+
+// idle reports if the scan can wait for an edge on a column
+func (k *Keypad) idle() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.Interrupt && k.rows != nil && k.sc.idle()
+}
+
+// Run scans the matrix every period until ctx is canceled. With
+// Interrupt set it waits for a press while no key is down.
+func (k *Keypad) Run(ctx context.Context, period time.Duration) error {
+	err := k.run(ctx, period)
+	slog.Debug("keypad stopped", "device", k.Device.Name, "error", err)
+	return err
+}
+
+func (k *Keypad) run(ctx context.Context, period time.Duration) error {
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+	for {
+		if err := k.Scan(); err != nil {
+			slog.Error("keypad scan", "device", k.Device.Name, "error", err)
+		}
+		if k.idle() {
+			if err := k.waitPress(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// waitPress pulls every row low and waits for a column to follow
+func (k *Keypad) waitPress(ctx context.Context) error {
+	select {
+	case <-k.wake:
+	default:
+	}
+	if err := k.setRows(1); err != nil {
+		return err
+	}
+	defer k.setRows(0)
+
+	// a key pressed before the rows were pulled low made no edge
+	if k.anyColumn() {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-k.wake:
+		return nil
+	}
+}
+
+// MockKey presses (true) or releases the key at row and col in mock
+// mode, the next Scan reads it
+func (k *Keypad) MockKey(row, col int, down bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.mock != nil {
+		k.mock[row][col] = down
+	}
+}
+
+// Close releases the lines
+func (k *Keypad) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var errs []error
+	for _, p := range append(k.rows, k.cols...) {
+		errs = append(errs, p.Close())
+	}
+	k.rows, k.cols = nil, nil
+	return errors.Join(errs...)
+}
+
+func (k *Keypad) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	k.PubData(j)
+	return nil
+}
@@ -0,0 +1,392 @@
+package keypad
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+func TestScannerDebounce(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	s := newScanner(2, 2)
+	s.debounce = 20 * time.Millisecond
+
+	up := grid(2, 2)
+	key := grid(2, 2)
+	key[1][0] = true
+
+	steps := []struct {
+		m    [][]bool
+		ms   int
+		want string
+	}{
+		{key, 0, ""},
+		{up, 5, ""}, // bounce
+		{key, 10, ""},
+		{key, 25, ""},
+		{key, 30, "press"},
+		{key, 40, ""},
+		{up, 50, ""},
+		{key, 55, ""}, // bounce
+		{up, 60, ""},
+		{up, 80, "release"},
+	}
+	for i, st := range steps {
+		evts := s.scan(st.m, at(st.ms))
+		got := ""
+		if len(evts) == 1 {
+			got = evts[0].kind
+			if evts[0].row != 1 || evts[0].col != 0 {
+				t.Errorf("step %d key got (%d, %d) want (1, 0)", i, evts[0].row, evts[0].col)
+			}
+		} else if len(evts) > 1 {
+			got = "many"
+		}
+		if got != st.want {
+			t.Errorf("step %d scan() got (%q) want (%q)", i, got, st.want)
+		}
+	}
+	if !s.idle() {
+		t.Error("idle() after the release got (false)")
+	}
+}
+
+func TestScannerGhost(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	s := newScanner(3, 3)
+
+	two := grid(3, 3)
+	two[0][0], two[1][1] = true, true
+	if evts := s.scan(two, t0); len(evts) != 2 || ghosting(two) {
+		t.Fatalf("scan(two keys) got (%v) want two presses", evts)
+	}
+
+	// a third key on the corner of a rectangle reads the fourth too
+	ghost := grid(3, 3)
+	ghost[0][0], ghost[1][1], ghost[0][1], ghost[1][0] = true, true, true, true
+	evts := s.scan(ghost, t0.Add(time.Millisecond))
+	if len(evts) != 1 || evts[0].kind != "ghost" {
+		t.Fatalf("scan(ghost) got (%v) want a ghost event", evts)
+	}
+	if evts := s.scan(ghost, t0.Add(2*time.Millisecond)); len(evts) != 0 {
+		t.Errorf("scan(still ghost) got (%v) want none", evts)
+	}
+	if s.idle() {
+		t.Error("idle() while ghosting got (true)")
+	}
+
+	// the keys before the ghost were kept
+	if evts := s.scan(two, t0.Add(3*time.Millisecond)); len(evts) != 0 {
+		t.Errorf("scan(two keys again) got (%v) want none", evts)
+	}
+}
+
+func TestScannerRepeat(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	s := newScanner(1, 2)
+	s.debounce, s.delay, s.rate = 10*time.Millisecond, 500*time.Millisecond, 100*time.Millisecond
+
+	key := [][]bool{{true, false}}
+	var repeats []int
+	for ms := 0; ms <= 1000; ms += 10 {
+		for _, e := range s.scan(key, at(ms)) {
+			if e.kind == "repeat" {
+				repeats = append(repeats, ms)
+			}
+		}
+	}
+	want := []int{510, 610, 710, 810, 910}
+	if len(repeats) != len(want) {
+		t.Fatalf("repeats got (%v) want (%v)", repeats, want)
+	}
+	for i := range want {
+		if repeats[i] != want[i] {
+			t.Errorf("repeats got (%v) want (%v)", repeats, want)
+			break
+		}
+	}
+
+	// a slow scan gets one repeat, not a burst
+	if evts := s.scan(key, at(1500)); len(evts) != 1 {
+		t.Errorf("scan() late got (%v) want one repeat", evts)
+	}
+	if evts := s.scan(key, at(1550)); len(evts) != 0 {
+		t.Errorf("scan() after the late repeat got (%v) want none", evts)
+	}
+
+	// two keys held do not repeat
+	both := [][]bool{{true, true}}
+	s.scan(both, at(1600))
+	for ms := 1610; ms < 3000; ms += 10 {
+		for _, e := range s.scan(both, at(ms)) {
+			if e.kind != "repeat" {
+				continue
+			}
+			t.Fatalf("scan(two keys) at %dms got (%v)", ms, e)
+		}
+	}
+}
+
+// matrix is a keypad on a fake chip, rows on the offsets 0 - 3 and
+// columns on 10 - 13. A column reads active through the keys pressed
+// from any row strobed, ghosting the way a matrix without diodes
+// does.
+type matrix struct {
+	*driverstest.Chip
+	pressed [4][4]bool
+	cols    [4]*column
+	mu      sync.Mutex
+}
+
+type column struct {
+	m       *matrix
+	c       int
+	handler gpiocdev.EventHandler
+	closed  bool
+}
+
+func newMatrix(t *testing.T) *matrix {
+	m := &matrix{Chip: driverstest.NewChip()}
+	driverstest.UseGPIO(t, m)
+	return m
+}
+
+func (m *matrix) RequestLine(offset int, opts ...gpiocdev.LineReqOption) (drivers.Line, error) {
+	if offset < 10 {
+		return m.Chip.RequestLine(offset, opts...)
+	}
+	col := &column{m: m, c: offset - 10}
+	for _, o := range opts {
+		if h, ok := o.(gpiocdev.EventHandler); ok {
+			col.handler = h
+		}
+	}
+	m.mu.Lock()
+	m.cols[col.c] = col
+	m.mu.Unlock()
+	return col, nil
+}
+
+// press presses or releases the key, a column going active fires its
+// handler
+func (m *matrix) press(r, c int, down bool) {
+	m.mu.Lock()
+	m.pressed[r][c] = down
+	col := m.cols[c]
+	m.mu.Unlock()
+	if v, _ := col.Value(); v == 1 && col.handler != nil {
+		col.handler(gpiocdev.LineEvent{Offset: 10 + c, Type: gpiocdev.LineEventRisingEdge})
+	}
+}
+
+// active returns the columns connected to a strobed row
+func (m *matrix) active() [4]bool {
+	var rows, cols [4]bool
+	for r := range rows {
+		if l := m.Line(r); l != nil {
+			v, _ := l.Value()
+			rows[r] = v == 1
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for changed := true; changed; {
+		changed = false
+		for r := range rows {
+			for c := range cols {
+				if m.pressed[r][c] && rows[r] != cols[c] {
+					rows[r], cols[c], changed = true, true, true
+				}
+			}
+		}
+	}
+	return cols
+}
+
+func (c *column) Offset() int                                    { return 10 + c.c }
+func (c *column) SetValue(int) error                             { return errors.New("input") }
+func (c *column) Reconfigure(...gpiocdev.LineConfigOption) error { return nil }
+
+func (c *column) Value() (int, error) {
+	if c.closed {
+		return 0, driverstest.ErrClosed
+	}
+	if c.m.active()[c.c] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (c *column) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newKeypad(t *testing.T) (*Keypad, *matrix) {
+	device.Mock(false)
+	m := newMatrix(t)
+	k, err := New("door", []string{"0", "1", "2", "3"}, []string{"10", "11", "12", "13"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	k.SetDebounce(0)
+	return k, m
+}
+
+func TestNew(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	if _, err := New("pad", []string{"1", "2", "3"}, []string{"4", "5"}); !errors.Is(err, ErrConfig) {
+		t.Errorf("New(3x2 without keymap) error got (%v) want (%v)", err, ErrConfig)
+	}
+	if _, err := New("pad", []string{"1", "2"}, []string{"4", "5"}, "12", "345"); !errors.Is(err, ErrConfig) {
+		t.Errorf("New(short keymap row) error got (%v) want (%v)", err, ErrConfig)
+	}
+	k, err := New("pad", []string{"1", "2", "3", "4"}, []string{"5", "6", "7"})
+	if err != nil || k.keys[3][2] != '#' {
+		t.Errorf("New(4x3) got (%v) want the 4x3 keymap", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	k, m := newKeypad(t)
+	defer k.Close()
+
+	m.press(2, 1, true)
+	got, err := k.read()
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	for r := range got {
+		for c, v := range got[r] {
+			if v != (r == 2 && c == 1) {
+				t.Errorf("read() key (%d, %d) got (%t)", r, c, v)
+			}
+		}
+	}
+	// the rows are released after the scan
+	for r := 0; r < 4; r++ {
+		if v, _ := m.Line(r).Value(); v != 0 {
+			t.Errorf("row %d left strobed", r)
+		}
+	}
+
+	// three keys on a rectangle read the fourth
+	m.press(2, 3, true)
+	m.press(0, 1, true)
+	got, _ = k.read()
+	if !got[0][3] || !ghosting(got) {
+		t.Errorf("read() of three keys got (%v) want the fourth ghosted", got)
+	}
+
+	k.Close()
+	if !m.Line(0).Closed() || !m.cols[0].closed {
+		t.Error("Close() did not release the lines")
+	}
+	if err := k.Scan(); err == nil {
+		t.Error("Scan() after Close() got no error")
+	}
+}
+
+func TestEntry(t *testing.T) {
+	k, m := newKeypad(t)
+	defer k.Close()
+	clock := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	k.now = func() time.Time { return clock }
+	k.SetEntry(true, 10*time.Second)
+
+	var codes []string
+	k.OnCode(func(code string) { codes = append(codes, code) })
+	typeKey := func(r, c int) {
+		for _, down := range []bool{true, false} {
+			m.press(r, c, down)
+			clock = clock.Add(100 * time.Millisecond)
+			if err := k.Scan(); err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+		}
+	}
+
+	typeKey(0, 0) // 1
+	typeKey(0, 1) // 2
+	typeKey(3, 0) // * clears
+	typeKey(2, 2) // 9
+	typeKey(3, 1) // 0
+	typeKey(3, 2) // #
+	if len(codes) != 1 || codes[0] != "90" {
+		t.Fatalf("codes got (%q) want [90]", codes)
+	}
+
+	// a code left too long starts over
+	typeKey(1, 0) // 4
+	clock = clock.Add(time.Minute)
+	typeKey(1, 1) // 5
+	typeKey(3, 2)
+	if len(codes) != 2 || codes[1] != "5" {
+		t.Errorf("codes got (%q) want [90 5]", codes)
+	}
+}
+
+func TestRun(t *testing.T) {
+	k, m := newKeypad(t)
+	defer k.Close()
+	k.SetRepeat(0, 0)
+	k.SetEntry(true, 0)
+	codes := make(chan string, 1)
+	k.OnCode(func(code string) { codes <- code })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- k.Run(ctx, time.Millisecond) }()
+
+	for _, key := range [][2]int{{0, 2}, {1, 3}, {3, 2}} {
+		m.press(key[0], key[1], true)
+		time.Sleep(20 * time.Millisecond)
+		m.press(key[0], key[1], false)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case code := <-codes:
+		if code != "3B" {
+			t.Errorf("code got (%q) want (3B)", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Run() got no code")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error got (%v) want (%v)", err, context.Canceled)
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	k, err := New("pad", []string{"1", "2", "3", "4"}, []string{"5", "6", "7", "8"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	k.SetDebounce(0)
+	var codes []string
+	k.SetEntry(true, 0)
+	k.OnCode(func(code string) { codes = append(codes, code) })
+	for _, key := range [][2]int{{0, 3}, {3, 3}, {3, 2}} {
+		k.MockKey(key[0], key[1], true)
+		k.Scan()
+		k.MockKey(key[0], key[1], false)
+		k.Scan()
+	}
+	if len(codes) != 1 || codes[0] != "AD" {
+		t.Errorf("codes got (%q) want [AD]", codes)
+	}
+}
@@ -0,0 +1,147 @@
+package keypad
+
+import "time"
+
+// event is what a scan found, a key pressed, released or repeated,
+// or the matrix starting to ghost
+type event struct {
+	kind string // "press", "release", "repeat" or "ghost"
+	row  int
+	col  int
+}
+
+// scanner debounces the scans of the matrix. A key changes once it
+// has read the same for the debounce time. Three keys pressed on the
+// corners of a rectangle connect the fourth corner too, without a
+// diode on every key that reads as pressed and can not be told from
+// four keys pressed. A scan with a rectangle in it is ghosting and
+// changes nothing. A single key held down repeats after the delay at
+// the rate.
+type scanner struct {
+	debounce time.Duration
+	delay    time.Duration
+	rate     time.Duration
+
+	raw   [][]bool // as last scanned
+	since [][]time.Time
+	down  [][]bool // debounced
+	ghost bool
+
+	held   bool // a single key is held down
+	heldR  int
+	heldC  int
+	repeat time.Time // when the held key repeats next
+}
+
+func newScanner(rows, cols int) *scanner {
+	s := &scanner{}
+	s.raw, s.down = grid(rows, cols), grid(rows, cols)
+	s.since = make([][]time.Time, rows)
+	for r := range s.since {
+		s.since[r] = make([]time.Time, cols)
+	}
+	return s
+}
+
+func grid(rows, cols int) [][]bool {
+	g := make([][]bool, rows)
+	for r := range g {
+		g[r] = make([]bool, cols)
+	}
+	return g
+}
+
+// scan takes the keys read pressed at at and returns the events, in
+// the order of the rows and the columns
+func (s *scanner) scan(m [][]bool, at time.Time) []event {
+	if ghosting(m) {
+		if s.ghost {
+			return nil
+		}
+		s.ghost = true
+		return []event{{kind: "ghost"}}
+	}
+	s.ghost = false
+
+	var evts []event
+	for r := range m {
+		for c, v := range m[r] {
+			if v != s.raw[r][c] {
+				s.raw[r][c], s.since[r][c] = v, at
+			}
+			if v == s.down[r][c] || at.Sub(s.since[r][c]) < s.debounce {
+				continue
+			}
+			s.down[r][c] = v
+			kind := "release"
+			if v {
+				kind = "press"
+			}
+			evts = append(evts, event{kind: kind, row: r, col: c})
+		}
+	}
+	return append(evts, s.repeats(at)...)
+}
+
+// repeats returns the repeat of a single key held down if it is due
+func (s *scanner) repeats(at time.Time) []event {
+	n, row, col := 0, 0, 0
+	for r := range s.down {
+		for c, v := range s.down[r] {
+			if v {
+				n, row, col = n+1, r, c
+			}
+		}
+	}
+	if n != 1 || s.delay <= 0 || s.rate <= 0 {
+		s.held = false
+		return nil
+	}
+	if !s.held || s.heldR != row || s.heldC != col {
+		s.held, s.heldR, s.heldC = true, row, col
+		s.repeat = s.since[row][col].Add(s.debounce).Add(s.delay)
+	}
+	if at.Before(s.repeat) {
+		return nil
+	}
+	s.repeat = s.repeat.Add(s.rate)
+	if !s.repeat.After(at) {
+		// the scans fell behind, do not burst to catch up
+		s.repeat = at.Add(s.rate)
+	}
+	return []event{{kind: "repeat", row: row, col: col}}
+}
+
+// idle reports if no key is down or on its way, the matrix can wait
+// for a column to change
+func (s *scanner) idle() bool {
+	if s.ghost {
+		return false
+	}
+	for r := range s.raw {
+		for c := range s.raw[r] {
+			if s.raw[r][c] || s.down[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ghosting reports if two rows have two columns pressed in common
+func ghosting(m [][]bool) bool {
+	for r1 := 0; r1 < len(m); r1++ {
+		for r2 := r1 + 1; r2 < len(m); r2++ {
+			common := 0
+			for c := range m[r1] {
+				if m[r1][c] && m[r2][c] {
+					common++
+				}
+			}
+			if common >= 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package device
+
+import (
+	"context"
+	"time"
+)
+
+// MaxMockLatency bounds the delay of a mock read, a stall included
+const MaxMockLatency = time.Minute
+
+// Jitter is how the delay of a mock read spreads around its mean
+type Jitter int
+
+const (
+	JitterNone    Jitter = iota
+	JitterUniform        // within the mean ± the spread
+	JitterNormal         // the spread the standard deviation
+)
+
+// latency is the delay of the mock reads of a device, see SetLatency
+type latency struct {
+	mean   time.Duration
+	jitter Jitter
+	spread time.Duration
+	stallP float64
+	stall  time.Duration
+}
+
+// LatencyOption configures the delay of the mock reads of a device
+type LatencyOption func(*latency)
+
+// LatencyDelay sets the mean delay of a read
+func LatencyDelay(mean time.Duration) LatencyOption {
+	return func(l *latency) {
+		l.mean = mean
+	}
+}
+
+// LatencyJitter spreads the delays around the mean
+func LatencyJitter(j Jitter, spread time.Duration) LatencyOption {
+	return func(l *latency) {
+		l.jitter, l.spread = j, spread
+	}
+}
+
+// LatencyStall stalls a read for d more with the probability p, the
+// long tail of a sensor clock stretching or a bus held by another
+func LatencyStall(p float64, d time.Duration) LatencyOption {
+	return func(l *latency) {
+		l.stallP, l.stall = min(max(p, 0), 1), d
+	}
+}
+
+// SetLatency delays the mock reads of the device, for the watchdogs
+// and the timeouts of the reads to be tested before the hardware
+// misbehaves. The delays are of its MockRand, the same for the same
+// seed, and bounded by 0 and MaxMockLatency. No options remove them.
+func (d *Device) SetLatency(opts ...LatencyOption) {
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(opts) == 0 {
+		d.latency = nil
+		return
+	}
+	l := &latency{}
+	for _, opt := range opts {
+		opt(l)
+	}
+	d.latency = l
+}
+
+// MockDelay returns the delay of the next mock read of the device, 0
+// without a latency set
+func (d *Device) MockDelay() time.Duration {
+	d.mu.Lock()
+	l := d.latency
+	d.mu.Unlock()
+	if l == nil {
+		return 0
+	}
+	r := d.MockRand()
+	delay := l.mean
+	switch l.jitter {
+	case JitterUniform:
+		delay += time.Duration((r.Float64()*2 - 1) * float64(l.spread))
+	case JitterNormal:
+		delay += time.Duration(r.NormFloat64() * float64(l.spread))
+	}
+	if l.stallP > 0 && r.Float64() < l.stallP {
+		delay += l.stall
+	}
+	return min(max(delay, 0), MaxMockLatency)
+}
+
+// MockWait waits for the delay of a mock read by the clock of the
+// device, its package calls it in the mock read path. It returns the
+// error of ctx when it is done first, the read abandoned.
+func (d *Device) MockWait(ctx context.Context) error {
+	delay := d.MockDelay()
+	if delay == 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-d.Clock().After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
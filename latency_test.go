@@ -0,0 +1,91 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	d := NewDevice("probe", "mqtt")
+	d.SetMockSeed(1)
+	if got := d.MockDelay(); got != 0 {
+		t.Errorf("MockDelay() without latency got (%v)", got)
+	}
+
+	// the mean and the standard deviation of n delays, in ms
+	stats := func(n int) (mean, sd float64, delays []time.Duration) {
+		for range n {
+			delay := d.MockDelay()
+			delays = append(delays, delay)
+			mean += float64(delay) / float64(time.Millisecond)
+		}
+		mean /= float64(n)
+		for _, delay := range delays {
+			diff := float64(delay)/float64(time.Millisecond) - mean
+			sd += diff * diff
+		}
+		return mean, math.Sqrt(sd / float64(n)), delays
+	}
+
+	d.SetLatency(LatencyDelay(10 * time.Millisecond))
+	if got := d.MockDelay(); got != 10*time.Millisecond {
+		t.Errorf("fixed MockDelay() got (%v) want (10ms)", got)
+	}
+
+	d.SetLatency(LatencyDelay(10*time.Millisecond), LatencyJitter(JitterUniform, 5*time.Millisecond))
+	mean, _, delays := stats(2000)
+	for _, delay := range delays {
+		if delay < 5*time.Millisecond || delay > 15*time.Millisecond {
+			t.Fatalf("uniform delay got (%v) want 5 - 15ms", delay)
+		}
+	}
+	if math.Abs(mean-10) > 0.5 {
+		t.Errorf("uniform mean got %.2fms want 10ms", mean)
+	}
+
+	d.SetLatency(LatencyDelay(20*time.Millisecond), LatencyJitter(JitterNormal, 2*time.Millisecond))
+	if mean, sd, _ := stats(2000); math.Abs(mean-20) > 0.5 || math.Abs(sd-2) > 0.3 {
+		t.Errorf("normal got mean %.2fms sd %.2fms want 20ms and 2ms", mean, sd)
+	}
+
+	d.SetLatency(LatencyDelay(time.Millisecond), LatencyStall(0.1, time.Second))
+	_, _, delays = stats(2000)
+	var stalled int
+	for _, delay := range delays {
+		if delay > time.Second {
+			stalled++
+		}
+	}
+	if p := float64(stalled) / 2000; p < 0.07 || p > 0.13 {
+		t.Errorf("stalled got %.3f want 0.1", p)
+	}
+
+	// bounded
+	d.SetLatency(LatencyDelay(-time.Second))
+	if got := d.MockDelay(); got != 0 {
+		t.Errorf("negative MockDelay() got (%v) want (0)", got)
+	}
+	d.SetLatency(LatencyStall(2, time.Hour))
+	if got := d.MockDelay(); got != MaxMockLatency {
+		t.Errorf("stalled an hour MockDelay() got (%v) want (%v)", got, MaxMockLatency)
+	}
+
+	// a stall cut short, every time
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.MockWait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("MockWait() stalled error got (%v) want (%v)", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("MockWait() took %v past the deadline", elapsed)
+	}
+
+	d.SetLatency()
+	if err := d.MockWait(context.Background()); err != nil || d.MockDelay() != 0 {
+		t.Errorf("MockWait() latency removed error got (%v)", err)
+	}
+}
@@ -9,6 +9,14 @@ import (
 type LED struct {
 	*device.Device
 	*drivers.DigitalPin
+
+	// PWM dims the led, set it to a hardware or PCA9685 channel
+	// (drivers.OpenPWM) with its frequency set before calling
+	// SetBrightness. When it is not set SetBrightness falls back to
+	// a soft PWM on the led's pin.
+	PWM drivers.PWMChannel
+
+	commands *device.Router
 }
 
 func New(name string, offset int) *LED {
@@ -16,17 +24,78 @@ func New(name string, offset int) *LED {
 	       Device: device.NewDevice(name, "mqtt"),
        }
 	g := drivers.GetGPIO()
-	led.DigitalPin = g.Pin(name, offset, gpiocdev.AsOutput(0))
+	led.DigitalPin = g.Pin(name, offset, drivers.WithOwner("led"), gpiocdev.AsOutput(0))
+	led.commands = commands(led.Device, led.DigitalPin)
 	return led
 }
 
-func (l *LED) Callback(msg *messanger.Msg) {
-	switch msg.String() {
-	case "off", "OFF", "Off", "0":
-		l.Off()
+// Listen subscribes the led to its ControlTopic, it takes "on",
+// "off" and "toggle", plain or in a command envelope acked on its
+// AckTopic, and publishes its value on its StateTopic
+func (l *LED) Listen() error {
+	return l.commands.Listen()
+}
+
+func (l *LED) Callback(msg *device.Msg) {
+	l.commands.Dispatch(msg)
+}
+
+// Entities is the led as a light of Home Assistant, switched by
+// the "1" and "0" commands and its state the value of its pin
+func (l *LED) Entities() []device.Entity {
+	return []device.Entity{{
+		Component:    "light",
+		Icon:         "mdi:led-on",
+		StateTopic:   l.StateTopic(),
+		CommandTopic: l.ControlTopic(),
+		PayloadOn:    "1",
+		PayloadOff:   "0",
+	}}
+}
+
+// commands routes the commands of a pin to it, the value of the pin
+// published on the device's StateTopic after each
+func commands(d *device.Device, pin *drivers.DigitalPin) *device.Router {
+	rt := device.NewRouter(d)
+	set := func(fn func() error) device.CommandFunc {
+		return func(*device.Command) error {
+			if err := fn(); err != nil {
+				return err
+			}
+			v, err := pin.Value()
+			if err != nil {
+				return err
+			}
+			// the command was executed, a state lost is the
+			// device's error
+			d.PubState(v)
+			return nil
+		}
+	}
+	rt.Handle("on", set(pin.On))
+	rt.Handle("1", set(pin.On))
+	rt.Handle("off", set(pin.Off))
+	rt.Handle("0", set(pin.Off))
+	rt.Handle("toggle", set(pin.Toggle))
+	return rt
+}
+
+// SetBrightness dims the led, brightness is a fraction 0.0 - 1.0
+func (l *LED) SetBrightness(brightness float64) error {
+	if l.PWM == nil {
+		pwm, err := drivers.NewSoftPWM(l.DigitalPin, drivers.DefaultSoftPWMFreq)
+		if err != nil {
+			return err
+		}
+		l.PWM = pwm
+	}
+	return l.PWM.SetDuty(brightness)
+}
 
-	case "on", "ON", "On", "1":
-		l.On()
+// Close releases the pwm channel and the pin used by the led
+func (l *LED) Close() error {
+	if l.PWM != nil {
+		l.PWM.Close()
 	}
-	return
+	return l.DigitalPin.Close()
 }
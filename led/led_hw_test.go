@@ -0,0 +1,66 @@
+//go:build hardware
+
+package led
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// TestHardwareLED switches the led on OTTO_LED_PIN on and off by its
+// commands, its value read back on OTTO_LED_READBACK_PIN when the led
+// is wired to an input too. OTTO_GPIO_CHIP is the chip of the led
+// when it is not gpiochip0.
+func TestHardwareLED(t *testing.T) {
+	offset := devicetest.HardwareInt(t, "OTTO_LED_PIN", "the offset of the line of the LED")
+	if chip := os.Getenv("OTTO_GPIO_CHIP"); chip != "" {
+		drivers.DefaultChipname = chip
+	}
+	defer device.Mock(device.IsMock())
+	device.Mock(false)
+
+	led := New("hw-led", offset)
+	defer led.Close()
+	if _, err := led.Get(); err != nil {
+		t.Fatalf("led on %s %d error = %v", drivers.DefaultChipname, offset, err)
+	}
+
+	var readback *drivers.DigitalPin
+	if id := os.Getenv("OTTO_LED_READBACK_PIN"); id != "" {
+		var err error
+		readback, err = drivers.NewDigitalPinID("hw-led-readback", id, gpiocdev.AsInput)
+		if err != nil {
+			t.Fatalf("NewDigitalPinID(%s) error = %v", id, err)
+		}
+		defer readback.Close()
+	} else {
+		t.Log("OTTO_LED_READBACK_PIN not set, the led is not read back")
+	}
+
+	for _, tt := range []struct {
+		cmd  string
+		want int
+	}{
+		{"on", 1}, {"off", 0}, {"toggle", 1}, {"toggle", 0},
+	} {
+		if err := led.commands.Execute(device.NewMsg(led.ControlTopic(), []byte(tt.cmd), "hw")); err != nil {
+			t.Fatalf("%s error = %v", tt.cmd, err)
+		}
+		if got, err := led.Get(); err != nil || got != tt.want {
+			t.Errorf("%s line got (%d %v) want (%d)", tt.cmd, got, err, tt.want)
+		}
+		if readback == nil {
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+		if got, err := readback.Get(); err != nil || got != tt.want {
+			t.Errorf("%s read back got (%d %v) want (%d)", tt.cmd, got, err, tt.want)
+		}
+	}
+}
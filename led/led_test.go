@@ -1,39 +1,158 @@
 package led
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/rustyeddy/otto-devices/device"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
 )
 
 func TestLED(t *testing.T) {
 	device.Mock(true)
+	c := devicetest.Use(t)
 
 	led := New("led", 5)
-	if led.Name() != "led" {
-		t.Errorf("led name got (%s) want (%s)", led.Name(), "led")
+	if led.Name != "led" {
+		t.Errorf("led name got (%s) want (%s)", led.Name, "led")
+	}
+	if err := led.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
 	}
 
-	msg := messanger.NewMsg(led.Topic(), []byte("on"), "test")
-	led.Callback(msg)
+	// the state is the value of the pin after each command, retained
+	for _, tt := range []struct {
+		cmd  string
+		want string
+	}{
+		{"on", "1"}, {"off", "0"}, {"toggle", "1"}, {"0", "0"}, {"1", "1"},
+	} {
+		c.Inject(led.ControlTopic(), []byte(tt.cmd))
+		msg := c.ExpectPublish(led.StateTopic(), devicetest.Any(), devicetest.Timeout)
+		var env device.Envelope
+		if err := json.Unmarshal(msg.Data, &env); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", msg, err)
+		}
+		if string(env.Data) != tt.want || env.Device != "led" || !msg.Retained {
+			t.Errorf("%s state got (%s) want data (%s) retained", tt.cmd, msg, tt.want)
+		}
+	}
+	if led.LastChanged().IsZero() {
+		t.Errorf("led LastChanged() not set")
+	}
+	driverstest.ExpectSequence(t, led.Waveform(), 0, 1, 0, 1, 0, 1)
+}
 
-	v, err := led.Value()
-	if err != nil {
-		t.Fatalf("led.Value() got error %v", err)
+// TestLEDBlink blinks the led by its commands on a SimClock, the
+// waveform of its line timed to the tick
+func TestLEDBlink(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	led := New("blinker", 11)
+	if err := led.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
 	}
-	if v != 1 {
-		t.Errorf("led expected (1) got (%d)", v)
+	for range 5 {
+		for _, cmd := range []string{"on", "off"} {
+			c.Inject(led.ControlTopic(), []byte(cmd))
+			c.ExpectPublish(led.StateTopic(), devicetest.Any(), devicetest.Timeout)
+			clock.Advance(250 * time.Millisecond)
+		}
 	}
 
-	msg = messanger.NewMsg(led.Topic(), []byte("off"), "test")
-	led.Callback(msg)
+	w := led.Waveform()
+	driverstest.ExpectSequence(t, w, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0)
+	driverstest.ExpectPulseWidth(t, w, 1, 250*time.Millisecond, 250*time.Millisecond)
+	driverstest.ExpectPulseWidth(t, w, 0, 250*time.Millisecond, 250*time.Millisecond)
+	driverstest.ExpectFrequency(t, w, 0, 2500*time.Millisecond, 2, 0)
+	driverstest.ExpectDuty(t, w, 0, 2500*time.Millisecond, 0.5, 0)
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestLEDDiscovery(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
+
+	led := New("porch", 7)
+	if err := led.PubDiscovery(led.Entities()); err != nil {
+		t.Fatalf("PubDiscovery() error = %v", err)
+	}
+	configs := make(map[string]json.RawMessage)
+	for _, msg := range c.Messages("homeassistant/#") {
+		configs[msg.Topic] = msg.Data
+	}
+	got, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent() error = %v", err)
+	}
+	got = append(got, '\n')
 
-	v, err = led.Value()
+	golden := filepath.Join("testdata", "discovery.json")
+	if *update {
+		os.MkdirAll("testdata", 0o755)
+		os.WriteFile(golden, got, 0o644)
+	}
+	want, err := os.ReadFile(golden)
 	if err != nil {
-		t.Fatalf("led.Value() got error %v", err)
+		t.Fatalf("ReadFile() error = %v", err)
 	}
-	if v != 0 {
-		t.Errorf("led expected (0) got (%d)", v)
+	if string(got) != string(want) {
+		t.Errorf("discovery got\n%s\nwant\n%s", got, want)
 	}
+}
 
+// FuzzLEDCallback feeds payloads to the commands of the Callback of
+// a led: one that is not a command of it fails with an ErrBadPayload,
+// "on" and "off" set its pin
+func FuzzLEDCallback(f *testing.F) {
+	for _, seed := range []string{
+		"on", "off", "1", "0", "toggle", " Toggle\n", "", "blink",
+		`{"id":"1","cmd":"on"}`,
+		`{"cmd":"OFF"}`,
+		`{"cmd":"fault","args":{"every":2}}`,
+		`{"mock":{"brightness":0.5}}`,
+		`{"id":"2"}`,
+		`{"cmd":`, `null`,
+	} {
+		f.Add([]byte(seed))
+	}
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	led := New("fuzz-led", 9)
+	defer led.Close()
+	led.commands.Window = -1 // every ID a new command, none a replay
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		err := led.commands.Execute(device.NewMsg(led.ControlTopic(), payload, "fuzz"))
+		cmd, perr := device.ParseCommand(payload)
+		if err != nil {
+			if !errors.Is(err, device.ErrBadPayload) {
+				t.Errorf("Execute(%q) error got (%v) want an ErrBadPayload", payload, err)
+			}
+			return
+		}
+		if perr != nil {
+			t.Fatalf("Execute(%q) succeeded, ParseCommand() error (%v)", payload, perr)
+		}
+		want := map[string]int{"on": 1, "1": 1, "off": 0, "0": 0}
+		switch name := strings.ToLower(cmd.Cmd); name {
+		case "on", "1", "off", "0":
+			if v, _ := led.Value(); v != want[name] {
+				t.Errorf("Execute(%q) pin got (%d) want (%d)", payload, v, want[name])
+			}
+		case "toggle", "fault", "mock":
+		default:
+			t.Errorf("Execute(%q) succeeded for (%s)", payload, cmd.Cmd)
+		}
+		led.InjectFaults()
+	})
 }
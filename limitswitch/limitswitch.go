@@ -0,0 +1,356 @@
+// Package limitswitch provides endstops, a mechanical or optical limit
+// switch on a GPIO line, that stop the actuators they guard.
+//
+// The line is pulled up and the switch connects it to ground. A
+// normally closed switch is the fail safe wiring: a broken wire opens
+// the circuit like a trip does and the two can not be told apart, so
+// an open circuit is always tripped. One read open when the line is
+// requested is reported as a fault until it has been seen closed.
+//
+// The actuators registered with Interlock, relays and steppers in the
+// DeviceManager, are stopped from the edge handler the moment the
+// switch trips, without a round trip through the broker, and those
+// that are an Inhibitor nack the commands driving them, like "on" or
+// "move", until the switch is clear. The trip is taken at the first
+// edge, the switch is clear once the contact stayed
+// clear for the debounce, or with SetLatch once it is also reset. The
+// state is published on every change.
+package limitswitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// Wiring tells how the switch behaves when the actuator reaches it
+type Wiring int
+
+const (
+	// NormallyOpen switches close at the limit
+	NormallyOpen Wiring = iota
+	// NormallyClosed switches open at the limit
+	NormallyClosed
+)
+
+// DefaultDebounce is how long the contact stays clear before the
+// switch is clear
+const DefaultDebounce = 20 * time.Millisecond
+
+var (
+	ErrTripped  = errors.New("limit switch still tripped")
+	ErrActuator = errors.New("interlock actuator")
+	ErrCommand  = errors.New("unknown command")
+)
+
+// Stopper is an actuator with a move to stop, like a stepper
+type Stopper interface {
+	Stop()
+}
+
+// Switch is an actuator to turn off, like a relay
+type Switch interface {
+	Off() error
+}
+
+// Inhibitor is an actuator holding back the commands driving it while
+// the switch is tripped, a relay or a stepper by their device
+type Inhibitor interface {
+	Inhibit(by string, on bool)
+}
+
+// Status is what is published on a change and by ReadPub. Latched
+// is a switch clear again but waiting for a reset, Fault an open
+// circuit that was never seen closed.
+type Status struct {
+	State      State     `json:"state"`
+	Latched    bool      `json:"latched,omitempty"`
+	Fault      bool      `json:"fault,omitempty"`
+	Trips      int       `json:"trips"`
+	LastChange time.Time `json:"last_change"`
+}
+
+// LimitSwitch is an endstop on a GPIO line
+type LimitSwitch struct {
+	*device.Device
+
+	pin        *drivers.DigitalPin
+	trk        tracker
+	timer      *device.Timer
+	interlocks []string
+
+	mu sync.Mutex
+}
+
+// New creates a limit switch on the line at offset of the default
+// chip. opts are added to the line request after the pull up.
+func New(name string, offset int, wiring Wiring, opts ...gpiocdev.LineReqOption) (*LimitSwitch, error) {
+	l := &LimitSwitch{
+		Device: device.NewDevice(name, "mqtt"),
+		trk:    tracker{wiring: wiring, debounce: DefaultDebounce},
+	}
+	if device.IsMock() {
+		l.trk.start(wiring == NormallyClosed, l.Now()) // clear
+		return l, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("limitswitch"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(l.edge),
+	}, opts...)
+
+	// no edge is handled before the initial state is known
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := pin.Value()
+	if err != nil {
+		pin.Close()
+		return nil, err
+	}
+	l.pin = pin
+	l.trk.start(v == 1, l.Now())
+	if l.trk.fault {
+		slog.Warn("limitswitch open circuit, tripped or a broken wire", "device", name)
+	}
+	return l, nil
+}
+
+// Name returns the name of the device
+func (l *LimitSwitch) Name() string {
+	return l.Device.Name
+}
+
+// SetDebounce sets how long the contact stays clear before the switch
+// is clear
+func (l *LimitSwitch) SetDebounce(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trk.debounce = d
+}
+
+// SetLatch makes a tripped switch stay tripped until Reset, or clear
+// by itself
+func (l *LimitSwitch) SetLatch(on bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trk.latch = on
+}
+
+// Interlock sets the actuators stopped when the switch trips, the
+// names of devices in the DeviceManager that are a Stopper or a
+// Switch, and inhibited until it is clear. None removes the interlock.
+// They are stopped at once if the switch is tripped, the ones removed
+// let go.
+func (l *LimitSwitch) Interlock(names ...string) error {
+	l.mu.Lock()
+	old := l.interlocks
+	l.interlocks = append([]string(nil), names...)
+	tripped := l.trk.tripped
+	l.mu.Unlock()
+	if !tripped {
+		return nil
+	}
+	l.inhibit(old, false)
+	return l.stop(names)
+}
+
+// Tripped reports if the switch is tripped
+func (l *LimitSwitch) Tripped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.trk.tripped
+}
+
+// Status returns the state of the switch
+func (l *LimitSwitch) Status() Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status()
+}
+
+func (l *LimitSwitch) status() Status {
+	return Status{
+		State:      l.trk.state(),
+		Latched:    l.trk.latched,
+		Fault:      l.trk.fault,
+		Trips:      l.trk.trips,
+		LastChange: l.trk.lastChange,
+	}
+}
+
+// Reset clears a latched switch, it fails while the contact is
+// tripped
+func (l *LimitSwitch) Reset() error {
+	l.mu.Lock()
+	was := l.trk.tripped
+	ok := l.trk.reset(l.Now())
+	s := l.status()
+	names := l.interlocks
+	l.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTripped, l.Device.Name)
+	}
+	if was {
+		l.inhibit(names, false)
+		return l.publish(s)
+	}
+	return nil
+}
+
+// Command handles a command payload: "reset"
+func (l *LimitSwitch) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "reset":
+		return l.Reset()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub publishes the Status
+func (l *LimitSwitch) ReadPub() error {
+	return l.publish(l.Status())
+}
+
+// Run publishes the Status every period until ctx is canceled, so a
+// late subscriber learns the state without waiting for a change
+func (l *LimitSwitch) Run(ctx context.Context, period time.Duration) error {
+	err := l.TimerLoop(ctx, period, l.ReadPub)
+	slog.Debug("limitswitch stopped", "device", l.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the debounce timer and releases the line
+func (l *LimitSwitch) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if l.pin == nil {
+		return nil
+	}
+	err := l.pin.Close()
+	l.pin = nil
+	return err
+}
+
+// MockContact closes (1) or opens (0) the contact in mock mode
+func (l *LimitSwitch) MockContact(v int) {
+	l.handle(v == 1, l.Now())
+}
+
+func (l *LimitSwitch) edge(evt gpiocdev.LineEvent) {
+	l.handle(evt.Type == gpiocdev.LineEventRisingEdge, l.Now())
+}
+
+// handle runs the tracker for an edge at t, a trip stops the
+// interlocked actuators before anything else
+func (l *LimitSwitch) handle(active bool, t time.Time) {
+	l.mu.Lock()
+	trip := l.trk.edge(active, t)
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if l.trk.tripped && !l.trk.latched && !l.trk.clearAt.IsZero() {
+		wait := l.trk.clearAt.Add(l.trk.debounce).Sub(t)
+		l.timer = l.Clock().AfterFunc(max(wait, 0), func() { l.settle(l.Now()) })
+	}
+	names := l.interlocks
+	s := l.status()
+	l.mu.Unlock()
+
+	if !trip {
+		return
+	}
+	if err := l.stop(names); err != nil {
+		slog.Error("limitswitch interlock", "device", l.Device.Name, "error", err)
+	}
+	l.publish(s)
+}
+
+// settle clears the switch once the contact has stayed clear for the
+// debounce by t
+func (l *LimitSwitch) settle(t time.Time) {
+	l.mu.Lock()
+	changed := l.trk.settle(t)
+	clear := !l.trk.tripped
+	s := l.status()
+	names := l.interlocks
+	l.mu.Unlock()
+	if !changed {
+		return
+	}
+	if clear {
+		l.inhibit(names, false)
+	}
+	l.publish(s)
+}
+
+// stop stops the actuators named and inhibits them, those missing or
+// of no kind to stop are errors but do not keep the others running
+func (l *LimitSwitch) stop(names []string) error {
+	dm := device.GetDeviceManager()
+	var errs []error
+	for _, name := range names {
+		d, ok := dm.Get(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: no device %q", ErrActuator, name))
+			continue
+		}
+		if a, ok := d.(Inhibitor); ok {
+			a.Inhibit(l.Device.Name, true)
+		}
+		switch a := d.(type) {
+		case Stopper:
+			a.Stop()
+		case Switch:
+			if err := a.Off(); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s: %w", ErrActuator, name, err))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%w: %q can not be stopped", ErrActuator, name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// inhibit inhibits the actuators named or lets them go, the ones
+// missing are left
+func (l *LimitSwitch) inhibit(names []string, on bool) {
+	dm := device.GetDeviceManager()
+	for _, name := range names {
+		if d, ok := dm.Get(name); ok {
+			if a, ok := d.(Inhibitor); ok {
+				a.Inhibit(l.Device.Name, on)
+			}
+		}
+	}
+}
+
+func (l *LimitSwitch) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l.PubData(j)
+	return nil
+}
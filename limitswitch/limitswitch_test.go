@@ -0,0 +1,382 @@
+package limitswitch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	relaydev "github.com/rustyeddy/otto-devices/relay"
+)
+
+// motor is a stepper to stop
+type motor struct {
+	name  string
+	stops int
+	mu    sync.Mutex
+}
+
+func (m *motor) Name() string { return m.name }
+
+func (m *motor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stops++
+}
+
+func (m *motor) stopped() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stops
+}
+
+// relay is a relay to turn off
+type relay struct {
+	name string
+	on   bool
+	err  error
+}
+
+func (r *relay) Name() string { return r.name }
+
+func (r *relay) Off() error {
+	r.on = false
+	return r.err
+}
+
+// actuator is a relay of the relay package in the DeviceManager
+type actuator struct {
+	*relaydev.Relay
+}
+
+func (a actuator) Name() string { return a.Relay.Device.Name }
+
+// led can not be stopped
+type led struct{}
+
+func (led) Name() string { return "led" }
+
+func add(t *testing.T, devs ...device.Name) {
+	dm := device.GetDeviceManager()
+	for _, d := range devs {
+		dm.Add(d)
+		name := d.Name()
+		t.Cleanup(func() { dm.Remove(name) })
+	}
+}
+
+func TestTracker(t *testing.T) {
+	t0 := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+
+	tests := []struct {
+		name   string
+		wiring Wiring
+		latch  bool
+		start  bool   // the contact closed at the start
+		edges  []bool // the contact closed at each edge, a millisecond apart
+		trips  int
+		want   State
+		fault  bool
+	}{
+		{"NO clear", NormallyOpen, false, false, nil, 0, Clear, false},
+		{"NO closes", NormallyOpen, false, false, []bool{true}, 1, Tripped, false},
+		{"NO bounces closing", NormallyOpen, false, false, []bool{true, false, true, false, true}, 1, Tripped, false},
+		{"NO released", NormallyOpen, false, false, []bool{true, false}, 1, Clear, false},
+		{"NO released latched", NormallyOpen, true, false, []bool{true, false}, 1, Tripped, false},
+		{"NO tripped twice", NormallyOpen, false, false, []bool{true, false, true}, 1, Tripped, false},
+		{"NC opens", NormallyClosed, false, true, []bool{false}, 1, Tripped, false},
+		{"NC released", NormallyClosed, false, true, []bool{false, true}, 1, Clear, false},
+		{"NC open at start", NormallyClosed, false, false, nil, 0, Tripped, true},
+		{"NC wire repaired", NormallyClosed, false, false, []bool{true}, 0, Clear, false},
+		{"NO closed at start", NormallyOpen, false, true, nil, 0, Tripped, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trk := tracker{wiring: tt.wiring, latch: tt.latch, debounce: 20 * time.Millisecond}
+			trk.start(tt.start, t0)
+			for i, active := range tt.edges {
+				trk.edge(active, ms(i+1))
+			}
+			// long after the last edge
+			trk.settle(ms(1000))
+			if trk.state() != tt.want || trk.trips != tt.trips || trk.fault != tt.fault {
+				t.Errorf("got (%s, %d trips, fault %t) want (%s, %d trips, fault %t)",
+					trk.state(), trk.trips, trk.fault, tt.want, tt.trips, tt.fault)
+			}
+		})
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	t0 := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+	trk := tracker{wiring: NormallyOpen, debounce: 20 * time.Millisecond}
+	trk.start(false, t0)
+
+	if !trk.edge(true, ms(0)) {
+		t.Fatal("the first edge did not trip")
+	}
+	// bouncing on release, clear from 14ms
+	for i, active := range []bool{false, true, false, true, false} {
+		if trk.edge(active, ms(10+i)) {
+			t.Fatalf("bounce %d tripped again", i)
+		}
+	}
+	if trk.settle(ms(33)) || trk.state() != Tripped {
+		t.Errorf("settled 19ms after the last bounce")
+	}
+	if !trk.settle(ms(34)) || trk.state() != Clear || !trk.lastChange.Equal(ms(34)) {
+		t.Errorf("not clear 20ms after the last bounce got (%s)", trk.state())
+	}
+}
+
+func TestInterlock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	m := &motor{name: "x-axis"}
+	r := &relay{name: "spindle", on: true}
+	add(t, m, r)
+
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+	l, err := New("x-min", 20, NormallyOpen)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer l.Close()
+	l.SetDebounce(time.Minute)
+	if err := l.Interlock("x-axis", "spindle"); err != nil {
+		t.Fatalf("Interlock() error = %v", err)
+	}
+	if m.stopped() != 0 || !r.on {
+		t.Fatal("Interlock() on a clear switch stopped the actuators")
+	}
+
+	// the carriage hits the switch, the contact bounces
+	l.MockContact(1)
+	if m.stopped() != 1 || r.on {
+		t.Fatalf("trip stopped (%d, relay on %t) want the motor stopped and the relay off", m.stopped(), r.on)
+	}
+	r.on = true
+	l.MockContact(0)
+	l.MockContact(1)
+	if m.stopped() != 1 || !r.on {
+		t.Errorf("a bounce stopped the actuators again")
+	}
+
+	// backed off, clear after the debounce
+	l.MockContact(0)
+	clock.Advance(time.Minute - time.Millisecond)
+	if !l.Tripped() {
+		t.Errorf("clear within the debounce")
+	}
+	clock.Advance(time.Millisecond)
+	if s := l.Status(); s.State != Clear || s.Trips != 1 {
+		t.Errorf("Status() got (%+v) want clear after 1 trip", s)
+	}
+
+	// a second trip stops them again
+	l.MockContact(1)
+	if m.stopped() != 2 || r.on {
+		t.Errorf("second trip stopped (%d, relay on %t)", m.stopped(), r.on)
+	}
+}
+
+func TestInterlockInhibits(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	c := devicetest.Use(t)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	r := relaydev.New("coolant", 12)
+	defer r.Close()
+	if err := r.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	add(t, actuator{r})
+	l, err := New("x-max", 24, NormallyOpen)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer l.Close()
+	line := chip.Line(24)
+	if err := l.Interlock("coolant"); err != nil {
+		t.Fatalf("Interlock() error = %v", err)
+	}
+	command := func(id, cmd string, ok bool) {
+		t.Helper()
+		c.Inject(r.ControlTopic(), []byte(`{"id":"`+id+`","cmd":"`+cmd+`"}`))
+		c.ExpectPublish(r.AckTopic(), devicetest.Contains(fmt.Sprintf(`"id":"%s","ok":%t`, id, ok)), devicetest.Timeout)
+	}
+	value := func() int {
+		t.Helper()
+		v, err := r.Value()
+		if err != nil {
+			t.Fatalf("Value() error = %v", err)
+		}
+		return v
+	}
+
+	command("1", "on", true)
+	line.Edge(1)
+	if value() != 0 {
+		t.Fatalf("trip left the relay on")
+	}
+
+	// switched on while tripped, nacked and left off
+	command("2", "on", false)
+	command("3", "toggle", false)
+	command("4", "off", true)
+	if value() != 0 {
+		t.Errorf("inhibited relay switched on")
+	}
+
+	// clear after the debounce, it is let go
+	line.Edge(0)
+	clock.Advance(DefaultDebounce)
+	if l.Tripped() {
+		t.Fatalf("still tripped after the debounce")
+	}
+	command("5", "on", true)
+	if value() != 1 {
+		t.Errorf("relay let go not switched on")
+	}
+
+	// a latched switch holds it off until reset
+	l.SetLatch(true)
+	line.Edge(1)
+	line.Edge(0)
+	clock.Advance(DefaultDebounce)
+	command("6", "on", false)
+	if err := l.Reset(); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	command("7", "on", true)
+}
+
+func TestInterlockErrors(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	m := &motor{name: "z-axis"}
+	r := &relay{name: "heater", on: true, err: errors.New("line released")}
+	add(t, m, r, led{})
+
+	l, err := New("z-max", 21, NormallyOpen)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer l.Close()
+	l.MockContact(1)
+
+	// registered while tripped, what can be stopped is
+	err = l.Interlock("gone", "led", "heater", "z-axis")
+	if !errors.Is(err, ErrActuator) {
+		t.Errorf("Interlock() error got (%v) want (%v)", err, ErrActuator)
+	}
+	if m.stopped() != 1 || r.on {
+		t.Errorf("stopped got (%d, relay on %t) want the motor stopped and the relay off", m.stopped(), r.on)
+	}
+}
+
+func TestLatch(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	m := &motor{name: "y-axis"}
+	add(t, m)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+	l, err := New("y-min", 22, NormallyClosed)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer l.Close()
+	l.SetDebounce(time.Minute)
+	l.SetLatch(true)
+	l.Interlock("y-axis")
+
+	// a normally closed switch trips open
+	l.MockContact(0)
+	if !l.Tripped() || m.stopped() != 1 {
+		t.Fatalf("open contact got (tripped %t, %d stops) want tripped and stopped", l.Tripped(), m.stopped())
+	}
+	if err := l.Command([]byte("reset")); !errors.Is(err, ErrTripped) {
+		t.Errorf("reset tripped error got (%v) want (%v)", err, ErrTripped)
+	}
+
+	// clear again, it stays tripped until reset
+	l.MockContact(1)
+	clock.Advance(time.Minute)
+	if s := l.Status(); s.State != Tripped || !s.Latched {
+		t.Errorf("Status() released got (%+v) want tripped and latched", s)
+	}
+
+	// tripped again while latched, the interlock acts again
+	l.MockContact(0)
+	if m.stopped() != 2 || l.Status().Latched {
+		t.Errorf("trip while latched got (%d stops, %+v)", m.stopped(), l.Status())
+	}
+	l.MockContact(1)
+	if err := l.Reset(); !errors.Is(err, ErrTripped) {
+		t.Errorf("Reset() within the debounce error got (%v) want (%v)", err, ErrTripped)
+	}
+	clock.Advance(time.Minute)
+	if err := l.Command([]byte(" Reset ")); err != nil {
+		t.Fatalf("reset error = %v", err)
+	}
+	if s := l.Status(); s.State != Clear || s.Latched || s.Trips != 2 {
+		t.Errorf("Status() reset got (%+v) want clear after 2 trips", s)
+	}
+	if err := l.Command([]byte("home")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(home) error got (%v) want (%v)", err, ErrCommand)
+	}
+}
+
+func TestLine(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	m := &motor{name: "a-axis"}
+	add(t, m)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	// the line reads 0, an open circuit
+	l, err := New("a-min", 23, NormallyClosed)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer l.Close()
+	if s := l.Status(); s.State != Tripped || !s.Fault {
+		t.Fatalf("open circuit at the start got (%+v) want tripped with a fault", s)
+	}
+	if err := l.Interlock("a-axis"); err != nil || m.stopped() != 1 {
+		t.Fatalf("Interlock() while tripped got (%v, %d stops)", err, m.stopped())
+	}
+
+	line := chip.Line(23)
+	line.Edge(1) // wired up
+	clock.Advance(DefaultDebounce - time.Millisecond)
+	if !l.Tripped() {
+		t.Fatalf("closed circuit clear within the debounce")
+	}
+	clock.Advance(time.Millisecond)
+	if s := l.Status(); s.State != Clear || s.Fault {
+		t.Fatalf("closed circuit got (%+v) want clear", s)
+	}
+
+	line.Edge(0)
+	if !l.Tripped() || m.stopped() != 2 {
+		t.Errorf("open circuit got (tripped %t, %d stops) want tripped and stopped", l.Tripped(), m.stopped())
+	}
+
+	l.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
@@ -0,0 +1,115 @@
+package limitswitch
+
+import "time"
+
+// State is the state of the endstop
+type State string
+
+const (
+	Clear   State = "clear"
+	Tripped State = "tripped"
+)
+
+// tracker follows the endstop from the level of the line. A trip is
+// taken at the first edge, the contact bouncing after it is still
+// tripped, and the switch is only clear once the contact has stayed
+// clear for the debounce. Latched, a clear switch stays tripped until
+// reset.
+type tracker struct {
+	wiring   Wiring
+	latch    bool
+	debounce time.Duration
+
+	active     bool // the contact is closed
+	tripped    bool
+	latched    bool      // clear, waiting for a reset
+	clearAt    time.Time // the contact went clear, zero while tripped
+	fault      bool
+	trips      int
+	lastChange time.Time
+}
+
+// tripping reports if the contact, closed (active) or open, trips the
+// switch. A normally closed switch trips open, and so does its broken
+// wire.
+func (t *tracker) tripping(active bool) bool {
+	return active == (t.wiring == NormallyOpen)
+}
+
+// start sets the state from the level of the line. A normally closed
+// switch read open is tripped or its wire is broken, it is a fault
+// until the contact has been seen closed.
+func (t *tracker) start(active bool, at time.Time) {
+	t.active = active
+	t.tripped = t.tripping(active)
+	t.fault = t.tripped && t.wiring == NormallyClosed
+	t.lastChange = at
+	if !t.tripped {
+		t.clearAt = at
+	}
+}
+
+// edge records the level of the line at an edge, it returns true for
+// a new trip, which is also a tripped contact of a latched switch
+func (t *tracker) edge(active bool, at time.Time) bool {
+	t.active = active
+	if active && t.wiring == NormallyClosed {
+		t.fault = false
+	}
+	if !t.tripping(active) {
+		if t.clearAt.IsZero() {
+			t.clearAt = at
+		}
+		return false
+	}
+	t.clearAt = time.Time{}
+	trip := !t.tripped || t.latched
+	t.tripped, t.latched = true, false
+	if trip {
+		t.trips++
+		t.lastChange = at
+	}
+	return trip
+}
+
+// settled reports if the contact has been clear for the debounce by
+// at
+func (t *tracker) settled(at time.Time) bool {
+	return !t.clearAt.IsZero() && at.Sub(t.clearAt) >= t.debounce
+}
+
+// settle clears a tripped switch whose contact has settled clear by
+// at, or latches it. It returns true if the state changed.
+func (t *tracker) settle(at time.Time) bool {
+	if !t.tripped || t.latched || !t.settled(at) {
+		return false
+	}
+	if t.latch {
+		t.latched = true
+		return true
+	}
+	t.tripped = false
+	t.lastChange = at
+	return true
+}
+
+// reset clears a latched switch, it returns false while the contact
+// is still tripped
+func (t *tracker) reset(at time.Time) bool {
+	if !t.tripped {
+		return true
+	}
+	if !t.settled(at) {
+		return false
+	}
+	t.tripped, t.latched = false, false
+	t.lastChange = at
+	return true
+}
+
+func (t *tracker) state() State {
+	if t.tripped {
+		return Tripped
+	}
+	return Clear
+}
@@ -0,0 +1,97 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Lines returns the reading of the named device as lines of text for
+// the displays, "bme280 temperature 21.53". The device is read with
+// its Read method, whatever it returns, and every value of the JSON of
+// the reading makes a line of the name, the keys and the value. A
+// device that is missing, cannot be read or fails is shown as
+// "name -", "name ?" or "name error".
+func (dm *DeviceManager) Lines(name string) []string {
+	dev, ok := dm.Get(name)
+	if !ok {
+		return []string{name + " -"}
+	}
+	read := reflect.ValueOf(dev).MethodByName("Read")
+	if !read.IsValid() || read.Type().NumIn() != 0 || read.Type().NumOut() == 0 {
+		return []string{name + " ?"}
+	}
+	out := read.Call(nil)
+	if last := out[len(out)-1]; last.Type() == reflect.TypeFor[error]() && !last.IsNil() {
+		return []string{name + " error"}
+	}
+	j, err := json.Marshal(out[0].Interface())
+	if err != nil {
+		return []string{name + " ?"}
+	}
+
+	var lines []string
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	if err := flatten(dec, name, &lines); err != nil && !errors.Is(err, io.EOF) {
+		return []string{name + " ?"}
+	}
+	return lines
+}
+
+// flatten adds a line for every value of the next JSON value of dec,
+// prefixed by the keys leading to it in the order of the JSON
+func flatten(dec *json.Decoder, prefix string, lines *[]string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		for i := 0; dec.More(); i++ {
+			key := strconv.Itoa(i)
+			if t == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key = k.(string)
+			}
+			if err := flatten(dec, prefix+" "+key, lines); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token() // the closing delimiter
+		return err
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+		*lines = append(*lines, prefix+" "+number(f))
+	case string:
+		*lines = append(*lines, prefix+" "+strings.TrimSpace(t))
+	case bool:
+		*lines = append(*lines, prefix+" "+strconv.FormatBool(t))
+	case nil:
+		*lines = append(*lines, prefix+" -")
+	}
+	return nil
+}
+
+// number formats a value to fit a line, whole numbers as they are and
+// fractions to 4 significant digits or 1 decimal
+func number(f float64) string {
+	switch {
+	case f == math.Trunc(f) && math.Abs(f) < 1e15:
+		return strconv.FormatFloat(f, 'f', 0, 64)
+	case math.Abs(f) >= 1000:
+		return strconv.FormatFloat(f, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', 4, 64)
+}
@@ -0,0 +1,90 @@
+package max31855
+
+import "math"
+
+// sensitivity is the mV/°C the MAX31855 converts at
+const sensitivity = 0.041276
+
+// NIST ITS-90 K-type coefficients, the thermoelectric voltage in mV
+// of a temperature below and above 0°C, and the inverse from -200 to
+// 0, 0 to 500 and 500 to 1372°C
+var (
+	kBelow = []float64{
+		0, 0.394501280250e-1, 0.236223735980e-4, -0.328589067840e-6,
+		-0.499048287770e-8, -0.675090591730e-10, -0.574103274280e-12,
+		-0.310888728940e-14, -0.104516093650e-16, -0.198892668780e-19,
+		-0.163226974860e-22,
+	}
+	kAbove = []float64{
+		-0.176004136860e-1, 0.389212049750e-1, 0.185587700320e-4,
+		-0.994575928740e-7, 0.318409457190e-9, -0.560728448890e-12,
+		0.560750590590e-15, -0.320207200030e-18, 0.971511471520e-22,
+		-0.121047212750e-25,
+	}
+	kA0, kA1, kA2 = 0.118597600000, -0.118343200000e-3, 0.126968600000e3
+
+	kInverse = []struct {
+		max float64 // mV
+		d   []float64
+	}{
+		{0, []float64{
+			0, 2.5173462e1, -1.1662878, -1.0833638, -8.9773540e-1,
+			-3.7342377e-1, -8.6632643e-2, -1.0450598e-2, -5.1920577e-4,
+		}},
+		{20.644, []float64{
+			0, 2.508355e1, 7.860106e-2, -2.503131e-1, 8.315270e-2,
+			-1.228034e-2, 9.804036e-4, -4.413030e-5, 1.057734e-6,
+			-1.052755e-8,
+		}},
+		{54.886, []float64{
+			-1.318058e2, 4.830222e1, -1.646031, 5.464731e-2,
+			-9.650715e-4, 8.802193e-6, -3.110810e-8,
+		}},
+	}
+)
+
+// kMinMV is the voltage at -200°C, the low end of the inverse
+const kMinMV = -5.891
+
+// poly evaluates the polynomial with the coefficients c at x
+func poly(c []float64, x float64) float64 {
+	y := 0.0
+	for i := len(c) - 1; i >= 0; i-- {
+		y = y*x + c[i]
+	}
+	return y
+}
+
+// kVoltage returns the K-type thermoelectric voltage in mV at t °C
+func kVoltage(t float64) float64 {
+	if t < 0 {
+		return poly(kBelow, t)
+	}
+	return poly(kAbove, t) + kA0*math.Exp(kA1*(t-kA2)*(t-kA2))
+}
+
+// kTemperature returns the temperature of the K-type voltage mv, ok
+// is false outside -200 to 1372°C
+func kTemperature(mv float64) (float64, bool) {
+	if mv < kMinMV {
+		return 0, false
+	}
+	for _, r := range kInverse {
+		if mv <= r.max {
+			return poly(r.d, mv), true
+		}
+	}
+	return 0, false
+}
+
+// linearize corrects the thermocouple temperature tc the chip derived
+// at a constant sensitivity, back to the voltage it measured plus the
+// voltage of the cold junction cj and through the NIST inverse. A
+// temperature out of the K-type range is returned as it is.
+func linearize(tc, cj float64) float64 {
+	mv := (tc-cj)*sensitivity + kVoltage(cj)
+	if t, ok := kTemperature(mv); ok {
+		return t
+	}
+	return tc
+}
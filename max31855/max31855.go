@@ -0,0 +1,241 @@
+// Package max31855 provides a driver for the MAX31855 thermocouple
+// amplifier, read over SPI.
+//
+// Every read clocks out a 32 bit frame with the thermocouple and the
+// cold junction temperatures and the fault bits. A fault is returned
+// as a Fault error and published as a FaultEvent when it appears and
+// when it clears.
+//
+// The chip converts the thermocouple voltage at a constant 41.276µV/°C,
+// which is off by a few degrees over much of the K-type range and by
+// tens of degrees below zero. With Linearize set the reading is
+// corrected with the NIST K-type polynomials.
+package max31855
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultSPI = "/dev/spidev0.0"
+
+	// SPIFreq is the clock the MAX31855 is read at, it takes up to
+	// 5MHz
+	SPIFreq = 4000000
+)
+
+// the 32 bit frame, the thermocouple in the top 14 bits and the cold
+// junction in bits 15 - 4, both signed
+const (
+	frameLength = 4
+	bitFault    = 1 << 16
+	reserved    = 1<<17 | 1<<3
+	faultBits   = 0x07
+	tcPerLSB    = 0.25
+	coldPerLSB  = 0.0625
+)
+
+// Fault is the fault bits of a frame, Read returns it as the error
+// of a faulted thermocouple. errors.Is matches each of the bits.
+type Fault uint8
+
+const (
+	ErrOpenCircuit Fault = 0x01
+	ErrShortToGND  Fault = 0x02
+	ErrShortToVCC  Fault = 0x04
+)
+
+var faultNames = []struct {
+	f    Fault
+	name string
+}{
+	{ErrOpenCircuit, "open_circuit"},
+	{ErrShortToGND, "short_to_gnd"},
+	{ErrShortToVCC, "short_to_vcc"},
+}
+
+// Names returns the names of the fault bits
+func (f Fault) Names() []string {
+	var names []string
+	for _, n := range faultNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (f Fault) Error() string {
+	return "max31855 thermocouple " + strings.ReplaceAll(strings.Join(f.Names(), ", "), "_", " ")
+}
+
+// Is reports if target is a Fault with all of its bits in f
+func (f Fault) Is(target error) bool {
+	t, ok := target.(Fault)
+	return ok && t != 0 && f&t == t
+}
+
+// ErrFrame is returned for a frame with the reserved bits set, like
+// the all ones of a MISO line with no chip answering, or with fault
+// bits that disagree
+var ErrFrame = errors.New("invalid max31855 frame")
+
+// Reading is what ReadPub publishes, in °C
+type Reading struct {
+	Temperature  float64 `json:"temperature"`
+	ColdJunction float64 `json:"cold_junction"`
+}
+
+// FaultEvent is published when a fault appears, "fault" with the
+// faults, and when it clears, "fault_cleared"
+type FaultEvent struct {
+	Event  string   `json:"event"`
+	Faults []string `json:"faults,omitempty"`
+}
+
+// decode returns the temperatures of a frame. A faulted frame returns
+// the Fault along with the cold junction temperature.
+func decode(frame uint32) (Reading, error) {
+	if frame&reserved != 0 || (frame&bitFault != 0) != (frame&faultBits != 0) {
+		return Reading{}, fmt.Errorf("%w: %#08x", ErrFrame, frame)
+	}
+	r := Reading{ColdJunction: float64(int32(frame<<16)>>20) * coldPerLSB}
+	if frame&bitFault != 0 {
+		return r, Fault(frame & faultBits)
+	}
+	r.Temperature = float64(int32(frame)>>18) * tcPerLSB
+	return r, nil
+}
+
+// MAX31855 is a thermocouple amplifier on an SPI bus
+type MAX31855 struct {
+	*device.Device
+
+	// Linearize corrects the K-type thermocouple reading
+	Linearize bool
+
+	spi   drivers.SPIConn
+	fault Fault
+	mu    sync.Mutex
+}
+
+// New opens the MAX31855 at the spidev device dev, like
+// /dev/spidev0.0
+func New(name, dev string) (*MAX31855, error) {
+	if device.IsMock() {
+		return NewWithSPI(name, nil), nil
+	}
+	spi, err := drivers.OpenSPI(dev, 0, SPIFreq)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithSPI(name, spi), nil
+}
+
+// NewWithSPI creates a MAX31855 on spi, it has to be clocked at up to
+// 5MHz in mode 0
+func NewWithSPI(name string, spi drivers.SPIConn) *MAX31855 {
+	return &MAX31855{
+		Device: device.NewDevice(name, "mqtt"),
+		spi:    spi,
+	}
+}
+
+// Name returns the name of the device
+func (m *MAX31855) Name() string {
+	return m.Device.Name
+}
+
+// Read reads a frame. A faulted thermocouple returns the Fault along
+// with the reading, which then only holds the cold junction.
+func (m *MAX31855) Read() (*Reading, error) {
+	if device.IsMock() {
+		return &Reading{
+			Temperature:  100 + m.MockRand().Float64()*100,
+			ColdJunction: 20 + m.MockRand().Float64()*5,
+		}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil, errors.New("not initialized")
+	}
+	buf := make([]byte, frameLength)
+	if err := m.spi.Tx(make([]byte, frameLength), buf); err != nil {
+		return nil, err
+	}
+	frame := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+	r, err := decode(frame)
+	if err == nil && m.Linearize {
+		r.Temperature = linearize(r.Temperature, r.ColdJunction)
+	}
+	return &r, err
+}
+
+// ReadPub publishes a reading, and a FaultEvent when a fault appears
+// or clears. Nothing else is published while the thermocouple is
+// faulted.
+func (m *MAX31855) ReadPub() error {
+	r, err := m.Read()
+	var fault Fault
+	if err != nil && !errors.As(err, &fault) {
+		return err
+	}
+
+	m.mu.Lock()
+	changed := fault != m.fault
+	m.fault = fault
+	m.mu.Unlock()
+
+	if changed {
+		evt := &FaultEvent{Event: "fault_cleared"}
+		if fault != 0 {
+			evt = &FaultEvent{Event: "fault", Faults: fault.Names()}
+		}
+		j, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		m.PubData(j)
+	}
+	if fault != 0 {
+		return nil
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	m.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (m *MAX31855) Run(ctx context.Context, period time.Duration) error {
+	err := m.TimerLoop(ctx, period, m.ReadPub)
+	slog.Debug("max31855 stopped", "device", m.Device.Name, "error", err)
+	return err
+}
+
+// Close closes the spi device
+func (m *MAX31855) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil
+	}
+	err := m.spi.Close()
+	m.spi = nil
+	return err
+}
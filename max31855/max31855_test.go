@@ -0,0 +1,160 @@
+package max31855
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// frame builds a frame of the 14 bit thermocouple and 12 bit cold
+// junction words of table 2 and 3 of the datasheet and the faults
+func frame(tc, cj uint32, f Fault) uint32 {
+	w := tc<<18 | cj<<4 | uint32(f)
+	if f != 0 {
+		w |= bitFault
+	}
+	return w
+}
+
+func TestDecode(t *testing.T) {
+	temps := []struct {
+		tc, cj uint32
+		want   Reading
+		name   string
+	}{
+		{0x1900, 0x7F0, Reading{1600, 127}, "+1600 +127"},
+		{0x0064, 0x181, Reading{25, 24.0625}, "+25 +24.0625"},
+		{0x0001, 0x001, Reading{0.25, 0.0625}, "+0.25 +0.0625"},
+		{0x0000, 0x000, Reading{0, 0}, "0 0"},
+		{0x3FFF, 0xFFF, Reading{-0.25, -0.0625}, "-0.25 -0.0625"},
+		{0x3C18, 0xC90, Reading{-250, -55}, "-250 -55"},
+		{0x3F06, 0xEC0, Reading{-62.5, -20}, "-62.5 -20"},
+	}
+	for _, tt := range temps {
+		got, err := decode(frame(tt.tc, tt.cj, 0))
+		if err != nil || got != tt.want {
+			t.Errorf("decode(%s) got (%+v, %v) want (%+v)", tt.name, got, err, tt.want)
+		}
+	}
+
+	// every combination of faults, the cold junction is still read
+	for f := Fault(1); f <= 7; f++ {
+		got, err := decode(frame(0x3FFF, 0xC90, f))
+		var fault Fault
+		if !errors.As(err, &fault) || fault != f {
+			t.Errorf("decode(fault %03b) error got (%v) want (%v)", f, err, f)
+		}
+		if got.ColdJunction != -55 || got.Temperature != 0 {
+			t.Errorf("decode(fault %03b) got (%+v) want the cold junction only", f, got)
+		}
+		for _, bit := range []Fault{ErrOpenCircuit, ErrShortToGND, ErrShortToVCC} {
+			if errors.Is(err, bit) != (f&bit != 0) {
+				t.Errorf("errors.Is(%v, %v) got (%t)", err, bit, !(f&bit != 0))
+			}
+		}
+		if len(f.Names()) == 0 || err.Error() == "" {
+			t.Errorf("fault %03b has no names", f)
+		}
+	}
+	if got := (ErrOpenCircuit | ErrShortToVCC).Error(); got != "max31855 thermocouple open circuit, short to vcc" {
+		t.Errorf("Error() got (%s)", got)
+	}
+
+	invalid := []uint32{
+		0xFFFFFFFF,
+		frame(0x64, 0x181, 0) | 1<<17,
+		frame(0x64, 0x181, 0) | 1<<3,
+		frame(0x64, 0x181, 0) | bitFault,
+		frame(0x64, 0x181, 0) | uint32(ErrOpenCircuit),
+	}
+	for _, w := range invalid {
+		if _, err := decode(w); !errors.Is(err, ErrFrame) {
+			t.Errorf("decode(%#08x) error got (%v) want (%v)", w, err, ErrFrame)
+		}
+	}
+}
+
+func TestLinearize(t *testing.T) {
+	// the NIST K-type table
+	volts := map[float64]float64{-200: -5.891, -100: -3.554, 0: 0, 25: 1.000, 100: 4.096, 500: 20.644, 1000: 41.276, 1372: 54.886}
+	for temp, mv := range volts {
+		if got := kVoltage(temp); math.Abs(got-mv) > 0.001 {
+			t.Errorf("kVoltage(%g) got (%.4f) want (%.3f)", temp, got, mv)
+		}
+		if got, ok := kTemperature(mv); !ok || math.Abs(got-temp) > 0.1 {
+			t.Errorf("kTemperature(%g) got (%.2f, %t) want (%g)", mv, got, ok, temp)
+		}
+	}
+
+	// what the chip reads at a 25°C cold junction with its constant
+	// sensitivity, and the temperature of the thermocouple
+	reads := []struct {
+		chip, want float64
+	}{
+		{1000.75, 1000},
+		{-118.25, -150},
+		{25, 25},
+	}
+	for _, tt := range reads {
+		if got := linearize(tt.chip, 25); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("linearize(%g, 25) got (%.2f) want (%g)", tt.chip, got, tt.want)
+		}
+	}
+	// out of range is left alone
+	if got := linearize(1600, 25); got != 1600 {
+		t.Errorf("linearize(1600, 25) got (%g) want (1600)", got)
+	}
+}
+
+func TestReadPub(t *testing.T) {
+	device.Mock(false)
+	spi := driverstest.NewSPI()
+	driverstest.UseSPI(t, spi)
+
+	m, err := New("kiln", DefaultSPI)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if spi.Dev != DefaultSPI || spi.Hz != SPIFreq {
+		t.Errorf("OpenSPI got (%s, %d) want (%s, %d)", spi.Dev, spi.Hz, DefaultSPI, SPIFreq)
+	}
+
+	queue := func(w uint32) {
+		spi.QueueRead(byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+	}
+	queue(frame(0x1900, 0x190, 0))
+	r, err := m.Read()
+	if err != nil || *r != (Reading{1600, 25}) {
+		t.Errorf("Read() got (%+v, %v) want (1600, 25)", r, err)
+	}
+
+	m.Linearize = true
+	queue(frame(0x3E27, 0x190, 0)) // -118.25
+	if r, err := m.Read(); err != nil || math.Abs(r.Temperature+150) > 0.1 {
+		t.Errorf("Read(linearized) got (%+v, %v) want (-150)", r, err)
+	}
+
+	queue(frame(0, 0x190, ErrOpenCircuit))
+	if err := m.ReadPub(); err != nil || m.fault != ErrOpenCircuit {
+		t.Errorf("ReadPub(open) got (%v, %v) want (nil, %v)", err, m.fault, ErrOpenCircuit)
+	}
+	queue(frame(0x64, 0x190, 0))
+	if err := m.ReadPub(); err != nil || m.fault != 0 {
+		t.Errorf("ReadPub(cleared) got (%v, %v) want (nil, 0)", err, m.fault)
+	}
+	queue(0xFFFFFFFF)
+	if err := m.ReadPub(); !errors.Is(err, ErrFrame) {
+		t.Errorf("ReadPub(no chip) error got (%v) want (%v)", err, ErrFrame)
+	}
+	if n := len(spi.Transcript()); n != 5 {
+		t.Errorf("transfers got (%d) want (5)", n)
+	}
+
+	m.Close()
+	if !spi.Closed() {
+		t.Error("Close() did not close the spi device")
+	}
+}
@@ -0,0 +1,41 @@
+package max31865
+
+import "math"
+
+// Callendar-Van Dusen coefficients of IEC 60751 platinum RTDs
+const (
+	cvdA = 3.9083e-3
+	cvdB = -5.775e-7
+	cvdC = -4.183e-12
+)
+
+// Resistance returns the resistance of a platinum RTD of r0 ohms at
+// 0°C, 100 for a PT100, at t °C
+func Resistance(t, r0 float64) float64 {
+	r := 1 + cvdA*t + cvdB*t*t
+	if t < 0 {
+		r += cvdC * (t - 100) * t * t * t
+	}
+	return r0 * r
+}
+
+// Temperature returns the temperature in °C of a platinum RTD of r0
+// ohms at 0°C measuring r ohms. From 0°C up it solves the quadratic,
+// below 0°C the C term makes it a quartic, which Newton's method
+// solves from the quadratic's root.
+func Temperature(r, r0 float64) float64 {
+	t := (-cvdA + math.Sqrt(cvdA*cvdA-4*cvdB*(1-r/r0))) / (2 * cvdB)
+	if r >= r0 {
+		return t
+	}
+	for i := 0; i < 10; i++ {
+		f := Resistance(t, r0) - r
+		df := r0 * (cvdA + 2*cvdB*t + cvdC*(4*t*t*t-300*t*t))
+		step := f / df
+		t -= step
+		if math.Abs(step) < 1e-9 {
+			break
+		}
+	}
+	return t
+}
@@ -0,0 +1,378 @@
+// Package max31865 provides a driver for the MAX31865 RTD to digital
+// converter, read over SPI, with PT100 and PT1000 platinum RTDs.
+//
+// The converter measures the ratio of the RTD to the reference
+// resistor on the board, 430Ω for a PT100 and 4300Ω for a PT1000 on
+// most of them. The resistance is converted to a temperature with the
+// Callendar-Van Dusen equation.
+//
+// A fault, like an open RTD or a reading out of the thresholds, is
+// returned as a Fault error and published as a FaultEvent when it
+// appears and when it clears. The fault status stays latched until
+// ClearFaults, or the "clear" command.
+package max31865
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultSPI = "/dev/spidev0.0"
+
+	// SPIMode and SPIFreq are how the MAX31865 is read, it takes mode
+	// 1 or 3 and up to 5MHz
+	SPIMode = 1
+	SPIFreq = 1000000
+
+	// RTD resistances at 0°C
+	PT100  = 100.0
+	PT1000 = 1000.0
+)
+
+// registers, writes set the top bit of the address
+const (
+	regConfig    = 0x00
+	regRTD       = 0x01
+	regThreshold = 0x03 // high MSB, LSB, low MSB, LSB
+	regFault     = 0x07
+	regWrite     = 0x80
+)
+
+// configuration bits
+const (
+	cfgVBias      = 0x80
+	cfgAuto       = 0x40
+	cfg3Wire      = 0x10
+	cfgCycle      = 0x2C // one-shot and the fault detection cycle
+	cfgFaultClear = 0x02
+	cfg50Hz       = 0x01
+)
+
+// Fault is the fault status register, Read returns it as the error
+// of a faulted RTD. errors.Is matches each of the bits.
+type Fault uint8
+
+const (
+	ErrRTDHigh   Fault = 0x80 // the RTD ratio above the high threshold
+	ErrRTDLow    Fault = 0x40 // the RTD ratio below the low threshold
+	ErrRefInHigh Fault = 0x20 // REFIN- above 0.85 x VBIAS
+	ErrRefInLow  Fault = 0x10 // REFIN- below 0.85 x VBIAS, FORCE- open
+	ErrRTDInLow  Fault = 0x08 // RTDIN- below 0.85 x VBIAS, FORCE- open
+	ErrVoltage   Fault = 0x04 // over or under voltage on an input
+)
+
+var faultNames = []struct {
+	f    Fault
+	name string
+}{
+	{ErrRTDHigh, "rtd_high"},
+	{ErrRTDLow, "rtd_low"},
+	{ErrRefInHigh, "refin_high"},
+	{ErrRefInLow, "refin_low"},
+	{ErrRTDInLow, "rtdin_low"},
+	{ErrVoltage, "voltage"},
+}
+
+// Names returns the names of the fault bits
+func (f Fault) Names() []string {
+	var names []string
+	for _, n := range faultNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (f Fault) Error() string {
+	return "max31865 fault " + strings.Join(f.Names(), ", ")
+}
+
+// Is reports if target is a Fault with all of its bits in f
+func (f Fault) Is(target error) bool {
+	t, ok := target.(Fault)
+	return ok && t != 0 && f&t == t
+}
+
+var (
+	ErrConfig  = errors.New("invalid max31865 configuration")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Env is what ReadPub publishes, the temperature field matches the
+// bme280 Env
+type Env struct {
+	Temperature string `json:"temperature"`
+}
+
+// Reading is a single measurement
+type Reading struct {
+	Temperature float64 // °C
+	Resistance  float64 // Ω
+}
+
+// FaultEvent is published when a fault appears, "fault" with the
+// faults, and when it clears, "fault_cleared"
+type FaultEvent struct {
+	Event  string   `json:"event"`
+	Faults []string `json:"faults,omitempty"`
+}
+
+// MAX31865 is an RTD converter on an SPI bus
+type MAX31865 struct {
+	*device.Device
+
+	// Wires is how the RTD is connected, 2, 3 or 4 wires
+	Wires int
+
+	// Mains is the mains frequency filtered out, 50 or 60 Hz
+	Mains int
+
+	// Units are the units ReadPub publishes, "F" like the bme280
+	// or "C"
+	Units string
+
+	spi   drivers.SPIConn
+	r0    float64
+	ref   float64
+	cfg   byte
+	fault Fault
+	mu    sync.Mutex
+}
+
+// New opens the MAX31865 at the spidev device dev, like
+// /dev/spidev0.0, for an RTD of r0 ohms at 0°C, PT100 or PT1000,
+// measured against the reference resistor of ref ohms
+func New(name, dev string, r0, ref float64) (*MAX31865, error) {
+	if r0 <= 0 || ref <= r0 {
+		return nil, fmt.Errorf("%w: RTD %gΩ against a %gΩ reference", ErrConfig, r0, ref)
+	}
+	if device.IsMock() {
+		return NewWithSPI(name, nil, r0, ref), nil
+	}
+	spi, err := drivers.OpenSPI(dev, SPIMode, SPIFreq)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithSPI(name, spi, r0, ref), nil
+}
+
+// NewWithSPI creates a MAX31865 on spi, opened in SPIMode, for a 2
+// wire RTD with a 60Hz filter
+func NewWithSPI(name string, spi drivers.SPIConn, r0, ref float64) *MAX31865 {
+	return &MAX31865{
+		Device: device.NewDevice(name, "mqtt"),
+		Wires:  2,
+		Mains:  60,
+		Units:  "F",
+		spi:    spi,
+		r0:     r0,
+		ref:    ref,
+	}
+}
+
+// Name returns the name of the device
+func (m *MAX31865) Name() string {
+	return m.Device.Name
+}
+
+// Init writes Wires and Mains, turns the bias on, starts converting
+// continuously and clears any fault
+func (m *MAX31865) Init() error {
+	var cfg byte = cfgVBias
+	switch m.Wires {
+	case 2, 4:
+	case 3:
+		cfg |= cfg3Wire
+	default:
+		return fmt.Errorf("%w: %d wires", ErrConfig, m.Wires)
+	}
+	switch m.Mains {
+	case 50:
+		cfg |= cfg50Hz
+	case 60:
+	default:
+		return fmt.Errorf("%w: %dHz mains", ErrConfig, m.Mains)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil
+	}
+	// the filter only changes with the conversions stopped
+	if err := m.write(regConfig, cfg); err != nil {
+		return err
+	}
+	m.cfg = cfg | cfgAuto
+	return m.write(regConfig, m.cfg|cfgFaultClear)
+}
+
+// SetThresholds sets the temperatures in °C below and above which the
+// RTD faults
+func (m *MAX31865) SetThresholds(low, high float64) error {
+	lo, hi := m.code(low), m.code(high)
+	if low >= high || lo < 0 || hi > 0x7FFF {
+		return fmt.Errorf("%w: thresholds %g to %g°C", ErrConfig, low, high)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil
+	}
+	h, l := uint16(hi)<<1, uint16(lo)<<1
+	return m.write(regThreshold, byte(h>>8), byte(h), byte(l>>8), byte(l))
+}
+
+// code returns the 15 bit RTD ratio of the temperature t
+func (m *MAX31865) code(t float64) int {
+	return int(Resistance(t, m.r0) / m.ref * 32768)
+}
+
+// ClearFaults clears the fault status
+func (m *MAX31865) ClearFaults() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil
+	}
+	return m.write(regConfig, m.cfg&^cfgCycle|cfgFaultClear)
+}
+
+// Command handles a command payload: "clear" clears the faults
+func (m *MAX31865) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	if len(f) == 1 && f[0] == "clear" {
+		return m.ClearFaults()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read reads the latest conversion. A faulted RTD returns the Fault
+// along with the reading. If the device is mocked it makes up a
+// reading.
+func (m *MAX31865) Read() (*Reading, error) {
+	if device.IsMock() {
+		t := 15 + m.MockRand().Float64()*10
+		return &Reading{Temperature: t, Resistance: Resistance(t, m.r0)}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil, errors.New("not initialized")
+	}
+	buf, err := m.read(regRTD, 2)
+	if err != nil {
+		return nil, err
+	}
+	code := uint16(buf[0])<<8 | uint16(buf[1])
+	r := m.reading(code >> 1)
+	if code&0x01 == 0 {
+		return r, nil
+	}
+
+	// the fault bit of the RTD register flags the fault status
+	status, err := m.read(regFault, 1)
+	if err != nil {
+		return nil, err
+	}
+	if f := Fault(status[0] & 0xFC); f != 0 {
+		return r, f
+	}
+	return r, nil
+}
+
+// reading converts the 15 bit RTD ratio
+func (m *MAX31865) reading(code uint16) *Reading {
+	ohms := float64(code) / 32768 * m.ref
+	return &Reading{Temperature: Temperature(ohms, m.r0), Resistance: ohms}
+}
+
+// ReadPub publishes the temperature in Units, and a FaultEvent when a
+// fault appears or clears. Nothing else is published while the RTD is
+// faulted.
+func (m *MAX31865) ReadPub() error {
+	r, err := m.Read()
+	var fault Fault
+	if err != nil && !errors.As(err, &fault) {
+		return err
+	}
+
+	m.mu.Lock()
+	changed := fault != m.fault
+	m.fault = fault
+	m.mu.Unlock()
+
+	if changed {
+		evt := &FaultEvent{Event: "fault_cleared"}
+		if fault != 0 {
+			evt = &FaultEvent{Event: "fault", Faults: fault.Names()}
+		}
+		j, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		m.PubData(j)
+	}
+	if fault != 0 {
+		return nil
+	}
+
+	t := r.Temperature
+	if m.Units != "C" {
+		t = t*9/5 + 32
+	}
+	j, err := json.Marshal(&Env{Temperature: fmt.Sprintf("%.2f", t)})
+	if err != nil {
+		return err
+	}
+	m.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (m *MAX31865) Run(ctx context.Context, period time.Duration) error {
+	err := m.TimerLoop(ctx, period, m.ReadPub)
+	slog.Debug("max31865 stopped", "device", m.Device.Name, "error", err)
+	return err
+}
+
+// Close turns the bias off and closes the spi device
+func (m *MAX31865) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.spi == nil {
+		return nil
+	}
+	m.write(regConfig, 0)
+	err := m.spi.Close()
+	m.spi = nil
+	return err
+}
+
+// write writes vals to consecutive registers from reg
+func (m *MAX31865) write(reg byte, vals ...byte) error {
+	return m.spi.Tx(append([]byte{reg | regWrite}, vals...), nil)
+}
+
+// read reads n consecutive registers from reg
+func (m *MAX31865) read(reg byte, n int) ([]byte, error) {
+	w := make([]byte, n+1)
+	w[0] = reg
+	r := make([]byte, n+1)
+	if err := m.spi.Tx(w, r); err != nil {
+		return nil, err
+	}
+	return r[1:], nil
+}
@@ -0,0 +1,150 @@
+package max31865
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// the IEC 60751 table of a PT100
+var pt100 = []struct {
+	t, r float64
+}{
+	{-200, 18.52},
+	{-100, 60.26},
+	{-50, 80.31},
+	{0, 100},
+	{50, 119.40},
+	{100, 138.51},
+	{200, 175.86},
+	{400, 247.09},
+	{850, 390.48},
+}
+
+func TestCallendarVanDusen(t *testing.T) {
+	for _, tt := range pt100 {
+		if got := Resistance(tt.t, PT100); math.Abs(got-tt.r) > 0.005 {
+			t.Errorf("Resistance(%g, PT100) got (%.3f) want (%.2f)", tt.t, got, tt.r)
+		}
+		if got := Resistance(tt.t, PT1000); math.Abs(got-tt.r*10) > 0.05 {
+			t.Errorf("Resistance(%g, PT1000) got (%.2f) want (%.1f)", tt.t, got, tt.r*10)
+		}
+		// the table is to 0.01Ω, 0.03°C
+		if got := Temperature(tt.r, PT100); math.Abs(got-tt.t) > 0.03 {
+			t.Errorf("Temperature(%g, PT100) got (%.3f) want (%g)", tt.r, got, tt.t)
+		}
+	}
+
+	// the two branches round trip
+	for temp := -200.0; temp <= 850; temp += 12.5 {
+		r := Resistance(temp, PT1000)
+		if got := Temperature(r, PT1000); math.Abs(got-temp) > 1e-6 {
+			t.Errorf("Temperature(Resistance(%g)) got (%.9f)", temp, got)
+		}
+	}
+}
+
+func TestFault(t *testing.T) {
+	f := ErrRTDHigh | ErrVoltage
+	if !errors.Is(f, ErrRTDHigh) || !errors.Is(f, ErrVoltage) || errors.Is(f, ErrRefInLow) {
+		t.Errorf("errors.Is(%v) matched the wrong faults", f)
+	}
+	if errors.Is(f, ErrRTDHigh|ErrRTDLow) {
+		t.Errorf("errors.Is(%v, rtd_high and rtd_low) got (true)", f)
+	}
+	if got := f.Error(); got != "max31865 fault rtd_high, voltage" {
+		t.Errorf("Error() got (%s)", got)
+	}
+	all := Fault(0xFC).Names()
+	if len(all) != 6 {
+		t.Errorf("Names(0xfc) got (%v) want 6 faults", all)
+	}
+	if got := Fault(0x03).Names(); len(got) != 0 {
+		t.Errorf("Names(0x03) got (%v) want none", got)
+	}
+}
+
+func TestReadPub(t *testing.T) {
+	device.Mock(false)
+	spi := driverstest.NewSPI()
+	driverstest.UseSPI(t, spi)
+
+	if _, err := New("rtd", DefaultSPI, PT100, 50); !errors.Is(err, ErrConfig) {
+		t.Errorf("New(ref 50Ω) error got (%v) want (%v)", err, ErrConfig)
+	}
+	m, err := New("rtd", DefaultSPI, PT100, 430)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if spi.Mode != SPIMode || spi.Hz != SPIFreq {
+		t.Errorf("OpenSPI got (mode %d, %d) want (mode %d, %d)", spi.Mode, spi.Hz, SPIMode, SPIFreq)
+	}
+
+	m.Wires = 5
+	if err := m.Init(); !errors.Is(err, ErrConfig) {
+		t.Errorf("Init(5 wires) error got (%v) want (%v)", err, ErrConfig)
+	}
+	m.Wires, m.Mains = 3, 50
+	if err := m.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := m.SetThresholds(-50, 150); err != nil {
+		t.Fatalf("SetThresholds() error = %v", err)
+	}
+	if err := m.SetThresholds(0, 2000); !errors.Is(err, ErrConfig) {
+		t.Errorf("SetThresholds(0, 2000) error got (%v) want (%v)", err, ErrConfig)
+	}
+
+	// 138.51Ω and 100°C
+	spi.QueueRead(0, 0x52, 0x76)
+	r, err := m.Read()
+	if err != nil || math.Abs(r.Temperature-100) > 0.01 || math.Abs(r.Resistance-138.51) > 0.01 {
+		t.Errorf("Read() got (%+v, %v) want (100°C, 138.51Ω)", r, err)
+	}
+
+	// the fault bit and the status
+	spi.QueueRead(0, 0x52, 0x77)
+	spi.QueueRead(0, 0x87)
+	if err := m.ReadPub(); err != nil || m.fault != ErrRTDHigh|ErrVoltage {
+		t.Errorf("ReadPub(fault) got (%v, %v) want (nil, %v)", err, m.fault, ErrRTDHigh|ErrVoltage)
+	}
+	if err := m.Command([]byte("clear")); err != nil {
+		t.Fatalf("Command(clear) error = %v", err)
+	}
+	if err := m.Command([]byte("reset")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(reset) error got (%v) want (%v)", err, ErrCommand)
+	}
+	spi.QueueRead(0, 0x52, 0x76)
+	if err := m.ReadPub(); err != nil || m.fault != 0 {
+		t.Errorf("ReadPub(cleared) got (%v, %v) want (nil, 0)", err, m.fault)
+	}
+
+	m.Close()
+	want := [][]byte{
+		{0x80, 0x91},                   // bias, 3 wires and 50Hz, stopped
+		{0x80, 0xD3},                   // converting, faults cleared
+		{0x83, 0x5D, 0xA8, 0x2F, 0xCE}, // 150°C high, -50°C low
+		{0x01, 0, 0},
+		{0x01, 0, 0},
+		{0x07, 0},
+		{0x80, 0xD3}, // clear
+		{0x01, 0, 0},
+		{0x80, 0x00}, // bias off
+	}
+	got := spi.Transcript()
+	if len(got) != len(want) {
+		t.Fatalf("transfers got (% x) want (% x)", got, want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("transfer %d got (% x) want (% x)", i, got[i], want[i])
+		}
+	}
+	if !spi.Closed() {
+		t.Error("Close() did not close the spi device")
+	}
+}
@@ -0,0 +1,457 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Msg is a message published on a topic
+type Msg struct {
+	Topic    string
+	Data     []byte
+	Source   string // the transport it came over
+	Time     time.Time
+	Retained bool // kept by the transport, not published since subscribing
+
+	// QoS is the level the message was delivered at. At QoS 1 and
+	// over the transport gives it an ID, the one it is delivered
+	// again with and flagged Duplicate when the delivery was not
+	// acknowledged.
+	QoS       QoS
+	ID        uint16
+	Duplicate bool
+}
+
+// QoS is the MQTT quality of service of a publish or a subscription,
+// a message is delivered at the lower of the two
+type QoS byte
+
+const (
+	// AtMostOnce may lose the message, for the readings the next one
+	// replaces
+	AtMostOnce QoS = iota
+
+	// AtLeastOnce delivers the message, maybe more than once, for the
+	// commands and alerts that must not be lost. The handlers see the
+	// duplicates, see Dedupe.
+	AtLeastOnce
+
+	// ExactlyOnce delivers the message once, at the cost of two
+	// round trips to the broker
+	ExactlyOnce
+)
+
+// NewMsg creates a message of data on topic, timestamped now
+func NewMsg(topic string, data []byte, source string) *Msg {
+	return &Msg{Topic: topic, Data: data, Source: source, Time: time.Now()}
+}
+
+func (m *Msg) String() string {
+	return string(m.Data)
+}
+
+// MsgHandler is called with the messages of a subscription
+type MsgHandler func(*Msg)
+
+// dedupeWindow is the number of messages Dedupe remembers
+const dedupeWindow = 64
+
+// Dedupe wraps a command handler so a message delivered again at
+// QoS 1 is handled once. A Duplicate with the topic, Source and ID of
+// one of the last messages handled is dropped, a command like
+// "toggle" taken twice would undo itself. The IDs of each Source are
+// its own, like the backends of a Fanout.
+func Dedupe(cb func(*Msg)) func(*Msg) {
+	type key struct {
+		topic  string
+		source string
+		id     uint16
+	}
+	var (
+		seen = make(map[key]bool)
+		ring []key
+		mu   sync.Mutex
+	)
+	return func(msg *Msg) {
+		if msg.QoS > AtMostOnce {
+			k := key{msg.Topic, msg.Source, msg.ID}
+			mu.Lock()
+			if msg.Duplicate && seen[k] {
+				mu.Unlock()
+				return
+			}
+			if !seen[k] {
+				seen[k] = true
+				ring = append(ring, k)
+				if len(ring) > dedupeWindow {
+					delete(seen, ring[0])
+					ring = ring[1:]
+				}
+			}
+			mu.Unlock()
+		}
+		cb(msg)
+	}
+}
+
+// Messanger is the transport devices publish their data and receive
+// their commands over. Topic filters take the MQTT wildcards, + for a
+// level and # for the rest of the topic.
+//
+// A retained message is kept by the transport, the last one on each
+// topic, and handed to every subscriber that comes later. An empty
+// retained payload clears the topic.
+//
+// Publish and Subscribe are at QoS 0 and PublishRetained at QoS 1,
+// PublishQoS and SubscribeQoS take the level. A publish at QoS 1 and
+// over returns once the transport acknowledged it, ErrNotDelivered
+// when it did not in time.
+//
+// The station is announced "online" on its StationTopic every time
+// the Messanger connects, the will of the connection has it "offline"
+// when the connection is lost, and Close has it "offline" first.
+// Announce does the same for a device's topic, clearing the retained
+// topic withdraws it.
+type Messanger interface {
+	Publish(topic string, payload []byte) error
+	PublishRetained(topic string, payload []byte) error
+	PublishQoS(topic string, payload []byte, qos QoS, retain bool) error
+	Subscribe(topic string, cb func(*Msg)) error
+	SubscribeQoS(topic string, qos QoS, cb func(*Msg)) error
+	Announce(topic string) error
+	Close() error
+}
+
+var (
+	// ErrNoMessanger is returned subscribing a device whose
+	// transport has no Messanger
+	ErrNoMessanger = errors.New("no messanger for transport")
+
+	// ErrMessangerClosed is returned by a closed Messanger
+	ErrMessangerClosed = errors.New("messanger closed")
+
+	// ErrNotDelivered is returned by a publish the transport did not
+	// acknowledge in time
+	ErrNotDelivered = errors.New("not delivered")
+
+	// ErrNotConnected is returned publishing while the connection
+	// is down
+	ErrNotConnected = errors.New("not connected")
+)
+
+var (
+	messangers   = make(map[string]Messanger)
+	messangersMu sync.RWMutex
+)
+
+// GetMessanger returns the Messanger of transport, the name devices
+// are created with like "mqtt"
+func GetMessanger(transport string) (Messanger, bool) {
+	messangersMu.RLock()
+	defer messangersMu.RUnlock()
+	m, ok := messangers[transport]
+	return m, ok
+}
+
+// SetMessanger sets the Messanger of transport for every device
+// created with it that was not given one of its own, nil removes it.
+// Until one is set the data of the devices goes nowhere, which lets
+// them run without a broker. Tests set a MemMessanger for "mqtt" to
+// see what the devices publish.
+func SetMessanger(transport string, m Messanger) {
+	messangersMu.Lock()
+	defer messangersMu.Unlock()
+	if m == nil {
+		delete(messangers, transport)
+		return
+	}
+	messangers[transport] = m
+}
+
+// closeMessangers closes the Messangers and removes them, the devices
+// publish nowhere after
+func closeMessangers() error {
+	messangersMu.Lock()
+	all := messangers
+	messangers = make(map[string]Messanger)
+	messangersMu.Unlock()
+
+	var errs []error
+	for transport, m := range all {
+		if err := m.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s messanger: %w", transport, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type subscription struct {
+	filter string
+	qos    QoS
+	cb     func(*Msg)
+}
+
+// MemMessanger is a Messanger within the process. A publish is
+// delivered to the subscriptions before it returns, and is kept so
+// tests can check what was published without a broker. Retained
+// messages are kept like a broker does.
+//
+// SetRedeliver has the messages delivered at QoS 1 delivered again,
+// like a broker does when the acknowledgement was lost, to test the
+// handlers take a command once.
+//
+// Disconnect and Connect go through what a broker does when the
+// connection is lost and taken again.
+type MemMessanger struct {
+	subs      []subscription
+	msgs      []*Msg
+	retained  map[string]*Msg
+	births    announcer
+	will      string
+	willMsg   string
+	redeliver int
+	lastID    uint16
+	down      bool
+	closed    bool
+	mu        sync.Mutex
+}
+
+// NewMemMessanger creates an in memory Messanger of the station. It
+// is created connected but without announcing the station, tests see
+// what they publish only until they Connect.
+func NewMemMessanger() *MemMessanger {
+	m := &MemMessanger{retained: make(map[string]*Msg), will: StationTopic(), willMsg: Offline}
+	m.births.add(m.will)
+	return m
+}
+
+// Publish keeps the message and delivers it to the subscriptions
+// matching topic
+func (m *MemMessanger) Publish(topic string, payload []byte) error {
+	return m.PublishQoS(topic, payload, AtMostOnce, false)
+}
+
+// PublishRetained publishes the message and keeps it as the last one
+// on topic, an empty payload clears the topic
+func (m *MemMessanger) PublishRetained(topic string, payload []byte) error {
+	return m.PublishQoS(topic, payload, AtLeastOnce, true)
+}
+
+// PublishQoS publishes the message at qos, retained or not
+func (m *MemMessanger) PublishQoS(topic string, payload []byte, qos QoS, retain bool) error {
+	msg := NewMsg(topic, append([]byte(nil), payload...), "memory")
+	msg.QoS = qos
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrMessangerClosed
+	}
+	if m.down {
+		m.mu.Unlock()
+		return ErrNotConnected
+	}
+	if qos > AtMostOnce {
+		m.lastID++
+		if m.lastID == 0 {
+			m.lastID = 1
+		}
+		msg.ID = m.lastID
+	}
+	logged := *msg
+	logged.Retained = retain
+	m.msgs = append(m.msgs, &logged)
+	switch {
+	case retain && len(payload) == 0:
+		delete(m.retained, topic)
+		m.births.withdraw(topic)
+	case retain:
+		kept := *msg
+		kept.Retained = true
+		m.retained[topic] = &kept
+	}
+	var subs []subscription
+	for _, s := range m.subs {
+		if TopicMatch(s.filter, topic) {
+			subs = append(subs, s)
+		}
+	}
+	redeliver := m.redeliver
+	m.mu.Unlock()
+
+	// the callbacks may publish
+	for _, s := range subs {
+		deliver(s, msg, redeliver)
+	}
+	return nil
+}
+
+// deliver hands msg to the subscription at the lower QoS of the two,
+// and again redeliver times flagged Duplicate at QoS 1 and over
+func deliver(s subscription, msg *Msg, redeliver int) {
+	got := *msg
+	got.QoS = min(msg.QoS, s.qos)
+	if got.QoS == AtMostOnce {
+		got.ID = 0
+		redeliver = 0
+	}
+	s.cb(&got)
+	for range redeliver {
+		dup := got
+		dup.Duplicate = true
+		s.cb(&dup)
+	}
+}
+
+// Deliver hands msg to the subscriptions matching its topic, like a
+// message come from another client of the broker. It is not kept with
+// the messages published.
+func (m *MemMessanger) Deliver(msg *Msg) {
+	m.mu.Lock()
+	var subs []subscription
+	for _, s := range m.subs {
+		if TopicMatch(s.filter, msg.Topic) {
+			subs = append(subs, s)
+		}
+	}
+	m.mu.Unlock()
+	for _, s := range subs {
+		deliver(s, msg, 0)
+	}
+}
+
+// SetRedeliver has every message delivered at QoS 1 and over
+// delivered n times more, flagged Duplicate
+func (m *MemMessanger) SetRedeliver(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redeliver = n
+}
+
+// Subscribe calls cb with the retained messages on topics matching
+// the filter topic, then with every message published on them
+func (m *MemMessanger) Subscribe(topic string, cb func(*Msg)) error {
+	return m.SubscribeQoS(topic, AtMostOnce, cb)
+}
+
+// SubscribeQoS subscribes like Subscribe, the messages are delivered
+// at qos at most
+func (m *MemMessanger) SubscribeQoS(topic string, qos QoS, cb func(*Msg)) error {
+	s := subscription{filter: topic, qos: qos, cb: cb}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrMessangerClosed
+	}
+	m.subs = append(m.subs, s)
+	var kept []*Msg
+	for t, msg := range m.retained {
+		if TopicMatch(topic, t) {
+			kept = append(kept, msg)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Topic < kept[j].Topic })
+	for _, msg := range kept {
+		deliver(s, msg, 0)
+	}
+	return nil
+}
+
+// Retained returns the message retained on topic
+func (m *MemMessanger) Retained(topic string) (*Msg, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.retained[topic]
+	return msg, ok
+}
+
+// Messages returns the messages published on topics matching the
+// filter topic, oldest first, Retained set on the ones published
+// retained
+func (m *MemMessanger) Messages(topic string) []*Msg {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []*Msg
+	for _, msg := range m.msgs {
+		if TopicMatch(topic, msg.Topic) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Reset forgets the messages published so far, the retained ones
+// are still kept
+func (m *MemMessanger) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = nil
+}
+
+// Announce publishes topic "online" and again on every Connect
+func (m *MemMessanger) Announce(topic string) error {
+	if !m.births.add(topic) {
+		return nil
+	}
+	m.mu.Lock()
+	down := m.down
+	m.mu.Unlock()
+	if down {
+		return nil
+	}
+	return m.PublishQoS(topic, []byte(Online), AtLeastOnce, true)
+}
+
+// Connect takes the connection again, the station and the devices
+// announced go "online", an EventConnected
+func (m *MemMessanger) Connect() error {
+	m.mu.Lock()
+	m.down = false
+	m.mu.Unlock()
+	err := m.births.announce(m, Online)
+	emit(EventConnected, "", "", connection{Broker: "memory"})
+	return err
+}
+
+// SetWill sets the will to payload on topic, like MQTTWill
+func (m *MemMessanger) SetWill(topic, payload string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.will, m.willMsg = topic, payload
+}
+
+// Disconnect loses the connection, the broker publishes the will,
+// the station "offline" unless SetWill set another. Publishing fails
+// until Connect, an EventDisconnected.
+func (m *MemMessanger) Disconnect() {
+	m.mu.Lock()
+	will, msg := m.will, m.willMsg
+	m.mu.Unlock()
+	m.PublishQoS(will, []byte(msg), AtLeastOnce, true)
+	m.mu.Lock()
+	m.down = true
+	m.mu.Unlock()
+	emit(EventDisconnected, "", "", connection{Broker: "memory", Error: ErrNotConnected.Error()})
+}
+
+// Close has the station and the devices announced "offline", then
+// drops the subscriptions, publishing after fails
+func (m *MemMessanger) Close() error {
+	m.mu.Lock()
+	announce := !m.down && !m.closed
+	m.mu.Unlock()
+	var err error
+	if announce {
+		err = m.births.announce(m, Offline)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.subs = nil
+	return err
+}
@@ -0,0 +1,284 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"ss/d/station/bme280", "ss/d/station/bme280", true},
+		{"ss/d/station/bme280", "ss/d/station/bme28", false},
+		{"ss/d/station/bme280", "ss/d/station/bme280/env", false},
+		{"ss/d/+/bme280", "ss/d/garage/bme280", true},
+		{"ss/d/+/bme280", "ss/d/bme280", false},
+		{"ss/d/#", "ss/d/station/pir/occupancy", true},
+		{"ss/d/#", "ss/d", true},
+		{"ss/d/#", "ss/c/station/relay", false},
+		{"#", "anything/at/all", true},
+		{"+/+", "ss/d", true},
+		{"+", "", true},
+	}
+	for _, tt := range tests {
+		if got := TopicMatch(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("TopicMatch(%q, %q) got (%t) want (%t)", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestMemMessanger(t *testing.T) {
+	m := NewMemMessanger()
+	var got []string
+	m.Subscribe("ss/c/station/+", func(msg *Msg) {
+		got = append(got, msg.Topic+" "+msg.String())
+		// a callback can answer
+		m.Publish("ss/d/station/relay", []byte("ack"))
+	})
+
+	payload := []byte("on")
+	if err := m.Publish("ss/c/station/relay", payload); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	payload[0] = 'x' // the message is a copy
+	m.Publish("ss/c/station/relay/extra", []byte("ignored"))
+
+	if len(got) != 1 || got[0] != "ss/c/station/relay on" {
+		t.Errorf("delivered got (%q) want ([ss/c/station/relay on])", got)
+	}
+	if msgs := m.Messages("ss/d/#"); len(msgs) != 1 || msgs[0].String() != "ack" || msgs[0].Source != "memory" {
+		t.Errorf("Messages(ss/d/#) got (%v)", msgs)
+	}
+	if msgs := m.Messages("#"); len(msgs) != 3 || msgs[0].String() != "on" {
+		t.Errorf("Messages(#) got (%v) want 3 messages starting with on", msgs)
+	}
+
+	m.Reset()
+	if msgs := m.Messages("#"); len(msgs) != 0 {
+		t.Errorf("Messages() after Reset() got (%v)", msgs)
+	}
+	m.Close()
+	if err := m.Publish("ss/c/station/relay", nil); !errors.Is(err, ErrMessangerClosed) {
+		t.Errorf("Publish() closed error got (%v) want (%v)", err, ErrMessangerClosed)
+	}
+	if err := m.Subscribe("#", func(*Msg) {}); !errors.Is(err, ErrMessangerClosed) {
+		t.Errorf("Subscribe() closed error got (%v) want (%v)", err, ErrMessangerClosed)
+	}
+}
+
+func TestPubData(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("sensor", "mqtt", WithMessanger(m), WithEnvelope(false))
+	if d.Topic() != "ss/d/station/sensor" {
+		t.Errorf("Topic() got (%s) want (ss/d/station/sensor)", d.Topic())
+	}
+
+	tests := []struct {
+		data any
+		want string
+	}{
+		{[]byte(`{"temperature":"71.24"}`), `{"temperature":"71.24"}`},
+		{"on", "on"},
+		{21.456, "21.46"},
+		{float32(0.5), "0.50"},
+		{1, "1"},
+		{uint8(7), "7"},
+		{true, "true"},
+		{struct {
+			Lux float64 `json:"lux"`
+		}{120.5}, `{"lux":120.5}`},
+	}
+	for _, tt := range tests {
+		m.Reset()
+		if err := d.PubData(tt.data); err != nil {
+			t.Fatalf("PubData(%v) error = %v", tt.data, err)
+		}
+		msgs := m.Messages(d.Topic())
+		if len(msgs) != 1 || msgs[0].String() != tt.want {
+			t.Errorf("PubData(%v) got (%v) want (%s)", tt.data, msgs, tt.want)
+		}
+	}
+	if err := d.PubData(func() {}); err == nil {
+		t.Error("PubData(func) did not fail")
+	}
+
+	d.SetTopic("ss/d/station/other")
+	d.PubData("moved")
+	if msgs := m.Messages("ss/d/station/other"); len(msgs) != 1 {
+		t.Errorf("SetTopic() published on got (%v)", m.Messages("#"))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	// without a messanger the data goes nowhere
+	d := NewDevice("sensor", "test")
+	if err := d.PubData("dropped"); err != nil {
+		t.Errorf("PubData() without a messanger error = %v", err)
+	}
+	if err := d.Subscribe(ControlTopic("sensor"), func(*Msg) {}); !errors.Is(err, ErrNoMessanger) {
+		t.Errorf("Subscribe() without a messanger error got (%v) want (%v)", err, ErrNoMessanger)
+	}
+
+	m := NewMemMessanger()
+	SetMessanger("test", m)
+	defer SetMessanger("test", nil)
+	if got, ok := GetMessanger("test"); !ok || got != m {
+		t.Fatal("GetMessanger() did not return the messanger set")
+	}
+
+	// devices pick the messanger of their transport when they use it
+	var cmds []string
+	if err := d.Subscribe(ControlTopic("sensor"), func(msg *Msg) { cmds = append(cmds, msg.String()) }); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	other := NewDevice("other", "test", WithTopic("ss/d/station/sensor/other"), WithEnvelope(false))
+	other.PubData(3.3)
+	m.Publish(ControlTopic("sensor"), []byte("reset"))
+	if msgs := m.Messages(DataTopic("sensor") + "/#"); len(msgs) != 1 || msgs[0].String() != "3.30" {
+		t.Errorf("Messages() got (%v) want ([3.30])", msgs)
+	}
+	if len(cmds) != 1 || cmds[0] != "reset" {
+		t.Errorf("commands got (%q) want ([reset])", cmds)
+	}
+
+	// another transport is not affected
+	if _, ok := NewDevice("x", "mqtt").Messanger(); ok {
+		t.Error("a mqtt device got the test messanger")
+	}
+}
+
+// named is a Device the manager can hold
+type named struct{ *Device }
+
+func (n named) Name() string { return n.Device.Name }
+
+func TestRetained(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("relay", "mqtt", WithMessanger(m), WithEnvelope(false))
+	d.PubState("on")
+	d.PubData(1.5)
+	d.PubState("off")
+
+	// the dashboard connects late
+	var got []*Msg
+	m.Subscribe("ss/+/station/relay", func(msg *Msg) { got = append(got, msg) })
+	if len(got) != 1 || got[0].String() != "off" || !got[0].Retained || got[0].Topic != d.StateTopic() {
+		t.Fatalf("late subscriber got (%v) want the last state retained", got)
+	}
+
+	// live messages are not flagged
+	d.PubState("on")
+	if len(got) != 2 || got[1].Retained {
+		t.Errorf("live state got (%+v)", got[len(got)-1])
+	}
+
+	// retaining the data too
+	d.SetRetain(Retain{Data: true, State: true})
+	d.PubData(2.5)
+	if msg, ok := m.Retained(d.DataTopic()); !ok || msg.String() != "2.50" {
+		t.Errorf("Retained(data) got (%v, %t) want (2.50)", msg, ok)
+	}
+	d.SetRetain(Retain{})
+	d.PubState("off")
+	if msg, _ := m.Retained(d.StateTopic()); msg.String() != "on" {
+		t.Errorf("Retained(state) not retaining got (%v) want (on)", msg)
+	}
+
+	// removed from the manager, nothing is left for the next
+	// subscriber but the clear is delivered to the ones there
+	got = nil
+	dm := GetDeviceManager()
+	dm.Add(named{d})
+	dm.Remove("relay")
+	if _, ok := m.Retained(d.StateTopic()); ok {
+		t.Error("Remove() left the state retained")
+	}
+	if _, ok := m.Retained(d.DataTopic()); ok {
+		t.Error("Remove() left the data retained")
+	}
+	if len(got) != 3 || len(got[0].Data) != 0 {
+		t.Errorf("clear delivered got (%v) want 3 empty messages", got)
+	}
+	got = nil
+	m.Subscribe("#", func(msg *Msg) { got = append(got, msg) })
+	if len(got) != 0 {
+		t.Errorf("subscriber after Remove() got (%v)", got)
+	}
+
+	// availability and discovery go retained whatever the device
+	d.PubRetained("ss/a/station/relay", "online")
+	if msg, ok := m.Retained("ss/a/station/relay"); !ok || msg.String() != "online" {
+		t.Errorf("PubRetained() got (%v, %t) want (online)", msg, ok)
+	}
+	if d := NewDevice("led", "mqtt"); d.Retain() != DefaultRetain || DefaultRetain.Data || !DefaultRetain.State {
+		t.Errorf("Retain() got (%+v) want state only", d.Retain())
+	}
+}
+
+func TestQoS(t *testing.T) {
+	m := NewMemMessanger()
+	m.SetRedeliver(1)
+	d := NewDevice("relay", "mqtt", WithMessanger(m), WithQoS(AtLeastOnce))
+
+	// a toggle taken twice is no toggle
+	on := false
+	var handled, delivered int
+	toggle := func(*Msg) { on = !on; handled++ }
+	if err := d.Subscribe(d.ControlTopic(), func(msg *Msg) { delivered++ }); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	d.Subscribe(d.ControlTopic(), Dedupe(toggle))
+	m.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	if delivered != 2 || handled != 1 || !on {
+		t.Errorf("redelivered got (%d delivered, %d handled, on %t) want (2, 1, true)", delivered, handled, on)
+	}
+	m.PublishQoS(d.ControlTopic(), []byte("toggle"), AtLeastOnce, false)
+	if handled != 2 || on {
+		t.Errorf("second toggle got (%d handled, on %t) want (2, false)", handled, on)
+	}
+
+	// QoS 0 is not delivered again, a subscription at 0 takes QoS 1
+	// at 0
+	var low []*Msg
+	m.Subscribe(d.ControlTopic(), func(msg *Msg) { low = append(low, msg) })
+	m.Publish(d.ControlTopic(), []byte("on"))
+	m.PublishQoS(d.ControlTopic(), []byte("off"), AtLeastOnce, false)
+	if len(low) != 2 || low[1].QoS != AtMostOnce || low[1].ID != 0 || delivered != 7 {
+		t.Errorf("qos 0 got (%v, %d delivered)", low, delivered)
+	}
+
+	// the device publishes at its QoS unless told otherwise
+	m.Reset()
+	sensor := NewDevice("lux", "mqtt", WithMessanger(m))
+	sensor.PubData(120.5)
+	sensor.PubData("too dark", PubQoS(AtLeastOnce))
+	sensor.PubData(1, PubRetain(true))
+	d.PubData("on")
+	msgs := m.Messages("#")
+	if len(msgs) != 4 || msgs[0].QoS != AtMostOnce || msgs[1].QoS != AtLeastOnce || msgs[2].QoS != AtLeastOnce || msgs[3].QoS != AtLeastOnce {
+		t.Errorf("publish qos got (%+v)", msgs)
+	}
+	if _, ok := m.Retained(sensor.DataTopic()); !ok {
+		t.Error("PubRetain(true) did not retain")
+	}
+	if s := sensor.PubStats(); s != (PubStats{Published: 3}) {
+		t.Errorf("PubStats() got (%+v) want 3 published", s)
+	}
+
+	// failures are the device's errors
+	m.Close()
+	if err := sensor.PubData(1.0); !errors.Is(err, ErrMessangerClosed) {
+		t.Errorf("PubData() closed error got (%v) want (%v)", err, ErrMessangerClosed)
+	}
+	if !errors.Is(sensor.Error(), ErrMessangerClosed) || sensor.PubStats().Failed != 1 {
+		t.Errorf("failed publish got (%v, %+v)", sensor.Error(), sensor.PubStats())
+	}
+	dropped := NewDevice("lux", "none")
+	dropped.PubData(1.0)
+	if s := dropped.PubStats(); s != (PubStats{Dropped: 1}) || dropped.Error() != nil {
+		t.Errorf("dropped got (%+v, %v)", s, dropped.Error())
+	}
+}
@@ -0,0 +1,314 @@
+// Package mhz19 reads the Winsen MH-Z19 NDIR CO2 sensor over its
+// UART, 9600 baud, with its 9 byte command protocol.
+//
+// The sensor answers a read with the CO2 in ppm and its temperature.
+// Its UART buffer keeps replies nobody waited for, the acks of the
+// configuration commands and replies that came too late, so a reply
+// is only taken when it answers the command just sent, the others are
+// counted as stale and dropped. The lamp takes WarmUp, 3 minutes,
+// after power on to heat up, the readings meanwhile are fixed values
+// and are not published.
+//
+// Automatic baseline calibration, on from the factory, takes the
+// lowest reading of each day as 400ppm, turn it off where the air is
+// never fresh. A zero point calibration sets 400ppm to the current
+// reading, it is only taken with the "calibrate zero confirm" command
+// after 20 minutes in fresh air.
+package mhz19
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	Baud = 9600
+
+	// DefaultWarmUp is the preheat time of the datasheet
+	DefaultWarmUp = 3 * time.Minute
+
+	// DefaultTimeout waits for a reply
+	DefaultTimeout = time.Second
+)
+
+// Ranges the sensor can be set to measure up to, in ppm
+var Ranges = []int{2000, 5000, 10000}
+
+var (
+	ErrTimeout = errors.New("no mhz19 reply")
+	ErrRange   = errors.New("invalid mhz19 detection range")
+	ErrConfirm = errors.New("zero point calibration needs confirm")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes, with the stale replies dropped
+// since the start
+type Reading struct {
+	Response
+	Stale int `json:"stale"`
+}
+
+// MHZ19 is a CO2 sensor on a UART
+type MHZ19 struct {
+	*device.Device
+
+	// WarmUp is how long after the start nothing is published
+	WarmUp time.Duration
+
+	// Timeout is how long to wait for a reply
+	Timeout time.Duration
+
+	port    io.ReadWriter
+	parser  Parser
+	frames  chan frame
+	listen  sync.Once
+	started time.Time
+	stale   int
+	now     func() time.Time
+	mu      sync.Mutex // one exchange at a time
+}
+
+// New creates an MH-Z19 on the serial port, like "/dev/serial0"
+func New(name, port string) (*MHZ19, error) {
+	if device.IsMock() {
+		return NewWithPort(name, nil), nil
+	}
+	s, err := drivers.NewSerial(port, Baud)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPort(name, s), nil
+}
+
+// NewWithPort creates an MH-Z19 talking over port, it is closed by
+// Close if it is an io.Closer. The sensor is taken as just powered
+// on, warming up.
+func NewWithPort(name string, port io.ReadWriter) *MHZ19 {
+	return &MHZ19{
+		Device:  device.NewDevice(name, "mqtt"),
+		WarmUp:  DefaultWarmUp,
+		Timeout: DefaultTimeout,
+		port:    port,
+		frames:  make(chan frame, 8),
+		started: time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Name returns the name of the device
+func (m *MHZ19) Name() string {
+	return m.Device.Name
+}
+
+// start reads the port in the background until it fails, the frames
+// are queued for exchange, the oldest dropped when nobody takes them
+func (m *MHZ19) start() {
+	m.listen.Do(func() {
+		go func() {
+			buf := make([]byte, 32)
+			for {
+				n, err := m.port.Read(buf)
+				fs, errs := m.parser.Feed(buf[:n])
+				for _, e := range errs {
+					slog.Debug("mhz19 frame dropped", "device", m.Device.Name, "error", e)
+				}
+				for _, f := range fs {
+					m.queue(f)
+				}
+				if err != nil {
+					if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+						slog.Warn("mhz19 read", "device", m.Device.Name, "error", err)
+					}
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (m *MHZ19) queue(f frame) {
+	for {
+		select {
+		case m.frames <- f:
+			return
+		default:
+		}
+		select {
+		case <-m.frames:
+		default:
+		}
+	}
+}
+
+// send writes a command without waiting for a reply
+func (m *MHZ19) send(cmd byte, data ...byte) error {
+	f := command(cmd, data...)
+	if _, err := m.port.Write(f[:]); err != nil {
+		return fmt.Errorf("%s: %w", m.Device.Name, err)
+	}
+	return nil
+}
+
+// exchange sends a command and returns its reply. Frames already
+// waiting and replies to other commands are stale and dropped.
+func (m *MHZ19) exchange(cmd byte, data ...byte) (frame, error) {
+	m.start()
+	for drained := false; !drained; {
+		select {
+		case <-m.frames:
+			m.stale++
+		default:
+			drained = true
+		}
+	}
+	if err := m.send(cmd, data...); err != nil {
+		return frame{}, err
+	}
+	timeout := time.After(m.Timeout)
+	for {
+		select {
+		case f := <-m.frames:
+			if f[1] == cmd {
+				return f, nil
+			}
+			m.stale++
+			slog.Debug("mhz19 stale reply", "device", m.Device.Name, "reply", fmt.Sprintf("% x", f[:]))
+		case <-timeout:
+			return frame{}, fmt.Errorf("%w: to %#02x in %v", ErrTimeout, cmd, m.Timeout)
+		}
+	}
+}
+
+// Read reads the CO2 and the temperature
+func (m *MHZ19) Read() (*Reading, error) {
+	if device.IsMock() {
+		return &Reading{Response: Response{CO2: 400 + m.MockRand().Intn(800), Temperature: 20 + m.MockRand().Intn(6)}}, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.exchange(cmdRead)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseRead(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reading{Response: r, Stale: m.stale}, nil
+}
+
+// SetABC turns the automatic baseline calibration on or off
+func (m *MHZ19) SetABC(on bool) error {
+	if device.IsMock() {
+		return nil
+	}
+	var v byte
+	if on {
+		v = abcOn
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.send(cmdABC, v)
+}
+
+// SetRange sets the detection range in ppm, one of Ranges
+func (m *MHZ19) SetRange(ppm int) error {
+	valid := false
+	for _, r := range Ranges {
+		valid = valid || r == ppm
+	}
+	if !valid {
+		return fmt.Errorf("%w: %d ppm", ErrRange, ppm)
+	}
+	if device.IsMock() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.send(cmdRange, 0, 0, 0, byte(ppm>>8), byte(ppm))
+}
+
+// CalibrateZero takes the current reading as 400ppm, there is no
+// undoing it. Have the sensor in fresh air for 20 minutes first.
+func (m *MHZ19) CalibrateZero() error {
+	if device.IsMock() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	slog.Info("mhz19 zero point calibration", "device", m.Device.Name)
+	return m.send(cmdZero)
+}
+
+// Warm reports if the warm up is over
+func (m *MHZ19) Warm() bool {
+	return m.now().Sub(m.started) >= m.WarmUp
+}
+
+// Command handles a command payload: "abc on", "abc off", "range
+// 5000" or "calibrate zero confirm"
+func (m *MHZ19) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(c) == 2 && c[0] == "abc" && c[1] == "on":
+		return m.SetABC(true)
+	case len(c) == 2 && c[0] == "abc" && c[1] == "off":
+		return m.SetABC(false)
+	case len(c) == 2 && c[0] == "range":
+		ppm, err := strconv.Atoi(c[1])
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrRange, c[1])
+		}
+		return m.SetRange(ppm)
+	case len(c) == 3 && c[0] == "calibrate" && c[1] == "zero" && c[2] == "confirm":
+		return m.CalibrateZero()
+	case len(c) == 2 && c[0] == "calibrate" && c[1] == "zero":
+		return fmt.Errorf("%w: send %q", ErrConfirm, "calibrate zero confirm")
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub reads the sensor and publishes the reading, nothing while
+// it warms up
+func (m *MHZ19) ReadPub() error {
+	if !m.Warm() {
+		slog.Debug("mhz19 warming up", "device", m.Device.Name)
+		return nil
+	}
+	r, err := m.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	m.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (m *MHZ19) Run(ctx context.Context, period time.Duration) error {
+	err := m.TimerLoop(ctx, period, m.ReadPub)
+	slog.Debug("mhz19 stopped", "device", m.Device.Name, "error", err)
+	return err
+}
+
+// Close closes the serial port, which ends the background reading
+func (m *MHZ19) Close() error {
+	if c, ok := m.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package mhz19
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// captured exchanges with a sensor
+const (
+	cmdReadHex  = "ff 01 86 00 00 00 00 00 79"
+	reply608    = "ff 86 02 60 47 00 00 00 d1" // 608ppm 31°C
+	reply415    = "ff 86 01 9f 40 00 00 00 9a" // 415ppm 24°C
+	reply500    = "ff 86 01 f4 3f 00 00 00 46" // 500ppm 23°C, warming up
+	ackABC      = "ff 79 01 00 00 00 00 00 86"
+	ackRange    = "ff 99 01 00 00 00 00 00 66"
+	abcOnHex    = "ff 01 79 a0 00 00 00 00 e6"
+	abcOffHex   = "ff 01 79 00 00 00 00 00 86"
+	zeroHex     = "ff 01 87 00 00 00 00 00 78"
+	range2000   = "ff 01 99 00 00 00 07 d0 8f"
+	range5000   = "ff 01 99 00 00 00 13 88 cb"
+	range10000  = "ff 01 99 00 00 00 27 10 2f"
+	corruptRead = "ff 86 02 60 47 00 00 00 d2"
+)
+
+func bytesOf(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestCommandFrames(t *testing.T) {
+	tests := []struct {
+		f    frame
+		want string
+	}{
+		{command(cmdRead), cmdReadHex},
+		{command(cmdABC, abcOn), abcOnHex},
+		{command(cmdABC, 0), abcOffHex},
+		{command(cmdZero), zeroHex},
+		{command(cmdRange, 0, 0, 0, 0x07, 0xD0), range2000},
+		{command(cmdRange, 0, 0, 0, 0x13, 0x88), range5000},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf("% x", tt.f[:]); got != tt.want {
+			t.Errorf("command got (%s) want (%s)", got, tt.want)
+		}
+	}
+	for _, s := range []string{reply608, reply415, ackABC, ackRange} {
+		b := bytesOf(s)
+		if got := checksum(b); got != b[8] {
+			t.Errorf("checksum(%s) got (%#02x)", s, got)
+		}
+	}
+}
+
+func TestParser(t *testing.T) {
+	// a reply cut off by the start up, a corrupt one, two good ones
+	// and an ack, in reads of any size
+	stream := append(bytesOf(reply608)[4:], bytesOf(corruptRead+" "+reply608+" "+reply415+" "+ackABC)...)
+	for _, size := range []int{1, 2, 8, 9, 10, len(stream)} {
+		var p Parser
+		var got []string
+		var errs []error
+		for data := stream; len(data) > 0; {
+			n := min(size, len(data))
+			fs, e := p.Feed(data[:n])
+			for _, f := range fs {
+				got = append(got, fmt.Sprintf("% x", f[:]))
+			}
+			errs = append(errs, e...)
+			data = data[n:]
+		}
+		want := []string{reply608, reply415, ackABC}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("reads of %d got (%v) want (%v)", size, got, want)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrChecksum) {
+			t.Errorf("reads of %d errors got (%v) want (%v)", size, errs, ErrChecksum)
+		}
+	}
+
+	var f frame
+	copy(f[:], bytesOf(reply608))
+	if r, err := parseRead(f); err != nil || r.CO2 != 608 || r.Temperature != 31 {
+		t.Errorf("parseRead(%s) got (%+v, %v) want (608ppm 31°C)", reply608, r, err)
+	}
+	copy(f[:], bytesOf(ackABC))
+	if _, err := parseRead(f); !errors.Is(err, ErrFrame) {
+		t.Errorf("parseRead(%s) error got (%v) want (%v)", ackABC, err, ErrFrame)
+	}
+}
+
+// sensor plays an MH-Z19 on the other end of a pipe. It answers a
+// read with reply, after the frames left in before, and acks the
+// configuration commands, the acks nobody waits for stay in the
+// buffer.
+type sensor struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+
+	reply  string
+	before []string
+	silent bool
+	cmds   []string
+	mu     sync.Mutex
+}
+
+func newSensor(t *testing.T, reply string) *sensor {
+	r, w := io.Pipe()
+	s := &sensor{r: r, w: w, reply: reply}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func (s *sensor) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+func (s *sensor) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = append(s.cmds, fmt.Sprintf("% x", b))
+	var out []string
+	switch b[2] {
+	case cmdRead:
+		if !s.silent {
+			out = append(s.before, s.reply)
+		}
+		s.before = nil
+	case cmdABC:
+		out = []string{ackABC}
+	case cmdRange:
+		out = []string{ackRange}
+	}
+	go func() {
+		for _, f := range out {
+			s.w.Write(bytesOf(f))
+		}
+	}()
+	return len(b), nil
+}
+
+func (s *sensor) Close() error {
+	s.r.Close()
+	return s.w.Close()
+}
+
+func (s *sensor) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cmds
+	s.cmds = nil
+	return c
+}
+
+func TestRead(t *testing.T) {
+	device.Mock(false)
+	s := newSensor(t, reply608)
+	m := NewWithPort("co2", s)
+	defer m.Close()
+
+	r, err := m.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.CO2 != 608 || r.Temperature != 31 || r.Stale != 0 {
+		t.Errorf("Read() got (%+v) want (608ppm 31°C)", r)
+	}
+
+	// the ack of the abc command is left in the buffer, and the
+	// sensor sends an old reply before the new one
+	if err := m.Command([]byte("ABC off")); err != nil {
+		t.Fatalf("abc off error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	s.reply, s.before = reply415, []string{reply608, ackRange}
+	s.mu.Unlock()
+	if r, err = m.Read(); err != nil || r.CO2 != 608 {
+		// the old reply answers the read, it can not be told apart
+		t.Errorf("Read() with an old reply got (%+v, %v) want (608ppm)", r, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if r, err = m.Read(); err != nil || r.CO2 != 415 || r.Temperature != 24 || r.Stale != 3 {
+		t.Errorf("Read() got (%+v, %v) want (415ppm 24°C 3 stale)", r, err)
+	}
+	want := []string{cmdReadHex, abcOffHex, cmdReadHex, cmdReadHex}
+	if got := s.commands(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	m.Timeout = 30 * time.Millisecond
+	s.mu.Lock()
+	s.silent = true
+	s.mu.Unlock()
+	if _, err := m.Read(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Read() without a reply error got (%v) want (%v)", err, ErrTimeout)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	device.Mock(false)
+	s := newSensor(t, reply608)
+	m := NewWithPort("co2", s)
+	defer m.Close()
+
+	tests := []struct {
+		payload string
+		want    string
+		err     error
+	}{
+		{"abc on", abcOnHex, nil},
+		{"abc off", abcOffHex, nil},
+		{"range 2000", range2000, nil},
+		{"Range 5000", range5000, nil},
+		{"range 10000", range10000, nil},
+		{"range 3000", "", ErrRange},
+		{"range x", "", ErrRange},
+		{"calibrate zero", "", ErrConfirm},
+		{"calibrate zero confirm", zeroHex, nil},
+		{"calibrate span", "", ErrCommand},
+	}
+	for _, tt := range tests {
+		err := m.Command([]byte(tt.payload))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", tt.payload, err, tt.err)
+		}
+		got := s.commands()
+		if tt.want == "" && len(got) != 0 || tt.want != "" && fmt.Sprint(got) != fmt.Sprint([]string{tt.want}) {
+			t.Errorf("Command(%s) sent (%v) want (%s)", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	device.Mock(false)
+	s := newSensor(t, reply500)
+	m := NewWithPort("co2", s)
+	defer m.Close()
+	clock := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return clock }
+	m.started = clock
+
+	if err := m.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() warming up error = %v", err)
+	}
+	if got := s.commands(); len(got) != 0 || m.Warm() {
+		t.Errorf("commands warming up got (%v) want none", got)
+	}
+	clock = clock.Add(DefaultWarmUp)
+	if err := m.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	if got := s.commands(); fmt.Sprint(got) != fmt.Sprint([]string{cmdReadHex}) {
+		t.Errorf("commands warm got (%v) want ([%s])", got, cmdReadHex)
+	}
+}
@@ -0,0 +1,101 @@
+package mhz19
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrChecksum = errors.New("mhz19 checksum mismatch")
+	ErrFrame    = errors.New("malformed mhz19 frame")
+)
+
+// frameLen is the length of every command and response
+const frameLen = 9
+
+// commands, the response to one starts 0xFF and the command
+const (
+	cmdRead      = 0x86
+	cmdZero      = 0x87
+	cmdABC       = 0x79
+	cmdRange     = 0x99
+	startByte    = 0xFF
+	sensorNumber = 0x01
+
+	abcOn = 0xA0
+)
+
+// frame is a command or a response, 0xFF, the sensor number or the
+// command, 7 bytes and the checksum
+type frame [frameLen]byte
+
+// checksum is the two's complement of the sum of bytes 1 to 7
+func checksum(f []byte) byte {
+	var sum byte
+	for _, b := range f[1 : frameLen-1] {
+		sum += b
+	}
+	return ^sum + 1
+}
+
+// command returns the frame of cmd with the data in bytes 3 on
+func command(cmd byte, data ...byte) frame {
+	f := frame{startByte, sensorNumber, cmd}
+	copy(f[3:frameLen-1], data)
+	f[frameLen-1] = checksum(f[:])
+	return f
+}
+
+// Response is the reply to a read, the CO2 in ppm and the
+// temperature of the sensor in °C, not of the room, off by a few
+// degrees
+type Response struct {
+	CO2         int `json:"co2"`
+	Temperature int `json:"temperature"`
+}
+
+// parseRead parses the response to cmdRead
+func parseRead(f frame) (Response, error) {
+	if f[1] != cmdRead {
+		return Response{}, fmt.Errorf("%w: reply to %#02x", ErrFrame, f[1])
+	}
+	return Response{
+		CO2:         int(f[2])<<8 | int(f[3]),
+		Temperature: int(f[4]) - 40,
+	}, nil
+}
+
+// Parser cuts the bytes coming off the UART into frames, a frame
+// split over several reads is put back together and bytes up to a
+// 0xFF are dropped
+type Parser struct {
+	buf []byte
+}
+
+// Feed parses data, returning the frames it completed and the errors
+// of those dropped for a bad checksum
+func (p *Parser) Feed(data []byte) ([]frame, []error) {
+	p.buf = append(p.buf, data...)
+	var fs []frame
+	var errs []error
+	for {
+		i := 0
+		for i < len(p.buf) && p.buf[i] != startByte {
+			i++
+		}
+		p.buf = p.buf[i:]
+		if len(p.buf) < frameLen {
+			break
+		}
+		var f frame
+		copy(f[:], p.buf)
+		if got, want := f[frameLen-1], checksum(f[:]); got != want {
+			errs = append(errs, fmt.Errorf("%w: got %#02x want %#02x", ErrChecksum, got, want))
+			p.buf = p.buf[1:]
+			continue
+		}
+		fs = append(fs, f)
+		p.buf = p.buf[frameLen:]
+	}
+	return fs, errs
+}
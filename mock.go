@@ -0,0 +1,228 @@
+package device
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// MockMode is what a mock sequence does once its last value was read
+type MockMode int
+
+const (
+	MockOnce     MockMode = iota // the reads after fail, ErrMockDone unless MockErr
+	MockLoop                     // starts over
+	MockHoldLast                 // the last value stays
+)
+
+var (
+	// ErrMockDone is the error of the reads of a MockOnce sequence
+	// past its last value
+	ErrMockDone = errors.New("mock sequence done")
+
+	// ErrMockSeedSet is returned by SetDefaultMockSeed once the
+	// default seed was set or used
+	ErrMockSeedSet = errors.New("default mock seed already set")
+)
+
+// the default seed of the mock randomness, fixed when set or first used
+var mockSeed struct {
+	seed  int64
+	fixed bool
+	mu    sync.Mutex
+}
+
+// SetDefaultMockSeed sets the seed the mock randomness of the devices
+// without one of their own derive theirs from, for the run of a test
+// to be the same as the last. It can be set once, before a device
+// used it.
+func SetDefaultMockSeed(seed int64) error {
+	mockSeed.mu.Lock()
+	defer mockSeed.mu.Unlock()
+	if mockSeed.fixed {
+		return ErrMockSeedSet
+	}
+	mockSeed.seed, mockSeed.fixed = seed, true
+	return nil
+}
+
+// DefaultMockSeed returns the default seed, one of the clock when it
+// was not set, logged by a failing test to run it again the same
+func DefaultMockSeed() int64 {
+	mockSeed.mu.Lock()
+	defer mockSeed.mu.Unlock()
+	if !mockSeed.fixed {
+		mockSeed.seed, mockSeed.fixed = time.Now().UnixNano(), true
+	}
+	return mockSeed.seed
+}
+
+// MockSeedOf returns the seed of the mock randomness of the device
+// named name without one of its own, the default seed and its name so
+// two devices do not read the same
+func MockSeedOf(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return DefaultMockSeed() ^ int64(h.Sum64())
+}
+
+// MockRand is the randomness of the mock readings of a device, a
+// rand.Rand of its own safe for concurrent use. The same seed reads
+// the same values, whatever the other devices and tests read.
+type MockRand struct {
+	r    *rand.Rand
+	seed int64
+	mu   sync.Mutex
+}
+
+// NewMockRand returns a MockRand seeded with seed
+func NewMockRand(seed int64) *MockRand {
+	return &MockRand{r: rand.New(rand.NewSource(seed)), seed: seed}
+}
+
+// Seed returns the seed of r
+func (r *MockRand) Seed() int64 {
+	return r.seed
+}
+
+// Float64 returns a number in [0.0,1.0)
+func (r *MockRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// NormFloat64 returns a normally distributed number, the mean 0 and
+// the standard deviation 1
+func (r *MockRand) NormFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.NormFloat64()
+}
+
+// Intn returns a number in [0,n)
+func (r *MockRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// mockSequence is the values the mock reads of a device take in turn
+type mockSequence struct {
+	values []any
+	mode   MockMode
+	next   int
+	err    error
+}
+
+// MockOption configures a mock sequence
+type MockOption func(*mockSequence)
+
+// MockErr sets the error of the reads past the last value of a
+// MockOnce sequence
+func MockErr(err error) MockOption {
+	return func(s *mockSequence) {
+		s.err = err
+	}
+}
+
+// SetMockSequence scripts the values the device reads, in turn, for
+// the tests of what it publishes as the values change, like a
+// threshold crossed. A device with a sequence reads it rather than
+// its sensor or the random values of Mock, the kind of the values is
+// the one of its package, an error is returned by the read. No values
+// remove the sequence.
+func (d *Device) SetMockSequence(values []any, mode MockMode, opts ...MockOption) {
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(values) == 0 {
+		d.mock = nil
+		return
+	}
+	s := &mockSequence{values: append([]any(nil), values...), mode: mode, err: ErrMockDone}
+	for _, opt := range opts {
+		opt(s)
+	}
+	d.mock = s
+}
+
+// MockSource is where the mock values of devices come from, like a
+// Replay or a CSVSource, several devices may read the same
+type MockSource interface {
+	// NextValue returns the next value of the device named name
+	NextValue(name string) (any, error)
+}
+
+// SetMockSource has the device read the values of src, its mock
+// sequence first when it has one, nil stops it
+func (d *Device) SetMockSource(src MockSource) {
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.source = src
+}
+
+// NextMock returns the next value of the mock sequence of the device,
+// or of its MockSource, ok when it has one. The error is the value
+// when it is one, or the one of a MockOnce sequence or a source read
+// past its end.
+func (d *Device) NextMock() (v any, ok bool, err error) {
+	d.mu.Lock()
+	s, src := d.mock, d.source
+	if s != nil {
+		defer d.mu.Unlock()
+		v, err = s.read()
+		return v, true, err
+	}
+	d.mu.Unlock()
+	if src == nil {
+		return nil, false, nil
+	}
+	if v, err = src.NextValue(d.Name); err != nil {
+		return nil, true, err
+	}
+	return v, true, nil
+}
+
+// read returns the next value of the sequence
+func (s *mockSequence) read() (any, error) {
+	if s.next == len(s.values) {
+		switch s.mode {
+		case MockLoop:
+			s.next = 0
+		case MockHoldLast:
+			s.next--
+		default:
+			return nil, s.err
+		}
+	}
+	v := s.values[s.next]
+	s.next++
+	if err, isErr := v.(error); isErr {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetMockSeed seeds the mock randomness of the device, reading the
+// same values again from the start
+func (d *Device) SetMockSeed(seed int64) {
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rand, d.seeded = NewMockRand(seed), true
+}
+
+// MockRand returns the randomness the mock readings of the device
+// are made of, seeded with MockSeedOf its name unless SetMockSeed
+func (d *Device) MockRand() *MockRand {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rand == nil {
+		d.rand = NewMockRand(MockSeedOf(d.Name))
+	}
+	return d.rand
+}
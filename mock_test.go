@@ -0,0 +1,104 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMockSequence(t *testing.T) {
+	d := NewDevice("probe", "mqtt")
+	if _, ok, _ := d.NextMock(); ok {
+		t.Fatal("NextMock() without a sequence got ok")
+	}
+
+	errStuck := errors.New("stuck")
+	for _, tt := range []struct {
+		name string
+		mode MockMode
+		opts []MockOption
+		want []any
+		err  error
+	}{
+		{"once", MockOnce, nil, []any{1, 2, nil}, ErrMockDone},
+		{"once error", MockOnce, []MockOption{MockErr(errStuck)}, []any{1, 2, nil}, errStuck},
+		{"loop", MockLoop, nil, []any{1, 2, 1, 2, 1}, nil},
+		{"hold last", MockHoldLast, nil, []any{1, 2, 2, 2}, nil},
+	} {
+		d.SetMockSequence([]any{1, 2}, tt.mode, tt.opts...)
+		for i, want := range tt.want {
+			v, ok, err := d.NextMock()
+			if !ok {
+				t.Fatalf("%s NextMock() %d not ok", tt.name, i)
+			}
+			if want == nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("%s NextMock() %d error got (%v) want (%v)", tt.name, i, err, tt.err)
+				}
+				continue
+			}
+			if err != nil || v != want {
+				t.Errorf("%s NextMock() %d got (%v, %v) want (%v)", tt.name, i, v, err, want)
+			}
+		}
+	}
+
+	// an error in the sequence is the one of its read
+	d.SetMockSequence([]any{errStuck, 3}, MockOnce)
+	if _, _, err := d.NextMock(); !errors.Is(err, errStuck) {
+		t.Errorf("NextMock() error got (%v) want (%v)", err, errStuck)
+	}
+	if v, _, _ := d.NextMock(); v != 3 {
+		t.Errorf("NextMock() after the error got (%v) want (3)", v)
+	}
+
+	d.SetMockSequence(nil, MockLoop)
+	if _, ok, _ := d.NextMock(); ok {
+		t.Error("NextMock() after the sequence removed got ok")
+	}
+}
+
+func TestMockSeed(t *testing.T) {
+	read := func(r *MockRand) []float64 {
+		var vals []float64
+		for range 5 {
+			vals = append(vals, r.Float64())
+		}
+		return vals
+	}
+
+	a, b, c := NewDevice("a", "mqtt"), NewDevice("b", "mqtt"), NewDevice("c", "mqtt")
+	a.SetMockSeed(42)
+	b.SetMockSeed(42)
+	c.SetMockSeed(43)
+	want := read(a.MockRand())
+	if got := read(b.MockRand()); !slices.Equal(got, want) {
+		t.Errorf("same seed got (%v) want (%v)", got, want)
+	}
+	if got := read(c.MockRand()); slices.Equal(got, want) {
+		t.Errorf("another seed got the same (%v)", got)
+	}
+
+	// seeded again it starts over, whatever the others read
+	a.SetMockSeed(42)
+	read(c.MockRand())
+	if got := read(a.MockRand()); !slices.Equal(got, want) {
+		t.Errorf("seeded again got (%v) want (%v)", got, want)
+	}
+
+	// the default seeds of two devices differ, the same name's not
+	seed := DefaultMockSeed()
+	if err := SetDefaultMockSeed(seed + 1); !errors.Is(err, ErrMockSeedSet) {
+		t.Errorf("SetDefaultMockSeed() once used error got (%v) want (%v)", err, ErrMockSeedSet)
+	}
+	if DefaultMockSeed() != seed {
+		t.Error("default seed changed")
+	}
+	if MockSeedOf("a") == MockSeedOf("b") || MockSeedOf("a") != MockSeedOf("a") {
+		t.Error("MockSeedOf() of the names not their own")
+	}
+	d, e := NewDevice("d", "mqtt"), NewDevice("d", "mqtt")
+	if got, want := read(d.MockRand()), read(e.MockRand()); !slices.Equal(got, want) {
+		t.Errorf("default seed of the same name got (%v) want (%v)", got, want)
+	}
+}
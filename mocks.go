@@ -0,0 +1,194 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockReport is what is faked in the station, see Mocks
+type MockReport struct {
+	Mode    bool         `json:"mode"`           // IsMock, the reads of every device
+	Seed    int64        `json:"seed,omitempty"` // the default mock seed, once used
+	Devices []MockStatus `json:"devices"`
+}
+
+// MockStatus is what is faked of a device, the mocks configured on it
+type MockStatus struct {
+	Device   string         `json:"device"`
+	Profile  string         `json:"profile,omitempty"`  // applied with MockProfile
+	Source   string         `json:"source,omitempty"`   // "sequence" or the type of its MockSource
+	Position int            `json:"position,omitempty"` // the values read of the source
+	Length   int            `json:"length,omitempty"`   // of the source, when it is known
+	Seed     int64          `json:"seed,omitempty"`     // set with SetMockSeed, it fakes nothing alone
+	Injected map[string]any `json:"injected,omitempty"` // like the args of the mock command
+	Faults   *FaultArgs     `json:"faults,omitempty"`   // like the args of the fault command
+	Latency  *MockLatency   `json:"latency,omitempty"`
+}
+
+// MockLatency is the delay of the mock reads of a device in its
+// MockStatus
+type MockLatency struct {
+	Mean   string  `json:"mean"`
+	Spread string  `json:"spread,omitempty"`
+	Stall  string  `json:"stall,omitempty"`
+	StallP float64 `json:"stall_p,omitempty"`
+}
+
+// positioner is a MockSource telling how far it was read, and out of
+// how many values when it knows
+type positioner interface {
+	mockPosition() (pos, length int)
+}
+
+// the devices mocks were configured on, until they are torn down
+var mocked = struct {
+	devices map[*Device]struct{}
+	mu      sync.Mutex
+}{devices: make(map[*Device]struct{})}
+
+// trackMock adds d to the devices Mocks reports, its package calls it
+// once it configured a mock and released the lock of d
+func trackMock(d *Device) {
+	mocked.mu.Lock()
+	defer mocked.mu.Unlock()
+	mocked.devices[d] = struct{}{}
+}
+
+// Mocks returns what is faked in the station now, the mock mode and
+// the mocks configured on every device: its profile, the sequence or
+// the source of its values and how far it was read, its seed, the
+// values injected, the faults and the latency. A device whose mocks were all torn down
+// is not in it any more.
+func Mocks() MockReport {
+	r := MockReport{Mode: IsMock(), Devices: []MockStatus{}}
+	mockSeed.mu.Lock()
+	if mockSeed.fixed {
+		r.Seed = mockSeed.seed
+	}
+	mockSeed.mu.Unlock()
+
+	mocked.mu.Lock()
+	for d := range mocked.devices {
+		if st, ok := d.MockStatus(); ok {
+			r.Devices = append(r.Devices, st)
+		} else {
+			delete(mocked.devices, d)
+		}
+	}
+	mocked.mu.Unlock()
+	slices.SortFunc(r.Devices, func(a, b MockStatus) int {
+		return strings.Compare(a.Device, b.Device)
+	})
+	return r
+}
+
+// IsAnythingMocked reports if the station is in mock mode or a device
+// has a mock configured, its readings not all of the real sensors
+func IsAnythingMocked() bool {
+	return Mocks().active()
+}
+
+func (r MockReport) active() bool {
+	return r.Mode || len(r.Devices) > 0
+}
+
+// MockStatus returns the mocks configured on the device, ok when it
+// has any
+func (d *Device) MockStatus() (st MockStatus, ok bool) {
+	now := d.Now()
+	d.mu.RLock()
+	seq, src, seeded, lat := d.mock, d.source, d.seeded, d.latency
+	st = MockStatus{Device: d.Name, Profile: d.profile}
+	if seeded && d.rand != nil {
+		st.Seed = d.rand.Seed()
+	}
+	for field, in := range d.injected {
+		if st.Injected == nil {
+			st.Injected = make(map[string]any, len(d.injected))
+		}
+		if in.set {
+			st.Injected[field] = in.value
+		} else {
+			st.Injected[field] = map[string]float64{"delta": in.offset}
+		}
+	}
+	if seq != nil {
+		st.Source, st.Position, st.Length = "sequence", seq.next, len(seq.values)
+	}
+	if d.faults != nil {
+		st.Faults = d.faults.args(now)
+	}
+	d.mu.RUnlock()
+
+	if seq == nil && src != nil {
+		st.Source = strings.TrimPrefix(fmt.Sprintf("%T", src), "*")
+		if p, ok := src.(positioner); ok {
+			st.Position, st.Length = p.mockPosition()
+		}
+	}
+	if lat != nil {
+		st.Latency = lat.status()
+	}
+	ok = st.Profile != "" || st.Source != "" || st.Injected != nil || st.Faults != nil || st.Latency != nil
+	return st, ok
+}
+
+// args returns the faults as the args of a fault command making them,
+// the time left of a FailFor at now
+func (f *faults) args(now time.Time) *FaultArgs {
+	a := &FaultArgs{Every: f.every, Open: f.open, Err: f.err.Error()}
+	if left := f.until.Sub(now); left > 0 {
+		a.For = left.Round(time.Millisecond).String()
+	}
+	// the name of the error itself before one it wraps, a NAK
+	// wrapping ErrInjected is "nak"
+	var same, wrapped []string
+	faultErrs.mu.RLock()
+	for name, err := range faultErrs.errs {
+		switch {
+		case errors.Is(f.err, err) && errors.Is(err, f.err):
+			same = append(same, name)
+		case errors.Is(f.err, err):
+			wrapped = append(wrapped, name)
+		}
+	}
+	faultErrs.mu.RUnlock()
+	if len(same) == 0 {
+		same = wrapped
+	}
+	if len(same) > 0 {
+		a.Err = slices.Min(same)
+	}
+	return a
+}
+
+func (l *latency) status() *MockLatency {
+	s := &MockLatency{Mean: l.mean.String(), StallP: l.stallP}
+	if l.jitter != JitterNone {
+		s.Spread = l.spread.String()
+	}
+	if l.stallP > 0 {
+		s.Stall = l.stall.String()
+	}
+	return s
+}
+
+// warnMocks warns the mocks are active in a station that is not a
+// test, its readings not to be taken for the ones of its sensors
+func warnMocks(msg string) {
+	r := Mocks()
+	if testing.Testing() || !r.active() {
+		return
+	}
+	names := make([]string, len(r.Devices))
+	for i, st := range r.Devices {
+		names[i] = st.Device
+	}
+	slog.Warn(msg, "mode", r.Mode, "seed", r.Seed, "devices", names)
+}
@@ -0,0 +1,108 @@
+package device
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockOf returns the MockStatus of the device named name in r
+func mockOf(r MockReport, name string) (MockStatus, bool) {
+	for _, st := range r.Devices {
+		if st.Device == name {
+			return st, true
+		}
+	}
+	return MockStatus{}, false
+}
+
+func TestMocks(t *testing.T) {
+	defer Mock(IsMock())
+	Mock(false)
+	pump := NewDevice("mocks-pump", "mqtt")
+	tank := NewDevice("mocks-tank", "mqtt")
+	if _, ok := mockOf(Mocks(), pump.Name); ok {
+		t.Fatalf("Mocks() reports (%s) before any mock", pump.Name)
+	}
+
+	// a seed alone fakes nothing, it is reported with the mocks
+	pump.SetMockSeed(7)
+	if _, ok := mockOf(Mocks(), pump.Name); ok {
+		t.Errorf("Mocks() reports (%s) seeded only", pump.Name)
+	}
+	pump.SetMockSequence([]any{1.0, 2.0, 3.0}, MockLoop)
+	pump.NextMock()
+	pump.SetMockFields("flow", "level")
+	pump.InjectMock("flow", 2.5)
+	pump.InjectMockDelta("level", -1)
+	pump.InjectFaults(FailEvery(3), FailWith(ErrInjected), FailOpen(1))
+	pump.SetLatency(LatencyDelay(20*time.Millisecond), LatencyStall(0.5, time.Second))
+
+	replay, err := NewReplay(strings.NewReader(`{"device":"mocks-tank","data":1}` + "\n" + `{"device":"mocks-tank","data":2}`))
+	if err != nil {
+		t.Fatalf("NewReplay() error (%v)", err)
+	}
+	tank.SetMockSource(replay)
+	tank.NextMock()
+
+	r := Mocks()
+	if r.Mode || !IsAnythingMocked() {
+		t.Errorf("Mocks() mode got (%v) IsAnythingMocked() (%v) want (false true)", r.Mode, IsAnythingMocked())
+	}
+	got, _ := mockOf(r, pump.Name)
+	want := MockStatus{
+		Device: pump.Name, Source: "sequence", Position: 1, Length: 3, Seed: 7,
+		Injected: map[string]any{"flow": 2.5, "level": map[string]float64{"delta": -1}},
+		Faults:   &FaultArgs{Every: 3, Err: "injected", Open: 1},
+		Latency:  &MockLatency{Mean: "20ms", Stall: "1s", StallP: 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Mocks() pump got (%+v) want (%+v)", got, want)
+	}
+	got, _ = mockOf(r, tank.Name)
+	if want := (MockStatus{Device: tank.Name, Source: "device.Replay", Position: 1, Length: 2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Mocks() tank got (%+v) want (%+v)", got, want)
+	}
+
+	// the manager and the station report them, labeled
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	doc, _ := json.Marshal(dm.Status())
+	if !strings.Contains(string(doc), `"mocks":{"mode":false`) || !strings.Contains(string(doc), `"source":"device.Replay"`) {
+		t.Errorf("Status() got (%s) want the mocks", doc)
+	}
+	if info := NewBeacon(dm).Info(); info.Mocks == nil || len(info.Mocks.Devices) < 2 {
+		t.Errorf("Info() mocks got (%+v) want the pump and the tank", info.Mocks)
+	}
+
+	// torn down one at a time, the device goes once none is left
+	pump.SetMockSequence(nil, MockOnce)
+	pump.RevertMock("flow")
+	pump.InjectFaults()
+	got, _ = mockOf(Mocks(), pump.Name)
+	want = MockStatus{
+		Device: pump.Name, Seed: 7,
+		Injected: map[string]any{"level": map[string]float64{"delta": -1}},
+		Latency:  &MockLatency{Mean: "20ms", Stall: "1s", StallP: 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Mocks() pump torn down got (%+v) want (%+v)", got, want)
+	}
+	pump.RevertMock()
+	pump.SetLatency()
+	tank.SetMockSource(nil)
+	r = Mocks()
+	if _, ok := mockOf(r, pump.Name); ok {
+		t.Errorf("Mocks() reports (%s) torn down", pump.Name)
+	}
+	if _, ok := mockOf(r, tank.Name); ok {
+		t.Errorf("Mocks() reports (%s) torn down", tank.Name)
+	}
+
+	Mock(true)
+	if r := Mocks(); !r.Mode || !IsAnythingMocked() {
+		t.Errorf("Mocks() in mock mode got (%+v)", r)
+	}
+}
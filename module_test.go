@@ -0,0 +1,43 @@
+package device
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestModuleBuilds builds every package of the module together, the
+// examples and the aliases of the otto paths under device/ with them,
+// and type checks their tests. A package importing the otto module
+// again, the module the devices were split from, fails it.
+func TestModuleBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the whole module")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool")
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command(gotool, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("go %s error (%v)\n%s", strings.Join(args, " "), err, out)
+		}
+		return string(out)
+	}
+	run("build", "./...")
+	// vet with a single analyzer, for the tests to be type checked, the
+	// hardware ones too, without failing on what the others report
+	run("vet", "-tags", "hardware", "-atomic", "./...")
+
+	imports := run("list", "-test", "-f", "{{.ImportPath}}: {{join .Imports \" \"}}", "./...")
+	for _, line := range strings.Split(imports, "\n") {
+		pkg, deps, _ := strings.Cut(line, ": ")
+		for _, dep := range strings.Fields(deps) {
+			if dep == "github.com/rustyeddy/otto" || strings.HasPrefix(dep, "github.com/rustyeddy/otto/") {
+				t.Errorf("%s imports (%s) of the otto module, import github.com/rustyeddy/otto-devices", pkg, dep)
+			}
+		}
+	}
+}
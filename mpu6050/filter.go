@@ -0,0 +1,61 @@
+package mpu6050
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultAlpha trusts the gyro for short term changes, at a 10ms
+// sample interval the accelerometer corrects drift over about half a
+// second
+const DefaultAlpha = 0.98
+
+// Filter is a complementary filter for roll and pitch. The gyro rates
+// are integrated for fast, smooth changes and blended with the angles
+// of the gravity vector, which are noisy but do not drift.
+type Filter struct {
+	Alpha float64
+
+	Roll, Pitch float64
+	started     bool
+}
+
+// Update blends a sample taken dt after the previous one into the
+// angles and returns roll and pitch in degrees. The first sample
+// starts from the accelerometer angles.
+func (f *Filter) Update(s *Sample, dt time.Duration) (roll, pitch float64) {
+	ar, ap := AccelAngles(s.Accel)
+	if !f.started {
+		f.Roll, f.Pitch, f.started = ar, ap, true
+		return f.Roll, f.Pitch
+	}
+	sec := dt.Seconds()
+	f.Roll = f.Alpha*(f.Roll+s.Gyro.X*sec) + (1-f.Alpha)*ar
+	f.Pitch = f.Alpha*(f.Pitch+s.Gyro.Y*sec) + (1-f.Alpha)*ap
+	return f.Roll, f.Pitch
+}
+
+// Reset starts the filter over from the next sample
+func (f *Filter) Reset() {
+	f.Roll, f.Pitch, f.started = 0, 0, false
+}
+
+// AccelAngles returns the roll and pitch in degrees of the gravity
+// vector, they only hold while the sensor is not accelerating
+func AccelAngles(a Vector) (roll, pitch float64) {
+	roll = math.Atan2(a.Y, a.Z)
+	pitch = math.Atan2(-a.X, math.Hypot(a.Y, a.Z))
+	return roll * 180 / math.Pi, pitch * 180 / math.Pi
+}
+
+// Magnitude returns the length of the vector, 1 for a sensor at rest
+func (v Vector) Magnitude() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// Moved reports whether the acceleration magnitude differs from 1g by
+// more than threshold, a threshold of 0 never reports motion
+func Moved(a Vector, threshold float64) (bool, float64) {
+	mag := a.Magnitude()
+	return threshold > 0 && math.Abs(mag-1) > threshold, mag
+}
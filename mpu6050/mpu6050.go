@@ -0,0 +1,396 @@
+// Package mpu6050 provides a driver for the InvenSense MPU6050 six axis
+// accelerometer and gyroscope. Besides the scaled readings it can
+// track roll and pitch with a complementary filter and publish an
+// event when the sensor is bumped.
+package mpu6050
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// AddressLow is the address with AD0 low, AddressHigh with it high
+	AddressLow  = 0x68
+	AddressHigh = 0x69
+)
+
+// registers, see the register map document
+const (
+	regSmplrtDiv   = 0x19
+	regConfig      = 0x1A
+	regGyroConfig  = 0x1B
+	regAccelConfig = 0x1C
+	regAccelXOutH  = 0x3B
+	regPwrMgmt1    = 0x6B
+	regWhoAmI      = 0x75
+
+	whoAmI   = 0x68
+	sleepBit = 0x40
+	clkPLLX  = 0x01 // the x gyro clock is more stable than the internal oscillator
+)
+
+// AccelRange is the accelerometer full scale, AFS_SEL
+type AccelRange uint8
+
+const (
+	Accel2G AccelRange = iota
+	Accel4G
+	Accel8G
+	Accel16G
+)
+
+// LSBPerG returns the counts per g of the range
+func (r AccelRange) LSBPerG() float64 {
+	return 16384 / float64(int(1)<<r)
+}
+
+// GyroRange is the gyroscope full scale, FS_SEL
+type GyroRange uint8
+
+const (
+	Gyro250 GyroRange = iota
+	Gyro500
+	Gyro1000
+	Gyro2000
+)
+
+// LSBPerDPS returns the counts per degree per second of the range
+func (r GyroRange) LSBPerDPS() float64 {
+	return 131 / float64(int(1)<<r)
+}
+
+// DLPF is the digital low pass filter setting, DLPF_CFG. The
+// accelerometer bandwidth goes from 260Hz (DLPF260Hz) down to 5Hz.
+type DLPF uint8
+
+const (
+	DLPF260Hz DLPF = iota
+	DLPF184Hz
+	DLPF94Hz
+	DLPF44Hz
+	DLPF21Hz
+	DLPF10Hz
+	DLPF5Hz
+)
+
+// Config holds the ranges, the low pass filter and the sample rate
+// divider, the sample rate is 1kHz / (1 + SampleRateDiv) with the
+// DLPF on
+type Config struct {
+	Accel         AccelRange
+	Gyro          GyroRange
+	DLPF          DLPF
+	SampleRateDiv uint8
+}
+
+// DefaultConfig returns ±2g, ±250°/s, a 44Hz low pass filter and a
+// 200Hz sample rate
+func DefaultConfig() Config {
+	return Config{
+		Accel:         Accel2G,
+		Gyro:          Gyro250,
+		DLPF:          DLPF44Hz,
+		SampleRateDiv: 4,
+	}
+}
+
+var (
+	ErrNotMPU6050 = errors.New("device is not an MPU6050")
+	ErrConfig     = errors.New("invalid mpu6050 configuration")
+	ErrReadFailed = errors.New("failed to read from MPU6050")
+)
+
+// Vector is a reading of the three axes
+type Vector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Sample is a single reading, acceleration in g, rotation in °/s and
+// the die temperature in C. Roll and Pitch in degrees are filled in
+// when Orientation is on.
+type Sample struct {
+	Accel       Vector  `json:"accel"`
+	Gyro        Vector  `json:"gyro"`
+	Temperature float64 `json:"temperature"`
+	Roll        float64 `json:"roll,omitempty"`
+	Pitch       float64 `json:"pitch,omitempty"`
+}
+
+// MotionEvent is published when the acceleration magnitude leaves
+// 1g by more than the MotionThreshold
+type MotionEvent struct {
+	Event     string    `json:"event"`
+	Magnitude float64   `json:"magnitude"`
+	Time      time.Time `json:"time"`
+}
+
+// MPU6050 is an accelerometer and gyroscope on an I2C bus
+type MPU6050 struct {
+	*device.Device
+
+	// Orientation tracks roll and pitch while Run is running
+	Orientation bool
+
+	// MotionThreshold is the deviation from 1g in g that publishes a
+	// MotionEvent while Run is running, 0 turns detection off.
+	// MotionHoldoff is the quiet time after an event.
+	MotionThreshold float64
+	MotionHoldoff   time.Duration
+
+	// SampleInterval is how often Run samples the sensor to track
+	// orientation and motion, the device period only sets how often
+	// a sample is published
+	SampleInterval time.Duration
+
+	Filter Filter
+
+	bus        string
+	addr       int
+	dev        *drivers.I2CDevice
+	cfg        Config
+	last       *Sample
+	lastMotion time.Time
+	mock       []Sample
+	mu         sync.Mutex
+}
+
+// New creates an MPU6050 at the given bus and address, the sensor is
+// not touched until Init
+func New(name, bus string, addr int) *MPU6050 {
+	return &MPU6050{
+		Device:         device.NewDevice(name, "mqtt"),
+		MotionHoldoff:  time.Second,
+		SampleInterval: 10 * time.Millisecond,
+		Filter:         Filter{Alpha: DefaultAlpha},
+		bus:            bus,
+		addr:           addr,
+		cfg:            DefaultConfig(),
+	}
+}
+
+// Init wakes the sensor with the default configuration
+func (m *MPU6050) Init() error {
+	return m.InitWith(DefaultConfig())
+}
+
+// InitWith checks WHO_AM_I, wakes the sensor from sleep and writes
+// the configuration
+func (m *MPU6050) InitWith(cfg Config) error {
+	if cfg.Accel > Accel16G || cfg.Gyro > Gyro2000 || cfg.DLPF > DLPF5Hz {
+		return fmt.Errorf("%w: %+v", ErrConfig, cfg)
+	}
+	if device.IsMock() {
+		m.cfg = cfg
+		return nil
+	}
+
+	dev, err := drivers.NewI2CDevice(m.bus, m.addr)
+	if err != nil {
+		return err
+	}
+	id, err := dev.ReadReg8(regWhoAmI)
+	if err != nil {
+		return err
+	}
+	// WHO_AM_I holds the upper 6 bits of the address, ignoring AD0
+	if id&0x7E != whoAmI {
+		return fmt.Errorf("%w: who am i %#02x", ErrNotMPU6050, id)
+	}
+
+	for _, w := range []struct {
+		reg byte
+		val byte
+	}{
+		{regPwrMgmt1, clkPLLX}, // clears the sleep bit
+		{regSmplrtDiv, cfg.SampleRateDiv},
+		{regConfig, byte(cfg.DLPF)},
+		{regGyroConfig, byte(cfg.Gyro) << 3},
+		{regAccelConfig, byte(cfg.Accel) << 3},
+	} {
+		if err := dev.WriteReg8(w.reg, w.val); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dev = dev
+	m.cfg = cfg
+	return nil
+}
+
+// Name returns the name of the device
+func (m *MPU6050) Name() string {
+	return m.Device.Name
+}
+
+// Read returns a scaled sample
+func (m *MPU6050) Read() (*Sample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.read()
+}
+
+func (m *MPU6050) read() (*Sample, error) {
+	if device.IsMock() {
+		return m.mockSample(), nil
+	}
+	if m.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+	// one burst so all axes come from the same sample
+	buf, err := m.dev.ReadBlock(regAccelXOutH, 14)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	s := scale(buf, m.cfg)
+	return &s, nil
+}
+
+// scale converts the 14 byte burst of ACCEL_XOUT_H to GYRO_ZOUT_L
+func scale(buf []byte, cfg Config) Sample {
+	raw := func(i int) float64 {
+		return float64(int16(uint16(buf[i])<<8 | uint16(buf[i+1])))
+	}
+	a, g := cfg.Accel.LSBPerG(), cfg.Gyro.LSBPerDPS()
+	return Sample{
+		Accel:       Vector{raw(0) / a, raw(2) / a, raw(4) / a},
+		Temperature: raw(6)/340 + 36.53,
+		Gyro:        Vector{raw(8) / g, raw(10) / g, raw(12) / g},
+	}
+}
+
+// ReadPub publishes a sample, the latest tracked one while Run is
+// tracking orientation or motion
+func (m *MPU6050) ReadPub() error {
+	m.mu.Lock()
+	s := m.last
+	m.mu.Unlock()
+	if s == nil {
+		var err error
+		if s, err = m.Read(); err != nil {
+			return err
+		}
+	}
+
+	j, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	m.PubData(j)
+	return nil
+}
+
+// Run publishes a sample every period until ctx is canceled. With
+// Orientation or a MotionThreshold the sensor is sampled every
+// SampleInterval in between.
+func (m *MPU6050) Run(ctx context.Context, period time.Duration) error {
+	if m.Orientation || m.MotionThreshold > 0 {
+		go m.track(ctx)
+	}
+	err := m.TimerLoop(ctx, period, m.ReadPub)
+	slog.Debug("mpu6050 stopped", "device", m.Device.Name, "error", err)
+	return err
+}
+
+// track samples the sensor until ctx is canceled
+func (m *MPU6050) track(ctx context.Context) {
+	ticker := time.NewTicker(m.SampleInterval)
+	defer ticker.Stop()
+
+	prev := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			m.last = nil
+			m.mu.Unlock()
+			return
+		case now := <-ticker.C:
+			if err := m.step(now, now.Sub(prev)); err != nil {
+				slog.Error("mpu6050 sample failed", "device", m.Device.Name, "error", err)
+			}
+			prev = now
+		}
+	}
+}
+
+// step takes one sample, updates the orientation and publishes a
+// MotionEvent if the sensor moved
+func (m *MPU6050) step(now time.Time, dt time.Duration) error {
+	m.mu.Lock()
+	s, err := m.read()
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if m.Orientation {
+		s.Roll, s.Pitch = m.Filter.Update(s, dt)
+	}
+	m.last = s
+
+	var evt *MotionEvent
+	if moved, mag := Moved(s.Accel, m.MotionThreshold); moved && now.Sub(m.lastMotion) >= m.MotionHoldoff {
+		m.lastMotion = now
+		evt = &MotionEvent{Event: "motion", Magnitude: mag, Time: now}
+	}
+	m.mu.Unlock()
+
+	if evt == nil {
+		return nil
+	}
+	j, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	m.PubData(j)
+	return nil
+}
+
+// Close puts the sensor to sleep and closes the i2c device
+func (m *MPU6050) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dev == nil {
+		return nil
+	}
+	m.dev.WriteReg8(regPwrMgmt1, sleepBit|clkPLLX)
+	err := m.dev.Close()
+	m.dev = nil
+	return err
+}
+
+// MockSamples scripts the samples returned in mock mode, afterwards
+// the sensor lies still and level
+func (m *MPU6050) MockSamples(s ...Sample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mock = append(m.mock, s...)
+}
+
+func (m *MPU6050) mockSample() *Sample {
+	if len(m.mock) > 0 {
+		s := m.mock[0]
+		m.mock = m.mock[1:]
+		return &s
+	}
+	noise := func(n float64) float64 { return (m.MockRand().Float64()*2 - 1) * n }
+	return &Sample{
+		Accel:       Vector{noise(0.01), noise(0.01), 1 + noise(0.01)},
+		Gyro:        Vector{noise(0.5), noise(0.5), noise(0.5)},
+		Temperature: 25 + noise(0.2),
+	}
+}
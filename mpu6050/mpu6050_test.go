@@ -0,0 +1,227 @@
+package mpu6050
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+// burst builds a 14 byte data burst from raw counts
+func burst(ax, ay, az, temp, gx, gy, gz int16) []byte {
+	var buf []byte
+	for _, v := range []int16{ax, ay, az, temp, gx, gy, gz} {
+		buf = append(buf, byte(uint16(v)>>8), byte(v))
+	}
+	return buf
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		buf   []byte
+		accel Vector
+		gyro  Vector
+		temp  float64
+	}{
+		{
+			"level at rest", DefaultConfig(),
+			burst(0, 0, 16384, -3940, 0, 0, 0),
+			Vector{0, 0, 1}, Vector{0, 0, 0}, 24.94,
+		},
+		{
+			"2g 250", Config{Accel: Accel2G, Gyro: Gyro250},
+			burst(-8192, 4096, 16384, 0, 131, -262, 13100),
+			Vector{-0.5, 0.25, 1}, Vector{1, -2, 100}, 36.53,
+		},
+		{
+			"16g 2000", Config{Accel: Accel16G, Gyro: Gyro2000},
+			burst(2048, -4096, 32767, 340, 131, 1310, -32768),
+			Vector{1, -2, 15.9995}, Vector{8, 80, -2001.1}, 37.53,
+		},
+		{
+			"8g 1000", Config{Accel: Accel8G, Gyro: Gyro1000},
+			burst(4096, 0, -4096, 0, 655, 0, 0),
+			Vector{1, 0, -1}, Vector{20, 0, 0}, 36.53,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scale(tt.buf, tt.cfg)
+			if !near(s.Accel.X, tt.accel.X, 1e-3) || !near(s.Accel.Y, tt.accel.Y, 1e-3) || !near(s.Accel.Z, tt.accel.Z, 1e-3) {
+				t.Errorf("accel got (%+v) want (%+v)", s.Accel, tt.accel)
+			}
+			if !near(s.Gyro.X, tt.gyro.X, 0.01) || !near(s.Gyro.Y, tt.gyro.Y, 0.01) || !near(s.Gyro.Z, tt.gyro.Z, 0.01) {
+				t.Errorf("gyro got (%+v) want (%+v)", s.Gyro, tt.gyro)
+			}
+			if !near(s.Temperature, tt.temp, 0.01) {
+				t.Errorf("temperature got (%.2f) want (%.2f)", s.Temperature, tt.temp)
+			}
+		})
+	}
+}
+
+func TestAccelAngles(t *testing.T) {
+	s45 := math.Sqrt2 / 2
+	tests := []struct {
+		name        string
+		a           Vector
+		roll, pitch float64
+	}{
+		{"level", Vector{0, 0, 1}, 0, 0},
+		{"rolled 45", Vector{0, s45, s45}, 45, 0},
+		{"rolled -90", Vector{0, -1, 0}, -90, 0},
+		{"pitched 30", Vector{-0.5, 0, math.Sqrt(3) / 2}, 0, 30},
+		{"pitched -45", Vector{s45, 0, s45}, 0, -45},
+	}
+	for _, tt := range tests {
+		roll, pitch := AccelAngles(tt.a)
+		if !near(roll, tt.roll, 1e-9) || !near(pitch, tt.pitch, 1e-9) {
+			t.Errorf("%s got (%.2f %.2f) want (%.2f %.2f)", tt.name, roll, pitch, tt.roll, tt.pitch)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	const dt = 10 * time.Millisecond
+	s45 := math.Sqrt2 / 2
+
+	// the first sample starts from the accelerometer
+	f := Filter{Alpha: DefaultAlpha}
+	if roll, _ := f.Update(&Sample{Accel: Vector{0, s45, s45}}, dt); !near(roll, 45, 1e-9) {
+		t.Errorf("first roll got (%.2f) want (45)", roll)
+	}
+
+	// a steady 90°/s roll is followed by the gyro while the
+	// accelerometer claims the sensor is level
+	f = Filter{Alpha: DefaultAlpha}
+	f.Update(&Sample{Accel: Vector{0, 0, 1}}, dt)
+	var roll float64
+	for i := 0; i < 10; i++ {
+		roll, _ = f.Update(&Sample{Accel: Vector{0, 0, 1}, Gyro: Vector{X: 90}}, dt)
+	}
+	if roll < 7 || roll > 9 {
+		t.Errorf("roll after 100ms at 90°/s got (%.2f) want close to 9", roll)
+	}
+
+	// a gyro bias drifts a plain integration, the accelerometer holds
+	// the filter near the true angle
+	f = Filter{Alpha: DefaultAlpha}
+	var pitch float64
+	for i := 0; i < 1000; i++ {
+		_, pitch = f.Update(&Sample{Accel: Vector{-0.5, 0, math.Sqrt(3) / 2}, Gyro: Vector{Y: 2}}, dt)
+	}
+	// the bias integrates 10s * 2°/s = 20° without the accelerometer,
+	// the filter settles at 30 + alpha*bias*dt/(1-alpha)
+	if !near(pitch, 30+DefaultAlpha*2*0.01/(1-DefaultAlpha), 0.01) {
+		t.Errorf("pitch with gyro bias got (%.2f) want (30.98)", pitch)
+	}
+
+	f.Reset()
+	if _, pitch := f.Update(&Sample{Accel: Vector{0, 0, 1}}, dt); pitch != 0 {
+		t.Errorf("pitch after Reset got (%.2f) want (0)", pitch)
+	}
+}
+
+func TestMoved(t *testing.T) {
+	tests := []struct {
+		a         Vector
+		threshold float64
+		moved     bool
+	}{
+		{Vector{0, 0, 1}, 0.1, false},
+		{Vector{0, 0.3, 0.95}, 0.1, false},
+		{Vector{0.5, 0, 1.2}, 0.1, true},
+		{Vector{0, 0, 0.5}, 0.1, true}, // free fall reads below 1g
+		{Vector{0, 0, 3}, 0, false},
+	}
+	for _, tt := range tests {
+		if moved, mag := Moved(tt.a, tt.threshold); moved != tt.moved {
+			t.Errorf("Moved(%+v, %.1f) got (%t, %.2f) want (%t)", tt.a, tt.threshold, moved, mag, tt.moved)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	m := New("imu-test", TestI2CBus, AddressLow)
+	m.Orientation = true
+	m.MotionThreshold = 0.2
+	m.MockSamples(
+		Sample{Accel: Vector{0, 0, 1}},
+		Sample{Accel: Vector{0, 0, 1.5}},
+		Sample{Accel: Vector{0, 0, 1.6}},
+		Sample{Accel: Vector{0, 0, 1.5}},
+	)
+
+	start := time.Now()
+	for i, at := range []time.Duration{0, 10, 20, 1500} {
+		if err := m.step(start.Add(at*time.Millisecond), 10*time.Millisecond); err != nil {
+			t.Fatalf("step(%d) error = %v", i, err)
+		}
+	}
+	// the second sample triggers, the third is held off, the fourth
+	// is past the holdoff
+	if want := start.Add(1500 * time.Millisecond); !m.lastMotion.Equal(want) {
+		t.Errorf("last motion got (%v) want (%v)", m.lastMotion.Sub(start), want.Sub(start))
+	}
+	if m.last == nil || m.last.Accel.Z != 1.5 {
+		t.Errorf("last sample got (%+v) want the fourth", m.last)
+	}
+}
+
+func TestInitRead(t *testing.T) {
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	fake.Set(regPwrMgmt1, sleepBit) // asleep after power on
+	fake.Set(regWhoAmI, whoAmI)
+	fake.Set(regAccelXOutH, burst(-8192, 0, 8192, 0, 655, 0, -655)...)
+
+	m := New("imu-test", TestI2CBus, AddressLow)
+	cfg := Config{Accel: Accel4G, Gyro: Gyro500, DLPF: DLPF21Hz, SampleRateDiv: 9}
+	if err := m.InitWith(cfg); err != nil {
+		t.Fatalf("InitWith() error = %v", err)
+	}
+	if got := fake.Get(regPwrMgmt1, 1)[0]; got != clkPLLX {
+		t.Errorf("PWR_MGMT_1 got (%#02x) want (%#02x)", got, clkPLLX)
+	}
+	if got := fake.Get(regSmplrtDiv, 4); got[0] != 9 || got[1] != 4 || got[2] != 0x08 || got[3] != 0x08 {
+		t.Errorf("configuration got (% x) want (09 04 08 08)", got)
+	}
+
+	s, err := m.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if s.Accel.X != -1 || s.Accel.Z != 1 || !near(s.Gyro.X, 10, 0.01) || !near(s.Gyro.Z, -10, 0.01) {
+		t.Errorf("Read() got (%+v) want accel (-1 0 1) gyro (10 0 -10)", s)
+	}
+
+	if err := m.InitWith(Config{DLPF: 7}); !errors.Is(err, ErrConfig) {
+		t.Errorf("InitWith(DLPF 7) error got (%v) want (%v)", err, ErrConfig)
+	}
+
+	fake.Set(regWhoAmI, 0x70)
+	if err := New("mpu9250", TestI2CBus, AddressLow).Init(); !errors.Is(err, ErrNotMPU6050) {
+		t.Errorf("Init() error got (%v) want (%v)", err, ErrNotMPU6050)
+	}
+
+	m.Close()
+	if fake.Get(regPwrMgmt1, 1)[0]&sleepBit == 0 || !fake.Closed() {
+		t.Error("Close() did not put the sensor to sleep")
+	}
+}
@@ -0,0 +1,226 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// DefaultBroker is a broker on the station itself
+	DefaultBroker = "tcp://localhost:1883"
+
+	// mqttTimeout bounds the wait for the broker to take a connect,
+	// a publish or a subscribe
+	mqttTimeout = 5 * time.Second
+)
+
+// MQTT is a Messanger on an MQTT broker. Messages are sent at QoS 0,
+// a reading lost is replaced by the next one, and retained ones at
+// QoS 1, unless PublishQoS is given the level. A publish at QoS 1 not
+// acknowledged by the broker, no PUBACK in time, is ErrNotDelivered.
+// The connection is kept up and the subscriptions taken again after a
+// reconnect.
+//
+// The will of the connection is the station "offline" on its
+// StationTopic, it is "online" after every connect. MQTTWill sets
+// another, a broker keeps one will for a connection.
+//
+// A connection lost is an EventDisconnected and taken again, waiting
+// 1s then twice as long after every attempt up to MQTTReconnect. Every
+// connect is an EventConnected, the subscriptions taken again, their
+// QoS and handler, so the devices take their commands without
+// subscribing again.
+type MQTT struct {
+	Broker string
+
+	client mqtt.Client
+	subs   []subscription
+	births announcer
+	mu     sync.Mutex
+}
+
+// MQTTOption configures the connection of NewMQTT
+type MQTTOption func(*mqtt.ClientOptions)
+
+// MQTTWill sets the will of the connection to payload on topic,
+// retained, rather than the station "offline", like the $state of a
+// Homie device
+func MQTTWill(topic, payload string) MQTTOption {
+	return func(o *mqtt.ClientOptions) {
+		o.SetWill(topic, payload, byte(AtLeastOnce), true)
+	}
+}
+
+// MQTTReconnect sets the longest wait between two attempts to take a
+// connection lost again, 10 minutes by default
+func MQTTReconnect(longest time.Duration) MQTTOption {
+	return func(o *mqtt.ClientOptions) {
+		o.SetMaxReconnectInterval(longest)
+	}
+}
+
+// MQTTConnectRetry has NewMQTT return without the broker, for a
+// station started before it, and connect every interval until it is
+// there. Publishing fails until then, see Buffer.
+func MQTTConnectRetry(interval time.Duration) MQTTOption {
+	return func(o *mqtt.ClientOptions) {
+		o.SetConnectRetry(true)
+		o.SetConnectRetryInterval(interval)
+	}
+}
+
+// mqttClient creates the client of NewMQTT, a var for the tests
+var mqttClient = mqtt.NewClient
+
+// NewMQTT connects to broker, like DefaultBroker, as the client id.
+// Set it with SetMessanger for the "mqtt" devices to publish on it.
+func NewMQTT(broker, id string, opts ...MQTTOption) (*MQTT, error) {
+	m := &MQTT{Broker: broker}
+	will := StationTopic()
+	m.births.add(will)
+	o := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(id).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectTimeout(mqttTimeout).
+		SetWill(will, Offline, byte(AtLeastOnce), true).
+		SetOnConnectHandler(func(mqtt.Client) { m.connected() }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) { m.lost(err) })
+	for _, opt := range opts {
+		opt(o)
+	}
+	m.client = mqttClient(o)
+	t := m.client.Connect()
+	if o.ConnectRetry {
+		return m, nil
+	}
+	if err := wait(t); err != nil {
+		return nil, fmt.Errorf("mqtt connect %s: %w", broker, err)
+	}
+	return m, nil
+}
+
+// Publish sends payload on topic
+func (m *MQTT) Publish(topic string, payload []byte) error {
+	return m.PublishQoS(topic, payload, AtMostOnce, false)
+}
+
+// PublishRetained sends payload on topic for the broker to keep, an
+// empty payload clears the topic. It goes at QoS 1, a retained state
+// lost would stay wrong until the next change.
+func (m *MQTT) PublishRetained(topic string, payload []byte) error {
+	return m.PublishQoS(topic, payload, AtLeastOnce, true)
+}
+
+// PublishQoS sends payload on topic at qos, for the broker to keep
+// when retain is set
+func (m *MQTT) PublishQoS(topic string, payload []byte, qos QoS, retain bool) error {
+	if retain && len(payload) == 0 {
+		m.births.withdraw(topic)
+	}
+	err := wait(m.client.Publish(topic, byte(qos), retain, payload))
+	if errors.Is(err, errTimeout) && qos > AtMostOnce {
+		err = fmt.Errorf("%w: no acknowledgement in %v", ErrNotDelivered, mqttTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("mqtt publish %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe calls cb with the messages on topics matching the filter
+// topic
+func (m *MQTT) Subscribe(topic string, cb func(*Msg)) error {
+	return m.SubscribeQoS(topic, AtMostOnce, cb)
+}
+
+// SubscribeQoS subscribes like Subscribe, the messages are delivered
+// at qos at most
+func (m *MQTT) SubscribeQoS(topic string, qos QoS, cb func(*Msg)) error {
+	s := subscription{filter: topic, qos: qos, cb: cb}
+	m.mu.Lock()
+	m.subs = append(m.subs, s)
+	m.mu.Unlock()
+	return m.subscribe(s)
+}
+
+func (m *MQTT) subscribe(s subscription) error {
+	t := m.client.Subscribe(s.filter, byte(s.qos), func(_ mqtt.Client, pm mqtt.Message) {
+		msg := NewMsg(pm.Topic(), pm.Payload(), "mqtt")
+		msg.Retained = pm.Retained()
+		msg.QoS = QoS(pm.Qos())
+		msg.ID = pm.MessageID()
+		msg.Duplicate = pm.Duplicate()
+		s.cb(msg)
+	})
+	if err := wait(t); err != nil {
+		return fmt.Errorf("mqtt subscribe %s: %w", s.filter, err)
+	}
+	return nil
+}
+
+// connected takes the subscriptions again and announces the station
+// and the devices, an EventConnected
+func (m *MQTT) connected() {
+	m.resubscribe()
+	if err := m.births.announce(m, Online); err != nil {
+		slog.Error("mqtt announce", "broker", m.Broker, "error", err)
+	}
+	emit(EventConnected, "", "", connection{Broker: m.Broker})
+}
+
+// lost is the connection lost, an EventDisconnected
+func (m *MQTT) lost(err error) {
+	slog.Warn("mqtt connection lost", "broker", m.Broker, "error", err)
+	emit(EventDisconnected, "", "", connection{Broker: m.Broker, Error: errString(err)})
+}
+
+// Announce publishes topic "online" and again after every reconnect
+func (m *MQTT) Announce(topic string) error {
+	if !m.births.add(topic) || !m.client.IsConnected() {
+		return nil
+	}
+	return m.PublishQoS(topic, []byte(Online), AtLeastOnce, true)
+}
+
+// resubscribe takes the subscriptions again, a clean session drops
+// them with the connection
+func (m *MQTT) resubscribe() {
+	m.mu.Lock()
+	subs := append([]subscription(nil), m.subs...)
+	m.mu.Unlock()
+	for _, s := range subs {
+		if err := m.subscribe(s); err != nil {
+			slog.Error("mqtt resubscribe", "broker", m.Broker, "error", err)
+		}
+	}
+}
+
+// Close has the station and the devices announced "offline", the
+// broker does not send the will on a disconnect, then disconnects
+// from the broker
+func (m *MQTT) Close() error {
+	var err error
+	if m.client.IsConnected() {
+		err = m.births.announce(m, Offline)
+	}
+	m.client.Disconnect(250)
+	return err
+}
+
+// errTimeout is the broker not answering within mqttTimeout
+var errTimeout = fmt.Errorf("no answer in %v", mqttTimeout)
+
+// wait waits for the broker to take t
+func wait(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return errTimeout
+	}
+	return t.Error()
+}
@@ -0,0 +1,299 @@
+package device
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// token is a finished mqtt.Token
+type token struct {
+	err     error
+	timeout bool
+}
+
+func (t *token) Wait() bool                     { return !t.timeout }
+func (t *token) WaitTimeout(time.Duration) bool { return !t.timeout }
+func (t *token) Error() error                   { return t.err }
+func (t *token) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// message is a received mqtt.Message
+type message struct {
+	mqtt.Message
+	topic     string
+	payload   []byte
+	retained  bool
+	qos       byte
+	id        uint16
+	duplicate bool
+}
+
+func (m *message) Topic() string     { return m.topic }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Retained() bool    { return m.retained }
+func (m *message) Qos() byte         { return m.qos }
+func (m *message) MessageID() uint16 { return m.id }
+func (m *message) Duplicate() bool   { return m.duplicate }
+
+// client is a broker connection, the methods MQTT does not use are
+// left to the nil Client
+type client struct {
+	mqtt.Client
+	published    map[string]string
+	qos          map[string]byte // of the publishes
+	retained     map[string]byte // the qos of the retained publishes
+	handlers     map[string]mqtt.MessageHandler
+	subQoS       map[string]byte
+	subscribes   int
+	err          error
+	timeout      bool
+	disconnected bool
+	opts         *mqtt.ClientOptions // of NewMQTT
+}
+
+func newClient() *client {
+	return &client{
+		published: make(map[string]string),
+		qos:       make(map[string]byte),
+		retained:  make(map[string]byte),
+		handlers:  make(map[string]mqtt.MessageHandler),
+		subQoS:    make(map[string]byte),
+	}
+}
+
+func (c *client) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	if c.err == nil && !c.timeout {
+		c.published[topic] = string(payload.([]byte))
+		c.qos[topic] = qos
+		if retained {
+			c.retained[topic] = qos
+		}
+	}
+	return &token{err: c.err, timeout: c.timeout}
+}
+
+func (c *client) Subscribe(topic string, qos byte, cb mqtt.MessageHandler) mqtt.Token {
+	c.subscribes++
+	if c.err == nil {
+		c.handlers[topic] = cb
+		c.subQoS[topic] = qos
+	}
+	return &token{err: c.err}
+}
+
+func (c *client) IsConnected() bool { return !c.disconnected }
+
+func (c *client) Disconnect(uint) {
+	c.disconnected = true
+}
+
+// Connect takes the connection, with the handlers of NewMQTT
+func (c *client) Connect() mqtt.Token {
+	if c.err == nil {
+		c.disconnected = false
+		if c.opts != nil && c.opts.OnConnect != nil {
+			c.opts.OnConnect(c)
+		}
+	}
+	return &token{err: c.err}
+}
+
+// restart is the broker restarting, the connection and the session
+// with its subscriptions lost, then the client reconnecting
+func (c *client) restart() {
+	c.disconnected = true
+	clear(c.handlers)
+	c.opts.OnConnectionLost(c, errors.New("EOF"))
+	c.Connect()
+}
+
+func TestMQTT(t *testing.T) {
+	c := newClient()
+	m := &MQTT{Broker: "tcp://test:1883", client: c}
+
+	d := NewDevice("bme280", "mqtt", WithMessanger(m), WithEnvelope(false))
+	if err := d.PubData(`{"temperature":"70.10"}`); err != nil {
+		t.Fatalf("PubData() error = %v", err)
+	}
+	if got := c.published["ss/d/station/bme280"]; got != `{"temperature":"70.10"}` {
+		t.Errorf("published got (%q)", got)
+	}
+	d.PubState("ready")
+	if qos, ok := c.retained["ss/s/station/bme280"]; !ok || qos != 1 || len(c.retained) != 1 {
+		t.Errorf("retained got (%v) want the state at qos 1", c.retained)
+	}
+
+	var got *Msg
+	if err := d.Subscribe(ControlTopic("bme280"), func(msg *Msg) { got = msg }); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	c.handlers["ss/c/station/bme280"](nil, &message{topic: "ss/c/station/bme280", payload: []byte("reset"), retained: true})
+	if got == nil || got.String() != "reset" || got.Source != "mqtt" || got.Topic != "ss/c/station/bme280" || !got.Retained {
+		t.Errorf("received got (%+v)", got)
+	}
+
+	// the broker went away and came back with a clean session
+	clear(c.handlers)
+	m.resubscribe()
+	if c.handlers["ss/c/station/bme280"] == nil || c.subscribes != 2 {
+		t.Errorf("resubscribe got (%d subscribes, %v)", c.subscribes, c.handlers)
+	}
+
+	c.err = errors.New("not connected")
+	if err := d.PubData("lost"); !errors.Is(err, c.err) {
+		t.Errorf("PubData() error got (%v) want (%v)", err, c.err)
+	}
+	// taken again once connected
+	if err := m.Subscribe("ss/c/station/relay", func(*Msg) {}); !errors.Is(err, c.err) {
+		t.Errorf("Subscribe() error got (%v) want (%v)", err, c.err)
+	}
+	c.err = nil
+	m.resubscribe()
+	if c.handlers["ss/c/station/relay"] == nil {
+		t.Error("the failed subscription was not taken again")
+	}
+
+	c.timeout = true
+	if err := d.PubData("slow"); err == nil || errors.Is(err, ErrNotDelivered) {
+		t.Errorf("PubData() without an answer error got (%v) want a timeout", err)
+	}
+	m.Close()
+	if !c.disconnected {
+		t.Error("Close() did not disconnect")
+	}
+}
+
+func TestMQTTQoS(t *testing.T) {
+	c := newClient()
+	m := &MQTT{Broker: "tcp://test:1883", client: c}
+	relay := NewDevice("relay", "mqtt", WithMessanger(m), WithQoS(AtLeastOnce))
+	sensor := NewDevice("lux", "mqtt", WithMessanger(m))
+
+	sensor.PubData(120.5)
+	sensor.PubData("too hot", PubQoS(AtLeastOnce)) // an alert
+	relay.PubData("on")
+	if c.qos["ss/d/station/lux"] != 1 || c.qos["ss/d/station/relay"] != 1 {
+		t.Errorf("publish qos got (%v) want 1 for the alert and the relay", c.qos)
+	}
+	sensor.PubData(121.0)
+	if c.qos["ss/d/station/lux"] != 0 {
+		t.Errorf("publish qos got (%d) want the device's 0", c.qos["ss/d/station/lux"])
+	}
+
+	var got []*Msg
+	relay.Subscribe(relay.ControlTopic(), func(msg *Msg) { got = append(got, msg) })
+	if c.subQoS["ss/c/station/relay"] != 1 {
+		t.Errorf("subscribe qos got (%d) want (1)", c.subQoS["ss/c/station/relay"])
+	}
+	c.handlers["ss/c/station/relay"](nil, &message{topic: "ss/c/station/relay", payload: []byte("on"), qos: 1, id: 7, duplicate: true})
+	if len(got) != 1 || got[0].QoS != AtLeastOnce || got[0].ID != 7 || !got[0].Duplicate {
+		t.Errorf("received got (%+v)", got)
+	}
+
+	// no PUBACK
+	c.timeout = true
+	err := relay.PubState("off")
+	if !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("PubState() error got (%v) want (%v)", err, ErrNotDelivered)
+	}
+	if !errors.Is(relay.Error(), ErrNotDelivered) || relay.State != StateError {
+		t.Errorf("device error got (%v, %s)", relay.Error(), relay.State)
+	}
+	if s := relay.PubStats(); s.Published != 1 || s.Failed != 1 {
+		t.Errorf("PubStats() got (%+v) want 1 published and 1 failed", s)
+	}
+	m.Close()
+	if !c.disconnected {
+		t.Error("Close() did not disconnect")
+	}
+}
+
+// useClient has NewMQTT connect with c for the test
+func useClient(t *testing.T, c *client) {
+	old := mqttClient
+	mqttClient = func(o *mqtt.ClientOptions) mqtt.Client {
+		c.opts = o
+		return c
+	}
+	t.Cleanup(func() { mqttClient = old })
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	c := newClient()
+	useClient(t, c)
+	events, stop := SubscribeEvents(8)
+	defer stop()
+
+	m, err := NewMQTT("tcp://test:1883", "station", MQTTReconnect(time.Minute))
+	if err != nil {
+		t.Fatalf("NewMQTT() error = %v", err)
+	}
+	if c.opts.MaxReconnectInterval != time.Minute || !c.opts.AutoReconnect {
+		t.Errorf("reconnect got (%v, %t) want (1m, true)", c.opts.MaxReconnectInterval, c.opts.AutoReconnect)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventConnected || string(ev.Data) != `{"broker":"tcp://test:1883"}` {
+		t.Errorf("event got (%+v) want connected", ev)
+	}
+
+	relay := NewDevice("relay", "mqtt", WithMessanger(m), WithEnvelope(false))
+	rt := NewRouter(relay)
+	rt.Handle("on", func(*Command) error { return relay.PubState(1) })
+	if err := rt.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	relay.Announce()
+
+	// the broker restarts with a clean session, the relay takes its
+	// commands again without subscribing again
+	c.restart()
+	if ev := nextEvent(t, events); ev.Type != EventDisconnected || string(ev.Data) != `{"broker":"tcp://test:1883","error":"EOF"}` {
+		t.Errorf("event got (%+v) want disconnected", ev)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventConnected {
+		t.Errorf("event got (%+v) want connected", ev)
+	}
+	cb := c.handlers[relay.ControlTopic()]
+	if cb == nil || c.subQoS[relay.ControlTopic()] != byte(relay.QoS()) {
+		t.Fatalf("subscriptions after the restart got (%v)", c.handlers)
+	}
+	cb(c, &message{topic: relay.ControlTopic(), payload: []byte("on")})
+	if got := c.published[relay.StateTopic()]; got != "1" {
+		t.Errorf("state after the restart got (%q) want (1)", got)
+	}
+	if c.published[StationTopic()] != Online || c.published[relay.AvailabilityTopic()] != Online {
+		t.Errorf("announced after the restart got (%v)", c.published)
+	}
+	m.Close()
+}
+
+func TestMQTTConnectRetry(t *testing.T) {
+	c := newClient()
+	c.err = errors.New("connection refused")
+	useClient(t, c)
+
+	if _, err := NewMQTT("tcp://test:1883", "station"); !errors.Is(err, c.err) {
+		t.Errorf("NewMQTT() error got (%v) want (%v)", err, c.err)
+	}
+	m, err := NewMQTT("tcp://test:1883", "station", MQTTConnectRetry(time.Second))
+	if err != nil || !c.opts.ConnectRetry || c.opts.ConnectRetryInterval != time.Second {
+		t.Errorf("NewMQTT(retry) got (%v, %t, %v) want no error", err, c.opts.ConnectRetry, c.opts.ConnectRetryInterval)
+	}
+	m.Close()
+}
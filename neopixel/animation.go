@@ -0,0 +1,61 @@
+package neopixel
+
+import "math"
+
+// Animation draws frame number frame of an animation into the pixels,
+// c is the color of the animations that have one
+type Animation func(px []Color, frame int, c Color)
+
+// BreatheFrames is the length of one breath of the breathe animation
+const BreatheFrames = 100
+
+// Animations are the animations Animate and the "animation" command
+// run by name. Add to them before the devices start.
+var Animations = map[string]Animation{
+	"rainbow":       Rainbow,
+	"theater_chase": TheaterChase,
+	"breathe":       Breathe,
+}
+
+// Rainbow spreads the color wheel over the strip and turns it a step
+// every frame
+func Rainbow(px []Color, frame int, _ Color) {
+	for i := range px {
+		px[i] = Wheel(byte(i*256/len(px) + frame))
+	}
+}
+
+// TheaterChase lights every third pixel in c, moving along a pixel
+// every frame
+func TheaterChase(px []Color, frame int, c Color) {
+	for i := range px {
+		px[i] = Color{}
+		if (i+frame)%3 == 0 {
+			px[i] = c
+		}
+	}
+}
+
+// Breathe fades the whole strip in c up and down, once every
+// BreatheFrames
+func Breathe(px []Color, frame int, c Color) {
+	level := (1 - math.Cos(2*math.Pi*float64(frame%BreatheFrames)/BreatheFrames)) / 2
+	dim := func(v byte) byte { return byte(math.Round(float64(v) * level)) }
+	for i := range px {
+		px[i] = Color{dim(c.R), dim(c.G), dim(c.B)}
+	}
+}
+
+// Wheel returns the color at pos around a red, green, blue color wheel
+func Wheel(pos byte) Color {
+	switch {
+	case pos < 85:
+		return Color{255 - pos*3, pos * 3, 0}
+	case pos < 170:
+		pos -= 85
+		return Color{0, 255 - pos*3, pos * 3}
+	default:
+		pos -= 170
+		return Color{pos * 3, 0, 255 - pos*3}
+	}
+}
@@ -0,0 +1,57 @@
+package neopixel
+
+import "math"
+
+const (
+	// SPIFreq is the SPI clock the strip is driven at, three SPI bits
+	// make one 1.25µs WS2812 bit
+	SPIFreq = 2400000
+
+	// ResetBytes of low after the pixels latch the colors, 300µs is
+	// enough for the newer WS2812B that need more than the 50µs of
+	// the datasheet
+	ResetBytes = 90
+)
+
+// encode expands the WS2812 bits of b into 3 SPI bytes, a 0 is sent
+// as 100 and a 1 as 110, most significant bit first
+func encode(dst []byte, b byte) {
+	var v uint32
+	for i := 7; i >= 0; i-- {
+		if b>>i&1 == 1 {
+			v = v<<3 | 0b110
+		} else {
+			v = v<<3 | 0b100
+		}
+	}
+	dst[0], dst[1], dst[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+// frame encodes the pixels in the GRB order of the strip through the
+// table followed by the reset. The buffer is reused when it is big
+// enough.
+func frame(buf []byte, px []Color, table *[256]byte) []byte {
+	n := 9*len(px) + ResetBytes
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	for i, c := range px {
+		p := buf[9*i:]
+		encode(p[0:], table[c.G])
+		encode(p[3:], table[c.R])
+		encode(p[6:], table[c.B])
+	}
+	clear(buf[9*len(px):])
+	return buf
+}
+
+// levels fills the table of what is sent for each color level, the
+// level scaled by brightness and gamma corrected so the steps look
+// even to the eye
+func levels(table *[256]byte, brightness, gamma float64) {
+	for i := range table {
+		v := math.Pow(float64(i)/255*brightness, gamma)
+		table[i] = byte(math.Round(255 * v))
+	}
+}
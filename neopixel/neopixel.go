@@ -0,0 +1,391 @@
+// Package neopixel drives a strip of WS2812 addressable LEDs, the
+// NeoPixels, from the MOSI line of an SPI device.
+//
+// Every bit of a pixel is sent as three SPI bits at SPIFreq so the
+// timing comes from the SPI clock rather than from the scheduler. The
+// pixels are set in a frame buffer and sent with Show. Colors are
+// scaled by the brightness and gamma corrected on the way out, the
+// frame buffer keeps the colors as set.
+//
+// The spidev driver takes at most 4096 bytes a transfer unless its
+// bufsiz module parameter is raised, 9 bytes a pixel and the reset
+// make that about 440 pixels.
+//
+// Animations run in the background a frame at a time until they are
+// canceled, stopped or replaced by another animation or a fill.
+package neopixel
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// DefaultGamma suits most WS2812 strips
+	DefaultGamma = 2.8
+
+	// DefaultSpeed is the frames per second of animations
+	DefaultSpeed = 30
+)
+
+var (
+	ErrPixel     = errors.New("pixel is off the strip")
+	ErrColor     = errors.New("bad color")
+	ErrAnimation = errors.New("no such animation")
+	ErrCommand   = errors.New("unknown command")
+)
+
+// Color is the red, green and blue of a pixel
+type Color struct {
+	R, G, B byte
+}
+
+// ParseColor parses a color in hex, "#ff8000" or "ff8000"
+func ParseColor(s string) (Color, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(b) != 3 {
+		return Color{}, fmt.Errorf("%w: %q", ErrColor, s)
+	}
+	return Color{b[0], b[1], b[2]}, nil
+}
+
+// String returns the color in the hex of ParseColor
+func (c Color) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	Pixels     int     `json:"pixels"`
+	Brightness float64 `json:"brightness"`
+	Animation  string  `json:"animation,omitempty"`
+}
+
+// Strip is a strip of WS2812 pixels
+type Strip struct {
+	*device.Device
+
+	spi        drivers.SPIConn
+	pixels     []Color
+	brightness float64
+	gamma      float64
+	table      [256]byte
+	buf        []byte
+
+	animation string
+	cancel    context.CancelFunc
+	animate   sync.Mutex // held while an animation runs
+
+	wait func(ctx context.Context, d time.Duration) error
+	mu   sync.Mutex
+}
+
+// New creates a strip of n pixels on the SPI device dev, like
+// "/dev/spidev0.0"
+func New(name, dev string, n int) (*Strip, error) {
+	if device.IsMock() {
+		return NewWithSPI(name, &mockSPI{}, n), nil
+	}
+	spi, err := drivers.OpenSPI(dev, 0, SPIFreq)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithSPI(name, spi, n), nil
+}
+
+// NewWithSPI creates a strip of n pixels on spi, it has to be clocked
+// at SPIFreq
+func NewWithSPI(name string, spi drivers.SPIConn, n int) *Strip {
+	s := &Strip{
+		Device:     device.NewDevice(name, "mqtt"),
+		spi:        spi,
+		pixels:     make([]Color, n),
+		brightness: 1,
+		gamma:      DefaultGamma,
+		wait:       wait,
+	}
+	levels(&s.table, s.brightness, s.gamma)
+	return s
+}
+
+// Name returns the name of the device
+func (s *Strip) Name() string {
+	return s.Device.Name
+}
+
+// Len returns the number of pixels
+func (s *Strip) Len() int {
+	return len(s.pixels)
+}
+
+// SetPixel sets pixel i in the frame buffer
+func (s *Strip) SetPixel(i int, r, g, b byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.pixels) {
+		return fmt.Errorf("%w: %d of %d", ErrPixel, i, len(s.pixels))
+	}
+	s.pixels[i] = Color{r, g, b}
+	return nil
+}
+
+// Pixel returns pixel i of the frame buffer
+func (s *Strip) Pixel(i int) (Color, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < 0 || i >= len(s.pixels) {
+		return Color{}, fmt.Errorf("%w: %d of %d", ErrPixel, i, len(s.pixels))
+	}
+	return s.pixels[i], nil
+}
+
+// Fill sets every pixel in the frame buffer to c
+func (s *Strip) Fill(c Color) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.pixels {
+		s.pixels[i] = c
+	}
+}
+
+// Clear turns every pixel in the frame buffer off
+func (s *Strip) Clear() {
+	s.Fill(Color{})
+}
+
+// SetBrightness scales every color from 0, off, to 1, full
+func (s *Strip) SetBrightness(b float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.brightness = min(max(b, 0), 1)
+	levels(&s.table, s.brightness, s.gamma)
+}
+
+// SetGamma sets the gamma correction, 1 sends the levels as they are
+func (s *Strip) SetGamma(g float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gamma = g
+	levels(&s.table, s.brightness, s.gamma)
+}
+
+// Show sends the frame buffer to the strip
+func (s *Strip) Show() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.show()
+}
+
+// show sends the frame buffer, s.mu is held
+func (s *Strip) show() error {
+	s.buf = frame(s.buf, s.pixels, &s.table)
+	return s.spi.Tx(s.buf, nil)
+}
+
+// Animate runs the named animation of Animations at speed frames per
+// second, stopping the one running first. It returns the ctx error
+// when canceled or stopped, the last frame stays lit.
+func (s *Strip) Animate(ctx context.Context, name string, speed float64, c Color) error {
+	run, err := s.start(ctx, name, speed, c)
+	if err != nil {
+		return err
+	}
+	return run()
+}
+
+// start stops the animation running, claims the strip for the named
+// one and returns the function running it
+func (s *Strip) start(ctx context.Context, name string, speed float64, c Color) (run func() error, err error) {
+	a, ok := Animations[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAnimation, name)
+	}
+	if speed <= 0 {
+		speed = DefaultSpeed
+	}
+	s.Stop()
+	s.animate.Lock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancel, s.animation = cancel, name
+	s.mu.Unlock()
+
+	period := time.Duration(float64(time.Second) / speed)
+	return func() error {
+		defer s.animate.Unlock()
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			s.cancel, s.animation = nil, ""
+			s.mu.Unlock()
+		}()
+		for f := 0; ; f++ {
+			s.mu.Lock()
+			a(s.pixels, f, c)
+			err := s.show()
+			s.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			if err := s.wait(ctx, period); err != nil {
+				return err
+			}
+		}
+	}, nil
+}
+
+// Stop stops the animation running
+func (s *Strip) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// command is the JSON of a command payload
+type command struct {
+	Fill       string   `json:"fill"`
+	Brightness *float64 `json:"brightness"`
+	Animation  string   `json:"animation"`
+	Speed      float64  `json:"speed"`
+	Color      string   `json:"color"`
+}
+
+// Command handles a JSON command payload: {"fill":"#ff0000"} fills the
+// strip, {"brightness":0.5} dims it and {"animation":"rainbow",
+// "speed":50} runs an animation in the background at speed frames per
+// second, with "color" for the animations that have one.
+// {"animation":"stop"} stops it. A fill stops the animation.
+func (s *Strip) Command(payload []byte) error {
+	var cmd command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+	if cmd.Fill == "" && cmd.Brightness == nil && cmd.Animation == "" {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+
+	var fill, c Color
+	var err error
+	if cmd.Fill != "" {
+		if fill, err = ParseColor(cmd.Fill); err != nil {
+			return err
+		}
+	}
+	c = Color{255, 255, 255}
+	if cmd.Color != "" {
+		if c, err = ParseColor(cmd.Color); err != nil {
+			return err
+		}
+	}
+	if _, ok := Animations[cmd.Animation]; !ok && cmd.Animation != "" && cmd.Animation != "stop" {
+		return fmt.Errorf("%w: %s", ErrAnimation, cmd.Animation)
+	}
+
+	if cmd.Brightness != nil {
+		s.SetBrightness(*cmd.Brightness)
+	}
+	switch {
+	case cmd.Animation == "stop" || cmd.Fill != "":
+		s.Stop()
+		s.animate.Lock()
+		defer s.animate.Unlock()
+		if cmd.Fill != "" {
+			s.Fill(fill)
+		}
+		return s.Show()
+	case cmd.Animation != "":
+		return s.background(cmd.Animation, cmd.Speed, c)
+	}
+
+	// a new brightness shows at once, an animation shows it next frame
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.animation != "" {
+		return nil
+	}
+	return s.show()
+}
+
+// background starts an animation and runs it in a goroutine, being
+// stopped is not an error
+func (s *Strip) background(name string, speed float64, c Color) error {
+	run, err := s.start(context.Background(), name, speed, c)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := run(); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Warn("neopixel animation", "device", s.Device.Name, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Read returns the state of the strip
+func (s *Strip) Read() *Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Reading{
+		Pixels:     len(s.pixels),
+		Brightness: s.brightness,
+		Animation:  s.animation,
+	}
+}
+
+// ReadPub publishes the reading
+func (s *Strip) ReadPub() error {
+	j, err := json.Marshal(s.Read())
+	if err != nil {
+		return err
+	}
+	s.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (s *Strip) Run(ctx context.Context, period time.Duration) error {
+	err := s.TimerLoop(ctx, period, s.ReadPub)
+	slog.Debug("neopixel stopped", "device", s.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the animation, turns the strip off and releases the SPI
+// device
+func (s *Strip) Close() error {
+	s.Stop()
+	s.animate.Lock()
+	defer s.animate.Unlock()
+	s.Clear()
+	return errors.Join(s.Show(), s.spi.Close())
+}
+
+// mockSPI is the SPI device of a mock strip
+type mockSPI struct{}
+
+func (*mockSPI) Tx(w, r []byte) error { return nil }
+func (*mockSPI) Close() error         { return nil }
+
+// wait waits d or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,308 @@
+package neopixel
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func newTestStrip(t *testing.T, n int) (*Strip, *driverstest.SPI) {
+	t.Helper()
+	device.Mock(false)
+	spi := driverstest.NewSPI()
+	driverstest.UseSPI(t, spi)
+	s, err := New("strip", "/dev/spidev0.0", n)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s, spi
+}
+
+// golden returns the hex of the encoded pixels followed by the reset
+func golden(pixels ...string) string {
+	return strings.Join(pixels, "") + hex.EncodeToString(make([]byte, ResetBytes))
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		b    byte
+		want string
+	}{
+		{0x00, "924924"},
+		{0xFF, "db6db6"},
+		{0xAA, "d34d34"},
+		{0x55, "9a69a6"},
+		{0x01, "924926"},
+		{0x80, "d24924"},
+	}
+	for _, tt := range tests {
+		got := make([]byte, 3)
+		encode(got, tt.b)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("encode(%#02x) got (%x) want (%s)", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestShow(t *testing.T) {
+	s, spi := newTestStrip(t, 2)
+	if spi.Dev != "/dev/spidev0.0" || spi.Hz != SPIFreq {
+		t.Errorf("opened (%s, %d Hz) want (/dev/spidev0.0, %d Hz)", spi.Dev, spi.Hz, SPIFreq)
+	}
+	s.SetGamma(1)
+
+	// green, red, blue on the wire
+	s.SetPixel(0, 0xFF, 0x00, 0xAA)
+	s.SetPixel(1, 0x01, 0x80, 0x55)
+	if err := s.Show(); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	s.Fill(Color{0xFF, 0xFF, 0xFF})
+	s.Show()
+	s.Clear()
+	s.Show()
+
+	want := []string{
+		golden("924924", "db6db6", "d34d34", "d24924", "924926", "9a69a6"),
+		golden("db6db6db6db6db6db6", "db6db6db6db6db6db6"),
+		golden("924924924924924924", "924924924924924924"),
+	}
+	got := spi.Transcript()
+	if len(got) != len(want) {
+		t.Fatalf("got (%d) transfers want (%d)", len(got), len(want))
+	}
+	for i := range want {
+		if hex.EncodeToString(got[i]) != want[i] {
+			t.Errorf("transfer %d got (%x) want (%s)", i, got[i], want[i])
+		}
+	}
+
+	if err := s.SetPixel(2, 1, 2, 3); !errors.Is(err, ErrPixel) {
+		t.Errorf("SetPixel(2) error got (%v) want (%v)", err, ErrPixel)
+	}
+	if err := s.SetPixel(-1, 1, 2, 3); !errors.Is(err, ErrPixel) {
+		t.Errorf("SetPixel(-1) error got (%v) want (%v)", err, ErrPixel)
+	}
+	s.Close()
+	if !spi.Closed() {
+		t.Error("Close() did not release the SPI device")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		brightness, gamma float64
+		in, want          []byte
+	}{
+		{1, 1, []byte{0, 1, 128, 255}, []byte{0, 1, 128, 255}},
+		{1, DefaultGamma, []byte{0, 1, 64, 128, 200, 255}, []byte{0, 0, 5, 37, 129, 255}},
+		{0.5, 1, []byte{128, 255}, []byte{64, 128}},
+		{0.5, DefaultGamma, []byte{128, 255}, []byte{5, 37}},
+		{0, DefaultGamma, []byte{255}, []byte{0}},
+	}
+	for _, tt := range tests {
+		var table [256]byte
+		levels(&table, tt.brightness, tt.gamma)
+		for i, v := range tt.in {
+			if table[v] != tt.want[i] {
+				t.Errorf("brightness %v gamma %v level %d got (%d) want (%d)", tt.brightness, tt.gamma, v, table[v], tt.want[i])
+			}
+		}
+	}
+
+	s, spi := newTestStrip(t, 1)
+	s.SetBrightness(2) // clamped to full
+	s.Fill(Color{255, 255, 0})
+	s.Show()
+	s.SetBrightness(0.5)
+	s.Show()
+	want := []string{
+		golden("db6db6", "db6db6", "924924"),
+		golden("9349a6", "9349a6", "924924"), // 255 at half is 37
+	}
+	got := spi.Transcript()
+	for i := range want {
+		if hex.EncodeToString(got[i]) != want[i] {
+			t.Errorf("transfer %d got (%x) want (%s)", i, got[i], want[i])
+		}
+	}
+	if r := s.Read(); r.Brightness != 0.5 || r.Pixels != 1 {
+		t.Errorf("Read() got (%+v) want (1 pixel at 0.5)", r)
+	}
+}
+
+func TestAnimations(t *testing.T) {
+	red, white, grey := Color{255, 0, 0}, Color{255, 255, 255}, Color{200, 200, 200}
+	px := make([]Color, 6)
+
+	Rainbow(px, 0, white)
+	if px[0] != Wheel(0) || px[3] != Wheel(128) {
+		t.Errorf("rainbow frame 0 got (%v)", px)
+	}
+	Rainbow(px, 10, white)
+	if px[0] != Wheel(10) || px[3] != Wheel(138) {
+		t.Errorf("rainbow frame 10 got (%v)", px)
+	}
+
+	TheaterChase(px, 1, red)
+	want := []Color{{}, {}, red, {}, {}, red}
+	for i := range want {
+		if px[i] != want[i] {
+			t.Errorf("theater chase frame 1 got (%v) want (%v)", px, want)
+			break
+		}
+	}
+
+	for _, tt := range []struct {
+		frame int
+		want  Color
+	}{
+		{0, Color{}},
+		{BreatheFrames / 4, Color{100, 100, 100}},
+		{BreatheFrames / 2, grey},
+		{BreatheFrames, Color{}},
+	} {
+		Breathe(px, tt.frame, grey)
+		if px[0] != tt.want || px[5] != tt.want {
+			t.Errorf("breathe frame %d got (%v) want (%v)", tt.frame, px[0], tt.want)
+		}
+	}
+
+	for pos, want := range map[byte]Color{0: {255, 0, 0}, 85: {0, 255, 0}, 170: {0, 0, 255}} {
+		if got := Wheel(pos); got != want {
+			t.Errorf("Wheel(%d) got (%v) want (%v)", pos, got, want)
+		}
+	}
+}
+
+func TestAnimate(t *testing.T) {
+	s, spi := newTestStrip(t, 3)
+	s.SetGamma(1)
+
+	// run five frames on a simulated clock
+	ctx, cancel := context.WithCancel(context.Background())
+	var waited []time.Duration
+	s.wait = func(ctx context.Context, d time.Duration) error {
+		waited = append(waited, d)
+		if len(waited) == 5 {
+			cancel()
+		}
+		return ctx.Err()
+	}
+	err := s.Animate(ctx, "theater_chase", 50, Color{0, 0, 0xFF})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Animate() error got (%v) want (%v)", err, context.Canceled)
+	}
+	if len(waited) != 5 || waited[0] != 20*time.Millisecond {
+		t.Errorf("frames got (%v) want 5 of 20ms", waited)
+	}
+	on, off := "924924924924db6db6", "924924924924924924"
+	want := []string{
+		golden(on, off, off),
+		golden(off, off, on),
+		golden(off, on, off),
+		golden(on, off, off),
+		golden(off, off, on),
+	}
+	got := spi.Transcript()
+	if len(got) != len(want) {
+		t.Fatalf("got (%d) frames want (%d)", len(got), len(want))
+	}
+	for i := range want {
+		if hex.EncodeToString(got[i]) != want[i] {
+			t.Errorf("frame %d got (%x) want (%s)", i, got[i], want[i])
+		}
+	}
+	if s.Read().Animation != "" {
+		t.Errorf("after the animation got (%s) want none", s.Read().Animation)
+	}
+	if err := s.Animate(context.Background(), "fireworks", 0, Color{}); !errors.Is(err, ErrAnimation) {
+		t.Errorf("Animate(fireworks) error got (%v) want (%v)", err, ErrAnimation)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s, spi := newTestStrip(t, 2)
+	s.SetGamma(1)
+
+	if err := s.Command([]byte(`{"animation":"rainbow","speed":200}`)); err != nil {
+		t.Fatalf("Command(rainbow) error = %v", err)
+	}
+	if got := s.Read().Animation; got != "rainbow" {
+		t.Errorf("Read() animation got (%q) want (rainbow)", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := len(spi.Transcript()); n < 2 {
+		t.Errorf("rainbow showed (%d) frames want more", n)
+	}
+
+	// a fill stops the animation and stays
+	if err := s.Command([]byte(`{"fill":"#ff0000"}`)); err != nil {
+		t.Fatalf("Command(fill) error = %v", err)
+	}
+	if got := s.Read().Animation; got != "" {
+		t.Errorf("after the fill animation got (%q) want none", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	red := golden("924924db6db6924924", "924924db6db6924924")
+	tr := spi.Transcript()
+	if last := hex.EncodeToString(tr[len(tr)-1]); last != red {
+		t.Errorf("after the fill got (%s) want (%s)", last, red)
+	}
+
+	n := len(tr)
+	if err := s.Command([]byte(`{"brightness":0}`)); err != nil {
+		t.Fatalf("Command(brightness) error = %v", err)
+	}
+	tr = spi.Transcript()
+	if len(tr) != n+1 || hex.EncodeToString(tr[n]) != golden("924924924924924924", "924924924924924924") {
+		t.Errorf("brightness 0 got (%x) want the strip dark", tr[len(tr)-1])
+	}
+	if c, _ := s.Pixel(1); c != (Color{255, 0, 0}) {
+		t.Errorf("Pixel(1) got (%v) want the fill kept", c)
+	}
+
+	s.Command([]byte(`{"animation":"breathe","color":"00ff00"}`))
+	if err := s.Command([]byte(`{"animation":"stop"}`)); err != nil {
+		t.Errorf("Command(stop) error = %v", err)
+	}
+	if got := s.Read().Animation; got != "" {
+		t.Errorf("after stop animation got (%q) want none", got)
+	}
+
+	for payload, want := range map[string]error{
+		`{"animation":"fireworks"}`:           ErrAnimation,
+		`{"fill":"red"}`:                      ErrColor,
+		`{"fill":"#ff00"}`:                    ErrColor,
+		`{"animation":"breathe","color":1}`:   ErrCommand,
+		`{"animation":"breathe","color":"x"}`: ErrColor,
+		`{}`:                                  ErrCommand,
+		`fill red`:                            ErrCommand,
+	} {
+		if err := s.Command([]byte(payload)); !errors.Is(err, want) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", payload, err, want)
+		}
+	}
+	s.Close()
+}
+
+func TestParseColor(t *testing.T) {
+	for in, want := range map[string]Color{
+		"#ff8000": {255, 128, 0},
+		"0A0B0C":  {10, 11, 12},
+	} {
+		got, err := ParseColor(in)
+		if err != nil || got != want {
+			t.Errorf("ParseColor(%s) got (%v, %v) want (%v)", in, got, err, want)
+		}
+	}
+	if got := (Color{255, 128, 0}).String(); got != "#ff8000" {
+		t.Errorf("String() got (%s) want (#ff8000)", got)
+	}
+}
@@ -0,0 +1,178 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The common kinds of payloads. A sensor publishing one of them with
+// PubData rather than JSON of its own is encoded by any Encoder, the
+// protobuf one in a few bytes.
+
+// Reading is an environmental reading, the quantities taken by name
+// like "temperature" and their units. The ProtobufEncoder sends the
+// values as 32 bit floats.
+type Reading struct {
+	Values map[string]float64 `json:"values"`
+	Units  map[string]string  `json:"units,omitempty"`
+}
+
+// BinaryState is the state of an actuator, a relay or a valve
+type BinaryState struct {
+	On bool `json:"on"`
+}
+
+// Alert is something a device wants noticed, like a threshold crossed
+type Alert struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// Status is the status of a device
+type Status struct {
+	State DeviceState `json:"state"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Encoder encodes what a device publishes with PubData and PubState,
+// wrapped in env when it is not nil. The Suffix is added to the topics
+// it is published on, so a consumer knows how to decode it, MQTT 3
+// has no headers.
+type Encoder interface {
+	Encode(data any, env *Envelope) ([]byte, error)
+	Suffix() string
+}
+
+// deviceEncoder is an Encoder that takes more of the device than the
+// Envelope has, like its labels
+type deviceEncoder interface {
+	encodeDevice(d *Device, data any, env *Envelope) ([]byte, error)
+}
+
+// ErrEncoding is returned decoding a payload that is not of the
+// encoding of its topic, or into a value of another kind
+var ErrEncoding = errors.New("bad encoding")
+
+// JSONEncoder is the default Encoder. []byte and strings go as they
+// are, numbers and bools formatted and anything else as JSON.
+type JSONEncoder struct{}
+
+// ProtobufEncoder encodes the payloads as the messages of payload.proto
+// on topics ending in /pb. Reading, BinaryState, Alert and Status go
+// as their messages, anything else as the bytes the JSONEncoder makes.
+type ProtobufEncoder struct{}
+
+var (
+	// JSON is the JSONEncoder
+	JSON Encoder = JSONEncoder{}
+
+	// Protobuf is the ProtobufEncoder
+	Protobuf Encoder = ProtobufEncoder{}
+)
+
+// ProtobufSuffix ends the topics of the protobuf payloads
+const ProtobufSuffix = "/pb"
+
+// WithEncoder sets the Encoder of the device, JSON unless it is given
+func WithEncoder(e Encoder) Option {
+	return func(d *Device) {
+		d.enc = e
+	}
+}
+
+// Encoder returns the Encoder of the device
+func (d *Device) Encoder() Encoder {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.enc == nil {
+		return JSON
+	}
+	return d.enc
+}
+
+// SetEncoder sets the Encoder of the device
+func (d *Device) SetEncoder(e Encoder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.enc = e
+}
+
+// Encode encodes data, as the data of env when it is not nil
+func (JSONEncoder) Encode(data any, env *Envelope) ([]byte, error) {
+	payload, err := textPayload(data)
+	if err != nil || env == nil {
+		return payload, err
+	}
+	wrapped := *env
+	wrapped.Data = jsonData(payload)
+	return json.Marshal(wrapped)
+}
+
+// Suffix is empty, JSON is what the topics carry by default
+func (JSONEncoder) Suffix() string {
+	return ""
+}
+
+// textPayload is data as the JSONEncoder publishes it bare
+func textPayload(data any) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', 2, 64), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'f', 2, 32), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+		return fmt.Append(nil, v), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// Decode decodes a payload published on topic into v, a pointer to
+// the kind of payload expected or to a []byte for the bytes as they
+// were published. The encoding is told by the suffix of the topic.
+// The Envelope is returned when the payload was wrapped in one.
+func Decode(topic string, payload []byte, v any) (*Envelope, error) {
+	switch {
+	case strings.HasSuffix(topic, ProtobufSuffix):
+		return decodeProtobuf(payload, v)
+	case strings.HasSuffix(topic, CBORSuffix):
+		return decodeCBOR(payload, v)
+	}
+	return decodeJSON(payload, v)
+}
+
+// decodeJSON decodes a JSON payload into v
+func decodeJSON(payload []byte, v any) (*Envelope, error) {
+	var env *Envelope
+	var probe struct {
+		Schema int             `json:"schema"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(payload, &probe) == nil && probe.Schema > 0 {
+		env = &Envelope{}
+		if err := json.Unmarshal(payload, env); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+		}
+		payload = probe.Data
+	}
+	if b, ok := v.(*[]byte); ok {
+		// the bytes that were wrapped, a string as it was
+		var s string
+		if env != nil && json.Unmarshal(payload, &s) == nil {
+			payload = []byte(s)
+		}
+		*b = append([]byte(nil), payload...)
+		return env, nil
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return env, fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	return env, nil
+}
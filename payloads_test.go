@@ -0,0 +1,61 @@
+package device
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestJSONEncoder(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("bme280", "mqtt", WithMessanger(m))
+	if d.Encoder() != JSON {
+		t.Errorf("Encoder() got (%T) want JSON", d.Encoder())
+	}
+
+	reading := Reading{Values: map[string]float64{"temperature": 21.5, "humidity": 40}, Units: map[string]string{"temperature": "C"}}
+	d.PubData(reading)
+	d.PubState(&BinaryState{On: true})
+	d.SetEnveloped(false)
+	d.PubData(Alert{Level: "warning", Message: "too hot"})
+	d.PubData("on")
+
+	msgs := m.Messages("#")
+	if len(msgs) != 4 {
+		t.Fatalf("published got (%v) want 4", msgs)
+	}
+
+	var got Reading
+	env, err := Decode(msgs[0].Topic, msgs[0].Data, &got)
+	if err != nil || !reflect.DeepEqual(got, reading) {
+		t.Errorf("Decode(reading) got (%+v, %v) want (%+v)", got, err, reading)
+	}
+	if env == nil || env.Device != "bme280" || env.Seq != 1 {
+		t.Errorf("Decode(reading) envelope got (%+v)", env)
+	}
+	var state BinaryState
+	if _, err := Decode(msgs[1].Topic, msgs[1].Data, &state); err != nil || !state.On {
+		t.Errorf("Decode(state) got (%+v, %v)", state, err)
+	}
+	var alert Alert
+	env, err = Decode(msgs[2].Topic, msgs[2].Data, &alert)
+	if err != nil || env != nil || alert.Message != "too hot" {
+		t.Errorf("Decode(bare alert) got (%+v, %+v, %v)", alert, env, err)
+	}
+	var raw []byte
+	if _, err := Decode(msgs[3].Topic, msgs[3].Data, &raw); err != nil || string(raw) != "on" {
+		t.Errorf("Decode(on) got (%s, %v)", raw, err)
+	}
+	if _, err := Decode(msgs[3].Topic, msgs[3].Data, &alert); !errors.Is(err, ErrEncoding) {
+		t.Errorf("Decode(on) into an Alert error got (%v) want (%v)", err, ErrEncoding)
+	}
+
+	// an enveloped string comes back as it was
+	d.SetEnveloped(true)
+	m.Reset()
+	d.PubData("on")
+	msg := m.Messages("#")[0]
+	if _, err := Decode(msg.Topic, msg.Data, &raw); err != nil || string(raw) != "on" {
+		t.Errorf("Decode(enveloped on) got (%s, %v)", raw, err)
+	}
+}
@@ -0,0 +1,162 @@
+package ph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// IdealSlope is the Nernst slope of a glass electrode at 25°C
+	// in millivolts per pH
+	IdealSlope = 59.16
+
+	// kelvin25 is 25°C, the slope grows with the absolute
+	// temperature
+	kelvin25 = 298.15
+
+	// MinEfficiency and MaxEfficiency bound the slope of a healthy
+	// probe in percent of IdealSlope, an aging probe gets slower
+	MinEfficiency = 90.0
+	MaxEfficiency = 105.0
+
+	// MaxOffset is the most millivolts a healthy probe reads in a
+	// pH 7 buffer
+	MaxOffset = 30.0
+)
+
+// Calibration is the millivolts the probe reads at pH 7, Offset, and
+// how many fewer it reads per pH more, Slope, both at 25°C. Points
+// are the buffer readings it was worked out from.
+type Calibration struct {
+	Offset float64 `json:"offset"`
+	Slope  float64 `json:"slope"`
+	Points []Point `json:"points,omitempty"`
+}
+
+// Point is the probe reading in a buffer, PH is the nominal pH of
+// the buffer, 4 or 7, and Temp the temperature in °C
+type Point struct {
+	PH   float64 `json:"ph"`
+	MV   float64 `json:"mv"`
+	Temp float64 `json:"temp"`
+}
+
+// DefaultCalibration is an ideal probe, calibrate the real one
+var DefaultCalibration = Calibration{Offset: 0, Slope: IdealSlope}
+
+// buffers hold the pH of the common calibration buffers with the
+// temperature, a pH 7.00 buffer is 7.02 at 20°C
+var buffers = map[float64][][2]float64{
+	4: {{0, 4.01}, {10, 4.00}, {20, 4.00}, {25, 4.01}, {30, 4.01}, {40, 4.03}, {50, 4.06}},
+	7: {{0, 7.12}, {10, 7.06}, {20, 7.02}, {25, 7.00}, {30, 6.99}, {40, 6.97}, {50, 6.97}},
+}
+
+// bufferPH returns the pH of the nominal buffer at temp °C,
+// interpolated in its table and held at its ends
+func bufferPH(nominal, temp float64) (float64, bool) {
+	tbl, ok := buffers[nominal]
+	if !ok {
+		return 0, false
+	}
+	if temp <= tbl[0][0] {
+		return tbl[0][1], true
+	}
+	for i := 1; i < len(tbl); i++ {
+		if temp <= tbl[i][0] {
+			a, b := tbl[i-1], tbl[i]
+			return a[1] + (temp-a[0])/(b[0]-a[0])*(b[1]-a[1]), true
+		}
+	}
+	return tbl[len(tbl)-1][1], true
+}
+
+// factor scales the slope at 25°C to temp °C
+func factor(temp float64) float64 {
+	return (temp + 273.15) / kelvin25
+}
+
+// Valid returns drivers.ErrCalibration for a slope no probe has, a
+// calibration with the buffers swapped has it negative
+func (c Calibration) Valid() error {
+	if !(c.Slope > 0) || math.IsInf(c.Slope, 0) || math.IsNaN(c.Offset) || math.IsInf(c.Offset, 0) {
+		return fmt.Errorf("%w: %.2fmV/pH offset %.1fmV", drivers.ErrCalibration, c.Slope, c.Offset)
+	}
+	return nil
+}
+
+// PH converts the probe millivolts at temp °C to pH
+func (c Calibration) PH(mv, temp float64) float64 {
+	return 7 - (mv-c.Offset)/(c.Slope*factor(temp))
+}
+
+// MV returns the millivolts the probe reads at pH and temp °C
+func (c Calibration) MV(ph, temp float64) float64 {
+	return c.Offset - c.Slope*factor(temp)*(ph-7)
+}
+
+// With returns the calibration with the reading p in a buffer, it
+// replaces an earlier reading in the same buffer. With readings in
+// both buffers the offset and the slope are worked out from them, a
+// single reading in pH 7 sets the offset and one in pH 4 the slope.
+func (c Calibration) With(p Point) (Calibration, error) {
+	ph, ok := bufferPH(p.PH, p.Temp)
+	if !ok {
+		return c, fmt.Errorf("%w: no pH %g buffer", drivers.ErrCalibration, p.PH)
+	}
+	n := Calibration{Offset: c.Offset, Slope: c.Slope}
+	for _, q := range c.Points {
+		if q.PH != p.PH {
+			n.Points = append(n.Points, q)
+		}
+	}
+	n.Points = append(n.Points, p)
+	sort.Slice(n.Points, func(i, j int) bool { return n.Points[i].PH < n.Points[j].PH })
+
+	k := factor(p.Temp)
+	switch {
+	case len(n.Points) == 2:
+		a, b := n.Points[0], n.Points[1]
+		pa, _ := bufferPH(a.PH, a.Temp)
+		pb, _ := bufferPH(b.PH, b.Temp)
+		ka, kb := factor(a.Temp), factor(b.Temp)
+		n.Slope = (b.MV - a.MV) / (ka*(pa-7) - kb*(pb-7))
+		n.Offset = a.MV + n.Slope*ka*(pa-7)
+	case p.PH == 7:
+		n.Offset = p.MV + n.Slope*k*(ph-7)
+	default:
+		n.Slope = (n.Offset - p.MV) / (k * (ph - 7))
+	}
+	if err := n.Valid(); err != nil {
+		return c, err
+	}
+	return n, nil
+}
+
+// Health is how the calibration compares to an ideal probe,
+// Efficiency is the slope in percent of IdealSlope. Warnings name
+// what needs looking after: "slope_low" for an aging probe due for a
+// clean or a replacement, "slope_high" for buffers gone bad, and
+// "offset" for a probe reading far off in pH 7.
+type Health struct {
+	Efficiency float64  `json:"efficiency"`
+	Offset     float64  `json:"offset"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// Health checks the calibration
+func (c Calibration) Health() Health {
+	h := Health{Efficiency: c.Slope / IdealSlope * 100, Offset: c.Offset}
+	switch {
+	case h.Efficiency < MinEfficiency:
+		h.Warnings = append(h.Warnings, "slope_low")
+	case h.Efficiency > MaxEfficiency:
+		h.Warnings = append(h.Warnings, "slope_high")
+	}
+	if math.Abs(c.Offset) > MaxOffset {
+		h.Warnings = append(h.Warnings, "offset")
+	}
+	return h
+}
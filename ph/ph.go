@@ -0,0 +1,382 @@
+// Package ph reads an analog pH probe over a drivers.AnalogReader,
+// one channel of an ADS1115 by default, and converts the probe
+// millivolts to pH with a two point calibration. The calibration is
+// taken in pH 4 and pH 7 buffers with the "calibrate 4" and
+// "calibrate 7" commands and saved in the device store. The slope of
+// the probe follows the temperature, linked to a device.Thermometer
+// in the device manager, like the ds18b20 in the tank, the readings
+// are compensated, and a slope
+// drifting away from the ideal 59.16mV/pH is reported as due for
+// maintenance.
+package ph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// ReferenceTemp is the temperature in °C assumed without a
+	// temperature device
+	ReferenceTemp = 25.0
+
+	// CalibrationSamples are averaged for a calibration point
+	CalibrationSamples = 10
+)
+
+var (
+	ErrSensor  = device.ErrSource
+	ErrAlert   = errors.New("invalid alert thresholds")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes. PH is the average over the
+// smoothing window, MV the probe millivolts of the last sample.
+// Compensated is set when Temperature came from the linked device,
+// Maintenance holds the warnings of the calibration Health.
+type Reading struct {
+	PH          float64  `json:"ph"`
+	MV          float64  `json:"mv"`
+	Temperature float64  `json:"temperature"`
+	Compensated bool     `json:"compensated,omitempty"`
+	Maintenance []string `json:"maintenance,omitempty"`
+}
+
+// AlertEvent is published when the pH drops below the low threshold,
+// "low", rises above the high one, "high", and when it is back
+// within them by the hysteresis, "normal"
+type AlertEvent struct {
+	Event string  `json:"event"`
+	PH    float64 `json:"ph"`
+}
+
+// MaintenanceEvent is published after a calibration that found the
+// probe aging or drifting
+type MaintenanceEvent struct {
+	Event string `json:"event"`
+	Health
+}
+
+// PH is an analog pH probe
+type PH struct {
+	*device.Device
+	drivers.AnalogReader
+
+	// Bias and Gain are the front end between the probe and the
+	// reader, the probe millivolts are (volts - Bias) / Gain * 1000.
+	// A board shifting the probe to 2.5V at pH 7 has Bias 2.5.
+	Bias float64
+	Gain float64
+
+	cal    Calibration
+	sensor string
+	temp   float64 // the last good temperature
+
+	window  []float64
+	samples int
+
+	low, high  float64
+	hysteresis float64
+	state      string // "", "low" or "high"
+
+	mu sync.Mutex
+}
+
+// New creates a probe on channel ch of the default ADS1115
+func New(name string, ch int) (*PH, error) {
+	if device.IsMock() {
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, ch)), nil
+	}
+	p, err := drivers.GetADS1115().Pin(name, ch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p), nil
+}
+
+// NewWithReader creates a probe reading r. The calibration saved in
+// the device store is loaded, DefaultCalibration is used if there is
+// none.
+func NewWithReader(name string, r drivers.AnalogReader) *PH {
+	p := &PH{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		Gain:         1,
+		cal:          DefaultCalibration,
+		temp:         ReferenceTemp,
+		samples:      1,
+	}
+	var cal Calibration
+	err := device.GetStore().Load(p.storeKey(), &cal)
+	switch {
+	case err == nil && cal.Valid() == nil:
+		p.cal = cal
+	case err == nil:
+		slog.Warn("ph ignoring saved calibration", "device", name, "error", cal.Valid())
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("ph loading calibration", "device", name, "error", err)
+	}
+	p.SetMockKind("ph", p)
+	return p
+}
+
+func (p *PH) storeKey() string {
+	return p.Device.Name + "/calibration"
+}
+
+// Name returns the name of the device
+func (p *PH) Name() string {
+	return p.Device.Name
+}
+
+// Calibration returns the calibration in use
+func (p *PH) Calibration() Calibration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cal
+}
+
+// SetCalibration sets and saves the calibration
+func (p *PH) SetCalibration(c Calibration) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cal = c
+	p.window = p.window[:0]
+	return device.GetStore().Save(p.storeKey(), &c)
+}
+
+// Calibrate takes the probe standing in the pH 4 or pH 7 buffer,
+// buffer is 4 or 7. The first buffer sets the offset or the slope of
+// the calibration in use, the second the both of them. A
+// MaintenanceEvent is published when the new calibration finds the
+// probe due for maintenance.
+func (p *PH) Calibrate(buffer float64) error {
+	var sum float64
+	for i := 0; i < CalibrationSamples; i++ {
+		mv, err := p.readMV()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.Device.Name, err)
+		}
+		sum += mv
+	}
+	temp, _, err := p.temperature()
+	if err != nil {
+		return err
+	}
+	c, err := p.Calibration().With(Point{PH: buffer, MV: sum / CalibrationSamples, Temp: temp})
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Device.Name, err)
+	}
+	if err := p.SetCalibration(c); err != nil {
+		return err
+	}
+	h := c.Health()
+	if len(h.Warnings) == 0 {
+		return nil
+	}
+	slog.Warn("ph probe needs maintenance", "device", p.Device.Name,
+		"efficiency", h.Efficiency, "offset", h.Offset, "warnings", h.Warnings)
+	return p.publish(&MaintenanceEvent{Event: "maintenance", Health: h})
+}
+
+// Link compensates the readings with the temperature device added to
+// the device manager as sensor, "" reads at ReferenceTemp again
+func (p *PH) Link(sensor string) error {
+	if sensor != "" {
+		if _, err := device.GetThermometer(sensor); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sensor = sensor
+	p.temp = ReferenceTemp
+	return nil
+}
+
+// temperature returns the temperature of the linked device, and if
+// it was read. A device failing to read gives the last good
+// temperature, one gone from the device manager an error.
+func (p *PH) temperature() (float64, bool, error) {
+	p.mu.Lock()
+	sensor, last := p.sensor, p.temp
+	p.mu.Unlock()
+	if sensor == "" {
+		return ReferenceTemp, false, nil
+	}
+	t, err := device.GetThermometer(sensor)
+	if err != nil {
+		return 0, false, err
+	}
+	temp, err := t.Temperature()
+	if err != nil {
+		slog.Warn("ph temperature, using the last one", "device", p.Device.Name,
+			"sensor", sensor, "temperature", last, "error", err)
+		return last, false, nil
+	}
+	p.mu.Lock()
+	p.temp = temp
+	p.mu.Unlock()
+	return temp, true, nil
+}
+
+// SetSmoothing averages the pH over the last n readings, 1 turns
+// the smoothing off
+func (p *PH) SetSmoothing(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = max(n, 1)
+	p.window = p.window[:0]
+}
+
+// SetAlert publishes an AlertEvent when the pH drops below low or
+// rises above high, and again once it is back within them by
+// hysteresis so a reading hovering around a threshold does not flap.
+// A 0 threshold turns its side off.
+func (p *PH) SetAlert(low, high, hysteresis float64) error {
+	if low < 0 || high < 0 || hysteresis < 0 || (high > 0 && low >= high) {
+		return fmt.Errorf("%w: low %g high %g hysteresis %g", ErrAlert, low, high, hysteresis)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.low, p.high, p.hysteresis = low, high, hysteresis
+	p.state = ""
+	return nil
+}
+
+// Command handles a command payload: "calibrate 4" or "calibrate 7"
+// with the probe in the buffer, "calibrate reset" for the default
+// calibration
+func (p *PH) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	if len(c) != 2 || c[0] != "calibrate" {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+	switch c[1] {
+	case "reset":
+		return p.SetCalibration(DefaultCalibration)
+	case "4", "7":
+		buffer, _ := strconv.ParseFloat(c[1], 64)
+		return p.Calibrate(buffer)
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// readMV returns the probe millivolts
+func (p *PH) readMV() (float64, error) {
+	volts, err := p.ReadVolts()
+	if err != nil {
+		return 0, err
+	}
+	return (volts - p.Bias) / p.Gain * 1000, nil
+}
+
+// Read samples the probe and returns the pH averaged over the
+// smoothing window
+func (p *PH) Read() (*Reading, error) {
+	mv, err := p.readMV()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Device.Name, err)
+	}
+	temp, compensated, err := p.temperature()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.window = append(p.window, p.cal.PH(mv, temp))
+	if len(p.window) > p.samples {
+		p.window = p.window[len(p.window)-p.samples:]
+	}
+	var sum float64
+	for _, v := range p.window {
+		sum += v
+	}
+	return &Reading{
+		PH:          sum / float64(len(p.window)),
+		MV:          mv,
+		Temperature: temp,
+		Compensated: compensated,
+		Maintenance: p.cal.Health().Warnings,
+	}, nil
+}
+
+// ReadPub reads the probe and publishes the reading, and the alert
+// if the reading crossed a threshold
+func (p *PH) ReadPub() error {
+	r, err := p.Read()
+	if err != nil {
+		return err
+	}
+	if err := p.publish(r); err != nil {
+		return err
+	}
+	if evt := p.check(r.PH); evt != nil {
+		return p.publish(evt)
+	}
+	return nil
+}
+
+// check returns the AlertEvent for a reading of ph, nil if the
+// alert state did not change
+func (p *PH) check(ph float64) *AlertEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.state
+	switch {
+	case p.low > 0 && ph < p.low:
+		state = "low"
+	case p.high > 0 && ph > p.high:
+		state = "high"
+	case state == "low" && ph >= p.low+p.hysteresis,
+		state == "high" && ph <= p.high-p.hysteresis:
+		state = ""
+	}
+	if state == p.state {
+		return nil
+	}
+	p.state = state
+	if state == "" {
+		return &AlertEvent{Event: "normal", PH: ph}
+	}
+	return &AlertEvent{Event: state, PH: ph}
+}
+
+func (p *PH) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (p *PH) Run(ctx context.Context, period time.Duration) error {
+	err := p.TimerLoop(ctx, period, p.ReadPub)
+	slog.Debug("ph stopped", "device", p.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the reader, the ADS1115 channel by default
+func (p *PH) Close() error {
+	if c, ok := p.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
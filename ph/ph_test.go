@@ -0,0 +1,382 @@
+package ph
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/ds18b20"
+)
+
+// probe is an aged probe reading 12mV in pH 7 with 93% of the ideal
+// slope, the voltages of the tests come from it
+var probe = Calibration{Offset: 12, Slope: 55}
+
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+func TestBufferPH(t *testing.T) {
+	tests := []struct {
+		nominal, temp, want float64
+	}{
+		{7, 25, 7.00},
+		{7, 20, 7.02},
+		{7, 15, 7.04},
+		{7, -5, 7.12},
+		{7, 60, 6.97},
+		{4, 25, 4.01},
+		{4, 45, 4.045},
+	}
+	for _, tt := range tests {
+		got, ok := bufferPH(tt.nominal, tt.temp)
+		if !ok || !near(got, tt.want, 1e-9) {
+			t.Errorf("bufferPH(%g, %g) got (%g, %t) want (%g)", tt.nominal, tt.temp, got, ok, tt.want)
+		}
+	}
+	if _, ok := bufferPH(10, 25); ok {
+		t.Errorf("bufferPH(10) got a buffer want none")
+	}
+}
+
+func TestTwoPoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		t4, t7 float64
+	}{
+		{"at 25°C", 25, 25},
+		{"cold", 10, 10},
+		{"warming up", 20, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ph4, _ := bufferPH(4, tt.t4)
+			ph7, _ := bufferPH(7, tt.t7)
+			c, err := DefaultCalibration.With(Point{PH: 7, MV: probe.MV(ph7, tt.t7), Temp: tt.t7})
+			if err != nil {
+				t.Fatalf("With(7) error = %v", err)
+			}
+			// pH 7 alone moves the offset, the slope stays ideal
+			if c.Slope != IdealSlope || !near(c.PH(probe.MV(ph7, tt.t7), tt.t7), ph7, 1e-9) {
+				t.Errorf("With(7) got (%+v) want the offset only", c)
+			}
+			c, err = c.With(Point{PH: 4, MV: probe.MV(ph4, tt.t4), Temp: tt.t4})
+			if err != nil {
+				t.Fatalf("With(4) error = %v", err)
+			}
+			if !near(c.Offset, probe.Offset, 1e-9) || !near(c.Slope, probe.Slope, 1e-9) || len(c.Points) != 2 {
+				t.Errorf("With(4) got (%+v) want (%+v)", c, probe)
+			}
+		})
+	}
+
+	// a pH 4 reading alone moves the slope
+	c, err := Calibration{Offset: 12, Slope: IdealSlope}.With(Point{PH: 4, MV: probe.MV(4.01, 25), Temp: 25})
+	if err != nil || !near(c.Slope, probe.Slope, 1e-9) || c.Offset != 12 {
+		t.Errorf("With(4) got (%+v, %v) want the slope (%g)", c, err, probe.Slope)
+	}
+
+	// a new reading in a buffer replaces the old one
+	c, _ = DefaultCalibration.With(Point{PH: 7, MV: 50, Temp: 25})
+	c, _ = c.With(Point{PH: 7, MV: 12, Temp: 25})
+	if len(c.Points) != 1 || c.Offset != 12 {
+		t.Errorf("With(7) twice got (%+v) want one point at 12mV", c)
+	}
+
+	// the buffers swapped give a negative slope
+	c, _ = DefaultCalibration.With(Point{PH: 7, MV: probe.MV(4.01, 25), Temp: 25})
+	if _, err := c.With(Point{PH: 4, MV: probe.MV(7, 25), Temp: 25}); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("With(swapped) error got (%v) want (%v)", err, drivers.ErrCalibration)
+	}
+	if _, err := c.With(Point{PH: 10, MV: -160, Temp: 25}); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("With(10) error got (%v) want (%v)", err, drivers.ErrCalibration)
+	}
+}
+
+func TestCompensation(t *testing.T) {
+	tests := []struct {
+		ph, temp float64
+	}{
+		{10, 40},
+		{10, 5},
+		{3, 40},
+		{7, 60},
+	}
+	for _, tt := range tests {
+		mv := probe.MV(tt.ph, tt.temp)
+		if got := probe.PH(mv, tt.temp); !near(got, tt.ph, 1e-9) {
+			t.Errorf("PH(%gmV, %g°C) got (%g) want (%g)", mv, tt.temp, got, tt.ph)
+		}
+	}
+
+	// read without compensation, pH 10 at 40°C is off by 0.15
+	mv := DefaultCalibration.MV(10, 40)
+	if !near(mv, -3*IdealSlope*313.15/298.15, 1e-9) {
+		t.Errorf("MV(10, 40°C) got (%g) want (%g)", mv, -3*IdealSlope*313.15/298.15)
+	}
+	if got := DefaultCalibration.PH(mv, 25); !near(got, 10.151, 0.001) {
+		t.Errorf("PH(%gmV, 25°C) got (%g) want (10.151)", mv, got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		cal  Calibration
+		want []string
+	}{
+		{"ideal", DefaultCalibration, nil},
+		{"aging within range", probe, nil},
+		{"aged", Calibration{Offset: 5, Slope: 50}, []string{"slope_low"}},
+		{"bad buffers", Calibration{Offset: 0, Slope: 63}, []string{"slope_high"}},
+		{"offset", Calibration{Offset: -40, Slope: 58}, []string{"offset"}},
+		{"worn out", Calibration{Offset: 45, Slope: 45}, []string{"slope_low", "offset"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.cal.Health()
+			if !slices.Equal(h.Warnings, tt.want) {
+				t.Errorf("Health() warnings got (%v) want (%v)", h.Warnings, tt.want)
+			}
+			if !near(h.Efficiency, tt.cal.Slope/IdealSlope*100, 1e-9) {
+				t.Errorf("Health() efficiency got (%g) want (%g)", h.Efficiency, tt.cal.Slope/IdealSlope*100)
+			}
+		})
+	}
+}
+
+// sensor is a temperature device
+type sensor struct {
+	temp float64
+	err  error
+}
+
+func (s *sensor) Name() string {
+	return "tank-temp"
+}
+
+func (s *sensor) Temperature() (float64, error) {
+	return s.temp, s.err
+}
+
+func TestCalibrate(t *testing.T) {
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("tank", 0)
+	p := NewWithReader("tank", pin)
+	p.Bias = 1.5
+	volts := func(ph, temp float64) float64 {
+		return p.Bias + probe.MV(ph, temp)/1000
+	}
+
+	if err := p.Link("tank-temp"); !errors.Is(err, ErrSensor) {
+		t.Errorf("Link(missing) error got (%v) want (%v)", err, ErrSensor)
+	}
+	s := &sensor{temp: 20}
+	dm := device.GetDeviceManager()
+	dm.Add(s)
+	defer dm.Remove("tank-temp")
+	if err := p.Link("tank-temp"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+
+	pin.MockValues(volts(7.02, 20))
+	if err := p.Command([]byte("calibrate 7")); err != nil {
+		t.Fatalf("calibrate 7 error = %v", err)
+	}
+	pin.MockValues(volts(4.00, 20))
+	if err := p.Command([]byte(" Calibrate 4\n")); err != nil {
+		t.Fatalf("calibrate 4 error = %v", err)
+	}
+	c := p.Calibration()
+	if !near(c.Offset, probe.Offset, 1e-6) || !near(c.Slope, probe.Slope, 1e-6) {
+		t.Errorf("Calibration() got (%+v) want (%+v)", c, probe)
+	}
+	for _, cmd := range []string{"calibrate", "calibrate 10", "measure 7"} {
+		if err := p.Command([]byte(cmd)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", cmd, err, ErrCommand)
+		}
+	}
+
+	// compensated at the temperature of the linked device
+	s.temp = 35
+	pin.MockValues(volts(8.2, 35))
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !near(r.PH, 8.2, 1e-6) || r.Temperature != 35 || !r.Compensated {
+		t.Errorf("Read() got (%+v) want pH 8.2 at 35°C compensated", r)
+	}
+	// a failing device leaves the last temperature
+	s.err = errors.New("bus error")
+	if r, err = p.Read(); err != nil || !near(r.PH, 8.2, 1e-6) || r.Temperature != 35 || r.Compensated {
+		t.Errorf("Read() with the device failing got (%+v, %v) want pH 8.2 at 35°C", r, err)
+	}
+	if err := p.Link(""); err != nil {
+		t.Fatalf("Link(\"\") error = %v", err)
+	}
+	if r, _ = p.Read(); r.Temperature != ReferenceTemp || near(r.PH, 8.2, 0.01) {
+		t.Errorf("Read() unlinked got (%+v) want uncompensated at %g°C", r, ReferenceTemp)
+	}
+
+	// a restarted device loads the saved calibration
+	again := NewWithReader("tank", pin)
+	if c := again.Calibration(); !near(c.Offset, probe.Offset, 1e-6) || len(c.Points) != 2 {
+		t.Errorf("Calibration() after a restart got (%+v) want (%+v)", c, probe)
+	}
+
+	if err := p.Command([]byte("calibrate reset")); err != nil {
+		t.Fatalf("calibrate reset error = %v", err)
+	}
+	if c := p.Calibration(); c.Slope != IdealSlope || c.Offset != 0 || c.Points != nil {
+		t.Errorf("Calibration() after a reset got (%+v) want the default", c)
+	}
+}
+
+// TestLinkDS18B20 compensates with the probe in the tank
+func TestLinkDS18B20(t *testing.T) {
+	devicetest.UseStore(t)
+	device.Mock(true)
+	defer device.Mock(false)
+
+	tank, err := ds18b20.New("tank-probe", "")
+	if err != nil {
+		t.Fatalf("ds18b20.New() error = %v", err)
+	}
+	dm := device.GetDeviceManager()
+	dm.Add(tank)
+	defer dm.Remove("tank-probe")
+
+	pin := drivers.NewMockAnalogPin("tank", 0)
+	p := NewWithReader("tank", pin)
+	if err := p.Link("tank-probe"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	pin.MockValues(p.Bias + DefaultCalibration.MV(4, 19.5)/1000)
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !r.Compensated || r.Temperature < 17 || r.Temperature > 22 || !near(r.PH, 4, 0.01) {
+		t.Errorf("Read() got (%+v) want pH 4 compensated near 19.5°C", r)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("tank", 0)
+	p := NewWithReader("tank", pin)
+	worn := Calibration{Offset: 8, Slope: 48}
+
+	pin.MockValues(worn.MV(7, 25) / 1000)
+	if err := p.Calibrate(7); err != nil {
+		t.Fatalf("Calibrate(7) error = %v", err)
+	}
+	pin.MockValues(worn.MV(4.01, 25) / 1000)
+	if err := p.Calibrate(4); err != nil {
+		t.Fatalf("Calibrate(4) error = %v", err)
+	}
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !slices.Equal(r.Maintenance, []string{"slope_low"}) {
+		t.Errorf("Read() maintenance got (%v) want ([slope_low])", r.Maintenance)
+	}
+	if h := p.Calibration().Health(); !near(h.Efficiency, 48/IdealSlope*100, 1e-6) {
+		t.Errorf("Health() efficiency got (%g) want (%g)", h.Efficiency, 48/IdealSlope*100)
+	}
+}
+
+func TestSmoothingAndAlerts(t *testing.T) {
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("tank", 0)
+	p := NewWithReader("tank", pin)
+	mv := func(phs ...float64) {
+		vals := make([]float64, len(phs))
+		for i, ph := range phs {
+			vals[i] = DefaultCalibration.MV(ph, ReferenceTemp) / 1000
+		}
+		pin.MockValues(vals...)
+	}
+
+	p.SetSmoothing(4)
+	mv(7, 7.4, 6.6, 8, 8)
+	want := []float64{7, 7.2, 7, 7.25, 7.5}
+	for i, w := range want {
+		r, err := p.Read()
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if !near(r.PH, w, 1e-9) {
+			t.Errorf("Read() #%d got (%g) want (%g)", i, r.PH, w)
+		}
+	}
+
+	if err := p.SetAlert(7, 6, 0); !errors.Is(err, ErrAlert) {
+		t.Errorf("SetAlert(7, 6) error got (%v) want (%v)", err, ErrAlert)
+	}
+	if err := p.SetAlert(6, 8, 0.2); err != nil {
+		t.Fatalf("SetAlert() error = %v", err)
+	}
+	steps := []struct {
+		ph   float64
+		want string
+	}{
+		{7, ""},
+		{5.9, "low"},
+		{5.8, ""},
+		{6.1, ""}, // within the hysteresis
+		{6.2, "normal"},
+		{8.1, "high"},
+		{5.5, "low"},
+		{7.9, "normal"},
+	}
+	for _, s := range steps {
+		evt := p.check(s.ph)
+		switch {
+		case s.want == "" && evt != nil:
+			t.Errorf("check(%g) got (%+v) want none", s.ph, evt)
+		case s.want != "" && (evt == nil || evt.Event != s.want):
+			t.Errorf("check(%g) got (%+v) want (%s)", s.ph, evt, s.want)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	devicetest.UseStore(t)
+	clock := devicetest.UseClock(t, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+
+	p, _ := New("ph-profile", 1)
+	if err := p.MockProfile("aquarium"); err != nil {
+		t.Fatalf("MockProfile(aquarium) error = %v", err)
+	}
+	for h := range 24 {
+		if r, err := p.Read(); err != nil || math.Abs(r.PH-7) > 0.25 {
+			t.Errorf("Read() aquarium at %02d:00 got (%+v, %v) want about pH 7", h, r, err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	// the front end shifts the volts, not the pH
+	p.Bias = 2.5
+	if err := p.MockProfile("hydroponic"); err != nil {
+		t.Fatalf("MockProfile(hydroponic) error = %v", err)
+	}
+	for _, tt := range []struct {
+		at time.Duration
+		ph float64
+	}{{0, 5.8}, {36 * time.Hour, 6.15}, {71 * time.Hour, 6.49}, {72 * time.Hour, 5.8}} {
+		clock.Set(time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC).Add(tt.at))
+		if r, err := p.Read(); err != nil || math.Abs(r.PH-tt.ph) > 0.05 {
+			t.Errorf("Read() hydroponic at %v got (%+v, %v) want pH (%.2f)", tt.at, r, err, tt.ph)
+		}
+	}
+}
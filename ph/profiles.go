@@ -0,0 +1,36 @@
+package ph
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a pH probe, the pH of the water it is in. An
+// "aquarium" stays around 7.0. A "hydroponic" reservoir rises from
+// 5.8 to 6.5 over 3 days as the plants feed, and is dosed back down.
+func init() {
+	device.RegisterMockProfile("ph", "aquarium", func(p *PH) error {
+		return p.profile(device.Walk(7, 0.0002, 6.8, 7.2, p.MockRand()))
+	})
+	device.RegisterMockProfile("ph", "hydroponic", func(p *PH) error {
+		return p.profile(device.Ramp(5.8, 6.5, 3*24*time.Hour))
+	})
+}
+
+// profile has the mock pin read the volts of the pH of g with the
+// noise of the probe, started now, the probe as calibrated at
+// ReferenceTemp behind the front end of the Bias and Gain
+func (p *PH) profile(g device.Generator) error {
+	pin, ok := p.AnalogReader.(*drivers.MockAnalogPin)
+	if !ok {
+		return fmt.Errorf("%w: %s reads no mock pin", device.ErrMockProfile, p.Device.Name)
+	}
+	ph := device.Sum(g, device.Noise(0.01, p.MockRand()))
+	pin.SetGenerator(device.GeneratorFunc(func(t time.Duration) float64 {
+		return p.Bias + p.Calibration().MV(ph.At(t), ReferenceTemp)/1000*p.Gain
+	}))
+	return nil
+}
@@ -0,0 +1,63 @@
+package pir
+
+import "time"
+
+// Occupancy is the state of the room the sensor watches
+type Occupancy string
+
+const (
+	Vacant   Occupancy = "vacant"
+	Occupied Occupancy = "occupied"
+)
+
+// occupancy tracks the room from the sensor's output. The output
+// stays high while the sensor sees motion, so the hold time starts
+// when it drops, and a rising edge within the hold time retriggers.
+type occupancy struct {
+	hold     time.Duration
+	occupied bool
+	active   bool      // the sensor's output is high
+	last     time.Time // when the output last dropped
+}
+
+// rise records motion, it returns true if the room became occupied
+func (o *occupancy) rise(t time.Time) bool {
+	o.active = true
+	o.last = t
+	if o.occupied {
+		return false
+	}
+	o.occupied = true
+	return true
+}
+
+// fall records the end of motion, the hold time starts
+func (o *occupancy) fall(t time.Time) {
+	o.active = false
+	o.last = t
+}
+
+// expire returns true if the room became vacant by t
+func (o *occupancy) expire(t time.Time) bool {
+	if !o.occupied || o.active || t.Sub(o.last) < o.hold {
+		return false
+	}
+	o.occupied = false
+	return true
+}
+
+// deadline returns when the room turns vacant without further
+// motion, false if it is vacant or the sensor still sees motion
+func (o *occupancy) deadline() (time.Time, bool) {
+	if !o.occupied || o.active {
+		return time.Time{}, false
+	}
+	return o.last.Add(o.hold), true
+}
+
+func (o *occupancy) state() Occupancy {
+	if o.occupied {
+		return Occupied
+	}
+	return Vacant
+}
@@ -0,0 +1,262 @@
+// Package pir reads a passive infrared motion sensor like the
+// HC-SR501 or AM312. Every rising edge of the sensor's output is
+// published as a motion event, and the room is tracked as occupied
+// until the sensor has seen no motion for the hold time. The
+// occupancy transitions are published by a second device named
+// <name>/occupancy, so they land on their own topic.
+package pir
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultHold is how long the room stays occupied after the last
+	// motion
+	DefaultHold = 5 * time.Minute
+
+	// DefaultWarmup covers the minute a PIR sensor needs to settle
+	// after power up, its output chatters meanwhile
+	DefaultWarmup = time.Minute
+)
+
+// MotionEvent is published for every rising edge
+type MotionEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+// OccupancyEvent is published on the occupancy device when the room
+// turns occupied or vacant
+type OccupancyEvent struct {
+	Occupancy Occupancy `json:"occupancy"`
+	Time      time.Time `json:"time"`
+}
+
+// Stats is what ReadPub publishes, the motion counts since Open and
+// over the last hour and the edges dropped during the warm up
+type Stats struct {
+	Occupancy      Occupancy `json:"occupancy"`
+	Motion         int       `json:"motion"`
+	MotionLastHour int       `json:"motion_last_hour"`
+	Suppressed     int       `json:"suppressed"`
+	LastMotion     time.Time `json:"last_motion"`
+}
+
+// PIR is a motion sensor on a GPIO line
+type PIR struct {
+	*device.Device
+
+	// Occupancy publishes the occupancy transitions
+	Occupancy *device.Device
+
+	// Hold and Warmup are read by Open
+	Hold   time.Duration
+	Warmup time.Duration
+
+	offset  int
+	opts    []gpiocdev.LineReqOption
+	pin     *drivers.DigitalPin
+	occ     occupancy
+	warmEnd time.Time
+	timer   *device.Timer
+
+	motion     int
+	suppressed int
+	hour       []time.Time // the motion of the last hour
+
+	mu sync.Mutex
+}
+
+// New creates a PIR sensor on the line at offset of the default
+// chip, opts are added to the line request, like a pull down for an
+// open collector output. The line is requested by Open.
+func New(name string, offset int, opts ...gpiocdev.LineReqOption) *PIR {
+	return &PIR{
+		Device:    device.NewDevice(name, "mqtt"),
+		Occupancy: device.NewDevice(name+"/occupancy", "mqtt"),
+		Hold:      DefaultHold,
+		Warmup:    DefaultWarmup,
+		offset:    offset,
+		opts:      opts,
+	}
+}
+
+// Name returns the name of the device
+func (p *PIR) Name() string {
+	return p.Device.Name
+}
+
+// Open requests the line and starts watching it, edges within the
+// Warmup are dropped
+func (p *PIR) Open() error {
+	p.mu.Lock()
+	p.occ = occupancy{hold: p.Hold}
+	p.warmEnd = p.Now().Add(p.Warmup)
+	p.motion, p.suppressed, p.hour = 0, 0, nil
+	p.mu.Unlock()
+
+	if device.IsMock() {
+		return nil
+	}
+	opts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("pir"),
+		gpiocdev.AsInput,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(p.edge),
+	}, p.opts...)
+	pin, err := drivers.GetGPIO().Request(p.Device.Name, p.offset, opts...)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.pin = pin
+	p.mu.Unlock()
+	return nil
+}
+
+// State returns the occupancy of the room
+func (p *PIR) State() Occupancy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.occ.state()
+}
+
+// Stats returns the motion counts and the occupancy
+func (p *PIR) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneHour(p.Now())
+	s := Stats{
+		Occupancy:      p.occ.state(),
+		Motion:         p.motion,
+		MotionLastHour: len(p.hour),
+		Suppressed:     p.suppressed,
+	}
+	if len(p.hour) > 0 {
+		s.LastMotion = p.hour[len(p.hour)-1]
+	}
+	return s
+}
+
+// ReadPub publishes the Stats
+func (p *PIR) ReadPub() error {
+	j, err := json.Marshal(p.Stats())
+	if err != nil {
+		return err
+	}
+	p.PubData(j)
+	return nil
+}
+
+// Run publishes the Stats every period until ctx is canceled, the
+// motion and occupancy events are published as they happen
+func (p *PIR) Run(ctx context.Context, period time.Duration) error {
+	err := p.TimerLoop(ctx, period, p.ReadPub)
+	slog.Debug("pir stopped", "device", p.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the hold timer and releases the line
+func (p *PIR) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if p.pin == nil {
+		return nil
+	}
+	err := p.pin.Close()
+	p.pin = nil
+	return err
+}
+
+// MockMotion drives the sensor's output in mock mode, 1 for motion
+// and 0 when it ends
+func (p *PIR) MockMotion(v int) {
+	p.handle(v == 1, p.Now())
+}
+
+func (p *PIR) edge(evt gpiocdev.LineEvent) {
+	p.handle(evt.Type == gpiocdev.LineEventRisingEdge, p.Now())
+}
+
+// handle runs the occupancy state machine for an edge at t and
+// publishes the events
+func (p *PIR) handle(high bool, t time.Time) {
+	var pubs []any
+	p.mu.Lock()
+	switch {
+	case t.Before(p.warmEnd):
+		p.suppressed++
+	case high:
+		p.motion++
+		p.pruneHour(t)
+		p.hour = append(p.hour, t)
+		pubs = append(pubs, &MotionEvent{Event: "motion", Time: t})
+		if p.occ.rise(t) {
+			pubs = append(pubs, &OccupancyEvent{Occupancy: Occupied, Time: t})
+		}
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+	default:
+		p.occ.fall(t)
+		if d, ok := p.occ.deadline(); ok {
+			if p.timer != nil {
+				p.timer.Stop()
+			}
+			p.timer = p.Clock().AfterFunc(d.Sub(t), func() { p.expire(p.Now()) })
+		}
+	}
+	p.mu.Unlock()
+
+	for _, e := range pubs {
+		j, err := json.Marshal(e)
+		if err != nil {
+			slog.Error("pir event", "device", p.Device.Name, "error", err)
+			continue
+		}
+		if _, ok := e.(*OccupancyEvent); ok {
+			p.Occupancy.PubData(j)
+		} else {
+			p.PubData(j)
+		}
+	}
+}
+
+// expire turns the room vacant if the hold time has passed by t
+func (p *PIR) expire(t time.Time) {
+	p.mu.Lock()
+	vacant := p.occ.expire(t)
+	p.mu.Unlock()
+	if !vacant {
+		return
+	}
+	j, err := json.Marshal(&OccupancyEvent{Occupancy: Vacant, Time: t})
+	if err != nil {
+		slog.Error("pir event", "device", p.Device.Name, "error", err)
+		return
+	}
+	p.Occupancy.PubData(j)
+}
+
+// pruneHour drops the motion older than an hour before t
+func (p *PIR) pruneHour(t time.Time) {
+	i := 0
+	for i < len(p.hour) && t.Sub(p.hour[i]) >= time.Hour {
+		i++
+	}
+	p.hour = p.hour[i:]
+}
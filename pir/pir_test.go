@@ -0,0 +1,187 @@
+package pir
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// step is an edge of the sensor's output, or a check of the
+// occupancy, at a time in seconds
+type step struct {
+	at   int
+	edge string // "rise", "fall" or "" to only expire
+	want Occupancy
+}
+
+func TestOccupancy(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"motion occupies", []step{
+			{0, "", Vacant},
+			{10, "rise", Occupied},
+		}},
+		{"held while the output is high", []step{
+			{0, "rise", Occupied},
+			{400, "", Occupied},
+			{401, "fall", Occupied},
+		}},
+		{"hold time expires", []step{
+			{0, "rise", Occupied},
+			{5, "fall", Occupied},
+			{304, "", Occupied},
+			{305, "", Vacant},
+		}},
+		{"retrigger restarts the hold time", []step{
+			{0, "rise", Occupied},
+			{5, "fall", Occupied},
+			{200, "rise", Occupied},
+			{203, "fall", Occupied},
+			{305, "", Occupied},
+			{502, "", Occupied},
+			{503, "", Vacant},
+		}},
+		{"occupied again", []step{
+			{0, "rise", Occupied},
+			{1, "fall", Occupied},
+			{400, "", Vacant},
+			{401, "rise", Occupied},
+		}},
+		{"fall while vacant", []step{
+			{0, "fall", Vacant},
+			{400, "", Vacant},
+		}},
+	}
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := occupancy{hold: 5 * time.Minute}
+			for _, s := range tt.steps {
+				at := base.Add(time.Duration(s.at) * time.Second)
+				switch s.edge {
+				case "rise":
+					o.rise(at)
+				case "fall":
+					o.fall(at)
+				}
+				o.expire(at)
+				if got := o.state(); got != s.want {
+					t.Fatalf("at %ds after %q got (%s) want (%s)", s.at, s.edge, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	base := time.Now()
+	o := occupancy{hold: time.Minute}
+	if _, ok := o.deadline(); ok {
+		t.Error("vacant room has a deadline")
+	}
+	o.rise(base)
+	if _, ok := o.deadline(); ok {
+		t.Error("deadline while the sensor sees motion")
+	}
+	o.fall(base.Add(time.Second))
+	if d, ok := o.deadline(); !ok || !d.Equal(base.Add(61*time.Second)) {
+		t.Errorf("deadline got (%v, %t) want (61s)", d.Sub(base), ok)
+	}
+}
+
+func TestWarmupStats(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	clock := device.NewSimClock(start)
+	p := New("hall", 17)
+	p.SetClock(clock)
+	if err := p.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer p.Close()
+
+	// chatter while warming up
+	for i := 0; i < 4; i++ {
+		clock.Set(start.Add(time.Duration(i) * time.Second))
+		p.handle(i%2 == 0, p.Now())
+	}
+	if p.State() != Vacant {
+		t.Errorf("warm up chatter occupied the room")
+	}
+
+	// three bursts of motion spread over more than an hour, the hold
+	// of the last not expired yet
+	for _, at := range []time.Duration{2 * time.Minute, 40 * time.Minute, 90 * time.Minute} {
+		clock.Set(start.Add(at))
+		p.handle(true, p.Now())
+		clock.Advance(3 * time.Second)
+		p.handle(false, p.Now())
+	}
+	clock.Set(start.Add(95 * time.Minute))
+	s := p.Stats()
+	if s.Motion != 3 || s.MotionLastHour != 2 || s.Suppressed != 4 {
+		t.Errorf("Stats() got (%+v) want 3 motion, 2 in the last hour, 4 suppressed", s)
+	}
+	if !s.LastMotion.Equal(clock.Now().Add(-5 * time.Minute)) {
+		t.Errorf("last motion got (%v) want 5 minutes ago", clock.Now().Sub(s.LastMotion))
+	}
+	if s.Occupancy != Occupied {
+		t.Errorf("occupancy got (%s) want (%s)", s.Occupancy, Occupied)
+	}
+}
+
+func TestHoldTimer(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+	p := New("office", 17)
+	p.SetClock(clock)
+	p.Hold = time.Minute
+	p.Warmup = 0
+	if err := p.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer p.Close()
+
+	line := chip.Line(17)
+	if line == nil {
+		t.Fatal("Open() did not request the line")
+	}
+	line.Edge(1)
+	if p.State() != Occupied {
+		t.Fatalf("rising edge got (%s) want (%s)", p.State(), Occupied)
+	}
+	line.Edge(0)
+	clock.Advance(40 * time.Second)
+	line.Edge(1) // retriggered within the hold time
+	line.Edge(0)
+	clock.Advance(40 * time.Second)
+	if p.State() != Occupied {
+		t.Errorf("retriggered got (%s) want (%s)", p.State(), Occupied)
+	}
+
+	// the hold of the retrigger expires a minute after it
+	clock.Advance(20 * time.Second)
+	if p.State() != Vacant {
+		t.Errorf("hold time expired got (%s) want (%s)", p.State(), Vacant)
+	}
+	if s := p.Stats(); s.Motion != 2 {
+		t.Errorf("motion got (%d) want (2)", s.Motion)
+	}
+
+	p.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Errorf("Waiters() after Close() got (%d) want no hold timer", n)
+	}
+}
@@ -0,0 +1,58 @@
+package pms5003
+
+// breakpoint maps the concentrations lo to hi onto the index ilo to
+// ihi
+type breakpoint struct {
+	lo, hi   float64
+	ilo, ihi int
+}
+
+// the US EPA breakpoints, PM2.5 as revised in 2024, in µg/m³
+var (
+	pm25Breakpoints = []breakpoint{
+		{0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	}
+	pm10Breakpoints = []breakpoint{
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	}
+)
+
+// categories of the index, in the order of the breakpoints
+var categories = []string{"good", "moderate", "unhealthy_sensitive", "unhealthy", "very_unhealthy", "hazardous"}
+
+// index returns the index of the concentration c and the category
+// it is in, the index tops out at 500
+func index(bps []breakpoint, c float64) (int, int) {
+	for i, bp := range bps {
+		if c <= bp.hi {
+			// the gap between two ranges goes to the upper
+			c = max(c, bp.lo)
+			f := float64(bp.ihi-bp.ilo)/(bp.hi-bp.lo)*(c-bp.lo) + float64(bp.ilo)
+			return int(f + 0.5), i
+		}
+	}
+	return 500, len(bps) - 1
+}
+
+// AQI returns the US EPA air quality index of the atmospheric PM2.5
+// and PM10 and its category, "good" to "hazardous". The EPA index is
+// of 24 hour averages, one of a single measurement only gives an
+// idea.
+func AQI(m Measurement) (int, string) {
+	a, ca := index(pm25Breakpoints, float64(m.PM25))
+	b, cb := index(pm10Breakpoints, float64(m.PM10))
+	if b > a {
+		return b, categories[cb]
+	}
+	return a, categories[ca]
+}
@@ -0,0 +1,120 @@
+package pms5003
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrChecksum = errors.New("pms5003 checksum mismatch")
+	ErrFrame    = errors.New("malformed pms5003 frame")
+)
+
+const (
+	start1 = 0x42
+	start2 = 0x4D
+
+	// dataLen is the frame length of a measurement, 13 words and the
+	// checksum, ackLen of the reply to a command
+	dataLen = 28
+	ackLen  = 4
+)
+
+// commands, the data word follows each
+const (
+	cmdRead  = 0xE2 // read a measurement in passive mode
+	cmdMode  = 0xE1 // 0 passive, 1 active
+	cmdSleep = 0xE4 // 0 sleep, 1 wake
+)
+
+// Measurement is a measurement frame, the mass concentrations in
+// µg/m³ as calibrated in the factory, CF=1, and for the atmosphere,
+// which is what to use outdoors and what the AQI is worked out from,
+// and the number of particles over each size in 0.1 liters of air
+type Measurement struct {
+	PM1CF  uint16 `json:"pm1_0_cf1"`
+	PM25CF uint16 `json:"pm2_5_cf1"`
+	PM10CF uint16 `json:"pm10_cf1"`
+	PM1    uint16 `json:"pm1_0"`
+	PM25   uint16 `json:"pm2_5"`
+	PM10   uint16 `json:"pm10"`
+	N03    uint16 `json:"n0_3"`
+	N05    uint16 `json:"n0_5"`
+	N1     uint16 `json:"n1_0"`
+	N25    uint16 `json:"n2_5"`
+	N5     uint16 `json:"n5_0"`
+	N10    uint16 `json:"n10"`
+}
+
+// Parser reads the frames as the bytes come off the UART. A frame
+// split over several reads is put back together, bytes up to a
+// 0x42 0x4D start, like half a frame on start up, are dropped.
+type Parser struct {
+	buf []byte
+}
+
+// Feed parses data, returning the measurements of the frames it
+// completed and the errors of the frames dropped for a bad length or
+// checksum. The replies to commands are skipped.
+func (p *Parser) Feed(data []byte) ([]Measurement, []error) {
+	p.buf = append(p.buf, data...)
+	var ms []Measurement
+	var errs []error
+	for {
+		i := 0
+		for i < len(p.buf) && !(p.buf[i] == start1 && (i+1 == len(p.buf) || p.buf[i+1] == start2)) {
+			i++
+		}
+		p.buf = p.buf[i:]
+		if len(p.buf) < 4 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(p.buf[2:4]))
+		if n != dataLen && n != ackLen {
+			errs = append(errs, fmt.Errorf("%w: length %d", ErrFrame, n))
+			p.buf = p.buf[1:]
+			continue
+		}
+		if len(p.buf) < 4+n {
+			break
+		}
+		frame := p.buf[:4+n]
+		if got, want := binary.BigEndian.Uint16(frame[2+n:]), checksum(frame[:2+n]); got != want {
+			errs = append(errs, fmt.Errorf("%w: got %#04x want %#04x", ErrChecksum, got, want))
+			// the start may have been in the noise, look past it
+			p.buf = p.buf[1:]
+			continue
+		}
+		if n == dataLen {
+			ms = append(ms, parseMeasurement(frame[4:]))
+		}
+		p.buf = p.buf[4+n:]
+	}
+	return ms, errs
+}
+
+func parseMeasurement(b []byte) Measurement {
+	w := func(i int) uint16 { return binary.BigEndian.Uint16(b[2*i:]) }
+	return Measurement{
+		PM1CF: w(0), PM25CF: w(1), PM10CF: w(2),
+		PM1: w(3), PM25: w(4), PM10: w(5),
+		N03: w(6), N05: w(7), N1: w(8), N25: w(9), N5: w(10), N10: w(11),
+	}
+}
+
+// checksum is the sum of the bytes of the frame before it
+func checksum(b []byte) uint16 {
+	var sum uint16
+	for _, v := range b {
+		sum += uint16(v)
+	}
+	return sum
+}
+
+// command returns the frame of a command with its data word
+func command(cmd byte, data uint16) []byte {
+	f := []byte{start1, start2, cmd, byte(data >> 8), byte(data)}
+	sum := checksum(f)
+	return append(f, byte(sum>>8), byte(sum))
+}
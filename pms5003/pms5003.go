@@ -0,0 +1,319 @@
+// Package pms5003 reads the Plantower PMS5003 particulate matter
+// sensor over its UART, 9600 baud.
+//
+// The sensor sends a measurement every second or so in active mode,
+// the default, or one when asked in passive mode. ReadPub publishes
+// the measurement with its US EPA air quality index. Its fan needs
+// WarmUp, 30 seconds, after power on or a wake before the readings
+// are good, nothing is published until then. The laser and the fan
+// wear out, on a battery set the cycle option: the sensor sleeps
+// between readings and every ReadPub wakes it, waits out the warm up,
+// reads and puts it back to sleep.
+package pms5003
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	Baud = 9600
+
+	// DefaultWarmUp is how long the fan runs before the readings
+	// are stable, from the datasheet
+	DefaultWarmUp = 30 * time.Second
+
+	// DefaultReadTimeout waits for a frame, the sensor sends one
+	// every 200ms to 2.3s in active mode
+	DefaultReadTimeout = 3 * time.Second
+)
+
+var (
+	ErrTimeout = errors.New("no pms5003 frame")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes, the measurement with its air
+// quality index and the frames dropped since the start
+type Reading struct {
+	Measurement
+	AQI      int    `json:"aqi"`
+	Category string `json:"aqi_category"`
+	Dropped  int    `json:"dropped"`
+}
+
+// PMS5003 is a particulate matter sensor on a UART
+type PMS5003 struct {
+	*device.Device
+
+	// WarmUp is how long the fan runs before the readings are good
+	WarmUp time.Duration
+
+	// ReadTimeout is how long Read waits for a frame
+	ReadTimeout time.Duration
+
+	port    io.ReadWriter
+	parser  Parser
+	last    Measurement
+	got     chan struct{} // closed on a new measurement
+	dropped int
+	passive bool
+	cycle   bool
+	asleep  bool
+	woke    time.Time
+	listen  sync.Once
+
+	now   func() time.Time
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates a PMS5003 on the serial port, like "/dev/serial0"
+func New(name, port string) (*PMS5003, error) {
+	if device.IsMock() {
+		return NewWithPort(name, nil), nil
+	}
+	s, err := drivers.NewSerial(port, Baud)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPort(name, s), nil
+}
+
+// NewWithPort creates a PMS5003 talking over port, it is closed by
+// Close if it is an io.Closer. The sensor is taken as just powered
+// on, warming up.
+func NewWithPort(name string, port io.ReadWriter) *PMS5003 {
+	return &PMS5003{
+		Device:      device.NewDevice(name, "mqtt"),
+		WarmUp:      DefaultWarmUp,
+		ReadTimeout: DefaultReadTimeout,
+		port:        port,
+		got:         make(chan struct{}),
+		woke:        time.Now(),
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+// Name returns the name of the device
+func (p *PMS5003) Name() string {
+	return p.Device.Name
+}
+
+// Feed parses bytes from the sensor, the port is read and fed in the
+// background once Read or Run is called
+func (p *PMS5003) Feed(data []byte) {
+	p.mu.Lock()
+	ms, errs := p.parser.Feed(data)
+	p.dropped += len(errs)
+	if len(ms) > 0 {
+		p.last = ms[len(ms)-1]
+		close(p.got)
+		p.got = make(chan struct{})
+	}
+	p.mu.Unlock()
+
+	for _, err := range errs {
+		slog.Debug("pms5003 frame dropped", "device", p.Device.Name, "error", err)
+	}
+}
+
+// start reads the port in the background until it fails
+func (p *PMS5003) start() {
+	p.listen.Do(func() {
+		go func() {
+			buf := make([]byte, 64)
+			for {
+				n, err := p.port.Read(buf)
+				if n > 0 {
+					p.Feed(buf[:n])
+				}
+				if err != nil {
+					if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+						slog.Warn("pms5003 read", "device", p.Device.Name, "error", err)
+					}
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (p *PMS5003) command(cmd byte, data uint16) error {
+	if device.IsMock() {
+		return nil
+	}
+	if _, err := p.port.Write(command(cmd, data)); err != nil {
+		return fmt.Errorf("%s: %w", p.Device.Name, err)
+	}
+	return nil
+}
+
+// SetPassive switches to passive mode, the sensor sends a
+// measurement when Read asks for one, or back to active mode
+func (p *PMS5003) SetPassive(passive bool) error {
+	var data uint16
+	if !passive {
+		data = 1
+	}
+	if err := p.command(cmdMode, data); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.passive = passive
+	return nil
+}
+
+// Sleep stops the fan and the laser
+func (p *PMS5003) Sleep() error {
+	if err := p.command(cmdSleep, 0); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.asleep = true
+	return nil
+}
+
+// Wake starts the fan and the laser again, the warm up starts over
+func (p *PMS5003) Wake() error {
+	if err := p.command(cmdSleep, 1); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.asleep, p.woke = false, p.now()
+	return nil
+}
+
+// SetCycle puts the sensor to sleep between the readings of ReadPub,
+// or keeps it running
+func (p *PMS5003) SetCycle(on bool) error {
+	p.mu.Lock()
+	p.cycle = on
+	p.mu.Unlock()
+	if on {
+		return p.Sleep()
+	}
+	return p.Wake()
+}
+
+// Warm reports if the sensor is awake and done warming up
+func (p *PMS5003) Warm() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.asleep && p.now().Sub(p.woke) >= p.WarmUp
+}
+
+// Command handles a command payload: "mode active", "mode passive",
+// "sleep", "wake", "cycle on" or "cycle off"
+func (p *PMS5003) Command(payload []byte) error {
+	switch strings.Join(strings.Fields(strings.ToLower(string(payload))), " ") {
+	case "mode active":
+		return p.SetPassive(false)
+	case "mode passive":
+		return p.SetPassive(true)
+	case "sleep":
+		return p.Sleep()
+	case "wake":
+		return p.Wake()
+	case "cycle on":
+		return p.SetCycle(true)
+	case "cycle off":
+		return p.SetCycle(false)
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the next measurement, asked for in passive mode
+func (p *PMS5003) Read() (*Reading, error) {
+	if device.IsMock() {
+		pm := uint16(5 + p.MockRand().Intn(30))
+		return reading(Measurement{PM1: pm / 2, PM25: pm, PM10: pm + 5, N03: 10 * pm}, 0), nil
+	}
+
+	p.start()
+	p.mu.Lock()
+	got, passive := p.got, p.passive
+	p.mu.Unlock()
+	if passive {
+		if err := p.command(cmdRead, 0); err != nil {
+			return nil, err
+		}
+	}
+	select {
+	case <-got:
+	case <-time.After(p.ReadTimeout):
+		return nil, fmt.Errorf("%w: in %v", ErrTimeout, p.ReadTimeout)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return reading(p.last, p.dropped), nil
+}
+
+func reading(m Measurement, dropped int) *Reading {
+	aqi, cat := AQI(m)
+	return &Reading{Measurement: m, AQI: aqi, Category: cat, Dropped: dropped}
+}
+
+// ReadPub reads the sensor and publishes the reading. Cycling, the
+// sensor is woken for it and waited for, otherwise nothing is
+// published while it warms up.
+func (p *PMS5003) ReadPub() error {
+	p.mu.Lock()
+	cycle := p.cycle
+	p.mu.Unlock()
+
+	if cycle {
+		if err := p.Wake(); err != nil {
+			return err
+		}
+		p.sleep(p.WarmUp)
+		defer func() {
+			if err := p.Sleep(); err != nil {
+				slog.Warn("pms5003 sleep", "device", p.Device.Name, "error", err)
+			}
+		}()
+	} else if !p.Warm() {
+		slog.Debug("pms5003 warming up", "device", p.Device.Name)
+		return nil
+	}
+
+	r, err := p.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	p.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (p *PMS5003) Run(ctx context.Context, period time.Duration) error {
+	err := p.TimerLoop(ctx, period, p.ReadPub)
+	slog.Debug("pms5003 stopped", "device", p.Device.Name, "error", err)
+	return err
+}
+
+// Close closes the serial port, which ends the background reading
+func (p *PMS5003) Close() error {
+	if c, ok := p.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
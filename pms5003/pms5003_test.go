@@ -0,0 +1,337 @@
+package pms5003
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// recorded frames of a sensor, clean air then some smoke
+var (
+	clean = Measurement{5, 8, 9, 5, 8, 9, 1077, 316, 48, 4, 0, 0}
+	room  = Measurement{12, 21, 25, 12, 21, 25, 2532, 741, 139, 9, 2, 1}
+	smoke = Measurement{40, 62, 75, 38, 57, 71, 7911, 2304, 416, 31, 6, 2}
+)
+
+const (
+	frameClean = "\x42\x4d\x00\x1c\x00\x05\x00\x08\x00\x09\x00\x05\x00\x08\x00\x09\x04\x35\x01\x3c\x00\x30\x00\x04\x00\x00\x00\x00\x97\x00\x02\x18"
+	frameRoom  = "\x42\x4d\x00\x1c\x00\x0c\x00\x15\x00\x19\x00\x0c\x00\x15\x00\x19\x09\xe4\x02\xe5\x00\x8b\x00\x09\x00\x02\x00\x01\x97\x00\x04\x21"
+	frameSmoke = "\x42\x4d\x00\x1c\x00\x28\x00\x3e\x00\x4b\x00\x26\x00\x39\x00\x47\x1e\xe7\x09\x00\x01\xa0\x00\x1f\x00\x06\x00\x02\x97\x00\x04\x6f"
+	ackActive  = "\x42\x4d\x00\x04\xe1\x00\x01\x74"
+)
+
+// recorded starts with the tail of a frame cut off by the start up,
+// has the reply to a mode command, a frame with a byte gone bad, a
+// start with a bad length and a frame cut short by the next
+var recorded = "\x00\x01\x97\x00\x02\x18" +
+	frameClean +
+	ackActive +
+	strings.Replace(frameRoom, "\x09\xe4", "\x09\xe5", 1) +
+	"\x42\x4d\x01\x00" +
+	frameRoom +
+	frameSmoke[:10] +
+	frameSmoke
+
+// dropped in recorded: the bad byte, the bad length and the cut short
+const dropped = 3
+
+func TestParser(t *testing.T) {
+	want := []Measurement{clean, room, smoke}
+
+	// whatever the reads split the frames into, the result is the
+	// same
+	for _, size := range []int{1, 2, 5, 31, 64, len(recorded)} {
+		var p Parser
+		var got []Measurement
+		var errs []error
+		for data := []byte(recorded); len(data) > 0; {
+			n := min(size, len(data))
+			ms, e := p.Feed(data[:n])
+			got, errs = append(got, ms...), append(errs, e...)
+			data = data[n:]
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("reads of %d got (%v) want (%v)", size, got, want)
+		}
+		if len(errs) != dropped || !errors.Is(errs[0], ErrChecksum) || !errors.Is(errs[1], ErrFrame) {
+			t.Errorf("reads of %d errors got (%v) want %d", size, errs, dropped)
+		}
+	}
+}
+
+func TestCommandFrames(t *testing.T) {
+	// the commands of the protocol appendix of the datasheet
+	tests := []struct {
+		cmd  byte
+		data uint16
+		want string
+	}{
+		{cmdRead, 0, "42 4d e2 00 00 01 71"},
+		{cmdMode, 0, "42 4d e1 00 00 01 70"},
+		{cmdMode, 1, "42 4d e1 00 01 01 71"},
+		{cmdSleep, 0, "42 4d e4 00 00 01 73"},
+		{cmdSleep, 1, "42 4d e4 00 01 01 74"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf("% x", command(tt.cmd, tt.data)); got != tt.want {
+			t.Errorf("command(%#02x, %d) got (%s) want (%s)", tt.cmd, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestAQI(t *testing.T) {
+	tests := []struct {
+		pm25, pm10 uint16
+		aqi        int
+		category   string
+	}{
+		{0, 0, 0, "good"},
+		{9, 20, 50, "good"},
+		{10, 54, 53, "moderate"},
+		{12, 0, 56, "moderate"},
+		{35, 0, 99, "moderate"},
+		{55, 0, 149, "unhealthy_sensitive"},
+		{8, 200, 123, "unhealthy_sensitive"},
+		{100, 0, 182, "unhealthy"},
+		{150, 0, 225, "very_unhealthy"},
+		{300, 0, 449, "hazardous"},
+		{500, 0, 500, "hazardous"},
+		{0, 700, 500, "hazardous"},
+	}
+	for _, tt := range tests {
+		aqi, cat := AQI(Measurement{PM25: tt.pm25, PM10: tt.pm10})
+		if aqi != tt.aqi || cat != tt.category {
+			t.Errorf("AQI(%d, %d) got (%d, %s) want (%d, %s)", tt.pm25, tt.pm10, aqi, cat, tt.aqi, tt.category)
+		}
+	}
+}
+
+// sensor plays a PMS5003 on the other end of a pipe: in active mode
+// it sends its measurement every few milliseconds, in passive mode
+// when asked, and nothing asleep
+type sensor struct {
+	r   *io.PipeReader
+	w   *io.PipeWriter
+	out chan []byte
+
+	m       Measurement
+	passive bool
+	asleep  bool
+	cmds    []string
+	mu      sync.Mutex
+}
+
+func newSensor(t *testing.T, m Measurement) *sensor {
+	r, w := io.Pipe()
+	s := &sensor{r: r, w: w, out: make(chan []byte, 16), m: m}
+	done := make(chan struct{})
+	go func() {
+		for b := range s.out {
+			if _, err := s.w.Write(b); err != nil {
+				break
+			}
+		}
+	}()
+	go func() {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				s.mu.Lock()
+				if !s.passive && !s.asleep {
+					s.send(frameOf(s.m))
+				}
+				s.mu.Unlock()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		s.Close()
+	})
+	return s
+}
+
+// frameOf returns the measurement frame of m
+func frameOf(m Measurement) []byte {
+	words := []uint16{m.PM1CF, m.PM25CF, m.PM10CF, m.PM1, m.PM25, m.PM10,
+		m.N03, m.N05, m.N1, m.N25, m.N5, m.N10, 0x9700}
+	f := []byte{start1, start2, 0, dataLen}
+	for _, w := range words {
+		f = binary.BigEndian.AppendUint16(f, w)
+	}
+	return binary.BigEndian.AppendUint16(f, checksum(f))
+}
+
+func (s *sensor) send(b []byte) {
+	select {
+	case s.out <- b:
+	default:
+	}
+}
+
+func (s *sensor) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+func (s *sensor) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = append(s.cmds, fmt.Sprintf("% x", b))
+	if len(b) != 7 || binary.BigEndian.Uint16(b[5:]) != checksum(b[:5]) {
+		return len(b), nil
+	}
+	data := binary.BigEndian.Uint16(b[3:5])
+	switch b[2] {
+	case cmdMode:
+		s.passive = data == 0
+		for len(s.out) > 0 {
+			<-s.out
+		}
+		ack := []byte{start1, start2, 0, ackLen, cmdMode, byte(data)}
+		s.send(binary.BigEndian.AppendUint16(ack, checksum(ack)))
+	case cmdSleep:
+		s.asleep = data == 0
+	case cmdRead:
+		if s.passive && !s.asleep {
+			s.send(frameOf(s.m))
+		}
+	}
+	return len(b), nil
+}
+
+func (s *sensor) Close() error {
+	s.r.Close()
+	return s.w.Close()
+}
+
+func (s *sensor) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.cmds
+	s.cmds = nil
+	return c
+}
+
+func equal(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+const (
+	wantPassive = "42 4d e1 00 00 01 70"
+	wantRead    = "42 4d e2 00 00 01 71"
+	wantSleep   = "42 4d e4 00 00 01 73"
+	wantWake    = "42 4d e4 00 01 01 74"
+)
+
+func TestRead(t *testing.T) {
+	device.Mock(false)
+	s := newSensor(t, room)
+	p := NewWithPort("pm", s)
+	defer p.Close()
+
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Measurement != room || r.AQI != 73 || r.Category != "moderate" {
+		t.Errorf("Read() active got (%+v) want (%+v) aqi 73", r, room)
+	}
+
+	if err := p.Command([]byte("Mode Passive")); err != nil {
+		t.Fatalf("mode passive error = %v", err)
+	}
+	// let the frames on the way arrive
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	s.m = smoke
+	s.mu.Unlock()
+	if r, err = p.Read(); err != nil || r.Measurement != smoke {
+		t.Errorf("Read() passive got (%+v, %v) want (%+v)", r, err, smoke)
+	}
+	if got, want := s.commands(), []string{wantPassive, wantRead}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	// asleep it does not answer
+	p.ReadTimeout = 30 * time.Millisecond
+	if err := p.Command([]byte("sleep")); err != nil {
+		t.Fatalf("sleep error = %v", err)
+	}
+	if _, err := p.Read(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Read() asleep error got (%v) want (%v)", err, ErrTimeout)
+	}
+	if err := p.Command([]byte("cycle")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(cycle) error got (%v) want (%v)", err, ErrCommand)
+	}
+}
+
+func TestReadPub(t *testing.T) {
+	device.Mock(false)
+	s := newSensor(t, clean)
+	p := NewWithPort("pm", s)
+	defer p.Close()
+	clock := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	p.now = func() time.Time { return clock }
+	p.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+	}
+	p.woke = clock
+	if err := p.SetPassive(true); err != nil {
+		t.Fatalf("SetPassive() error = %v", err)
+	}
+	s.commands()
+
+	// warming up after power on nothing is read
+	if err := p.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() warming up error = %v", err)
+	}
+	if got := s.commands(); len(got) != 0 || p.Warm() {
+		t.Errorf("commands warming up got (%v) want none", got)
+	}
+	clock = clock.Add(DefaultWarmUp)
+	if err := p.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() error = %v", err)
+	}
+	if got, want := s.commands(), []string{wantRead}; !equal(got, want) {
+		t.Errorf("commands warm got (%v) want (%v)", got, want)
+	}
+
+	// cycling it sleeps between the readings, woken and warmed up
+	// for each
+	if err := p.Command([]byte("cycle on")); err != nil {
+		t.Fatalf("cycle on error = %v", err)
+	}
+	clock = clock.Add(time.Hour)
+	if err := p.ReadPub(); err != nil {
+		t.Fatalf("ReadPub() cycling error = %v", err)
+	}
+	if got, want := s.commands(), []string{wantSleep, wantWake, wantRead, wantSleep}; !equal(got, want) {
+		t.Errorf("commands cycling got (%v) want (%v)", got, want)
+	}
+	if len(slept) != 1 || slept[0] != DefaultWarmUp {
+		t.Errorf("slept got (%v) want ([%v])", slept, DefaultWarmUp)
+	}
+	if p.Warm() {
+		t.Errorf("Warm() between the cycles got true want false")
+	}
+
+	if err := p.Command([]byte("cycle off")); err != nil {
+		t.Fatalf("cycle off error = %v", err)
+	}
+	if got, want := s.commands(), []string{wantWake}; !equal(got, want) {
+		t.Errorf("commands cycle off got (%v) want (%v)", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrMockProfile is a mock profile not registered for the kind of a
+// device
+var ErrMockProfile = errors.New("unknown mock profile")
+
+// the mock profiles by the kind of device and their name, and the one
+// of the station
+var mockProfiles = struct {
+	kinds map[string]map[string]func(any) error
+	def   string
+	mu    sync.RWMutex
+}{kinds: make(map[string]map[string]func(any) error)}
+
+// RegisterMockProfile registers the mock profile name of the devices
+// of kind, like "indoor" of a "bme280", apply bundling what it fakes
+// of one of them: its generators and their ranges, its failure rates
+// and its latency. Its package registers them in init, T its device,
+// and calls SetMockKind in New.
+func RegisterMockProfile[T any](kind, name string, apply func(T) error) {
+	mockProfiles.mu.Lock()
+	defer mockProfiles.mu.Unlock()
+	if mockProfiles.kinds[kind] == nil {
+		mockProfiles.kinds[kind] = make(map[string]func(any) error)
+	}
+	mockProfiles.kinds[kind][name] = func(d any) error {
+		t, ok := d.(T)
+		if !ok {
+			return fmt.Errorf("%w: %s %q is for a %T not a %T", ErrMockProfile, kind, name, t, d)
+		}
+		return apply(t)
+	}
+}
+
+// MockProfiles returns the names of the mock profiles of kind, sorted,
+// or of every kind without one
+func MockProfiles(kind string) []string {
+	mockProfiles.mu.RLock()
+	defer mockProfiles.mu.RUnlock()
+	var names []string
+	for k, profiles := range mockProfiles.kinds {
+		if kind != "" && k != kind {
+			continue
+		}
+		for name := range profiles {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SetDefaultMockProfile sets the profile of the station, the one the
+// devices created in mock mode take when their kind has one of the
+// name. It fails with ErrMockProfile when no kind has, "" clears it.
+func SetDefaultMockProfile(name string) error {
+	if all := MockProfiles(""); name != "" && !slices.Contains(all, name) {
+		return fmt.Errorf("%w: %q, one of %s", ErrMockProfile, name, strings.Join(all, ", "))
+	}
+	mockProfiles.mu.Lock()
+	defer mockProfiles.mu.Unlock()
+	mockProfiles.def = name
+	return nil
+}
+
+// DefaultMockProfile returns the profile of the station, "" without
+// one
+func DefaultMockProfile() string {
+	mockProfiles.mu.RLock()
+	defer mockProfiles.mu.RUnlock()
+	return mockProfiles.def
+}
+
+// ApplyMockProfile applies the mock profile name of kind to self, the
+// profiles of a part that is not a Device, like the ADC under the
+// analog sensors. It fails with ErrMockProfile listing the profiles of
+// the kind when it has none of the name.
+func ApplyMockProfile(kind, name string, self any) error {
+	mockProfiles.mu.RLock()
+	apply, ok := mockProfiles.kinds[kind][name]
+	mockProfiles.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q for %s, one of %s", ErrMockProfile, name, kind,
+			strings.Join(MockProfiles(kind), ", "))
+	}
+	return apply(self)
+}
+
+// SetMockKind sets the kind of the device its mock profiles are
+// registered by, self the device of its package they apply to. Its
+// package calls it in New, the default profile of the station applied
+// in mock mode when the kind has it.
+func (d *Device) SetMockKind(kind string, self any) {
+	d.mu.Lock()
+	d.kind, d.self = kind, self
+	d.mu.Unlock()
+
+	mockProfiles.mu.RLock()
+	def := mockProfiles.def
+	_, ok := mockProfiles.kinds[kind][def]
+	mockProfiles.mu.RUnlock()
+	if def == "" || !ok || !IsMock() {
+		return
+	}
+	if err := d.MockProfile(def); err != nil {
+		slog.Error("default mock profile", "device", d.Name, "profile", def, "error", err)
+	}
+}
+
+// MockProfile applies the mock profile name of the kind of the device,
+// it fails with ErrMockProfile listing the profiles of the kind when
+// it has none of the name
+func (d *Device) MockProfile(name string) error {
+	d.mu.RLock()
+	kind, self := d.kind, d.self
+	d.mu.RUnlock()
+
+	if kind == "" {
+		return fmt.Errorf("%w: %s has no mock profiles", ErrMockProfile, d.Name)
+	}
+	if err := ApplyMockProfile(kind, name, self); err != nil {
+		return fmt.Errorf("%s: %w", d.Name, err)
+	}
+
+	defer trackMock(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.profile = name
+	return nil
+}
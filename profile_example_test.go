@@ -0,0 +1,98 @@
+package device_test
+
+import (
+	"fmt"
+	"time"
+
+	device "github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/bme280"
+	"github.com/rustyeddy/otto-devices/ds18b20"
+	"github.com/rustyeddy/otto-devices/relay"
+	"github.com/rustyeddy/otto-devices/sht31"
+	"github.com/rustyeddy/otto-devices/soilmoisture"
+)
+
+// simulated is a device of the station, mocked by a profile
+type simulated interface {
+	SetMockSeed(seed int64)
+	MockProfile(name string) error
+}
+
+// A fully simulated station of five devices, one line each: the
+// BME280 of the kitchen, the SHT31 of a greenhouse, the DS18B20 of a
+// fermenter, the soil moisture probe of the tomatoes drying out and
+// the relay of a vent sticking every third switch. Its clock is fast
+// forwarded to 3 in the afternoon.
+func Example_simulatedStation() {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	device.SetMessanger("mqtt", device.NewMemMessanger())
+	defer device.SetMessanger("mqtt", nil)
+	clock := device.NewSimClock(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	defer device.SetDefaultClock(clock)()
+
+	fermenter, _ := ds18b20.New("fermenter", "")
+	tomatoes, _ := soilmoisture.New("tomatoes", 0)
+	station := []struct {
+		dev     simulated
+		profile string
+	}{
+		{bme280.New("kitchen", "/dev/i2c-1", 0x76), "indoor"},
+		{sht31.New("tunnel", "/dev/i2c-1", sht31.AddressLow), "greenhouse"},
+		{fermenter, "fermenter"},
+		{tomatoes, "drying"},
+		{relay.New("vent", 27), "flaky"},
+	}
+	for _, s := range station {
+		s.dev.SetMockSeed(1)
+		if err := s.dev.MockProfile(s.profile); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	clock.Advance(15 * time.Hour)
+
+	for _, s := range station {
+		var line string
+		var err error
+		switch d := s.dev.(type) {
+		case *bme280.BME280:
+			var r *bme280.Response
+			if r, err = d.Read(); err == nil {
+				line = fmt.Sprintf("%-9s %-10s %5.1fC %3.0f%% %4.0fhPa", d.Name(), s.profile, r.Temperature, r.Humidity, r.Pressure)
+			}
+		case *sht31.SHT31:
+			var r *sht31.Response
+			if r, err = d.Read(); err == nil {
+				line = fmt.Sprintf("%-9s %-10s %5.1fC %3.0f%%", d.Name(), s.profile, r.Temperature, r.Humidity)
+			}
+		case *ds18b20.DS18B20:
+			var c float64
+			if c, err = d.Read(); err == nil {
+				line = fmt.Sprintf("%-9s %-10s %5.1fC", d.Name(), s.profile, c)
+			}
+		case *soilmoisture.SoilMoisture:
+			var r *soilmoisture.Reading
+			if r, err = d.Read(); err == nil {
+				line = fmt.Sprintf("%-9s %-10s %5.0f%%", d.Name(), s.profile, r.Percent)
+			}
+		case *relay.Relay:
+			before := d.PubStats().Published
+			for range 6 {
+				d.Callback(device.NewMsg(d.ControlTopic(), []byte("toggle"), "example"))
+			}
+			line = fmt.Sprintf("%-9s %-10s %d of 6 switched", d.Name, s.profile, d.PubStats().Published-before)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(line)
+	}
+	// Output:
+	// kitchen   indoor      22.4C  40% 1013hPa
+	// tunnel    greenhouse  33.6C  55%
+	// fermenter fermenter   18.9C
+	// tomatoes  drying        86%
+	// vent      flaky      4 of 6 switched
+}
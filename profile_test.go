@@ -0,0 +1,88 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// profiled is the device of a package with mock profiles
+type profiled struct {
+	*Device
+	weather string
+}
+
+func newProfiled(name string) *profiled {
+	p := &profiled{Device: NewDevice(name, "mqtt")}
+	p.SetMockKind("profiletest", p)
+	return p
+}
+
+func TestMockProfile(t *testing.T) {
+	defer Mock(IsMock())
+	Mock(true)
+	for _, name := range []string{"calm", "stormy"} {
+		RegisterMockProfile("profiletest", name, func(p *profiled) error {
+			p.weather = name
+			return nil
+		})
+	}
+	RegisterMockProfile("profiletest", "broken", func(p *profiled) error {
+		return ErrInjected
+	})
+	RegisterMockProfile("profiletest-other", "wrong-type", func(*Device) error { return nil })
+	if got := MockProfiles("profiletest"); strings.Join(got, ",") != "broken,calm,stormy" {
+		t.Errorf("MockProfiles() got (%v)", got)
+	}
+
+	p := newProfiled("profile-station")
+	if err := p.MockProfile("stormy"); err != nil || p.weather != "stormy" {
+		t.Fatalf("MockProfile(stormy) got (%q) error (%v)", p.weather, err)
+	}
+	if st, _ := p.MockStatus(); st.Profile != "stormy" {
+		t.Errorf("MockStatus() profile got (%q) want (stormy)", st.Profile)
+	}
+	err := p.MockProfile("foggy")
+	if !errors.Is(err, ErrMockProfile) || !strings.Contains(err.Error(), "one of broken, calm, stormy") {
+		t.Errorf("MockProfile(foggy) error got (%v) want the profiles listed", err)
+	}
+	if err := p.MockProfile("broken"); !errors.Is(err, ErrInjected) {
+		t.Errorf("MockProfile(broken) error got (%v) want (%v)", err, ErrInjected)
+	}
+	if err := NewDevice("plain", "mqtt").MockProfile("calm"); !errors.Is(err, ErrMockProfile) {
+		t.Errorf("MockProfile() of a device without profiles error got (%v) want (%v)", err, ErrMockProfile)
+	}
+	other := NewDevice("other", "mqtt")
+	other.SetMockKind("profiletest-other", p)
+	if err := other.MockProfile("wrong-type"); !errors.Is(err, ErrMockProfile) {
+		t.Errorf("MockProfile() of the wrong type error got (%v) want (%v)", err, ErrMockProfile)
+	}
+
+	// a part that is not a Device
+	part := &profiled{}
+	if err := ApplyMockProfile("profiletest", "calm", part); err != nil || part.weather != "calm" {
+		t.Errorf("ApplyMockProfile(calm) got (%q) error (%v)", part.weather, err)
+	}
+	if err := ApplyMockProfile("profiletest", "foggy", part); !errors.Is(err, ErrMockProfile) {
+		t.Errorf("ApplyMockProfile(foggy) error got (%v) want (%v)", err, ErrMockProfile)
+	}
+
+	// the station's profile, taken by the devices created in mock mode
+	defer SetDefaultMockProfile("")
+	if err := SetDefaultMockProfile("foggy"); !errors.Is(err, ErrMockProfile) || !strings.Contains(err.Error(), "calm") {
+		t.Errorf("SetDefaultMockProfile(foggy) error got (%v) want the profiles listed", err)
+	}
+	if err := SetDefaultMockProfile("calm"); err != nil {
+		t.Fatalf("SetDefaultMockProfile(calm) error (%v)", err)
+	}
+	if def := DefaultMockProfile(); def != "calm" {
+		t.Errorf("DefaultMockProfile() got (%q) want (calm)", def)
+	}
+	if p := newProfiled("profile-default"); p.weather != "calm" {
+		t.Errorf("default profile got (%q) want (calm)", p.weather)
+	}
+	Mock(false)
+	if p := newProfiled("profile-real"); p.weather != "" {
+		t.Errorf("default profile out of mock mode got (%q) want none", p.weather)
+	}
+}
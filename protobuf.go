@@ -0,0 +1,295 @@
+package device
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The field numbers of payload.proto, TestProtobufSchema decodes the
+// output with the descriptor of the file to keep them, and the
+// quantities, in step
+const (
+	pbSchema protowire.Number = 1
+	pbTS     protowire.Number = 2
+	pbDevice protowire.Number = 3
+	pbSeq    protowire.Number = 4
+	pbRead   protowire.Number = 5
+	pbState  protowire.Number = 6
+	pbAlert  protowire.Number = 7
+	pbStatus protowire.Number = 8
+	pbRaw    protowire.Number = 9
+	pbReplay protowire.Number = 10
+)
+
+// Encode encodes data as an Envelope message, with the fields of env
+// when it is not nil
+func (ProtobufEncoder) Encode(data any, env *Envelope) ([]byte, error) {
+	var b []byte
+	if env != nil {
+		b = protowire.AppendTag(b, pbSchema, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(env.Schema))
+		if ts, err := time.Parse(time.RFC3339Nano, env.TS); err == nil {
+			b = protowire.AppendTag(b, pbTS, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, uint64(ts.UnixNano()))
+		}
+		b = appendString(b, pbDevice, env.Device)
+		b = protowire.AppendTag(b, pbSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, env.Seq)
+		if env.Replay {
+			b = protowire.AppendTag(b, pbReplay, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1)
+		}
+	}
+
+	switch v := data.(type) {
+	case Reading:
+		return appendMessage(b, pbRead, v.proto()), nil
+	case *Reading:
+		return appendMessage(b, pbRead, v.proto()), nil
+	case BinaryState:
+		return appendMessage(b, pbState, v.proto()), nil
+	case *BinaryState:
+		return appendMessage(b, pbState, v.proto()), nil
+	case Alert:
+		return appendMessage(b, pbAlert, v.proto()), nil
+	case *Alert:
+		return appendMessage(b, pbAlert, v.proto()), nil
+	case Status:
+		return appendMessage(b, pbStatus, v.proto()), nil
+	case *Status:
+		return appendMessage(b, pbStatus, v.proto()), nil
+	}
+	raw, err := textPayload(data)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, pbRaw, protowire.BytesType)
+	return protowire.AppendBytes(b, raw), nil
+}
+
+// Suffix is ProtobufSuffix
+func (ProtobufEncoder) Suffix() string {
+	return ProtobufSuffix
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// quantities are the kinds of the Quantity messages, a quantity of a
+// Reading by one of these names goes as its kind rather than its name.
+// Append only, the index is the number on the wire.
+var quantities = []string{
+	"", "temperature", "humidity", "pressure", "light", "co2", "tvoc",
+	"pm1", "pm25", "pm10", "moisture", "voltage", "current", "power",
+}
+
+func (r Reading) proto() []byte {
+	var b []byte
+	for _, k := range slices.Sorted(maps.Keys(r.Values)) {
+		var q []byte
+		if kind := slices.Index(quantities, k); kind > 0 {
+			q = protowire.AppendTag(q, 1, protowire.VarintType)
+			q = protowire.AppendVarint(q, uint64(kind))
+		} else {
+			q = appendString(q, 2, k)
+		}
+		q = protowire.AppendTag(q, 3, protowire.Fixed32Type)
+		q = protowire.AppendFixed32(q, math.Float32bits(float32(r.Values[k])))
+		q = appendString(q, 4, r.Units[k])
+		b = appendMessage(b, 1, q)
+	}
+	return b
+}
+
+func (s BinaryState) proto() []byte {
+	if !s.On {
+		return nil
+	}
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func (a Alert) proto() []byte {
+	return appendString(appendString(nil, 1, a.Level), 2, a.Message)
+}
+
+func (s Status) proto() []byte {
+	return appendString(appendString(nil, 1, string(s.State)), 2, s.Error)
+}
+
+// field is a field of a message as it was read
+type field struct {
+	num    protowire.Number
+	varint uint64
+	bytes  []byte
+}
+
+// fields reads the fields of a message, the ones of an unknown type
+// are skipped
+func fields(b []byte) ([]field, error) {
+	var fs []field
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("%w: %v", ErrEncoding, protowire.ParseError(n))
+		}
+		b = b[n:]
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.varint = uint64(v)
+		case protowire.Fixed64Type:
+			f.varint, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			f.num = 0
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: %v", ErrEncoding, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if f.num != 0 {
+			fs = append(fs, f)
+		}
+	}
+	return fs, nil
+}
+
+// decodeProtobuf decodes an Envelope message into v
+func decodeProtobuf(payload []byte, v any) (*Envelope, error) {
+	fs, err := fields(payload)
+	if err != nil {
+		return nil, err
+	}
+	env := &Envelope{}
+	var body *field
+	for i, f := range fs {
+		switch f.num {
+		case pbSchema:
+			env.Schema = int(f.varint)
+		case pbTS:
+			env.TS = time.Unix(0, int64(f.varint)).UTC().Format(time.RFC3339Nano)
+		case pbDevice:
+			env.Device = string(f.bytes)
+		case pbSeq:
+			env.Seq = f.varint
+		case pbReplay:
+			env.Replay = f.varint != 0
+		case pbRead, pbState, pbAlert, pbStatus, pbRaw:
+			body = &fs[i]
+		}
+	}
+	if env.Schema == 0 {
+		env = nil
+	}
+	if body == nil {
+		return env, fmt.Errorf("%w: no payload", ErrEncoding)
+	}
+
+	sub, err := fields(body.bytes)
+	if body.num != pbRaw && err != nil {
+		return env, err
+	}
+	switch dst := v.(type) {
+	case *[]byte:
+		if body.num == pbRaw {
+			*dst = append([]byte(nil), body.bytes...)
+			return env, nil
+		}
+	case *Reading:
+		if body.num == pbRead {
+			*dst = decodeReading(sub)
+			return env, nil
+		}
+	case *BinaryState:
+		if body.num == pbState {
+			*dst = BinaryState{}
+			for _, f := range sub {
+				dst.On = dst.On || (f.num == 1 && f.varint != 0)
+			}
+			return env, nil
+		}
+	case *Alert:
+		if body.num == pbAlert {
+			a, b := stringFields(sub)
+			*dst = Alert{Level: a, Message: b}
+			return env, nil
+		}
+	case *Status:
+		if body.num == pbStatus {
+			a, b := stringFields(sub)
+			*dst = Status{State: DeviceState(a), Error: b}
+			return env, nil
+		}
+	}
+	return env, fmt.Errorf("%w: field %d into %T", ErrEncoding, body.num, v)
+}
+
+func decodeReading(fs []field) Reading {
+	r := Reading{Values: make(map[string]float64)}
+	for _, f := range fs {
+		q, err := fields(f.bytes)
+		if f.num != 1 || err != nil {
+			continue
+		}
+		var name, unit string
+		var value float64
+		for _, e := range q {
+			switch e.num {
+			case 1:
+				if e.varint < uint64(len(quantities)) {
+					name = quantities[e.varint]
+				}
+			case 2:
+				name = string(e.bytes)
+			case 3:
+				value = float64(math.Float32frombits(uint32(e.varint)))
+			case 4:
+				unit = string(e.bytes)
+			}
+		}
+		r.Values[name] = value
+		if unit != "" {
+			if r.Units == nil {
+				r.Units = make(map[string]string)
+			}
+			r.Units[name] = unit
+		}
+	}
+	return r
+}
+
+// stringFields returns the string fields 1 and 2 of a message
+func stringFields(fs []field) (string, string) {
+	var a, b string
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			a = string(f.bytes)
+		case 2:
+			b = string(f.bytes)
+		}
+	}
+	return a, b
+}
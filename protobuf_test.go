@@ -0,0 +1,334 @@
+package device
+
+import (
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestProtobuf(t *testing.T) {
+	m := NewMemMessanger()
+	d := NewDevice("bme280", "mqtt", WithMessanger(m), WithEncoder(Protobuf))
+
+	reading := Reading{
+		Values: map[string]float64{"temperature": 21.53, "humidity": 40.2, "pressure": 1006.53, "radon": 42},
+		Units:  map[string]string{"temperature": "C", "humidity": "%", "pressure": "hPa", "radon": "Bq/m3"},
+	}
+	before := time.Now()
+	d.PubData(reading)
+	d.PubState(BinaryState{On: true})
+	d.PubState(&BinaryState{})
+	d.PubData(Alert{Level: "critical", Message: "sensor open"})
+	d.PubData(&Status{State: StateError, Error: "no answer"})
+	d.PubData(21.456)
+
+	msgs := m.Messages("#")
+	if len(msgs) != 6 {
+		t.Fatalf("published got (%v) want 6", msgs)
+	}
+	if msgs[0].Topic != d.DataTopic()+"/pb" || msgs[1].Topic != d.StateTopic()+"/pb" {
+		t.Errorf("topics got (%s, %s) want the /pb suffix", msgs[0].Topic, msgs[1].Topic)
+	}
+
+	var got Reading
+	env, err := Decode(msgs[0].Topic, msgs[0].Data, &got)
+	if err != nil || !reflect.DeepEqual(got.Units, reading.Units) || len(got.Values) != len(reading.Values) {
+		t.Errorf("Decode(reading) got (%+v, %v) want (%+v)", got, err, reading)
+	}
+	for k, v := range reading.Values {
+		if math.Abs(got.Values[k]-v) > v*1e-6 {
+			t.Errorf("Decode(reading) %s got (%f) want (%f)", k, got.Values[k], v)
+		}
+	}
+	ts, _ := time.Parse(time.RFC3339Nano, env.TS)
+	if env.Schema != SchemaVersion || env.Device != "bme280" || env.Seq != 1 || ts.Before(before.Add(-time.Second)) {
+		t.Errorf("Decode(reading) envelope got (%+v)", env)
+	}
+
+	var on, off BinaryState
+	Decode(msgs[1].Topic, msgs[1].Data, &on)
+	env, _ = Decode(msgs[2].Topic, msgs[2].Data, &off)
+	if !on.On || off.On || env.Seq != 2 {
+		t.Errorf("Decode(states) got (%+v, %+v, seq %d)", on, off, env.Seq)
+	}
+	var alert Alert
+	if _, err := Decode(msgs[3].Topic, msgs[3].Data, &alert); err != nil || alert != (Alert{Level: "critical", Message: "sensor open"}) {
+		t.Errorf("Decode(alert) got (%+v, %v)", alert, err)
+	}
+	var status Status
+	if _, err := Decode(msgs[4].Topic, msgs[4].Data, &status); err != nil || status != (Status{State: StateError, Error: "no answer"}) {
+		t.Errorf("Decode(status) got (%+v, %v)", status, err)
+	}
+	var raw []byte
+	if _, err := Decode(msgs[5].Topic, msgs[5].Data, &raw); err != nil || string(raw) != "21.46" {
+		t.Errorf("Decode(raw) got (%s, %v)", raw, err)
+	}
+
+	// a kind for another
+	if _, err := Decode(msgs[0].Topic, msgs[0].Data, &alert); !errors.Is(err, ErrEncoding) {
+		t.Errorf("Decode(reading) into an Alert error got (%v) want (%v)", err, ErrEncoding)
+	}
+	if _, err := Decode(msgs[0].Topic, []byte{0x2a, 0xff}, &got); !errors.Is(err, ErrEncoding) {
+		t.Errorf("Decode(truncated) error got (%v) want (%v)", err, ErrEncoding)
+	}
+
+	// bare, the body only
+	d.SetEnveloped(false)
+	d.PubState(BinaryState{On: true})
+	msgs = m.Messages(d.StateTopic() + "/pb")
+	env, err = Decode(msgs[len(msgs)-1].Topic, msgs[len(msgs)-1].Data, &on)
+	if err != nil || env != nil || !on.On {
+		t.Errorf("Decode(bare state) got (%+v, %+v, %v)", on, env, err)
+	}
+
+	// removed, the retained topics it used are cleared
+	d.SetRetain(Retain{Data: true, State: true})
+	d.PubData(reading)
+	d.ClearRetained()
+	if _, ok := m.Retained(d.DataTopic() + "/pb"); ok {
+		t.Error("ClearRetained() left the /pb data")
+	}
+}
+
+func TestProtobufSize(t *testing.T) {
+	reading := Reading{Values: map[string]float64{"temperature": 21.53, "humidity": 40.2, "pressure": 1006.53}}
+	env := &Envelope{Schema: SchemaVersion, TS: time.Now().UTC().Format(time.RFC3339Nano), Device: "bme280", Seq: 1234}
+
+	for _, tt := range []struct {
+		name string
+		data any
+		env  *Envelope
+	}{
+		{"reading", reading, nil},
+		{"enveloped reading", reading, env},
+		{"enveloped state", BinaryState{On: true}, env},
+	} {
+		j, err := JSON.Encode(tt.data, tt.env)
+		if err != nil {
+			t.Fatalf("%s JSON error = %v", tt.name, err)
+		}
+		pb, err := Protobuf.Encode(tt.data, tt.env)
+		if err != nil {
+			t.Fatalf("%s protobuf error = %v", tt.name, err)
+		}
+		if len(pb)*2 > len(j) {
+			t.Errorf("%s protobuf (%d bytes) not half of JSON (%d bytes)", tt.name, len(pb), len(j))
+		}
+		t.Logf("%s: JSON %d bytes, protobuf %d bytes", tt.name, len(j), len(pb))
+	}
+}
+
+// TestProtobufSchema decodes what the encoder makes with the messages
+// of payload.proto, the field numbers and kinds of protobuf.go can not
+// drift from it
+func TestProtobufSchema(t *testing.T) {
+	fd, err := protodesc.NewFile(parseProto(t, "payload.proto"), nil)
+	if err != nil {
+		t.Fatalf("payload.proto error = %v", err)
+	}
+	envelope := fd.Messages().ByName("Envelope")
+
+	kinds := fd.Messages().ByName("Quantity").Enums().ByName("Kind").Values()
+	if kinds.Len() != len(quantities) {
+		t.Errorf("quantities got (%d) want the %d kinds", len(quantities), kinds.Len())
+	}
+	for i := range kinds.Len() {
+		v := kinds.Get(i)
+		want := strings.ToLower(string(v.Name()))
+		if v.Number() == 0 {
+			want = ""
+		}
+		if n := int(v.Number()); n >= len(quantities) || quantities[n] != want {
+			t.Errorf("quantities[%d] want (%q)", n, want)
+		}
+	}
+
+	ts := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	env := &Envelope{Schema: SchemaVersion, TS: ts.Format(time.RFC3339Nano), Device: "bme280", Seq: 7, Replay: true}
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	str := func(m protoreflect.Message, name string) string {
+		return get(m, name).String()
+	}
+
+	for _, tt := range []struct {
+		data any
+		body string
+		ok   func(body protoreflect.Value) bool
+	}{
+		{Reading{Values: map[string]float64{"temperature": 21.5, "radon": 42}, Units: map[string]string{"temperature": "C"}}, "reading",
+			func(v protoreflect.Value) bool {
+				values := get(v.Message(), "values").List()
+				if values.Len() != 2 {
+					return false
+				}
+				radon, temp := values.Get(0).Message(), values.Get(1).Message()
+				return str(radon, "name") == "radon" && get(radon, "value").Float() == 42 &&
+					get(temp, "kind").Enum() == kinds.ByName("TEMPERATURE").Number() &&
+					get(temp, "value").Float() == 21.5 && str(temp, "unit") == "C"
+			}},
+		{BinaryState{On: true}, "state", func(v protoreflect.Value) bool {
+			return get(v.Message(), "on").Bool()
+		}},
+		{Alert{Level: "critical", Message: "sensor open"}, "alert", func(v protoreflect.Value) bool {
+			return str(v.Message(), "level") == "critical" && str(v.Message(), "message") == "sensor open"
+		}},
+		{&Status{State: StateError, Error: "no answer"}, "status", func(v protoreflect.Value) bool {
+			return str(v.Message(), "state") == string(StateError) && str(v.Message(), "error") == "no answer"
+		}},
+		{21.456, "raw", func(v protoreflect.Value) bool {
+			return string(v.Bytes()) == "21.46"
+		}},
+	} {
+		b, err := Protobuf.Encode(tt.data, env)
+		if err != nil {
+			t.Fatalf("Encode(%T) error = %v", tt.data, err)
+		}
+		msg := dynamicpb.NewMessage(envelope)
+		if err := proto.Unmarshal(b, msg); err != nil {
+			t.Fatalf("Unmarshal(%T) error = %v", tt.data, err)
+		}
+		if unknown(msg) {
+			t.Errorf("Unmarshal(%T) fields not in payload.proto", tt.data)
+		}
+		if get(msg, "schema").Uint() != SchemaVersion || get(msg, "ts").Int() != ts.UnixNano() ||
+			str(msg, "device") != "bme280" || get(msg, "seq").Uint() != 7 || !get(msg, "replay").Bool() {
+			t.Errorf("Unmarshal(%T) envelope got (%v)", tt.data, msg)
+		}
+		body := msg.WhichOneof(envelope.Oneofs().ByName("body"))
+		if body == nil || string(body.Name()) != tt.body || !tt.ok(msg.Get(body)) {
+			t.Errorf("Unmarshal(%T) body got (%v) want (%s)", tt.data, msg, tt.body)
+		}
+	}
+}
+
+// unknown reports if m or a message in it has fields its descriptor
+// does not
+func unknown(m protoreflect.Message) bool {
+	found := len(m.GetUnknown()) > 0
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i := range v.List().Len() {
+				found = found || unknown(v.List().Get(i).Message())
+			}
+		default:
+			found = found || unknown(v.Message())
+		}
+		return !found
+	})
+	return found
+}
+
+// parseProto reads a .proto file into a descriptor, only as much of
+// the language as payload.proto uses: messages of scalar, message and
+// enum fields, repeated, oneofs and nested enums
+func parseProto(t *testing.T, path string) *descriptorpb.FileDescriptorProto {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error = %v", path, err)
+	}
+	quoted := regexp.MustCompile(`"[^"]*"`)
+	var toks []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line, _, _ = strings.Cut(quoted.ReplaceAllString(line, `""`), "//")
+		for _, p := range []string{"{", "}", ";", "="} {
+			line = strings.ReplaceAll(line, p, " "+p+" ")
+		}
+		toks = append(toks, strings.Fields(line)...)
+	}
+
+	i := 0
+	next := func() string {
+		if i == len(toks) {
+			t.Fatalf("%s: unexpected end", path)
+		}
+		i++
+		return toks[i-1]
+	}
+	number := func() *int32 {
+		next() // =
+		n, err := strconv.Atoi(next())
+		if err != nil {
+			t.Fatalf("%s: field number %v", path, err)
+		}
+		next() // ;
+		return proto.Int32(int32(n))
+	}
+
+	file := &descriptorpb.FileDescriptorProto{Name: proto.String(path), Syntax: proto.String("proto3")}
+	message := func(name string) *descriptorpb.DescriptorProto {
+		m := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+		var oneof *int32
+		next() // {
+		for tok := next(); tok != "}" || oneof != nil; tok = next() {
+			switch tok {
+			case "}":
+				oneof = nil
+			case "oneof":
+				m.OneofDecl = append(m.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(next())})
+				oneof = proto.Int32(int32(len(m.OneofDecl) - 1))
+				next() // {
+			case "enum":
+				e := &descriptorpb.EnumDescriptorProto{Name: proto.String(next())}
+				next() // {
+				for v := next(); v != "}"; v = next() {
+					e.Value = append(e.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(v), Number: number()})
+				}
+				m.EnumType = append(m.EnumType, e)
+			default:
+				f := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), OneofIndex: oneof}
+				if tok == "repeated" {
+					f.Label, tok = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), next()
+				}
+				f.TypeName, f.Name = proto.String(tok), proto.String(next())
+				f.Number = number()
+				m.Field = append(m.Field, f)
+			}
+		}
+
+		// the types, enums are nested and may come after their fields
+		for _, f := range m.Field {
+			typ := f.GetTypeName()
+			if n, ok := descriptorpb.FieldDescriptorProto_Type_value["TYPE_"+strings.ToUpper(typ)]; ok {
+				f.Type, f.TypeName = descriptorpb.FieldDescriptorProto_Type(n).Enum(), nil
+				continue
+			}
+			f.Type, f.TypeName = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), proto.String("."+file.GetPackage()+"."+typ)
+			for _, e := range m.EnumType {
+				if e.GetName() == typ {
+					f.Type, f.TypeName = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum(), proto.String("."+file.GetPackage()+"."+name+"."+typ)
+				}
+			}
+		}
+		return m
+	}
+
+	for i < len(toks) {
+		switch next() {
+		case "package":
+			file.Package = proto.String(next())
+		case "message":
+			file.MessageType = append(file.MessageType, message(next()))
+		default: // syntax and options
+			for next() != ";" {
+			}
+		}
+	}
+	return file
+}
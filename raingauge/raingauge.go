@@ -0,0 +1,203 @@
+// Package raingauge counts the tips of a tipping bucket rain gauge,
+// like the ones of the SparkFun and Argent weather meters, from the
+// pulses of its reed switch. It publishes the rain rate and the
+// totals of the last hour, the last 24 hours and the day so far.
+//
+// The day's total is saved in the device store on every tip, a
+// restart in the middle of a storm carries on counting from it.
+package raingauge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultMMPerTip is the 0.011" bucket of the SparkFun and Argent
+	// gauges
+	DefaultMMPerTip = 0.2794
+
+	// DefaultDebounce filters the bounce of the reed contacts
+	DefaultDebounce = 5 * time.Millisecond
+
+	// DefaultHoldoff ignores the edges after a tip, the reed chatters
+	// longer than the debounce as the magnet swings past. Even a
+	// cloudburst tips the bucket only every few seconds.
+	DefaultHoldoff = 250 * time.Millisecond
+
+	// RateWindow is how far back the tips are counted for the rate
+	RateWindow = 15 * time.Minute
+)
+
+// Reading is what is published, the rate in mm an hour and the
+// totals in mm
+type Reading struct {
+	Rate     float64 `json:"rate_mm_h"`
+	LastHour float64 `json:"last_hour_mm"`
+	Last24h  float64 `json:"last_24h_mm"`
+	Today    float64 `json:"today_mm"`
+}
+
+// saved is the day's count kept in the device store
+type saved struct {
+	Day  string `json:"day"`
+	Tips int    `json:"tips"`
+}
+
+// RainGauge is a tipping bucket rain gauge on a GPIO line. It tells
+// the time by the clock of its Device, a SimClock set with SetClock
+// runs its days in a test.
+type RainGauge struct {
+	*device.Device
+
+	mmPerTip float64
+	pin      *drivers.DigitalPin
+	tot      totals
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates a rain gauge on the line at offset of the default chip,
+// mmPerTip is the rain that fills the bucket once. The reed switch
+// connects the line to ground as the bucket tips, the line is pulled
+// up. opts are added to the line request after the defaults,
+// gpiocdev.WithDebounce changes the debounce.
+func New(name string, offset int, mmPerTip float64, opts ...gpiocdev.LineReqOption) (*RainGauge, error) {
+	r := &RainGauge{
+		Device:   device.NewDevice(name, "mqtt"),
+		mmPerTip: mmPerTip,
+		tot:      totals{holdoff: DefaultHoldoff},
+	}
+	r.now = r.Device.Now
+	r.restore()
+	if device.IsMock() {
+		return r, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("raingauge"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithDebounce(DefaultDebounce),
+		gpiocdev.WithRisingEdge,
+		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			if evt.Type == gpiocdev.LineEventRisingEdge {
+				r.tip(r.now())
+			}
+		}),
+	}, opts...)
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.pin = pin
+	r.mu.Unlock()
+	return r, nil
+}
+
+// Name returns the name of the device
+func (r *RainGauge) Name() string {
+	return r.Device.Name
+}
+
+// SetHoldoff sets the time after a tip its chatter is ignored
+func (r *RainGauge) SetHoldoff(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tot.holdoff = d
+}
+
+// Read returns the rate and the totals
+func (r *RainGauge) Read() *Reading {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.tot.rollDay(now)
+	r.tot.prune(now)
+	mm := func(tips int) float64 { return float64(tips) * r.mmPerTip }
+	return &Reading{
+		Rate:     mm(r.tot.since(now, RateWindow)) * float64(time.Hour/RateWindow),
+		LastHour: mm(r.tot.since(now, time.Hour)),
+		Last24h:  mm(len(r.tot.tips)),
+		Today:    mm(r.tot.today),
+	}
+}
+
+// ReadPub publishes the Reading
+func (r *RainGauge) ReadPub() error {
+	j, err := json.Marshal(r.Read())
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
+
+// Run publishes the Reading every period until ctx is canceled
+func (r *RainGauge) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("raingauge stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the line
+func (r *RainGauge) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pin == nil {
+		return nil
+	}
+	err := r.pin.Close()
+	r.pin = nil
+	return err
+}
+
+// MockTip tips the bucket in mock mode
+func (r *RainGauge) MockTip() {
+	r.tip(r.now())
+}
+
+// tip counts a tip at t and saves the day's count
+func (r *RainGauge) tip(t time.Time) {
+	r.mu.Lock()
+	counted := r.tot.tip(t)
+	s := saved{Day: r.tot.day.Format(time.DateOnly), Tips: r.tot.today}
+	r.mu.Unlock()
+	if !counted {
+		return
+	}
+	if err := device.GetStore().Save(r.storeKey(), &s); err != nil {
+		slog.Warn("raingauge saving the day's total", "device", r.Device.Name, "error", err)
+	}
+}
+
+// restore loads the day's count saved before a restart, a count of
+// an earlier day is dropped. The rolling totals start over, only the
+// day's count is saved.
+func (r *RainGauge) restore() {
+	now := r.now()
+	r.tot.rollDay(now)
+	var s saved
+	err := device.GetStore().Load(r.storeKey(), &s)
+	switch {
+	case err == nil && s.Day == r.tot.day.Format(time.DateOnly):
+		r.tot.today = s.Tips
+	case err != nil && !errors.Is(err, device.ErrNotStored):
+		slog.Warn("raingauge loading the day's total", "device", r.Device.Name, "error", err)
+	}
+}
+
+func (r *RainGauge) storeKey() string {
+	return r.Device.Name + "/today"
+}
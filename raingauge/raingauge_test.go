@@ -0,0 +1,195 @@
+package raingauge
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// newMock creates a gauge of 1mm a tip in mock mode with its clock
+// at *clock
+func newMock(t *testing.T, clock *time.Time) *RainGauge {
+	device.Mock(true)
+	t.Cleanup(func() { device.Mock(false) })
+	r, err := New("rain", 6, 1)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.now = func() time.Time { return *clock }
+	r.restore()
+	return r
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestHoldoff(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	c := totals{holdoff: DefaultHoldoff}
+
+	// a tip with the reed chattering for 100ms, then the next tip
+	var counted int
+	for _, ms := range []int{0, 3, 20, 45, 100, 5000, 5010} {
+		if c.tip(t0.Add(time.Duration(ms) * time.Millisecond)) {
+			counted++
+		}
+	}
+	if counted != 2 || c.today != 2 {
+		t.Errorf("tips got (%d, today %d) want (2)", counted, c.today)
+	}
+}
+
+func TestRollingTotals(t *testing.T) {
+	devicetest.UseStore(t)
+	clock := time.Date(2024, 5, 1, 20, 0, 0, 0, time.Local)
+	r := newMock(t, &clock)
+
+	// 3 tips a minute apart at 20:00, 10 at 22:50
+	for i := 0; i < 3; i++ {
+		r.MockTip()
+		clock = clock.Add(time.Minute)
+	}
+	clock = time.Date(2024, 5, 1, 22, 50, 0, 0, time.Local)
+	for i := 0; i < 10; i++ {
+		r.MockTip()
+		clock = clock.Add(30 * time.Second)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want Reading
+	}{
+		{
+			"in the shower", time.Date(2024, 5, 1, 22, 55, 0, 0, time.Local),
+			Reading{Rate: 40, LastHour: 10, Last24h: 13, Today: 13},
+		},
+		{
+			"rate window passed", time.Date(2024, 5, 1, 23, 10, 0, 0, time.Local),
+			Reading{Rate: 0, LastHour: 10, Last24h: 13, Today: 13},
+		},
+		{
+			"after midnight", time.Date(2024, 5, 2, 0, 30, 0, 0, time.Local),
+			Reading{Rate: 0, LastHour: 0, Last24h: 13, Today: 0},
+		},
+		{
+			"a day later", time.Date(2024, 5, 2, 20, 2, 0, 0, time.Local),
+			Reading{Rate: 0, LastHour: 0, Last24h: 10, Today: 0},
+		},
+		{
+			"the shower a day later", time.Date(2024, 5, 2, 22, 54, 0, 0, time.Local),
+			Reading{Rate: 0, LastHour: 0, Last24h: 1, Today: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock = tt.at
+			got := r.Read()
+			if !near(got.Rate, tt.want.Rate) || !near(got.LastHour, tt.want.LastHour) ||
+				!near(got.Last24h, tt.want.Last24h) || !near(got.Today, tt.want.Today) {
+				t.Errorf("Read() got (%+v) want (%+v)", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHourBoundary(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	c := totals{holdoff: DefaultHoldoff}
+	c.tip(t0)
+	c.tip(t0.Add(30 * time.Minute))
+
+	if n := c.since(t0.Add(time.Hour-time.Second), time.Hour); n != 2 {
+		t.Errorf("tips in the hour got (%d) want (2)", n)
+	}
+	// an hour on the first tip is out
+	if n := c.since(t0.Add(time.Hour), time.Hour); n != 1 {
+		t.Errorf("tips in the hour got (%d) want (1)", n)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	devicetest.UseStore(t)
+	clock := time.Date(2024, 5, 1, 15, 0, 0, 0, time.Local)
+	r := newMock(t, &clock)
+	for i := 0; i < 4; i++ {
+		r.MockTip()
+		clock = clock.Add(time.Second)
+	}
+
+	// restarted the same day, the count carries on
+	again := newMock(t, &clock)
+	if got := again.Read().Today; !near(got, 4) {
+		t.Errorf("Today after restart got (%v) want (4)", got)
+	}
+	again.MockTip()
+	if got := again.Read().Today; !near(got, 5) {
+		t.Errorf("Today after a tip got (%v) want (5)", got)
+	}
+
+	// restarted the next day, yesterday's count is dropped
+	clock = clock.Add(24 * time.Hour)
+	tomorrow := newMock(t, &clock)
+	if got := tomorrow.Read().Today; got != 0 {
+		t.Errorf("Today the next day got (%v) want (0)", got)
+	}
+}
+
+func TestRainGauge(t *testing.T) {
+	devicetest.UseStore(t)
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	r, err := New("rain", 6, DefaultMMPerTip)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer r.Close()
+
+	line := chip.Line(6)
+	line.Edge(1)
+	line.Edge(0)
+	line.Edge(1) // chatter
+	if got := r.Read().Today; !near(got, DefaultMMPerTip) {
+		t.Errorf("Today got (%v) want (%v)", got, DefaultMMPerTip)
+	}
+
+	r.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
+
+func TestSimClockDays(t *testing.T) {
+	devicetest.UseStore(t)
+	device.Mock(true)
+	t.Cleanup(func() { device.Mock(false) })
+	r, err := New("rain", 6, 1)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := device.NewSimClock(time.Date(2024, 5, 1, 22, 30, 0, 0, time.Local))
+	r.SetClock(clock)
+	r.restore()
+
+	for range 3 {
+		r.MockTip()
+		clock.Advance(time.Minute)
+	}
+	clock.Advance(90 * time.Minute) // past midnight
+	got := r.Read()
+	if !near(got.LastHour, 0) || !near(got.Last24h, 3) || !near(got.Today, 0) {
+		t.Errorf("after midnight got (%+v) want 3mm in 24h only", got)
+	}
+
+	clock.Advance(3 * 24 * time.Hour)
+	if got := r.Read(); !near(got.Last24h, 0) || !near(got.Today, 0) {
+		t.Errorf("days on got (%+v) want none", got)
+	}
+}
@@ -0,0 +1,62 @@
+package raingauge
+
+import "time"
+
+// totals counts the tips of the bucket. The tips of the last day are
+// kept so the rolling totals are exact, the tips since midnight are
+// counted on their own so they can be restored after a restart.
+type totals struct {
+	holdoff time.Duration
+
+	tips  []time.Time // the tips within the last 24 hours
+	last  time.Time   // the last counted tip
+	today int
+	day   time.Time // local midnight of the day today counts
+}
+
+// tip counts a tip at t unless it is within holdoff of the last one,
+// a reed switch chatters for a while as the magnet swings past. It
+// returns true if the tip counted.
+func (c *totals) tip(at time.Time) bool {
+	if !c.last.IsZero() && at.Sub(c.last) < c.holdoff {
+		return false
+	}
+	c.rollDay(at)
+	c.prune(at)
+	c.last = at
+	c.tips = append(c.tips, at)
+	c.today++
+	return true
+}
+
+// since returns the number of tips within d before now
+func (c *totals) since(now time.Time, d time.Duration) int {
+	n := 0
+	for i := len(c.tips) - 1; i >= 0 && now.Sub(c.tips[i]) < d; i-- {
+		n++
+	}
+	return n
+}
+
+// prune drops the tips older than a day
+func (c *totals) prune(now time.Time) {
+	i := 0
+	for i < len(c.tips) && now.Sub(c.tips[i]) >= 24*time.Hour {
+		i++
+	}
+	c.tips = c.tips[i:]
+}
+
+// rollDay starts the day's count over after midnight
+func (c *totals) rollDay(at time.Time) {
+	if m := midnight(at); !m.Equal(c.day) {
+		c.day = m
+		c.today = 0
+	}
+}
+
+// midnight returns the start of the day of t in the local time zone
+func midnight(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
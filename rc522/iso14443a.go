@@ -0,0 +1,150 @@
+package rc522
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ISO 14443A commands of the PCD, the reader, to the PICC, the card
+const (
+	piccREQA = 0x26 // 7 bits, wakes the idle cards
+	piccWUPA = 0x52 // 7 bits, wakes the halted ones too
+	piccHLTA = 0x50 // with 0x00 and the CRC
+	piccSEL1 = 0x93 // the select of each cascade level
+	piccSEL2 = 0x95
+	piccSEL3 = 0x97
+
+	// nvbSelect is the number of valid bits of a select with the
+	// whole UID of a level, 7 bytes
+	nvbSelect = 0x70
+
+	// cascadeTag starts a level that carries 3 bytes of a longer UID
+	cascadeTag = 0x88
+
+	// sakCascade in the SAK says the UID goes on at the next level
+	sakCascade = 0x04
+)
+
+var cascade = []byte{piccSEL1, piccSEL2, piccSEL3}
+
+var (
+	ErrFrame = errors.New("broken iso14443a frame")
+	ErrCRC   = errors.New("iso14443a crc_a mismatch")
+	ErrBCC   = errors.New("iso14443a uid bcc mismatch")
+	ErrUID   = errors.New("invalid uid")
+)
+
+// crcA returns the CRC_A of data, ISO 14443-3 appends it low byte
+// first to the frames of more than a byte or so: the CRC-16/CCITT
+// polynomial reflected, 0x8408, from 0x6363
+func crcA(data []byte) uint16 {
+	crc := uint16(0x6363)
+	for _, b := range data {
+		b ^= byte(crc)
+		b ^= b << 4
+		crc = crc>>8 ^ uint16(b)<<8 ^ uint16(b)<<3 ^ uint16(b)>>4
+	}
+	return crc
+}
+
+// withCRC returns data with its CRC_A appended
+func withCRC(data []byte) []byte {
+	crc := crcA(data)
+	return append(append([]byte(nil), data...), byte(crc), byte(crc>>8))
+}
+
+// checkCRC returns frame without the CRC_A it ends with
+func checkCRC(frame []byte) ([]byte, error) {
+	if len(frame) < 3 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrame, len(frame))
+	}
+	n := len(frame) - 2
+	got := uint16(frame[n]) | uint16(frame[n+1])<<8
+	if want := crcA(frame[:n]); got != want {
+		return nil, fmt.Errorf("%w: got %#04x want %#04x", ErrCRC, got, want)
+	}
+	return frame[:n], nil
+}
+
+// bcc is the check byte following the 4 UID bytes of a level
+func bcc(b []byte) byte {
+	var x byte
+	for _, v := range b {
+		x ^= v
+	}
+	return x
+}
+
+// levelFrame returns the 4 bytes and the BCC sent by a card with uid
+// at the cascade level, a cascade tag leads a level when the UID goes
+// on
+func levelFrame(uid UID, level int) ([5]byte, error) {
+	var f [5]byte
+	var last int
+	switch len(uid) {
+	case 4:
+		last = 0
+	case 7:
+		last = 1
+	case 10:
+		last = 2
+	default:
+		return f, fmt.Errorf("%w: %d bytes", ErrUID, len(uid))
+	}
+	switch {
+	case level > last:
+		return f, fmt.Errorf("%w: no level %d in %d bytes", ErrUID, level+1, len(uid))
+	case level < last:
+		f[0] = cascadeTag
+		copy(f[1:4], uid[3*level:])
+	default:
+		copy(f[:4], uid[3*level:])
+	}
+	f[4] = bcc(f[:4])
+	return f, nil
+}
+
+// nvb is the number of valid bits byte of an anticollision frame
+// sending the first known bits of the UID, the high nibble counts the
+// whole bytes with the select and the nvb, the low the bits left
+func nvb(known int) byte {
+	return byte((2+known/8)<<4 | known%8)
+}
+
+// UID is the unique identifier of a card, 4, 7 or 10 bytes
+type UID []byte
+
+// String returns the UID in hex, like "04a31b2c"
+func (u UID) String() string {
+	return hex.EncodeToString(u)
+}
+
+// ParseUID parses a UID in hex, bytes may be separated by ':' or '-'
+func ParseUID(s string) (UID, error) {
+	b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(s))
+	if err != nil || (len(b) != 4 && len(b) != 7 && len(b) != 10) {
+		return nil, fmt.Errorf("%w: %q", ErrUID, s)
+	}
+	return b, nil
+}
+
+// cardType names the card from the SAK of its last cascade level
+func cardType(sak byte) string {
+	switch sak &^ sakCascade {
+	case 0x00:
+		return "mifare_ultralight"
+	case 0x08:
+		return "mifare_classic_1k"
+	case 0x09:
+		return "mifare_mini"
+	case 0x18:
+		return "mifare_classic_4k"
+	case 0x10, 0x11:
+		return "mifare_plus"
+	case 0x20:
+		return "iso14443_4"
+	}
+	return ""
+}
@@ -0,0 +1,723 @@
+// Package rc522 reads the UID of MIFARE and other ISO 14443A cards
+// with an NXP MFRC522 reader on an SPI bus, like the RC522 boards.
+//
+// Every poll wakes the cards in the field, runs the anticollision
+// loop to select one and halts it again. A card put on the reader is
+// published as a "tag" event with its UID, and as "removed" once it
+// has not been read for the absence time. A card held on the reader
+// is a single tag event, and one taken off and put back within the
+// debounce time makes none.
+//
+// With an allow list every tag event is followed by an "authorized"
+// or a "denied" event, and an authorized card can pulse a relay in
+// the device manager, a door strike, without a round trip through
+// the broker. The allow list is saved in the device store.
+package rc522
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultSPI = "/dev/spidev0.0"
+
+	// SPIMode and SPIFreq are how the MFRC522 is read, it takes mode
+	// 0 up to 10MHz
+	SPIMode = 0
+	SPIFreq = 4000000
+
+	// DefaultDebounce is how long after its tag event a card makes
+	// no other
+	DefaultDebounce = 2 * time.Second
+
+	// DefaultAbsence is how long a card has to be unread to be
+	// removed, a few polls
+	DefaultAbsence = time.Second
+
+	// DefaultStrike is how long an authorized card pulses the strike
+	DefaultStrike = 3 * time.Second
+)
+
+// registers, the address is shifted left one bit on the bus with the
+// top bit set to read
+const (
+	regCommand    = 0x01
+	regComIrq     = 0x04
+	regError      = 0x06
+	regFIFOData   = 0x09
+	regFIFOLevel  = 0x0A
+	regBitFraming = 0x0D
+	regColl       = 0x0E
+	regMode       = 0x11
+	regTxMode     = 0x12
+	regRxMode     = 0x13
+	regTxControl  = 0x14
+	regTxASK      = 0x15
+	regModWidth   = 0x24
+	regTMode      = 0x2A
+	regTPrescaler = 0x2B
+	regTReloadH   = 0x2C
+	regTReloadL   = 0x2D
+	regVersion    = 0x37
+)
+
+// commands, the ComIrq bits and the Error bits
+const (
+	cmdIdle       = 0x00
+	cmdTransceive = 0x0C
+	cmdSoftReset  = 0x0F
+	powerDown     = 0x10
+
+	irqRx    = 0x20
+	irqIdle  = 0x10
+	irqTimer = 0x01
+
+	errColl     = 0x08
+	errProtocol = 0x13 // buffer overflow, parity and protocol errors
+
+	collPosNotValid = 0x20
+	startSend       = 0x80
+)
+
+var (
+	// irqTimeout bounds the wait for a command, the reader's own
+	// timer gives up on a silent card after 25ms
+	irqTimeout = 50 * time.Millisecond
+
+	// resetTimeout bounds the wait for the soft reset
+	resetTimeout = 50 * time.Millisecond
+)
+
+var (
+	ErrNoCard    = errors.New("no card answered")
+	ErrCollision = errors.New("iso14443a collision")
+	ErrProtocol  = errors.New("iso14443a protocol error")
+	ErrVersion   = errors.New("no mfrc522 found")
+	ErrTimeout   = errors.New("mfrc522 timeout")
+	ErrRelay     = errors.New("no relay to strike")
+	ErrCommand   = errors.New("unknown command")
+)
+
+// collision is the bit a collision was detected at, counted from 1
+// at the first UID bit of the cascade level
+type collision int
+
+func (c collision) Error() string {
+	return fmt.Sprintf("%s at bit %d", ErrCollision, int(c))
+}
+
+func (c collision) Is(target error) bool {
+	return target == ErrCollision
+}
+
+// Strike is a relay an authorized card pulses, relay.Relay is one
+type Strike interface {
+	On() error
+	Off() error
+}
+
+// Card is a card read, Type is named from the SAK of the well known
+// cards
+type Card struct {
+	UID  UID
+	SAK  byte
+	Type string
+}
+
+// Event is published when a card is put on the reader, "tag", taken
+// off it, "removed", and with an allow list, "authorized" or
+// "denied" after the tag event
+type Event struct {
+	Event string `json:"event"`
+	UID   string `json:"uid"`
+	Type  string `json:"type,omitempty"`
+}
+
+// RC522 is an MFRC522 reader on an SPI bus
+type RC522 struct {
+	*device.Device
+
+	spi    drivers.SPIConn
+	trk    *tracker
+	allow  map[string]bool
+	strike string
+	pulse  time.Duration
+	timer  *device.Timer
+	mock   *Card
+
+	mu sync.Mutex
+}
+
+// New opens the reader at the spidev device dev, like
+// /dev/spidev0.0, and initializes it
+func New(name, dev string) (*RC522, error) {
+	if device.IsMock() {
+		return NewWithSPI(name, nil), nil
+	}
+	spi, err := drivers.OpenSPI(dev, SPIMode, SPIFreq)
+	if err != nil {
+		return nil, err
+	}
+	r := NewWithSPI(name, spi)
+	if err := r.Init(); err != nil {
+		spi.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewWithSPI creates a reader on spi, opened in SPIMode, Init has to
+// be called before it reads. The allow list saved for name is
+// loaded.
+func NewWithSPI(name string, spi drivers.SPIConn) *RC522 {
+	r := &RC522{
+		Device: device.NewDevice(name, "mqtt"),
+		spi:    spi,
+		trk:    newTracker(DefaultDebounce, DefaultAbsence),
+		allow:  make(map[string]bool),
+	}
+	var uids []string
+	err := device.GetStore().Load(r.allowKey(), &uids)
+	if err != nil && !errors.Is(err, device.ErrNotStored) {
+		slog.Warn("rc522 loading the allow list", "device", name, "error", err)
+	}
+	for _, u := range uids {
+		r.allow[u] = true
+	}
+	return r
+}
+
+func (r *RC522) allowKey() string {
+	return r.Device.Name + "/allow"
+}
+
+// Name returns the name of the device
+func (r *RC522) Name() string {
+	return r.Device.Name
+}
+
+// Init resets the reader, sets its timer to give up on a silent card
+// after 25ms and turns the antenna on
+func (r *RC522) Init() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.spi == nil {
+		return nil
+	}
+	if err := r.write(regCommand, cmdSoftReset); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(resetTimeout); ; time.Sleep(time.Millisecond) {
+		v, err := r.readReg(regCommand)
+		if err != nil {
+			return err
+		}
+		if v&powerDown == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: soft reset", ErrTimeout)
+		}
+	}
+
+	v, err := r.readReg(regVersion)
+	if err != nil {
+		return err
+	}
+	switch v {
+	case 0x91, 0x92, 0x88, 0x12, 0xB2:
+	default:
+		return fmt.Errorf("%w: version %#02x", ErrVersion, v)
+	}
+
+	// 106kBd both ways, the timer at 40kHz starts when a frame is
+	// sent and runs out after 1000 ticks, 100% ASK and the CRC
+	// preset to 0x6363 of ISO 14443A
+	for _, w := range [][2]byte{
+		{regTxMode, 0x00},
+		{regRxMode, 0x00},
+		{regModWidth, 0x26},
+		{regTMode, 0x80},
+		{regTPrescaler, 0xA9},
+		{regTReloadH, 0x03},
+		{regTReloadL, 0xE8},
+		{regTxASK, 0x40},
+		{regMode, 0x3D},
+	} {
+		if err := r.write(w[0], w[1]); err != nil {
+			return err
+		}
+	}
+	return r.antenna(true)
+}
+
+// Antenna turns the field on or off, a reader with the antenna off
+// reads no card and draws less
+func (r *RC522) Antenna(on bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.spi == nil {
+		return nil
+	}
+	return r.antenna(on)
+}
+
+func (r *RC522) antenna(on bool) error {
+	v, err := r.readReg(regTxControl)
+	if err != nil {
+		return err
+	}
+	if on {
+		return r.write(regTxControl, v|0x03)
+	}
+	return r.write(regTxControl, v&^0x03)
+}
+
+// SetDebounce sets how long after its tag event a card makes no
+// other, and how long it has to be unread to be removed
+func (r *RC522) SetDebounce(debounce, absence time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trk.debounce, r.trk.absence = debounce, absence
+}
+
+// Allow adds uids to the allow list. With an allow list every card
+// put on the reader is authorized or denied.
+func (r *RC522) Allow(uids ...UID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range uids {
+		r.allow[u.String()] = true
+	}
+	return r.saveAllow()
+}
+
+// Revoke drops uid from the allow list, the last one dropped turns
+// the allow list off
+func (r *RC522) Revoke(uid UID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.allow, uid.String())
+	return r.saveAllow()
+}
+
+// Allowed returns the allow list
+func (r *RC522) Allowed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.allowed()
+}
+
+func (r *RC522) allowed() []string {
+	uids := make([]string, 0, len(r.allow))
+	for u := range r.allow {
+		uids = append(uids, u)
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+// saveAllow saves the allow list, r.mu is held
+func (r *RC522) saveAllow() error {
+	return device.GetStore().Save(r.allowKey(), r.allowed())
+}
+
+// SetStrike makes an authorized card turn on the relay added to the
+// device manager as name for d, "" turns it off
+func (r *RC522) SetStrike(name string, d time.Duration) error {
+	if name != "" {
+		if _, err := strike(name); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.strike, r.pulse = name, d
+	return nil
+}
+
+// strike returns the Strike added to the device manager as name
+func strike(name string) (Strike, error) {
+	d, ok := device.GetDeviceManager().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRelay, name)
+	}
+	s, ok := d.(Strike)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can not be switched", ErrRelay, name)
+	}
+	return s, nil
+}
+
+// Command handles a command payload: "allow 04a31b2c" and "revoke
+// 04a31b2c" change the allow list, "antenna on" and "antenna off"
+func (r *RC522) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(c) == 2 && (c[0] == "allow" || c[0] == "revoke"):
+		uid, err := ParseUID(c[1])
+		if err != nil {
+			return err
+		}
+		if c[0] == "allow" {
+			return r.Allow(uid)
+		}
+		return r.Revoke(uid)
+	case len(c) == 2 && c[0] == "antenna" && (c[1] == "on" || c[1] == "off"):
+		return r.Antenna(c[1] == "on")
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the card on the reader, nil for none. If the device is
+// mocked it returns the card of MockCard.
+func (r *RC522) Read() (*Card, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if device.IsMock() {
+		return r.mock, nil
+	}
+	if r.spi == nil {
+		return nil, errors.New("not initialized")
+	}
+
+	atqa, err := r.transceive([]byte{piccWUPA}, 7)
+	if errors.Is(err, ErrNoCard) {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, ErrCollision) {
+		// the ATQA of different cards collides, it is not used
+		return nil, err
+	}
+	if len(atqa) != 2 {
+		return nil, fmt.Errorf("%w: atqa of %d bytes", ErrFrame, len(atqa))
+	}
+
+	uid, sak, err := r.selectCard()
+	if err != nil {
+		return nil, err
+	}
+
+	// a halted card only answers a WUPA, a card left on the reader
+	// is woken by every poll. It does not answer the halt.
+	if _, err := r.transceive(withCRC([]byte{piccHLTA, 0}), 0); err != nil && !errors.Is(err, ErrNoCard) {
+		slog.Debug("rc522 halt", "device", r.Device.Name, "error", err)
+	}
+	return &Card{UID: uid, SAK: sak, Type: cardType(sak)}, nil
+}
+
+// selectCard selects a card through the cascade levels, r.mu is held
+func (r *RC522) selectCard() (UID, byte, error) {
+	// the bits received after a collision are cleared
+	if err := r.write(regColl, 0x00); err != nil {
+		return nil, 0, err
+	}
+	var uid UID
+	for _, sel := range cascade {
+		f, sak, err := r.selectLevel(sel)
+		if err != nil {
+			return nil, 0, err
+		}
+		if sak&sakCascade == 0 {
+			return append(uid, f[:4]...), sak, nil
+		}
+		if f[0] != cascadeTag {
+			return nil, 0, fmt.Errorf("%w: no cascade tag at level %#02x", ErrFrame, sel)
+		}
+		uid = append(uid, f[1:4]...)
+	}
+	return nil, 0, fmt.Errorf("%w: uid longer than 10 bytes", ErrFrame)
+}
+
+// selectLevel runs the anticollision loop of a cascade level and
+// selects the card found. On a collision the bits received up to it
+// are kept, the bit is taken as 1 and the frame sent again with the
+// bits known so far, only the cards matching them answer.
+func (r *RC522) selectLevel(sel byte) ([5]byte, byte, error) {
+	var f [5]byte
+	known := 0
+	for {
+		n, rem := known/8, known%8
+		tx := append([]byte{sel, nvb(known)}, f[:n]...)
+		if rem > 0 {
+			tx = append(tx, f[n])
+		}
+		// the first bit received goes after the bits sent of the
+		// last byte
+		rx, err := r.transceive(tx, byte(rem<<4|rem))
+		var coll collision
+		if err != nil && !errors.As(err, &coll) {
+			return f, 0, err
+		}
+		if n+len(rx) > len(f) || len(rx) == 0 {
+			return f, 0, fmt.Errorf("%w: %d uid bytes after %d bits", ErrFrame, len(rx), known)
+		}
+		mask := byte(1)<<rem - 1
+		f[n] = f[n]&mask | rx[0]&^mask
+		copy(f[n+1:], rx[1:])
+
+		if coll == 0 {
+			if n+len(rx) != len(f) {
+				return f, 0, fmt.Errorf("%w: %d uid bytes after %d bits", ErrFrame, len(rx), known)
+			}
+			break
+		}
+		if int(coll) <= known || coll > 32 {
+			return f, 0, coll
+		}
+		known = int(coll)
+		f[(known-1)/8] |= 1 << ((known - 1) % 8)
+	}
+	if b := bcc(f[:4]); b != f[4] {
+		return f, 0, fmt.Errorf("%w: got %#02x want %#02x", ErrBCC, f[4], b)
+	}
+
+	rx, err := r.transceive(withCRC(append([]byte{sel, nvbSelect}, f[:]...)), 0)
+	if err != nil {
+		return f, 0, err
+	}
+	sak, err := checkCRC(rx)
+	if err != nil {
+		return f, 0, err
+	}
+	if len(sak) != 1 {
+		return f, 0, fmt.Errorf("%w: sak of %d bytes", ErrFrame, len(sak))
+	}
+	return f, sak[0], nil
+}
+
+// transceive sends tx, the low 3 bits of framing are the bits sent
+// of the last byte, 0 for all 8, and the next 3 where the first bit
+// received goes. It returns what came back, with a collision error
+// when the cards answered different bits. r.mu is held.
+func (r *RC522) transceive(tx []byte, framing byte) ([]byte, error) {
+	for _, w := range [][]byte{
+		{regCommand, cmdIdle},
+		{regComIrq, 0x7F},
+		{regFIFOLevel, 0x80},
+		append([]byte{regFIFOData}, tx...),
+		{regBitFraming, framing},
+		{regCommand, cmdTransceive},
+		{regBitFraming, framing | startSend},
+	} {
+		if err := r.write(w[0], w[1:]...); err != nil {
+			return nil, err
+		}
+	}
+
+	for deadline := time.Now().Add(irqTimeout); ; {
+		irq, err := r.readReg(regComIrq)
+		if err != nil {
+			return nil, err
+		}
+		if irq&(irqRx|irqIdle) != 0 {
+			break
+		}
+		if irq&irqTimer != 0 {
+			return nil, ErrNoCard
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: transceive", ErrTimeout)
+		}
+	}
+
+	e, err := r.readReg(regError)
+	if err != nil {
+		return nil, err
+	}
+	if e&errProtocol != 0 {
+		return nil, fmt.Errorf("%w: error register %#02x", ErrProtocol, e)
+	}
+	n, err := r.readReg(regFIFOLevel)
+	if err != nil {
+		return nil, err
+	}
+	var rx []byte
+	if n &= 0x7F; n > 0 {
+		if rx, err = r.read(regFIFOData, int(n)); err != nil {
+			return nil, err
+		}
+	}
+	if e&errColl == 0 {
+		return rx, nil
+	}
+	c, err := r.readReg(regColl)
+	if err != nil {
+		return nil, err
+	}
+	if c&collPosNotValid != 0 {
+		return rx, fmt.Errorf("%w: position not valid", ErrCollision)
+	}
+	pos := int(c & 0x1F)
+	if pos == 0 {
+		pos = 32
+	}
+	return rx, collision(pos)
+}
+
+// write writes vals to reg, the FIFO data register takes them all
+func (r *RC522) write(reg byte, vals ...byte) error {
+	return r.spi.Tx(append([]byte{reg << 1 & 0x7E}, vals...), nil)
+}
+
+// read reads reg n times, the address of the next read goes out with
+// every byte read
+func (r *RC522) read(reg byte, n int) ([]byte, error) {
+	w := make([]byte, n+1)
+	for i := 0; i < n; i++ {
+		w[i] = 0x80 | reg<<1&0x7E
+	}
+	buf := make([]byte, n+1)
+	if err := r.spi.Tx(w, buf); err != nil {
+		return nil, err
+	}
+	return buf[1:], nil
+}
+
+func (r *RC522) readReg(reg byte) (byte, error) {
+	v, err := r.read(reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// ReadPub polls for a card and publishes the tag and removed events,
+// and with an allow list whether the card is authorized. A card that
+// could not be read is logged and changes nothing.
+func (r *RC522) ReadPub() error {
+	card, err := r.Read()
+	if err != nil {
+		for _, e := range []error{ErrFrame, ErrCRC, ErrBCC, ErrCollision, ErrProtocol} {
+			if errors.Is(err, e) {
+				slog.Debug("rc522 read", "device", r.Device.Name, "error", err)
+				return nil
+			}
+		}
+		return err
+	}
+
+	var pubs []*Event
+	var pulse bool
+	r.mu.Lock()
+	at := r.Now()
+	var evts []tagEvent
+	if card != nil {
+		evts = r.trk.read(card.UID.String(), at)
+	} else {
+		evts = r.trk.none(at)
+	}
+	for _, e := range evts {
+		pubs = append(pubs, &Event{Event: e.kind, UID: e.uid})
+		if e.kind != "tag" {
+			continue
+		}
+		pubs[len(pubs)-1].Type = card.Type
+		if len(r.allow) == 0 {
+			continue
+		}
+		access := &Event{Event: "denied", UID: e.uid}
+		if r.allow[e.uid] {
+			access.Event, pulse = "authorized", r.strike != ""
+		}
+		pubs = append(pubs, access)
+	}
+	r.mu.Unlock()
+
+	for _, e := range pubs {
+		if e.Event == "denied" {
+			slog.Warn("rc522 card denied", "device", r.Device.Name, "uid", e.UID)
+		}
+		if err := r.publish(e); err != nil {
+			return err
+		}
+	}
+	if pulse {
+		return r.strikeDoor()
+	}
+	return nil
+}
+
+// strikeDoor turns the strike on and off again after the pulse, a
+// card read during the pulse makes it longer
+func (r *RC522) strikeDoor() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, err := strike(r.strike)
+	if err != nil {
+		return err
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if err := s.On(); err != nil {
+		return err
+	}
+	d := r.pulse
+	if d <= 0 {
+		d = DefaultStrike
+	}
+	r.timer = r.Clock().AfterFunc(d, func() {
+		if err := s.Off(); err != nil {
+			slog.Error("rc522 strike", "device", r.Device.Name, "error", err)
+		}
+	})
+	return nil
+}
+
+// Run polls for a card every period until ctx is canceled
+func (r *RC522) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("rc522 stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close turns the antenna off and releases the bus, a strike pulsing
+// is turned off
+func (r *RC522) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil && r.timer.Stop() {
+		if s, err := strike(r.strike); err == nil {
+			s.Off()
+		}
+	}
+	r.timer = nil
+	if r.spi == nil {
+		return nil
+	}
+	err := errors.Join(r.antenna(false), r.spi.Close())
+	r.spi = nil
+	return err
+}
+
+// MockCard puts a card with uid on the reader in mock mode, nil takes
+// it off
+func (r *RC522) MockCard(uid UID, sak byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mock = nil
+	if uid != nil {
+		r.mock = &Card{UID: uid, SAK: sak, Type: cardType(sak)}
+	}
+}
+
+func (r *RC522) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
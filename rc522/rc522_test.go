@@ -0,0 +1,597 @@
+package rc522
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+func TestCRCA(t *testing.T) {
+	crcs := []struct {
+		data []byte
+		want uint16
+	}{
+		{[]byte{0x00, 0x00}, 0x1EA0}, // ISO 14443-3 annex B
+		{[]byte{0x12, 0x34}, 0xCF26},
+		{[]byte{piccHLTA, 0x00}, 0xCD57},
+	}
+	for _, c := range crcs {
+		if got := crcA(c.data); got != c.want {
+			t.Errorf("crcA(% x) got (%#04x) want (%#04x)", c.data, got, c.want)
+		}
+	}
+	if got := withCRC([]byte{piccHLTA, 0x00}); !bytes.Equal(got, []byte{0x50, 0x00, 0x57, 0xCD}) {
+		t.Errorf("withCRC(hlta) got (% x) want (50 00 57 cd)", got)
+	}
+	if got, err := checkCRC([]byte{0x08, 0xB6, 0xDD}); err != nil || !bytes.Equal(got, []byte{0x08}) {
+		t.Errorf("checkCRC(sak) got (% x, %v) want (08)", got, err)
+	}
+	if _, err := checkCRC([]byte{0x08, 0xB6, 0xDE}); !errors.Is(err, ErrCRC) {
+		t.Errorf("checkCRC(bad) error got (%v) want (%v)", err, ErrCRC)
+	}
+	if _, err := checkCRC([]byte{0x08}); !errors.Is(err, ErrFrame) {
+		t.Errorf("checkCRC(short) error got (%v) want (%v)", err, ErrFrame)
+	}
+}
+
+func TestFraming(t *testing.T) {
+	uid := UID{0x04, 0x52, 0x8A, 0x1A, 0x3C, 0x5E, 0x80}
+	f, err := levelFrame(uid, 0)
+	if want := [5]byte{0x88, 0x04, 0x52, 0x8A, 0x88 ^ 0x04 ^ 0x52 ^ 0x8A}; err != nil || f != want {
+		t.Errorf("levelFrame(0) got (% x, %v) want (% x)", f, err, want)
+	}
+	f, err = levelFrame(uid, 1)
+	if want := [5]byte{0x1A, 0x3C, 0x5E, 0x80, 0x1A ^ 0x3C ^ 0x5E ^ 0x80}; err != nil || f != want {
+		t.Errorf("levelFrame(1) got (% x, %v) want (% x)", f, err, want)
+	}
+	if _, err := levelFrame(uid, 2); !errors.Is(err, ErrUID) {
+		t.Errorf("levelFrame(2) of 7 bytes error got (%v) want (%v)", err, ErrUID)
+	}
+
+	for known, want := range map[int]byte{0: 0x20, 4: 0x24, 8: 0x30, 13: 0x35, 32: 0x60} {
+		if got := nvb(known); got != want {
+			t.Errorf("nvb(%d) got (%#02x) want (%#02x)", known, got, want)
+		}
+	}
+
+	if u, err := ParseUID("04:52:8a:1a:3c:5e:80"); err != nil || u.String() != "04528a1a3c5e80" {
+		t.Errorf("ParseUID() got (%v, %v)", u, err)
+	}
+	if _, err := ParseUID("0452"); !errors.Is(err, ErrUID) {
+		t.Errorf("ParseUID(2 bytes) error got (%v) want (%v)", err, ErrUID)
+	}
+	if cardType(0x08) != "mifare_classic_1k" || cardType(0x00) != "mifare_ultralight" || cardType(0x42) != "" {
+		t.Error("cardType() got the wrong card")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	t0 := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	trk := newTracker(2*time.Second, time.Second)
+
+	steps := []struct {
+		uid  string // "" for no card read
+		ms   int
+		want string
+	}{
+		{"a", 0, "tag a"},
+		{"a", 200, ""},
+		{"", 400, ""}, // a poll missed it
+		{"a", 600, ""},
+		{"", 1400, ""},
+		{"", 1600, "removed a"},
+		{"a", 1800, ""}, // back within the debounce
+		{"", 3000, ""},
+		{"b", 3200, "tag b"}, // a silent card gives way
+		{"a", 4200, "removed b,tag a"},
+		{"", 5400, "removed a"},
+	}
+	for i, s := range steps {
+		var evts []tagEvent
+		if s.uid == "" {
+			evts = trk.none(at(s.ms))
+		} else {
+			evts = trk.read(s.uid, at(s.ms))
+		}
+		got := ""
+		for j, e := range evts {
+			if j > 0 {
+				got += ","
+			}
+			got += e.kind + " " + e.uid
+		}
+		if got != s.want {
+			t.Errorf("step %d got (%q) want (%q)", i, got, s.want)
+		}
+	}
+}
+
+// simCard is a card in the field of the simulated reader
+type simCard struct {
+	uid    UID
+	sak    byte
+	state  string // "idle", "ready", "active" or "halt"
+	level  int
+	levels int
+}
+
+func (c *simCard) frame() [5]byte {
+	f, _ := levelFrame(c.uid, c.level)
+	return f
+}
+
+// simRC522 is an MFRC522 at the register level, the cards in its
+// field answer the frames sent the way ISO 14443A cards do
+type simRC522 struct {
+	regs    [64]byte
+	fifo    []byte
+	cards   []*simCard
+	corrupt int      // the select answers to corrupt
+	sent    [][]byte // the frames sent to the cards
+	closed  bool
+	mu      sync.Mutex
+}
+
+func newSim() *simRC522 {
+	s := &simRC522{}
+	s.regs[regVersion] = 0x92
+	s.regs[regCommand] = powerDown
+	return s
+}
+
+func (s *simRC522) put(uid UID, sak byte) *simCard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &simCard{uid: uid, sak: sak, state: "idle", levels: (len(uid) - 1) / 3}
+	s.cards = append(s.cards, c)
+	return c
+}
+
+func (s *simRC522) take(c *simCard) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.cards {
+		if v == c {
+			s.cards = append(s.cards[:i], s.cards[i+1:]...)
+		}
+	}
+}
+
+func (s *simRC522) Tx(w, r []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("closed")
+	}
+	if w[0]&0x80 != 0 {
+		for i := 0; i < len(w)-1; i++ {
+			r[i+1] = s.read(w[i] >> 1 & 0x3F)
+		}
+		return nil
+	}
+	for _, v := range w[1:] {
+		s.write(w[0]>>1&0x3F, v)
+	}
+	return nil
+}
+
+func (s *simRC522) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *simRC522) read(reg byte) byte {
+	switch reg {
+	case regFIFOData:
+		if len(s.fifo) == 0 {
+			return 0
+		}
+		v := s.fifo[0]
+		s.fifo = s.fifo[1:]
+		return v
+	case regFIFOLevel:
+		return byte(len(s.fifo))
+	}
+	return s.regs[reg]
+}
+
+func (s *simRC522) write(reg, v byte) {
+	switch reg {
+	case regCommand:
+		if v == cmdSoftReset {
+			version := s.regs[regVersion]
+			s.regs = [64]byte{}
+			s.regs[regVersion] = version
+			s.fifo = nil
+			return
+		}
+		s.regs[reg] = v
+	case regComIrq:
+		if v&0x80 == 0 {
+			s.regs[reg] &^= v
+		} else {
+			s.regs[reg] |= v & 0x7F
+		}
+	case regFIFOLevel:
+		if v&0x80 != 0 {
+			s.fifo = nil
+		}
+	case regFIFOData:
+		s.fifo = append(s.fifo, v)
+	case regBitFraming:
+		s.regs[reg] = v &^ startSend
+		if v&startSend != 0 && s.regs[regCommand] == cmdTransceive {
+			s.transmit(int(v & 7))
+		}
+	default:
+		s.regs[reg] = v
+	}
+}
+
+func (s *simRC522) respond(data []byte) {
+	s.fifo = data
+	s.regs[regComIrq] |= irqRx | irqIdle
+}
+
+// transmit sends the FIFO to the cards with txLast bits of the last
+// byte
+func (s *simRC522) transmit(txLast int) {
+	data := s.fifo
+	s.fifo = nil
+	s.sent = append(s.sent, data)
+	s.regs[regError] = 0
+	if s.regs[regTxControl]&0x03 != 0x03 {
+		s.regs[regComIrq] |= irqTimer
+		return
+	}
+
+	switch {
+	case txLast == 7 && len(data) == 1 && (data[0] == piccWUPA || data[0] == piccREQA):
+		// the cards left ready by the last anticollision went idle
+		// with the frames that were not for them
+		var atqa []byte
+		for _, c := range s.cards {
+			if c.state == "idle" || c.state == "ready" || (c.state == "halt" && data[0] == piccWUPA) {
+				c.state, c.level = "ready", 0
+				atqa = []byte{0x04 | byte(c.levels-1)<<6, 0x00}
+			}
+		}
+		if atqa == nil {
+			break
+		}
+		s.respond(atqa)
+		return
+	case len(data) >= 2 && bytes.IndexByte(cascade, data[0]) >= 0:
+		if s.sel(bytes.IndexByte(cascade, data[0]), data) {
+			return
+		}
+	case len(data) == 4 && data[0] == piccHLTA:
+		for _, c := range s.cards {
+			if c.state == "active" {
+				c.state = "halt"
+			}
+		}
+	}
+	s.regs[regComIrq] |= irqTimer
+}
+
+func bit(f [5]byte, i int) byte {
+	return f[i/8] >> (i % 8) & 1
+}
+
+// sel handles a select, or an anticollision frame, of level
+func (s *simRC522) sel(level int, data []byte) bool {
+	var ready []*simCard
+	for _, c := range s.cards {
+		if c.state == "ready" && c.level == level {
+			ready = append(ready, c)
+		}
+	}
+
+	if data[1] == nvbSelect {
+		if _, err := checkCRC(data); err != nil || len(data) != 9 {
+			return false
+		}
+		for _, c := range ready {
+			if f := c.frame(); bytes.Equal(f[:], data[2:7]) {
+				sak := byte(sakCascade)
+				if c.level++; c.level == c.levels {
+					sak, c.state = c.sak, "active"
+				}
+				rx := withCRC([]byte{sak})
+				if s.corrupt > 0 {
+					s.corrupt--
+					rx[1] ^= 0x01
+				}
+				s.respond(rx)
+				return true
+			}
+		}
+		return false
+	}
+
+	known := int(data[1]>>4-2)*8 + int(data[1]&0x0F)
+	var sent [5]byte
+	copy(sent[:], data[2:])
+	var match []*simCard
+	for _, c := range ready {
+		f, ok := c.frame(), true
+		for i := 0; i < known; i++ {
+			ok = ok && bit(f, i) == bit(sent, i)
+		}
+		if ok {
+			match = append(match, c)
+		}
+	}
+	if len(match) == 0 {
+		return false
+	}
+
+	// the answers are wired or, the first bit they differ at is
+	// the collision
+	var rx [5]byte
+	coll := 0
+	for i := known; i < 40; i++ {
+		var or, and byte = 0, 1
+		for _, c := range match {
+			or |= bit(c.frame(), i)
+			and &= bit(c.frame(), i)
+		}
+		rx[i/8] |= or << (i % 8)
+		if or != and && coll == 0 {
+			coll = i + 1
+		}
+	}
+	if coll != 0 {
+		s.regs[regError] = errColl
+		s.regs[regColl] = byte(coll % 32)
+		if coll > 32 {
+			s.regs[regColl] = collPosNotValid
+		}
+	}
+	s.respond(append([]byte(nil), rx[known/8:]...))
+	return true
+}
+
+func newReader(t *testing.T) (*RC522, *simRC522) {
+	device.Mock(false)
+	sim := newSim()
+	restore := drivers.SetSPIProvider(func(dev string, mode, hz int) (drivers.SPIConn, error) {
+		if mode != SPIMode {
+			t.Errorf("OpenSPI() mode got (%d) want (%d)", mode, SPIMode)
+		}
+		return sim, nil
+	})
+	t.Cleanup(restore)
+	r, err := New("door", DefaultSPI)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return r, sim
+}
+
+func TestInit(t *testing.T) {
+	r, sim := newReader(t)
+	defer r.Close()
+	if sim.sent != nil || sim.regs[regTxControl]&0x03 != 0x03 || sim.regs[regTReloadL] != 0xE8 || sim.regs[regMode] != 0x3D {
+		t.Errorf("Init() left the registers (% x)", sim.regs[:0x30])
+	}
+	if err := r.Command([]byte("antenna off")); err != nil || sim.regs[regTxControl]&0x03 != 0 {
+		t.Errorf("Command(antenna off) got (%v) tx control (%#02x)", err, sim.regs[regTxControl])
+	}
+	sim.put(UID{0xDE, 0xAD, 0xBE, 0xEF}, 0x08)
+	if c, err := r.Read(); c != nil || err != nil {
+		t.Errorf("Read() with the antenna off got (%v, %v)", c, err)
+	}
+	r.Close()
+	if !sim.closed || sim.regs[regTxControl]&0x03 != 0 {
+		t.Error("Close() did not turn the antenna off and close the bus")
+	}
+
+	fake := newSim()
+	fake.regs[regVersion] = 0xFF
+	if err := NewWithSPI("none", fake).Init(); !errors.Is(err, ErrVersion) {
+		t.Errorf("Init(version 0xff) error got (%v) want (%v)", err, ErrVersion)
+	}
+}
+
+func TestRead(t *testing.T) {
+	r, sim := newReader(t)
+	defer r.Close()
+
+	if c, err := r.Read(); c != nil || err != nil {
+		t.Errorf("Read() of no card got (%v, %v)", c, err)
+	}
+
+	classic := sim.put(UID{0xDE, 0xAD, 0xBE, 0xEF}, 0x08)
+	sim.sent = nil
+	c, err := r.Read()
+	if err != nil || c == nil || c.UID.String() != "deadbeef" || c.Type != "mifare_classic_1k" {
+		t.Fatalf("Read() got (%+v, %v) want deadbeef", c, err)
+	}
+	want := [][]byte{
+		{piccWUPA},
+		{piccSEL1, 0x20},
+		withCRC([]byte{piccSEL1, 0x70, 0xDE, 0xAD, 0xBE, 0xEF, 0xDE ^ 0xAD ^ 0xBE ^ 0xEF}),
+		{piccHLTA, 0x00, 0x57, 0xCD},
+	}
+	if len(sim.sent) != len(want) {
+		t.Fatalf("frames sent got (% x) want (% x)", sim.sent, want)
+	}
+	for i := range want {
+		if !bytes.Equal(sim.sent[i], want[i]) {
+			t.Errorf("frame %d got (% x) want (% x)", i, sim.sent[i], want[i])
+		}
+	}
+	if classic.state != "halt" {
+		t.Errorf("card state got (%s) want (halt)", classic.state)
+	}
+	// the halted card is woken again
+	if c, err := r.Read(); err != nil || c == nil {
+		t.Errorf("Read() again got (%v, %v)", c, err)
+	}
+	sim.take(classic)
+
+	// two levels
+	ul := sim.put(UID{0x04, 0x52, 0x8A, 0x1A, 0x3C, 0x5E, 0x80}, 0x00)
+	if c, err := r.Read(); err != nil || c.UID.String() != "04528a1a3c5e80" || c.Type != "mifare_ultralight" {
+		t.Errorf("Read(7 byte uid) got (%+v, %v)", c, err)
+	}
+	sim.take(ul)
+
+	// the SAK garbled
+	sim.put(UID{0x01, 0x02, 0x03, 0x04}, 0x08)
+	sim.corrupt = 1
+	if _, err := r.Read(); !errors.Is(err, ErrCRC) {
+		t.Errorf("Read(bad crc) error got (%v) want (%v)", err, ErrCRC)
+	}
+}
+
+func TestAnticollision(t *testing.T) {
+	r, sim := newReader(t)
+	defer r.Close()
+
+	// they differ from bit 3, then in the last bit
+	a := sim.put(UID{0x01, 0x02, 0x03, 0x04}, 0x08)
+	b := sim.put(UID{0x09, 0x02, 0x03, 0x84}, 0x08)
+	c := sim.put(UID{0x09, 0x02, 0x03, 0x04}, 0x08)
+	sim.sent = nil
+	for _, want := range []*simCard{b, c, a} {
+		got, err := r.Read()
+		if err != nil || got == nil || got.UID.String() != want.uid.String() {
+			t.Fatalf("Read() got (%+v, %v) want (%s)", got, err, want.uid)
+		}
+		sim.take(want)
+	}
+	// the second anticollision frame sends 4 bits, with bit 3 set
+	if f := sim.sent[2]; !bytes.Equal(f, []byte{piccSEL1, 0x24, 0x09}) {
+		t.Errorf("anticollision frame got (% x) want (93 24 09)", f)
+	}
+}
+
+// strikeRelay is a door strike relay
+type strikeRelay struct {
+	on  chan bool
+	err error
+}
+
+func (s *strikeRelay) Name() string {
+	return "strike"
+}
+
+func (s *strikeRelay) On() error {
+	s.on <- true
+	return s.err
+}
+
+func (s *strikeRelay) Off() error {
+	s.on <- false
+	return s.err
+}
+
+func TestReadPub(t *testing.T) {
+	device.SetStore(device.NewFileStore(t.TempDir()))
+	defer device.SetStore(device.NewMemStore())
+	r, sim := newReader(t)
+	defer r.Close()
+	clock := device.NewSimClock(time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC))
+	r.SetClock(clock)
+	poll := func() {
+		clock.Advance(200 * time.Millisecond)
+		if err := r.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() error = %v", err)
+		}
+	}
+
+	if err := r.SetStrike("strike", time.Millisecond); !errors.Is(err, ErrRelay) {
+		t.Errorf("SetStrike(missing) error got (%v) want (%v)", err, ErrRelay)
+	}
+	s := &strikeRelay{on: make(chan bool, 4)}
+	dm := device.GetDeviceManager()
+	dm.Add(s)
+	defer dm.Remove("strike")
+	if err := r.SetStrike("strike", 10*time.Millisecond); err != nil {
+		t.Fatalf("SetStrike() error = %v", err)
+	}
+	if err := r.Command([]byte("allow DE:AD:BE:EF")); err != nil {
+		t.Fatalf("Command(allow) error = %v", err)
+	}
+
+	// a card held for a while strikes once, for the pulse
+	card := sim.put(UID{0xDE, 0xAD, 0xBE, 0xEF}, 0x08)
+	for i := 0; i < 10 && len(s.on) == 0; i++ {
+		if err := r.ReadPub(); err != nil {
+			t.Fatalf("ReadPub() error = %v", err)
+		}
+		if len(s.on) == 0 {
+			clock.Advance(200 * time.Millisecond)
+		}
+	}
+	if on := <-s.on; !on {
+		t.Fatalf("strike got (false) want (true)")
+	}
+	clock.Advance(9 * time.Millisecond)
+	if len(s.on) != 0 {
+		t.Errorf("strike released within the pulse")
+	}
+	clock.Advance(time.Millisecond)
+	if len(s.on) != 1 || <-s.on {
+		t.Errorf("strike not released after the pulse")
+	}
+	for i := 0; i < 10; i++ {
+		poll()
+	}
+	if len(s.on) != 0 {
+		t.Errorf("strike got (%t) again", <-s.on)
+	}
+
+	// a denied card does not
+	sim.take(card)
+	for i := 0; i < 6; i++ {
+		poll()
+	}
+	sim.put(UID{0x01, 0x02, 0x03, 0x04}, 0x08)
+	poll()
+	poll()
+	if len(s.on) != 0 {
+		t.Errorf("strike got (%t) for a denied card", <-s.on)
+	}
+
+	// the allow list is saved
+	r2 := NewWithSPI("door", nil)
+	if got := r2.Allowed(); len(got) != 1 || got[0] != "deadbeef" {
+		t.Errorf("Allowed() got (%v) want [deadbeef]", got)
+	}
+	if err := r.Command([]byte("revoke deadbeef")); err != nil || len(r.Allowed()) != 0 {
+		t.Errorf("Command(revoke) got (%v, %v)", err, r.Allowed())
+	}
+	if err := r.Command([]byte("allow dead")); !errors.Is(err, ErrUID) {
+		t.Errorf("Command(allow short) error got (%v) want (%v)", err, ErrUID)
+	}
+	if err := r.Command([]byte("open")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(open) error got (%v) want (%v)", err, ErrCommand)
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	r, err := New("door", DefaultSPI)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.MockCard(UID{0xDE, 0xAD, 0xBE, 0xEF}, 0x08)
+	if c, err := r.Read(); err != nil || c == nil || c.UID.String() != "deadbeef" {
+		t.Errorf("Read() got (%v, %v) want deadbeef", c, err)
+	}
+	r.MockCard(nil, 0)
+	if c, err := r.Read(); err != nil || c != nil {
+		t.Errorf("Read() got (%v, %v) want none", c, err)
+	}
+}
@@ -0,0 +1,70 @@
+package rc522
+
+import "time"
+
+// tracker turns the cards read by the polls into events. A card read
+// is "tag" when it was not on the reader, and "removed" once it has
+// not been read for the absence time, a card held on the reader, or
+// missed by a poll now and then, is a single tag. A card put back
+// within the debounce time of its last tag event comes and goes
+// without events.
+type tracker struct {
+	debounce time.Duration
+	absence  time.Duration
+
+	uid       string // the card on the reader, "" for none
+	announced bool   // its tag event was published
+	seen      time.Time
+	tagged    map[string]time.Time // the last tag event of a card
+}
+
+// tagEvent is what the tracker found
+type tagEvent struct {
+	kind string // "tag" or "removed"
+	uid  string
+}
+
+func newTracker(debounce, absence time.Duration) *tracker {
+	return &tracker{debounce: debounce, absence: absence, tagged: make(map[string]time.Time)}
+}
+
+// read takes the card uid read at at
+func (t *tracker) read(uid string, at time.Time) []tagEvent {
+	if uid == t.uid {
+		t.seen = at
+		return nil
+	}
+	// another card took its place
+	evts := t.remove()
+
+	for u, tagged := range t.tagged {
+		if at.Sub(tagged) >= t.debounce {
+			delete(t.tagged, u)
+		}
+	}
+	t.uid, t.seen = uid, at
+	_, recent := t.tagged[uid]
+	t.announced = !recent
+	if t.announced {
+		t.tagged[uid] = at
+		evts = append(evts, tagEvent{kind: "tag", uid: uid})
+	}
+	return evts
+}
+
+// none takes a poll at at that read no card
+func (t *tracker) none(at time.Time) []tagEvent {
+	if t.uid == "" || at.Sub(t.seen) < t.absence {
+		return nil
+	}
+	return t.remove()
+}
+
+func (t *tracker) remove() []tagEvent {
+	uid, announced := t.uid, t.announced
+	t.uid, t.announced = "", false
+	if uid == "" || !announced {
+		return nil
+	}
+	return []tagEvent{{kind: "removed", uid: uid}}
+}
@@ -0,0 +1,248 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReplayDone is the error of the reads of a Replay past its last
+// sample unless it loops
+var ErrReplayDone = errors.New("replay done")
+
+// Sample is a reading of a device as it is recorded, a line of a
+// recording. Data is the value read as JSON, the type of its package.
+type Sample struct {
+	TS     time.Time       `json:"ts"`
+	Device string          `json:"device"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Recorder appends the readings of the devices recording on it to a
+// file of JSON lines, one Sample each, to be replayed in the tests
+// with a Replay. Several devices may record on the same one.
+type Recorder struct {
+	w     io.Writer
+	close func() error
+	mu    sync.Mutex
+}
+
+// NewRecorder creates a Recorder writing on w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, close: func() error { return nil }}
+}
+
+// CreateRecorder creates a Recorder appending to the file at path,
+// created if it does not exist
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("recording: %w", err)
+	}
+	return &Recorder{w: f, close: f.Close}, nil
+}
+
+// Record appends the value read by the device named name
+func (r *Recorder) Record(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(Sample{TS: time.Now(), Device: name, Data: data})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file of the Recorder
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.close()
+}
+
+// Replay is a recording a device reads its values from, see
+// SetReplay. It replays a sample per read, or with ReplayTiming the
+// reads wait for the time between the samples as they were recorded.
+// Past the last one it starts over with ReplayLoop, the reads fail
+// with ErrReplayDone otherwise.
+type Replay struct {
+	samples []Sample
+	timing  bool
+	loop    bool
+	name    string
+
+	next int
+	last time.Time // when the last sample was replayed
+	mu   sync.Mutex
+}
+
+// ReplayOption configures a Replay
+type ReplayOption func(*Replay)
+
+// ReplayTiming has the reads wait for the time between the samples
+// as they were recorded
+func ReplayTiming(on bool) ReplayOption {
+	return func(r *Replay) {
+		r.timing = on
+	}
+}
+
+// ReplayLoop has the Replay start over past its last sample
+func ReplayLoop(on bool) ReplayOption {
+	return func(r *Replay) {
+		r.loop = on
+	}
+}
+
+// ReplayDevice replays the samples of the device named name, of a
+// recording of several
+func ReplayDevice(name string) ReplayOption {
+	return func(r *Replay) {
+		r.name = name
+	}
+}
+
+// NewReplay creates a Replay of the recording read from rd
+func NewReplay(rd io.Reader, opts ...ReplayOption) (*Replay, error) {
+	r := &Replay{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	dec := json.NewDecoder(rd)
+	for {
+		var s Sample
+		err := dec.Decode(&s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("recording sample %d: %w", len(r.samples)+1, err)
+		}
+		if r.name == "" || s.Device == r.name {
+			r.samples = append(r.samples, s)
+		}
+	}
+	return r, nil
+}
+
+// OpenReplay creates a Replay of the recording in the file at path
+func OpenReplay(path string, opts ...ReplayOption) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("recording: %w", err)
+	}
+	defer f.Close()
+	return NewReplay(f, opts...)
+}
+
+// Len returns the number of samples replayed
+func (r *Replay) Len() int {
+	return len(r.samples)
+}
+
+func (r *Replay) mockPosition() (pos, length int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next, len(r.samples)
+}
+
+// Next returns the data of the next sample, once the time since the
+// last one passed with ReplayTiming
+func (r *Replay) Next() (json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == len(r.samples) {
+		if !r.loop || r.next == 0 {
+			return nil, ErrReplayDone
+		}
+		r.next = 0
+		r.last = time.Time{}
+	}
+	s := r.samples[r.next]
+	if r.timing && r.next > 0 && !r.last.IsZero() {
+		gap := s.TS.Sub(r.samples[r.next-1].TS)
+		time.Sleep(time.Until(r.last.Add(gap)))
+	}
+	r.next++
+	r.last = time.Now()
+	return s.Data, nil
+}
+
+// SetRecorder has the device record its readings on r, nil stops it
+func (d *Device) SetRecorder(r *Recorder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recorder = r
+}
+
+// RecordRead records the value the device read when it is recording,
+// its package calls it with every reading
+func (d *Device) RecordRead(v any) {
+	d.mu.Lock()
+	r := d.recorder
+	d.mu.Unlock()
+	if r == nil {
+		return
+	}
+	if err := r.Record(d.Name, v); err != nil {
+		slog.Error("recording", "device", d.Name, "error", err)
+	}
+}
+
+// NextValue returns the data of the next sample, whatever the device
+func (r *Replay) NextValue(string) (any, error) {
+	data, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SetReplay has the device read the samples of r, its mock sequence
+// first when it has one, nil stops it. NextMock returns them as the
+// json.RawMessage recorded, MockValue decodes them.
+func (d *Device) SetReplay(r *Replay) {
+	if r == nil {
+		d.SetMockSource(nil)
+		return
+	}
+	d.SetMockSource(r)
+}
+
+// MockValue returns the value of the type of a package read from
+// NextMock, one of its type or a pointer to it, recorded, or the
+// fields of a CSVSource by their names
+func MockValue[T any](v any) (*T, error) {
+	switch val := v.(type) {
+	case T:
+		return &val, nil
+	case *T:
+		c := *val
+		return &c, nil
+	case map[string]float64:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("mock value: %w", err)
+		}
+		v = json.RawMessage(data)
+	}
+	if data, ok := v.(json.RawMessage); ok {
+		var t T
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("mock value: %w", err)
+		}
+		return &t, nil
+	}
+	var t T
+	return nil, fmt.Errorf("mock value %T, want %T", v, t)
+}
@@ -0,0 +1,113 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type level struct {
+	Cm float64 `json:"cm"`
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := CreateRecorder(path)
+	if err != nil {
+		t.Fatalf("CreateRecorder() error (%v)", err)
+	}
+	tank, pump := NewDevice("tank", "mqtt"), NewDevice("pump", "mqtt")
+	tank.SetRecorder(rec)
+	pump.SetRecorder(rec)
+	for _, cm := range []float64{10, 12, 15} {
+		tank.RecordRead(level{cm})
+		pump.RecordRead(cm > 11)
+	}
+	tank.SetRecorder(nil)
+	tank.RecordRead(level{99})
+	rec.Close()
+
+	// the samples of the tank, once
+	replay, err := OpenReplay(path, ReplayDevice("tank"))
+	if err != nil {
+		t.Fatalf("OpenReplay() error (%v)", err)
+	}
+	if replay.Len() != 3 {
+		t.Fatalf("Len() got %d want 3", replay.Len())
+	}
+	d := NewDevice("test", "mqtt")
+	d.SetReplay(replay)
+	for _, want := range []float64{10, 12, 15} {
+		v, ok, err := d.NextMock()
+		if !ok || err != nil {
+			t.Fatalf("NextMock() got (%v, %v)", ok, err)
+		}
+		got, err := MockValue[level](v)
+		if err != nil || got.Cm != want {
+			t.Errorf("replayed got (%v, %v) want (%v)", got, err, want)
+		}
+	}
+	if _, _, err := d.NextMock(); !errors.Is(err, ErrReplayDone) {
+		t.Errorf("NextMock() past the end error got (%v) want (%v)", err, ErrReplayDone)
+	}
+
+	// a mock sequence first
+	d.SetMockSequence([]any{level{1}}, MockOnce)
+	if v, _, _ := d.NextMock(); v != (level{1}) {
+		t.Errorf("NextMock() with a sequence got (%v)", v)
+	}
+	d.SetMockSequence(nil, MockOnce)
+
+	// looping, every device
+	all, _ := OpenReplay(path, ReplayLoop(true))
+	d.SetReplay(all)
+	var got []string
+	for range 8 {
+		v, _, err := d.NextMock()
+		if err != nil {
+			t.Fatalf("NextMock() looping error (%v)", err)
+		}
+		got = append(got, string(v.(json.RawMessage)))
+	}
+	want := `{"cm":10} false {"cm":12} true {"cm":15} true {"cm":10} false`
+	if strings.Join(got, " ") != want {
+		t.Errorf("looped got (%s) want (%s)", strings.Join(got, " "), want)
+	}
+
+	if _, err := MockValue[level]("10"); err == nil {
+		t.Error("MockValue() of another type got no error")
+	}
+	if _, err := NewReplay(strings.NewReader("{")); err == nil {
+		t.Error("NewReplay() of a broken recording got no error")
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now()
+	for i, gap := range []time.Duration{0, 30 * time.Millisecond, 60 * time.Millisecond} {
+		buf.WriteString(`{"ts":"` + start.Add(gap).Format(time.RFC3339Nano) + `","device":"tank","data":` + string(rune('0'+i)) + "}\n")
+	}
+
+	timed, _ := NewReplay(bytes.NewReader(buf.Bytes()), ReplayTiming(true))
+	begin := time.Now()
+	for range 3 {
+		timed.Next()
+	}
+	if elapsed := time.Since(begin); elapsed < 60*time.Millisecond {
+		t.Errorf("timed replay took %v want the 60ms recorded", elapsed)
+	}
+
+	ticked, _ := NewReplay(bytes.NewReader(buf.Bytes()))
+	begin = time.Now()
+	for range 3 {
+		ticked.Next()
+	}
+	if elapsed := time.Since(begin); elapsed > 30*time.Millisecond {
+		t.Errorf("replay per tick took %v", elapsed)
+	}
+}
@@ -0,0 +1,285 @@
+// Package reedswitch provides door and window contact sensors, a reed
+// switch on a GPIO line closed by a magnet on the door. The state is
+// published on every change along with the time of the change and
+// the day's open count, and an alert is published for a door left
+// open.
+//
+// The line is pulled up and the switch connects it to ground. A
+// normally closed switch is the safer wiring: a cut wire reads as an
+// open door. A floating line picks up noise, the flurry of edges that
+// no door makes is reported as tamper.
+package reedswitch
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// Wiring tells how the reed switch behaves with the magnet next to
+// it, which is the door closed
+type Wiring int
+
+const (
+	// NormallyOpen switches close with the magnet next to them
+	NormallyOpen Wiring = iota
+	// NormallyClosed switches open with the magnet next to them
+	NormallyClosed
+)
+
+const (
+	// DefaultDebounce filters the bounce of the reed contacts
+	DefaultDebounce = 50 * time.Millisecond
+
+	// DefaultChatterEdges within DefaultChatterWindow are more than
+	// a door makes, the line is reported as tampered
+	DefaultChatterEdges  = 10
+	DefaultChatterWindow = 10 * time.Second
+
+	// DefaultTamperQuiet is how long the line has to be quiet for the
+	// tamper state to clear
+	DefaultTamperQuiet = time.Minute
+)
+
+// Status is what is published on a change and by ReadPub
+type Status struct {
+	State      State     `json:"state"`
+	LastChange time.Time `json:"last_change"`
+	OpenCount  int       `json:"open_count_today"`
+	Tamper     bool      `json:"tamper"`
+}
+
+// AlertEvent is published once per opening when the door has been
+// open for the SetOpenAlert time
+type AlertEvent struct {
+	Event string    `json:"event"`
+	Since time.Time `json:"since"`
+}
+
+// ReedSwitch is a door or window contact on a GPIO line
+type ReedSwitch struct {
+	*device.Device
+
+	pin        *drivers.DigitalPin
+	trk        tracker
+	openAlert  time.Duration
+	alertTimer *device.Timer
+	alerted    time.Time // the opening alerted for
+	quietTimer *device.Timer
+
+	mu sync.Mutex
+}
+
+// New creates a contact sensor on the line at offset of the default
+// chip. opts are added to the line request after the pull up and the
+// debounce, gpiocdev.WithDebounce changes the debounce.
+func New(name string, offset int, wiring Wiring, opts ...gpiocdev.LineReqOption) (*ReedSwitch, error) {
+	r := &ReedSwitch{
+		Device: device.NewDevice(name, "mqtt"),
+		trk: tracker{
+			wiring:  wiring,
+			chatter: DefaultChatterEdges,
+			window:  DefaultChatterWindow,
+			quiet:   DefaultTamperQuiet,
+		},
+	}
+	if device.IsMock() {
+		r.trk.start(wiring == NormallyOpen, r.Now()) // closed
+		return r, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("reedswitch"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithDebounce(DefaultDebounce),
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(r.edge),
+	}, opts...)
+
+	// no edge is handled before the initial state is known
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := pin.Value()
+	if err != nil {
+		pin.Close()
+		return nil, err
+	}
+	r.pin = pin
+	r.trk.start(v == 1, r.Now())
+	return r, nil
+}
+
+// Name returns the name of the device
+func (r *ReedSwitch) Name() string {
+	return r.Device.Name
+}
+
+// SetOpenAlert publishes an AlertEvent when the door stays open for
+// d, 0 turns the alert off
+func (r *ReedSwitch) SetOpenAlert(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.openAlert = d
+	r.armAlert()
+}
+
+// SetChatter sets the number of edges within window that report the
+// line as tampered and the quiet time that clears it, 0 edges turns
+// the detection off
+func (r *ReedSwitch) SetChatter(edges int, window, quiet time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trk.chatter, r.trk.window, r.trk.quiet = edges, window, quiet
+}
+
+// Status returns the state, the time it last changed and the number
+// of openings today
+func (r *ReedSwitch) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trk.rollDay(r.Now())
+	return r.status()
+}
+
+func (r *ReedSwitch) status() Status {
+	return Status{
+		State:      r.trk.state,
+		LastChange: r.trk.lastChange,
+		OpenCount:  r.trk.opens,
+		Tamper:     r.trk.tamper,
+	}
+}
+
+// ReadPub publishes the Status
+func (r *ReedSwitch) ReadPub() error {
+	return r.publish(r.Status())
+}
+
+// Run publishes the Status every period until ctx is canceled, so a
+// late subscriber learns the state without waiting for a change
+func (r *ReedSwitch) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("reedswitch stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the timers and releases the line
+func (r *ReedSwitch) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stop(&r.alertTimer)
+	stop(&r.quietTimer)
+	if r.pin == nil {
+		return nil
+	}
+	err := r.pin.Close()
+	r.pin = nil
+	return err
+}
+
+// MockContact closes (1) or opens (0) the contact in mock mode
+func (r *ReedSwitch) MockContact(v int) {
+	r.handle(v == 1, r.Now())
+}
+
+func (r *ReedSwitch) edge(evt gpiocdev.LineEvent) {
+	r.handle(evt.Type == gpiocdev.LineEventRisingEdge, r.Now())
+}
+
+// handle runs the tracker for an edge at t
+func (r *ReedSwitch) handle(active bool, t time.Time) {
+	r.mu.Lock()
+	changed, tampered := r.trk.edge(active, t)
+	if r.trk.tamper {
+		// the door state means nothing until the line is quiet
+		stop(&r.alertTimer)
+		r.armQuiet(t)
+	} else if changed {
+		r.armAlert()
+	}
+	s := r.status()
+	r.mu.Unlock()
+
+	if changed || tampered {
+		if tampered {
+			slog.Warn("reedswitch line chattering", "device", r.Device.Name)
+		}
+		r.publish(s)
+	}
+}
+
+// armAlert starts the open alert timer for an open door and stops it
+// otherwise, r.mu is held
+func (r *ReedSwitch) armAlert() {
+	stop(&r.alertTimer)
+	if r.openAlert <= 0 || r.trk.state != Open || r.trk.tamper {
+		return
+	}
+	wait := r.trk.lastChange.Add(r.openAlert).Sub(r.Now())
+	r.alertTimer = r.Clock().AfterFunc(max(wait, 0), func() { r.alert() })
+}
+
+// alert publishes the AlertEvent if the door has been open for the
+// SetOpenAlert time, once per opening. It returns true if it did.
+func (r *ReedSwitch) alert() bool {
+	r.mu.Lock()
+	since := r.trk.lastChange
+	due := r.openAlert > 0 && r.trk.state == Open && !r.trk.tamper &&
+		r.Now().Sub(since) >= r.openAlert && !r.alerted.Equal(since)
+	if due {
+		r.alerted = since
+	}
+	r.mu.Unlock()
+	if due {
+		r.publish(&AlertEvent{Event: "left_open", Since: since})
+	}
+	return due
+}
+
+// armQuiet (re)starts the timer clearing the tamper state, r.mu is
+// held
+func (r *ReedSwitch) armQuiet(t time.Time) {
+	stop(&r.quietTimer)
+	r.quietTimer = r.Clock().AfterFunc(r.trk.quietAt().Sub(t), func() { r.quiet() })
+}
+
+func (r *ReedSwitch) quiet() {
+	r.mu.Lock()
+	cleared := r.trk.clear(r.Now())
+	if cleared {
+		r.armAlert()
+	}
+	s := r.status()
+	r.mu.Unlock()
+	if cleared {
+		r.publish(s)
+	}
+}
+
+func (r *ReedSwitch) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
+
+func stop(t **device.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
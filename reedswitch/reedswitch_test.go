@@ -0,0 +1,209 @@
+package reedswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func TestTracker(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		wiring Wiring
+		edges  []bool // the contact closed at each edge, a second apart
+		want   State
+		opens  int
+	}{
+		{"NO closed door", NormallyOpen, nil, Closed, 0},
+		{"NO opened", NormallyOpen, []bool{false}, Open, 1},
+		{"NO opened and closed", NormallyOpen, []bool{false, true}, Closed, 1},
+		{"NO opened twice", NormallyOpen, []bool{false, true, false}, Open, 2},
+		{"NC opened", NormallyClosed, []bool{true}, Open, 1},
+		{"NC opened and closed", NormallyClosed, []bool{true, false}, Closed, 1},
+		{"same level twice", NormallyOpen, []bool{false, false}, Open, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trk := tracker{wiring: tt.wiring, chatter: DefaultChatterEdges, window: DefaultChatterWindow}
+			trk.start(tt.wiring == NormallyOpen, t0) // door closed
+			at := t0
+			for _, active := range tt.edges {
+				at = at.Add(time.Second)
+				trk.edge(active, at)
+			}
+			if trk.state != tt.want || trk.opens != tt.opens {
+				t.Errorf("state got (%s, %d opens) want (%s, %d opens)", trk.state, trk.opens, tt.want, tt.opens)
+			}
+			if len(tt.edges) > 0 && trk.state == tt.want && !trk.lastChange.After(t0) {
+				t.Errorf("lastChange got (%v) want after (%v)", trk.lastChange, t0)
+			}
+		})
+	}
+}
+
+func TestTrackerDay(t *testing.T) {
+	evening := time.Date(2024, 5, 1, 23, 0, 0, 0, time.Local)
+	trk := tracker{wiring: NormallyOpen}
+	trk.start(true, evening)
+	trk.edge(false, evening)
+	trk.edge(true, evening.Add(time.Minute))
+	if trk.opens != 1 {
+		t.Fatalf("opens got (%d) want (1)", trk.opens)
+	}
+
+	morning := evening.Add(8 * time.Hour)
+	trk.rollDay(morning)
+	if trk.opens != 0 {
+		t.Errorf("opens after midnight got (%d) want (0)", trk.opens)
+	}
+	trk.edge(false, morning)
+	if trk.opens != 1 {
+		t.Errorf("opens in the morning got (%d) want (1)", trk.opens)
+	}
+}
+
+func TestTrackerTamper(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	trk := tracker{wiring: NormallyOpen, chatter: 5, window: time.Second, quiet: time.Minute}
+	trk.start(true, t0)
+
+	// a floating line, edges every 100ms
+	at, active := t0, true
+	var tampered int
+	for i := 0; i < 8; i++ {
+		at = at.Add(100 * time.Millisecond)
+		active = !active
+		if _, tamp := trk.edge(active, at); tamp {
+			tampered++
+		}
+	}
+	if !trk.tamper || tampered != 1 {
+		t.Fatalf("tamper got (%t, reported %d times) want (true, once)", trk.tamper, tampered)
+	}
+	if got, want := trk.quietAt(), at.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("quietAt() got (%v) want (%v)", got, want)
+	}
+	if trk.clear(at.Add(30 * time.Second)) {
+		t.Error("clear() before the quiet time got (true) want (false)")
+	}
+	if !trk.clear(at.Add(time.Minute)) || trk.tamper {
+		t.Error("clear() after the quiet time got (false) want (true)")
+	}
+	// the last level was the contact closed
+	if trk.state != Closed {
+		t.Errorf("state after clear got (%s) want (%s)", trk.state, Closed)
+	}
+
+	// a door slowly opened and closed is not tampering
+	at = at.Add(time.Hour)
+	for i := 0; i < 8; i++ {
+		at = at.Add(2 * time.Second)
+		trk.edge(i%2 == 1, at)
+	}
+	if trk.tamper {
+		t.Error("tamper got (true) for a door want (false)")
+	}
+}
+
+func TestOpenAlert(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	clock := devicetest.UseClock(t, start)
+	r, err := New("front-door", 4, NormallyOpen)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer r.Close()
+	alerted := func() time.Time {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.alerted
+	}
+	r.SetOpenAlert(time.Hour)
+
+	if r.alert() {
+		t.Error("alert() for a closed door got (true) want (false)")
+	}
+	r.MockContact(0)
+	if s := r.Status(); s.State != Open || s.OpenCount != 1 || !s.LastChange.Equal(start) {
+		t.Errorf("Status() got (%+v) want (open, 1 open at %v)", s, start)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if r.alert() || !alerted().IsZero() {
+		t.Error("alert() before the threshold got (true) want (false)")
+	}
+	// the timer alerts at the threshold, once per opening
+	clock.Advance(30 * time.Minute)
+	if !alerted().Equal(start) {
+		t.Errorf("alerted at the threshold got (%v) want (%v)", alerted(), start)
+	}
+	if r.alert() {
+		t.Error("second alert() for the same opening got (true) want (false)")
+	}
+
+	r.MockContact(1)
+	r.MockContact(0)
+	clock.Advance(time.Hour)
+	if !alerted().Equal(start.Add(time.Hour)) {
+		t.Errorf("alerted for a new opening got (%v) want (%v)", alerted(), start.Add(time.Hour))
+	}
+	if s := r.Status(); s.OpenCount != 2 {
+		t.Errorf("OpenCount got (%d) want (2)", s.OpenCount)
+	}
+}
+
+func TestReedSwitch(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	clock := devicetest.UseClock(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local))
+
+	// the fake line starts low, a normally closed switch reads the
+	// door closed
+	r, err := New("window", 5, NormallyClosed)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer r.Close()
+	if s := r.Status(); s.State != Closed {
+		t.Fatalf("initial state got (%s) want (%s)", s.State, Closed)
+	}
+
+	line := chip.Line(5)
+	line.Edge(1)
+	if s := r.Status(); s.State != Open || s.OpenCount != 1 {
+		t.Errorf("Status() got (%+v) want (open, 1 open)", s)
+	}
+	line.Edge(0)
+	if s := r.Status(); s.State != Closed || s.Tamper {
+		t.Errorf("Status() got (%+v) want (closed)", s)
+	}
+
+	r.SetChatter(4, time.Second, time.Hour)
+	line.Edge(1)
+	line.Edge(0)
+	if s := r.Status(); !s.Tamper {
+		t.Errorf("Tamper got (false) want (true)")
+	}
+	// the quiet timer clears it an hour after the last edge
+	clock.Advance(59 * time.Minute)
+	if s := r.Status(); !s.Tamper {
+		t.Errorf("Tamper before the quiet time got (false) want (true)")
+	}
+	clock.Advance(time.Minute)
+	if s := r.Status(); s.Tamper || s.State != Closed {
+		t.Errorf("Status() after the quiet time got (%+v) want (closed)", s)
+	}
+
+	r.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
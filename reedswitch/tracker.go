@@ -0,0 +1,120 @@
+package reedswitch
+
+import "time"
+
+// State is the state of the door or window
+type State string
+
+const (
+	Open   State = "open"
+	Closed State = "closed"
+)
+
+// tracker follows the door from the debounced level of the line. A
+// line that changes ChatterEdges times within ChatterWindow is not a
+// door, the sensor is tampered with or its wire is loose, the state
+// is ignored until the line has been quiet for TamperQuiet.
+type tracker struct {
+	wiring  Wiring
+	chatter int
+	window  time.Duration
+	quiet   time.Duration
+
+	active     bool // the contact is closed
+	state      State
+	lastChange time.Time
+	tamper     bool
+	edges      []time.Time // the edges within window
+
+	opens int
+	day   time.Time // local midnight of the day opens counts
+}
+
+func (t *tracker) stateOf(active bool) State {
+	// a normally open reed closes with the magnet next to it, so a
+	// closed contact is a closed door
+	if active == (t.wiring == NormallyOpen) {
+		return Closed
+	}
+	return Open
+}
+
+// start sets the state from the level of the line without counting
+func (t *tracker) start(active bool, at time.Time) {
+	t.active = active
+	t.state = t.stateOf(active)
+	t.lastChange = at
+	t.day = midnight(at)
+}
+
+// edge records the level of the line at an edge, it returns whether
+// the door state changed or the line started chattering
+func (t *tracker) edge(active bool, at time.Time) (changed, tampered bool) {
+	t.rollDay(at)
+	t.active = active
+
+	i := 0
+	for i < len(t.edges) && at.Sub(t.edges[i]) >= t.window {
+		i++
+	}
+	t.edges = append(t.edges[i:], at)
+
+	if t.tamper {
+		return false, false
+	}
+	if t.chatter > 0 && len(t.edges) >= t.chatter {
+		t.tamper = true
+		return false, true
+	}
+	return t.set(t.stateOf(active), at), false
+}
+
+// clear ends the tamper state once the line has been quiet, it
+// returns true if it did
+func (t *tracker) clear(at time.Time) bool {
+	if !t.tamper {
+		return false
+	}
+	if n := len(t.edges); n > 0 && at.Sub(t.edges[n-1]) < t.quiet {
+		return false
+	}
+	t.rollDay(at)
+	t.tamper = false
+	t.edges = nil
+	t.set(t.stateOf(t.active), at)
+	return true
+}
+
+// quietAt returns when the tamper state can clear
+func (t *tracker) quietAt() time.Time {
+	if n := len(t.edges); n > 0 {
+		return t.edges[n-1].Add(t.quiet)
+	}
+	return time.Time{}
+}
+
+func (t *tracker) set(s State, at time.Time) bool {
+	if s == t.state {
+		return false
+	}
+	t.state = s
+	t.lastChange = at
+	if s == Open {
+		t.opens++
+	}
+	return true
+}
+
+// rollDay starts the day's open count over after midnight
+func (t *tracker) rollDay(at time.Time) {
+	if m := midnight(at); !m.Equal(t.day) {
+		t.day = m
+		t.opens = 0
+	}
+}
+
+// midnight returns the start of the day of t in the local time zone
+func midnight(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
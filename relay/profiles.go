@@ -0,0 +1,23 @@
+package relay
+
+import (
+	"errors"
+
+	"github.com/rustyeddy/otto-devices"
+)
+
+// ErrStuck is the error of the switching a flaky relay fails
+var ErrStuck = errors.New("relay contact stuck")
+
+// the mock profiles of a relay, "normal" switching every time and
+// "flaky" failing every third switch
+func init() {
+	device.RegisterMockProfile("relay", "normal", func(r *Relay) error {
+		r.InjectFaults()
+		return nil
+	})
+	device.RegisterMockProfile("relay", "flaky", func(r *Relay) error {
+		r.InjectFaults(device.FailEvery(3), device.FailWith(ErrStuck))
+		return nil
+	})
+}
@@ -9,25 +9,86 @@ import (
 type Relay struct {
 	*device.Device
 	*drivers.DigitalPin
+
+	commands *device.Router
 }
 
 func New(name string, offset int) *Relay {
        relay := &Relay{
-	       Device: device.NewDevice(name, "mqtt"),
+	       Device: device.NewDevice(name, "mqtt", device.WithQoS(device.AtLeastOnce)),
        }
 	g := drivers.GetGPIO()
-	relay.DigitalPin = g.Pin(name, offset, gpiocdev.AsOutput(0))
+	relay.DigitalPin = g.Pin(name, offset, drivers.WithOwner("relay"), gpiocdev.AsOutput(0))
+	relay.commands = commands(relay.Device, relay.DigitalPin)
+	relay.SetMockKind("relay", relay)
 	return relay
 }
 
-func (r *Relay) Callback(msg *messanger.Msg) {
-	str := msg.String()
-	switch str {
-	case "off", "0":
-		r.Off()
+// Listen subscribes the relay to its ControlTopic, it takes "on",
+// "off" and "toggle", plain or in a command envelope acked on its
+// AckTopic, and publishes its value on its StateTopic. The commands
+// come at QoS 1, one delivered again is taken once. "on" and "toggle"
+// are nacked with device.ErrInhibited while an interlock inhibits it.
+func (r *Relay) Listen() error {
+	return r.commands.Listen()
+}
+
+func (r *Relay) Callback(msg *device.Msg) {
+	r.commands.Dispatch(msg)
+}
+
+// Entities is the relay as a switch of Home Assistant, switched by
+// the "1" and "0" commands and its state the value of its pin
+func (r *Relay) Entities() []device.Entity {
+	return []device.Entity{{
+		Component:    "switch",
+		Icon:         "mdi:electric-switch",
+		StateTopic:   r.StateTopic(),
+		CommandTopic: r.ControlTopic(),
+		PayloadOn:    "1",
+		PayloadOff:   "0",
+	}}
+}
+
+// Schemas describe the state of the relay, the value of its pin
+func (r *Relay) Schemas() device.Schemas {
+	return device.Schemas{State: &device.Schema{Type: "integer", Description: "pin value", Enum: []any{0, 1}}}
+}
 
-	case "on", "1":
-		r.On()
+// commands routes the commands of a pin to it, the value of the pin
+// published on the device's StateTopic after each. A fault injected
+// fails a command, the contact stuck, and the ones switching it on
+// are held back while the device is inhibited.
+func commands(d *device.Device, pin *drivers.DigitalPin) *device.Router {
+	rt := device.NewRouter(d)
+	set := func(fn func() error) device.CommandFunc {
+		return func(*device.Command) error {
+			if err := d.ReadFault(); err != nil {
+				return err
+			}
+			if err := fn(); err != nil {
+				return err
+			}
+			v, err := pin.Value()
+			if err != nil {
+				return err
+			}
+			// the command was executed, a state lost is the
+			// device's error
+			d.PubState(v)
+			return nil
+		}
 	}
-	return
+	rt.Handle("on", set(pin.On))
+	rt.Handle("1", set(pin.On))
+	rt.Handle("off", set(pin.Off))
+	rt.Handle("0", set(pin.Off))
+	rt.Handle("toggle", set(pin.Toggle))
+	rt.Inhibitable("on", "1", "toggle")
+	return rt
+}
+
+// Close releases the pin used by the relay
+func (r *Relay) Close() error {
+	return r.DigitalPin.Close()
 }
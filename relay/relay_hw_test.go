@@ -0,0 +1,99 @@
+//go:build hardware
+
+package relay
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// TestHardwareRelayPulse closes the relay on OTTO_RELAY_PIN for
+// OTTO_RELAY_PULSE, 200ms unless set, by its commands. The pulse is
+// timed by the changes of its pin, and by the edges of its contact on
+// OTTO_RELAY_READBACK_PIN when wired to an input, within 25ms for the
+// contact to settle. OTTO_GPIO_CHIP is the chip of the relay when it
+// is not gpiochip0.
+func TestHardwareRelayPulse(t *testing.T) {
+	offset := devicetest.HardwareInt(t, "OTTO_RELAY_PIN", "the offset of the line of the relay")
+	if chip := os.Getenv("OTTO_GPIO_CHIP"); chip != "" {
+		drivers.DefaultChipname = chip
+	}
+	pulse := 200 * time.Millisecond
+	if v := os.Getenv("OTTO_RELAY_PULSE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			t.Fatalf("OTTO_RELAY_PULSE=%s is not a duration", v)
+		}
+		pulse = d
+	}
+	const tolerance = 25 * time.Millisecond
+	defer device.Mock(device.IsMock())
+	device.Mock(false)
+
+	relay := New("hw-relay", offset)
+	defer relay.Close()
+	if _, err := relay.Get(); err != nil {
+		t.Fatalf("relay on %s %d error = %v", drivers.DefaultChipname, offset, err)
+	}
+
+	edges := make(chan gpiocdev.LineEvent, 64)
+	if id := os.Getenv("OTTO_RELAY_READBACK_PIN"); id != "" {
+		readback, err := drivers.NewDigitalPinID("hw-relay-readback", id, gpiocdev.AsInput,
+			gpiocdev.WithBothEdges, gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+				select {
+				case edges <- evt:
+				default:
+				}
+			}))
+		if err != nil {
+			t.Fatalf("NewDigitalPinID(%s) error = %v", id, err)
+		}
+		defer readback.Close()
+	} else {
+		t.Log("OTTO_RELAY_READBACK_PIN not set, the contact is not read back")
+		edges = nil
+	}
+
+	command := func(cmd string) time.Time {
+		t.Helper()
+		if err := relay.commands.Execute(device.NewMsg(relay.ControlTopic(), []byte(cmd), "hw")); err != nil {
+			t.Fatalf("%s error = %v", cmd, err)
+		}
+		return relay.LastChanged()
+	}
+	closed := command("on")
+	time.Sleep(pulse)
+	opened := command("off")
+	if got := opened.Sub(closed); got < pulse || got > pulse+tolerance {
+		t.Errorf("pin pulse got (%v) want (%v) within %v", got, pulse, tolerance)
+	}
+	if edges == nil {
+		return
+	}
+
+	// the first edge of the contact closing to the last of it opening,
+	// bounces left out
+	time.Sleep(tolerance)
+	var first, last *gpiocdev.LineEvent
+	for len(edges) > 0 {
+		evt := <-edges
+		switch {
+		case evt.Type == gpiocdev.LineEventRisingEdge && first == nil:
+			first = &evt
+		case evt.Type == gpiocdev.LineEventFallingEdge:
+			last = &evt
+		}
+	}
+	if first == nil || last == nil {
+		t.Fatalf("contact edges got (%v %v) want the contact closing and opening", first, last)
+	}
+	if got := last.Timestamp - first.Timestamp; got < pulse-tolerance || got > pulse+tolerance {
+		t.Errorf("contact pulse got (%v) want (%v) within %v", got, pulse, tolerance)
+	}
+}
@@ -1,38 +1,262 @@
 package relay
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
 )
 
 func TestRelay(t *testing.T) {
 	device.Mock(true)
+	c := devicetest.Use(t)
 
 	relay := New("relay", 5)
-	if relay.Name() != "relay" {
-		t.Errorf("relay expected Name (%s) got (%s)", "relay", relay.Name())
+	if relay.Name != "relay" {
+		t.Errorf("relay expected Name (%s) got (%s)", "relay", relay.Name)
 	}
+	if err := relay.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	// the state is the value of the pin after each command, at the
+	// QoS of the relay
+	for _, tt := range []struct {
+		cmd  string
+		want int
+	}{
+		{"on", 1}, {"off", 0}, {"toggle", 1}, {"0", 0}, {"1", 1},
+	} {
+		c.Inject(relay.ControlTopic(), []byte(tt.cmd))
+		c.ExpectPublish(relay.StateTopic(), devicetest.All(devicetest.Data(tt.want), devicetest.QoS(device.AtLeastOnce)), devicetest.Timeout)
+	}
+	if relay.LastChanged().IsZero() {
+		t.Errorf("relay LastChanged() not set")
+	}
+
+	// a command in an envelope is acked
+	c.Inject(relay.ControlTopic(), []byte(`{"id":"42","cmd":"off"}`))
+	c.ExpectPublish(relay.StateTopic(), devicetest.Data(0), devicetest.Timeout)
+	c.ExpectPublish(relay.AckTopic(), devicetest.Contains(`"id":"42","ok":true`), devicetest.Timeout)
+
+	c.Inject(relay.ControlTopic(), []byte("explode"))
+	c.ExpectNoPublish(relay.StateTopic(), devicetest.Any(), 10*time.Millisecond)
+
+	// the coil switched once a command, never twice
+	driverstest.ExpectSequence(t, relay.Waveform(), 0, 1, 0, 1, 0, 1, 0)
+}
+
+// TestRelayPulse closes the relay for a pulse by its commands on a
+// SimClock, the waveform of the coil timed to the tick
+func TestRelayPulse(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	relay := New("pulser", 13)
+	if err := relay.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	for _, step := range []struct {
+		cmd  string
+		want int
+		hold time.Duration
+	}{
+		{"on", 1, 200 * time.Millisecond}, {"off", 0, 800 * time.Millisecond},
+		{"on", 1, 200 * time.Millisecond}, {"off", 0, 800 * time.Millisecond},
+		{"on", 1, 200 * time.Millisecond}, {"off", 0, 0},
+	} {
+		c.Inject(relay.ControlTopic(), []byte(step.cmd))
+		c.ExpectPublish(relay.StateTopic(), devicetest.Data(step.want), devicetest.Timeout)
+		clock.Advance(step.hold)
+	}
+
+	w := relay.Waveform()
+	driverstest.ExpectSequence(t, w, 0, 1, 0, 1, 0, 1, 0)
+	driverstest.ExpectPulseWidth(t, w, 1, 200*time.Millisecond, 200*time.Millisecond)
+	driverstest.ExpectPulseWidth(t, w, 0, 800*time.Millisecond, 800*time.Millisecond)
+	driverstest.ExpectFrequency(t, w, 0, 3*time.Second, 1, 0)
+	driverstest.ExpectDuty(t, w, 0, 3*time.Second, 0.2, 0)
+}
+
+func TestRelayStateEnvelope(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
+
+	relay := New("pump", 6)
+	relay.Callback(device.NewMsg(relay.ControlTopic(), []byte("on"), "test"))
+	relay.Callback(device.NewMsg(relay.ControlTopic(), []byte("off"), "test"))
+
+	want := []string{
+		`{"schema":1,"ts":"TS","device":"pump","seq":1,"data":1}`,
+		`{"schema":1,"ts":"TS","device":"pump","seq":2,"data":0}`,
+	}
+	for i := range want {
+		msg := c.ExpectPublish(relay.StateTopic(), devicetest.Any(), devicetest.Timeout)
+		var env device.Envelope
+		if err := json.Unmarshal(msg.Data, &env); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", msg, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, env.TS); err != nil {
+			t.Errorf("ts got (%s) error = %v", env.TS, err)
+		}
+		if got := strings.Replace(msg.String(), env.TS, "TS", 1); got != want[i] {
+			t.Errorf("state %d got (%s) want (%s)", i, got, want[i])
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestRelayDiscovery(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
 
-	msg := messanger.NewMsg(relay.Topic(), []byte("on"), "test")
-	relay.Callback(msg)
+	relay := New("pump", 7)
+	if err := relay.PubDiscovery(relay.Entities()); err != nil {
+		t.Fatalf("PubDiscovery() error = %v", err)
+	}
+	configs := make(map[string]json.RawMessage)
+	for _, msg := range c.Messages("homeassistant/#") {
+		configs[msg.Topic] = msg.Data
+	}
+	got, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		t.Fatalf("MarshalIndent() error = %v", err)
+	}
+	got = append(got, '\n')
 
-	v, err := relay.Value()
+	golden := filepath.Join("testdata", "discovery.json")
+	if *update {
+		os.MkdirAll("testdata", 0o755)
+		os.WriteFile(golden, got, 0o644)
+	}
+	want, err := os.ReadFile(golden)
 	if err != nil {
-		t.Fatalf("relay.Value() got error %v", err)
+		t.Fatalf("ReadFile() error = %v", err)
 	}
-	if v != 1 {
-		t.Errorf("relay expected (1) got (%d)", v)
+	if string(got) != string(want) {
+		t.Errorf("discovery got\n%s\nwant\n%s", got, want)
 	}
+}
 
-	msg = messanger.NewMsg(relay.Topic(), []byte("off"), "test")
-	relay.Callback(msg)
+func TestRelaySchemas(t *testing.T) {
+	device.Mock(true)
+	c := devicetest.Use(t)
 
-	v, err = relay.Value()
+	relay := New("pump", 8)
+	if err := relay.PubSchemas(relay.Schemas()); err != nil {
+		t.Fatalf("PubSchemas() error = %v", err)
+	}
+	msg := c.ExpectPublish(device.SchemaTopic(relay.StateTopic()), devicetest.Retained(true), devicetest.Timeout)
+	var got bytes.Buffer
+	json.Indent(&got, msg.Data, "", "  ")
+	got.WriteByte('\n')
+
+	golden := filepath.Join("testdata", "schema.json")
+	if *update {
+		os.WriteFile(golden, got.Bytes(), 0o644)
+	}
+	want, err := os.ReadFile(golden)
 	if err != nil {
-		t.Fatalf("relay.Value() got error %v", err)
+		t.Fatalf("ReadFile() error = %v", err)
 	}
-	if v != 0 {
-		t.Errorf("relay expected (0) got (%d)", v)
+	if got.String() != string(want) {
+		t.Errorf("schema got\n%s\nwant\n%s", got.String(), want)
 	}
+	if _, ok := c.Retained(device.SchemaTopic(relay.DataTopic())); ok {
+		t.Errorf("data schema retained for a relay publishing none")
+	}
+}
+
+func TestRelayProfiles(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	c := devicetest.Use(t)
+
+	relay := New("relay-flaky", 9)
+	if err := relay.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if err := relay.MockProfile("flaky"); err != nil {
+		t.Fatalf("MockProfile(flaky) error = %v", err)
+	}
+	for i := 1; i <= 6; i++ {
+		c.Inject(relay.ControlTopic(), []byte(`{"id":"`+strconv.Itoa(i)+`","cmd":"toggle"}`))
+		want := `"ok":true`
+		if i%3 == 0 {
+			want = `"ok":false,"error":"relay contact stuck"`
+		}
+		c.ExpectPublish(relay.AckTopic(), devicetest.Contains(want), devicetest.Timeout)
+	}
+	driverstest.ExpectSequence(t, relay.Waveform(), 0, 1, 0, 1, 0)
+
+	// a normal one switches every time
+	if err := relay.MockProfile("normal"); err != nil {
+		t.Fatalf("MockProfile(normal) error = %v", err)
+	}
+	relay.Waveform().Reset()
+	for i := 7; i <= 9; i++ {
+		c.Inject(relay.ControlTopic(), []byte(`{"id":"`+strconv.Itoa(i)+`","cmd":"toggle"}`))
+		c.ExpectPublish(relay.AckTopic(), devicetest.Contains(`"ok":true`), devicetest.Timeout)
+	}
+	driverstest.ExpectSequence(t, relay.Waveform(), 0, 1, 0, 1)
+}
+
+// FuzzRelayCommand feeds payloads to the commands of a relay: one
+// that is not a command of it fails with an ErrBadPayload, "on" and
+// "off" switch its contact
+func FuzzRelayCommand(f *testing.F) {
+	for _, seed := range []string{
+		"on", "off", "1", "0", "toggle", "ON ", "", "open",
+		`{"id":"1","cmd":"on"}`,
+		`{"id":"1","cmd":"off"}`,
+		`{"cmd":"Toggle"}`,
+		`{"cmd":"fault","args":{"every":1,"err":"stuck"}}`,
+		`{"cmd":"fault","args":{"for":"-1s"}}`,
+		`{"mock":null}`,
+		`{"id":3,"cmd":"on"}`,
+		`{"cmd":""}`, `"on"`,
+	} {
+		f.Add([]byte(seed))
+	}
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+	relay := New("fuzz-relay", 10)
+	defer relay.Close()
+	relay.commands.Window = -1 // every ID a new command, none a replay
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		err := relay.commands.Execute(device.NewMsg(relay.ControlTopic(), payload, "fuzz"))
+		cmd, perr := device.ParseCommand(payload)
+		if err != nil {
+			if !errors.Is(err, device.ErrBadPayload) {
+				t.Errorf("Execute(%q) error got (%v) want an ErrBadPayload", payload, err)
+			}
+			return
+		}
+		if perr != nil {
+			t.Fatalf("Execute(%q) succeeded, ParseCommand() error (%v)", payload, perr)
+		}
+		want := map[string]int{"on": 1, "1": 1, "off": 0, "0": 0}
+		switch name := strings.ToLower(cmd.Cmd); name {
+		case "on", "1", "off", "0":
+			if v, _ := relay.Value(); v != want[name] {
+				t.Errorf("Execute(%q) contact got (%d) want (%d)", payload, v, want[name])
+			}
+		case "toggle", "fault", "mock":
+		default:
+			t.Errorf("Execute(%q) succeeded for (%s)", payload, cmd.Cmd)
+		}
+		relay.InjectFaults()
+	})
 }
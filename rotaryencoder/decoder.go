@@ -0,0 +1,94 @@
+package rotaryencoder
+
+import (
+	"math"
+	"time"
+)
+
+// transitions holds the quarter step of every change of the A and B
+// lines, indexed by the old state << 2 | the new state with a state
+// being A << 1 | B. Turning clockwise A leads B: 00 10 11 01 00.
+// Both lines changing at once is invalid and counts nothing.
+var transitions = [16]int8{
+	0, -1, +1, 0,
+	+1, 0, 0, -1,
+	-1, 0, 0, +1,
+	0, +1, -1, 0,
+}
+
+// decoder turns line changes into detents. The quarter steps are
+// summed and only counted when the lines come back to a rest state,
+// a bouncing contact adds and takes away the same step so it never
+// makes a phantom detent. A missed edge shows as both lines changing
+// at once, it is forgiven as long as half the detent's steps were
+// seen.
+type decoder struct {
+	steps int   // quarter steps per detent, 1, 2 or 4
+	rest  uint8 // the state at a detent
+	state uint8
+	count int
+}
+
+func newDecoder(steps int, state uint8) decoder {
+	return decoder{steps: steps, rest: state, state: state}
+}
+
+// update moves to state and returns +1 or -1 when a detent is done
+func (d *decoder) update(state uint8) int {
+	d.count += int(transitions[d.state<<2|state])
+	d.state = state
+	if !d.atRest(state) {
+		return 0
+	}
+	c := d.count
+	d.count = 0
+	switch {
+	case c > 0 && 2*c >= d.steps:
+		return 1
+	case c < 0 && -2*c >= d.steps:
+		return -1
+	}
+	return 0
+}
+
+// atRest reports if state is a detent, half step encoders rest at
+// both 00 and 11
+func (d *decoder) atRest(state uint8) bool {
+	switch d.steps {
+	case 1:
+		return true
+	case 2:
+		return state == d.rest || state == d.rest^3
+	}
+	return state == d.rest
+}
+
+// Acceleration makes a fast turn count more than one step per detent,
+// for volume style controls. A detent Slow or longer after the last
+// one is a single step, one Fast or sooner counts Max steps, in
+// between the steps scale linearly.
+type Acceleration struct {
+	Slow time.Duration
+	Fast time.Duration
+	Max  int
+}
+
+// DefaultAcceleration reaches 10 steps a detent at 20 detents per
+// second
+var DefaultAcceleration = Acceleration{
+	Slow: 150 * time.Millisecond,
+	Fast: 50 * time.Millisecond,
+	Max:  10,
+}
+
+// stepsFor returns the steps of a detent dt after the previous one
+func (a *Acceleration) stepsFor(dt time.Duration) int {
+	if a == nil || a.Max <= 1 || dt >= a.Slow {
+		return 1
+	}
+	if dt <= a.Fast {
+		return a.Max
+	}
+	f := float64(a.Slow-dt) / float64(a.Slow-a.Fast)
+	return 1 + int(math.Round(f*float64(a.Max-1)))
+}
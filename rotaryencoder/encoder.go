@@ -0,0 +1,260 @@
+// Package rotaryencoder reads an incremental rotary encoder like the
+// KY-040 from the edges of its A and B lines. The quadrature signal
+// is decoded with a state table so contact bounce does not turn into
+// phantom steps, the detents move a position that is published either
+// as the relative step or as the absolute position. The encoder's
+// push switch can be added as a button.GestureButton.
+package rotaryencoder
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/button"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// the quarter steps of the signal from one detent to the next, full
+// step encoders like the KY-040 run a whole quadrature cycle
+const (
+	FullStep    = 4
+	HalfStep    = 2
+	QuarterStep = 1
+)
+
+var ErrSteps = errors.New("steps per detent must be 1, 2 or 4")
+
+// Step is published for every detent unless Absolute is set, the
+// delta is larger than 1 with Acceleration
+type Step struct {
+	Delta int `json:"delta"`
+}
+
+// Position is published for every detent with Absolute set
+type Position struct {
+	Position int `json:"position"`
+}
+
+// Encoder is a rotary encoder on two GPIO lines
+type Encoder struct {
+	*device.Device
+
+	// Switch is the push switch added with AddSwitch
+	Switch *button.GestureButton
+
+	// Absolute publishes the position instead of the step
+	Absolute bool
+
+	// Acceleration scales the steps of fast turns, nil counts every
+	// detent as one step
+	Acceleration *Acceleration
+
+	a, b     *drivers.DigitalPin
+	levels   uint8
+	dec      decoder
+	position int
+	last     time.Time
+	onTurn   []func(delta, position int)
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates an encoder with A (CLK on a KY-040) and B (DT) on the
+// lines at the given offsets of the default chip, steps is the
+// number of quarter steps per detent. opts are added to both line
+// requests, like gpiocdev.WithPullUp for an encoder without pull ups
+// of its own.
+func New(name string, a, b, steps int, opts ...gpiocdev.LineReqOption) (*Encoder, error) {
+	if steps != FullStep && steps != HalfStep && steps != QuarterStep {
+		return nil, ErrSteps
+	}
+	e := &Encoder{
+		Device: device.NewDevice(name, "mqtt"),
+		now:    time.Now,
+	}
+	if device.IsMock() {
+		e.dec = newDecoder(steps, 3)
+		e.levels = 3
+		return e, nil
+	}
+
+	request := func(suffix string, offset int, bit uint8) (*drivers.DigitalPin, error) {
+		lopts := append([]gpiocdev.LineReqOption{
+			drivers.WithOwner("rotaryencoder"),
+			gpiocdev.AsInput,
+			gpiocdev.WithBothEdges,
+			gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+				e.edge(bit, evt.Type == gpiocdev.LineEventRisingEdge)
+			}),
+		}, opts...)
+		return drivers.GetGPIO().Request(name+"-"+suffix, offset, lopts...)
+	}
+
+	// hold the lock so no edge is decoded before the initial state
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var err error
+	if e.a, err = request("a", a, 2); err != nil {
+		return nil, err
+	}
+	if e.b, err = request("b", b, 1); err != nil {
+		e.a.Close()
+		return nil, err
+	}
+	va, erra := e.a.Value()
+	vb, errb := e.b.Value()
+	if err := errors.Join(erra, errb); err != nil {
+		e.a.Close()
+		e.b.Close()
+		return nil, err
+	}
+	e.levels = uint8(va<<1 | vb)
+	e.dec = newDecoder(steps, e.levels)
+	return e, nil
+}
+
+// AddSwitch adds the encoder's push switch (SW on a KY-040) on the
+// line at offset as a button named <name>/switch, see
+// button.NewGesture for the wiring and opts
+func (e *Encoder) AddSwitch(offset int, opts ...gpiocdev.LineReqOption) (*button.GestureButton, error) {
+	sw, err := button.NewGesture(e.Device.Name+"/switch", offset, opts...)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	e.Switch = sw
+	e.mu.Unlock()
+	return sw, nil
+}
+
+// Name returns the name of the device
+func (e *Encoder) Name() string {
+	return e.Device.Name
+}
+
+// Position returns the position
+func (e *Encoder) Position() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.position
+}
+
+// SetPosition sets the position, like the volume restored at start up
+func (e *Encoder) SetPosition(p int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.position = p
+}
+
+// OnTurn calls fn with the step and the new position for every
+// detent
+func (e *Encoder) OnTurn(fn func(delta, position int)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onTurn = append(e.onTurn, fn)
+}
+
+// ReadPub publishes the position
+func (e *Encoder) ReadPub() error {
+	j, err := json.Marshal(&Position{Position: e.Position()})
+	if err != nil {
+		return err
+	}
+	e.PubData(j)
+	return nil
+}
+
+// MockTurn turns the encoder by detents in mock mode, clockwise for
+// positive detents, running the full quadrature sequence through the
+// decoder
+func (e *Encoder) MockTurn(detents int) {
+	seq := []uint8{1, 0, 2, 3} // clockwise from rest at 11: 01 00 10 11
+	if detents < 0 {
+		seq = []uint8{2, 0, 1, 3}
+		detents = -detents
+	}
+	for i := 0; i < detents; i++ {
+		for _, s := range seq {
+			e.mu.Lock()
+			e.levels = s
+			t := e.decode()
+			e.mu.Unlock()
+			e.report(t)
+		}
+	}
+}
+
+// Close releases the lines and the switch
+func (e *Encoder) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var errs []error
+	if e.a != nil {
+		errs = append(errs, e.a.Close(), e.b.Close())
+		e.a, e.b = nil, nil
+	}
+	if e.Switch != nil {
+		errs = append(errs, e.Switch.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// edge records the new level of the line at bit and decodes the
+// state of both lines
+func (e *Encoder) edge(bit uint8, high bool) {
+	e.mu.Lock()
+	if high {
+		e.levels |= bit
+	} else {
+		e.levels &^= bit
+	}
+	t := e.decode()
+	e.mu.Unlock()
+	e.report(t)
+}
+
+// turn is a detent to report
+type turn struct {
+	delta, position int
+	fns             []func(int, int)
+}
+
+// decode runs the levels through the decoder and moves the position
+// when a detent is done, e.mu is held
+func (e *Encoder) decode() *turn {
+	dir := e.dec.update(e.levels)
+	if dir == 0 {
+		return nil
+	}
+	now := e.now()
+	delta := dir * e.Acceleration.stepsFor(now.Sub(e.last))
+	e.last = now
+	e.position += delta
+	return &turn{
+		delta:    delta,
+		position: e.position,
+		fns:      append([]func(int, int){}, e.onTurn...),
+	}
+}
+
+// report publishes a turn and calls the OnTurn functions
+func (e *Encoder) report(t *turn) {
+	if t == nil {
+		return
+	}
+	var msg any = &Step{Delta: t.delta}
+	if e.Absolute {
+		msg = &Position{Position: t.position}
+	}
+	if j, err := json.Marshal(msg); err == nil {
+		e.PubData(j)
+	}
+	for _, fn := range t.fns {
+		fn(t.delta, t.position)
+	}
+}
@@ -0,0 +1,185 @@
+package rotaryencoder
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// states parses a sequence of AB levels like "11 01 00"
+func states(seq string) []uint8 {
+	var s []uint8
+	for _, f := range strings.Fields(seq) {
+		s = append(s, (f[0]-'0')<<1|(f[1]-'0'))
+	}
+	return s
+}
+
+func TestDecoder(t *testing.T) {
+	// the sequences are the line levels of a KY-040 as a logic
+	// analyzer shows them, one entry per edge
+	tests := []struct {
+		name  string
+		steps int
+		seq   string
+		want  []int
+	}{
+		{"clockwise", FullStep, "11 01 00 10 11", []int{1}},
+		{"counter clockwise", FullStep, "11 10 00 01 11", []int{-1}},
+		{"two detents", FullStep, "11 01 00 10 11 01 00 10 11", []int{1, 1}},
+		{
+			"bounce on A leaving the detent", FullStep,
+			"11 01 11 01 11 01 00 10 11", []int{1},
+		},
+		{
+			"bounce on B mid detent", FullStep,
+			"11 01 00 01 00 01 00 10 11", []int{1},
+		},
+		{
+			"bounce at the detent", FullStep,
+			"11 01 00 10 11 10 11 10 11", []int{1},
+		},
+		{
+			"bounce without a turn", FullStep,
+			"11 01 11 01 11 10 11", nil,
+		},
+		{"turned back half way", FullStep, "11 01 00 01 11", nil},
+		{
+			"a missed edge is forgiven", FullStep,
+			"11 01 10 11", []int{1}, // 00 was missed, 01 to 10 is invalid
+		},
+		{"both lines at once", FullStep, "11 00 11", nil},
+		{"reversing", FullStep, "11 01 00 10 11 10 00 01 11", []int{1, -1}},
+		{"half step", HalfStep, "11 01 00 10 11", []int{1, 1}},
+		{"half step bounce", HalfStep, "11 01 11 01 00 01 00", []int{1}},
+		{"quarter step", QuarterStep, "11 01 00 10", []int{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := states(tt.seq)
+			d := newDecoder(tt.steps, seq[0])
+			var got []int
+			for _, s := range seq[1:] {
+				if dir := d.update(s); dir != 0 {
+					got = append(got, dir)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("detents got (%v) want (%v)", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("detents got (%v) want (%v)", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceleration(t *testing.T) {
+	a := DefaultAcceleration
+	tests := []struct {
+		dt   time.Duration
+		want int
+	}{
+		{time.Second, 1},
+		{150 * time.Millisecond, 1},
+		{100 * time.Millisecond, 6},
+		{60 * time.Millisecond, 9},
+		{50 * time.Millisecond, 10},
+		{10 * time.Millisecond, 10},
+	}
+	for _, tt := range tests {
+		if got := a.stepsFor(tt.dt); got != tt.want {
+			t.Errorf("stepsFor(%v) got (%d) want (%d)", tt.dt, got, tt.want)
+		}
+	}
+	var none *Acceleration
+	if got := none.stepsFor(time.Millisecond); got != 1 {
+		t.Errorf("no acceleration got (%d) want (1)", got)
+	}
+}
+
+func TestMockTurn(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	e, err := New("volume", 17, 27, FullStep)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := time.Now()
+	e.now = func() time.Time {
+		clock = clock.Add(20 * time.Millisecond)
+		return clock
+	}
+	e.SetPosition(50)
+	e.MockTurn(3)
+	e.MockTurn(-1)
+	if got := e.Position(); got != 52 {
+		t.Errorf("Position() got (%d) want (52)", got)
+	}
+
+	// a fast turn counts more with acceleration
+	e.Acceleration = &DefaultAcceleration
+	e.MockTurn(2)
+	if got := e.Position(); got != 72 {
+		t.Errorf("accelerated Position() got (%d) want (72)", got)
+	}
+
+	if _, err := New("bad", 17, 27, 3); err != ErrSteps {
+		t.Errorf("New(3 steps) error got (%v) want (%v)", err, ErrSteps)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	e, err := New("volume", 17, 27, FullStep)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer e.Close()
+
+	var mu sync.Mutex
+	var deltas []int
+	e.OnTurn(func(delta, position int) {
+		mu.Lock()
+		deltas = append(deltas, delta)
+		mu.Unlock()
+	})
+
+	// the fake lines start low, so the encoder rests at 00
+	a, b := chip.Line(17), chip.Line(27)
+	for _, edge := range []func(){
+		func() { a.Edge(1) }, func() { b.Edge(1) }, func() { a.Edge(0) }, func() { b.Edge(0) },
+		func() { b.Edge(1) }, func() { b.Edge(0) }, // bounce
+		func() { b.Edge(1) }, func() { a.Edge(1) }, func() { b.Edge(0) }, func() { a.Edge(0) },
+	} {
+		edge()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deltas) != 2 || deltas[0] != 1 || deltas[1] != -1 || e.Position() != 0 {
+		t.Errorf("turns got (%v) at (%d) want ([1 -1]) at (0)", deltas, e.Position())
+	}
+
+	sw, err := e.AddSwitch(22)
+	if err != nil {
+		t.Fatalf("AddSwitch() error = %v", err)
+	}
+	if sw.Name() != "volume/switch" || chip.Line(22) == nil {
+		t.Errorf("AddSwitch() got (%s) want (volume/switch) on line 22", sw.Name())
+	}
+	e.Close()
+	if !a.Closed() || !b.Closed() || !chip.Line(22).Closed() {
+		t.Error("Close() did not release the lines")
+	}
+}
@@ -0,0 +1,79 @@
+package rpmsensor
+
+import "time"
+
+// maxSamples caps the pulse times kept for the window, at a higher
+// rate the oldest are dropped and the window gets shorter
+const maxSamples = 4096
+
+// meter times the pulses of the sensor. The speed is the mean period
+// of the pulses in the window, from the first to the last, so it does
+// not depend on where the window cuts a period. With fewer than two
+// pulses in the window the period between the last two is used. The
+// time since the last pulse bounds the period from below, a shaft that
+// slows down or stops is not shown at the speed of its last pulses,
+// and no pulse for the timeout is a stopped shaft.
+type meter struct {
+	window  time.Duration
+	timeout time.Duration
+
+	times    []time.Time   // the pulses in the window, oldest first
+	interval time.Duration // between the last two pulses, 0 for none
+	last     time.Time
+	count    int64
+}
+
+// pulse records a pulse at at. A pulse after a stop starts timing
+// afresh, the time it was stopped is not a period.
+func (m *meter) pulse(at time.Time) {
+	m.interval = 0
+	if !m.last.IsZero() && at.Sub(m.last) < m.timeout {
+		m.interval = at.Sub(m.last)
+	}
+	if m.interval == 0 {
+		m.times = m.times[:0]
+	}
+	m.last = at
+	m.count++
+	m.times = append(m.times, at)
+	if len(m.times) > maxSamples {
+		m.times = m.times[len(m.times)-maxSamples:]
+	}
+	m.prune(at)
+}
+
+// prune drops the pulses that left the window by at
+func (m *meter) prune(at time.Time) {
+	i := 0
+	for i < len(m.times) && at.Sub(m.times[i]) > m.window {
+		i++
+	}
+	m.times = m.times[i:]
+}
+
+// period returns the pulse period at now, 0 when the shaft is stopped
+// or has only turned a single pulse
+func (m *meter) period(now time.Time) time.Duration {
+	since := now.Sub(m.last)
+	if m.last.IsZero() || since >= m.timeout {
+		return 0
+	}
+	m.prune(now)
+	p := m.interval
+	if n := len(m.times); n >= 2 {
+		p = m.times[n-1].Sub(m.times[0]) / time.Duration(n-1)
+	}
+	if p == 0 {
+		return 0
+	}
+	return max(p, since)
+}
+
+// rpm returns the speed at now for ppr pulses a revolution
+func (m *meter) rpm(ppr int, now time.Time) float64 {
+	p := m.period(now)
+	if p == 0 || ppr <= 0 {
+		return 0
+	}
+	return time.Minute.Seconds() / p.Seconds() / float64(ppr)
+}
@@ -0,0 +1,305 @@
+// Package rpmsensor measures the speed of a shaft with a hall effect
+// sensor, like the A3144, and a magnet on the shaft, counting the
+// pulses of the sensor on a GPIO line.
+//
+// The speed is the mean period of the pulses in the measurement
+// window. At low speeds, with fewer than two pulses in the window, the
+// period between the last two pulses is used, and the time since the
+// last pulse bounds it so a slowing shaft shows its speed falling.
+// With no pulse for the timeout the shaft is stopped, at 0 RPM. The
+// speed and the revolutions since the start are published, and an
+// AlertEvent when the speed goes over or under the alert thresholds.
+package rpmsensor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultPulsesPerRev is one magnet on the shaft
+	DefaultPulsesPerRev = 1
+
+	// DefaultWindow is the measurement window
+	DefaultWindow = time.Second
+
+	// DefaultTimeout is how long without a pulse the shaft is
+	// stopped, the lowest speed measured is a revolution in it, 20 RPM
+	// with one magnet
+	DefaultTimeout = 3 * time.Second
+
+	// EventBufferSize is the number of events the kernel holds for
+	// the line, a tenth of a second at 100,000 RPM with one magnet
+	EventBufferSize = 256
+)
+
+var (
+	ErrPulsesPerRev = errors.New("pulses per revolution must be at least 1")
+	ErrDuration     = errors.New("invalid duration")
+	ErrAlert        = errors.New("invalid alert thresholds")
+	ErrCommand      = errors.New("unknown command")
+)
+
+// Reading is the speed of the shaft and the revolutions since the
+// start
+type Reading struct {
+	RPM         float64 `json:"rpm"`
+	Revolutions float64 `json:"revolutions"`
+	Pulses      int64   `json:"pulses"`
+	Stopped     bool    `json:"stopped"`
+}
+
+// AlertEvent is published when the speed goes over the over-speed
+// threshold, "over", under the under-speed one, "under", and when it
+// is back within them by the hysteresis, "normal"
+type AlertEvent struct {
+	Event string  `json:"event"`
+	RPM   float64 `json:"rpm"`
+}
+
+// RPMSensor is a hall effect sensor on a GPIO line
+type RPMSensor struct {
+	*device.Device
+
+	pin   *drivers.DigitalPin
+	ppr   int
+	meter meter
+
+	under, over float64
+	hysteresis  float64
+	state       string // "", "under" or "over"
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates a sensor on the line at offset of the default chip,
+// counting falling edges, the A3144 pulls its open collector output
+// low at the magnet. opts are added to the line request after the
+// defaults, like a pull up where the board has none.
+func New(name string, offset int, opts ...gpiocdev.LineReqOption) (*RPMSensor, error) {
+	r := &RPMSensor{
+		Device: device.NewDevice(name, "mqtt"),
+		ppr:    DefaultPulsesPerRev,
+		meter:  meter{window: DefaultWindow, timeout: DefaultTimeout},
+		now:    time.Now,
+	}
+	if device.IsMock() {
+		return r, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("rpmsensor"),
+		gpiocdev.AsInput,
+		gpiocdev.WithFallingEdge,
+		gpiocdev.WithEventBufferSize(EventBufferSize),
+		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			if evt.Type == gpiocdev.LineEventFallingEdge {
+				r.pulse(r.now())
+			}
+		}),
+	}, opts...)
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.pin = pin
+	r.mu.Unlock()
+	return r, nil
+}
+
+// Name returns the name of the device
+func (r *RPMSensor) Name() string {
+	return r.Device.Name
+}
+
+// SetPulsesPerRev sets the pulses a revolution, the number of magnets
+// on the shaft
+func (r *RPMSensor) SetPulsesPerRev(n int) error {
+	if n < 1 {
+		return fmt.Errorf("%w: %d", ErrPulsesPerRev, n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ppr = n
+	return nil
+}
+
+// SetWindow sets the measurement window, a longer one smooths the
+// speed of a shaft that does not turn evenly
+func (r *RPMSensor) SetWindow(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%w: window %v", ErrDuration, d)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.meter.window = d
+	return nil
+}
+
+// SetTimeout sets how long without a pulse the shaft is stopped, it
+// has to be longer than a revolution at the lowest speed to measure
+func (r *RPMSensor) SetTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%w: timeout %v", ErrDuration, d)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.meter.timeout = d
+	return nil
+}
+
+// SetAlert publishes an AlertEvent when the speed goes under under or
+// over over, and again once it is back within them by hysteresis so a
+// speed hovering around a threshold does not flap. A 0 threshold
+// turns its side off.
+func (r *RPMSensor) SetAlert(under, over, hysteresis float64) error {
+	if under < 0 || over < 0 || hysteresis < 0 || (over > 0 && under >= over) {
+		return fmt.Errorf("%w: under %g over %g hysteresis %g", ErrAlert, under, over, hysteresis)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.under, r.over, r.hysteresis = under, over, hysteresis
+	r.state = ""
+	return nil
+}
+
+// Read returns the speed now and the revolutions
+func (r *RPMSensor) Read() *Reading {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rpm := r.meter.rpm(r.ppr, r.now())
+	return &Reading{
+		RPM:         rpm,
+		Revolutions: float64(r.meter.count) / float64(r.ppr),
+		Pulses:      r.meter.count,
+		Stopped:     rpm == 0,
+	}
+}
+
+// Command handles a command payload: "ppr 2", "window 2s", "timeout
+// 5s" or "alert <under> <over> <hysteresis>"
+func (r *RPMSensor) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(c) == 2 && c[0] == "ppr":
+		n, err := strconv.Atoi(c[1])
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrPulsesPerRev, c[1])
+		}
+		return r.SetPulsesPerRev(n)
+	case len(c) == 2 && (c[0] == "window" || c[0] == "timeout"):
+		d, err := time.ParseDuration(c[1])
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrDuration, c[1])
+		}
+		if c[0] == "window" {
+			return r.SetWindow(d)
+		}
+		return r.SetTimeout(d)
+	case len(c) == 4 && c[0] == "alert":
+		var v [3]float64
+		for i, s := range c[1:] {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %q", ErrAlert, s)
+			}
+			v[i] = f
+		}
+		return r.SetAlert(v[0], v[1], v[2])
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub publishes the Reading, and an AlertEvent when the speed
+// crossed a threshold
+func (r *RPMSensor) ReadPub() error {
+	rd := r.Read()
+	if err := r.publish(rd); err != nil {
+		return err
+	}
+	if evt := r.check(rd.RPM); evt != nil {
+		return r.publish(evt)
+	}
+	return nil
+}
+
+// check returns the AlertEvent for a speed of rpm, nil if the alert
+// state did not change
+func (r *RPMSensor) check(rpm float64) *AlertEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state
+	switch {
+	case r.under > 0 && rpm < r.under:
+		state = "under"
+	case r.over > 0 && rpm > r.over:
+		state = "over"
+	case state == "under" && rpm >= r.under+r.hysteresis,
+		state == "over" && rpm <= r.over-r.hysteresis:
+		state = ""
+	}
+	if state == r.state {
+		return nil
+	}
+	r.state = state
+	if state == "" {
+		return &AlertEvent{Event: "normal", RPM: rpm}
+	}
+	return &AlertEvent{Event: state, RPM: rpm}
+}
+
+// Run publishes the Reading every period until ctx is canceled
+func (r *RPMSensor) Run(ctx context.Context, period time.Duration) error {
+	err := r.TimerLoop(ctx, period, r.ReadPub)
+	slog.Debug("rpmsensor stopped", "device", r.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the line
+func (r *RPMSensor) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pin == nil {
+		return nil
+	}
+	err := r.pin.Close()
+	r.pin = nil
+	return err
+}
+
+// MockPulses spreads n pulses evenly over the span ending now, in
+// mock mode
+func (r *RPMSensor) MockPulses(n int, span time.Duration) {
+	end := r.now()
+	for i := range n {
+		r.pulse(end.Add(-span + time.Duration(i+1)*span/time.Duration(n)))
+	}
+}
+
+func (r *RPMSensor) pulse(at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.meter.pulse(at)
+}
+
+func (r *RPMSensor) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.PubData(j)
+	return nil
+}
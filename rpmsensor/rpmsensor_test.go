@@ -0,0 +1,261 @@
+package rpmsensor
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+var base = time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+
+// ms returns the time ms milliseconds after base
+func ms(n int) time.Time {
+	return base.Add(time.Duration(n) * time.Millisecond)
+}
+
+// check is the speed expected at a time in milliseconds
+type check struct {
+	at  int
+	rpm float64
+}
+
+// run feeds the pulses at their times in milliseconds to m, checking
+// the speed in between
+func run(t *testing.T, m *meter, ppr int, pulses []int, checks []check) {
+	t.Helper()
+	i := 0
+	for _, c := range checks {
+		for i < len(pulses) && pulses[i] <= c.at {
+			m.pulse(ms(pulses[i]))
+			i++
+		}
+		if got := m.rpm(ppr, ms(c.at)); math.Abs(got-c.rpm) > 0.01 {
+			t.Errorf("rpm at %dms got (%.2f) want (%.2f)", c.at, got, c.rpm)
+		}
+	}
+}
+
+// every returns the pulses every period ms from start, n of them
+func every(start, period, n int) []int {
+	var p []int
+	for i := range n {
+		p = append(p, start+i*period)
+	}
+	return p
+}
+
+func TestSteady(t *testing.T) {
+	// 600 RPM with two magnets, a pulse every 50ms
+	m := &meter{window: DefaultWindow, timeout: DefaultTimeout}
+	run(t, m, 2, every(0, 50, 40), []check{
+		{0, 0}, // a single pulse has no period
+		{50, 600},
+		{1000, 600},
+		{1025, 600}, // between two pulses
+		{1950, 600},
+	})
+
+	// jitter averages out over the window
+	var jitter []int
+	for i, at := 0, 0; i < 40; i++ {
+		jitter = append(jitter, at)
+		at += 40 + 20*(i%2)
+	}
+	m = &meter{window: DefaultWindow, timeout: DefaultTimeout}
+	run(t, m, 2, jitter, []check{{1900, 600}})
+}
+
+func TestStopping(t *testing.T) {
+	// a shaft coasting to a stop, one magnet: 1200 RPM and slowing
+	pulses := []int{0, 50, 100, 150, 250, 450, 850, 1650}
+	m := &meter{window: DefaultWindow, timeout: DefaultTimeout}
+	run(t, m, 1, pulses, []check{
+		{150, 1200},
+		{250, 960}, // 4 periods in 250ms
+		{850, 60000.0 / (850.0 / 6)},
+		// the last period alone is left in the window
+		{1650, 60000.0 / 800},
+		// no pulse longer than the last period, the speed is bounded
+		// by the time since the last pulse, not the stale period
+		{2650, 60},
+		{3650, 30},
+		{4600, 60000.0 / 2950},
+		// stopped after the timeout
+		{4650, 0},
+		{9000, 0},
+	})
+
+	// the speed never rises after the last pulse
+	prev := math.Inf(1)
+	for at := 1650; at < 5000; at += 10 {
+		got := m.rpm(1, ms(at))
+		if got > prev {
+			t.Fatalf("rpm rose coasting at %dms from (%.2f) to (%.2f)", at, prev, got)
+		}
+		prev = got
+	}
+}
+
+func TestLowSpeed(t *testing.T) {
+	// 30 RPM, a pulse every 2s, longer than the window
+	m := &meter{window: DefaultWindow, timeout: DefaultTimeout}
+	run(t, m, 1, every(0, 2000, 4), []check{
+		{1000, 0},
+		{2000, 30},
+		{3000, 30},
+		{4000, 30},
+		{6000, 30},
+		{8500, 60000.0 / 2500},
+		{9000, 0},
+	})
+
+	// started again after a stop, the stop is not a period
+	run(t, m, 1, []int{20000, 20500}, []check{
+		{20000, 0},
+		{20100, 0},
+		{20500, 120},
+	})
+}
+
+func TestMaxSamples(t *testing.T) {
+	// 60,000 RPM with 8 magnets fills the window past the cap
+	m := &meter{window: DefaultWindow, timeout: DefaultTimeout}
+	for i := range 10000 {
+		m.pulse(base.Add(time.Duration(i) * 125 * time.Microsecond))
+	}
+	if len(m.times) > maxSamples {
+		t.Errorf("samples got (%d) want at most (%d)", len(m.times), maxSamples)
+	}
+	if got := m.rpm(8, base.Add(9999*125*time.Microsecond)); math.Abs(got-60000) > 0.01 {
+		t.Errorf("rpm got (%.2f) want (60000)", got)
+	}
+}
+
+func TestRead(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	clock := base
+	r, err := New("spindle", 5)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	r.now = func() time.Time { return clock }
+	if err := r.SetPulsesPerRev(4); err != nil {
+		t.Fatalf("SetPulsesPerRev() error = %v", err)
+	}
+	clock = ms(3000)
+	r.MockPulses(200, 3*time.Second) // 1000 RPM
+	rd := r.Read()
+	if math.Abs(rd.RPM-1000) > 0.01 || rd.Revolutions != 50 || rd.Pulses != 200 || rd.Stopped {
+		t.Errorf("Read() got (%+v) want 1000 RPM 50 revolutions", rd)
+	}
+	clock = clock.Add(DefaultTimeout)
+	if rd := r.Read(); rd.RPM != 0 || !rd.Stopped || rd.Revolutions != 50 {
+		t.Errorf("Read() stopped got (%+v) want 0 RPM 50 revolutions", rd)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	r, err := New("spindle", 5)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := r.SetAlert(1000, 500, 10); !errors.Is(err, ErrAlert) {
+		t.Errorf("SetAlert() under over over error got (%v) want (%v)", err, ErrAlert)
+	}
+	if err := r.SetAlert(500, 3000, 50); err != nil {
+		t.Fatalf("SetAlert() error = %v", err)
+	}
+	tests := []struct {
+		rpm  float64
+		want string
+	}{
+		{1000, ""},
+		{3001, "over"},
+		{2960, ""}, // within the hysteresis
+		{2950, "normal"},
+		{499, "under"},
+		{540, ""},
+		{550, "normal"},
+		{0, "under"}, // stopped
+		{3500, "over"},
+	}
+	for _, tt := range tests {
+		evt := r.check(tt.rpm)
+		got := ""
+		if evt != nil {
+			got = evt.Event
+		}
+		if got != tt.want {
+			t.Errorf("check(%g) got (%q) want (%q)", tt.rpm, got, tt.want)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	r, err := New("spindle", 5)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tests := []struct {
+		payload string
+		err     error
+	}{
+		{"ppr 2", nil},
+		{"Window 2s", nil},
+		{"timeout 10s", nil},
+		{"alert 100 2000 20", nil},
+		{"ppr 0", ErrPulsesPerRev},
+		{"ppr two", ErrPulsesPerRev},
+		{"window 0s", ErrDuration},
+		{"timeout later", ErrDuration},
+		{"alert 100 x 20", ErrAlert},
+		{"alert 2000 100 20", ErrAlert},
+		{"reset", ErrCommand},
+	}
+	for _, tt := range tests {
+		if err := r.Command([]byte(tt.payload)); !errors.Is(err, tt.err) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", tt.payload, err, tt.err)
+		}
+	}
+	if r.ppr != 2 || r.meter.window != 2*time.Second || r.meter.timeout != 10*time.Second || r.over != 2000 {
+		t.Errorf("settings got (ppr %d window %v timeout %v over %g)", r.ppr, r.meter.window, r.meter.timeout, r.over)
+	}
+}
+
+func TestLine(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	r, err := New("shaft", 6)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	line := chip.Line(6)
+	if line == nil {
+		t.Fatal("New() did not request the line")
+	}
+	for range 3 {
+		line.Edge(0)
+		line.Edge(1)
+	}
+	if rd := r.Read(); rd.Pulses != 3 {
+		t.Errorf("pulses got (%d) want (3) falling edges", rd.Pulses)
+	}
+	r.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
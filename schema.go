@@ -0,0 +1,134 @@
+package device
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+// SchemaDraft is the JSON Schema draft the schemas are written in
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, the keywords a payload is described with.
+// Unit is not one of the draft, an annotation the validators ignore,
+// the unit of a number like Entity has it.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Unit        string             `json:"unit,omitempty"`
+	Const       any                `json:"const,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+}
+
+// Object is the schema of an object of props, every one of them
+// required
+func Object(props map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Properties: props, Required: slices.Sorted(maps.Keys(props))}
+}
+
+// Number is the schema of a number in unit
+func Number(description, unit string) *Schema {
+	return &Schema{Type: "number", Description: description, Unit: unit}
+}
+
+// Schemas are the schemas of the payloads of a device, of its data
+// and of its state, nil for the one it does not publish
+type Schemas struct {
+	Data  *Schema `json:"data,omitempty"`
+	State *Schema `json:"state,omitempty"`
+}
+
+// Describer is a device that describes the payloads it publishes as
+// they are now, the DeviceManager publishes their Schemas when it is
+// added. One whose payloads change with its configuration publishes
+// them again with PubSchemas when it changes.
+type Describer interface {
+	Schemas() Schemas
+}
+
+// SchemaTopic returns the topic the schema of the payloads on topic
+// goes on
+func SchemaTopic(topic string) string {
+	return topic + "/schema"
+}
+
+// MessageSchemas returns the schemas of the messages the device
+// publishes the payloads of s in, the Envelope wrapping them when the
+// device wraps its payloads. They describe them as JSON, a device
+// with another Encoder carries the same data.
+func (d *Device) MessageSchemas(s Schemas) Schemas {
+	return Schemas{
+		Data:  d.messageSchema(d.Name+" data", s.Data),
+		State: d.messageSchema(d.Name+" state", s.State),
+	}
+}
+
+func (d *Device) messageSchema(title string, data *Schema) *Schema {
+	if data == nil {
+		return nil
+	}
+	if !d.Enveloped() {
+		s := *data
+		s.Schema = SchemaDraft
+		s.Title = title
+		return &s
+	}
+	one := 1.0
+	return &Schema{
+		Schema: SchemaDraft,
+		Title:  title,
+		Type:   "object",
+		Properties: map[string]*Schema{
+			"schema": {Const: SchemaVersion},
+			"ts":     {Type: "string", Format: "date-time"},
+			"device": {Const: d.Name},
+			"seq":    {Type: "integer", Minimum: &one},
+			"replay": {Type: "boolean"},
+			"data":   data,
+		},
+		Required: []string{"data", "device", "schema", "seq", "ts"},
+	}
+}
+
+// PubSchemas publishes the schemas of the messages of the device
+// retained on the SchemaTopic of its data and state topics, the one
+// of a payload it does not publish cleared
+func (d *Device) PubSchemas(s Schemas) error {
+	s = d.MessageSchemas(s)
+	pub := func(topic string, schema *Schema) error {
+		if schema == nil {
+			return d.PubRetained(SchemaTopic(topic), nil)
+		}
+		return d.PubRetained(SchemaTopic(topic), schema)
+	}
+	return errors.Join(
+		pub(d.DataTopic(), s.Data),
+		pub(d.StateTopic(), s.State),
+	)
+}
+
+// ClearSchemas clears the schemas of the device
+func (d *Device) ClearSchemas() error {
+	return errors.Join(
+		d.PubRetained(SchemaTopic(d.DataTopic()), nil),
+		d.PubRetained(SchemaTopic(d.StateTopic()), nil),
+	)
+}
+
+// describer is a Describer that can publish its schemas, one
+// embedding a Device
+type describer interface {
+	Describer
+	MessageSchemas(Schemas) Schemas
+	PubSchemas(Schemas) error
+	ClearSchemas() error
+}
@@ -0,0 +1,97 @@
+package device
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type described struct {
+	named
+	schemas Schemas
+}
+
+func (d described) Schemas() Schemas { return d.schemas }
+
+func TestSchemas(t *testing.T) {
+	dm := GetDeviceManager()
+	defer dm.Clear()
+	m := NewMemMessanger()
+
+	data := Object(map[string]*Schema{"flow": Number("flow", "L/min")})
+	pump := described{named{NewDevice("pump", "mqtt", WithMessanger(m))}, Schemas{Data: data}}
+	m.PublishRetained("ss/s/station/pump/schema", []byte("{}"))
+	if err := dm.Add(pump); err != nil {
+		t.Fatalf("Add() error (%v)", err)
+	}
+
+	msg, ok := m.Retained("ss/d/station/pump/schema")
+	if !ok {
+		t.Fatal("data schema not retained")
+	}
+	var got map[string]any
+	json.Unmarshal(msg.Data, &got)
+	want := map[string]any{
+		"$schema": SchemaDraft,
+		"title":   "pump data",
+		"type":    "object",
+		"properties": map[string]any{
+			"schema": map[string]any{"const": 1.0},
+			"ts":     map[string]any{"type": "string", "format": "date-time"},
+			"device": map[string]any{"const": "pump"},
+			"seq":    map[string]any{"type": "integer", "minimum": 1.0},
+			"replay": map[string]any{"type": "boolean"},
+			"data": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"flow": map[string]any{"type": "number", "description": "flow", "unit": "L/min"}},
+				"required":   []any{"flow"},
+			},
+		},
+		"required": []any{"data", "device", "schema", "seq", "ts"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("data schema got (%s)", msg.Data)
+	}
+	if _, ok := m.Retained("ss/s/station/pump/schema"); ok {
+		t.Error("state schema of a device without one not cleared")
+	}
+
+	// bare payloads are described bare
+	fan := NewDevice("fan", "mqtt", WithMessanger(m), WithEnvelope(false))
+	fan.PubSchemas(Schemas{State: &Schema{Type: "integer", Enum: []any{0, 1}}})
+	msg, _ = m.Retained("ss/s/station/fan/schema")
+	if want := `{"$schema":"` + SchemaDraft + `","title":"fan state","type":"integer","enum":[0,1]}`; msg.String() != want {
+		t.Errorf("bare state schema got (%s) want (%s)", msg, want)
+	}
+
+	srv := httptest.NewServer(dm.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/devices/pump/schema")
+	if err != nil {
+		t.Fatalf("GET schema error (%v)", err)
+	}
+	var served Schemas
+	json.NewDecoder(resp.Body).Decode(&served)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || served.Data == nil || served.Data.Title != "pump data" || served.State != nil {
+		t.Errorf("GET schema got %d (%+v)", resp.StatusCode, served)
+	}
+	dm.Add(named{NewDevice("plain", "mqtt")})
+	for _, name := range []string{"plain", "nope"} {
+		resp, err := http.Get(srv.URL + "/devices/" + name + "/schema")
+		if err != nil {
+			t.Fatalf("GET %s schema error (%v)", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s schema got %d want 404", name, resp.StatusCode)
+		}
+	}
+
+	dm.Remove("pump")
+	if _, ok := m.Retained("ss/d/station/pump/schema"); ok {
+		t.Error("data schema not cleared on Remove")
+	}
+}
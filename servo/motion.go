@@ -0,0 +1,77 @@
+package servo
+
+import (
+	"math"
+	"time"
+)
+
+// leg is one move of the horn to target at rate degrees per second,
+// a rate of 0 moves there at once
+type leg struct {
+	target float64
+	rate   float64
+}
+
+// motion estimates where the horn is. A hobby servo does not report
+// its position, the estimate assumes it follows the pulse we send and
+// the pulse is ramped along the legs in order.
+type motion struct {
+	known bool // false until the first move, the power on angle is anyone's guess
+	angle float64
+	at    time.Time // when the horn was at angle
+	legs  []leg
+}
+
+// position advances the estimate to t and returns the angle
+func (m *motion) position(t time.Time) float64 {
+	for len(m.legs) > 0 {
+		l := m.legs[0]
+		d := l.target - m.angle
+		if l.rate <= 0 || d == 0 {
+			m.angle = l.target
+			m.legs = m.legs[1:]
+			continue
+		}
+		need := time.Duration(math.Abs(d) / l.rate * float64(time.Second))
+		el := t.Sub(m.at)
+		if el >= need {
+			m.angle = l.target
+			m.at = m.at.Add(need)
+			m.legs = m.legs[1:]
+			continue
+		}
+		if el > 0 {
+			m.angle += math.Copysign(l.rate*el.Seconds(), d)
+			m.at = t
+		}
+		return m.angle
+	}
+	m.at = t
+	return m.angle
+}
+
+// move replaces the legs still to go with legs starting from where the
+// horn is at t. The first move jumps, there is nothing to ramp from.
+func (m *motion) move(t time.Time, legs ...leg) {
+	m.position(t)
+	if !m.known && len(legs) > 0 {
+		m.known = true
+		m.angle = legs[0].target
+		m.at = t
+		legs = legs[1:]
+	}
+	m.legs = legs
+}
+
+// moving returns whether there are legs to go
+func (m *motion) moving() bool {
+	return len(m.legs) > 0
+}
+
+// target returns the angle the horn ends up at
+func (m *motion) target() float64 {
+	if n := len(m.legs); n > 0 {
+		return m.legs[n-1].target
+	}
+	return m.angle
+}
@@ -0,0 +1,300 @@
+// Package servo drives a standard hobby servo from a drivers.PWMChannel,
+// a kernel pwmchip, a PCA9685 channel or a soft PWM on any pin.
+//
+// The angle is mapped linearly from 0 - Range degrees onto MinPulse -
+// MaxPulse. With a SlewRate the pulse is ramped a frame at a time so a
+// big jump does not slam the gears, and with DetachAfter the pulse
+// train is stopped once the horn has settled so it does not sit there
+// buzzing. A hobby servo does not report its position, the angle
+// published is an estimate that assumes the horn keeps up.
+package servo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// Frequency is the pulse rate of a standard servo, one frame
+	// every 20ms
+	Frequency = 50
+
+	// DefaultMinPulse and DefaultMaxPulse are the pulse widths of 0
+	// and DefaultRange degrees, most servos go a little further in
+	// both directions, check before widening them
+	DefaultMinPulse = time.Millisecond
+	DefaultMaxPulse = 2 * time.Millisecond
+	DefaultRange    = 180
+)
+
+var (
+	ErrAngle   = errors.New("servo angle is not a number")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes. The angles are nil until the
+// first move.
+type Reading struct {
+	Target   *float64 `json:"target_deg"`
+	Angle    *float64 `json:"angle_deg"`
+	Attached bool     `json:"attached"`
+}
+
+// Servo is a hobby servo on a pwm channel
+type Servo struct {
+	*device.Device
+	drivers.PWMChannel
+
+	// MinPulse, MaxPulse and Range map angles to pulse widths, set
+	// them before the first move
+	MinPulse time.Duration
+	MaxPulse time.Duration
+	Range    float64
+
+	// SlewRate limits the speed of the horn in degrees per second, 0
+	// moves at the speed of the servo
+	SlewRate float64
+
+	// DetachAfter stops the pulses this long after the horn settles,
+	// 0 keeps holding the angle
+	DetachAfter time.Duration
+
+	m        motion
+	attached bool
+
+	frame       time.Duration
+	moveTimer   *device.Timer
+	detachTimer *device.Timer
+
+	mu sync.Mutex
+}
+
+// New creates a servo on channel of the pwm chip, a kernel pwmchip or
+// a controller registered with drivers.RegisterPWMChip
+func New(name, chip string, channel int) (*Servo, error) {
+	if device.IsMock() {
+		return NewWithPWM(name, &mockPWM{})
+	}
+	pwm, err := drivers.OpenPWM(chip, channel)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewWithPWM(name, pwm)
+	if err != nil {
+		pwm.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewWithPWM creates a servo on pwm, like a drivers.SoftPWM. The
+// frequency is set to Frequency, no pulses are sent until the first
+// move.
+func NewWithPWM(name string, pwm drivers.PWMChannel) (*Servo, error) {
+	if err := pwm.SetFrequency(Frequency); err != nil {
+		return nil, err
+	}
+	return &Servo{
+		Device:     device.NewDevice(name, "mqtt"),
+		PWMChannel: pwm,
+		MinPulse:   DefaultMinPulse,
+		MaxPulse:   DefaultMaxPulse,
+		Range:      DefaultRange,
+		frame:      time.Second / Frequency,
+	}, nil
+}
+
+// Name returns the name of the device
+func (s *Servo) Name() string {
+	return s.Device.Name
+}
+
+// SetAngle moves the horn to deg at the slew rate, deg is clamped to
+// 0 - Range. The first move goes straight there, where the horn was
+// at power on is not known.
+func (s *Servo) SetAngle(deg float64) error {
+	if math.IsNaN(deg) {
+		return ErrAngle
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m.move(s.Now(), leg{target: s.clamp(deg), rate: s.SlewRate})
+	return s.update(s.Now())
+}
+
+// Sweep moves the horn to from at the slew rate and then to to in d
+func (s *Servo) Sweep(from, to float64, d time.Duration) error {
+	if math.IsNaN(from) || math.IsNaN(to) {
+		return ErrAngle
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from, to = s.clamp(from), s.clamp(to)
+	rate := 0.0
+	if d > 0 {
+		rate = math.Abs(to-from) / d.Seconds()
+	}
+	s.m.move(s.Now(), leg{target: from, rate: s.SlewRate}, leg{target: to, rate: rate})
+	return s.update(s.Now())
+}
+
+// Detach stops the pulses, the servo stops holding its angle
+func (s *Servo) Detach() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.detach()
+}
+
+// Command handles a command payload: "angle 90", "sweep 0 180 2s" or
+// "detach"
+func (s *Servo) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(f) == 2 && f[0] == "angle":
+		deg, err := strconv.ParseFloat(f[1], 64)
+		if err == nil {
+			return s.SetAngle(deg)
+		}
+	case len(f) == 4 && f[0] == "sweep":
+		from, err1 := strconv.ParseFloat(f[1], 64)
+		to, err2 := strconv.ParseFloat(f[2], 64)
+		d, err3 := time.ParseDuration(f[3])
+		if err1 == nil && err2 == nil && err3 == nil && d >= 0 {
+			return s.Sweep(from, to, d)
+		}
+	case len(f) == 1 && f[0] == "detach":
+		return s.Detach()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Pulse returns the pulse width of deg, clamped to 0 - Range
+func (s *Servo) Pulse(deg float64) time.Duration {
+	span := float64(s.MaxPulse - s.MinPulse)
+	return s.MinPulse + time.Duration(math.Round(span*s.clamp(deg)/s.Range))
+}
+
+// Read returns the target and the estimated angle
+func (s *Servo) Read() *Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Reading{Attached: s.attached}
+	if s.m.known {
+		angle, target := s.m.position(s.Now()), s.m.target()
+		r.Angle, r.Target = &angle, &target
+	}
+	return r
+}
+
+// ReadPub publishes the reading
+func (s *Servo) ReadPub() error {
+	j, err := json.Marshal(s.Read())
+	if err != nil {
+		return err
+	}
+	s.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (s *Servo) Run(ctx context.Context, period time.Duration) error {
+	err := s.TimerLoop(ctx, period, s.ReadPub)
+	slog.Debug("servo stopped", "device", s.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the pulses and closes the pwm channel
+func (s *Servo) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stop(&s.moveTimer)
+	stop(&s.detachTimer)
+	s.detach()
+	return s.PWMChannel.Close()
+}
+
+func (s *Servo) clamp(deg float64) float64 {
+	return min(max(deg, 0), s.Range)
+}
+
+// update sends the pulse of the angle at t and schedules the next
+// frame while moving, or the detach once settled. s.mu is held.
+func (s *Servo) update(t time.Time) error {
+	stop(&s.moveTimer)
+	stop(&s.detachTimer)
+
+	angle := s.m.position(t)
+	duty := s.Pulse(angle).Seconds() * Frequency
+	if err := s.PWMChannel.SetDuty(duty); err != nil {
+		return err
+	}
+	s.attached = true
+
+	switch {
+	case s.m.moving():
+		s.moveTimer = s.Clock().AfterFunc(s.frame, s.step)
+	case s.DetachAfter > 0:
+		s.detachTimer = s.Clock().AfterFunc(s.DetachAfter, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.detachTimer = nil
+			if err := s.detach(); err != nil {
+				slog.Warn("servo detach", "device", s.Device.Name, "error", err)
+			}
+		})
+	}
+	return nil
+}
+
+// step sends the next frame of a move
+func (s *Servo) step() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveTimer = nil
+	if !s.attached {
+		return // detached while the timer fired
+	}
+	if err := s.update(s.Now()); err != nil {
+		slog.Warn("servo move", "device", s.Device.Name, "error", err)
+	}
+}
+
+// detach sends no pulses, a move is stopped where the horn is.
+// s.mu is held.
+func (s *Servo) detach() error {
+	if !s.attached {
+		return nil
+	}
+	stop(&s.moveTimer)
+	s.m.move(s.Now())
+	if err := s.PWMChannel.SetDuty(0); err != nil {
+		return err
+	}
+	s.attached = false
+	return nil
+}
+
+func stop(t **device.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
+
+// mockPWM is the pwm channel of a mock servo
+type mockPWM struct{}
+
+func (*mockPWM) SetFrequency(float64) error { return nil }
+func (*mockPWM) SetDuty(float64) error      { return nil }
+func (*mockPWM) Close() error               { return nil }
@@ -0,0 +1,253 @@
+package servo
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// newTestServo returns a servo on a fake pwm with the clock under the
+// test's control. The frame timer is pushed out of the way, the test
+// steps the moves itself.
+func newTestServo(t *testing.T) (*Servo, *driverstest.PWM, *device.SimClock) {
+	t.Helper()
+	pwm := driverstest.NewPWM()
+	s, err := NewWithPWM("servo", pwm)
+	if err != nil {
+		t.Fatalf("NewWithPWM() error = %v", err)
+	}
+	clock := device.NewSimClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s.SetClock(clock)
+	s.frame = time.Hour
+	t.Cleanup(func() { s.Close() })
+	return s, pwm, clock
+}
+
+func TestPulse(t *testing.T) {
+	s, pwm, _ := newTestServo(t)
+	if pwm.Freq != Frequency {
+		t.Errorf("frequency got (%v) want (%v)", pwm.Freq, Frequency)
+	}
+	if len(pwm.Duties) != 0 {
+		t.Errorf("duties got (%v) want no pulses before a move", pwm.Duties)
+	}
+
+	tests := []struct {
+		deg  float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{90, 1500 * time.Microsecond},
+		{180, 2 * time.Millisecond},
+		{45, 1250 * time.Microsecond},
+		{-20, time.Millisecond},
+		{270, 2 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := s.Pulse(tt.deg); got != tt.want {
+			t.Errorf("Pulse(%v) got (%v) want (%v)", tt.deg, got, tt.want)
+		}
+	}
+
+	// a 270 degree servo on 0.5 - 2.5ms
+	s.MinPulse, s.MaxPulse, s.Range = 500*time.Microsecond, 2500*time.Microsecond, 270
+	if got := s.Pulse(135); got != 1500*time.Microsecond {
+		t.Errorf("270 degree Pulse(135) got (%v) want (1.5ms)", got)
+	}
+	if err := s.SetAngle(300); err != nil {
+		t.Fatalf("SetAngle() error = %v", err)
+	}
+	if !near(pwm.Duty, 0.125) {
+		t.Errorf("SetAngle(300) duty got (%v) want clamped to (0.125)", pwm.Duty)
+	}
+	if err := s.SetAngle(math.NaN()); !errors.Is(err, ErrAngle) {
+		t.Errorf("SetAngle(NaN) error got (%v) want (%v)", err, ErrAngle)
+	}
+}
+
+func TestMotion(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+
+	var m motion
+	m.move(t0, leg{target: 30, rate: 90})
+	if !m.known || m.moving() || m.position(t0) != 30 {
+		t.Fatalf("the first move got (%v, moving %v) want a jump to 30", m.angle, m.moving())
+	}
+
+	m.move(t0, leg{target: 120, rate: 90})
+	tests := []struct {
+		at   time.Duration
+		want float64
+	}{
+		{0, 30},
+		{250 * time.Millisecond, 52.5},
+		{500 * time.Millisecond, 75},
+		{time.Second, 120},
+		{2 * time.Second, 120},
+	}
+	for _, tt := range tests {
+		if got := m.position(at(tt.at)); !near(got, tt.want) {
+			t.Errorf("position(%v) got (%v) want (%v)", tt.at, got, tt.want)
+		}
+	}
+	if m.moving() {
+		t.Error("moving() after the move got (true) want (false)")
+	}
+
+	// turning back half way ramps back from where the horn is
+	m.move(at(2*time.Second), leg{target: 0, rate: 60})
+	m.move(at(3*time.Second), leg{target: 120, rate: 60})
+	if got := m.position(at(3500 * time.Millisecond)); !near(got, 90) {
+		t.Errorf("position() after turning back got (%v) want (90)", got)
+	}
+
+	// the sweep legs run one after the other
+	m.move(at(4*time.Second), leg{target: 0}, leg{target: 180, rate: 90})
+	if m.target() != 180 {
+		t.Errorf("target() got (%v) want (180)", m.target())
+	}
+	if got := m.position(at(5 * time.Second)); !near(got, 90) {
+		t.Errorf("sweep position() got (%v) want (90)", got)
+	}
+	if got := m.position(at(7 * time.Second)); !near(got, 180) || m.moving() {
+		t.Errorf("sweep position() at the end got (%v) want (180)", got)
+	}
+}
+
+func TestSlew(t *testing.T) {
+	s, pwm, clock := newTestServo(t)
+	start := clock.Now()
+	s.SlewRate = 90
+	s.SetAngle(0)
+	if !near(pwm.Duty, 0.05) {
+		t.Fatalf("SetAngle(0) duty got (%v) want (0.05)", pwm.Duty)
+	}
+
+	s.SetAngle(180)
+	if !near(pwm.Duty, 0.05) {
+		t.Errorf("duty at the start of the ramp got (%v) want (0.05)", pwm.Duty)
+	}
+	for _, tt := range []struct {
+		at   time.Duration
+		deg  float64
+		duty float64
+	}{
+		{20 * time.Millisecond, 1.8, 0.0505},
+		{time.Second, 90, 0.075},
+		{1990 * time.Millisecond, 179.1, 0.09975},
+		{2100 * time.Millisecond, 180, 0.1},
+	} {
+		clock.Set(start.Add(tt.at))
+		s.step()
+		r := s.Read()
+		if !near(*r.Angle, tt.deg) || *r.Target != 180 || math.Abs(pwm.Duty-tt.duty) > 1e-6 {
+			t.Errorf("at %v got (%v deg, duty %v) want (%v deg, duty %v)", tt.at, *r.Angle, pwm.Duty, tt.deg, tt.duty)
+		}
+	}
+	if s.moveTimer != nil {
+		t.Error("the frame timer is running after the move")
+	}
+
+	// without a slew rate the pulse goes straight to the target
+	s.SlewRate = 0
+	s.SetAngle(45)
+	if !near(pwm.Duty, 0.0625) {
+		t.Errorf("SetAngle(45) duty got (%v) want (0.0625)", pwm.Duty)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	pwm := driverstest.NewPWM()
+	s, _ := NewWithPWM("servo", pwm)
+	defer s.Close()
+	clock := device.NewSimClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s.SetClock(clock)
+	s.SlewRate = 1800
+	s.DetachAfter = 30 * time.Millisecond
+
+	s.SetAngle(0)
+	s.SetAngle(90) // 50ms, settled by the frame at 60ms
+	clock.Advance(60 * time.Millisecond)
+	if r := s.Read(); !r.Attached || *r.Angle != 90 {
+		t.Fatalf("settled got (attached %v, %v deg) want (true, 90)", r.Attached, *r.Angle)
+	}
+	clock.Advance(29 * time.Millisecond)
+	if !s.Read().Attached {
+		t.Fatal("detached within DetachAfter of settling")
+	}
+	clock.Advance(time.Millisecond)
+	r := s.Read()
+	if r.Attached {
+		t.Fatal("the servo did not detach")
+	}
+	if pwm.Duty != 0 || *r.Angle != 90 {
+		t.Errorf("detached got (duty %v, %v deg) want (0, 90)", pwm.Duty, *r.Angle)
+	}
+	if len(pwm.Duties) < 4 {
+		t.Errorf("duties got (%v) want a ramp", pwm.Duties)
+	}
+
+	// the next move attaches again
+	s.SlewRate = 0
+	s.SetAngle(180)
+	if r := s.Read(); !r.Attached || pwm.Duty != 0.1 {
+		t.Errorf("after SetAngle() got (attached %v, duty %v) want (true, 0.1)", r.Attached, pwm.Duty)
+	}
+	s.Detach()
+	if r := s.Read(); r.Attached || pwm.Duty != 0 {
+		t.Errorf("after Detach() got (attached %v, duty %v) want (false, 0)", r.Attached, pwm.Duty)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s, pwm, clock := newTestServo(t)
+
+	j, _ := json.Marshal(s.Read())
+	if string(j) != `{"target_deg":null,"angle_deg":null,"attached":false}` {
+		t.Errorf("reading before a move got (%s)", j)
+	}
+
+	if err := s.Command([]byte("angle 90")); err != nil {
+		t.Fatalf("angle 90 error = %v", err)
+	}
+	if !near(pwm.Duty, 0.075) {
+		t.Errorf("angle 90 duty got (%v) want (0.075)", pwm.Duty)
+	}
+
+	if err := s.Command([]byte(" Sweep 0 180 2s ")); err != nil {
+		t.Fatalf("sweep error = %v", err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	s.step()
+	r := s.Read()
+	if !near(*r.Angle, 45) || *r.Target != 180 {
+		t.Errorf("sweep after 0.5s got (%v, target %v) want (45, 180)", *r.Angle, *r.Target)
+	}
+	j, _ = json.Marshal(r)
+	if string(j) != `{"target_deg":180,"angle_deg":45,"attached":true}` {
+		t.Errorf("reading got (%s)", j)
+	}
+
+	if err := s.Command([]byte("detach")); err != nil || s.Read().Attached {
+		t.Errorf("detach got (%v) want detached", err)
+	}
+
+	for _, bad := range []string{"angle", "angle left", "sweep 0 180", "sweep 0 180 -1s", "spin 90", ""} {
+		if err := s.Command([]byte(bad)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", bad, err, ErrCommand)
+		}
+	}
+	if err := s.Command([]byte("angle nan")); !errors.Is(err, ErrAngle) {
+		t.Errorf("angle nan error got (%v) want (%v)", err, ErrAngle)
+	}
+}
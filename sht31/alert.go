@@ -0,0 +1,75 @@
+package sht31
+
+import "fmt"
+
+// Limit selects one of the four alert limits. The ALERT pin goes
+// high when temperature or humidity cross a Set limit and low again
+// once they are back past the Clear limit.
+type Limit int
+
+const (
+	HighSet Limit = iota
+	HighClear
+	LowClear
+	LowSet
+)
+
+// read and write commands of each limit, application note "Alert Mode"
+var limitCmds = [...]struct{ read, write uint16 }{
+	HighSet:   {0xE11F, 0x611D},
+	HighClear: {0xE114, 0x6116},
+	LowClear:  {0xE109, 0x610B},
+	LowSet:    {0xE102, 0x6100},
+}
+
+// SetAlertLimit writes an alert limit. The limit keeps only the 7
+// most significant bits of the humidity and the 9 of the temperature,
+// about 0.8%RH and 0.3C steps.
+func (s *SHT31) SetAlertLimit(l Limit, temp, hum float64) error {
+	if l < HighSet || l > LowSet {
+		return fmt.Errorf("invalid sht31 alert limit %d", l)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.command(limitCmds[l].write, encodeLimit(temp, hum))
+}
+
+// AlertLimit reads an alert limit
+func (s *SHT31) AlertLimit(l Limit) (temp, hum float64, err error) {
+	if l < HighSet || l > LowSet {
+		return 0, 0, fmt.Errorf("invalid sht31 alert limit %d", l)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.command(limitCmds[l].read); err != nil {
+		return 0, 0, err
+	}
+	words, err := s.readWords(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	temp, hum = decodeLimit(words[0])
+	return temp, hum, nil
+}
+
+// encodeLimit packs the humidity into bits 15:9 and the temperature
+// into bits 8:0
+func encodeLimit(temp, hum float64) uint16 {
+	rh := rawClamp(hum / 100 * 65535)
+	t := rawClamp((temp + 45) / 175 * 65535)
+	return rh&0xFE00 | t>>7
+}
+
+func decodeLimit(w uint16) (temp, hum float64) {
+	return temperature((w & 0x01FF) << 7), humidity(w & 0xFE00)
+}
+
+func rawClamp(v float64) uint16 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 65535:
+		return 65535
+	}
+	return uint16(v)
+}
@@ -0,0 +1,43 @@
+package sht31
+
+import (
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of an SHT31, the weather of where it is like the
+// ones of a bme280. The days of a profile applied at midnight are the
+// warmest at 3 in the afternoon, the driest too.
+func init() {
+	device.RegisterMockProfile("sht31", "indoor", func(s *SHT31) error {
+		s.profile(Generators{
+			Temperature: daily(s, 21, 1.5, 0.05),
+			Humidity:    device.Shift(device.Sine(5, 24*time.Hour, 45), 21*time.Hour),
+		})
+		return nil
+	})
+	device.RegisterMockProfile("sht31", "greenhouse", func(s *SHT31) error {
+		s.profile(Generators{
+			Temperature: daily(s, 26, 8, 0.3),
+			Humidity:    device.Shift(device.Sine(15, 24*time.Hour, 70), 21*time.Hour),
+		}, device.FailEvery(25), device.FailWith(&drivers.ErrNak{Err: device.ErrInjected}))
+		return nil
+	})
+}
+
+// daily is a day of mean ± swing C, the noise of its MockRand sd
+func daily(s *SHT31, mean, swing, sd float64) device.Generator {
+	return device.Sum(
+		device.Shift(device.Sine(swing, 24*time.Hour, mean), 9*time.Hour),
+		device.Noise(sd, s.MockRand()),
+	)
+}
+
+// profile sets the Generators of a profile and its faults, the ones
+// of the profile before cleared
+func (s *SHT31) profile(gens Generators, faults ...device.FaultOption) {
+	s.SetGenerators(gens)
+	s.InjectFaults(faults...)
+}
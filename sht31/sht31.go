@@ -0,0 +1,391 @@
+// Package sht31 provides a driver for the Sensirion SHT31 temperature
+// and humidity sensor. The SHT31 takes 16 bit commands rather than
+// registers and protects every 16 bit word it sends with a CRC.
+package sht31
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// AddressLow is the address with the ADDR pin low, the default
+	// on most breakouts, AddressHigh with it pulled high
+	AddressLow  = 0x44
+	AddressHigh = 0x45
+)
+
+// commands, see section 4 of the datasheet
+const (
+	cmdMeasureHigh = 0x2400 // single shot, high repeatability, no clock stretching
+	cmdSoftReset   = 0x30A2
+	cmdHeaterOn    = 0x306D
+	cmdHeaterOff   = 0x3066
+	cmdStatus      = 0xF32D
+	cmdClearStatus = 0x3041
+
+	// a high repeatability measurement takes up to 15.5ms, the
+	// soft reset up to 1.5ms
+	measureTime = 16 * time.Millisecond
+	resetTime   = 2 * time.Millisecond
+
+	StatusAlert  = 1 << 15
+	StatusHeater = 1 << 13
+)
+
+var (
+	ErrAddress    = errors.New("sht31 address must be 0x44 or 0x45")
+	ErrCRC        = errors.New("sht31 crc mismatch")
+	ErrReadFailed = errors.New("failed to read from SHT31")
+	ErrCommand    = errors.New("unknown sht31 command")
+)
+
+// Response holds the temperature (C) and humidity (%RH)
+type Response struct {
+	Temperature float64
+	Humidity    float64
+}
+
+// Env is what ReadPub publishes, the bme280 Env without the pressure
+type Env struct {
+	Temperature string `json:"temperature"`
+	Humidity    string `json:"humidity"`
+}
+
+// Generators make the mock Responses of an SHT31 by channel, the
+// channels without one random
+type Generators struct {
+	Temperature device.Generator
+	Humidity    device.Generator
+}
+
+// SHT31 is a temperature and humidity sensor on an I2C bus
+type SHT31 struct {
+	*device.Device
+
+	bus    string
+	addr   int
+	dev    *drivers.I2CDevice
+	heater bool
+	live   [2]func() float64 // the Generators started, by channel
+	mu     sync.Mutex
+}
+
+// New creates an SHT31 at the given bus and address, the sensor is
+// not touched until Init
+func New(name, bus string, addr int) *SHT31 {
+	s := &SHT31{
+		Device: device.NewDevice(name, "mqtt"),
+		bus:    bus,
+		addr:   addr,
+	}
+	s.SetMockKind("sht31", s)
+	return s
+}
+
+// Init opens the i2c bus and soft resets the sensor so it starts
+// from a known state
+func (s *SHT31) Init() error {
+	if s.addr != AddressLow && s.addr != AddressHigh {
+		return fmt.Errorf("%w: %#02x", ErrAddress, s.addr)
+	}
+	if device.IsMock() {
+		return nil
+	}
+
+	dev, err := drivers.NewI2CDevice(s.bus, s.addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dev = dev
+	return s.reset()
+}
+
+// Name returns the name of the device
+func (s *SHT31) Name() string {
+	return s.Device.Name
+}
+
+// Read makes a single shot measurement. When it fails the sensor is
+// soft reset and measured once more, a sensor that locked up after a
+// brown out recovers that way.
+func (s *SHT31) Read() (*Response, error) {
+	if device.IsMock() {
+		if err := s.ReadFault(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		return s.mockResponse(), nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dev == nil {
+		return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+	}
+
+	resp, err := s.measure()
+	if err == nil {
+		return resp, nil
+	}
+	slog.Warn("sht31 read failed, resetting", "device", s.Device.Name, "error", err)
+	if rerr := s.reset(); rerr != nil {
+		return nil, fmt.Errorf("%w: %w (reset: %w)", ErrReadFailed, err, rerr)
+	}
+	if resp, err = s.measure(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+	}
+	return resp, nil
+}
+
+// SetGenerators has the mock reads take their values of gens, started
+// now by the clock of the device
+func (s *SHT31) SetGenerators(gens Generators) {
+	var live [2]func() float64
+	for i, g := range []device.Generator{gens.Temperature, gens.Humidity} {
+		if g != nil {
+			live[i] = device.LiveOn(g, s.Now)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live = live
+}
+
+// mockResponse makes a Response of the Generators, random values of a
+// room for the channels without one
+func (s *SHT31) mockResponse() *Response {
+	s.mu.Lock()
+	live := s.live
+	s.mu.Unlock()
+	var r Response
+	if live[0] != nil {
+		r.Temperature = live[0]()
+	} else {
+		r.Temperature = 18 + s.MockRand().Float64()*6
+	}
+	if live[1] != nil {
+		r.Humidity = live[1]()
+	} else {
+		r.Humidity = 40 + s.MockRand().Float64()*20
+	}
+	return &r
+}
+
+// Temperature reads the temperature in °C, the SHT31 is a
+// device.Thermometer
+func (s *SHT31) Temperature() (float64, error) {
+	r, err := s.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// Humidity reads the relative humidity in %, the SHT31 is a
+// device.Hygrometer
+func (s *SHT31) Humidity() (float64, error) {
+	r, err := s.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Humidity, nil
+}
+
+func (s *SHT31) measure() (*Response, error) {
+	if err := s.command(cmdMeasureHigh); err != nil {
+		return nil, err
+	}
+	time.Sleep(measureTime)
+
+	words, err := s.readWords(2)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Temperature: temperature(words[0]),
+		Humidity:    humidity(words[1]),
+	}, nil
+}
+
+// Reset soft resets the sensor, the heater is turned back on if it
+// was on
+func (s *SHT31) Reset() error {
+	if device.IsMock() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reset()
+}
+
+func (s *SHT31) reset() error {
+	if err := s.command(cmdSoftReset); err != nil {
+		return err
+	}
+	time.Sleep(resetTime)
+	if s.heater {
+		return s.command(cmdHeaterOn)
+	}
+	return nil
+}
+
+// Heater switches the internal heater, a few seconds of heating
+// drives off condensation. The heater warms the sensor by a few
+// degrees, readings are off while it is on.
+func (s *SHT31) Heater(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !device.IsMock() {
+		cmd := uint16(cmdHeaterOff)
+		if on {
+			cmd = cmdHeaterOn
+		}
+		if err := s.command(cmd); err != nil {
+			return err
+		}
+	}
+	s.heater = on
+	return nil
+}
+
+// HeaterOn reports if the heater has been switched on
+func (s *SHT31) HeaterOn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heater
+}
+
+// Status reads the status register, see StatusAlert and StatusHeater.
+// The alert flags are cleared after reading.
+func (s *SHT31) Status() (uint16, error) {
+	if device.IsMock() {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.command(cmdStatus); err != nil {
+		return 0, err
+	}
+	words, err := s.readWords(1)
+	if err != nil {
+		return 0, err
+	}
+	return words[0], s.command(cmdClearStatus)
+}
+
+// Command handles a command payload: "heater on", "heater off" or
+// "reset"
+func (s *SHT31) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "heater on":
+		return s.Heater(true)
+	case "heater off":
+		return s.Heater(false)
+	case "reset":
+		return s.Reset()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub reads the sensor and publishes the values on the device's
+// topic
+func (s *SHT31) ReadPub() error {
+	vals, err := s.Read()
+	if err != nil {
+		return fmt.Errorf("reading SHT31: %w", err)
+	}
+
+	jb, err := json.Marshal(&Env{
+		Temperature: fmt.Sprintf("%.2f", vals.Temperature),
+		Humidity:    fmt.Sprintf("%.2f", vals.Humidity),
+	})
+	if err != nil {
+		return err
+	}
+	s.PubData(jb)
+	return nil
+}
+
+// Close closes the i2c device
+func (s *SHT31) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dev == nil {
+		return nil
+	}
+	err := s.dev.Close()
+	s.dev = nil
+	return err
+}
+
+// command sends a 16 bit command followed by any data words
+func (s *SHT31) command(cmd uint16, words ...uint16) error {
+	buf := []byte{byte(cmd >> 8), byte(cmd)}
+	for _, w := range words {
+		buf = append(buf, byte(w>>8), byte(w), crc8(byte(w>>8), byte(w)))
+	}
+	return s.dev.Tx(func(bus drivers.I2CBus) error {
+		if err := bus.Write(buf); err != nil {
+			return fmt.Errorf("command %#04x: %w", cmd, err)
+		}
+		return nil
+	})
+}
+
+// readWords reads n words checking the CRC following each
+func (s *SHT31) readWords(n int) ([]uint16, error) {
+	buf := make([]byte, n*3)
+	err := s.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.Read(buf)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]uint16, n)
+	for i := range words {
+		b := buf[i*3 : i*3+3]
+		if crc := crc8(b[0], b[1]); crc != b[2] {
+			return nil, fmt.Errorf("%w: word %d got %#02x want %#02x", ErrCRC, i, b[2], crc)
+		}
+		words[i] = uint16(b[0])<<8 | uint16(b[1])
+	}
+	return words, nil
+}
+
+// crc8 is the CRC of a data word, polynomial 0x31 starting at 0xFF
+func crc8(data ...byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// temperature and humidity convert the raw words, section 4.13
+func temperature(raw uint16) float64 {
+	return -45 + 175*float64(raw)/65535
+}
+
+func humidity(raw uint16) float64 {
+	return 100 * float64(raw) / 65535
+}
@@ -0,0 +1,274 @@
+package sht31
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// frame is a measurement as the sensor sends it, 25.0C and 50.0%RH
+var frame = []byte{0x66, 0x66, 0x93, 0x80, 0x00, 0xA2}
+
+func newTestSHT31(t *testing.T) (*SHT31, *driverstest.I2C) {
+	t.Helper()
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	s := New("sht-test", TestI2CBus, AddressLow)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	fake.Writes = nil
+	return s, fake
+}
+
+// commands returns the raw writes made to the fake as hex strings
+func commands(fake *driverstest.I2C) []string {
+	var cmds []string
+	for _, w := range fake.Writes {
+		cmds = append(cmds, fmt.Sprintf("% x", w.Data))
+	}
+	return cmds
+}
+
+func equal(a, b []string) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func TestCRC(t *testing.T) {
+	// the example of section 4.12 of the datasheet
+	if got := crc8(0xBE, 0xEF); got != 0x92 {
+		t.Errorf("crc8(0xBEEF) got (%#02x) want (0x92)", got)
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		raw  uint16
+		temp float64
+		hum  float64
+	}{
+		{0x0000, -45, 0},
+		{0x6666, 25, 40},
+		{0x8000, 42.5, 50},
+		{0xFFFF, 130, 100},
+	}
+	for _, tt := range tests {
+		if got := temperature(tt.raw); math.Abs(got-tt.temp) > 0.01 {
+			t.Errorf("temperature(%#04x) got (%.2f) want (%.2f)", tt.raw, got, tt.temp)
+		}
+		if got := humidity(tt.raw); math.Abs(got-tt.hum) > 0.01 {
+			t.Errorf("humidity(%#04x) got (%.2f) want (%.2f)", tt.raw, got, tt.hum)
+		}
+	}
+}
+
+func TestInitRead(t *testing.T) {
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	s := New("sht-test", TestI2CBus, AddressHigh)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	fake.QueueRead(frame...)
+	resp, err := s.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(resp.Temperature-25) > 0.01 || math.Abs(resp.Humidity-50) > 0.01 {
+		t.Errorf("Read() got (%+v) want (25.00C 50.00%%)", resp)
+	}
+	if got, want := commands(fake), []string{"30 a2", "24 00"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	// a thermometer linked by its name
+	dm := device.GetDeviceManager()
+	dm.Add(s)
+	defer dm.Remove(s.Name())
+	th, err := device.GetThermometer("sht-test")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	fake.QueueRead(frame...)
+	if c, err := th.Temperature(); err != nil || math.Abs(c-25) > 0.01 {
+		t.Errorf("Temperature() got (%v, %v) want (25.00)", c, err)
+	}
+	h, err := device.GetHygrometer("sht-test")
+	if err != nil {
+		t.Fatalf("GetHygrometer() error = %v", err)
+	}
+	fake.QueueRead(frame...)
+	if rh, err := h.Humidity(); err != nil || math.Abs(rh-50) > 0.01 {
+		t.Errorf("Humidity() got (%v, %v) want (50.00)", rh, err)
+	}
+
+	bad := New("sht-bad", TestI2CBus, 0x40)
+	if err := bad.Init(); !errors.Is(err, ErrAddress) {
+		t.Errorf("Init() error got (%v) want (%v)", err, ErrAddress)
+	}
+}
+
+func TestReadRecovery(t *testing.T) {
+	s, fake := newTestSHT31(t)
+
+	// a corrupt humidity word resets the sensor and measures again
+	fake.QueueRead(0x66, 0x66, 0x93, 0x80, 0x00, 0xA3)
+	fake.QueueRead(frame...)
+	if _, err := s.Read(); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if got, want := commands(fake), []string{"24 00", "30 a2", "24 00"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+
+	// a sensor that does not answer the reset gives up
+	fake.Writes = nil
+	fake.FailNext(driverstest.ErrnoNak, driverstest.ErrnoNak)
+	if _, err := s.Read(); !errors.Is(err, ErrReadFailed) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrReadFailed)
+	}
+
+	fake.QueueRead(0x66, 0x66, 0x00, 0x80, 0x00, 0xA2)
+	fake.QueueRead(0x66, 0x66, 0x00, 0x80, 0x00, 0xA2)
+	if _, err := s.Read(); !errors.Is(err, ErrCRC) {
+		t.Errorf("Read() error got (%v) want (%v)", err, ErrCRC)
+	}
+}
+
+func TestHeaterCommand(t *testing.T) {
+	s, fake := newTestSHT31(t)
+
+	tests := []struct {
+		payload string
+		cmds    []string
+		heater  bool
+		err     error
+	}{
+		{"heater on", []string{"30 6d"}, true, nil},
+		{"reset", []string{"30 a2", "30 6d"}, true, nil},
+		{"Heater Off\n", []string{"30 66"}, false, nil},
+		{"reset", []string{"30 a2"}, false, nil},
+		{"defrost", nil, false, ErrCommand},
+	}
+	for _, tt := range tests {
+		fake.Writes = nil
+		err := s.Command([]byte(tt.payload))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", tt.payload, err, tt.err)
+		}
+		if got := commands(fake); !equal(got, tt.cmds) {
+			t.Errorf("Command(%q) wrote (%v) want (%v)", tt.payload, got, tt.cmds)
+		}
+		if s.HeaterOn() != tt.heater {
+			t.Errorf("Command(%q) heater got (%t) want (%t)", tt.payload, s.HeaterOn(), tt.heater)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s, fake := newTestSHT31(t)
+
+	w := uint16(StatusAlert | StatusHeater)
+	fake.QueueRead(byte(w>>8), byte(w), crc8(byte(w>>8), byte(w)))
+	status, err := s.Status()
+	if err != nil || status != w {
+		t.Errorf("Status() got (%#04x, %v) want (%#04x, nil)", status, err, w)
+	}
+	if got, want := commands(fake), []string{"f3 2d", "30 41"}; !equal(got, want) {
+		t.Errorf("commands got (%v) want (%v)", got, want)
+	}
+}
+
+func TestAlertLimit(t *testing.T) {
+	s, fake := newTestSHT31(t)
+
+	if err := s.SetAlertLimit(HighSet, 60, 80); err != nil {
+		t.Fatalf("SetAlertLimit() error = %v", err)
+	}
+	data := fake.Writes[0].Data
+	if len(data) != 5 || data[0] != 0x61 || data[1] != 0x1D || data[4] != crc8(data[2], data[3]) {
+		t.Fatalf("SetAlertLimit() wrote (% x) want (61 1d word crc)", data)
+	}
+
+	// read back what was written
+	fake.QueueRead(data[2:]...)
+	temp, hum, err := s.AlertLimit(HighSet)
+	if err != nil {
+		t.Fatalf("AlertLimit() error = %v", err)
+	}
+	if math.Abs(temp-60) > 0.35 || math.Abs(hum-80) > 0.8 {
+		t.Errorf("AlertLimit() got (%.2fC %.2f%%) want about (60C 80%%)", temp, hum)
+	}
+	if got := commands(fake)[1]; got != "e1 1f" {
+		t.Errorf("AlertLimit() command got (%s) want (e1 1f)", got)
+	}
+
+	if err := s.SetAlertLimit(Limit(7), 0, 0); err == nil {
+		t.Error("SetAlertLimit(7) expected an error")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	defer device.Mock(device.IsMock())
+	device.Mock(true)
+
+	for _, tt := range []struct {
+		profile    string
+		lo, hi     float64 // C, the day
+		faultEvery int
+	}{
+		{"indoor", 19, 23, 0},
+		{"greenhouse", 17, 35, 25},
+	} {
+		t.Run(tt.profile, func(t *testing.T) {
+			clock := device.NewSimClock(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+			s := New("sht31-"+tt.profile, TestI2CBus, AddressLow)
+			s.SetClock(clock)
+			if err := s.MockProfile(tt.profile); err != nil {
+				t.Fatalf("MockProfile() error = %v", err)
+			}
+
+			var night, afternoon float64
+			for h := range 24 {
+				r, err := s.Read()
+				if err != nil {
+					t.Fatalf("Read() at %02d:00 error = %v", h, err)
+				}
+				if r.Temperature < tt.lo || r.Temperature > tt.hi || r.Humidity < 0 || r.Humidity > 100 {
+					t.Errorf("Read() at %02d:00 got (%+v) out of the profile", h, r)
+				}
+				switch h {
+				case 3:
+					night = r.Temperature
+				case 15:
+					afternoon = r.Temperature
+				}
+				clock.Advance(time.Hour)
+			}
+			if afternoon <= night {
+				t.Errorf("Temperature at 15:00 (%.1f) not above 03:00 (%.1f)", afternoon, night)
+			}
+			if _, err := s.Read(); (err != nil) != (tt.faultEvery > 0) || (err != nil && !errors.Is(err, ErrReadFailed)) {
+				t.Errorf("Read() 25 error got (%v) want a fault every (%d)", err, tt.faultEvery)
+			}
+		})
+	}
+
+	err := New("sht31", TestI2CBus, AddressLow).MockProfile("outdoor-winter")
+	if !errors.Is(err, device.ErrMockProfile) || !strings.Contains(err.Error(), "greenhouse, indoor") {
+		t.Errorf("MockProfile(outdoor-winter) error got (%v) want the profiles of an sht31", err)
+	}
+}
@@ -0,0 +1,44 @@
+package soilmoisture
+
+import (
+	"fmt"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// Calibration is the voltage of the probe in dry air, 0%, and in
+// water, 100%. A capacitive probe reads lower the wetter the soil,
+// the two points can be either way around.
+type Calibration struct {
+	Dry float64 `json:"dry"`
+	Wet float64 `json:"wet"`
+}
+
+// DefaultCalibration is typical of a capacitive v1.2 probe powered
+// from 3.3V, every probe differs so calibrate it before trusting the
+// percentages
+var DefaultCalibration = Calibration{Dry: 2.6, Wet: 1.2}
+
+// Valid returns drivers.ErrCalibration if the two points are the
+// same and no percentage can be interpolated between them
+func (c Calibration) Valid() error {
+	if c.Dry == c.Wet {
+		return fmt.Errorf("%w: dry and wet are both %.3fV", drivers.ErrCalibration, c.Dry)
+	}
+	return nil
+}
+
+// Percent interpolates the moisture of volts between the dry and the
+// wet point. A reading beyond either point is clamped to 0 or 100
+// and returned with inRange false, a probe out of the soil or a
+// calibration gone stale does that.
+func (c Calibration) Percent(volts float64) (pct float64, inRange bool) {
+	pct = (volts - c.Dry) / (c.Wet - c.Dry) * 100
+	switch {
+	case pct < 0:
+		return 0, false
+	case pct > 100:
+		return 100, false
+	}
+	return pct, true
+}
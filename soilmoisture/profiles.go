@@ -0,0 +1,33 @@
+package soilmoisture
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a soil moisture probe, the volts of its mock
+// pin. "watered" soil stays moist around 1.35V, near the wet end of
+// the DefaultCalibration. "drying" soil dries out over a week to
+// 2.5V, almost the dry end, and is watered again.
+func init() {
+	device.RegisterMockProfile("soilmoisture", "watered", func(s *SoilMoisture) error {
+		return s.profile(device.Walk(1.35, 0.0005, 1.25, 1.45, s.MockRand()))
+	})
+	device.RegisterMockProfile("soilmoisture", "drying", func(s *SoilMoisture) error {
+		return s.profile(device.Ramp(1.3, 2.5, 7*24*time.Hour))
+	})
+}
+
+// profile has the mock pin read the volts of g with the noise of the
+// probe, started now
+func (s *SoilMoisture) profile(g device.Generator) error {
+	pin, ok := s.AnalogReader.(*drivers.MockAnalogPin)
+	if !ok {
+		return fmt.Errorf("%w: %s reads no mock pin", device.ErrMockProfile, s.Device.Name)
+	}
+	pin.SetGenerator(device.Sum(g, device.Noise(0.005, s.MockRand())))
+	return nil
+}
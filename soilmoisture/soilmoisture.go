@@ -0,0 +1,229 @@
+// Package soilmoisture reads a capacitive soil moisture probe over a
+// drivers.AnalogReader, one channel of an ADS1115 by default, and
+// converts its voltage to percent moisture with a two point
+// calibration. The calibration is taken with the "calibrate dry" and
+// "calibrate wet" commands and saved in the device store, so it
+// survives a restart.
+package soilmoisture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+var ErrCommand = errors.New("unknown command")
+
+// Reading is a single sample of the probe, OutOfRange is set when the
+// voltage is beyond the calibration and Percent was clamped
+type Reading struct {
+	Percent    float64 `json:"percent"`
+	Volts      float64 `json:"volts"`
+	OutOfRange bool    `json:"out_of_range,omitempty"`
+}
+
+// AlertEvent is published when the moisture drops below the low
+// alert threshold, "dry", and when it is back above the threshold
+// plus the hysteresis, "moist"
+type AlertEvent struct {
+	Event   string  `json:"event"`
+	Percent float64 `json:"percent"`
+}
+
+// SoilMoisture is a capacitive soil moisture probe
+type SoilMoisture struct {
+	*device.Device
+	drivers.AnalogReader
+
+	cal        Calibration
+	low        float64
+	hysteresis float64
+	dry        bool // below the low threshold
+
+	mu sync.Mutex
+}
+
+// New creates a probe on channel ch of the default ADS1115
+func New(name string, ch int) (*SoilMoisture, error) {
+	if device.IsMock() {
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, ch)), nil
+	}
+	p, err := drivers.GetADS1115().Pin(name, ch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p), nil
+}
+
+// NewWithReader creates a probe reading r. The calibration saved in
+// the device store is loaded, DefaultCalibration is used if there is
+// none.
+func NewWithReader(name string, r drivers.AnalogReader) *SoilMoisture {
+	s := &SoilMoisture{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		cal:          DefaultCalibration,
+	}
+	var cal Calibration
+	err := device.GetStore().Load(s.storeKey(), &cal)
+	switch {
+	case err == nil && cal.Valid() == nil:
+		s.cal = cal
+	case err == nil:
+		slog.Warn("soilmoisture ignoring saved calibration", "device", name, "error", cal.Valid())
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("soilmoisture loading calibration", "device", name, "error", err)
+	}
+	s.SetMockKind("soilmoisture", s)
+	return s
+}
+
+func (s *SoilMoisture) storeKey() string {
+	return s.Device.Name + "/calibration"
+}
+
+// Name returns the name of the device
+func (s *SoilMoisture) Name() string {
+	return s.Device.Name
+}
+
+// Calibration returns the calibration in use
+func (s *SoilMoisture) Calibration() Calibration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cal
+}
+
+// SetCalibration sets and saves the calibration
+func (s *SoilMoisture) SetCalibration(c Calibration) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cal = c
+	return device.GetStore().Save(s.storeKey(), &c)
+}
+
+// CalibrateDry takes the current reading as 0%, the probe held in
+// the air
+func (s *SoilMoisture) CalibrateDry() error {
+	return s.calibrate(func(c *Calibration, v float64) { c.Dry = v })
+}
+
+// CalibrateWet takes the current reading as 100%, the probe standing
+// in water up to its line
+func (s *SoilMoisture) CalibrateWet() error {
+	return s.calibrate(func(c *Calibration, v float64) { c.Wet = v })
+}
+
+func (s *SoilMoisture) calibrate(set func(*Calibration, float64)) error {
+	volts, err := s.ReadVolts()
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.Device.Name, err)
+	}
+	c := s.Calibration()
+	set(&c, volts)
+	return s.SetCalibration(c)
+}
+
+// SetLowAlert publishes an AlertEvent when the moisture drops below
+// percent, and again when it recovers above percent + hysteresis so
+// a reading hovering around the threshold does not flap. 0 turns the
+// alert off.
+func (s *SoilMoisture) SetLowAlert(percent, hysteresis float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.low, s.hysteresis = percent, hysteresis
+	s.dry = false
+}
+
+// Command handles a command payload: "calibrate dry" or "calibrate
+// wet"
+func (s *SoilMoisture) Command(payload []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "calibrate dry":
+		return s.CalibrateDry()
+	case "calibrate wet":
+		return s.CalibrateWet()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the moisture and the voltage it was read from
+func (s *SoilMoisture) Read() (*Reading, error) {
+	volts, err := s.ReadVolts()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Device.Name, err)
+	}
+	pct, ok := s.Calibration().Percent(volts)
+	if !ok {
+		slog.Debug("soilmoisture reading out of calibration range", "device", s.Device.Name, "volts", volts)
+	}
+	return &Reading{Percent: pct, Volts: volts, OutOfRange: !ok}, nil
+}
+
+// ReadPub reads the probe and publishes the reading, and the alert
+// if the reading crossed the low threshold
+func (s *SoilMoisture) ReadPub() error {
+	r, err := s.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.PubData(j)
+
+	if evt := s.check(r.Percent); evt != nil {
+		j, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		s.PubData(j)
+	}
+	return nil
+}
+
+// check returns the AlertEvent for a reading of pct, nil if the
+// alert state did not change
+func (s *SoilMoisture) check(pct float64) *AlertEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.low <= 0:
+		return nil
+	case !s.dry && pct < s.low:
+		s.dry = true
+		return &AlertEvent{Event: "dry", Percent: pct}
+	case s.dry && pct >= s.low+s.hysteresis:
+		s.dry = false
+		return &AlertEvent{Event: "moist", Percent: pct}
+	}
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (s *SoilMoisture) Run(ctx context.Context, period time.Duration) error {
+	err := s.TimerLoop(ctx, period, s.ReadPub)
+	slog.Debug("soilmoisture stopped", "device", s.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the reader, the ADS1115 channel by default
+func (s *SoilMoisture) Close() error {
+	if c, ok := s.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package soilmoisture
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		cal     Calibration
+		volts   float64
+		want    float64
+		inRange bool
+	}{
+		{"dry", DefaultCalibration, 2.6, 0, true},
+		{"wet", DefaultCalibration, 1.2, 100, true},
+		{"half", DefaultCalibration, 1.9, 50, true},
+		{"quarter", DefaultCalibration, 2.25, 25, true},
+		{"drier than air", DefaultCalibration, 2.9, 0, false},
+		{"wetter than water", DefaultCalibration, 1.0, 100, false},
+		{"rising probe", Calibration{Dry: 0.5, Wet: 2.5}, 1.0, 25, true},
+		{"rising probe beyond wet", Calibration{Dry: 0.5, Wet: 2.5}, 3.0, 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.cal.Percent(tt.volts)
+			if math.Abs(got-tt.want) > 1e-9 || ok != tt.inRange {
+				t.Errorf("Percent(%v) got (%v, %t) want (%v, %t)", tt.volts, got, ok, tt.want, tt.inRange)
+			}
+		})
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("soil", 0)
+	s := NewWithReader("soil", pin)
+	if s.Calibration() != DefaultCalibration {
+		t.Fatalf("Calibration() got (%v) want the default (%v)", s.Calibration(), DefaultCalibration)
+	}
+
+	pin.MockValues(3.0)
+	if err := s.Command([]byte("calibrate dry")); err != nil {
+		t.Fatalf("calibrate dry error = %v", err)
+	}
+	pin.MockValues(1.4)
+	if err := s.Command([]byte(" Calibrate Wet\n")); err != nil {
+		t.Fatalf("calibrate wet error = %v", err)
+	}
+	want := Calibration{Dry: 3.0, Wet: 1.4}
+	if s.Calibration() != want {
+		t.Errorf("Calibration() got (%v) want (%v)", s.Calibration(), want)
+	}
+
+	// the wet point can not be the dry one
+	pin.MockValues(3.0)
+	if err := s.CalibrateWet(); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("CalibrateWet() at the dry point error got (%v) want (%v)", err, drivers.ErrCalibration)
+	}
+	if err := s.Command([]byte("calibrate")); !errors.Is(err, ErrCommand) {
+		t.Errorf("Command(calibrate) error got (%v) want (%v)", err, ErrCommand)
+	}
+
+	// a restarted device loads the saved calibration
+	again := NewWithReader("soil", pin)
+	if again.Calibration() != want {
+		t.Errorf("Calibration() after restart got (%v) want (%v)", again.Calibration(), want)
+	}
+	other := NewWithReader("tomatoes", pin)
+	if other.Calibration() != DefaultCalibration {
+		t.Errorf("Calibration() of another probe got (%v) want (%v)", other.Calibration(), DefaultCalibration)
+	}
+
+	pin.MockValues(2.2)
+	r, err := again.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if math.Abs(r.Percent-50) > 1e-9 || r.Volts != 2.2 || r.OutOfRange {
+		t.Errorf("Read() got (%+v) want (50%% at 2.2V)", r)
+	}
+	pin.MockValues(3.2)
+	if r, _ := again.Read(); !r.OutOfRange || r.Percent != 0 {
+		t.Errorf("Read() in the air got (%+v) want (0%% out of range)", r)
+	}
+}
+
+func TestBadStoredCalibration(t *testing.T) {
+	store := devicetest.UseStore(t)
+	if err := store.Save("soil/calibration", &Calibration{Dry: 2, Wet: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewWithReader("soil", drivers.NewMockAnalogPin("soil", 0))
+	if s.Calibration() != DefaultCalibration {
+		t.Errorf("Calibration() got (%v) want the default (%v)", s.Calibration(), DefaultCalibration)
+	}
+}
+
+func TestLowAlert(t *testing.T) {
+	devicetest.UseStore(t)
+	s := NewWithReader("soil", drivers.NewMockAnalogPin("soil", 0))
+	if evt := s.check(5); evt != nil {
+		t.Errorf("check() with the alert off got (%v) want (nil)", evt)
+	}
+
+	s.SetLowAlert(30, 5)
+	tests := []struct {
+		pct  float64
+		want string
+	}{
+		{40, ""},
+		{29, "dry"},
+		{20, ""},
+		{31, ""}, // within the hysteresis
+		{29, ""},
+		{35, "moist"},
+		{32, ""},
+		{28, "dry"},
+	}
+	for _, tt := range tests {
+		evt := s.check(tt.pct)
+		got := ""
+		if evt != nil {
+			got = evt.Event
+		}
+		if got != tt.want {
+			t.Errorf("check(%v) got (%q) want (%q)", tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestMockNew(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	devicetest.UseStore(t)
+
+	s, err := New("soil", 1)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.ReadPub(); err != nil {
+		t.Errorf("ReadPub() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	devicetest.UseStore(t)
+	clock := devicetest.UseClock(t, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+
+	s, _ := New("soil", 1)
+	if err := s.MockProfile("drying"); err != nil {
+		t.Fatalf("MockProfile(drying) error = %v", err)
+	}
+	last := 100.0
+	for day := range 7 {
+		r, err := s.Read()
+		if err != nil || r.Percent >= last || r.Percent < 10 {
+			t.Errorf("Read() drying day %d got (%+v, %v) want drier than (%.0f%%)", day, r, err, last)
+		}
+		last = r.Percent
+		clock.Advance(24 * time.Hour)
+	}
+	if r, _ := s.Read(); r.Percent < 85 {
+		t.Errorf("Read() after a week got (%.0f%%) want watered", r.Percent)
+	}
+
+	if err := s.MockProfile("watered"); err != nil {
+		t.Fatalf("MockProfile(watered) error = %v", err)
+	}
+	for h := range 24 {
+		if r, err := s.Read(); err != nil || r.Percent < 80 || r.Percent > 100 {
+			t.Errorf("Read() watered at %02d:00 got (%+v, %v) want moist", h, r, err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	// a probe of a real ADC
+	ads := NewWithReader("soil-ads", &drivers.Calibrated{AnalogReader: drivers.NewMockAnalogPin("soil-ads", 2)})
+	if err := ads.MockProfile("watered"); !errors.Is(err, device.ErrMockProfile) {
+		t.Errorf("MockProfile() without a mock pin error got (%v) want (%v)", err, device.ErrMockProfile)
+	}
+}
@@ -0,0 +1,178 @@
+package ssd1306
+
+// The frame buffer is laid out the way the controller keeps its RAM,
+// a page of 8 rows at a time: byte x of page p holds the column x of
+// rows 8p to 8p+7, the top row in the least significant bit. Every
+// page keeps the span of columns changed since the last Show.
+
+// span is the changed columns of a page, empty when lo > hi
+type span struct {
+	lo, hi int
+}
+
+// set sets the pixel at x, y, pixels off the display are clipped. Only
+// a pixel that changes is marked to be sent, d.mu is held.
+func (d *SSD1306) set(x, y int, on bool) {
+	if x < 0 || x >= d.width || y < 0 || y >= d.height {
+		return
+	}
+	p := y / 8
+	i := p*d.width + x
+	b := d.buf[i]
+	if on {
+		b |= 1 << (y % 8)
+	} else {
+		b &^= 1 << (y % 8)
+	}
+	if b == d.buf[i] {
+		return
+	}
+	d.buf[i] = b
+	s := &d.dirty[p]
+	s.lo, s.hi = min(s.lo, x), max(s.hi, x)
+}
+
+// invalidate marks the whole display to be sent, d.mu is held
+func (d *SSD1306) invalidate() {
+	for p := range d.dirty {
+		d.dirty[p] = span{0, d.width - 1}
+	}
+}
+
+// SetPixel turns the pixel at x, y on or off
+func (d *SSD1306) SetPixel(x, y int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.set(x, y, on)
+}
+
+// Pixel returns whether the pixel at x, y is on
+func (d *SSD1306) Pixel(x, y int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if x < 0 || x >= d.width || y < 0 || y >= d.height {
+		return false
+	}
+	return d.buf[y/8*d.width+x]&(1<<(y%8)) != 0
+}
+
+// Clear turns every pixel off
+func (d *SSD1306) Clear() {
+	d.FillRect(0, 0, d.width, d.height, false)
+}
+
+// Line draws a line from x0, y0 to x1, y1 inclusive
+func (d *SSD1306) Line(x0, y0, x1, y1 int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dx, sx := x1-x0, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	dy, sy := y1-y0, 1
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	e := dx - dy
+	for {
+		d.set(x0, y0, on)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 > -dy {
+			e -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// Rect draws the outline of the w by h rectangle at x, y
+func (d *SSD1306) Rect(x, y, w, h int, on bool) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	d.Line(x, y, x+w-1, y, on)
+	d.Line(x, y+h-1, x+w-1, y+h-1, on)
+	d.Line(x, y, x, y+h-1, on)
+	d.Line(x+w-1, y, x+w-1, y+h-1, on)
+}
+
+// FillRect fills the w by h rectangle at x, y
+func (d *SSD1306) FillRect(x, y, w, h int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for j := max(y, 0); j < min(y+h, d.height); j++ {
+		for i := max(x, 0); i < min(x+w, d.width); i++ {
+			d.set(i, j, on)
+		}
+	}
+}
+
+// Text draws s in the 5x7 font with its top left corner at x, y, a
+// character every 6 pixels. Only the lit pixels of the characters are
+// drawn.
+func (d *SSD1306) Text(x, y int, s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.text(x, y, s)
+}
+
+// text draws s, d.mu is held
+func (d *SSD1306) text(x, y int, s string) {
+	for _, r := range s {
+		for c, col := range glyph(r) {
+			for b := 0; b < 7; b++ {
+				if col&(1<<b) != 0 {
+					d.set(x+c, y+b, true)
+				}
+			}
+		}
+		x += cellWidth
+	}
+}
+
+// Rows returns the number of text rows, 8 pixels each
+func (d *SSD1306) Rows() int {
+	return d.height / cellHeight
+}
+
+// Columns returns the number of characters that fit on a text row
+func (d *SSD1306) Columns() int {
+	return d.width / cellWidth
+}
+
+// Row replaces text row r with s, cut to the Columns that fit. Rows
+// that do not change are not sent again by Show.
+func (d *SSD1306) Row(r int, s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r < 0 || r >= d.Rows() {
+		return
+	}
+	if rs := []rune(s); len(rs) > d.Columns() {
+		s = string(rs[:d.Columns()])
+	}
+	// a row is a page, it is drawn whole and only the columns that
+	// differ are marked
+	page := make([]byte, d.width)
+	x := 0
+	for _, c := range s {
+		g := glyph(c)
+		copy(page[x:], g[:])
+		x += cellWidth
+	}
+	row := d.buf[r*d.width : (r+1)*d.width]
+	for i, b := range page {
+		if row[i] != b {
+			row[i] = b
+			sp := &d.dirty[r]
+			sp.lo, sp.hi = min(sp.lo, i), max(sp.hi, i)
+		}
+	}
+}
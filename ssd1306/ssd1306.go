@@ -0,0 +1,319 @@
+// Package ssd1306 drives the 128x64 and 128x32 SSD1306 monochrome
+// OLED displays on an I2C bus.
+//
+// Drawing goes to a frame buffer, Show sends the columns of the pages
+// that changed since the last Show so a status line that ticks over
+// does not resend the whole display. Text is drawn in a built in 5x7
+// font, a text Row is a page: 21 characters by 8 rows on a 128x64.
+//
+// With Watch the display shows the readings of other devices in the
+// device manager, a row per value, refreshed every period of Run.
+package ssd1306
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// Address is the address with SA0 low, AddressAlt with it high
+	Address    = 0x3C
+	AddressAlt = 0x3D
+
+	Width = 128
+
+	// DefaultContrast is the contrast Init sets
+	DefaultContrast = 0xCF
+)
+
+// control bytes starting every write, the rest of it is commands or
+// display RAM
+const (
+	ctrlCommand = 0x00
+	ctrlData    = 0x40
+)
+
+// commands, section 9 of the datasheet
+const (
+	cmdContrast     = 0x81
+	cmdResume       = 0xA4
+	cmdNormal       = 0xA6
+	cmdOff          = 0xAE
+	cmdOn           = 0xAF
+	cmdMemoryMode   = 0x20
+	cmdPageMode     = 0x02
+	cmdStartLine    = 0x40
+	cmdSegRemap     = 0xA1
+	cmdMultiplex    = 0xA8
+	cmdScanDec      = 0xC8
+	cmdOffset       = 0xD3
+	cmdComPins      = 0xDA
+	cmdClockDiv     = 0xD5
+	cmdPrecharge    = 0xD9
+	cmdVcomDeselect = 0xDB
+	cmdChargePump   = 0x8D
+	cmdStopScroll   = 0x2E
+	cmdPage         = 0xB0 // | page
+	cmdColumnLow    = 0x00 // | low nibble
+	cmdColumnHigh   = 0x10 // | high nibble
+)
+
+var (
+	ErrSize    = errors.New("ssd1306 height must be 32 or 64")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	On       bool     `json:"on"`
+	Contrast int      `json:"contrast"`
+	Watching []string `json:"watching,omitempty"`
+}
+
+// SSD1306 is a 128 pixel wide OLED display
+type SSD1306 struct {
+	*device.Device
+
+	bus      string
+	addr     int
+	dev      *drivers.I2CDevice
+	width    int
+	height   int
+	on       bool
+	contrast byte
+
+	buf   []byte
+	dirty []span
+
+	watch []string
+	mu    sync.Mutex
+}
+
+// New creates a 128 pixel wide display height 32 or 64 pixels high at
+// the given bus and address, the display is not touched until Init
+func New(name, bus string, addr, height int) (*SSD1306, error) {
+	if height != 32 && height != 64 {
+		return nil, fmt.Errorf("%w: %d", ErrSize, height)
+	}
+	d := &SSD1306{
+		Device:   device.NewDevice(name, "mqtt"),
+		bus:      bus,
+		addr:     addr,
+		width:    Width,
+		height:   height,
+		contrast: DefaultContrast,
+		buf:      make([]byte, Width*height/8),
+		dirty:    make([]span, height/8),
+	}
+	d.invalidate()
+	return d, nil
+}
+
+// Name returns the name of the device
+func (d *SSD1306) Name() string {
+	return d.Device.Name
+}
+
+// Init opens the i2c bus, sets the display up in page addressing mode,
+// sends the frame buffer and turns the display on
+func (d *SSD1306) Init() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.on = true
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(d.bus, d.addr)
+	if err != nil {
+		return err
+	}
+	d.dev = dev
+
+	mux, pins := byte(d.height-1), byte(0x12)
+	if d.height == 32 {
+		pins = 0x02
+	}
+	err = d.command(
+		cmdOff,
+		cmdClockDiv, 0x80,
+		cmdMultiplex, mux,
+		cmdOffset, 0x00,
+		cmdStartLine,
+		cmdChargePump, 0x14,
+		cmdMemoryMode, cmdPageMode,
+		cmdSegRemap,
+		cmdScanDec,
+		cmdComPins, pins,
+		cmdContrast, d.contrast,
+		cmdPrecharge, 0xF1,
+		cmdVcomDeselect, 0x40,
+		cmdResume,
+		cmdNormal,
+		cmdStopScroll,
+	)
+	if err != nil {
+		return err
+	}
+	d.invalidate()
+	if err := d.show(); err != nil {
+		return err
+	}
+	return d.command(cmdOn)
+}
+
+// Width returns the width in pixels
+func (d *SSD1306) Width() int {
+	return d.width
+}
+
+// Height returns the height in pixels
+func (d *SSD1306) Height() int {
+	return d.height
+}
+
+// Show sends the columns of the frame buffer changed since the last
+// Show
+func (d *SSD1306) Show() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.show()
+}
+
+// show sends the changed columns a page at a time, d.mu is held
+func (d *SSD1306) show() error {
+	for p, s := range d.dirty {
+		if s.lo > s.hi {
+			continue
+		}
+		if d.dev != nil {
+			data := d.buf[p*d.width+s.lo : p*d.width+s.hi+1]
+			err := d.dev.Tx(func(bus drivers.I2CBus) error {
+				col := byte(s.lo)
+				err := bus.WriteReg(ctrlCommand, []byte{cmdPage | byte(p), cmdColumnLow | col&0x0F, cmdColumnHigh | col>>4})
+				if err != nil {
+					return err
+				}
+				return bus.WriteReg(ctrlData, data)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		d.dirty[p] = span{d.width, -1}
+	}
+	return nil
+}
+
+// command sends commands, d.mu is held
+func (d *SSD1306) command(cmds ...byte) error {
+	if d.dev == nil {
+		return nil
+	}
+	return d.dev.Tx(func(bus drivers.I2CBus) error {
+		return bus.WriteReg(ctrlCommand, cmds)
+	})
+}
+
+// SetContrast sets the contrast, 0 to 255, the brightness of the lit
+// pixels
+func (d *SSD1306) SetContrast(c byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.command(cmdContrast, c); err != nil {
+		return err
+	}
+	d.contrast = c
+	return nil
+}
+
+// SetOn turns the display on or off, it keeps its RAM while off
+func (d *SSD1306) SetOn(on bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cmd := byte(cmdOff)
+	if on {
+		cmd = cmdOn
+	}
+	if err := d.command(cmd); err != nil {
+		return err
+	}
+	d.on = on
+	return nil
+}
+
+// Command handles a command payload: "contrast 128", "on", "off" or
+// "clear"
+func (d *SSD1306) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(f) == 2 && f[0] == "contrast":
+		c, err := strconv.ParseUint(f[1], 10, 8)
+		if err != nil {
+			break
+		}
+		return d.SetContrast(byte(c))
+	case len(f) == 1 && f[0] == "on":
+		return d.SetOn(true)
+	case len(f) == 1 && f[0] == "off":
+		return d.SetOn(false)
+	case len(f) == 1 && f[0] == "clear":
+		d.Clear()
+		return d.Show()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// Read returns the state of the display
+func (d *SSD1306) Read() *Reading {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &Reading{
+		On:       d.on,
+		Contrast: int(d.contrast),
+		Watching: d.watch,
+	}
+}
+
+// ReadPub publishes the reading
+func (d *SSD1306) ReadPub() error {
+	j, err := json.Marshal(d.Read())
+	if err != nil {
+		return err
+	}
+	d.PubData(j)
+	return nil
+}
+
+// Run refreshes the watched devices and publishes a reading every
+// period until ctx is canceled
+func (d *SSD1306) Run(ctx context.Context, period time.Duration) error {
+	err := d.TimerLoop(ctx, period, func() error {
+		return errors.Join(d.Refresh(), d.ReadPub())
+	})
+	slog.Debug("ssd1306 stopped", "device", d.Device.Name, "error", err)
+	return err
+}
+
+// Close turns the display off and releases the bus
+func (d *SSD1306) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dev == nil {
+		return nil
+	}
+	err := errors.Join(d.command(cmdOff), d.dev.Close())
+	d.dev = nil
+	return err
+}
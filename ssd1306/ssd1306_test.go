@@ -0,0 +1,324 @@
+package ssd1306
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func newTestDisplay(t *testing.T, height int) (*SSD1306, *driverstest.I2C) {
+	t.Helper()
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+	d, err := New("oled", TestI2CBus, Address, height)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := d.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	fake.Writes = nil
+	return d, fake
+}
+
+// art renders the w by h pixels at x, y, '#' on and '.' off
+func art(d *SSD1306, x, y, w, h int) string {
+	var b strings.Builder
+	for j := y; j < y+h; j++ {
+		for i := x; i < x+w; i++ {
+			if d.Pixel(i, j) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// image joins the rows of a golden image
+func image(rows ...string) string {
+	return strings.Join(rows, "\n") + "\n"
+}
+
+func TestDraw(t *testing.T) {
+	d, err := New("oled", TestI2CBus, Address, 64)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		draw       func()
+		x, y, w, h int
+		want       string
+	}{
+		{"line", func() { d.Line(0, 0, 5, 2, true) }, 0, 0, 6, 3, image(
+			"##....",
+			"..##..",
+			"....##",
+		)},
+		{"steep line", func() { d.Line(1, 3, 0, 0, true) }, 0, 0, 2, 4, image(
+			"#.",
+			"#.",
+			".#",
+			".#",
+		)},
+		{"rect", func() { d.Rect(1, 1, 5, 4, true) }, 0, 0, 7, 6, image(
+			".......",
+			".#####.",
+			".#...#.",
+			".#...#.",
+			".#####.",
+			".......",
+		)},
+		{"fill", func() { d.FillRect(-2, 62, 4, 5, true) }, 0, 61, 3, 3, image(
+			"...",
+			"##.",
+			"##.",
+		)},
+		{"text", func() { d.Text(1, 0, "A1°") }, 0, 0, 19, 8, image(
+			"..###....#.....##..",
+			".#...#..##....#..#.",
+			".#...#...#....#..#.",
+			".#...#...#.....##..",
+			".#####...#.........",
+			".#...#...#.........",
+			".#...#..###........",
+			"...................",
+		)},
+	}
+	for _, tt := range tests {
+		d.Clear()
+		tt.draw()
+		if got := art(d, tt.x, tt.y, tt.w, tt.h); got != tt.want {
+			t.Errorf("%s got\n%swant\n%s", tt.name, got, tt.want)
+		}
+	}
+
+	// 21 characters fit on a row, the 22nd is cut
+	d.Clear()
+	d.Row(1, strings.Repeat("~", 22))
+	if !d.Pixel(120, 11) || d.Pixel(126, 11) {
+		t.Errorf("Row() got (%v %v) want the 21st character only", d.Pixel(120, 11), d.Pixel(126, 11))
+	}
+	if d.Rows() != 8 || d.Columns() != 21 {
+		t.Errorf("got (%d rows, %d columns) want (8, 21)", d.Rows(), d.Columns())
+	}
+}
+
+func TestInit(t *testing.T) {
+	for _, tt := range []struct {
+		height    int
+		mux, pins byte
+	}{
+		{64, 0x3F, 0x12},
+		{32, 0x1F, 0x02},
+	} {
+		device.Mock(false)
+		fake := driverstest.NewI2C()
+		driverstest.UseI2C(t, fake)
+		d, _ := New("oled", TestI2CBus, Address, tt.height)
+		d.SetPixel(0, 0, true)
+		if err := d.Init(); err != nil {
+			t.Fatalf("Init() error = %v", err)
+		}
+
+		init := []byte{
+			0xAE, 0xD5, 0x80, 0xA8, tt.mux, 0xD3, 0x00, 0x40, 0x8D, 0x14,
+			0x20, 0x02, 0xA1, 0xC8, 0xDA, tt.pins, 0x81, 0xCF, 0xD9, 0xF1,
+			0xDB, 0x40, 0xA4, 0xA6, 0x2E,
+		}
+		w := fake.Writes
+		pages := tt.height / 8
+		if len(w) != 2+2*pages {
+			t.Fatalf("height %d got (%d) writes want (%d)", tt.height, len(w), 2+2*pages)
+		}
+		if w[0].Reg != ctrlCommand || !bytes.Equal(w[0].Data, init) {
+			t.Errorf("height %d init got (%#x % x) want (0 % x)", tt.height, w[0].Reg, w[0].Data, init)
+		}
+		for p := 0; p < pages; p++ {
+			cmd, data := w[1+2*p], w[2+2*p]
+			want := make([]byte, Width)
+			if p == 0 {
+				want[0] = 0x01
+			}
+			if cmd.Reg != ctrlCommand || !bytes.Equal(cmd.Data, []byte{0xB0 | byte(p), 0x00, 0x10}) {
+				t.Errorf("page %d address got (%#x % x)", p, cmd.Reg, cmd.Data)
+			}
+			if data.Reg != ctrlData || !bytes.Equal(data.Data, want) {
+				t.Errorf("page %d data got (%#x % x)", p, data.Reg, data.Data)
+			}
+		}
+		if last := w[len(w)-1]; last.Reg != ctrlCommand || !bytes.Equal(last.Data, []byte{0xAF}) {
+			t.Errorf("display on got (%#x % x) want (0 af)", last.Reg, last.Data)
+		}
+	}
+
+	if _, err := New("oled", TestI2CBus, Address, 48); !errors.Is(err, ErrSize) {
+		t.Errorf("New(48) error got (%v) want (%v)", err, ErrSize)
+	}
+}
+
+func TestShow(t *testing.T) {
+	d, fake := newTestDisplay(t, 64)
+
+	// only the changed columns of the changed pages are sent
+	d.SetPixel(10, 20, true)
+	d.SetPixel(12, 23, true)
+	d.SetPixel(200, 20, true) // off the display
+	d.Line(30, 63, 33, 63, true)
+	if err := d.Show(); err != nil {
+		t.Fatalf("Show() error = %v", err)
+	}
+	want := []driverstest.Write{
+		{Reg: ctrlCommand, Data: []byte{0xB2, 0x0A, 0x10}},
+		{Reg: ctrlData, Data: []byte{0x10, 0x00, 0x80}},
+		{Reg: ctrlCommand, Data: []byte{0xB7, 0x0E, 0x11}},
+		{Reg: ctrlData, Data: []byte{0x80, 0x80, 0x80, 0x80}},
+	}
+	check := func(name string, want []driverstest.Write) {
+		t.Helper()
+		if len(fake.Writes) != len(want) {
+			t.Fatalf("%s got (%v) want (%v)", name, fake.Writes, want)
+		}
+		for i := range want {
+			if fake.Writes[i].Reg != want[i].Reg || !bytes.Equal(fake.Writes[i].Data, want[i].Data) {
+				t.Errorf("%s write %d got (%#x % x) want (%#x % x)", name, i,
+					fake.Writes[i].Reg, fake.Writes[i].Data, want[i].Reg, want[i].Data)
+			}
+		}
+		fake.Writes = nil
+	}
+	check("pixels", want)
+
+	// nothing changed, nothing is sent
+	d.SetPixel(10, 20, true)
+	d.Show()
+	check("unchanged", nil)
+
+	d.Row(0, "Hi")
+	d.Show()
+	check("row", []driverstest.Write{
+		{Reg: ctrlCommand, Data: []byte{0xB0, 0x00, 0x10}},
+		{Reg: ctrlData, Data: []byte{0x7F, 0x08, 0x08, 0x08, 0x7F, 0x00, 0x00, 0x44, 0x7D, 0x40}},
+	})
+	d.Row(0, "Hi")
+	d.Show()
+	check("same row", nil)
+	d.Row(0, "Ho")
+	d.Show()
+	check("changed row", []driverstest.Write{
+		{Reg: ctrlCommand, Data: []byte{0xB0, 0x06, 0x10}},
+		{Reg: ctrlData, Data: []byte{0x38, 0x44, 0x44, 0x44, 0x38}},
+	})
+}
+
+func TestCommand(t *testing.T) {
+	d, fake := newTestDisplay(t, 32)
+
+	for _, tt := range []struct {
+		payload string
+		want    []byte
+	}{
+		{"contrast 128", []byte{0x81, 0x80}},
+		{"off", []byte{0xAE}},
+		{"ON", []byte{0xAF}},
+	} {
+		fake.Writes = nil
+		if err := d.Command([]byte(tt.payload)); err != nil {
+			t.Fatalf("Command(%q) error = %v", tt.payload, err)
+		}
+		if len(fake.Writes) != 1 || !bytes.Equal(fake.Writes[0].Data, tt.want) {
+			t.Errorf("Command(%q) got (%v) want (% x)", tt.payload, fake.Writes, tt.want)
+		}
+	}
+	if r := d.Read(); !r.On || r.Contrast != 128 {
+		t.Errorf("Read() got (%+v) want (on, contrast 128)", r)
+	}
+
+	d.Text(0, 0, "x")
+	d.Show()
+	fake.Writes = nil
+	d.Command([]byte("clear"))
+	if len(fake.Writes) != 2 || d.Pixel(0, 0) {
+		t.Errorf("clear got (%v) want the page sent dark", fake.Writes)
+	}
+
+	for _, bad := range []string{"", "contrast", "contrast 256", "contrast high", "dim"} {
+		if err := d.Command([]byte(bad)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", bad, err, ErrCommand)
+		}
+	}
+
+	d.Close()
+	if !fake.Closed() {
+		t.Error("Close() did not release the bus")
+	}
+}
+
+// sensor is a device read by the watch
+type sensor struct {
+	temp float64
+}
+
+func (s *sensor) Name() string { return "weather" }
+
+func (s *sensor) Read() (map[string]float64, error) {
+	return map[string]float64{"temp": s.temp}, nil
+}
+
+// gauge reads a bare value
+type gauge struct{}
+
+func (gauge) Name() string  { return "gauge" }
+func (gauge) Read() float64 { return 1013.25 }
+
+func TestWatch(t *testing.T) {
+	dm := device.GetDeviceManager()
+	s := &sensor{temp: 21.5}
+	dm.Add(s)
+	dm.Add(gauge{})
+	defer dm.Remove("weather")
+	defer dm.Remove("gauge")
+
+	d, fake := newTestDisplay(t, 32)
+	d.Watch("gauge", "weather", "nothing")
+	if err := d.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(fake.Writes) != 6 {
+		t.Errorf("Refresh() got (%d) writes want a page for each of 3 rows", len(fake.Writes))
+	}
+	row := func(r int, s string) string {
+		t.Helper()
+		want, _ := New("want", TestI2CBus, Address, 32)
+		want.Row(r, s)
+		return art(want, 0, 8*r, Width, 8)
+	}
+	for r, line := range []string{"gauge 1013.2", "weather temp 21.5", "nothing -", ""} {
+		if got := art(d, 0, 8*r, Width, 8); got != row(r, line) {
+			t.Errorf("row %d got\n%swant (%s)", r, got, line)
+		}
+	}
+
+	// only the row that changed is sent again
+	fake.Writes = nil
+	s.temp = 22.25
+	d.Refresh()
+	if len(fake.Writes) != 2 || !bytes.Equal(fake.Writes[0].Data[:1], []byte{0xB1}) {
+		t.Errorf("second Refresh() got (%v) want row 1 only", fake.Writes)
+	}
+
+	if r := d.Read(); len(r.Watching) != 3 {
+		t.Errorf("Read() watching got (%v) want (gauge weather nothing)", r.Watching)
+	}
+}
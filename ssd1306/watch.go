@@ -0,0 +1,39 @@
+package ssd1306
+
+import "github.com/rustyeddy/otto-devices"
+
+// Watch shows the readings of the named devices of the device manager
+// on the display, a row for every line of DeviceManager.Lines:
+// "bme280 temperature 21.53".
+// The rows are redrawn by Refresh, rows that did not change are not
+// resent. Watch with no names stops watching, the display is left as
+// it is.
+func (d *SSD1306) Watch(names ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.watch = names
+}
+
+// Refresh reads the watched devices and shows their rows, rows beyond
+// the bottom of the display are dropped
+func (d *SSD1306) Refresh() error {
+	d.mu.Lock()
+	names := d.watch
+	d.mu.Unlock()
+	if len(names) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, device.GetDeviceManager().Lines(name)...)
+	}
+	for r := 0; r < d.Rows(); r++ {
+		line := ""
+		if r < len(lines) {
+			line = lines[r]
+		}
+		d.Row(r, line)
+	}
+	return d.Show()
+}
@@ -0,0 +1,189 @@
+package stepper
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// Driver energizes the coils of a stepper one step at a time
+type Driver interface {
+	// Step moves the rotor a step, dir is 1 or -1
+	Step(dir int) error
+
+	// Release de-energizes the coils, the next Step energizes them
+	// again
+	Release() error
+
+	Close() error
+}
+
+// Sequence is the pattern on the four coil lines, IN1 to IN4 of a
+// ULN2003 board, for each step. Stepping forward walks it in order.
+type Sequence [][4]int
+
+var (
+	// FullStep energizes two coils at a time, full torque
+	FullStep = Sequence{
+		{1, 1, 0, 0},
+		{0, 1, 1, 0},
+		{0, 0, 1, 1},
+		{1, 0, 0, 1},
+	}
+
+	// HalfStep alternates one and two coils, twice the steps per
+	// turn with a little less torque on the single coil steps
+	HalfStep = Sequence{
+		{1, 0, 0, 0},
+		{1, 1, 0, 0},
+		{0, 1, 0, 0},
+		{0, 1, 1, 0},
+		{0, 0, 1, 0},
+		{0, 0, 1, 1},
+		{0, 0, 0, 1},
+		{1, 0, 0, 1},
+	}
+)
+
+// Coils drives a 4 wire stepper, like the 28BYJ-48, through four GPIO
+// lines
+type Coils struct {
+	pins  [4]*drivers.DigitalPin
+	seq   Sequence
+	phase int
+}
+
+// NewCoils requests the four lines at offsets of the default chip,
+// IN1 to IN4, all off
+func NewCoils(name string, offsets [4]int, seq Sequence) (*Coils, error) {
+	c := &Coils{seq: seq}
+	for n, off := range offsets {
+		pin, err := drivers.GetGPIO().Request(fmt.Sprintf("%s-in%d", name, n+1), off,
+			drivers.WithOwner("stepper"),
+			gpiocdev.AsOutput(0),
+		)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.pins[n] = pin
+	}
+	return c, nil
+}
+
+// Step moves to the next pattern of the sequence in dir
+func (c *Coils) Step(dir int) error {
+	c.phase = (c.phase + dir + len(c.seq)) % len(c.seq)
+	return c.write(c.seq[c.phase])
+}
+
+// Release turns every coil off
+func (c *Coils) Release() error {
+	return c.write([4]int{})
+}
+
+// Close turns the coils off and releases the lines
+func (c *Coils) Close() error {
+	var errs []error
+	for _, pin := range c.pins {
+		if pin != nil {
+			errs = append(errs, pin.Set(0), pin.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Coils) write(pattern [4]int) error {
+	for n, pin := range c.pins {
+		if err := pin.Set(pattern[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepDir drives a STEP/DIR driver board like the A4988, DRV8825 or
+// TMC2208. The board does the microstepping, a STEP pulse is one
+// microstep.
+type StepDir struct {
+	step   *drivers.DigitalPin
+	dir    *drivers.DigitalPin
+	enable *drivers.DigitalPin // nil without an ENABLE line
+
+	lastDir int
+	enabled bool
+}
+
+// NewStepDir requests the STEP, DIR and ENABLE lines at the offsets of
+// the default chip, enable is -1 if the board's ENABLE is not wired.
+// ENABLE is active low on these boards, the board starts disabled.
+func NewStepDir(name string, step, dir, enable int) (*StepDir, error) {
+	d := &StepDir{}
+	var err error
+	d.step, err = drivers.GetGPIO().Request(name+"-step", step, drivers.WithOwner("stepper"), gpiocdev.AsOutput(0))
+	if err != nil {
+		return nil, err
+	}
+	d.dir, err = drivers.GetGPIO().Request(name+"-dir", dir, drivers.WithOwner("stepper"), gpiocdev.AsOutput(0))
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	if enable >= 0 {
+		d.enable, err = drivers.GetGPIO().Request(name+"-enable", enable,
+			drivers.WithOwner("stepper"),
+			gpiocdev.AsActiveLow,
+			gpiocdev.AsOutput(0),
+		)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Step sets DIR, high for forward, and pulses STEP. The boards want a
+// pulse of a microsecond or two, setting a line takes longer than
+// that.
+func (d *StepDir) Step(dir int) error {
+	if d.enable != nil && !d.enabled {
+		if err := d.enable.Set(1); err != nil {
+			return err
+		}
+		d.enabled = true
+	}
+	if dir != d.lastDir {
+		if err := d.dir.Set(max(dir, 0)); err != nil {
+			return err
+		}
+		d.lastDir = dir
+	}
+	if err := d.step.Set(1); err != nil {
+		return err
+	}
+	return d.step.Set(0)
+}
+
+// Release disables the board, without an ENABLE line the coils stay
+// energized
+func (d *StepDir) Release() error {
+	if d.enable == nil || !d.enabled {
+		return nil
+	}
+	d.enabled = false
+	return d.enable.Set(0)
+}
+
+// Close disables the board and releases the lines
+func (d *StepDir) Close() error {
+	errs := []error{d.Release()}
+	for _, pin := range []*drivers.DigitalPin{d.step, d.dir, d.enable} {
+		if pin != nil {
+			errs = append(errs, pin.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,34 @@
+package stepper
+
+import (
+	"math"
+	"time"
+)
+
+// profile is the trapezoidal speed profile of a move of n steps: the
+// speed ramps up at accel steps/s² from rest, runs at speed steps/s
+// and ramps down to stop on the last step. A move too short to reach
+// speed turns around half way, a triangle.
+type profile struct {
+	n     int64
+	speed float64
+	accel float64 // 0 runs every step at speed
+}
+
+// interval returns the wait before step i, 0 to n - 1. Under constant
+// acceleration from rest x steps are taken in sqrt(2x / accel), a
+// step on the ramps waits the difference.
+func (p profile) interval(i int64) time.Duration {
+	d := 1 / p.speed
+	if p.accel > 0 {
+		up := ramp(i, p.accel)
+		down := ramp(p.n-1-i, p.accel)
+		d = max(d, up, down)
+	}
+	return time.Duration(d * float64(time.Second))
+}
+
+// ramp returns the seconds step i takes accelerating from rest
+func ramp(i int64, accel float64) float64 {
+	return math.Sqrt(2*float64(i+1)/accel) - math.Sqrt(2*float64(i)/accel)
+}
@@ -0,0 +1,425 @@
+// Package stepper moves a stepper motor through a Driver, four coil
+// lines (Coils) or a STEP/DIR driver board (StepDir), and keeps track
+// of where it is.
+//
+// Moves ramp up and down with a trapezoidal profile, MaxSpeed and
+// Accel in steps, and run until done, canceled or stopped by a limit
+// switch. The absolute position is saved in the device store after
+// every move so it survives a restart, the motor has to stay put while
+// the program is down. The lower limit switch is the home, Home runs
+// the motor into it and calls that position 0.
+package stepper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	DefaultMaxSpeed  = 500  // steps/s
+	DefaultAccel     = 1000 // steps/s²
+	DefaultHomeSpeed = 100  // steps/s
+)
+
+var (
+	ErrBusy    = errors.New("stepper is moving")
+	ErrLimit   = errors.New("stepper stopped by a limit switch")
+	ErrNoLimit = errors.New("stepper has no lower limit switch")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes
+type Reading struct {
+	Position int64 `json:"position"`
+	Target   int64 `json:"target"`
+	Moving   bool  `json:"moving"`
+	Homed    bool  `json:"homed"`
+}
+
+// saved is what the device store keeps
+type saved struct {
+	Position int64 `json:"position"`
+}
+
+// Stepper is a stepper motor
+type Stepper struct {
+	*device.Device
+	Driver
+
+	// MaxSpeed in steps/s and Accel in steps/s² shape the moves, an
+	// Accel of 0 starts and stops at MaxSpeed. HomeSpeed is the
+	// constant speed Home creeps to the switch at.
+	MaxSpeed  float64
+	Accel     float64
+	HomeSpeed float64
+
+	// ReleaseIdle de-energizes the coils between moves, the motor
+	// runs cool but holds only by its detent torque
+	ReleaseIdle bool
+
+	lower *drivers.DigitalPin
+	upper *drivers.DigitalPin
+
+	position int64
+	target   int64
+	moving   bool
+	homed    bool
+	cancel   context.CancelFunc
+
+	wait func(ctx context.Context, d time.Duration) error
+	mu   sync.Mutex
+}
+
+// New creates a stepper moved by d. The position saved in the device
+// store is loaded, 0 if there is none.
+func New(name string, d Driver) *Stepper {
+	s := &Stepper{
+		Device:    device.NewDevice(name, "mqtt"),
+		Driver:    d,
+		MaxSpeed:  DefaultMaxSpeed,
+		Accel:     DefaultAccel,
+		HomeSpeed: DefaultHomeSpeed,
+		wait:      wait,
+	}
+	var sv saved
+	err := device.GetStore().Load(s.storeKey(), &sv)
+	switch {
+	case err == nil:
+		s.position, s.target = sv.Position, sv.Position
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("stepper loading position", "device", name, "error", err)
+	}
+	return s
+}
+
+func (s *Stepper) storeKey() string {
+	return s.Device.Name + "/position"
+}
+
+// Name returns the name of the device
+func (s *Stepper) Name() string {
+	return s.Device.Name
+}
+
+// SetLimits requests the limit switches at the offsets of the default
+// chip, -1 for a switch that is not fitted. The switches close to
+// ground, the lines are pulled up. A pressed switch stops any move
+// towards it, the lower one is the home.
+func (s *Stepper) SetLimits(lower, upper int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.moving {
+		return ErrBusy
+	}
+	req := func(suffix string, offset int) (*drivers.DigitalPin, error) {
+		if offset < 0 {
+			return nil, nil
+		}
+		return drivers.GetGPIO().Request(s.Device.Name+suffix, offset,
+			drivers.WithOwner("stepper"),
+			gpiocdev.AsInput,
+			gpiocdev.AsActiveLow,
+			gpiocdev.WithPullUp,
+		)
+	}
+	lo, err := req("-lower", lower)
+	if err != nil {
+		return err
+	}
+	up, err := req("-upper", upper)
+	if err != nil {
+		if lo != nil {
+			lo.Close()
+		}
+		return err
+	}
+	s.closeLimits()
+	s.lower, s.upper = lo, up
+	return nil
+}
+
+// Position returns the absolute position in steps
+func (s *Stepper) Position() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position
+}
+
+// SetPosition calls the current position pos without moving, like
+// after lining the motor up by hand
+func (s *Stepper) SetPosition(pos int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.moving {
+		return ErrBusy
+	}
+	s.position, s.target = pos, pos
+	return s.save()
+}
+
+// MoveTo moves to the absolute position pos. It returns when the move
+// is done, with the ctx error when it is canceled or Stop is called,
+// or ErrLimit when a limit switch stopped it. The position is kept
+// wherever the motor stopped.
+func (s *Stepper) MoveTo(ctx context.Context, pos int64) error {
+	run, err := s.start(ctx, func(at int64) int64 { return pos - at }, false)
+	if err != nil {
+		return err
+	}
+	return run()
+}
+
+// MoveBy moves steps from the current position, see MoveTo
+func (s *Stepper) MoveBy(ctx context.Context, steps int64) error {
+	run, err := s.start(ctx, func(int64) int64 { return steps }, false)
+	if err != nil {
+		return err
+	}
+	return run()
+}
+
+// Home creeps towards the lower limit switch at HomeSpeed until it is
+// pressed and makes that position 0
+func (s *Stepper) Home(ctx context.Context) error {
+	run, err := s.start(ctx, func(int64) int64 { return math.MinInt64 + 1 }, true)
+	if err != nil {
+		return err
+	}
+	return run()
+}
+
+// Stop cancels the move in progress, the motor stops at once
+func (s *Stepper) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// Release de-energizes the coils
+func (s *Stepper) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.moving {
+		return ErrBusy
+	}
+	return s.Driver.Release()
+}
+
+// Command handles a command payload: "move 200", "moveto 0", "home",
+// "stop" or "release". Moves run in the background, their errors are
+// logged. The moves fail with device.ErrInhibited while an interlock,
+// like a tripped limit switch, inhibits the motor, MoveBy backs it
+// off.
+func (s *Stepper) Command(payload []byte) error {
+	f := strings.Fields(strings.ToLower(string(payload)))
+	switch {
+	case len(f) == 2 && (f[0] == "move" || f[0] == "moveto"):
+		n, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			break
+		}
+		if err := s.Inhibited(); err != nil {
+			return err
+		}
+		steps := func(at int64) int64 { return n - at }
+		if f[0] == "move" {
+			steps = func(int64) int64 { return n }
+		}
+		return s.background(steps, false)
+	case len(f) == 1 && f[0] == "home":
+		if err := s.Inhibited(); err != nil {
+			return err
+		}
+		return s.background(func(int64) int64 { return math.MinInt64 + 1 }, true)
+	case len(f) == 1 && f[0] == "stop":
+		s.Stop()
+		return nil
+	case len(f) == 1 && f[0] == "release":
+		return s.Release()
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// background starts the move of a command and runs it, ErrBusy is
+// returned at once if the motor is moving
+func (s *Stepper) background(steps func(at int64) int64, homing bool) error {
+	run, err := s.start(context.Background(), steps, homing)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := run(); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Warn("stepper move", "device", s.Device.Name, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Read returns the position and the state of the motor
+func (s *Stepper) Read() *Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Reading{
+		Position: s.position,
+		Target:   s.target,
+		Moving:   s.moving,
+		Homed:    s.homed,
+	}
+}
+
+// ReadPub publishes the reading
+func (s *Stepper) ReadPub() error {
+	j, err := json.Marshal(s.Read())
+	if err != nil {
+		return err
+	}
+	s.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (s *Stepper) Run(ctx context.Context, period time.Duration) error {
+	err := s.TimerLoop(ctx, period, s.ReadPub)
+	slog.Debug("stepper stopped", "device", s.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the motor, saves the position and releases the driver
+// and the limit switches
+func (s *Stepper) Close() error {
+	s.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLimits()
+	return errors.Join(s.save(), s.Driver.Close())
+}
+
+// start claims the motor for a move of steps(position) steps and
+// returns the function running it, a homing move creeps at HomeSpeed
+// until the lower switch
+func (s *Stepper) start(ctx context.Context, steps func(at int64) int64, homing bool) (run func() error, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.moving {
+		return nil, ErrBusy
+	}
+	n := steps(s.position)
+	if n == 0 && !homing {
+		return func() error { return nil }, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.moving, s.cancel = true, cancel
+	s.target = s.position + n
+	p := profile{n: n, speed: s.MaxSpeed, accel: s.Accel}
+	if homing {
+		s.target = 0
+		p = profile{n: n, speed: s.HomeSpeed}
+	}
+	dir, limit := 1, s.upper
+	if n < 0 {
+		dir, limit, p.n = -1, s.lower, -n
+	}
+	return func() error {
+		err := s.steps(ctx, p, dir, limit, homing)
+		cancel()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.moving, s.cancel = false, nil
+		s.target = s.position
+		if s.ReleaseIdle {
+			err = errors.Join(err, s.Driver.Release())
+		}
+		if serr := s.save(); serr != nil {
+			slog.Warn("stepper saving position", "device", s.Device.Name, "error", serr)
+		}
+		return err
+	}, nil
+}
+
+// steps takes the steps of a move in dir, stopping if limit, the
+// switch in the way, is pressed
+func (s *Stepper) steps(ctx context.Context, p profile, dir int, limit *drivers.DigitalPin, homing bool) error {
+	if homing && limit == nil {
+		return ErrNoLimit
+	}
+	for i := int64(0); i < p.n; i++ {
+		if pressed(limit) {
+			return s.hitLimit(dir < 0, homing)
+		}
+		if err := s.wait(ctx, p.interval(i)); err != nil {
+			return err
+		}
+		if err := s.Driver.Step(dir); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.position += int64(dir)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// hitLimit stops a move at a limit switch, the lower one sets the home
+func (s *Stepper) hitLimit(lower, homing bool) error {
+	if !lower {
+		return fmt.Errorf("%w: upper at %d", ErrLimit, s.Position())
+	}
+	s.mu.Lock()
+	s.position, s.homed = 0, true
+	s.mu.Unlock()
+	if homing {
+		return nil
+	}
+	return fmt.Errorf("%w: lower, position set to 0", ErrLimit)
+}
+
+// save saves the position, s.mu is held
+func (s *Stepper) save() error {
+	return device.GetStore().Save(s.storeKey(), &saved{Position: s.position})
+}
+
+// closeLimits releases the limit switches, s.mu is held
+func (s *Stepper) closeLimits() {
+	for _, pin := range []*drivers.DigitalPin{s.lower, s.upper} {
+		if pin != nil {
+			pin.Close()
+		}
+	}
+	s.lower, s.upper = nil, nil
+}
+
+// pressed returns whether a limit switch is pressed, a switch that
+// cannot be read is taken as pressed
+func pressed(pin *drivers.DigitalPin) bool {
+	if pin == nil {
+		return false
+	}
+	v, err := pin.Get()
+	return err != nil || v == 1
+}
+
+// wait waits d or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
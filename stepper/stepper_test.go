@@ -0,0 +1,421 @@
+package stepper
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+// recorder is a Driver keeping the steps, onStep is called after
+// every step with the number of steps so far
+type recorder struct {
+	steps    []int
+	releases int
+	onStep   func(n int)
+	mu       sync.Mutex
+}
+
+func (r *recorder) Step(dir int) error {
+	r.mu.Lock()
+	r.steps = append(r.steps, dir)
+	n, f := len(r.steps), r.onStep
+	r.mu.Unlock()
+	if f != nil {
+		f(n)
+	}
+	return nil
+}
+
+func (r *recorder) Release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.releases++
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+// clock is a simulated clock, wait advances it instead of sleeping and
+// records the intervals
+type clock struct {
+	elapsed   time.Duration
+	intervals []time.Duration
+}
+
+func (c *clock) wait(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.elapsed += d
+	c.intervals = append(c.intervals, d)
+	return nil
+}
+
+func newTestStepper(t *testing.T) (*Stepper, *recorder, *clock) {
+	t.Helper()
+	devicetest.UseStore(t)
+	r := &recorder{}
+	c := &clock{}
+	s := New("stepper", r)
+	s.wait = c.wait
+	return s, r, c
+}
+
+func TestProfile(t *testing.T) {
+	// no acceleration, every step at speed
+	p := profile{n: 10, speed: 200}
+	for i := int64(0); i < p.n; i++ {
+		if got := p.interval(i); got != 5*time.Millisecond {
+			t.Errorf("constant interval(%d) got (%v) want (5ms)", i, got)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		p     profile
+		total time.Duration // of the ideal trapezoid or triangle
+		peak  float64
+	}{
+		// 0.5s up to 500 steps/s over 125 steps, 1750 steps at speed,
+		// 0.5s down
+		{"trapezoid", profile{n: 2000, speed: 500, accel: 1000}, 4500 * time.Millisecond, 500},
+		// turns at 50 steps, 0.316s up to 316 steps/s and back down
+		{"triangle", profile{n: 100, speed: 500, accel: 1000}, 632455 * time.Microsecond, math.Sqrt(1e5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total time.Duration
+			fastest := time.Hour
+			for i := int64(0); i < tt.p.n; i++ {
+				d := tt.p.interval(i)
+				total += d
+				fastest = min(fastest, d)
+				if back := tt.p.interval(tt.p.n - 1 - i); back != d {
+					t.Fatalf("interval(%d) got (%v) want the same as on the way down (%v)", i, d, back)
+				}
+				if i > 0 && i < tt.p.n/2 && d > tt.p.interval(i-1) {
+					t.Fatalf("interval(%d) got (%v) want no slower than the step before", i, d)
+				}
+			}
+			if diff := math.Abs(float64(total-tt.total)) / float64(tt.total); diff > 0.02 {
+				t.Errorf("move time got (%v) want (%v)", total, tt.total)
+			}
+			if peak := 1 / fastest.Seconds(); math.Abs(peak-tt.peak)/tt.peak > 0.02 {
+				t.Errorf("peak speed got (%v) want (%v)", peak, tt.peak)
+			}
+		})
+	}
+}
+
+func TestCoils(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	pattern := func() [4]int {
+		var p [4]int
+		for n := range p {
+			p[n], _ = chip.Line(20 + n).Value()
+		}
+		return p
+	}
+	for _, seq := range []Sequence{FullStep, HalfStep} {
+		c, err := NewCoils("stepper", [4]int{20, 21, 22, 23}, seq)
+		if err != nil {
+			t.Fatalf("NewCoils() error = %v", err)
+		}
+		if pattern() != [4]int{} {
+			t.Errorf("coils at start got (%v) want off", pattern())
+		}
+		// forward around the sequence twice and back
+		for i := 1; i <= 2*len(seq); i++ {
+			c.Step(1)
+			if want := seq[i%len(seq)]; pattern() != want {
+				t.Errorf("forward step %d got (%v) want (%v)", i, pattern(), want)
+			}
+		}
+		for i := 2*len(seq) - 1; i >= 0; i-- {
+			c.Step(-1)
+			if want := seq[i%len(seq)]; pattern() != want {
+				t.Errorf("back to step %d got (%v) want (%v)", i, pattern(), want)
+			}
+		}
+		c.Release()
+		if pattern() != [4]int{} {
+			t.Errorf("released coils got (%v) want off", pattern())
+		}
+		c.Close()
+		if !chip.Line(20).Closed() {
+			t.Error("Close() did not release the lines")
+		}
+	}
+}
+
+func TestStepDir(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	d, err := NewStepDir("stepper", 5, 6, 7)
+	if err != nil {
+		t.Fatalf("NewStepDir() error = %v", err)
+	}
+	for _, dir := range []int{1, 1, 1, -1, -1} {
+		d.Step(dir)
+	}
+	step, dir, enable := chip.Line(5), chip.Line(6), chip.Line(7)
+	if len(step.Values) != 10 {
+		t.Errorf("STEP got (%v) want 5 pulses", step.Values)
+	}
+	if len(dir.Values) != 2 || dir.Values[0] != 1 || dir.Values[1] != 0 {
+		t.Errorf("DIR got (%v) want (1 0)", dir.Values)
+	}
+	if v, _ := enable.Value(); v != 1 {
+		t.Errorf("ENABLE got (%d) want asserted", v)
+	}
+	d.Release()
+	if v, _ := enable.Value(); v != 0 {
+		t.Errorf("ENABLE after Release() got (%d) want released", v)
+	}
+	d.Close()
+	if !step.Closed() || !enable.Closed() {
+		t.Error("Close() did not release the lines")
+	}
+}
+
+func TestMove(t *testing.T) {
+	s, r, c := newTestStepper(t)
+	s.MaxSpeed, s.Accel = 500, 1000
+	ctx := context.Background()
+
+	if err := s.MoveTo(ctx, 2000); err != nil {
+		t.Fatalf("MoveTo() error = %v", err)
+	}
+	if s.Position() != 2000 || len(r.steps) != 2000 || r.steps[0] != 1 {
+		t.Errorf("MoveTo(2000) got (position %d, %d steps) want (2000, 2000)", s.Position(), len(r.steps))
+	}
+	p := profile{n: 2000, speed: 500, accel: 1000}
+	for i, d := range c.intervals {
+		if d != p.interval(int64(i)) {
+			t.Fatalf("step %d came after (%v) want (%v)", i, d, p.interval(int64(i)))
+		}
+	}
+	if math.Abs(c.elapsed.Seconds()-4.5) > 0.09 {
+		t.Errorf("MoveTo(2000) took (%v) want about 4.5s", c.elapsed)
+	}
+
+	r.steps, c.intervals = nil, nil
+	if err := s.MoveBy(ctx, -300); err != nil {
+		t.Fatalf("MoveBy() error = %v", err)
+	}
+	if s.Position() != 1700 || len(r.steps) != 300 || r.steps[299] != -1 {
+		t.Errorf("MoveBy(-300) got (position %d, %d steps) want (1700, 300)", s.Position(), len(r.steps))
+	}
+	if err := s.MoveTo(ctx, 1700); err != nil || len(c.intervals) != 300 {
+		t.Errorf("MoveTo() where it is got (%v, %d steps) want no move", err, len(c.intervals)-300)
+	}
+	if r.releases != 0 {
+		t.Errorf("releases got (%d) want the coils held", r.releases)
+	}
+
+	s.ReleaseIdle = true
+	s.MoveBy(ctx, 10)
+	if r.releases != 1 {
+		t.Errorf("releases with ReleaseIdle got (%d) want (1)", r.releases)
+	}
+	if got := s.Read(); got.Position != 1710 || got.Target != 1710 || got.Moving {
+		t.Errorf("Read() got (%+v) want at 1710 and stopped", got)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	devicetest.UseStore(t)
+	r := &recorder{}
+	s := New("stepper", r)
+	s.MaxSpeed, s.Accel = 1000, 0
+
+	done := make(chan error)
+	go func() { done <- s.MoveBy(context.Background(), 100000) }()
+	time.Sleep(50 * time.Millisecond)
+	if err := s.MoveBy(context.Background(), 1); !errors.Is(err, ErrBusy) {
+		t.Errorf("a second move error got (%v) want (%v)", err, ErrBusy)
+	}
+	start := time.Now()
+	s.Stop()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("stopped move error got (%v) want (%v)", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("the move stopped after (%v) want at once", d)
+	}
+	r.mu.Lock()
+	n := int64(len(r.steps))
+	r.mu.Unlock()
+	if n == 0 || n > 1000 || s.Position() != n {
+		t.Errorf("stopped at (%d) after (%d) steps want where the motor is", s.Position(), n)
+	}
+
+	// a deadline cancels the same way
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := s.MoveBy(ctx, -100000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timed out move error got (%v) want (%v)", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	s, r, _ := newTestStepper(t)
+	s.Accel = 0
+	ctx := context.Background()
+
+	if err := s.Home(ctx); !errors.Is(err, ErrNoLimit) {
+		t.Errorf("Home() without a switch error got (%v) want (%v)", err, ErrNoLimit)
+	}
+	if err := s.SetLimits(8, 9); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	lower, upper := chip.Line(8), chip.Line(9)
+
+	// the upper switch closes 40 steps in
+	r.onStep = func(n int) {
+		if n == 40 {
+			upper.Edge(1)
+		}
+	}
+	if err := s.MoveTo(ctx, 100); !errors.Is(err, ErrLimit) {
+		t.Errorf("MoveTo() into the upper switch error got (%v) want (%v)", err, ErrLimit)
+	}
+	if s.Position() != 40 || s.Read().Homed {
+		t.Errorf("stopped at (%d) want (40) and not homed", s.Position())
+	}
+	// moving away from a pressed switch is fine
+	r.onStep = func(n int) {
+		if n == 45 {
+			upper.Edge(0)
+		}
+	}
+	if err := s.MoveBy(ctx, -10); err != nil || s.Position() != 30 {
+		t.Errorf("backing off got (%v, %d) want (nil, 30)", err, s.Position())
+	}
+
+	// the lower switch, 25 steps below, is the home
+	r.steps = nil
+	r.onStep = func(n int) {
+		if n == 25 {
+			lower.Edge(1)
+		}
+	}
+	if err := s.Home(ctx); err != nil {
+		t.Fatalf("Home() error = %v", err)
+	}
+	if got := s.Read(); got.Position != 0 || !got.Homed || len(r.steps) != 25 {
+		t.Errorf("Home() got (%+v) after (%d) steps want home after 25", got, len(r.steps))
+	}
+	if err := s.MoveBy(ctx, -5); !errors.Is(err, ErrLimit) || s.Position() != 0 {
+		t.Errorf("MoveBy() past home got (%v, %d) want (%v, 0)", err, s.Position(), ErrLimit)
+	}
+
+	// running into home on a move resets the position too
+	r.onStep = func(n int) {
+		if n == 45 { // 5 steps before the 50 the motor thinks it needs
+			lower.Edge(1)
+		}
+	}
+	lower.Edge(0)
+	r.steps = nil
+	s.MoveTo(ctx, 50)
+	r.steps = nil
+	if err := s.MoveTo(ctx, 0); !errors.Is(err, ErrLimit) || s.Position() != 0 {
+		t.Errorf("MoveTo() into home got (%v, %d) want (%v, 0)", err, s.Position(), ErrLimit)
+	}
+	s.Close()
+	if !lower.Closed() || !upper.Closed() {
+		t.Error("Close() did not release the switches")
+	}
+}
+
+func TestPosition(t *testing.T) {
+	s, _, _ := newTestStepper(t)
+	s.MoveTo(context.Background(), 250)
+
+	again := New("stepper", &recorder{})
+	if again.Position() != 250 {
+		t.Errorf("position after restart got (%d) want (250)", again.Position())
+	}
+	again.SetPosition(-10)
+	if New("stepper", &recorder{}).Position() != -10 {
+		t.Error("SetPosition() was not saved")
+	}
+	if New("other", &recorder{}).Position() != 0 {
+		t.Error("a new stepper got a saved position")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s, r, _ := newTestStepper(t)
+	idle := func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for s.Read().Moving {
+			if time.Now().After(deadline) {
+				t.Fatal("the move did not finish")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := s.Command([]byte("move 120")); err != nil {
+		t.Fatalf("move error = %v", err)
+	}
+	idle()
+	if err := s.Command([]byte("MoveTo -30")); err != nil {
+		t.Fatalf("moveto error = %v", err)
+	}
+	idle()
+	if s.Position() != -30 {
+		t.Errorf("position got (%d) want (-30)", s.Position())
+	}
+	if err := s.Command([]byte("release")); err != nil || r.releases != 1 {
+		t.Errorf("release got (%v, %d releases) want one", err, r.releases)
+	}
+	if err := s.Command([]byte("stop")); err != nil {
+		t.Errorf("stop error = %v", err)
+	}
+
+	// an interlock holds the moves back, not the stop
+	s.Inhibit("x-min", true)
+	for _, move := range []string{"move 10", "moveto 0", "home"} {
+		if err := s.Command([]byte(move)); !errors.Is(err, device.ErrInhibited) {
+			t.Errorf("Command(%q) inhibited error got (%v) want (%v)", move, err, device.ErrInhibited)
+		}
+	}
+	if err := s.Command([]byte("stop")); err != nil || s.Position() != -30 {
+		t.Errorf("stop inhibited got (%v) at (%d) want (nil) at (-30)", err, s.Position())
+	}
+	s.Inhibit("x-min", false)
+	if err := s.Command([]byte("move 10")); err != nil {
+		t.Fatalf("move let go error = %v", err)
+	}
+	idle()
+	if s.Position() != -20 {
+		t.Errorf("position got (%d) want (-20)", s.Position())
+	}
+
+	for _, bad := range []string{"move", "move far", "moveto 1.5", "spin", ""} {
+		if err := s.Command([]byte(bad)); !errors.Is(err, ErrCommand) {
+			t.Errorf("Command(%q) error got (%v) want (%v)", bad, err, ErrCommand)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps the little state devices need across restarts, like
+// calibrations and baselines, as JSON under a key. Devices use their
+// name in the key so devices of the same kind do not share values.
+type Store interface {
+	// Load unmarshals the value saved under key into v, it returns
+	// ErrNotStored if nothing was saved
+	Load(key string, v any) error
+
+	// Save saves v under key, replacing what was saved before
+	Save(key string, v any) error
+}
+
+// ErrNotStored is returned by Load for a key that was never saved
+var ErrNotStored = errors.New("not stored")
+
+var (
+	store   Store = NewMemStore()
+	storeMu sync.RWMutex
+)
+
+// GetStore returns the store devices save to, a MemStore unless
+// SetStore was called
+func GetStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// SetStore sets the store devices save to, usually a FileStore set
+// before the devices are created
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// FileStore keeps every key in a JSON file of its own in Dir
+type FileStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore creates a store in dir, the directory is created on
+// the first Save
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.Dir, url.PathEscape(key)+".json")
+}
+
+// Load reads the file of key into v
+func (s *FileStore) Load(key string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotStored, key)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+// Save writes v to the file of key. The file is replaced with a
+// rename so a power cut never leaves half a calibration behind.
+func (s *FileStore) Save(key string, v any) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	p := s.path(key)
+	if err := os.WriteFile(p+".tmp", j, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// MemStore keeps the keys in memory, it is the default store and
+// the one for tests
+type MemStore struct {
+	vals map[string][]byte
+	mu   sync.Mutex
+}
+
+// NewMemStore creates an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{vals: make(map[string][]byte)}
+}
+
+// Load unmarshals the value of key into v
+func (s *MemStore) Load(key string, v any) error {
+	s.mu.Lock()
+	j, ok := s.vals[key]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotStored, key)
+	}
+	return json.Unmarshal(j, v)
+}
+
+// Save marshals v as the value of key
+func (s *MemStore) Save(key string, v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals[key] = j
+	return nil
+}
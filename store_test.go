@@ -0,0 +1,73 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testCal struct {
+	Dry float64 `json:"dry"`
+	Wet float64 `json:"wet"`
+}
+
+func TestStores(t *testing.T) {
+	for name, s := range map[string]Store{
+		"file": NewFileStore(filepath.Join(t.TempDir(), "store")),
+		"mem":  NewMemStore(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got testCal
+			if err := s.Load("soil/calibration", &got); !errors.Is(err, ErrNotStored) {
+				t.Fatalf("Load() of a new key error got (%v) want (%v)", err, ErrNotStored)
+			}
+
+			want := testCal{Dry: 2.6, Wet: 1.2}
+			if err := s.Save("soil/calibration", &want); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if err := s.Save("soil/calibration", &want); err != nil {
+				t.Fatalf("second Save() error = %v", err)
+			}
+			if err := s.Load("soil/calibration", &got); err != nil || got != want {
+				t.Errorf("Load() got (%v, %v) want (%v)", got, err, want)
+			}
+			if err := s.Load("soil", &got); !errors.Is(err, ErrNotStored) {
+				t.Errorf("Load() of another key error got (%v) want (%v)", err, ErrNotStored)
+			}
+		})
+	}
+}
+
+func TestFileStoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	if err := s.Save("soil/calibration", &testCal{Dry: 2.6}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "soil%2Fcalibration.json" {
+		t.Errorf("files got (%v) want ([soil%%2Fcalibration.json])", files)
+	}
+
+	// a new store on the same directory, like after a restart
+	var got testCal
+	if err := NewFileStore(dir).Load("soil/calibration", &got); err != nil || got.Dry != 2.6 {
+		t.Errorf("Load() after restart got (%v, %v) want ({2.6 0})", got, err)
+	}
+}
+
+func TestSetStore(t *testing.T) {
+	old := GetStore()
+	defer SetStore(old)
+
+	s := NewMemStore()
+	SetStore(s)
+	if GetStore() != s {
+		t.Error("GetStore() did not return the store set")
+	}
+}
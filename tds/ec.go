@@ -0,0 +1,76 @@
+package tds
+
+import (
+	"fmt"
+
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// Alpha is the rise of the conductivity per °C, 2% for most
+	// water, EC is reported at 25°C
+	Alpha = 0.02
+
+	// MinK and MaxK bound the calibration factor of a working probe
+	MinK = 0.5
+	MaxK = 1.5
+)
+
+// TDS factors converting EC in µS/cm to ppm
+const (
+	FactorNaCl    = 0.5  // sodium chloride, the usual one
+	FactorKCl     = 0.64 // potassium chloride
+	FactorNatural = 0.7  // the 442 natural water mix
+)
+
+// ec returns the conductivity in µS/cm of a probe reading volts at
+// 25°C, the cubic of the common analog TDS boards
+func ec(volts float64) float64 {
+	return 133.42*volts*volts*volts - 255.86*volts*volts + 857.39*volts
+}
+
+// compensate returns the volts a probe reading volts at temp °C
+// reads at 25°C
+func compensate(volts, temp float64) float64 {
+	return volts / (1 + Alpha*(temp-25))
+}
+
+// Calibration scales the EC of the cubic by K, taken in a reference
+// solution of Reference µS/cm at Temp °C
+type Calibration struct {
+	K         float64 `json:"k"`
+	Reference float64 `json:"reference,omitempty"`
+	Temp      float64 `json:"temp,omitempty"`
+}
+
+// DefaultCalibration is the cubic as it is
+var DefaultCalibration = Calibration{K: 1}
+
+// Valid returns drivers.ErrCalibration for a factor out of MinK and
+// MaxK, a probe that far off is broken or was in the wrong solution
+func (c Calibration) Valid() error {
+	if !(c.K >= MinK && c.K <= MaxK) {
+		return fmt.Errorf("%w: k %.3f", drivers.ErrCalibration, c.K)
+	}
+	return nil
+}
+
+// EC returns the conductivity in µS/cm at 25°C of a probe reading
+// volts at temp °C
+func (c Calibration) EC(volts, temp float64) float64 {
+	return c.K * ec(compensate(volts, temp))
+}
+
+// Calibrate returns the calibration of a probe reading volts at temp
+// °C in a solution of reference µS/cm at 25°C
+func Calibrate(volts, temp, reference float64) (Calibration, error) {
+	raw := ec(compensate(volts, temp))
+	if !(raw > 0) || !(reference > 0) {
+		return DefaultCalibration, fmt.Errorf("%w: %.0fµS/cm reading %.0fµS/cm", drivers.ErrCalibration, reference, raw)
+	}
+	c := Calibration{K: reference / raw, Reference: reference, Temp: temp}
+	if err := c.Valid(); err != nil {
+		return DefaultCalibration, err
+	}
+	return c, nil
+}
@@ -0,0 +1,33 @@
+package tds
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a TDS probe, the volts of its mock pin at 25°C.
+// "tap" water reads around 0.5V, about 190ppm. A "hydroponic"
+// reservoir falls from 2.3V to 1.8V over a week, about 1120ppm to
+// 750ppm as the plants feed, and is topped up with nutrients.
+func init() {
+	device.RegisterMockProfile("tds", "tap", func(t *TDS) error {
+		return t.profile(device.Walk(0.5, 0.0005, 0.45, 0.55, t.MockRand()))
+	})
+	device.RegisterMockProfile("tds", "hydroponic", func(t *TDS) error {
+		return t.profile(device.Ramp(2.3, 1.8, 7*24*time.Hour))
+	})
+}
+
+// profile has the mock pin read the volts of g with the noise of the
+// probe, started now
+func (t *TDS) profile(g device.Generator) error {
+	pin, ok := t.AnalogReader.(*drivers.MockAnalogPin)
+	if !ok {
+		return fmt.Errorf("%w: %s reads no mock pin", device.ErrMockProfile, t.Device.Name)
+	}
+	pin.SetGenerator(device.Sum(g, device.Noise(0.01, t.MockRand())))
+	return nil
+}
@@ -0,0 +1,364 @@
+// Package tds reads an analog TDS/EC water quality probe over a
+// drivers.AnalogReader, one channel of an ADS1115 by default. The
+// voltage is converted to conductivity with the cubic of the common
+// analog TDS boards, compensated to 25°C with the temperature of a
+// linked device.Thermometer, like the ds18b20 in the tank, and to total dissolved solids in ppm
+// with a factor. A single point calibration in a reference solution
+// is taken with the "calibrate 1413" command and saved in the device
+// store.
+package tds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	// ReferenceTemp is the temperature in °C assumed without a
+	// temperature device
+	ReferenceTemp = 25.0
+
+	// DefaultSamples are read for a reading, the median is taken
+	DefaultSamples = 9
+
+	// DefaultAirVolts is the reading of a probe out of the water
+	DefaultAirVolts = 0.01
+
+	// DefaultMaxEC is the top of the range of the common boards,
+	// 1000ppm of NaCl
+	DefaultMaxEC = 2000.0
+)
+
+var (
+	ErrSensor  = device.ErrSource
+	ErrFactor  = errors.New("invalid tds factor")
+	ErrCommand = errors.New("unknown command")
+)
+
+// Reading is what ReadPub publishes, EC in µS/cm and TDS in ppm at
+// 25°C. Temperature is the one compensated for, Compensated is set
+// when it came from the linked device. Air is set when the probe
+// reads next to nothing, out of the water, and OutOfRange when the
+// EC is beyond the range of the probe.
+type Reading struct {
+	EC          float64 `json:"ec"`
+	TDS         float64 `json:"tds"`
+	Volts       float64 `json:"volts"`
+	Temperature float64 `json:"temperature"`
+	Compensated bool    `json:"compensated,omitempty"`
+	Air         bool    `json:"air,omitempty"`
+	OutOfRange  bool    `json:"out_of_range,omitempty"`
+}
+
+// ProbeEvent is published when the probe comes out of the water,
+// "air", and when it is back in, "immersed"
+type ProbeEvent struct {
+	Event string `json:"event"`
+}
+
+// TDS is an analog TDS/EC probe
+type TDS struct {
+	*device.Device
+	drivers.AnalogReader
+
+	// Samples are read for a reading and the median taken
+	Samples int
+
+	// AirVolts is the most a probe out of the water reads
+	AirVolts float64
+
+	// MaxEC is the top of the range of the probe in µS/cm
+	MaxEC float64
+
+	cal    Calibration
+	factor float64
+	sensor string
+	temp   float64 // the last good temperature
+	air    bool
+
+	mu sync.Mutex
+}
+
+// New creates a probe on channel ch of the default ADS1115
+func New(name string, ch int) (*TDS, error) {
+	if device.IsMock() {
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, ch)), nil
+	}
+	p, err := drivers.GetADS1115().Pin(name, ch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p), nil
+}
+
+// NewWithReader creates a probe reading r converting with
+// FactorNaCl. The calibration saved in the device store is loaded,
+// DefaultCalibration is used if there is none.
+func NewWithReader(name string, r drivers.AnalogReader) *TDS {
+	t := &TDS{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		Samples:      DefaultSamples,
+		AirVolts:     DefaultAirVolts,
+		MaxEC:        DefaultMaxEC,
+		cal:          DefaultCalibration,
+		factor:       FactorNaCl,
+		temp:         ReferenceTemp,
+	}
+	var cal Calibration
+	err := device.GetStore().Load(t.storeKey(), &cal)
+	switch {
+	case err == nil && cal.Valid() == nil:
+		t.cal = cal
+	case err == nil:
+		slog.Warn("tds ignoring saved calibration", "device", name, "error", cal.Valid())
+	case !errors.Is(err, device.ErrNotStored):
+		slog.Warn("tds loading calibration", "device", name, "error", err)
+	}
+	t.SetMockKind("tds", t)
+	return t
+}
+
+func (t *TDS) storeKey() string {
+	return t.Device.Name + "/calibration"
+}
+
+// Name returns the name of the device
+func (t *TDS) Name() string {
+	return t.Device.Name
+}
+
+// Calibration returns the calibration in use
+func (t *TDS) Calibration() Calibration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cal
+}
+
+// SetCalibration sets and saves the calibration
+func (t *TDS) SetCalibration(c Calibration) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cal = c
+	return device.GetStore().Save(t.storeKey(), &c)
+}
+
+// Calibrate takes the probe standing in a reference solution of
+// reference µS/cm at 25°C, 1413 is the common one
+func (t *TDS) Calibrate(reference float64) error {
+	volts, err := t.sample()
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.Device.Name, err)
+	}
+	temp, _, err := t.temperature()
+	if err != nil {
+		return err
+	}
+	c, err := Calibrate(volts, temp, reference)
+	if err != nil {
+		return fmt.Errorf("%s: %w", t.Device.Name, err)
+	}
+	return t.SetCalibration(c)
+}
+
+// Factor returns the factor converting EC to TDS
+func (t *TDS) Factor() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.factor
+}
+
+// SetFactor sets the factor converting EC in µS/cm to TDS in ppm,
+// FactorNaCl, FactorKCl or FactorNatural, or one between 0.4 and 1
+// for another mix
+func (t *TDS) SetFactor(f float64) error {
+	if !(f >= 0.4 && f <= 1) {
+		return fmt.Errorf("%w: %g", ErrFactor, f)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.factor = f
+	return nil
+}
+
+// Link compensates the readings with the temperature device added to
+// the device manager as sensor, "" reads at ReferenceTemp again
+func (t *TDS) Link(sensor string) error {
+	if sensor != "" {
+		if _, err := device.GetThermometer(sensor); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sensor = sensor
+	t.temp = ReferenceTemp
+	return nil
+}
+
+// temperature returns the temperature of the linked device, and if
+// it was read. A device failing to read gives the last good
+// temperature, one gone from the device manager an error.
+func (t *TDS) temperature() (float64, bool, error) {
+	t.mu.Lock()
+	sensor, last := t.sensor, t.temp
+	t.mu.Unlock()
+	if sensor == "" {
+		return ReferenceTemp, false, nil
+	}
+	th, err := device.GetThermometer(sensor)
+	if err != nil {
+		return 0, false, err
+	}
+	temp, err := th.Temperature()
+	if err != nil {
+		slog.Warn("tds temperature, using the last one", "device", t.Device.Name,
+			"sensor", sensor, "temperature", last, "error", err)
+		return last, false, nil
+	}
+	t.mu.Lock()
+	t.temp = temp
+	t.mu.Unlock()
+	return temp, true, nil
+}
+
+// Command handles a command payload: "calibrate 1413" with the probe
+// in a reference solution of 1413µS/cm, "calibrate reset" for the
+// default calibration, or "factor 0.64"
+func (t *TDS) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	if len(c) != 2 {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+	switch {
+	case c[0] == "calibrate" && c[1] == "reset":
+		return t.SetCalibration(DefaultCalibration)
+	case c[0] == "calibrate":
+		ref, err := strconv.ParseFloat(c[1], 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrCommand, payload)
+		}
+		return t.Calibrate(ref)
+	case c[0] == "factor":
+		f, err := strconv.ParseFloat(c[1], 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrCommand, payload)
+		}
+		return t.SetFactor(f)
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// sample returns the median of Samples readings, the probe is noisy
+func (t *TDS) sample() (float64, error) {
+	vals := make([]float64, max(t.Samples, 1))
+	for i := range vals {
+		v, err := t.ReadVolts()
+		if err != nil {
+			return 0, err
+		}
+		vals[i] = v
+	}
+	slices.Sort(vals)
+	if n := len(vals); n%2 == 0 {
+		return (vals[n/2-1] + vals[n/2]) / 2, nil
+	}
+	return vals[len(vals)/2], nil
+}
+
+// Read samples the probe and returns the EC and TDS at 25°C
+func (t *TDS) Read() (*Reading, error) {
+	volts, err := t.sample()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t.Device.Name, err)
+	}
+	temp, compensated, err := t.temperature()
+	if err != nil {
+		return nil, err
+	}
+	r := &Reading{
+		Volts:       volts,
+		Temperature: temp,
+		Compensated: compensated,
+		Air:         volts < t.AirVolts,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !r.Air {
+		r.EC = t.cal.EC(volts, temp)
+		r.TDS = r.EC * t.factor
+		r.OutOfRange = r.EC > t.MaxEC
+	}
+	return r, nil
+}
+
+// ReadPub reads the probe and publishes the reading, and a
+// ProbeEvent when the probe came out of the water or went back in
+func (t *TDS) ReadPub() error {
+	r, err := t.Read()
+	if err != nil {
+		return err
+	}
+	if err := t.publish(r); err != nil {
+		return err
+	}
+	if evt := t.check(r); evt != nil {
+		return t.publish(evt)
+	}
+	return nil
+}
+
+// check returns the ProbeEvent for the reading r, nil if the probe
+// did not come out or go in
+func (t *TDS) check(r *Reading) *ProbeEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r.Air == t.air {
+		return nil
+	}
+	t.air = r.Air
+	if r.Air {
+		slog.Warn("tds probe out of the water", "device", t.Device.Name, "volts", r.Volts)
+		return &ProbeEvent{Event: "air"}
+	}
+	return &ProbeEvent{Event: "immersed"}
+}
+
+func (t *TDS) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (t *TDS) Run(ctx context.Context, period time.Duration) error {
+	err := t.TimerLoop(ctx, period, t.ReadPub)
+	slog.Debug("tds stopped", "device", t.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the reader, the ADS1115 channel by default
+func (t *TDS) Close() error {
+	if c, ok := t.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package tds
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/ds18b20"
+)
+
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+func TestEC(t *testing.T) {
+	// the cubic worked out by hand
+	tests := []struct {
+		volts, ec float64
+	}{
+		{0, 0},
+		{0.1, 83.3138},
+		{0.5, 381.4075},
+		{1.0, 734.95},
+		{1.5, 1160.6925},
+		{2.0, 1758.7},
+		{2.3, 2241.8187},
+	}
+	for _, tt := range tests {
+		if got := ec(tt.volts); !near(got, tt.ec, 1e-4) {
+			t.Errorf("ec(%g) got (%g) want (%g)", tt.volts, got, tt.ec)
+		}
+		if got := DefaultCalibration.EC(tt.volts, 25); !near(got, tt.ec, 1e-4) {
+			t.Errorf("EC(%g, 25°C) got (%g) want (%g)", tt.volts, got, tt.ec)
+		}
+	}
+}
+
+func TestCompensation(t *testing.T) {
+	// a 734.95µS/cm solution reads 1V at 25°C, 2% more a °C warmer,
+	// and the EC it reads without compensation
+	tests := []struct {
+		temp, volts, raw float64
+	}{
+		{10, 0.70, 520.5647},
+		{20, 0.90, 661.6676},
+		{25, 1.00, 734.95},
+		{30, 1.10, 811.1204},
+		{40, 1.30, 975.3273},
+	}
+	for _, tt := range tests {
+		if got := DefaultCalibration.EC(tt.volts, tt.temp); !near(got, 734.95, 1e-9) {
+			t.Errorf("EC(%gV, %g°C) got (%g) want (734.95)", tt.volts, tt.temp, got)
+		}
+		if got := DefaultCalibration.EC(tt.volts, 25); !near(got, tt.raw, 1e-4) {
+			t.Errorf("EC(%gV) uncompensated got (%g) want (%g)", tt.volts, got, tt.raw)
+		}
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	c, err := Calibrate(1.8, 25, 1413)
+	if err != nil {
+		t.Fatalf("Calibrate() error = %v", err)
+	}
+	if !near(c.K, 1413/ec(1.8), 1e-12) || !near(c.EC(1.8, 25), 1413, 1e-9) {
+		t.Errorf("Calibrate(1.8V, 1413) got (%+v) want k (%g)", c, 1413/ec(1.8))
+	}
+	// calibrated warm, the reading of the solution at 25°C is the
+	// reference
+	c, err = Calibrate(1.8*1.1, 30, 1413)
+	if err != nil || !near(c.EC(1.8, 25), 1413, 1e-9) {
+		t.Errorf("Calibrate(30°C) got (%+v, %v) want 1413 at 1.8V 25°C", c, err)
+	}
+
+	for _, v := range []float64{1.0, 0, 3} {
+		if _, err := Calibrate(v, 25, 1413); !errors.Is(err, drivers.ErrCalibration) {
+			t.Errorf("Calibrate(%gV, 1413) error got (%v) want (%v)", v, err, drivers.ErrCalibration)
+		}
+	}
+	if _, err := Calibrate(1.8, 25, -1); !errors.Is(err, drivers.ErrCalibration) {
+		t.Errorf("Calibrate(-1) error got (%v) want (%v)", err, drivers.ErrCalibration)
+	}
+}
+
+// sensor is a temperature device
+type sensor struct {
+	temp float64
+	err  error
+}
+
+func (s *sensor) Name() string {
+	return "tank-temp"
+}
+
+func (s *sensor) Temperature() (float64, error) {
+	return s.temp, s.err
+}
+
+func TestTDS(t *testing.T) {
+	devicetest.UseStore(t)
+	pin := drivers.NewMockAnalogPin("tank", 1)
+	p := NewWithReader("tank", pin)
+	p.Samples = 5
+
+	if err := p.Link("tank-temp"); !errors.Is(err, ErrSensor) {
+		t.Errorf("Link(missing) error got (%v) want (%v)", err, ErrSensor)
+	}
+	s := &sensor{temp: 30}
+	dm := device.GetDeviceManager()
+	dm.Add(s)
+	defer dm.Remove("tank-temp")
+	if err := p.Link("tank-temp"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+
+	// the median leaves out the spikes
+	pin.MockValues(1.98, 3.0, 1.98, 0, 1.98)
+	if err := p.Command([]byte(" Calibrate 1413\n")); err != nil {
+		t.Fatalf("calibrate 1413 error = %v", err)
+	}
+	if c := p.Calibration(); !near(c.K, 1413/ec(1.8), 1e-9) || c.Reference != 1413 || c.Temp != 30 {
+		t.Errorf("Calibration() got (%+v) want k (%g)", c, 1413/ec(1.8))
+	}
+
+	pin.MockValues(1.1)
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	want := 1413 / ec(1.8) * 734.95
+	if !near(r.EC, want, 1e-6) || !near(r.TDS, want/2, 1e-6) || r.Temperature != 30 || !r.Compensated || r.Air || r.OutOfRange {
+		t.Errorf("Read() got (%+v) want %gµS/cm %gppm at 30°C", r, want, want/2)
+	}
+
+	if err := p.Command([]byte("factor 0.7")); err != nil {
+		t.Fatalf("factor 0.7 error = %v", err)
+	}
+	if r, _ = p.Read(); !near(r.TDS, want*FactorNatural, 1e-6) {
+		t.Errorf("Read() TDS at 0.7 got (%g) want (%g)", r.TDS, want*FactorNatural)
+	}
+	for _, cmd := range []string{"factor 2", "factor x", "calibrate x", "calibrate", "measure 1413"} {
+		err := p.Command([]byte(cmd))
+		if !errors.Is(err, ErrCommand) && !errors.Is(err, ErrFactor) {
+			t.Errorf("Command(%s) error got (%v) want an error", cmd, err)
+		}
+	}
+
+	// a failing device leaves the last temperature
+	s.err = errors.New("bus error")
+	if r, _ = p.Read(); !near(r.EC, want, 1e-6) || r.Temperature != 30 || r.Compensated {
+		t.Errorf("Read() with the device failing got (%+v) want %gµS/cm at 30°C", r, want)
+	}
+
+	pin.MockValues(2.6)
+	if r, _ = p.Read(); !r.OutOfRange {
+		t.Errorf("Read() at 2.6V got (%+v) want out of range", r)
+	}
+
+	// out of the water and back
+	pin.MockValues(0.004)
+	r, _ = p.Read()
+	if !r.Air || r.EC != 0 || r.TDS != 0 {
+		t.Errorf("Read() in the air got (%+v) want air", r)
+	}
+	if evt := p.check(r); evt == nil || evt.Event != "air" {
+		t.Errorf("check() in the air got (%+v) want (air)", evt)
+	}
+	if evt := p.check(r); evt != nil {
+		t.Errorf("check() in the air again got (%+v) want none", evt)
+	}
+	pin.MockValues(1.1)
+	r, _ = p.Read()
+	if evt := p.check(r); evt == nil || evt.Event != "immersed" {
+		t.Errorf("check() back in got (%+v) want (immersed)", evt)
+	}
+
+	// a restarted device loads the saved calibration
+	again := NewWithReader("tank", pin)
+	if c := again.Calibration(); c != p.Calibration() {
+		t.Errorf("Calibration() after a restart got (%+v) want (%+v)", c, p.Calibration())
+	}
+	if err := p.Command([]byte("calibrate reset")); err != nil || p.Calibration() != DefaultCalibration {
+		t.Errorf("calibrate reset got (%+v, %v) want the default", p.Calibration(), err)
+	}
+}
+
+// TestLinkDS18B20 compensates with the probe in the tank
+func TestLinkDS18B20(t *testing.T) {
+	devicetest.UseStore(t)
+	device.Mock(true)
+	defer device.Mock(false)
+
+	tank, err := ds18b20.New("tank-probe", "")
+	if err != nil {
+		t.Fatalf("ds18b20.New() error = %v", err)
+	}
+	dm := device.GetDeviceManager()
+	dm.Add(tank)
+	defer dm.Remove("tank-probe")
+
+	pin := drivers.NewMockAnalogPin("tank", 1)
+	p := NewWithReader("tank", pin)
+	if err := p.Link("tank-probe"); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	pin.MockValues(1.1)
+	r, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !r.Compensated || r.Temperature < 17 || r.Temperature > 22 {
+		t.Errorf("Read() got (%+v) want compensated near 19.5°C", r)
+	}
+	if uncompensated := ec(1.1); r.EC <= uncompensated {
+		t.Errorf("Read() EC got (%g) want above (%g), raised to 25°C", r.EC, uncompensated)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	devicetest.UseStore(t)
+	clock := devicetest.UseClock(t, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+
+	p, _ := New("tds-profile", 1)
+	if err := p.MockProfile("tap"); err != nil {
+		t.Fatalf("MockProfile(tap) error = %v", err)
+	}
+	for h := range 24 {
+		if r, err := p.Read(); err != nil || r.Air || r.TDS < 150 || r.TDS > 230 {
+			t.Errorf("Read() tap at %02d:00 got (%+v, %v) want about 190ppm", h, r, err)
+		}
+		clock.Advance(time.Hour)
+	}
+
+	if err := p.MockProfile("hydroponic"); err != nil {
+		t.Fatalf("MockProfile(hydroponic) error = %v", err)
+	}
+	last := math.Inf(1)
+	for day := range 7 {
+		r, err := p.Read()
+		if err != nil || r.TDS >= last || r.TDS < 700 || r.TDS > 1150 {
+			t.Errorf("Read() hydroponic day %d got (%+v, %v) want below (%.0f)", day, r, err, last)
+		}
+		last = r.TDS
+		clock.Advance(24 * time.Hour)
+	}
+}
@@ -0,0 +1,150 @@
+package thermistor
+
+import (
+	"fmt"
+	"math"
+)
+
+const kelvin = 273.15
+
+// Leg is where the thermistor sits in the divider
+type Leg int
+
+const (
+	// LowSide is a thermistor between the output and ground, the
+	// series resistor goes to the supply
+	LowSide Leg = iota
+	// HighSide is a thermistor between the supply and the output,
+	// the series resistor goes to ground
+	HighSide
+)
+
+// Divider is the voltage divider the thermistor is read through
+type Divider struct {
+	Leg    Leg     `json:"leg"`
+	Series float64 `json:"series"` // Ω
+	Supply float64 `json:"supply"` // V across the divider
+}
+
+// DefaultDivider is a thermistor to ground under a 10kΩ resistor
+// from 3.3V
+var DefaultDivider = Divider{Leg: LowSide, Series: 10000, Supply: 3.3}
+
+// Valid returns ErrConfig for a divider that can not be solved
+func (d Divider) Valid() error {
+	if d.Series <= 0 || d.Supply <= 0 {
+		return fmt.Errorf("%w: %gΩ series from %gV", ErrConfig, d.Series, d.Supply)
+	}
+	return nil
+}
+
+// Resistance returns the resistance of the thermistor with v volts at
+// the output. A reading at either rail is an open (+Inf) or a shorted
+// (0) thermistor, depending on the leg.
+func (d Divider) Resistance(v float64) float64 {
+	if d.Leg == HighSide {
+		v = d.Supply - v
+	}
+	switch {
+	case v <= 0:
+		return 0
+	case v >= d.Supply:
+		return math.Inf(1)
+	}
+	return d.Series * v / (d.Supply - v)
+}
+
+// Volts returns the output of the divider with a thermistor of r ohms
+func (d Divider) Volts(r float64) float64 {
+	v := d.Supply * r / (r + d.Series)
+	if d.Leg == HighSide {
+		return d.Supply - v
+	}
+	return v
+}
+
+// Curve converts between the resistance of an NTC thermistor and its
+// temperature in °C
+type Curve interface {
+	Temperature(ohms float64) float64
+	Resistance(celsius float64) float64
+}
+
+// Beta is the curve from the datasheet of most thermistors, the
+// resistance R0 at T0 °C and the B constant
+type Beta struct {
+	R0 float64 `json:"r0"`
+	T0 float64 `json:"t0"`
+	B  float64 `json:"b"`
+}
+
+// B3950 is the 10kΩ thermistor of most cheap probes
+var B3950 = Beta{R0: 10000, T0: 25, B: 3950}
+
+// Temperature returns the temperature at r ohms, 1/T = 1/T0 + ln(R/R0)/B
+func (b Beta) Temperature(r float64) float64 {
+	return 1/(1/(b.T0+kelvin)+math.Log(r/b.R0)/b.B) - kelvin
+}
+
+// Resistance returns the resistance at t °C
+func (b Beta) Resistance(t float64) float64 {
+	return b.R0 * math.Exp(b.B*(1/(t+kelvin)-1/(b.T0+kelvin)))
+}
+
+// SteinhartHart are the coefficients of 1/T = A + B ln(R) + C ln(R)³,
+// T in kelvin. They follow the thermistor closer than the Beta over a
+// wide range.
+type SteinhartHart struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+	C float64 `json:"c"`
+}
+
+// Temperature returns the temperature at r ohms
+func (s SteinhartHart) Temperature(r float64) float64 {
+	l := math.Log(r)
+	return 1/(s.A+s.B*l+s.C*l*l*l) - kelvin
+}
+
+// Resistance returns the resistance at t °C, the real root of the
+// cubic in ln(R)
+func (s SteinhartHart) Resistance(t float64) float64 {
+	y := 1 / (t + kelvin)
+	if s.C == 0 {
+		return math.Exp((y - s.A) / s.B)
+	}
+	x := (s.A - y) / s.C
+	q := math.Sqrt(math.Pow(s.B/(3*s.C), 3) + x*x/4)
+	return math.Exp(math.Cbrt(q-x/2) - math.Cbrt(q+x/2))
+}
+
+// Point is a calibration point, the resistance measured at a
+// temperature
+type Point struct {
+	Temp float64 `json:"temp"` // °C
+	Ohms float64 `json:"ohms"`
+}
+
+// Fit solves the Steinhart-Hart coefficients through three
+// calibration points, best taken at the bottom, the middle and the
+// top of the range the thermistor is used in
+func Fit(p1, p2, p3 Point) (SteinhartHart, error) {
+	var l, y [3]float64
+	for i, p := range []Point{p1, p2, p3} {
+		if p.Ohms <= 0 {
+			return SteinhartHart{}, fmt.Errorf("%w: %gΩ at %g°C", ErrConfig, p.Ohms, p.Temp)
+		}
+		l[i], y[i] = math.Log(p.Ohms), 1/(p.Temp+kelvin)
+	}
+	g2 := (y[1] - y[0]) / (l[1] - l[0])
+	g3 := (y[2] - y[0]) / (l[2] - l[0])
+	c := (g3 - g2) / (l[2] - l[1]) / (l[0] + l[1] + l[2])
+	b := g2 - c*(l[0]*l[0]+l[0]*l[1]+l[1]*l[1])
+	s := SteinhartHart{A: y[0] - (b+c*l[0]*l[0])*l[0], B: b, C: c}
+	for _, v := range []float64{s.A, s.B, s.C} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return SteinhartHart{}, fmt.Errorf("%w: the points do not define a curve", ErrConfig)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,226 @@
+// Package thermistor reads an NTC thermistor in a voltage divider
+// over a drivers.AnalogReader, one channel of an ADS1115 by default.
+//
+// The voltage at the output of the Divider gives the resistance of
+// the thermistor and the Curve its temperature, the Beta of the
+// datasheet or the Steinhart-Hart coefficients fitted to calibration
+// points. A resistance no thermistor has between MinTemp and MaxTemp
+// is a fault: an open probe, its wire cut or unplugged, reads far
+// too high and a shorted one far too low.
+package thermistor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the range of most epoxy and stainless steel probes
+const (
+	DefaultMinTemp = -55.0
+	DefaultMaxTemp = 150.0
+)
+
+var (
+	ErrOpen   = errors.New("thermistor open")
+	ErrShort  = errors.New("thermistor shorted")
+	ErrConfig = errors.New("invalid thermistor configuration")
+)
+
+// Env is what ReadPub publishes, the temperature field matches the
+// bme280 Env. The resistance is there to check the divider and the
+// curve with.
+type Env struct {
+	Temperature string `json:"temperature"`
+	Resistance  string `json:"resistance"`
+}
+
+// Reading is a single measurement, an open or shorted probe has no
+// Temperature
+type Reading struct {
+	Temperature float64 // °C
+	Resistance  float64 // Ω
+	Volts       float64
+}
+
+// FaultEvent is published when the probe goes open or short, "fault"
+// with the fault and the resistance unless it is infinite, and when
+// it reads again, "fault_cleared"
+type FaultEvent struct {
+	Event      string  `json:"event"`
+	Fault      string  `json:"fault,omitempty"`
+	Resistance float64 `json:"resistance,omitempty"`
+}
+
+// Thermistor is an NTC thermistor read through a voltage divider
+type Thermistor struct {
+	*device.Device
+	drivers.AnalogReader
+
+	Divider Divider
+	Curve   Curve
+
+	// Samples are averaged for each reading
+	Samples int
+
+	// MinTemp and MaxTemp in °C bound the resistance of a working
+	// probe
+	MinTemp float64
+	MaxTemp float64
+
+	// Units are the units ReadPub publishes, "F" like the bme280
+	// or "C"
+	Units string
+
+	fault string
+	mu    sync.Mutex
+}
+
+// New creates a thermistor on channel ch of the default ADS1115
+func New(name string, ch int) (*Thermistor, error) {
+	if device.IsMock() {
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, ch)), nil
+	}
+	p, err := drivers.GetADS1115().Pin(name, ch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithReader(name, p), nil
+}
+
+// NewWithReader creates a B3950 thermistor in the DefaultDivider
+// reading r
+func NewWithReader(name string, r drivers.AnalogReader) *Thermistor {
+	return &Thermistor{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+		Divider:      DefaultDivider,
+		Curve:        B3950,
+		Samples:      1,
+		MinTemp:      DefaultMinTemp,
+		MaxTemp:      DefaultMaxTemp,
+		Units:        "F",
+	}
+}
+
+// Name returns the name of the device
+func (t *Thermistor) Name() string {
+	return t.Device.Name
+}
+
+// Read averages Samples voltages and converts them. An open or
+// shorted probe returns ErrOpen or ErrShort along with the reading.
+// If the device is mocked it makes up a reading.
+func (t *Thermistor) Read() (*Reading, error) {
+	if err := t.Divider.Valid(); err != nil {
+		return nil, err
+	}
+	if device.IsMock() {
+		c := 15 + t.MockRand().Float64()*10
+		r := t.Curve.Resistance(c)
+		return &Reading{Temperature: c, Resistance: r, Volts: t.Divider.Volts(r)}, nil
+	}
+
+	n := max(t.Samples, 1)
+	var sum float64
+	for i := 0; i < n; i++ {
+		v, err := t.ReadVolts()
+		if err != nil {
+			return nil, err
+		}
+		sum += v
+	}
+	v := sum / float64(n)
+	r := &Reading{Resistance: t.Divider.Resistance(v), Volts: v}
+
+	// an NTC thermistor's resistance falls as it warms
+	switch {
+	case r.Resistance > t.Curve.Resistance(t.MinTemp):
+		return r, fmt.Errorf("%w: %.0fΩ at %.3fV", ErrOpen, r.Resistance, v)
+	case r.Resistance < t.Curve.Resistance(t.MaxTemp):
+		return r, fmt.Errorf("%w: %.0fΩ at %.3fV", ErrShort, r.Resistance, v)
+	}
+	r.Temperature = t.Curve.Temperature(r.Resistance)
+	return r, nil
+}
+
+// Temperature returns the temperature in °C, so pH and TDS probes can
+// be compensated with the thermistor
+func (t *Thermistor) Temperature() (float64, error) {
+	r, err := t.Read()
+	if err != nil {
+		return 0, err
+	}
+	return r.Temperature, nil
+}
+
+// ReadPub publishes the temperature in Units and the resistance, and
+// a FaultEvent when the probe goes open or short and when it reads
+// again. Nothing else is published while the probe is faulted.
+func (t *Thermistor) ReadPub() error {
+	r, err := t.Read()
+	var fault string
+	switch {
+	case errors.Is(err, ErrOpen):
+		fault = "open"
+	case errors.Is(err, ErrShort):
+		fault = "short"
+	case err != nil:
+		return err
+	}
+
+	t.mu.Lock()
+	changed := fault != t.fault
+	t.fault = fault
+	t.mu.Unlock()
+
+	if changed {
+		evt := &FaultEvent{Event: "fault_cleared"}
+		if fault != "" {
+			slog.Warn("thermistor fault", "device", t.Device.Name, "error", err)
+			evt = &FaultEvent{Event: "fault", Fault: fault}
+			if !math.IsInf(r.Resistance, 0) {
+				evt.Resistance = r.Resistance
+			}
+		}
+		if err := t.publish(evt); err != nil {
+			return err
+		}
+	}
+	if fault != "" {
+		return nil
+	}
+
+	temp := r.Temperature
+	if t.Units != "C" {
+		temp = temp*9/5 + 32
+	}
+	return t.publish(&Env{
+		Temperature: fmt.Sprintf("%.2f", temp),
+		Resistance:  fmt.Sprintf("%.0f", r.Resistance),
+	})
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (t *Thermistor) Run(ctx context.Context, period time.Duration) error {
+	err := t.TimerLoop(ctx, period, t.ReadPub)
+	slog.Debug("thermistor stopped", "device", t.Device.Name, "error", err)
+	return err
+}
+
+func (t *Thermistor) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.PubData(j)
+	return nil
+}
@@ -0,0 +1,189 @@
+package thermistor
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the resistance table of a 10kΩ 3950 probe, and the Steinhart-Hart
+// coefficients usually given for it
+var (
+	table = []Point{{0, 32650}, {25, 10000}, {50, 3602}}
+	sh10k = SteinhartHart{A: 1.129148e-3, B: 2.34125e-4, C: 8.76741e-8}
+)
+
+func near(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+func TestDivider(t *testing.T) {
+	low := Divider{Leg: LowSide, Series: 10000, Supply: 3.3}
+	high := Divider{Leg: HighSide, Series: 10000, Supply: 3.3}
+	tests := []struct {
+		d     Divider
+		volts float64
+		ohms  float64
+	}{
+		{low, 1.65, 10000},
+		{low, 3.3 * 32650 / 42650, 32650},
+		{low, 0, 0},
+		{low, -0.01, 0},
+		{low, 3.3, math.Inf(1)},
+		{high, 1.65, 10000},
+		{high, 3.3 * 10000 / 42650, 32650},
+		{high, 0, math.Inf(1)},
+		{high, 3.3, 0},
+		{high, 3.31, 0},
+	}
+	for _, tt := range tests {
+		got := tt.d.Resistance(tt.volts)
+		if !near(got, tt.ohms, 1e-6) && got != tt.ohms {
+			t.Errorf("%+v Resistance(%.4f) got (%.3f) want (%.3f)", tt.d, tt.volts, got, tt.ohms)
+		}
+		if math.IsInf(tt.ohms, 0) || tt.ohms == 0 {
+			continue
+		}
+		if v := tt.d.Volts(tt.ohms); !near(v, tt.volts, 1e-9) {
+			t.Errorf("%+v Volts(%.0f) got (%.4f) want (%.4f)", tt.d, tt.ohms, v, tt.volts)
+		}
+	}
+	if err := (Divider{Series: 10000}).Valid(); !errors.Is(err, ErrConfig) {
+		t.Errorf("Valid() without a supply error got (%v) want (%v)", err, ErrConfig)
+	}
+}
+
+func TestBeta(t *testing.T) {
+	tests := []struct {
+		ohms float64
+		temp float64
+	}{
+		{10000, 25},
+		{33620.60, 0},
+		{3588.18, 50},
+		{1288233.8, -55},
+		{199.682, 150},
+	}
+	for _, tt := range tests {
+		if got := B3950.Temperature(tt.ohms); !near(got, tt.temp, 0.001) {
+			t.Errorf("Temperature(%.2f) got (%.3f) want (%.3f)", tt.ohms, got, tt.temp)
+		}
+		if got := B3950.Resistance(tt.temp); !near(got, tt.ohms, tt.ohms*1e-5) {
+			t.Errorf("Resistance(%.0f) got (%.2f) want (%.2f)", tt.temp, got, tt.ohms)
+		}
+	}
+}
+
+func TestSteinhartHart(t *testing.T) {
+	for _, p := range table {
+		if got := sh10k.Temperature(p.Ohms); !near(got, p.Temp, 0.01) {
+			t.Errorf("Temperature(%.0f) got (%.3f) want (%.2f)", p.Ohms, got, p.Temp)
+		}
+	}
+	for _, c := range []float64{-40, -10, 0, 37.5, 85, 125} {
+		if got := sh10k.Temperature(sh10k.Resistance(c)); !near(got, c, 1e-9) {
+			t.Errorf("Temperature(Resistance(%g)) got (%g)", c, got)
+		}
+	}
+	// without the C term it is a Beta
+	b := SteinhartHart{A: 1 / (25 + kelvin), B: 1 / 3950.0}
+	b.A -= b.B * math.Log(10000)
+	if got := b.Resistance(50); !near(got, B3950.Resistance(50), 1e-6) {
+		t.Errorf("Resistance(50) without C got (%.3f) want (%.3f)", got, B3950.Resistance(50))
+	}
+}
+
+func TestFit(t *testing.T) {
+	s, err := Fit(table[0], table[1], table[2])
+	if err != nil {
+		t.Fatalf("Fit() error = %v", err)
+	}
+	if !near(s.A, 1.127355e-3, 1e-9) || !near(s.B, 2.343978e-4, 1e-10) || !near(s.C, 8.674848e-8, 1e-13) {
+		t.Errorf("Fit() got (%+v)", s)
+	}
+	for _, p := range table {
+		if got := s.Temperature(p.Ohms); !near(got, p.Temp, 1e-6) {
+			t.Errorf("Temperature(%.0f) got (%.6f) want (%.0f)", p.Ohms, got, p.Temp)
+		}
+		if got := s.Resistance(p.Temp); !near(got, p.Ohms, 1e-4) {
+			t.Errorf("Resistance(%.0f) got (%.4f) want (%.0f)", p.Temp, got, p.Ohms)
+		}
+	}
+	// between the points it follows the published coefficients
+	if a, b := s.Temperature(6530), sh10k.Temperature(6530); !near(a, b, 0.05) {
+		t.Errorf("Temperature(6530) got (%.3f) want (%.3f)", a, b)
+	}
+
+	bad := [][3]Point{
+		{table[0], table[0], table[2]},
+		{table[0], {25, 0}, table[2]},
+	}
+	for _, pts := range bad {
+		if _, err := Fit(pts[0], pts[1], pts[2]); !errors.Is(err, ErrConfig) {
+			t.Errorf("Fit(%v) error got (%v) want (%v)", pts, err, ErrConfig)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	device.Mock(false)
+	pin := drivers.NewMockAnalogPin("tank", 0)
+	th := NewWithReader("tank", pin)
+	th.Samples = 4
+
+	// noise around 25°C averaged out
+	pin.MockValues(1.64, 1.66, 1.63, 1.67)
+	r, err := th.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !near(r.Resistance, 10000, 1e-6) || !near(r.Temperature, 25, 1e-6) {
+		t.Errorf("Read() got (%+v) want 10kΩ at 25°C", r)
+	}
+
+	th.Curve = sh10k
+	th.Samples = 1
+	pin.MockValues(th.Divider.Volts(3602))
+	if c, err := th.Temperature(); err != nil || !near(c, 50, 0.01) {
+		t.Errorf("Temperature() got (%.3f, %v) want (50)", c, err)
+	}
+
+	tests := []struct {
+		name  string
+		volts float64
+		err   error
+	}{
+		{"unplugged", 3.3, ErrOpen},
+		{"cut", 3.29, ErrOpen},
+		{"shorted", 0, ErrShort},
+		{"shorted leads", 0.05, ErrShort},
+		{"freezer", th.Divider.Volts(sh10k.Resistance(-20)), nil},
+		{"oven", th.Divider.Volts(sh10k.Resistance(140)), nil},
+	}
+	for _, tt := range tests {
+		pin.MockValues(tt.volts)
+		if _, err := th.Read(); !errors.Is(err, tt.err) {
+			t.Errorf("%s Read() error got (%v) want (%v)", tt.name, err, tt.err)
+		}
+	}
+
+	// the fault is kept until the probe reads again
+	pin.MockValues(3.3)
+	for range 2 {
+		if err := th.ReadPub(); err != nil || th.fault != "open" {
+			t.Errorf("ReadPub() open got (%v, %q) want (nil, open)", err, th.fault)
+		}
+	}
+	pin.MockValues(1.65)
+	if err := th.ReadPub(); err != nil || th.fault != "" {
+		t.Errorf("ReadPub() cleared got (%v, %q) want (nil)", err, th.fault)
+	}
+
+	th.Divider.Supply = 0
+	if _, err := th.Read(); !errors.Is(err, ErrConfig) {
+		t.Errorf("Read() without a supply error got (%v) want (%v)", err, ErrConfig)
+	}
+}
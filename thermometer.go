@@ -0,0 +1,48 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSource is a device named as the source of a reading, like the
+// thermometer a fan follows, that is not in the DeviceManager or does
+// not give the reading
+var ErrSource = errors.New("no source device")
+
+// Thermometer is a device giving the temperature in °C. A fan follows
+// one and the pH, TDS, gas and distance sensors are compensated by
+// one, linked by its name in the DeviceManager.
+type Thermometer interface {
+	Temperature() (float64, error)
+}
+
+// Hygrometer is a device giving the relative humidity in %
+type Hygrometer interface {
+	Humidity() (float64, error)
+}
+
+// GetThermometer returns the Thermometer added to the DeviceManager as
+// name, an ErrSource when there is none
+func GetThermometer(name string) (Thermometer, error) {
+	return source[Thermometer](name, "temperature")
+}
+
+// GetHygrometer returns the Hygrometer added to the DeviceManager as
+// name, an ErrSource when there is none
+func GetHygrometer(name string) (Hygrometer, error) {
+	return source[Hygrometer](name, "humidity")
+}
+
+func source[T any](name, reading string) (T, error) {
+	var none T
+	d, ok := GetDeviceManager().Get(name)
+	if !ok {
+		return none, fmt.Errorf("%w: %s", ErrSource, name)
+	}
+	t, ok := d.(T)
+	if !ok {
+		return none, fmt.Errorf("%w: %s has no %s", ErrSource, name, reading)
+	}
+	return t, nil
+}
@@ -0,0 +1,46 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+// climate is a thermometer and hygrometer
+type climate struct {
+	mockDevice
+}
+
+func (climate) Temperature() (float64, error) { return 21.5, nil }
+func (climate) Humidity() (float64, error)    { return 45, nil }
+
+func TestGetThermometer(t *testing.T) {
+	dm := GetDeviceManager()
+	dm.Clear()
+	defer dm.Clear()
+	dm.Add(&climate{mockDevice{name: "porch"}})
+	dm.Add(&mockDevice{name: "plain"})
+
+	th, err := GetThermometer("porch")
+	if err != nil {
+		t.Fatalf("GetThermometer() error = %v", err)
+	}
+	if c, err := th.Temperature(); err != nil || c != 21.5 {
+		t.Errorf("Temperature() got (%v, %v) want (21.5)", c, err)
+	}
+	h, err := GetHygrometer("porch")
+	if err != nil {
+		t.Fatalf("GetHygrometer() error = %v", err)
+	}
+	if rh, err := h.Humidity(); err != nil || rh != 45 {
+		t.Errorf("Humidity() got (%v, %v) want (45)", rh, err)
+	}
+
+	for _, name := range []string{"missing", "plain"} {
+		if _, err := GetThermometer(name); !errors.Is(err, ErrSource) {
+			t.Errorf("GetThermometer(%s) error got (%v) want (%v)", name, err, ErrSource)
+		}
+		if _, err := GetHygrometer(name); !errors.Is(err, ErrSource) {
+			t.Errorf("GetHygrometer(%s) error got (%v) want (%v)", name, err, ErrSource)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Package tilt counts the pulses of a ball tilt switch or an SW-420
+// vibration switch on a GPIO input to tell if a machine, like a
+// compressor, is running.
+//
+// Every rising edge is an activation, edges closer than the Debounce
+// to the one before are contact bounce and dropped. The activations
+// are counted in a rolling Window, when the count reaches the
+// Threshold the machine is running and when it stays below it for the
+// Quiet period the machine has stopped. The transitions are published
+// as they happen by a second device named <name>/state, ReadPub
+// publishes the vibration intensity of each period.
+package tilt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultWindow is the rolling window the activations are
+	// counted in
+	DefaultWindow = 10 * time.Second
+
+	// DefaultThreshold is the activations in the window of a
+	// running machine, lower it to make the detection more sensitive
+	DefaultThreshold = 20
+
+	// DefaultQuiet is how long the activity stays below the
+	// threshold before the machine is stopped
+	DefaultQuiet = 30 * time.Second
+
+	// DefaultDebounce drops the bounce of the switch contact
+	DefaultDebounce = 2 * time.Millisecond
+
+	// EventBufferSize holds the edges of a hard shake until the
+	// handler catches up
+	EventBufferSize = 1024
+)
+
+var (
+	ErrThreshold = errors.New("threshold must be at least 1")
+	ErrDuration  = errors.New("invalid duration")
+	ErrCommand   = errors.New("unknown command")
+)
+
+// State is what the machine is doing
+type State string
+
+const (
+	Stopped State = "stopped"
+	Running State = "running"
+)
+
+// StateEvent is published on the state device when the machine
+// starts or stops, with the activations in the window
+type StateEvent struct {
+	State    State     `json:"state"`
+	Activity int       `json:"activity"`
+	Time     time.Time `json:"time"`
+}
+
+// Reading is what ReadPub publishes: the activations since the last
+// reading and their rate, the activations in the window, and the
+// totals since Open
+type Reading struct {
+	State     State     `json:"state"`
+	Since     time.Time `json:"since"`
+	Pulses    int       `json:"pulses"`
+	Intensity float64   `json:"intensity"` // activations a second
+	Activity  int       `json:"activity"`
+	Total     int       `json:"total"`
+	Bounced   int       `json:"bounced"`
+}
+
+// Tilt is a tilt or vibration switch on a GPIO line
+type Tilt struct {
+	*device.Device
+
+	// StateDevice publishes the running and stopped events
+	StateDevice *device.Device
+
+	// Window and Debounce are read by Open, Threshold and Quiet can
+	// be changed by SetThreshold and SetQuiet while open
+	Window    time.Duration
+	Threshold int
+	Quiet     time.Duration
+	Debounce  time.Duration
+
+	offset int
+	opts   []gpiocdev.LineReqOption
+	pin    *drivers.DigitalPin
+	win    *window
+	timer  *device.Timer
+
+	running bool
+	since   time.Time // of the last transition
+	active  time.Time // last pulse with the activity at the threshold
+	last    time.Time // last pulse counted
+	pulses  int       // since the last reading
+	read    time.Time // of the last reading
+	total   int
+	bounced int
+
+	mu sync.Mutex
+}
+
+// New creates a tilt switch on the line at offset of the default
+// chip, opts are added to the line request, like a pull up for a
+// switch to ground. The line is requested by Open.
+func New(name string, offset int, opts ...gpiocdev.LineReqOption) *Tilt {
+	return &Tilt{
+		Device:      device.NewDevice(name, "mqtt"),
+		StateDevice: device.NewDevice(name+"/state", "mqtt"),
+		Window:      DefaultWindow,
+		Threshold:   DefaultThreshold,
+		Quiet:       DefaultQuiet,
+		Debounce:    DefaultDebounce,
+		win:         newWindow(DefaultWindow),
+		offset:      offset,
+		opts:        opts,
+	}
+}
+
+// Name returns the name of the device
+func (t *Tilt) Name() string {
+	return t.Device.Name
+}
+
+// Open requests the line and starts counting, the machine is taken
+// as stopped
+func (t *Tilt) Open() error {
+	t.mu.Lock()
+	now := t.Now()
+	t.win = newWindow(t.Window)
+	t.running, t.since, t.read = false, now, now
+	t.active, t.last = time.Time{}, time.Time{}
+	t.pulses, t.total, t.bounced = 0, 0, 0
+	t.mu.Unlock()
+
+	if device.IsMock() {
+		return nil
+	}
+	opts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("tilt"),
+		gpiocdev.AsInput,
+		gpiocdev.WithRisingEdge,
+		gpiocdev.WithEventBufferSize(EventBufferSize),
+		gpiocdev.WithEventHandler(t.edge),
+	}, t.opts...)
+	pin, err := drivers.GetGPIO().Request(t.Device.Name, t.offset, opts...)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.pin = pin
+	t.mu.Unlock()
+	return nil
+}
+
+// State returns what the machine is doing
+func (t *Tilt) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running {
+		return Running
+	}
+	return Stopped
+}
+
+// SetThreshold sets the activations in the window of a running
+// machine
+func (t *Tilt) SetThreshold(n int) error {
+	if n < 1 {
+		return fmt.Errorf("%w: %d", ErrThreshold, n)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Threshold = n
+	return nil
+}
+
+// SetQuiet sets how long the activity stays below the threshold
+// before the machine is stopped
+func (t *Tilt) SetQuiet(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%w: quiet %v", ErrDuration, d)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Quiet = d
+	return nil
+}
+
+// Read returns the Reading, the pulses and the intensity are those
+// since the last Read
+func (t *Tilt) Read() *Reading {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.Now()
+	r := &Reading{
+		State:    Stopped,
+		Since:    t.since,
+		Pulses:   t.pulses,
+		Activity: t.win.count(now),
+		Total:    t.total,
+		Bounced:  t.bounced,
+	}
+	if t.running {
+		r.State = Running
+	}
+	if d := now.Sub(t.read).Seconds(); d > 0 {
+		r.Intensity = float64(t.pulses) / d
+	}
+	t.pulses, t.read = 0, now
+	return r
+}
+
+// Command handles a command payload: "threshold 12" or "quiet 45s"
+func (t *Tilt) Command(payload []byte) error {
+	c := strings.Fields(strings.ToLower(string(payload)))
+	if len(c) != 2 {
+		return fmt.Errorf("%w: %q", ErrCommand, payload)
+	}
+	switch c[0] {
+	case "threshold":
+		n, err := strconv.Atoi(c[1])
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrThreshold, c[1])
+		}
+		return t.SetThreshold(n)
+	case "quiet":
+		d, err := time.ParseDuration(c[1])
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrDuration, c[1])
+		}
+		return t.SetQuiet(d)
+	}
+	return fmt.Errorf("%w: %q", ErrCommand, payload)
+}
+
+// ReadPub publishes the Reading
+func (t *Tilt) ReadPub() error {
+	if device.IsMock() {
+		t.mu.Lock()
+		n := t.MockRand().Intn(2 * t.Threshold)
+		t.mu.Unlock()
+		t.MockPulses(n, time.Second)
+	}
+	j, err := json.Marshal(t.Read())
+	if err != nil {
+		return err
+	}
+	t.PubData(j)
+	return nil
+}
+
+// Run publishes a Reading every period until ctx is canceled, the
+// state events are published as they happen
+func (t *Tilt) Run(ctx context.Context, period time.Duration) error {
+	err := t.TimerLoop(ctx, period, t.ReadPub)
+	slog.Debug("tilt stopped", "device", t.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the quiet timer and releases the line
+func (t *Tilt) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if t.pin == nil {
+		return nil
+	}
+	err := t.pin.Close()
+	t.pin = nil
+	return err
+}
+
+// MockPulses spreads n activations evenly over the span ending now,
+// in mock mode
+func (t *Tilt) MockPulses(n int, span time.Duration) {
+	end := t.Now()
+	for i := range n {
+		t.pulse(end.Add(-span + time.Duration(i+1)*span/time.Duration(n)))
+	}
+}
+
+func (t *Tilt) edge(evt gpiocdev.LineEvent) {
+	if evt.Type == gpiocdev.LineEventRisingEdge {
+		t.pulse(t.Now())
+	}
+}
+
+// pulse counts an activation at at, and starts the machine when the
+// activity reaches the threshold. The quiet timer is only armed on the
+// start, it rearms itself, so a pulse costs no timer at any rate.
+func (t *Tilt) pulse(at time.Time) {
+	t.mu.Lock()
+	if !t.last.IsZero() && at.Sub(t.last) < t.Debounce {
+		t.bounced++
+		t.mu.Unlock()
+		return
+	}
+	t.last = at
+	t.pulses++
+	t.total++
+	n := t.win.add(at)
+	if n < t.Threshold {
+		t.mu.Unlock()
+		return
+	}
+	t.active = at
+	if t.running {
+		t.mu.Unlock()
+		return
+	}
+	t.running, t.since = true, at
+	t.arm(t.Quiet)
+	t.mu.Unlock()
+
+	t.publish(&StateEvent{State: Running, Activity: n, Time: at})
+}
+
+// arm fires expire after d, with t.mu held
+func (t *Tilt) arm(d time.Duration) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = t.Clock().AfterFunc(d, func() { t.expire(t.Now()) })
+}
+
+// expire stops the machine if the activity has been below the
+// threshold for the quiet period by at, or rearms the timer for when
+// it will have been
+func (t *Tilt) expire(at time.Time) {
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return
+	}
+	if wait := t.active.Add(t.Quiet).Sub(at); wait > 0 {
+		t.arm(wait)
+		t.mu.Unlock()
+		return
+	}
+	t.running, t.since = false, at
+	t.timer = nil
+	n := t.win.count(at)
+	t.mu.Unlock()
+
+	t.publish(&StateEvent{State: Stopped, Activity: n, Time: at})
+}
+
+func (t *Tilt) publish(e *StateEvent) {
+	j, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("tilt event", "device", t.Device.Name, "error", err)
+		return
+	}
+	t.StateDevice.PubData(j)
+}
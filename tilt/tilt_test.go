@@ -0,0 +1,224 @@
+package tilt
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func TestWindow(t *testing.T) {
+	base := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	w := newWindow(10 * time.Second)
+	if w.span() != 10*time.Second {
+		t.Fatalf("span got (%v) want (10s)", w.span())
+	}
+	// a pulse a second for 30 seconds
+	for i := range 30 {
+		w.add(base.Add(time.Duration(i) * time.Second))
+	}
+	tests := []struct {
+		at   time.Duration
+		want int
+	}{
+		{29 * time.Second, 10},
+		{35 * time.Second, 4},
+		{39 * time.Second, 0},
+		{time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := w.count(base.Add(tt.at)); got != tt.want {
+			t.Errorf("count at %v got (%d) want (%d)", tt.at, got, tt.want)
+		}
+	}
+	if got := w.add(base.Add(time.Hour)); got != 1 {
+		t.Errorf("add after an idle hour got (%d) want (1)", got)
+	}
+}
+
+// burst sends n activations every interval from start, each followed
+// by a bounce of the contact, and returns the time of the last
+func burst(tl *Tilt, start time.Time, n int, every time.Duration) time.Time {
+	at := start
+	for i := range n {
+		at = start.Add(time.Duration(i) * every)
+		tl.pulse(at)
+		tl.pulse(at.Add(500 * time.Microsecond))
+	}
+	return at
+}
+
+func TestRunningStopped(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	base := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	c := device.NewSimClock(base)
+	tl := New("compressor", 27)
+	tl.SetClock(c)
+	if err := tl.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer tl.Close()
+
+	// the odd knock does not start the machine
+	burst(tl, base, 5, time.Second)
+	if tl.State() != Stopped {
+		t.Fatalf("5 knocks got (%s) want (%s)", tl.State(), Stopped)
+	}
+
+	// the compressor shakes the switch 50 times a second, it runs
+	// from the 20th activation in the window
+	start := base.Add(time.Minute)
+	c.Set(start)
+	tl.Read()
+	burst(tl, start, DefaultThreshold-1, 20*time.Millisecond)
+	if tl.State() != Stopped {
+		t.Fatalf("%d activations got (%s) want (%s)", DefaultThreshold-1, tl.State(), Stopped)
+	}
+	end := burst(tl, start.Add((DefaultThreshold-1)*20*time.Millisecond), 250-DefaultThreshold+1, 20*time.Millisecond)
+	if tl.State() != Running {
+		t.Fatalf("%d activations got (%s) want (%s)", DefaultThreshold, tl.State(), Running)
+	}
+
+	c.Set(start.Add(5 * time.Second))
+	r := tl.Read()
+	if r.Pulses != 250 || r.Bounced != 255 || r.Total != 255 || r.State != Running {
+		t.Errorf("Read() got (%+v) want 250 pulses, 255 bounced, 255 total", r)
+	}
+	if math.Abs(r.Intensity-50) > 0.01 {
+		t.Errorf("intensity got (%.2f) want (50)", r.Intensity)
+	}
+	if want := start.Add((DefaultThreshold - 1) * 20 * time.Millisecond); !r.Since.Equal(want) {
+		t.Errorf("running since got (%v) want (%v)", r.Since, want)
+	}
+
+	// it stops, a knock now and then is below the threshold and
+	// does not hold it running past the quiet period
+	for _, s := range []time.Duration{12, 17, 22, 27} {
+		tl.pulse(end.Add(s * time.Second))
+	}
+	c.Set(end.Add(DefaultQuiet - time.Millisecond))
+	if tl.State() != Running {
+		t.Fatalf("within the quiet period got (%s) want (%s)", tl.State(), Running)
+	}
+	// the quiet timer stops it
+	stop := end.Add(DefaultQuiet)
+	c.Set(stop)
+	if tl.State() != Stopped {
+		t.Fatalf("after the quiet period got (%s) want (%s)", tl.State(), Stopped)
+	}
+	if r := tl.Read(); r.Pulses != 4 || r.Activity != 2 || !r.Since.Equal(stop) {
+		t.Errorf("Read() stopped got (%+v) want 4 pulses, 2 in the window", r)
+	}
+
+	// and starts again
+	burst(tl, stop.Add(time.Minute), DefaultThreshold, 20*time.Millisecond)
+	if tl.State() != Running {
+		t.Errorf("restarted got (%s) want (%s)", tl.State(), Running)
+	}
+}
+
+func TestHighRate(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	base := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	c := device.NewSimClock(base)
+	tl := New("grinder", 27)
+	tl.SetClock(c)
+	tl.Debounce = 0
+	if err := tl.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer tl.Close()
+
+	// a minute at 20kHz
+	const n = 20000 * 60
+	for i := range n {
+		tl.pulse(base.Add(time.Duration(i) * 50 * time.Microsecond))
+	}
+	c.Set(base.Add(time.Minute))
+	r := tl.Read()
+	if r.Total != n || r.State != Running {
+		t.Errorf("Read() got (%+v) want %d running", r, n)
+	}
+	// the window is exact to a bucket
+	if want := 20000 * 10; r.Activity < want-20000/2 || r.Activity > want {
+		t.Errorf("activity got (%d) want about (%d)", r.Activity, want)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tl := New("compressor", 27)
+	tests := []struct {
+		payload string
+		err     error
+	}{
+		{"threshold 12", nil},
+		{"Quiet 45s", nil},
+		{"threshold 0", ErrThreshold},
+		{"threshold x", ErrThreshold},
+		{"quiet -1s", ErrDuration},
+		{"quiet soon", ErrDuration},
+		{"debounce", ErrCommand},
+		{"reset now", ErrCommand},
+	}
+	for _, tt := range tests {
+		if err := tl.Command([]byte(tt.payload)); !errors.Is(err, tt.err) {
+			t.Errorf("Command(%s) error got (%v) want (%v)", tt.payload, err, tt.err)
+		}
+	}
+	if tl.Threshold != 12 || tl.Quiet != 45*time.Second {
+		t.Errorf("threshold and quiet got (%d, %v) want (12, 45s)", tl.Threshold, tl.Quiet)
+	}
+}
+
+func TestLine(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	tl := New("pump", 22)
+	tl.Threshold = 3
+	tl.Quiet = 50 * time.Millisecond
+	tl.Debounce = 0
+	if err := tl.Open(); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer tl.Close()
+
+	line := chip.Line(22)
+	if line == nil {
+		t.Fatal("Open() did not request the line")
+	}
+	for range 3 {
+		line.Edge(1)
+		line.Edge(0)
+	}
+	if tl.State() != Running {
+		t.Fatalf("3 activations got (%s) want (%s)", tl.State(), Running)
+	}
+
+	clock.Advance(tl.Quiet - time.Millisecond)
+	if tl.State() != Running {
+		t.Fatalf("within the quiet period got (%s) want (%s)", tl.State(), Running)
+	}
+	clock.Advance(time.Millisecond)
+	if tl.State() != Stopped {
+		t.Errorf("quiet period passed got (%s) want (%s)", tl.State(), Stopped)
+	}
+	if r := tl.Read(); r.Total != 3 {
+		t.Errorf("total got (%d) want (3)", r.Total)
+	}
+
+	tl.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}
@@ -0,0 +1,65 @@
+package tilt
+
+import "time"
+
+// window counts the pulses of a rolling window in a ring of buckets,
+// so a pulse costs the same whatever the rate. The count is exact to a
+// bucket, the oldest bucket is dropped whole when the window moves on.
+type window struct {
+	counts []int
+	width  time.Duration // of a bucket
+	head   int           // the newest bucket
+	start  time.Time     // of the newest bucket
+	total  int
+}
+
+// windowBuckets is how many buckets a window is cut into
+const windowBuckets = 20
+
+func newWindow(span time.Duration) *window {
+	width := max(span/windowBuckets, time.Millisecond)
+	return &window{counts: make([]int, windowBuckets), width: width}
+}
+
+// span returns the length of the window
+func (w *window) span() time.Duration {
+	return w.width * time.Duration(len(w.counts))
+}
+
+// advance moves the window on to t, pulses from before the newest
+// bucket are counted in it
+func (w *window) advance(t time.Time) {
+	if w.start.IsZero() {
+		w.start = t
+		return
+	}
+	steps := int(t.Sub(w.start) / w.width)
+	if steps <= 0 {
+		return
+	}
+	if steps >= len(w.counts) {
+		clear(w.counts)
+		w.total = 0
+	} else {
+		for range steps {
+			w.head = (w.head + 1) % len(w.counts)
+			w.total -= w.counts[w.head]
+			w.counts[w.head] = 0
+		}
+	}
+	w.start = w.start.Add(time.Duration(steps) * w.width)
+}
+
+// add counts a pulse at t and returns the pulses in the window
+func (w *window) add(t time.Time) int {
+	w.advance(t)
+	w.counts[w.head]++
+	w.total++
+	return w.total
+}
+
+// count returns the pulses in the window ending at t
+func (w *window) count(t time.Time) int {
+	w.advance(t)
+	return w.total
+}
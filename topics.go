@@ -0,0 +1,211 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Topics are the templates of the topics a device publishes its data
+// and state on and takes its commands on. They take the placeholders
+// {station}, {device} for the name of the device and {field}, which
+// is "data", "control", "state" or "availability" so one template can
+// serve them all, like "home/{station}/{device}/{field}".
+//
+// Availability is where a device that announces itself is "online",
+// Station where the station is, it takes {station} only.
+type Topics struct {
+	Data         string `json:"data"`
+	Control      string `json:"control"`
+	State        string `json:"state"`
+	Availability string `json:"availability"`
+	Station      string `json:"station"`
+}
+
+// DefaultTopics are the topics of a station that set none
+var DefaultTopics = Topics{
+	Data:         "ss/d/{station}/{device}",
+	Control:      "ss/c/{station}/{device}",
+	State:        "ss/s/{station}/{device}",
+	Availability: "ss/a/{station}/{device}",
+	Station:      "ss/a/{station}",
+}
+
+// ErrTopic is returned for a station name or a template that can not
+// make a topic
+var ErrTopic = errors.New("invalid topic")
+
+var placeholders = []string{"station", "device", "field"}
+
+var (
+	stationName   = "station"
+	stationTopics = DefaultTopics
+	topicsMu      sync.RWMutex
+)
+
+// Station returns the name of the station
+func Station() string {
+	topicsMu.RLock()
+	defer topicsMu.RUnlock()
+	return stationName
+}
+
+// SetStation sets the name of the station, {station} in the topics,
+// set it before creating the devices
+func SetStation(name string) error {
+	if name == "" || strings.ContainsAny(name, "/+#") {
+		return fmt.Errorf("%w: station %q", ErrTopic, name)
+	}
+	topicsMu.Lock()
+	defer topicsMu.Unlock()
+	stationName = name
+	return nil
+}
+
+// GetTopics returns the topic templates of the station
+func GetTopics() Topics {
+	topicsMu.RLock()
+	defer topicsMu.RUnlock()
+	return stationTopics
+}
+
+// SetTopics sets the topic templates of the station, the ones left
+// empty are the DefaultTopics. Set them before creating the devices,
+// a device resolves its topics when it is created.
+func SetTopics(t Topics) error {
+	t = DefaultTopics.With(t)
+	if err := t.Valid(); err != nil {
+		return err
+	}
+	topicsMu.Lock()
+	defer topicsMu.Unlock()
+	stationTopics = t
+	return nil
+}
+
+// With returns the topics with the templates of o that are set
+func (t Topics) With(o Topics) Topics {
+	if o.Data != "" {
+		t.Data = o.Data
+	}
+	if o.Control != "" {
+		t.Control = o.Control
+	}
+	if o.State != "" {
+		t.State = o.State
+	}
+	if o.Availability != "" {
+		t.Availability = o.Availability
+	}
+	if o.Station != "" {
+		t.Station = o.Station
+	}
+	return t
+}
+
+// Valid returns ErrTopic for a template that is empty, has a
+// wildcard, or a placeholder that would be left unresolved
+func (t Topics) Valid() error {
+	for _, tmpl := range []string{t.Data, t.Control, t.State, t.Availability, t.Station} {
+		if err := validTemplate(tmpl); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(t.Station, "{device}") || strings.Contains(t.Station, "{field}") {
+		return fmt.Errorf("%w: station topic %q is not a device's", ErrTopic, t.Station)
+	}
+	return nil
+}
+
+func validTemplate(tmpl string) error {
+	if tmpl == "" {
+		return fmt.Errorf("%w: empty template", ErrTopic)
+	}
+	if strings.ContainsAny(tmpl, "+#") {
+		return fmt.Errorf("%w: wildcard in %q", ErrTopic, tmpl)
+	}
+	rest := tmpl
+	for {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			return nil
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if rest[open] == '}' || end < 0 || rest[open+1+end] == '{' {
+			return fmt.Errorf("%w: unmatched brace in %q", ErrTopic, tmpl)
+		}
+		name := rest[open+1 : open+1+end]
+		known := false
+		for _, p := range placeholders {
+			known = known || name == p
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown placeholder {%s} in %q", ErrTopic, name, tmpl)
+		}
+		rest = rest[open+end+2:]
+	}
+}
+
+// Resolve returns the topics of the device name on the station
+func (t Topics) Resolve(station, name string) Topics {
+	resolve := func(tmpl, field string) string {
+		return strings.NewReplacer("{station}", station, "{device}", name, "{field}", field).Replace(tmpl)
+	}
+	return Topics{
+		Data:         resolve(t.Data, "data"),
+		Control:      resolve(t.Control, "control"),
+		State:        resolve(t.State, "state"),
+		Availability: resolve(t.Availability, "availability"),
+		Station:      resolve(t.Station, ""),
+	}
+}
+
+// stationTopicsOf resolves the station's topics for the device name
+func stationTopicsOf(name string) Topics {
+	topicsMu.RLock()
+	defer topicsMu.RUnlock()
+	return stationTopics.Resolve(stationName, name)
+}
+
+// DataTopic is the topic the device name publishes its data on
+func DataTopic(name string) string {
+	return stationTopicsOf(name).Data
+}
+
+// ControlTopic is the topic the device name takes its commands on
+func ControlTopic(name string) string {
+	return stationTopicsOf(name).Control
+}
+
+// StateTopic is the topic the device name publishes its state on
+func StateTopic(name string) string {
+	return stationTopicsOf(name).State
+}
+
+// AvailabilityTopic is the topic the device name is announced on
+func AvailabilityTopic(name string) string {
+	return stationTopicsOf(name).Availability
+}
+
+// StationTopic is the topic the station is announced on, the will of
+// its connection to the broker
+func StationTopic() string {
+	return stationTopicsOf("").Station
+}
+
+// TopicMatch reports if topic matches the filter, which takes the MQTT
+// wildcards
+func TopicMatch(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i == len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
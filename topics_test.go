@@ -0,0 +1,114 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+// useTopics sets the station for the duration of the test
+func useTopics(t *testing.T, station string, topics Topics) {
+	t.Helper()
+	oldStation, oldTopics := Station(), GetTopics()
+	t.Cleanup(func() {
+		SetStation(oldStation)
+		SetTopics(oldTopics)
+	})
+	if err := SetStation(station); err != nil {
+		t.Fatalf("SetStation(%q) error = %v", station, err)
+	}
+	if err := SetTopics(topics); err != nil {
+		t.Fatalf("SetTopics(%+v) error = %v", topics, err)
+	}
+}
+
+func TestTopicsDefault(t *testing.T) {
+	d := NewDevice("bme280", "mqtt")
+	want := Topics{Data: "ss/d/station/bme280", Control: "ss/c/station/bme280", State: "ss/s/station/bme280"}
+	got := Topics{Data: d.DataTopic(), Control: d.ControlTopic(), State: d.StateTopic()}
+	if got != want || d.Topic() != want.Data {
+		t.Errorf("topics got (%+v) want (%+v)", got, want)
+	}
+	if DataTopic("bme280") != want.Data || ControlTopic("bme280") != want.Control || StateTopic("bme280") != want.State {
+		t.Errorf("station topics got (%s, %s, %s)", DataTopic("bme280"), ControlTopic("bme280"), StateTopic("bme280"))
+	}
+}
+
+func TestTopicsResolve(t *testing.T) {
+	useTopics(t, "kitchen", Topics{
+		Data:    "home/{station}/{device}/{field}",
+		Control: "home/{station}/{device}/set",
+	})
+
+	d := NewDevice("bme280", "mqtt")
+	want := Topics{Data: "home/kitchen/bme280/data", Control: "home/kitchen/bme280/set", State: "ss/s/kitchen/bme280"}
+	got := Topics{Data: d.DataTopic(), Control: d.ControlTopic(), State: d.StateTopic()}
+	if got != want {
+		t.Errorf("topics got (%+v) want (%+v)", got, want)
+	}
+
+	// the device overrides the station
+	if err := d.SetTopics(Topics{State: "home/{station}/{device}/{field}"}); err != nil {
+		t.Fatalf("SetTopics() error = %v", err)
+	}
+	want.State = "home/kitchen/bme280/state"
+	got = Topics{Data: d.DataTopic(), Control: d.ControlTopic(), State: d.StateTopic()}
+	if got != want {
+		t.Errorf("overridden topics got (%+v) want (%+v)", got, want)
+	}
+
+	m := NewMemMessanger()
+	led := NewDevice("led", "mqtt", WithMessanger(m), WithTopic("home/kitchen/lights"), WithEnvelope(false))
+	led.PubData("on")
+	led.PubState(1)
+	if msgs := m.Messages("home/kitchen/lights"); len(msgs) != 1 || msgs[0].String() != "on" {
+		t.Errorf("PubData() got (%v) want ([on])", m.Messages("#"))
+	}
+	if msgs := m.Messages("ss/s/kitchen/led"); len(msgs) != 1 || msgs[0].String() != "1" {
+		t.Errorf("PubState() got (%v) want ([1])", m.Messages("#"))
+	}
+}
+
+func TestTopicsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		topics Topics
+	}{
+		{"unknown placeholder", Topics{Data: "home/{room}/{device}"}},
+		{"misspelled", Topics{Control: "home/{station}/{devise}"}},
+		{"unclosed", Topics{State: "home/{station/{device}"}},
+		{"unopened", Topics{Data: "home/station}/{device}"}},
+		{"dangling", Topics{Data: "home/{device}/{"}},
+		{"empty placeholder", Topics{Data: "home/{}/{device}"}},
+		{"wildcard level", Topics{Data: "home/+/{device}"}},
+		{"wildcard rest", Topics{Data: "home/{device}/#"}},
+		{"station of a device", Topics{Station: "home/{station}/{device}"}},
+	}
+	before := GetTopics()
+	for _, tt := range tests {
+		if err := SetTopics(tt.topics); !errors.Is(err, ErrTopic) {
+			t.Errorf("%s SetTopics() error got (%v) want (%v)", tt.name, err, ErrTopic)
+		}
+		d := NewDevice("sensor", "mqtt")
+		if err := d.SetTopics(tt.topics); !errors.Is(err, ErrTopic) {
+			t.Errorf("%s Device.SetTopics() error got (%v) want (%v)", tt.name, err, ErrTopic)
+		}
+		if d.DataTopic() != "ss/d/station/sensor" {
+			t.Errorf("%s changed the topic to (%s)", tt.name, d.DataTopic())
+		}
+	}
+	if GetTopics() != before {
+		t.Errorf("an invalid template changed the station topics to (%+v)", GetTopics())
+	}
+	if err := (Topics{Data: "a", Control: "b"}).Valid(); !errors.Is(err, ErrTopic) {
+		t.Errorf("Valid() without a state error got (%v) want (%v)", err, ErrTopic)
+	}
+
+	for _, name := range []string{"", "home/kitchen", "+", "#"} {
+		if err := SetStation(name); !errors.Is(err, ErrTopic) {
+			t.Errorf("SetStation(%q) error got (%v) want (%v)", name, err, ErrTopic)
+		}
+	}
+	if Station() != "station" {
+		t.Errorf("Station() got (%s) want (station)", Station())
+	}
+}
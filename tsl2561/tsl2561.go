@@ -0,0 +1,292 @@
+// Package tsl2561 provides a driver for the TAOS/AMS TSL2561 light
+// sensor. It measures a broadband (visible and infrared) channel and
+// an infrared channel, the lux calculation subtracts the infrared
+// part so it follows the response of the human eye.
+package tsl2561
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+
+	// the ADDR SEL pin tied low, floating (the default) or high
+	AddressLow   = 0x29
+	AddressFloat = 0x39
+	AddressHigh  = 0x49
+)
+
+// registers are addressed through the command byte
+const (
+	cmd     = 0x80
+	cmdWord = 0x20
+
+	regControl = 0x00
+	regTiming  = 0x01
+	regID      = 0x0A
+	regData0   = 0x0C
+	regData1   = 0x0E
+
+	powerOn  = 0x03
+	powerOff = 0x00
+
+	// the part number in the high nibble of the ID register tells
+	// the package as well
+	partTSL2561CS = 0x1
+	partTSL2561T  = 0x5
+)
+
+// Gain is the GAIN bit of the timing register
+type Gain byte
+
+const (
+	Gain1x  Gain = 0x00
+	Gain16x Gain = 0x10
+)
+
+// Integration is the INTEG field of the timing register
+type Integration byte
+
+const (
+	Integ13ms  Integration = 0x00
+	Integ101ms Integration = 0x01
+	Integ402ms Integration = 0x02
+)
+
+// Duration returns the integration time, 13.7, 101 or 402ms
+func (it Integration) Duration() time.Duration {
+	switch it {
+	case Integ13ms:
+		return 13700 * time.Microsecond
+	case Integ101ms:
+		return 101 * time.Millisecond
+	}
+	return 402 * time.Millisecond
+}
+
+// maxCount is the count a channel saturates at, the short
+// integration times can not count to 65535
+func (it Integration) maxCount() uint16 {
+	switch it {
+	case Integ13ms:
+		return 5047
+	case Integ101ms:
+		return 37177
+	}
+	return 65535
+}
+
+var (
+	ErrNotTSL2561 = errors.New("device is not a TSL2561")
+	ErrTiming     = errors.New("invalid tsl2561 gain or integration time")
+	ErrReadFailed = errors.New("failed to read from TSL2561")
+)
+
+// Reading holds the channel counts and the lux. A saturated channel
+// makes the lux +Inf rather than a bogus low number.
+type Reading struct {
+	Lux       float64 `json:"lux"`
+	Broadband uint16  `json:"broadband"`
+	IR        uint16  `json:"ir"`
+	Ratio     float64 `json:"ratio"`
+	Saturated bool    `json:"saturated"`
+}
+
+// MarshalJSON publishes the lux of a saturated reading as null, JSON
+// has no infinity
+func (r Reading) MarshalJSON() ([]byte, error) {
+	type reading Reading
+	var lux *float64
+	if !math.IsInf(r.Lux, 0) {
+		lux = &r.Lux
+	}
+	return json.Marshal(struct {
+		reading
+		Lux *float64 `json:"lux"`
+	}{reading(r), lux})
+}
+
+// TSL2561 is a light sensor on an I2C bus
+type TSL2561 struct {
+	*device.Device
+	Package Package
+
+	bus  string
+	addr int
+	dev  *drivers.I2CDevice
+	gain Gain
+	it   Integration
+	mu   sync.Mutex
+}
+
+// New creates a TSL2561 at the given bus and address with 16x gain
+// and 402ms integration, the sensor is not touched until Init
+func New(name, bus string, addr int) *TSL2561 {
+	return &TSL2561{
+		Device:  device.NewDevice(name, "mqtt"),
+		Package: PackageT,
+		bus:     bus,
+		addr:    addr,
+		gain:    Gain16x,
+		it:      Integ402ms,
+	}
+}
+
+// Init opens the i2c bus, checks the part number and sets the
+// Package from it, powers the sensor up and writes the timing
+func (t *TSL2561) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(t.bus, t.addr)
+	if err != nil {
+		return err
+	}
+	dev.Order = binary.LittleEndian
+
+	id, err := dev.ReadReg8(cmd | regID)
+	if err != nil {
+		return err
+	}
+	switch id >> 4 {
+	case partTSL2561CS:
+		t.Package = PackageCS
+	case partTSL2561T:
+		t.Package = PackageT
+	default:
+		return fmt.Errorf("%w: id %#02x", ErrNotTSL2561, id)
+	}
+	if err := dev.WriteReg8(cmd|regControl, powerOn); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dev = dev
+	return t.writeTiming(t.gain, t.it)
+}
+
+// Name returns the name of the device
+func (t *TSL2561) Name() string {
+	return t.Device.Name
+}
+
+// SetTiming sets the gain and integration time. 16x gain suits dim
+// light, a short integration time extends the range in bright light.
+func (t *TSL2561) SetTiming(g Gain, it Integration) error {
+	if (g != Gain1x && g != Gain16x) || it > Integ402ms {
+		return fmt.Errorf("%w: %#02x %#02x", ErrTiming, byte(g), byte(it))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writeTiming(g, it)
+}
+
+// Timing returns the gain and integration time
+func (t *TSL2561) Timing() (Gain, Integration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gain, t.it
+}
+
+// Read returns the lux and the channel counts of the last completed
+// integration
+func (t *TSL2561) Read() (*Reading, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ch0, ch1 uint16
+	if device.IsMock() {
+		// steady indoor light with a little infrared
+		ch0 = uint16(float64(t.it.maxCount()) * (0.05 + t.MockRand().Float64()*0.02))
+		ch1 = ch0 / 5
+	} else {
+		if t.dev == nil {
+			return nil, fmt.Errorf("%w: not initialized", ErrReadFailed)
+		}
+		var err error
+		if ch0, err = t.dev.ReadReg16(cmd | cmdWord | regData0); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		if ch1, err = t.dev.ReadReg16(cmd | cmdWord | regData1); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+	}
+	return t.reading(ch0, ch1), nil
+}
+
+func (t *TSL2561) reading(ch0, ch1 uint16) *Reading {
+	r := &Reading{
+		Broadband: ch0,
+		IR:        ch1,
+		Ratio:     Ratio(ch0, ch1),
+	}
+	max := t.it.maxCount()
+	if ch0 >= max || ch1 >= max {
+		r.Saturated = true
+		r.Lux = math.Inf(1)
+		return r
+	}
+	r.Lux = Lux(ch0, ch1, t.gain, t.it, t.Package)
+	return r
+}
+
+// ReadPub reads the sensor and publishes the lux, the counts and
+// their ratio
+func (t *TSL2561) ReadPub() error {
+	r, err := t.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	t.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (t *TSL2561) Run(ctx context.Context, period time.Duration) error {
+	err := t.TimerLoop(ctx, period, t.ReadPub)
+	slog.Debug("tsl2561 stopped", "device", t.Device.Name, "error", err)
+	return err
+}
+
+// Close powers the sensor down and closes the i2c device
+func (t *TSL2561) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dev == nil {
+		return nil
+	}
+	t.dev.WriteReg8(cmd|regControl, powerOff)
+	err := t.dev.Close()
+	t.dev = nil
+	return err
+}
+
+// writeTiming writes the timing register and waits for an
+// integration with the new timing to complete
+func (t *TSL2561) writeTiming(g Gain, it Integration) error {
+	if t.dev != nil {
+		if err := t.dev.WriteReg8(cmd|regTiming, byte(g)|byte(it)); err != nil {
+			return err
+		}
+		time.Sleep(it.Duration() + 5*time.Millisecond)
+	}
+	t.gain, t.it = g, it
+	return nil
+}
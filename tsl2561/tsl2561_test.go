@@ -0,0 +1,127 @@
+package tsl2561
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+func TestLux(t *testing.T) {
+	// want is what the datasheet appendix's CalculateLux returns, it
+	// rounds to whole lux
+	tests := []struct {
+		name     string
+		ch0, ch1 uint16
+		gain     Gain
+		it       Integration
+		pkg      Package
+		want     float64
+	}{
+		{"no infrared", 1000, 0, Gain16x, Integ402ms, PackageT, 30},
+		{"ratio 0.2", 1000, 200, Gain16x, Integ402ms, PackageT, 24},
+		{"ratio 0.55", 1000, 550, Gain16x, Integ402ms, PackageT, 5},
+		{"ratio 0.7", 1000, 700, Gain16x, Integ402ms, PackageT, 2},
+		{"ratio 1.0", 1000, 1000, Gain16x, Integ402ms, PackageT, 0},
+		{"ratio above 1.3", 1000, 1400, Gain16x, Integ402ms, PackageT, 0},
+		{"gain 1x", 500, 100, Gain1x, Integ402ms, PackageT, 189},
+		{"13.7ms", 800, 300, Gain16x, Integ13ms, PackageT, 344},
+		{"101ms", 800, 300, Gain16x, Integ101ms, PackageT, 47},
+		{"1x 101ms", 30000, 6000, Gain1x, Integ101ms, PackageT, 45168},
+		{"cs ratio 0.2", 1000, 200, Gain16x, Integ402ms, PackageCS, 25},
+		{"cs ratio 0.7", 1000, 700, Gain16x, Integ402ms, PackageCS, 3},
+		{"dark", 0, 0, Gain16x, Integ402ms, PackageT, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lux(tt.ch0, tt.ch1, tt.gain, tt.it, tt.pkg)
+			if math.Round(got) != tt.want {
+				t.Errorf("Lux(%d, %d) got (%.2f) want (%.0f)", tt.ch0, tt.ch1, got, tt.want)
+			}
+		})
+	}
+
+	// dim light keeps its fraction
+	if got := Lux(20, 4, Gain16x, Integ402ms, PackageT); got <= 0 || got >= 1 {
+		t.Errorf("Lux(20, 4) got (%.3f) want between 0 and 1", got)
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	tests := []struct {
+		it        Integration
+		ch0, ch1  uint16
+		saturated bool
+	}{
+		{Integ13ms, 5046, 1000, false},
+		{Integ13ms, 5047, 1000, true},
+		{Integ101ms, 37177, 1000, true},
+		{Integ402ms, 65535, 65535, true},
+		{Integ402ms, 60000, 20000, false},
+	}
+	for _, tt := range tests {
+		s := New("tsl-test", TestI2CBus, AddressFloat)
+		s.it = tt.it
+		r := s.reading(tt.ch0, tt.ch1)
+		if r.Saturated != tt.saturated || math.IsInf(r.Lux, 1) != tt.saturated {
+			t.Errorf("reading(%d, %d) at %v got (%+v) want saturated (%t)", tt.ch0, tt.ch1, tt.it.Duration(), r, tt.saturated)
+		}
+	}
+
+	j, err := json.Marshal(&Reading{Lux: math.Inf(1), Broadband: 65535, IR: 65535, Ratio: 1, Saturated: true})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `{"broadband":65535,"ir":65535,"ratio":1,"saturated":true,"lux":null}`
+	if string(j) != want {
+		t.Errorf("Marshal() got (%s) want (%s)", j, want)
+	}
+}
+
+func TestInitRead(t *testing.T) {
+	device.Mock(false)
+	fake := driverstest.NewI2C()
+	driverstest.UseI2C(t, fake)
+
+	// a TSL2561CS reading 1000 and 200
+	fake.Set(cmd|regID, 0x11)
+	fake.Set(cmd|cmdWord|regData0, 0xE8, 0x03, 0xC8, 0x00)
+
+	s := New("tsl-test", TestI2CBus, AddressFloat)
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if s.Package != PackageCS {
+		t.Errorf("Package got (%d) want (%d)", s.Package, PackageCS)
+	}
+	if got := fake.Get(cmd|regControl, 2); got[0] != powerOn || got[1] != byte(Gain16x)|byte(Integ402ms) {
+		t.Errorf("control and timing got (% x) want (03 12)", got)
+	}
+
+	r, err := s.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if r.Broadband != 1000 || r.IR != 200 || r.Ratio != 0.2 || math.Round(r.Lux) != 25 {
+		t.Errorf("Read() got (%+v) want (1000 200 0.2 25lx)", r)
+	}
+
+	if err := s.SetTiming(Gain1x, 3); !errors.Is(err, ErrTiming) {
+		t.Errorf("SetTiming(manual) error got (%v) want (%v)", err, ErrTiming)
+	}
+
+	fake.Set(cmd|regID, 0x40)
+	if err := New("tsl2560", TestI2CBus, AddressLow).Init(); !errors.Is(err, ErrNotTSL2561) {
+		t.Errorf("Init() error got (%v) want (%v)", err, ErrNotTSL2561)
+	}
+
+	s.Close()
+	if fake.Get(cmd|regControl, 1)[0] != powerOff || !fake.Closed() {
+		t.Error("Close() did not power the sensor down")
+	}
+}
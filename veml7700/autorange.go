@@ -0,0 +1,67 @@
+package veml7700
+
+// ladder holds the settings AutoRange moves along, from the widest
+// range to the most sensitive. The app note raises the gain first
+// and then the integration time for dim light, and shortens the
+// integration time for bright light.
+var ladder = []struct {
+	gain Gain
+	it   IntegrationTime
+}{
+	{Gain1_8, IT25ms},
+	{Gain1_8, IT50ms},
+	{Gain1_8, IT100ms},
+	{Gain1_4, IT100ms},
+	{Gain1, IT100ms},
+	{Gain2, IT100ms},
+	{Gain2, IT200ms},
+	{Gain2, IT400ms},
+	{Gain2, IT800ms},
+}
+
+// startStep is the app note's starting point, gain 1/8 and 100ms
+const startStep = 2
+
+// the app note's limits of useful counts, below countLow the
+// resolution is poor and above countHigh the response is nonlinear
+const (
+	countLow  = 100
+	countHigh = 10000
+)
+
+// step returns the ladder step of a gain and integration time, -1
+// for a setting made by hand that is not on the ladder
+func step(g Gain, it IntegrationTime) int {
+	for i, s := range ladder {
+		if s.gain == g && s.it == it {
+			return i
+		}
+	}
+	return -1
+}
+
+// autoRange returns the step for the next measurement and if the
+// count should be measured again there. A count outside the limits
+// is measured again one step over. A count that would stay under
+// half of countHigh one step up moves up for the next reading to
+// regain resolution once bright light is gone. A step changes the
+// count at most fourfold so it never bounces between the limits.
+func autoRange(cur int, count uint16) (next int, again bool) {
+	last := len(ladder) - 1
+	switch {
+	case cur < 0:
+		return startStep, true
+	case count <= countLow && cur < last:
+		return cur + 1, true
+	case count > countHigh && cur > 0:
+		return cur - 1, true
+	case cur < last && float64(count)*gainRatio(cur) < countHigh/2:
+		return cur + 1, false
+	}
+	return cur, false
+}
+
+// gainRatio is how many times more counts the next step up makes
+func gainRatio(cur int) float64 {
+	return luxPerCount(ladder[cur].gain, ladder[cur].it) / luxPerCount(ladder[cur+1].gain, ladder[cur+1].it)
+}
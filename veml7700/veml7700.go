@@ -0,0 +1,376 @@
+// Package veml7700 provides a driver for the Vishay VEML7700 ambient
+// light sensor. The sensor's response turns nonlinear in bright light,
+// readings above 1000 lx are corrected with the polynomial of the
+// "Designing the VEML7700 Into an Application" note, and AutoRange
+// follows that note's gain and integration time selection to keep
+// the counts in the linear region.
+package veml7700
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+const (
+	DefaultI2CBus = "/dev/i2c-1"
+	Address       = 0x10
+)
+
+// command codes, the registers are 16 bit little endian
+const (
+	regConf  = 0x00
+	regPSM   = 0x03
+	regALS   = 0x04
+	regWhite = 0x05
+
+	confShutdown = 1 << 0
+	psmEnable    = 1 << 0
+)
+
+// Gain is the ALS_GAIN field of the configuration register
+type Gain uint16
+
+const (
+	Gain1   Gain = 0x00
+	Gain2   Gain = 0x01
+	Gain1_8 Gain = 0x02
+	Gain1_4 Gain = 0x03
+)
+
+// Factor returns the gain as a number
+func (g Gain) Factor() float64 {
+	switch g {
+	case Gain2:
+		return 2
+	case Gain1_8:
+		return 0.125
+	case Gain1_4:
+		return 0.25
+	}
+	return 1
+}
+
+// IntegrationTime is the ALS_IT field of the configuration register
+type IntegrationTime uint16
+
+const (
+	IT25ms  IntegrationTime = 0x0C
+	IT50ms  IntegrationTime = 0x08
+	IT100ms IntegrationTime = 0x00
+	IT200ms IntegrationTime = 0x01
+	IT400ms IntegrationTime = 0x02
+	IT800ms IntegrationTime = 0x03
+)
+
+// Duration returns the integration time
+func (it IntegrationTime) Duration() time.Duration {
+	switch it {
+	case IT25ms:
+		return 25 * time.Millisecond
+	case IT50ms:
+		return 50 * time.Millisecond
+	case IT200ms:
+		return 200 * time.Millisecond
+	case IT400ms:
+		return 400 * time.Millisecond
+	case IT800ms:
+		return 800 * time.Millisecond
+	}
+	return 100 * time.Millisecond
+}
+
+// PowerSaving is the PSM mode, the sensor sleeps between measurements
+// for 0.5s (PSM1) to 4s (PSM4) to cut its current draw
+type PowerSaving int
+
+const (
+	PSMOff PowerSaving = iota
+	PSM1
+	PSM2
+	PSM3
+	PSM4
+)
+
+// sleepTime is the time a power saving mode adds to a measurement
+func (p PowerSaving) sleepTime() time.Duration {
+	if p == PSMOff {
+		return 0
+	}
+	return 500 * time.Millisecond << (p - 1)
+}
+
+const (
+	// resolution is the lux per count at gain 2 and 800ms, section
+	// "Resolution and maximum detection range" of the app note
+	resolution = 0.0036
+
+	// correctAbove is the lux above which the response is corrected
+	correctAbove = 1000
+)
+
+var (
+	ErrGain            = errors.New("invalid veml7700 gain")
+	ErrIntegrationTime = errors.New("invalid veml7700 integration time")
+	ErrPowerSaving     = errors.New("veml7700 power saving mode must be 0 to 4")
+	ErrReadFailed      = errors.New("failed to read from VEML7700")
+)
+
+// Reading holds the raw counts and the corrected lux, it is what
+// ReadPub publishes
+type Reading struct {
+	ALS   uint16  `json:"als"`
+	White uint16  `json:"white"`
+	Lux   float64 `json:"lux"`
+}
+
+// VEML7700 is an ambient light sensor on an I2C bus
+type VEML7700 struct {
+	*device.Device
+
+	// AutoRange picks the gain and integration time for every read
+	AutoRange bool
+
+	bus  string
+	dev  *drivers.I2CDevice
+	gain Gain
+	it   IntegrationTime
+	psm  PowerSaving
+
+	sleep func(time.Duration)
+	mu    sync.Mutex
+}
+
+// New creates a VEML7700 on the given bus with AutoRange on, the
+// sensor is not touched until Init
+func New(name, bus string) *VEML7700 {
+	return &VEML7700{
+		Device:    device.NewDevice(name, "mqtt"),
+		AutoRange: true,
+		bus:       bus,
+		gain:      Gain1_8,
+		it:        IT100ms,
+		sleep:     time.Sleep,
+	}
+}
+
+// Init opens the i2c bus, writes the configuration and powers the
+// sensor on
+func (v *VEML7700) Init() error {
+	if device.IsMock() {
+		return nil
+	}
+	dev, err := drivers.NewI2CDevice(v.bus, Address)
+	if err != nil {
+		return err
+	}
+	dev.Order = binary.LittleEndian
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.dev = dev
+	if err := v.writePSM(); err != nil {
+		return err
+	}
+	return v.configure(v.gain, v.it)
+}
+
+// Name returns the name of the device
+func (v *VEML7700) Name() string {
+	return v.Device.Name
+}
+
+// SetGain sets the gain and turns AutoRange off
+func (v *VEML7700) SetGain(g Gain) error {
+	if g > Gain1_4 {
+		return fmt.Errorf("%w: %#02x", ErrGain, uint16(g))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.AutoRange = false
+	return v.configure(g, v.it)
+}
+
+// SetIntegrationTime sets the integration time and turns AutoRange off
+func (v *VEML7700) SetIntegrationTime(it IntegrationTime) error {
+	switch it {
+	case IT25ms, IT50ms, IT100ms, IT200ms, IT400ms, IT800ms:
+	default:
+		return fmt.Errorf("%w: %#02x", ErrIntegrationTime, uint16(it))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.AutoRange = false
+	return v.configure(v.gain, it)
+}
+
+// Settings returns the current gain and integration time
+func (v *VEML7700) Settings() (Gain, IntegrationTime) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.gain, v.it
+}
+
+// SetPowerSaving sets the power saving mode, PSMOff measures
+// continuously
+func (v *VEML7700) SetPowerSaving(p PowerSaving) error {
+	if p < PSMOff || p > PSM4 {
+		return fmt.Errorf("%w: %d", ErrPowerSaving, p)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.psm = p
+	return v.writePSM()
+}
+
+// Read returns the counts and the lux. With AutoRange the gain and
+// integration time are adjusted and the light measured again until
+// the counts are in the linear region, in steady light the
+// resolution improves over the next reads.
+func (v *VEML7700) Read() (*Reading, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for {
+		r, err := v.measure()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailed, err)
+		}
+		if !v.AutoRange {
+			return r, nil
+		}
+		cur := step(v.gain, v.it)
+		next, again := autoRange(cur, r.ALS)
+		if next != cur {
+			if err := v.configure(ladder[next].gain, ladder[next].it); err != nil {
+				return nil, err
+			}
+		}
+		if !again {
+			return r, nil
+		}
+	}
+}
+
+// ReadPub reads the sensor and publishes the counts and lux
+func (v *VEML7700) ReadPub() error {
+	r, err := v.Read()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	v.PubData(j)
+	return nil
+}
+
+// Run publishes a reading every period until ctx is canceled
+func (v *VEML7700) Run(ctx context.Context, period time.Duration) error {
+	err := v.TimerLoop(ctx, period, v.ReadPub)
+	slog.Debug("veml7700 stopped", "device", v.Device.Name, "error", err)
+	return err
+}
+
+// Close shuts the sensor down and closes the i2c device
+func (v *VEML7700) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.dev == nil {
+		return nil
+	}
+	v.dev.WriteReg16(regConf, confValue(v.gain, v.it)|confShutdown)
+	err := v.dev.Close()
+	v.dev = nil
+	return err
+}
+
+// Lux converts a count at the given gain and integration time to lux,
+// correcting the nonlinear response above 1000 lx
+func Lux(count uint16, g Gain, it IntegrationTime) float64 {
+	lux := float64(count) * luxPerCount(g, it)
+	if lux > correctAbove {
+		lux = correct(lux)
+	}
+	return lux
+}
+
+func luxPerCount(g Gain, it IntegrationTime) float64 {
+	return resolution * (2 / g.Factor()) * float64(800*time.Millisecond) / float64(it.Duration())
+}
+
+// correct is the app note's polynomial fit of the sensor's response
+func correct(lux float64) float64 {
+	return ((6.0135e-13*lux-9.3924e-9)*lux+8.1488e-5)*lux*lux + 1.0023*lux
+}
+
+func confValue(g Gain, it IntegrationTime) uint16 {
+	return uint16(g)<<11 | uint16(it)<<6
+}
+
+// configure writes the gain and integration time, the next result
+// is ready a full integration after the change
+func (v *VEML7700) configure(g Gain, it IntegrationTime) error {
+	if v.dev != nil {
+		if err := v.dev.WriteReg16(regConf, confValue(g, it)); err != nil {
+			return err
+		}
+	}
+	changed := g != v.gain || it != v.it
+	v.gain, v.it = g, it
+	if changed && v.dev != nil {
+		v.sleep(v.cycle())
+	}
+	return nil
+}
+
+func (v *VEML7700) writePSM() error {
+	if v.dev == nil {
+		return nil
+	}
+	var psm uint16
+	if v.psm != PSMOff {
+		psm = uint16(v.psm-1)<<1 | psmEnable
+	}
+	return v.dev.WriteReg16(regPSM, psm)
+}
+
+// cycle is the time one measurement takes, with some margin for the
+// sensor's oscillator
+func (v *VEML7700) cycle() time.Duration {
+	return (v.it.Duration()+v.psm.sleepTime())*11/10 + 5*time.Millisecond
+}
+
+func (v *VEML7700) measure() (*Reading, error) {
+	var als, white uint16
+	if device.IsMock() || v.dev == nil {
+		if !device.IsMock() {
+			return nil, errors.New("not initialized")
+		}
+		// an office, 300 to 500 lx
+		c := (300 + v.MockRand().Float64()*200) / luxPerCount(v.gain, v.it)
+		als, white = uint16(min(c, 0xFFFF)), uint16(min(c*1.3, 0xFFFF))
+	} else {
+		var err error
+		if als, err = v.dev.ReadReg16(regALS); err != nil {
+			return nil, err
+		}
+		if white, err = v.dev.ReadReg16(regWhite); err != nil {
+			return nil, err
+		}
+	}
+	return &Reading{
+		ALS:   als,
+		White: white,
+		Lux:   Lux(als, v.gain, v.it),
+	}, nil
+}
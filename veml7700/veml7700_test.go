@@ -0,0 +1,200 @@
+package veml7700
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+const TestI2CBus = "/dev/i2c-1"
+
+// sensor models the VEML7700 on the register map fake, the ALS and
+// white registers follow the light level and the configuration
+// written to the fake
+type sensor struct {
+	*driverstest.I2C
+	lux float64
+}
+
+func (s *sensor) ReadReg(reg byte, buf []byte) error {
+	conf := binary.LittleEndian.Uint16(s.Get(regConf, 2))
+	g, it := Gain(conf>>11&0x03), IntegrationTime(conf>>6&0x0F)
+	count := uint16(math.Min(s.lux/luxPerCount(g, it), 0xFFFF))
+	switch reg {
+	case regALS:
+		s.Set(regALS, byte(count), byte(count>>8))
+	case regWhite:
+		s.Set(regWhite, byte(count), byte(count>>8))
+	}
+	return s.I2C.ReadReg(reg, buf)
+}
+
+func newTestVEML(t *testing.T, lux float64) (*VEML7700, *sensor) {
+	t.Helper()
+	device.Mock(false)
+	s := &sensor{I2C: driverstest.NewI2C(), lux: lux}
+	driverstest.UseI2C(t, s)
+
+	v := New("veml-test", TestI2CBus)
+	v.sleep = func(time.Duration) {}
+	if err := v.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	s.Writes = nil
+	return v, s
+}
+
+func TestLux(t *testing.T) {
+	tests := []struct {
+		count uint16
+		gain  Gain
+		it    IntegrationTime
+		lux   float64
+	}{
+		{1000, Gain2, IT800ms, 3.6},
+		{1000, Gain1, IT100ms, 57.6},
+		{100, Gain1_8, IT25ms, 184.32},
+		{500, Gain1_4, IT100ms, 115.2},
+		// above 1000 lx the response is corrected
+		{1000, Gain1_8, IT25ms, 2072.41},
+		{10000, Gain1_8, IT25ms, 56752.44},
+	}
+	for _, tt := range tests {
+		if got := Lux(tt.count, tt.gain, tt.it); math.Abs(got-tt.lux) > 0.01 {
+			t.Errorf("Lux(%d, %d, %d) got (%.2f) want (%.2f)", tt.count, tt.gain, tt.it, got, tt.lux)
+		}
+	}
+}
+
+func TestAutoRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		cur   int
+		count uint16
+		next  int
+		again bool
+	}{
+		{"set by hand", -1, 5000, startStep, true},
+		{"good", startStep, 5000, startStep, false},
+		{"dim", startStep, 100, 3, true},
+		{"darkest", len(ladder) - 1, 3, len(ladder) - 1, false},
+		{"bright", 5, 10001, 4, true},
+		{"brightest", 0, 0xFFFF, 0, false},
+		{"limit", 5, 10000, 5, false},
+		{"regain resolution", startStep, 1000, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, again := autoRange(tt.cur, tt.count)
+			if next != tt.next || again != tt.again {
+				t.Errorf("autoRange(%d, %d) got (%d, %t) want (%d, %t)", tt.cur, tt.count, next, again, tt.next, tt.again)
+			}
+		})
+	}
+}
+
+func TestReadAutoRange(t *testing.T) {
+	v, s := newTestVEML(t, 0)
+
+	tests := []struct {
+		name string
+		lux  float64
+		gain Gain
+		it   IntegrationTime
+		als  uint16
+	}{
+		{"night", 0.5, Gain2, IT800ms, 138},
+		{"office", 400, Gain1, IT100ms, 6944},
+		{"sun", 50000, Gain1_8, IT25ms, 27126},
+		{"overcast", 2000, Gain1_8, IT50ms, 1085},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.lux = tt.lux
+			r, err := v.Read()
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			g, it := v.Settings()
+			if g != tt.gain || it != tt.it || r.ALS != tt.als {
+				t.Errorf("Read() got (gain %d it %d als %d) want (gain %d it %d als %d)", g, it, r.ALS, tt.gain, tt.it, tt.als)
+			}
+		})
+	}
+}
+
+// words returns the 16 bit writes made to the fake
+func words(s *sensor) []string {
+	var w []string
+	for _, wr := range s.Writes {
+		w = append(w, fmt.Sprintf("%02x=%04x", wr.Reg, binary.LittleEndian.Uint16(wr.Data)))
+	}
+	return w
+}
+
+func TestConfig(t *testing.T) {
+	device.Mock(false)
+	s := &sensor{I2C: driverstest.NewI2C(), lux: 100}
+	driverstest.UseI2C(t, s)
+
+	v := New("veml-test", TestI2CBus)
+	v.sleep = func(time.Duration) {}
+	if err := v.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+		want string
+	}{
+		{"init", func() error { return nil }, "[03=0000 00=1000]"},
+		{"gain", func() error { return v.SetGain(Gain2) }, "[00=0800]"},
+		{"integration", func() error { return v.SetIntegrationTime(IT800ms) }, "[00=08c0]"},
+		{"power saving", func() error { return v.SetPowerSaving(PSM3) }, "[03=0005]"},
+		{"power saving off", func() error { return v.SetPowerSaving(PSMOff) }, "[03=0000]"},
+		{"shutdown", v.Close, "[00=08c1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got := fmt.Sprint(words(s)); got != tt.want {
+				t.Errorf("wrote (%s) want (%s)", got, tt.want)
+			}
+			s.Writes = nil
+		})
+	}
+	if v.AutoRange {
+		t.Error("AutoRange still on after setting the gain by hand")
+	}
+
+	if err := v.SetGain(Gain(4)); !errors.Is(err, ErrGain) {
+		t.Errorf("SetGain(4) error got (%v) want (%v)", err, ErrGain)
+	}
+	if err := v.SetIntegrationTime(IntegrationTime(5)); !errors.Is(err, ErrIntegrationTime) {
+		t.Errorf("SetIntegrationTime(5) error got (%v) want (%v)", err, ErrIntegrationTime)
+	}
+	if err := v.SetPowerSaving(PSM4 + 1); !errors.Is(err, ErrPowerSaving) {
+		t.Errorf("SetPowerSaving(5) error got (%v) want (%v)", err, ErrPowerSaving)
+	}
+}
+
+func TestMock(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	v := New("veml-mock", TestI2CBus)
+	v.sleep = func(time.Duration) {}
+	r, err := v.Read()
+	if err != nil || r.Lux < 300 || r.Lux > 500 {
+		t.Errorf("Read() got (%+v, %v) want an office light level", r, err)
+	}
+}
@@ -0,0 +1,32 @@
+package vh400
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+)
+
+// the mock profiles of a VH400, the volts of its mock pin. "watered"
+// soil stays around 1.7V, about 34% VWC. "drying" soil dries out over
+// a week from 1.8V to 0.9V, about 39% to 8%, and is watered again.
+func init() {
+	device.RegisterMockProfile("vh400", "watered", func(v *VH400) error {
+		return v.profile(device.Walk(1.7, 0.0005, 1.6, 1.8, v.MockRand()))
+	})
+	device.RegisterMockProfile("vh400", "drying", func(v *VH400) error {
+		return v.profile(device.Ramp(1.8, 0.9, 7*24*time.Hour))
+	})
+}
+
+// profile has the mock pin read the volts of g with the noise of the
+// probe, started now
+func (v *VH400) profile(g device.Generator) error {
+	pin, ok := v.AnalogReader.(*drivers.MockAnalogPin)
+	if !ok {
+		return fmt.Errorf("%w: %s reads no mock pin", device.ErrMockProfile, v.Name())
+	}
+	pin.SetGenerator(device.Sum(g, device.Noise(0.005, v.MockRand())))
+	return nil
+}
@@ -4,26 +4,24 @@ package vh400
 // For calculations on the VWC.  Borrowed from above website
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
 
-	"github.com/rustyeddy/otto/device"
-	"github.com/rustyeddy/otto/device/drivers"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
 )
 
 type VH400 struct {
 	*device.Device
-	drivers.AnalogPin
+	drivers.AnalogReader
 }
 
+// New creates a VH400 on channel pin of the default ADS1115
 func New(name string, pin int) *VH400 {
-       d := device.NewDevice(name, "mqtt")
-       v := &VH400{
-	       Device: d,
-       }
 	if device.IsMock() {
-		v.AnalogPin = drivers.NewMockAnalogPin(name, pin, nil)
-		return v
+		return NewWithReader(name, drivers.NewMockAnalogPin(name, pin, nil))
 	}
 
 	ads := drivers.GetADS1115()
@@ -32,16 +30,25 @@ func New(name string, pin int) *VH400 {
 		slog.Error("vh400.New", "name", name, "pin", pin, "error", err)
 		return nil
 	}
-	v.AnalogPin = p
+	return NewWithReader(name, p)
+}
+
+// NewWithReader creates a VH400 reading its voltage from r
+func NewWithReader(name string, r drivers.AnalogReader) *VH400 {
+	v := &VH400{
+		Device:       device.NewDevice(name, "mqtt"),
+		AnalogReader: r,
+	}
+	v.SetMockKind("vh400", v)
 	return v
 }
 
 func (v *VH400) Name() string {
-	return v.Device.Name()
+	return v.Device.Name
 }
 
 func (v *VH400) Read() (float64, error) {
-	volts, err := v.AnalogPin.Read()
+	volts, err := v.ReadVolts()
 	if err != nil {
 		return volts, err
 	}
@@ -59,8 +66,12 @@ func (v *VH400) ReadPub() error {
 }
 
 func (v *VH400) ReadContinousPub() error {
-	v.SetTopic(messanger.GetTopics().Data("vh100/" + v.Name()))
-	q := v.AnalogPin.ReadContinuous()
+	ap, ok := v.AnalogReader.(drivers.AnalogPin)
+	if !ok {
+		return fmt.Errorf("vh400 %s: reader can not read continuously", v.Name())
+	}
+	v.SetTopic(device.DataTopic("vh100/" + v.Name()))
+	q := ap.ReadContinuous()
 	go func() {
 		for {
 			vbytes := <-q
@@ -127,3 +138,11 @@ func vwc(volts float64) float64 {
 	vwc := coef*volts - rem
 	return vwc
 }
+
+// Close releases the analog pin used by the VH400
+func (v *VH400) Close() error {
+	if c, ok := v.AnalogReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
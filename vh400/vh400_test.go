@@ -2,8 +2,10 @@ package vh400
 
 import (
 	"testing"
+	"time"
 
-	"github.com/rustyeddy/otto/device"
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
 )
 
 func TestVH400(t *testing.T) {
@@ -23,3 +25,30 @@ func TestVH400(t *testing.T) {
 		t.Errorf("Expected value but got 0")
 	}
 }
+
+func TestProfiles(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+	clock := devicetest.UseClock(t, time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+
+	v := New("vh400-profile", 2)
+	if err := v.MockProfile("drying"); err != nil {
+		t.Fatalf("MockProfile(drying) error = %v", err)
+	}
+	last := 100.0
+	for day := range 7 {
+		vwc, err := v.Read()
+		if err != nil || vwc >= last || vwc < 5 {
+			t.Errorf("Read() drying day %d got (%.1f, %v) want drier than (%.1f)", day, vwc, err, last)
+		}
+		last = vwc
+		clock.Advance(24 * time.Hour)
+	}
+
+	if err := v.MockProfile("watered"); err != nil {
+		t.Fatalf("MockProfile(watered) error = %v", err)
+	}
+	if vwc, err := v.Read(); err != nil || vwc < 28 || vwc > 40 {
+		t.Errorf("Read() watered got (%.1f, %v) want about 34", vwc, err)
+	}
+}
@@ -0,0 +1,106 @@
+package waterlevel
+
+import "time"
+
+// tracker follows the level from the float switches, ordered from
+// the lowest up. A switch change only counts once every switch has
+// been steady for the stability window, slosh flips a float for a
+// moment. Floats below a triggered one that are not triggered are
+// stuck, either they are or the one above them is, the level is
+// taken from the highest triggered float so a stuck switch errs on
+// the side of a full tank.
+type tracker struct {
+	stable time.Duration
+	high   int // the level alerted as high
+
+	raw     []bool // the switches as last read
+	changed time.Time
+
+	on    []bool // the switches as of the last steady reading
+	level int
+	stuck []int
+}
+
+func newTracker(n int, stable time.Duration) *tracker {
+	return &tracker{
+		stable: stable,
+		high:   n,
+		raw:    make([]bool, n),
+		on:     make([]bool, n),
+	}
+}
+
+// start sets the switches without waiting for them to be steady
+func (t *tracker) start(on []bool, at time.Time) {
+	copy(t.raw, on)
+	t.changed = at
+	t.commit()
+}
+
+// input records switch i read on at at, it returns true if the
+// switches changed
+func (t *tracker) input(i int, on bool, at time.Time) bool {
+	if t.raw[i] == on {
+		return false
+	}
+	t.raw[i] = on
+	t.changed = at
+	return true
+}
+
+// settleAt is when the switches are steady if they do not change
+// again
+func (t *tracker) settleAt() time.Time {
+	return t.changed.Add(t.stable)
+}
+
+// settle takes the switches as steady if they have not changed for
+// the stability window, it returns true if the level or the stuck
+// switches changed
+func (t *tracker) settle(at time.Time) bool {
+	if at.Before(t.settleAt()) {
+		return false
+	}
+	level, stuck := t.level, t.stuck
+	t.commit()
+	return level != t.level || !equal(stuck, t.stuck)
+}
+
+func (t *tracker) commit() {
+	copy(t.on, t.raw)
+	t.level, t.stuck = evaluate(t.on)
+}
+
+// isHigh reports if the level is at or above the high level
+func (t *tracker) isHigh() bool {
+	return t.level >= t.high
+}
+
+// evaluate returns the level, the number of the highest triggered
+// float, and the floats below it that are not triggered
+func evaluate(on []bool) (level int, stuck []int) {
+	for i := len(on) - 1; i >= 0; i-- {
+		if on[i] {
+			level = i + 1
+			break
+		}
+	}
+	for i := 0; i < level-1; i++ {
+		if !on[i] {
+			stuck = append(stuck, i)
+		}
+	}
+	return level, stuck
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,371 @@
+// Package waterlevel provides a water level device for sump pits and
+// tanks, built from float switches on GPIO lines, low to high. The
+// level is the number of the highest float triggered, 0 with none
+// of them, and is published whenever it changes.
+//
+// The floats have to be steady for a stability window before a
+// change counts, so the slosh of a pump starting does not flap the
+// level. A float triggered above one that is not is a stuck switch,
+// it is published as a "stuck" event. Reaching the high level is
+// published as a "high" event and dropping below it as "normal".
+//
+// With Interlock set the named relay in the device manager is turned
+// off, cutting the pump filling the tank, on a high level and on a
+// stuck switch. A relay that is an Inhibitor, like relay.Relay, nacks
+// "on" until the level is back below high and no switch is stuck.
+package waterlevel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// DefaultStable is how long the floats have to be steady for a
+// change of level to count
+const DefaultStable = 2 * time.Second
+
+var (
+	ErrConfig = errors.New("invalid water level configuration")
+	ErrRelay  = errors.New("no relay to interlock")
+)
+
+// Float is a float switch, Pin is any pin identifier
+// drivers.ResolvePinID understands
+type Float struct {
+	Name string
+	Pin  string
+}
+
+// Status is what is published on a change and by ReadPub. Name is
+// the name of the highest float triggered.
+type Status struct {
+	Level int      `json:"level"`
+	Name  string   `json:"name,omitempty"`
+	High  bool     `json:"high"`
+	Stuck []string `json:"stuck,omitempty"`
+}
+
+// Event is published on a high level, "high", back below it,
+// "normal", on a stuck switch, "stuck" with the floats that are not
+// triggered below a triggered one, on it clearing, "stuck_cleared",
+// and when the interlock cuts the relay, "interlock"
+type Event struct {
+	Event  string   `json:"event"`
+	Level  int      `json:"level"`
+	Floats []string `json:"floats,omitempty"`
+	Relay  string   `json:"relay,omitempty"`
+}
+
+// Switch is a relay the interlock turns off, relay.Relay is one
+type Switch interface {
+	Off() error
+}
+
+// Inhibitor is a relay holding back the commands switching it on
+// while the interlock has it cut, relay.Relay by its device
+type Inhibitor interface {
+	Inhibit(by string, on bool)
+}
+
+// WaterLevel is a set of float switches
+type WaterLevel struct {
+	*device.Device
+
+	floats []Float
+	pins   []*drivers.DigitalPin
+	trk    *tracker
+	timer  *device.Timer
+	relay  string
+	cut    bool // the interlock has turned the relay off
+
+	mu sync.Mutex
+}
+
+// New creates a water level device from the floats, from the lowest
+// up. The floats close to ground when the water lifts them, their
+// lines are pulled up. opts are added to every line request.
+func New(name string, floats []Float, opts ...gpiocdev.LineReqOption) (*WaterLevel, error) {
+	if len(floats) == 0 {
+		return nil, fmt.Errorf("%w: no floats", ErrConfig)
+	}
+	w := &WaterLevel{
+		Device: device.NewDevice(name, "mqtt"),
+		floats: floats,
+		trk:    newTracker(len(floats), DefaultStable),
+	}
+	if device.IsMock() {
+		w.trk.start(make([]bool, len(floats)), w.Now())
+		return w, nil
+	}
+
+	// no edge is handled before the floats are read
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	on := make([]bool, len(floats))
+	for i, f := range floats {
+		ropts := append([]gpiocdev.LineReqOption{
+			drivers.WithOwner("waterlevel"),
+			gpiocdev.AsInput,
+			gpiocdev.AsActiveLow,
+			gpiocdev.WithPullUp,
+			gpiocdev.WithBothEdges,
+			gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+				w.input(i, evt.Type == gpiocdev.LineEventRisingEdge)
+			}),
+		}, opts...)
+		pin, err := drivers.NewDigitalPinID(name+"-"+f.Name, f.Pin, ropts...)
+		if err == nil {
+			var v int
+			v, err = pin.Value()
+			on[i] = v == 1
+			w.pins = append(w.pins, pin)
+		}
+		if err != nil {
+			w.closePins()
+			return nil, fmt.Errorf("waterlevel %s float %s: %w", name, f.Name, err)
+		}
+	}
+	w.trk.start(on, w.Now())
+	return w, nil
+}
+
+// Name returns the name of the device
+func (w *WaterLevel) Name() string {
+	return w.Device.Name
+}
+
+// SetStable sets how long the floats have to be steady for a change
+// to count, 0 takes every change at once
+func (w *WaterLevel) SetStable(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.trk.stable = d
+}
+
+// SetHigh sets the level alerted as high, the top float by default
+func (w *WaterLevel) SetHigh(level int) error {
+	if level < 1 || level > len(w.floats) {
+		return fmt.Errorf("%w: high level %d of %d floats", ErrConfig, level, len(w.floats))
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.trk.high = level
+	return nil
+}
+
+// Interlock turns the relay added to the device manager as name off
+// on a high level or a stuck switch, "" turns the interlock off. A
+// level already high cuts the relay at once.
+func (w *WaterLevel) Interlock(name string) error {
+	w.mu.Lock()
+	old := w.relay
+	w.relay, w.cut = name, false
+	w.mu.Unlock()
+	if old != "" && old != name {
+		w.inhibit(old, false)
+	}
+	if name == "" {
+		return nil
+	}
+	if _, err := relay(name); err != nil {
+		return err
+	}
+	return w.interlock()
+}
+
+// relay returns the Switch added to the device manager as name
+func relay(name string) (Switch, error) {
+	d, ok := device.GetDeviceManager().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRelay, name)
+	}
+	s, ok := d.(Switch)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can not be turned off", ErrRelay, name)
+	}
+	return s, nil
+}
+
+// interlock turns the relay off and inhibits it while the level calls
+// for it, the "interlock" event is published when it first does. It
+// is let go once the level no longer does.
+func (w *WaterLevel) interlock() error {
+	w.mu.Lock()
+	name, level := w.relay, w.trk.level
+	trip := w.trk.isHigh() || len(w.trk.stuck) > 0
+	if !trip {
+		w.cut = false
+	}
+	w.mu.Unlock()
+	if name == "" {
+		return nil
+	}
+	if !trip {
+		w.inhibit(name, false)
+		return nil
+	}
+
+	s, err := relay(name)
+	if err != nil {
+		return err
+	}
+	w.inhibit(name, true)
+	if err := s.Off(); err != nil {
+		return fmt.Errorf("waterlevel %s cutting %s: %w", w.Device.Name, name, err)
+	}
+	w.mu.Lock()
+	first := !w.cut
+	w.cut = true
+	w.mu.Unlock()
+	if !first {
+		return nil
+	}
+	slog.Warn("waterlevel interlock cut the relay", "device", w.Device.Name, "relay", name, "level", level)
+	return w.publish(&Event{Event: "interlock", Level: level, Relay: name})
+}
+
+// inhibit holds back, or lets go, the commands of the relay name
+func (w *WaterLevel) inhibit(name string, on bool) {
+	if d, ok := device.GetDeviceManager().Get(name); ok {
+		if r, ok := d.(Inhibitor); ok {
+			r.Inhibit(w.Device.Name, on)
+		}
+	}
+}
+
+// Status returns the level as of the last steady reading
+func (w *WaterLevel) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status()
+}
+
+func (w *WaterLevel) status() Status {
+	s := Status{Level: w.trk.level, High: w.trk.isHigh(), Stuck: w.names(w.trk.stuck)}
+	if s.Level > 0 {
+		s.Name = w.floats[s.Level-1].Name
+	}
+	return s
+}
+
+func (w *WaterLevel) names(floats []int) []string {
+	var names []string
+	for _, i := range floats {
+		names = append(names, w.floats[i].Name)
+	}
+	return names
+}
+
+// ReadPub publishes the Status, and makes sure an interlocked relay
+// stays off while the level calls for it
+func (w *WaterLevel) ReadPub() error {
+	if err := w.publish(w.Status()); err != nil {
+		return err
+	}
+	return w.interlock()
+}
+
+// Run publishes the Status every period until ctx is canceled
+func (w *WaterLevel) Run(ctx context.Context, period time.Duration) error {
+	err := w.TimerLoop(ctx, period, w.ReadPub)
+	slog.Debug("waterlevel stopped", "device", w.Device.Name, "error", err)
+	return err
+}
+
+// Close stops the stability timer and releases the lines
+func (w *WaterLevel) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	return w.closePins()
+}
+
+func (w *WaterLevel) closePins() error {
+	var errs []error
+	for _, p := range w.pins {
+		errs = append(errs, p.Close())
+	}
+	w.pins = nil
+	return errors.Join(errs...)
+}
+
+// MockFloat triggers (true) or drops float i in mock mode
+func (w *WaterLevel) MockFloat(i int, on bool) {
+	w.input(i, on)
+}
+
+// input handles float i read on, the level is evaluated once the
+// floats have been steady for the stability window
+func (w *WaterLevel) input(i int, on bool) {
+	w.mu.Lock()
+	if i < 0 || i >= len(w.trk.raw) || !w.trk.input(i, on, w.Now()) {
+		w.mu.Unlock()
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	stable := w.trk.stable
+	if stable > 0 {
+		w.timer = w.Clock().AfterFunc(stable, w.settle)
+	}
+	w.mu.Unlock()
+
+	if stable <= 0 {
+		w.settle()
+	}
+}
+
+// settle evaluates the steady floats and publishes the changes
+func (w *WaterLevel) settle() {
+	w.mu.Lock()
+	wasHigh, wasStuck := w.trk.isHigh(), w.trk.stuck
+	if !w.trk.settle(w.Now()) {
+		w.mu.Unlock()
+		return
+	}
+	s := w.status()
+	high, stuck := w.trk.isHigh(), w.trk.stuck
+	w.mu.Unlock()
+
+	w.publish(s)
+	switch {
+	case high && !wasHigh:
+		w.publish(&Event{Event: "high", Level: s.Level})
+	case !high && wasHigh:
+		w.publish(&Event{Event: "normal", Level: s.Level})
+	}
+	switch {
+	case equal(stuck, wasStuck):
+	case len(stuck) > 0:
+		slog.Warn("waterlevel float stuck", "device", w.Device.Name, "floats", s.Stuck, "level", s.Level)
+		w.publish(&Event{Event: "stuck", Level: s.Level, Floats: s.Stuck})
+	default:
+		w.publish(&Event{Event: "stuck_cleared", Level: s.Level})
+	}
+	if err := w.interlock(); err != nil {
+		slog.Error("waterlevel interlock", "device", w.Device.Name, "error", err)
+	}
+}
+
+func (w *WaterLevel) publish(v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.PubData(j)
+	return nil
+}
@@ -0,0 +1,297 @@
+package waterlevel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/devicetest"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+	relaydev "github.com/rustyeddy/otto-devices/relay"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name  string
+		on    []bool
+		level int
+		stuck []int
+	}{
+		{"empty", []bool{false, false, false}, 0, nil},
+		{"low", []bool{true, false, false}, 1, nil},
+		{"mid", []bool{true, true, false}, 2, nil},
+		{"full", []bool{true, true, true}, 3, nil},
+		{"high without low", []bool{false, true, true}, 3, []int{0}},
+		{"high alone", []bool{false, false, true}, 3, []int{0, 1}},
+		{"mid without low", []bool{false, true, false}, 2, []int{0}},
+		{"one float", []bool{true}, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, stuck := evaluate(tt.on)
+			if level != tt.level || !equal(stuck, tt.stuck) {
+				t.Errorf("evaluate(%v) got (%d, %v) want (%d, %v)", tt.on, level, stuck, tt.level, tt.stuck)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+
+	trk := newTracker(3, 2*time.Second)
+	trk.start([]bool{false, false, false}, t0)
+
+	// slosh lifts the low float and drops it again
+	seq := []struct {
+		ms    int
+		float int
+		on    bool
+	}{
+		{100, 0, true},
+		{400, 0, false},
+		{900, 0, true},
+		{1200, 0, false},
+		{1500, 0, true},
+	}
+	for _, s := range seq {
+		trk.input(s.float, s.on, at(s.ms))
+		if trk.settle(at(s.ms + 1000)) {
+			t.Fatalf("settle(%dms) took the sloshing float", s.ms+1000)
+		}
+	}
+	if trk.level != 0 || !trk.settleAt().Equal(at(3500)) {
+		t.Errorf("level got (%d) settling at (%v) want (0, 3.5s)", trk.level, trk.settleAt())
+	}
+	if !trk.settle(at(3500)) || trk.level != 1 {
+		t.Errorf("settle(3.5s) got level (%d) want (1)", trk.level)
+	}
+	if trk.settle(at(9000)) {
+		t.Error("settle() with nothing changed got (true)")
+	}
+
+	// a read of the same level is no change
+	if trk.input(0, true, at(9000)) {
+		t.Error("input(same level) got (true)")
+	}
+
+	// the high float triggers with the mid one stuck
+	trk.input(2, true, at(10000))
+	if !trk.settle(at(12000)) || trk.level != 3 || !equal(trk.stuck, []int{1}) || !trk.isHigh() {
+		t.Errorf("settle(stuck mid) got (%d, %v) want (3, [1])", trk.level, trk.stuck)
+	}
+	// the mid float frees, the level is the same but not the stuck
+	trk.input(1, true, at(13000))
+	if !trk.settle(at(15000)) || trk.level != 3 || trk.stuck != nil {
+		t.Errorf("settle(mid freed) got (%d, %v) want (3, [])", trk.level, trk.stuck)
+	}
+
+	trk.high = 2
+	trk.input(2, false, at(16000))
+	if !trk.settle(at(18000)) || trk.level != 2 || !trk.isHigh() {
+		t.Errorf("settle(high 2) got (%d, high %t) want (2, true)", trk.level, trk.isHigh())
+	}
+}
+
+// pump is a relay the interlock can cut
+type pump struct {
+	offs int
+	mu   sync.Mutex
+}
+
+func (p *pump) Name() string {
+	return "pump"
+}
+
+func (p *pump) Off() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.offs++
+	return nil
+}
+
+func (p *pump) cuts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.offs
+}
+
+func TestWaterLevel(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	clock := devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	if _, err := New("sump", nil); !errors.Is(err, ErrConfig) {
+		t.Errorf("New(no floats) error got (%v) want (%v)", err, ErrConfig)
+	}
+	w, err := New("sump", []Float{{"low", "5"}, {"mid", "6"}, {"high", "7"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer w.Close()
+	w.SetStable(0)
+
+	if err := w.Interlock("pump"); !errors.Is(err, ErrRelay) {
+		t.Errorf("Interlock(missing) error got (%v) want (%v)", err, ErrRelay)
+	}
+	p := &pump{}
+	dm := device.GetDeviceManager()
+	dm.Add(p)
+	defer dm.Remove("pump")
+	if err := w.Interlock("pump"); err != nil {
+		t.Fatalf("Interlock() error = %v", err)
+	}
+
+	low, mid, high := chip.Line(5), chip.Line(6), chip.Line(7)
+	low.Edge(1)
+	mid.Edge(1)
+	if s := w.Status(); s.Level != 2 || s.Name != "mid" || s.High || p.cuts() != 0 {
+		t.Errorf("Status() got (%+v) cuts (%d) want (mid, no cut)", s, p.cuts())
+	}
+	high.Edge(1)
+	if s := w.Status(); s.Level != 3 || !s.High || p.cuts() != 1 {
+		t.Errorf("Status() got (%+v) cuts (%d) want (high, 1 cut)", s, p.cuts())
+	}
+	high.Edge(0)
+	if s := w.Status(); s.Level != 2 || s.High {
+		t.Errorf("Status() got (%+v) want (mid)", s)
+	}
+
+	// the low float drops with the mid one still up
+	low.Edge(0)
+	s := w.Status()
+	if s.Level != 2 || len(s.Stuck) != 1 || s.Stuck[0] != "low" || p.cuts() != 2 {
+		t.Errorf("Status() got (%+v) cuts (%d) want (stuck low, 2 cuts)", s, p.cuts())
+	}
+	// the relay is kept off
+	if err := w.ReadPub(); err != nil || p.cuts() != 3 {
+		t.Errorf("ReadPub() got (%v) cuts (%d) want (nil, 3)", err, p.cuts())
+	}
+	low.Edge(1)
+	if s := w.Status(); s.Stuck != nil || w.ReadPub() != nil || p.cuts() != 3 {
+		t.Errorf("Status() got (%+v) cuts (%d) want (mid, 3 cuts)", s, p.cuts())
+	}
+
+	if err := w.SetHigh(4); !errors.Is(err, ErrConfig) {
+		t.Errorf("SetHigh(4) error got (%v) want (%v)", err, ErrConfig)
+	}
+	if err := w.SetHigh(2); err != nil || p.cuts() != 3 {
+		t.Errorf("SetHigh(2) got (%v) cuts (%d)", err, p.cuts())
+	}
+
+	// slosh within the stability window is not a change
+	w.SetStable(50 * time.Millisecond)
+	mid.Edge(0)
+	mid.Edge(1)
+	mid.Edge(0)
+	if s := w.Status(); s.Level != 2 {
+		t.Errorf("Status() while sloshing got (%+v) want (mid)", s)
+	}
+	clock.Advance(49 * time.Millisecond)
+	if s := w.Status(); s.Level != 2 {
+		t.Errorf("Status() within the stability window got (%+v) want (mid)", s)
+	}
+	clock.Advance(time.Millisecond)
+	if s := w.Status(); s.Level != 1 {
+		t.Errorf("Status() once steady got (%+v) want (low)", s)
+	}
+
+	w.Close()
+	if !low.Closed() || !mid.Closed() || !high.Closed() {
+		t.Error("Close() did not release the lines")
+	}
+}
+
+// actuator is a relay of the relay package in the DeviceManager
+type actuator struct {
+	*relaydev.Relay
+}
+
+func (a actuator) Name() string { return a.Relay.Device.Name }
+
+func TestInterlockInhibits(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+	c := devicetest.Use(t)
+	devicetest.UseClock(t, time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC))
+
+	r := relaydev.New("pump", 12)
+	defer r.Close()
+	if err := r.Listen(); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	dm := device.GetDeviceManager()
+	dm.Add(actuator{r})
+	defer dm.Remove("pump")
+
+	w, err := New("sump", []Float{{"low", "5"}, {"high", "6"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer w.Close()
+	w.SetStable(0)
+	if err := w.Interlock("pump"); err != nil {
+		t.Fatalf("Interlock() error = %v", err)
+	}
+	command := func(id, cmd string, ok bool) {
+		t.Helper()
+		c.Inject(r.ControlTopic(), []byte(`{"id":"`+id+`","cmd":"`+cmd+`"}`))
+		c.ExpectPublish(r.AckTopic(), devicetest.Contains(fmt.Sprintf(`"id":"%s","ok":%t`, id, ok)), devicetest.Timeout)
+	}
+	value := func() int {
+		t.Helper()
+		v, err := r.Value()
+		if err != nil {
+			t.Fatalf("Value() error = %v", err)
+		}
+		return v
+	}
+
+	command("1", "on", true)
+	low, high := chip.Line(5), chip.Line(6)
+	low.Edge(1)
+	high.Edge(1)
+	if value() != 0 {
+		t.Fatalf("high level left the pump on")
+	}
+
+	// switched back on while high, nacked and left off
+	command("2", "on", false)
+	command("3", "toggle", false)
+	command("4", "off", true)
+	if value() != 0 {
+		t.Errorf("inhibited pump switched on")
+	}
+
+	// the low float stuck down under the high one, still held off
+	low.Edge(0)
+	if s := w.Status(); len(s.Stuck) != 1 {
+		t.Fatalf("Status() got (%+v) want (stuck low)", s)
+	}
+	command("5", "on", false)
+
+	// back to normal, the pump is let go
+	high.Edge(0)
+	if s := w.Status(); s.High || s.Stuck != nil {
+		t.Fatalf("Status() got (%+v) want (normal)", s)
+	}
+	command("6", "on", true)
+	if value() != 1 {
+		t.Errorf("pump let go not switched on")
+	}
+
+	// moving the interlock lets go of the old relay
+	high.Edge(1)
+	low.Edge(1)
+	command("7", "on", false)
+	if err := w.Interlock(""); err != nil {
+		t.Fatalf("Interlock(\"\") error = %v", err)
+	}
+	command("8", "on", true)
+}
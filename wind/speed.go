@@ -0,0 +1,60 @@
+package wind
+
+import "time"
+
+// GustWindow is the length of the average a gust is the maximum of,
+// the 3 second gust of the WMO
+const GustWindow = 3
+
+// counter counts the anemometer pulses of a reporting period in one
+// second buckets, the gust is the largest sum of GustWindow
+// consecutive buckets
+type counter struct {
+	start   time.Time
+	buckets []int
+}
+
+// reset starts a new period at t
+func (c *counter) reset(at time.Time) {
+	c.start = at
+	c.buckets = c.buckets[:0]
+}
+
+// add counts a pulse at t
+func (c *counter) add(at time.Time) {
+	i := max(int(at.Sub(c.start)/time.Second), 0)
+	for len(c.buckets) <= i {
+		c.buckets = append(c.buckets, 0)
+	}
+	c.buckets[i]++
+}
+
+// rates returns the average pulses a second of the period up to now
+// and of its gust. A period shorter than the gust window has no gust
+// but its average.
+func (c *counter) rates(now time.Time) (avg, gust float64) {
+	d := now.Sub(c.start)
+	if d <= 0 {
+		return 0, 0
+	}
+	total := 0
+	for _, b := range c.buckets {
+		total += b
+	}
+	avg = float64(total) / d.Seconds()
+
+	// only the buckets of whole seconds
+	n := min(int(d/time.Second), len(c.buckets))
+	if int(d/time.Second) < GustWindow {
+		return avg, avg
+	}
+	sum, most := 0, 0
+	for i := 0; i < n; i++ {
+		sum += c.buckets[i]
+		if i >= GustWindow {
+			sum -= c.buckets[i-GustWindow]
+		}
+		most = max(most, sum)
+	}
+	return avg, float64(most) / GustWindow
+}
@@ -0,0 +1,80 @@
+package wind
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrDirection is returned for a vane voltage that matches none of
+// the positions, the vane is disconnected or shorted
+var ErrDirection = errors.New("no wind direction matches")
+
+// VanePosition is the resistance of the vane pointing at Degrees
+type VanePosition struct {
+	Degrees float64
+	Ohms    float64
+}
+
+// Vane is a wind vane that switches a resistor per direction, read
+// as a voltage divider with Fixed ohms from Supply to the reader
+// input and the vane from the input to ground
+type Vane struct {
+	Supply float64
+	Fixed  float64
+
+	// Tolerance is how many volts off the nearest position a reading
+	// may be
+	Tolerance float64
+
+	Positions []VanePosition
+}
+
+// SparkFunVane is the vane of the SparkFun and Argent weather meters
+// with the 10k resistor of their datasheet, powered from 3.3V
+var SparkFunVane = Vane{
+	Supply:    3.3,
+	Fixed:     10000,
+	Tolerance: 0.1,
+	Positions: []VanePosition{
+		{0, 33000}, {22.5, 6570}, {45, 8200}, {67.5, 891},
+		{90, 1000}, {112.5, 688}, {135, 2200}, {157.5, 1410},
+		{180, 3900}, {202.5, 3140}, {225, 16000}, {247.5, 14120},
+		{270, 120000}, {292.5, 42120}, {315, 64900}, {337.5, 21880},
+	},
+}
+
+// Volts returns the reading of the vane at ohms
+func (v *Vane) Volts(ohms float64) float64 {
+	return v.Supply * ohms / (ohms + v.Fixed)
+}
+
+// Direction returns the degrees of the position nearest to volts, or
+// ErrDirection if it is further off than the tolerance
+func (v *Vane) Direction(volts float64) (float64, error) {
+	best, off := -1, math.Inf(1)
+	for i, p := range v.Positions {
+		if d := math.Abs(v.Volts(p.Ohms) - volts); d < off {
+			best, off = i, d
+		}
+	}
+	if best < 0 || off > v.Tolerance {
+		return 0, fmt.Errorf("%w: %.3fV", ErrDirection, volts)
+	}
+	return v.Positions[best].Degrees, nil
+}
+
+var compass = [16]string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+// Compass returns the point of the 16 point compass nearest to
+// degrees
+func Compass(degrees float64) string {
+	d := math.Mod(degrees, 360)
+	if d < 0 {
+		d += 360
+	}
+	return compass[int(math.Round(d/22.5))%16]
+}
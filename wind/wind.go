@@ -0,0 +1,192 @@
+// Package wind reads an anemometer and a wind vane, like the ones of
+// the SparkFun and Argent weather meters. The anemometer closes a
+// reed switch as it turns, its pulses are counted on a GPIO line for
+// the average speed and the gust of every period. The vane switches
+// in a resistor per direction, it is read as a voltage divider over a
+// drivers.AnalogReader.
+package wind
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// DefaultPulsesPerRotation is one reed closure a rotation
+	DefaultPulsesPerRotation = 1
+
+	// DefaultFactor is the m/s of one rotation a second, 2.4 km/h
+	// for the SparkFun and Argent anemometer
+	DefaultFactor = 2.4 / 3.6
+
+	// DefaultDebounce filters the bounce of the reed contacts, at
+	// 100 km/h the anemometer pulses every 24ms
+	DefaultDebounce = 2 * time.Millisecond
+)
+
+// Reading is the wind of one period, the speeds are in m/s. The
+// direction is left out when there is no vane or it could not be
+// read.
+type Reading struct {
+	Speed     float64  `json:"speed_m_s"`
+	Gust      float64  `json:"gust_m_s"`
+	Direction *float64 `json:"direction_deg,omitempty"`
+	Compass   string   `json:"direction,omitempty"`
+}
+
+// Wind is an anemometer on a GPIO line and an optional vane
+type Wind struct {
+	*device.Device
+
+	// PulsesPerRotation and Factor turn the pulses into m/s, set
+	// them before Run
+	PulsesPerRotation int
+	Factor            float64
+
+	// Vane describes the resistor network of the vane
+	Vane Vane
+
+	vane drivers.AnalogReader
+	pin  *drivers.DigitalPin
+	cnt  counter
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New creates an anemometer on the line at offset of the default chip
+// and a vane read from vane, which may be nil for no vane. The reed
+// switch connects the line to ground, the line is pulled up. opts are
+// added to the line request after the defaults.
+func New(name string, offset int, vane drivers.AnalogReader, opts ...gpiocdev.LineReqOption) (*Wind, error) {
+	w := &Wind{
+		Device:            device.NewDevice(name, "mqtt"),
+		PulsesPerRotation: DefaultPulsesPerRotation,
+		Factor:            DefaultFactor,
+		Vane:              SparkFunVane,
+		vane:              vane,
+		now:               time.Now,
+	}
+	w.cnt.reset(w.now())
+	if device.IsMock() {
+		return w, nil
+	}
+
+	ropts := append([]gpiocdev.LineReqOption{
+		drivers.WithOwner("wind"),
+		gpiocdev.AsInput,
+		gpiocdev.AsActiveLow,
+		gpiocdev.WithPullUp,
+		gpiocdev.WithDebounce(DefaultDebounce),
+		gpiocdev.WithRisingEdge,
+		gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			if evt.Type == gpiocdev.LineEventRisingEdge {
+				w.pulse(w.now())
+			}
+		}),
+	}, opts...)
+	pin, err := drivers.GetGPIO().Request(name, offset, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.pin = pin
+	w.mu.Unlock()
+	return w, nil
+}
+
+// Name returns the name of the device
+func (w *Wind) Name() string {
+	return w.Device.Name
+}
+
+// speed returns the m/s of pulses a second
+func (w *Wind) speed(hz float64) float64 {
+	return hz / float64(max(w.PulsesPerRotation, 1)) * w.Factor
+}
+
+// Read returns the average speed and the gust since the last Read
+// and starts a new period, and the direction the vane points at now.
+// A vane that can not be read is returned as an error along with
+// the speeds.
+func (w *Wind) Read() (*Reading, error) {
+	w.mu.Lock()
+	now := w.now()
+	avg, gust := w.cnt.rates(now)
+	w.cnt.reset(now)
+	w.mu.Unlock()
+
+	r := &Reading{Speed: w.speed(avg), Gust: w.speed(gust)}
+	if w.vane == nil {
+		return r, nil
+	}
+	volts, err := w.vane.ReadVolts()
+	if err != nil {
+		return r, fmt.Errorf("%s vane: %w", w.Device.Name, err)
+	}
+	deg, err := w.Vane.Direction(volts)
+	if err != nil {
+		return r, fmt.Errorf("%s vane: %w", w.Device.Name, err)
+	}
+	r.Direction = &deg
+	r.Compass = Compass(deg)
+	return r, nil
+}
+
+// ReadPub publishes the Reading, a vane error is returned after the
+// speeds were published without a direction
+func (w *Wind) ReadPub() error {
+	r, err := w.Read()
+	j, jerr := json.Marshal(r)
+	if jerr != nil {
+		return jerr
+	}
+	w.PubData(j)
+	return err
+}
+
+// Run publishes the wind of every period until ctx is canceled
+func (w *Wind) Run(ctx context.Context, period time.Duration) error {
+	w.mu.Lock()
+	w.cnt.reset(w.now())
+	w.mu.Unlock()
+	err := w.TimerLoop(ctx, period, w.ReadPub)
+	slog.Debug("wind stopped", "device", w.Device.Name, "error", err)
+	return err
+}
+
+// Close releases the line and the vane's reader
+func (w *Wind) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	if w.pin != nil {
+		errs = append(errs, w.pin.Close())
+		w.pin = nil
+	}
+	if c, ok := w.vane.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// MockPulse counts an anemometer pulse in mock mode
+func (w *Wind) MockPulse() {
+	w.pulse(w.now())
+}
+
+func (w *Wind) pulse(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cnt.add(t)
+}
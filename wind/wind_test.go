@@ -0,0 +1,196 @@
+package wind
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rustyeddy/otto-devices"
+	"github.com/rustyeddy/otto-devices/drivers"
+	"github.com/rustyeddy/otto-devices/drivers/driverstest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestRates(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		perSec []int // pulses in every second of the period
+		period time.Duration
+		avg    float64
+		gust   float64
+	}{
+		{"calm", nil, 10 * time.Second, 0, 0},
+		{"steady", []int{2, 2, 2, 2, 2, 2, 2, 2, 2, 2}, 10 * time.Second, 2, 2},
+		{"gust", []int{1, 1, 1, 4, 6, 5, 1, 1, 1, 1}, 10 * time.Second, 2.2, 5},
+		{"gust at the end", []int{1, 1, 1, 1, 1, 1, 1, 3, 3, 6}, 10 * time.Second, 1.9, 4},
+		{"single second spike", []int{0, 0, 9, 0, 0, 0}, 6 * time.Second, 1.5, 3},
+		{"shorter than the gust window", []int{3, 1}, 2 * time.Second, 2, 2},
+		{"pulses past the last whole second", []int{2, 2, 2, 2}, 3500 * time.Millisecond, 8 / 3.5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c counter
+			c.reset(t0)
+			for s, n := range tt.perSec {
+				for i := 0; i < n; i++ {
+					// spread over the second
+					c.add(t0.Add(time.Duration(s)*time.Second + time.Duration(i)*time.Second/time.Duration(n)))
+				}
+			}
+			avg, gust := c.rates(t0.Add(tt.period))
+			if !near(avg, tt.avg) || !near(gust, tt.gust) {
+				t.Errorf("rates() got (%v, %v) want (%v, %v)", avg, gust, tt.avg, tt.gust)
+			}
+		})
+	}
+
+	// a new period forgets the old one
+	var c counter
+	c.reset(t0)
+	c.add(t0)
+	c.reset(t0.Add(time.Minute))
+	if avg, gust := c.rates(t0.Add(2 * time.Minute)); avg != 0 || gust != 0 {
+		t.Errorf("rates() after reset got (%v, %v) want (0, 0)", avg, gust)
+	}
+}
+
+func TestDirection(t *testing.T) {
+	v := SparkFunVane
+	for _, p := range v.Positions {
+		for _, off := range []float64{0, 0.01, -0.01} {
+			got, err := v.Direction(v.Volts(p.Ohms) + off)
+			if err != nil || got != p.Degrees {
+				t.Errorf("Direction(%v ohms %+.2fV) got (%v, %v) want (%v)", p.Ohms, off, got, err, p.Degrees)
+			}
+		}
+	}
+
+	// the closest pair, 67.5 and 90 degrees, split at the midpoint
+	e, s := v.Volts(1000), v.Volts(891)
+	if got, _ := v.Direction(e - (e-s)*0.4); got != 90 {
+		t.Errorf("Direction() nearer 90 got (%v) want (90)", got)
+	}
+	if got, _ := v.Direction(e - (e-s)*0.6); got != 67.5 {
+		t.Errorf("Direction() nearer 67.5 got (%v) want (67.5)", got)
+	}
+
+	for _, volts := range []float64{0, v.Supply} { // shorted, disconnected
+		if _, err := v.Direction(volts); !errors.Is(err, ErrDirection) {
+			t.Errorf("Direction(%v) error got (%v) want (%v)", volts, err, ErrDirection)
+		}
+	}
+
+	// a 5V supply moves every voltage
+	v5 := SparkFunVane
+	v5.Supply = 5
+	if got, err := v5.Direction(3.84); err != nil || got != 0 {
+		t.Errorf("5V Direction(3.84) got (%v, %v) want (0)", got, err)
+	}
+}
+
+func TestCompass(t *testing.T) {
+	tests := []struct {
+		deg  float64
+		want string
+	}{
+		{0, "N"}, {22.5, "NNE"}, {90, "E"}, {180, "S"}, {270, "W"},
+		{337.5, "NNW"}, {350, "N"}, {360, "N"}, {-90, "W"}, {100, "E"}, {105, "ESE"},
+	}
+	for _, tt := range tests {
+		if got := Compass(tt.deg); got != tt.want {
+			t.Errorf("Compass(%v) got (%s) want (%s)", tt.deg, got, tt.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	device.Mock(true)
+	defer device.Mock(false)
+
+	vane := drivers.NewMockAnalogPin("vane", 0)
+	w, err := New("wind", 5, vane)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return clock }
+	w.cnt.reset(clock)
+
+	// 3 pulses a second for 10 seconds, 6 in the 5th
+	for s := 0; s < 10; s++ {
+		n := 3
+		if s == 4 {
+			n = 6
+		}
+		for i := 0; i < n; i++ {
+			clock = clock.Add(time.Second / time.Duration(n))
+			w.MockPulse()
+		}
+	}
+	vane.MockValues(SparkFunVane.Volts(3900))
+	r, err := w.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !near(r.Speed, 3.3*DefaultFactor) || !near(r.Gust, 4*DefaultFactor) {
+		t.Errorf("Read() speeds got (%v, %v) want (%v, %v)", r.Speed, r.Gust, 3.3*DefaultFactor, 4*DefaultFactor)
+	}
+	if r.Direction == nil || *r.Direction != 180 || r.Compass != "S" {
+		t.Errorf("Read() direction got (%v, %s) want (180, S)", r.Direction, r.Compass)
+	}
+
+	// two pulses a rotation halve the speed, the vane is unplugged
+	w.PulsesPerRotation = 2
+	for i := 0; i < 10; i++ {
+		clock = clock.Add(time.Second)
+		w.MockPulse()
+	}
+	vane.MockValues(SparkFunVane.Supply)
+	r, err = w.Read()
+	if !errors.Is(err, ErrDirection) {
+		t.Errorf("Read() unplugged vane error got (%v) want (%v)", err, ErrDirection)
+	}
+	if r == nil || !near(r.Speed, DefaultFactor/2) || r.Direction != nil {
+		t.Errorf("Read() got (%+v) want (%v m/s) without direction", r, DefaultFactor/2)
+	}
+}
+
+func TestAnemometer(t *testing.T) {
+	device.Mock(false)
+	chip := driverstest.NewChip()
+	driverstest.UseGPIO(t, chip)
+
+	w, err := New("wind", 5, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer w.Close()
+
+	line := chip.Line(5)
+	for i := 0; i < 4; i++ {
+		line.Edge(1)
+		line.Edge(0)
+	}
+	w.mu.Lock()
+	n := 0
+	for _, b := range w.cnt.buckets {
+		n += b
+	}
+	w.mu.Unlock()
+	if n != 4 {
+		t.Errorf("pulses got (%d) want (4)", n)
+	}
+	if r, err := w.Read(); err != nil || r.Direction != nil {
+		t.Errorf("Read() without a vane got (%+v, %v) want no direction", r, err)
+	}
+
+	w.Close()
+	if !line.Closed() {
+		t.Error("Close() did not release the line")
+	}
+}